@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/liftbridge-io/liftbridge/server"
+	"github.com/liftbridge-io/liftbridge/server/bench"
+)
+
+func benchCommand() cli.Command {
+	return cli.Command{
+		Name:  "bench",
+		Usage: "publish and subscribe to a stream to measure throughput and latency",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "server",
+				Usage: "Liftbridge server `ADDR` to connect to",
+				Value: fmt.Sprintf("localhost:%d", server.DefaultPort),
+			},
+			cli.StringFlag{
+				Name:  "tls-ca",
+				Usage: "connect to the Liftbridge server using TLS, verified with the CA certificate `FILE`",
+			},
+			cli.StringFlag{
+				Name:  "stream, s",
+				Usage: "stream to publish to, created if it doesn't exist",
+				Value: "bench",
+			},
+			cli.IntFlag{
+				Name:  "partitions, p",
+				Usage: "number of partitions of the stream if it's created",
+				Value: 1,
+			},
+			cli.IntFlag{
+				Name:  "replication-factor, r",
+				Usage: "replication factor of the stream if it's created, -1 for every server",
+				Value: 1,
+			},
+			cli.IntFlag{
+				Name:  "message-size",
+				Usage: "size of message values in bytes",
+				Value: 128,
+			},
+			cli.Int64Flag{
+				Name:  "messages, n",
+				Usage: "number of messages to publish, 0 to publish for the duration",
+				Value: 100000,
+			},
+			cli.DurationFlag{
+				Name:  "duration, d",
+				Usage: "time to publish for when the number of messages is 0",
+				Value: 30 * time.Second,
+			},
+			cli.IntFlag{
+				Name:  "rate",
+				Usage: "messages to publish per second, 0 for as fast as possible",
+			},
+			cli.IntFlag{
+				Name:  "publishers",
+				Usage: "number of concurrent publishers",
+				Value: 1,
+			},
+			cli.StringFlag{
+				Name:  "ack-policy",
+				Usage: "ack policy of published messages [leader|all|none]",
+				Value: "leader",
+			},
+			cli.BoolFlag{
+				Name:  "subscribe",
+				Usage: "subscribe to the stream to measure end-to-end latency",
+			},
+		},
+		Action: runBench,
+	}
+}
+
+func runBench(c *cli.Context) error {
+	ackPolicy, ok := client.AckPolicy_value[strings.ToUpper(c.String("ack-policy"))]
+	if !ok {
+		return fmt.Errorf("unknown ack policy %q", c.String("ack-policy"))
+	}
+	dialOpt := grpc.WithInsecure()
+	if ca := c.String("tls-ca"); ca != "" {
+		creds, err := credentials.NewClientTLSFromFile(ca, "")
+		if err != nil {
+			return err
+		}
+		dialOpt = grpc.WithTransportCredentials(creds)
+	}
+	apis, closeAPIs, err := dialCluster(c.String("server"), dialOpt)
+	if err != nil {
+		return err
+	}
+	defer closeAPIs()
+
+	opts := bench.Options{
+		Stream:            c.String("stream"),
+		Partitions:        int32(c.Int("partitions")),
+		ReplicationFactor: int32(c.Int("replication-factor")),
+		MessageSize:       c.Int("message-size"),
+		Messages:          c.Int64("messages"),
+		Duration:          c.Duration("duration"),
+		Rate:              c.Int("rate"),
+		Publishers:        c.Int("publishers"),
+		AckPolicy:         client.AckPolicy(ackPolicy),
+		Subscribe:         c.Bool("subscribe"),
+	}
+	b, err := bench.New(apis, opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+	result, err := b.Run(ctx)
+	if err != nil {
+		return err
+	}
+	printBenchResult(os.Stdout, result, opts)
+	return nil
+}
+
+func printBenchResult(w io.Writer, result *bench.Result, opts bench.Options) {
+	fmt.Fprintf(w, "Published %d messages of %d bytes in %s: %.0f msgs/sec, %.2f MB/sec\n",
+		result.Published, opts.MessageSize, result.Duration.Round(time.Millisecond),
+		result.MessagesPerSecond(), result.BytesPerSecond(opts.MessageSize)/1e6)
+	printLatencies(w, "Publish latency", result.PublishLatency)
+	if opts.Subscribe {
+		fmt.Fprintf(w, "Received %d of %d messages\n", result.Received, result.Published)
+		printLatencies(w, "End-to-end latency", result.EndToEndLatency)
+	}
+}
+
+func printLatencies(w io.Writer, name string, l bench.Latencies) {
+	if l.Count == 0 {
+		return
+	}
+	round := func(d time.Duration) time.Duration { return d.Round(time.Microsecond) }
+	fmt.Fprintf(w, "%s: min %s, mean %s, p50 %s, p90 %s, p99 %s, p99.9 %s, max %s\n",
+		name, round(l.Min), round(l.Mean), round(l.P50), round(l.P90), round(l.P99),
+		round(l.P999), round(l.Max))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// adminClient sends requests to the admin HTTP API of Liftbridge servers.
+// Unlike the client API, most of the admin API applies to the server a
+// request is sent to, so servers other than the one liftctl was pointed at
+// are found by their admin address in the broker list.
+type adminClient struct {
+	addr   string
+	scheme string
+	token  string
+	client *http.Client
+}
+
+// adminError is an error response from the admin API. MetadataLeader is set
+// when the request must be sent to the metadata leader.
+type adminError struct {
+	Message        string `json:"error"`
+	MetadataLeader string `json:"metadataLeader"`
+	status         int
+}
+
+func (e *adminError) Error() string {
+	return e.Message
+}
+
+// adminBroker is a broker in the admin API's broker list.
+type adminBroker struct {
+	ID             string `json:"id"`
+	Host           string `json:"host"`
+	Port           int32  `json:"port"`
+	AdminAddress   string `json:"adminAddress"`
+	Suffrage       string `json:"suffrage"`
+	MetadataLeader bool   `json:"metadataLeader"`
+	Partitions     int    `json:"partitions"`
+	Leaders        int    `json:"leaders"`
+	Reachable      bool   `json:"reachable"`
+	Serving        bool   `json:"serving"`
+	Liveness       string `json:"liveness"`
+}
+
+// newAdminClient returns an adminClient for the admin address liftctl was
+// given. HTTPS is used if a CA or client certificate is given.
+func newAdminClient(c *cli.Context) (*adminClient, error) {
+	admin := &adminClient{
+		addr:   c.GlobalString("admin"),
+		scheme: "http",
+		token:  c.GlobalString("admin-token"),
+		client: &http.Client{},
+	}
+	var tlsConfig *tls.Config
+	if ca := c.GlobalString("admin-tls-ca"); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", ca)
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+	if cert := c.GlobalString("admin-tls-cert"); cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, c.GlobalString("admin-tls-key"))
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	if tlsConfig != nil {
+		admin.scheme = "https"
+		admin.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return admin, nil
+}
+
+// do sends a request to the admin API of the server at the given address and
+// decodes the JSON response into resp unless it's nil. It returns the
+// response's status code.
+func (a *adminClient) do(ctx context.Context, addr, method, path string, resp interface{}) (
+	int, error) {
+
+	req, err := http.NewRequest(method, a.scheme+"://"+addr+path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	r, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return r.StatusCode, err
+	}
+	if r.StatusCode >= http.StatusMultipleChoices {
+		// The drain endpoint responds with plain text.
+		apiErr := &adminError{status: r.StatusCode}
+		if json.Unmarshal(body, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		if apiErr.Message == "" {
+			apiErr.Message = r.Status
+		}
+		return r.StatusCode, apiErr
+	}
+	if resp != nil {
+		if err := json.Unmarshal(body, resp); err != nil {
+			return r.StatusCode, fmt.Errorf("invalid admin API response: %v", err)
+		}
+	}
+	return r.StatusCode, nil
+}
+
+// brokers lists the brokers in the cluster.
+func (a *adminClient) brokers(ctx context.Context) ([]*adminBroker, error) {
+	var resp struct {
+		Brokers []*adminBroker `json:"brokers"`
+	}
+	if _, err := a.do(ctx, a.addr, http.MethodGet, "/v1/brokers", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Brokers, nil
+}
+
+// brokerAddr returns the admin address of the broker with the given ID, or
+// the address liftctl was given if the ID is empty.
+func (a *adminClient) brokerAddr(ctx context.Context, id string) (string, error) {
+	if id == "" {
+		return a.addr, nil
+	}
+	brokers, err := a.brokers(ctx)
+	if err != nil {
+		return "", err
+	}
+	return adminAddr(brokers, id)
+}
+
+// leader sends a request which must be handled by the metadata leader,
+// resending it to the leader's admin address if the server liftctl was given
+// isn't the leader.
+func (a *adminClient) leader(ctx context.Context, method, path string, resp interface{}) (
+	int, error) {
+
+	code, err := a.do(ctx, a.addr, method, path, resp)
+	apiErr, ok := err.(*adminError)
+	if !ok || apiErr.MetadataLeader == "" {
+		return code, err
+	}
+	addr, addrErr := a.brokerAddr(ctx, apiErr.MetadataLeader)
+	if addrErr != nil {
+		return code, addrErr
+	}
+	return a.do(ctx, addr, method, path, resp)
+}
+
+// adminAddr returns the admin address of the broker with the given ID.
+func adminAddr(brokers []*adminBroker, id string) (string, error) {
+	for _, broker := range brokers {
+		if broker.ID != id {
+			continue
+		}
+		if broker.AdminAddress == "" {
+			return "", fmt.Errorf("broker %q has no admin address", id)
+		}
+		return broker.AdminAddress, nil
+	}
+	return "", fmt.Errorf("broker %q not found", id)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+func brokerCommand() cli.Command {
+	return cli.Command{
+		Name:  "broker",
+		Usage: "manage brokers using the admin API",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list the brokers in the cluster",
+				Action: adminAction(listBrokers),
+			},
+			{
+				Name:      "add",
+				Usage:     "add a broker to the cluster ahead of starting it",
+				ArgsUsage: "ID",
+				Action:    adminAction(addBroker),
+			},
+			{
+				Name:      "remove",
+				Usage:     "remove a stopped broker from the cluster",
+				ArgsUsage: "ID",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "force",
+						Usage: "remove the broker even if it's still a replica of partitions",
+					},
+				},
+				Action: adminAction(removeBroker),
+			},
+			{
+				Name:      "drain",
+				Usage:     "hand off a broker's leaderships and end its client requests before it's stopped",
+				ArgsUsage: "[ID]",
+				Flags: []cli.Flag{
+					cli.DurationFlag{
+						Name:  "drain-timeout",
+						Usage: "maximum time to drain for (default: drain.timeout of the broker)",
+					},
+				},
+				Action: drainBroker,
+			},
+		},
+	}
+}
+
+func listBrokers(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	brokers, err := admin.brokers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "BROKER\tADDRESS\tADMIN\tSUFFRAGE\tPARTITIONS\tLEADERS\tSTATE")
+	for _, broker := range brokers {
+		addr := "-"
+		if broker.Host != "" {
+			addr = net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+		}
+		suffrage := broker.Suffrage
+		if broker.MetadataLeader {
+			suffrage += " (leader)"
+		}
+		state := "serving"
+		switch {
+		case !broker.Reachable:
+			state = "unreachable"
+		case !broker.Serving:
+			state = "draining"
+		}
+		if broker.Liveness != "" {
+			state += ", " + broker.Liveness
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", broker.ID, addr, orDash(broker.AdminAddress),
+			suffrage, broker.Partitions, broker.Leaders, state)
+	}
+	return w.Flush()
+}
+
+// membershipResponse is the response to adding or removing a broker.
+type membershipResponse struct {
+	ID       string `json:"id"`
+	Suffrage string `json:"suffrage"`
+}
+
+func addBroker(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	var resp membershipResponse
+	code, err := admin.leader(ctx, http.MethodPut, "/v1/brokers/"+url.PathEscape(c.Args().First()), &resp)
+	if err != nil {
+		return err
+	}
+	if code == http.StatusCreated {
+		fmt.Fprintf(c.App.Writer, "Added broker %s as %s\n", resp.ID, resp.Suffrage)
+	} else {
+		fmt.Fprintf(c.App.Writer, "Broker %s is already a %s\n", resp.ID, resp.Suffrage)
+	}
+	return nil
+}
+
+func removeBroker(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	path := "/v1/brokers/" + url.PathEscape(c.Args().First())
+	if c.Bool("force") {
+		path += "?force=true"
+	}
+	var resp membershipResponse
+	if _, err := admin.leader(ctx, http.MethodDelete, path, &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Removed broker %s\n", resp.ID)
+	return nil
+}
+
+// drainBroker drains the broker with the given ID, or the server liftctl was
+// given. The request is bounded by the drain timeout in addition to the
+// request timeout since the broker responds once draining is complete.
+func drainBroker(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return fmt.Errorf("usage: %s %s %s", c.App.Name, c.Command.FullName(), c.Command.ArgsUsage)
+	}
+	admin, err := newAdminClient(c)
+	if err != nil {
+		return err
+	}
+	timeout := c.GlobalDuration("timeout")
+	path := "/drain"
+	if drainTimeout := c.Duration("drain-timeout"); drainTimeout > 0 {
+		timeout += drainTimeout
+		path += "?timeout=" + drainTimeout.String()
+	} else {
+		// The broker's drain timeout isn't known, so allow for a long one.
+		timeout += time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	id := c.Args().First()
+	addr, err := admin.brokerAddr(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := admin.do(ctx, addr, http.MethodPost, path, nil); err != nil {
+		return err
+	}
+	if id == "" {
+		id = addr
+	}
+	fmt.Fprintf(c.App.Writer, "Drained broker %s, which can be stopped now\n", id)
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// connectedClient describes a client connected to a broker's API.
+type connectedClient struct {
+	ID               uint64    `json:"id"`
+	Address          string    `json:"address"`
+	Listener         string    `json:"listener"`
+	Principal        string    `json:"principal"`
+	Rejected         bool      `json:"rejected"`
+	ConnectedAt      time.Time `json:"connectedAt"`
+	LastActivity     time.Time `json:"lastActivity"`
+	Requests         int64     `json:"requests"`
+	Publishes        int64     `json:"publishes"`
+	PublishedBytes   int64     `json:"publishedBytes"`
+	PublishRate      float64   `json:"publishRate"`
+	BufferedMessages int       `json:"bufferedMessages"`
+	Subscriptions    []struct {
+		Stream           string `json:"stream"`
+		Partition        int32  `json:"partition"`
+		BufferedMessages int    `json:"bufferedMessages"`
+	} `json:"subscriptions"`
+}
+
+func clientCommand() cli.Command {
+	return cli.Command{
+		Name:  "client",
+		Usage: "inspect the clients connected to a broker using the admin API",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list the clients connected to a broker",
+				Flags:  []cli.Flag{brokerFlag},
+				Action: adminAction(listClients),
+			},
+			{
+				Name:      "describe",
+				Usage:     "show a connected client's activity and subscriptions",
+				ArgsUsage: "ID",
+				Flags:     []cli.Flag{brokerFlag},
+				Action:    adminAction(describeClient),
+			},
+			{
+				Name:      "disconnect",
+				Usage:     "close a client's connection, ending its requests and subscriptions",
+				ArgsUsage: "ID",
+				Flags:     []cli.Flag{brokerFlag},
+				Action:    adminAction(disconnectClient),
+			},
+		},
+	}
+}
+
+func listClients(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	addr, err := admin.brokerAddr(ctx, c.String("broker"))
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Clients []*connectedClient `json:"clients"`
+	}
+	if _, err := admin.do(ctx, addr, http.MethodGet, "/v1/clients", &resp); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tLISTENER\tPRINCIPAL\tCONNECTED\tREQUESTS\tPUBLISH RATE\tSUBSCRIPTIONS\tBUFFERED")
+	for _, cl := range resp.Clients {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%.1f/s\t%d\t%d\n", cl.ID, cl.Address,
+			orDash(cl.Listener), orDash(cl.Principal), cl.ConnectedAt.UTC().Format(time.RFC3339),
+			cl.Requests, cl.PublishRate, len(cl.Subscriptions), cl.BufferedMessages)
+	}
+	return w.Flush()
+}
+
+func describeClient(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	cl, err := clientRequest(ctx, c, admin, http.MethodGet)
+	if err != nil {
+		return err
+	}
+	printClient(c, cl)
+	return nil
+}
+
+func disconnectClient(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	cl, err := clientRequest(ctx, c, admin, http.MethodDelete)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Disconnected client %d (%s)\n", cl.ID, cl.Address)
+	return nil
+}
+
+// clientRequest sends a request for the client given as the command's
+// argument and returns its description.
+func clientRequest(ctx context.Context, c *cli.Context, admin *adminClient, method string) (
+	*connectedClient, error) {
+
+	if err := requireArgs(c, 1); err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseUint(c.Args().First(), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client ID %q", c.Args().First())
+	}
+	addr, err := admin.brokerAddr(ctx, c.String("broker"))
+	if err != nil {
+		return nil, err
+	}
+	cl := new(connectedClient)
+	if _, err := admin.do(ctx, addr, method, "/v1/clients/"+strconv.FormatUint(id, 10), cl); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+func printClient(c *cli.Context, cl *connectedClient) {
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%d\n", cl.ID)
+	fmt.Fprintf(w, "Address:\t%s\n", cl.Address)
+	fmt.Fprintf(w, "Listener:\t%s\n", orDash(cl.Listener))
+	fmt.Fprintf(w, "Principal:\t%s\n", orDash(cl.Principal))
+	fmt.Fprintf(w, "Rejected:\t%t\n", cl.Rejected)
+	fmt.Fprintf(w, "Connected:\t%s\n", cl.ConnectedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "Last activity:\t%s\n", cl.LastActivity.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "Requests:\t%d\n", cl.Requests)
+	fmt.Fprintf(w, "Publishes:\t%d (%d bytes, %.1f/s)\n", cl.Publishes, cl.PublishedBytes, cl.PublishRate)
+	fmt.Fprintf(w, "Buffered messages:\t%d\n", cl.BufferedMessages)
+	if len(cl.Subscriptions) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "STREAM\tPARTITION\tBUFFERED")
+		for _, sub := range cl.Subscriptions {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", sub.Stream, sub.Partition, sub.BufferedMessages)
+		}
+	}
+	w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cluster holds connections to the servers in a Liftbridge cluster. Servers
+// other than the one liftctl was pointed at are discovered from metadata and
+// connected to lazily.
+type cluster struct {
+	addr  string
+	opt   grpc.DialOption
+	conns map[string]*grpc.ClientConn
+}
+
+func newCluster(addr string, opt grpc.DialOption) *cluster {
+	return &cluster{addr: addr, opt: opt, conns: make(map[string]*grpc.ClientConn)}
+}
+
+// api returns an API client for the server at the given address.
+func (c *cluster) api(addr string) (client.APIClient, error) {
+	conn, ok := c.conns[addr]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(addr, c.opt)
+		if err != nil {
+			return nil, err
+		}
+		c.conns[addr] = conn
+	}
+	return client.NewAPIClient(conn), nil
+}
+
+// bootstrap returns an API client for the server liftctl was pointed at.
+func (c *cluster) bootstrap() (client.APIClient, error) {
+	return c.api(c.addr)
+}
+
+// metadata fetches broker and stream metadata. If no streams are given,
+// metadata for all streams is returned.
+func (c *cluster) metadata(ctx context.Context, streams ...string) (*client.FetchMetadataResponse, error) {
+	api, err := c.bootstrap()
+	if err != nil {
+		return nil, err
+	}
+	return api.FetchMetadata(ctx, &client.FetchMetadataRequest{Streams: streams})
+}
+
+// streamMetadata fetches metadata for a single stream.
+func (c *cluster) streamMetadata(ctx context.Context, stream string) (*client.FetchMetadataResponse, *client.StreamMetadata, error) {
+	resp, err := c.metadata(ctx, stream)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, meta := range resp.Metadata {
+		if meta.Name == stream && meta.Error == client.StreamMetadata_OK {
+			return resp, meta, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("stream %q not found", stream)
+}
+
+// brokerAPI returns an API client for the broker with the given ID.
+func (c *cluster) brokerAPI(resp *client.FetchMetadataResponse, id string) (client.APIClient, error) {
+	for _, broker := range resp.Brokers {
+		if broker.Id == id {
+			return c.api(brokerAddr(broker))
+		}
+	}
+	return nil, fmt.Errorf("broker %q not found", id)
+}
+
+// partitionLeader returns an API client for the leader of the given stream
+// partition.
+func (c *cluster) partitionLeader(ctx context.Context, stream string, partition int32) (client.APIClient, error) {
+	resp, meta, err := c.streamMetadata(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+	partitionMeta, ok := meta.Partitions[partition]
+	if !ok {
+		return nil, fmt.Errorf("stream %q has no partition %d", stream, partition)
+	}
+	if partitionMeta.Leader == "" {
+		return nil, fmt.Errorf("partition %d of stream %q has no leader", partition, stream)
+	}
+	return c.brokerAPI(resp, partitionMeta.Leader)
+}
+
+// callLeader calls f on each server in the cluster until one does not reject
+// the request for not being the leader. This is used for requests such as
+// cursor operations, which must be handled by the leader of a partition that
+// isn't known ahead of time.
+func (c *cluster) callLeader(ctx context.Context, f func(client.APIClient) error) error {
+	api, err := c.bootstrap()
+	if err != nil {
+		return err
+	}
+	err = f(api)
+	if status.Code(err) != codes.FailedPrecondition {
+		return err
+	}
+	resp, metaErr := c.metadata(ctx)
+	if metaErr != nil {
+		return err
+	}
+	for _, broker := range resp.Brokers {
+		addr := brokerAddr(broker)
+		if addr == c.addr {
+			continue
+		}
+		api, dialErr := c.api(addr)
+		if dialErr != nil {
+			return dialErr
+		}
+		err = f(api)
+		if status.Code(err) != codes.FailedPrecondition {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *cluster) close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+func brokerAddr(broker *client.Broker) string {
+	return net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/urfave/cli"
+)
+
+func cursorCommand() cli.Command {
+	partitionFlag := cli.IntFlag{
+		Name:  "partition, p",
+		Usage: "stream partition `ID` the cursor is for",
+	}
+	return cli.Command{
+		Name:  "cursor",
+		Usage: "manage consumer cursors",
+		Subcommands: []cli.Command{
+			{
+				Name:      "get",
+				Usage:     "print the offset of a cursor, or -1 if it is not set",
+				ArgsUsage: "STREAM CURSOR",
+				Flags:     []cli.Flag{partitionFlag},
+				Action:    action(getCursor),
+			},
+			{
+				Name:      "set",
+				Usage:     "set the offset of a cursor",
+				ArgsUsage: "STREAM CURSOR OFFSET",
+				Flags:     []cli.Flag{partitionFlag},
+				Action:    action(setCursor),
+			},
+		},
+	}
+}
+
+func getCursor(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 2); err != nil {
+		return err
+	}
+	req := &client.FetchCursorRequest{
+		Stream:    c.Args().Get(0),
+		CursorId:  c.Args().Get(1),
+		Partition: int32(c.Int("partition")),
+	}
+	var offset int64
+	if err := cl.callLeader(ctx, func(api client.APIClient) error {
+		resp, err := api.FetchCursor(ctx, req)
+		if err != nil {
+			return err
+		}
+		offset = resp.Offset
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.App.Writer, offset)
+	return nil
+}
+
+func setCursor(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 3); err != nil {
+		return err
+	}
+	offset, err := strconv.ParseInt(c.Args().Get(2), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid offset %q", c.Args().Get(2))
+	}
+	req := &client.SetCursorRequest{
+		Stream:    c.Args().Get(0),
+		CursorId:  c.Args().Get(1),
+		Partition: int32(c.Int("partition")),
+		Offset:    offset,
+	}
+	if err := cl.callLeader(ctx, func(api client.APIClient) error {
+		_, err := api.SetCursor(ctx, req)
+		return err
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Set cursor %s on %s/%d to %d\n", req.CursorId, req.Stream, req.Partition, offset)
+	return nil
+}
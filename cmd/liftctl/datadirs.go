@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// brokerFlag selects the broker a request which applies to a single server is
+// sent to.
+var brokerFlag = cli.StringFlag{
+	Name:  "broker, b",
+	Usage: "send the request to the broker with the given `ID` (default: the server at --admin)",
+}
+
+func dataDirsCommand() cli.Command {
+	return cli.Command{
+		Name:  "datadirs",
+		Usage: "manage a broker's data directories using the admin API",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list a broker's data directories and whether they have failed",
+				Flags:  []cli.Flag{brokerFlag},
+				Action: adminAction(listDataDirs),
+			},
+			{
+				Name:   "rebuild",
+				Usage:  "rebuild the partitions of failed data directories in the online ones",
+				Flags:  []cli.Flag{brokerFlag},
+				Action: adminAction(rebuildDataDirs),
+			},
+		},
+	}
+}
+
+func listDataDirs(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	addr, err := admin.brokerAddr(ctx, c.String("broker"))
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		DataDirs []struct {
+			Path       string     `json:"path"`
+			Online     bool       `json:"online"`
+			Error      string     `json:"error"`
+			FailedAt   *time.Time `json:"failedAt"`
+			Partitions int        `json:"partitions"`
+		} `json:"dataDirs"`
+	}
+	if _, err := admin.do(ctx, addr, http.MethodGet, "/v1/datadirs", &resp); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tSTATE\tPARTITIONS\tFAILED\tERROR")
+	for _, dir := range resp.DataDirs {
+		state, failedAt, errMsg := "online", "-", "-"
+		if !dir.Online {
+			state, errMsg = "offline", dir.Error
+		}
+		if dir.FailedAt != nil {
+			failedAt = dir.FailedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", dir.Path, state, dir.Partitions, failedAt, errMsg)
+	}
+	return w.Flush()
+}
+
+func rebuildDataDirs(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	addr, err := admin.brokerAddr(ctx, c.String("broker"))
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Rebuilt []string `json:"rebuilt"`
+		Pending []string `json:"pending"`
+	}
+	if _, err := admin.do(ctx, addr, http.MethodPost, "/v1/datadirs/rebuild", &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Rebuilt %d partitions\n", len(resp.Rebuilt))
+	if len(resp.Pending) > 0 {
+		fmt.Fprintf(c.App.Writer, "Pending partitions still led by the broker: %s\n",
+			strings.Join(resp.Pending, ", "))
+	}
+	return nil
+}
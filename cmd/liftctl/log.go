@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+)
+
+// logReport is the result of verifying or repairing a partition's log.
+type logReport struct {
+	Segments int   `json:"segments"`
+	Messages int64 `json:"messages"`
+	Issues   []struct {
+		Kind     string `json:"kind"`
+		Segment  int64  `json:"segment"`
+		Offset   int64  `json:"offset"`
+		Position int64  `json:"position"`
+		Message  string `json:"message"`
+	} `json:"issues"`
+	OmittedIssues   int   `json:"omittedIssues"`
+	LastValidOffset int64 `json:"lastValidOffset"`
+	Repaired        bool  `json:"repaired"`
+}
+
+func logCommand() cli.Command {
+	partitionFlag := cli.IntFlag{
+		Name:  "partition, p",
+		Usage: "stream partition `ID` whose log to use",
+	}
+	return cli.Command{
+		Name:  "log",
+		Usage: "check and export the partition logs of a broker using the admin API",
+		Subcommands: []cli.Command{
+			{
+				Name:      "verify",
+				Usage:     "check a partition's log for truncated or corrupt messages and index mismatches",
+				ArgsUsage: "STREAM",
+				Flags:     []cli.Flag{partitionFlag, brokerFlag},
+				Action:    adminAction(verifyLog),
+			},
+			{
+				Name:      "repair",
+				Usage:     "rebuild a follower's indexes and truncate its log before the first invalid message",
+				ArgsUsage: "STREAM",
+				Flags:     []cli.Flag{partitionFlag, brokerFlag},
+				Action:    adminAction(repairLog),
+			},
+			{
+				Name:      "export",
+				Usage:     "export a partition's committed messages to a Parquet file on the broker or an http(s) URL",
+				ArgsUsage: "STREAM DESTINATION",
+				Flags: []cli.Flag{
+					partitionFlag,
+					brokerFlag,
+					cli.Int64Flag{
+						Name:  "start-offset",
+						Usage: "first offset to export, -1 for the oldest offset",
+						Value: -1,
+					},
+					cli.Int64Flag{
+						Name:  "end-offset",
+						Usage: "last offset to export, -1 for the high watermark",
+						Value: -1,
+					},
+				},
+				Action: adminAction(exportLog),
+			},
+		},
+	}
+}
+
+func verifyLog(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	return checkLog(ctx, c, admin, http.MethodGet, "verify")
+}
+
+func repairLog(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	return checkLog(ctx, c, admin, http.MethodPost, "repair")
+}
+
+// checkLog verifies or repairs the log of the given partition and prints the
+// report. It returns an error if the log has issues which weren't repaired.
+func checkLog(ctx context.Context, c *cli.Context, admin *adminClient, method, action string) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	addr, err := admin.brokerAddr(ctx, c.String("broker"))
+	if err != nil {
+		return err
+	}
+	var (
+		stream = c.Args().First()
+		report logReport
+	)
+	if _, err := admin.do(ctx, addr, method, partitionPath(stream, c.Int("partition"), action), &report); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Segments:\t%d\n", report.Segments)
+	fmt.Fprintf(w, "Messages:\t%d\n", report.Messages)
+	fmt.Fprintf(w, "Last valid offset:\t%d\n", report.LastValidOffset)
+	fmt.Fprintf(w, "Repaired:\t%t\n", report.Repaired)
+	if len(report.Issues) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "KIND\tSEGMENT\tOFFSET\tPOSITION\tMESSAGE")
+		for _, issue := range report.Issues {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", issue.Kind, issue.Segment, issue.Offset,
+				issue.Position, issue.Message)
+		}
+		if report.OmittedIssues > 0 {
+			fmt.Fprintf(w, "... %d more issues\n", report.OmittedIssues)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if issues := len(report.Issues) + report.OmittedIssues; issues > 0 && !report.Repaired {
+		return fmt.Errorf("log of partition %d of stream %q has %d issues", c.Int("partition"), stream, issues)
+	}
+	return nil
+}
+
+func exportLog(ctx context.Context, c *cli.Context, admin *adminClient) error {
+	if err := requireArgs(c, 2); err != nil {
+		return err
+	}
+	addr, err := admin.brokerAddr(ctx, c.String("broker"))
+	if err != nil {
+		return err
+	}
+	stream := c.Args().Get(0)
+	query := url.Values{}
+	query.Set("destination", c.Args().Get(1))
+	query.Set("startOffset", strconv.FormatInt(c.Int64("start-offset"), 10))
+	query.Set("endOffset", strconv.FormatInt(c.Int64("end-offset"), 10))
+	var resp struct {
+		Messages int64 `json:"messages"`
+	}
+	path := partitionPath(stream, c.Int("partition"), "export") + "?" + query.Encode()
+	if _, err := admin.do(ctx, addr, http.MethodPost, path, &resp); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Exported %d messages to %s\n", resp.Messages, c.Args().Get(1))
+	return nil
+}
+
+// partitionPath returns the admin API path of the given action on a stream
+// partition.
+func partitionPath(stream string, partition int, action string) string {
+	return fmt.Sprintf("/v1/streams/%s/partitions/%d/%s", url.PathEscape(stream), partition, action)
+}
@@ -0,0 +1,131 @@
+// Command liftctl administers a Liftbridge cluster using the client and admin
+// APIs.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/liftbridge-io/liftbridge/server"
+)
+
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func newApp() *cli.App {
+	app := cli.NewApp()
+	app.Name = "liftctl"
+	app.Usage = "administer a Liftbridge cluster"
+	app.Version = server.Version
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "server, s",
+			Usage:  "connect to the Liftbridge server at `ADDR`",
+			Value:  fmt.Sprintf("localhost:%d", server.DefaultPort),
+			EnvVar: "LIFTCTL_SERVER",
+		},
+		cli.StringFlag{
+			Name:  "tls-ca",
+			Usage: "connect using TLS, verified with the CA certificate `FILE`",
+		},
+		cli.StringFlag{
+			Name:   "admin, a",
+			Usage:  "send admin API requests to the Liftbridge server whose admin API listens at `ADDR`",
+			Value:  "localhost:9293",
+			EnvVar: "LIFTCTL_ADMIN",
+		},
+		cli.StringFlag{
+			Name:  "admin-tls-ca",
+			Usage: "connect to the admin API using HTTPS, verified with the CA certificate `FILE`",
+		},
+		cli.StringFlag{
+			Name:  "admin-tls-cert",
+			Usage: "authenticate to the admin API with the client certificate `FILE`, implies HTTPS",
+		},
+		cli.StringFlag{
+			Name:  "admin-tls-key",
+			Usage: "private key `FILE` of the admin API client certificate",
+		},
+		cli.StringFlag{
+			Name:   "admin-token",
+			Usage:  "authenticate to the admin API with the bearer `TOKEN`",
+			EnvVar: "LIFTCTL_ADMIN_TOKEN",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "timeout for requests",
+			Value: 10 * time.Second,
+		},
+	}
+	app.Commands = []cli.Command{
+		statusCommand(),
+		streamCommand(),
+		tailCommand(),
+		cursorCommand(),
+		brokerCommand(),
+		rebalanceCommand(),
+		dataDirsCommand(),
+		clientCommand(),
+		logCommand(),
+	}
+	return app
+}
+
+// action wraps a command implementation, connecting to the cluster and
+// bounding the command by the request timeout.
+func action(f func(ctx context.Context, c *cli.Context, cl *cluster) error) cli.ActionFunc {
+	return withCluster(func(c *cli.Context, cl *cluster) error {
+		ctx, cancel := context.WithTimeout(context.Background(), c.GlobalDuration("timeout"))
+		defer cancel()
+		return f(ctx, c, cl)
+	})
+}
+
+// withCluster wraps a command implementation, connecting to the cluster.
+func withCluster(f func(c *cli.Context, cl *cluster) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		opt := grpc.WithInsecure()
+		if ca := c.GlobalString("tls-ca"); ca != "" {
+			creds, err := credentials.NewClientTLSFromFile(ca, "")
+			if err != nil {
+				return err
+			}
+			opt = grpc.WithTransportCredentials(creds)
+		}
+		cl := newCluster(c.GlobalString("server"), opt)
+		defer cl.close()
+		return f(c, cl)
+	}
+}
+
+// adminAction wraps a command implementation which uses the admin API,
+// bounding the command by the request timeout.
+func adminAction(f func(ctx context.Context, c *cli.Context, admin *adminClient) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		admin, err := newAdminClient(c)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.GlobalDuration("timeout"))
+		defer cancel()
+		return f(ctx, c, admin)
+	}
+}
+
+// requireArgs returns an error unless exactly n arguments were given.
+func requireArgs(c *cli.Context, n int) error {
+	if c.NArg() != n {
+		return fmt.Errorf("usage: %s %s %s", c.App.Name, c.Command.FullName(), c.Command.ArgsUsage)
+	}
+	return nil
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/liftbridge-io/liftbridge/server"
+	"github.com/liftbridge-io/liftbridge/server/liftbridgetest"
+)
+
+// run runs liftctl against the given server and returns its output.
+func run(t *testing.T, s *liftbridgetest.Server, args ...string) (string, error) {
+	app := newApp()
+	out := new(bytes.Buffer)
+	app.Writer = out
+	err := app.Run(append([]string{"liftctl", "--server", s.Addr()}, args...))
+	return out.String(), err
+}
+
+// Ensures streams can be created, inspected, paused, aliased, renamed, and
+// deleted.
+func TestStreamCommands(t *testing.T) {
+	s := liftbridgetest.Run(t, liftbridgetest.Options{})
+
+	out, err := run(t, s, "stream", "create", "--partitions", "2", "foo")
+	require.NoError(t, err)
+	require.Equal(t, "Created stream foo\n", out)
+
+	_, err = run(t, s, "stream", "create", "foo")
+	require.Error(t, err)
+
+	out, err = run(t, s, "stream", "list")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, []string{"foo", "foo", "2"}, strings.Fields(lines[1])[:3])
+
+	out, err = run(t, s, "stream", "pause", "-p", "1", "foo")
+	require.NoError(t, err)
+	require.Equal(t, "Paused stream foo\n", out)
+
+	out, err = run(t, s, "stream", "describe", "foo")
+	require.NoError(t, err)
+	require.Contains(t, out, "Subject:  foo")
+	id := s.Config().Clustering.ServerID
+	require.Contains(t, out, "0          "+id+"  "+id)
+	require.Regexp(t, `\n1\s+\S+\s+\S+\s+\S+\s+-1\s+-1\s+true\s+false\n`, out)
+
+	out, err = run(t, s, "status")
+	require.NoError(t, err)
+	require.Contains(t, out, "Partitions:                   2\n")
+	require.Contains(t, out, "Paused partitions:            1\n")
+
+	_, err = run(t, s, "stream", "readonly", "foo")
+	require.NoError(t, err)
+	out, err = run(t, s, "stream", "readonly", "--writable", "foo")
+	require.NoError(t, err)
+	require.Equal(t, "Made stream foo writable\n", out)
+
+	out, err = run(t, s, "stream", "alias", "foo", "bar")
+	require.NoError(t, err)
+	require.Equal(t, "Added alias bar to stream foo\n", out)
+	out, err = run(t, s, "stream", "describe", "bar")
+	require.NoError(t, err)
+	require.Contains(t, out, "Subject:  foo")
+	out, err = run(t, s, "stream", "alias", "--remove", "foo", "bar")
+	require.NoError(t, err)
+	require.Equal(t, "Removed alias bar from stream foo\n", out)
+	_, err = run(t, s, "stream", "describe", "bar")
+	require.Error(t, err)
+
+	out, err = run(t, s, "stream", "rename", "foo", "baz")
+	require.NoError(t, err)
+	require.Equal(t, "Renamed stream foo to baz\n", out)
+	out, err = run(t, s, "stream", "list")
+	require.NoError(t, err)
+	require.Contains(t, out, "\nbaz ")
+	require.NotContains(t, out, "\nfoo ")
+	out, err = run(t, s, "stream", "rename", "baz", "foo")
+	require.NoError(t, err)
+	require.Equal(t, "Renamed stream baz to foo\n", out)
+
+	out, err = run(t, s, "stream", "delete", "foo")
+	require.NoError(t, err)
+	require.Equal(t, "Deleted stream foo\n", out)
+
+	_, err = run(t, s, "stream", "describe", "foo")
+	require.Error(t, err)
+	_, err = run(t, s, "stream", "describe")
+	require.Error(t, err)
+}
+
+// Ensures messages can be tailed and cursors can be managed.
+func TestTailAndCursorCommands(t *testing.T) {
+	s := liftbridgetest.Run(t, liftbridgetest.Options{
+		Configure: func(config *server.Config) {
+			config.CursorsStream.Partitions = 1
+		},
+	})
+	_, err := run(t, s, "stream", "create", "foo")
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial(s.Addr(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	for _, value := range []string{"a", "b", "c"} {
+		_, err := api.Publish(context.Background(), &client.PublishRequest{
+			Stream:    "foo",
+			Key:       []byte("k"),
+			Value:     []byte(value),
+			Headers:   map[string][]byte{"h": []byte("v")},
+			AckPolicy: client.AckPolicy_LEADER,
+		})
+		require.NoError(t, err)
+	}
+
+	out, err := run(t, s, "tail", "--earliest", "--to-latest", "--headers", "foo")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	require.Regexp(t, `^offset=0 timestamp=\S+ key="k" value="a" h="v" `, lines[0])
+	require.Regexp(t, `^offset=2 timestamp=\S+ key="k" value="c" h="v" `, lines[2])
+
+	out, err = run(t, s, "tail", "--offset", "1", "-n", "1", "foo")
+	require.NoError(t, err)
+	require.Regexp(t, `^offset=1 timestamp=\S+ key="k" value="b"\n$`, out)
+
+	_, err = run(t, s, "tail", "--earliest", "--latest", "foo")
+	require.Error(t, err)
+
+	out, err = run(t, s, "cursor", "get", "foo", "cursor")
+	require.NoError(t, err)
+	require.Equal(t, "-1\n", out)
+
+	_, err = run(t, s, "cursor", "set", "foo", "cursor", "1")
+	require.NoError(t, err)
+	out, err = run(t, s, "cursor", "get", "foo", "cursor")
+	require.NoError(t, err)
+	require.Equal(t, "1\n", out)
+
+	_, err = run(t, s, "cursor", "set", "foo", "cursor", "x")
+	require.Error(t, err)
+}
+
+// Ensures a stream can be copied into a new stream with a different number
+// of partitions and that the copy resumes from its last checkpoint.
+func TestRepartitionCommand(t *testing.T) {
+	s := liftbridgetest.Run(t, liftbridgetest.Options{
+		Configure: func(config *server.Config) {
+			config.CursorsStream.Partitions = 1
+		},
+	})
+	_, err := run(t, s, "stream", "create", "foo")
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial(s.Addr(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	publish := func(key, value string) {
+		req := &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(value),
+			AckPolicy: client.AckPolicy_LEADER,
+		}
+		if key != "" {
+			req.Key = []byte(key)
+		}
+		_, err := api.Publish(context.Background(), req)
+		require.NoError(t, err)
+	}
+	for _, key := range []string{"a", "b", "c", "", "a", "d"} {
+		publish(key, "v-"+key)
+	}
+
+	// readAll returns the messages in each partition of the new stream.
+	readAll := func() map[int32][]*client.Message {
+		msgs := make(map[int32][]*client.Message)
+		for partition := int32(0); partition < 3; partition++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+				Stream:        "bar",
+				Partition:     partition,
+				StartPosition: client.StartPosition_EARLIEST,
+				StopPosition:  client.StopPosition_STOP_LATEST,
+			})
+			require.NoError(t, err)
+			for {
+				msg, err := sub.Recv()
+				if err != nil {
+					break
+				}
+				if msg.Stream != "" {
+					msgs[partition] = append(msgs[partition], msg)
+				}
+			}
+			cancel()
+		}
+		return msgs
+	}
+
+	out, err := run(t, s, "stream", "repartition", "--partitions", "3", "foo", "bar")
+	require.NoError(t, err)
+	require.Contains(t, out, "Created stream bar\n")
+	require.Contains(t, out, "Partition 0: copied 6 messages through offset 5\n")
+
+	total := 0
+	for partition, msgs := range readAll() {
+		for _, msg := range msgs {
+			require.Equal(t, repartitionPartition(msg.Key, 0, 3), partition)
+			require.Equal(t, "v-"+string(msg.Key), string(msg.Value))
+			total++
+		}
+	}
+	require.Equal(t, 6, total)
+
+	// Running again resumes from the checkpoint.
+	out, err = run(t, s, "stream", "repartition", "--partitions", "3", "foo", "bar")
+	require.NoError(t, err)
+	require.Contains(t, out, "Partition 0: up to date\n")
+
+	publish("e", "v-e")
+	out, err = run(t, s, "stream", "repartition", "--partitions", "3", "foo", "bar")
+	require.NoError(t, err)
+	require.Contains(t, out, "Partition 0: copied 1 messages through offset 6\n")
+	total = 0
+	for _, msgs := range readAll() {
+		total += len(msgs)
+	}
+	require.Equal(t, 7, total)
+
+	_, err = run(t, s, "stream", "repartition", "--partitions", "2", "foo", "bar")
+	require.Error(t, err)
+	_, err = run(t, s, "stream", "repartition", "foo", "baz")
+	require.Error(t, err)
+
+	// Swapping the name copies the remaining messages and replaces the
+	// source stream with the new one.
+	publish("f", "v-f")
+	out, err = run(t, s, "stream", "repartition", "--partitions", "3", "--swap-name", "foo", "bar")
+	require.NoError(t, err)
+	require.Contains(t, out, "Partition 0: copied 1 messages through offset 7\n")
+	require.Contains(t, out, "Made stream foo readonly\n")
+	require.Contains(t, out, "Partition 0: up to date\n")
+	require.True(t, strings.HasSuffix(out, "Renamed stream bar to foo, replacing the source stream\n"))
+	total = 0
+	for _, msgs := range readAll() {
+		total += len(msgs)
+	}
+	require.Equal(t, 8, total)
+
+	out, err = run(t, s, "stream", "list")
+	require.NoError(t, err)
+	require.Regexp(t, `\nfoo\s+bar\s+3\s`, out)
+	require.NotContains(t, out, "\nbar ")
+	publish("g", "v-g")
+
+	_, err = run(t, s, "stream", "repartition", "--partitions", "3", "foo", "bar")
+	require.Error(t, err)
+}
+
+// freeAddr returns a free address on the loopback interface.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// Ensures brokers, data directories, clients and partition logs can be
+// managed with the admin API and that a broker can be drained.
+func TestAdminCommands(t *testing.T) {
+	adminAddr := freeAddr(t)
+	exportDir, err := ioutil.TempDir("", "liftctl_export_")
+	require.NoError(t, err)
+	defer os.RemoveAll(exportDir)
+	s := liftbridgetest.Run(t, liftbridgetest.Options{
+		Configure: func(config *server.Config) {
+			config.AdminListen = adminAddr
+			config.AdminToken = "secret"
+			config.ExportDir = exportDir
+		},
+	})
+	id := s.Config().Clustering.ServerID
+	admin := func(args ...string) (string, error) {
+		return run(t, s, append([]string{"--admin", adminAddr, "--admin-token", "secret"}, args...)...)
+	}
+
+	_, err = run(t, s, "--admin", adminAddr, "broker", "list")
+	require.EqualError(t, err, "Unauthorized")
+
+	out, err := admin("broker", "list")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, []string{id, s.Addr(), adminAddr, "voter", "(leader)", "0", "0", "serving"},
+		strings.Fields(lines[1]))
+
+	out, err = admin("broker", "add", "other")
+	require.NoError(t, err)
+	require.Equal(t, "Added broker other as voter\n", out)
+	out, err = admin("broker", "add", "other")
+	require.NoError(t, err)
+	require.Equal(t, "Broker other is already a voter\n", out)
+	out, err = admin("broker", "remove", "other")
+	require.NoError(t, err)
+	require.Equal(t, "Removed broker other\n", out)
+
+	out, err = admin("datadirs", "list", "--broker", id)
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, []string{"online", "0", "-", "-"}, strings.Fields(lines[1])[1:])
+	_, err = admin("datadirs", "list", "--broker", "unknown")
+	require.EqualError(t, err, `broker "unknown" not found`)
+
+	_, err = run(t, s, "stream", "create", "foo")
+	require.NoError(t, err)
+	conn, err := grpc.Dial(s.Addr(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	for _, value := range []string{"a", "b", "c"} {
+		_, err := api.Publish(context.Background(), &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(value),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	out, err = admin("client", "list")
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	require.True(t, len(lines) > 1)
+	clientID := strings.Fields(lines[1])[0]
+	out, err = admin("client", "describe", clientID)
+	require.NoError(t, err)
+	require.Contains(t, out, "ID:                 "+clientID+"\n")
+	_, err = admin("client", "describe", "x")
+	require.Error(t, err)
+
+	out, err = admin("log", "verify", "foo")
+	require.NoError(t, err)
+	require.Contains(t, out, "Messages:           3\n")
+	require.Contains(t, out, "Last valid offset:  2\n")
+	_, err = admin("log", "verify", "bar")
+	require.Error(t, err)
+
+	dest := filepath.Join(exportDir, "foo.parquet")
+	out, err = admin("log", "export", "--start-offset", "1", "foo", dest)
+	require.NoError(t, err)
+	require.Equal(t, "Exported 2 messages to "+dest+"\n", out)
+
+	out, err = admin("rebalance")
+	require.NoError(t, err)
+	require.Equal(t, "Partition leadership is balanced\n", out)
+
+	out, err = admin("client", "disconnect", clientID)
+	require.NoError(t, err)
+	require.Contains(t, out, "Disconnected client "+clientID)
+
+	out, err = admin("broker", "drain", "--drain-timeout", "5s", id)
+	require.NoError(t, err)
+	require.Equal(t, "Drained broker "+id+", which can be stopped now\n", out)
+	out, err = admin("broker", "list")
+	require.NoError(t, err)
+	require.Contains(t, out, "draining")
+}
+
+// Ensures rebalance hands off leaderships from brokers leading more
+// partitions than others.
+func TestRebalanceCommand(t *testing.T) {
+	cluster := liftbridgetest.RunCluster(t, liftbridgetest.ClusterOptions{
+		Brokers: 2,
+		Configure: func(broker int, config *server.Config) {
+			config.AdminListen = freeAddr(t)
+		},
+	})
+	bootstrap := cluster.Brokers()[0]
+	run := func(args ...string) (string, error) {
+		app := newApp()
+		out := new(bytes.Buffer)
+		app.Writer = out
+		err := app.Run(append([]string{"liftctl", "--server", bootstrap.Addr(),
+			"--admin", bootstrap.Config().AdminListen}, args...))
+		return out.String(), err
+	}
+	for _, name := range []string{"bar", "foo"} {
+		_, err := run("stream", "create", "-r", "2", name)
+		require.NoError(t, err)
+		require.NoError(t, cluster.WaitForISR(name, 0, 2, 0))
+	}
+
+	// Move both leaderships to the leader of foo.
+	leader, err := cluster.WaitForPartitionLeader("foo", 0, 0)
+	require.NoError(t, err)
+	barLeader, err := cluster.WaitForPartitionLeader("bar", 0, 0)
+	require.NoError(t, err)
+	if barLeader != leader {
+		newLeader, err := barLeader.Server().HandoffPartition(context.Background(), "bar", 0)
+		require.NoError(t, err)
+		require.Equal(t, leader.ID(), newLeader)
+	}
+	other := cluster.Brokers()[0]
+	if other == leader {
+		other = cluster.Brokers()[1]
+	}
+	_, err = other.Server().HandoffPartition(context.Background(), "bar", 0)
+	require.Error(t, err)
+
+	handoff := fmt.Sprintf("leadership of bar/0 from %s to %s\n", leader.ID(), other.ID())
+	require.Eventually(t, func() bool {
+		out, err := run("rebalance", "--dry-run")
+		return err == nil && out == "Would hand off "+handoff
+	}, 5*time.Second, 50*time.Millisecond)
+
+	out, err := run("rebalance")
+	require.NoError(t, err)
+	require.Equal(t, "Handed off "+handoff, out)
+	require.Eventually(t, func() bool {
+		out, err := run("rebalance", "--dry-run")
+		return err == nil && out == "Partition leadership is balanced\n"
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/urfave/cli"
+)
+
+func rebalanceCommand() cli.Command {
+	return cli.Command{
+		Name:  "rebalance",
+		Usage: "balance partition leadership across brokers by handing off leaderships",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "print the handoffs without making them",
+			},
+		},
+		Action: withCluster(rebalance),
+	}
+}
+
+// leadership is a partition's leader and the in-sync replicas it can be
+// handed off to.
+type leadership struct {
+	stream    string
+	partition int32
+	leader    string
+	isr       []string
+	moved     bool
+}
+
+func (l *leadership) String() string {
+	return fmt.Sprintf("%s/%d", l.stream, l.partition)
+}
+
+// rebalance hands off the leadership of partitions led by the brokers leading
+// the most partitions to in-sync replicas leading fewer, until no handoff
+// would make the brokers' leader counts more even. Brokers pick the in-sync
+// replica leading the fewest partitions when handing off, which may differ
+// from the planned one, so each partition is handed off at most once.
+func rebalance(c *cli.Context, cl *cluster) error {
+	admin, err := newAdminClient(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.GlobalDuration("timeout"))
+	defer cancel()
+	resp, err := cl.metadata(ctx)
+	if err != nil {
+		return err
+	}
+	brokers, err := admin.brokers(ctx)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int, len(resp.Brokers))
+	for _, broker := range resp.Brokers {
+		counts[broker.Id] = 0
+	}
+	var partitions []*leadership
+	streams := resp.Metadata
+	sort.Slice(streams, func(i, j int) bool { return streams[i].Name < streams[j].Name })
+	for _, stream := range streams {
+		for _, id := range sortedPartitions(stream) {
+			partition := stream.Partitions[id]
+			if partition.Leader == "" {
+				continue
+			}
+			counts[partition.Leader]++
+			partitions = append(partitions, &leadership{
+				stream:    stream.Name,
+				partition: id,
+				leader:    partition.Leader,
+				isr:       partition.Isr,
+			})
+		}
+	}
+
+	moved := 0
+	for {
+		l, target := nextHandoff(partitions, counts)
+		if l == nil {
+			break
+		}
+		l.moved = true
+		if c.Bool("dry-run") {
+			fmt.Fprintf(c.App.Writer, "Would hand off leadership of %s from %s to %s\n", l, l.leader, target)
+		} else {
+			if target, err = handoff(c, admin, brokers, l); err != nil {
+				return fmt.Errorf("failed to hand off leadership of %s: %v", l, err)
+			}
+			fmt.Fprintf(c.App.Writer, "Handed off leadership of %s from %s to %s\n", l, l.leader, target)
+		}
+		counts[l.leader]--
+		counts[target]++
+		l.leader = target
+		moved++
+	}
+	if moved == 0 {
+		fmt.Fprintln(c.App.Writer, "Partition leadership is balanced")
+	}
+	return nil
+}
+
+// nextHandoff returns a partition led by the broker leading the most
+// partitions which has an in-sync replica leading at least two fewer, along
+// with the replica leading the fewest partitions. It returns nil if there is
+// no such partition.
+func nextHandoff(partitions []*leadership, counts map[string]int) (*leadership, string) {
+	var (
+		best   *leadership
+		target string
+	)
+	for _, l := range partitions {
+		if l.moved || (best != nil && counts[l.leader] <= counts[best.leader]) {
+			continue
+		}
+		candidate := ""
+		for _, replica := range l.isr {
+			count, ok := counts[replica]
+			if replica == l.leader || !ok || count+1 >= counts[l.leader] {
+				continue
+			}
+			if candidate == "" || count < counts[candidate] {
+				candidate = replica
+			}
+		}
+		if candidate != "" {
+			best, target = l, candidate
+		}
+	}
+	return best, target
+}
+
+// handoff asks the leader of the given partition to hand off its leadership
+// and returns the new leader.
+func handoff(c *cli.Context, admin *adminClient, brokers []*adminBroker, l *leadership) (string, error) {
+	addr, err := adminAddr(brokers, l.leader)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.GlobalDuration("timeout"))
+	defer cancel()
+	var resp struct {
+		Leader string `json:"leader"`
+	}
+	path := partitionPath(l.stream, int(l.partition), "handoff")
+	if _, err := admin.do(ctx, addr, http.MethodPost, path, &resp); err != nil {
+		return "", err
+	}
+	return resp.Leader, nil
+}
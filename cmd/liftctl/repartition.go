@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"os/signal"
+	"syscall"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/liftbridge-io/liftbridge/server"
+)
+
+// repartitionCheckpointInterval is the number of messages copied from a
+// partition between progress checkpoints.
+const repartitionCheckpointInterval = 100
+
+func repartitionCommand() cli.Command {
+	return cli.Command{
+		Name:  "repartition",
+		Usage: "copy a stream into a new stream with a different number of partitions",
+		Description: "Messages are assigned to partitions of the new stream by hashing their key " +
+			"and keyless messages keep the source partition modulo the new partition count. " +
+			"Progress is checkpointed with a cursor on the source stream, so an interrupted " +
+			"copy resumes where it left off when run again. With --swap-name, the source " +
+			"stream is made readonly once copied, the remaining messages are copied, and the " +
+			"new stream is renamed to the source name, replacing the source stream.",
+		ArgsUsage: "SOURCE DEST",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "partitions",
+				Usage: "number of partitions of the new stream",
+			},
+			cli.StringFlag{
+				Name:  "subject",
+				Usage: "NATS subject the new stream attaches to (default: new stream name)",
+			},
+			cli.IntFlag{
+				Name:  "replication-factor, r",
+				Usage: "number of replicas for each partition of the new stream, -1 for all brokers",
+				Value: 1,
+			},
+			cli.BoolFlag{
+				Name:  "swap-name",
+				Usage: "cut over by renaming the new stream to the source name, deleting the source stream",
+			},
+		},
+		Action: withCluster(repartition),
+	}
+}
+
+// repartitionCursor returns the ID of the cursor used to track the progress of
+// copying into the given stream.
+func repartitionCursor(dest string) string {
+	return "liftctl-repartition-" + dest
+}
+
+func repartition(c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 2); err != nil {
+		return err
+	}
+	var (
+		source     = c.Args().Get(0)
+		dest       = c.Args().Get(1)
+		partitions = int32(c.Int("partitions"))
+		timeout    = c.GlobalDuration("timeout")
+	)
+	if partitions < 1 {
+		return errors.New("--partitions must be at least 1")
+	}
+	if source == dest {
+		return errors.New("source and destination streams must be different")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, timeout)
+	_, sourceMeta, err := cl.streamMetadata(reqCtx, source)
+	if err == nil {
+		err = ensureRepartitionDest(reqCtx, c, cl, sourceMeta, dest, partitions)
+	}
+	reqCancel()
+	if err != nil {
+		return err
+	}
+
+	if err := copyPartitions(ctx, c, cl, sourceMeta, dest, partitions); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Copied stream %s to %s with %d partitions\n", source, dest, partitions)
+	if !c.Bool("swap-name") {
+		return nil
+	}
+	return swapRepartitionName(ctx, c, cl, sourceMeta, dest, partitions)
+}
+
+// copyPartitions copies each partition of the source stream into the new
+// stream.
+func copyPartitions(ctx context.Context, c *cli.Context, cl *cluster, sourceMeta *client.StreamMetadata,
+	dest string, partitions int32) error {
+
+	for _, id := range sortedPartitions(sourceMeta) {
+		if err := copyPartition(ctx, c, cl, sourceMeta.Name, id, dest, partitions); err != nil {
+			if ctx.Err() != nil {
+				return errors.New("interrupted, run the command again to resume")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// swapRepartitionName cuts over to the new stream. The source stream is made
+// readonly so no messages are published to it after the final copy, then the
+// new stream is renamed to the source name, replacing the source stream. The
+// rename is a single metadata operation, so clients using the source name are
+// served by the new stream from then on. If interrupted, running the command
+// again resumes the cutover since the source stream is left readonly.
+func swapRepartitionName(ctx context.Context, c *cli.Context, cl *cluster, sourceMeta *client.StreamMetadata,
+	dest string, partitions int32) error {
+
+	source := sourceMeta.Name
+	api, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+	reqCtx, reqCancel := context.WithTimeout(ctx, c.GlobalDuration("timeout"))
+	_, err = api.SetStreamReadonly(reqCtx, &client.SetStreamReadonlyRequest{
+		Name:     source,
+		Readonly: true,
+	})
+	reqCancel()
+	if err != nil {
+		return fmt.Errorf("failed to make stream %s readonly: %v", source, err)
+	}
+	fmt.Fprintf(c.App.Writer, "Made stream %s readonly\n", source)
+
+	if err := copyPartitions(ctx, c, cl, sourceMeta, dest, partitions); err != nil {
+		return err
+	}
+
+	reqCtx, reqCancel = context.WithTimeout(ctx, c.GlobalDuration("timeout"))
+	defer reqCancel()
+	reqCtx = metadata.AppendToOutgoingContext(reqCtx,
+		server.RenameFromMetadata, dest,
+		server.RenameReplaceMetadata, "true")
+	if _, err := api.CreateStream(reqCtx, &client.CreateStreamRequest{Name: source}); err != nil {
+		return fmt.Errorf("failed to rename stream %s to %s: %v", dest, source, err)
+	}
+	fmt.Fprintf(c.App.Writer, "Renamed stream %s to %s, replacing the source stream\n", dest, source)
+	return nil
+}
+
+// ensureRepartitionDest creates the destination stream, or checks the number
+// of partitions if it already exists from a previous run. It returns an error
+// if the destination is the source stream, such as after the source has been
+// replaced by a renamed stream.
+func ensureRepartitionDest(ctx context.Context, c *cli.Context, cl *cluster, sourceMeta *client.StreamMetadata,
+	dest string, partitions int32) error {
+
+	api, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+	subject := c.String("subject")
+	if subject == "" {
+		subject = dest
+	}
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Name:              dest,
+		Subject:           subject,
+		Partitions:        partitions,
+		ReplicationFactor: int32(c.Int("replication-factor")),
+	})
+	if err == nil {
+		fmt.Fprintf(c.App.Writer, "Created stream %s\n", dest)
+		return nil
+	}
+	if status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+	_, meta, err := cl.streamMetadata(ctx, dest)
+	if err != nil {
+		return err
+	}
+	if meta.Subject == sourceMeta.Subject && meta.CreationTimestamp == sourceMeta.CreationTimestamp {
+		return fmt.Errorf("stream %s refers to the source stream %s", dest, sourceMeta.Name)
+	}
+	if int32(len(meta.Partitions)) != partitions {
+		return fmt.Errorf("stream %s already exists with %d partitions", dest, len(meta.Partitions))
+	}
+	return nil
+}
+
+// copyPartition copies the messages of a source partition, starting after
+// the last checkpoint, up to its newest message at the time of the copy.
+func copyPartition(ctx context.Context, c *cli.Context, cl *cluster, source string, partition int32,
+	dest string, partitions int32) error {
+
+	timeout := c.GlobalDuration("timeout")
+	cursor := &client.SetCursorRequest{
+		Stream:    source,
+		Partition: partition,
+		CursorId:  repartitionCursor(dest),
+	}
+	checkpoint := func(offset int64) error {
+		cursor.Offset = offset
+		reqCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return cl.callLeader(reqCtx, func(api client.APIClient) error {
+			_, err := api.SetCursor(reqCtx, cursor)
+			return err
+		})
+	}
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, timeout)
+	var last int64
+	err := cl.callLeader(reqCtx, func(api client.APIClient) error {
+		resp, err := api.FetchCursor(reqCtx, &client.FetchCursorRequest{
+			Stream:    source,
+			Partition: partition,
+			CursorId:  cursor.CursorId,
+		})
+		if err != nil {
+			return err
+		}
+		last = resp.Offset
+		return nil
+	})
+	if err != nil {
+		reqCancel()
+		return fmt.Errorf("failed to fetch progress for partition %d: %v", partition, err)
+	}
+	sourceAPI, err := cl.partitionLeader(reqCtx, source, partition)
+	if err != nil {
+		reqCancel()
+		return err
+	}
+	metaResp, err := sourceAPI.FetchPartitionMetadata(reqCtx, &client.FetchPartitionMetadataRequest{
+		Stream:    source,
+		Partition: partition,
+	})
+	reqCancel()
+	if err != nil {
+		return err
+	}
+	if newest := metaResp.Metadata.NewestOffset; newest <= last {
+		fmt.Fprintf(c.App.Writer, "Partition %d: up to date\n", partition)
+		return nil
+	}
+	destAPI, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	defer subCancel()
+	sub, err := sourceAPI.Subscribe(subCtx, &client.SubscribeRequest{
+		Stream:        source,
+		Partition:     partition,
+		StartPosition: client.StartPosition_OFFSET,
+		StartOffset:   last + 1,
+		StopPosition:  client.StopPosition_STOP_OFFSET,
+		StopOffset:    metaResp.Metadata.NewestOffset,
+	})
+	if err != nil {
+		return err
+	}
+	// The first message is empty and signals the subscription was created.
+	if _, err := sub.Recv(); err != nil {
+		return err
+	}
+
+	var (
+		copied  int64
+		pending int
+		offset  = last
+	)
+	for {
+		msg, err := sub.Recv()
+		if err != nil {
+			if err := subscribeErr(ctx, err); err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			break
+		}
+
+		headers := make(map[string][]byte, len(msg.Headers)+1)
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
+		// Set a message ID derived from the source position so that, if
+		// publish deduplication is enabled on the server, messages published
+		// again after an interrupted copy are dropped.
+		headers[server.MsgIDHeader] = []byte(fmt.Sprintf("%s/%d/%d", source, partition, msg.Offset))
+
+		reqCtx, reqCancel := context.WithTimeout(ctx, timeout)
+		_, err = destAPI.Publish(reqCtx, &client.PublishRequest{
+			Stream:    dest,
+			Partition: repartitionPartition(msg.Key, partition, partitions),
+			Key:       msg.Key,
+			Value:     msg.Value,
+			Headers:   headers,
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		reqCancel()
+		if err != nil {
+			return fmt.Errorf("failed to copy offset %d of partition %d: %v", msg.Offset, partition, err)
+		}
+		offset = msg.Offset
+		copied++
+		pending++
+
+		if pending == repartitionCheckpointInterval {
+			if err := checkpoint(offset); err != nil {
+				return fmt.Errorf("failed to checkpoint partition %d: %v", partition, err)
+			}
+			pending = 0
+			fmt.Fprintf(c.App.Writer, "Partition %d: copied through offset %d\n", partition, offset)
+		}
+	}
+	if pending > 0 {
+		if err := checkpoint(offset); err != nil {
+			return fmt.Errorf("failed to checkpoint partition %d: %v", partition, err)
+		}
+	}
+	fmt.Fprintf(c.App.Writer, "Partition %d: copied %d messages through offset %d\n", partition, copied, offset)
+	return nil
+}
+
+// repartitionPartition returns the partition of the new stream a message is
+// copied to. Keyed messages are assigned using the same hash the server uses
+// to partition by key.
+func repartitionPartition(key []byte, partition, partitions int32) int32 {
+	if key == nil {
+		return partition % partitions
+	}
+	return int32(crc32.ChecksumIEEE(key) % uint32(partitions))
+}
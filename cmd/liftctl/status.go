@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/urfave/cli"
+)
+
+func statusCommand() cli.Command {
+	return cli.Command{
+		Name:   "status",
+		Usage:  "show brokers and partition health",
+		Action: action(clusterStatus),
+	}
+}
+
+func clusterStatus(ctx context.Context, c *cli.Context, cl *cluster) error {
+	resp, err := cl.metadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	leaders := make(map[string]int)
+	var (
+		partitions      int
+		offline         []string
+		underReplicated []string
+		paused          []string
+	)
+	for _, stream := range resp.Metadata {
+		for _, id := range sortedPartitions(stream) {
+			partition := stream.Partitions[id]
+			name := fmt.Sprintf("%s/%d", stream.Name, id)
+			partitions++
+			if partition.Leader == "" {
+				offline = append(offline, name)
+			} else {
+				leaders[partition.Leader]++
+			}
+			if len(partition.Isr) < len(partition.Replicas) {
+				underReplicated = append(underReplicated, name)
+			}
+			if partition.Paused {
+				paused = append(paused, name)
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Brokers:\t%d\n", len(resp.Brokers))
+	fmt.Fprintf(w, "Streams:\t%d\n", len(resp.Metadata))
+	fmt.Fprintf(w, "Partitions:\t%d\n", partitions)
+	fmt.Fprintf(w, "Offline partitions:\t%d\n", len(offline))
+	fmt.Fprintf(w, "Under-replicated partitions:\t%d\n", len(underReplicated))
+	fmt.Fprintf(w, "Paused partitions:\t%d\n", len(paused))
+	fmt.Fprintln(w)
+
+	brokers := resp.Brokers
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].Id < brokers[j].Id })
+	fmt.Fprintln(w, "BROKER\tADDRESS\tLEADERS")
+	for _, broker := range brokers {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", broker.Id, brokerAddr(broker), leaders[broker.Id])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	printPartitionList(c, "Offline", offline)
+	printPartitionList(c, "Under-replicated", underReplicated)
+	return nil
+}
+
+func printPartitionList(c *cli.Context, title string, partitions []string) {
+	if len(partitions) == 0 {
+		return
+	}
+	fmt.Fprintf(c.App.Writer, "\n%s partitions:\n", title)
+	for _, partition := range partitions {
+		fmt.Fprintf(c.App.Writer, "  %s\n", partition)
+	}
+}
+
+func sortedPartitions(stream *client.StreamMetadata) []int32 {
+	ids := make([]int32, 0, len(stream.Partitions))
+	for id := range stream.Partitions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
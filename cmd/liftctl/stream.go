@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/liftbridge-io/liftbridge/server"
+)
+
+func streamCommand() cli.Command {
+	partitionsFlag := cli.IntSliceFlag{
+		Name:  "partition, p",
+		Usage: "partition `ID` to apply to, may be repeated (default: all partitions)",
+	}
+	return cli.Command{
+		Name:  "stream",
+		Usage: "manage streams",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list streams",
+				Action: action(listStreams),
+			},
+			{
+				Name:      "describe",
+				Usage:     "show a stream's partitions and offsets",
+				ArgsUsage: "STREAM",
+				Action:    action(describeStream),
+			},
+			{
+				Name:      "create",
+				Usage:     "create a stream",
+				ArgsUsage: "STREAM",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "subject",
+						Usage: "NATS subject the stream attaches to (default: stream name)",
+					},
+					cli.StringFlag{
+						Name:  "group",
+						Usage: "load-balance group the stream joins",
+					},
+					cli.IntFlag{
+						Name:  "partitions",
+						Usage: "number of partitions",
+						Value: 1,
+					},
+					cli.IntFlag{
+						Name:  "replication-factor, r",
+						Usage: "number of replicas for each partition, -1 for all brokers",
+						Value: 1,
+					},
+				},
+				Action: action(createStream),
+			},
+			{
+				Name:      "delete",
+				Usage:     "delete a stream and its data",
+				ArgsUsage: "STREAM",
+				Action:    action(deleteStream),
+			},
+			{
+				Name:      "pause",
+				Usage:     "pause a stream's partitions",
+				ArgsUsage: "STREAM",
+				Flags: []cli.Flag{
+					partitionsFlag,
+					cli.BoolFlag{
+						Name:  "resume-all",
+						Usage: "resume all partitions when any of them receives a message",
+					},
+				},
+				Action: action(pauseStream),
+			},
+			{
+				Name:      "readonly",
+				Usage:     "make a stream's partitions readonly",
+				ArgsUsage: "STREAM",
+				Flags: []cli.Flag{
+					partitionsFlag,
+					cli.BoolFlag{
+						Name:  "writable",
+						Usage: "make the partitions writable again",
+					},
+				},
+				Action: action(setStreamReadonly),
+			},
+			{
+				Name:      "alias",
+				Usage:     "add an alternate name a stream can be referenced by",
+				ArgsUsage: "STREAM ALIAS",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "remove",
+						Usage: "remove the alias instead",
+					},
+				},
+				Action: action(aliasStream),
+			},
+			{
+				Name:      "rename",
+				Usage:     "rename a stream, keeping its previous name as an alias",
+				ArgsUsage: "STREAM NAME",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "replace",
+						Usage: "replace the stream or alias NAME refers to, deleting a stream",
+					},
+				},
+				Action: action(renameStream),
+			},
+			repartitionCommand(),
+		},
+	}
+}
+
+func listStreams(ctx context.Context, c *cli.Context, cl *cluster) error {
+	resp, err := cl.metadata(ctx)
+	if err != nil {
+		return err
+	}
+	streams := resp.Metadata
+	sort.Slice(streams, func(i, j int) bool { return streams[i].Name < streams[j].Name })
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSUBJECT\tPARTITIONS\tCREATED")
+	for _, stream := range streams {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", stream.Name, stream.Subject, len(stream.Partitions),
+			time.Unix(0, stream.CreationTimestamp).UTC().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func describeStream(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	resp, stream, err := cl.streamMetadata(ctx, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", stream.Name)
+	fmt.Fprintf(w, "Subject:\t%s\n", stream.Subject)
+	fmt.Fprintf(w, "Created:\t%s\n", time.Unix(0, stream.CreationTimestamp).UTC().Format(time.RFC3339))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "PARTITION\tLEADER\tREPLICAS\tISR\tHW\tNEWEST\tPAUSED\tREADONLY")
+	for _, id := range sortedPartitions(stream) {
+		partition := stream.Partitions[id]
+		// Offsets in stream metadata come from the server that was queried,
+		// so ask the leader for up-to-date values.
+		if partition.Leader != "" {
+			if api, err := cl.brokerAPI(resp, partition.Leader); err == nil {
+				leaderResp, err := api.FetchPartitionMetadata(ctx, &client.FetchPartitionMetadataRequest{
+					Stream:    stream.Name,
+					Partition: id,
+				})
+				if err == nil {
+					partition = leaderResp.Metadata
+				}
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%t\t%t\n", id, partition.Leader,
+			strings.Join(partition.Replicas, ","), strings.Join(partition.Isr, ","),
+			partition.HighWatermark, partition.NewestOffset, partition.Paused, partition.Readonly)
+	}
+	return w.Flush()
+}
+
+func createStream(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	name := c.Args().First()
+	subject := c.String("subject")
+	if subject == "" {
+		subject = name
+	}
+	api, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+	if _, err := api.CreateStream(ctx, &client.CreateStreamRequest{
+		Name:              name,
+		Subject:           subject,
+		Group:             c.String("group"),
+		Partitions:        int32(c.Int("partitions")),
+		ReplicationFactor: int32(c.Int("replication-factor")),
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Created stream %s\n", name)
+	return nil
+}
+
+func deleteStream(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	name := c.Args().First()
+	api, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+	if _, err := api.DeleteStream(ctx, &client.DeleteStreamRequest{Name: name}); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Deleted stream %s\n", name)
+	return nil
+}
+
+func aliasStream(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 2); err != nil {
+		return err
+	}
+	name := c.Args().Get(0)
+	alias := c.Args().Get(1)
+	api, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, server.AliasForMetadata, name)
+	if c.Bool("remove") {
+		if _, err := api.DeleteStream(ctx, &client.DeleteStreamRequest{Name: alias}); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.Writer, "Removed alias %s from stream %s\n", alias, name)
+		return nil
+	}
+	if _, err := api.CreateStream(ctx, &client.CreateStreamRequest{Name: alias}); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Added alias %s to stream %s\n", alias, name)
+	return nil
+}
+
+func renameStream(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 2); err != nil {
+		return err
+	}
+	name := c.Args().Get(0)
+	newName := c.Args().Get(1)
+	api, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, server.RenameFromMetadata, name)
+	if c.Bool("replace") {
+		ctx = metadata.AppendToOutgoingContext(ctx, server.RenameReplaceMetadata, "true")
+	}
+	if _, err := api.CreateStream(ctx, &client.CreateStreamRequest{Name: newName}); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Renamed stream %s to %s\n", name, newName)
+	return nil
+}
+
+func pauseStream(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	name := c.Args().First()
+	api, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+	if _, err := api.PauseStream(ctx, &client.PauseStreamRequest{
+		Name:       name,
+		Partitions: partitionFlags(c),
+		ResumeAll:  c.Bool("resume-all"),
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.Writer, "Paused stream %s\n", name)
+	return nil
+}
+
+func setStreamReadonly(ctx context.Context, c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	name := c.Args().First()
+	api, err := cl.bootstrap()
+	if err != nil {
+		return err
+	}
+	readonly := !c.Bool("writable")
+	if _, err := api.SetStreamReadonly(ctx, &client.SetStreamReadonlyRequest{
+		Name:       name,
+		Partitions: partitionFlags(c),
+		Readonly:   readonly,
+	}); err != nil {
+		return err
+	}
+	if readonly {
+		fmt.Fprintf(c.App.Writer, "Made stream %s readonly\n", name)
+	} else {
+		fmt.Fprintf(c.App.Writer, "Made stream %s writable\n", name)
+	}
+	return nil
+}
+
+func partitionFlags(c *cli.Context) []int32 {
+	var partitions []int32
+	for _, id := range c.IntSlice("partition") {
+		partitions = append(partitions, int32(id))
+	}
+	return partitions
+}
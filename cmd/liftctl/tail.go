@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func tailCommand() cli.Command {
+	return cli.Command{
+		Name:      "tail",
+		Usage:     "print messages from a stream partition",
+		ArgsUsage: "STREAM",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "partition, p",
+				Usage: "partition `ID` to read from",
+			},
+			cli.Int64Flag{
+				Name:  "offset, o",
+				Usage: "start at `OFFSET`",
+				Value: -1,
+			},
+			cli.BoolFlag{
+				Name:  "earliest",
+				Usage: "start at the oldest message",
+			},
+			cli.BoolFlag{
+				Name:  "latest",
+				Usage: "start at the newest message",
+			},
+			cli.DurationFlag{
+				Name:  "since",
+				Usage: "start at the first message received within `DURATION`",
+			},
+			cli.IntFlag{
+				Name:  "count, n",
+				Usage: "exit after `N` messages",
+			},
+			cli.BoolFlag{
+				Name:  "to-latest",
+				Usage: "exit after the newest message at the time of subscribing instead of waiting for new messages",
+			},
+			cli.BoolFlag{
+				Name:  "headers",
+				Usage: "print message headers",
+			},
+		},
+		Action: withCluster(tail),
+	}
+}
+
+func tail(c *cli.Context, cl *cluster) error {
+	if err := requireArgs(c, 1); err != nil {
+		return err
+	}
+	req, err := subscribeRequest(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	lookupCtx, lookupCancel := context.WithTimeout(ctx, c.GlobalDuration("timeout"))
+	api, err := cl.partitionLeader(lookupCtx, req.Stream, req.Partition)
+	lookupCancel()
+	if err != nil {
+		return err
+	}
+	sub, err := api.Subscribe(ctx, req)
+	if err != nil {
+		return err
+	}
+	// The first message is empty and signals the subscription was created.
+	if _, err := sub.Recv(); err != nil {
+		return subscribeErr(ctx, err)
+	}
+
+	count := c.Int("count")
+	for received := 0; count == 0 || received < count; received++ {
+		msg, err := sub.Recv()
+		if err != nil {
+			return subscribeErr(ctx, err)
+		}
+		printMessage(c.App.Writer, msg, c.Bool("headers"))
+	}
+	return nil
+}
+
+// subscribeRequest builds a SubscribeRequest from the tail command's flags.
+func subscribeRequest(c *cli.Context) (*client.SubscribeRequest, error) {
+	req := &client.SubscribeRequest{
+		Stream:        c.Args().First(),
+		Partition:     int32(c.Int("partition")),
+		StartPosition: client.StartPosition_NEW_ONLY,
+	}
+	positions := 0
+	if offset := c.Int64("offset"); offset >= 0 {
+		req.StartPosition = client.StartPosition_OFFSET
+		req.StartOffset = offset
+		positions++
+	}
+	if c.Bool("earliest") {
+		req.StartPosition = client.StartPosition_EARLIEST
+		positions++
+	}
+	if c.Bool("latest") {
+		req.StartPosition = client.StartPosition_LATEST
+		positions++
+	}
+	if since := c.Duration("since"); since > 0 {
+		req.StartPosition = client.StartPosition_TIMESTAMP
+		req.StartTimestamp = time.Now().Add(-since).UnixNano()
+		positions++
+	}
+	if positions > 1 {
+		return nil, errors.New("only one of --offset, --earliest, --latest, and --since can be set")
+	}
+	if c.Bool("to-latest") {
+		req.StopPosition = client.StopPosition_STOP_LATEST
+	}
+	return req, nil
+}
+
+// subscribeErr translates errors ending a subscription. Reaching the stop
+// position or being interrupted ends the command without an error.
+func subscribeErr(ctx context.Context, err error) error {
+	if err == io.EOF || ctx.Err() != nil {
+		return nil
+	}
+	if status.Code(err) == codes.ResourceExhausted {
+		// Returned when the stop position is reached or the partition is
+		// empty with --to-latest.
+		return nil
+	}
+	return err
+}
+
+func printMessage(w io.Writer, msg *client.Message, headers bool) {
+	fmt.Fprintf(w, "offset=%d timestamp=%s key=%q value=%q", msg.Offset,
+		time.Unix(0, msg.Timestamp).UTC().Format(time.RFC3339Nano), msg.Key, msg.Value)
+	if headers {
+		keys := make([]string, 0, len(msg.Headers))
+		for key := range msg.Headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, " %s=%q", key, msg.Headers[key])
+		}
+	}
+	fmt.Fprintln(w)
+}
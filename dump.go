@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+func dumpCommand() cli.Command {
+	return cli.Command{
+		Name:      "dump",
+		Usage:     "print the contents of segment log, index and time index files",
+		ArgsUsage: "FILE...",
+		Flags: []cli.Flag{
+			cli.Int64Flag{
+				Name:  "start-offset",
+				Usage: "first offset to print, -1 for the first offset in the file",
+				Value: -1,
+			},
+			cli.Int64Flag{
+				Name:  "end-offset",
+				Usage: "last offset to print, -1 for the last offset in the file",
+				Value: -1,
+			},
+			cli.StringFlag{
+				Name:  "start-time",
+				Usage: "print entries with a timestamp at or after `TIME` (RFC 3339)",
+			},
+			cli.StringFlag{
+				Name:  "end-time",
+				Usage: "print entries with a timestamp at or before `TIME` (RFC 3339)",
+			},
+			cli.IntFlag{
+				Name:  "preview-bytes",
+				Usage: "number of bytes of keys, values and headers to print, -1 for all of them",
+				Value: 64,
+			},
+		},
+		Action: dump,
+	}
+}
+
+// dumpFilter selects the entries printed by the dump command.
+type dumpFilter struct {
+	startOffset int64
+	endOffset   int64
+	startTime   int64
+	endTime     int64
+}
+
+// match indicates if an entry with the given offset and timestamp should be
+// printed.
+func (f *dumpFilter) match(offset, timestamp int64) bool {
+	return (f.startOffset < 0 || offset >= f.startOffset) &&
+		(f.endOffset < 0 || offset <= f.endOffset) &&
+		(f.startTime == 0 || timestamp >= f.startTime) &&
+		(f.endTime == 0 || timestamp <= f.endTime)
+}
+
+// done indicates if no entry following one with the given offset will be
+// printed. Offsets increase through a file while timestamps may not.
+func (f *dumpFilter) done(offset int64) bool {
+	return f.endOffset >= 0 && offset >= f.endOffset
+}
+
+func dump(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("at least one file is required")
+	}
+	filter := &dumpFilter{
+		startOffset: c.Int64("start-offset"),
+		endOffset:   c.Int64("end-offset"),
+	}
+	for name, ts := range map[string]*int64{"start-time": &filter.startTime, "end-time": &filter.endTime} {
+		if c.String(name) == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, c.String(name))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		*ts = t.UnixNano()
+	}
+	for _, path := range c.Args() {
+		if err := dumpFile(os.Stdout, path, filter, c.Int("preview-bytes")); err != nil {
+			return fmt.Errorf("failed to dump %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// dumpFile prints the entries of the given segment log, index or time index
+// file which match the filter.
+func dumpFile(w io.Writer, path string, filter *dumpFilter, previewBytes int) error {
+	var (
+		format  int
+		printed int
+		err     error
+	)
+	name := filepath.Base(path)
+	switch {
+	case strings.Contains(name, ".log"):
+		format, err = commitlog.DumpLog(path, func(msg *commitlog.DumpedMessage) bool {
+			if filter.match(msg.Offset, msg.Timestamp) {
+				printMessage(w, msg, previewBytes)
+				printed++
+			}
+			return !filter.done(msg.Offset)
+		})
+	case strings.Contains(name, ".timeindex"):
+		format, err = commitlog.DumpTimeIndex(path, func(e *commitlog.DumpedTimeIndexEntry) bool {
+			if filter.match(e.Offset, e.Timestamp) {
+				fmt.Fprintf(w, "offset: %d max timestamp: %s position: %d\n",
+					e.Offset, formatTimestamp(e.Timestamp), e.Position)
+				printed++
+			}
+			return !filter.done(e.Offset)
+		})
+	case strings.Contains(name, ".index"):
+		format, err = commitlog.DumpIndex(path, func(e *commitlog.DumpedIndexEntry) bool {
+			if filter.match(e.Offset, e.Timestamp) {
+				fmt.Fprintf(w, "offset: %d timestamp: %s position: %d size: %d\n",
+					e.Offset, formatTimestamp(e.Timestamp), e.Position, e.Size)
+				printed++
+			}
+			return !filter.done(e.Offset)
+		})
+	default:
+		return errors.New("not a segment log, index or time index file")
+	}
+	fmt.Fprintf(w, "%s: format version %d, %d entries printed\n", path, format, printed)
+	return err
+}
+
+func printMessage(w io.Writer, msg *commitlog.DumpedMessage, previewBytes int) {
+	fmt.Fprintf(w, "offset: %d timestamp: %s leaderEpoch: %d position: %d size: %d crc: 0x%08x",
+		msg.Offset, formatTimestamp(msg.Timestamp), msg.LeaderEpoch, msg.Position, msg.Size, msg.Crc)
+	if !msg.CrcValid {
+		fmt.Fprintln(w, " CORRUPT: crc didn't match")
+		return
+	}
+	fmt.Fprintf(w, " attributes: %d key: %s", msg.Attributes, preview(msg.Key, previewBytes))
+	if len(msg.Headers) > 0 {
+		keys := make([]string, 0, len(msg.Headers))
+		for key := range msg.Headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		headers := make([]string, len(keys))
+		for i, key := range keys {
+			headers[i] = strconv.Quote(key) + ": " + preview(msg.Headers[key], previewBytes)
+		}
+		fmt.Fprintf(w, " headers: {%s}", strings.Join(headers, ", "))
+	}
+	fmt.Fprintf(w, " value: %s\n", preview(msg.Value, previewBytes))
+}
+
+// preview returns the quoted bytes, truncated to n bytes unless n is
+// negative.
+func preview(b []byte, n int) string {
+	if b == nil {
+		return "null"
+	}
+	if n < 0 || len(b) <= n {
+		return strconv.Quote(string(b))
+	}
+	return fmt.Sprintf("%s... (%d bytes)", strconv.Quote(string(b[:n])), len(b))
+}
+
+func formatTimestamp(ts int64) string {
+	return time.Unix(0, ts).UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// Ensure dumpFile prints the messages of a segment log and its index which
+// match the filter.
+func TestDumpFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lift_dump_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := commitlog.New(commitlog.Options{Path: dir, MaxSegmentBytes: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []*commitlog.Message{
+		{Key: []byte("a"), Value: []byte("one"), Timestamp: 1000},
+		{Value: []byte("two"), Timestamp: 2000, Headers: map[string][]byte{"h": []byte("v")}},
+		{Value: []byte(strings.Repeat("x", 100)), Timestamp: 3000},
+	}
+	if _, err := l.Append(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, "00000000000000000000.log")
+
+	var out bytes.Buffer
+	filter := &dumpFilter{startOffset: 1, endOffset: -1}
+	if err := dumpFile(&out, logPath, filter, 8); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", out.String())
+	}
+	for _, expected := range []string{"offset: 1 ", `headers: {"h": "v"}`, `value: "two"`} {
+		if !strings.Contains(lines[0], expected) {
+			t.Fatalf("expected %q in %q", expected, lines[0])
+		}
+	}
+	if !strings.Contains(lines[1], `value: "xxxxxxxx"... (100 bytes)`) {
+		t.Fatalf("expected truncated value in %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "format version 1, 2 entries printed") {
+		t.Fatalf("unexpected summary %q", lines[2])
+	}
+
+	out.Reset()
+	filter = &dumpFilter{startOffset: -1, endOffset: -1, endTime: 1000}
+	if err := dumpFile(&out, strings.TrimSuffix(logPath, ".log")+".index", filter, 8); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "offset: 0 timestamp: 1970-01-01T00:00:00.000001Z") {
+		t.Fatalf("unexpected index dump %q", out.String())
+	}
+
+	if err := dumpFile(&out, filepath.Join(dir, "replication.offset"), filter, 8); err == nil {
+		t.Fatal("expected error dumping a file which isn't part of a segment")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/urfave/cli"
+
+	"github.com/liftbridge-io/liftbridge/server"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	"github.com/liftbridge-io/liftbridge/server/encryption"
+	"github.com/liftbridge-io/liftbridge/server/parquet"
+)
+
+func exportCommand() cli.Command {
+	return cli.Command{
+		Name:  "export",
+		Usage: "export a stream partition from a data directory to a Parquet file",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "config, c",
+				Usage: "load configuration from `FILE`",
+			},
+			cli.StringFlag{
+				Name:  "data-dir, d",
+				Usage: "read data from `DIR` (default: data directory from configuration)",
+			},
+			cli.StringFlag{
+				Name:  "stream, s",
+				Usage: "name of the stream to export",
+			},
+			cli.IntFlag{
+				Name:  "partition, p",
+				Usage: "partition of the stream to export",
+			},
+			cli.Int64Flag{
+				Name:  "start-offset",
+				Usage: "first offset to export, -1 for the oldest offset",
+				Value: -1,
+			},
+			cli.Int64Flag{
+				Name:  "end-offset",
+				Usage: "last offset to export, -1 for the high watermark",
+				Value: -1,
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "write to new local `FILE` or upload with a PUT request to an http(s) URL",
+			},
+			cli.IntFlag{
+				Name:  "row-group-size",
+				Usage: "number of messages per Parquet row group",
+				Value: parquet.DefaultRowGroupSize,
+			},
+			cli.BoolFlag{
+				Name:  "decrypt",
+				Usage: "decrypt message values of a stream with encryption at rest enabled",
+			},
+			cli.StringFlag{
+				Name:  "segment-keys-dir",
+				Usage: "read the keys of encrypted segments from `DIR` (default: segment.encryption.keys.dir from configuration if enabled)",
+			},
+		},
+		Action: export,
+	}
+}
+
+func export(c *cli.Context) error {
+	if c.String("stream") == "" {
+		return errors.New("stream name is required")
+	}
+	if c.String("output") == "" {
+		return errors.New("output is required")
+	}
+	var (
+		dataDir = c.String("data-dir")
+		keysDir = c.String("segment-keys-dir")
+	)
+	if dataDir == "" {
+		config, err := server.NewConfig(c.String("config"))
+		if err != nil {
+			return err
+		}
+		dataDir = config.DataDir
+		if dataDir == "" {
+			dataDir = filepath.Join("/tmp", "liftbridge", config.Clustering.Namespace)
+		}
+		if keysDir == "" && config.Streams.SegmentEncryption {
+			keysDir = config.Streams.SegmentEncryptionKeysDir
+		}
+	}
+
+	path := filepath.Join(dataDir, "streams", c.String("stream"),
+		strconv.Itoa(c.Int("partition")))
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no data for partition: %v", err)
+	}
+	var keys commitlog.KeyProvider
+	if keysDir != "" {
+		provider, err := encryption.NewDirKeyProvider(keysDir)
+		if err != nil {
+			return err
+		}
+		keys = provider
+	}
+
+	opts := server.ExportOptions{
+		StartOffset:  c.Int64("start-offset"),
+		EndOffset:    c.Int64("end-offset"),
+		Destination:  c.String("output"),
+		RowGroupSize: c.Int("row-group-size"),
+	}
+	if c.Bool("decrypt") {
+		codec, err := encryption.NewLocalEncryptionHandler()
+		if err != nil {
+			return err
+		}
+		opts.Codec = codec
+	}
+	n, err := server.ExportDir(context.Background(), path, keys, c.String("stream"), opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d messages to %s\n", n, opts.Destination)
+	return nil
+}
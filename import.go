@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/liftbridge-io/liftbridge/server"
+	"github.com/liftbridge-io/liftbridge/server/kafka"
+	"github.com/liftbridge-io/liftbridge/server/logger"
+)
+
+func importKafkaCommand() cli.Command {
+	return cli.Command{
+		Name:  "import-kafka",
+		Usage: "import a Kafka topic into a stream, translating consumer group offsets to cursors",
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "kafka-brokers, k",
+				Usage: "connect to Kafka bootstrap brokers at `ADDR[,ADDR]`",
+			},
+			cli.BoolFlag{
+				Name:  "kafka-tls",
+				Usage: "connect to Kafka brokers using TLS, verified with the system CAs unless --kafka-tls-ca is set",
+			},
+			cli.StringFlag{
+				Name:  "kafka-tls-ca",
+				Usage: "connect to Kafka brokers using TLS, verified with the CA certificate `FILE`",
+			},
+			cli.StringFlag{
+				Name:  "kafka-tls-cert",
+				Usage: "connect to Kafka brokers using TLS, presenting the client certificate `FILE`",
+			},
+			cli.StringFlag{
+				Name:  "kafka-tls-key",
+				Usage: "private key `FILE` of the Kafka client certificate",
+			},
+			cli.StringFlag{
+				Name:  "kafka-sasl-mechanism",
+				Usage: "SASL mechanism used to authenticate to Kafka [PLAIN|SCRAM-SHA-256|SCRAM-SHA-512]",
+				Value: kafka.SASLPlain,
+			},
+			cli.StringFlag{
+				Name:  "kafka-sasl-user",
+				Usage: "authenticate to Kafka with SASL as `USER`",
+			},
+			cli.StringFlag{
+				Name:   "kafka-sasl-password",
+				Usage:  "password of the Kafka SASL user",
+				EnvVar: "LIFTBRIDGE_KAFKA_SASL_PASSWORD",
+			},
+			cli.StringFlag{
+				Name:  "topic, t",
+				Usage: "Kafka topic to import",
+			},
+			cli.StringFlag{
+				Name:  "group, g",
+				Usage: "Kafka consumer group whose offsets are translated to cursors",
+			},
+			cli.StringFlag{
+				Name:  "stream, s",
+				Usage: "stream to import into (default: topic name)",
+			},
+			cli.StringFlag{
+				Name:  "server",
+				Usage: "Liftbridge server `ADDR` to connect to",
+				Value: fmt.Sprintf("localhost:%d", server.DefaultPort),
+			},
+			cli.StringFlag{
+				Name:  "tls-ca",
+				Usage: "connect to the Liftbridge server using TLS, verified with the CA certificate `FILE`",
+			},
+			cli.StringFlag{
+				Name:  "cursor-id",
+				Usage: "ID of the cursor used to checkpoint import progress (default: kafka-import-<topic>)",
+			},
+			cli.BoolFlag{
+				Name:  "follow, f",
+				Usage: "keep importing new records after catching up",
+			},
+			cli.StringFlag{
+				Name:  "level, l",
+				Usage: "logging level [debug|info|warn|error]",
+				Value: "info",
+			},
+		},
+		Action: importKafka,
+	}
+}
+
+func importKafka(c *cli.Context) error {
+	brokers, err := normalizeNatsServers(c.StringSlice("kafka-brokers"))
+	if err != nil {
+		return err
+	}
+	if len(brokers) == 0 {
+		return errors.New("kafka brokers are required")
+	}
+	topic := c.String("topic")
+	if topic == "" {
+		return errors.New("topic is required")
+	}
+	stream := c.String("stream")
+	if stream == "" {
+		stream = topic
+	}
+	level, err := server.GetLogLevel(c.String("level"))
+	if err != nil {
+		return err
+	}
+
+	opts, err := kafkaOptions(c)
+	if err != nil {
+		return err
+	}
+	opts.Brokers = brokers
+	source, err := kafka.NewClient(opts)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	dialOpt := grpc.WithInsecure()
+	if ca := c.String("tls-ca"); ca != "" {
+		creds, err := credentials.NewClientTLSFromFile(ca, "")
+		if err != nil {
+			return err
+		}
+		dialOpt = grpc.WithTransportCredentials(creds)
+	}
+	apis, closeAPIs, err := dialCluster(c.String("server"), dialOpt)
+	if err != nil {
+		return err
+	}
+	defer closeAPIs()
+
+	importer, err := kafka.NewImporter(source, apis, kafka.ImportOptions{
+		Topic:    topic,
+		Stream:   stream,
+		Group:    c.String("group"),
+		CursorID: c.String("cursor-id"),
+		Follow:   c.Bool("follow"),
+		Logger:   logger.NewLogger(level),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+	return importer.Run(ctx)
+}
+
+// kafkaOptions returns the TLS and SASL options for connecting to Kafka set
+// by the flags.
+func kafkaOptions(c *cli.Context) (kafka.Options, error) {
+	var opts kafka.Options
+	if c.Bool("kafka-tls") {
+		opts.TLS = &tls.Config{}
+	}
+	if ca := c.String("kafka-tls-ca"); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return opts, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return opts, fmt.Errorf("no certificates found in %s", ca)
+		}
+		opts.TLS = &tls.Config{RootCAs: pool}
+	}
+	if cert := c.String("kafka-tls-cert"); cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, c.String("kafka-tls-key"))
+		if err != nil {
+			return opts, err
+		}
+		if opts.TLS == nil {
+			opts.TLS = &tls.Config{}
+		}
+		opts.TLS.Certificates = []tls.Certificate{pair}
+	}
+	if user := c.String("kafka-sasl-user"); user != "" {
+		opts.SASL = &kafka.SASL{
+			Mechanism: c.String("kafka-sasl-mechanism"),
+			User:      user,
+			Password:  c.String("kafka-sasl-password"),
+		}
+	}
+	return opts, nil
+}
+
+// dialCluster connects to the given server and every other server in its
+// cluster.
+func dialCluster(addr string, opt grpc.DialOption) ([]client.APIClient, func(), error) {
+	var conns []*grpc.ClientConn
+	closeAll := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	conn, err := grpc.Dial(addr, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	conns = append(conns, conn)
+	api := client.NewAPIClient(conn)
+	apis := []client.APIClient{api}
+
+	resp, err := api.FetchMetadata(context.Background(), &client.FetchMetadataRequest{})
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	seen := map[string]struct{}{addr: {}}
+	for _, broker := range resp.Brokers {
+		brokerAddr := net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+		if _, ok := seen[brokerAddr]; ok {
+			continue
+		}
+		seen[brokerAddr] = struct{}{}
+		conn, err := grpc.Dial(brokerAddr, opt)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		conns = append(conns, conn)
+		apis = append(apis, client.NewAPIClient(conn))
+	}
+	return apis, closeAll, nil
+}
@@ -21,6 +21,13 @@ func main() {
 	app.Version = server.Version
 	app.Flags = getFlags()
 	app.Action = start
+	app.Commands = []cli.Command{
+		exportCommand(),
+		importKafkaCommand(),
+		migrateLogsCommand(),
+		dumpCommand(),
+		benchCommand(),
+	}
 	if err := app.Run(os.Args); err != nil {
 		panic(err)
 	}
@@ -35,6 +42,16 @@ func start(c *cli.Context) error {
 	if err := overrideFromFlags(c, config); err != nil {
 		return err
 	}
+	if c.Bool("validate-config") {
+		return validateConfig(config)
+	}
+	service, err := server.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if service {
+		return server.RunWindowsService(c.String("service-name"), config)
+	}
 	server := server.New(config)
 	if err := server.Start(); err != nil {
 		return err
@@ -43,6 +60,24 @@ func start(c *cli.Context) error {
 	return nil
 }
 
+// validateConfig checks the configuration and prints the effective
+// configuration without starting the server. Problems are reported with a
+// non-zero exit status so this can be used as a deployment gate.
+func validateConfig(config *server.Config) error {
+	warnings, err := config.Validate()
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := config.WriteEffective(os.Stdout); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Configuration is valid")
+	return nil
+}
+
 func overrideFromFlags(c *cli.Context, config *server.Config) error {
 	// Override with flags.
 	if c.IsSet("id") {
@@ -61,6 +96,9 @@ func overrideFromFlags(c *cli.Context, config *server.Config) error {
 		}
 		config.LogLevel = level
 	}
+	if c.IsSet("log-file") {
+		config.LogFile = c.String("log-file")
+	}
 	if c.IsSet("raft-bootstrap-seed") {
 		config.Clustering.RaftBootstrapSeed = c.Bool("raft-bootstrap-seed")
 	}
@@ -159,6 +197,19 @@ func getFlags() []cli.Flag {
 			Name:  "raft-bootstrap-peers",
 			Usage: "bootstrap the Raft cluster with the provided list of peer IDs if there is no existing state",
 		},
+		cli.StringFlag{
+			Name:  "log-file",
+			Usage: "append log messages to `FILE` instead of stderr",
+		},
+		cli.BoolFlag{
+			Name:  "validate-config",
+			Usage: "check the configuration and print the effective configuration without starting the server",
+		},
+		cli.StringFlag{
+			Name:  "service-name",
+			Usage: "name of the Windows service when running as one",
+			Value: "liftbridge",
+		},
 	}
 }
 
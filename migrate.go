@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli"
+
+	"github.com/liftbridge-io/liftbridge/server"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+func migrateLogsCommand() cli.Command {
+	return cli.Command{
+		Name:  "migrate-logs",
+		Usage: "upgrade stream partition logs to the current segment format (the server must not be running)",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "config, c",
+				Usage: "load configuration from `FILE`",
+			},
+			cli.StringSliceFlag{
+				Name:  "data-dir, d",
+				Usage: "migrate data in `DIR`, can be repeated (default: data directories from configuration)",
+			},
+		},
+		Action: migrateLogs,
+	}
+}
+
+func migrateLogs(c *cli.Context) error {
+	dataDirs := c.StringSlice("data-dir")
+	if len(dataDirs) == 0 {
+		config, err := server.NewConfig(c.String("config"))
+		if err != nil {
+			return err
+		}
+		dataDirs = config.DataDirs
+		if len(dataDirs) == 0 {
+			dataDir := config.DataDir
+			if dataDir == "" {
+				dataDir = filepath.Join("/tmp", "liftbridge", config.Clustering.Namespace)
+			}
+			dataDirs = []string{dataDir}
+		}
+	}
+
+	var partitions, segments int
+	for _, dataDir := range dataDirs {
+		paths, err := filepath.Glob(filepath.Join(dataDir, "streams", "*", "*"))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				continue
+			}
+			migrated, err := commitlog.MigrateLog(path)
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s: %v", path, err)
+			}
+			if migrated > 0 {
+				fmt.Printf("Migrated %d segments in %s\n", migrated, path)
+				partitions++
+				segments += migrated
+			}
+		}
+	}
+	fmt.Printf("Migrated %d segments in %d partitions to format version %d\n",
+		segments, partitions, commitlog.CurrentFormat)
+	return nil
+}
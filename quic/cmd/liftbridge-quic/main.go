@@ -0,0 +1,79 @@
+// Command liftbridge-quic relays QUIC connections to a Liftbridge server's
+// gRPC port, so that clients can connect to the server over QUIC. It is
+// experimental.
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+	"github.com/urfave/cli"
+
+	"github.com/liftbridge-io/liftbridge/quic"
+)
+
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func newApp() *cli.App {
+	app := cli.NewApp()
+	app.Name = "liftbridge-quic"
+	app.Usage = "relay QUIC connections to a Liftbridge server"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "listen, l",
+			Usage: "listen for QUIC connections on the UDP address `ADDR`",
+			Value: ":9292",
+		},
+		cli.StringFlag{
+			Name:  "server, s",
+			Usage: "relay connections to the Liftbridge server at `ADDR`",
+			Value: "localhost:9292",
+		},
+		cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "server certificate `FILE`, which QUIC requires",
+		},
+		cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "private key `FILE` of the server certificate",
+		},
+		cli.DurationFlag{
+			Name:  "idle-timeout",
+			Usage: "close connections which are idle for `DURATION`",
+			Value: defaultIdleTimeout,
+		},
+	}
+	app.Action = run
+	return app
+}
+
+// defaultIdleTimeout is the idle timeout of relayed connections. gRPC
+// keepalives are disabled by default and subscriptions can be idle for a
+// while, so it's longer than QUIC's default.
+const defaultIdleTimeout = 5 * time.Minute
+
+func run(c *cli.Context) error {
+	if c.String("tls-cert") == "" || c.String("tls-key") == "" {
+		return fmt.Errorf("--tls-cert and --tls-key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(c.String("tls-cert"), c.String("tls-key"))
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	ln, err := quic.Listen(c.String("listen"),
+		&tls.Config{Certificates: []tls.Certificate{cert}},
+		&quicgo.Config{MaxIdleTimeout: c.Duration("idle-timeout")})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Relaying QUIC connections on %s to %s\n", ln.Addr(), c.String("server"))
+	return quic.Forward(ln, c.String("server"))
+}
@@ -0,0 +1,225 @@
+// Package quic carries the Liftbridge client API over QUIC. It is
+// experimental and is a separate module so that the server's dependencies and
+// Go version are not tied to a QUIC implementation.
+//
+// A QUIC connection carries a single stream with the gRPC connection a client
+// would otherwise make over TCP. Compared to TCP, QUIC recovers from packet
+// loss without stalling the connection's congestion window as much, which
+// helps on lossy WAN links, and a connection survives the client's address
+// changing, e.g. when a mobile or edge subscriber switches networks. Servers
+// don't accept QUIC connections themselves. Instead, Forward relays QUIC
+// connections to a server's gRPC port, e.g. with the liftbridge-quic command
+// running next to the server, and clients connect with Dialer.
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+// NextProto is the ALPN protocol negotiated by QUIC connections carrying the
+// Liftbridge gRPC API.
+const NextProto = "liftbridge-grpc"
+
+// streamAcceptTimeout bounds how long a QUIC connection can take to open its
+// stream once its handshake completes.
+const streamAcceptTimeout = 10 * time.Second
+
+// conn is a net.Conn over the stream of a QUIC connection.
+type conn struct {
+	*quicgo.Stream
+	qc *quicgo.Conn
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.qc.LocalAddr()
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.qc.RemoteAddr()
+}
+
+// Close closes the stream and the QUIC connection.
+func (c *conn) Close() error {
+	c.Stream.CancelRead(0)
+	c.Stream.Close() // nolint: errcheck
+	return c.qc.CloseWithError(0, "")
+}
+
+// CloseWrite closes the sending side of the stream, so the peer reads EOF
+// once it has read the data already written.
+func (c *conn) CloseWrite() error {
+	return c.Stream.Close()
+}
+
+// listener is a net.Listener accepting the streams of QUIC connections.
+type listener struct {
+	ln     *quicgo.Listener
+	conns  chan net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	err    error // Error which ended the accept loop, set before conns is closed
+}
+
+// Listen listens for QUIC connections on the given UDP address and returns a
+// net.Listener accepting the stream each connection opens. NextProto is
+// negotiated if the TLS config doesn't set any protocols. The QUIC config may
+// be nil.
+func Listen(addr string, tlsConfig *tls.Config, config *quicgo.Config) (net.Listener, error) {
+	ln, err := quicgo.ListenAddr(addr, withNextProto(tlsConfig), config)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &listener{
+		ln:     ln,
+		conns:  make(chan net.Conn),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+func (l *listener) acceptLoop() {
+	defer close(l.conns)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		qc, err := l.ln.Accept(l.ctx)
+		if err != nil {
+			l.err = err
+			return
+		}
+		// Accept streams concurrently so a slow client doesn't hold up
+		// others.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(l.ctx, streamAcceptTimeout)
+			defer cancel()
+			stream, err := qc.AcceptStream(ctx)
+			if err != nil {
+				qc.CloseWithError(0, "no stream opened") // nolint: errcheck
+				return
+			}
+			select {
+			case l.conns <- &conn{Stream: stream, qc: qc}:
+			case <-l.ctx.Done():
+				qc.CloseWithError(0, "listener closed") // nolint: errcheck
+			}
+		}()
+	}
+}
+
+// Accept returns the stream of the next QUIC connection.
+func (l *listener) Accept() (net.Conn, error) {
+	c, ok := <-l.conns
+	if !ok {
+		return nil, l.err
+	}
+	return c, nil
+}
+
+// Close stops listening. Connections already accepted are not closed.
+func (l *listener) Close() error {
+	l.cancel()
+	return l.ln.Close()
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Dial opens a QUIC connection to the given UDP address and returns a
+// net.Conn over a stream of it. NextProto is negotiated if the TLS config
+// doesn't set any protocols. The QUIC config may be nil.
+func Dial(ctx context.Context, addr string, tlsConfig *tls.Config, config *quicgo.Config) (net.Conn, error) {
+	qc, err := quicgo.DialAddr(ctx, addr, withNextProto(tlsConfig), config)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		qc.CloseWithError(0, "") // nolint: errcheck
+		return nil, err
+	}
+	return &conn{Stream: stream, qc: qc}, nil
+}
+
+// Dialer returns a function which dials QUIC connections with the given
+// configs, for use with grpc.WithContextDialer. Since QUIC connections are
+// encrypted with TLS, the gRPC connection itself should be insecure, e.g.:
+//
+//	conn, err := grpc.Dial("liftbridge.example.com:9292",
+//		grpc.WithContextDialer(quic.Dialer(tlsConfig, nil)),
+//		grpc.WithInsecure())
+func Dialer(tlsConfig *tls.Config, config *quicgo.Config) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return Dial(ctx, addr, tlsConfig, config)
+	}
+}
+
+// Forward relays the connections accepted by the listener to the TCP address,
+// e.g. a Liftbridge server's gRPC port, until the listener is closed. Each
+// relayed connection is closed once both of its directions are done.
+func Forward(ln net.Listener, addr string) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go forward(c, addr)
+	}
+}
+
+func forward(c net.Conn, addr string) {
+	defer c.Close()
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyAndCloseWrite(target, c)
+	}()
+	go func() {
+		defer wg.Done()
+		copyAndCloseWrite(c, target)
+	}()
+	wg.Wait()
+}
+
+// copyAndCloseWrite copies src to dst until EOF or an error and then closes
+// the sending side of dst, so the peer sees the end of the stream.
+func copyAndCloseWrite(dst, src net.Conn) {
+	io.Copy(dst, src) // nolint: errcheck
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite() // nolint: errcheck
+		return
+	}
+	dst.Close() // nolint: errcheck
+}
+
+// withNextProto returns the TLS config with NextProto set if it doesn't set
+// any protocols.
+func withNextProto(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = new(tls.Config)
+	}
+	if len(tlsConfig.NextProtos) > 0 {
+		return tlsConfig
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{NextProto}
+	return tlsConfig
+}
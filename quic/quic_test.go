@@ -0,0 +1,106 @@
+package quic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testTLSConfigs returns a server TLS config with a self-signed certificate
+// for 127.0.0.1 and a client TLS config trusting it.
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "liftbridge"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return server, &tls.Config{RootCAs: pool}
+}
+
+// Ensure data written on a dialed connection is read from the accepted one
+// and closing the sending side is seen as EOF.
+func TestListenDial(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	ln, err := Listen("127.0.0.1:0", serverTLS, nil)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := Dial(ctx, ln.Addr().String(), clientTLS, nil)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, client.(*conn).CloseWrite())
+
+	server, err := ln.Accept()
+	require.NoError(t, err)
+	defer server.Close()
+	data, err := ioutil.ReadAll(server)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+	require.Equal(t, ln.Addr().String(), client.RemoteAddr().String())
+
+	// Accept returns an error once the listener is closed.
+	require.NoError(t, ln.Close())
+	_, err = ln.Accept()
+	require.Error(t, err)
+}
+
+// Ensure gRPC requests are relayed over QUIC to a gRPC server.
+func TestForward(t *testing.T) {
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(tcpLn) // nolint: errcheck
+	defer srv.Stop()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+	ln, err := Listen("127.0.0.1:0", serverTLS, nil)
+	require.NoError(t, err)
+	defer ln.Close()
+	go Forward(ln, tcpLn.Addr().String()) // nolint: errcheck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, ln.Addr().String(),
+		grpc.WithContextDialer(Dialer(clientTLS, nil)),
+		grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, new(healthpb.HealthCheckRequest))
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/nats-io/nuid"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// coalescedAckInboxToken is the token following the namespace and "ack" in
+// the ack inboxes of publishers which accept coalesced acks, i.e. inboxes of
+// the form <namespace>.ack.batch.<id>. Acks to these inboxes may be delivered
+// in ack batch envelopes. Acks to other inboxes are always delivered one per
+// NATS message.
+const coalescedAckInboxToken = "batch"
+
+// getCoalescedAckInbox returns a random NATS subject to use for publish acks
+// which accepts ack batch envelopes, scoped to the cluster namespace.
+func (s *Server) getCoalescedAckInbox() string {
+	return fmt.Sprintf("%s.ack.%s.%s", s.config.Clustering.Namespace, coalescedAckInboxToken, nuid.Next())
+}
+
+// ackCoalescer batches the acks a partition sends to each ack inbox which
+// accepts coalesced acks, publishing them together once the flush interval
+// passes after the first pending ack or maxAcks acks are pending for the
+// inbox. This cuts the NATS messages sent to publishers which have many
+// messages in flight to the partition.
+type ackCoalescer struct {
+	partition *partition
+	prefix    string // Prefix of inboxes which accept coalesced acks
+	interval  time.Duration
+	maxAcks   int
+	mu        sync.Mutex
+	pending   map[string][]*client.Ack
+	timer     *time.Timer // Set while acks are pending
+}
+
+// newAckCoalescer returns an ackCoalescer for the partition or nil if acks
+// are not coalesced.
+func newAckCoalescer(p *partition, interval time.Duration, maxAcks int) *ackCoalescer {
+	if interval <= 0 || maxAcks <= 1 {
+		return nil
+	}
+	return &ackCoalescer{
+		partition: p,
+		prefix:    p.srv.config.Clustering.Namespace + ".ack." + coalescedAckInboxToken + ".",
+		interval:  interval,
+		maxAcks:   maxAcks,
+		pending:   make(map[string][]*client.Ack),
+	}
+}
+
+// send publishes the ack or, if its inbox accepts coalesced acks, adds it to
+// the inbox's pending acks.
+func (c *ackCoalescer) send(ack *client.Ack) {
+	if !strings.HasPrefix(ack.AckInbox, c.prefix) {
+		c.partition.publishAck(ack)
+		return
+	}
+	c.mu.Lock()
+	acks := append(c.pending[ack.AckInbox], ack)
+	if len(acks) >= c.maxAcks {
+		delete(c.pending, ack.AckInbox)
+		c.mu.Unlock()
+		c.publish(acks)
+		return
+	}
+	c.pending[ack.AckInbox] = acks
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.interval, c.flush)
+	}
+	c.mu.Unlock()
+}
+
+// flush publishes all pending acks.
+func (c *ackCoalescer) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string][]*client.Ack)
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.mu.Unlock()
+	for _, acks := range pending {
+		c.publish(acks)
+	}
+}
+
+// publish publishes the acks to their inbox in an ack batch envelope, or as a
+// plain ack if there is only one.
+func (c *ackCoalescer) publish(acks []*client.Ack) {
+	p := c.partition
+	if len(acks) == 1 {
+		p.publishAck(acks[0])
+		return
+	}
+	data, err := proto.AppendAckBatch(getEnvelopeBuffer(), acks)
+	if err != nil {
+		panic(err)
+	}
+	err = p.srv.ncAcks.Publish(acks[0].AckInbox, data)
+	putEnvelopeBuffer(data)
+	if err != nil {
+		p.srv.logger.Errorf("Error sending %d acks for partition %s: %v", len(acks), p, err)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure acks to inboxes which accept coalesced acks are published in batches
+// once enough are pending or the flush interval passes, and acks to other
+// inboxes are published immediately.
+func TestAckCoalescer(t *testing.T) {
+	defer cleanupStorage(t)
+
+	server := createServer()
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	nc, err := nats.GetDefaultOptions().Connect()
+	require.NoError(t, err)
+	defer nc.Close()
+
+	p, err := server.newPartition(&proto.Partition{
+		Subject:  "foo",
+		Stream:   "foo",
+		Replicas: []string{"a"},
+		Leader:   "a",
+		Isr:      []string{"a"},
+	}, false, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	require.Nil(t, newAckCoalescer(p, 0, 10))
+	require.Nil(t, newAckCoalescer(p, time.Second, 1))
+	coalescer := newAckCoalescer(p, 200*time.Millisecond, 3)
+
+	inbox := server.getCoalescedAckInbox()
+	require.True(t, strings.HasPrefix(inbox, server.config.Clustering.Namespace+".ack.batch."))
+	sub, err := nc.SubscribeSync(inbox)
+	require.NoError(t, err)
+	plainInbox := server.getAckInbox()
+	plainSub, err := nc.SubscribeSync(plainInbox)
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	// Acks to other inboxes are not coalesced.
+	coalescer.send(&client.Ack{Offset: 0, AckInbox: plainInbox})
+	msg, err := plainSub.NextMsg(time.Second)
+	require.NoError(t, err)
+	ack, err := proto.UnmarshalAck(msg.Data)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), ack.Offset)
+
+	// Reaching maxAcks publishes a batch.
+	for i := 0; i < 3; i++ {
+		coalescer.send(&client.Ack{Offset: int64(i), AckInbox: inbox})
+	}
+	msg, err = sub.NextMsg(100 * time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, proto.IsAckBatch(msg.Data))
+	acks, err := proto.UnmarshalAckBatch(msg.Data)
+	require.NoError(t, err)
+	require.Len(t, acks, 3)
+	for i, ack := range acks {
+		require.Equal(t, int64(i), ack.Offset)
+	}
+
+	// Pending acks are published after the flush interval.
+	coalescer.send(&client.Ack{Offset: 3, AckInbox: inbox})
+	coalescer.send(&client.Ack{Offset: 4, AckInbox: inbox})
+	_, err = sub.NextMsg(50 * time.Millisecond)
+	require.Equal(t, nats.ErrTimeout, err)
+	msg, err = sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	acks, err = proto.UnmarshalAckBatch(msg.Data)
+	require.NoError(t, err)
+	require.Len(t, acks, 2)
+
+	// A single pending ack is published as a plain ack.
+	coalescer.send(&client.Ack{Offset: 5, AckInbox: inbox})
+	coalescer.flush()
+	msg, err = sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	ack, err = proto.UnmarshalAck(msg.Data)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), ack.Offset)
+}
+
+// Ensure PublishAsync returns an ack for every message when acks are
+// coalesced.
+func TestPublishAsyncCoalescedAcks(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.AckCoalesceInterval = 10 * time.Millisecond
+	s1Config.Streams.AckCoalesceMaxAcks = 8
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	stream, err := api.PublishAsync(ctx)
+	require.NoError(t, err)
+	num := 50
+	for i := 0; i < num; i++ {
+		require.NoError(t, stream.Send(&client.PublishRequest{
+			Stream:        "foo",
+			Value:         []byte(strconv.Itoa(i)),
+			AckPolicy:     client.AckPolicy_ALL,
+			CorrelationId: strconv.Itoa(i),
+		}))
+	}
+
+	acked := make(map[string]int64)
+	for len(acked) < num {
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		require.Nil(t, resp.AsyncError)
+		acked[resp.CorrelationId] = resp.Ack.Offset
+	}
+	for i := 0; i < num; i++ {
+		require.Equal(t, int64(i), acked[strconv.Itoa(i)])
+	}
+}
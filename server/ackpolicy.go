@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// AckPolicyHeader is the message header selecting an ack policy beyond those
+// of the publish API. The only supported value is AckPolicyReplicated.
+const AckPolicyHeader = "Liftbridge-Ack-Policy"
+
+// AckPolicyReplicated is the AckPolicyHeader value acknowledging a message
+// once it has been replicated to the ISR without waiting for it to be synced
+// to disk when streams.sync.on.append is enabled. Replicas sync such messages
+// in the background instead, so an acknowledged message is only lost if every
+// ISR replica fails before syncing it. Messages published with
+// AckPolicy_LEADER are handled as AckPolicy_ALL, while messages published
+// with AckPolicy_NONE only defer syncing.
+const AckPolicyReplicated = "replicated"
+
+// checkAckPolicyHeader verifies that a publish request's AckPolicyHeader, if
+// set, is supported.
+func checkAckPolicyHeader(req *client.PublishRequest) *client.PublishAsyncError {
+	policy, ok := req.Headers[AckPolicyHeader]
+	if !ok || string(policy) == AckPolicyReplicated {
+		return nil
+	}
+	return &client.PublishAsyncError{
+		Code:    client.PublishAsyncError_BAD_REQUEST,
+		Message: fmt.Sprintf("unsupported ack policy: %s", policy),
+	}
+}
+
+// applyAckPolicyHeader marks messages published with the AckPolicyReplicated
+// policy to defer syncing and to be acknowledged once replicated to the ISR.
+// Other values, which can be published directly to NATS, are ignored.
+func applyAckPolicyHeader(msgs []*commitlog.Message) {
+	for _, m := range msgs {
+		if string(m.Headers[AckPolicyHeader]) != AckPolicyReplicated {
+			continue
+		}
+		m.Attributes |= commitlog.AttrSyncDeferred
+		if m.AckPolicy == client.AckPolicy_LEADER {
+			m.AckPolicy = client.AckPolicy_ALL
+		}
+	}
+}
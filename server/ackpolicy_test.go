@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Ensure messages published with the replicated ack policy are acknowledged
+// once committed without waiting for fsyncs, other messages wait for them, and
+// unsupported ack policies are rejected.
+func TestAckPolicyReplicated(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.SyncOnAppend = true
+	s1Config.Streams.SyncMaxDelay = 500 * time.Millisecond
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	start := time.Now()
+	resp, err := api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("replicated"),
+		Headers:   map[string][]byte{AckPolicyHeader: []byte(AckPolicyReplicated)},
+		AckPolicy: client.AckPolicy_LEADER,
+	})
+	require.NoError(t, err)
+	require.True(t, time.Since(start) < s1Config.Streams.SyncMaxDelay)
+	require.Equal(t, int64(0), resp.Ack.Offset)
+	require.Equal(t, client.AckPolicy_ALL, resp.Ack.AckPolicy)
+
+	start = time.Now()
+	resp, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("synced"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= s1Config.Streams.SyncMaxDelay)
+	require.Equal(t, int64(1), resp.Ack.Offset)
+
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("bad"),
+		Headers:   map[string][]byte{AckPolicyHeader: []byte("fsync")},
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
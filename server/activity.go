@@ -152,6 +152,16 @@ func (a *activityManager) handleRaftLog(l *raft.Log) error {
 	if err := log.Unmarshal(l.Data); err != nil {
 		panic(err)
 	}
+	event := activityEvent(log, l.Index)
+	if event == nil {
+		return nil
+	}
+	return a.publishActivityEvent(event)
+}
+
+// activityEvent returns the activity stream event for the Raft log operation
+// at the given index or nil if the operation has no event.
+func activityEvent(log *proto.RaftLog, index uint64) *client.ActivityStreamEvent {
 	var event *client.ActivityStreamEvent
 	switch log.Op {
 	case proto.Op_CREATE_STREAM:
@@ -202,8 +212,8 @@ func (a *activityManager) handleRaftLog(l *raft.Log) error {
 	default:
 		return nil
 	}
-	event.Id = l.Index
-	return a.publishActivityEvent(event)
+	event.Id = index
+	return event
 }
 
 // createActivityStream creates the activity stream and connects a local client
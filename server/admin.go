@@ -0,0 +1,477 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+)
+
+// brokersPath is the path of the cluster membership API on the admin HTTP
+// server. It's versioned so that tools reconciling against it, such as
+// Kubernetes operators, can rely on its responses staying compatible.
+const brokersPath = "/v1/brokers"
+
+// Suffrages reported for brokers by the admin API. Brokers which respond to
+// the cluster survey but are not in the metadata Raft group, e.g. because
+// they were removed but are still running, have no suffrage.
+const (
+	suffrageVoter    = "voter"
+	suffrageNonvoter = "nonvoter"
+	suffrageStaging  = "staging"
+	suffrageNone     = "none"
+)
+
+// brokerInfo describes a broker in the cluster as reported by the admin API.
+type brokerInfo struct {
+	ID              string `json:"id"`
+	Host            string `json:"host,omitempty"`
+	Port            int32  `json:"port,omitempty"`
+	Version         string `json:"version,omitempty"`
+	Rack            string `json:"rack,omitempty"`
+	AdminAddress    string `json:"adminAddress,omitempty"`
+	Suffrage        string `json:"suffrage"`
+	MetadataLeader  bool   `json:"metadataLeader"`
+	Partitions      int    `json:"partitions"`
+	Leaders         int    `json:"leaders"`
+	Reachable       bool   `json:"reachable"`
+	Serving         bool   `json:"serving"`
+	Liveness        string `json:"liveness,omitempty"`
+	MetadataVersion uint32 `json:"metadataVersion"`
+}
+
+// brokersResponse is the response to listing the brokers in the cluster.
+type brokersResponse struct {
+	MetadataLeader string        `json:"metadataLeader"`
+	Brokers        []*brokerInfo `json:"brokers"`
+
+	// MetadataVersion is the highest metadata version supported by every
+	// member, omitted if a member couldn't be reached.
+	MetadataVersion *uint32 `json:"metadataVersion,omitempty"`
+}
+
+// membershipResponse is the response to adding or removing a broker.
+type membershipResponse struct {
+	ID       string `json:"id"`
+	Suffrage string `json:"suffrage"`
+}
+
+// adminErrorResponse is the response to a failed admin API request.
+// MetadataLeader is set when the request must be sent to the metadata leader.
+type adminErrorResponse struct {
+	Error          string `json:"error"`
+	MetadataLeader string `json:"metadataLeader,omitempty"`
+}
+
+// startAdminServer starts the admin HTTP server, which exposes the cluster
+// membership API, the data directory API and the drain endpoint, if an
+// address for it is configured. It serves HTTPS if a certificate is
+// configured and requires requests to be authenticated with a client
+// certificate or bearer token if either is configured.
+func (s *Server) startAdminServer() error {
+	if s.config.AdminListen == "" {
+		return nil
+	}
+	tlsConfig, err := s.adminTLSConfig()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", s.config.AdminListen)
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+		scheme = "https"
+	}
+	if s.config.AdminTLSClientCA == "" && s.config.AdminToken == "" &&
+		!isLoopbackAddress(s.config.AdminListen) {
+		s.logger.Warnf("Admin server listens on %s without authentication, set %s or %s "+
+			"or listen on a loopback address", s.config.AdminListen, configAdminTLSClientCA,
+			configAdminToken)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(drainPath, s.handleDrain)
+	mux.HandleFunc(brokersPath, s.handleBrokers)
+	mux.HandleFunc(brokersPath+"/", s.handleBroker)
+	mux.HandleFunc(dataDirsPath, s.handleDataDirs)
+	mux.HandleFunc(dataDirsRebuildPath, s.handleRebuildDataDirs)
+	mux.HandleFunc(canaryPath, s.handleCanary)
+	mux.HandleFunc(tieredPath, s.handleTiered)
+	mux.HandleFunc(clientsPath, s.handleClients)
+	mux.HandleFunc(clientsPath+"/", s.handleClient)
+	mux.HandleFunc(streamsPath+"/", s.handleStream)
+	s.adminServer = &http.Server{Handler: s.authenticateAdmin(mux)}
+	s.logger.Infof("Admin server listening on %s://%s", scheme, listener.Addr())
+	s.startGoroutine(func() {
+		if err := s.adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Errorf("Admin server failed: %v", err)
+		}
+	})
+	return nil
+}
+
+// adminTLSConfig returns the TLS configuration of the admin server, or nil if
+// no certificate is configured. Clients must present a certificate signed by
+// admin.tls.client.ca if it's set.
+func (s *Server) adminTLSConfig() (*tls.Config, error) {
+	if s.config.AdminTLSCert == "" && s.config.AdminTLSKey == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.config.AdminTLSCert, s.config.AdminTLSKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load admin TLS key pair")
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if s.config.AdminTLSClientCA != "" {
+		ca, err := ioutil.ReadFile(s.config.AdminTLSClientCA)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load admin TLS client ca certificate")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("admin TLS client ca %s contains no certificates",
+				s.config.AdminTLSClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// authenticateAdmin wraps the given admin API handler so that requests must
+// set the configured admin token as a bearer token in their Authorization
+// header. The handler is returned unchanged if no token is configured.
+func (s *Server) authenticateAdmin(handler http.Handler) http.Handler {
+	if s.config.AdminToken == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + s.config.AdminToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(actual, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="liftbridge"`)
+			writeAdminError(w, http.StatusUnauthorized, "Unauthorized", "")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// adminAddress returns the address other servers and operators can reach the
+// admin HTTP server on, or an empty string if it's disabled. If the admin
+// server listens on all interfaces, the advertised host is used instead.
+func (s *Server) adminAddress() string {
+	if s.config.AdminListen == "" {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(s.config.AdminListen)
+	if err != nil {
+		return s.config.AdminListen
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = s.getConnectionAddress().Host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// isServing indicates if the server is running and not draining.
+func (s *Server) isServing() bool {
+	if !s.IsRunning() {
+		return false
+	}
+	select {
+	case <-s.drainCh:
+		return false
+	default:
+		return true
+	}
+}
+
+// handleBrokers lists the brokers in the cluster.
+func (s *Server) handleBrokers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	resp, err := s.listBrokers(r.Context())
+	if err != nil {
+		writeAdminError(w, http.StatusServiceUnavailable, err.Error(), "")
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, resp)
+}
+
+// handleBroker describes, adds or removes the broker named by the request
+// path. Adding and removing brokers are idempotent so that they can be
+// retried and used to reconcile the cluster against a desired set of brokers.
+func (s *Server) handleBroker(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, brokersPath+"/")
+	if id == "" || strings.Contains(id, "/") {
+		writeAdminError(w, http.StatusNotFound, "Not found", "")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		resp, err := s.listBrokers(r.Context())
+		if err != nil {
+			writeAdminError(w, http.StatusServiceUnavailable, err.Error(), "")
+			return
+		}
+		for _, broker := range resp.Brokers {
+			if broker.ID == id {
+				writeAdminResponse(w, http.StatusOK, broker)
+				return
+			}
+		}
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("Unknown broker %s", id), "")
+	case http.MethodPut:
+		s.addBroker(w, id)
+	case http.MethodDelete:
+		force := false
+		if param := r.URL.Query().Get("force"); param != "" {
+			parsed, err := strconv.ParseBool(param)
+			if err != nil {
+				writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("Invalid force %q", param), "")
+				return
+			}
+			force = parsed
+		}
+		s.removeBroker(w, id, force)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+	}
+}
+
+// listBrokers returns the members of the metadata Raft group along with the
+// information they report about themselves and their partition counts.
+// Members which don't respond to the survey are marked unreachable, which
+// delays the response until the survey times out.
+func (s *Server) listBrokers(ctx context.Context) (*brokersResponse, error) {
+	node := s.getRaft()
+	if node == nil {
+		return nil, raft.ErrRaftShutdown
+	}
+	future := node.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	var (
+		members    = future.Configuration().Servers
+		leader     = string(node.Leader())
+		partitions = s.metadata.BrokerPartitionCounts()
+		leaders    = s.metadata.BrokerLeaderCounts()
+		brokers    = make(map[string]*brokerInfo, len(members))
+		numPeers   = 0
+	)
+	for _, member := range members {
+		id := string(member.ID)
+		brokers[id] = &brokerInfo{ID: id, Suffrage: suffrageString(member.Suffrage)}
+		if id != s.config.Clustering.ServerID {
+			numPeers++
+		}
+	}
+
+	servers, st := s.metadata.surveyServers(ctx, numPeers)
+	if st != nil {
+		return nil, st.Err()
+	}
+	for _, server := range servers {
+		broker, ok := brokers[server.Id]
+		if !ok {
+			broker = &brokerInfo{ID: server.Id, Suffrage: suffrageNone}
+			brokers[server.Id] = broker
+		}
+		broker.Host = server.Host
+		broker.Port = server.Port
+		broker.Version = server.Version
+		broker.Rack = server.Rack
+		broker.AdminAddress = server.AdminAddress
+		broker.Reachable = true
+		broker.Serving = server.Serving
+		broker.MetadataVersion = server.MetadataVersion
+	}
+
+	if s.gossip != nil {
+		statuses := s.gossip.memberStatuses()
+		for id, broker := range brokers {
+			if id == s.config.Clustering.ServerID {
+				broker.Liveness = livenessAlive
+				continue
+			}
+			status, ok := statuses[id]
+			if !ok {
+				continue
+			}
+			broker.Liveness = status.Liveness
+			// Gossip knows the address of brokers unreachable over NATS.
+			if !broker.Reachable {
+				broker.Host = status.Host
+				broker.Port = status.Port
+			}
+		}
+	}
+
+	resp := &brokersResponse{
+		MetadataLeader: leader,
+		Brokers:        make([]*brokerInfo, 0, len(brokers)),
+	}
+	memberIDs := make([]string, len(members))
+	for i, member := range members {
+		memberIDs[i] = string(member.ID)
+	}
+	if version, err := negotiateMetadataVersion(memberIDs, servers); err == nil {
+		resp.MetadataVersion = &version
+	}
+	for id, broker := range brokers {
+		broker.MetadataLeader = id == leader
+		broker.Partitions = partitions[id]
+		broker.Leaders = leaders[id]
+		resp.Brokers = append(resp.Brokers, broker)
+	}
+	sort.Slice(resp.Brokers, func(i, j int) bool {
+		return resp.Brokers[i].ID < resp.Brokers[j].ID
+	})
+	return resp, nil
+}
+
+// addBroker adds the broker with the given ID to the metadata Raft group, as
+// a voter or non-voter depending on clustering.raft.max.quorum.size, ahead of
+// it starting. It does nothing if the broker is already a member. This must
+// be done on the metadata leader.
+func (s *Server) addBroker(w http.ResponseWriter, id string) {
+	node, member, ok := s.getMembershipForIntent(w, id)
+	if !ok {
+		return
+	}
+	if member != nil {
+		writeAdminResponse(w, http.StatusOK, &membershipResponse{
+			ID:       id,
+			Suffrage: suffrageString(member.Suffrage),
+		})
+		return
+	}
+	// NATS transport uses ID for addr.
+	if err := s.addRaftServer(node.Raft, id, id); err != nil {
+		writeAdminError(w, http.StatusServiceUnavailable, err.Error(), "")
+		return
+	}
+	s.logger.Infof("Added server %s to metadata Raft group", id)
+	_, member, ok = s.getMembershipForIntent(w, id)
+	if !ok {
+		return
+	}
+	suffrage := suffrageNone
+	if member != nil {
+		suffrage = suffrageString(member.Suffrage)
+	}
+	writeAdminResponse(w, http.StatusCreated, &membershipResponse{ID: id, Suffrage: suffrage})
+}
+
+// removeBroker removes the broker with the given ID from the metadata Raft
+// group. It does nothing if the broker is not a member. Partitions are not
+// reassigned, so brokers still hosting partitions are only removed if forced.
+// This must be done on the metadata leader.
+func (s *Server) removeBroker(w http.ResponseWriter, id string, force bool) {
+	node, member, ok := s.getMembershipForIntent(w, id)
+	if !ok {
+		return
+	}
+	if member == nil {
+		writeAdminResponse(w, http.StatusOK, &membershipResponse{ID: id, Suffrage: suffrageNone})
+		return
+	}
+	if count := s.metadata.BrokerPartitionCounts()[id]; count > 0 && !force {
+		writeAdminError(w, http.StatusConflict,
+			fmt.Sprintf("Broker %s hosts %d partitions, set force=true to remove it anyway", id, count), "")
+		return
+	}
+	future := node.GetConfiguration()
+	if err := future.Error(); err != nil {
+		writeAdminError(w, http.StatusServiceUnavailable, err.Error(), "")
+		return
+	}
+	if len(future.Configuration().Servers) == 1 {
+		writeAdminError(w, http.StatusConflict, "Cannot remove the only broker", "")
+		return
+	}
+	if err := node.RemoveServer(raft.ServerID(id), 0, 0).Error(); err != nil {
+		writeAdminError(w, http.StatusServiceUnavailable, err.Error(), "")
+		return
+	}
+	s.logger.Infof("Removed server %s from metadata Raft group", id)
+	writeAdminResponse(w, http.StatusOK, &membershipResponse{ID: id, Suffrage: suffrageNone})
+}
+
+// getMembershipForIntent returns the Raft node and the metadata Raft group
+// member with the given ID, which is nil if there is none, for changing the
+// cluster membership. If this server is not the metadata leader, it responds
+// with the leader's ID so that the request can be retried there and returns
+// false.
+func (s *Server) getMembershipForIntent(w http.ResponseWriter, id string) (*raftNode, *raft.Server, bool) {
+	node := s.getRaft()
+	if node == nil {
+		writeAdminError(w, http.StatusServiceUnavailable, raft.ErrRaftShutdown.Error(), "")
+		return nil, nil, false
+	}
+	if !node.isLeader() {
+		writeAdminError(w, http.StatusConflict, "Server is not the metadata leader", string(node.Leader()))
+		return nil, nil, false
+	}
+	future := node.GetConfiguration()
+	if err := future.Error(); err != nil {
+		writeAdminError(w, http.StatusServiceUnavailable, err.Error(), "")
+		return nil, nil, false
+	}
+	for _, member := range future.Configuration().Servers {
+		if string(member.ID) == id {
+			return node, &member, true
+		}
+	}
+	return node, nil, true
+}
+
+// suffrageString returns the admin API name of the given Raft suffrage.
+func suffrageString(suffrage raft.ServerSuffrage) string {
+	switch suffrage {
+	case raft.Voter:
+		return suffrageVoter
+	case raft.Nonvoter:
+		return suffrageNonvoter
+	case raft.Staging:
+		return suffrageStaging
+	default:
+		return suffrageNone
+	}
+}
+
+// writeAdminResponse writes the given response as JSON with the given status
+// code.
+func writeAdminResponse(w http.ResponseWriter, code int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeAdminError writes an error response as JSON with the given status
+// code.
+func writeAdminError(w http.ResponseWriter, code int, msg, metadataLeader string) {
+	writeAdminResponse(w, code, &adminErrorResponse{Error: msg, MetadataLeader: metadataLeader})
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	natsdTest "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// adminRequest sends a request to the given server's admin API and decodes
+// the JSON response into resp if it's not nil.
+func adminRequest(t *testing.T, s *Server, method, path string, resp interface{}) int {
+	req, err := http.NewRequest(method, "http://"+s.config.AdminListen+path, nil)
+	require.NoError(t, err)
+	r, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer r.Body.Close()
+	require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	if resp != nil {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(resp))
+	}
+	return r.StatusCode
+}
+
+// Ensure the admin API lists the brokers in the cluster and adds and removes
+// brokers idempotently on the metadata leader.
+func TestAdminBrokersAPI(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Use an external NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	var servers []*Server
+	for i, id := range []string{"a", "b", "c"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		config.AdminListen = fmt.Sprintf("localhost:%d", 9390+i)
+		config.Clustering.Rack = "rack-" + id
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	leader := getMetadataLeader(t, 10*time.Second, servers...)
+	var follower *Server
+	for _, s := range servers {
+		if s != leader {
+			follower = s
+			break
+		}
+	}
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.NewAPIClient(conn).CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	waitForISR(t, 10*time.Second, "foo", 0, 3, servers...)
+
+	// Any server lists the brokers.
+	list := &brokersResponse{}
+	require.Equal(t, http.StatusOK, adminRequest(t, follower, http.MethodGet, brokersPath, list))
+	require.Equal(t, leader.config.Clustering.ServerID, list.MetadataLeader)
+	require.Len(t, list.Brokers, 3)
+	require.NotNil(t, list.MetadataVersion)
+	require.Equal(t, uint32(metadataVersion), *list.MetadataVersion)
+	leaders := 0
+	for i, broker := range list.Brokers {
+		s := servers[i]
+		require.Equal(t, s.config.Clustering.ServerID, broker.ID)
+		require.Equal(t, int32(s.config.Port), broker.Port)
+		require.Equal(t, Version, broker.Version)
+		require.Equal(t, uint32(metadataVersion), broker.MetadataVersion)
+		require.Equal(t, "rack-"+broker.ID, broker.Rack)
+		require.Equal(t, s.config.AdminListen, broker.AdminAddress)
+		require.Equal(t, suffrageVoter, broker.Suffrage)
+		require.Equal(t, s == leader, broker.MetadataLeader)
+		require.Equal(t, 1, broker.Partitions)
+		require.True(t, broker.Reachable)
+		require.True(t, broker.Serving)
+		leaders += broker.Leaders
+	}
+	require.Equal(t, 1, leaders)
+
+	broker := &brokerInfo{}
+	require.Equal(t, http.StatusOK, adminRequest(t, follower, http.MethodGet, brokersPath+"/b", broker))
+	require.Equal(t, "b", broker.ID)
+	require.Equal(t, http.StatusNotFound, adminRequest(t, follower, http.MethodGet, brokersPath+"/z", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, adminRequest(t, follower, http.MethodPost, brokersPath, nil))
+
+	// Membership changes must be made on the metadata leader.
+	errResp := &adminErrorResponse{}
+	require.Equal(t, http.StatusConflict, adminRequest(t, follower, http.MethodPut, brokersPath+"/d", errResp))
+	require.Equal(t, leader.config.Clustering.ServerID, errResp.MetadataLeader)
+
+	// Add a broker ahead of it starting.
+	membership := &membershipResponse{}
+	require.Equal(t, http.StatusCreated, adminRequest(t, leader, http.MethodPut, brokersPath+"/d", membership))
+	require.Equal(t, &membershipResponse{ID: "d", Suffrage: suffrageVoter}, membership)
+	require.Equal(t, http.StatusOK, adminRequest(t, leader, http.MethodPut, brokersPath+"/d", membership))
+	require.Equal(t, &membershipResponse{ID: "d", Suffrage: suffrageVoter}, membership)
+	future := leader.getRaft().GetConfiguration()
+	require.NoError(t, future.Error())
+	require.Len(t, future.Configuration().Servers, 4)
+
+	// Remove it again.
+	require.Equal(t, http.StatusOK, adminRequest(t, leader, http.MethodDelete, brokersPath+"/d", membership))
+	require.Equal(t, &membershipResponse{ID: "d", Suffrage: suffrageNone}, membership)
+	require.Equal(t, http.StatusOK, adminRequest(t, leader, http.MethodDelete, brokersPath+"/d", membership))
+	require.Equal(t, &membershipResponse{ID: "d", Suffrage: suffrageNone}, membership)
+
+	// Brokers hosting partitions are only removed if forced.
+	require.Equal(t, http.StatusConflict, adminRequest(t, leader, http.MethodDelete, brokersPath+"/"+follower.config.Clustering.ServerID, nil))
+	require.Equal(t, http.StatusBadRequest, adminRequest(t, leader, http.MethodDelete, brokersPath+"/"+follower.config.Clustering.ServerID+"?force=yes", nil))
+	require.Equal(t, http.StatusOK, adminRequest(t, leader, http.MethodDelete, brokersPath+"/"+follower.config.Clustering.ServerID+"?force=true", membership))
+	future = leader.getRaft().GetConfiguration()
+	require.NoError(t, future.Error())
+	require.Len(t, future.Configuration().Servers, 2)
+	for _, member := range future.Configuration().Servers {
+		require.NotEqual(t, raft.ServerID(follower.config.Clustering.ServerID), member.ID)
+	}
+}
+
+// Ensure the admin server requires a client certificate signed by the
+// configured CA and the configured bearer token.
+func TestAdminAuthentication(t *testing.T) {
+	defer cleanupStorage(t)
+
+	dir, err := ioutil.TempDir("", "liftbridge-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "liftbridge")
+	other := newTestCA(t, dir, "other")
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	config.AdminTLSCert, config.AdminTLSKey = ca.issue(t, "admin")
+	config.AdminTLSClientCA = ca.caFile
+	config.AdminToken = "secret"
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	request := func(tlsConfig *tls.Config, token string) (int, error) {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		req, err := http.NewRequest(http.MethodGet, "https://localhost:9390"+brokersPath, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	// Clients must present a certificate signed by the client CA.
+	noCert := ca.tlsConfig(t, "client")
+	noCert.Certificates = nil
+	_, err = request(noCert, "secret")
+	require.Error(t, err)
+	otherCert := other.tlsConfig(t, "client")
+	otherCert.RootCAs = noCert.RootCAs
+	_, err = request(otherCert, "secret")
+	require.Error(t, err)
+
+	// And the token.
+	clientTLS := ca.tlsConfig(t, "client")
+	code, err := request(clientTLS, "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, code)
+	code, err = request(clientTLS, "wrong")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, code)
+	code, err = request(clientTLS, "secret")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, code)
+}
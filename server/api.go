@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"hash/crc32"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	client "github.com/liftbridge-io/liftbridge-api/go"
@@ -20,6 +24,65 @@ import (
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
 
+// CompactKeepVersionsMetadata is the CreateStream request metadata key used to
+// set the number of messages compaction retains for each key on the stream.
+const CompactKeepVersionsMetadata = "liftbridge-compact-keep-versions"
+
+// RetentionMaxKeysMetadata is the CreateStream request metadata key used to
+// set the maximum number of distinct keys compaction retains on the stream.
+const RetentionMaxKeysMetadata = "liftbridge-retention-max-keys"
+
+// FlushMessagesMetadata is the CreateStream request metadata key used to set
+// the number of messages appended to the stream's partitions after which
+// their active segments are fsynced.
+const FlushMessagesMetadata = "liftbridge-flush-messages"
+
+// FlushMsMetadata is the CreateStream request metadata key used to set the
+// number of milliseconds after which the active segments of the stream's
+// partitions are fsynced if messages were appended.
+const FlushMsMetadata = "liftbridge-flush-ms"
+
+// TieredStorageEnabledMetadata is the CreateStream request metadata key used
+// to enable or disable offloading the sealed segments of the stream's
+// partitions to tiered storage.
+const TieredStorageEnabledMetadata = "liftbridge-tiered-storage-enabled"
+
+// TieredStorageBucketMetadata is the CreateStream request metadata key used to
+// set the tiered storage bucket the stream's segments are offloaded to. It
+// must be the server's default bucket or one of its allowed buckets.
+const TieredStorageBucketMetadata = "liftbridge-tiered-storage-bucket"
+
+// TieredStoragePrefixMetadata is the CreateStream request metadata key used to
+// set the prefix of the keys the stream's segments are offloaded under.
+const TieredStoragePrefixMetadata = "liftbridge-tiered-storage-prefix"
+
+// SegmentCompressionMetadata is the CreateStream request metadata key used to
+// set the codec the sealed segments of the stream's partitions are compressed
+// with, "none", "zstd", or "lz4".
+const SegmentCompressionMetadata = "liftbridge-segment-compression"
+
+// DedupWindowMetadata is the CreateStream request metadata key used to set how
+// long the leaders of the stream's partitions remember message IDs to drop
+// duplicates, e.g. "10m". A window of 0 disables deduplication.
+const DedupWindowMetadata = "liftbridge-dedup-window"
+
+// AliasForMetadata is the CreateStream request metadata key used to add the
+// request's stream name as an alias of the existing stream with the given name
+// instead of creating a new stream. On a DeleteStream request, it removes the
+// alias from the stream instead of deleting a stream.
+const AliasForMetadata = "liftbridge-alias-for"
+
+// RenameFromMetadata is the CreateStream request metadata key used to rename
+// the existing stream with the given name to the request's stream name instead
+// of creating a new stream.
+const RenameFromMetadata = "liftbridge-rename-from"
+
+// RenameReplaceMetadata is the CreateStream request metadata key which, when
+// set to true along with RenameFromMetadata, replaces the stream the new name
+// refers to. If the name is an alias, it's moved to the renamed stream.
+// Otherwise, that stream is deleted as part of the rename.
+const RenameReplaceMetadata = "liftbridge-rename-replace"
+
 const (
 	waitForNewMessages int64 = -1
 	asyncAckTimeout          = 5 * time.Second
@@ -52,11 +115,46 @@ func (a *apiServer) CreateStream(ctx context.Context, req *client.CreateStreamRe
 		a.logger.Errorf("api: Failed to create stream: name cannot be empty")
 		return nil, status.Error(codes.InvalidArgument, "Name cannot be empty")
 	}
+	if aliasFor := aliasForMetadata(ctx); aliasFor != "" {
+		return a.createStreamAlias(ctx, req.Name, aliasFor)
+	}
+	if renameFrom, replace, st := renameMetadata(ctx); st != nil {
+		a.logger.Errorf("api: Failed to create stream: %v", st.Message())
+		return nil, st.Err()
+	} else if renameFrom != "" {
+		return a.renameStream(ctx, renameFrom, req.Name, replace)
+	}
 	if req.Subject == "" || !isValidSubject(req.Subject) {
 		a.logger.Errorf("api: Failed to create stream: subject is invalid")
 		return nil, status.Error(codes.InvalidArgument, "Subject is invalid")
 	}
 
+	config := getStreamConfig(req)
+	if st := applyStreamConfigMetadata(ctx, config); st != nil {
+		a.logger.Errorf("api: Failed to create stream: %v", st.Message())
+		return nil, st.Err()
+	}
+	if st := a.checkTieredStorage(config); st != nil {
+		a.logger.Errorf("api: Failed to create stream %s: %v", req.Name, st.Message())
+		return nil, st.Err()
+	}
+	if st := a.checkSegmentCompression(config); st != nil {
+		a.logger.Errorf("api: Failed to create stream %s: %v", req.Name, st.Message())
+		return nil, st.Err()
+	}
+	if st := a.ensureSampleSource(config); st != nil {
+		a.logger.Errorf("api: Failed to create stream %s: %v", req.Name, st.Message())
+		return nil, st.Err()
+	}
+	if st := a.ensureDeriveSource(config); st != nil {
+		a.logger.Errorf("api: Failed to create stream %s: %v", req.Name, st.Message())
+		return nil, st.Err()
+	}
+	if st := a.ensureSnapshotSource(config); st != nil {
+		a.logger.Errorf("api: Failed to create stream %s: %v", req.Name, st.Message())
+		return nil, st.Err()
+	}
+
 	partitions := make([]*proto.Partition, req.Partitions)
 	for i := int32(0); i < req.Partitions; i++ {
 		partitions[i] = &proto.Partition{
@@ -72,7 +170,7 @@ func (a *apiServer) CreateStream(ctx context.Context, req *client.CreateStreamRe
 		Name:       req.Name,
 		Subject:    req.Subject,
 		Partitions: partitions,
-		Config:     getStreamConfig(req),
+		Config:     config,
 	}
 
 	err := a.ensureCreateStreamPrecondition(req)
@@ -99,6 +197,28 @@ func (a *apiServer) DeleteStream(ctx context.Context, req *client.DeleteStreamRe
 	a.logger.Debugf("api: DeleteStream [name=%s]",
 		req.Name)
 
+	// Deleting an alias only removes the alias, not the stream it refers to.
+	// If the request names the stream the alias is for, it fails rather than
+	// deleting a stream when the name is not an alias.
+	aliasFor := aliasForMetadata(ctx)
+	if aliasFor == "" {
+		stream := a.metadata.GetStream(req.Name)
+		if stream != nil && stream.GetName() != req.Name && stream.GetDisplayName() != req.Name {
+			aliasFor = stream.GetName()
+		}
+	}
+	if aliasFor != "" {
+		if e := a.metadata.SetStreamAlias(ctx, &proto.SetStreamAliasOp{
+			Stream: aliasFor,
+			Alias:  req.Name,
+			Remove: true,
+		}); e != nil {
+			a.logger.Errorf("api: Failed to remove stream alias %v: %v", req.Name, e.Err())
+			return nil, e.Err()
+		}
+		return resp, nil
+	}
+
 	if e := a.metadata.DeleteStream(ctx, &proto.DeleteStreamOp{
 		Stream: req.Name,
 	}); e != nil {
@@ -109,6 +229,47 @@ func (a *apiServer) DeleteStream(ctx context.Context, req *client.DeleteStreamRe
 	return resp, nil
 }
 
+// createStreamAlias adds an alias for an existing stream. Publishers and
+// subscribers can then reference the stream by either its name or the alias.
+func (a *apiServer) createStreamAlias(ctx context.Context, alias, streamName string) (
+	*client.CreateStreamResponse, error) {
+
+	a.logger.Debugf("api: CreateStream alias [name=%s, stream=%s]", alias, streamName)
+
+	if e := a.metadata.SetStreamAlias(ctx, &proto.SetStreamAliasOp{
+		Stream: streamName,
+		Alias:  alias,
+	}); e != nil {
+		if e.Code() != codes.AlreadyExists {
+			a.logger.Errorf("api: Failed to add alias %s to stream %s: %v", alias, streamName, e.Err())
+		}
+		return nil, e.Err()
+	}
+
+	return &client.CreateStreamResponse{}, nil
+}
+
+// renameStream renames an existing stream. The stream's previous name remains
+// an alias, so clients can keep using it until they move to the new name.
+func (a *apiServer) renameStream(ctx context.Context, streamName, name string, replace bool) (
+	*client.CreateStreamResponse, error) {
+
+	a.logger.Debugf("api: CreateStream rename [name=%s, stream=%s, replace=%v]", name, streamName, replace)
+
+	if e := a.metadata.RenameStream(ctx, &proto.RenameStreamOp{
+		Stream:  streamName,
+		Name:    name,
+		Replace: replace,
+	}); e != nil {
+		if e.Code() != codes.AlreadyExists {
+			a.logger.Errorf("api: Failed to rename stream %s to %s: %v", streamName, name, e.Err())
+		}
+		return nil, e.Err()
+	}
+
+	return &client.CreateStreamResponse{}, nil
+}
+
 // PauseStream pauses a stream's partitions. If no partitions are specified,
 // all of the stream's partitions will be paused. Partitions are resumed when
 // they are published to via the Liftbridge Publish API.
@@ -122,7 +283,7 @@ func (a *apiServer) PauseStream(ctx context.Context, req *client.PauseStreamRequ
 	if len(req.Partitions) == 0 {
 		stream := a.metadata.GetStream(req.Name)
 		if stream == nil {
-			return nil, status.Error(codes.NotFound, "stream not found")
+			return nil, streamNotFoundStatus(codes.NotFound, "stream not found", req.Name).Err()
 		}
 		for _, partition := range stream.GetPartitions() {
 			req.Partitions = append(req.Partitions, partition.Id)
@@ -154,7 +315,7 @@ func (a *apiServer) SetStreamReadonly(ctx context.Context, req *client.SetStream
 	if len(req.Partitions) == 0 {
 		stream := a.metadata.GetStream(req.Name)
 		if stream == nil {
-			return nil, status.Error(codes.NotFound, "stream not found")
+			return nil, streamNotFoundStatus(codes.NotFound, "stream not found", req.Name).Err()
 		}
 		for _, partition := range stream.GetPartitions() {
 			req.Partitions = append(req.Partitions, partition.Id)
@@ -176,13 +337,63 @@ func (a *apiServer) SetStreamReadonly(ctx context.Context, req *client.SetStream
 // Subscribe creates an ephemeral subscription for the given stream partition.
 // It begins to receive messages starting at the given offset and waits for new
 // messages when it reaches the end of the partition. Use the request context
-// to close the subscription.
+// to close the subscription. Requests with WatchMetadataMetadata set receive
+// metadata changes instead.
 func (a *apiServer) Subscribe(req *client.SubscribeRequest, out client.API_SubscribeServer) error {
-	msgC, errC, cancel, err := a.SubscribeInternal(out.Context(), req)
+	watch, version, st := watchMetadataFromContext(out.Context())
+	if st != nil {
+		return st.Err()
+	}
+	if watch {
+		return a.watchMetadata(req, out, version)
+	}
+	opts, st := a.subscriptionOptionsFromContext(out.Context(), req)
+	if st != nil {
+		return st.Err()
+	}
+	// Count subscriptions through aliases against the stream they refer to.
+	release, limit := a.subscriptionLimits.acquire(clientFromContext(out.Context()),
+		a.streamName(req.Stream))
+	if limit != "" {
+		a.logger.Debugf("api: Rejected subscription to [stream=%s, partition=%d], %s limit reached",
+			req.Stream, req.Partition, limit)
+		out.SetTrailer(limitExceededMetadata(limit))
+		return limitExceededStatus(limit).Err()
+	}
+	defer release()
+	msgC, errC, cancel, err := a.subscribeWithOptions(out.Context(), req, opts)
 	if err != nil {
 		return err
 	}
 	defer cancel()
+	defer clientFromContext(out.Context()).addSubscription(req.Stream, req.Partition,
+		func() int { return len(msgC) })()
+
+	// Messages shared with other subscriptions through the partition's
+	// delivery cache are sent as frames, which are only serialized once.
+	var (
+		cache     *deliveryCache
+		committer *autoCommitter
+	)
+	if partition := a.metadata.GetPartition(req.Stream, req.Partition); partition != nil {
+		cache = partition.deliveryCache
+		// Flow-controlled subscriptions only receive messages they have
+		// credit for. Waiting for credit leaves messages in msgC, which
+		// holds back reading more from the log.
+		if opts.credit != nil {
+			if st := partition.addCredit(opts.credit); st != nil {
+				return st.Err()
+			}
+			defer partition.removeCredit(opts.credit)
+		}
+		if opts.autoCommitCursor != "" {
+			if committer, st = partition.addAutoCommit(out.Context(), a.cursors,
+				opts.autoCommitCursor, opts.atLeastOnce); st != nil {
+				return st.Err()
+			}
+			defer partition.removeAutoCommit(committer)
+		}
+	}
 
 	// Send an empty message which signals the subscription was successfully
 	// created.
@@ -194,12 +405,46 @@ func (a *apiServer) Subscribe(req *client.SubscribeRequest, out client.API_Subsc
 		select {
 		case <-out.Context().Done():
 			return nil
+		case <-a.drainCh:
+			// Notify the client and end the subscription so it resubscribes
+			// to another server. Clients which predate the notification only
+			// get the trailer.
+			if clientAPIVersion(out.Context()) >= apiVersionDrainNotifications {
+				if err := out.Send(a.drainingNotification(req.Stream, req.Partition)); err != nil {
+					return err
+				}
+			}
+			out.SetTrailer(a.drainingMetadata(req.Stream, req.Partition))
+			return errServerDraining()
 		case m := <-msgC:
-			if err := out.Send(m); err != nil {
+			if !opts.credit.acquire(m, out.Context().Done()) {
+				return nil
+			}
+			if st := committer.delivered(out.Context(), m.Offset); st != nil {
+				return st.Err()
+			}
+			if err := out.SendMsg(cache.frame(m)); err != nil {
 				return err
 			}
 		case err := <-errC:
-			return err.Err()
+			// Send the messages buffered before the error first.
+			for {
+				select {
+				case m := <-msgC:
+					if !opts.credit.acquire(m, out.Context().Done()) {
+						return nil
+					}
+					if st := committer.delivered(out.Context(), m.Offset); st != nil {
+						return st.Err()
+					}
+					if err := out.SendMsg(cache.frame(m)); err != nil {
+						return err
+					}
+					continue
+				default:
+				}
+				return err.Err()
+			}
 		}
 	}
 }
@@ -207,10 +452,24 @@ func (a *apiServer) Subscribe(req *client.SubscribeRequest, out client.API_Subsc
 // Subscribe creates an ephemeral subscription for the given stream partition.
 // It begins to receive messages starting at the given offset and waits for new
 // messages when it reaches the end of the partition. Use the request context
-// to close the subscription. This is a non-gRPC API for internal use.
+// to close the subscription. Messages are buffered, so messages still in the
+// message channel when an error is received precede the error. This is a
+// non-gRPC API for internal use.
 func (a *apiServer) SubscribeInternal(ctx context.Context, req *client.SubscribeRequest) (
 	<-chan *client.Message, <-chan *status.Status, func(), error) {
 
+	opts, st := a.subscriptionOptionsFromContext(ctx, req)
+	if st != nil {
+		return nil, nil, nil, st.Err()
+	}
+	return a.subscribeWithOptions(ctx, req, opts)
+}
+
+// subscribeWithOptions creates a subscription for the given stream partition
+// with options already parsed from the request metadata.
+func (a *apiServer) subscribeWithOptions(ctx context.Context, req *client.SubscribeRequest,
+	opts *subscriptionOptions) (<-chan *client.Message, <-chan *status.Status, func(), error) {
+
 	a.logger.Debugf("api: Subscribe [stream=%s, partition=%d, start=%s, offset=%d, timestamp=%d]",
 		req.Stream, req.Partition, req.StartPosition, req.StartOffset, req.StartTimestamp)
 
@@ -219,7 +478,8 @@ func (a *apiServer) SubscribeInternal(ctx context.Context, req *client.Subscribe
 		a.logger.Errorf("api: Failed to subscribe to partition "+
 			"[stream=%s, partition=%d]: no such partition",
 			req.Stream, req.Partition)
-		return nil, nil, nil, status.Error(codes.NotFound, "No such partition")
+		return nil, nil, nil, partitionNotFoundStatus(codes.NotFound, "No such partition",
+			req.Stream, req.Partition).Err()
 	}
 
 	leader, _ := partition.GetLeader()
@@ -228,18 +488,30 @@ func (a *apiServer) SubscribeInternal(ctx context.Context, req *client.Subscribe
 			a.logger.Info("api: Accepting subscription to partition %s: server not stream leader", partition)
 		} else {
 			a.logger.Errorf("api: Failed to subscribe to partition %s: server not stream leader", partition)
-			return nil, nil, nil, status.Error(codes.FailedPrecondition, "Server not partition leader")
+			return nil, nil, nil, a.metadata.notLeaderStatus(ctx, partition, "Server not partition leader").Err()
 		}
 	}
 
 	cancel := make(chan struct{})
-	ch, errCh, err := a.subscribe(ctx, partition, req, cancel)
+	ch, errCh, err := a.subscribe(ctx, partition, req, opts, cancel)
 	if err != nil {
 		a.logger.Errorf("api: Failed to subscribe to partition %s: %v", partition, err.Err())
 		return nil, nil, nil, err.Err()
 	}
 
-	return ch, errCh, func() { close(cancel) }, nil
+	// Resuming the stream creates a new partition.
+	if req.Resume {
+		if resumed := a.metadata.GetPartition(req.Stream, req.Partition); resumed != nil {
+			partition = resumed
+		}
+	}
+
+	return ch, errCh, func() {
+		close(cancel)
+		// Detach the subscription from the partition's dispatcher now in
+		// case it's idle.
+		partition.dispatcher.cancel(cancel)
+	}, nil
 }
 
 // FetchMetadata retrieves the latest cluster metadata, including stream broker
@@ -248,6 +520,10 @@ func (a *apiServer) FetchMetadata(ctx context.Context, req *client.FetchMetadata
 	*client.FetchMetadataResponse, error) {
 	a.logger.Debugf("api: FetchMetadata %s", req.Streams)
 
+	if err := a.hotPartitions.setHotPartitionsHeader(ctx); err != nil {
+		return nil, err.Err()
+	}
+
 	resp, err := a.metadata.FetchMetadata(ctx, req)
 	if err != nil {
 		a.logger.Errorf("api: Failed to fetch metadata: %v", err.Err())
@@ -269,6 +545,9 @@ func (a *apiServer) FetchPartitionMetadata(ctx context.Context, req *client.Fetc
 		a.logger.Errorf("api: Failed to fetch partition metadata: %v", err.Err())
 		return nil, err.Err()
 	}
+	if partition := a.metadata.GetPartition(req.Stream, req.Partition); partition != nil {
+		setLogStartOffsetHeader(ctx, partition.log)
+	}
 	return resp, nil
 }
 
@@ -276,12 +555,23 @@ func (a *apiServer) FetchPartitionMetadata(ctx context.Context, req *client.Fetc
 // deadline is provided, this will synchronously block until the ack is
 // received. If the ack is not received in time, a DeadlineExceeded status code
 // is returned. A FailedPrecondition status code is returned if the partition is
-// readonly.
+// readonly. If the request metadata includes CursorCommitMetadata, a cursor is
+// committed with the published messages.
 func (a *apiServer) Publish(ctx context.Context, req *client.PublishRequest) (
 	*client.PublishResponse, error) {
 
 	// TODO: Deprecate in favor of PublishAsync and log a warning.
 	a.logger.Debugf("api: Publish [stream=%s, partition=%d]", req.Stream, req.Partition)
+	clientFromContext(ctx).recordPublish(len(req.Value))
+
+	if a.isDraining() {
+		grpc.SetTrailer(ctx, a.drainingMetadata(req.Stream, req.Partition)) // nolint: errcheck
+		return nil, errServerDraining()
+	}
+
+	if e := a.autoCreateStream(ctx, req.Stream); e != nil {
+		return nil, convertPublishAsyncError(e)
+	}
 
 	subject, e := a.getPublishSubject(req)
 	if e != nil {
@@ -293,6 +583,23 @@ func (a *apiServer) Publish(ctx context.Context, req *client.PublishRequest) (
 		return nil, convertPublishAsyncError(e)
 	}
 
+	commit, st := cursorCommitFromContext(ctx)
+	if st != nil {
+		return nil, st.Err()
+	}
+
+	sched, st := scheduleFromContext(ctx)
+	if st != nil {
+		return nil, st.Err()
+	}
+	if sched != nil {
+		if commit != nil {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"%s can't be used with a scheduled publish", CursorCommitMetadata)
+		}
+		return a.schedulePublish(ctx, req, sched)
+	}
+
 	if err := a.resumeStream(ctx, req.Stream, req.Partition); err != nil {
 		a.logger.Errorf("api: Failed to resume stream: %v", err)
 		return nil, err
@@ -302,22 +609,23 @@ func (a *apiServer) Publish(ctx context.Context, req *client.PublishRequest) (
 		req.AckInbox = a.getAckInbox()
 	}
 
-	var (
-		msg = &client.Message{
-			Key:           req.Key,
-			Value:         req.Value,
-			Stream:        req.Stream,
-			Subject:       subject,
-			Headers:       req.Headers,
-			AckInbox:      req.AckInbox,
-			CorrelationId: req.CorrelationId,
-			AckPolicy:     req.AckPolicy,
-			Offset:        req.ExpectedOffset,
+	batch, st := batchFromContext(ctx)
+	if st != nil {
+		return nil, st.Err()
+	}
+	if commit != nil {
+		if err := a.prepareCursorCommit(ctx, commit, req, batch); err != nil {
+			return nil, err
 		}
-		resp = new(client.PublishResponse)
-	)
+	}
+	buf, e := marshalPublishRequest(req, subject, batch)
+	if e != nil {
+		a.logger.Errorf("api: Failed to publish message: %v", e.Message)
+		return nil, convertPublishAsyncError(e)
+	}
 
-	ack, err := a.publish(ctx, subject, req.AckInbox, req.AckPolicy, msg)
+	resp := new(client.PublishResponse)
+	ack, err := a.publishEnvelope(ctx, subject, req.AckInbox, req.AckPolicy, buf)
 	if err != nil {
 		a.logger.Errorf("api: Failed to publish message: %v", err)
 		return nil, err
@@ -330,6 +638,13 @@ func (a *apiServer) Publish(ctx context.Context, req *client.PublishRequest) (
 		}
 	}
 
+	if commit != nil {
+		if err := a.finishCursorCommit(ctx, commit); err != nil {
+			a.logger.Errorf("api: Failed to commit cursor: %v", err)
+			return nil, err
+		}
+	}
+
 	resp.Ack = ack
 	return resp, nil
 }
@@ -343,9 +658,22 @@ func (a *apiServer) PublishAsync(stream client.API_PublishAsyncServer) error {
 	}
 	defer session.close()
 
-	if err := session.publishLoop(); err != nil {
-		a.logger.Errorf("api: Failed to publish async message: %v", err)
-		return err
+	done := make(chan error, 1)
+	go func() {
+		done <- session.publishLoop()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			a.logger.Errorf("api: Failed to publish async message: %v", err)
+			return err
+		}
+	case <-a.drainCh:
+		// Deliver the acks for messages already published, then end the
+		// session so the client publishes through another server.
+		session.waitForInflight()
+		stream.SetTrailer(a.drainingMetadata("", 0))
+		return errServerDraining()
 	}
 
 	return nil
@@ -358,6 +686,12 @@ func (a *apiServer) PublishAsync(stream client.API_PublishAsyncServer) error {
 func (a *apiServer) PublishToSubject(ctx context.Context, req *client.PublishToSubjectRequest) (
 	*client.PublishToSubjectResponse, error) {
 	a.logger.Debugf("api: PublishToSubject [subject=%s]", req.Subject)
+	clientFromContext(ctx).recordPublish(len(req.Value))
+
+	if a.isDraining() {
+		grpc.SetTrailer(ctx, a.drainingMetadata("", 0)) // nolint: errcheck
+		return nil, errServerDraining()
+	}
 
 	if req.AckInbox == "" {
 		req.AckInbox = a.getAckInbox()
@@ -387,7 +721,8 @@ func (a *apiServer) PublishToSubject(ctx context.Context, req *client.PublishToS
 }
 
 // SetCursor stores a cursor position for a particular stream partition which
-// is uniquely identified by an opaque string.
+// is uniquely identified by an opaque string. Requests with
+// SetCursorActionMetadata set perform that action instead.
 //
 // NOTE: This is a beta endpoint and is subject to change. It is not included
 // as part of Liftbridge's semantic versioning scheme.
@@ -403,7 +738,18 @@ func (a *apiServer) SetCursor(ctx context.Context, req *client.SetCursorRequest)
 		return nil, status.Error(codes.InvalidArgument, "No cursorId provided")
 	}
 
-	if status := a.cursors.SetCursor(ctx, req.Stream, req.CursorId, req.Partition, req.Offset); status != nil {
+	action, st := setCursorActionFromContext(ctx)
+	if st != nil {
+		return nil, st.Err()
+	}
+	if action != "" {
+		if st := a.setCursorAction(ctx, action, req); st != nil {
+			return nil, st.Err()
+		}
+		return new(client.SetCursorResponse), nil
+	}
+
+	if status := a.cursors.SetCursor(ctx, a.streamName(req.Stream), req.CursorId, req.Partition, req.Offset); status != nil {
 		return nil, status.Err()
 	}
 	return new(client.SetCursorResponse), nil
@@ -425,13 +771,22 @@ func (a *apiServer) FetchCursor(ctx context.Context, req *client.FetchCursorRequ
 		return nil, status.Error(codes.InvalidArgument, "No cursorId provided")
 	}
 
-	offset, status := a.cursors.GetCursor(ctx, req.Stream, req.CursorId, req.Partition)
+	offset, status := a.cursors.GetCursor(ctx, a.streamName(req.Stream), req.CursorId, req.Partition)
 	if status != nil {
 		return nil, status.Err()
 	}
 	return &client.FetchCursorResponse{Offset: offset}, nil
 }
 
+// streamName returns the name of the stream the given name or alias refers to.
+// If there is no such stream, the name is returned unchanged.
+func (a *apiServer) streamName(name string) string {
+	if stream := a.metadata.GetStream(name); stream != nil {
+		return stream.GetName()
+	}
+	return name
+}
+
 // isValidSubject indicates if the string is a valid NATS subject.
 func isValidSubject(subj string) bool {
 	if strings.ContainsAny(subj, " \t\r\n") {
@@ -500,13 +855,16 @@ func (a *apiServer) ensurePublishPreconditions(req *client.PublishRequest) *clie
 		}
 	}
 
-	return nil
+	if e := checkAckPolicyHeader(req); e != nil {
+		return e
+	}
+	return checkTombstone(req)
 }
 
 func (a *apiServer) resumeStream(ctx context.Context, streamName string, partitionID int32) error {
 	stream := a.metadata.GetStream(streamName)
 	if stream == nil {
-		return status.Error(codes.NotFound, fmt.Sprintf("No such stream: %s", streamName))
+		return streamNotFoundStatus(codes.NotFound, fmt.Sprintf("No such stream: %s", streamName), streamName).Err()
 	}
 	var toResume []int32
 	if stream.GetResumeAll() {
@@ -524,7 +882,8 @@ func (a *apiServer) resumeStream(ctx context.Context, streamName string, partiti
 		// paused.
 		partition := stream.GetPartition(partitionID)
 		if partition == nil {
-			return status.Error(codes.NotFound, fmt.Sprintf("No such partition: %d", partitionID))
+			return partitionNotFoundStatus(codes.NotFound, fmt.Sprintf("No such partition: %d", partitionID),
+				streamName, partitionID).Err()
 		}
 		if partition.IsPaused() {
 			toResume = []int32{partition.Id}
@@ -572,16 +931,27 @@ func (a *apiServer) getPublishSubject(req *client.PublishRequest) (string, *clie
 func (a *apiServer) publish(ctx context.Context, subject, ackInbox string,
 	ackPolicy client.AckPolicy, msg *client.Message) (*client.Ack, error) {
 
-	buf, err := proto.MarshalPublish(msg)
+	buf, err := proto.AppendPublish(getEnvelopeBuffer(), msg)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to marshal message")
 	}
+	return a.publishEnvelope(ctx, subject, ackInbox, ackPolicy, buf)
+}
+
+// publishEnvelope publishes a message serialized in the Liftbridge envelope
+// wire format to a NATS subject. If the AckPolicy is not NONE and the context
+// has a deadline, this waits for the ack. The buffer is returned to the
+// envelope pool once published since NATS copies it.
+func (a *apiServer) publishEnvelope(ctx context.Context, subject, ackInbox string,
+	ackPolicy client.AckPolicy, buf []byte) (*client.Ack, error) {
 
 	// If AckPolicy is NONE or a timeout isn't specified, then we will fire and
 	// forget.
 	_, hasDeadline := ctx.Deadline()
 	if ackPolicy == client.AckPolicy_NONE || !hasDeadline {
-		if err := a.ncPublishes.Publish(subject, buf); err != nil {
+		err := a.ncPublishes.Publish(subject, buf)
+		putEnvelopeBuffer(buf)
+		if err != nil {
 			return nil, errors.Wrap(err, "failed to publish to NATS")
 		}
 		return nil, nil
@@ -602,7 +972,9 @@ func (a *apiServer) publishSync(ctx context.Context, subject,
 		return nil, errors.Wrap(err, "failed to auto unsubscribe from ack inbox")
 	}
 
-	if err := a.ncPublishes.Publish(subject, msg); err != nil {
+	err = a.ncPublishes.Publish(subject, msg)
+	putEnvelopeBuffer(msg)
+	if err != nil {
 		return nil, errors.Wrap(err, "failed to publish to NATS")
 	}
 
@@ -621,12 +993,12 @@ func (a *apiServer) publishSync(ctx context.Context, subject,
 	return ack, nil
 }
 
-// subscribe sets up a subscription on the given partition and begins sending
-// messages on the returned channel. The subscription will run until the cancel
-// channel is closed, the context is canceled, or an error is returned
-// asynchronously on the status channel.
+// subscribe sets up a subscription on the given partition with the given
+// options and begins sending messages on the returned channel. The
+// subscription will run until the cancel channel is closed, the context is
+// canceled, or an error is returned asynchronously on the status channel.
 func (a *apiServer) subscribe(ctx context.Context, partition *partition,
-	req *client.SubscribeRequest, cancel chan struct{}) (
+	req *client.SubscribeRequest, opts *subscriptionOptions, cancel chan struct{}) (
 	<-chan *client.Message, <-chan *status.Status, *status.Status) {
 
 	if req.Resume {
@@ -642,15 +1014,33 @@ func (a *apiServer) subscribe(ctx context.Context, partition *partition,
 			a.logger.Errorf("api: Failed to subscribe to partition "+
 				"[stream=%s, partition=%d]: no such partition",
 				req.Stream, req.Partition)
-			return nil, nil, status.New(codes.NotFound, "No such partition")
+			return nil, nil, partitionNotFoundStatus(codes.NotFound, "No such partition", req.Stream, req.Partition)
 		}
 	}
 
+	if opts.queueName != "" {
+		return a.subscribeQueue(ctx, partition, req, opts.queueName, opts.queueOptions, cancel)
+	}
+
+	if opts.startCursor != "" {
+		if st := a.cursors.applyStartCursor(ctx, partition, opts.startCursor, req); st != nil {
+			return nil, nil, st
+		}
+	}
+
+	if st := setEncoderDictionary(ctx, opts.encoder, partition); st != nil {
+		return nil, nil, st
+	}
+
 	startOffset, st := getStartOffset(req, partition.log)
 	if st != nil {
 		return nil, nil, st
 	}
 
+	if st := checkOffsetContinuity(ctx, req, partition.log, startOffset); st != nil {
+		return nil, nil, st
+	}
+
 	stopOffset, st := getStopOffset(req, partition.log)
 	if st != nil {
 		return nil, nil, st
@@ -661,103 +1051,173 @@ func (a *apiServer) subscribe(ctx context.Context, partition *partition,
 			codes.InvalidArgument, fmt.Sprintf("Stop offset is before start offset: %d < %d", stopOffset, startOffset))
 	}
 
-	var (
-		ch          = make(chan *client.Message)
-		errCh       = make(chan *status.Status)
-		reader, err = partition.log.NewReader(startOffset, false)
-	)
+	reader, err := partition.log.NewReader(startOffset, false)
 	if err != nil {
 		return nil, nil, status.New(
 			codes.Internal, fmt.Sprintf("Failed to create stream reader: %v", err))
 	}
 
-	a.startGoroutine(func() {
-		// Update the active subscriber count.
-		partition.IncreaseSubscriberCount()
-		defer partition.DecreaseSubscriberCount()
+	sub := a.newSubscription(ctx, partition, opts, stopOffset, cancel)
+	sub.start(reader, startOffset)
+	return sub.ch, sub.errCh, nil
+}
 
-		headersBuf := make([]byte, 28)
-		for {
-			// TODO: this could be more efficient.
-			m, offset, timestamp, _, err := reader.ReadMessage(ctx, headersBuf)
+// contextWithCancel returns a context which is done when the given context is
+// done or the cancel channel is closed.
+func (s *Server) contextWithCancel(ctx context.Context, cancel <-chan struct{}) context.Context {
+	ctx, stop := context.WithCancel(ctx)
+	s.startGoroutine(func() {
+		defer stop()
+		select {
+		case <-cancel:
+		case <-ctx.Done():
+		}
+	})
+	return ctx
+}
 
-			if err != nil {
-				var s *status.Status
-				if err == commitlog.ErrCommitLogDeleted {
-					// Partition was deleted while subscribed.
-					s = status.New(codes.NotFound, err.Error())
-				} else if err == commitlog.ErrCommitLogClosed {
-					// Partition was closed while subscribed (likely paused).
-					code := codes.Internal
-					if partition.IsPaused() {
-						code = codes.FailedPrecondition
-					}
-					s = status.New(code, err.Error())
-				} else if err == commitlog.ErrCommitLogReadonly {
-					// Partition was set to readonly while subscribed.
-					s = status.New(codes.ResourceExhausted, "End of readonly partition")
-				} else {
-					s = status.Convert(err)
-				}
+// readSubscriptionMessage reads the next message for a subscription to the
+// given partition from the reader, decrypting its value if encryption is
+// enabled. This blocks until a message is available or the context is done,
+// in which case the returned status has the code for the context's error.
+func readSubscriptionMessage(ctx context.Context, partition *partition, reader *commitlog.Reader,
+	headersBuf []byte) (*client.Message, *status.Status) {
 
-				select {
-				case errCh <- s:
-				case <-cancel:
-				}
-				return
+	m, offset, timestamp, _, err := reader.ReadMessage(ctx, headersBuf)
+	return newSubscriptionMessage(ctx, partition, m, offset, timestamp, err)
+}
+
+// readSnapshotMessages reads the messages at the given sorted offsets from
+// the partition's log and calls fn with each in offset order. Offsets which
+// were removed by compaction or retention after they were collected are
+// skipped, and reading stops at snapshotEnd so it never waits on messages
+// past the end of the snapshot. It returns false if fn returned false or the
+// log could not be read, in which case the status is also returned.
+func readSnapshotMessages(ctx context.Context, partition *partition, offsets []int64, snapshotEnd int64,
+	headersBuf []byte, fn func(*client.Message) bool) (bool, *status.Status) {
+
+	if len(offsets) == 0 {
+		return true, nil
+	}
+	reader, err := partition.log.NewReader(offsets[0], false)
+	if err != nil {
+		return false, status.New(codes.Internal,
+			fmt.Sprintf("Failed to create stream reader: %v", err))
+	}
+	for i := 0; i < len(offsets); {
+		msg, s := readSubscriptionMessage(ctx, partition, reader, headersBuf)
+		if s != nil {
+			return false, s
+		}
+		if msg.Offset > snapshotEnd {
+			break
+		}
+		// Skip offsets which are no longer in the log.
+		for i < len(offsets) && offsets[i] < msg.Offset {
+			i++
+		}
+		if i < len(offsets) && offsets[i] == msg.Offset {
+			if !fn(msg) {
+				return false, nil
 			}
-			msgValue := m.Value()
+			i++
+		}
+	}
+	return true, nil
+}
 
-			headers := m.Headers()
+// readSharedSubscriptionMessage is like readSubscriptionMessage but returns
+// the message shared through the partition's delivery cache. If another
+// subscription already read the message, the copy read from the log is not
+// needed, so it's read into a pooled buffer which is then released. This
+// keeps subscriptions tailing the same partition from each allocating a copy
+// of every message.
+func readSharedSubscriptionMessage(ctx context.Context, partition *partition,
+	reader *commitlog.Reader, headersBuf []byte) (*client.Message, *status.Status) {
 
-			// Data decryption
-			if partition.encryptionHandler != nil {
-				// Decryption of data on server side
-				decryptedMsg, err := partition.encryptionHandler.Read(msgValue)
+	if partition.deliveryCache == nil {
+		return readSubscriptionMessage(ctx, partition, reader, headersBuf)
+	}
+	m, offset, timestamp, _, err := reader.ReadPooledMessage(ctx, headersBuf)
+	msg, s := newSubscriptionMessage(ctx, partition, m, offset, timestamp, err)
+	if s != nil {
+		return nil, s
+	}
+	shared := partition.deliveryCache.share(msg)
+	if shared != msg {
+		commitlog.ReleaseMessage(m)
+	}
+	return shared, nil
+}
 
-				if err != nil {
-					s := status.Convert(err)
-					select {
-					case errCh <- s:
-					case <-cancel:
-					}
-					return
-				}
+// newSubscriptionMessage returns the message read from the partition's log
+// for a subscription, decrypting its value if encryption is enabled, or the
+// status for the error reading it.
+func newSubscriptionMessage(ctx context.Context, partition *partition, m commitlog.SerializedMessage,
+	offset, timestamp int64, err error) (*client.Message, *status.Status) {
 
-				msgValue = decryptedMsg
+	if err != nil {
+		var s *status.Status
+		if ctx.Err() != nil {
+			// The subscription was canceled or its deadline was exceeded
+			// while waiting for data.
+			s = status.FromContextError(ctx.Err())
+		} else if err == commitlog.ErrCommitLogDeleted {
+			// Partition was deleted while subscribed.
+			s = status.New(codes.NotFound, err.Error())
+		} else if err == commitlog.ErrCommitLogClosed {
+			// Partition was closed while subscribed (likely paused).
+			code := codes.Internal
+			if partition.IsPaused() {
+				code = codes.FailedPrecondition
 			}
+			s = status.New(code, err.Error())
+		} else if err == commitlog.ErrCommitLogReadonly {
+			// Partition was set to readonly while subscribed.
+			s = status.New(codes.ResourceExhausted, "End of readonly partition")
+		} else if errors.Cause(err) == commitlog.ErrCorruptMessage {
+			// The message read from disk does not match its checksum.
+			s = status.New(codes.DataLoss, err.Error())
+		} else {
+			s = status.Convert(err)
+		}
+		return nil, s
+	}
+	atomic.AddInt64(&partition.readCount, 1)
+	msgValue := m.Value()
 
-			var (
-				msg = &client.Message{
-					Stream:       partition.Stream,
-					Partition:    partition.Id,
-					Offset:       offset,
-					Key:          m.Key(),
-					Value:        msgValue,
-					Timestamp:    timestamp,
-					Headers:      headers,
-					Subject:      string(headers["subject"]),
-					ReplySubject: string(headers["reply"]),
-				}
-			)
-			select {
-			case ch <- msg:
-			case <-cancel:
-				return
-			}
-			if offset == stopOffset {
-				s := status.New(codes.ResourceExhausted, "Stop offset reached")
+	headers := m.Headers()
 
-				select {
-				case errCh <- s:
-				case <-cancel:
-				}
-				return
-			}
+	// Tombstones are flagged so consumers can tell deletes apart. Their
+	// values are not encrypted.
+	tombstone := isTombstone(m.Key(), msgValue)
+	if tombstone {
+		headers[TombstoneHeader] = []byte("true")
+	}
+
+	// Data decryption
+	if partition.encryptionHandler != nil && !tombstone {
+		// Decryption of data on server side
+		decryptedMsg, err := partition.encryptionHandler.Read(msgValue)
+
+		if err != nil {
+			return nil, status.Convert(err)
 		}
-	})
 
-	return ch, errCh, nil
+		msgValue = decryptedMsg
+	}
+
+	return &client.Message{
+		Stream:       partition.Stream,
+		Partition:    partition.Id,
+		Offset:       offset,
+		Key:          m.Key(),
+		Value:        msgValue,
+		Timestamp:    timestamp,
+		Headers:      headers,
+		Subject:      string(headers["subject"]),
+		ReplySubject: string(headers["reply"]),
+	}, nil
 }
 
 func getStartOffset(req *client.SubscribeRequest, log commitlog.CommitLog) (int64, *status.Status) {
@@ -868,6 +1328,140 @@ func getStreamConfig(req *client.CreateStreamRequest) *proto.StreamConfig {
 	return config
 }
 
+// aliasForMetadata returns the name of the stream a CreateStream request adds
+// an alias for, if any.
+func aliasForMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(AliasForMetadata); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// renameMetadata returns the name of the stream a CreateStream request renames,
+// if any, and whether the rename replaces the stream the new name refers to.
+func renameMetadata(ctx context.Context) (string, bool, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false, nil
+	}
+	values := md.Get(RenameFromMetadata)
+	if len(values) == 0 {
+		return "", false, nil
+	}
+	renameFrom := values[0]
+	replace := false
+	if values := md.Get(RenameReplaceMetadata); len(values) > 0 {
+		var err error
+		replace, err = strconv.ParseBool(values[0])
+		if err != nil {
+			return "", false, status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", RenameReplaceMetadata, values[0]))
+		}
+	}
+	return renameFrom, replace, nil
+}
+
+// applyStreamConfigMetadata applies stream settings which are not part of the
+// CreateStreamRequest from the request metadata to the given StreamConfig.
+func applyStreamConfigMetadata(ctx context.Context, config *proto.StreamConfig) *status.Status {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	if values := md.Get(CompactKeepVersionsMetadata); len(values) > 0 {
+		keepVersions, err := strconv.ParseInt(values[0], 10, 32)
+		if err != nil || keepVersions < 1 {
+			return status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", CompactKeepVersionsMetadata, values[0]))
+		}
+		config.CompactKeepVersions = &proto.NullableInt32{Value: int32(keepVersions)}
+	}
+	if values := md.Get(RetentionMaxKeysMetadata); len(values) > 0 {
+		maxKeys, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil || maxKeys < 0 {
+			return status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", RetentionMaxKeysMetadata, values[0]))
+		}
+		config.RetentionMaxKeys = &proto.NullableInt64{Value: maxKeys}
+	}
+	if values := md.Get(FlushMessagesMetadata); len(values) > 0 {
+		flushMessages, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil || flushMessages < 0 {
+			return status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", FlushMessagesMetadata, values[0]))
+		}
+		config.FlushMessages = &proto.NullableInt64{Value: flushMessages}
+	}
+	if values := md.Get(FlushMsMetadata); len(values) > 0 {
+		flushMs, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil || flushMs < 0 {
+			return status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", FlushMsMetadata, values[0]))
+		}
+		config.FlushMs = &proto.NullableInt64{Value: flushMs}
+	}
+	if values := md.Get(TieredStorageEnabledMetadata); len(values) > 0 {
+		enabled, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", TieredStorageEnabledMetadata, values[0]))
+		}
+		config.TieredStorageEnabled = &proto.NullableBool{Value: enabled}
+	}
+	if values := md.Get(TieredStorageBucketMetadata); len(values) > 0 {
+		config.TieredStorageBucket = values[0]
+	}
+	if values := md.Get(TieredStoragePrefixMetadata); len(values) > 0 {
+		if !isValidTieredPrefix(values[0]) {
+			return status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", TieredStoragePrefixMetadata, values[0]))
+		}
+		config.TieredStoragePrefix = values[0]
+	}
+	if values := md.Get(SegmentCompressionMetadata); len(values) > 0 {
+		compression, err := commitlog.ParseSegmentCompression(values[0])
+		if err != nil {
+			return status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", SegmentCompressionMetadata, values[0]))
+		}
+		config.SegmentCompression = string(compression)
+	}
+	if values := md.Get(DedupWindowMetadata); len(values) > 0 {
+		window, err := time.ParseDuration(values[0])
+		if err != nil || window < 0 {
+			return status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", DedupWindowMetadata, values[0]))
+		}
+		config.DedupWindow = &proto.NullableInt64{Value: int64(window / time.Millisecond)}
+	}
+	if st := applySampleMetadata(md, config); st != nil {
+		return st
+	}
+	if st := applyDeriveMetadata(md, config); st != nil {
+		return st
+	}
+	if st := applySnapshotMetadata(md, config); st != nil {
+		return st
+	}
+	return applyPartitionKeyMetadata(md, config)
+}
+
+// checkSegmentCompression returns an error if a stream with the given
+// configuration would compress segments while segment encryption is enabled,
+// since encrypted segments can't be compressed.
+func (a *apiServer) checkSegmentCompression(config *proto.StreamConfig) *status.Status {
+	streamsConfig := a.getStreamsConfig(config)
+	if streamsConfig.SegmentEncryption && streamsConfig.SegmentCompression != "" &&
+		streamsConfig.SegmentCompression != commitlog.CompressionNone {
+		return status.New(codes.InvalidArgument, "Segment compression cannot be combined with segment encryption")
+	}
+	return nil
+}
+
 func convertPublishAsyncError(err *client.PublishAsyncError) error {
 	if err == nil {
 		return nil
@@ -880,7 +1474,7 @@ func convertPublishAsyncError(err *client.PublishAsyncError) error {
 		code = codes.InvalidArgument
 	case client.PublishAsyncError_READONLY:
 		code = codes.FailedPrecondition
-	case client.PublishAsyncError_ENCRYPTION_FAILED:
+	case client.PublishAsyncError_ENCRYPTION_FAILED, client.PublishAsyncError_INTERNAL:
 		code = codes.Internal
 	case client.PublishAsyncError_UNKNOWN:
 		fallthrough
@@ -932,35 +1526,32 @@ func (a *apiServer) newPublishAsyncSession(stream client.API_PublishAsyncServer)
 	return &publishAsyncSession{
 		apiServer: a,
 		stream:    stream,
-		ackInbox:  a.getAckInbox(),
+		ackInbox:  a.getCoalescedAckInbox(),
 	}
 }
 
 // dispatchAcks sets up a subscription on the ack inbox to dispatch acks for
-// published messages back to the client.
+// published messages back to the client. The inbox accepts coalesced acks, so
+// partitions may send several acks in one ack batch envelope.
 func (p *publishAsyncSession) dispatchAcks() error {
 	sub, err := p.ncPublishes.Subscribe(p.ackInbox, func(m *nats.Msg) {
+		if proto.IsAckBatch(m.Data) {
+			acks, err := proto.UnmarshalAckBatch(m.Data)
+			if err != nil {
+				p.logger.Errorf("api: Invalid ack batch received on ack inbox: %v", err)
+				return
+			}
+			for _, ack := range acks {
+				p.dispatchAck(ack)
+			}
+			return
+		}
 		ack, err := proto.UnmarshalAck(m.Data)
 		if err != nil {
 			p.logger.Errorf("api: Invalid ack received on ack inbox: %v", err)
 			return
 		}
-		p.mu.Lock()
-		p.inflight--
-		if p.inflight < 0 {
-			p.inflight = 0
-		}
-		p.mu.Unlock()
-
-		if e := convertAckError(ack.AckError); e != nil {
-			p.logger.Errorf("api: Published async message was rejected: %v", e.Message)
-			p.sendPublishAsyncError(ack.CorrelationId, e)
-			return
-		}
-
-		if err := p.stream.Send(&client.PublishResponse{CorrelationId: ack.CorrelationId, Ack: ack}); err != nil {
-			p.logger.Errorf("api: Failed to send PublishAsync response: %v", err)
-		}
+		p.dispatchAck(ack)
 	})
 	if err != nil {
 		return err
@@ -970,11 +1561,36 @@ func (p *publishAsyncSession) dispatchAcks() error {
 	return nil
 }
 
+// dispatchAck sends the ack for a published message back to the client.
+func (p *publishAsyncSession) dispatchAck(ack *client.Ack) {
+	p.mu.Lock()
+	p.inflight--
+	if p.inflight < 0 {
+		p.inflight = 0
+	}
+	p.mu.Unlock()
+
+	if e := convertAckError(ack.AckError); e != nil {
+		p.logger.Errorf("api: Published async message was rejected: %v", e.Message)
+		p.sendPublishAsyncError(ack.CorrelationId, e)
+		return
+	}
+
+	if err := p.stream.Send(&client.PublishResponse{CorrelationId: ack.CorrelationId, Ack: ack}); err != nil {
+		p.logger.Errorf("api: Failed to send PublishAsync response: %v", err)
+	}
+}
+
 // publishLoop is a long-lived loop that receives messages from the client and
 // publishes them. It returns nil on completion or an error which is terminal.
 // If the client closes the stream, this will attempt to wait for remaining
 // acks for any in-flight messages before ending the session.
 func (p *publishAsyncSession) publishLoop() error {
+	batch, st := batchFromContext(p.stream.Context())
+	if st != nil {
+		return st.Err()
+	}
+	c := clientFromContext(p.stream.Context())
 	for {
 		req, err := p.stream.Recv()
 		if err != nil {
@@ -984,6 +1600,23 @@ func (p *publishAsyncSession) publishLoop() error {
 			}
 			return err
 		}
+		c.recordPublish(len(req.Value))
+
+		// Messages received once the server begins draining are rejected
+		// rather than published since the session is about to end.
+		if p.isDraining() {
+			p.sendPublishAsyncError(req.CorrelationId, &client.PublishAsyncError{
+				Code:    client.PublishAsyncError_UNKNOWN,
+				Message: "server is draining",
+			})
+			continue
+		}
+
+		if e := p.autoCreateStream(p.stream.Context(), req.Stream); e != nil {
+			p.logger.Errorf("api: Failed to publish async message: %v", e.Message)
+			p.sendPublishAsyncError(req.CorrelationId, e)
+			continue
+		}
 
 		if e := p.ensurePublishPreconditions(req); e != nil {
 			p.logger.Errorf("api: Failed to publish async message: %v", e.Message)
@@ -1010,27 +1643,15 @@ func (p *publishAsyncSession) publishLoop() error {
 			})
 			continue
 		}
-		msg, err := proto.MarshalPublish(&client.Message{
-			Key:           req.Key,
-			Value:         req.Value,
-			Stream:        req.Stream,
-			Subject:       subject,
-			Headers:       req.Headers,
-			AckInbox:      req.AckInbox,
-			CorrelationId: req.CorrelationId,
-			AckPolicy:     req.AckPolicy,
-			Offset:        req.ExpectedOffset,
-		})
-		if err != nil {
-			err = errors.Wrap(err, "failed to marshal message")
-			p.logger.Errorf("api: Failed to publish async message: %v", err)
-			p.sendPublishAsyncError(req.CorrelationId, &client.PublishAsyncError{
-				Code:    client.PublishAsyncError_INTERNAL,
-				Message: err.Error(),
-			})
+		msg, e := marshalPublishRequest(req, subject, batch)
+		if e != nil {
+			p.logger.Errorf("api: Failed to publish async message: %v", e.Message)
+			p.sendPublishAsyncError(req.CorrelationId, e)
 			continue
 		}
-		if err := p.ncPublishes.Publish(subject, msg); err != nil {
+		err = p.ncPublishes.Publish(subject, msg)
+		putEnvelopeBuffer(msg)
+		if err != nil {
 			err = errors.Wrap(err, "failed to publish to NATS")
 			p.logger.Errorf("api: Failed to publish async message: %v", err)
 			p.sendPublishAsyncError(req.CorrelationId, &client.PublishAsyncError{
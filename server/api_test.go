@@ -16,6 +16,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/liftbridge-io/liftbridge/server/protocol"
@@ -785,7 +786,7 @@ func TestSubscribePartitionDeleted(t *testing.T) {
 	require.NoError(t, err)
 
 	req := &proto.SubscribeRequest{StartPosition: proto.StartPosition_NEW_ONLY}
-	_, statusCh, status := api.subscribe(context.Background(), stream.GetPartitions()[0], req, make(chan struct{}))
+	_, statusCh, status := api.subscribe(context.Background(), stream.GetPartitions()[0], req, &subscriptionOptions{}, make(chan struct{}))
 	require.Nil(t, status)
 
 	require.NoError(t, stream.Delete())
@@ -819,7 +820,7 @@ func TestSubscribePartitionPaused(t *testing.T) {
 	require.NoError(t, err)
 
 	req := &proto.SubscribeRequest{StartPosition: proto.StartPosition_NEW_ONLY}
-	_, statusCh, status := api.subscribe(context.Background(), stream.GetPartitions()[0], req, make(chan struct{}))
+	_, statusCh, status := api.subscribe(context.Background(), stream.GetPartitions()[0], req, &subscriptionOptions{}, make(chan struct{}))
 	require.Nil(t, status)
 
 	_, err = stream.Pause(nil, true)
@@ -853,7 +854,7 @@ func TestSubscribePartitionClosed(t *testing.T) {
 	require.NoError(t, err)
 
 	req := &proto.SubscribeRequest{StartPosition: proto.StartPosition_NEW_ONLY}
-	_, statusCh, status := api.subscribe(context.Background(), stream.GetPartitions()[0], req, make(chan struct{}))
+	_, statusCh, status := api.subscribe(context.Background(), stream.GetPartitions()[0], req, &subscriptionOptions{}, make(chan struct{}))
 	require.Nil(t, status)
 
 	require.NoError(t, stream.Close())
@@ -993,6 +994,245 @@ func TestGetStreamConfig(t *testing.T) {
 	require.Equal(t, int32(9), config.MinIsr.Value)
 }
 
+// Ensure applyStreamConfigMetadata applies stream settings from the request
+// metadata to the StreamConfig and rejects invalid values.
+func TestApplyStreamConfigMetadata(t *testing.T) {
+	config := new(protocol.StreamConfig)
+	require.Nil(t, applyStreamConfigMetadata(context.Background(), config))
+	require.Nil(t, config.CompactKeepVersions)
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(CompactKeepVersionsMetadata, "3"))
+	require.Nil(t, applyStreamConfigMetadata(ctx, config))
+	require.Equal(t, int32(3), config.CompactKeepVersions.Value)
+
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(RetentionMaxKeysMetadata, "100"))
+	require.Nil(t, applyStreamConfigMetadata(ctx, config))
+	require.Equal(t, int64(100), config.RetentionMaxKeys.Value)
+
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(FlushMessagesMetadata, "1000", FlushMsMetadata, "200"))
+	require.Nil(t, applyStreamConfigMetadata(ctx, config))
+	require.Equal(t, int64(1000), config.FlushMessages.Value)
+	require.Equal(t, int64(200), config.FlushMs.Value)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		TieredStorageEnabledMetadata, "true",
+		TieredStorageBucketMetadata, "/tmp/tiered",
+		TieredStoragePrefixMetadata, "foo/bar"))
+	require.Nil(t, applyStreamConfigMetadata(ctx, config))
+	require.True(t, config.TieredStorageEnabled.Value)
+	require.Equal(t, "/tmp/tiered", config.TieredStorageBucket)
+	require.Equal(t, "foo/bar", config.TieredStoragePrefix)
+
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(SegmentCompressionMetadata, "lz4"))
+	require.Nil(t, applyStreamConfigMetadata(ctx, config))
+	require.Equal(t, "lz4", config.SegmentCompression)
+
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(DedupWindowMetadata, "10m"))
+	require.Nil(t, applyStreamConfigMetadata(ctx, config))
+	require.Equal(t, int64(600000), config.DedupWindow.Value)
+
+	for _, md := range []metadata.MD{
+		metadata.Pairs(CompactKeepVersionsMetadata, "0"),
+		metadata.Pairs(CompactKeepVersionsMetadata, "-1"),
+		metadata.Pairs(CompactKeepVersionsMetadata, "foo"),
+		metadata.Pairs(RetentionMaxKeysMetadata, "-1"),
+		metadata.Pairs(RetentionMaxKeysMetadata, "foo"),
+		metadata.Pairs(FlushMessagesMetadata, "-1"),
+		metadata.Pairs(FlushMsMetadata, "foo"),
+		metadata.Pairs(TieredStorageEnabledMetadata, "foo"),
+		metadata.Pairs(TieredStoragePrefixMetadata, "/foo"),
+		metadata.Pairs(TieredStoragePrefixMetadata, "foo/../.."),
+		metadata.Pairs(SegmentCompressionMetadata, "snappy"),
+		metadata.Pairs(DedupWindowMetadata, "-1s"),
+		metadata.Pairs(DedupWindowMetadata, "10"),
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		st := applyStreamConfigMetadata(ctx, new(protocol.StreamConfig))
+		require.NotNil(t, st)
+		require.Equal(t, codes.InvalidArgument, st.Code())
+	}
+}
+
+// Ensure a stream can be published to, subscribed to, and have cursors set
+// using its aliases and that deleting an alias leaves the stream intact.
+func TestStreamAlias(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.CursorsStream.Partitions = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := proto.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &proto.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	aliasCtx := metadata.AppendToOutgoingContext(ctx, AliasForMetadata, "foo")
+	_, err = api.CreateStream(aliasCtx, &proto.CreateStreamRequest{Name: "bar"})
+	require.NoError(t, err)
+
+	// The alias and stream names are taken.
+	_, err = api.CreateStream(aliasCtx, &proto.CreateStreamRequest{Name: "bar"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = api.CreateStream(ctx, &proto.CreateStreamRequest{Subject: "bar", Name: "bar"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = api.CreateStream(metadata.AppendToOutgoingContext(ctx, AliasForMetadata, "baz"),
+		&proto.CreateStreamRequest{Name: "qux"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = api.Publish(ctx, &proto.PublishRequest{
+		Stream:    "bar",
+		Value:     []byte("hello"),
+		AckPolicy: proto.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+
+	metaResp, err := api.FetchMetadata(ctx, &proto.FetchMetadataRequest{Streams: []string{"bar"}})
+	require.NoError(t, err)
+	require.Equal(t, proto.StreamMetadata_OK, metaResp.Metadata[0].Error)
+	require.Equal(t, "foo", metaResp.Metadata[0].Subject)
+
+	sub, err := api.Subscribe(ctx, &proto.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: proto.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), msg.Value)
+
+	// Cursors set using the alias are shared with the stream name.
+	_, err = api.SetCursor(ctx, &proto.SetCursorRequest{Stream: "bar", CursorId: "abc", Offset: 0})
+	require.NoError(t, err)
+	cursorResp, err := api.FetchCursor(ctx, &proto.FetchCursorRequest{Stream: "foo", CursorId: "abc"})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), cursorResp.Offset)
+
+	// Ensure aliases are recovered from a snapshot.
+	require.NoError(t, s1.getRaft().Snapshot().Error())
+	s1.Stop()
+	s1 = runServerWithConfig(t, s1.config)
+	defer s1.Stop()
+	waitForPartition(t, 10*time.Second, "foo", 0, s1)
+	require.Equal(t, []string{"bar"}, s1.metadata.GetStream("foo").GetAliases())
+
+	conn, err = grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api = proto.NewAPIClient(conn)
+
+	// Deleting the alias leaves the stream.
+	_, err = api.DeleteStream(ctx, &proto.DeleteStreamRequest{Name: "bar"})
+	require.NoError(t, err)
+	require.Nil(t, s1.metadata.GetStream("bar"))
+	require.NotNil(t, s1.metadata.GetStream("foo"))
+
+	// Removing an alias that doesn't exist does not delete a stream.
+	_, err = api.DeleteStream(metadata.AppendToOutgoingContext(ctx, AliasForMetadata, "foo"),
+		&proto.DeleteStreamRequest{Name: "foo"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.NotNil(t, s1.metadata.GetStream("foo"))
+}
+
+// Ensure a stream can be renamed, replacing the stream that had the name, and
+// that the rename is recovered from a snapshot.
+func TestStreamRename(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.CursorsStream.Partitions = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := proto.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, name := range []string{"foo", "foo-v2"} {
+		_, err = api.CreateStream(ctx, &proto.CreateStreamRequest{Subject: name, Name: name})
+		require.NoError(t, err)
+		_, err = api.Publish(ctx, &proto.PublishRequest{
+			Stream:    name,
+			Value:     []byte(name),
+			AckPolicy: proto.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	// The name is taken unless the rename replaces the stream.
+	renameCtx := metadata.AppendToOutgoingContext(ctx, RenameFromMetadata, "foo-v2")
+	_, err = api.CreateStream(renameCtx, &proto.CreateStreamRequest{Name: "foo"})
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = api.CreateStream(metadata.AppendToOutgoingContext(renameCtx, RenameReplaceMetadata, "maybe"),
+		&proto.CreateStreamRequest{Name: "foo"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = api.CreateStream(metadata.AppendToOutgoingContext(ctx, RenameFromMetadata, "__cursors"),
+		&proto.CreateStreamRequest{Name: "bar"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = api.CreateStream(metadata.AppendToOutgoingContext(renameCtx, RenameReplaceMetadata, "true"),
+		&proto.CreateStreamRequest{Name: "foo"})
+	require.NoError(t, err)
+
+	// The stream is listed under its new name and the replaced stream is gone.
+	metaResp, err := api.FetchMetadata(ctx, &proto.FetchMetadataRequest{})
+	require.NoError(t, err)
+	var names []string
+	for _, stream := range metaResp.Metadata {
+		names = append(names, stream.Name)
+	}
+	require.Contains(t, names, "foo")
+	require.NotContains(t, names, "foo-v2")
+	require.Equal(t, "foo-v2", s1.metadata.GetStream("foo").GetName())
+
+	sub, err := api.Subscribe(ctx, &proto.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: proto.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo-v2"), msg.Value)
+
+	// Ensure the rename is recovered from a snapshot.
+	require.NoError(t, s1.getRaft().Snapshot().Error())
+	s1.Stop()
+	s1 = runServerWithConfig(t, s1.config)
+	defer s1.Stop()
+	waitForPartition(t, 10*time.Second, "foo-v2", 0, s1)
+	require.Equal(t, "foo", s1.metadata.GetStream("foo-v2").GetDisplayName())
+
+	conn, err = grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api = proto.NewAPIClient(conn)
+
+	// Deleting the stream by its new name deletes it.
+	_, err = api.DeleteStream(ctx, &proto.DeleteStreamRequest{Name: "foo"})
+	require.NoError(t, err)
+	require.Nil(t, s1.metadata.GetStream("foo-v2"))
+}
+
 // Ensure SetCursor stores cursors and FetchCursor retrieves them.
 func TestSetFetchCursor(t *testing.T) {
 	defer cleanupStorage(t)
@@ -1096,6 +1336,66 @@ func TestSetFetchCursorNoCache(t *testing.T) {
 	require.Error(t, err)
 }
 
+// Ensure subscriptions started from a cursor begin after the cursor's position
+// and fall back to the request's start position if it is not set.
+func TestSubscribeStartCursor(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.CursorsStream.Partitions = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := proto.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &proto.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = api.Publish(ctx, &proto.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: proto.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	firstOffset := func() int64 {
+		sub, err := api.Subscribe(
+			metadata.AppendToOutgoingContext(ctx, StartCursorMetadata, "abc"),
+			&proto.SubscribeRequest{Stream: "foo", StartPosition: proto.StartPosition_EARLIEST},
+		)
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		return msg.Offset
+	}
+
+	// The cursor has not been set, so the subscription starts at the
+	// request's start position.
+	require.Equal(t, int64(0), firstOffset())
+
+	_, err = api.SetCursor(ctx, &proto.SetCursorRequest{Stream: "foo", CursorId: "abc", Offset: 2})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), firstOffset())
+
+	// Starting from a cursor is not supported for queue subscriptions.
+	sub, err := api.Subscribe(
+		metadata.AppendToOutgoingContext(ctx, StartCursorMetadata, "abc", QueueMetadata, "q"),
+		&proto.SubscribeRequest{Stream: "foo"},
+	)
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 // publishAndReceive publishes and waits for a message to arrive.
 func publishAndReceive(t *testing.T, client lift.Client, stream string) {
 	gotMsg := make(chan struct{})
@@ -2077,3 +2377,51 @@ func TestDataEncryptionStreamOnError(t *testing.T) {
 	require.Contains(t, st.Message(), "invalid AES key size")
 
 }
+
+// Ensure an internal subscription stops reading the log as soon as it's
+// canceled, even though its context is not done, and that a subscription
+// whose deadline is exceeded while waiting for messages ends with a
+// DeadlineExceeded status.
+func TestSubscribeInternalCancelStopsReading(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	client, err := lift.Connect([]string{"localhost:5050"})
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.CreateStream(context.Background(), "foo", "foo"))
+	getPartitionLeader(t, 10*time.Second, "foo", 0, s1)
+	partition := s1.metadata.GetPartition("foo", 0)
+	subscribers := func() int64 {
+		partition.mu.RLock()
+		defer partition.mu.RUnlock()
+		return partition.subscriberCount
+	}
+
+	// A priority window keeps the subscription reading in its own goroutine
+	// rather than attaching to the partition's dispatcher.
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(PriorityWindowMetadata, "10"))
+	_, _, cancel, err := s1.api.SubscribeInternal(ctx, &proto.SubscribeRequest{Stream: "foo"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), subscribers())
+	cancel()
+	require.Eventually(t, func() bool { return subscribers() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancelCtx := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelCtx()
+	_, errC, cancel, err := s1.api.SubscribeInternal(ctx, &proto.SubscribeRequest{Stream: "foo"})
+	require.NoError(t, err)
+	defer cancel()
+	select {
+	case st := <-errC:
+		require.Equal(t, codes.DeadlineExceeded, st.Code())
+	case <-time.After(5 * time.Second):
+		t.Fatal("Subscription did not end after its deadline")
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// APIVersionMetadata is the response header containing the version of the
+// client API the server speaks, i.e. APIVersion.
+const APIVersionMetadata = "liftbridge-api-version"
+
+// ClientAPIVersionMetadata is the request metadata with which clients declare
+// the version of the client API they speak. The server adapts its responses
+// to it, e.g. by not sending messages the client does not understand. Clients
+// which don't declare a version are assumed to speak version 1. Versions
+// newer than the server's are accepted, so clients should check
+// APIVersionMetadata to find out which features the server supports.
+const ClientAPIVersionMetadata = "liftbridge-client-api-version"
+
+// DeprecationMetadata is the response header containing a warning for each
+// deprecated feature the request used, e.g. a deprecated RPC or client API
+// version.
+const DeprecationMetadata = "liftbridge-deprecation"
+
+const (
+	// APIVersion is the version of the client API the server speaks. It's
+	// incremented when the server starts sending responses older clients may
+	// not understand.
+	//
+	// Version 1 is the protocol of clients which don't declare a version.
+	// Version 2 adds the notification message sent to subscriptions when the
+	// server begins draining.
+	APIVersion = 2
+
+	// minAPIVersion is the oldest client API version the server supports.
+	minAPIVersion = 1
+
+	// apiVersionDrainNotifications is the client API version from which
+	// subscriptions are notified when the server begins draining.
+	apiVersionDrainNotifications = 2
+)
+
+// deprecatedAPIVersions are the supported client API versions which are
+// deprecated.
+var deprecatedAPIVersions = map[int]string{
+	1: fmt.Sprintf("Client API version 1 is deprecated, upgrade to version %d", APIVersion),
+}
+
+// deprecatedMethods are the API methods which are deprecated.
+var deprecatedMethods = map[string]string{
+	"/proto.API/Publish": "Publish is deprecated, use PublishAsync",
+}
+
+// clientAPIVersion returns the client API version declared in the request
+// context or 1 if none or an invalid version was declared.
+func clientAPIVersion(ctx context.Context) int {
+	version, err := parseClientAPIVersion(ctx)
+	if err != nil {
+		return minAPIVersion
+	}
+	return version
+}
+
+func parseClientAPIVersion(ctx context.Context) (int, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return minAPIVersion, nil
+	}
+	values := md.Get(ClientAPIVersionMetadata)
+	if len(values) == 0 {
+		return minAPIVersion, nil
+	}
+	version, err := strconv.Atoi(values[0])
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid %s %q", ClientAPIVersionMetadata, values[0])
+	}
+	if version < minAPIVersion {
+		return 0, fmt.Errorf("client API version %d is no longer supported, the oldest supported "+
+			"version is %d", version, minAPIVersion)
+	}
+	return version, nil
+}
+
+// apiVersionHeader returns the response header for a request to the given API
+// method. It contains the server's API version and any deprecation warnings or
+// an error if the client declared an unsupported version.
+func apiVersionHeader(ctx context.Context, method string) (metadata.MD, *status.Status) {
+	version, err := parseClientAPIVersion(ctx)
+	if err != nil {
+		return nil, status.New(codes.FailedPrecondition, err.Error())
+	}
+	md := metadata.Pairs(APIVersionMetadata, strconv.Itoa(APIVersion))
+	if warning, ok := deprecatedAPIVersions[version]; ok {
+		md.Append(DeprecationMetadata, warning)
+	}
+	if warning, ok := deprecatedMethods[method]; ok {
+		md.Append(DeprecationMetadata, warning)
+	}
+	return md, nil
+}
+
+// isAPIMethod indicates if the method is part of the client API as opposed
+// to e.g. the health service.
+func isAPIMethod(method string) bool {
+	return strings.HasPrefix(method, "/proto.API/")
+}
+
+// apiVersionUnaryInterceptor sets the API version header on unary API
+// requests.
+func apiVersionUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	if !isAPIMethod(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	md, st := apiVersionHeader(ctx, info.FullMethod)
+	if st != nil {
+		return nil, st.Err()
+	}
+	grpc.SetHeader(ctx, md) // nolint: errcheck
+	return handler(ctx, req)
+}
+
+// apiVersionStreamInterceptor sets the API version header on streaming API
+// requests.
+func apiVersionStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	if !isAPIMethod(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	md, st := apiVersionHeader(ss.Context(), info.FullMethod)
+	if st != nil {
+		return st.Err()
+	}
+	ss.SetHeader(md) // nolint: errcheck
+	return handler(srv, ss)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func withClientAPIVersion(version string) context.Context {
+	return metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(ClientAPIVersionMetadata, version))
+}
+
+// Ensure the API version header contains the server's version and warnings
+// for deprecated versions and methods, and rejects invalid versions.
+func TestAPIVersionHeader(t *testing.T) {
+	// Clients which don't declare a version speak the deprecated version 1.
+	md, st := apiVersionHeader(context.Background(), "/proto.API/FetchMetadata")
+	require.Nil(t, st)
+	require.Equal(t, []string{strconv.Itoa(APIVersion)}, md.Get(APIVersionMetadata))
+	require.Equal(t, []string{deprecatedAPIVersions[1]}, md.Get(DeprecationMetadata))
+	require.Equal(t, 1, clientAPIVersion(context.Background()))
+
+	ctx := withClientAPIVersion(strconv.Itoa(APIVersion))
+	md, st = apiVersionHeader(ctx, "/proto.API/FetchMetadata")
+	require.Nil(t, st)
+	require.Empty(t, md.Get(DeprecationMetadata))
+	require.Equal(t, APIVersion, clientAPIVersion(ctx))
+
+	md, st = apiVersionHeader(ctx, "/proto.API/Publish")
+	require.Nil(t, st)
+	require.Equal(t, []string{deprecatedMethods["/proto.API/Publish"]}, md.Get(DeprecationMetadata))
+
+	// Newer clients are accepted.
+	ctx = withClientAPIVersion(strconv.Itoa(APIVersion + 1))
+	_, st = apiVersionHeader(ctx, "/proto.API/FetchMetadata")
+	require.Nil(t, st)
+	require.Equal(t, APIVersion+1, clientAPIVersion(ctx))
+
+	for _, version := range []string{"0", "-1", "two"} {
+		ctx = withClientAPIVersion(version)
+		_, st = apiVersionHeader(ctx, "/proto.API/FetchMetadata")
+		require.NotNil(t, st, version)
+		require.Equal(t, codes.FailedPrecondition, st.Code())
+		require.Equal(t, 1, clientAPIVersion(ctx))
+	}
+}
+
+// Ensure API responses carry the API version header and requests declaring
+// an invalid version are rejected.
+func TestAPIVersionNegotiation(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var header metadata.MD
+	_, err = api.FetchMetadata(ctx, &client.FetchMetadataRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{strconv.Itoa(APIVersion)}, header.Get(APIVersionMetadata))
+	require.Equal(t, []string{deprecatedAPIVersions[1]}, header.Get(DeprecationMetadata))
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	waitForPartition(t, 10*time.Second, "foo", 0, s)
+
+	// Streaming responses carry the header too.
+	subCtx := metadata.AppendToOutgoingContext(ctx, ClientAPIVersionMetadata, strconv.Itoa(APIVersion))
+	sub, err := api.Subscribe(subCtx, &client.SubscribeRequest{Stream: "foo"})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	header, err = sub.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{strconv.Itoa(APIVersion)}, header.Get(APIVersionMetadata))
+	require.Empty(t, header.Get(DeprecationMetadata))
+
+	badCtx := metadata.AppendToOutgoingContext(ctx, ClientAPIVersionMetadata, "two")
+	_, err = api.FetchMetadata(badCtx, &client.FetchMetadataRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/liftbridge-io/liftbridge/server/archive"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// streamsPath is the path of the stream API on the admin HTTP server.
+// Streams are archived with POST requests to streamsPath/<name>/archive and
+// unarchived with POST requests to streamsPath/<name>/unarchive. The logs of
+// their partitions are verified and repaired under
+// streamsPath/<name>/partitions/<id>.
+const streamsPath = "/v1/streams"
+
+// archiveManifestKey is the key of the object describing an archived stream
+// under the stream's prefix. It's stored last, so a stream is only archived
+// once it's stored.
+const archiveManifestKey = "manifest.json"
+
+var (
+	// ErrArchiveNotConfigured is returned when archiving or unarchiving a
+	// stream if no archive store is configured.
+	ErrArchiveNotConfigured = errors.New("no archive store configured")
+
+	// ErrArchiveNotFound is returned by UnarchiveStream if the stream has not
+	// been archived.
+	ErrArchiveNotFound = errors.New("stream is not archived")
+
+	// errNotInISR is returned by ArchiveStream if the server is not in the ISR
+	// of every partition of the stream, so it may not have all of its data.
+	errNotInISR = errors.New("server is not in the ISR of every partition")
+)
+
+// archiveManifest describes an archived stream.
+type archiveManifest struct {
+	Stream     []byte             `json:"stream"`     // Marshaled proto.Stream
+	Partitions map[int32][]string `json:"partitions"` // Files of each partition
+	ArchivedAt time.Time          `json:"archivedAt"`
+}
+
+// archivePrefix returns the key prefix of the objects of an archived stream.
+func archivePrefix(stream string) string {
+	return url.PathEscape(stream) + "/"
+}
+
+// archivePartitionKey returns the key of a file of an archived partition.
+func archivePartitionKey(stream string, id int32, file string) string {
+	return fmt.Sprintf("%s%d/%s", archivePrefix(stream), id, file)
+}
+
+// ArchiveStream moves a stream to the archive store. It pauses the stream,
+// stores the data and metadata of its partitions, and then deletes it, which
+// removes its data from every server. The data is read from this server, so
+// it must be in the ISR of every partition. The archived stream can be
+// restored with UnarchiveStream.
+func (s *Server) ArchiveStream(ctx context.Context, name string) error {
+	if s.archiveStore == nil {
+		return ErrArchiveNotConfigured
+	}
+	stream := s.metadata.GetStream(name)
+	if stream == nil {
+		return ErrStreamNotFound
+	}
+	partitions := stream.GetPartitions()
+	ids := make([]int32, 0, len(partitions))
+	for id, partition := range partitions {
+		if !partition.inISR(s.config.Clustering.ServerID) {
+			return errNotInISR
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	s.logger.Infof("Archiving stream %s", name)
+
+	// Pause the stream so its data does not change while it's stored.
+	if st := s.metadata.PauseStream(ctx, &proto.PauseStreamOp{Stream: name, Partitions: ids}); st != nil {
+		return st.Err()
+	}
+	if err := waitForPaused(ctx, partitions); err != nil {
+		return err
+	}
+
+	manifest := &archiveManifest{
+		Partitions: make(map[int32][]string, len(ids)),
+		ArchivedAt: time.Now(),
+	}
+	protoStream := &proto.Stream{
+		Name:       stream.GetName(),
+		Subject:    stream.GetSubject(),
+		Config:     stream.GetConfig(),
+		Partitions: make([]*proto.Partition, 0, len(ids)),
+	}
+	for _, id := range ids {
+		partition := partitions[id]
+		files, err := s.archivePartition(ctx, partition)
+		if err != nil {
+			return errors.Wrapf(err, "failed to archive partition %s", partition)
+		}
+		manifest.Partitions[id] = files
+		protoStream.Partitions = append(protoStream.Partitions, &proto.Partition{
+			Subject:           partition.Subject,
+			Stream:            partition.Stream,
+			Id:                partition.Id,
+			Group:             partition.Group,
+			ReplicationFactor: partition.ReplicationFactor,
+		})
+	}
+	data, err := protoStream.Marshal()
+	if err != nil {
+		return err
+	}
+	manifest.Stream = data
+	data, err = json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	key := archivePrefix(name) + archiveManifestKey
+	if err := s.archiveStore.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return errors.Wrap(err, "failed to store archive manifest")
+	}
+
+	if st := s.metadata.DeleteStream(ctx, &proto.DeleteStreamOp{Stream: name}); st != nil {
+		return st.Err()
+	}
+	// Wait for the deletion to be applied here in case the request was
+	// forwarded to the metadata leader, so the stream can be unarchived
+	// right away.
+	for s.metadata.GetStream(name) != nil {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.logger.Infof("Archived stream %s", name)
+	return nil
+}
+
+// waitForPaused waits until the given partitions are paused on this server.
+func waitForPaused(ctx context.Context, partitions map[int32]*partition) error {
+	for _, partition := range partitions {
+		for !partition.IsPaused() {
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// archivePartition stores the files of a paused partition and returns their
+// names.
+func (s *Server) archivePartition(ctx context.Context, partition *partition) ([]string, error) {
+	dir, err := s.dataDirs.place(partition.Stream, partition.Id)
+	if err != nil {
+		return nil, err
+	}
+	path := partitionPath(dir, partition.Stream, partition.Id)
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if err := s.archiveFile(ctx, filepath.Join(path, info.Name()),
+			archivePartitionKey(partition.Stream, partition.Id, info.Name())); err != nil {
+			return nil, err
+		}
+		files = append(files, info.Name())
+	}
+	return files, nil
+}
+
+func (s *Server) archiveFile(ctx context.Context, path, key string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.archiveStore.Put(ctx, key, file, info.Size())
+}
+
+// UnarchiveStream restores an archived stream. The data of its partitions is
+// restored on this server, which leads them, with their original offsets, and
+// the other replicas replicate it from there. The stream's aliases are not
+// restored. The archive is kept, so the stream can be restored again, until
+// the stream is archived again.
+func (s *Server) UnarchiveStream(ctx context.Context, name string) error {
+	if s.archiveStore == nil {
+		return ErrArchiveNotConfigured
+	}
+	if s.metadata.GetStream(name) != nil {
+		return ErrStreamExists
+	}
+	manifest, err := s.getArchiveManifest(ctx, name)
+	if err != nil {
+		return err
+	}
+	protoStream := &proto.Stream{}
+	if err := protoStream.Unmarshal(manifest.Stream); err != nil {
+		return errors.Wrap(err, "invalid archive manifest")
+	}
+
+	s.logger.Infof("Unarchiving stream %s", name)
+
+	removePartitions := func() {
+		for _, partition := range protoStream.Partitions {
+			s.removeUnarchivedPartition(name, partition.Id)
+		}
+	}
+	for _, partition := range protoStream.Partitions {
+		if err := s.unarchivePartition(ctx, name, partition.Id, manifest.Partitions[partition.Id]); err != nil {
+			removePartitions()
+			return errors.Wrapf(err, "failed to unarchive partition %d", partition.Id)
+		}
+		partition.Leader = s.config.Clustering.ServerID
+	}
+
+	if st := s.metadata.CreateStream(ctx, &proto.CreateStreamOp{Stream: protoStream}); st != nil {
+		removePartitions()
+		return st.Err()
+	}
+	s.logger.Infof("Unarchived stream %s", name)
+	return nil
+}
+
+func (s *Server) getArchiveManifest(ctx context.Context, name string) (*archiveManifest, error) {
+	r, err := s.archiveStore.Get(ctx, archivePrefix(name)+archiveManifestKey)
+	if err == archive.ErrNotFound {
+		return nil, ErrArchiveNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read archive manifest")
+	}
+	defer r.Close()
+	manifest := &archiveManifest{}
+	if err := json.NewDecoder(r).Decode(manifest); err != nil {
+		return nil, errors.Wrap(err, "invalid archive manifest")
+	}
+	return manifest, nil
+}
+
+// unarchivePartition restores the files of an archived partition to the data
+// directory it's placed in.
+func (s *Server) unarchivePartition(ctx context.Context, stream string, id int32, files []string) error {
+	dir, err := s.dataDirs.place(stream, id)
+	if err != nil {
+		return err
+	}
+	path := partitionPath(dir, stream, id)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := s.unarchiveFile(ctx, archivePartitionKey(stream, id, file),
+			filepath.Join(path, file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) unarchiveFile(ctx context.Context, key, path string) error {
+	r, err := s.archiveStore.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// removeUnarchivedPartition removes the restored data of a partition which
+// could not be unarchived.
+func (s *Server) removeUnarchivedPartition(stream string, id int32) {
+	if s.metadata.GetPartition(stream, id) != nil {
+		return
+	}
+	dir, err := s.dataDirs.place(stream, id)
+	if err == nil {
+		os.RemoveAll(partitionPath(dir, stream, id))
+	}
+	s.dataDirs.remove(stream, id)
+}
+
+// handleStream archives or unarchives the stream named by the request path, or
+// verifies or repairs the log of one of its partitions.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, streamsPath+"/"), "/")
+	if len(parts) == 4 && parts[0] != "" && parts[1] == "partitions" {
+		switch parts[3] {
+		case "export":
+			s.handlePartitionExport(w, r, parts[0], parts[2])
+		case "handoff":
+			s.handlePartitionHandoff(w, r, parts[0], parts[2])
+		default:
+			s.handlePartitionLog(w, r, parts[0], parts[2], parts[3])
+		}
+		return
+	}
+	if len(parts) != 2 || parts[0] == "" {
+		writeAdminError(w, http.StatusNotFound, "Not found", "")
+		return
+	}
+	name, action := parts[0], parts[1]
+	var operation func(context.Context, string) error
+	switch action {
+	case "archive":
+		operation = s.ArchiveStream
+	case "unarchive":
+		operation = s.UnarchiveStream
+	default:
+		writeAdminError(w, http.StatusNotFound, "Not found", "")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	switch err := operation(r.Context(), name); err {
+	case nil:
+		writeAdminResponse(w, http.StatusOK, struct{}{})
+	case ErrStreamNotFound, ErrArchiveNotFound:
+		writeAdminError(w, http.StatusNotFound, err.Error(), "")
+	case ErrStreamExists, errNotInISR, ErrArchiveNotConfigured:
+		writeAdminError(w, http.StatusConflict, err.Error(), "")
+	default:
+		writeAdminError(w, http.StatusInternalServerError, err.Error(), "")
+	}
+}
@@ -0,0 +1,153 @@
+// Package archive implements the stores archived streams are kept in. An
+// archived stream is a set of objects named by keys such as
+// "<stream>/<partition>/<file>", so any store which puts and gets objects by
+// key can be used.
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by Get if there is no object with the key.
+var ErrNotFound = errors.New("archive object not found")
+
+// Store stores archived stream data.
+type Store interface {
+	// Put stores size bytes read from r under the given key, replacing any
+	// object already stored under it.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// Get returns the object stored under the given key. It returns
+	// ErrNotFound if there is none. The caller must close the returned
+	// reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// New returns the store for the given location, which is either a local
+// directory or an http(s) URL.
+func New(location string) (Store, error) {
+	if location == "" {
+		return nil, errors.New("no archive location provided")
+	}
+	if u, err := url.Parse(location); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return NewHTTPStore(location), nil
+	}
+	return NewDirStore(location), nil
+}
+
+// dirStore stores objects as files in a directory.
+type dirStore struct {
+	dir string
+}
+
+// NewDirStore returns a Store which stores objects as files in the given
+// directory, e.g. a mounted network file system or bucket.
+func NewDirStore(dir string) Store {
+	return &dirStore{dir: dir}
+}
+
+// Put writes the object to a temporary file and then renames it so that a
+// failed Put leaves the previous object, if any, in place.
+func (d *dirStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(file, r, size); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d *dirStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(d.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// httpStore stores objects with PUT requests to URLs under a base URL and
+// reads them with GET requests.
+type httpStore struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPStore returns a Store which stores objects at baseURL/key with PUT
+// requests and reads them with GET requests. Requests are sent without
+// credentials or signatures, so the server at baseURL must accept them as
+// they are. Object storage services which require signed requests, such as
+// S3 or GCS, are not supported directly.
+func NewHTTPStore(baseURL string) Store {
+	return &httpStore{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  http.DefaultClient,
+	}
+}
+
+func (h *httpStore) url(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return h.baseURL + "/" + strings.Join(segments, "/")
+}
+
+func (h *httpStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	req, err := http.NewRequest(http.MethodPut, h.url(key), io.LimitReader(r, size))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to store %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (h *httpStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, h.url(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to read %s: %s", key, resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	_, err := store.Get(ctx, "foo/0/data")
+	require.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, store.Put(ctx, "foo/0/data", strings.NewReader("hello"), 5))
+	require.NoError(t, store.Put(ctx, "foo/0/data", strings.NewReader("world"), 5))
+	r, err := store.Get(ctx, "foo/0/data")
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(data))
+}
+
+// Ensure directory stores store objects as files.
+func TestDirStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "liftbridge-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	require.NoError(t, err)
+	testStore(t, store)
+}
+
+// Ensure HTTP stores store objects with PUT requests and read them with GET
+// requests.
+func TestHTTPStore(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		objects = make(map[string][]byte)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = data
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	store, err := New(server.URL + "/bucket/")
+	require.NoError(t, err)
+	testStore(t, store)
+	require.Contains(t, objects, "/bucket/foo/0/data")
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// Ensure archived streams are removed from the cluster and restored with their
+// original offsets when unarchived.
+func TestArchiveStream(t *testing.T) {
+	defer cleanupStorage(t)
+
+	dir, err := ioutil.TempDir("", "liftbridge-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := getTestConfig("a", true, 5050)
+	config.ArchiveLocation = dir
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	require.Equal(t, ErrStreamNotFound, s.ArchiveStream(ctx, "foo"))
+	require.Equal(t, ErrArchiveNotFound, s.UnarchiveStream(ctx, "foo"))
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, s.ArchiveStream(ctx, "foo"))
+	require.Nil(t, s.metadata.GetStream("foo"))
+	_, err = os.Stat(filepath.Join(dir, "foo", archiveManifestKey))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(config.DataDir, "streams", "foo"))
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, s.UnarchiveStream(ctx, "foo"))
+	require.Equal(t, ErrStreamExists, s.UnarchiveStream(ctx, "foo"))
+	waitForPartition(t, 10*time.Second, "foo", 0, s)
+
+	resp, err := api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("3"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), resp.Ack.Offset)
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, int64(i), msg.Offset)
+		require.Equal(t, strconv.Itoa(i), string(msg.Value))
+	}
+}
+
+// Ensure unarchived partitions are replicated from the server which unarchived
+// them.
+func TestArchiveStreamReplicated(t *testing.T) {
+	defer cleanupStorage(t)
+
+	dir, err := ioutil.TempDir("", "liftbridge-archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.ArchiveLocation = dir
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	s2Config := getTestConfig("b", false, 5051)
+	s2Config.ArchiveLocation = dir
+	s2 := runServerWithConfig(t, s2Config)
+	defer s2.Stop()
+	getMetadataLeader(t, 10*time.Second, s1, s2)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	waitForPartition(t, 10*time.Second, "foo", 0, s1, s2)
+	for i := 0; i < 3; i++ {
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	// Archive and unarchive on the server which isn't the metadata leader.
+	require.NoError(t, s2.ArchiveStream(ctx, "foo"))
+	require.NoError(t, s2.UnarchiveStream(ctx, "foo"))
+	waitForPartition(t, 10*time.Second, "foo", 0, s1, s2)
+
+	partition := s1.metadata.GetPartition("foo", 0)
+	leader, _ := partition.GetLeader()
+	require.Equal(t, "b", leader)
+	require.Eventually(t, func() bool {
+		return partition.log.NewestOffset() == 2 && len(partition.GetISR()) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+}
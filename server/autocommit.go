@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Request metadata keys used to have the server commit a subscription's
+// progress to a cursor. Neither the Subscribe nor the SetCursor request has
+// fields for this, so auto-committing is requested with gRPC metadata.
+const (
+	// AutoCommitCursorMetadata is the Subscribe request metadata key naming a
+	// cursor the server advances as the subscription consumes the partition.
+	// The subscription starts after the cursor's position unless
+	// StartCursorMetadata names another cursor. Only one subscription on a
+	// partition can auto-commit a cursor at a time, and the server must be
+	// the leader of the internal cursors partition for the cursor as with
+	// SetCursor.
+	AutoCommitCursorMetadata = "liftbridge-auto-commit-cursor"
+
+	// AutoCommitModeMetadata is the Subscribe request metadata key setting
+	// when messages are committed. With "at-most-once", the default, each
+	// message is committed before it's sent, so a message is never delivered
+	// twice but may be lost if the subscriber fails. With "at-least-once",
+	// messages are committed once they are acked with SetCursorActionAutoCommitAck,
+	// so messages which were not acked are delivered again by the next
+	// subscription starting from the cursor.
+	AutoCommitModeMetadata = "liftbridge-auto-commit-mode"
+)
+
+const (
+	autoCommitAtMostOnce  = "at-most-once"
+	autoCommitAtLeastOnce = "at-least-once"
+)
+
+// autoCommitFromContext parses the cursor a subscription auto-commits and
+// whether it commits messages once acked from the incoming request metadata.
+// The cursor is empty if the subscription does not auto-commit.
+func autoCommitFromContext(ctx context.Context) (string, bool, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false, nil
+	}
+	cursors := md.Get(AutoCommitCursorMetadata)
+	if len(cursors) == 0 || cursors[0] == "" {
+		return "", false, nil
+	}
+	modes := md.Get(AutoCommitModeMetadata)
+	if len(modes) == 0 {
+		return cursors[0], false, nil
+	}
+	switch modes[0] {
+	case autoCommitAtMostOnce:
+		return cursors[0], false, nil
+	case autoCommitAtLeastOnce:
+		return cursors[0], true, nil
+	default:
+		return "", false, status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", AutoCommitModeMetadata, modes[0]))
+	}
+}
+
+// autoCommitter advances a cursor as a subscription consumes a partition. A
+// nil autoCommitter commits nothing.
+type autoCommitter struct {
+	cursorID    string
+	atLeastOnce bool
+	partition   *partition
+	cursors     *cursorManager
+	commitMu    sync.Mutex // Serializes commits so the committed offset only advances
+	mu          sync.Mutex
+	committed   int64              // Last committed offset
+	pending     []int64            // Offsets delivered but not committed, ascending
+	acked       map[int64]struct{} // Pending offsets which were acked
+}
+
+// delivered is called before a message at the given offset is sent. For
+// at-most-once subscriptions, it commits the offset, returning an error if
+// this fails so that the message is not sent. Otherwise, the offset is
+// committed once acked.
+func (c *autoCommitter) delivered(ctx context.Context, offset int64) *status.Status {
+	if c == nil {
+		return nil
+	}
+	if !c.atLeastOnce {
+		c.commitMu.Lock()
+		defer c.commitMu.Unlock()
+		return c.commit(ctx, offset)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Keep pending ascending even if messages are not delivered in offset
+	// order.
+	i := sort.Search(len(c.pending), func(i int) bool { return c.pending[i] >= offset })
+	if i < len(c.pending) && c.pending[i] == offset {
+		return nil
+	}
+	c.pending = append(c.pending, 0)
+	copy(c.pending[i+1:], c.pending[i:])
+	c.pending[i] = offset
+	return nil
+}
+
+// ack acks the delivered message at the given offset and commits the
+// messages delivered before the first one which has not been acked. Acking a
+// committed message has no effect.
+func (c *autoCommitter) ack(ctx context.Context, offset int64) *status.Status {
+	c.commitMu.Lock()
+	defer c.commitMu.Unlock()
+
+	c.mu.Lock()
+	if offset <= c.committed {
+		c.mu.Unlock()
+		return nil
+	}
+	i := sort.Search(len(c.pending), func(i int) bool { return c.pending[i] >= offset })
+	if i == len(c.pending) || c.pending[i] != offset {
+		c.mu.Unlock()
+		return status.Newf(codes.FailedPrecondition, "Message at offset %d has not been delivered", offset)
+	}
+	c.acked[offset] = struct{}{}
+	n := 0
+	for n < len(c.pending) {
+		if _, ok := c.acked[c.pending[n]]; !ok {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	commit := c.pending[n-1]
+	c.mu.Unlock()
+
+	return c.commit(ctx, commit)
+}
+
+// commit stores the given offset in the cursor unless it's not past the last
+// committed offset. The caller must hold commitMu.
+func (c *autoCommitter) commit(ctx context.Context, offset int64) *status.Status {
+	c.mu.Lock()
+	committed := c.committed
+	c.mu.Unlock()
+	if offset <= committed {
+		return nil
+	}
+	if st := c.cursors.SetCursor(ctx, c.partition.Stream, c.cursorID, c.partition.Id, offset); st != nil {
+		return st
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committed = offset
+	for len(c.pending) > 0 && c.pending[0] <= offset {
+		delete(c.acked, c.pending[0])
+		c.pending = c.pending[1:]
+	}
+	return nil
+}
+
+// addAutoCommit registers a subscription auto-committing the given cursor
+// and returns its autoCommitter.
+func (p *partition) addAutoCommit(ctx context.Context, cursors *cursorManager, cursorID string,
+	atLeastOnce bool) (*autoCommitter, *status.Status) {
+
+	// Fail early if commits would fail.
+	cursorKey := cursors.getCursorKey(cursorID, p.Stream, p.Id)
+	if _, st := cursors.getCursorsPartitionID(ctx, cursorKey); st != nil {
+		return nil, st
+	}
+	p.autoCommitsMu.Lock()
+	defer p.autoCommitsMu.Unlock()
+	if _, ok := p.autoCommits[cursorID]; ok {
+		return nil, status.Newf(codes.AlreadyExists,
+			"Cursor %s is already auto-committed by another subscription", cursorID)
+	}
+	if p.autoCommits == nil {
+		p.autoCommits = make(map[string]*autoCommitter)
+	}
+	c := &autoCommitter{
+		cursorID:    cursorID,
+		atLeastOnce: atLeastOnce,
+		partition:   p,
+		cursors:     cursors,
+		committed:   -1,
+		acked:       make(map[int64]struct{}),
+	}
+	p.autoCommits[cursorID] = c
+	return c, nil
+}
+
+// removeAutoCommit unregisters a subscription auto-committing a cursor which
+// has ended.
+func (p *partition) removeAutoCommit(c *autoCommitter) {
+	p.autoCommitsMu.Lock()
+	defer p.autoCommitsMu.Unlock()
+	if p.autoCommits[c.cursorID] == c {
+		delete(p.autoCommits, c.cursorID)
+	}
+}
+
+// getAutoCommit returns the autoCommitter of the subscription auto-committing
+// the given cursor or nil if there is no such subscription.
+func (p *partition) getAutoCommit(cursorID string) *autoCommitter {
+	p.autoCommitsMu.Lock()
+	defer p.autoCommitsMu.Unlock()
+	return p.autoCommits[cursorID]
+}
+
+// ackAutoCommit acks the message at the SetCursor request's offset for the
+// at-least-once subscription auto-committing the cursor named by its cursor
+// ID.
+func (a *apiServer) ackAutoCommit(ctx context.Context, req *client.SetCursorRequest) *status.Status {
+	if req.Offset < 0 {
+		return status.Newf(codes.InvalidArgument, "Invalid offset %d", req.Offset)
+	}
+	partition := a.metadata.GetPartition(a.streamName(req.Stream), req.Partition)
+	if partition == nil {
+		return partitionNotFoundStatus(codes.NotFound, "No such partition", req.Stream, req.Partition)
+	}
+	c := partition.getAutoCommit(req.CursorId)
+	if c == nil || !c.atLeastOnce {
+		return status.Newf(codes.NotFound, "No at-least-once subscription auto-committing cursor %s",
+			req.CursorId)
+	}
+	return c.ack(ctx, req.Offset)
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func setupAutoCommitTest(t *testing.T, messages int) (*Server, client.APIClient, func()) {
+	config := getTestConfig("a", true, 5050)
+	config.CursorsStream.Partitions = 1
+	s := runServerWithConfig(t, config)
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	publishAutoCommitTestMessages(t, api, messages)
+	return s, api, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func publishAutoCommitTestMessages(t *testing.T, api client.APIClient, messages int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 0; i < messages; i++ {
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+}
+
+func subscribeAutoCommit(t *testing.T, ctx context.Context, api client.APIClient,
+	mode string, kv ...string) (client.API_SubscribeClient, error) {
+
+	ctx = metadata.AppendToOutgoingContext(ctx, append([]string{AutoCommitCursorMetadata, "abc"}, kv...)...)
+	if mode != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, AutoCommitModeMetadata, mode)
+	}
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	return sub, err
+}
+
+func fetchAutoCommitCursor(t *testing.T, ctx context.Context, api client.APIClient) int64 {
+	resp, err := api.FetchCursor(ctx, &client.FetchCursorRequest{Stream: "foo", CursorId: "abc"})
+	require.NoError(t, err)
+	return resp.Offset
+}
+
+func ackAutoCommitTestMessage(ctx context.Context, api client.APIClient, offset int64) error {
+	_, err := api.SetCursor(
+		metadata.AppendToOutgoingContext(ctx, SetCursorActionMetadata, SetCursorActionAutoCommitAck),
+		&client.SetCursorRequest{Stream: "foo", CursorId: "abc", Offset: offset},
+	)
+	return err
+}
+
+// Ensure at-most-once auto-committing subscriptions commit messages as they
+// are sent and resume after the last one.
+func TestSubscribeAutoCommitAtMostOnce(t *testing.T) {
+	defer cleanupStorage(t)
+
+	_, api, cleanup := setupAutoCommitTest(t, 3)
+	defer cleanup()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	sub, err := subscribeAutoCommit(t, subCtx, api, "")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, int64(i), msg.Offset)
+	}
+	require.Equal(t, int64(2), fetchAutoCommitCursor(t, ctx, api))
+
+	// Only one subscription can auto-commit the cursor.
+	_, err = subscribeAutoCommit(t, ctx, api, autoCommitAtMostOnce)
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// The next subscription resumes after the committed messages.
+	subCancel()
+	publishAutoCommitTestMessages(t, api, 1)
+	require.Eventually(t, func() bool {
+		sub, err = subscribeAutoCommit(t, ctx, api, autoCommitAtMostOnce)
+		return status.Code(err) != codes.AlreadyExists
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), msg.Offset)
+
+	_, err = subscribeAutoCommit(t, ctx, api, "exactly-once")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// Ensure at-least-once auto-committing subscriptions commit messages once
+// they and all messages before them are acked and redeliver the rest.
+func TestSubscribeAutoCommitAtLeastOnce(t *testing.T) {
+	defer cleanupStorage(t)
+
+	_, api, cleanup := setupAutoCommitTest(t, 5)
+	defer cleanup()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ack := func(offset int64) error { return ackAutoCommitTestMessage(ctx, api, offset) }
+
+	// Acks require an at-least-once subscription.
+	require.Equal(t, codes.NotFound, status.Code(ack(0)))
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	sub, err := subscribeAutoCommit(t, subCtx, api, autoCommitAtLeastOnce)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := sub.Recv()
+		require.NoError(t, err)
+	}
+
+	// Nothing is committed until the first message is acked.
+	require.NoError(t, ack(1))
+	require.Equal(t, int64(-1), fetchAutoCommitCursor(t, ctx, api))
+	require.NoError(t, ack(0))
+	require.Equal(t, int64(1), fetchAutoCommitCursor(t, ctx, api))
+	require.NoError(t, ack(3))
+	require.Equal(t, int64(1), fetchAutoCommitCursor(t, ctx, api))
+
+	// Acking a committed message has no effect, unknown messages can't be
+	// acked.
+	require.NoError(t, ack(0))
+	require.Equal(t, codes.FailedPrecondition, status.Code(ack(10)))
+	require.Equal(t, codes.InvalidArgument, status.Code(ack(-1)))
+
+	// Messages which were not committed are redelivered.
+	subCancel()
+	require.Eventually(t, func() bool {
+		sub, err = subscribeAutoCommit(t, ctx, api, autoCommitAtLeastOnce)
+		return status.Code(err) != codes.AlreadyExists
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), msg.Offset)
+}
+
+// Ensure auto-committing subscriptions cannot deliver by priority and commit
+// compressed batches by the offset of their last message.
+func TestSubscribeAutoCommitOptions(t *testing.T) {
+	defer cleanupStorage(t)
+
+	_, api, cleanup := setupAutoCommitTest(t, 5)
+	defer cleanup()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := subscribeAutoCommit(t, ctx, api, autoCommitAtLeastOnce, PriorityWindowMetadata, "10")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = subscribeAutoCommit(t, ctx, api, autoCommitAtMostOnce, PriorityWindowMetadata, "10")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	sub, err := subscribeAutoCommit(t, ctx, api, autoCommitAtLeastOnce, AcceptEncodingMetadata, "gzip")
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "5", string(msg.Headers[BatchCountHeader]))
+	require.Equal(t, int64(4), msg.Offset)
+
+	// Messages in the batch are committed with it.
+	require.Equal(t, codes.FailedPrecondition, status.Code(ackAutoCommitTestMessage(ctx, api, 2)))
+	require.NoError(t, ackAutoCommitTestMessage(ctx, api, 4))
+	require.Equal(t, int64(4), fetchAutoCommitCursor(t, ctx, api))
+}
+
+// Ensure the auto-committed cursor only moves forward when messages are
+// delivered or acked out of order.
+func TestAutoCommitterOutOfOrder(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s, api, cleanup := setupAutoCommitTest(t, 0)
+	defer cleanup()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	partition := s.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+
+	c, st := partition.addAutoCommit(ctx, s.cursors, "abc", true)
+	require.Nil(t, st)
+	for _, offset := range []int64{3, 1, 2, 0} {
+		require.Nil(t, c.delivered(ctx, offset))
+	}
+	require.Equal(t, []int64{0, 1, 2, 3}, c.pending)
+	require.Nil(t, c.ack(ctx, 1))
+	require.Nil(t, c.ack(ctx, 0))
+	require.Equal(t, int64(1), fetchAutoCommitCursor(t, ctx, api))
+	require.Nil(t, c.ack(ctx, 3))
+	require.Nil(t, c.ack(ctx, 2))
+	require.Equal(t, int64(3), fetchAutoCommitCursor(t, ctx, api))
+	partition.removeAutoCommit(c)
+
+	c, st = partition.addAutoCommit(ctx, s.cursors, "abc", false)
+	require.Nil(t, st)
+	require.Nil(t, c.delivered(ctx, 5))
+	require.Nil(t, c.delivered(ctx, 4))
+	require.Equal(t, int64(5), fetchAutoCommitCursor(t, ctx, api))
+}
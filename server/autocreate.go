@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// autoCreateStreamPollInterval is how often to check if an auto-created
+// stream has been applied to the local metadata store.
+const autoCreateStreamPollInterval = 10 * time.Millisecond
+
+// autoCreateStream creates the stream a message is being published to if it
+// does not exist and streams.auto.create.enabled is set. The stream name must
+// match the configured naming policy and, if a list of clients is configured,
+// the publisher must have authenticated with a TLS client certificate whose
+// common name is in the list. Otherwise, the publish fails as if auto-creation
+// were disabled.
+func (a *apiServer) autoCreateStream(ctx context.Context, name string) *client.PublishAsyncError {
+	config := a.config.StreamsAutoCreate
+	if !config.Enabled || name == "" || a.metadata.GetStream(name) != nil {
+		return nil
+	}
+	if reason := autoCreateStreamDenied(ctx, config, name); reason != "" {
+		a.logger.Debugf("api: Not auto-creating stream %s: %s", name, reason)
+		return &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_NOT_FOUND,
+			Message: fmt.Sprintf("no such stream: %s (%s)", name, reason),
+		}
+	}
+	if !isValidSubject(name) {
+		return &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_NOT_FOUND,
+			Message: fmt.Sprintf("no such stream: %s (name is not a valid subject)", name),
+		}
+	}
+
+	partitions := make([]*proto.Partition, config.Partitions)
+	for i := int32(0); i < config.Partitions; i++ {
+		partitions[i] = &proto.Partition{
+			Subject:           name,
+			Stream:            name,
+			ReplicationFactor: config.ReplicationFactor,
+			Id:                i,
+		}
+	}
+	stream := &proto.Stream{
+		Name:       name,
+		Subject:    name,
+		Partitions: partitions,
+		Config:     config.streamConfig(),
+	}
+
+	ctx, cancel := ensureTimeout(ctx, defaultPropagateTimeout)
+	defer cancel()
+
+	// If another publisher created the stream concurrently, use it.
+	if st := a.metadata.CreateStream(ctx, &proto.CreateStreamOp{Stream: stream}); st != nil &&
+		st.Code() != codes.AlreadyExists {
+		a.logger.Errorf("api: Failed to auto-create stream %s: %v", name, st.Err())
+		return &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_INTERNAL,
+			Message: fmt.Sprintf("failed to auto-create stream %s: %s", name, st.Message()),
+		}
+	}
+	a.logger.Infof("api: Auto-created stream %s", name)
+
+	// If the stream was created by the metadata leader on behalf of this
+	// server, wait for it to be applied locally before publishing.
+	ticker := time.NewTicker(autoCreateStreamPollInterval)
+	defer ticker.Stop()
+	for a.metadata.GetStream(name) == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return &client.PublishAsyncError{
+				Code:    client.PublishAsyncError_INTERNAL,
+				Message: fmt.Sprintf("timed out waiting for auto-created stream %s", name),
+			}
+		}
+	}
+	return nil
+}
+
+// autoCreateStreamDenied returns the reason the publisher is not allowed to
+// auto-create the named stream, or an empty string if it is allowed.
+func autoCreateStreamDenied(ctx context.Context, config StreamsAutoCreateConfig, name string) string {
+	if config.NamePattern != nil && !config.NamePattern.MatchString(name) {
+		return "name does not match auto-create naming policy"
+	}
+	if len(config.Clients) == 0 {
+		return ""
+	}
+	commonName := clientCommonName(ctx)
+	for _, allowed := range config.Clients {
+		if commonName != "" && commonName == allowed {
+			return ""
+		}
+	}
+	return "client is not allowed to auto-create streams"
+}
+
+// clientCommonName returns the common name of the verified TLS client
+// certificate the request was made with, if any.
+func clientCommonName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ""
+	}
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return ""
+	}
+	return chains[0][0].Subject.CommonName
+}
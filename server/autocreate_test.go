@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"regexp"
+	"testing"
+	"time"
+
+	proto "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// clientCertContext returns a Context for a request made with a verified TLS
+// client certificate with the given common name.
+func clientCertContext(commonName string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			},
+		},
+	})
+}
+
+// Ensure stream auto-creation is gated by the naming policy and the list of
+// allowed clients.
+func TestAutoCreateStreamDenied(t *testing.T) {
+	config := StreamsAutoCreateConfig{
+		Enabled:     true,
+		NamePattern: regexp.MustCompile(`^(?:events\..+)$`),
+	}
+	require.Empty(t, autoCreateStreamDenied(context.Background(), config, "events.foo"))
+	require.NotEmpty(t, autoCreateStreamDenied(context.Background(), config, "foo"))
+
+	config.Clients = []string{"publisher"}
+	require.Empty(t, autoCreateStreamDenied(clientCertContext("publisher"), config, "events.foo"))
+	require.NotEmpty(t, autoCreateStreamDenied(clientCertContext("publisher"), config, "foo"))
+	require.NotEmpty(t, autoCreateStreamDenied(clientCertContext("other"), config, "events.foo"))
+	require.NotEmpty(t, autoCreateStreamDenied(context.Background(), config, "events.foo"))
+
+	// Unverified certificates are not trusted.
+	unverified := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{
+					{Subject: pkix.Name{CommonName: "publisher"}},
+				},
+			},
+		},
+	})
+	require.NotEmpty(t, autoCreateStreamDenied(unverified, config, "events.foo"))
+}
+
+// Ensure publishing to a nonexistent stream creates it with the configured
+// defaults when auto-creation is enabled and the name matches the naming
+// policy.
+func TestAutoCreateStreamOnPublish(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.StreamsAutoCreate.Enabled = true
+	s1Config.StreamsAutoCreate.Partitions = 2
+	s1Config.StreamsAutoCreate.RetentionMaxMessages = 10
+	s1Config.StreamsAutoCreate.NamePattern = regexp.MustCompile(`^(?:events\..+)$`)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := proto.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = api.Publish(ctx, &proto.PublishRequest{
+		Stream:    "events.foo",
+		Value:     []byte("hello"),
+		AckPolicy: proto.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+
+	metaResp, err := api.FetchMetadata(ctx, &proto.FetchMetadataRequest{Streams: []string{"events.foo"}})
+	require.NoError(t, err)
+	require.Equal(t, proto.StreamMetadata_OK, metaResp.Metadata[0].Error)
+	require.Equal(t, "events.foo", metaResp.Metadata[0].Subject)
+	require.Len(t, metaResp.Metadata[0].Partitions, 2)
+
+	stream := s1.metadata.GetStream("events.foo")
+	require.NotNil(t, stream)
+	require.Equal(t, int64(10), stream.GetConfig().RetentionMaxMessages.Value)
+
+	// Publishing again uses the existing stream.
+	_, err = api.Publish(ctx, &proto.PublishRequest{
+		Stream:    "events.foo",
+		Partition: 1,
+		Value:     []byte("world"),
+		AckPolicy: proto.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+
+	// Names which don't match the naming policy are not created.
+	_, err = api.Publish(ctx, &proto.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("hello"),
+		AckPolicy: proto.AckPolicy_ALL,
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Nil(t, s1.metadata.GetStream("foo"))
+}
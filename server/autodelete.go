@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// AutoDeleteHeader is the header set on the activity stream event published
+// before a stream is automatically deleted for having been paused longer than
+// the auto delete time. The event is a DELETE_STREAM event with ID 0 since it
+// does not correspond to a Raft operation. The usual DELETE_STREAM event
+// follows once the stream is deleted.
+const AutoDeleteHeader = "Liftbridge-Auto-Delete"
+
+// maxAutoDeleteInterval bounds how often the metadata leader checks for
+// streams to automatically delete.
+const maxAutoDeleteInterval = time.Minute
+
+// autoDeleteLoop is a long-running loop which runs while the server is the
+// metadata leader. It deletes streams whose partitions have all been paused
+// for longer than the auto delete time. Internal streams are never deleted.
+func (s *Server) autoDeleteLoop(stop <-chan struct{}) {
+	var (
+		autoDeleteTime = s.config.Streams.AutoDeleteTime
+		interval       = autoDeleteTime / 2
+		// Time this server first saw each stream paused, used for
+		// partitions paused before it started.
+		pausedSeen = make(map[string]time.Time)
+	)
+	if interval > maxAutoDeleteInterval {
+		interval = maxAutoDeleteInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		paused := make(map[string]time.Time)
+		for _, stream := range s.metadata.GetStreams() {
+			name := stream.GetName()
+			if isInternalStream(name) {
+				continue
+			}
+			since, ok := streamPausedSince(stream)
+			if !ok {
+				continue
+			}
+			seen, ok := pausedSeen[name]
+			if !ok {
+				seen = now
+			}
+			paused[name] = seen
+			if since.IsZero() {
+				since = seen
+			}
+			if now.Sub(since) <= autoDeleteTime {
+				continue
+			}
+			s.logger.Infof("Stream %s has been paused for over %s, auto deleting stream",
+				name, autoDeleteTime)
+			s.publishAutoDeleteEvent(name)
+			if e := s.metadata.DeleteStream(context.Background(), &proto.DeleteStreamOp{
+				Stream: name,
+			}); e != nil {
+				s.logger.Errorf("Failed to auto delete stream %s: %v", name, e.Err())
+			}
+		}
+		pausedSeen = paused
+	}
+}
+
+// streamPausedSince returns the latest time a partition of the stream was
+// paused if all of its partitions are paused. The time is zero if it is not
+// known, e.g. because the partitions were paused before the server started.
+// It returns false if any partition is not paused.
+func streamPausedSince(stream *stream) (time.Time, bool) {
+	var (
+		since   time.Time
+		unknown bool
+	)
+	for _, partition := range stream.GetPartitions() {
+		if !partition.IsPaused() {
+			return time.Time{}, false
+		}
+		latest := partition.PauseTimestamps().latestTime
+		if latest.IsZero() {
+			// Keep checking the remaining partitions are paused.
+			unknown = true
+			continue
+		}
+		if latest.After(since) {
+			since = latest
+		}
+	}
+	if unknown {
+		return time.Time{}, true
+	}
+	return since, true
+}
+
+// isInternalStream indicates if the stream is one Liftbridge creates for its
+// own use.
+func isInternalStream(name string) bool {
+	switch name {
+	case activityStream, cursorsStream, schedulesStream, canaryStream:
+		return true
+	}
+	return false
+}
+
+// publishAutoDeleteEvent publishes an event to the activity stream, if it's
+// enabled, indicating the stream is about to be automatically deleted.
+func (s *Server) publishAutoDeleteEvent(name string) {
+	if !s.config.ActivityStream.Enabled {
+		return
+	}
+	event := &client.ActivityStreamEvent{
+		Op:             client.ActivityStreamOp_DELETE_STREAM,
+		DeleteStreamOp: &client.DeleteStreamOp{Stream: name},
+	}
+	data, err := event.Marshal()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ActivityStream.PublishTimeout)
+	defer cancel()
+
+	if _, err := s.api.Publish(ctx, &client.PublishRequest{
+		Value:     data,
+		Stream:    activityStream,
+		Headers:   map[string][]byte{AutoDeleteHeader: []byte(s.config.Streams.AutoDeleteTime.String())},
+		AckPolicy: s.config.ActivityStream.PublishAckPolicy,
+	}); err != nil {
+		s.logger.Errorf("Failed to publish auto delete event for stream %s: %v", name, err)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure streams whose partitions have all been paused for longer than the
+// auto delete time are deleted and an activity event is published first.
+func TestStreamAutoDelete(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.AutoDeleteTime = 500 * time.Millisecond
+	s1Config.ActivityStream.Enabled = true
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "bar", Name: "bar", Partitions: 2})
+	require.NoError(t, err)
+
+	// Pause all of foo's partitions but only some of bar's.
+	_, err = api.PauseStream(ctx, &client.PauseStreamRequest{Name: "foo"})
+	require.NoError(t, err)
+	_, err = api.PauseStream(ctx, &client.PauseStreamRequest{Name: "bar", Partitions: []int32{0}})
+	require.NoError(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s1.metadata.GetStream("foo") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Paused stream was not auto deleted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.NotNil(t, s1.metadata.GetStream("bar"))
+
+	// The auto delete event precedes the stream's deletion.
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        activityStream,
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	autoDeleted := false
+	for {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		event := new(client.ActivityStreamEvent)
+		require.NoError(t, event.Unmarshal(msg.Value))
+		if event.Op != client.ActivityStreamOp_DELETE_STREAM {
+			continue
+		}
+		require.Equal(t, "foo", event.DeleteStreamOp.Stream)
+		if _, ok := msg.Headers[AutoDeleteHeader]; ok {
+			require.Equal(t, uint64(0), event.Id)
+			autoDeleted = true
+			continue
+		}
+		require.True(t, autoDeleted)
+		require.NotZero(t, event.Id)
+		break
+	}
+}
+
+// Ensure a stream is only considered paused if all of its partitions are
+// paused, even if the time a partition was paused is not known, and the time
+// is zero if it's not known for any partition.
+func TestStreamPausedSince(t *testing.T) {
+	newPartition := func(id int32, paused bool, pausedAt time.Time) *partition {
+		p := &partition{Partition: &proto.Partition{Id: id}, paused: paused}
+		p.pauseTimestamps.latestTime = pausedAt
+		return p
+	}
+	now := time.Now()
+
+	stream := newStream("foo", "foo", &proto.StreamConfig{}, now)
+	stream.SetPartition(0, newPartition(0, true, time.Time{}))
+	stream.SetPartition(1, newPartition(1, false, time.Time{}))
+	// Partitions are iterated in random order, so check repeatedly.
+	for i := 0; i < 100; i++ {
+		_, ok := streamPausedSince(stream)
+		require.False(t, ok)
+	}
+
+	stream.SetPartition(1, newPartition(1, true, now))
+	stream.SetPartition(2, newPartition(2, true, now.Add(-time.Minute)))
+	for i := 0; i < 100; i++ {
+		since, ok := streamPausedSince(stream)
+		require.True(t, ok)
+		require.True(t, since.IsZero())
+	}
+
+	stream.SetPartition(0, newPartition(0, true, now.Add(-time.Hour)))
+	since, ok := streamPausedSince(stream)
+	require.True(t, ok)
+	require.Equal(t, now, since)
+}
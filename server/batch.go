@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// BatchMetadata is the Publish and PublishAsync request metadata key which, if
+// "true", causes the value of each PublishRequest to be published as an
+// atomic batch of messages. The value must contain the messages serialized
+// with protocol.MarshalBatch, i.e. Message protobufs each prefixed with its
+// size as a varint, of which only the key, value, and headers are used.
+//
+// A batch is appended to the partition all-or-nothing and is exposed to
+// subscribers only once all of it is committed, including across leader
+// failovers. The batch is acked once, with the offset of its last message, and
+// the request's ExpectedOffset applies to its first message. A batch is
+// deduplicated by the message ID of its last message.
+const BatchMetadata = "liftbridge-batch"
+
+// batchFromContext indicates if the incoming request metadata publishes
+// atomic batches.
+func batchFromContext(ctx context.Context) (bool, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false, nil
+	}
+	values := md.Get(BatchMetadata)
+	if len(values) == 0 {
+		return false, nil
+	}
+	batch, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", BatchMetadata, values[0]))
+	}
+	return batch, nil
+}
+
+// marshalPublishRequest serializes the message published with the given
+// request into the Liftbridge envelope wire format. If batch is true, the
+// request's value is decoded as an atomic batch of messages instead. Only the
+// last message of a batch is acked. The envelope is serialized in a pooled
+// buffer, which publishEnvelope returns to the pool once it's been published.
+func marshalPublishRequest(req *client.PublishRequest, subject string, batch bool) (
+	[]byte, *client.PublishAsyncError) {
+
+	if !batch {
+		msg := getPooledMessage()
+		defer putPooledMessage(msg)
+		msg.Key = req.Key
+		msg.Value = req.Value
+		msg.Stream = req.Stream
+		msg.Subject = subject
+		msg.Headers = req.Headers
+		msg.AckInbox = req.AckInbox
+		msg.CorrelationId = req.CorrelationId
+		msg.AckPolicy = req.AckPolicy
+		msg.Offset = req.ExpectedOffset
+		buf, err := proto.AppendPublish(getEnvelopeBuffer(), msg)
+		if err != nil {
+			return nil, &client.PublishAsyncError{
+				Code:    client.PublishAsyncError_INTERNAL,
+				Message: fmt.Sprintf("failed to marshal message: %v", err),
+			}
+		}
+		return buf, nil
+	}
+
+	msgs, err := proto.UnmarshalBatch(req.Value)
+	if err != nil {
+		return nil, &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_BAD_REQUEST,
+			Message: fmt.Sprintf("invalid batch: %v", err),
+		}
+	}
+	if len(msgs) == 0 {
+		return nil, &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_BAD_REQUEST,
+			Message: "empty batch",
+		}
+	}
+	for i, m := range msgs {
+		batchMsg := &client.Message{
+			Key:     m.Key,
+			Value:   m.Value,
+			Stream:  req.Stream,
+			Subject: subject,
+			Headers: m.Headers,
+			Offset:  -1,
+		}
+		if i == 0 {
+			batchMsg.Offset = req.ExpectedOffset
+		}
+		if i == len(msgs)-1 {
+			batchMsg.AckInbox = req.AckInbox
+			batchMsg.CorrelationId = req.CorrelationId
+			batchMsg.AckPolicy = req.AckPolicy
+		}
+		msgs[i] = batchMsg
+	}
+	buf, err := proto.AppendPublishBatch(getEnvelopeBuffer(), msgs)
+	if err != nil {
+		return nil, &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_INTERNAL,
+			Message: fmt.Sprintf("failed to marshal batch: %v", err),
+		}
+	}
+	return buf, nil
+}
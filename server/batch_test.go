@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure marshalPublishRequest acks only the last message of a batch and
+// applies the expected offset to its first message.
+func TestMarshalPublishRequestBatch(t *testing.T) {
+	value, err := proto.MarshalBatch([]*client.Message{
+		{Key: []byte("a"), Value: []byte("one")},
+		{Value: []byte("two"), Headers: map[string][]byte{"foo": []byte("bar")}},
+		{Value: []byte("three")},
+	})
+	require.NoError(t, err)
+	req := &client.PublishRequest{
+		Stream:         "foo",
+		Value:          value,
+		AckInbox:       "ack",
+		CorrelationId:  "123",
+		AckPolicy:      client.AckPolicy_ALL,
+		ExpectedOffset: 5,
+	}
+
+	buf, e := marshalPublishRequest(req, "foo", true)
+	require.Nil(t, e)
+	msgs, err := proto.UnmarshalPublishBatch(buf)
+	require.NoError(t, err)
+	require.Len(t, msgs, 3)
+	require.Equal(t, []byte("a"), msgs[0].Key)
+	require.Equal(t, []byte("one"), msgs[0].Value)
+	require.Equal(t, int64(5), msgs[0].Offset)
+	require.Equal(t, "", msgs[0].AckInbox)
+	require.Equal(t, []byte("bar"), msgs[1].Headers["foo"])
+	require.Equal(t, int64(-1), msgs[1].Offset)
+	require.Equal(t, int64(-1), msgs[2].Offset)
+	require.Equal(t, "ack", msgs[2].AckInbox)
+	require.Equal(t, "123", msgs[2].CorrelationId)
+	require.Equal(t, client.AckPolicy_ALL, msgs[2].AckPolicy)
+
+	// Without batching, the value is published as is.
+	buf, e = marshalPublishRequest(req, "foo", false)
+	require.Nil(t, e)
+	msg, err := proto.UnmarshalPublish(buf)
+	require.NoError(t, err)
+	require.Equal(t, value, msg.Value)
+
+	req.Value = []byte("foo")
+	_, e = marshalPublishRequest(req, "foo", true)
+	require.Equal(t, client.PublishAsyncError_BAD_REQUEST, e.Code)
+
+	req.Value = nil
+	_, e = marshalPublishRequest(req, "foo", true)
+	require.Equal(t, client.PublishAsyncError_BAD_REQUEST, e.Code)
+}
+
+// Ensure a batch published in one call is appended as a whole, is acked once
+// with the offset of its last message, and records its boundaries in the log.
+func TestPublishBatch(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("zero"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+
+	value, err := proto.MarshalBatch([]*client.Message{
+		{Value: []byte("one")},
+		{Value: []byte("two")},
+		{Value: []byte("three")},
+	})
+	require.NoError(t, err)
+	batchCtx := metadata.AppendToOutgoingContext(ctx, BatchMetadata, "true")
+	resp, err := api.Publish(batchCtx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     value,
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), resp.Ack.Offset)
+
+	_, err = api.Publish(batchCtx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("foo"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Batches can also be published asynchronously.
+	value, err = proto.MarshalBatch([]*client.Message{
+		{Value: []byte("four")},
+		{Value: []byte("five")},
+	})
+	require.NoError(t, err)
+	stream, err := api.PublishAsync(batchCtx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&client.PublishRequest{
+		Stream:        "foo",
+		Value:         value,
+		CorrelationId: "abc",
+		AckPolicy:     client.AckPolicy_ALL,
+	}))
+	asyncResp, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "abc", asyncResp.Ack.CorrelationId)
+	require.Equal(t, int64(5), asyncResp.Ack.Offset)
+	require.NoError(t, stream.CloseSend())
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	for i, expected := range []string{"zero", "one", "two", "three", "four", "five"} {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, int64(i), msg.Offset)
+		require.Equal(t, []byte(expected), msg.Value)
+	}
+
+	// Ensure batch boundaries are recorded in the log.
+	partition := s1.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+	reader, err := partition.log.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, continues := range []bool{false, true, true, false, true, false} {
+		msg, _, _, _, err := reader.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, continues, msg.BatchContinues())
+	}
+}
+
+// Ensure batches are replicated whole with their boundaries.
+func TestPublishBatchReplicated(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Clustering.ReplicationMaxBytes = 200
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	s2Config := getTestConfig("b", false, 5051)
+	s2Config.Clustering.ReplicationMaxBytes = 200
+	s2 := runServerWithConfig(t, s2Config)
+	defer s2.Stop()
+	getMetadataLeader(t, 10*time.Second, s1, s2)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	waitForISR(t, 10*time.Second, "foo", 0, 2, s1, s2)
+
+	leader := getPartitionLeader(t, 10*time.Second, "foo", 0, s1, s2)
+	if leader == s2 {
+		conn, err = grpc.Dial("localhost:5051", grpc.WithInsecure())
+		require.NoError(t, err)
+		defer conn.Close()
+		api = client.NewAPIClient(conn)
+	}
+
+	// Publish batches which don't fit in a single replication response
+	// together.
+	batchCtx := metadata.AppendToOutgoingContext(ctx, BatchMetadata, "true")
+	for i := 0; i < 3; i++ {
+		value, err := proto.MarshalBatch([]*client.Message{
+			{Value: []byte("one")},
+			{Value: []byte("two")},
+		})
+		require.NoError(t, err)
+		_, err = api.Publish(batchCtx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     value,
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+	waitForHW(t, 10*time.Second, "foo", 0, 5, s1, s2)
+
+	for _, s := range []*Server{s1, s2} {
+		partition := s.metadata.GetPartition("foo", 0)
+		reader, err := partition.log.NewReader(0, false)
+		require.NoError(t, err)
+		headers := make([]byte, 28)
+		for i := 0; i < 6; i++ {
+			msg, offset, _, _, err := reader.ReadMessage(ctx, headers)
+			require.NoError(t, err)
+			require.Equal(t, int64(i), offset)
+			require.Equal(t, i%2 == 0, msg.BatchContinues())
+		}
+	}
+}
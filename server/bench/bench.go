@@ -0,0 +1,407 @@
+// Package bench drives publish and subscribe load against a Liftbridge cluster
+// and measures throughput and latency.
+package bench
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// timestampLen is the length of the publish timestamp written at the start of
+// each message value to measure end-to-end latency.
+const timestampLen = 8
+
+// Options contains settings for a benchmark.
+type Options struct {
+	// Stream is the stream to publish to. It's created if it doesn't exist.
+	Stream string
+
+	// Partitions is the number of partitions of the stream if it's created.
+	// Messages are published to every partition of the stream in turn.
+	Partitions int32
+
+	// ReplicationFactor is the replication factor of the stream if it's
+	// created. -1 replicates the stream to every server.
+	ReplicationFactor int32
+
+	// MessageSize is the size of message values in bytes. Values are at least
+	// 8 bytes long since they start with the publish timestamp.
+	MessageSize int
+
+	// Messages is the number of messages to publish. If it's 0, messages are
+	// published until Duration elapses.
+	Messages int64
+
+	// Duration is the time to publish for if Messages is 0.
+	Duration time.Duration
+
+	// Rate is the number of messages to publish per second across all
+	// publishers. If it's 0, messages are published as fast as possible.
+	Rate int
+
+	// Publishers is the number of concurrent publishers. Each waits for a
+	// message to be acked before publishing the next one.
+	Publishers int
+
+	// AckPolicy is the ack policy of published messages.
+	AckPolicy client.AckPolicy
+
+	// Subscribe subscribes to every partition of the stream to measure the
+	// time from publishing messages until they're delivered.
+	Subscribe bool
+
+	// DrainTimeout is how long to wait for subscriptions to receive the
+	// published messages once publishing has finished.
+	DrainTimeout time.Duration
+}
+
+// Latencies summarizes a latency distribution.
+type Latencies struct {
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	P999  time.Duration
+	Max   time.Duration
+}
+
+// Result is the outcome of a benchmark.
+type Result struct {
+	// Published is the number of messages published and Received the number
+	// delivered to subscriptions.
+	Published int64
+	Received  int64
+
+	// Duration is the time spent publishing.
+	Duration time.Duration
+
+	// PublishLatency is the time for Publish requests to return, i.e. until
+	// messages are acked according to the ack policy.
+	PublishLatency Latencies
+
+	// EndToEndLatency is the time from publishing messages until they're
+	// delivered to subscriptions.
+	EndToEndLatency Latencies
+}
+
+// MessagesPerSecond returns the publish throughput in messages per second.
+func (r *Result) MessagesPerSecond() float64 {
+	return float64(r.Published) / r.Duration.Seconds()
+}
+
+// BytesPerSecond returns the publish throughput of message values in bytes
+// per second.
+func (r *Result) BytesPerSecond(messageSize int) float64 {
+	return r.MessagesPerSecond() * float64(messageSize)
+}
+
+// Bench runs a benchmark against a Liftbridge cluster.
+type Bench struct {
+	apis []client.APIClient
+	opts Options
+}
+
+// New creates a Bench which sends requests to the Liftbridge cluster using
+// the given API clients. Publishers are spread across the clients and
+// subscriptions are made through the client connected to each partition's
+// leader, so a client should be provided for every server in the cluster.
+func New(apis []client.APIClient, opts Options) (*Bench, error) {
+	if opts.Stream == "" {
+		return nil, errors.New("no stream provided")
+	}
+	if len(apis) == 0 {
+		return nil, errors.New("no Liftbridge API clients provided")
+	}
+	if opts.Messages <= 0 && opts.Duration <= 0 {
+		return nil, errors.New("either a number of messages or a duration is required")
+	}
+	if opts.Partitions <= 0 {
+		opts.Partitions = 1
+	}
+	if opts.ReplicationFactor == 0 {
+		opts.ReplicationFactor = 1
+	}
+	if opts.MessageSize < timestampLen {
+		opts.MessageSize = timestampLen
+	}
+	if opts.Publishers <= 0 {
+		opts.Publishers = 1
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = 10 * time.Second
+	}
+	return &Bench{apis: apis, opts: opts}, nil
+}
+
+// Run creates the stream if needed, subscribes to it if enabled, and
+// publishes messages until the configured number has been published or the
+// duration has elapsed. It returns once the subscriptions have received the
+// published messages or the drain timeout has elapsed. If the context is
+// canceled, the benchmark stops early and the result covers the messages
+// published until then.
+func (b *Bench) Run(ctx context.Context) (*Result, error) {
+	partitions, err := b.ensureStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		received int64
+		e2e      = make([][]time.Duration, partitions)
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+	if b.opts.Subscribe {
+		for partition := int32(0); partition < partitions; partition++ {
+			sub, err := b.subscribe(ctx, partition)
+			if err != nil {
+				return nil, err
+			}
+			wg.Add(1)
+			go func(partition int32) {
+				defer wg.Done()
+				for {
+					msg, err := sub.Recv()
+					if err != nil {
+						if ctx.Err() == nil {
+							fail(errors.Wrapf(err, "subscription to partition %d failed", partition))
+						}
+						return
+					}
+					if len(msg.Value) < timestampLen {
+						continue
+					}
+					sent := int64(binary.BigEndian.Uint64(msg.Value))
+					e2e[partition] = append(e2e[partition], time.Duration(time.Now().UnixNano()-sent))
+					atomic.AddInt64(&received, 1)
+				}
+			}(partition)
+		}
+	}
+
+	var (
+		pubWg     sync.WaitGroup
+		seq       int64
+		published int64
+		pubLat    = make([][]time.Duration, b.opts.Publishers)
+		start     = time.Now()
+		deadline  time.Time
+		interval  time.Duration
+	)
+	if b.opts.Messages <= 0 {
+		deadline = start.Add(b.opts.Duration)
+	}
+	if b.opts.Rate > 0 {
+		interval = time.Second / time.Duration(b.opts.Rate)
+	}
+	for i := 0; i < b.opts.Publishers; i++ {
+		pubWg.Add(1)
+		go func(publisher int) {
+			defer pubWg.Done()
+			api := b.apis[publisher%len(b.apis)]
+			value := make([]byte, b.opts.MessageSize)
+			for ctx.Err() == nil {
+				n := atomic.AddInt64(&seq, 1) - 1
+				if b.opts.Messages > 0 && n >= b.opts.Messages {
+					return
+				}
+				if interval > 0 {
+					if !sleepUntil(ctx, start.Add(time.Duration(n)*interval)) {
+						return
+					}
+				}
+				if !deadline.IsZero() && !time.Now().Before(deadline) {
+					return
+				}
+				sent := time.Now()
+				binary.BigEndian.PutUint64(value, uint64(sent.UnixNano()))
+				resp, err := api.Publish(ctx, &client.PublishRequest{
+					Stream:    b.opts.Stream,
+					Partition: int32(n % int64(partitions)),
+					Value:     value,
+					AckPolicy: b.opts.AckPolicy,
+				})
+				if err == nil && resp.Ack != nil && resp.Ack.AckError != client.Ack_OK {
+					err = errors.New(resp.Ack.AckError.String())
+				}
+				if err != nil {
+					if ctx.Err() == nil {
+						fail(errors.Wrap(err, "failed to publish"))
+					}
+					return
+				}
+				pubLat[publisher] = append(pubLat[publisher], time.Since(sent))
+				atomic.AddInt64(&published, 1)
+			}
+		}(i)
+	}
+	pubWg.Wait()
+	result := &Result{
+		Published:      published,
+		Duration:       time.Since(start),
+		PublishLatency: summarize(pubLat),
+	}
+
+	if b.opts.Subscribe {
+		b.drain(ctx, &received, published)
+		cancel()
+		wg.Wait()
+		result.Received = atomic.LoadInt64(&received)
+		result.EndToEndLatency = summarize(e2e)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+// ensureStream creates the stream if it does not exist and waits for each of
+// its partitions to have a leader. It returns the number of partitions of the
+// stream.
+func (b *Bench) ensureStream(ctx context.Context) (int32, error) {
+	_, err := b.apis[0].CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           b.opts.Stream,
+		Name:              b.opts.Stream,
+		Partitions:        b.opts.Partitions,
+		ReplicationFactor: b.opts.ReplicationFactor,
+	})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return 0, errors.Wrap(err, "failed to create stream")
+	}
+	for {
+		resp, err := b.apis[0].FetchMetadata(ctx, &client.FetchMetadataRequest{
+			Streams: []string{b.opts.Stream},
+		})
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to fetch stream metadata")
+		}
+		for _, stream := range resp.Metadata {
+			if stream.Name != b.opts.Stream || len(stream.Partitions) == 0 {
+				continue
+			}
+			ready := true
+			for _, partition := range stream.Partitions {
+				if partition.Leader == "" {
+					ready = false
+				}
+			}
+			if ready {
+				return int32(len(stream.Partitions)), nil
+			}
+		}
+		if !sleepUntil(ctx, time.Now().Add(100*time.Millisecond)) {
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// subscribe subscribes to new messages in the given partition through the
+// client connected to its leader.
+func (b *Bench) subscribe(ctx context.Context, partition int32) (client.API_SubscribeClient, error) {
+	var err error
+	for _, api := range b.apis {
+		var sub client.API_SubscribeClient
+		sub, err = api.Subscribe(ctx, &client.SubscribeRequest{
+			Stream:        b.opts.Stream,
+			Partition:     partition,
+			StartPosition: client.StartPosition_NEW_ONLY,
+		})
+		if err == nil {
+			// The server sends an empty message once the subscription is
+			// created.
+			_, err = sub.Recv()
+		}
+		if err == nil {
+			return sub, nil
+		}
+		if status.Code(err) != codes.FailedPrecondition {
+			break
+		}
+	}
+	return nil, errors.Wrapf(err, "failed to subscribe to partition %d", partition)
+}
+
+// drain waits until the subscriptions have received the given number of
+// messages or the drain timeout elapses.
+func (b *Bench) drain(ctx context.Context, received *int64, expected int64) {
+	deadline := time.Now().Add(b.opts.DrainTimeout)
+	for atomic.LoadInt64(received) < expected && time.Now().Before(deadline) {
+		if !sleepUntil(ctx, time.Now().Add(10*time.Millisecond)) {
+			return
+		}
+	}
+}
+
+// sleepUntil waits until the given time and returns false if the context is
+// done first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// summarize merges the given samples and computes their distribution.
+func summarize(samples [][]time.Duration) Latencies {
+	var all []time.Duration
+	for _, s := range samples {
+		all = append(all, s...)
+	}
+	if len(all) == 0 {
+		return Latencies{}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	var sum time.Duration
+	for _, d := range all {
+		sum += d
+	}
+	percentile := func(p float64) time.Duration {
+		i := int(p*float64(len(all))+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		return all[i]
+	}
+	return Latencies{
+		Count: len(all),
+		Min:   all[0],
+		Mean:  sum / time.Duration(len(all)),
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		P999:  percentile(0.999),
+		Max:   all[len(all)-1],
+	}
+}
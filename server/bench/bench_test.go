@@ -0,0 +1,179 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCluster holds a stream with the given number of partitions which
+// delivers published messages to subscriptions.
+type fakeCluster struct {
+	mu         sync.Mutex
+	partitions int32
+	created    *client.CreateStreamRequest
+	published  map[int32]int
+	subs       map[int32]chan *client.Message
+	publishErr error
+}
+
+func newFakeCluster(partitions int32) *fakeCluster {
+	return &fakeCluster{
+		partitions: partitions,
+		published:  make(map[int32]int),
+		subs:       make(map[int32]chan *client.Message),
+	}
+}
+
+// fakeAPI is a Liftbridge API client connected to a server of a fakeCluster.
+// If notLeader is set, subscriptions are rejected as if sent to a follower.
+type fakeAPI struct {
+	client.APIClient
+	*fakeCluster
+	notLeader bool
+}
+
+func (f *fakeAPI) CreateStream(ctx context.Context, in *client.CreateStreamRequest, opts ...grpc.CallOption) (*client.CreateStreamResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = in
+	return &client.CreateStreamResponse{}, nil
+}
+
+func (f *fakeAPI) FetchMetadata(ctx context.Context, in *client.FetchMetadataRequest, opts ...grpc.CallOption) (*client.FetchMetadataResponse, error) {
+	partitions := make(map[int32]*client.PartitionMetadata, f.partitions)
+	for i := int32(0); i < f.partitions; i++ {
+		partitions[i] = &client.PartitionMetadata{Id: i, Leader: "a"}
+	}
+	return &client.FetchMetadataResponse{Metadata: []*client.StreamMetadata{{
+		Name:       in.Streams[0],
+		Partitions: partitions,
+	}}}, nil
+}
+
+func (f *fakeAPI) Publish(ctx context.Context, in *client.PublishRequest, opts ...grpc.CallOption) (*client.PublishResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.publishErr != nil {
+		return nil, f.publishErr
+	}
+	f.published[in.Partition]++
+	if sub, ok := f.subs[in.Partition]; ok {
+		sub <- &client.Message{Value: append([]byte{}, in.Value...)}
+	}
+	return &client.PublishResponse{Ack: &client.Ack{}}, nil
+}
+
+func (f *fakeAPI) Subscribe(ctx context.Context, in *client.SubscribeRequest, opts ...grpc.CallOption) (client.API_SubscribeClient, error) {
+	if f.notLeader {
+		return nil, status.Error(codes.FailedPrecondition, "Server not partition leader")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub := make(chan *client.Message, 1000)
+	sub <- &client.Message{}
+	f.subs[in.Partition] = sub
+	return &fakeSubscription{ctx: ctx, msgs: sub}, nil
+}
+
+type fakeSubscription struct {
+	grpc.ClientStream
+	ctx  context.Context
+	msgs chan *client.Message
+}
+
+func (f *fakeSubscription) Recv() (*client.Message, error) {
+	select {
+	case msg := <-f.msgs:
+		return msg, nil
+	case <-f.ctx.Done():
+		return nil, status.FromContextError(f.ctx.Err()).Err()
+	}
+}
+
+// Ensure the benchmark publishes the configured number of messages across the
+// partitions and measures publish and end-to-end latency.
+func TestBenchMessages(t *testing.T) {
+	cluster := newFakeCluster(3)
+	follower := &fakeAPI{fakeCluster: cluster, notLeader: true}
+	leader := &fakeAPI{fakeCluster: cluster}
+	b, err := New([]client.APIClient{follower, leader}, Options{
+		Stream:      "foo",
+		Partitions:  3,
+		MessageSize: 64,
+		Messages:    300,
+		Publishers:  4,
+		Subscribe:   true,
+	})
+	require.NoError(t, err)
+
+	result, err := b.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(300), result.Published)
+	require.Equal(t, int64(300), result.Received)
+	require.Equal(t, 300, result.PublishLatency.Count)
+	require.Equal(t, 300, result.EndToEndLatency.Count)
+	require.True(t, result.PublishLatency.Max >= result.PublishLatency.P99)
+	require.True(t, result.MessagesPerSecond() > 0)
+
+	require.Equal(t, "foo", cluster.created.Name)
+	require.Equal(t, int32(3), cluster.created.Partitions)
+	require.Equal(t, int32(1), cluster.created.ReplicationFactor)
+	for partition := int32(0); partition < 3; partition++ {
+		require.Equal(t, 100, cluster.published[partition])
+	}
+}
+
+// Ensure the benchmark publishes at the configured rate for the duration.
+func TestBenchDurationRate(t *testing.T) {
+	cluster := newFakeCluster(1)
+	b, err := New([]client.APIClient{&fakeAPI{fakeCluster: cluster}}, Options{
+		Stream:     "foo",
+		Duration:   500 * time.Millisecond,
+		Rate:       100,
+		Publishers: 2,
+	})
+	require.NoError(t, err)
+
+	result, err := b.Run(context.Background())
+	require.NoError(t, err)
+	require.True(t, result.Published >= 40 && result.Published <= 51, result.Published)
+	require.True(t, result.Duration >= 500*time.Millisecond)
+}
+
+// Ensure the benchmark fails if publishing fails.
+func TestBenchPublishError(t *testing.T) {
+	cluster := newFakeCluster(1)
+	cluster.publishErr = errors.New("boom")
+	b, err := New([]client.APIClient{&fakeAPI{fakeCluster: cluster}}, Options{Stream: "foo", Messages: 10})
+	require.NoError(t, err)
+
+	_, err = b.Run(context.Background())
+	require.Error(t, err)
+}
+
+func TestSummarize(t *testing.T) {
+	var samples []time.Duration
+	for i := 1; i <= 1000; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	l := summarize([][]time.Duration{samples[500:], samples[:500]})
+	require.Equal(t, 1000, l.Count)
+	require.Equal(t, time.Millisecond, l.Min)
+	require.Equal(t, 500*time.Millisecond, l.P50)
+	require.Equal(t, 900*time.Millisecond, l.P90)
+	require.Equal(t, 990*time.Millisecond, l.P99)
+	require.Equal(t, 999*time.Millisecond, l.P999)
+	require.Equal(t, time.Second, l.Max)
+	require.Equal(t, 500500*time.Microsecond, l.Mean)
+
+	require.Equal(t, Latencies{}, summarize(nil))
+}
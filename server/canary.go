@@ -0,0 +1,247 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/liftbridge-io/liftbridge/server/health"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+const (
+	// canaryPath is the path of the canary status endpoint on the admin HTTP
+	// server.
+	canaryPath = "/v1/canary"
+
+	// canaryRetention is how long canary messages are kept.
+	canaryRetention = time.Hour
+)
+
+// errCanaryNotRunning is returned by canaryStatus when the canary is not
+// enabled.
+var errCanaryNotRunning = errors.New("canary is not enabled")
+
+// canaryProbe is the outcome of a successful canary probe.
+type canaryProbe struct {
+	// publishLatency is the time for a publish to be acked by the partition
+	// leader.
+	publishLatency time.Duration
+	// replicationLatency is the time for a publish to be acked once every
+	// replica in the ISR has stored it.
+	replicationLatency time.Duration
+	// deliveryLatency is the time from publishing a message until it's
+	// delivered to a subscription on this server.
+	deliveryLatency time.Duration
+}
+
+// canaryStatus describes the state of the canary as reported by the admin API.
+// Latencies are those of the last successful probe, in milliseconds.
+type canaryStatus struct {
+	Healthy             bool       `json:"healthy"`
+	Probes              int64      `json:"probes"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastProbe           *time.Time `json:"lastProbe,omitempty"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	PublishLatency      float64    `json:"publishLatencyMs"`
+	ReplicationLatency  float64    `json:"replicationLatencyMs"`
+	DeliveryLatency     float64    `json:"deliveryLatencyMs"`
+}
+
+// canary periodically publishes synthetic messages to the internal canary
+// stream and reads them back to check the health of the publish, replication
+// and delivery path end to end. Every server runs its own canary, so each
+// measures the path from itself to the canary partition's leader and back.
+type canary struct {
+	*Server
+	mu     sync.RWMutex
+	status canaryStatus
+}
+
+func newCanary(s *Server) *canary {
+	return &canary{Server: s}
+}
+
+// createCanaryStream creates the internal canary stream if the canary is
+// enabled and the stream doesn't yet exist. This should be called when this
+// node has been elected metadata leader. The stream has a single partition
+// replicated to every server and only keeps recent messages.
+func (s *Server) createCanaryStream() error {
+	if !s.config.Canary.Enabled {
+		return nil
+	}
+	if stream := s.metadata.GetStream(canaryStream); stream != nil {
+		return nil
+	}
+
+	stream := &proto.Stream{
+		Name:    canaryStream,
+		Subject: s.getCanaryStreamSubject(),
+		Partitions: []*proto.Partition{{
+			Subject:           s.getCanaryStreamSubject(),
+			Stream:            canaryStream,
+			ReplicationFactor: maxReplicationFactor,
+		}},
+		Config: &proto.StreamConfig{
+			RetentionMaxAge: &proto.NullableInt64{Value: canaryRetention.Milliseconds()},
+			SegmentMaxAge:   &proto.NullableInt64{Value: canaryRetention.Milliseconds() / 4},
+			AutoPauseTime:   &proto.NullableInt64{Value: 0},
+		},
+	}
+	status := s.metadata.CreateStream(context.Background(), &proto.CreateStreamOp{Stream: stream})
+	if status == nil || status.Code() == codes.AlreadyExists {
+		return nil
+	}
+
+	return status.Err()
+}
+
+// getCanaryStreamSubject returns the NATS subject used for canary messages.
+func (s *Server) getCanaryStreamSubject() string {
+	return fmt.Sprintf("%s.canary", s.config.Clustering.Namespace)
+}
+
+// run probes the canary stream every interval until the server is shut down.
+func (c *canary) run() {
+	ticker := time.NewTicker(c.config.Canary.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.shutdownCh:
+			return
+		case <-ticker.C:
+		}
+		// The stream is created once a metadata leader is elected.
+		if c.metadata.GetStream(canaryStream) == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.Canary.Timeout)
+		probe, err := c.probe(ctx)
+		cancel()
+		c.record(probe, err)
+	}
+}
+
+// probe publishes a message to the canary partition which is acked by its
+// leader, then one which is acked once the ISR has stored it, and waits for
+// the latter to be delivered to a subscription on this server.
+func (c *canary) probe(ctx context.Context) (*canaryProbe, error) {
+	var (
+		probe = &canaryProbe{}
+		value = []byte(c.config.Clustering.ServerID)
+		start = time.Now()
+	)
+	if _, err := c.api.Publish(ctx, &client.PublishRequest{
+		Stream:    canaryStream,
+		Value:     value,
+		AckPolicy: client.AckPolicy_LEADER,
+	}); err != nil {
+		return nil, fmt.Errorf("publish failed: %v", err)
+	}
+	probe.publishLatency = time.Since(start)
+
+	start = time.Now()
+	resp, err := c.api.Publish(ctx, &client.PublishRequest{
+		Stream:    canaryStream,
+		Value:     value,
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("replicated publish failed: %v", err)
+	}
+	probe.replicationLatency = time.Since(start)
+
+	// Read the message from this server's replica, even if it's not the
+	// leader, so that delivery from every server is checked.
+	msgC, errC, cancel, err := c.api.SubscribeInternal(ctx, &client.SubscribeRequest{
+		Stream:         canaryStream,
+		StartPosition:  client.StartPosition_OFFSET,
+		StartOffset:    resp.Ack.Offset,
+		ReadISRReplica: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("subscribe failed: %v", err)
+	}
+	defer cancel()
+	select {
+	case <-msgC:
+		probe.deliveryLatency = time.Since(start)
+	case st := <-errC:
+		return nil, fmt.Errorf("subscription failed: %v", st.Message())
+	case <-ctx.Done():
+		return nil, fmt.Errorf("delivery timed out: %v", ctx.Err())
+	}
+	return probe, nil
+}
+
+// record updates the canary status with the outcome of a probe and marks the
+// canary health service as not serving once the failure threshold is
+// reached.
+func (c *canary) record(probe *canaryProbe, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.status.Probes++
+	c.status.LastProbe = &now
+	if err != nil {
+		c.status.Failures++
+		c.status.ConsecutiveFailures++
+		c.status.LastError = err.Error()
+		c.logger.Warnf("Canary probe failed: %v", err)
+		if c.status.ConsecutiveFailures == c.config.Canary.FailureThreshold {
+			c.logger.Errorf("Canary failed %d consecutive probes, marking it unhealthy",
+				c.status.ConsecutiveFailures)
+		}
+		if c.status.ConsecutiveFailures >= c.config.Canary.FailureThreshold {
+			c.status.Healthy = false
+			health.SetCanaryNotServing()
+		}
+		return
+	}
+	c.status.ConsecutiveFailures = 0
+	c.status.LastSuccess = &now
+	c.status.LastError = ""
+	c.status.PublishLatency = milliseconds(probe.publishLatency)
+	c.status.ReplicationLatency = milliseconds(probe.replicationLatency)
+	c.status.DeliveryLatency = milliseconds(probe.deliveryLatency)
+	c.status.Healthy = true
+	health.SetCanaryServing()
+}
+
+// getStatus returns the state of the canary.
+func (c *canary) getStatus() (canaryStatus, error) {
+	if !c.config.Canary.Enabled {
+		return canaryStatus{}, errCanaryNotRunning
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status, nil
+}
+
+// milliseconds returns the duration in fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// handleCanary reports the state of the server's canary.
+func (s *Server) handleCanary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	status, err := s.canary.getStatus()
+	if err == errCanaryNotRunning {
+		writeAdminError(w, http.StatusNotFound, "Canary is not enabled", "")
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, status)
+}
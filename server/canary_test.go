@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure the canary probes the canary stream and reports its latencies
+// through the admin API.
+func TestCanary(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	config.Canary.Enabled = true
+	config.Canary.Interval = 100 * time.Millisecond
+	config.Canary.Timeout = 2 * time.Second
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+
+	getMetadataLeader(t, 10*time.Second, s)
+	waitForPartition(t, 10*time.Second, canaryStream, 0, s)
+
+	require.Eventually(t, func() bool {
+		status := canaryStatus{}
+		if adminRequest(t, s, http.MethodGet, canaryPath, &status) != http.StatusOK {
+			return false
+		}
+		return status.Healthy && status.Probes > 0 && status.LastSuccess != nil &&
+			status.PublishLatency > 0 && status.ReplicationLatency > 0 &&
+			status.DeliveryLatency > 0
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// The canary stream is internal.
+	require.True(t, isInternalStream(canaryStream))
+}
+
+// Ensure the canary is only marked unhealthy once the failure threshold is
+// reached and recovers on the next successful probe.
+func TestCanaryFailureThreshold(t *testing.T) {
+	config := NewDefaultConfig()
+	config.Canary.Enabled = true
+	config.Canary.FailureThreshold = 2
+	s := New(config)
+
+	s.canary.record(&canaryProbe{}, nil)
+	status, err := s.canary.getStatus()
+	require.NoError(t, err)
+	require.True(t, status.Healthy)
+
+	s.canary.record(nil, errors.New("timeout"))
+	status, _ = s.canary.getStatus()
+	require.True(t, status.Healthy)
+	require.Equal(t, 1, status.ConsecutiveFailures)
+	require.Equal(t, "timeout", status.LastError)
+
+	s.canary.record(nil, errors.New("timeout"))
+	status, _ = s.canary.getStatus()
+	require.False(t, status.Healthy)
+	require.Equal(t, int64(2), status.Failures)
+
+	s.canary.record(&canaryProbe{deliveryLatency: time.Millisecond}, nil)
+	status, _ = s.canary.getStatus()
+	require.True(t, status.Healthy)
+	require.Equal(t, 0, status.ConsecutiveFailures)
+	require.Equal(t, float64(1), status.DeliveryLatency)
+	require.Equal(t, int64(4), status.Probes)
+}
+
+// Ensure the canary endpoint returns 404 when the canary is disabled.
+func TestCanaryDisabled(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+
+	getMetadataLeader(t, 10*time.Second, s)
+	require.Nil(t, s.metadata.GetStream(canaryStream))
+	require.Equal(t, http.StatusNotFound, adminRequest(t, s, http.MethodGet, canaryPath, nil))
+}
@@ -0,0 +1,438 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+)
+
+const (
+	clientsPath = "/v1/clients"
+
+	// publishRateWindow is the window publish rates of clients are computed
+	// over.
+	publishRateWindow = 10 * time.Second
+)
+
+// clientKey is the context key for the connectedClient a request arrived
+// from.
+type clientKey struct{}
+
+// clientRegistry tracks the clients connected to the API so operators can
+// see who is connected and what they are doing. It also enforces the maximum
+// number of connected clients, 0 or less meaning no limit.
+type clientRegistry struct {
+	mu             sync.RWMutex
+	clients        map[uint64]*connectedClient
+	nextID         uint64
+	maxConnections int
+	accepted       int // Number of clients not over the connection limit
+}
+
+func newClientRegistry(maxConnections int) *clientRegistry {
+	return &clientRegistry{
+		clients:        make(map[uint64]*connectedClient),
+		maxConnections: maxConnections,
+	}
+}
+
+// register starts tracking a client connected over the given connection. If
+// the connection limit is reached, the client is marked as over the limit so
+// its requests are rejected.
+func (r *clientRegistry) register(conn net.Conn, listener string) *connectedClient {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	c := &connectedClient{
+		id:            r.nextID,
+		conn:          conn,
+		address:       conn.RemoteAddr().String(),
+		listener:      listener,
+		connectedAt:   now,
+		lastActivity:  now,
+		rejected:      r.maxConnections > 0 && r.accepted >= r.maxConnections,
+		subscriptions: make(map[*clientSubscription]struct{}),
+		publishRate:   rateCounter{windowStart: now},
+	}
+	if !c.rejected {
+		r.accepted++
+	}
+	r.clients[c.id] = c
+	return c
+}
+
+// unregister stops tracking the given client.
+func (r *clientRegistry) unregister(c *connectedClient) {
+	r.mu.Lock()
+	delete(r.clients, c.id)
+	if !c.rejected {
+		r.accepted--
+	}
+	r.mu.Unlock()
+}
+
+// get returns the client with the given ID or nil if it's not connected.
+func (r *clientRegistry) get(id uint64) *connectedClient {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.clients[id]
+}
+
+// list returns the connected clients ordered by ID.
+func (r *clientRegistry) list() []*connectedClient {
+	r.mu.RLock()
+	clients := make([]*connectedClient, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// listen wraps the given API listener so the connections it accepts are
+// tracked by the registry.
+func (r *clientRegistry) listen(l net.Listener) net.Listener {
+	return &trackingListener{Listener: l, registry: r}
+}
+
+// trackingListener is an API listener which registers the connections it
+// accepts with a clientRegistry.
+type trackingListener struct {
+	net.Listener
+	registry *clientRegistry
+}
+
+// Accept waits for the next connection, registers it and tags its local
+// address with the client so that API requests can tell which client they
+// arrived from.
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	var listener string
+	if addr, ok := conn.LocalAddr().(*listenerAddr); ok {
+		listener = addr.listener
+	}
+	tc := &trackedConn{Conn: conn}
+	tc.client = l.registry.register(tc, listener)
+	tc.addr = &clientAddr{Addr: conn.LocalAddr(), client: tc.client}
+	tc.registry = l.registry
+	if tc.client.rejected {
+		// Close the connection once the client has had a chance to see its
+		// requests rejected.
+		time.AfterFunc(connectionRejectGrace, func() { tc.Close() }) // nolint: errcheck
+	}
+	return tc, nil
+}
+
+// trackedConn is a connection accepted by a trackingListener.
+type trackedConn struct {
+	net.Conn
+	addr     *clientAddr
+	client   *connectedClient
+	registry *clientRegistry
+	once     sync.Once
+}
+
+// LocalAddr returns the local address of the connection tagged with the
+// client connected over it.
+func (c *trackedConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// Close closes the connection and stops tracking its client.
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.registry.unregister(c.client) })
+	return c.Conn.Close()
+}
+
+// clientAddr is the local address of a connection accepted by a
+// trackingListener.
+type clientAddr struct {
+	net.Addr
+	client *connectedClient
+}
+
+// connectedClient is a client connected to the API.
+type connectedClient struct {
+	id          uint64
+	conn        net.Conn
+	address     string
+	listener    string
+	connectedAt time.Time
+	rejected    bool  // Connected over the connection limit
+	requests    int64 // accessed atomically
+
+	mu             sync.Mutex
+	principal      string
+	lastActivity   time.Time
+	publishes      int64
+	publishedBytes int64
+	publishRate    rateCounter
+	subscriptions  map[*clientSubscription]struct{}
+}
+
+// clientSubscription is a subscription of a connectedClient.
+type clientSubscription struct {
+	stream    string
+	partition int32
+	buffered  func() int
+}
+
+// overLimit indicates if the client connected while the connection limit was
+// reached, in which case its requests are rejected. It's false if the client
+// is nil.
+func (c *connectedClient) overLimit() bool {
+	return c != nil && c.rejected
+}
+
+// recordRequest records an RPC made by the client.
+func (c *connectedClient) recordRequest(ctx context.Context) {
+	atomic.AddInt64(&c.requests, 1)
+	var principal string
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok &&
+			len(tlsInfo.State.PeerCertificates) > 0 {
+			principal = tlsInfo.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	c.mu.Lock()
+	c.lastActivity = time.Now()
+	if principal != "" {
+		c.principal = principal
+	}
+	c.mu.Unlock()
+}
+
+// recordPublish records a message of the given size published by the
+// client. It's a no-op if the client is nil so requests which weren't
+// accepted by a trackingListener can be passed through.
+func (c *connectedClient) recordPublish(size int) {
+	if c == nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	c.lastActivity = now
+	c.publishes++
+	c.publishedBytes += int64(size)
+	c.publishRate.add(now, 1)
+	c.mu.Unlock()
+}
+
+// addSubscription records a subscription of the client to the given stream
+// partition. Buffered returns the number of messages read for it which have
+// not been sent to the client yet. The returned function removes the
+// subscription. It's a no-op if the client is nil.
+func (c *connectedClient) addSubscription(stream string, partition int32, buffered func() int) func() {
+	if c == nil {
+		return func() {}
+	}
+	sub := &clientSubscription{stream: stream, partition: partition, buffered: buffered}
+	c.mu.Lock()
+	c.subscriptions[sub] = struct{}{}
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		delete(c.subscriptions, sub)
+		c.mu.Unlock()
+	}
+}
+
+// disconnect forcibly closes the client's connection.
+func (c *connectedClient) disconnect() error {
+	return c.conn.Close()
+}
+
+// clientFromContext returns the client the request with the given context
+// arrived from, or nil if it's not tracked.
+func clientFromContext(ctx context.Context) *connectedClient {
+	c, _ := ctx.Value(clientKey{}).(*connectedClient)
+	return c
+}
+
+// rateCounter computes the rate of events over the last complete window. A
+// window is complete once it's at least publishRateWindow long, so an idle
+// window stretches until the next event or read and averages over it.
+type rateCounter struct {
+	windowStart time.Time
+	count       int64
+	rate        float64
+}
+
+// add records n events at the given time.
+func (r *rateCounter) add(now time.Time, n int64) {
+	r.roll(now)
+	r.count += n
+}
+
+// perSecond returns the events per second over the last complete window.
+func (r *rateCounter) perSecond(now time.Time) float64 {
+	r.roll(now)
+	return r.rate
+}
+
+func (r *rateCounter) roll(now time.Time) {
+	elapsed := now.Sub(r.windowStart)
+	if elapsed < publishRateWindow {
+		return
+	}
+	r.rate = float64(r.count) / elapsed.Seconds()
+	r.windowStart = now
+	r.count = 0
+}
+
+// apiStatsHandler is a gRPC stats handler which adds the client and the name
+// of the listener a connection was accepted by to the context of its
+// requests.
+type apiStatsHandler struct{}
+
+// TagConn adds the client to the connection's context if it was accepted by
+// a trackingListener before tagging it with its listener.
+func (apiStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	if addr, ok := info.LocalAddr.(*clientAddr); ok {
+		ctx = context.WithValue(ctx, clientKey{}, addr.client)
+		info = &stats.ConnTagInfo{RemoteAddr: info.RemoteAddr, LocalAddr: addr.Addr}
+	}
+	return listenerStatsHandler{}.TagConn(ctx, info)
+}
+
+// TagRPC records the request with the client it arrived from.
+func (apiStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	if c := clientFromContext(ctx); c != nil {
+		c.recordRequest(ctx)
+	}
+	return ctx
+}
+
+func (apiStatsHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (apiStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// clientsResponse is the response to a request listing the connected
+// clients.
+type clientsResponse struct {
+	Clients []clientResponse `json:"clients"`
+}
+
+// clientResponse describes a client connected to the API.
+type clientResponse struct {
+	ID               uint64                   `json:"id"`
+	Address          string                   `json:"address"`
+	Listener         string                   `json:"listener,omitempty"`
+	Principal        string                   `json:"principal,omitempty"`
+	Rejected         bool                     `json:"rejected,omitempty"`
+	ConnectedAt      time.Time                `json:"connectedAt"`
+	LastActivity     time.Time                `json:"lastActivity"`
+	Requests         int64                    `json:"requests"`
+	Publishes        int64                    `json:"publishes"`
+	PublishedBytes   int64                    `json:"publishedBytes"`
+	PublishRate      float64                  `json:"publishRate"`
+	BufferedMessages int                      `json:"bufferedMessages"`
+	Subscriptions    []clientSubscriptionInfo `json:"subscriptions"`
+}
+
+// clientSubscriptionInfo describes a subscription of a connected client.
+type clientSubscriptionInfo struct {
+	Stream           string `json:"stream"`
+	Partition        int32  `json:"partition"`
+	BufferedMessages int    `json:"bufferedMessages"`
+}
+
+func (c *connectedClient) describe(now time.Time) clientResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp := clientResponse{
+		ID:             c.id,
+		Address:        c.address,
+		Listener:       c.listener,
+		Principal:      c.principal,
+		Rejected:       c.rejected,
+		ConnectedAt:    c.connectedAt,
+		LastActivity:   c.lastActivity,
+		Requests:       atomic.LoadInt64(&c.requests),
+		Publishes:      c.publishes,
+		PublishedBytes: c.publishedBytes,
+		PublishRate:    c.publishRate.perSecond(now),
+		Subscriptions:  make([]clientSubscriptionInfo, 0, len(c.subscriptions)),
+	}
+	for sub := range c.subscriptions {
+		buffered := sub.buffered()
+		resp.BufferedMessages += buffered
+		resp.Subscriptions = append(resp.Subscriptions, clientSubscriptionInfo{
+			Stream:           sub.stream,
+			Partition:        sub.partition,
+			BufferedMessages: buffered,
+		})
+	}
+	sort.Slice(resp.Subscriptions, func(i, j int) bool {
+		a, b := resp.Subscriptions[i], resp.Subscriptions[j]
+		if a.Stream != b.Stream {
+			return a.Stream < b.Stream
+		}
+		return a.Partition < b.Partition
+	})
+	return resp
+}
+
+// handleClients lists the clients connected to the API.
+func (s *Server) handleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	var (
+		now     = time.Now()
+		clients = s.clients.list()
+		resp    = make([]clientResponse, len(clients))
+	)
+	for i, c := range clients {
+		resp[i] = c.describe(now)
+	}
+	writeAdminResponse(w, http.StatusOK, &clientsResponse{Clients: resp})
+}
+
+// handleClient describes or disconnects the connected client with the ID in
+// the request path.
+func (s *Server) handleClient(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, clientsPath+"/"), 10, 64)
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, "Not found", "")
+		return
+	}
+	c := s.clients.get(id)
+	if c == nil {
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("Unknown client %d", id), "")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminResponse(w, http.StatusOK, c.describe(time.Now()))
+	case http.MethodDelete:
+		resp := c.describe(time.Now())
+		s.logger.Warnf("Disconnecting client %d (%s) by request of the admin API", c.id, c.address)
+		if err := c.disconnect(); err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err.Error(), "")
+			return
+		}
+		writeAdminResponse(w, http.StatusOK, resp)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+	}
+}
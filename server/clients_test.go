@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// Ensure the admin API lists connected clients along with their publishes and
+// subscriptions and forcibly disconnects them.
+func TestClientsAPI(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	config.Listeners = []ListenerConfig{{
+		Name:   "external",
+		Listen: HostPort{Host: "localhost", Port: 5051},
+	}}
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5051", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	waitForPartition(t, 10*time.Second, "foo", 0, s)
+	for i := 0; i < 3; i++ {
+		_, err = api.Publish(ctx, &client.PublishRequest{Stream: "foo", Value: []byte("hello")})
+		require.NoError(t, err)
+	}
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{Stream: "foo"})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+
+	findClient := func() *clientResponse {
+		resp := clientsResponse{}
+		require.Equal(t, http.StatusOK, adminRequest(t, s, http.MethodGet, clientsPath, &resp))
+		for _, c := range resp.Clients {
+			if c.Listener == "external" {
+				return &c
+			}
+		}
+		return nil
+	}
+	var c *clientResponse
+	require.Eventually(t, func() bool {
+		c = findClient()
+		return c != nil && len(c.Subscriptions) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, int64(3), c.Publishes)
+	require.Equal(t, int64(15), c.PublishedBytes)
+	require.True(t, c.Requests >= 5, c.Requests)
+	require.Equal(t, "foo", c.Subscriptions[0].Stream)
+	require.False(t, c.LastActivity.Before(c.ConnectedAt))
+
+	path := fmt.Sprintf("%s/%d", clientsPath, c.ID)
+	got := clientResponse{}
+	require.Equal(t, http.StatusOK, adminRequest(t, s, http.MethodGet, path, &got))
+	require.Equal(t, c.Address, got.Address)
+
+	require.Equal(t, http.StatusOK, adminRequest(t, s, http.MethodDelete, path, nil))
+	_, err = sub.Recv()
+	require.Error(t, err)
+	// The client reconnects as a new client.
+	require.Equal(t, http.StatusNotFound, adminRequest(t, s, http.MethodGet, path, nil))
+	if reconnected := findClient(); reconnected != nil {
+		require.NotEqual(t, c.ID, reconnected.ID)
+	}
+	require.Equal(t, http.StatusNotFound, adminRequest(t, s, http.MethodGet, clientsPath+"/foo", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, adminRequest(t, s, http.MethodPost, clientsPath, nil))
+}
+
+// Ensure publish rates are computed over complete windows.
+func TestRateCounter(t *testing.T) {
+	start := time.Now()
+	r := rateCounter{windowStart: start}
+	r.add(start, 50)
+	require.Equal(t, float64(0), r.perSecond(start.Add(time.Second)))
+	r.add(start.Add(5*time.Second), 50)
+	require.Equal(t, float64(10), r.perSecond(start.Add(publishRateWindow)))
+	require.Equal(t, float64(0), r.perSecond(start.Add(3*publishRateWindow)))
+}
@@ -0,0 +1,209 @@
+package commitlog
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+
+	"github.com/pkg/errors"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// MessageFormat is the format the messages of new segments are written in.
+// Segments are read in the format they were written in, so changing it only
+// affects segments created afterwards.
+type MessageFormat string
+
+const (
+	// MessageFormatV1 writes each message with its own header and CRC.
+	MessageFormatV1 MessageFormat = "v1"
+	// MessageFormatV2 writes the messages of each append as a batch with a
+	// single header and CRC, which takes much less space per message.
+	MessageFormatV2 MessageFormat = "v2"
+)
+
+// ParseMessageFormat returns the MessageFormat with the given name.
+func ParseMessageFormat(name string) (MessageFormat, error) {
+	switch format := MessageFormat(name); format {
+	case MessageFormatV1, MessageFormatV2:
+		return format, nil
+	}
+	return "", errors.Errorf("unknown message format %q", name)
+}
+
+// Logs written in MessageFormatV2 use FormatV4. Each write to the log is
+// stored as a batch with the following layout:
+//
+// length (4 bytes) CRC (4 bytes) messages length (4 bytes) base offset (8
+// bytes) base timestamp (8 bytes) base leader epoch (8 bytes) messages
+//
+// The length excludes the length field itself and the CRC covers the rest of
+// the batch. The messages length is the length the messages have in a
+// FormatV1 log, since positions are those of the messages in FormatV1, as with
+// compressed and encrypted logs, so indexes are not affected by the format.
+// Each message has the following layout:
+//
+// offset delta (uvarint) timestamp delta (varint) leader epoch delta (varint)
+// size (uvarint) message
+//
+// Deltas are from the base values in the batch header and the message is
+// stored without its CRC, which is covered by the batch's CRC and computed
+// again when the batch is read.
+const (
+	batchLenWidth      = 4
+	batchCRCPos        = 4
+	batchDataLenPos    = 8
+	batchOffsetPos     = 12
+	batchTimestampPos  = 20
+	batchEpochPos      = 28
+	batchHeaderLen     = 36
+	batchPrefixLen     = batchOffsetPos
+	messageCRCLen      = 4
+	maxMessageDeltaLen = 4 * binary.MaxVarintLen64
+)
+
+// newBatchedLogHeader returns the header of a log written in
+// MessageFormatV2.
+func newBatchedLogHeader() []byte {
+	header := make([]byte, logHeaderLen)
+	copy(header, logMagic)
+	proto.Encoding.PutUint16(header[magicLen:], FormatV4)
+	return header
+}
+
+// batchCodec encodes the records of a log written in MessageFormatV2 as
+// batches.
+type batchCodec struct{}
+
+func (batchCodec) prefixLen() int {
+	return batchPrefixLen
+}
+
+func (batchCodec) recordLen(prefix []byte) (int64, int64) {
+	return batchLenWidth + int64(proto.Encoding.Uint32(prefix)),
+		int64(proto.Encoding.Uint32(prefix[batchDataLenPos:]))
+}
+
+func (batchCodec) encode(dst, data []byte, pos int64) ([]byte, error) {
+	var (
+		start = len(dst)
+		delta [maxMessageDeltaLen]byte
+		first = messageSet(data)
+	)
+	if len(data) < msgSetHeaderLen {
+		return nil, errors.Errorf("partial message set at position %d", pos)
+	}
+	dst = append(dst, make([]byte, batchHeaderLen)...)
+	batch := dst[start:]
+	proto.Encoding.PutUint32(batch[batchDataLenPos:], uint32(len(data)))
+	proto.Encoding.PutUint64(batch[batchOffsetPos:], uint64(first.Offset()))
+	proto.Encoding.PutUint64(batch[batchTimestampPos:], uint64(first.Timestamp()))
+	proto.Encoding.PutUint64(batch[batchEpochPos:], first.LeaderEpoch())
+	for len(data) > 0 {
+		ms := messageSet(data)
+		if len(data) < msgSetHeaderLen || ms.Size() < messageCRCLen ||
+			int(ms.Size()) > len(data)-msgSetHeaderLen {
+			return nil, errors.Errorf("partial message set at position %d", pos)
+		}
+		var (
+			offset = ms.Offset()
+			msg    = data[msgSetHeaderLen : msgSetHeaderLen+int(ms.Size())]
+		)
+		if offset < first.Offset() {
+			return nil, errors.Errorf("offset %d is before the batch's base offset %d",
+				offset, first.Offset())
+		}
+		// A message whose CRC doesn't match can't be stored since its CRC
+		// is computed again when it's read.
+		if crc32.Checksum(msg[messageCRCLen:], crc32cTable) != proto.Encoding.Uint32(msg) {
+			return nil, errors.Wrapf(ErrCorruptMessage, "message at offset %d does not match its CRC",
+				offset)
+		}
+		n := binary.PutUvarint(delta[:], uint64(offset-first.Offset()))
+		n += binary.PutVarint(delta[n:], ms.Timestamp()-first.Timestamp())
+		n += binary.PutVarint(delta[n:], int64(ms.LeaderEpoch()-first.LeaderEpoch()))
+		n += binary.PutUvarint(delta[n:], uint64(len(msg)-messageCRCLen))
+		dst = append(dst, delta[:n]...)
+		dst = append(dst, msg[messageCRCLen:]...)
+		pos += int64(len(msg)) + msgSetHeaderLen
+		data = data[len(msg)+msgSetHeaderLen:]
+	}
+	batch = dst[start:]
+	proto.Encoding.PutUint32(batch, uint32(len(batch)-batchLenWidth))
+	proto.Encoding.PutUint32(batch[batchCRCPos:], crc32.Checksum(batch[batchDataLenPos:], crc32cTable))
+	return dst, nil
+}
+
+func (batchCodec) decode(dst, batch []byte, pos int64) ([]byte, error) {
+	if len(batch) < batchHeaderLen ||
+		crc32.Checksum(batch[batchDataLenPos:], crc32cTable) != proto.Encoding.Uint32(batch[batchCRCPos:]) {
+		return nil, errors.Wrapf(ErrCorruptMessage, "batch at position %d does not match its CRC", pos)
+	}
+	var (
+		baseOffset    = int64(proto.Encoding.Uint64(batch[batchOffsetPos:]))
+		baseTimestamp = int64(proto.Encoding.Uint64(batch[batchTimestampPos:]))
+		baseEpoch     = proto.Encoding.Uint64(batch[batchEpochPos:])
+		r             = &varintReader{b: batch[batchHeaderLen:]}
+		header        = make([]byte, msgSetHeaderLen+messageCRCLen)
+	)
+	for len(r.b) > 0 {
+		var (
+			offsetDelta    = r.uvarint()
+			timestampDelta = r.varint()
+			epochDelta     = r.varint()
+			size           = r.uvarint()
+		)
+		if r.invalid || size > uint64(len(r.b)) {
+			return nil, errors.Errorf("invalid message in batch at position %d", pos)
+		}
+		msg := r.b[:size]
+		proto.Encoding.PutUint64(header[offsetPos:], uint64(baseOffset+int64(offsetDelta)))
+		proto.Encoding.PutUint64(header[timestampPos:], uint64(baseTimestamp+timestampDelta))
+		proto.Encoding.PutUint64(header[leaderEpochPos:], baseEpoch+uint64(epochDelta))
+		proto.Encoding.PutUint32(header[sizePos:], uint32(size)+messageCRCLen)
+		proto.Encoding.PutUint32(header[msgSetHeaderLen:], crc32.Checksum(msg, crc32cTable))
+		dst = append(dst, header...)
+		dst = append(dst, msg...)
+		r.b = r.b[size:]
+	}
+	return dst, nil
+}
+
+// varintReader reads varints from a buffer, consuming them. If one is
+// invalid, it's marked invalid and the rest of the buffer is discarded.
+type varintReader struct {
+	b       []byte
+	invalid bool
+}
+
+func (r *varintReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.b, r.invalid = nil, true
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *varintReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.b, r.invalid = nil, true
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+// openBatchedLog returns a recordLog for the log file of the given size
+// written in MessageFormatV2, which is opened for appending. A batch
+// partially written before a crash is removed from the end of the log.
+func openBatchedLog(file *os.File, size int64) (*recordLog, error) {
+	l, err := openRecordLog(file, size, logHeaderLen, batchCodec{})
+	if err != nil {
+		return nil, err
+	}
+	return l, l.repair(size)
+}
@@ -0,0 +1,259 @@
+package commitlog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func batchTestMessages(n int) []*Message {
+	msgs := make([]*Message, n)
+	for i := range msgs {
+		msgs[i] = &Message{
+			Key:       []byte(strconv.Itoa(i % 10)),
+			Value:     []byte("value-" + strconv.Itoa(i)),
+			Timestamp: int64(1000 + i*3),
+		}
+	}
+	return msgs
+}
+
+func appendBatches(t *testing.T, l *commitLog, msgs []*Message, batchSize int) {
+	for i := 0; i < len(msgs); i += batchSize {
+		end := i + batchSize
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		_, err := l.Append(msgs[i:end])
+		require.NoError(t, err)
+	}
+}
+
+func logFileSize(t *testing.T, l *commitLog) int64 {
+	var size int64
+	for _, seg := range l.Segments() {
+		info, err := os.Stat(seg.logPath())
+		require.NoError(t, err)
+		size += info.Size()
+	}
+	return size
+}
+
+// Ensure segments written in MessageFormatV2 are read transparently,
+// including after the log is reopened, and take less space than in
+// MessageFormatV1 while keeping the same positions.
+func TestMessageFormatV2(t *testing.T) {
+	msgs := batchTestMessages(200)
+	v1, cleanup := setupWithOptions(t, Options{Path: tempDir(t)})
+	defer cleanup()
+	appendBatches(t, v1, msgs, 20)
+
+	opts := Options{
+		Path:          tempDir(t),
+		MessageFormat: MessageFormatV2,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	appendBatches(t, l, msgs, 20)
+	requireLogMessages(t, l, msgs)
+
+	seg := l.Segments()[0]
+	require.Equal(t, FormatV4, seg.format)
+	require.Equal(t, v1.Segments()[0].Position(), seg.Position())
+	// Each message saves its header and CRC, less the deltas.
+	require.True(t, logFileSize(t, l) < logFileSize(t, v1)-20*int64(len(msgs)),
+		"v1 log is %d bytes, v2 log is %d bytes", logFileSize(t, v1), logFileSize(t, l))
+
+	offset, err := l.EarliestOffsetAfterTimestamp(1100)
+	require.NoError(t, err)
+	require.Equal(t, int64(34), offset)
+
+	dumped := 0
+	format, err := DumpLog(seg.logPath(), func(m *DumpedMessage) bool {
+		require.Equal(t, int64(dumped), m.Offset)
+		require.True(t, m.CrcValid)
+		require.Equal(t, msgs[dumped].Value, m.Value)
+		dumped++
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, FormatV4, format)
+	require.Equal(t, len(msgs), dumped)
+
+	require.NoError(t, l.Close())
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	requireLogMessages(t, l, msgs)
+}
+
+// Ensure existing segments remain readable in MessageFormatV1 when the log's
+// message format is changed and only new segments use the new format.
+func TestMessageFormatMixedLog(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 2048,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	msgs := batchTestMessages(200)
+	appendBatches(t, l, msgs[:100], 10)
+	require.NoError(t, l.Close())
+
+	opts.MessageFormat = MessageFormatV2
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	appendBatches(t, l, msgs[100:], 10)
+	requireLogMessages(t, l, msgs)
+	segments := l.Segments()
+	require.Equal(t, CurrentFormat, segments[0].format)
+	require.Equal(t, FormatV4, segments[len(segments)-1].format)
+
+	// Switching back to MessageFormatV1 leaves batched segments readable.
+	require.NoError(t, l.Close())
+	opts.MessageFormat = MessageFormatV1
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	requireLogMessages(t, l, msgs)
+}
+
+// Ensure truncating a batched log within a batch keeps the messages before
+// the truncation offset.
+func TestMessageFormatV2Truncate(t *testing.T) {
+	opts := Options{
+		Path:          tempDir(t),
+		MessageFormat: MessageFormatV2,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	msgs := batchTestMessages(50)
+	appendBatches(t, l, msgs[:40], 20)
+
+	require.NoError(t, l.Truncate(25))
+	appendBatches(t, l, msgs[25:], 10)
+	requireLogMessages(t, l, msgs)
+
+	require.NoError(t, l.Close())
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	requireLogMessages(t, l, msgs)
+}
+
+// Ensure a batch partially written before a crash is removed when the log is
+// reopened and appends continue after the last complete batch.
+func TestBatchedLogRecovery(t *testing.T) {
+	for _, torn := range []string{"truncated", "corrupt"} {
+		t.Run(torn, func(t *testing.T) {
+			opts := Options{
+				Path:          tempDir(t),
+				MessageFormat: MessageFormatV2,
+			}
+			l, cleanup := setupWithOptions(t, opts)
+			defer cleanup()
+			msgs := batchTestMessages(100)
+			appendBatches(t, l, msgs[:50], 10)
+			require.NoError(t, l.Close())
+
+			path := l.Segments()[0].logPath()
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			// The batch's length is past the end of the file if it's
+			// truncated. Otherwise its CRC doesn't match.
+			tail := make([]byte, batchHeaderLen+100)
+			tail[3] = batchHeaderLen + 100 - batchLenWidth
+			if torn == "truncated" {
+				tail[3] = 200
+			}
+			tail[11] = 28
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+			require.NoError(t, err)
+			_, err = f.Write(tail)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			// Remove the clean shutdown marker to simulate a crash.
+			require.NoError(t, os.Remove(filepath.Join(opts.Path, cleanShutdownFileName)))
+
+			_, err = DumpLog(path, func(*DumpedMessage) bool { return true })
+			require.Error(t, err)
+
+			l, cleanup = setupWithOptions(t, opts)
+			defer cleanup()
+			after, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, info.Size(), after.Size())
+			appendBatches(t, l, msgs[50:], 10)
+			requireLogMessages(t, l, msgs)
+		})
+	}
+}
+
+// Ensure a batch whose CRC doesn't match is reported as corrupt when it's
+// read.
+func TestBatchedLogCorruptBatch(t *testing.T) {
+	opts := Options{
+		Path:          tempDir(t),
+		MessageFormat: MessageFormatV2,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	appendBatches(t, l, batchTestMessages(30), 10)
+	require.NoError(t, l.Close())
+
+	// Flip a byte in the values of the first batch.
+	path := l.Segments()[0].logPath()
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	require.NoError(t, err)
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, logHeaderLen+batchHeaderLen+20)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, logHeaderLen+batchHeaderLen+20)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	_, _, _, _, err = r.ReadMessage(context.Background(), make([]byte, 28))
+	require.Error(t, err)
+	require.Equal(t, ErrCorruptMessage, errors.Cause(err))
+}
+
+// Ensure messages whose CRC doesn't match can't be written in a batch, since
+// their CRC is computed again when they're read.
+func TestBatchCodecCorruptMessage(t *testing.T) {
+	ms, _, err := newMessageSetFromProto(0, 0, batchTestMessages(2), false)
+	require.NoError(t, err)
+	_, err = batchCodec{}.encode(nil, ms, 0)
+	require.NoError(t, err)
+
+	ms[len(ms)-1] ^= 0xff
+	_, err = batchCodec{}.encode(nil, ms, 0)
+	require.Equal(t, ErrCorruptMessage, errors.Cause(err))
+}
+
+// Ensure the message format is validated and can't be combined with
+// encryption.
+func TestMessageFormatOptions(t *testing.T) {
+	format, err := ParseMessageFormat("v2")
+	require.NoError(t, err)
+	require.Equal(t, MessageFormatV2, format)
+	_, err = ParseMessageFormat("v3")
+	require.Error(t, err)
+
+	path := tempDir(t)
+	defer remove(t, path)
+	_, err = New(Options{Path: path, MessageFormat: "v3"})
+	require.Error(t, err)
+	_, err = New(Options{
+		Path:           path,
+		MessageFormat:  MessageFormatV2,
+		EncryptionKeys: newTestKeyProvider(),
+	})
+	require.Error(t, err)
+}
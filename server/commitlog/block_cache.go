@@ -0,0 +1,76 @@
+package commitlog
+
+import (
+	"container/list"
+	"sync"
+)
+
+// blockSize is the size of the segment log blocks held by a BlockCache.
+const blockSize = 32 * 1024
+
+// BlockCache is an LRU cache of segment log blocks shared by the readers of
+// all logs it's passed to. It keeps recently read data in memory so that
+// replaying recently produced messages does not go back to disk once they
+// have fallen out of the page cache. Only complete blocks are cached since
+// they never change: truncating or compacting a segment replaces it with a
+// new one, whose blocks are cached separately. Blocks of closed segments are
+// not read again and age out of the cache. A nil BlockCache disables caching.
+type BlockCache struct {
+	maxBytes int64
+	mu       sync.Mutex
+	size     int64
+	lru      *list.List // Most recently used first
+	blocks   map[blockKey]*list.Element
+}
+
+type blockKey struct {
+	segment uint64 // Unique ID of the segment instance
+	block   int64  // Position of the block in the segment log divided by blockSize
+}
+
+type cachedBlock struct {
+	key  blockKey
+	data []byte
+}
+
+// NewBlockCache returns a BlockCache holding up to maxBytes of segment data or
+// nil if maxBytes is less than the size of a block.
+func NewBlockCache(maxBytes int64) *BlockCache {
+	if maxBytes < blockSize {
+		return nil
+	}
+	return &BlockCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		blocks:   make(map[blockKey]*list.Element),
+	}
+}
+
+// get returns the cached block or nil if it's not cached.
+func (c *BlockCache) get(key blockKey) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.blocks[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedBlock).data
+}
+
+// put adds the block to the cache, evicting the least recently used blocks to
+// make room for it.
+func (c *BlockCache) put(key blockKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blocks[key]; ok {
+		return
+	}
+	c.blocks[key] = c.lru.PushFront(&cachedBlock{key: key, data: data})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		oldest := c.lru.Remove(c.lru.Back()).(*cachedBlock)
+		delete(c.blocks, oldest.key)
+		c.size -= int64(len(oldest.data))
+	}
+}
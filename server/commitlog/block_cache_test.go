@@ -0,0 +1,82 @@
+package commitlog
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure BlockCache evicts the least recently used blocks once it's full.
+func TestBlockCacheEviction(t *testing.T) {
+	require.Nil(t, NewBlockCache(0))
+	require.Nil(t, NewBlockCache(blockSize-1))
+
+	cache := NewBlockCache(2 * blockSize)
+	block := func(i int64) []byte {
+		return bytes.Repeat([]byte{byte(i)}, blockSize)
+	}
+	cache.put(blockKey{segment: 1, block: 0}, block(0))
+	cache.put(blockKey{segment: 1, block: 1}, block(1))
+	require.Equal(t, block(0), cache.get(blockKey{segment: 1, block: 0}))
+
+	// Block 1 is the least recently used, so it's evicted.
+	cache.put(blockKey{segment: 2, block: 0}, block(2))
+	require.Nil(t, cache.get(blockKey{segment: 1, block: 1}))
+	require.Equal(t, block(0), cache.get(blockKey{segment: 1, block: 0}))
+	require.Equal(t, block(2), cache.get(blockKey{segment: 2, block: 0}))
+	require.Equal(t, int64(2*blockSize), cache.size)
+}
+
+// Ensure reads through the block cache return the same messages as reads
+// from the log, including from the incomplete last block of the active
+// segment as it's written.
+func TestCommitLogBlockCache(t *testing.T) {
+	cache := NewBlockCache(4 * blockSize)
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 3 * blockSize,
+		BlockCache:      cache,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	value := bytes.Repeat([]byte("x"), 1000)
+	numMsgs := 300
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Key: []byte(strconv.Itoa(i)), Value: value}})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	headers := make([]byte, 28)
+	read := func() {
+		r, err := l.NewReader(0, true)
+		require.NoError(t, err)
+		for i := 0; i < numMsgs; i++ {
+			msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+			require.NoError(t, err)
+			require.Equal(t, int64(i), offset)
+			require.Equal(t, []byte(strconv.Itoa(i)), msg.Key())
+			require.Equal(t, value, msg.Value())
+		}
+	}
+
+	// Read twice, the second time partly from the cache.
+	read()
+	require.Equal(t, int64(4*blockSize), cache.size)
+	read()
+
+	// Messages appended to the incomplete last block are read.
+	_, err := l.Append([]*Message{{Key: []byte("new"), Value: value}})
+	require.NoError(t, err)
+	r, err := l.NewReader(int64(numMsgs), true)
+	require.NoError(t, err)
+	msg, _, _, _, err := r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), msg.Key())
+}
@@ -0,0 +1,91 @@
+package commitlog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	atomic_file "github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+)
+
+const (
+	cleanShutdownFileName = "clean-shutdown"
+	cleanShutdownFileV0   = 0
+)
+
+// writeCleanShutdown writes the clean shutdown marker file, which records the
+// tail of the closed active segment so that it does not need to be recovered
+// when the log is opened again, in the following format:
+//
+// v0:
+// version
+// base_offset position index_position indexed_position last_offset last_write_time
+func (l *commitLog) writeCleanShutdown() error {
+	tail := l.activeSegment().Tail()
+	b := new(bytes.Buffer)
+	if _, err := b.WriteString(fmt.Sprintf("%d\n", cleanShutdownFileV0)); err != nil {
+		return err
+	}
+	_, err := b.WriteString(fmt.Sprintf("%d %d %d %d %d %d\n", tail.BaseOffset, tail.Position,
+		tail.IndexPosition, tail.IndexedPos, tail.LastOffset, tail.LastWriteTime))
+	if err != nil {
+		return err
+	}
+	return atomic_file.WriteFile(filepath.Join(l.Path, cleanShutdownFileName), b)
+}
+
+// readCleanShutdown returns the tail of the active segment recorded in the
+// clean shutdown marker file and removes the file so that it's only used once.
+// It returns nil if there is no marker file or it's invalid, in which case the
+// log is recovered as after an unclean shutdown.
+func (l *commitLog) readCleanShutdown() (*segmentTail, error) {
+	file := filepath.Join(l.Path, cleanShutdownFileName)
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open clean shutdown file failed")
+	}
+	tail, err := parseCleanShutdown(f)
+	f.Close() // nolint: errcheck
+	if err != nil {
+		l.Logger.Warnf("Ignoring invalid clean shutdown file for log %s: %v", l.Path, err)
+		tail = nil
+	}
+	if err := os.Remove(file); err != nil {
+		return nil, errors.Wrap(err, "remove clean shutdown file failed")
+	}
+	return tail, nil
+}
+
+func parseCleanShutdown(r io.Reader) (*segmentTail, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	if !scanner.Scan() {
+		return nil, errors.New("missing version")
+	}
+	version, err := strconv.Atoi(scanner.Text())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid file version value")
+	}
+	if version > cleanShutdownFileV0 {
+		return nil, fmt.Errorf("unknown version: %d", version)
+	}
+	tail := &segmentTail{}
+	for _, field := range []*int64{&tail.BaseOffset, &tail.Position, &tail.IndexPosition,
+		&tail.IndexedPos, &tail.LastOffset, &tail.LastWriteTime} {
+		if !scanner.Scan() {
+			return nil, errors.New("missing segment tail value")
+		}
+		if *field, err = strconv.ParseInt(scanner.Text(), 10, 64); err != nil {
+			return nil, errors.Wrap(err, "invalid segment tail value")
+		}
+	}
+	return tail, nil
+}
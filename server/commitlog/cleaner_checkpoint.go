@@ -0,0 +1,55 @@
+package commitlog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	atomic_file "github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+)
+
+const cleanerCheckpointFileName = "cleaner-offset-checkpoint"
+
+// writeResumeOffset checkpoints the base offset of the first segment which an
+// interrupted compaction did not get to so that the next compaction resumes
+// from it. A negative offset removes the checkpoint.
+func (c *compactCleaner) writeResumeOffset(offset int64) error {
+	if c.Path == "" {
+		return nil
+	}
+	file := filepath.Join(c.Path, cleanerCheckpointFileName)
+	if offset < 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove cleaner checkpoint file failed")
+		}
+		return nil
+	}
+	r := bytes.NewReader([]byte(strconv.FormatInt(offset, 10)))
+	return atomic_file.WriteFile(file, r)
+}
+
+// readResumeOffset returns the offset checkpointed by an interrupted
+// compaction or -1 if there is none or the checkpoint is invalid.
+func (c *compactCleaner) readResumeOffset() int64 {
+	if c.Path == "" {
+		return -1
+	}
+	b, err := ioutil.ReadFile(filepath.Join(c.Path, cleanerCheckpointFileName))
+	if os.IsNotExist(err) {
+		return -1
+	}
+	if err != nil {
+		c.Logger.Warnf("Failed to read cleaner checkpoint file for log %s: %v", c.Name, err)
+		return -1
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil || offset < 0 {
+		c.Logger.Warnf("Ignoring invalid cleaner checkpoint file for log %s", c.Name)
+		return -1
+	}
+	return offset
+}
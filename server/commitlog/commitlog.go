@@ -2,10 +2,13 @@
 package commitlog
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,6 +20,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/liftbridge-io/liftbridge/server/logger"
+	"github.com/liftbridge-io/liftbridge/server/timerwheel"
 )
 
 // ErrSegmentNotFound is returned if the segment could not be found.
@@ -39,10 +43,16 @@ const (
 // log.
 type commitLog struct {
 	readonly         int32 // Atomic flag
+	unflushed        int64 // Atomic count of messages appended since the last flush
+	lastFlush        int64 // Atomic time of the last flush in Unix nanoseconds
 	deleteCleaner    *deleteCleaner
 	compactCleaner   *compactCleaner
 	name             string
 	mu               sync.RWMutex
+	appendMu         sync.Mutex
+	cleanMu          sync.Mutex
+	stopCleanOnce    sync.Once
+	stopClean        chan struct{}
 	hw               int64
 	closed           chan struct{}
 	segments         []*segment
@@ -50,41 +60,111 @@ type commitLog struct {
 	hwWaiters        map[contextReader]chan bool
 	leaderEpochCache *leaderEpochCache
 	deleted          bool
+	cleanShutdown    bool
+	checkpointTask   *timerwheel.Task
+	nextCheckpoint   time.Time
+	cleanerTask      *timerwheel.Task
+	tiered           *tieredLog
+	keys             *segmentKeys // Nil if segments are not encrypted
 	Options
 }
 
 // Options contains settings for configuring a commitLog.
 type Options struct {
-	Name                 string        // commitLog name
-	Path                 string        // Path to log directory
-	MaxSegmentBytes      int64         // Max bytes a Segment can contain before creating a new one
-	MaxSegmentAge        time.Duration // Max time before a new log segment is rolled out.
-	MaxLogBytes          int64         // Retention by bytes
-	MaxLogMessages       int64         // Retention by messages
-	MaxLogAge            time.Duration // Retention by age
-	Compact              bool          // Run compaction on log clean
-	CompactMaxGoroutines int           // Max number of goroutines to use in a log compaction
-	CleanerInterval      time.Duration // Frequency to enforce retention policy
-	HWCheckpointInterval time.Duration // Frequency to checkpoint HW to disk
-	ConcurrencyControl   bool          // Optimistic Concurrency Control
-	Logger               logger.Logger
-}
-
-// New creates a new CommitLog and starts a background goroutine which
-// periodically checkpoints the high watermark to disk.
+	Name                      string               // commitLog name
+	Path                      string               // Path to log directory
+	MaxSegmentBytes           int64                // Max bytes a Segment can contain before creating a new one
+	MaxSegmentAge             time.Duration        // Max time before a new log segment is rolled out.
+	MaxLogBytes               int64                // Retention by bytes
+	MaxLogMessages            int64                // Retention by messages
+	MaxLogAge                 time.Duration        // Retention by age
+	MaxLogKeys                int64                // Retention by distinct keys of compacted logs
+	Compact                   bool                 // Run compaction on log clean
+	CompactMaxGoroutines      int                  // Max number of goroutines to use in a log compaction
+	CompactKeepVersions       int                  // Number of messages to retain per key in a log compaction
+	CompactTombstoneRetention time.Duration        // Time to retain tombstones in a log compaction
+	CompactMinDirtyRatio      float64              // Min ratio of uncompacted bytes for a log compaction to run
+	CompactMaxBytes           int64                // Max uncompacted bytes to compact per log compaction, 0 is unlimited
+	CleanerInterval           time.Duration        // Frequency to enforce retention policy
+	HWCheckpointInterval      time.Duration        // Frequency to checkpoint HW to disk
+	ConcurrencyControl        bool                 // Optimistic Concurrency Control
+	SyncOnAppend              bool                 // Fsync segments before appends return
+	SyncMaxDelay              time.Duration        // Max time to wait for other appends to share an fsync
+	FlushMessages             int64                // Fsync the active segment after this many appended messages, 0 disables
+	FlushInterval             time.Duration        // Fsync the active segment this long after the last fsync if it has new messages, 0 disables
+	IOUring                   bool                 // Use io_uring for segment I/O if supported
+	IndexIntervalBytes        int64                // Min log bytes between index entries, 0 indexes every message
+	IndexAdvice               IndexAdvice          // Access pattern hint for memory-mapped indexes, empty uses the kernel default
+	IndexLockBytes            int64                // Bytes before the active index's write position to lock in memory, 0 locks nothing
+	IndexPositionalIO         bool                 // Read and write indexes with positional I/O instead of memory-mapping them
+	IndexPreallocateBytes     int64                // Size indexes are preallocated to and expanded by, 0 uses 10MB
+	BlockCache                *BlockCache          // Cache of recently read log blocks, nil disables caching
+	ChecksumVerification      ChecksumVerification // When message CRCs are verified, empty verifies them on every read
+	TimerWheel                *timerwheel.Wheel    // Runs HW checkpoints and cleaning, nil uses a timer per log
+	CompactionScheduler       *CompactionScheduler // Cleans compacted logs within an IO budget, nil cleans them on TimerWheel
+	TieredStorage             *TieredStorage       // Store sealed segments are offloaded to, nil disables offloading
+	TieredPrefix              string               // Key prefix of the log's segments in TieredStorage
+	Compression               SegmentCompression   // Codec sealed segments are compressed with, empty or none disables compression
+	EncryptionKeys            KeyProvider          // Provider of the keys new segments are encrypted with, nil disables encryption
+	EncryptionKeyName         string               // Name the log's keys are scoped by in EncryptionKeys, e.g. its stream
+	MessageFormat             MessageFormat        // Format messages of new segments are written in, empty uses MessageFormatV1
+	Logger                    logger.Logger
+}
+
+// New creates a new CommitLog and schedules background tasks which
+// periodically checkpoint the high watermark to disk and clean the log.
 func New(opts Options) (CommitLog, error) {
 	if opts.Path == "" {
 		return nil, errors.New("path is empty")
 	}
 
 	if opts.Logger == nil {
-		opts.Logger = logger.NewLogger(0)
-		opts.Logger.SetWriter(ioutil.Discard)
+		opts.Logger = logger.NewNopLogger()
 	}
 
 	if opts.MaxSegmentBytes == 0 {
 		opts.MaxSegmentBytes = defaultMaxSegmentBytes
 	}
+	if opts.IOUring {
+		if err := ioUringSupported(); err != nil {
+			opts.Logger.Warnf("io_uring is not available for log %s, using standard file I/O: %v",
+				opts.Path, err)
+			opts.IOUring = false
+		}
+	}
+	if opts.IndexAdvice != "" {
+		if _, err := ParseIndexAdvice(string(opts.IndexAdvice)); err != nil {
+			return nil, err
+		}
+	}
+	if opts.IndexLockBytes > 0 && !opts.IndexPositionalIO {
+		if err := indexLockSupported(opts.IndexLockBytes); err != nil {
+			opts.Logger.Warnf("Index pages cannot be locked in memory for log %s: %v",
+				opts.Path, err)
+			opts.IndexLockBytes = 0
+		}
+	}
+	if opts.ChecksumVerification != "" {
+		if _, err := ParseChecksumVerification(string(opts.ChecksumVerification)); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Compression != "" {
+		if _, err := ParseSegmentCompression(string(opts.Compression)); err != nil {
+			return nil, err
+		}
+	}
+	if opts.EncryptionKeys != nil && opts.Compression != "" && opts.Compression != CompressionNone {
+		return nil, errors.New("segment compression is not supported with encryption")
+	}
+	if opts.MessageFormat != "" {
+		if _, err := ParseMessageFormat(string(opts.MessageFormat)); err != nil {
+			return nil, err
+		}
+	}
+	if opts.EncryptionKeys != nil && opts.MessageFormat == MessageFormatV2 {
+		return nil, errors.New("message format v2 is not supported with encryption")
+	}
 	if opts.HWCheckpointInterval == 0 {
 		opts.HWCheckpointInterval = defaultHWCheckpointInterval
 	}
@@ -101,19 +181,27 @@ func New(opts Options) (CommitLog, error) {
 	cleanerOpts.Retention.Age = opts.MaxLogAge
 	cleaner := newDeleteCleaner(cleanerOpts)
 
-	compactCleanerOpts := compactCleanerOptions{
-		Name:          opts.Name,
-		Logger:        opts.Logger,
-		MaxGoroutines: opts.CompactMaxGoroutines,
-	}
-	compactCleaner := newCompactCleaner(compactCleanerOpts)
-
 	path, _ := filepath.Abs(opts.Path)
 	epochCache, err := newLeaderEpochCache(opts.Name, path, opts.Logger)
 	if err != nil {
 		return nil, err
 	}
 
+	compactCleanerOpts := compactCleanerOptions{
+		Name:               opts.Name,
+		Logger:             opts.Logger,
+		MaxGoroutines:      opts.CompactMaxGoroutines,
+		KeepVersions:       opts.CompactKeepVersions,
+		TombstoneRetention: opts.CompactTombstoneRetention,
+		MinDirtyRatio:      opts.CompactMinDirtyRatio,
+		MaxBytes:           opts.CompactMaxBytes,
+		MaxKeys:            opts.MaxLogKeys,
+		Scheduler:          opts.CompactionScheduler,
+		Path:               path,
+		LeaderEpochCache:   epochCache,
+	}
+	compactCleaner := newCompactCleaner(compactCleanerOpts)
+
 	l := &commitLog{
 		Options:          opts,
 		name:             filepath.Base(path),
@@ -121,8 +209,13 @@ func New(opts Options) (CommitLog, error) {
 		compactCleaner:   compactCleaner,
 		hw:               -1,
 		closed:           make(chan struct{}),
+		stopClean:        make(chan struct{}),
 		hwWaiters:        make(map[contextReader]chan bool),
 		leaderEpochCache: epochCache,
+		lastFlush:        time.Now().UnixNano(),
+	}
+	if opts.EncryptionKeys != nil {
+		l.keys = &segmentKeys{provider: opts.EncryptionKeys, name: opts.EncryptionKeyName}
 	}
 
 	if err := l.init(); err != nil {
@@ -133,24 +226,49 @@ func New(opts Options) (CommitLog, error) {
 		return nil, err
 	}
 
+	if l.TieredStorage != nil {
+		if l.tiered, err = newTieredLog(l); err != nil {
+			return nil, err
+		}
+	}
+
+	// After a clean shutdown, the log and leader epoch cache are consistent,
+	// so there's nothing to recover.
+	if !l.cleanShutdown {
+		if err := l.recover(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The log could end with part of an atomic batch, e.g. after an unclean
+	// shutdown or if a follower stopped while replicating the batch. Remove
+	// it so the batch is never exposed partially.
+	if err := l.truncateIncompleteBatch(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	l.nextCheckpoint = now.Add(l.HWCheckpointInterval)
+	l.checkpointTask = l.TimerWheel.Schedule(l.checkpointTaskDelay(now), l.checkpointHWTask)
+	l.cleanerTask = l.TimerWheel.Schedule(l.CleanerInterval, l.cleanTask)
+
+	return l, nil
+}
+
+// recover makes the log and leader epoch cache consistent after an unclean
+// shutdown.
+func (l *commitLog) recover() error {
 	// After an unclean shutdown, the leader epoch checkpoint file could be
 	// ahead of the log (as the log is flushed asynchronously by default). To
 	// account for this, remove all entries from the leader epoch checkpoint
 	// file where the offset is greater than the log end offset.
 	if err := l.leaderEpochCache.ClearLatest(l.activeSegment().NextOffset()); err != nil {
-		return nil, err
+		return err
 	}
 
 	// The earliest leader epoch may not be flushed during a hard failure.
 	// Recover it here.
-	if err := l.leaderEpochCache.ClearEarliest(l.OldestOffset()); err != nil {
-		return nil, err
-	}
-
-	go l.checkpointHWLoop()
-	go l.cleanerLoop()
-
-	return l, nil
+	return l.leaderEpochCache.ClearEarliest(l.OldestOffset())
 }
 
 func (l *commitLog) init() error {
@@ -162,37 +280,52 @@ func (l *commitLog) init() error {
 }
 
 func (l *commitLog) open() error {
-	files, err := ioutil.ReadDir(l.Path)
+	// Only read the names of the files in the log directory since stat-ing
+	// every segment is slow for logs with many segments.
+	dir, err := os.Open(l.Path)
+	if err != nil {
+		return errors.Wrap(err, "open dir failed")
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close() // nolint: errcheck
 	if err != nil {
 		return errors.Wrap(err, "read dir failed")
 	}
-	for _, file := range files {
-		// If this file is an index file, make sure it has a corresponding .log
-		// file.
-		if strings.HasSuffix(file.Name(), indexFileSuffix) {
-			_, err := os.Stat(filepath.Join(
-				l.Path, strings.Replace(file.Name(), indexFileSuffix, logFileSuffix, 1)))
-			if os.IsNotExist(err) {
-				if err := os.Remove(filepath.Join(l.Path, file.Name())); err != nil {
+	sort.Strings(names)
+	cleanTail, err := l.readCleanShutdown()
+	if err != nil {
+		return err
+	}
+	logFiles := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		if strings.HasSuffix(name, logFileSuffix) {
+			logFiles[name] = struct{}{}
+		}
+	}
+	for _, name := range names {
+		// If this file is an index or time index file, make sure it has a
+		// corresponding .log file.
+		if strings.HasSuffix(name, indexFileSuffix) || strings.HasSuffix(name, timeIndexSuffix) {
+			logFile := name[:strings.LastIndex(name, ".")] + logFileSuffix
+			if _, ok := logFiles[logFile]; !ok {
+				if err := os.Remove(filepath.Join(l.Path, name)); err != nil {
 					return err
 				}
-			} else if err != nil {
-				return errors.Wrap(err, "stat file failed")
 			}
-		} else if strings.HasSuffix(file.Name(), logFileSuffix) {
-			offsetStr := strings.TrimSuffix(file.Name(), logFileSuffix)
+		} else if strings.HasSuffix(name, logFileSuffix) {
+			offsetStr := strings.TrimSuffix(name, logFileSuffix)
 			baseOffset, err := strconv.Atoi(offsetStr)
 			if err != nil {
 				return err
 			}
-			segment, err := newSegment(l.Path, int64(baseOffset), l.MaxSegmentBytes, false, "")
-			if err != nil {
-				return err
-			}
+			// Segments are opened when they are first accessed. The active
+			// segment is opened below.
+			segment := newLazySegment(l.Path, int64(baseOffset), l.MaxSegmentBytes, "", l.IOUring,
+				l.IndexIntervalBytes, l.indexAccess(), l.BlockCache, l.keys, l.MessageFormat)
 			l.segments = append(l.segments, segment)
-		} else if file.Name() == hwFileName {
+		} else if name == hwFileName {
 			// Recover high watermark.
-			b, err := ioutil.ReadFile(filepath.Join(l.Path, file.Name()))
+			b, err := ioutil.ReadFile(filepath.Join(l.Path, name))
 			if err != nil {
 				return errors.Wrap(err, "read high watermark file failed")
 			}
@@ -204,18 +337,72 @@ func (l *commitLog) open() error {
 		}
 	}
 	if len(l.segments) == 0 {
-		segment, err := newSegment(l.Path, 0, l.MaxSegmentBytes, true, "")
+		segment, err := newSegment(l.Path, 0, l.MaxSegmentBytes, true, "", l.IOUring,
+			l.IndexIntervalBytes, l.indexAccess(), l.BlockCache, l.keys, l.MessageFormat)
 		if err != nil {
 			return err
 		}
 		l.segments = append(l.segments, segment)
 	}
 	activeSegment := l.segments[len(l.segments)-1]
+	if cleanTail != nil && cleanTail.BaseOffset == activeSegment.BaseOffset {
+		activeSegment.cleanTail = cleanTail
+	}
+	if err := activeSegment.load(); err != nil {
+		return err
+	}
+	if activeSegment.IsCompressed() {
+		// The active segment must be writable, e.g. if the segments after it
+		// were removed while the log was closed.
+		if activeSegment, err = truncateSegment(activeSegment, activeSegment.NextOffset()); err != nil {
+			return err
+		}
+		l.segments[len(l.segments)-1] = activeSegment
+	}
+	// The clean tail is only kept if it matches the active segment's log.
+	l.cleanShutdown = activeSegment.cleanTail != nil
 	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&l.vActiveSegment)),
 		unsafe.Pointer(activeSegment))
 	return nil
 }
 
+// truncateIncompleteBatch removes the messages at the end of the log which
+// belong to an atomic batch whose last message is missing. Batches are never
+// split across segments, so only the active segment needs to be checked.
+func (l *commitLog) truncateIncompleteBatch() error {
+	segment := l.activeSegment()
+	if segment.IsEmpty() {
+		return nil
+	}
+	last, err := segment.findEntry(segment.LastOffset())
+	if err != nil {
+		return err
+	}
+	ms := make(messageSet, last.Size)
+	if _, err := segment.ReadAt(ms, last.Position); err != nil {
+		return err
+	}
+	if !ms.Message().BatchContinues() {
+		return nil
+	}
+
+	// Find the first message of the incomplete batch.
+	var (
+		ss         = newSegmentScanner(segment)
+		batchStart = int64(-1)
+	)
+	defer ss.close()
+	for ms, _, err := ss.Scan(); err == nil; ms, _, err = ss.Scan() {
+		if !ms.Message().BatchContinues() {
+			batchStart = -1
+		} else if batchStart == -1 {
+			batchStart = ms.Offset()
+		}
+	}
+	l.Logger.Warnf("Truncating incomplete batch at offset %d from log %s", batchStart, l.name)
+	return l.Truncate(batchStart)
+}
+
 // Append writes the given batch of messages to the log and returns their
 // corresponding offsets in the log. This will return ErrCommitLogReadonly if
 // the log is in readonly mode.
@@ -223,19 +410,32 @@ func (l *commitLog) Append(msgs []*Message) ([]int64, error) {
 	if l.IsReadonly() {
 		return nil, ErrCommitLogReadonly
 	}
-	if _, err := l.checkAndPerformSplit(); err != nil {
+	segment, offsets, err := l.appendMessages(msgs)
+	if err != nil {
 		return nil, err
 	}
+	return l.syncAppend(segment, offsets, syncDeferred(msgs))
+}
+
+func (l *commitLog) appendMessages(msgs []*Message) (*segment, []int64, error) {
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+	if _, err := l.checkAndPerformSplit(); err != nil {
+		return nil, nil, err
+	}
 	var (
-		segment          = l.activeSegment()
-		basePosition     = segment.Position()
-		baseOffset       = segment.NextOffset()
-		ms, entries, err = newMessageSetFromProto(baseOffset, basePosition, msgs, l.IsConcurrencyControlEnabled())
+		segment                = l.activeSegment()
+		basePosition           = segment.Position()
+		baseOffset             = segment.NextOffset()
+		ms, bufs, entries, err = newMessageSetBuffersFromProto(baseOffset, basePosition, msgs,
+			l.IsConcurrencyControlEnabled())
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return l.append(segment, ms, entries)
+	defer releaseMessageSet(ms)
+	offsets, err := l.append(segment, bufs, entries)
+	return segment, offsets, err
 }
 
 // AppendMessageSet writes the given message set data to the log and returns
@@ -243,19 +443,58 @@ func (l *commitLog) Append(msgs []*Message) ([]int64, error) {
 // in readonly mode to allow for reconciliation, e.g. when replicating from
 // another log.
 func (l *commitLog) AppendMessageSet(ms []byte) ([]int64, error) {
-	if _, err := l.checkAndPerformSplit(); err != nil {
+	segment, offsets, err := l.appendMessageSet(ms)
+	if err != nil {
 		return nil, err
 	}
+	return l.syncAppend(segment, offsets, messageSetSyncDeferred(ms))
+}
+
+func (l *commitLog) appendMessageSet(ms []byte) (*segment, []int64, error) {
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+	if _, err := l.checkAndPerformSplit(); err != nil {
+		return nil, nil, err
+	}
 	var (
 		segment      = l.activeSegment()
 		basePosition = segment.Position()
 		entries      = entriesForMessageSet(basePosition, ms)
 	)
-	return l.append(segment, ms, entries)
+	offsets, err := l.append(segment, [][]byte{ms}, entries)
+	return segment, offsets, err
 }
 
-func (l *commitLog) append(segment *segment, ms []byte, entries []*entry) ([]int64, error) {
-	if err := segment.WriteMessageSet(ms, entries); err != nil {
+// syncAppend flushes the segment written to by an append to disk if
+// SyncOnAppend is enabled. This happens after releasing the append lock so
+// that concurrent appends to the segment can share the fsync. If the appended
+// messages all defer syncing, the segment is synced in the background instead
+// of waiting for it. Otherwise, the segment is flushed if the append reaches
+// FlushMessages.
+func (l *commitLog) syncAppend(segment *segment, offsets []int64, deferred bool) ([]int64, error) {
+	if !l.SyncOnAppend {
+		if err := l.flushAppend(segment, len(offsets)); err != nil {
+			return nil, err
+		}
+		return offsets, nil
+	}
+	if deferred {
+		segment.SyncAsync(l.SyncMaxDelay, func(err error) {
+			// A closed segment no longer needs syncing by appends.
+			if err != ErrSegmentClosed {
+				l.Logger.Errorf("Failed to sync log %s: %v", l.Name, err)
+			}
+		})
+		return offsets, nil
+	}
+	if err := segment.Sync(l.SyncMaxDelay); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+func (l *commitLog) append(segment *segment, bufs [][]byte, entries []*entry) ([]int64, error) {
+	if err := segment.WriteMessageSetBuffers(bufs, entries); err != nil {
 		return nil, err
 	}
 	var (
@@ -290,6 +529,56 @@ func (l *commitLog) OldestOffset() int64 {
 	return l.segments[0].FirstOffset()
 }
 
+// LogStartOffset returns the offset the log starts at. Offsets before it were
+// deleted along with the segments containing them. Offsets at or after it
+// which are not in the log were removed by compaction. If tiered storage is
+// enabled, the log starts at the first segment offloaded to it.
+func (l *commitLog) LogStartOffset() int64 {
+	start := l.localStartOffset()
+	if l.tiered != nil {
+		if tieredStart, ok := l.tiered.startOffset(start); ok {
+			return tieredStart
+		}
+	}
+	return start
+}
+
+// localStartOffset returns the base offset of the first segment on disk.
+func (l *commitLog) localStartOffset() int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.segments[0].BaseOffset
+}
+
+// EarliestOffsetAfter returns the earliest offset in the log which is greater
+// than or equal to the given offset or the next assignable offset if there is
+// none.
+func (l *commitLog) EarliestOffsetAfter(offset int64) (int64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.tiered != nil {
+		if earliest, ok := l.tiered.earliestOffsetAfter(offset, l.segments[0].BaseOffset); ok {
+			return earliest, nil
+		}
+	}
+
+	// Search the segments starting with the first one which could contain the
+	// offset. Compaction may have removed the offset or emptied the rest of
+	// the segment, in which case the next segment is searched.
+	_, idx := findSegment(l.segments, offset)
+	for ; idx < len(l.segments); idx++ {
+		entry, err := l.segments[idx].findEntry(offset)
+		if err == nil {
+			return entry.Offset, nil
+		}
+		if err != ErrEntryNotFound && err != io.EOF {
+			return 0, errors.Wrap(err, "failed to find log entry for offset")
+		}
+	}
+	return l.segments[len(l.segments)-1].NextOffset(), nil
+}
+
 // EarliestOffsetAfterTimestamp returns the earliest offset whose timestamp is
 // greater than or equal to the given timestamp.
 func (l *commitLog) EarliestOffsetAfterTimestamp(timestamp int64) (int64, error) {
@@ -327,7 +616,7 @@ func (l *commitLog) EarliestOffsetAfterTimestamp(timestamp int64) (int64, error)
 	// is greater than or equal to the target timestamp. In this case, search
 	// the next segment if there is one. If there isn't, the timestamp is
 	// beyond the end of the log so return the next assignable offset.
-	if idx < len(l.segments)-1 {
+	if idx > 0 && idx < len(l.segments) {
 		seg = l.segments[idx]
 		entry, err := seg.findEntryByTimestamp(timestamp)
 		if err != nil {
@@ -381,7 +670,7 @@ func (l *commitLog) LatestOffsetBeforeTimestamp(timestamp int64) (int64, error)
 		return 0, errors.Wrap(err, "failed to find log entry for timestamp")
 	}
 
-	return seg.lastOffset, nil
+	return seg.LastOffset(), nil
 }
 
 // SetHighWatermark sets the high watermark on the log. All messages up to and
@@ -497,17 +786,35 @@ func (l *commitLog) close() error {
 		return err
 	}
 	close(l.closed)
+	l.checkpointTask.Stop()
+	l.cleanerTask.Stop()
+	// Flush the active segment so the tail recorded on a clean shutdown
+	// survives a machine crash.
+	if !l.deleted {
+		if err := l.activeSegment().sync(); err != nil {
+			return err
+		}
+	}
 	for _, segment := range l.segments {
 		if err := segment.Close(); err != nil {
 			return err
 		}
 	}
-	return nil
+	if l.tiered != nil {
+		if err := l.tiered.evict(time.Time{}); err != nil {
+			return err
+		}
+	}
+	if l.deleted {
+		return nil
+	}
+	return l.writeCleanShutdown()
 }
 
-// Close closes each log segment file and stops the background goroutine
-// checkpointing the high watermark to disk.
+// Close closes each log segment file and stops the background tasks
+// checkpointing the high watermark to disk and cleaning the log.
 func (l *commitLog) Close() error {
+	l.stopCleaning()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -517,6 +824,7 @@ func (l *commitLog) Close() error {
 // Delete closes the log and removes all data associated with it from the
 // filesystem.
 func (l *commitLog) Delete() error {
+	l.stopCleaning()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -527,6 +835,14 @@ func (l *commitLog) Delete() error {
 	return os.RemoveAll(l.Path)
 }
 
+// stopCleaning interrupts a clean in progress, and any later ones, and waits
+// for it to return.
+func (l *commitLog) stopCleaning() {
+	l.stopCleanOnce.Do(func() { close(l.stopClean) })
+	l.cleanMu.Lock()
+	l.cleanMu.Unlock() // nolint: staticcheck
+}
+
 // IsDeleted returns true if the commit log has been deleted.
 func (l *commitLog) IsDeleted() bool {
 	l.mu.RLock()
@@ -546,7 +862,11 @@ func (l *commitLog) IsClosed() bool {
 }
 
 // Truncate removes all messages from the log starting at the given offset.
+// Appends wait for the truncation to finish since it replaces the active
+// segment.
 func (l *commitLog) Truncate(offset int64) error {
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	seg, idx := findSegment(l.segments, offset)
@@ -555,6 +875,10 @@ func (l *commitLog) Truncate(offset int64) error {
 		return nil
 	}
 
+	// Offsets from the truncation point on will be reassigned, so they must
+	// be compacted again.
+	l.compactCleaner.Truncated(offset)
+
 	// Delete all following segments.
 	deleted := 0
 	for i := idx + 1; i < len(l.segments); i++ {
@@ -589,46 +913,78 @@ func (l *commitLog) Truncate(offset int64) error {
 
 	// Replace segment containing offset with truncated segment.
 	if replace {
-		var (
-			ss              = newSegmentScanner(seg)
-			newSegment, err = seg.Truncated()
-		)
+		newSegment, err := truncateSegment(seg, offset)
 		if err != nil {
 			return err
 		}
-		for ms, e, err := ss.Scan(); err == nil; ms, e, err = ss.Scan() {
-			if ms.Offset() < offset {
-				if err := newSegment.WriteMessageSet(ms, []*entry{e}); err != nil {
-					return err
-				}
-			} else {
-				break
-			}
-		}
-		if err = newSegment.Replace(seg); err != nil {
-			return err
-		}
 		segments[idx] = newSegment
 	}
 	activeSegment := segments[len(segments)-1]
+	if activeSegment.IsCompressed() {
+		// The segment preceding the deleted ones becomes the active segment,
+		// so it must be writable.
+		newSegment, err := truncateSegment(activeSegment, activeSegment.NextOffset())
+		if err != nil {
+			return err
+		}
+		segments[len(segments)-1] = newSegment
+		activeSegment = newSegment
+	}
 	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&l.vActiveSegment)),
 		unsafe.Pointer(activeSegment))
 	l.segments = segments
 	return l.leaderEpochCache.ClearLatest(offset)
 }
 
+// truncateSegment replaces the given segment with an uncompressed copy of its
+// messages before the given offset and returns the copy.
+func truncateSegment(seg *segment, offset int64) (*segment, error) {
+	return rewriteSegment(seg, offset, math.MaxInt64)
+}
+
+// rewriteSegment replaces the given segment with an uncompressed copy of its
+// messages before the given offset and position and returns the copy.
+func rewriteSegment(seg *segment, offset, end int64) (*segment, error) {
+	var (
+		ss              = newSegmentScanner(seg)
+		newSegment, err = seg.Truncated()
+		ms              messageSet
+		e               *entry
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer ss.close()
+	for ss.position < end {
+		if ms, e, err = ss.Scan(); err != nil || ms.Offset() >= offset {
+			break
+		}
+		if err := newSegment.WriteMessageSet(ms, []*entry{e}); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil && err != io.EOF {
+		newSegment.Delete() // nolint: errcheck
+		return nil, err
+	}
+	if err = newSegment.Replace(seg); err != nil {
+		return nil, err
+	}
+	return newSegment, nil
+}
+
 func (l *commitLog) Segments() []*segment {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 	return l.segments
 }
 
-// NotifyLEO registers and returns a channel which is closed when messages past
-// the given log end offset are added to the log. If the given offset is no
-// longer the log end offset, the channel is closed immediately. Waiter is an
-// opaque value that uniquely identifies the entity waiting for data.
-func (l *commitLog) NotifyLEO(waiter interface{}, expectedLEO int64) <-chan struct{} {
-	return l.activeSegment().WaitForLEO(waiter, expectedLEO, l.NewestOffset())
+// NotifyLEO returns a channel which is closed when messages past the given log
+// end offset are added to the log. If the given offset is no longer the log
+// end offset, the channel is closed immediately. The channel is shared by all
+// callers waiting on the same log end offset.
+func (l *commitLog) NotifyLEO(expectedLEO int64) <-chan struct{} {
+	return l.activeSegment().WaitForLEO(expectedLEO, l.NewestOffset())
 }
 
 // SetReadonly marks the log as readonly. When in readonly mode, new messages
@@ -662,6 +1018,12 @@ func (l *commitLog) IsConcurrencyControlEnabled() bool {
 	return l.Options.ConcurrencyControl
 }
 
+// SetRetention changes the limits of the log's retention policy. They are
+// enforced the next time the log is cleaned. A limit of 0 disables it.
+func (l *commitLog) SetRetention(bytes, messages int64, age time.Duration) {
+	l.deleteCleaner.setRetention(bytes, messages, age)
+}
+
 // checkAndPerformSplit determines if a new log segment should be rolled out
 // either because the active segment is full or MaxSegmentAge has passed since
 // the first message was written to it. It then performs the split if eligible,
@@ -686,14 +1048,38 @@ func (l *commitLog) checkAndPerformSplit() (bool, error) {
 			return false, err
 		}
 		activeSegment.Seal()
+		// Messages counted towards the flush policy were appended to the
+		// sealed segment, which is no longer flushed once it's rolled.
+		if l.flushEnabled() && atomic.LoadInt64(&l.unflushed) > 0 {
+			if err := l.flush(activeSegment); err != nil {
+				return false, err
+			}
+		}
+		if l.compressionEnabled() {
+			// Compress the sealed segment in the background so the
+			// append which rolled it doesn't wait for it.
+			l.TimerWheel.Schedule(0, l.compressTask)
+		}
 		return true, nil
 	}
 }
 
+// indexAccess returns the settings tuning how the log's indexes are allocated
+// and accessed.
+func (l *commitLog) indexAccess() indexAccess {
+	return indexAccess{
+		advice:           l.IndexAdvice,
+		lockBytes:        l.IndexLockBytes,
+		positional:       l.IndexPositionalIO,
+		preallocateBytes: l.IndexPreallocateBytes,
+	}
+}
+
 func (l *commitLog) split(oldActiveSegment *segment) error {
 	offset := l.NewestOffset() + 1
 	l.Logger.Debugf("Appending new log segment for %s with base offset %d", l.Path, offset)
-	segment, err := newSegment(l.Path, offset, l.MaxSegmentBytes, true, "")
+	segment, err := newSegment(l.Path, offset, l.MaxSegmentBytes, true, "", l.IOUring,
+		l.IndexIntervalBytes, l.indexAccess(), l.BlockCache, l.keys, l.MessageFormat)
 	if err != nil {
 		return err
 	}
@@ -713,42 +1099,76 @@ func (l *commitLog) split(oldActiveSegment *segment) error {
 	return nil
 }
 
-func (l *commitLog) cleanerLoop() {
-	ticker := time.NewTicker(l.CleanerInterval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-		case <-l.closed:
-			return
-		}
+// cleanTask runs every CleanerInterval until the log is closed to split the
+// active segment if it's too old and apply retention and compaction rules.
+func (l *commitLog) cleanTask() time.Duration {
+	if l.IsClosed() {
+		return 0
+	}
 
-		// Check to see if the active segment should be split.
-		split, err := l.checkAndPerformSplit()
-		if err != nil {
-			l.Logger.Errorf("Failed to split log %s: %v", l.Path, err)
-			continue
-		}
+	// Check to see if the active segment should be split.
+	split, err := l.checkAndPerformSplit()
+	if err != nil {
+		l.Logger.Errorf("Failed to split log %s: %v", l.Path, err)
+		return l.CleanerInterval
+	}
 
-		// If we rolled a new segment, we don't need to run the cleaner since
-		// it already ran.
-		if split {
-			continue
-		}
+	// If we rolled a new segment, we don't need to run the cleaner since it
+	// already ran.
+	if split {
+		return l.CleanerInterval
+	}
 
-		if err := l.Clean(); err != nil {
-			l.Logger.Errorf("Failed to clean log %s: %v", l.Path, err)
-		}
+	// Compacted logs are cleaned by the scheduler's workers, if any, so they
+	// share its IO budget.
+	if l.Compact && l.CompactionScheduler != nil {
+		l.CompactionScheduler.schedule(l)
+		return l.CleanerInterval
+	}
+	l.runClean(context.Background())
+	return l.CleanerInterval
+}
+
+// runClean cleans the log, logging any error other than an interruption.
+func (l *commitLog) runClean(ctx context.Context) {
+	if err := l.Clean(ctx); err != nil && err != context.Canceled {
+		l.Logger.Errorf("Failed to clean log %s: %v", l.Path, err)
 	}
 }
 
 // Clean applies retention and compaction rules against the log, if applicable.
-func (l *commitLog) Clean() error {
+// It stops as soon as possible when the context is canceled or the log is
+// closed, keeping the progress made so far, and returns the context's error.
+// An interrupted compaction resumes where it stopped on the next clean.
+func (l *commitLog) Clean(ctx context.Context) error {
+	l.cleanMu.Lock()
+	defer l.cleanMu.Unlock()
+
+	ctx, cancel := l.cleanContext(ctx)
+	defer cancel()
+	select {
+	case <-l.stopClean:
+		return context.Canceled
+	default:
+	}
+
+	if l.compressionEnabled() {
+		if err := l.compress(ctx); err != nil {
+			return err
+		}
+	}
 	l.mu.RLock()
 	oldSegments := l.segments
 	l.mu.RUnlock()
-	cleaned, epochCache, err := l.clean(oldSegments)
-	if err != nil {
+	if l.tiered != nil {
+		// Offload sealed segments before retention can delete them. If this
+		// fails, skip cleaning so they are offloaded on the next clean.
+		if err := l.offload(ctx, oldSegments); err != nil {
+			return err
+		}
+	}
+	cleaned, epochCache, err := l.clean(ctx, oldSegments)
+	if cleaned == nil {
 		return err
 	}
 	l.mu.Lock()
@@ -769,7 +1189,24 @@ func (l *commitLog) Clean() error {
 		err = l.leaderEpochCache.ClearEarliest(l.segments[0].BaseOffset)
 	}
 	l.mu.Unlock()
-	return err
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// cleanContext returns a context derived from the given one which is also
+// canceled when the log is closed.
+func (l *commitLog) cleanContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-l.stopClean:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
 }
 
 // rebaseSegments adds the segments in from to the end of the slice of segments
@@ -788,41 +1225,58 @@ func (l *commitLog) rebaseSegments(from, to []*segment, epochCache *leaderEpochC
 
 // clean returns the cleaned segments and, if compaction ran, a
 // *leaderEpochCache maintaining the start offset for each new leader epoch. If
-// compaction did not run, the leaderEpochCache will be nil.
-func (l *commitLog) clean(segments []*segment) ([]*segment, *leaderEpochCache, error) {
-	cleaned, err := l.deleteCleaner.Clean(segments)
+// compaction did not run, the leaderEpochCache will be nil. If the context is
+// canceled, the segments cleaned so far are returned along with the context's
+// error.
+func (l *commitLog) clean(ctx context.Context, segments []*segment) ([]*segment,
+	*leaderEpochCache, error) {
+
+	// Segments which have not been offloaded to tiered storage yet are kept.
+	deletable := len(segments)
+	if l.tiered != nil {
+		deletable = l.tiered.offloaded(segments)
+	}
+	cleaned, err := l.deleteCleaner.CleanFirst(segments, deletable)
 	if err != nil {
 		return nil, nil, err
 	}
 	var epochCache *leaderEpochCache
-	if l.Compact {
-		cleaned, epochCache, err = l.compactCleaner.Compact(l.HighWatermark(), cleaned)
-		if err != nil {
+	if l.Compact && ctx.Err() == nil {
+		cleaned, epochCache, err = l.compactCleaner.Compact(ctx, l.HighWatermark(), cleaned)
+		if err != nil && ctx.Err() == nil {
 			return nil, nil, err
 		}
 	}
-	return cleaned, epochCache, nil
+	return cleaned, epochCache, ctx.Err()
 }
 
-func (l *commitLog) checkpointHWLoop() {
-	ticker := time.NewTicker(l.HWCheckpointInterval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-		case <-l.closed:
-			return
-		}
-		l.mu.RLock()
-		if l.deleted {
-			l.mu.RUnlock()
-			return
-		}
+// checkpointHWTask runs every HWCheckpointInterval until the log is closed
+// to checkpoint the HW to disk. If FlushInterval is set, it also flushes the
+// active segment when it's due and runs again in time for the next flush.
+func (l *commitLog) checkpointHWTask() time.Duration {
+	l.mu.RLock()
+	if l.deleted || l.IsClosed() {
+		l.mu.RUnlock()
+		return 0
+	}
+	now := time.Now()
+	if !now.Before(l.nextCheckpoint) {
 		if err := l.checkpointHW(); err != nil {
 			panic(errors.Wrap(err, "failed to checkpoint high watermark"))
 		}
-		l.mu.RUnlock()
+		l.nextCheckpoint = now.Add(l.HWCheckpointInterval)
+	}
+	segment := l.activeSegment()
+	l.mu.RUnlock()
+
+	// Flush without holding the log lock since segments can't be rolled
+	// while it's held.
+	if l.flushDue(now) {
+		if err := l.flush(segment); err != nil {
+			l.Logger.Errorf("Failed to flush log %s: %v", l.Name, err)
+		}
 	}
+	return l.checkpointTaskDelay(time.Now())
 }
 
 func (l *commitLog) checkpointHW() error {
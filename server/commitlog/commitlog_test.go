@@ -4,11 +4,16 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/liftbridge-io/liftbridge/server/timerwheel"
 )
 
 var (
@@ -143,6 +148,108 @@ func TestCommitLogRecover(t *testing.T) {
 	}
 }
 
+// Ensure only the active segment is opened when a log is opened and other
+// segments are opened when they are first accessed.
+func TestCommitLogOpenLazy(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	// Append some messages.
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	// Only the active segment is opened.
+	segments := l.Segments()
+	require.True(t, len(segments) > 2)
+	for i, seg := range segments {
+		require.Equal(t, i == len(segments)-1, atomic.LoadUint32(&seg.loaded) == 1)
+	}
+
+	// Reading the log opens the remaining segments.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < numMsgs; i++ {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+	for _, seg := range segments {
+		require.Equal(t, uint32(1), atomic.LoadUint32(&seg.loaded))
+	}
+	require.Equal(t, int64(0), l.OldestOffset())
+	require.Equal(t, int64(numMsgs-1), l.NewestOffset())
+}
+
+// Ensure the tail of the log is restored after a clean shutdown and recovered
+// if the log changed after it.
+func TestCommitLogCleanShutdown(t *testing.T) {
+	opts := Options{
+		Path:               tempDir(t),
+		IndexIntervalBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i)}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+	marker := filepath.Join(opts.Path, cleanShutdownFileName)
+	require.FileExists(t, marker)
+
+	// Reopen the log, which restores the tail and removes the marker.
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	require.True(t, l.cleanShutdown)
+	_, err := os.Stat(marker)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, int64(numMsgs-1), l.NewestOffset())
+	require.Equal(t, int64(numMsgs-1), l.activeSegment().LastWriteTime())
+	offsets, err := l.Append([]*Message{{Value: []byte("10"), Timestamp: 10}})
+	require.NoError(t, err)
+	require.Equal(t, []int64{10}, offsets)
+	require.NoError(t, l.Close())
+
+	// Append to the log after closing it, which invalidates the marker.
+	tail := l.activeSegment().Tail()
+	ms, _, err := newMessageSetFromProto(11, tail.Position,
+		[]*Message{{Value: []byte("11"), Timestamp: 11}}, false)
+	require.NoError(t, err)
+	f, err := os.OpenFile(l.activeSegment().logPath(), os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.Write(ms)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Reopen the log, which recovers the tail.
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+	require.False(t, l.cleanShutdown)
+	_, err = os.Stat(marker)
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, int64(11), l.NewestOffset())
+	require.Equal(t, int64(11), l.activeSegment().LastWriteTime())
+}
+
 func TestCommitLogRecoverHW(t *testing.T) {
 	opts := Options{
 		Path:            tempDir(t),
@@ -160,6 +267,358 @@ func TestCommitLogRecoverHW(t *testing.T) {
 	require.Equal(t, int64(100), l.HighWatermark())
 }
 
+// Ensure an atomic batch whose last message was not written is truncated
+// when the log is recovered.
+func TestCommitLogRecoverIncompleteBatch(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	// Append a complete batch followed by the start of another batch.
+	_, err := l.Append([]*Message{{Value: []byte("single")}})
+	require.NoError(t, err)
+	_, err = l.Append([]*Message{
+		{Value: []byte("one"), Attributes: AttrBatchContinues},
+		{Value: []byte("two")},
+	})
+	require.NoError(t, err)
+	_, err = l.Append([]*Message{
+		{Value: []byte("three"), Attributes: AttrBatchContinues},
+		{Value: []byte("four"), Attributes: AttrBatchContinues},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), l.NewestOffset())
+
+	// Close the log and reopen, then ensure the incomplete batch was removed.
+	require.NoError(t, l.Close())
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+	require.Equal(t, int64(2), l.NewestOffset())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i, continues := range []bool{false, true, false} {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, continues, msg.BatchContinues())
+	}
+
+	// New messages are appended after the complete batch.
+	offsets, err := l.Append([]*Message{{Value: []byte("five")}})
+	require.NoError(t, err)
+	require.Equal(t, []int64{3}, offsets)
+}
+
+// Ensure a corrupt or partially written tail is truncated when the log is
+// recovered, whether or not the corrupt message is indexed.
+func TestCommitLogRecoverCorruptTail(t *testing.T) {
+	for _, indexInterval := range []int64{0, 1024} {
+		t.Run(strconv.FormatInt(indexInterval, 10), func(t *testing.T) {
+			opts := Options{
+				Path:               tempDir(t),
+				IndexIntervalBytes: indexInterval,
+			}
+			l, cleanup := setupWithOptions(t, opts)
+			defer cleanup()
+
+			for i := 0; i < 5; i++ {
+				_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+				require.NoError(t, err)
+			}
+			segment := l.activeSegment()
+			require.NoError(t, l.Close())
+			require.NoError(t, os.Remove(filepath.Join(opts.Path, cleanShutdownFileName)))
+
+			// Corrupt the last message and follow it with part of another.
+			data, err := ioutil.ReadFile(segment.logPath())
+			require.NoError(t, err)
+			data[len(data)-1] ^= 0xff
+			data = append(data, make([]byte, msgSetHeaderLen/2)...)
+			require.NoError(t, ioutil.WriteFile(segment.logPath(), data, 0666))
+
+			l, cleanup = setupWithOptions(t, opts)
+			defer cleanup()
+			defer l.Close()
+			require.False(t, l.cleanShutdown)
+			require.Equal(t, int64(3), l.NewestOffset())
+			offsets, err := l.Append([]*Message{{Value: []byte("4")}})
+			require.NoError(t, err)
+			require.Equal(t, []int64{4}, offsets)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r, err := l.NewReader(0, true)
+			require.NoError(t, err)
+			headers := make([]byte, 28)
+			for i := 0; i < 5; i++ {
+				msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+				require.NoError(t, err)
+				require.Equal(t, int64(i), offset)
+				require.Equal(t, strconv.Itoa(i), string(msg.Value()))
+			}
+		})
+	}
+}
+
+// Ensure an atomic batch can be appended with concurrency control enabled and
+// the expected offset is checked against the first message of the batch.
+func TestAppendBatchConcurrencyControl(t *testing.T) {
+	opts := Options{
+		Path:               tempDir(t),
+		MaxSegmentBytes:    1024,
+		ConcurrencyControl: true,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	offsets, err := l.Append([]*Message{
+		{Value: []byte("one"), Offset: 0, Attributes: AttrBatchContinues},
+		{Value: []byte("two"), Offset: -1},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1}, offsets)
+
+	_, err = l.Append([]*Message{
+		{Value: []byte("three"), Offset: 0, Attributes: AttrBatchContinues},
+		{Value: []byte("four"), Offset: -1},
+	})
+	require.Equal(t, ErrIncorrectOffset, err)
+	require.Equal(t, int64(1), l.NewestOffset())
+
+	// Messages which are not a single batch are rejected.
+	require.Panics(t, func() {
+		l.Append([]*Message{
+			{Value: []byte("three"), Offset: -1},
+			{Value: []byte("four"), Offset: -1},
+		})
+	})
+}
+
+// Ensure concurrent appends with SyncOnAppend enabled are all written with
+// distinct offsets.
+func TestAppendSyncOnAppend(t *testing.T) {
+	opts := Options{
+		Path:         tempDir(t),
+		SyncOnAppend: true,
+		SyncMaxDelay: time.Millisecond,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	var (
+		n       = 20
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		offsets = make(map[int64]struct{})
+	)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			appended, err := l.Append(msgs)
+			require.NoError(t, err)
+			mu.Lock()
+			for _, offset := range appended {
+				offsets[offset] = struct{}{}
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	require.Len(t, offsets, n*len(msgs))
+	require.Equal(t, int64(n*len(msgs)-1), l.NewestOffset())
+}
+
+// Ensure appends of messages which defer syncing return without waiting for
+// the fsync, both when appending messages and replicated message sets, while
+// other appends wait for it.
+func TestAppendSyncDeferred(t *testing.T) {
+	opts := Options{
+		Path:         tempDir(t),
+		SyncOnAppend: true,
+		SyncMaxDelay: 200 * time.Millisecond,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	deferred := []*Message{
+		{Value: []byte("one"), Attributes: AttrSyncDeferred},
+		{Value: []byte("two"), Attributes: AttrSyncDeferred | AttrBatchContinues},
+		{Value: []byte("three"), Attributes: AttrSyncDeferred},
+	}
+	start := time.Now()
+	offsets, err := l.Append(deferred)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2}, offsets)
+	require.True(t, time.Since(start) < opts.SyncMaxDelay)
+
+	set, _, err := newMessageSetFromProto(3, 0, deferred, false)
+	require.NoError(t, err)
+	require.True(t, messageSetSyncDeferred(set))
+	start = time.Now()
+	offsets, err = l.AppendMessageSet(set)
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 4, 5}, offsets)
+	require.True(t, time.Since(start) < opts.SyncMaxDelay)
+
+	// A single message which doesn't defer syncing makes the append wait.
+	mixed := []*Message{
+		{Value: []byte("four"), Attributes: AttrSyncDeferred},
+		{Value: []byte("five")},
+	}
+	require.False(t, syncDeferred(mixed))
+	set, _, err = newMessageSetFromProto(6, 0, mixed, false)
+	require.NoError(t, err)
+	require.False(t, messageSetSyncDeferred(set))
+	start = time.Now()
+	_, err = l.Append(mixed)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= opts.SyncMaxDelay)
+}
+
+// Ensure the active segment is flushed once FlushMessages messages have been
+// appended since the last flush and when a segment with unflushed messages is
+// rolled.
+func TestAppendFlushMessages(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+		FlushMessages:   5,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	for i := 0; i < 4; i++ {
+		_, err := l.Append([]*Message{{Value: []byte("foo")}})
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(4), atomic.LoadInt64(&l.unflushed))
+	lastFlush := atomic.LoadInt64(&l.lastFlush)
+
+	_, err := l.Append([]*Message{{Value: []byte("foo")}})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), atomic.LoadInt64(&l.unflushed))
+	require.True(t, atomic.LoadInt64(&l.lastFlush) > lastFlush)
+
+	// Messages appended to a segment before it's rolled are flushed, so only
+	// the message appended to the new segment is unflushed.
+	value := make([]byte, 300)
+	for len(l.Segments()) == 1 {
+		require.True(t, atomic.LoadInt64(&l.unflushed) < opts.FlushMessages)
+		_, err := l.Append([]*Message{{Value: value}})
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&l.unflushed))
+}
+
+// Ensure the active segment is flushed once FlushInterval has passed since the
+// last flush if messages have been appended, independently of HW checkpoints.
+func TestAppendFlushInterval(t *testing.T) {
+	opts := Options{
+		Path:                 tempDir(t),
+		FlushInterval:        20 * time.Millisecond,
+		HWCheckpointInterval: time.Hour,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append([]*Message{{Value: []byte("foo")}})
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt64(&l.unflushed) == 0
+		}, 5*time.Second, time.Millisecond)
+	}
+}
+
+// Ensure SyncOnAppend supersedes the flush policy.
+func TestAppendFlushSyncOnAppend(t *testing.T) {
+	opts := Options{
+		Path:          tempDir(t),
+		SyncOnAppend:  true,
+		FlushMessages: 5,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	require.False(t, l.flushEnabled())
+	_, err := l.Append(msgs)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), atomic.LoadInt64(&l.unflushed))
+}
+
+// Ensure messages can be found by offset and timestamp, recovered, and
+// truncated when only some of them are indexed.
+func TestSparseIndex(t *testing.T) {
+	opts := Options{
+		Path:               tempDir(t),
+		MaxSegmentBytes:    1000,
+		IndexIntervalBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	// Append some messages.
+	numMsgs := 50
+	for i := 0; i < numMsgs; i++ {
+		msg := &Message{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i * 10)}
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+
+	var (
+		segments = l.Segments()
+		count    int64
+	)
+	require.True(t, len(segments) > 1)
+	for _, seg := range segments {
+		require.True(t, seg.Index.CountEntries() < seg.MessageCount())
+		count += seg.MessageCount()
+	}
+	require.Equal(t, int64(numMsgs), count)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	headers := make([]byte, 28)
+	for i := 0; i < numMsgs; i++ {
+		r, err := l.NewReader(int64(i), true)
+		require.NoError(t, err)
+		msg, offset, timestamp, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, int64(i*10), timestamp)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+
+		offset, err = l.EarliestOffsetAfterTimestamp(int64(i*10 - 5))
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+	}
+
+	// Close the log and reopen, then ensure the last message, which is not
+	// indexed, is recovered.
+	require.NoError(t, l.Close())
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+	require.Equal(t, int64(numMsgs-1), l.NewestOffset())
+
+	require.NoError(t, l.Truncate(37))
+	require.Equal(t, int64(36), l.NewestOffset())
+	offsets, err := l.Append([]*Message{{Value: []byte("37"), Timestamp: 370}})
+	require.NoError(t, err)
+	require.Equal(t, []int64{37}, offsets)
+}
+
 func TestOverrideHighWatermark(t *testing.T) {
 	l, cleanup := setup(t)
 	defer l.Close()
@@ -183,6 +642,59 @@ func BenchmarkCommitLog(b *testing.B) {
 	}
 }
 
+func BenchmarkNewMessageSetFromProto(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ms, _, err := newMessageSetFromProto(int64(i), 0, msgs, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		releaseMessageSet(ms)
+	}
+}
+
+func BenchmarkAppendSyncOnAppend(b *testing.B) {
+	opts := Options{
+		Path:         tempDir(b),
+		SyncOnAppend: true,
+		SyncMaxDelay: 100 * time.Microsecond,
+	}
+	l, cleanup := setupWithOptions(b, opts)
+	defer cleanup()
+	defer l.Close()
+
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := l.Append(msgs); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkSegmentScanner(b *testing.B) {
+	l, cleanup := setupWithOptions(b, Options{Path: tempDir(b)})
+	defer cleanup()
+	defer l.Close()
+	for i := 0; i < 100; i++ {
+		_, err := l.Append(msgs)
+		require.NoError(b, err)
+	}
+	seg := l.activeSegment()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ss := newSegmentScanner(seg)
+		for _, _, err := ss.Scan(); err == nil; _, _, err = ss.Scan() {
+		}
+		ss.close()
+	}
+}
+
 func TestOffsets(t *testing.T) {
 	l, cleanup := setupWithOptions(t, Options{
 		Path:            tempDir(t),
@@ -228,7 +740,7 @@ func TestCleaner(t *testing.T) {
 	_, err = l.Append(msgs)
 	require.NoError(t, err)
 
-	require.NoError(t, l.Clean())
+	require.NoError(t, l.Clean(context.Background()))
 
 	require.Equal(t, 1, len(l.Segments()))
 	for i, s := range l.Segments() {
@@ -288,7 +800,7 @@ func TestCleanerDeleteLeaderEpochOffsets(t *testing.T) {
 	require.Equal(t, int64(14), l.LastOffsetForLeaderEpoch(3))
 
 	// Force a clean.
-	require.NoError(t, l.Clean())
+	require.NoError(t, l.Clean(context.Background()))
 
 	require.Equal(t, 5, len(l.Segments()))
 	require.Equal(t, int64(10), l.OldestOffset())
@@ -359,7 +871,7 @@ func TestCleanerReplaceLeaderEpochOffsets(t *testing.T) {
 	require.Equal(t, int64(14), l.LastOffsetForLeaderEpoch(3))
 
 	// Force a clean.
-	require.NoError(t, l.Clean())
+	require.NoError(t, l.Clean(context.Background()))
 
 	require.Equal(t, 3, len(l.Segments()))
 	require.Equal(t, int64(4), l.OldestOffset())
@@ -425,6 +937,160 @@ func TestEarliestOffsetAfterTimestamp(t *testing.T) {
 	require.Equal(t, int64(3), offset)
 }
 
+// Ensure timestamp lookups use the segments' time indexes, which are sparse
+// if the offset index is, and find the same offsets after the log is
+// reopened.
+func TestOffsetByTimestampTimeIndex(t *testing.T) {
+	for _, interval := range []int64{0, 64} {
+		opts := Options{
+			Path:               tempDir(t),
+			MaxSegmentBytes:    8000,
+			IndexIntervalBytes: interval,
+		}
+		l, cleanup := setupWithOptions(t, opts)
+
+		appendMsgs := func(start, num int) {
+			for i := start; i < start+num; i++ {
+				_, err := l.Append([]*Message{{Value: []byte("x"), Timestamp: int64(i * 10)}})
+				require.NoError(t, err)
+			}
+		}
+		check := func(numMsgs int) {
+			for i := 0; i < numMsgs; i++ {
+				offset, err := l.EarliestOffsetAfterTimestamp(int64(i * 10))
+				require.NoError(t, err)
+				require.Equal(t, int64(i), offset)
+				offset, err = l.EarliestOffsetAfterTimestamp(int64(i*10 - 5))
+				require.NoError(t, err)
+				require.Equal(t, int64(i), offset)
+				offset, err = l.LatestOffsetBeforeTimestamp(int64(i*10 + 5))
+				require.NoError(t, err)
+				require.Equal(t, int64(i), offset)
+			}
+			offset, err := l.EarliestOffsetAfterTimestamp(int64(numMsgs * 10))
+			require.NoError(t, err)
+			require.Equal(t, int64(numMsgs), offset)
+		}
+
+		appendMsgs(0, 300)
+		check(300)
+		segments := l.Segments()
+		require.True(t, len(segments) > 1)
+		for _, seg := range segments[:len(segments)-1] {
+			// Sealed segments end with an entry for their last message.
+			last, ok := seg.TimeIndex.lastEntry()
+			require.True(t, ok)
+			require.Equal(t, seg.LastOffset(), last.Offset)
+			require.Equal(t, seg.LastOffset()*10, last.Timestamp)
+			if interval > 0 {
+				require.True(t, seg.TimeIndex.entries < seg.MessageCount())
+			}
+		}
+
+		appendMsgs(300, 200)
+		check(500)
+		require.Equal(t, int64(4990), l.activeSegment().MaxTimestamp())
+
+		require.NoError(t, l.Close())
+		reopened, err := New(opts)
+		require.NoError(t, err)
+		l = reopened.(*commitLog)
+		check(500)
+		require.NoError(t, l.Close())
+		cleanup()
+	}
+}
+
+// Ensure timestamp lookups find the first message at or past the timestamp
+// when message timestamps are not ordered, e.g. because they were set by
+// producers, including in sparsely indexed segments whose time index is
+// rebuilt.
+func TestOffsetByTimestampUnordered(t *testing.T) {
+	for _, interval := range []int64{0, 8192} {
+		opts := Options{
+			Path:               tempDir(t),
+			MaxSegmentBytes:    100000,
+			IndexIntervalBytes: interval,
+		}
+		l, cleanup := setupWithOptions(t, opts)
+
+		timestamps := []int64{10, 50, 20, 30, 60, 40, 45, 100, 70, 80, 55, 90, 120, 110}
+		value := make([]byte, 1500)
+		for _, ts := range timestamps {
+			_, err := l.Append([]*Message{{Value: value, Timestamp: ts}})
+			require.NoError(t, err)
+		}
+		entries := l.activeSegment().TimeIndex.entries
+		require.True(t, entries > 1)
+		check := func() {
+			for target := int64(0); target <= 130; target += 5 {
+				expected := int64(len(timestamps))
+				for i, ts := range timestamps {
+					if ts >= target {
+						expected = int64(i)
+						break
+					}
+				}
+				offset, err := l.EarliestOffsetAfterTimestamp(target)
+				require.NoError(t, err)
+				require.Equal(t, expected, offset, "timestamp %d", target)
+			}
+		}
+		check()
+
+		// Rebuild the time index from the log.
+		require.NoError(t, l.Close())
+		require.NoError(t, os.Remove(l.Segments()[0].timeIndexPath()))
+		reopened, err := New(opts)
+		require.NoError(t, err)
+		l = reopened.(*commitLog)
+		check()
+		require.Equal(t, entries, l.activeSegment().TimeIndex.entries)
+		require.Equal(t, int64(120), l.activeSegment().MaxTimestamp())
+		require.NoError(t, l.Close())
+		cleanup()
+	}
+}
+
+// Ensure time index entries of messages removed when the log is recovered
+// are removed too.
+func TestTimeIndexRecovery(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100000,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	// Messages are about 1KB, so every fourth one is added to the time index.
+	value := make([]byte, 1024)
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: value, Timestamp: int64(i * 10)}})
+		require.NoError(t, err)
+	}
+	segment := l.activeSegment()
+	last, ok := segment.TimeIndex.lastEntry()
+	require.True(t, ok)
+	require.Equal(t, int64(8), last.Offset)
+	entry, err := segment.findEntry(7)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// Cut the log in the middle of the message at offset 7.
+	require.NoError(t, os.Truncate(segment.logPath(), logHeaderLen+entry.Position+5))
+	reopened, err := New(opts)
+	require.NoError(t, err)
+	l = reopened.(*commitLog)
+	defer l.Close()
+	require.Equal(t, int64(6), l.NewestOffset())
+	last, ok = l.activeSegment().TimeIndex.lastEntry()
+	require.True(t, ok)
+	require.Equal(t, int64(4), last.Offset)
+	require.Equal(t, int64(60), l.activeSegment().MaxTimestamp())
+	offset, err := l.EarliestOffsetAfterTimestamp(65)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), offset)
+}
+
 // Ensure EarliestOffsetAfterTimestamp returns the next assignable offset
 // when the log is empty.
 func TestEarliestOffsetAfterTimestampEmptyLog(t *testing.T) {
@@ -440,6 +1106,44 @@ func TestEarliestOffsetAfterTimestampEmptyLog(t *testing.T) {
 	require.Equal(t, int64(0), offset)
 }
 
+// Ensure LogStartOffset returns the base offset of the oldest segment and
+// EarliestOffsetAfter skips offsets removed by compaction.
+func TestEarliestOffsetAfter(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+		Compact:         true,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("baz"), []byte("second")},
+		{[]byte("qux"), []byte("first")},
+		{[]byte("foo"), []byte("fourth")},
+		{[]byte("baz"), []byte("third")},
+	}
+	appendToLog(t, l, entries, true)
+	require.Equal(t, int64(0), l.LogStartOffset())
+
+	// Compaction removes the first two segments entirely and offsets 5 and 6
+	// from the two segments after them.
+	require.NoError(t, l.Clean(context.Background()))
+	require.Equal(t, int64(4), l.LogStartOffset())
+
+	for offset, expected := range []int64{4, 4, 4, 4, 4, 7, 7, 7, 8, 9, 10, 10} {
+		actual, err := l.EarliestOffsetAfter(int64(offset))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual, offset)
+	}
+}
+
 // Ensure LatestOffsetBeforeTimestamp returns the latest offset whose
 // timestamp is less than or equal to the given timestamp.
 func TestLatestOffsetBeforeTimestamp(t *testing.T) {
@@ -580,8 +1284,7 @@ func TestNotifyLEOMismatch(t *testing.T) {
 
 	// Notify LEO should return a closed channel because the LEO is different
 	// than the expected LEO.
-	waiter := struct{}{}
-	ch := l.NotifyLEO(waiter, leo)
+	ch := l.NotifyLEO(leo)
 	select {
 	case <-ch:
 	default:
@@ -612,9 +1315,8 @@ func TestNotifyLEONewData(t *testing.T) {
 	// Get current log end offset.
 	leo := l.NewestOffset()
 
-	// Register a waiter.
-	waiter := struct{}{}
-	ch := l.NotifyLEO(waiter, leo)
+	// Wait for new data.
+	ch := l.NotifyLEO(leo)
 
 	select {
 	case <-ch:
@@ -637,8 +1339,8 @@ func TestNotifyLEONewData(t *testing.T) {
 	}
 }
 
-// Ensure NotifyLEO returns the same channel if the waiter is already
-// registered in the log.
+// Ensure NotifyLEO returns the same channel to callers waiting on the same log
+// end offset.
 func TestNotifyLEOIdempotent(t *testing.T) {
 	l, cleanup := setupWithOptions(t, Options{
 		Path:            tempDir(t),
@@ -650,12 +1352,11 @@ func TestNotifyLEOIdempotent(t *testing.T) {
 	// Get current log end offset.
 	leo := l.NewestOffset()
 
-	// Register a waiter.
-	waiter := struct{}{}
-	ch1 := l.NotifyLEO(waiter, leo)
+	// Wait for new data.
+	ch1 := l.NotifyLEO(leo)
 
-	// Register the same waiter again. This should return the same channel.
-	ch2 := l.NotifyLEO(waiter, leo)
+	// Wait again. This should return the same channel.
+	ch2 := l.NotifyLEO(leo)
 
 	require.Equal(t, ch1, ch2)
 }
@@ -767,6 +1468,39 @@ func TestSetReadonlyWakeHWEqualsLEO(t *testing.T) {
 	require.Equal(t, ErrCommitLogReadonly, err)
 }
 
+// Ensure the HW is checkpointed and retention is enforced by tasks run on a
+// shared timer wheel.
+func TestCommitLogTimerWheel(t *testing.T) {
+	wheel := timerwheel.New(1, time.Millisecond)
+	defer wheel.Stop()
+	opts := Options{
+		Path:                 tempDir(t),
+		MaxSegmentBytes:      6,
+		MaxLogMessages:       2,
+		CleanerInterval:      10 * time.Millisecond,
+		HWCheckpointInterval: 10 * time.Millisecond,
+		TimerWheel:           wheel,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	for _, msg := range msgs {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(3)
+
+	require.Eventually(t, func() bool {
+		hw, err := ioutil.ReadFile(filepath.Join(opts.Path, hwFileName))
+		return err == nil && string(hw) == "3"
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return l.OldestOffset() > 0
+	}, 5*time.Second, time.Millisecond)
+
+	require.NoError(t, l.Close())
+}
+
 func setup(t require.TestingT) (*commitLog, func()) {
 	opts := Options{
 		Path:            tempDir(t),
@@ -780,6 +1514,8 @@ func setupWithOptions(t require.TestingT, opts Options) (*commitLog, func()) {
 	l, err := New(opts)
 	require.NoError(t, err)
 	return l.(*commitLog), func() {
+		// Stop the background tasks before removing the log directory.
+		l.Close() // nolint: errcheck
 		remove(t, opts.Path)
 	}
 }
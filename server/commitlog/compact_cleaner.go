@@ -1,6 +1,9 @@
 package commitlog
 
 import (
+	"context"
+	"io"
+	"sort"
 	"sync"
 	"time"
 
@@ -9,7 +12,11 @@ import (
 	"github.com/liftbridge-io/liftbridge/server/logger"
 )
 
-const defaultCompactMaxGoroutines = 10
+const (
+	defaultCompactMaxGoroutines = 10
+	defaultCompactKeepVersions  = 1
+	defaultTombstoneRetention   = 24 * time.Hour
+)
 
 // compactCleanerOptions contains configuration settings for the
 // compactCleaner.
@@ -17,12 +24,59 @@ type compactCleanerOptions struct {
 	Logger        logger.Logger
 	Name          string
 	MaxGoroutines int
+	KeepVersions  int
+
+	// TombstoneRetention is how long a tombstone, i.e. a message with a key
+	// and no value, is retained after all earlier versions of its key have
+	// been removed. This gives consumers time to see the delete.
+	TombstoneRetention time.Duration
+
+	// MinDirtyRatio is the minimum ratio of dirty, i.e. not yet compacted,
+	// bytes to total bytes in the compactable segments of the log for
+	// compaction to run. 0 compacts on every run.
+	MinDirtyRatio float64
+
+	// MaxBytes is the maximum number of dirty segment bytes compacted in a
+	// single run, 0 meaning no limit. At least one dirty segment is compacted
+	// per run.
+	MaxBytes int64
+
+	// MaxKeys is the maximum number of distinct keys retained, 0 meaning no
+	// limit. Beyond it, the keys whose latest versions are the oldest are
+	// evicted, i.e. all of their versions are removed, as if they had been
+	// deleted without a tombstone.
+	MaxKeys int64
+
+	// Scheduler is the CompactionScheduler whose IO budget limits the bytes
+	// compaction reads and writes, nil meaning no limit.
+	Scheduler *CompactionScheduler
+
+	// Path is the log directory, where the progress of an interrupted
+	// compaction is checkpointed so the next one resumes from it.
+	Path string
+
+	// LeaderEpochCache is the log's leader epoch cache. It provides the
+	// leader epochs of the segments an interrupted compaction did not get to.
+	LeaderEpochCache *leaderEpochCache
 }
 
 // compactCleaner implements the compaction policy which replaces segments with
-// compacted ones, i.e. retaining only the last message for a given key.
+// compacted ones, i.e. retaining only the last message, or last KeepVersions
+// messages, for a given key. Keys whose last message is a tombstone retain
+// only the tombstone, which is itself removed once older than
+// TombstoneRetention. If MaxKeys is set, only the most recently written keys
+// are retained.
 type compactCleaner struct {
 	compactCleanerOptions
+	mu sync.Mutex
+	// firstDirtyOffset is the offset of the first message which has not been
+	// compacted. It's not persisted, so the first compactions after a restart
+	// consider the whole log dirty.
+	firstDirtyOffset int64
+	// resumeOffset is the base offset of the first segment which an
+	// interrupted compaction did not get to or -1 if the last compaction
+	// completed. Segments before it are not compacted again when resuming.
+	resumeOffset int64
 }
 
 // NewCompactCleaner returns a new cleaner which performs log compaction by
@@ -32,73 +86,277 @@ func newCompactCleaner(opts compactCleanerOptions) *compactCleaner {
 	if opts.MaxGoroutines == 0 {
 		opts.MaxGoroutines = defaultCompactMaxGoroutines
 	}
-	return &compactCleaner{opts}
+	if opts.KeepVersions <= 0 {
+		opts.KeepVersions = defaultCompactKeepVersions
+	}
+	if opts.TombstoneRetention <= 0 {
+		opts.TombstoneRetention = defaultTombstoneRetention
+	}
+	c := &compactCleaner{compactCleanerOptions: opts}
+	c.resumeOffset = c.readResumeOffset()
+	return c
 }
 
 // Compact performs log compaction by rewriting segments such that they contain
-// only the last message for a given key. Compaction is applied to all segments
-// up to but excluding the active (last) segment or the provided HW, whichever
-// comes first. This returns the compacted segments and a leaderEpochCache
+// only the last message, or last KeepVersions messages, for a given key.
+// Compaction is applied to segments up to but excluding the active (last)
+// segment or the provided HW, whichever comes first. It only runs if the ratio
+// of dirty bytes, i.e. bytes written since the last compaction, is at least
+// MinDirtyRatio and compacts at most MaxBytes of dirty segments along with the
+// already compacted segments before them. Segments with nothing to remove are
+// not rewritten. This returns the compacted segments and a leaderEpochCache
 // containing the earliest offsets for each leader epoch or nil if nothing was
 // compacted.
-func (c *compactCleaner) Compact(hw int64, segments []*segment) ([]*segment,
+//
+// If the context is canceled, compaction stops as soon as possible and
+// returns the context's error along with the segments compacted so far
+// followed by the remaining ones. Its progress is checkpointed so the next
+// compaction resumes where it stopped, regardless of the dirty ratio.
+func (c *compactCleaner) Compact(ctx context.Context, hw int64, segments []*segment) ([]*segment,
 	*leaderEpochCache, error) {
 
 	if len(segments) <= 1 {
 		return segments, nil, nil
 	}
 
-	c.Logger.Debugf("Compacting log %s", c.Name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end, ratio := c.dirtyRange(hw, segments)
+	resuming := c.resumeOffset >= 0
+	if !resuming && ratio < c.MinDirtyRatio {
+		c.Logger.Debugf("Skipping compaction of log %s, dirty ratio %.2f is below %.2f",
+			c.Name, ratio, c.MinDirtyRatio)
+		return segments, nil, nil
+	}
+
+	if resuming {
+		c.Logger.Debugf("Resuming compaction of log %s at offset %d", c.Name, c.resumeOffset)
+	} else {
+		c.Logger.Debugf("Compacting log %s", c.Name)
+	}
 	before := time.Now()
-	compacted, epochCache, removed, err := c.compact(hw, segments)
-	if err == nil {
-		c.Logger.Debugf("Finished compacting log %s\n"+
-			"\tMessages Removed: %d\n"+
-			"\tSegments: %d -> %d\n"+
-			"\tDuration: %s",
-			c.Name, removed, len(segments), len(compacted), time.Since(before))
+	compacted, epochCache, removed, err := c.compact(ctx, hw, segments, end)
+	if err != nil && ctx.Err() != nil {
+		if compacted == nil {
+			// Interrupted before any segment was compacted.
+			return segments, nil, ctx.Err()
+		}
+		c.Logger.Debugf("Interrupted compaction of log %s after removing %d messages",
+			c.Name, removed)
+		return compacted, epochCache, ctx.Err()
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to compact log")
+	}
+	if err := c.setResumeOffset(-1); err != nil {
+		c.Logger.Warnf("Failed to remove cleaner checkpoint for log %s: %v", c.Name, err)
+	}
+
+	// Messages are compacted up to the first segment which was not, or the
+	// HW since messages after it are retained.
+	c.firstDirtyOffset = segments[end].BaseOffset
+	if hw < c.firstDirtyOffset {
+		c.firstDirtyOffset = hw
 	}
+	c.Logger.Debugf("Finished compacting log %s\n"+
+		"\tMessages Removed: %d\n"+
+		"\tSegments: %d -> %d\n"+
+		"\tDirty Ratio: %.2f\n"+
+		"\tCompacted Through Offset: %d\n"+
+		"\tDuration: %s",
+		c.Name, removed, len(segments), len(compacted), ratio, c.firstDirtyOffset-1,
+		time.Since(before))
 
-	return compacted, epochCache, errors.Wrap(err, "failed to compact log")
+	return compacted, epochCache, nil
+}
 
+// Truncated marks messages from the given offset on as dirty since the log
+// was truncated to it.
+func (c *compactCleaner) Truncated(offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if offset < c.firstDirtyOffset {
+		c.firstDirtyOffset = offset
+	}
+	// Segments from the truncation point on are gone, so an interrupted
+	// compaction resumes from it.
+	if offset < c.resumeOffset {
+		if err := c.setResumeOffset(offset); err != nil {
+			c.Logger.Warnf("Failed to checkpoint cleaner offset for log %s: %v", c.Name, err)
+		}
+	}
+}
+
+// setResumeOffset sets and checkpoints the offset the next compaction resumes
+// from, -1 meaning there is nothing to resume.
+func (c *compactCleaner) setResumeOffset(offset int64) error {
+	if offset == c.resumeOffset {
+		return nil
+	}
+	c.resumeOffset = offset
+	return c.writeResumeOffset(offset)
 }
 
+// dirtyRange returns the index of the first segment which will not be
+// compacted and the ratio of dirty bytes to total bytes in the segments which
+// can be compacted, i.e. all but the active segment. Segments are compacted up
+// to MaxBytes of dirty segments but not past the HW.
+func (c *compactCleaner) dirtyRange(hw int64, segments []*segment) (int, float64) {
+	var (
+		candidates = segments[:len(segments)-1]
+		total      int64
+		dirty      int64
+		rangeBytes int64
+		end        = -1
+	)
+	for i, seg := range candidates {
+		size := seg.Position()
+		total += size
+		// A segment is clean if all of its messages precede the first dirty
+		// offset, i.e. the next segment starts at or before it.
+		if segments[i+1].BaseOffset <= c.firstDirtyOffset {
+			continue
+		}
+		dirty += size
+		if end != -1 {
+			continue
+		}
+		if seg.BaseOffset >= hw ||
+			(c.MaxBytes > 0 && rangeBytes > 0 && rangeBytes+size > c.MaxBytes) {
+			end = i
+			continue
+		}
+		rangeBytes += size
+	}
+	if end == -1 {
+		end = len(candidates)
+	}
+	if total == 0 {
+		return end, 0
+	}
+	return end, float64(dirty) / float64(total)
+}
+
+// keyOffset tracks the latest offsets for a key, up to the number of versions
+// retained by compaction, and whether the latest version is a tombstone.
 type keyOffset struct {
 	sync.RWMutex
-	offset int64
+	offsets   []int64 // In ascending order
+	tombstone bool    // Latest version is a tombstone
+	timestamp int64   // Timestamp of the latest version
+	evicted   bool    // Key is removed to retain MaxKeys keys
 }
 
-func (k *keyOffset) set(offset int64) {
+func (k *keyOffset) set(offset, timestamp int64, tombstone bool, versions int) {
 	k.Lock()
-	if offset > k.offset {
-		k.offset = offset
+	defer k.Unlock()
+	if len(k.offsets) == versions {
+		if offset <= k.offsets[0] {
+			return
+		}
+		k.offsets = k.offsets[1:]
+	}
+	i := sort.Search(len(k.offsets), func(i int) bool { return k.offsets[i] > offset })
+	k.offsets = append(k.offsets, 0)
+	copy(k.offsets[i+1:], k.offsets[i:])
+	k.offsets[i] = offset
+	if i == len(k.offsets)-1 {
+		k.tombstone = tombstone
+		k.timestamp = timestamp
 	}
-	k.Unlock()
 }
 
-func (k *keyOffset) get() int64 {
+// retains indicates if the message at the given offset is one of the latest
+// versions for the key. If the key was deleted, only its tombstone is
+// retained, and only if it's not older than the given tombstone TTL.
+func (k *keyOffset) retains(offset, tombstoneTTL int64) bool {
 	k.RLock()
 	defer k.RUnlock()
-	return k.offset
+	if len(k.offsets) == 0 || k.evicted {
+		return false
+	}
+	if k.tombstone {
+		return offset == k.offsets[len(k.offsets)-1] && k.timestamp >= tombstoneTTL
+	}
+	return offset >= k.offsets[0]
 }
 
-func (c *compactCleaner) compact(hw int64, segments []*segment) ([]*segment,
-	*leaderEpochCache, int, error) {
+// evictKeys marks the keys whose latest versions are the oldest as evicted so
+// that at most maxKeys keys are retained and returns the number of evicted
+// keys. Deleted keys are not counted since only their tombstones are retained,
+// nor are messages without keys, which are always retained.
+func evictKeys(keyOffsets *sync.Map, maxKeys int64) int {
+	var keys []*keyOffset
+	keyOffsets.Range(func(key, value interface{}) bool {
+		if k := value.(*keyOffset); key.(string) != "" && !k.tombstone {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	if int64(len(keys)) <= maxKeys {
+		return 0
+	}
+	latest := func(k *keyOffset) int64 { return k.offsets[len(k.offsets)-1] }
+	sort.Slice(keys, func(i, j int) bool { return latest(keys[i]) < latest(keys[j]) })
+	evict := keys[:int64(len(keys))-maxKeys]
+	for _, k := range evict {
+		k.evicted = true
+	}
+	return len(evict)
+}
+
+// isTombstone indicates if a message with the given key and value is a
+// tombstone, i.e. a delete of its key.
+func isTombstone(key, value []byte) bool {
+	return len(key) > 0 && len(value) == 0
+}
 
-	// Compact messages up to the last segment or HW, whichever is first, by
-	// scanning keys and retaining only the latest.
+// compact compacts the segments before end and returns them along with the
+// remaining segments. If the context is canceled, the segments compacted so
+// far are returned along with the remaining ones, or nil if none were.
+func (c *compactCleaner) compact(ctx context.Context, hw int64, segments []*segment,
+	end int) ([]*segment, *leaderEpochCache, int, error) {
+
+	// Compact messages up to the end of the range or HW, whichever is first,
+	// by scanning keys in the whole log up to the HW and retaining only the
+	// latest.
 	// TODO: Implement option for configuring minimum compaction lag.
 	var (
-		compacted  = make([]*segment, 0, len(segments))
-		epochCache = newLeaderEpochCacheNoFile(c.Name, c.Logger)
-		removed    = 0
-		keyOffsets = c.scanKeys(hw, segments)
+		compacted    = make([]*segment, 0, len(segments))
+		epochCache   = newLeaderEpochCacheNoFile(c.Name, c.Logger)
+		removed      = 0
+		tombstoneTTL = computeTTL(c.TombstoneRetention)
 	)
+	keyOffsets, err := c.scanKeys(ctx, hw, segments)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if c.MaxKeys > 0 {
+		if evicted := evictKeys(keyOffsets, c.MaxKeys); evicted > 0 {
+			c.Logger.Debugf("Evicting %d keys from log %s to retain %d keys",
+				evicted, c.Name, c.MaxKeys)
+		}
+	}
 
-	// Write new segments. Skip the last segment since we will not compact it.
+	// Write new segments for those in the range. Segments before the resume
+	// offset were compacted by an interrupted compaction, so they are kept as
+	// is.
 	// TODO: Join segments that are below the bytes limit.
-	for _, seg := range segments[:len(segments)-1] {
-		cleaned, msgsRemoved, err := c.cleanSegment(seg, keyOffsets, hw, epochCache)
+	for i, seg := range segments[:end] {
+		var (
+			cleaned     = seg
+			msgsRemoved = 0
+			err         error
+		)
+		if seg.BaseOffset < c.resumeOffset {
+			err = assignLeaderEpochs(ctx, seg, epochCache, c.Scheduler)
+		} else {
+			cleaned, msgsRemoved, err = c.cleanSegment(ctx, seg, keyOffsets, hw, tombstoneTTL, epochCache)
+		}
+		if err != nil && ctx.Err() != nil {
+			return c.interrupted(ctx, compacted, segments[i:], epochCache, removed, i < end)
+		}
 		if err != nil {
 			return nil, nil, 0, err
 		}
@@ -108,26 +366,113 @@ func (c *compactCleaner) compact(hw int64, segments []*segment) ([]*segment,
 		removed += msgsRemoved
 	}
 
-	// Add the last segment back in to the compacted list.
-	last := segments[len(segments)-1]
-	compacted = append(compacted, last)
+	// Add the remaining segments back in to the compacted list and maintain
+	// the start offset for each new leader epoch in them.
+	for i, seg := range segments[end:] {
+		if err := assignLeaderEpochs(ctx, seg, epochCache, c.Scheduler); err != nil {
+			if ctx.Err() != nil {
+				return c.interrupted(ctx, compacted, segments[end+i:], epochCache, removed, false)
+			}
+			return nil, nil, 0, err
+		}
+		compacted = append(compacted, seg)
+	}
+
+	return compacted, epochCache, removed, nil
+}
+
+// interrupted returns the segments compacted so far followed by the remaining
+// ones after compaction was interrupted by the context. The leader epochs of
+// the remaining segments are taken from the log's leader epoch cache. If the
+// remaining segments were to be compacted, their base offset is checkpointed
+// for the next compaction to resume from.
+func (c *compactCleaner) interrupted(ctx context.Context, compacted, remaining []*segment,
+	epochCache *leaderEpochCache, removed int, resume bool) ([]*segment,
+	*leaderEpochCache, int, error) {
+
+	if resume {
+		if err := c.setResumeOffset(remaining[0].BaseOffset); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	// Drop any epochs assigned from the segment which was being compacted.
+	if err := epochCache.ClearLatest(remaining[0].BaseOffset); err != nil {
+		return nil, nil, 0, err
+	}
+	if c.LeaderEpochCache == nil {
+		// Fall back to scanning the remaining segments. This doesn't wait
+		// for the IO budget so the interruption isn't held up.
+		for _, seg := range remaining {
+			if err := assignLeaderEpochs(context.Background(), seg, epochCache, nil); err != nil {
+				return nil, nil, 0, err
+			}
+		}
+		return append(compacted, remaining...), epochCache, removed, ctx.Err()
+	}
+	// The leader epoch of the first remaining message may start in an earlier
+	// segment whose messages for it were all removed, so assign it first.
+	ss := newSegmentScanner(remaining[0])
+	defer ss.close()
+	if ms, _, err := ss.Scan(); err == nil && ms.LeaderEpoch() > epochCache.LastLeaderEpoch() {
+		if err := epochCache.Assign(ms.LeaderEpoch(), ms.Offset()); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	if err := epochCache.Rebase(c.LeaderEpochCache, remaining[0].BaseOffset); err != nil {
+		return nil, nil, 0, err
+	}
+	return append(compacted, remaining...), epochCache, removed, ctx.Err()
+}
+
+// assignLeaderEpochs adds the start offset for each new leader epoch in the
+// segment to the leaderEpochCache. The segment is read within the scheduler's
+// IO budget unless it's nil.
+func assignLeaderEpochs(ctx context.Context, seg *segment, epochCache *leaderEpochCache,
+	scheduler *CompactionScheduler) error {
 
-	// Maintain start offset for each new leader epoch for the last segment.
-	ss := newSegmentScanner(last)
+	ss := newSegmentScanner(seg)
+	defer ss.close()
 	for ms, _, err := ss.Scan(); err == nil; ms, _, err = ss.Scan() {
+		if err := scheduler.waitIO(ctx, len(ms)); err != nil {
+			return err
+		}
 		leaderEpoch := ms.LeaderEpoch()
 		if leaderEpoch > epochCache.LastLeaderEpoch() {
 			if err := epochCache.Assign(leaderEpoch, ms.Offset()); err != nil {
-				return nil, nil, 0, err
+				return err
 			}
 		}
 	}
+	return nil
+}
 
-	return compacted, epochCache, removed, nil
+// retains indicates if compaction retains the message. This includes all
+// messages with no keys, all control records, and the last messages for each
+// key unless it was deleted. Also retain all messages after the HW.
+func retains(ms messageSet, keyOffsets *sync.Map, hw, tombstoneTTL int64) bool {
+	var (
+		offset     = ms.Offset()
+		key        = ms.Message().Key()
+		latest, ok = keyOffsets.Load(string(key))
+	)
+	return key == nil || ms.IsControl() || offset >= hw ||
+		(ok && latest.(*keyOffset).retains(offset, tombstoneTTL))
 }
 
-func (c *compactCleaner) cleanSegment(seg *segment, keyOffsets *sync.Map, hw int64,
-	epochCache *leaderEpochCache) (*segment, int, error) {
+// cleanSegment writes a compacted copy of the segment and replaces the
+// segment with it. If the context is canceled, the copy is deleted and the
+// segment is left as is.
+func (c *compactCleaner) cleanSegment(ctx context.Context, seg *segment, keyOffsets *sync.Map,
+	hw, tombstoneTTL int64, epochCache *leaderEpochCache) (*segment, int, error) {
+
+	// Keep the segment as is if there is nothing to remove from it.
+	removes, err := c.removesAny(ctx, seg, keyOffsets, hw, tombstoneTTL)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !removes {
+		return seg, 0, assignLeaderEpochs(ctx, seg, epochCache, c.Scheduler)
+	}
 
 	cleaned, err := seg.Cleaned()
 	if err != nil {
@@ -136,22 +481,25 @@ func (c *compactCleaner) cleanSegment(seg *segment, keyOffsets *sync.Map, hw int
 	var (
 		ss      = newSegmentScanner(seg)
 		removed = 0
+		ms      messageSet
 	)
-	for ms, _, err := ss.Scan(); err == nil; ms, _, err = ss.Scan() {
+	defer ss.close()
+	for ms, _, err = ss.Scan(); err == nil; ms, _, err = ss.Scan() {
 		var (
-			offset       = ms.Offset()
-			key          = ms.Message().Key()
-			leaderEpoch  = ms.LeaderEpoch()
-			latest, ok   = keyOffsets.Load(string(key))
-			latestOffset int64
+			offset      = ms.Offset()
+			leaderEpoch = ms.LeaderEpoch()
+			retained    = retains(ms, keyOffsets, hw, tombstoneTTL)
+			ioBytes     = len(ms)
 		)
-		if ok {
-			latestOffset = latest.(*keyOffset).get()
+		// Retained messages are also written, so they take twice the IO.
+		if retained {
+			ioBytes *= 2
 		}
-
-		// Retain all messages with no keys and last message for each key.
-		// Also retain all messages after the HW.
-		if key == nil || offset == latestOffset || offset >= hw {
+		if err := c.Scheduler.waitIO(ctx, ioBytes); err != nil {
+			cleaned.Delete() // nolint: errcheck
+			return nil, 0, err
+		}
+		if retained {
 			entries := entriesForMessageSet(cleaned.Position(), ms)
 			if err := cleaned.WriteMessageSet(ms, entries); err != nil {
 				return nil, removed, err
@@ -166,6 +514,12 @@ func (c *compactCleaner) cleanSegment(seg *segment, keyOffsets *sync.Map, hw int
 			removed++
 		}
 	}
+	// Don't replace the segment if it could not be read to the end, e.g.
+	// because a message is corrupt, since that would drop the rest of it.
+	if err != io.EOF {
+		cleaned.Delete() // nolint: errcheck
+		return nil, removed, err
+	}
 
 	if cleaned.IsEmpty() {
 		// If the new segment is empty, remove it along with the old one.
@@ -178,7 +532,27 @@ func (c *compactCleaner) cleanSegment(seg *segment, keyOffsets *sync.Map, hw int
 	return cleaned, removed, nil
 }
 
-func (c *compactCleaner) scanKeys(hw int64, segments []*segment) *sync.Map {
+// removesAny indicates if compaction removes any messages from the segment.
+func (c *compactCleaner) removesAny(ctx context.Context, seg *segment, keyOffsets *sync.Map,
+	hw, tombstoneTTL int64) (bool, error) {
+
+	ss := newSegmentScanner(seg)
+	defer ss.close()
+	for ms, _, err := ss.Scan(); err == nil; ms, _, err = ss.Scan() {
+		if err := c.Scheduler.waitIO(ctx, len(ms)); err != nil {
+			return false, err
+		}
+		if !retains(ms, keyOffsets, hw, tombstoneTTL) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// scanKeys returns the latest offsets for each key in the segments up to the
+// HW. It returns the context's error if it's canceled before the scan
+// finishes.
+func (c *compactCleaner) scanKeys(ctx context.Context, hw int64, segments []*segment) (*sync.Map, error) {
 	var (
 		wg            sync.WaitGroup
 		keyOffsets    = new(sync.Map)
@@ -191,7 +565,7 @@ func (c *compactCleaner) scanKeys(hw int64, segments []*segment) *sync.Map {
 
 	wg.Add(numGoroutines)
 	for i := 0; i < numGoroutines; i++ {
-		go c.scanSegments(hw, segmentC, &wg, keyOffsets)
+		go c.scanSegments(ctx, hw, segmentC, &wg, keyOffsets)
 	}
 
 	for _, seg := range segments {
@@ -200,22 +574,40 @@ func (c *compactCleaner) scanKeys(hw int64, segments []*segment) *sync.Map {
 	close(segmentC)
 
 	wg.Wait()
-	return keyOffsets
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return keyOffsets, nil
 }
 
-func (c *compactCleaner) scanSegments(hw int64, ch <-chan *segment, wg *sync.WaitGroup, keyOffsets *sync.Map) {
+func (c *compactCleaner) scanSegments(ctx context.Context, hw int64, ch <-chan *segment,
+	wg *sync.WaitGroup, keyOffsets *sync.Map) {
+	// Reuse one scanner and its buffer for all of the segments.
+	ss := newSegmentScanner(nil)
+	defer ss.close()
 LOOP:
 	for seg := range ch {
-		ss := newSegmentScanner(seg)
+		ss.reset(seg)
 		for ms, _, err := ss.Scan(); err == nil; ms, _, err = ss.Scan() {
-			offset := ms.Offset()
+			if c.Scheduler.waitIO(ctx, len(ms)) != nil {
+				break LOOP
+			}
+			var (
+				offset    = ms.Offset()
+				timestamp = ms.Timestamp()
+				msg       = ms.Message()
+				tombstone = isTombstone(msg.Key(), msg.Value())
+			)
 			if offset > hw {
 				break LOOP
 			}
-			curr, loaded := keyOffsets.LoadOrStore(
-				string(ms.Message().Key()), &keyOffset{offset: offset})
+			curr, loaded := keyOffsets.LoadOrStore(string(msg.Key()), &keyOffset{
+				offsets:   []int64{offset},
+				tombstone: tombstone,
+				timestamp: timestamp,
+			})
 			if loaded {
-				curr.(*keyOffset).set(offset)
+				curr.(*keyOffset).set(offset, timestamp, tombstone, c.KeepVersions)
 			}
 		}
 	}
@@ -4,7 +4,12 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -23,7 +28,7 @@ type expectedMsg struct {
 func TestCompactCleanerNoSegments(t *testing.T) {
 	opts := compactCleanerOptions{Name: "foo", Logger: noopLogger()}
 	cleaner := newCompactCleaner(opts)
-	segments, epochCache, err := cleaner.Compact(0, nil)
+	segments, epochCache, err := cleaner.Compact(context.Background(), 0, nil)
 	require.NoError(t, err)
 	require.Nil(t, segments)
 	require.Nil(t, epochCache)
@@ -37,7 +42,7 @@ func TestCompactCleanerOneSegment(t *testing.T) {
 	defer remove(t, dir)
 
 	expected := []*segment{createSegment(t, dir, 0, 100)}
-	actual, epochCache, err := cleaner.Compact(0, expected)
+	actual, epochCache, err := cleaner.Compact(context.Background(), 0, expected)
 	require.NoError(t, err)
 	require.Equal(t, expected, actual)
 	require.Nil(t, epochCache)
@@ -70,7 +75,7 @@ func TestCompactCleaner(t *testing.T) {
 	appendToLog(t, l, entries, true)
 
 	// Force a compaction.
-	require.NoError(t, l.Clean())
+	require.NoError(t, l.Clean(context.Background()))
 
 	expected := []*expectedMsg{
 		{Offset: 4, Msg: &Message{Key: []byte("bar"), Value: []byte("second")}},
@@ -120,7 +125,7 @@ func TestCompactCleanerHW(t *testing.T) {
 	l.SetHighWatermark(5)
 
 	// Force a compaction.
-	require.NoError(t, l.Clean())
+	require.NoError(t, l.Clean(context.Background()))
 
 	expected := []*expectedMsg{
 		{Offset: 3, Msg: &Message{Key: []byte("foo"), Value: []byte("third")}},
@@ -146,6 +151,111 @@ func TestCompactCleanerHW(t *testing.T) {
 	}
 }
 
+// Ensure Compact retains the last KeepVersions messages for each key.
+func TestCompactCleanerKeepVersions(t *testing.T) {
+	opts := Options{
+		Path:                tempDir(t),
+		MaxSegmentBytes:     100,
+		Compact:             true,
+		CompactKeepVersions: 2,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	// Append some messages.
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("baz"), []byte("second")},
+		{[]byte("qux"), []byte("first")},
+		{[]byte("foo"), []byte("fourth")},
+		{[]byte("baz"), []byte("third")},
+	}
+	appendToLog(t, l, entries, true)
+
+	// Force a compaction.
+	require.NoError(t, l.Clean(context.Background()))
+
+	expected := []*expectedMsg{
+		{Offset: 1, Msg: &Message{Key: []byte("bar"), Value: []byte("first")}},
+		{Offset: 3, Msg: &Message{Key: []byte("foo"), Value: []byte("third")}},
+		{Offset: 4, Msg: &Message{Key: []byte("bar"), Value: []byte("second")}},
+		{Offset: 6, Msg: &Message{Key: []byte("baz"), Value: []byte("second")}},
+		{Offset: 7, Msg: &Message{Key: []byte("qux"), Value: []byte("first")}},
+		{Offset: 8, Msg: &Message{Key: []byte("foo"), Value: []byte("fourth")}},
+		{Offset: 9, Msg: &Message{Key: []byte("baz"), Value: []byte("third")}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.Offset, offset)
+		compareMessages(t, exp.Msg, msg)
+	}
+}
+
+// Ensure Compact retains only the MaxLogKeys keys with the latest messages,
+// not counting deleted keys or messages without keys.
+func TestCompactCleanerMaxKeys(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+		Compact:         true,
+		MaxLogKeys:      2,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	// Append some messages.
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{nil, []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("baz"), []byte("second")},
+		{[]byte("qux"), nil},
+		{[]byte("quux"), []byte("first")},
+	}
+	appendToLog(t, l, entries, true)
+
+	// Force a compaction.
+	require.NoError(t, l.Clean(context.Background()))
+
+	// foo and bar are evicted since the qux tombstone doesn't count towards
+	// the limit.
+	expected := []*expectedMsg{
+		{Offset: 2, Msg: &Message{Value: []byte("first")}},
+		{Offset: 7, Msg: &Message{Key: []byte("baz"), Value: []byte("second")}},
+		// These are present because they're in the active segment.
+		{Offset: 8, Msg: &Message{Key: []byte("qux")}},
+		{Offset: 9, Msg: &Message{Key: []byte("quux"), Value: []byte("first")}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.Offset, offset)
+		compareMessages(t, exp.Msg, msg)
+	}
+}
+
 // Ensure Compact retains all messages that do not have keys.
 func TestCompactCleanerNoKeys(t *testing.T) {
 	opts := Options{
@@ -166,7 +276,7 @@ func TestCompactCleanerNoKeys(t *testing.T) {
 	appendToLog(t, l, entries, true)
 
 	// Force a compaction.
-	require.NoError(t, l.Clean())
+	require.NoError(t, l.Clean(context.Background()))
 
 	expected := []*expectedMsg{
 		{Offset: 0, Msg: &Message{Value: []byte("first")}},
@@ -188,6 +298,225 @@ func TestCompactCleanerNoKeys(t *testing.T) {
 	}
 }
 
+// Ensure retention limits are still enforced on a compacted log so that the
+// latest message for keys that are no longer written eventually ages out.
+func TestCompactCleanerWithRetention(t *testing.T) {
+	computeTTLBefore := computeTTL
+	computeTTL = func(age time.Duration) int64 {
+		return 200 - int64(age)
+	}
+	defer func() {
+		computeTTL = computeTTLBefore
+	}()
+
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 6,
+		MaxLogAge:       100,
+		Compact:         true,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	for i, msg := range []*Message{
+		{Key: []byte("foo"), Value: []byte("first"), Timestamp: 10},
+		{Key: []byte("bar"), Value: []byte("first"), Timestamp: 20},
+		{Key: []byte("foo"), Value: []byte("second"), Timestamp: 30},
+		{Key: []byte("baz"), Value: []byte("first"), Timestamp: 150},
+		{Key: []byte("bar"), Value: []byte("second"), Timestamp: 160},
+		{Key: []byte("baz"), Value: []byte("second"), Timestamp: 170},
+		{Key: []byte("qux"), Value: []byte("first"), Timestamp: 180},
+	} {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+		l.SetHighWatermark(int64(i))
+	}
+
+	// Force a clean. Segments older than the TTL are deleted, which drops
+	// key foo entirely, and the remaining segments are compacted.
+	require.NoError(t, l.Clean(context.Background()))
+
+	expected := []*expectedMsg{
+		{Offset: 4, Msg: &Message{Key: []byte("bar"), Value: []byte("second")}},
+		{Offset: 5, Msg: &Message{Key: []byte("baz"), Value: []byte("second")}},
+		{Offset: 6, Msg: &Message{Key: []byte("qux"), Value: []byte("first")}},
+	}
+
+	require.Equal(t, int64(4), l.OldestOffset())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.Offset, offset)
+		compareMessages(t, exp.Msg, msg)
+	}
+}
+
+// Ensure Compact removes all earlier messages for keys whose latest message
+// is a tombstone and removes tombstones older than the tombstone retention.
+func TestCompactCleanerTombstones(t *testing.T) {
+	computeTTLBefore := computeTTL
+	computeTTL = func(age time.Duration) int64 {
+		return 200 - int64(age)
+	}
+	defer func() {
+		computeTTL = computeTTLBefore
+	}()
+
+	opts := Options{
+		Path:                      tempDir(t),
+		MaxSegmentBytes:           6,
+		Compact:                   true,
+		CompactTombstoneRetention: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	for i, msg := range []*Message{
+		{Key: []byte("foo"), Value: []byte("first"), Timestamp: 10},
+		{Key: []byte("bar"), Value: []byte("first"), Timestamp: 20},
+		{Key: []byte("qux"), Value: []byte("first"), Timestamp: 30},
+		{Key: []byte("foo"), Timestamp: 40},
+		{Key: []byte("qux"), Timestamp: 50},
+		{Key: []byte("bar"), Value: []byte("second"), Timestamp: 60},
+		{Key: []byte("bar"), Timestamp: 150},
+		{Key: []byte("qux"), Value: []byte("second"), Timestamp: 160},
+		{Key: []byte("baz"), Value: []byte("first"), Timestamp: 170},
+	} {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+		l.SetHighWatermark(int64(i))
+	}
+
+	// Force a compaction. Key foo was deleted and its tombstone has expired,
+	// key bar was deleted recently, and key qux was written again after it
+	// was deleted.
+	require.NoError(t, l.Clean(context.Background()))
+
+	expected := []*expectedMsg{
+		{Offset: 6, Msg: &Message{Key: []byte("bar")}},
+		{Offset: 7, Msg: &Message{Key: []byte("qux"), Value: []byte("second")}},
+		{Offset: 8, Msg: &Message{Key: []byte("baz"), Value: []byte("first")}},
+	}
+
+	require.Equal(t, int64(6), l.OldestOffset())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.Offset, offset)
+		compareMessages(t, exp.Msg, msg)
+	}
+}
+
+// Ensure Compact compacts at most MaxBytes of dirty segments per run, oldest
+// first, and eventually compacts the whole log.
+func TestCompactCleanerMaxBytes(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+		Compact:         true,
+		CompactMaxBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	// Append some messages.
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("baz"), []byte("second")},
+		{[]byte("qux"), []byte("first")},
+		{[]byte("foo"), []byte("fourth")},
+		{[]byte("baz"), []byte("third")},
+	}
+	appendToLog(t, l, entries, true)
+
+	// Only the first segment is compacted.
+	require.NoError(t, l.Clean(context.Background()))
+	require.Equal(t, int64(2), l.compactCleaner.firstDirtyOffset)
+	require.Equal(t, int64(2), readFirstOffset(t, l, 0))
+
+	// The rest is compacted one segment per run.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Clean(context.Background()))
+	}
+	require.Equal(t, int64(8), l.compactCleaner.firstDirtyOffset)
+
+	expected := []*expectedMsg{
+		{Offset: 4, Msg: &Message{Key: []byte("bar"), Value: []byte("second")}},
+		{Offset: 7, Msg: &Message{Key: []byte("qux"), Value: []byte("first")}},
+		{Offset: 8, Msg: &Message{Key: []byte("foo"), Value: []byte("fourth")}},
+		{Offset: 9, Msg: &Message{Key: []byte("baz"), Value: []byte("third")}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.Offset, offset)
+		compareMessages(t, exp.Msg, msg)
+	}
+}
+
+// Ensure Compact only runs once the ratio of dirty bytes in the log reaches
+// MinDirtyRatio.
+func TestCompactCleanerMinDirtyRatio(t *testing.T) {
+	opts := Options{
+		Path:                 tempDir(t),
+		MaxSegmentBytes:      100,
+		Compact:              true,
+		CompactMinDirtyRatio: 0.9,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	// Append some messages. The log is entirely dirty, so it's compacted.
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("baz"), []byte("second")},
+		{[]byte("qux"), []byte("first")},
+		{[]byte("foo"), []byte("fourth")},
+		{[]byte("baz"), []byte("third")},
+	}
+	appendToLog(t, l, entries, true)
+	require.NoError(t, l.Clean(context.Background()))
+	require.Equal(t, int64(4), readFirstOffset(t, l, 0))
+
+	// Superseding a key doesn't make enough of the log dirty.
+	appendToLog(t, l, []keyValue{{[]byte("foo"), []byte("fifth")}}, true)
+	require.NoError(t, l.Clean(context.Background()))
+	require.Equal(t, int64(8), readFirstOffset(t, l, 8))
+
+	// Once enough of the log is dirty, it's compacted.
+	for i := 0; i < 20; i++ {
+		appendToLog(t, l, []keyValue{{[]byte("quux"), []byte(strconv.Itoa(i))}}, true)
+	}
+	require.NoError(t, l.Clean(context.Background()))
+	require.Equal(t, int64(9), readFirstOffset(t, l, 8))
+}
+
 // Ensure neither log truncation nor compaction fail when run concurrently.
 func TestCompactCleanerTruncateConcurrent(t *testing.T) {
 	opts := Options{
@@ -231,12 +560,143 @@ func TestCompactCleanerTruncateConcurrent(t *testing.T) {
 	<-wait
 
 	// Force a compaction.
-	require.NoError(t, l.Clean())
+	require.NoError(t, l.Clean(context.Background()))
 	require.NoError(t, l.Truncate(0))
 
 	require.Equal(t, int64(-1), l.OldestOffset())
 }
 
+// cancelAfterContext is a context which is canceled once its Err method has
+// been called the given number of times.
+type cancelAfterContext struct {
+	context.Context
+	calls int32
+}
+
+func (c *cancelAfterContext) Err() error {
+	if atomic.AddInt32(&c.calls, -1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+// Ensure a compaction interrupted at any point leaves the log readable and is
+// resumed where it stopped after a restart.
+func TestCompactCleanerInterruptedResumes(t *testing.T) {
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("baz"), []byte("second")},
+		{[]byte("qux"), []byte("first")},
+		{[]byte("foo"), []byte("fourth")},
+		{[]byte("baz"), []byte("third")},
+	}
+	expected := []*expectedMsg{
+		{Offset: 4, Msg: &Message{Key: []byte("bar"), Value: []byte("second")}},
+		{Offset: 7, Msg: &Message{Key: []byte("qux"), Value: []byte("first")}},
+		{Offset: 8, Msg: &Message{Key: []byte("foo"), Value: []byte("fourth")}},
+		{Offset: 9, Msg: &Message{Key: []byte("baz"), Value: []byte("third")}},
+	}
+
+	resumed := false
+	for calls := int32(0); ; calls++ {
+		opts := Options{
+			Path:                 tempDir(t),
+			MaxSegmentBytes:      100,
+			Compact:              true,
+			CompactMaxGoroutines: 1,
+		}
+		l, cleanup := setupWithOptions(t, opts)
+		appendToLog(t, l, entries, true)
+
+		// Interrupt the compaction after the given number of checks.
+		ctx := &cancelAfterContext{Context: context.Background(), calls: calls}
+		segments, epochCache, err := l.compactCleaner.Compact(ctx, l.HighWatermark(), l.segments)
+		if err == nil {
+			require.NoError(t, l.Close())
+			cleanup()
+			break
+		}
+		require.Equal(t, context.Canceled, err)
+		l.segments = segments
+		if epochCache != nil {
+			require.NoError(t, l.leaderEpochCache.Replace(epochCache))
+		}
+		resumeOffset := l.compactCleaner.resumeOffset
+		if resumeOffset > 0 {
+			resumed = true
+		}
+
+		// The checkpoint survives a restart and the compaction completes.
+		require.NoError(t, l.Close())
+		l, _ = setupWithOptions(t, opts)
+		require.Equal(t, resumeOffset, l.compactCleaner.resumeOffset)
+		require.NoError(t, l.Clean(context.Background()))
+		require.Equal(t, int64(-1), l.compactCleaner.resumeOffset)
+		_, err = os.Stat(filepath.Join(opts.Path, cleanerCheckpointFileName))
+		require.True(t, os.IsNotExist(err))
+
+		r, err := l.NewReader(0, true)
+		require.NoError(t, err)
+		headers := make([]byte, 28)
+		for _, exp := range expected {
+			msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+			require.NoError(t, err)
+			require.Equal(t, exp.Offset, offset)
+			compareMessages(t, exp.Msg, msg)
+		}
+		require.NoError(t, l.Close())
+		cleanup()
+	}
+	require.True(t, resumed)
+}
+
+// Ensure closing the log interrupts cleaning and stops later cleans.
+func TestCompactCleanerCloseInterruptsClean(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+		Compact:         true,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	entries := make([]keyValue, 1000)
+	for i := range entries {
+		entries[i] = keyValue{[]byte(strconv.Itoa(i % 10)), []byte(strconv.Itoa(i))}
+	}
+	appendToLog(t, l, entries, true)
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- l.Clean(context.Background())
+	}()
+	require.NoError(t, l.Close())
+
+	// Close waits for the clean to return.
+	select {
+	case err := <-errC:
+		if err != nil {
+			require.Equal(t, context.Canceled, err)
+		}
+	default:
+		t.Fatal("Expected clean to return")
+	}
+	require.Equal(t, context.Canceled, l.Clean(context.Background()))
+
+	// The log is intact after reopening.
+	l, _ = setupWithOptions(t, opts)
+	defer l.Close()
+	require.Equal(t, int64(999), l.NewestOffset())
+	require.NoError(t, l.Clean(context.Background()))
+	// Only the latest version of each key before the active segment remains.
+	require.Greater(t, readFirstOffset(t, l, 0), int64(900))
+}
+
 func BenchmarkClean1GBSegments(b *testing.B) {
 	benchmarkClean(b, 1024*1024*1024)
 }
@@ -290,13 +750,23 @@ func benchmarkClean(b *testing.B, segmentSize int64) {
 				}
 
 				b.StartTimer()
-				require.NoError(b, l.Clean())
+				require.NoError(b, l.Clean(context.Background()))
 			}
 			b.StopTimer()
 		})
 	}
 }
 
+func readFirstOffset(t *testing.T, l *commitLog, offset int64) int64 {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(offset, true)
+	require.NoError(t, err)
+	_, offset, _, _, err = r.ReadMessage(ctx, make([]byte, 28))
+	require.NoError(t, err)
+	return offset
+}
+
 func appendToLog(t *testing.T, l *commitLog, entries []keyValue, commit bool) {
 	for _, entry := range entries {
 		msg := &Message{
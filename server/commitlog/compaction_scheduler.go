@@ -0,0 +1,171 @@
+package commitlog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// minIOWait is the shortest time a compaction waits for its IO budget.
+// Shorter waits are carried over to the next request instead, which keeps
+// the rate accurate without sleeping for every message.
+const minIOWait = 10 * time.Millisecond
+
+// CompactionScheduler runs the cleaning of the compacted logs it's passed to
+// on a fixed number of workers and limits the bytes their compactions read
+// and write to a budget per second. This lets compaction keep up with many
+// compacted logs without starving appends and reads of disk bandwidth, so
+// logs on the same disk should share a scheduler. A log is queued once per
+// CleanerInterval and only once at a time, so a log whose compaction takes
+// longer than that is not queued again until it finishes. A nil
+// CompactionScheduler cleans each log on its own clean task without an IO
+// budget.
+type CompactionScheduler struct {
+	budget  *ioBudget // Nil if IO is unlimited
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []*commitLog
+	pending map[*commitLog]struct{} // Logs queued or being cleaned
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewCompactionScheduler returns a CompactionScheduler which cleans logs on
+// the given number of workers and limits compaction IO to bytesPerSec, 0
+// meaning unlimited. It must be stopped with Stop.
+func NewCompactionScheduler(workers int, bytesPerSec int64) *CompactionScheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &CompactionScheduler{
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(map[*commitLog]struct{}),
+	}
+	if bytesPerSec > 0 {
+		s.budget = newIOBudget(bytesPerSec)
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// Stop stops the workers, interrupting running compactions, which resume
+// where they stopped on the next clean. Queued logs are not cleaned.
+func (s *CompactionScheduler) Stop() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.stopped = true
+	s.queue = nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+}
+
+// schedule queues the log to be cleaned by a worker unless it's already
+// queued or being cleaned.
+func (s *CompactionScheduler) schedule(l *commitLog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if _, ok := s.pending[l]; ok {
+		return
+	}
+	s.pending[l] = struct{}{}
+	s.queue = append(s.queue, l)
+	s.cond.Signal()
+}
+
+func (s *CompactionScheduler) worker() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.stopped {
+			s.cond.Wait()
+		}
+		if s.stopped {
+			s.mu.Unlock()
+			return
+		}
+		l := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		l.runClean(s.ctx)
+
+		s.mu.Lock()
+		delete(s.pending, l)
+		s.mu.Unlock()
+	}
+}
+
+// waitIO waits until n more bytes of compaction IO fit in the budget. It
+// returns the context's error if it's canceled, so compaction checks for
+// cancellation with it even if IO is unlimited.
+func (s *CompactionScheduler) waitIO(ctx context.Context, n int) error {
+	if s == nil || s.budget == nil {
+		return ctx.Err()
+	}
+	return s.budget.wait(ctx, n)
+}
+
+// ioBudget is a token bucket of bytes which refills at a fixed rate and holds
+// up to a second's worth. Requests larger than the bucket are allowed and put
+// it in debt, which later requests wait to be paid off.
+type ioBudget struct {
+	rate   float64 // Bytes per second
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newIOBudget(bytesPerSec int64) *ioBudget {
+	return &ioBudget{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n bytes from the budget, waiting until the budget is no longer
+// in debt. It returns the context's error if it's canceled first, in which
+// case the bytes are still taken.
+func (b *ioBudget) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay < minIOWait {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
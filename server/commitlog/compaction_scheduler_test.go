@@ -0,0 +1,145 @@
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure compacted logs sharing a scheduler are cleaned by its workers.
+func TestCompactionSchedulerCleansLogs(t *testing.T) {
+	scheduler := NewCompactionScheduler(2, 0)
+	defer scheduler.Stop()
+
+	entries := make([]keyValue, 100)
+	for i := range entries {
+		entries[i] = keyValue{[]byte(strconv.Itoa(i % 10)), []byte(strconv.Itoa(i))}
+	}
+	logs := make([]*commitLog, 3)
+	for i := range logs {
+		l, cleanup := setupWithOptions(t, Options{
+			Path:                tempDir(t),
+			MaxSegmentBytes:     1024,
+			Compact:             true,
+			CleanerInterval:     10 * time.Millisecond,
+			CompactionScheduler: scheduler,
+		})
+		defer cleanup()
+		appendToLog(t, l, entries, true)
+		logs[i] = l
+	}
+
+	for _, l := range logs {
+		c := l.compactCleaner
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.firstDirtyOffset > 0
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// Only the latest version of each key before the active segment remains.
+	scheduler.Stop()
+	for _, l := range logs {
+		require.GreaterOrEqual(t, readFirstOffset(t, l, 0), int64(80))
+	}
+}
+
+// Ensure compaction reads and writes no faster than the IO budget allows.
+func TestCompactionSchedulerIOBudget(t *testing.T) {
+	const bytesPerSec = 16 * 1024
+	scheduler := NewCompactionScheduler(1, bytesPerSec)
+	defer scheduler.Stop()
+
+	l, cleanup := setupWithOptions(t, Options{
+		Path:                tempDir(t),
+		MaxSegmentBytes:     1024,
+		Compact:             true,
+		CompactionScheduler: scheduler,
+	})
+	defer cleanup()
+	entries := make([]keyValue, 400)
+	for i := range entries {
+		entries[i] = keyValue{[]byte(strconv.Itoa(i % 10)), []byte(strconv.Itoa(i))}
+	}
+	appendToLog(t, l, entries, true)
+
+	// Compaction scans the keys of the segments before the active one before
+	// rewriting them, so it does at least twice their size in IO, less the
+	// initial budget.
+	var (
+		size     int64
+		segments = l.Segments()
+	)
+	for _, seg := range segments[:len(segments)-1] {
+		size += seg.Position()
+	}
+	minDuration := time.Duration(float64(2*size-bytesPerSec) / bytesPerSec * float64(time.Second))
+	require.True(t, minDuration > 500*time.Millisecond, "log size %d", size)
+
+	start := time.Now()
+	require.NoError(t, l.Clean(context.Background()))
+	require.True(t, time.Since(start) >= minDuration-minIOWait,
+		"compaction took %s, expected at least %s", time.Since(start), minDuration)
+	require.Greater(t, readFirstOffset(t, l, 0), int64(350))
+}
+
+// Ensure stopping the scheduler interrupts running compactions and no more
+// logs are cleaned.
+func TestCompactionSchedulerStop(t *testing.T) {
+	scheduler := NewCompactionScheduler(1, 1024)
+	l, cleanup := setupWithOptions(t, Options{
+		Path:                tempDir(t),
+		MaxSegmentBytes:     1024,
+		Compact:             true,
+		CompactionScheduler: scheduler,
+	})
+	defer cleanup()
+	entries := make([]keyValue, 400)
+	for i := range entries {
+		entries[i] = keyValue{[]byte(strconv.Itoa(i % 10)), []byte(strconv.Itoa(i))}
+	}
+	appendToLog(t, l, entries, true)
+
+	scheduler.schedule(l)
+	time.Sleep(50 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected scheduler to stop")
+	}
+	// The compaction was interrupted before it got far.
+	require.Equal(t, int64(0), readFirstOffset(t, l, 0))
+
+	scheduler.schedule(l)
+	scheduler.mu.Lock()
+	require.Empty(t, scheduler.queue)
+	scheduler.mu.Unlock()
+}
+
+// Ensure the IO budget allows bursts of up to a second's worth of bytes and
+// waits for debt to be paid off.
+func TestIOBudget(t *testing.T) {
+	b := newIOBudget(1000)
+	start := time.Now()
+	require.NoError(t, b.wait(context.Background(), 1000))
+	require.True(t, time.Since(start) < 500*time.Millisecond)
+
+	require.NoError(t, b.wait(context.Background(), 300))
+	require.True(t, time.Since(start) >= 250*time.Millisecond)
+
+	// Waiting stops when the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	require.Equal(t, context.Canceled, b.wait(ctx, 10000))
+	require.True(t, time.Since(start) < time.Second)
+}
@@ -0,0 +1,513 @@
+package commitlog
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// SegmentCompression is the codec the logs of sealed segments are compressed
+// with.
+type SegmentCompression string
+
+const (
+	// CompressionNone leaves segment logs uncompressed.
+	CompressionNone SegmentCompression = "none"
+	// CompressionZstd compresses segment logs with Zstandard, which
+	// compresses better than LZ4.
+	CompressionZstd SegmentCompression = "zstd"
+	// CompressionLZ4 compresses segment logs with LZ4, which decompresses
+	// faster than Zstandard.
+	CompressionLZ4 SegmentCompression = "lz4"
+)
+
+// ParseSegmentCompression returns the SegmentCompression with the given name.
+func ParseSegmentCompression(name string) (SegmentCompression, error) {
+	switch compression := SegmentCompression(name); compression {
+	case CompressionNone, CompressionZstd, CompressionLZ4:
+		return compression, nil
+	}
+	return "", errors.Errorf("unknown segment compression %q", name)
+}
+
+// ErrCompressedLog is returned when writing to or truncating a segment whose
+// log is compressed.
+var ErrCompressedLog = errors.New("segment log is compressed")
+
+// Compressed logs are written in FormatV2. They start with the usual log
+// header and are split into blocks of compressedBlockSize uncompressed bytes
+// which are compressed independently, so reads only decompress the blocks
+// they need. Blocks which don't compress are stored as they are. The blocks
+// are followed by a table of their file offsets and a trailer with the
+// following layout:
+//
+// table offset (8 bytes) uncompressed size (8 bytes) block size (4 bytes)
+// codec (2 bytes) reserved (2 bytes)
+const (
+	compressedBlockSize  = 64 * 1024
+	compressedTrailerLen = 24
+
+	codecZstd = 1
+	codecLZ4  = 2
+)
+
+// blockCodec compresses and decompresses the blocks of compressed logs.
+type blockCodec interface {
+	// compress returns the compressed block, reusing dst if it's large
+	// enough.
+	compress(src, dst []byte) ([]byte, error)
+	// decompress decompresses the block into dst, which has the length of
+	// the decompressed block.
+	decompress(src, dst []byte) error
+}
+
+func newBlockCodec(id uint16) (blockCodec, error) {
+	switch id {
+	case codecZstd:
+		return zstdCodec{}, nil
+	case codecLZ4:
+		return lz4Codec{}, nil
+	}
+	return nil, errors.Errorf("unknown compressed log codec %d", id)
+}
+
+func codecID(compression SegmentCompression) (uint16, error) {
+	switch compression {
+	case CompressionZstd:
+		return codecZstd, nil
+	case CompressionLZ4:
+		return codecLZ4, nil
+	}
+	return 0, errors.Errorf("segment compression %q has no codec", compression)
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec compresses blocks with Zstandard. Its encoder and decoder are
+// shared by all logs since they can be used concurrently.
+type zstdCodec struct{}
+
+func (zstdCodec) init() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+func (c zstdCodec) compress(src, dst []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return zstdEncoder.EncodeAll(src, dst[:0]), nil
+}
+
+func (c zstdCodec) decompress(src, dst []byte) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+	b, err := zstdDecoder.DecodeAll(src, dst[:0])
+	if err != nil {
+		return err
+	}
+	if len(b) != len(dst) {
+		return errors.New("decompressed block has the wrong size")
+	}
+	return nil
+}
+
+// lz4Codec compresses blocks with LZ4.
+type lz4Codec struct{}
+
+func (lz4Codec) compress(src, dst []byte) ([]byte, error) {
+	if bound := lz4.CompressBlockBound(len(src)); cap(dst) < bound {
+		dst = make([]byte, bound)
+	}
+	n, err := lz4.CompressBlock(src, dst[:cap(dst)], nil)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		// The block is incompressible, so it's stored as it is.
+		return src, nil
+	}
+	return dst[:n], nil
+}
+
+func (lz4Codec) decompress(src, dst []byte) error {
+	n, err := lz4.UncompressBlock(src, dst)
+	if err != nil {
+		return err
+	}
+	if n != len(dst) {
+		return errors.New("decompressed block has the wrong size")
+	}
+	return nil
+}
+
+// writeCompressedLog writes the first size bytes read from src, which are the
+// messages of a segment log, to the file at the given path as a compressed
+// log.
+func writeCompressedLog(path string, src io.ReaderAt, size int64, compression SegmentCompression) error {
+	id, err := codecID(compression)
+	if err != nil {
+		return err
+	}
+	codec, err := newBlockCodec(id)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "create file failed")
+	}
+	defer file.Close()
+
+	var (
+		w       = bufio.NewWriter(file)
+		block   = make([]byte, compressedBlockSize)
+		buf     []byte
+		offsets = make([]int64, 0, size/compressedBlockSize+1)
+		pos     = int64(logHeaderLen)
+	)
+	header := newFileHeader(logMagic, logHeaderLen)
+	proto.Encoding.PutUint16(header[magicLen:], FormatV2)
+	if _, err := w.Write(header); err != nil {
+		return errors.Wrap(err, "write header failed")
+	}
+	for off := int64(0); off < size; off += compressedBlockSize {
+		n := compressedBlockSize
+		if remaining := size - off; remaining < int64(n) {
+			n = int(remaining)
+		}
+		if _, err := src.ReadAt(block[:n], off); err != nil {
+			return errors.Wrap(err, "read log failed")
+		}
+		compressed, err := codec.compress(block[:n], buf)
+		if err != nil {
+			return errors.Wrap(err, "compress block failed")
+		}
+		if len(compressed) >= n {
+			compressed = block[:n]
+		} else {
+			buf = compressed
+		}
+		offsets = append(offsets, pos)
+		if _, err := w.Write(compressed); err != nil {
+			return errors.Wrap(err, "write block failed")
+		}
+		pos += int64(len(compressed))
+	}
+	b := make([]byte, 8)
+	for _, offset := range offsets {
+		proto.Encoding.PutUint64(b, uint64(offset))
+		if _, err := w.Write(b); err != nil {
+			return errors.Wrap(err, "write block table failed")
+		}
+	}
+	trailer := make([]byte, compressedTrailerLen)
+	proto.Encoding.PutUint64(trailer, uint64(pos))
+	proto.Encoding.PutUint64(trailer[8:], uint64(size))
+	proto.Encoding.PutUint32(trailer[16:], compressedBlockSize)
+	proto.Encoding.PutUint16(trailer[20:], id)
+	if _, err := w.Write(trailer); err != nil {
+		return errors.Wrap(err, "write trailer failed")
+	}
+	if err := w.Flush(); err != nil {
+		return errors.Wrap(err, "write file failed")
+	}
+	if err := file.Sync(); err != nil {
+		return errors.Wrap(err, "sync file failed")
+	}
+	return file.Close()
+}
+
+// compressedReader reads the messages of a compressed log. It keeps the last
+// block it decompressed since reads are mostly sequential.
+type compressedReader struct {
+	file      io.ReaderAt
+	codec     blockCodec
+	size      int64   // Uncompressed size of the messages
+	blockSize int64   // Uncompressed size of each block but the last
+	offsets   []int64 // File offset of each block followed by the table's
+	mu        sync.Mutex
+	block     int64 // Index of the block in buf, -1 if none
+	buf       []byte
+	scratch   []byte
+}
+
+// openCompressedLog returns a compressedReader for the compressed log of the
+// given size.
+func openCompressedLog(file io.ReaderAt, fileSize int64) (*compressedReader, error) {
+	if fileSize < logHeaderLen+compressedTrailerLen {
+		return nil, errors.New("compressed log is truncated")
+	}
+	trailer := make([]byte, compressedTrailerLen)
+	if _, err := file.ReadAt(trailer, fileSize-compressedTrailerLen); err != nil {
+		return nil, errors.Wrap(err, "read trailer failed")
+	}
+	var (
+		tableOffset = int64(proto.Encoding.Uint64(trailer))
+		size        = int64(proto.Encoding.Uint64(trailer[8:]))
+		blockSize   = int64(proto.Encoding.Uint32(trailer[16:]))
+	)
+	codec, err := newBlockCodec(proto.Encoding.Uint16(trailer[20:]))
+	if err != nil {
+		return nil, err
+	}
+	if blockSize <= 0 || size < 0 || tableOffset < logHeaderLen {
+		return nil, errors.New("invalid compressed log trailer")
+	}
+	blocks := (size + blockSize - 1) / blockSize
+	if tableOffset+blocks*8+compressedTrailerLen != fileSize {
+		return nil, errors.New("invalid compressed log block table")
+	}
+	table := make([]byte, blocks*8)
+	if _, err := file.ReadAt(table, tableOffset); err != nil {
+		return nil, errors.Wrap(err, "read block table failed")
+	}
+	offsets := make([]int64, blocks+1)
+	for i := int64(0); i < blocks; i++ {
+		offsets[i] = int64(proto.Encoding.Uint64(table[i*8:]))
+	}
+	offsets[blocks] = tableOffset
+	return &compressedReader{
+		file:      file,
+		codec:     codec,
+		size:      size,
+		blockSize: blockSize,
+		offsets:   offsets,
+		block:     -1,
+		buf:       make([]byte, blockSize),
+	}, nil
+}
+
+// ReadAt reads len(p) uncompressed bytes starting at the given offset. Like
+// os.File, it returns io.EOF if it reads fewer bytes because the log ends.
+func (r *compressedReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.size {
+			return n, io.EOF
+		}
+		data, err := r.readBlock(pos / r.blockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos%r.blockSize:])
+	}
+	return n, nil
+}
+
+// readBlock returns the uncompressed contents of the given block.
+func (r *compressedReader) readBlock(block int64) ([]byte, error) {
+	length := r.blockSize
+	if remaining := r.size - block*r.blockSize; remaining < length {
+		length = remaining
+	}
+	data := r.buf[:length]
+	if block == r.block {
+		return data, nil
+	}
+	start, end := r.offsets[block], r.offsets[block+1]
+	if end-start == length {
+		// The block is stored uncompressed.
+		if _, err := r.file.ReadAt(data, start); err != nil {
+			return nil, errors.Wrap(err, "read block failed")
+		}
+		r.block = block
+		return data, nil
+	}
+	if int64(cap(r.scratch)) < end-start {
+		r.scratch = make([]byte, end-start)
+	}
+	compressed := r.scratch[:end-start]
+	if _, err := r.file.ReadAt(compressed, start); err != nil {
+		return nil, errors.Wrap(err, "read block failed")
+	}
+	// Invalidate the buffered block in case decompression fails partway.
+	r.block = -1
+	if err := r.codec.decompress(compressed, data); err != nil {
+		return nil, errors.Wrapf(err, "decompress block %d failed", block)
+	}
+	r.block = block
+	return data, nil
+}
+
+// compressedLogWriter is the writer of segments with compressed logs, which
+// are sealed.
+type compressedLogWriter struct{}
+
+func (compressedLogWriter) Write([]byte) (int, error) {
+	return 0, ErrCompressedLog
+}
+
+// Compressed writes a copy of the segment with its log compressed using the
+// given codec and returns it. The segment must be sealed. The copy is
+// written next to the segment and replaces it with Replace.
+func (s *segment) Compressed(compression SegmentCompression) (*segment, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	compressed := newLazySegment(s.path, s.BaseOffset, s.maxBytes, compressedSuffix, s.ioUring,
+		s.indexInterval, s.indexAccess, s.blockCache, s.keys, s.messageFormat)
+	if err := writeCompressedLog(compressed.logPath(), segmentLogReader{s}, s.Position(),
+		compression); err != nil {
+		os.Remove(compressed.logPath()) // nolint: errcheck
+		return nil, err
+	}
+	// Positions are those of the uncompressed messages, so the indexes are
+	// copied as they are.
+	for _, paths := range [][2]string{
+		{s.indexPath(), compressed.indexPath()},
+		{s.timeIndexPath(), compressed.timeIndexPath()},
+	} {
+		if err := copyFile(paths[0], paths[1]); err != nil {
+			compressed.Delete() // nolint: errcheck
+			return nil, err
+		}
+	}
+	if err := compressed.load(); err != nil {
+		compressed.Delete() // nolint: errcheck
+		return nil, err
+	}
+	return compressed, nil
+}
+
+// segmentLogReader reads a segment's log without going through the block
+// cache, so compressing a segment doesn't evict blocks being read.
+type segmentLogReader struct {
+	s *segment
+}
+
+func (r segmentLogReader) ReadAt(p []byte, off int64) (int, error) {
+	r.s.RLock()
+	defer r.s.RUnlock()
+	if r.s.closed {
+		if r.s.replaced {
+			return 0, ErrSegmentReplaced
+		}
+		return 0, ErrSegmentClosed
+	}
+	return r.s.reader.ReadAt(p, off)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "open file failed")
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return errors.Wrap(err, "create file failed")
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return errors.Wrap(err, "copy file failed")
+	}
+	if err := out.Sync(); err != nil {
+		return errors.Wrap(err, "sync file failed")
+	}
+	return out.Close()
+}
+
+// compressionEnabled indicates if the logs of sealed segments are
+// compressed.
+func (l *commitLog) compressionEnabled() bool {
+	return l.Compression != "" && l.Compression != CompressionNone
+}
+
+// compress compresses the logs of the sealed segments which are not
+// compressed yet. The active segment is left uncompressed so that appends
+// don't pay for compression. It must be called with cleanMu held.
+func (l *commitLog) compress(ctx context.Context) error {
+	segments := l.Segments()
+	for _, seg := range segments[:len(segments)-1] {
+		if seg.IsEmpty() || seg.IsCompressed() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		compressed, err := seg.Compressed(l.Compression)
+		if err != nil {
+			if errors.Cause(err) == ErrSegmentReplaced || errors.Cause(err) == ErrSegmentClosed {
+				// The segment was truncated or deleted meanwhile.
+				continue
+			}
+			return errors.Wrapf(err, "failed to compress segment %d", seg.BaseOffset)
+		}
+		if err := l.replaceSegment(seg, compressed); err != nil {
+			return errors.Wrapf(err, "failed to compress segment %d", seg.BaseOffset)
+		}
+		l.Logger.Debugf("Compressed segment %d of log %s with %s", seg.BaseOffset, l.name,
+			l.Compression)
+	}
+	return nil
+}
+
+// replaceSegment replaces the given segment of the log with its new version.
+// If the segment is no longer part of the log, e.g. because it was truncated
+// meanwhile, the new version is deleted instead.
+func (l *commitLog) replaceSegment(old, replacement *segment) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	idx := -1
+	for i, seg := range l.segments {
+		if seg == old {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 || idx == len(l.segments)-1 {
+		return replacement.Delete()
+	}
+	if err := replacement.Replace(old); err != nil {
+		return err
+	}
+	// Readers hold the slice of segments, so it's copied rather than
+	// modified in place.
+	segments := make([]*segment, len(l.segments))
+	copy(segments, l.segments)
+	segments[idx] = replacement
+	l.segments = segments
+	return nil
+}
+
+// compressTask runs once after a segment is rolled to compress the segment
+// which was sealed.
+func (l *commitLog) compressTask() time.Duration {
+	l.cleanMu.Lock()
+	defer l.cleanMu.Unlock()
+	select {
+	case <-l.stopClean:
+		return 0
+	default:
+	}
+	ctx, cancel := l.cleanContext(context.Background())
+	defer cancel()
+	if err := l.compress(ctx); err != nil && err != context.Canceled {
+		l.Logger.Errorf("Failed to compress log %s: %v", l.Path, err)
+	}
+	return 0
+}
@@ -0,0 +1,179 @@
+package commitlog
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// compressionTestMessages returns messages spanning several compressed blocks
+// per segment, alternating between compressible and incompressible values.
+func compressionTestMessages(n int) []*Message {
+	rng := rand.New(rand.NewSource(1))
+	msgs := make([]*Message, n)
+	for i := range msgs {
+		value := make([]byte, 4096)
+		if i%2 == 0 {
+			rng.Read(value)
+		} else {
+			value = bytes.Repeat([]byte{byte(i)}, len(value))
+		}
+		msgs[i] = &Message{
+			Key:       []byte("foo"),
+			Value:     value,
+			Timestamp: int64(i + 1),
+			Headers:   map[string][]byte{"bar": []byte("baz")},
+		}
+	}
+	return msgs
+}
+
+// Ensure sealed segments are compressed when the log is cleaned, while the
+// active segment is not, and compressed segments are read transparently,
+// including after the log is reopened.
+func TestSegmentCompression(t *testing.T) {
+	for _, compression := range []SegmentCompression{CompressionZstd, CompressionLZ4} {
+		t.Run(string(compression), func(t *testing.T) {
+			opts := Options{
+				Path:            tempDir(t),
+				MaxSegmentBytes: 200 * 1024,
+				Compression:     compression,
+			}
+			l, cleanup := setupWithOptions(t, opts)
+			defer cleanup()
+
+			msgs := compressionTestMessages(120)
+			for _, msg := range msgs {
+				_, err := l.Append([]*Message{msg})
+				require.NoError(t, err)
+			}
+			require.NoError(t, l.Clean(context.Background()))
+
+			segments := l.Segments()
+			require.True(t, len(segments) > 2)
+			for _, seg := range segments[:len(segments)-1] {
+				require.True(t, seg.IsCompressed())
+				info, err := os.Stat(seg.logPath())
+				require.NoError(t, err)
+				require.True(t, info.Size() < seg.Position())
+			}
+			require.False(t, l.activeSegment().IsCompressed())
+			requireLogMessages(t, l, msgs)
+
+			// Offsets are found by timestamp in compressed segments.
+			offset, err := l.EarliestOffsetAfterTimestamp(50)
+			require.NoError(t, err)
+			require.Equal(t, int64(49), offset)
+
+			// Compressed logs can be dumped.
+			dumped := 0
+			format, err := DumpLog(segments[0].logPath(), func(msg *DumpedMessage) bool {
+				require.True(t, msg.CrcValid)
+				require.Equal(t, msgs[dumped].Value, msg.Value)
+				dumped++
+				return true
+			})
+			require.NoError(t, err)
+			require.Equal(t, FormatV2, format)
+			require.Equal(t, segments[1].BaseOffset, int64(dumped))
+
+			// Appends continue in the active segment.
+			more := compressionTestMessages(130)[120:]
+			for _, msg := range more {
+				_, err := l.Append([]*Message{msg})
+				require.NoError(t, err)
+			}
+			msgs = append(msgs, more...)
+			requireLogMessages(t, l, msgs)
+
+			require.NoError(t, l.Close())
+			l, cleanup = setupWithOptions(t, opts)
+			defer cleanup()
+			require.True(t, l.Segments()[0].IsCompressed())
+			requireLogMessages(t, l, msgs)
+		})
+	}
+}
+
+// Ensure the segment sealed when a new segment is rolled is compressed in
+// the background.
+func TestSegmentCompressionOnRoll(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 200 * 1024,
+		Compression:     CompressionLZ4,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	msgs := compressionTestMessages(60)
+	for _, msg := range msgs {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 1)
+	require.Eventually(t, func() bool {
+		return l.Segments()[0].IsCompressed()
+	}, 5*time.Second, 10*time.Millisecond)
+	require.False(t, l.activeSegment().IsCompressed())
+	requireLogMessages(t, l, msgs)
+}
+
+// Ensure truncating the log into a compressed segment, or back to the start
+// of the segment after one, leaves an uncompressed active segment which can
+// be appended to.
+func TestSegmentCompressionTruncate(t *testing.T) {
+	for _, truncate := range []string{"middle", "base"} {
+		t.Run(truncate, func(t *testing.T) {
+			opts := Options{
+				Path:            tempDir(t),
+				MaxSegmentBytes: 200 * 1024,
+				Compression:     CompressionZstd,
+			}
+			l, cleanup := setupWithOptions(t, opts)
+			defer cleanup()
+
+			msgs := compressionTestMessages(160)
+			for _, msg := range msgs {
+				_, err := l.Append([]*Message{msg})
+				require.NoError(t, err)
+			}
+			require.NoError(t, l.Clean(context.Background()))
+			segments := l.Segments()
+			require.True(t, len(segments) > 2)
+			require.True(t, segments[0].IsCompressed())
+
+			offset := segments[1].BaseOffset
+			if truncate == "middle" {
+				offset = segments[0].BaseOffset + 10
+			}
+			require.NoError(t, l.Truncate(offset))
+			require.False(t, l.activeSegment().IsCompressed())
+			require.Equal(t, offset-1, l.NewestOffset())
+
+			msgs = msgs[:offset]
+			more := compressionTestMessages(int(offset) + 5)[offset:]
+			for _, msg := range more {
+				_, err := l.Append([]*Message{msg})
+				require.NoError(t, err)
+			}
+			msgs = append(msgs, more...)
+			requireLogMessages(t, l, msgs)
+		})
+	}
+}
+
+// Ensure unknown codecs are rejected.
+func TestSegmentCompressionInvalid(t *testing.T) {
+	_, err := ParseSegmentCompression("gzip")
+	require.Error(t, err)
+	path := tempDir(t)
+	defer remove(t, path)
+	_, err = New(Options{Path: path, Compression: "gzip"})
+	require.Error(t, err)
+}
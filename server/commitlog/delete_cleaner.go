@@ -1,6 +1,7 @@
 package commitlog
 
 import (
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -28,18 +29,37 @@ type deleteCleanerOptions struct {
 // deleteCleaner implements the delete cleanup policy which deletes old log
 // segments based on the retention policy.
 type deleteCleaner struct {
+	mu sync.Mutex // Protects Retention, held while cleaning
 	deleteCleanerOptions
 }
 
 // newDeleteCleaner returns a new cleaner which enforces log retention
 // policies by deleting segments.
 func newDeleteCleaner(opts deleteCleanerOptions) *deleteCleaner {
-	return &deleteCleaner{opts}
+	return &deleteCleaner{deleteCleanerOptions: opts}
+}
+
+// setRetention changes the retention policy enforced by subsequent cleans.
+func (c *deleteCleaner) setRetention(bytes, messages int64, age time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Retention.Bytes = bytes
+	c.Retention.Messages = messages
+	c.Retention.Age = age
 }
 
 // Clean will enforce the log retention policy by deleting old segments.
 // Deletion only occurs at the segment granularity.
 func (c *deleteCleaner) Clean(segments []*segment) ([]*segment, error) {
+	return c.CleanFirst(segments, len(segments))
+}
+
+// CleanFirst is like Clean but only deletes segments among the first
+// deletable ones, e.g. to keep segments which have not been offloaded to
+// tiered storage yet.
+func (c *deleteCleaner) CleanFirst(segments []*segment, deletable int) ([]*segment, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	var err error
 	if len(segments) == 0 || c.noRetentionLimits() {
 		return segments, nil
@@ -50,23 +70,27 @@ func (c *deleteCleaner) Clean(segments []*segment) ([]*segment, error) {
 
 	// Limit by age first.
 	if c.Retention.Age > 0 {
-		segments, err = c.applyAgeLimit(segments)
+		n := len(segments)
+		segments, err = c.applyAgeLimit(segments, deletable)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to apply age retention limit")
 		}
+		deletable -= n - len(segments)
 	}
 
 	// Next limit by number of messages.
 	if c.Retention.Messages > 0 {
-		segments, err = c.applyMessagesLimit(segments)
+		n := len(segments)
+		segments, err = c.applyMessagesLimit(segments, deletable)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to apply message retention limit")
 		}
+		deletable -= n - len(segments)
 	}
 
 	// Lastly limit by number of bytes.
 	if c.Retention.Bytes > 0 {
-		segments, err = c.applyBytesLimit(segments)
+		segments, err = c.applyBytesLimit(segments, deletable)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to apply bytes retention limit")
 		}
@@ -79,7 +103,7 @@ func (c *deleteCleaner) noRetentionLimits() bool {
 	return c.Retention.Bytes == 0 && c.Retention.Messages == 0 && c.Retention.Age == 0
 }
 
-func (c *deleteCleaner) applyMessagesLimit(segments []*segment) ([]*segment, error) {
+func (c *deleteCleaner) applyMessagesLimit(segments []*segment, deletable int) ([]*segment, error) {
 	// We must retain at least the active segment.
 	if len(segments) <= 1 {
 		return segments, nil
@@ -97,7 +121,7 @@ func (c *deleteCleaner) applyMessagesLimit(segments []*segment) ([]*segment, err
 	for i = len(segments) - 2; i > -1; i-- {
 		s := segments[i]
 		totalMessages += s.MessageCount()
-		if totalMessages > c.Retention.Messages {
+		if totalMessages > c.Retention.Messages && i < deletable {
 			break
 		}
 		cleanedSegments = append([]*segment{s}, cleanedSegments...)
@@ -117,7 +141,7 @@ func (c *deleteCleaner) applyMessagesLimit(segments []*segment) ([]*segment, err
 	return cleanedSegments, nil
 }
 
-func (c *deleteCleaner) applyBytesLimit(segments []*segment) ([]*segment, error) {
+func (c *deleteCleaner) applyBytesLimit(segments []*segment, deletable int) ([]*segment, error) {
 	// We must retain at least the active segment.
 	if len(segments) <= 1 {
 		return segments, nil
@@ -135,7 +159,7 @@ func (c *deleteCleaner) applyBytesLimit(segments []*segment) ([]*segment, error)
 	for i = len(segments) - 2; i > -1; i-- {
 		s := segments[i]
 		totalBytes += s.Position()
-		if totalBytes > c.Retention.Bytes {
+		if totalBytes > c.Retention.Bytes && i < deletable {
 			break
 		}
 		cleanedSegments = append([]*segment{s}, cleanedSegments...)
@@ -155,7 +179,7 @@ func (c *deleteCleaner) applyBytesLimit(segments []*segment) ([]*segment, error)
 	return cleanedSegments, nil
 }
 
-func (c *deleteCleaner) applyAgeLimit(segments []*segment) ([]*segment, error) {
+func (c *deleteCleaner) applyAgeLimit(segments []*segment, deletable int) ([]*segment, error) {
 	// We must retain at least the active segment.
 	if len(segments) <= 1 {
 		return segments, nil
@@ -169,7 +193,7 @@ func (c *deleteCleaner) applyAgeLimit(segments []*segment) ([]*segment, error) {
 	// Delete all segments whose last-written timestamp is less than the TTL
 	// with the exception of the active (last) segment.
 	for i, seg := range segments {
-		if i != len(segments)-1 && seg.lastWriteTime < ttl {
+		if i != len(segments)-1 && i < deletable && seg.LastWriteTime() < ttl {
 			// TODO: There is an edge case here where we fail partway through
 			// deletion. We will delete some segments but return an error. This
 			// should probably mark segments for deletion, remove them from the
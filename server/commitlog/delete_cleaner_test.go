@@ -17,7 +17,7 @@ func noopLogger() logger.Logger {
 }
 
 func createSegment(t require.TestingT, dir string, baseOffset, maxBytes int64) *segment {
-	s, err := newSegment(dir, baseOffset, maxBytes, false, "")
+	s, err := newSegment(dir, baseOffset, maxBytes, false, "", false, 0, indexAccess{}, nil, nil, "")
 	require.NoError(t, err)
 	return s
 }
@@ -0,0 +1,364 @@
+package commitlog
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// minMessageSize is the size of an encoded message with a null key and value
+// and no headers: CRC, magic byte, attributes, key and value sizes, and header
+// count.
+const minMessageSize = 4 + 1 + 1 + 4 + 4 + 2
+
+// DumpedMessage is a message read from a segment log file by DumpLog.
+type DumpedMessage struct {
+	Offset      int64
+	Timestamp   int64
+	LeaderEpoch uint64
+	// Position is the position of the message in the log, excluding the
+	// file header, as referenced by index entries.
+	Position int64
+	// Size is the size of the message including its message set header.
+	Size int32
+	Crc  uint32
+	// CrcValid indicates if the CRC matches the message contents. The
+	// remaining fields are only decoded if it does.
+	CrcValid   bool
+	MagicByte  int8
+	Attributes int8
+	Key        []byte
+	Value      []byte
+	Headers    map[string][]byte
+}
+
+// DumpedIndexEntry is an entry read from a segment index file by DumpIndex.
+type DumpedIndexEntry struct {
+	Offset    int64
+	Timestamp int64
+	Position  int64
+	Size      int32
+}
+
+// DumpLog reads the segment log file at the given path and calls fn with each
+// of its messages in order until fn returns false. It returns the format
+// version of the file. Unlike the read path, messages with a CRC mismatch are
+// returned rather than treated as fatal, and the file is only opened for
+// reading, so this can be used to inspect the segments of a running server.
+// Compressed and batched logs are decoded, so positions are those of the
+// messages in a FormatV1 log. An error is returned if the file ends with a
+// partially written message or batch. Encrypted logs can't be dumped since the key
+// provider is not available outside of the server, so ErrEncryptedLog is
+// returned for them. Use DumpEncryptedLog if the keys are available.
+func DumpLog(path string, fn func(*DumpedMessage) bool) (int, error) {
+	return dumpLog(path, nil, fn)
+}
+
+// DumpEncryptedLog reads the segment log file at the given path like DumpLog,
+// decrypting it with the keys the given provider has for the given name, e.g.
+// the log's stream, if it's encrypted.
+func DumpEncryptedLog(path string, keys KeyProvider, name string,
+	fn func(*DumpedMessage) bool) (int, error) {
+
+	return dumpLog(path, &segmentKeys{provider: keys, name: name}, fn)
+}
+
+func dumpLog(path string, keys *segmentKeys, fn func(*DumpedMessage) bool) (int, error) {
+	file, size, err := openDumpFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	format, headerLen, err := readFileHeader(file, size, logMagic, logHeaderLen)
+	if err != nil {
+		return 0, err
+	}
+	if format == FormatV3 && keys == nil {
+		return format, ErrEncryptedLog
+	}
+
+	messages := io.NewSectionReader(file, headerLen, size-headerLen)
+	if format == FormatV2 {
+		compressed, err := openCompressedLog(file, size)
+		if err != nil {
+			return format, err
+		}
+		messages = io.NewSectionReader(compressed, 0, compressed.size)
+	}
+	var tornErr error
+	if format == FormatV3 || format == FormatV4 {
+		var records *recordLog
+		if format == FormatV3 {
+			records, err = readDumpEncryptedLog(file, size, path, keys)
+		} else {
+			records, err = openRecordLog(file, size, headerLen, batchCodec{})
+		}
+		if err != nil {
+			return format, err
+		}
+		if records.end < size {
+			tornErr = errors.Errorf("truncated record at position %d", records.size)
+		}
+		messages = io.NewSectionReader(records, 0, records.size)
+	}
+	length := messages.Size()
+
+	var (
+		r        = bufio.NewReader(messages)
+		header   = make([]byte, msgSetHeaderLen)
+		position int64
+	)
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return format, tornErr
+		} else if err != nil {
+			return format, errors.Errorf("truncated message set header at position %d", position)
+		}
+		ms := messageSet(header)
+		msgSize := ms.Size()
+		if msgSize < minMessageSize || int64(msgSize) > length-position-msgSetHeaderLen {
+			return format, errors.Errorf("invalid message size %d at position %d", msgSize, position)
+		}
+		buf := make([]byte, msgSize)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return format, errors.Wrapf(err, "failed to read message at position %d", position)
+		}
+		var (
+			m   = SerializedMessage(buf)
+			msg = &DumpedMessage{
+				Offset:      ms.Offset(),
+				Timestamp:   ms.Timestamp(),
+				LeaderEpoch: ms.LeaderEpoch(),
+				Position:    position,
+				Size:        msgSize + msgSetHeaderLen,
+				Crc:         m.Crc(),
+			}
+		)
+		msg.CrcValid = crc32.Checksum(m[4:], crc32cTable) == msg.Crc
+		if msg.CrcValid {
+			msg.MagicByte = m.MagicByte()
+			msg.Attributes = m.Attributes()
+			msg.Key = m.Key()
+			msg.Value = m.Value()
+			msg.Headers = m.Headers()
+		}
+		if !fn(msg) {
+			return format, nil
+		}
+		position += int64(msg.Size)
+	}
+}
+
+// DumpIndex reads the segment index file at the given path and calls fn with
+// each of its entries in order until fn returns false. It returns the format
+// version of the file. The base offset of the index is taken from the file
+// name.
+func DumpIndex(path string, fn func(*DumpedIndexEntry) bool) (int, error) {
+	baseOffset, err := parseBaseOffset(path)
+	if err != nil {
+		return 0, err
+	}
+	file, size, err := openDumpFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	format, headerLen, err := readFileHeader(file, size, indexMagic, indexHeaderLen)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		r = bufio.NewReader(io.NewSectionReader(file, headerLen, size-headerLen))
+		p = make([]byte, entryWidth)
+	)
+	for {
+		if _, err := io.ReadFull(r, p); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return format, nil
+		} else if err != nil {
+			return format, errors.Wrap(err, "failed to read index entry")
+		}
+		rel := relEntry{
+			Offset:    int32(proto.Encoding.Uint32(p[0:])),
+			Timestamp: int64(proto.Encoding.Uint64(p[offsetWidth:])),
+			Position:  int32(proto.Encoding.Uint32(p[offsetWidth+timestampWidth:])),
+			Size:      int32(proto.Encoding.Uint32(p[offsetWidth+timestampWidth+positionWidth:])),
+		}
+		// Index files are preallocated, so the first empty entry marks the
+		// end of the index.
+		if rel.Position == 0 && rel.Timestamp == 0 && rel.Size == 0 {
+			return format, nil
+		}
+		var e entry
+		rel.fill(&e, baseOffset)
+		if !fn(&DumpedIndexEntry{
+			Offset:    e.Offset,
+			Timestamp: e.Timestamp,
+			Position:  e.Position,
+			Size:      e.Size,
+		}) {
+			return format, nil
+		}
+	}
+}
+
+// DumpedTimeIndexEntry is an entry read from a segment time index file by
+// DumpTimeIndex.
+type DumpedTimeIndexEntry struct {
+	// Timestamp is the largest timestamp of the messages up to and including
+	// the one at Offset.
+	Timestamp int64
+	Offset    int64
+	Position  int64
+}
+
+// DumpTimeIndex reads the segment time index file at the given path and calls
+// fn with each of its entries in order until fn returns false. It returns the
+// format version of the file. The base offset of the time index is taken from
+// the file name.
+func DumpTimeIndex(path string, fn func(*DumpedTimeIndexEntry) bool) (int, error) {
+	baseOffset, err := parseBaseOffset(path)
+	if err != nil {
+		return 0, err
+	}
+	file, size, err := openDumpFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	format, headerLen, err := readFileHeader(file, size, timeIndexMagic, timeIndexHeaderLen)
+	if err != nil {
+		return 0, err
+	}
+	if headerLen == 0 {
+		return format, errors.New("time index has no header")
+	}
+	ti := &timeIndex{file: file, baseOffset: baseOffset}
+	var e timeEntry
+	for i := int64(0); i < (size-headerLen)/timeEntryWidth; i++ {
+		if err := ti.readEntry(&e, i); err != nil {
+			return format, err
+		}
+		if !fn(&DumpedTimeIndexEntry{
+			Timestamp: e.Timestamp,
+			Offset:    e.Offset,
+			Position:  e.Position,
+		}) {
+			return format, nil
+		}
+	}
+	return format, nil
+}
+
+// DumpDir reads the segment logs in the log directory at the given path in
+// order and calls fn with each of their messages until fn returns false. Like
+// DumpLog, the files are only opened for reading. Encrypted segments are
+// decrypted with the keys the given provider has for the given name, and keys
+// may be nil if the log is not encrypted. Segments offloaded to tiered storage
+// are not read.
+func DumpDir(path string, keys KeyProvider, name string, fn func(*DumpedMessage) bool) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open dir failed")
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close() // nolint: errcheck
+	if err != nil {
+		return errors.Wrap(err, "read dir failed")
+	}
+	sort.Strings(names)
+	var dirKeys *segmentKeys
+	if keys != nil {
+		dirKeys = &segmentKeys{provider: keys, name: name}
+	}
+	done := false
+	for _, fileName := range names {
+		if !strings.HasSuffix(fileName, logFileSuffix) {
+			continue
+		}
+		file := filepath.Join(path, fileName)
+		if _, err := dumpLog(file, dirKeys, func(msg *DumpedMessage) bool {
+			done = !fn(msg)
+			return !done
+		}); err != nil {
+			return errors.Wrapf(err, "failed to read %s", file)
+		}
+		if done {
+			return nil
+		}
+	}
+	return nil
+}
+
+// DumpHighWatermark returns the high watermark last checkpointed to the log
+// directory at the given path, or -1 if it has never been checkpointed. The
+// high watermark is checkpointed when the log is closed.
+func DumpHighWatermark(path string) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(path, hwFileName))
+	if os.IsNotExist(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "read high watermark file failed")
+	}
+	hw, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse high watermark file failed")
+	}
+	return hw, nil
+}
+
+// readDumpEncryptedLog opens the encrypted segment log file at the given path
+// for reading with the key its header refers to.
+func readDumpEncryptedLog(file *os.File, size int64, path string, keys *segmentKeys) (
+	*recordLog, error) {
+
+	baseOffset, err := parseBaseOffset(path)
+	if err != nil {
+		return nil, err
+	}
+	header, err := readEncryptedLogHeader(file, size)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys.provider.Key(keys.name, header.keyID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get encryption key %q", header.keyID)
+	}
+	return readEncryptedLog(file, size, header, baseOffset, key)
+}
+
+// parseBaseOffset returns the base offset of the segment file at the given
+// path, which is taken from its name.
+func parseBaseOffset(path string) (int64, error) {
+	name := filepath.Base(path)
+	baseOffset, err := strconv.ParseInt(strings.SplitN(name, ".", 2)[0], 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("%s is not named after its base offset", name)
+	}
+	return baseOffset, nil
+}
+
+// openDumpFile opens the given file for reading and returns its size.
+func openDumpFile(path string) (*os.File, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "open file failed")
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, errors.Wrap(err, "stat file failed")
+	}
+	return file, fi.Size(), nil
+}
@@ -0,0 +1,168 @@
+package commitlog
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure DumpLog, DumpIndex and DumpTimeIndex return the messages and index
+// entries of a segment.
+func TestDumpSegment(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	msgs := []*Message{
+		{Key: []byte("a"), Value: []byte("one"), Timestamp: 1, LeaderEpoch: 42, Headers: headers},
+		{Value: []byte("two"), Timestamp: 2, LeaderEpoch: 43},
+	}
+	_, err := l.Append(msgs)
+	require.NoError(t, err)
+	segment := l.Segments()[0]
+	require.NoError(t, l.Close())
+
+	var dumped []*DumpedMessage
+	format, err := DumpLog(segment.logPath(), func(msg *DumpedMessage) bool {
+		dumped = append(dumped, msg)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, CurrentFormat, format)
+	require.Len(t, dumped, 2)
+	var position int64
+	for i, msg := range dumped {
+		require.Equal(t, int64(i), msg.Offset)
+		require.Equal(t, msgs[i].Timestamp, msg.Timestamp)
+		require.Equal(t, msgs[i].LeaderEpoch, msg.LeaderEpoch)
+		require.Equal(t, position, msg.Position)
+		require.True(t, msg.CrcValid)
+		require.Equal(t, msgs[i].Key, msg.Key)
+		require.Equal(t, msgs[i].Value, msg.Value)
+		require.Len(t, msg.Headers, len(msgs[i].Headers))
+		position += int64(msg.Size)
+	}
+
+	var entries []*DumpedIndexEntry
+	format, err = DumpIndex(segment.indexPath(), func(e *DumpedIndexEntry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, CurrentFormat, format)
+	require.Len(t, entries, 2)
+	for i, e := range entries {
+		require.Equal(t, dumped[i].Offset, e.Offset)
+		require.Equal(t, dumped[i].Timestamp, e.Timestamp)
+		require.Equal(t, dumped[i].Position, e.Position)
+		require.Equal(t, dumped[i].Size, e.Size)
+	}
+
+	var timeEntries []*DumpedTimeIndexEntry
+	format, err = DumpTimeIndex(segment.timeIndexPath(), func(e *DumpedTimeIndexEntry) bool {
+		timeEntries = append(timeEntries, e)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, CurrentFormat, format)
+	// The time index is sparse, so only the first message is indexed.
+	require.Len(t, timeEntries, 1)
+	require.Equal(t, dumped[0].Offset, timeEntries[0].Offset)
+	require.Equal(t, dumped[0].Timestamp, timeEntries[0].Timestamp)
+	require.Equal(t, dumped[0].Position, timeEntries[0].Position)
+
+	// Stop once the callback returns false.
+	count := 0
+	_, err = DumpLog(segment.logPath(), func(msg *DumpedMessage) bool {
+		count++
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// Corrupt the value of the second message.
+	f, err := os.OpenFile(segment.logPath(), os.O_WRONLY, 0666)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("x"), logHeaderLen+dumped[1].Position+int64(dumped[1].Size)-3)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	dumped = nil
+	_, err = DumpLog(segment.logPath(), func(msg *DumpedMessage) bool {
+		dumped = append(dumped, msg)
+		return true
+	})
+	require.NoError(t, err)
+	require.Len(t, dumped, 2)
+	require.True(t, dumped[0].CrcValid)
+	require.False(t, dumped[1].CrcValid)
+	require.Nil(t, dumped[1].Value)
+
+	// Truncate the last message.
+	require.NoError(t, os.Truncate(segment.logPath(), logHeaderLen+dumped[1].Position+10))
+	dumped = nil
+	_, err = DumpLog(segment.logPath(), func(msg *DumpedMessage) bool {
+		dumped = append(dumped, msg)
+		return true
+	})
+	require.Error(t, err)
+	require.Len(t, dumped, 1)
+}
+
+// Ensure DumpDir returns the messages of every segment of a log in order,
+// decrypting encrypted segments, and DumpHighWatermark returns the high
+// watermark checkpointed when the log was closed.
+func TestDumpDir(t *testing.T) {
+	keys := newTestKeyProvider()
+	opts := Options{
+		Path:              tempDir(t),
+		MaxSegmentBytes:   100,
+		EncryptionKeys:    keys,
+		EncryptionKeyName: "foo",
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	hw, err := DumpHighWatermark(opts.Path)
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), hw)
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: []byte("hello"), Timestamp: int64(i)}})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 1)
+	l.SetHighWatermark(8)
+	segment := l.Segments()[0]
+	require.NoError(t, l.Close())
+
+	hw, err = DumpHighWatermark(opts.Path)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), hw)
+
+	_, err = DumpLog(segment.logPath(), func(*DumpedMessage) bool { return true })
+	require.Equal(t, ErrEncryptedLog, err)
+
+	var offsets []int64
+	err = DumpDir(opts.Path, keys, "foo", func(msg *DumpedMessage) bool {
+		require.True(t, msg.CrcValid)
+		require.Equal(t, []byte("hello"), msg.Value)
+		offsets = append(offsets, msg.Offset)
+		return true
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, offsets)
+
+	// Stop once the callback returns false.
+	offsets = nil
+	err = DumpDir(opts.Path, keys, "foo", func(msg *DumpedMessage) bool {
+		offsets = append(offsets, msg.Offset)
+		return msg.Offset < 5
+	})
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2, 3, 4, 5}, offsets)
+
+	// The segments can't be read without their keys.
+	err = DumpDir(opts.Path, nil, "", func(*DumpedMessage) bool { return true })
+	require.Error(t, err)
+}
@@ -3,6 +3,7 @@ package commitlog
 import (
 	"encoding/binary"
 	"errors"
+	"hash/crc32"
 	"math"
 )
 
@@ -339,3 +340,104 @@ func (e *byteEncoder) Pop() {
 	e.stack = e.stack[:len(e.stack)-1]
 	pe.Fill(e.off, e.b)
 }
+
+// minReferencedBytes is the size from which byte fields of messages appended
+// to a log are written from the messages themselves rather than copied into
+// the message set buffer. Smaller fields are cheaper to copy than to write
+// separately.
+const minReferencedBytes = 4096
+
+// refLenEncoder is a lenEncoder which does not count byte fields of at least
+// minRef bytes, which are referenced by a refEncoder. A minRef of 0 counts
+// all byte fields.
+type refLenEncoder struct {
+	lenEncoder
+	minRef int
+}
+
+// PutBytes increments length for a size-prefixed byte array, counting only
+// the size if the array is referenced.
+func (e *refLenEncoder) PutBytes(in []byte) error {
+	if e.minRef == 0 || len(in) < e.minRef {
+		return e.lenEncoder.PutBytes(in)
+	}
+	if len(in) > math.MaxInt32 {
+		return errInvalidByteSliceLength
+	}
+	e.Length += 4
+	return nil
+}
+
+// refEncoder is a byteEncoder which references byte fields of at least minRef
+// bytes rather than copying them into its byte slice. The serialized data is
+// the byte slice interleaved with the referenced fields, as returned by
+// buffers. A minRef of 0 copies all byte fields.
+type refEncoder struct {
+	*byteEncoder
+	minRef int
+	refs   []bytesRef
+	refLen int
+}
+
+// bytesRef is a byte field referenced by a refEncoder.
+type bytesRef struct {
+	off  int // Offset in the byte slice the field follows
+	data []byte
+}
+
+// PutBytes serializes a size-prefixed byte array, referencing the array if
+// it's large enough.
+func (e *refEncoder) PutBytes(in []byte) error {
+	if e.minRef == 0 || len(in) < e.minRef {
+		return e.byteEncoder.PutBytes(in)
+	}
+	e.PutInt32(int32(len(in)))
+	e.refs = append(e.refs, bytesRef{off: e.off, data: in})
+	e.refLen += len(in)
+	return nil
+}
+
+// Pop the stack and run the popped pushEncoder on the serialized data. CRC
+// digests include referenced fields.
+func (e *refEncoder) Pop() {
+	crc, ok := e.stack[len(e.stack)-1].(*crcField)
+	if !ok || len(e.refs) == 0 {
+		e.byteEncoder.Pop()
+		return
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+	var (
+		start  = crc.StartOffset + crc.ReserveSize()
+		digest uint32
+	)
+	for _, ref := range e.refs {
+		if ref.off < start {
+			continue
+		}
+		digest = crc32.Update(digest, crc32cTable, e.b[start:ref.off])
+		digest = crc32.Update(digest, crc32cTable, ref.data)
+		start = ref.off
+	}
+	digest = crc32.Update(digest, crc32cTable, e.b[start:e.off])
+	encoding.PutUint32(e.b[crc.StartOffset:], digest)
+}
+
+// pos returns the position in the serialized data, including referenced
+// fields.
+func (e *refEncoder) pos() int {
+	return e.off + e.refLen
+}
+
+// buffers returns the serialized data as the byte slice interleaved with the
+// referenced fields.
+func (e *refEncoder) buffers() [][]byte {
+	var (
+		bufs  = make([][]byte, 0, 2*len(e.refs)+1)
+		start = 0
+	)
+	for _, ref := range e.refs {
+		bufs = append(bufs, e.b[start:ref.off], ref.data)
+		start = ref.off
+	}
+	return append(bufs, e.b[start:e.off])
+}
@@ -0,0 +1,243 @@
+package commitlog
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// KeyProvider provides the AES keys the logs of segments are encrypted with,
+// e.g. from a key management service. Keys are scoped by a name, which is
+// typically the log's stream, and identified by an ID within it. The ID is
+// stored in the header of each encrypted log so that the log can still be
+// decrypted after the current key is rotated.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key new segments of the logs with
+	// the given name are encrypted with. It's called each time a segment is
+	// created, so a rotated key takes effect on the next segment roll.
+	CurrentKey(name string) (id string, key []byte, err error)
+
+	// Key returns the key with the given ID of the logs with the given name.
+	Key(name, id string) ([]byte, error)
+}
+
+var (
+	// ErrNoEncryptionKeys is returned when opening a segment whose log is
+	// encrypted if the commit log has no KeyProvider.
+	ErrNoEncryptionKeys = errors.New("segment log is encrypted but no key provider is configured")
+
+	// ErrEncryptedLog is returned when dumping a segment log which is
+	// encrypted.
+	ErrEncryptedLog = errors.New("segment log is encrypted")
+)
+
+// Encrypted logs are written in FormatV3. Their header is followed by the ID
+// of the key they're encrypted with, prefixed by its length (2 bytes), and by
+// a nonce (12 bytes) and the tag (16 bytes) of an empty message encrypted
+// with the key, which verifies that the key is correct before the log is
+// recovered. Each write to the log is encrypted with AES-GCM as a record with
+// the following layout:
+//
+// length (4 bytes) nonce (12 bytes) ciphertext (length bytes) tag (16 bytes)
+//
+// Each record is authenticated along with the segment's base offset and the
+// position of its first byte, so records can't be moved within or between
+// segments. Positions are those of the decrypted messages, as with compressed
+// logs, so indexes are not affected by encryption.
+const (
+	encryptedKeyIDLen       = 2
+	encryptedRecordLenWidth = 4
+	encryptedNonceLen       = 12
+	encryptedTagLen         = 16
+	encryptedRecordOverhead = encryptedRecordLenWidth + encryptedNonceLen + encryptedTagLen
+	encryptedKeyCheckLen    = encryptedNonceLen + encryptedTagLen
+)
+
+// segmentKeys provides the keys of a log's segments.
+type segmentKeys struct {
+	provider KeyProvider
+	name     string
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid encryption key")
+	}
+	return cipher.NewGCM(block)
+}
+
+// newEncryptedLogHeader returns the header of an encrypted log whose key has
+// the given ID.
+func newEncryptedLogHeader(keyID string, key []byte) ([]byte, error) {
+	if len(keyID) > math.MaxUint16 {
+		return nil, errors.New("encryption key ID is too long")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	idLen := encryptedKeyIDLen + len(keyID)
+	header := make([]byte, logHeaderLen+idLen, logHeaderLen+idLen+encryptedKeyCheckLen)
+	copy(header, logMagic)
+	proto.Encoding.PutUint16(header[magicLen:], FormatV3)
+	proto.Encoding.PutUint16(header[logHeaderLen:], uint16(len(keyID)))
+	copy(header[logHeaderLen+encryptedKeyIDLen:], keyID)
+	nonce := make([]byte, encryptedNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce failed")
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, nil, nil), nil
+}
+
+// encryptedLogHeader is the part of an encrypted log's header following the
+// usual log header.
+type encryptedLogHeader struct {
+	keyID    string
+	keyCheck []byte
+	length   int64 // Length of the whole header
+}
+
+// readEncryptedLogHeader reads the header of the encrypted log of the given
+// size.
+func readEncryptedLogHeader(file io.ReaderAt, size int64) (*encryptedLogHeader, error) {
+	b := make([]byte, encryptedKeyIDLen)
+	if size < logHeaderLen+encryptedKeyIDLen {
+		return nil, errors.New("encrypted log header is truncated")
+	}
+	if _, err := file.ReadAt(b, logHeaderLen); err != nil {
+		return nil, errors.Wrap(err, "read header failed")
+	}
+	idLen := int64(proto.Encoding.Uint16(b))
+	length := logHeaderLen + encryptedKeyIDLen + idLen + encryptedKeyCheckLen
+	if size < length {
+		return nil, errors.New("encrypted log header is truncated")
+	}
+	b = make([]byte, idLen+encryptedKeyCheckLen)
+	if _, err := file.ReadAt(b, logHeaderLen+encryptedKeyIDLen); err != nil {
+		return nil, errors.Wrap(err, "read header failed")
+	}
+	return &encryptedLogHeader{
+		keyID:    string(b[:idLen]),
+		keyCheck: b[idLen:],
+		length:   length,
+	}, nil
+}
+
+// aeadCodec encrypts the records of an encrypted log.
+type aeadCodec struct {
+	aead       cipher.AEAD
+	baseOffset int64
+}
+
+func (c *aeadCodec) prefixLen() int {
+	return encryptedRecordLenWidth
+}
+
+func (c *aeadCodec) recordLen(prefix []byte) (int64, int64) {
+	n := int64(proto.Encoding.Uint32(prefix))
+	return encryptedRecordOverhead + n, n
+}
+
+// additionalData returns the data the record starting at the given position is
+// authenticated with.
+func (c *aeadCodec) additionalData(pos int64) []byte {
+	ad := make([]byte, 16)
+	proto.Encoding.PutUint64(ad, uint64(c.baseOffset))
+	proto.Encoding.PutUint64(ad[8:], uint64(pos))
+	return ad
+}
+
+func (c *aeadCodec) encode(dst, data []byte, pos int64) ([]byte, error) {
+	var (
+		length = make([]byte, encryptedRecordLenWidth)
+		nonce  = make([]byte, encryptedNonceLen)
+	)
+	proto.Encoding.PutUint32(length, uint32(len(data)))
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce failed")
+	}
+	dst = append(append(dst, length...), nonce...)
+	return c.aead.Seal(dst, nonce, data, c.additionalData(pos)), nil
+}
+
+func (c *aeadCodec) decode(dst, record []byte, pos int64) ([]byte, error) {
+	sealed := record[encryptedRecordLenWidth:]
+	data, err := c.aead.Open(dst, sealed[:encryptedNonceLen], sealed[encryptedNonceLen:],
+		c.additionalData(pos))
+	if err != nil {
+		return nil, errors.Wrapf(err, "decrypt record at position %d failed", pos)
+	}
+	return data, nil
+}
+
+// openEncryptedLog returns a recordLog for the encrypted log file of the
+// given size, which is opened for appending, using the given key. A record
+// partially written before a crash is removed from the end of the log.
+func openEncryptedLog(file *os.File, size int64, header *encryptedLogHeader, baseOffset int64,
+	key []byte) (*recordLog, error) {
+
+	l, err := readEncryptedLog(file, size, header, baseOffset, key)
+	if err != nil {
+		return nil, err
+	}
+	return l, l.repair(size)
+}
+
+// readEncryptedLog opens the encrypted log of the given size like
+// openEncryptedLog without removing a partially written record, so the file is
+// only read.
+func readEncryptedLog(file *os.File, size int64, header *encryptedLogHeader, baseOffset int64,
+	key []byte) (*recordLog, error) {
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	check := header.keyCheck
+	if _, err := aead.Open(nil, check[:encryptedNonceLen], check[encryptedNonceLen:], nil); err != nil {
+		return nil, errors.Errorf("encryption key %q does not match the log", header.keyID)
+	}
+	return openRecordLog(file, size, header.length, &aeadCodec{aead: aead, baseOffset: baseOffset})
+}
+
+// openEncryptedLog opens the segment's encrypted log of the given size using
+// the key its header refers to.
+func (s *segment) openEncryptedLog(log *os.File, size int64) (*recordLog, error) {
+	if s.keys == nil {
+		return nil, ErrNoEncryptionKeys
+	}
+	header, err := readEncryptedLogHeader(log, size)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.keys.provider.Key(s.keys.name, header.keyID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get encryption key %q", header.keyID)
+	}
+	return openEncryptedLog(log, size, header, s.BaseOffset, key)
+}
+
+// newLogHeader returns the header a new log of the segment starts with, which
+// refers to the current encryption key if its logs are encrypted.
+func (s *segment) newLogHeader() ([]byte, error) {
+	if s.keys == nil {
+		if s.messageFormat == MessageFormatV2 {
+			return newBatchedLogHeader(), nil
+		}
+		return newFileHeader(logMagic, logHeaderLen), nil
+	}
+	id, key, err := s.keys.provider.CurrentKey(s.keys.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get encryption key")
+	}
+	return newEncryptedLogHeader(id, key)
+}
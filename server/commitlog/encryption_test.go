@@ -0,0 +1,329 @@
+package commitlog
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeyProvider provides keys from memory and records the names it was
+// asked for.
+type testKeyProvider struct {
+	mu      sync.Mutex
+	keys    map[string][]byte
+	current string
+	names   map[string]struct{}
+}
+
+func newTestKeyProvider() *testKeyProvider {
+	p := &testKeyProvider{
+		keys:  make(map[string][]byte),
+		names: make(map[string]struct{}),
+	}
+	p.rotate("key-1")
+	return p
+}
+
+// rotate adds a key with the given ID and makes it the current key.
+func (p *testKeyProvider) rotate(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[id] = bytes.Repeat([]byte{byte(len(p.keys) + 1)}, 32)
+	p.current = id
+}
+
+func (p *testKeyProvider) CurrentKey(name string) (string, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.names[name] = struct{}{}
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *testKeyProvider) Key(name, id string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.names[name] = struct{}{}
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.Errorf("unknown key %s", id)
+	}
+	return key, nil
+}
+
+// Ensure segment logs are encrypted with the current key when they're
+// created, so a rotated key is used from the next segment on, and are read
+// transparently, including after the log is reopened.
+func TestSegmentEncryption(t *testing.T) {
+	keys := newTestKeyProvider()
+	opts := Options{
+		Path:              tempDir(t),
+		MaxSegmentBytes:   100 * 1024,
+		EncryptionKeys:    keys,
+		EncryptionKeyName: "foo",
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	msgs := compressionTestMessages(60)
+	for i, msg := range msgs {
+		if i == 30 {
+			keys.rotate("key-2")
+		}
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	requireLogMessages(t, l, msgs)
+	require.Equal(t, map[string]struct{}{"foo": {}}, keys.names)
+
+	segments := l.Segments()
+	require.True(t, len(segments) > 2)
+	keyIDs := make(map[string]bool)
+	for _, seg := range segments {
+		require.Equal(t, FormatV3, seg.format)
+		data, err := ioutil.ReadFile(seg.logPath())
+		require.NoError(t, err)
+		header, err := readEncryptedLogHeader(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		keyIDs[header.keyID] = true
+		// Values are not stored in plaintext.
+		require.False(t, bytes.Contains(data, msgs[1].Value[:64]))
+	}
+	require.Equal(t, map[string]bool{"key-1": true, "key-2": true}, keyIDs)
+
+	// Offsets are found by timestamp in encrypted segments.
+	offset, err := l.EarliestOffsetAfterTimestamp(50)
+	require.NoError(t, err)
+	require.Equal(t, int64(49), offset)
+
+	_, err = DumpLog(segments[0].logPath(), func(*DumpedMessage) bool { return true })
+	require.Equal(t, ErrEncryptedLog, err)
+
+	require.NoError(t, l.Close())
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	requireLogMessages(t, l, msgs)
+
+	// Truncation rewrites the active segment with the current key.
+	require.NoError(t, l.Truncate(45))
+	msgs = msgs[:45]
+	more := compressionTestMessages(50)[45:]
+	for _, msg := range more {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	msgs = append(msgs, more...)
+	requireLogMessages(t, l, msgs)
+}
+
+// Ensure existing unencrypted segments remain readable when encryption is
+// enabled and only new segments are encrypted.
+func TestSegmentEncryptionMixedLog(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100 * 1024,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	msgs := compressionTestMessages(60)
+	for _, msg := range msgs[:30] {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	opts.EncryptionKeys = newTestKeyProvider()
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	for _, msg := range msgs[30:] {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	requireLogMessages(t, l, msgs)
+	segments := l.Segments()
+	require.Equal(t, CurrentFormat, segments[0].format)
+	require.Equal(t, FormatV3, segments[len(segments)-1].format)
+}
+
+// Ensure a log with encrypted segments can't be opened without a key
+// provider.
+func TestSegmentEncryptionNoKeys(t *testing.T) {
+	opts := Options{
+		Path:           tempDir(t),
+		EncryptionKeys: newTestKeyProvider(),
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	_, err := l.Append(compressionTestMessages(1))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	opts.EncryptionKeys = nil
+	_, err = New(opts)
+	require.Error(t, err)
+	require.Equal(t, ErrNoEncryptionKeys, errors.Cause(err))
+}
+
+// Ensure compacted segments are encrypted.
+func TestSegmentEncryptionCompact(t *testing.T) {
+	keys := newTestKeyProvider()
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100 * 1024,
+		Compact:         true,
+		EncryptionKeys:  keys,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	// The first messages have unique keys, so they're retained in the first
+	// segment.
+	msgs := compressionTestMessages(60)
+	for i, msg := range msgs {
+		msg.Key = []byte{byte(i % 10)}
+		if i < 10 {
+			msg.Key = []byte{byte(100 + i)}
+		}
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(59)
+	keys.rotate("key-2")
+	require.NoError(t, l.Clean(context.Background()))
+
+	segments := l.Segments()
+	data, err := ioutil.ReadFile(segments[0].logPath())
+	require.NoError(t, err)
+	header, err := readEncryptedLogHeader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Equal(t, "key-2", header.keyID)
+
+	// The latest message of each key is retained.
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	for _, i := range []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 50, 51, 52, 53, 54, 55, 56, 57, 58, 59} {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), make([]byte, 28))
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		compareMessages(t, msgs[i], msg)
+	}
+}
+
+// Ensure a record partially written to an encrypted log before a crash is
+// removed when the log is reopened and appends continue after the last
+// complete record.
+func TestEncryptedLogRecovery(t *testing.T) {
+	for _, torn := range []string{"truncated", "corrupt"} {
+		t.Run(torn, func(t *testing.T) {
+			opts := Options{
+				Path:           tempDir(t),
+				EncryptionKeys: newTestKeyProvider(),
+			}
+			l, cleanup := setupWithOptions(t, opts)
+			defer cleanup()
+			msgs := compressionTestMessages(10)
+			for _, msg := range msgs[:5] {
+				_, err := l.Append([]*Message{msg})
+				require.NoError(t, err)
+			}
+			require.NoError(t, l.Close())
+
+			path := l.Segments()[0].logPath()
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			// The record's length is past the end of the file if it's
+			// truncated. Otherwise its contents are not authentic.
+			tail := make([]byte, encryptedRecordOverhead+100)
+			tail[3] = 100
+			if torn == "truncated" {
+				tail[3] = 200
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+			require.NoError(t, err)
+			_, err = f.Write(tail)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			// Remove the clean shutdown marker to simulate a crash.
+			require.NoError(t, os.Remove(filepath.Join(opts.Path, cleanShutdownFileName)))
+
+			l, cleanup = setupWithOptions(t, opts)
+			defer cleanup()
+			after, err := os.Stat(path)
+			require.NoError(t, err)
+			require.Equal(t, info.Size(), after.Size())
+			for _, msg := range msgs[5:] {
+				_, err := l.Append([]*Message{msg})
+				require.NoError(t, err)
+			}
+			requireLogMessages(t, l, msgs)
+		})
+	}
+}
+
+// Ensure truncating an encrypted log within a record keeps the data before
+// the truncation position.
+func TestEncryptedLogTruncate(t *testing.T) {
+	dir := tempDir(t)
+	defer remove(t, dir)
+	f, err := os.OpenFile(filepath.Join(dir, "log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	defer f.Close()
+	key := bytes.Repeat([]byte{1}, 16)
+	b, err := newEncryptedLogHeader("key", key)
+	require.NoError(t, err)
+	_, err = f.Write(b)
+	require.NoError(t, err)
+	header, err := readEncryptedLogHeader(f, int64(len(b)))
+	require.NoError(t, err)
+
+	l, err := openEncryptedLog(f, int64(len(b)), header, 0, key)
+	require.NoError(t, err)
+	_, err = l.WriteBuffers([][]byte{[]byte("hello "), []byte("world")})
+	require.NoError(t, err)
+	_, err = l.Write([]byte(", goodbye"))
+	require.NoError(t, err)
+	require.Equal(t, int64(20), l.size)
+
+	require.NoError(t, l.truncate(8))
+	_, err = l.Write([]byte("rld"))
+	require.NoError(t, err)
+	p := make([]byte, 11)
+	_, err = l.ReadAt(p, 0)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(p))
+
+	info, err := f.Stat()
+	require.NoError(t, err)
+	l, err = openEncryptedLog(f, info.Size(), header, 0, key)
+	require.NoError(t, err)
+	require.Equal(t, int64(11), l.size)
+	_, err = l.ReadAt(p, 0)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(p))
+
+	// Records are authenticated with the segment's base offset.
+	l.codec.(*aeadCodec).baseOffset, l.record = 1, -1
+	_, err = l.ReadAt(p, 0)
+	require.Error(t, err)
+
+	// The log can't be opened with the wrong key.
+	_, err = openEncryptedLog(f, info.Size(), header, 0, bytes.Repeat([]byte{2}, 16))
+	require.Error(t, err)
+}
+
+// Ensure encryption can't be combined with segment compression.
+func TestSegmentEncryptionCompression(t *testing.T) {
+	path := tempDir(t)
+	defer remove(t, path)
+	_, err := New(Options{
+		Path:           path,
+		Compression:    CompressionZstd,
+		EncryptionKeys: newTestKeyProvider(),
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,71 @@
+package commitlog
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// flushEnabled indicates if the log flushes its active segment after a number
+// of messages or an amount of time, rather than leaving it to the OS.
+// SyncOnAppend flushes every append, so it supersedes the flush policy.
+func (l *commitLog) flushEnabled() bool {
+	return !l.SyncOnAppend && (l.FlushMessages > 0 || l.FlushInterval > 0)
+}
+
+// flushAppend counts the given number of messages appended to the segment
+// towards the flush policy and flushes the segment if FlushMessages has been
+// reached.
+func (l *commitLog) flushAppend(segment *segment, n int) error {
+	if !l.flushEnabled() {
+		return nil
+	}
+	unflushed := atomic.AddInt64(&l.unflushed, int64(n))
+	if l.FlushMessages <= 0 || unflushed < l.FlushMessages {
+		return nil
+	}
+	return l.flush(segment)
+}
+
+// flushDue indicates if FlushInterval has passed since the last flush and
+// messages have been appended since.
+func (l *commitLog) flushDue(now time.Time) bool {
+	if !l.flushEnabled() || l.FlushInterval <= 0 || atomic.LoadInt64(&l.unflushed) == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&l.lastFlush))) >= l.FlushInterval
+}
+
+// flush fsyncs the segment's log and indexes and resets the flush policy.
+// Messages appended while the fsync is in progress may not be covered by it,
+// but are counted towards the next one. Concurrent flushes share an fsync.
+func (l *commitLog) flush(segment *segment) error {
+	atomic.StoreInt64(&l.unflushed, 0)
+	atomic.StoreInt64(&l.lastFlush, time.Now().UnixNano())
+	// A closed segment was removed from the log, e.g. by truncation or
+	// because the log was closed, which flushes its active segment.
+	if err := segment.Sync(l.SyncMaxDelay); err != nil && err != ErrSegmentClosed {
+		return err
+	}
+	return nil
+}
+
+// checkpointTaskDelay returns the delay until the checkpoint task should run
+// again, which is when the next HW checkpoint or flush is due. If no flush is
+// pending, messages appended later are flushed within FlushInterval.
+func (l *commitLog) checkpointTaskDelay(now time.Time) time.Duration {
+	delay := l.nextCheckpoint.Sub(now)
+	if l.flushEnabled() && l.FlushInterval > 0 {
+		next := time.Unix(0, atomic.LoadInt64(&l.lastFlush)).Add(l.FlushInterval).Sub(now)
+		if next <= 0 {
+			next = l.FlushInterval
+		}
+		if next < delay {
+			delay = next
+		}
+	}
+	// A delay of zero would stop the task.
+	if delay < time.Millisecond {
+		delay = time.Millisecond
+	}
+	return delay
+}
@@ -0,0 +1,193 @@
+package commitlog
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	atomic_file "github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Segment log and index files start with a header identifying the format
+// they're written in so that the format can evolve without stranding existing
+// data. Segments of every format up to the latest one can be read, so logs
+// can mix formats, and MigrateLog upgrades the segments of a log written in a
+// format older than the current one. The header has the following layout:
+//
+// magic (4 bytes) version (2 bytes) reserved (log: 2 bytes, index: 14 bytes)
+//
+// Index headers are padded to the width of an index entry to keep entries
+// aligned.
+const (
+	// FormatV0 is the format of segments written before the format was
+	// versioned. Their files have no header.
+	FormatV0 = 0
+
+	// FormatV1 adds a header with the format version to log and index
+	// files.
+	FormatV1 = 1
+
+	// FormatV2 is the format of compressed logs, which is FormatV1 with
+	// the messages split into compressed blocks. It's only used for the
+	// logs of sealed segments, so it's not the current format.
+	FormatV2 = 2
+
+	// FormatV3 is the format of encrypted logs, which is FormatV1 with the
+	// ID of the encryption key following the header and the messages
+	// written in encrypted records. It's only used if the commit log has a
+	// KeyProvider.
+	FormatV3 = 3
+
+	// FormatV4 is the format of logs written in MessageFormatV2, which is
+	// FormatV1 with the messages of each write encoded in a batch with a
+	// single CRC. It's only used if the commit log's MessageFormat is
+	// MessageFormatV2.
+	FormatV4 = 4
+
+	// CurrentFormat is the format new segments are written in.
+	CurrentFormat = FormatV1
+
+	// latestFormat is the newest format which can be read.
+	latestFormat = FormatV4
+
+	logHeaderLen   = 8
+	indexHeaderLen = entryWidth
+	magicLen       = 4
+	versionLen     = 2
+)
+
+var (
+	logMagic   = []byte("LBLG")
+	indexMagic = []byte("LBIX")
+
+	// ErrUnsupportedFormat is returned when opening a segment written in a
+	// newer format than this version supports.
+	ErrUnsupportedFormat = errors.New("unsupported segment format")
+)
+
+// newFileHeader returns the header of a log or index file written in the
+// current format.
+func newFileHeader(magic []byte, length int) []byte {
+	header := make([]byte, length)
+	copy(header, magic)
+	proto.Encoding.PutUint16(header[magicLen:], CurrentFormat)
+	return header
+}
+
+// parseFileHeader returns the format of the file starting with the given
+// bytes and the length of its header. Files which don't start with the magic
+// bytes were written before the format was versioned and have no header. This
+// can't be mistaken for a legacy log since its first message's offset would
+// have to start with the magic bytes, nor for a legacy index since its first
+// entry would have to be empty.
+func parseFileHeader(magic, b []byte, length int) (int, int64, error) {
+	if len(b) < length || !bytes.Equal(b[:magicLen], magic) ||
+		!isZero(b[magicLen+versionLen:length]) {
+		return FormatV0, 0, nil
+	}
+	format := int(proto.Encoding.Uint16(b[magicLen:]))
+	if format == FormatV0 || format > latestFormat {
+		return 0, 0, errors.Wrapf(ErrUnsupportedFormat, "format version %d", format)
+	}
+	return format, int64(length), nil
+}
+
+// readFileHeader returns the format of the given file of the given size and
+// the length of its header.
+func readFileHeader(file io.ReaderAt, size int64, magic []byte, length int) (int, int64, error) {
+	if size < int64(length) {
+		return FormatV0, 0, nil
+	}
+	b := make([]byte, length)
+	if _, err := file.ReadAt(b, 0); err != nil {
+		return 0, 0, errors.Wrap(err, "read header failed")
+	}
+	return parseFileHeader(magic, b, length)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// headerReaderAt reads a file past its header.
+type headerReaderAt struct {
+	io.ReaderAt
+	headerLen int64
+}
+
+func (r headerReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.ReaderAt.ReadAt(p, off+r.headerLen)
+}
+
+// MigrateLog upgrades the segments of the commit log in the given directory
+// which are written in an older format to the current format and returns the
+// number of segments it upgraded. The log must not be open, e.g. by a running
+// server. Each file is rewritten to a temporary file which then replaces it,
+// so an interrupted migration can be run again.
+func MigrateLog(path string) (int, error) {
+	names, err := ioutil.ReadDir(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "read dir failed")
+	}
+	migrated := 0
+	for _, info := range names {
+		name := info.Name()
+		if !strings.HasSuffix(name, logSuffix) {
+			continue
+		}
+		var (
+			logPath   = filepath.Join(path, name)
+			indexPath = strings.TrimSuffix(logPath, logSuffix) + indexSuffix
+		)
+		upgradedIndex, err := migrateFile(indexPath, indexMagic, indexHeaderLen)
+		if err != nil {
+			return migrated, err
+		}
+		upgradedLog, err := migrateFile(logPath, logMagic, logHeaderLen)
+		if err != nil {
+			return migrated, err
+		}
+		if upgradedLog || upgradedIndex {
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+// migrateFile prepends a header in the current format to the given segment
+// file if it's written in an older format. It returns false if the file
+// doesn't exist or is already in the current format or a newer one.
+func migrateFile(path string, magic []byte, headerLen int) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "read file failed")
+	}
+	format, _, err := parseFileHeader(magic, data, headerLen)
+	if err != nil {
+		return false, errors.Wrap(err, path)
+	}
+	if format >= CurrentFormat {
+		return false, nil
+	}
+	b := bytes.NewBuffer(make([]byte, 0, headerLen+len(data)))
+	b.Write(newFileHeader(magic, headerLen))
+	b.Write(data)
+	if err := atomic_file.WriteFile(path, b); err != nil {
+		return false, errors.Wrap(err, "write file failed")
+	}
+	return true, nil
+}
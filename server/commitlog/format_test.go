@@ -0,0 +1,146 @@
+package commitlog
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure new segment log and index files start with a header in the current
+// format.
+func TestSegmentFormatHeader(t *testing.T) {
+	dir := tempDir(t)
+	defer remove(t, dir)
+
+	s := createSegment(t, dir, 0, 100)
+	require.Equal(t, CurrentFormat, s.format)
+	require.Equal(t, int64(logHeaderLen), s.headerLen)
+	require.Equal(t, CurrentFormat, s.Index.format)
+	require.Equal(t, int64(0), s.Position())
+	require.NoError(t, s.Close())
+
+	for _, file := range []string{s.logPath(), s.indexPath()} {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		magic := logMagic
+		if strings.HasSuffix(file, indexSuffix) {
+			magic = indexMagic
+		}
+		require.Equal(t, magic, data[:magicLen])
+		require.Equal(t, uint16(CurrentFormat), proto.Encoding.Uint16(data[magicLen:]))
+	}
+}
+
+// Ensure logs with segments written before the format was versioned can be
+// read and appended to, and that MigrateLog upgrades them to the current
+// format.
+func TestMigrateLog(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	for _, msg := range msgs {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+	require.True(t, len(l.Segments()) > 1)
+
+	// Strip the headers to downgrade the segments to the legacy format.
+	files, err := ioutil.ReadDir(opts.Path)
+	require.NoError(t, err)
+	for _, file := range files {
+		headerLen := logHeaderLen
+		if strings.HasSuffix(file.Name(), indexSuffix) {
+			headerLen = indexHeaderLen
+		} else if !strings.HasSuffix(file.Name(), logSuffix) {
+			continue
+		}
+		path := filepath.Join(opts.Path, file.Name())
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(path, data[headerLen:], 0666))
+	}
+
+	// Legacy segments are read and appended to in their format.
+	l, _ = setupWithOptions(t, opts)
+	for _, segment := range l.Segments() {
+		require.NoError(t, segment.load())
+		require.Equal(t, FormatV0, segment.format)
+		require.Equal(t, FormatV0, segment.Index.format)
+	}
+	requireLogMessages(t, l, msgs)
+	_, err = l.Append(msgs[:1])
+	require.NoError(t, err)
+	expected := append(append([]*Message{}, msgs...), msgs[0])
+	requireLogMessages(t, l, expected)
+	require.NoError(t, l.Close())
+
+	migrated, err := MigrateLog(opts.Path)
+	require.NoError(t, err)
+	require.Equal(t, len(l.Segments()), migrated)
+
+	// Migrating again does nothing.
+	migrated, err = MigrateLog(opts.Path)
+	require.NoError(t, err)
+	require.Equal(t, 0, migrated)
+
+	l, _ = setupWithOptions(t, opts)
+	defer l.Close()
+	for _, segment := range l.Segments() {
+		require.NoError(t, segment.load())
+		require.Equal(t, CurrentFormat, segment.format)
+		require.Equal(t, CurrentFormat, segment.Index.format)
+	}
+	requireLogMessages(t, l, expected)
+}
+
+// Ensure segments written in a newer format than the latest one are not
+// opened.
+func TestSegmentUnsupportedFormat(t *testing.T) {
+	dir := tempDir(t)
+	defer remove(t, dir)
+
+	s := createSegment(t, dir, 0, 100)
+	require.NoError(t, s.Close())
+	f, err := os.OpenFile(s.logPath(), os.O_WRONLY, 0666)
+	require.NoError(t, err)
+	version := make([]byte, versionLen)
+	proto.Encoding.PutUint16(version, latestFormat+1)
+	_, err = f.WriteAt(version, magicLen)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = newSegment(dir, 0, 100, false, "", false, 0, indexAccess{}, nil, nil, "")
+	require.Equal(t, ErrUnsupportedFormat, errors.Cause(err))
+
+	_, err = MigrateLog(dir)
+	require.Equal(t, ErrUnsupportedFormat, errors.Cause(err))
+}
+
+// requireLogMessages reads the log from the beginning and checks it contains
+// the expected messages.
+func requireLogMessages(t *testing.T, l *commitLog, expected []*Message) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		compareMessages(t, exp, msg)
+	}
+	require.Equal(t, int64(len(expected)-1), l.NewestOffset())
+}
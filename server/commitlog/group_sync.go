@@ -0,0 +1,82 @@
+package commitlog
+
+import (
+	"sync"
+	"time"
+)
+
+// groupSyncer coalesces fsyncs requested by concurrent appends to a segment
+// into a single fsync, i.e. group commit. The first caller to request a sync
+// waits up to maxDelay for other appends to join before syncing on behalf of
+// all of them. Callers which arrive while a sync is in progress wait for it to
+// finish and then sync again if it started before they requested it.
+type groupSyncer struct {
+	sync      func() error
+	maxDelay  time.Duration
+	mu        sync.Mutex
+	cond      *sync.Cond
+	requested uint64
+	synced    uint64
+	syncing   bool
+	pending   bool // A background sync was started but hasn't requested yet
+}
+
+func newGroupSyncer(syncFn func() error, maxDelay time.Duration) *groupSyncer {
+	g := &groupSyncer{sync: syncFn, maxDelay: maxDelay}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// Sync blocks until everything written before it was called has been synced.
+func (g *groupSyncer) Sync() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pending = false
+	g.requested++
+	seq := g.requested
+	for g.synced < seq {
+		if g.syncing {
+			g.cond.Wait()
+			continue
+		}
+		g.syncing = true
+		g.mu.Unlock()
+		if g.maxDelay > 0 {
+			time.Sleep(g.maxDelay)
+		}
+		g.mu.Lock()
+		// Every request made up to now is covered by this sync.
+		target := g.requested
+		g.mu.Unlock()
+		err := g.sync()
+		g.mu.Lock()
+		g.syncing = false
+		if err == nil && target > g.synced {
+			g.synced = target
+		}
+		g.cond.Broadcast()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncAsync syncs everything written before it was called in the background,
+// calling onErr if the sync fails. Calls made before a background sync
+// requests its sync are covered by it, so a burst of calls starts a single
+// goroutine.
+func (g *groupSyncer) SyncAsync(onErr func(error)) {
+	g.mu.Lock()
+	if g.pending {
+		g.mu.Unlock()
+		return
+	}
+	g.pending = true
+	g.mu.Unlock()
+	go func() {
+		if err := g.Sync(); err != nil {
+			onErr(err)
+		}
+	}()
+}
@@ -0,0 +1,77 @@
+package commitlog
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure concurrent syncs are coalesced into fewer fsyncs.
+func TestGroupSyncerCoalesces(t *testing.T) {
+	var syncs int32
+	g := newGroupSyncer(func() error {
+		atomic.AddInt32(&syncs, 1)
+		return nil
+	}, 50*time.Millisecond)
+
+	var (
+		n     = 10
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+	)
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			<-start
+			require.NoError(t, g.Sync())
+		}()
+	}
+	close(start)
+	wg.Wait()
+	require.Less(t, int(atomic.LoadInt32(&syncs)), n)
+
+	// A sync requested after the others completed must fsync again.
+	before := atomic.LoadInt32(&syncs)
+	require.NoError(t, g.Sync())
+	require.Equal(t, before+1, atomic.LoadInt32(&syncs))
+}
+
+// Ensure background syncs requested before one starts share a single fsync,
+// and errors are reported.
+func TestGroupSyncerAsync(t *testing.T) {
+	var (
+		syncs int32
+		fail  int32
+		errs  = make(chan error, 10)
+	)
+	g := newGroupSyncer(func() error {
+		atomic.AddInt32(&syncs, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			return errors.New("sync failed")
+		}
+		return nil
+	}, 50*time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		g.SyncAsync(func(err error) { errs <- err })
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&syncs) == 1 },
+		5*time.Second, time.Millisecond)
+
+	// Once it has synced, a new call syncs again.
+	atomic.StoreInt32(&fail, 1)
+	g.SyncAsync(func(err error) { errs <- err })
+	select {
+	case err := <-errs:
+		require.EqualError(t, err, "sync failed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected sync error")
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&syncs))
+	require.Len(t, errs, 0)
+}
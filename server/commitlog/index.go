@@ -16,6 +16,31 @@ import (
 
 var errIndexCorrupt = errors.New("corrupt index file")
 
+// IndexAdvice is the hint given to the kernel about how memory-mapped index
+// files will be accessed.
+type IndexAdvice string
+
+const (
+	// IndexAdviceNormal uses the kernel's default read-ahead.
+	IndexAdviceNormal IndexAdvice = "normal"
+	// IndexAdviceWillNeed asks the kernel to read the index into memory
+	// ahead of lookups.
+	IndexAdviceWillNeed IndexAdvice = "willneed"
+	// IndexAdviceSequential asks the kernel to read ahead aggressively.
+	IndexAdviceSequential IndexAdvice = "sequential"
+	// IndexAdviceRandom asks the kernel not to read ahead.
+	IndexAdviceRandom IndexAdvice = "random"
+)
+
+// ParseIndexAdvice returns the IndexAdvice with the given name.
+func ParseIndexAdvice(name string) (IndexAdvice, error) {
+	switch advice := IndexAdvice(name); advice {
+	case IndexAdviceNormal, IndexAdviceWillNeed, IndexAdviceSequential, IndexAdviceRandom:
+		return advice, nil
+	}
+	return "", errors.Errorf("unknown index advice %q", name)
+}
+
 const (
 	offsetWidth    = 4
 	timestampWidth = 8
@@ -26,12 +51,20 @@ const (
 
 type index struct {
 	options
-	mmap     gommap.MMap
-	file     *os.File
-	size     int64
-	mu       sync.RWMutex
-	position int64
-	closed   bool
+	mmap gommap.MMap // Nil if the index uses positional I/O
+	file *os.File
+	// format is the format version of the index and headerLen the length of
+	// its header. Sizes and positions exclude the header.
+	format    int
+	headerLen int64
+	size      int64
+	mu        sync.RWMutex
+	position  int64
+	closed    bool
+	// lockStart and lockEnd are the bounds of the range of the mapping
+	// locked in memory.
+	lockStart int64
+	lockEnd   int64
 }
 
 type entry struct {
@@ -70,11 +103,40 @@ type options struct {
 	path       string
 	bytes      int64
 	baseOffset int64
+	indexAccess
+}
+
+// indexAccess contains the settings tuning how an index is allocated and
+// accessed.
+type indexAccess struct {
+	// advice is given to the kernel for the mapping. If it's empty, the
+	// kernel's default is used.
+	advice IndexAdvice
+	// lockBytes is the size of the range of the index before its write
+	// position which is locked in memory. If it's 0, nothing is locked.
+	lockBytes int64
+	// positional reads and writes the index with positional I/O instead of
+	// memory-mapping it, which costs a syscall per entry read but keeps the
+	// index out of the process's address space. advice and lockBytes only
+	// apply to memory-mapped indexes.
+	positional bool
+	// preallocateBytes is the size new indexes are preallocated to and
+	// expanded by when they're full. If it's 0, defaultIndexBytes is used.
+	preallocateBytes int64
 }
 
+// defaultIndexBytes is the default size indexes are preallocated to.
+const defaultIndexBytes = 10 * 1024 * 1024
+
 func newIndex(opts options) (idx *index, err error) {
 	if opts.bytes == 0 {
-		opts.bytes = 10 * 1024 * 1024
+		opts.bytes = opts.preallocateBytes
+	}
+	if opts.bytes == 0 {
+		opts.bytes = defaultIndexBytes
+	}
+	if opts.positional {
+		opts.lockBytes = 0
 	}
 	if opts.path == "" {
 		return nil, errors.New("path is empty")
@@ -90,9 +152,10 @@ func newIndex(opts options) (idx *index, err error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "stat file failed")
 	}
-	// Pre-allocate the index if we just created it.
-	if fi.Size() == 0 {
-		if err := idx.file.Truncate(roundDown(opts.bytes, entryWidth)); err != nil {
+	// Pre-allocate the index and write its header if we just created it.
+	isNew := fi.Size() == 0
+	if isNew {
+		if err := idx.file.Truncate(indexHeaderLen + roundDown(opts.bytes, entryWidth)); err != nil {
 			return nil, err
 		}
 	}
@@ -101,14 +164,104 @@ func newIndex(opts options) (idx *index, err error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "stat file failed")
 	}
-	idx.position = fi.Size()
-	idx.size = fi.Size()
 
+	if idx.format, idx.headerLen, err = idx.open(isNew, fi.Size()); err != nil {
+		if idx.mmap != nil {
+			idx.mmap.UnsafeUnmap() // nolint: errcheck
+		}
+		idx.file.Close() // nolint: errcheck
+		return nil, err
+	}
+	idx.position = fi.Size() - idx.headerLen
+	idx.size = idx.position
+	if err := idx.advise(); err != nil {
+		return nil, errors.Wrap(err, "madvise failed")
+	}
+	return idx, nil
+}
+
+// open maps the index file of the given size into memory, unless the index
+// uses positional I/O, writes its header if it's new, and returns its format
+// and the length of its header.
+func (idx *index) open(isNew bool, size int64) (int, int64, error) {
+	if idx.positional {
+		header := newFileHeader(indexMagic, indexHeaderLen)
+		if isNew {
+			if _, err := idx.file.WriteAt(header, 0); err != nil {
+				return 0, 0, errors.Wrap(err, "write header failed")
+			}
+		}
+		return readFileHeader(idx.file, size, indexMagic, indexHeaderLen)
+	}
+	var err error
 	idx.mmap, err = gommap.Map(idx.file.Fd(), gommap.PROT_READ|gommap.PROT_WRITE, gommap.MAP_SHARED)
 	if err != nil {
-		return nil, errors.Wrap(err, "mmap file failed")
+		return 0, 0, errors.Wrap(err, "mmap file failed")
 	}
-	return idx, nil
+	if isNew {
+		copy(idx.mmap, newFileHeader(indexMagic, indexHeaderLen))
+	}
+	return parseFileHeader(indexMagic, idx.mmap, indexHeaderLen)
+}
+
+// advise gives the kernel the configured hint for the mapping.
+func (idx *index) advise() error {
+	if idx.mmap == nil || idx.advice == "" || idx.advice == IndexAdviceNormal {
+		return nil
+	}
+	return madvise(idx.mmap, idx.advice)
+}
+
+// lockTail locks the pages of the mapping from lockBytes before the position
+// through the page written next in memory, so lookups of recent offsets don't
+// fault on cold pages. As the position advances, pages leaving the range are
+// unlocked and pages entering it are locked. Locking is best effort, so if it
+// fails, nothing remains locked and locking is disabled for the index. The
+// caller must hold the write lock.
+func (idx *index) lockTail() {
+	if idx.lockBytes <= 0 {
+		return
+	}
+	// The range is in terms of the mapping, which includes the header, so
+	// that it's aligned to pages.
+	var (
+		page     = int64(os.Getpagesize())
+		position = idx.headerLen + idx.position
+		start    = position - idx.lockBytes
+	)
+	if start < 0 {
+		start = 0
+	}
+	start = roundDown(start, page)
+	end := min(roundDown(position, page)+page, idx.headerLen+idx.size)
+	if start == idx.lockStart && end == idx.lockEnd {
+		return
+	}
+	var err error
+	if idx.lockStart < idx.lockEnd && idx.lockStart <= start && start <= idx.lockEnd &&
+		idx.lockEnd <= end {
+		// The range moved forward, so only lock and unlock the difference.
+		if err = mlock(idx.mmap[idx.lockEnd:end]); err == nil {
+			err = munlock(idx.mmap[idx.lockStart:start])
+		}
+	} else {
+		idx.unlockTail()
+		err = mlock(idx.mmap[start:end])
+	}
+	idx.lockStart, idx.lockEnd = start, end
+	if err != nil {
+		idx.unlockTail()
+		idx.lockBytes = 0
+	}
+}
+
+// unlockTail unlocks the pages locked by lockTail. The caller must hold the
+// write lock.
+func (idx *index) unlockTail() {
+	if idx.lockStart < idx.lockEnd {
+		munlock(idx.mmap[idx.lockStart:idx.lockEnd]) // nolint: errcheck
+	}
+	idx.lockStart, idx.lockEnd = 0, 0
 }
 
 // Position returns the current position in the index to write to next. This
@@ -142,6 +295,7 @@ func (idx *index) writeEntries(entries []*entry) (err error) {
 		return errors.Wrap(err, "index write failed")
 	}
 	idx.position += entryWidth * int64(len(entries))
+	idx.lockTail()
 	return nil
 }
 
@@ -149,15 +303,17 @@ func (idx *index) writeEntries(entries []*entry) (err error) {
 // byte offset of the index file. ReadEntryAtLogOffset is generally
 // more useful for higher level use.
 func (idx *index) ReadEntryAtFileOffset(e *entry, fileOffset int64) (err error) {
-	p := make([]byte, entryWidth)
-	if _, err = idx.ReadAt(p, fileOffset); err != nil {
+	// Decode the entry by hand rather than with binary.Read since this is on
+	// the read path and binary.Read allocates.
+	var p [entryWidth]byte
+	if _, err = idx.ReadAt(p[:], fileOffset); err != nil {
 		return err
 	}
-	b := bytes.NewReader(p)
-	rel := &relEntry{}
-	err = binary.Read(b, proto.Encoding, rel)
-	if err != nil {
-		return errors.Wrap(err, "binary read failed")
+	rel := relEntry{
+		Offset:    int32(proto.Encoding.Uint32(p[0:])),
+		Timestamp: int64(proto.Encoding.Uint64(p[offsetWidth:])),
+		Position:  int32(proto.Encoding.Uint32(p[offsetWidth+timestampWidth:])),
+		Size:      int32(proto.Encoding.Uint32(p[offsetWidth+timestampWidth+positionWidth:])),
 	}
 	idx.mu.RLock()
 	rel.fill(e, idx.baseOffset)
@@ -180,6 +336,10 @@ func (idx *index) ReadAt(p []byte, offset int64) (n int, err error) {
 	if idx.position < offset+entryWidth {
 		return 0, io.EOF
 	}
+	offset += idx.headerLen
+	if idx.mmap == nil {
+		return idx.file.ReadAt(p[:entryWidth], offset)
+	}
 	n = copy(p, idx.mmap[offset:offset+entryWidth])
 	return n, nil
 }
@@ -192,11 +352,14 @@ func (idx *index) writeAt(p []byte, offset int64) error {
 		if newSize < offset+pSize {
 			newSize = idx.size + pSize
 		}
-		err := idx.file.Truncate(newSize)
+		err := idx.file.Truncate(idx.headerLen + newSize)
 		if err != nil {
 			panic(errors.Wrap(err, "failed to expand index file"))
 		}
 		idx.size = newSize
+		if idx.mmap == nil {
+			return idx.put(p, offset)
+		}
 
 		// Re-mmap the index.
 		oldMmap := idx.mmap
@@ -204,13 +367,27 @@ func (idx *index) writeAt(p []byte, offset int64) error {
 		if err != nil {
 			panic(errors.Wrap(err, "failed to mmap expanded index file"))
 		}
-		// Unmap the old index.
+		// Unmap the old index, which also unlocks its locked pages.
+		idx.lockStart, idx.lockEnd = 0, 0
 		if err := oldMmap.UnsafeUnmap(); err != nil {
 			return errors.Wrap(err, "failed to unmap memory mapped index file")
 		}
+		if err := idx.advise(); err != nil {
+			return errors.Wrap(err, "madvise failed")
+		}
 	}
 
-	copy(idx.mmap[offset:], p)
+	return idx.put(p, offset)
+}
+
+// put writes p to the index at the given offset, which must be within its
+// size.
+func (idx *index) put(p []byte, offset int64) error {
+	if idx.mmap == nil {
+		_, err := idx.file.WriteAt(p, idx.headerLen+offset)
+		return err
+	}
+	copy(idx.mmap[idx.headerLen+offset:], p)
 	return nil
 }
 
@@ -227,6 +404,9 @@ func (idx *index) sync() error {
 	if err := idx.file.Sync(); err != nil {
 		return errors.Wrap(err, "file sync failed")
 	}
+	if idx.mmap == nil {
+		return nil
+	}
 	if err := idx.mmap.Sync(gommap.MS_SYNC); err != nil {
 		return errors.Wrap(err, "mmap sync failed")
 	}
@@ -248,28 +428,75 @@ func (idx *index) Close() error {
 	if err := idx.file.Close(); err != nil {
 		return err
 	}
-	if err := idx.mmap.UnsafeUnmap(); err != nil {
-		return err
+	if idx.mmap != nil {
+		if err := idx.mmap.UnsafeUnmap(); err != nil {
+			return err
+		}
 	}
 	idx.closed = true
 	return nil
 }
 
-// Shrink truncates the memory-mapped index file to the size of its contents.
+// Shrink truncates the memory-mapped index file to the size of its contents
+// and unlocks any pages locked in memory since the index is no longer written.
 func (idx *index) Shrink() error {
-	idx.mu.RLock()
-	defer idx.mu.RUnlock()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
 	return idx.shrink()
 }
 
 func (idx *index) shrink() error {
-	return idx.file.Truncate(idx.position)
+	if !idx.closed {
+		idx.unlockTail()
+	}
+	return idx.file.Truncate(idx.headerLen + idx.position)
 }
 
 func (idx *index) Name() string {
 	return idx.file.Name()
 }
 
+// SetPosition initializes the position in the index to write to next when it
+// is already known, e.g. from a clean shutdown, instead of searching the index
+// for it.
+func (idx *index) SetPosition(position int64) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if position < 0 || position > idx.size || position%entryWidth != 0 {
+		return errIndexCorrupt
+	}
+	idx.position = position
+	return nil
+}
+
+// removeLastEntry removes the last entry from the index, e.g. when the
+// message it points to is found to be corrupt during recovery, and returns
+// the entry before it, or nil if the index is now empty.
+func (idx *index) removeLastEntry() (*entry, error) {
+	idx.mu.Lock()
+	if idx.position < entryWidth {
+		idx.mu.Unlock()
+		return nil, nil
+	}
+	idx.position -= entryWidth
+	// Zero the entry so that it's not found when the position is initialized
+	// again.
+	err := idx.put(make([]byte, entryWidth), idx.position)
+	position := idx.position
+	idx.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if position == 0 {
+		return nil, nil
+	}
+	e := new(entry)
+	if err := idx.ReadEntryAtFileOffset(e, position-entryWidth); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
 func (idx *index) InitializePosition() (*entry, error) {
 	// Find the first empty entry.
 	n := int(idx.size / entryWidth)
@@ -306,25 +533,3 @@ func (idx *index) InitializePosition() (*entry, error) {
 	}
 	return entry, nil
 }
-
-type indexScanner struct {
-	idx    *index
-	entry  *entry
-	offset int64
-}
-
-func newIndexScanner(idx *index) *indexScanner {
-	return &indexScanner{idx: idx, entry: &entry{}}
-}
-
-func (s *indexScanner) Scan() (*entry, error) {
-	err := s.idx.ReadEntryAtLogOffset(s.entry, s.offset)
-	if err != nil {
-		return nil, err
-	}
-	if s.entry.Offset == 0 && s.offset != 0 {
-		return nil, io.EOF
-	}
-	s.offset++
-	return s.entry, err
-}
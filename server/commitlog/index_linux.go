@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+package commitlog
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// madvise gives the kernel a hint of how the memory-mapped index will be
+// accessed.
+func madvise(b []byte, advice IndexAdvice) error {
+	var flag int
+	switch advice {
+	case IndexAdviceNormal:
+		flag = unix.MADV_NORMAL
+	case IndexAdviceWillNeed:
+		flag = unix.MADV_WILLNEED
+	case IndexAdviceSequential:
+		flag = unix.MADV_SEQUENTIAL
+	case IndexAdviceRandom:
+		flag = unix.MADV_RANDOM
+	default:
+		return fmt.Errorf("unknown index advice %q", advice)
+	}
+	return unix.Madvise(b, flag)
+}
+
+// mlock locks the memory-mapped pages in memory.
+func mlock(b []byte) error {
+	return unix.Mlock(b)
+}
+
+// munlock unlocks memory-mapped pages locked with mlock.
+func munlock(b []byte) error {
+	return unix.Munlock(b)
+}
+
+// indexLockSupported returns an error if the process cannot lock the given
+// number of bytes of an index in memory.
+func indexLockSupported(bytes int64) error {
+	var limit unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_MEMLOCK, &limit); err != nil {
+		return err
+	}
+	if limit.Cur != unix.RLIM_INFINITY && uint64(bytes) > limit.Cur {
+		return fmt.Errorf("RLIMIT_MEMLOCK of %d bytes is below %d bytes", limit.Cur, bytes)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package commitlog
+
+import "github.com/pkg/errors"
+
+var errIndexLockNotSupported = errors.New("locking index pages is only supported on Linux")
+
+// madvise does nothing since memory advice is only applied on Linux.
+func madvise(b []byte, advice IndexAdvice) error {
+	return nil
+}
+
+// mlock returns an error since locking index pages is only supported on
+// Linux.
+func mlock(b []byte) error {
+	return errIndexLockNotSupported
+}
+
+// munlock does nothing since pages are never locked outside of Linux.
+func munlock(b []byte) error {
+	return nil
+}
+
+// indexLockSupported returns an error since locking index pages is only
+// supported on Linux.
+func indexLockSupported(bytes int64) error {
+	return errIndexLockNotSupported
+}
@@ -22,10 +22,11 @@ func TestIndexInitialSize(t *testing.T) {
 	require.Equal(t, int64(10*1024*1024), idx.size)
 	require.Equal(t, int64(0), idx.position)
 
-	// Verify the recorded size matches the file size.
+	// Verify the recorded size matches the file size past the header.
 	finfo, err := idx.file.Stat()
 	require.NoError(t, err)
-	require.Equal(t, idx.size, finfo.Size())
+	require.Equal(t, int64(indexHeaderLen), idx.headerLen)
+	require.Equal(t, idx.headerLen+idx.size, finfo.Size())
 }
 
 func TestIndexExistingSize(t *testing.T) {
@@ -78,3 +79,112 @@ func TestIndexExpansion(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, writeEntry, readEntry)
 }
+
+// Ensure indexes using positional I/O are preallocated, expanded, and read
+// like memory-mapped ones and can be reopened memory-mapped.
+func TestIndexPositional(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	access := indexAccess{positional: true, preallocateBytes: 2 * entryWidth}
+	idx, err := newIndex(options{path: dir + "test.idx", indexAccess: access})
+	require.NoError(t, err)
+	require.Nil(t, idx.mmap)
+	require.Equal(t, int64(2*entryWidth), idx.size)
+	e, err := idx.InitializePosition()
+	require.NoError(t, err)
+	require.Nil(t, e)
+
+	// Write past the preallocated size so the index is expanded.
+	entries := make([]*entry, 5)
+	for i := range entries {
+		entries[i] = &entry{Offset: int64(i), Timestamp: int64(i), Position: int64(i * 10), Size: 10}
+	}
+	require.NoError(t, idx.writeEntries(entries))
+	require.GreaterOrEqual(t, idx.size, int64(5*entryWidth))
+	var readEntry entry
+	for i, e := range entries {
+		require.NoError(t, idx.ReadEntryAtLogOffset(&readEntry, int64(i)))
+		require.Equal(t, *e, readEntry)
+	}
+	last, err := idx.removeLastEntry()
+	require.NoError(t, err)
+	require.Equal(t, *entries[3], *last)
+	require.NoError(t, idx.Close())
+
+	// Reopen the index memory-mapped.
+	idx, err = newIndex(options{path: dir + "test.idx"})
+	require.NoError(t, err)
+	defer idx.Close()
+	require.NotNil(t, idx.mmap)
+	last, err = idx.InitializePosition()
+	require.NoError(t, err)
+	require.Equal(t, *entries[3], *last)
+	require.Equal(t, int64(4*entryWidth), idx.Position())
+}
+
+// Ensure the locked range of the index follows its write position through
+// expansions and is released when the index is shrunk.
+func TestIndexLockTail(t *testing.T) {
+	page := int64(os.Getpagesize())
+	if err := indexLockSupported(2 * page); err != nil {
+		t.Skipf("Index pages cannot be locked: %v", err)
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	idx, err := newIndex(options{
+		path:  dir + "test.idx",
+		bytes: 2 * page,
+		indexAccess: indexAccess{
+			advice:    IndexAdviceWillNeed,
+			lockBytes: 2 * page,
+		},
+	})
+	require.NoError(t, err)
+	defer idx.Close()
+	_, err = idx.InitializePosition()
+	require.NoError(t, err)
+
+	// The first write locks the page being written.
+	require.NoError(t, idx.writeEntries([]*entry{{}}))
+	require.Equal(t, int64(0), idx.lockStart)
+	require.Equal(t, page, idx.lockEnd)
+
+	// Write past the initial size so the index is expanded and remapped.
+	entries := make([]*entry, 5*page/entryWidth)
+	for i := range entries {
+		entries[i] = &entry{Offset: int64(i + 1)}
+	}
+	// The locked range is in terms of the mapping, which includes the
+	// header.
+	require.NoError(t, idx.writeEntries(entries))
+	position := idx.headerLen + idx.Position()
+	require.Equal(t, roundDown(position-2*page, page), idx.lockStart)
+	require.Equal(t, roundDown(position, page)+page, idx.lockEnd)
+
+	// The range moves forward with more writes.
+	entries = make([]*entry, page/entryWidth)
+	for i := range entries {
+		entries[i] = &entry{}
+	}
+	require.NoError(t, idx.writeEntries(entries))
+	position = idx.headerLen + idx.Position()
+	require.Equal(t, roundDown(position-2*page, page), idx.lockStart)
+	require.Equal(t, min(roundDown(position, page)+page, idx.headerLen+idx.size), idx.lockEnd)
+	require.Equal(t, 2*page, idx.lockBytes)
+
+	// Shrinking unlocks the range.
+	require.NoError(t, idx.Shrink())
+	require.Equal(t, int64(0), idx.lockStart)
+	require.Equal(t, int64(0), idx.lockEnd)
+}
+
+// Ensure unknown index advice is rejected.
+func TestParseIndexAdvice(t *testing.T) {
+	advice, err := ParseIndexAdvice("willneed")
+	require.NoError(t, err)
+	require.Equal(t, IndexAdviceWillNeed, advice)
+	_, err = ParseIndexAdvice("bogus")
+	require.Error(t, err)
+}
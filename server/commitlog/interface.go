@@ -1,5 +1,10 @@
 package commitlog
 
+import (
+	"context"
+	"time"
+)
+
 // CommitLog is the durable write-ahead log interface used to back each stream.
 type CommitLog interface {
 	// Delete closes the log and removes all data associated with it from the
@@ -14,6 +19,18 @@ type CommitLog interface {
 	// Truncate removes all messages from the log starting at the given offset.
 	Truncate(offset int64) error
 
+	// VerifyLog scans the log's segments and indexes for truncated or corrupt
+	// messages, offsets which are out of order or, unless the log is
+	// compacted, have gaps, and index entries which don't match the log. It
+	// doesn't change the log and can be called while it's in use.
+	VerifyLog(ctx context.Context) (*LogReport, error)
+
+	// RepairLog verifies the log like VerifyLog, rebuilds the indexes which
+	// don't match the log and truncates the log before the first message
+	// which is truncated, corrupt or out of order. Appends are blocked while
+	// the log is repaired.
+	RepairLog(ctx context.Context) (*LogReport, error)
+
 	// NewestOffset returns the offset of the last message in the log or -1 if
 	// empty.
 	NewestOffset() int64
@@ -22,6 +39,17 @@ type CommitLog interface {
 	// empty.
 	OldestOffset() int64
 
+	// LogStartOffset returns the offset the log starts at. Offsets before it
+	// were deleted along with the segments containing them. Offsets at or
+	// after it which are not in the log were removed by compaction. If tiered
+	// storage is enabled, offsets offloaded to it are part of the log.
+	LogStartOffset() int64
+
+	// EarliestOffsetAfter returns the earliest offset in the log which is
+	// greater than or equal to the given offset or the next assignable offset
+	// if there is none.
+	EarliestOffsetAfter(offset int64) (int64, error)
+
 	// EarliestOffsetAfterTimestamp returns the earliest offset whose timestamp
 	// is greater than or equal to the given timestamp.
 	EarliestOffsetAfterTimestamp(timestamp int64) (int64, error)
@@ -65,15 +93,16 @@ type CommitLog interface {
 	AppendMessageSet(ms []byte) ([]int64, error)
 
 	// Clean applies retention and compaction rules against the log, if
-	// applicable.
-	Clean() error
+	// applicable. It stops as soon as possible when the context is canceled
+	// or the log is closed and returns the context's error. An interrupted
+	// compaction resumes where it stopped on the next clean.
+	Clean(ctx context.Context) error
 
 	// NotifyLEO registers and returns a channel which is closed when messages
 	// past the given log end offset are added to the log. If the given offset
 	// is no longer the log end offset, the channel is closed immediately.
-	// Waiter is an opaque value that uniquely identifies the entity waiting
-	// for data.
-	NotifyLEO(waiter interface{}, leo int64) <-chan struct{}
+	// The channel is shared by all callers waiting on the same offset.
+	NotifyLEO(leo int64) <-chan struct{}
 
 	// SetReadonly marks the log as readonly. When in readonly mode, new
 	// messages cannot be added to the log with Append and committed readers
@@ -89,6 +118,10 @@ type CommitLog interface {
 	// IsReadonly indicates if the log is in readonly mode.
 	IsReadonly() bool
 
+	// SetRetention changes the limits of the log's retention policy. They are
+	// enforced the next time the log is cleaned. A limit of 0 disables it.
+	SetRetention(bytes, messages int64, age time.Duration)
+
 	// IsConcurrencyControlEnabled indicates if the log should check for concurrency before appending messages
 	IsConcurrencyControlEnabled() bool
 
@@ -2,6 +2,7 @@ package commitlog
 
 import (
 	"errors"
+	"fmt"
 	"hash/crc32"
 
 	client "github.com/liftbridge-io/liftbridge-api/go"
@@ -9,6 +10,84 @@ import (
 
 var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
 
+// ErrCorruptMessage is returned when a message read from the log does not
+// match its CRC, e.g. because of bit rot or a partial write.
+var ErrCorruptMessage = errors.New("corrupt message")
+
+// ChecksumVerification controls when the CRCs of messages read from the log
+// are verified.
+type ChecksumVerification string
+
+const (
+	// VerifyOnRead verifies every message read from the log as well as the
+	// messages recovered when the log is opened.
+	VerifyOnRead ChecksumVerification = "read"
+	// VerifyOnRecovery only verifies the messages recovered when the log is
+	// opened, which avoids checksumming every message served to subscribers
+	// and followers.
+	VerifyOnRecovery ChecksumVerification = "recovery"
+)
+
+// ParseChecksumVerification returns the ChecksumVerification with the given
+// name.
+func ParseChecksumVerification(name string) (ChecksumVerification, error) {
+	switch verification := ChecksumVerification(name); verification {
+	case VerifyOnRead, VerifyOnRecovery:
+		return verification, nil
+	}
+	return "", fmt.Errorf("unknown checksum verification %q", name)
+}
+
+// Message attribute flags.
+const (
+	// AttrBatchContinues is set on every message of an atomic batch except the
+	// last one. This records batch boundaries in the log so that a batch is
+	// never replicated or recovered partially.
+	AttrBatchContinues int8 = 1 << iota
+
+	// AttrSyncDeferred is set on messages which may be acknowledged before
+	// they are synced to disk. When SyncOnAppend is enabled, appends made up
+	// only of such messages return once written and sync in the background,
+	// relying on replication for durability in the meantime.
+	AttrSyncDeferred
+
+	// AttrControl is set on control records, i.e. markers written to the log
+	// by the server rather than published by clients, such as transaction
+	// commit and abort markers. Control records take offsets and are
+	// replicated like other messages, but Readers skip them unless they were
+	// asked for. See NewControlMessage.
+	AttrControl
+)
+
+// ControlType identifies the kind of marker a control record is.
+type ControlType int16
+
+const (
+	// ControlCommit marks the commit of a transaction.
+	ControlCommit ControlType = iota + 1
+	// ControlAbort marks the abort of a transaction.
+	ControlAbort
+	// ControlLeaderChange marks the start of a new leader's epoch.
+	ControlLeaderChange
+)
+
+// controlTypeLen is the size of the control type at the start of a control
+// record's value.
+const controlTypeLen = 2
+
+// NewControlMessage returns a control record of the given type with the given
+// payload. The type is encoded at the start of the value, followed by the
+// payload. Control records have no key, so compaction always retains them.
+func NewControlMessage(controlType ControlType, payload []byte) *Message {
+	value := make([]byte, controlTypeLen+len(payload))
+	encoding.PutUint16(value, uint16(controlType))
+	copy(value[controlTypeLen:], payload)
+	return &Message{
+		Attributes: AttrControl,
+		Value:      value,
+	}
+}
+
 // Message is the object that gets serialized and written to the log.
 type Message struct {
 	Crc        int32
@@ -100,6 +179,37 @@ func (m SerializedMessage) Attributes() int8 {
 	return int8(m[5])
 }
 
+// BatchContinues indicates if the message is followed by another message of
+// the same atomic batch.
+func (m SerializedMessage) BatchContinues() bool {
+	return m.Attributes()&AttrBatchContinues != 0
+}
+
+// SyncDeferred indicates if the message may be acknowledged before it's synced
+// to disk.
+func (m SerializedMessage) SyncDeferred() bool {
+	return m.Attributes()&AttrSyncDeferred != 0
+}
+
+// IsControl indicates if the message is a control record written by the
+// server rather than a message published by a client.
+func (m SerializedMessage) IsControl() bool {
+	return m.Attributes()&AttrControl != 0
+}
+
+// ControlType returns the type of a control record and its payload. It
+// returns false if the message is not a valid control record.
+func (m SerializedMessage) ControlType() (ControlType, []byte, bool) {
+	if !m.IsControl() {
+		return 0, nil, false
+	}
+	value := m.Value()
+	if len(value) < controlTypeLen {
+		return 0, nil, false
+	}
+	return ControlType(encoding.Uint16(value)), value[controlTypeLen:], true
+}
+
 // Key returns the message key.
 func (m SerializedMessage) Key() []byte {
 	start, end, size := m.keyOffsets()
@@ -1,9 +1,7 @@
 package commitlog
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"fmt"
 	"hash/crc32"
 
@@ -48,73 +46,152 @@ func entriesForMessageSet(basePos int64, ms []byte) []*entry {
 	return entries
 }
 
+// newMessageSetFromProto encodes the messages into a message set starting at
+// the given offset and position. The message set is built in a pooled buffer
+// which should be returned with releaseMessageSet once it has been written.
 func newMessageSetFromProto(baseOffset, basePos int64, msgs []*Message, concurrencyControl bool) (
 	messageSet, []*entry, error) {
 
+	buf, _, entries, err := encodeMessageSet(baseOffset, basePos, msgs, concurrencyControl, 0)
+	return buf, entries, err
+}
+
+// newMessageSetBuffersFromProto is like newMessageSetFromProto but byte
+// fields of at least minReferencedBytes, typically message values, are not
+// copied into the pooled buffer. Instead, the message set is returned as the
+// buffers to write in order, which interleave the pooled buffer with those
+// fields. The pooled buffer should be returned with releaseMessageSet once
+// the message set has been written.
+func newMessageSetBuffersFromProto(baseOffset, basePos int64, msgs []*Message,
+	concurrencyControl bool) (messageSet, [][]byte, []*entry, error) {
+
+	return encodeMessageSet(baseOffset, basePos, msgs, concurrencyControl, minReferencedBytes)
+}
+
+// encodeMessageSet encodes the messages into a message set starting at the
+// given offset and position. Byte fields of at least minRef bytes are
+// referenced rather than copied into the returned pooled buffer, unless minRef
+// is 0. It also returns the message set as the buffers to write in order.
+func encodeMessageSet(baseOffset, basePos int64, msgs []*Message, concurrencyControl bool,
+	minRef int) (messageSet, [][]byte, []*entry, error) {
+
 	// When concurrency control is enabled, messages shall be processed on by one
-	if concurrencyControl && len(msgs) > 1 {
+	// unless they form a single atomic batch.
+	if concurrencyControl && !isAtomicBatch(msgs) {
 		panic(fmt.Errorf("Concurrency Control is enabled, unable to process a batch of messages"))
 	}
 
+	// Compute the size of the buffer up front so that the message set can be
+	// encoded into a single buffer.
+	lenEnc := &refLenEncoder{minRef: minRef}
+	for _, m := range msgs {
+		if err := m.Encode(lenEnc); err != nil {
+			panic(err)
+		}
+	}
+
 	var (
-		buf     = new(bytes.Buffer)
+		buf     = getBuffer(lenEnc.Length + len(msgs)*msgSetHeaderLen)
+		enc     = &refEncoder{byteEncoder: newByteEncoder(buf), minRef: minRef}
 		entries = make([]*entry, len(msgs))
-		n       int32
+		block   = make([]entry, len(msgs))
 	)
 	for i, m := range msgs {
-		data, err := encode(m)
-		if err != nil {
-			panic(err)
-		}
-		var (
-			len    = int32(len(data))
-			relPos = int64(n)
-			offset = int64(i) + baseOffset
-		)
+		offset := int64(i) + baseOffset
 
 		// Check expected offset for concurrency in case of Optimistic Concurrency Control
 		if concurrencyControl && m.Offset != -1 {
 			if offset != m.Offset {
-				return nil, nil, ErrIncorrectOffset
+				putBuffer(buf)
+				return nil, nil, nil, ErrIncorrectOffset
 			}
 		}
 
-		if err := binary.Write(buf, encoding, uint64(offset)); err != nil {
-			return nil, nil, err
-		}
-		n += 8
-		if err := binary.Write(buf, encoding, uint64(m.Timestamp)); err != nil {
-			return nil, nil, err
-		}
-		n += 8
-		if err := binary.Write(buf, encoding, m.LeaderEpoch); err != nil {
-			return nil, nil, err
-		}
-		n += 8
-		if err := binary.Write(buf, encoding, uint32(len)); err != nil {
-			return nil, nil, err
-		}
-		n += 4
-		if _, err := buf.Write(data); err != nil {
-			return nil, nil, err
+		// Encode the message after its header, then fill in the header once
+		// the message size is known. Positions and sizes include referenced
+		// fields.
+		var (
+			bufPos = enc.off
+			relPos = enc.pos()
+		)
+		enc.off += msgSetHeaderLen
+		if err := m.Encode(enc); err != nil {
+			panic(err)
 		}
-		n += len
-		entries[i] = &entry{
+		size := int32(enc.pos() - relPos - msgSetHeaderLen)
+		encoding.PutUint64(buf[bufPos+offsetPos:], uint64(offset))
+		encoding.PutUint64(buf[bufPos+timestampPos:], uint64(m.Timestamp))
+		encoding.PutUint64(buf[bufPos+leaderEpochPos:], m.LeaderEpoch)
+		encoding.PutUint32(buf[bufPos+sizePos:], uint32(size))
+
+		block[i] = entry{
 			Offset:      offset,
 			Timestamp:   m.Timestamp,
 			LeaderEpoch: m.LeaderEpoch,
-			Position:    basePos + relPos,
-			Size:        len + msgSetHeaderLen,
+			Position:    basePos + int64(relPos),
+			Size:        size + msgSetHeaderLen,
 		}
+		entries[i] = &block[i]
 	}
-	return buf.Bytes(), entries, nil
+	return buf, enc.buffers(), entries, nil
+}
+
+// releaseMessageSet returns the buffer of a message set created with
+// newMessageSetFromProto to the pool.
+func releaseMessageSet(ms messageSet) {
+	putBuffer(ms)
+}
+
+// isAtomicBatch indicates if the messages consist of exactly one message or
+// exactly one atomic batch.
+func isAtomicBatch(msgs []*Message) bool {
+	for i, m := range msgs {
+		continues := m.Attributes&AttrBatchContinues != 0
+		if continues == (i == len(msgs)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// syncDeferred indicates if all of the messages may be acknowledged before
+// they are synced to disk.
+func syncDeferred(msgs []*Message) bool {
+	for _, m := range msgs {
+		if m.Attributes&AttrSyncDeferred == 0 {
+			return false
+		}
+	}
+	return len(msgs) > 0
+}
+
+// messageSetSyncDeferred indicates if all of the messages in the message set
+// may be acknowledged before they are synced to disk.
+func messageSetSyncDeferred(ms []byte) bool {
+	if len(ms) <= msgSetHeaderLen {
+		return false
+	}
+	for len(ms) > 0 {
+		m := messageSet(ms)
+		if !m.Message().SyncDeferred() {
+			return false
+		}
+		ms = ms[msgSetHeaderLen+m.Size():]
+	}
+	return true
 }
 
 // readMessage reads a single message from the reader or blocks until one is
 // available. It returns the Message in addition to its offset, timestamp, and
 // leader epoch. This may return uncommitted messages if the reader was created
-// with the uncommitted flag set to true.
-func readMessage(ctx context.Context, reader contextReader, headersBuf []byte) (SerializedMessage, int64, int64, uint64, error) {
+// with the uncommitted flag set to true. The message is read into buf if it
+// has enough capacity, otherwise a buffer is taken from the pool if pooled is
+// true or allocated if it's not. If verify is true,
+// an error whose cause is ErrCorruptMessage is returned if the message does
+// not match its CRC.
+func readMessage(ctx context.Context, reader contextReader, headersBuf, buf []byte, verify, pooled bool) (
+	SerializedMessage, int64, int64, uint64, error) {
+
 	if _, err := reader.Read(ctx, headersBuf); err != nil {
 		return nil, 0, 0, 0, errors.Wrap(err, "failed to read message headers")
 	}
@@ -122,23 +199,44 @@ func readMessage(ctx context.Context, reader contextReader, headersBuf []byte) (
 		offset      = int64(encoding.Uint64(headersBuf[offsetPos:]))
 		timestamp   = int64(encoding.Uint64(headersBuf[timestampPos:]))
 		leaderEpoch = encoding.Uint64(headersBuf[leaderEpochPos:])
-		size        = encoding.Uint32(headersBuf[sizePos:])
-		buf         = make([]byte, int(size))
+		size        = int32(encoding.Uint32(headersBuf[sizePos:]))
 	)
+	if size < 0 {
+		return nil, 0, 0, 0, errors.Wrapf(ErrCorruptMessage, "invalid size %d at offset %d", size, offset)
+	}
+	if cap(buf) < int(size) {
+		if pooled {
+			buf = getBuffer(int(size))
+		} else {
+			buf = make([]byte, size)
+		}
+	}
+	buf = buf[:size]
 	if _, err := reader.Read(ctx, buf); err != nil {
 		return nil, 0, 0, 0, errors.Wrap(err, "failed to ready message payload")
 	}
 	m := SerializedMessage(buf)
-	// Check the CRC on the message.
-	crc := m.Crc()
-	if c := crc32.Checksum(m[4:], crc32cTable); crc != c {
-		// If the CRC doesn't match, data on disk is corrupted which means the
-		// server is in an unrecoverable state.
-		panic(fmt.Errorf("Read corrupted data, expected CRC: 0x%08x, got: 0x%08x", crc, c))
+	if verify {
+		if err := verifyMessage(m, offset); err != nil {
+			return nil, 0, 0, 0, err
+		}
 	}
 	return m, offset, timestamp, leaderEpoch, nil
 }
 
+// verifyMessage returns an error whose cause is ErrCorruptMessage if the
+// message at the given offset does not match its CRC.
+func verifyMessage(m SerializedMessage, offset int64) error {
+	if len(m) < 4 {
+		return errors.Wrapf(ErrCorruptMessage, "truncated message at offset %d", offset)
+	}
+	if crc, c := m.Crc(), crc32.Checksum(m[4:], crc32cTable); crc != c {
+		return errors.Wrapf(ErrCorruptMessage, "expected CRC 0x%08x, got 0x%08x at offset %d",
+			crc, c, offset)
+	}
+	return nil
+}
+
 func (ms messageSet) Offset() int64 {
 	return int64(encoding.Uint64(ms[offsetPos : offsetPos+8]))
 }
@@ -155,6 +253,12 @@ func (ms messageSet) Size() int32 {
 	return int32(encoding.Uint32(ms[sizePos : sizePos+4]))
 }
 
+// IsControl indicates if the message is a control record.
+func (ms messageSet) IsControl() bool {
+	m := ms.Message()
+	return len(m) > 5 && m.IsControl()
+}
+
 func (ms messageSet) Message() SerializedMessage {
 	if len(ms) <= msgSetHeaderLen {
 		return nil
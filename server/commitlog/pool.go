@@ -0,0 +1,65 @@
+package commitlog
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	// minPooledBufferSize is the capacity of the smallest pooled buffers.
+	minPooledBufferSize = 512
+
+	// maxPooledBufferSize is the capacity above which buffers are not
+	// returned to the pool so that an occasional large message set does not
+	// pin memory.
+	maxPooledBufferSize = 1024 * 1024 // 1MB
+
+	// numBufferClasses is the number of buffer size classes, which double in
+	// capacity from minPooledBufferSize to maxPooledBufferSize.
+	numBufferClasses = 12
+)
+
+// bufferPools hold the buffers message sets are built in when appending to a
+// log and read into when scanning segments or reading messages. Reusing them
+// avoids allocating a buffer for every message set. Buffers are pooled by
+// size class so that a request for a small buffer does not take a large one
+// and a request for a large buffer does not discard the small ones it finds.
+// Class i holds buffers with a capacity of at least minPooledBufferSize<<i.
+var bufferPools [numBufferClasses]sync.Pool
+
+// bufferClass returns the smallest size class whose buffers can hold the
+// given number of bytes or -1 if they are too large to be pooled.
+func bufferClass(size int) int {
+	if size > maxPooledBufferSize {
+		return -1
+	}
+	if size <= minPooledBufferSize {
+		return 0
+	}
+	return bits.Len(uint((size - 1) / minPooledBufferSize))
+}
+
+// getBuffer returns a byte slice of the given length from the pool. Its
+// contents are undefined.
+func getBuffer(size int) []byte {
+	class := bufferClass(size)
+	if class < 0 {
+		return make([]byte, size)
+	}
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		return (*buf)[:size]
+	}
+	return make([]byte, size, minPooledBufferSize<<class)
+}
+
+// putBuffer returns a byte slice obtained with getBuffer to the pool. The
+// slice must not be used afterwards.
+func putBuffer(buf []byte) {
+	if cap(buf) < minPooledBufferSize || cap(buf) > maxPooledBufferSize {
+		return
+	}
+	// Pool the buffer in the largest class it can serve.
+	class := bits.Len(uint(cap(buf)/minPooledBufferSize)) - 1
+	buf = buf[:0]
+	bufferPools[class].Put(&buf)
+}
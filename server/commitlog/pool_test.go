@@ -0,0 +1,43 @@
+package commitlog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure buffers are taken from the smallest size class that can hold them.
+func TestBufferClass(t *testing.T) {
+	require.Equal(t, 0, bufferClass(0))
+	require.Equal(t, 0, bufferClass(28))
+	require.Equal(t, 0, bufferClass(512))
+	require.Equal(t, 1, bufferClass(513))
+	require.Equal(t, 1, bufferClass(1024))
+	require.Equal(t, 2, bufferClass(1025))
+	require.Equal(t, numBufferClasses-1, bufferClass(maxPooledBufferSize))
+	require.Equal(t, -1, bufferClass(maxPooledBufferSize+1))
+}
+
+// Ensure buffers have the requested length and a capacity of their size
+// class, and pooled buffers are only reused for sizes they can hold.
+func TestGetPutBuffer(t *testing.T) {
+	for _, size := range []int{1, 100, 600, 5000, maxPooledBufferSize} {
+		buf := getBuffer(size)
+		require.Len(t, buf, size)
+		require.Equal(t, minPooledBufferSize<<bufferClass(size), cap(buf))
+		putBuffer(buf)
+	}
+
+	// A buffer which can't be pooled is allocated with its exact size.
+	buf := getBuffer(maxPooledBufferSize + 1)
+	require.Equal(t, maxPooledBufferSize+1, cap(buf))
+	putBuffer(buf)
+
+	// A buffer with a capacity between classes serves the smaller class.
+	putBuffer(make([]byte, 0, 1500))
+	for i := 0; i < 10; i++ {
+		buf := getBuffer(1025)
+		require.True(t, cap(buf) >= 1025)
+		putBuffer(buf)
+	}
+}
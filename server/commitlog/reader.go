@@ -24,21 +24,16 @@ type Reader struct {
 	offset      int64
 	log         *commitLog
 	uncommitted bool
+	readControl bool
 }
 
 // NewReader creates a new Reader starting at the given offset. If uncommitted
 // is true, the Reader will read uncommitted messages from the log. Otherwise,
-// it will only return committed messages.
+// it will only return committed messages. If the offset precedes the log but
+// was offloaded to tiered storage, the Reader hydrates the segments containing
+// it.
 func (l *commitLog) NewReader(offset int64, uncommitted bool) (*Reader, error) {
-	var (
-		ctxReader contextReader
-		err       error
-	)
-	if uncommitted {
-		ctxReader, err = l.newReaderUncommitted(offset)
-	} else {
-		ctxReader, err = l.newReaderCommitted(offset)
-	}
+	ctxReader, err := l.newContextReader(offset, uncommitted)
 	return &Reader{
 		ctxReader:   ctxReader,
 		offset:      offset,
@@ -47,10 +42,22 @@ func (l *commitLog) NewReader(offset int64, uncommitted bool) (*Reader, error) {
 	}, err
 }
 
+// SetReadControl sets whether the Reader returns control records. By default,
+// they are skipped, so their offsets appear as gaps in the log. Readers which
+// must see every message, such as replication, should read them.
+func (r *Reader) SetReadControl(readControl bool) {
+	r.readControl = readControl
+}
+
 // ReadMessage reads a single message from the underlying CommitLog or blocks
 // until one is available. It returns the SerializedMessage in addition to its
 // offset, timestamp, and leader epoch. This may return uncommitted messages if
-// the reader was created with the uncommitted flag set to true.
+// the reader was created with the uncommitted flag set to true. If the context
+// is done, or is done while waiting for a message, this returns an error whose
+// cause is io.EOF without waiting for the log to be written to. Unless the
+// log only verifies checksums on recovery, it returns an error whose cause is
+// ErrCorruptMessage if the message does not match its CRC. Control records are
+// skipped unless SetReadControl was called.
 //
 // ReadMessage should not be called concurrently, and the headersBuf slice
 // should have a capacity of at least 28.
@@ -58,8 +65,42 @@ func (l *commitLog) NewReader(offset int64, uncommitted bool) (*Reader, error) {
 // TODO: Should this just return a MessageSet directly instead of a Message and
 // the MessageSet header values?
 func (r *Reader) ReadMessage(ctx context.Context, headersBuf []byte) (SerializedMessage, int64, int64, uint64, error) {
+	return r.ReadMessageInto(ctx, headersBuf, nil)
+}
+
+// ReadMessageInto is like ReadMessage but reads the message into buf if it
+// has enough capacity, avoiding an allocation per message. The returned
+// SerializedMessage is then only valid until buf is reused. Callers which
+// copy messages out immediately, such as replication, should use this with a
+// scratch buffer.
+func (r *Reader) ReadMessageInto(ctx context.Context, headersBuf, buf []byte) (
+	SerializedMessage, int64, int64, uint64, error) {
+	return r.readMessage(ctx, headersBuf, buf, false)
+}
+
+// ReadPooledMessage is like ReadMessage but reads the message into a pooled
+// buffer. Once the caller no longer references the message or any slice of
+// it, such as its key, value, or headers, it can pass it to ReleaseMessage so
+// the buffer is reused. This saves callers which read many messages and
+// discard most of them, such as subscriptions sharing messages, from
+// allocating a buffer per message. Messages which are not released are
+// garbage collected as usual.
+func (r *Reader) ReadPooledMessage(ctx context.Context, headersBuf []byte) (
+	SerializedMessage, int64, int64, uint64, error) {
+	return r.readMessage(ctx, headersBuf, nil, true)
+}
+
+// ReleaseMessage returns the buffer of a message read with ReadPooledMessage
+// to the pool. The message must not be used afterwards.
+func ReleaseMessage(msg SerializedMessage) {
+	putBuffer(msg)
+}
+
+func (r *Reader) readMessage(ctx context.Context, headersBuf, buf []byte, pooled bool) (
+	SerializedMessage, int64, int64, uint64, error) {
 RETRY:
-	msg, offset, timestamp, leaderEpoch, err := readMessage(ctx, r.ctxReader, headersBuf)
+	msg, offset, timestamp, leaderEpoch, err := readMessage(ctx, r.ctxReader, headersBuf, buf,
+		r.log.ChecksumVerification != VerifyOnRecovery, pooled)
 	if err != nil {
 		if r.log.IsDeleted() {
 			// The log was deleted while we were trying to read.
@@ -73,12 +114,9 @@ RETRY:
 		} else if pkgErrors.Cause(err) == ErrSegmentReplaced {
 			// ErrSegmentReplaced indicates we attempted to read from a log
 			// segment that was replaced due to compaction, so reinitialize the
-			// contextReader and try again to read from the new segment.
-			if r.uncommitted {
-				r.ctxReader, err = r.log.newReaderUncommitted(r.offset)
-			} else {
-				r.ctxReader, err = r.log.newReaderCommitted(r.offset)
-			}
+			// contextReader and try again to read from the new segment. This is
+			// also returned for hydrated segments which were evicted.
+			r.ctxReader, err = r.log.newContextReader(r.offset, r.uncommitted)
 			if err != nil {
 				return nil, 0, 0, 0, pkgErrors.Wrap(err, "failed to reinitialize reader")
 			}
@@ -88,6 +126,12 @@ RETRY:
 		}
 	}
 	r.offset = offset + 1
+	if msg.IsControl() && !r.readControl {
+		if pooled {
+			putBuffer(msg)
+		}
+		goto RETRY
+	}
 	return msg, offset, timestamp, leaderEpoch, err
 }
 
@@ -101,6 +145,9 @@ type uncommittedReader struct {
 func (r *uncommittedReader) Read(ctx context.Context, p []byte) (n int, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if ctx.Err() != nil {
+		return 0, io.EOF
+	}
 
 	var (
 		segments = r.cl.Segments()
@@ -169,13 +216,14 @@ LOOP:
 }
 
 func (r *uncommittedReader) waitForData(ctx context.Context, seg *segment) bool {
-	wait := seg.WaitForData(r, r.pos)
+	if ctx.Err() != nil {
+		return false
+	}
+	wait := seg.WaitForData(r.pos)
 	select {
 	case <-r.cl.closed:
-		seg.removeWaiter(r)
 		return false
 	case <-ctx.Done():
-		seg.removeWaiter(r)
 		return false
 	case <-wait:
 		return true
@@ -217,6 +265,9 @@ type committedReader struct {
 func (r *committedReader) Read(ctx context.Context, p []byte) (n int, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if ctx.Err() != nil {
+		return 0, io.EOF
+	}
 	segments := r.cl.Segments()
 
 	// If seg is nil then the reader offset exceeded the HW, i.e. the log is
@@ -318,6 +369,10 @@ LOOP:
 }
 
 func (r *committedReader) waitForHW(ctx context.Context, hw int64) error {
+	// Don't register a waiter which would only be removed again.
+	if ctx.Err() != nil {
+		return io.EOF
+	}
 	wait := r.cl.waitForHW(r, hw)
 	select {
 	case <-r.cl.closed:
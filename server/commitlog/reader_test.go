@@ -3,6 +3,7 @@ package commitlog
 import (
 	"context"
 	"io"
+	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -214,6 +215,51 @@ func TestReaderCommittedBlockCancel(t *testing.T) {
 	require.Equal(t, io.EOF, errors.Cause(err))
 }
 
+// Ensure a committed reader whose context is canceled while waiting for the
+// HW removes its HW waiter and returns right away, and doesn't register one
+// again once the context is done.
+func TestReaderCommittedCancelReleasesWaiter(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 10,
+	})
+	defer l.Close()
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	errC := make(chan error, 1)
+	go func() {
+		_, _, _, _, err := r.ReadMessage(ctx, make([]byte, 28))
+		errC <- err
+	}()
+
+	// Wait for the reader to register as an HW waiter.
+	require.Eventually(t, func() bool {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return len(l.hwWaiters) == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-errC:
+		require.Equal(t, io.EOF, errors.Cause(err))
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after its context was canceled")
+	}
+	l.mu.RLock()
+	require.Len(t, l.hwWaiters, 0)
+	l.mu.RUnlock()
+
+	_, _, _, _, err = r.ReadMessage(ctx, make([]byte, 28))
+	require.Equal(t, io.EOF, errors.Cause(err))
+	l.mu.RLock()
+	require.Len(t, l.hwWaiters, 0)
+	l.mu.RUnlock()
+}
+
 func TestReaderCommittedReadError(t *testing.T) {
 	l, cleanup := setupWithOptions(t, Options{
 		Path:            tempDir(t),
@@ -565,3 +611,154 @@ func compareMessages(t *testing.T, exp *Message, act SerializedMessage) {
 		require.Equal(t, exp.Headers, act.Headers())
 	}
 }
+
+// Ensure corrupt messages are detected by readers unless checksums are only
+// verified on recovery and are always detected by segment scanners.
+func TestReaderCorruptMessage(t *testing.T) {
+	for _, verification := range []ChecksumVerification{VerifyOnRead, VerifyOnRecovery} {
+		t.Run(string(verification), func(t *testing.T) {
+			l, cleanup := setupWithOptions(t, Options{
+				Path:                 tempDir(t),
+				ChecksumVerification: verification,
+			})
+			defer cleanup()
+			defer l.Close()
+
+			for i := 0; i < 3; i++ {
+				_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+				require.NoError(t, err)
+			}
+
+			// Flip a bit of the second message.
+			segment := l.activeSegment()
+			e, err := segment.findEntry(1)
+			require.NoError(t, err)
+			file, err := os.OpenFile(segment.logPath(), os.O_RDWR, 0666)
+			require.NoError(t, err)
+			_, err = file.WriteAt([]byte{0xff}, segment.headerLen+e.Position+int64(e.Size)-1)
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r, err := l.NewReader(0, true)
+			require.NoError(t, err)
+			headers := make([]byte, 28)
+			_, _, _, _, err = r.ReadMessage(ctx, headers)
+			require.NoError(t, err)
+			_, offset, _, _, err := r.ReadMessage(ctx, headers)
+			if verification == VerifyOnRecovery {
+				require.NoError(t, err)
+				require.Equal(t, int64(1), offset)
+			} else {
+				require.Equal(t, ErrCorruptMessage, errors.Cause(err))
+			}
+
+			ss := newSegmentScanner(segment)
+			_, _, err = ss.Scan()
+			require.NoError(t, err)
+			_, _, err = ss.Scan()
+			require.Equal(t, ErrCorruptMessage, errors.Cause(err))
+		})
+	}
+}
+
+// Ensure Readers skip control records unless they're asked to read them and
+// compaction retains control records even if they have a key.
+func TestReaderControlRecords(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+		Compact:         true,
+	})
+	defer cleanup()
+
+	keyed := NewControlMessage(ControlAbort, nil)
+	keyed.Key = []byte("foo")
+	msgs := []*Message{
+		{Key: []byte("foo"), Value: []byte("first")},
+		NewControlMessage(ControlCommit, []byte("txn")),
+		keyed,
+		{Key: []byte("foo"), Value: []byte("second")},
+		{Key: []byte("foo"), Value: []byte("third")},
+	}
+	for _, msg := range msgs {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(l.NewestOffset())
+	require.NoError(t, l.Clean(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	headers := make([]byte, 28)
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), offset)
+	require.False(t, msg.IsControl())
+	_, _, ok := msg.ControlType()
+	require.False(t, ok)
+
+	r, err = l.NewReader(0, true)
+	require.NoError(t, err)
+	r.SetReadControl(true)
+	msg, offset, _, _, err = r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), offset)
+	require.True(t, msg.IsControl())
+	controlType, payload, ok := msg.ControlType()
+	require.True(t, ok)
+	require.Equal(t, ControlCommit, controlType)
+	require.Equal(t, []byte("txn"), payload)
+
+	msg, offset, _, _, err = r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+	controlType, payload, ok = msg.ControlType()
+	require.True(t, ok)
+	require.Equal(t, ControlAbort, controlType)
+	require.Empty(t, payload)
+
+	msg, offset, _, _, err = r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), offset)
+	require.False(t, msg.IsControl())
+}
+
+// Ensure pooled messages are read like any other and their buffers are
+// reused once released.
+func TestReaderPooledMessage(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+
+	msgs := []*Message{
+		{Key: []byte("foo"), Value: []byte("first")},
+		NewControlMessage(ControlCommit, nil),
+		{Key: []byte("bar"), Value: []byte("second")},
+		{Key: []byte("baz"), Value: []byte("third")},
+	}
+	for _, msg := range msgs {
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	headers := make([]byte, 28)
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	// Control records are skipped.
+	for _, i := range []int{0, 2, 3} {
+		msg, offset, _, _, err := r.ReadPooledMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		compareMessages(t, msgs[i], msg)
+		require.Equal(t, minPooledBufferSize, cap(msg))
+		ReleaseMessage(msg)
+	}
+}
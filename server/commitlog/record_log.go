@@ -0,0 +1,261 @@
+package commitlog
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// recordCodec encodes the messages written to a recordLog into records and
+// decodes them back.
+type recordCodec interface {
+	// prefixLen returns the length of the prefix every record starts with.
+	prefixLen() int
+
+	// recordLen returns the length of the record starting with the given
+	// prefix and the length of the messages it contains.
+	recordLen(prefix []byte) (length, dataLen int64)
+
+	// encode appends the record containing the given messages, whose first
+	// byte is at the given position, to dst.
+	encode(dst, data []byte, pos int64) ([]byte, error)
+
+	// decode appends the messages contained in the given record, whose first
+	// byte is at the given position, to dst.
+	decode(dst, record []byte, pos int64) ([]byte, error)
+}
+
+// recordLog reads and writes the messages of a log whose writes are each
+// stored as a record encoded by a recordCodec, e.g. encrypted or batched. The
+// positions of messages are those they would have in a FormatV1 log, so
+// indexes are not affected by the encoding. It keeps the position and file
+// offset of each record in memory to find the records a read spans, as well as
+// the last record it decoded or wrote since reads are mostly sequential and
+// mostly at the end of the log.
+type recordLog struct {
+	file      *os.File
+	codec     recordCodec
+	headerLen int64
+	mu        sync.Mutex
+	starts    []uint32 // Position of each record's first byte
+	offsets   []uint32 // File offset of each record
+	size      int64    // Size of the decoded messages
+	end       int64    // File offset of the end of the last record
+	record    int      // Index of the record in buf, -1 if none
+	buf       []byte
+	scratch   []byte
+}
+
+// openRecordLog returns a recordLog for the log file of the given size whose
+// records follow a header of the given length. Records past the end of the
+// file are ignored, as is the last record if it can't be decoded, since they
+// may have been partially written before a crash. repair removes them from
+// the file.
+func openRecordLog(file *os.File, size, headerLen int64, codec recordCodec) (*recordLog, error) {
+	l := &recordLog{
+		file:      file,
+		codec:     codec,
+		headerLen: headerLen,
+		end:       headerLen,
+		record:    -1,
+	}
+	var (
+		r      = bufio.NewReaderSize(io.NewSectionReader(file, headerLen, size-headerLen), 64*1024)
+		prefix = make([]byte, codec.prefixLen())
+	)
+	for {
+		if _, err := io.ReadFull(r, prefix); err != nil {
+			break
+		}
+		length, dataLen := codec.recordLen(prefix)
+		if length < int64(len(prefix)) || l.end+length > size {
+			break
+		}
+		if _, err := r.Discard(int(length) - len(prefix)); err != nil {
+			return nil, errors.Wrap(err, "read record failed")
+		}
+		l.starts = append(l.starts, uint32(l.size))
+		l.offsets = append(l.offsets, uint32(l.end))
+		l.size += dataLen
+		l.end += length
+	}
+	// The last record may also have been torn by a crash if the file was
+	// extended before its contents were written.
+	if len(l.starts) > 0 {
+		last := len(l.starts) - 1
+		if _, err := l.readRecord(last); err != nil {
+			l.end, l.size = int64(l.offsets[last]), int64(l.starts[last])
+			l.starts, l.offsets = l.starts[:last], l.offsets[:last]
+			l.record = -1
+		}
+	}
+	return l, nil
+}
+
+// repair truncates the log file of the given size to the end of the last
+// record, removing a record partially written before a crash.
+func (l *recordLog) repair(size int64) error {
+	if l.end < size {
+		if err := l.file.Truncate(l.end); err != nil {
+			return errors.Wrap(err, "truncate file failed")
+		}
+	}
+	return nil
+}
+
+func (l *recordLog) Write(p []byte) (int, error) {
+	return l.WriteBuffers([][]byte{p})
+}
+
+// WriteBuffers encodes the buffers as a single record and appends it to the
+// log.
+func (l *recordLog) WriteBuffers(bufs [][]byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.writeRecord(bufs)
+}
+
+func (l *recordLog) writeRecord(bufs [][]byte) (int, error) {
+	n := 0
+	for _, p := range bufs {
+		n += len(p)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if l.size+int64(n) > math.MaxUint32 {
+		return 0, errors.New("segment log is full")
+	}
+	// The messages are kept as the buffered record since consumers at the
+	// end of the log read them next.
+	l.record = -1
+	data := l.buf[:0]
+	for _, p := range bufs {
+		data = append(data, p...)
+	}
+	l.buf = data
+	record, err := l.codec.encode(l.scratch[:0], data, l.size)
+	if err != nil {
+		return 0, err
+	}
+	l.scratch = record
+	if l.end+int64(len(record)) > math.MaxUint32 {
+		return 0, errors.New("segment log is full")
+	}
+	if _, err := l.file.Write(record); err != nil {
+		return 0, err
+	}
+	l.starts = append(l.starts, uint32(l.size))
+	l.offsets = append(l.offsets, uint32(l.end))
+	l.record = len(l.starts) - 1
+	l.size += int64(n)
+	l.end += int64(len(record))
+	return n, nil
+}
+
+// ReadAt reads len(p) decoded bytes starting at the given offset. Like
+// os.File, it returns io.EOF if it reads fewer bytes because the log ends.
+func (l *recordLog) ReadAt(p []byte, off int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= l.size {
+			return n, io.EOF
+		}
+		record := l.recordAt(pos)
+		data, err := l.readRecord(record)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos-int64(l.starts[record]):])
+	}
+	return n, nil
+}
+
+// recordAt returns the index of the record containing the given position,
+// which must be in the log.
+func (l *recordLog) recordAt(pos int64) int {
+	return sort.Search(len(l.starts), func(i int) bool {
+		return int64(l.starts[i]) > pos
+	}) - 1
+}
+
+// readRecord returns the decoded contents of the given record.
+func (l *recordLog) readRecord(record int) ([]byte, error) {
+	var (
+		start     = int64(l.starts[record])
+		end       = l.size
+		offset    = int64(l.offsets[record])
+		endOffset = l.end
+	)
+	if record+1 < len(l.starts) {
+		end = int64(l.starts[record+1])
+		endOffset = int64(l.offsets[record+1])
+	}
+	length := end - start
+	if record == l.record {
+		return l.buf[:length], nil
+	}
+	encoded := int(endOffset - offset)
+	if cap(l.scratch) < encoded {
+		l.scratch = make([]byte, encoded)
+	}
+	b := l.scratch[:encoded]
+	if _, err := l.file.ReadAt(b, offset); err != nil {
+		return nil, errors.Wrap(err, "read record failed")
+	}
+	// Invalidate the buffered record in case decoding fails partway.
+	l.record = -1
+	data, err := l.codec.decode(l.buf[:0], b, start)
+	if err != nil {
+		return nil, err
+	}
+	l.buf = data
+	if int64(len(data)) != length {
+		return nil, errors.Errorf("record at position %d has %d bytes, expected %d",
+			start, len(data), length)
+	}
+	l.record = record
+	return data, nil
+}
+
+// truncate removes the messages past the given position from the log. If the
+// position is within a record, the record is replaced by one containing the
+// messages before the position.
+func (l *recordLog) truncate(pos int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if pos >= l.size {
+		return nil
+	}
+	var (
+		record = l.recordAt(pos)
+		start  = int64(l.starts[record])
+		offset = int64(l.offsets[record])
+		kept   []byte
+	)
+	if pos > start {
+		data, err := l.readRecord(record)
+		if err != nil {
+			return err
+		}
+		kept = append(kept, data[:pos-start]...)
+	}
+	if err := l.file.Truncate(offset); err != nil {
+		return errors.Wrap(err, "truncate file failed")
+	}
+	l.starts, l.offsets = l.starts[:record], l.offsets[:record]
+	l.size, l.end = start, offset
+	l.record = -1
+	if _, err := l.writeRecord([][]byte{kept}); err != nil {
+		return errors.Wrap(err, "log write failed")
+	}
+	return nil
+}
@@ -7,17 +7,19 @@ import (
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
 const (
-	fileFormat      = "%020d%s"
-	logSuffix       = ".log"
-	cleanedSuffix   = ".cleaned"
-	truncatedSuffix = ".truncated"
-	indexSuffix     = ".index"
+	fileFormat       = "%020d%s"
+	logSuffix        = ".log"
+	cleanedSuffix    = ".cleaned"
+	truncatedSuffix  = ".truncated"
+	compressedSuffix = ".compressed"
+	indexSuffix      = ".index"
 )
 
 var (
@@ -49,58 +51,253 @@ var (
 	// timestamp returns the current time in Unix nanoseconds. This function
 	// exists for mocking purposes.
 	timestamp = func() int64 { return time.Now().UnixNano() }
+
+	// closedChan is returned to waiters which don't need to wait.
+	closedChan = func() chan struct{} {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}()
 )
 
+// segmentIDs is the last ID assigned to a segment instance.
+var segmentIDs uint64
+
+// segmentTail is the state of the end of a segment.
+type segmentTail struct {
+	BaseOffset    int64
+	Position      int64
+	IndexPosition int64
+	IndexedPos    int64
+	LastOffset    int64
+	LastWriteTime int64
+}
+
 type segment struct {
-	writer         io.Writer
-	reader         io.Reader
-	log            *os.File
-	Index          *index
-	BaseOffset     int64
+	// These fields are updated by writes and read atomically so that they
+	// can be read without taking the segment lock. They are first in the
+	// struct to ensure 64-bit alignment.
 	firstOffset    int64
 	lastOffset     int64
 	firstWriteTime int64
 	lastWriteTime  int64
 	position       int64
-	maxBytes       int64
-	path           string
-	suffix         string
-	waiters        map[interface{}]chan struct{}
-	sealed         bool
-	closed         bool
-	replaced       bool
+	maxTimestamp   int64 // Largest message timestamp, -1 if empty
+	waiting        int32
+	loaded         uint32
+	// id uniquely identifies the segment instance in the block cache.
+	id uint64
+
+	writer io.Writer
+	reader io.ReaderAt
+	log    *os.File
+	// format is the format version of the log and headerLen the length of
+	// its header. Positions exclude the header.
+	format     int
+	headerLen  int64
+	Index      *index
+	TimeIndex  *timeIndex
+	BaseOffset int64
+	maxBytes   int64
+	path       string
+	suffix     string
+	ioUring    bool
+	// indexInterval is the minimum number of log bytes between index
+	// entries. If it's 0, every message is indexed.
+	indexInterval int64
+	indexAccess   indexAccess
+	blockCache    *BlockCache
+	// keys provides the key the log is encrypted with. If it's nil, new
+	// logs are not encrypted.
+	keys *segmentKeys
+	// messageFormat is the format new logs are written in if they're not
+	// encrypted.
+	messageFormat MessageFormat
+	// indexedPos is the log position of the last indexed message. It's
+	// guarded by writeMu.
+	indexedPos int64
+	dataWait   atomic.Value // chan struct{} closed on the next write or seal
+	syncer     *groupSyncer
+	sealed     bool
+	closed     bool
+	replaced   bool
+	writeMu    sync.Mutex
+	loadMu     sync.Mutex
+	loadErr    error
+	// cleanTail is the tail recorded when the segment was last closed
+	// cleanly, if any. It's used when the segment is opened and cleared if it
+	// does not match the log.
+	cleanTail *segmentTail
 
+	// Writes hold the read lock, in addition to writeMu, so that they don't
+	// block reads. The write lock is held to seal, close, or replace the
+	// segment.
 	sync.RWMutex
 }
 
-func newSegment(path string, baseOffset, maxBytes int64, isNew bool, suffix string) (*segment, error) {
-	s := &segment{
-		maxBytes:    maxBytes,
-		BaseOffset:  baseOffset,
-		firstOffset: -1,
-		lastOffset:  -1,
-		path:        path,
-		suffix:      suffix,
-		waiters:     make(map[interface{}]chan struct{}),
-	}
+// newSegment opens the segment with the given base offset, creating it if it
+// does not exist. If ioUring is true and io_uring is supported, the segment's
+// log is read and written using io_uring. Messages are indexed at most every
+// indexInterval bytes of the log, or every message if it's 0, and the index's
+// memory mapping is tuned with indexAccess. Reads go through the given block
+// cache unless it's nil. If keys is not nil, a new log is encrypted with the
+// current key. Otherwise it's written in the given message format.
+func newSegment(path string, baseOffset, maxBytes int64, isNew bool, suffix string,
+	ioUring bool, indexInterval int64, indexAccess indexAccess, blockCache *BlockCache,
+	keys *segmentKeys, messageFormat MessageFormat) (*segment, error) {
+
+	s := newLazySegment(path, baseOffset, maxBytes, suffix, ioUring, indexInterval, indexAccess,
+		blockCache, keys, messageFormat)
 	// If this is a new segment, ensure the file doesn't already exist.
 	if isNew && exists(s.logPath()) {
 		return nil, ErrSegmentExists
 	}
+	return s, s.load()
+}
+
+// newLazySegment returns the existing segment with the given base offset
+// without opening it. Its log and index are opened the first time the segment
+// is accessed, so logs with many segments open quickly. If opening fails, the
+// segment appears empty and reads and writes return the error.
+func newLazySegment(path string, baseOffset, maxBytes int64, suffix string, ioUring bool,
+	indexInterval int64, indexAccess indexAccess, blockCache *BlockCache, keys *segmentKeys,
+	messageFormat MessageFormat) *segment {
+
+	s := &segment{
+		id:            atomic.AddUint64(&segmentIDs, 1),
+		blockCache:    blockCache,
+		maxBytes:      maxBytes,
+		BaseOffset:    baseOffset,
+		firstOffset:   -1,
+		lastOffset:    -1,
+		path:          path,
+		suffix:        suffix,
+		ioUring:       ioUring,
+		indexInterval: indexInterval,
+		indexAccess:   indexAccess,
+		keys:          keys,
+		messageFormat: messageFormat,
+	}
+	s.dataWait.Store(make(chan struct{}))
+	return s
+}
+
+// load opens the segment's log and index if they have not been opened yet and
+// returns the error opening them, if any.
+func (s *segment) load() error {
+	if atomic.LoadUint32(&s.loaded) == 1 {
+		return s.loadErr
+	}
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	if s.loaded == 0 {
+		s.loadErr = s.open()
+		atomic.StoreUint32(&s.loaded, 1)
+	}
+	return s.loadErr
+}
+
+// skipLoad prevents a segment which has not been opened yet from being opened,
+// causing loads to return the given error instead. It returns false if the
+// segment's log and index are open.
+func (s *segment) skipLoad(err error) bool {
+	s.loadMu.Lock()
+	defer s.loadMu.Unlock()
+	if s.loaded == 0 {
+		s.loadErr = err
+		atomic.StoreUint32(&s.loaded, 1)
+	}
+	return s.loadErr != nil
+}
+
+// open opens the segment's log and index, creating them if they don't exist.
+func (s *segment) open() error {
+	if err := s.openLog(); err != nil {
+		return err
+	}
+	if err := s.setupIndex(); err != nil {
+		return err
+	}
+	return s.setupTimeIndex()
+}
+
+// openLog opens the segment's log, creating it with a header in the current
+// format, encrypted if the segment has keys or batched if its message format
+// is MessageFormatV2, if it doesn't exist, and initializes the position.
+func (s *segment) openLog() error {
 	log, err := os.OpenFile(s.logPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		return nil, errors.Wrap(err, "open file failed")
+		return errors.Wrap(err, "open file failed")
 	}
 	info, err := log.Stat()
 	if err != nil {
-		return nil, errors.Wrap(err, "stat file failed")
+		log.Close() // nolint: errcheck
+		return errors.Wrap(err, "stat file failed")
+	}
+	size := info.Size()
+	if size == 0 {
+		header, err := s.newLogHeader()
+		if err != nil {
+			log.Close() // nolint: errcheck
+			return err
+		}
+		if _, err := log.Write(header); err != nil {
+			log.Close() // nolint: errcheck
+			return errors.Wrap(err, "write header failed")
+		}
+		size = int64(len(header))
+	}
+	s.format, s.headerLen, err = readFileHeader(log, size, logMagic, logHeaderLen)
+	if err != nil {
+		log.Close() // nolint: errcheck
+		return err
+	}
+	if s.format == FormatV2 {
+		compressed, err := openCompressedLog(log, size)
+		if err != nil {
+			log.Close() // nolint: errcheck
+			return errors.Wrap(err, "open compressed log failed")
+		}
+		s.log = log
+		atomic.StoreInt64(&s.position, compressed.size)
+		s.writer, s.reader = compressedLogWriter{}, compressed
+		return nil
+	}
+	if s.format == FormatV3 || s.format == FormatV4 {
+		var records *recordLog
+		if s.format == FormatV3 {
+			records, err = s.openEncryptedLog(log, size)
+			err = errors.Wrap(err, "open encrypted log failed")
+		} else {
+			records, err = openBatchedLog(log, size)
+			err = errors.Wrap(err, "open batched log failed")
+		}
+		if err != nil {
+			log.Close() // nolint: errcheck
+			return err
+		}
+		s.log = log
+		s.headerLen = records.headerLen
+		atomic.StoreInt64(&s.position, records.size)
+		s.writer, s.reader = records, records
+		return nil
 	}
 	s.log = log
-	s.position = info.Size()
-	s.writer = log
-	s.reader = log
-	err = s.setupIndex()
-	return s, err
+	atomic.StoreInt64(&s.position, size-s.headerLen)
+	s.writer, s.reader = newSegmentFileIO(log, s.ioUring)
+	if s.headerLen > 0 {
+		s.reader = headerReaderAt{ReaderAt: s.reader, headerLen: s.headerLen}
+	}
+	return nil
+}
+
+// IsCompressed indicates if the segment's log is compressed, in which case
+// the segment can't be written to or truncated.
+func (s *segment) IsCompressed() bool {
+	if err := s.load(); err != nil {
+		return false
+	}
+	return s.format == FormatV2
 }
 
 // setupIndex creates and initializes an index.
@@ -108,55 +305,162 @@ func newSegment(path string, baseOffset, maxBytes int64, isNew bool, suffix stri
 // - Initialize index position
 // - Initialize firstOffset/lastOffset
 // - Initialize firstWriteTime/lastWriteTime
+// If the segment has a clean tail which matches its log, the index position
+// and last offset are restored from it. Otherwise they are recovered from the
+// index and, since the index may be sparse, by scanning the log past the last
+// indexed message.
 func (s *segment) setupIndex() (err error) {
 	s.Index, err = newIndex(options{
-		path:       s.indexPath(),
-		baseOffset: s.BaseOffset,
+		path:        s.indexPath(),
+		baseOffset:  s.BaseOffset,
+		indexAccess: s.indexAccess,
 	})
 	if err != nil {
 		return err
 	}
+	if s.cleanTail != nil && s.cleanTail.Position == atomic.LoadInt64(&s.position) {
+		err = s.restoreTail(s.cleanTail)
+	} else {
+		s.cleanTail = nil
+		err = s.recoverTail()
+	}
+	// If the index position is 0, the index is empty.
+	if err != nil || s.Index.Position() == 0 {
+		return err
+	}
+	// Read the first entry to get firstOffset and firstWriteTime.
+	var firstEntry entry
+	if err := s.Index.ReadEntryAtFileOffset(&firstEntry, 0); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&s.firstOffset, firstEntry.Offset)
+	atomic.StoreInt64(&s.firstWriteTime, firstEntry.Timestamp)
+	return nil
+}
+
+// recoverTail initializes the index position and the segment's last offset
+// and last write time from the index and log. After a crash, the messages past
+// the last indexed message, as well as that message itself, may have been
+// partially written, so they are verified and the log is truncated at the
+// first one which is incomplete or corrupt. Index entries of truncated
+// messages are removed.
+func (s *segment) recoverTail() error {
 	lastEntry, err := s.Index.InitializePosition()
-	if err != nil {
+	if err != nil || lastEntry == nil {
 		return err
 	}
-	// If lastEntry is nil, the index is empty.
-	if lastEntry != nil {
-		s.lastOffset = lastEntry.Offset
-		s.lastWriteTime = lastEntry.Timestamp
-		// Read the first entry to get firstOffset and firstWriteTime.
-		var firstEntry entry
-		if err := s.Index.ReadEntryAtFileOffset(&firstEntry, 0); err != nil {
+	end := atomic.LoadInt64(&s.position)
+	for lastEntry != nil {
+		var (
+			last  = *lastEntry
+			valid = false
+		)
+		pos, err := s.scanLog(last.Position, end, true, func(e *entry) bool {
+			if !valid {
+				valid = e.Offset == last.Offset
+				return valid
+			}
+			if e.Offset <= last.Offset {
+				return false
+			}
+			last = *e
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if valid {
+			s.indexedPos = lastEntry.Position
+			atomic.StoreInt64(&s.lastOffset, last.Offset)
+			atomic.StoreInt64(&s.lastWriteTime, last.Timestamp)
+			return s.truncateLog(pos)
+		}
+		// The entry may point past the end of the log if the index was
+		// flushed but the log was not.
+		if lastEntry.Position < end {
+			end = lastEntry.Position
+		}
+		if lastEntry, err = s.Index.removeLastEntry(); err != nil {
+			return err
+		}
+	}
+	// The first message of the segment was corrupt, so it is now empty.
+	return s.truncateLog(0)
+}
+
+// truncateLog truncates the log to the given position, removing the messages
+// past it, if it ends after the position.
+func (s *segment) truncateLog(pos int64) error {
+	if pos >= atomic.LoadInt64(&s.position) {
+		return nil
+	}
+	if s.format == FormatV2 {
+		return ErrCompressedLog
+	}
+	if records, ok := s.writer.(*recordLog); ok {
+		if err := records.truncate(pos); err != nil {
 			return err
 		}
-		s.firstOffset = firstEntry.Offset
-		s.firstWriteTime = firstEntry.Timestamp
+		atomic.StoreInt64(&s.position, pos)
+		return nil
+	}
+	if err := s.log.Truncate(s.headerLen + pos); err != nil {
+		return errors.Wrap(err, "truncate file failed")
 	}
+	atomic.StoreInt64(&s.position, pos)
 	return nil
 }
 
+// restoreTail initializes the index position and the segment's last offset
+// and last write time from the tail recorded when it was closed.
+func (s *segment) restoreTail(tail *segmentTail) error {
+	if err := s.Index.SetPosition(tail.IndexPosition); err != nil {
+		return err
+	}
+	s.indexedPos = tail.IndexedPos
+	if tail.IndexPosition > 0 {
+		atomic.StoreInt64(&s.lastOffset, tail.LastOffset)
+		atomic.StoreInt64(&s.lastWriteTime, tail.LastWriteTime)
+	}
+	return nil
+}
+
+// Tail returns the state of the end of the segment, which is recorded when
+// the log is closed cleanly so that it does not need to be recovered when the
+// segment is opened again. The segment must be closed.
+func (s *segment) Tail() segmentTail {
+	return segmentTail{
+		BaseOffset:    s.BaseOffset,
+		Position:      atomic.LoadInt64(&s.position),
+		IndexPosition: s.Index.Position(),
+		IndexedPos:    s.indexedPos,
+		LastOffset:    atomic.LoadInt64(&s.lastOffset),
+		LastWriteTime: atomic.LoadInt64(&s.lastWriteTime),
+	}
+}
+
 // CheckSplit determines if a new log segment should be rolled out either
 // because this segment is full or LogRollTime has passed since the first
 // message was written to the segment.
 func (s *segment) CheckSplit(logRollTime time.Duration) bool {
-	s.RLock()
-	defer s.RUnlock()
-	if s.position >= s.maxBytes {
+	if s.Position() >= s.maxBytes {
 		return true
 	}
-	if logRollTime == 0 || s.firstWriteTime == 0 {
+	firstWriteTime := s.FirstWriteTime()
+	if logRollTime == 0 || firstWriteTime == 0 {
 		// Don't roll a new segment if there have been no writes to the segment
 		// or LogRollTime is disabled.
 		return false
 	}
 	// Check if LogRollTime has passed since first write.
-	return timestamp()-s.firstWriteTime >= int64(logRollTime)
+	return timestamp()-firstWriteTime >= int64(logRollTime)
 }
 
 // Seal a segment from being written to. This is called on the former active
 // segment after a new segment is rolled or when the segment is closed. This is
 // a no-op if the segment is already sealed.
 func (s *segment) Seal() {
+	s.load() // nolint: errcheck
 	s.Lock()
 	defer s.Unlock()
 	s.seal()
@@ -169,88 +473,214 @@ func (s *segment) seal() {
 	s.sealed = true
 	// Notify any readers waiting for data.
 	s.notifyWaiters()
-	s.Index.Shrink() // nolint: errcheck
+	if s.Index != nil {
+		s.Index.Shrink() // nolint: errcheck
+	}
+	if !s.closed {
+		s.sealTimeIndex()
+	}
 }
 
 func (s *segment) NextOffset() int64 {
-	s.RLock()
-	defer s.RUnlock()
 	// If the segment hasn't been written to, the next offset should be the
 	// base offset.
-	if s.lastOffset == -1 {
+	lastOffset := s.LastOffset()
+	if lastOffset == -1 {
 		return s.BaseOffset
 	}
-	return s.lastOffset + 1
+	return lastOffset + 1
 }
 
 func (s *segment) FirstOffset() int64 {
-	s.RLock()
-	defer s.RUnlock()
-	return s.firstOffset
+	s.load() // nolint: errcheck
+	return atomic.LoadInt64(&s.firstOffset)
 }
 
 func (s *segment) FirstWriteTime() int64 {
+	s.load() // nolint: errcheck
+	return atomic.LoadInt64(&s.firstWriteTime)
+}
+
+// firstTimestamp returns the timestamp of the segment's first message, which
+// is always indexed, or io.EOF if the segment is empty. Unlike FirstWriteTime,
+// it returns the error opening the segment, if any.
+func (s *segment) firstTimestamp() (int64, error) {
+	if err := s.load(); err != nil {
+		return 0, err
+	}
 	s.RLock()
 	defer s.RUnlock()
-	return s.firstWriteTime
+	var first entry
+	if err := s.Index.ReadEntryAtFileOffset(&first, 0); err != nil {
+		return 0, err
+	}
+	return first.Timestamp, nil
 }
 
 func (s *segment) LastOffset() int64 {
-	s.RLock()
-	defer s.RUnlock()
-	return s.lastOffset
+	s.load() // nolint: errcheck
+	return atomic.LoadInt64(&s.lastOffset)
+}
+
+func (s *segment) LastWriteTime() int64 {
+	s.load() // nolint: errcheck
+	return atomic.LoadInt64(&s.lastWriteTime)
 }
 
 func (s *segment) Position() int64 {
-	s.RLock()
-	defer s.RUnlock()
-	return s.position
+	s.load() // nolint: errcheck
+	return atomic.LoadInt64(&s.position)
 }
 
 func (s *segment) IsEmpty() bool {
-	s.RLock()
-	defer s.RUnlock()
-	return s.firstOffset == -1
+	return s.FirstOffset() == -1
 }
 
+// MessageCount returns the number of messages in the segment. Messages
+// between index entries are counted by scanning the log, which is only needed
+// if the index is sparse.
 func (s *segment) MessageCount() int64 {
+	if s.load() != nil {
+		return 0
+	}
 	s.RLock()
 	defer s.RUnlock()
-	return s.Index.CountEntries()
+	var (
+		count = s.Index.CountEntries()
+		end   = s.Position()
+		e     entry
+	)
+	for i := count - 1; i >= 0; i-- {
+		if err := s.Index.ReadEntryAtLogOffset(&e, i); err != nil {
+			break
+		}
+		s.scanLog(e.Position+int64(e.Size), end, false, func(*entry) bool { // nolint: errcheck
+			count++
+			return true
+		})
+		end = e.Position
+	}
+	return count
 }
 
+// WriteMessageSet writes the message set and its index entries to the
+// segment. Writes are serialized with each other but not with reads.
 func (s *segment) WriteMessageSet(ms []byte, entries []*entry) error {
-	s.Lock()
-	defer s.Unlock()
-	if _, err := s.write(ms, entries); err != nil {
+	return s.WriteMessageSetBuffers([][]byte{ms}, entries)
+}
+
+// WriteMessageSetBuffers is like WriteMessageSet but takes the message set as
+// a sequence of buffers, which are written with a single vectored write if
+// the log supports it.
+func (s *segment) WriteMessageSetBuffers(bufs [][]byte, entries []*entry) error {
+	if err := s.load(); err != nil {
 		return err
 	}
-	return s.Index.writeEntries(entries)
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.RLock()
+	defer s.RUnlock()
+	if _, err := s.write(bufs, entries); err != nil {
+		return err
+	}
+	if err := s.Index.writeEntries(s.indexedEntries(entries)); err != nil {
+		return err
+	}
+	return s.TimeIndex.append(s.timeEntries(entries))
 }
 
-// write a byte slice to the log at the current position. This increments the
+// indexedEntries returns the entries to add to the index. If the index is
+// sparse, this is the first message of the segment and every message at least
+// indexInterval bytes past the last indexed message. The caller must hold
+// writeMu.
+func (s *segment) indexedEntries(entries []*entry) []*entry {
+	if s.indexInterval <= 0 {
+		return entries
+	}
+	var (
+		indexed = make([]*entry, 0, 1)
+		empty   = s.Index.Position() == 0
+	)
+	for _, e := range entries {
+		if empty || e.Position-s.indexedPos >= s.indexInterval {
+			indexed = append(indexed, e)
+			s.indexedPos = e.Position
+			empty = false
+		}
+	}
+	return indexed
+}
+
+// write the buffers to the log at the current position. This increments the
 // offset as well as sets the position to the new tail.
-func (s *segment) write(p []byte, entries []*entry) (n int, err error) {
+func (s *segment) write(bufs [][]byte, entries []*entry) (n int, err error) {
 	if s.closed {
 		return 0, ErrSegmentClosed
 	}
-	n, err = s.writer.Write(p)
+	n, err = writeBuffers(s.writer, bufs)
 	if err != nil {
 		return n, errors.Wrap(err, "log write failed")
 	}
-	s.position += int64(n)
-	if s.firstWriteTime == 0 {
+	atomic.AddInt64(&s.position, int64(n))
+	if s.FirstWriteTime() == 0 {
 		first := entries[0]
-		s.firstOffset = first.Offset
-		s.firstWriteTime = first.Timestamp
+		atomic.StoreInt64(&s.firstOffset, first.Offset)
+		atomic.StoreInt64(&s.firstWriteTime, first.Timestamp)
 	}
 	last := entries[len(entries)-1]
-	s.lastOffset = last.Offset
-	s.lastWriteTime = last.Timestamp
+	atomic.StoreInt64(&s.lastOffset, last.Offset)
+	atomic.StoreInt64(&s.lastWriteTime, last.Timestamp)
 	s.notifyWaiters()
 	return n, nil
 }
 
+// Sync flushes the segment's log and index to disk. Concurrent calls are
+// coalesced into a single fsync, waiting up to maxDelay for other calls to
+// join.
+func (s *segment) Sync(maxDelay time.Duration) error {
+	s.writeMu.Lock()
+	if s.syncer == nil {
+		s.syncer = newGroupSyncer(s.sync, maxDelay)
+	}
+	syncer := s.syncer
+	s.writeMu.Unlock()
+	return syncer.Sync()
+}
+
+// SyncAsync flushes the segment's log and index to disk in the background,
+// calling onErr if the sync fails. It shares fsyncs with Sync.
+func (s *segment) SyncAsync(maxDelay time.Duration, onErr func(error)) {
+	s.writeMu.Lock()
+	if s.syncer == nil {
+		s.syncer = newGroupSyncer(s.sync, maxDelay)
+	}
+	syncer := s.syncer
+	s.writeMu.Unlock()
+	syncer.SyncAsync(onErr)
+}
+
+// sync flushes the segment's log and index to disk. It does not hold the
+// segment lock while syncing so that appends can continue in the meantime.
+func (s *segment) sync() error {
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.RLock()
+	if s.closed {
+		s.RUnlock()
+		return ErrSegmentClosed
+	}
+	log, index, timeIndex := s.log, s.Index, s.TimeIndex
+	s.RUnlock()
+	if err := log.Sync(); err != nil {
+		return errors.Wrap(err, "log sync failed")
+	}
+	if err := index.Sync(); err != nil {
+		return err
+	}
+	return timeIndex.Sync()
+}
+
 func (s *segment) ReadAt(p []byte, off int64) (n int, err error) {
 	s.RLock()
 	defer s.RUnlock()
@@ -260,60 +690,97 @@ func (s *segment) ReadAt(p []byte, off int64) (n int, err error) {
 		}
 		return 0, ErrSegmentClosed
 	}
-	return s.log.ReadAt(p, off)
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	if s.blockCache == nil {
+		return s.reader.ReadAt(p, off)
+	}
+	return s.readCached(p, off)
 }
 
+// readCached reads from the segment log through the block cache. Complete
+// blocks missing from the cache are read from the log and cached, while the
+// incomplete last block is always read from the log since it's still being
+// written.
+func (s *segment) readCached(p []byte, off int64) (n int, err error) {
+	position := atomic.LoadInt64(&s.position)
+	for n < len(p) {
+		var (
+			pos   = off + int64(n)
+			block = pos / blockSize
+			start = block * blockSize
+		)
+		if start+blockSize > position {
+			m, err := s.reader.ReadAt(p[n:], pos)
+			return n + m, err
+		}
+		key := blockKey{segment: s.id, block: block}
+		data := s.blockCache.get(key)
+		if data == nil {
+			data = make([]byte, blockSize)
+			if _, err := s.reader.ReadAt(data, start); err != nil {
+				return n, err
+			}
+			s.blockCache.put(key, data)
+		}
+		n += copy(p[n:], data[pos-start:])
+	}
+	return n, nil
+}
+
+// notifyWaiters closes the channel returned to waiters since the last
+// notification, if there are any, and replaces it with a new one. Writes and
+// seals are the only callers and never run concurrently.
 func (s *segment) notifyWaiters() {
-	for r, ch := range s.waiters {
-		close(ch)
-		delete(s.waiters, r)
+	if atomic.SwapInt32(&s.waiting, 0) == 0 {
+		return
 	}
+	wait := s.dataWait.Load().(chan struct{})
+	s.dataWait.Store(make(chan struct{}))
+	close(wait)
 }
 
-func (s *segment) WaitForLEO(waiter interface{}, expectedLEO, actualLEO int64) <-chan struct{} {
-	s.Lock()
-	defer s.Unlock()
+// waitChan returns the channel closed on the next write or seal. It must be
+// called before checking the segment's state so that a write after the check
+// closes the returned channel.
+func (s *segment) waitChan() chan struct{} {
+	wait := s.dataWait.Load().(chan struct{})
+	atomic.StoreInt32(&s.waiting, 1)
+	return wait
+}
+
+// WaitForLEO returns a channel which is closed when data is written past the
+// expected log end offset. All waiters share the same channel.
+func (s *segment) WaitForLEO(expectedLEO, actualLEO int64) <-chan struct{} {
+	wait := s.waitChan()
 	// Check expected LEO against last known LEO and against the current
 	// (active) segment's last offset in case the LEO changed since we last
 	// checked it. If the current segment's last offset is -1, this means the
 	// segment is empty and we should wait for data.
-	if expectedLEO != actualLEO || (expectedLEO != s.lastOffset && s.lastOffset != -1) {
+	lastOffset := s.LastOffset()
+	if expectedLEO != actualLEO || (expectedLEO != lastOffset && lastOffset != -1) {
 		// LEO has since changed so close channel immediately.
-		ch := make(chan struct{})
-		close(ch)
-		return ch
+		return closedChan
 	}
-	return s.waitForData(waiter, s.position)
-}
-func (s *segment) WaitForData(waiter interface{}, pos int64) <-chan struct{} {
-	s.Lock()
-	ch := s.waitForData(waiter, pos)
-	s.Unlock()
-	return ch
+	if s.Position() >= s.maxBytes {
+		return closedChan
+	}
+	return wait
 }
 
-func (s *segment) waitForData(waiter interface{}, pos int64) <-chan struct{} {
-	// Check if we're already registered.
-	wait, ok := s.waiters[waiter]
-	if ok {
-		return wait
-	}
-	wait = make(chan struct{})
+// WaitForData returns a channel which is closed when data is written past the
+// given position or the segment is sealed. All waiters share the same
+// channel.
+func (s *segment) WaitForData(pos int64) <-chan struct{} {
+	wait := s.waitChan()
 	// Check if data has been written and/or the segment was filled.
-	if s.position > pos || s.position >= s.maxBytes {
-		close(wait)
-	} else {
-		s.waiters[waiter] = wait
+	if position := s.Position(); position > pos || position >= s.maxBytes {
+		return closedChan
 	}
 	return wait
 }
 
-func (s *segment) removeWaiter(waiter interface{}) {
-	s.Lock()
-	delete(s.waiters, waiter)
-	s.Unlock()
-}
-
 // Close a segment such that it can no longer be read from or written to. This
 // operation is idempotent.
 func (s *segment) Close() error {
@@ -326,12 +793,21 @@ func (s *segment) close() error {
 	if s.closed {
 		return nil
 	}
+	// A segment which was never opened has nothing to close.
+	if s.skipLoad(ErrSegmentClosed) {
+		s.closed = true
+		s.sealed = true
+		return nil
+	}
 	if err := s.log.Close(); err != nil {
 		return err
 	}
 	if err := s.Index.Close(); err != nil {
 		return err
 	}
+	if err := s.TimeIndex.Close(); err != nil {
+		return err
+	}
 	s.closed = true
 	s.seal()
 	return nil
@@ -339,12 +815,14 @@ func (s *segment) close() error {
 
 // Cleaned creates a cleaned segment for this segment.
 func (s *segment) Cleaned() (*segment, error) {
-	return newSegment(s.path, s.BaseOffset, s.maxBytes, false, cleanedSuffix)
+	return newSegment(s.path, s.BaseOffset, s.maxBytes, false, cleanedSuffix, s.ioUring,
+		s.indexInterval, s.indexAccess, s.blockCache, s.keys, s.messageFormat)
 }
 
 // Truncated creates a truncated segment for this segment.
 func (s *segment) Truncated() (*segment, error) {
-	return newSegment(s.path, s.BaseOffset, s.maxBytes, false, truncatedSuffix)
+	return newSegment(s.path, s.BaseOffset, s.maxBytes, false, truncatedSuffix, s.ioUring,
+		s.indexInterval, s.indexAccess, s.blockCache, s.keys, s.messageFormat)
 }
 
 // Replace replaces the given segment with the callee.
@@ -365,71 +843,177 @@ func (s *segment) Replace(old *segment) error {
 	if err := os.Rename(s.indexPath(), old.indexPath()); err != nil {
 		return err
 	}
+	if err := os.Rename(s.timeIndexPath(), old.timeIndexPath()); err != nil {
+		return err
+	}
 	s.suffix = ""
-	log, err := os.OpenFile(s.logPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		return errors.Wrap(err, "open file failed")
+	if err := s.openLog(); err != nil {
+		return err
 	}
-	s.log = log
-	s.writer = log
-	s.reader = log
 	s.closed = false
 	old.replaced = true
-	return s.setupIndex()
+	if err := s.setupIndex(); err != nil {
+		return err
+	}
+	return s.setupTimeIndex()
 }
 
 // findEntry returns the first entry whose offset is greater than or equal to
 // the given offset.
 func (s *segment) findEntry(offset int64) (*entry, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
 	s.RLock()
 	defer s.RUnlock()
 	var (
-		entry = &entry{}
-		n     = int(s.Index.Position() / entryWidth)
-		err   error
+		indexEntry = &entry{}
+		n          = int(s.Index.Position() / entryWidth)
+		err        error
 	)
+	// Find the first index entry past the offset. The entry is either the one
+	// before it or a message following that one which is not indexed.
 	idx := sort.Search(n, func(i int) bool {
-		if e := s.Index.ReadEntryAtFileOffset(entry, int64(i*entryWidth)); e != nil {
+		if e := s.Index.ReadEntryAtFileOffset(indexEntry, int64(i*entryWidth)); e != nil {
 			err = e
 			return true
 		}
-		return entry.Offset >= offset
+		return indexEntry.Offset > offset
 	})
 	if err != nil {
 		return nil, err
 	}
-	if idx == n {
-		return nil, ErrEntryNotFound
-	}
-	err = s.Index.ReadEntryAtFileOffset(entry, int64(idx*entryWidth))
-	return entry, err
+	return s.scanEntries(idx, func(e *entry) bool {
+		return e.Offset >= offset
+	})
 }
 
 // findEntryByTimestamp returns the first entry whose timestamp is greater than
-// or equal to the given timestamp.
+// or equal to the given timestamp. Message timestamps don't need to be
+// ordered: the time index is searched for the last entry whose timestamp is
+// smaller, since none of the messages up to it match, and the log is scanned
+// from there.
 func (s *segment) findEntryByTimestamp(timestamp int64) (*entry, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
 	s.RLock()
 	defer s.RUnlock()
-	var (
-		entry = &entry{}
-		n     = int(s.Index.CountEntries())
-		err   error
-	)
-	idx := sort.Search(n, func(i int) bool {
-		if e := s.Index.ReadEntryAtLogOffset(entry, int64(i)); e != nil {
-			err = e
-			return true
+	if s.IsEmpty() || atomic.LoadInt64(&s.maxTimestamp) < timestamp {
+		// No message in the segment is this recent.
+		return nil, ErrEntryNotFound
+	}
+	var pos, after int64 = 0, -1
+	start, ok, err := s.TimeIndex.lookup(timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		pos, after = start.Position, start.Offset
+	}
+	var found *entry
+	_, err = s.scanLog(pos, s.Position(), false, func(e *entry) bool {
+		if e.Offset > after && e.Timestamp >= timestamp {
+			found = e
+			return false
 		}
-		return entry.Timestamp >= timestamp
+		return true
 	})
 	if err != nil {
 		return nil, err
 	}
-	if idx == n {
+	if found == nil {
 		return nil, ErrEntryNotFound
 	}
-	err = s.Index.ReadEntryAtLogOffset(entry, int64(idx))
-	return entry, err
+	return found, nil
+}
+
+// scanEntries returns the first entry matching the given predicate starting
+// with the index entry before the one at position idx in the index and the
+// messages following it in the log. If idx is 0, the first index entry is
+// returned. The caller must hold the segment lock.
+func (s *segment) scanEntries(idx int, match func(*entry) bool) (*entry, error) {
+	if s.Index.Position() == 0 {
+		return nil, ErrEntryNotFound
+	}
+	found := &entry{}
+	if idx == 0 {
+		err := s.Index.ReadEntryAtLogOffset(found, 0)
+		return found, err
+	}
+	if err := s.Index.ReadEntryAtLogOffset(found, int64(idx-1)); err != nil {
+		return nil, err
+	}
+	if match(found) {
+		return found, nil
+	}
+	var ok bool
+	_, err := s.scanLog(found.Position+int64(found.Size), s.Position(), false, func(e *entry) bool {
+		if e.Offset <= found.Offset {
+			return false
+		}
+		ok = match(e)
+		*found = *e
+		return !ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrEntryNotFound
+	}
+	return found, nil
+}
+
+// scanLog calls fn with the entry of each message in the log starting at the
+// given position and ending before the given end position until fn returns
+// false. The entry is reused between calls. A message extending past the end
+// position, e.g. one partially written before a crash, ends the scan, as does
+// a message which does not match its CRC if verify is true or a batch which
+// does not match its CRC, which can't be decoded. It returns the
+// position of the message which ended the scan, or of the end of the last
+// message if none did. The caller must hold the segment lock or have
+// exclusive access to the segment.
+func (s *segment) scanLog(pos, end int64, verify bool, fn func(*entry) bool) (int64, error) {
+	var (
+		header = make(messageSet, msgSetHeaderLen)
+		e      = &entry{}
+		buf    []byte
+	)
+	for pos+msgSetHeaderLen <= end {
+		if _, err := s.reader.ReadAt(header, pos); err != nil {
+			if errors.Cause(err) == ErrCorruptMessage {
+				return pos, nil
+			}
+			return pos, err
+		}
+		size := header.Size()
+		if size < 0 || pos+msgSetHeaderLen+int64(size) > end {
+			return pos, nil
+		}
+		if verify {
+			if cap(buf) < int(size) {
+				buf = make([]byte, size)
+			}
+			buf = buf[:size]
+			if _, err := s.reader.ReadAt(buf, pos+msgSetHeaderLen); err != nil {
+				return pos, err
+			}
+			if verifyMessage(buf, header.Offset()) != nil {
+				return pos, nil
+			}
+		}
+		e.Offset = header.Offset()
+		e.Timestamp = header.Timestamp()
+		e.LeaderEpoch = header.LeaderEpoch()
+		e.Position = pos
+		e.Size = size + msgSetHeaderLen
+		if !fn(e) {
+			return pos, nil
+		}
+		pos += int64(e.Size)
+	}
+	return pos, nil
 }
 
 // Delete closes the segment and then deletes its log and index files.
@@ -439,47 +1023,102 @@ func (s *segment) Delete() error {
 	}
 	s.Lock()
 	defer s.Unlock()
-	if exists(s.log.Name()) {
-		if err := os.Remove(s.log.Name()); err != nil {
+	if exists(s.logPath()) {
+		if err := os.Remove(s.logPath()); err != nil {
+			return err
+		}
+	}
+	if exists(s.indexPath()) {
+		if err := os.Remove(s.indexPath()); err != nil {
 			return err
 		}
 	}
-	if exists(s.Index.Name()) {
-		if err := os.Remove(s.Index.Name()); err != nil {
+	if exists(s.timeIndexPath()) {
+		if err := os.Remove(s.timeIndexPath()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// segmentScanner iterates over the messages in a segment by reading the log,
+// so it does not depend on every message being indexed. It reads message sets
+// into a pooled buffer, which close returns to the pool.
 type segmentScanner struct {
-	s  *segment
-	is *indexScanner
+	s        *segment
+	entry    *entry
+	position int64
+	buf      []byte
 }
 
 func newSegmentScanner(segment *segment) *segmentScanner {
-	return &segmentScanner{s: segment, is: newIndexScanner(segment.Index)}
+	return &segmentScanner{s: segment, entry: &entry{}}
+}
+
+// reset makes the scanner scan the given segment from the start, reusing its
+// buffer.
+func (s *segmentScanner) reset(segment *segment) {
+	s.s = segment
+	s.position = 0
+}
+
+// close returns the scanner's buffer to the pool. The last message set
+// returned by Scan must not be used afterwards.
+func (s *segmentScanner) close() {
+	putBuffer(s.buf)
+	s.buf = nil
 }
 
 // Scan should be called repeatedly to iterate over the messages in the
-// segment, it will return io.EOF when there are no more messages.
+// segment, it will return io.EOF when there are no more messages. It returns
+// an error whose cause is ErrCorruptMessage if the next message does not match
+// its CRC. The returned message set and entry are only valid until the next
+// call to Scan since the scanner reuses them.
 func (s *segmentScanner) Scan() (messageSet, *entry, error) {
-	entry, err := s.is.Scan()
+	end := s.s.Position()
+	if s.position+msgSetHeaderLen > end {
+		return nil, nil, io.EOF
+	}
+	header := s.grow(msgSetHeaderLen)
+	_, err := s.s.ReadAt(header, s.position)
 	if err != nil {
 		return nil, nil, err
 	}
-	header := make(messageSet, msgSetHeaderLen)
-	_, err = s.s.ReadAt(header, entry.Position)
+	size := messageSet(header).Size()
+	if size < 0 || s.position+msgSetHeaderLen+int64(size) > end {
+		return nil, nil, io.EOF
+	}
+	msgSet := s.grow(msgSetHeaderLen + int(size))
+	_, err = s.s.ReadAt(msgSet[msgSetHeaderLen:], s.position+msgSetHeaderLen)
 	if err != nil {
 		return nil, nil, err
 	}
-	payload := make([]byte, header.Size())
-	_, err = s.s.ReadAt(payload, entry.Position+msgSetHeaderLen)
-	if err != nil {
+	if err := verifyMessage(msgSet.Message(), msgSet.Offset()); err != nil {
 		return nil, nil, err
 	}
-	msgSet := append(header, payload...)
-	return msgSet, entry, nil
+	*s.entry = entry{
+		Offset:      msgSet.Offset(),
+		Timestamp:   msgSet.Timestamp(),
+		LeaderEpoch: msgSet.LeaderEpoch(),
+		Position:    s.position,
+		Size:        size + msgSetHeaderLen,
+	}
+	s.position += int64(s.entry.Size)
+	return msgSet, s.entry, nil
+}
+
+// grow returns the scanner's buffer resliced to the given length, keeping its
+// contents. A buffer which is too small is swapped for a larger one from the
+// pool.
+func (s *segmentScanner) grow(size int) messageSet {
+	if cap(s.buf) < size {
+		buf := getBuffer(size)
+		copy(buf, s.buf)
+		putBuffer(s.buf)
+		s.buf = buf
+	}
+	s.buf = s.buf[:size]
+	return s.buf
 }
 
 func (s *segment) logPath() string {
@@ -489,3 +1128,7 @@ func (s *segment) logPath() string {
 func (s *segment) indexPath() string {
 	return filepath.Join(s.path, fmt.Sprintf(fileFormat, s.BaseOffset, indexSuffix+s.suffix))
 }
+
+func (s *segment) timeIndexPath() string {
+	return filepath.Join(s.path, fmt.Sprintf(fileFormat, s.BaseOffset, timeIndexSuffix+s.suffix))
+}
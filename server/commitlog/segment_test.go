@@ -1,7 +1,6 @@
 package commitlog
 
 import (
-	"context"
 	"testing"
 	"time"
 
@@ -17,7 +16,7 @@ func TestSegmentCheckSplitFull(t *testing.T) {
 	s := createSegment(t, dir, 0, 10)
 	require.False(t, s.CheckSplit(1))
 
-	_, err := s.write(make([]byte, 10), []*entry{{}})
+	_, err := s.write([][]byte{make([]byte, 10)}, []*entry{{}})
 	require.NoError(t, err)
 	require.True(t, s.CheckSplit(1))
 }
@@ -46,7 +45,7 @@ func TestSegmentCheckSplitNotFull(t *testing.T) {
 	}()
 
 	s := createSegment(t, dir, 0, 10)
-	_, err := s.write(make([]byte, 5), []*entry{{}})
+	_, err := s.write([][]byte{make([]byte, 5)}, []*entry{{}})
 	require.NoError(t, err)
 	s.firstWriteTime = 1
 	require.False(t, s.CheckSplit(5))
@@ -66,18 +65,12 @@ func TestSegmentCheckSplitLogRollTimeExceeded(t *testing.T) {
 	}()
 
 	s := createSegment(t, dir, 0, 10)
-	_, err := s.write(make([]byte, 5), []*entry{{}})
+	_, err := s.write([][]byte{make([]byte, 5)}, []*entry{{}})
 	require.NoError(t, err)
 	s.firstWriteTime = 1
 	require.True(t, s.CheckSplit(1))
 }
 
-type mockContextReader struct{}
-
-func (m *mockContextReader) Read(ctx context.Context, buf []byte) (int, error) {
-	return 0, nil
-}
-
 // Ensure Seal marks a Segment as sealed, notify waiters, and shrinks the
 // index.
 func TestSegmentSeal(t *testing.T) {
@@ -85,13 +78,13 @@ func TestSegmentSeal(t *testing.T) {
 	defer remove(t, dir)
 
 	s := createSegment(t, dir, 0, 10)
-	// Ensure index file is pre-allocated to 10MB.
+	// Ensure index file is pre-allocated to 10MB past its header.
 	stats, err := s.Index.file.Stat()
 	require.NoError(t, err)
-	require.Equal(t, int64(10485760), stats.Size())
+	require.Equal(t, int64(indexHeaderLen+10485760), stats.Size())
 
 	// Add a waiter.
-	ch := s.WaitForData(&mockContextReader{}, 0)
+	ch := s.WaitForData(0)
 
 	s.Seal()
 
@@ -99,7 +92,7 @@ func TestSegmentSeal(t *testing.T) {
 	// Ensure index was shrunk.
 	stats, err = s.Index.file.Stat()
 	require.NoError(t, err)
-	require.Equal(t, int64(0), stats.Size())
+	require.Equal(t, int64(indexHeaderLen), stats.Size())
 
 	// Ensure waiter is notified.
 	select {
@@ -123,7 +116,7 @@ func TestSegmentSealIdempotent(t *testing.T) {
 	// Ensure index was shrunk.
 	stats, err := s.Index.file.Stat()
 	require.NoError(t, err)
-	require.Equal(t, int64(0), stats.Size())
+	require.Equal(t, int64(indexHeaderLen), stats.Size())
 
 	// Resize the index.
 	require.NoError(t, s.Index.file.Truncate(256))
@@ -147,7 +140,7 @@ func TestSegmentWaitForLEO(t *testing.T) {
 
 	// Channel should be closed immediately if the expected LEO differs from
 	// the actual.
-	waiter := s.WaitForLEO(struct{}{}, 0, 1)
+	waiter := s.WaitForLEO(0, 1)
 	select {
 	case <-waiter:
 	case <-time.After(time.Second):
@@ -160,7 +153,7 @@ func TestSegmentWaitForLEO(t *testing.T) {
 	// Channel should be closed immediately if the expected LEO and actual last
 	// known LEO are the same but the expected differs from the active
 	// segment's last offset.
-	waiter = s.WaitForLEO(struct{}{}, 0, 0)
+	waiter = s.WaitForLEO(0, 0)
 	select {
 	case <-waiter:
 	case <-time.After(time.Second):
@@ -168,7 +161,7 @@ func TestSegmentWaitForLEO(t *testing.T) {
 	}
 
 	// Channel should not be closed until segment is written to.
-	waiter = s.WaitForLEO(struct{}{}, 1, 1)
+	waiter = s.WaitForLEO(1, 1)
 	select {
 	case <-waiter:
 		t.Fatal("Channel was unexpectedly closed")
@@ -185,7 +178,7 @@ func TestSegmentWaitForLEO(t *testing.T) {
 	}
 
 	// Channel should not be closed until segment is sealed.
-	waiter = s.WaitForLEO(struct{}{}, 2, 2)
+	waiter = s.WaitForLEO(2, 2)
 	select {
 	case <-waiter:
 		t.Fatal("Channel was unexpectedly closed")
@@ -204,10 +197,80 @@ func TestSegmentWaitForLEO(t *testing.T) {
 	require.NoError(t, s.WriteMessageSet(make([]byte, 100), []*entry{{Offset: 3, Size: 100}}))
 
 	// Channel should be closed immediately because the segment is full.
-	waiter = s.WaitForLEO(struct{}{}, 3, 3)
+	waiter = s.WaitForLEO(3, 3)
 	select {
 	case <-waiter:
 	case <-time.After(time.Second):
 		t.Fatal("Expected channel to be closed")
 	}
 }
+
+// Ensure all waiters are notified when data is written.
+func TestSegmentWaitForDataMultipleWaiters(t *testing.T) {
+	dir := tempDir(t)
+	defer remove(t, dir)
+
+	s := createSegment(t, dir, 0, 100)
+	waiters := make([]<-chan struct{}, 10)
+	for i := range waiters {
+		waiters[i] = s.WaitForData(0)
+	}
+
+	require.NoError(t, s.WriteMessageSet(make([]byte, 5), []*entry{{Offset: 0, Size: 5}}))
+
+	for _, waiter := range waiters {
+		select {
+		case <-waiter:
+		case <-time.After(time.Second):
+			t.Fatal("Expected waiter to be notified")
+		}
+	}
+
+	// Waiting at a position before the end returns immediately.
+	select {
+	case <-s.WaitForData(0):
+	default:
+		t.Fatal("Expected channel to be closed")
+	}
+}
+
+func BenchmarkSegmentReadAtWhileWriting(b *testing.B) {
+	dir := tempDir(b)
+	defer remove(b, dir)
+
+	s := createSegment(b, dir, 0, 1024*1024*1024)
+	require.NoError(b, s.WriteMessageSet(make([]byte, 1024), []*entry{{Offset: 0, Size: 1024}}))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 100)
+		for offset := int64(1); ; offset++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := s.WriteMessageSet(buf, []*entry{{Offset: offset, Size: 100}}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		buf := make([]byte, 1024)
+		for pb.Next() {
+			if _, err := s.ReadAt(buf, 0); err != nil {
+				b.Error(err)
+				return
+			}
+			s.WaitForData(0)
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
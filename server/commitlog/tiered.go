@@ -0,0 +1,506 @@
+package commitlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	atomic_file "github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+
+	"github.com/liftbridge-io/liftbridge/server/archive"
+)
+
+const (
+	tieredSegmentsFileName = "tiered-segments"
+	tieredSegmentsFileV0   = 0
+
+	// tieredDirName is the directory in the log directory which segments
+	// hydrated from tiered storage are stored in. It's removed when the log
+	// is opened since hydrated segments are only cached.
+	tieredDirName = "tiered"
+)
+
+// TieredStorage offloads the sealed segments of commit logs to an object
+// store before retention deletes them locally and hydrates them when readers
+// request offsets older than the oldest local one. It's shared by the logs of
+// a server, which each store their segments under their own key prefix.
+type TieredStorage struct {
+	*tieredCounters
+	store  archive.Store
+	prefix string
+}
+
+// tieredCounters are the statistics of a TieredStorage, updated atomically.
+// They're shared by the TieredStorages returned by WithStore.
+type tieredCounters struct {
+	uploads              int64
+	uploadedBytes        int64
+	hydrations           int64
+	hydrationErrors      int64
+	hydrationNanos       int64
+	lastHydrationNanos   int64
+	maxHydrationNanos    int64
+	hydratedSegmentBytes int64
+}
+
+// NewTieredStorage returns a TieredStorage which stores segments in the given
+// store under the given key prefix.
+func NewTieredStorage(store archive.Store, prefix string) *TieredStorage {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &TieredStorage{tieredCounters: new(tieredCounters), store: store, prefix: prefix}
+}
+
+// WithStore returns a TieredStorage which stores segments in the given store
+// under the given key prefix and counts them in the statistics of this one.
+func (t *TieredStorage) WithStore(store archive.Store, prefix string) *TieredStorage {
+	other := NewTieredStorage(store, prefix)
+	other.tieredCounters = t.tieredCounters
+	return other
+}
+
+// TieredStats contains the statistics of a TieredStorage.
+type TieredStats struct {
+	Uploads         int64 // Number of segments offloaded
+	UploadedBytes   int64 // Bytes of segments offloaded, including indexes
+	Hydrations      int64 // Number of segments hydrated
+	HydrationErrors int64 // Number of segments which failed to hydrate
+	HydratedBytes   int64 // Bytes of segments currently hydrated
+	// Average, maximum, and last time to download and open a hydrated
+	// segment.
+	HydrationLatency     time.Duration
+	MaxHydrationLatency  time.Duration
+	LastHydrationLatency time.Duration
+}
+
+// Stats returns the statistics of the tiered storage.
+func (t *TieredStorage) Stats() TieredStats {
+	stats := TieredStats{
+		Uploads:              atomic.LoadInt64(&t.uploads),
+		UploadedBytes:        atomic.LoadInt64(&t.uploadedBytes),
+		Hydrations:           atomic.LoadInt64(&t.hydrations),
+		HydrationErrors:      atomic.LoadInt64(&t.hydrationErrors),
+		HydratedBytes:        atomic.LoadInt64(&t.hydratedSegmentBytes),
+		MaxHydrationLatency:  time.Duration(atomic.LoadInt64(&t.maxHydrationNanos)),
+		LastHydrationLatency: time.Duration(atomic.LoadInt64(&t.lastHydrationNanos)),
+	}
+	if stats.Hydrations > 0 {
+		stats.HydrationLatency = time.Duration(atomic.LoadInt64(&t.hydrationNanos) / stats.Hydrations)
+	}
+	return stats
+}
+
+func (t *TieredStorage) recordHydration(latency time.Duration) {
+	atomic.AddInt64(&t.hydrations, 1)
+	atomic.AddInt64(&t.hydrationNanos, int64(latency))
+	atomic.StoreInt64(&t.lastHydrationNanos, int64(latency))
+	for {
+		max := atomic.LoadInt64(&t.maxHydrationNanos)
+		if int64(latency) <= max ||
+			atomic.CompareAndSwapInt64(&t.maxHydrationNanos, max, int64(latency)) {
+			return
+		}
+	}
+}
+
+// tieredSegment is a segment offloaded to tiered storage.
+type tieredSegment struct {
+	BaseOffset  int64
+	FirstOffset int64
+	LastOffset  int64
+}
+
+// hydratedSegment is a segment hydrated from tiered storage.
+type hydratedSegment struct {
+	*segment
+	lastRead int64 // Unix nanoseconds, accessed atomically
+	bytes    int64
+}
+
+// tieredLog tracks the segments of a log which were offloaded to tiered
+// storage and the segments hydrated from it.
+type tieredLog struct {
+	*TieredStorage
+	log      *commitLog
+	prefix   string
+	dir      string
+	mu       sync.Mutex
+	segments []tieredSegment // Sorted by base offset
+	hydrated map[int64]*hydratedSegment
+}
+
+// newTieredLog returns the tieredLog of the given log, reading the segments
+// offloaded from it and removing any segments hydrated before it was opened.
+func newTieredLog(l *commitLog) (*tieredLog, error) {
+	t := &tieredLog{
+		TieredStorage: l.TieredStorage,
+		log:           l,
+		prefix:        l.TieredStorage.prefix + strings.TrimSuffix(l.TieredPrefix, "/") + "/",
+		dir:           filepath.Join(l.Path, tieredDirName),
+		hydrated:      make(map[int64]*hydratedSegment),
+	}
+	if err := os.RemoveAll(t.dir); err != nil {
+		return nil, errors.Wrap(err, "remove hydrated segments failed")
+	}
+	segments, err := readTieredSegments(filepath.Join(l.Path, tieredSegmentsFileName))
+	if err != nil {
+		return nil, err
+	}
+	t.segments = segments
+	return t, nil
+}
+
+// readTieredSegments reads the segments offloaded to tiered storage recorded
+// in the given file, which has the following format:
+//
+// v0:
+// version
+// base_offset first_offset last_offset
+// ...
+func readTieredSegments(file string) ([]tieredSegment, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "open tiered segments file failed")
+	}
+	defer f.Close()
+	var (
+		scanner  = bufio.NewScanner(f)
+		segments []tieredSegment
+	)
+	if !scanner.Scan() {
+		return nil, errors.New("tiered segments file is empty")
+	}
+	if version, err := strconv.Atoi(scanner.Text()); err != nil || version != tieredSegmentsFileV0 {
+		return nil, fmt.Errorf("unsupported tiered segments file version %q", scanner.Text())
+	}
+	for scanner.Scan() {
+		var seg tieredSegment
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d",
+			&seg.BaseOffset, &seg.FirstOffset, &seg.LastOffset); err != nil {
+			return nil, errors.Wrap(err, "invalid tiered segments file")
+		}
+		segments = append(segments, seg)
+	}
+	return segments, scanner.Err()
+}
+
+func (t *tieredLog) writeSegments() error {
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "%d\n", tieredSegmentsFileV0)
+	for _, seg := range t.segments {
+		fmt.Fprintf(b, "%d %d %d\n", seg.BaseOffset, seg.FirstOffset, seg.LastOffset)
+	}
+	return atomic_file.WriteFile(filepath.Join(t.log.Path, tieredSegmentsFileName), b)
+}
+
+func (t *tieredLog) key(baseOffset int64, suffix string) string {
+	return t.prefix + fmt.Sprintf(fileFormat, baseOffset, suffix)
+}
+
+// offload offloads the log's sealed segments which only contain committed
+// messages to tiered storage and evicts the hydrated segments which have not
+// been read for a cleaner interval. Segments with uncommitted messages are not
+// offloaded since they could still be truncated.
+func (l *commitLog) offload(ctx context.Context, segments []*segment) error {
+	hw := l.HighWatermark()
+	sealed := make([]*segment, 0, len(segments))
+	for _, seg := range segments[:len(segments)-1] {
+		if seg.LastOffset() > hw {
+			break
+		}
+		sealed = append(sealed, seg)
+	}
+	if err := l.tiered.offload(ctx, sealed); err != nil {
+		return err
+	}
+	return l.tiered.evict(time.Now().Add(-l.CleanerInterval))
+}
+
+// offload uploads the given sealed segments which have not been offloaded
+// yet. A segment's index is uploaded before its log, and a segment is only
+// recorded as offloaded once both are stored.
+func (t *tieredLog) offload(ctx context.Context, segments []*segment) error {
+	for _, seg := range segments {
+		if seg.IsEmpty() {
+			continue
+		}
+		t.mu.Lock()
+		offloaded := len(t.segments) > 0 && t.segments[len(t.segments)-1].LastOffset >= seg.LastOffset()
+		t.mu.Unlock()
+		if offloaded {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, file := range []struct {
+			path, suffix string
+		}{{seg.indexPath(), indexSuffix}, {seg.logPath(), logSuffix}} {
+			if err := t.upload(ctx, file.path, t.key(seg.BaseOffset, file.suffix)); err != nil {
+				return errors.Wrapf(err, "failed to offload segment %d", seg.BaseOffset)
+			}
+		}
+		t.mu.Lock()
+		t.segments = append(t.segments, tieredSegment{
+			BaseOffset:  seg.BaseOffset,
+			FirstOffset: seg.FirstOffset(),
+			LastOffset:  seg.LastOffset(),
+		})
+		err := t.writeSegments()
+		t.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&t.uploads, 1)
+		t.log.Logger.Debugf("Offloaded segment %d of log %s to tiered storage", seg.BaseOffset, t.log.name)
+	}
+	return nil
+}
+
+func (t *tieredLog) upload(ctx context.Context, path, key string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := t.store.Put(ctx, key, file, info.Size()); err != nil {
+		return err
+	}
+	atomic.AddInt64(&t.uploadedBytes, info.Size())
+	return nil
+}
+
+// find returns the offloaded segment containing the given offset, or the
+// first one after it, which precedes the local log. It returns false if
+// there is none.
+func (t *tieredLog) find(offset, localStart int64) (tieredSegment, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := sort.Search(len(t.segments), func(i int) bool {
+		return t.segments[i].LastOffset >= offset
+	})
+	if i == len(t.segments) || t.segments[i].BaseOffset >= localStart {
+		return tieredSegment{}, false
+	}
+	return t.segments[i], true
+}
+
+// offloaded returns the number of segments at the start of the given ones
+// which were offloaded.
+func (t *tieredLog) offloaded(segments []*segment) int {
+	t.mu.Lock()
+	lastOffset := int64(-1)
+	if len(t.segments) > 0 {
+		lastOffset = t.segments[len(t.segments)-1].LastOffset
+	}
+	t.mu.Unlock()
+	for i, seg := range segments {
+		if seg.LastOffset() > lastOffset {
+			return i
+		}
+	}
+	return len(segments)
+}
+
+// startOffset returns the base offset of the first offloaded segment if it
+// precedes the local log.
+func (t *tieredLog) startOffset(localStart int64) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.segments) == 0 || t.segments[0].BaseOffset >= localStart {
+		return 0, false
+	}
+	return t.segments[0].BaseOffset, true
+}
+
+// earliestOffsetAfter returns the earliest offloaded offset which is greater
+// than or equal to the given offset and precedes the local log. Offsets
+// removed by compaction before a segment was offloaded are not detected.
+func (t *tieredLog) earliestOffsetAfter(offset, localStart int64) (int64, bool) {
+	seg, ok := t.find(offset, localStart)
+	if !ok {
+		return 0, false
+	}
+	if offset < seg.FirstOffset {
+		return seg.FirstOffset, true
+	}
+	return offset, true
+}
+
+// hydrate returns the hydrated segment with the given base offset,
+// downloading it if it's not hydrated yet.
+func (t *tieredLog) hydrate(ctx context.Context, base int64) (*hydratedSegment, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seg, ok := t.hydrated[base]; ok {
+		return seg, nil
+	}
+	start := time.Now()
+	seg, err := t.download(ctx, base)
+	if err != nil {
+		atomic.AddInt64(&t.hydrationErrors, 1)
+		return nil, errors.Wrapf(err, "failed to hydrate segment %d", base)
+	}
+	t.recordHydration(time.Since(start))
+	t.hydrated[base] = seg
+	atomic.AddInt64(&t.hydratedSegmentBytes, seg.bytes)
+	t.log.Logger.Debugf("Hydrated segment %d of log %s from tiered storage in %s",
+		base, t.log.name, time.Since(start))
+	return seg, nil
+}
+
+func (t *tieredLog) download(ctx context.Context, base int64) (*hydratedSegment, error) {
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return nil, err
+	}
+	var size int64
+	for _, suffix := range []string{indexSuffix, logSuffix} {
+		path := filepath.Join(t.dir, fmt.Sprintf(fileFormat, base, suffix))
+		n, err := t.downloadFile(ctx, t.key(base, suffix), path)
+		if err != nil {
+			return nil, err
+		}
+		size += n
+	}
+	seg, err := newSegment(t.dir, base, t.log.MaxSegmentBytes, false, "", false,
+		t.log.IndexIntervalBytes, indexAccess{}, nil, t.log.keys, t.log.MessageFormat)
+	if err != nil {
+		return nil, err
+	}
+	hydrated := &hydratedSegment{segment: seg, bytes: size}
+	hydrated.touch()
+	return hydrated, nil
+}
+
+func (t *tieredLog) downloadFile(ctx context.Context, key, path string) (int64, error) {
+	r, err := t.store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	return n, file.Close()
+}
+
+func (s *hydratedSegment) touch() {
+	atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+}
+
+// evict deletes the hydrated segments which have not been read since the
+// given time, or all of them if it's zero. Readers of an evicted segment
+// hydrate it again.
+func (t *tieredLog) evict(before time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for base, seg := range t.hydrated {
+		if !before.IsZero() && atomic.LoadInt64(&seg.lastRead) >= before.UnixNano() {
+			continue
+		}
+		// Mark the segment replaced so that its readers reinitialize.
+		seg.Lock()
+		seg.replaced = true
+		seg.Unlock()
+		if err := seg.Delete(); err != nil {
+			return err
+		}
+		delete(t.hydrated, base)
+		atomic.AddInt64(&t.hydratedSegmentBytes, -seg.bytes)
+	}
+	return nil
+}
+
+// newContextReader returns a contextReader which reads the log starting at
+// the given offset. If the offset precedes the local log and was offloaded to
+// tiered storage, the reader reads the hydrated segments containing it and
+// then continues with the local log.
+func (l *commitLog) newContextReader(offset int64, uncommitted bool) (contextReader, error) {
+	if l.tiered != nil {
+		if seg, ok := l.tiered.find(offset, l.localStartOffset()); ok {
+			return l.newTieredReader(offset, seg.BaseOffset, uncommitted)
+		}
+	}
+	if uncommitted {
+		return l.newReaderUncommitted(offset)
+	}
+	return l.newReaderCommitted(offset)
+}
+
+// tieredReader reads a segment hydrated from tiered storage and then the
+// segments following it.
+type tieredReader struct {
+	cl          *commitLog
+	seg         *hydratedSegment
+	pos         int64
+	uncommitted bool
+	next        contextReader // Reads the following segments once seg is read
+}
+
+func (l *commitLog) newTieredReader(offset, base int64, uncommitted bool) (contextReader, error) {
+	seg, err := l.tiered.hydrate(context.Background(), base)
+	if err != nil {
+		return nil, err
+	}
+	position := int64(0)
+	if offset > seg.FirstOffset() {
+		e, err := seg.findEntry(offset)
+		if err != nil {
+			return nil, err
+		}
+		position = e.Position
+	}
+	return &tieredReader{cl: l, seg: seg, pos: position, uncommitted: uncommitted}, nil
+}
+
+func (r *tieredReader) Read(ctx context.Context, p []byte) (int, error) {
+	if r.next != nil {
+		return r.next.Read(ctx, p)
+	}
+	if ctx.Err() != nil {
+		return 0, io.EOF
+	}
+	if r.pos >= r.seg.Position() {
+		// The hydrated segment has been read, so continue with the segments
+		// following it, which are read as messages start on segment
+		// boundaries.
+		next, err := r.cl.newContextReader(r.seg.LastOffset()+1, r.uncommitted)
+		if err != nil {
+			return 0, err
+		}
+		r.next = next
+		return next.Read(ctx, p)
+	}
+	r.seg.touch()
+	n, err := r.seg.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
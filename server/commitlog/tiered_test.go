@@ -0,0 +1,123 @@
+package commitlog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liftbridge-io/liftbridge/server/archive"
+)
+
+// Ensure sealed segments are offloaded to tiered storage before retention
+// deletes them and are hydrated when reading offsets older than the log.
+func TestTieredStorage(t *testing.T) {
+	storeDir := tempDir(t)
+	defer remove(t, storeDir)
+	tiered := NewTieredStorage(archive.NewDirStore(storeDir), "tiered")
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 150,
+		MaxLogMessages:  5,
+		TieredStorage:   tiered,
+		TieredPrefix:    "foo/0",
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	for i := 0; i < 20; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i + 1)}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(l.NewestOffset())
+	require.NoError(t, l.Clean(context.Background()))
+	require.True(t, l.OldestOffset() > 0)
+	// Offloaded offsets are still part of the log.
+	require.Equal(t, int64(0), l.LogStartOffset())
+	earliest, err := l.EarliestOffsetAfter(1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), earliest)
+	stats := tiered.Stats()
+	require.True(t, stats.Uploads > 0)
+	require.True(t, stats.UploadedBytes > 0)
+	require.Equal(t, int64(0), stats.Hydrations)
+
+	read := func(l *commitLog, start int64) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r, err := l.NewReader(start, false)
+		require.NoError(t, err)
+		headers := make([]byte, 28)
+		for i := start; i < 20; i++ {
+			msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+			require.NoError(t, err)
+			require.Equal(t, i, offset)
+			require.Equal(t, strconv.FormatInt(i, 10), string(msg.Value()))
+		}
+	}
+
+	// Reading from the start hydrates every offloaded segment and then reads
+	// the local log.
+	read(l, 0)
+	stats = tiered.Stats()
+	require.Equal(t, stats.Uploads, stats.Hydrations)
+	require.Equal(t, int64(0), stats.HydrationErrors)
+	require.True(t, stats.HydratedBytes > 0)
+	require.True(t, stats.MaxHydrationLatency >= stats.HydrationLatency)
+
+	// Reading an offset in the middle of a hydrated segment reuses it.
+	read(l, 1)
+	require.Equal(t, stats.Hydrations, tiered.Stats().Hydrations)
+
+	// Evicted segments are hydrated again.
+	require.NoError(t, l.tiered.evict(time.Now()))
+	require.Equal(t, int64(0), tiered.Stats().HydratedBytes)
+	read(l, 1)
+	require.True(t, tiered.Stats().Hydrations > stats.Hydrations)
+
+	// Offloaded segments are remembered when the log is reopened, while
+	// hydrated segments are removed.
+	require.NoError(t, l.Close())
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	require.Equal(t, int64(0), l.LogStartOffset())
+	_, err = os.Stat(filepath.Join(opts.Path, tieredDirName))
+	require.True(t, os.IsNotExist(err))
+	read(l, 0)
+}
+
+// Ensure segments with uncommitted messages are not offloaded and retention
+// keeps segments until they are offloaded.
+func TestTieredStorageUncommitted(t *testing.T) {
+	storeDir := tempDir(t)
+	defer remove(t, storeDir)
+	tiered := NewTieredStorage(archive.NewDirStore(storeDir), "")
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 150,
+		MaxLogMessages:  5,
+		TieredStorage:   tiered,
+		TieredPrefix:    "foo/0",
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	for i := 0; i < 20; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i + 1)}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Clean(context.Background()))
+	require.Equal(t, int64(0), tiered.Stats().Uploads)
+	require.Equal(t, int64(0), l.OldestOffset())
+
+	first := l.Segments()[0]
+	l.SetHighWatermark(first.LastOffset())
+	require.NoError(t, l.Clean(context.Background()))
+	require.Equal(t, int64(1), tiered.Stats().Uploads)
+	require.Equal(t, first.LastOffset()+1, l.OldestOffset())
+	require.Equal(t, int64(0), l.LogStartOffset())
+}
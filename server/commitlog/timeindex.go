@@ -0,0 +1,370 @@
+package commitlog
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+const (
+	timeIndexSuffix = ".timeindex"
+
+	// timeEntryWidth is the width of a time index entry: timestamp (8 bytes)
+	// relative offset (4 bytes) position (4 bytes).
+	timeEntryWidth     = timestampWidth + offsetWidth + positionWidth
+	timeIndexHeaderLen = timeEntryWidth
+
+	// minTimeIndexInterval is the minimum number of log bytes between time
+	// index entries, which keeps the time index sparse and its writes rare
+	// when every message is added to the offset index.
+	minTimeIndexInterval = 4096
+)
+
+var timeIndexMagic = []byte("LBTI")
+
+// timeEntry maps the largest timestamp of the messages up to and including
+// the message at Offset to that message's position in the log. Since it holds
+// the largest timestamp so far, entries are ordered by timestamp even if
+// message timestamps are not.
+type timeEntry struct {
+	Timestamp int64
+	Offset    int64
+	Position  int64
+}
+
+// timeIndex is a sparse index of a segment's messages by timestamp, kept in a
+// file alongside the offset index. An entry is added when the largest
+// timestamp in the segment grows, at most every indexInterval bytes of the
+// log and no more often than every minTimeIndexInterval bytes, so the
+// messages before an entry's offset all have timestamps no larger than the
+// entry's. It's small, so it's read and written with positional I/O rather
+// than memory-mapped.
+type timeIndex struct {
+	file       *os.File
+	baseOffset int64
+	mu         sync.RWMutex
+	entries    int64
+	last       timeEntry // Last entry, if there are any
+	closed     bool
+}
+
+// openTimeIndex opens the time index file at the given path, creating it if
+// it doesn't exist. The returned bool is false if the time index has to be
+// rebuilt from the log because it was created or has no valid header, e.g.
+// because the segment was written before time indexes existed.
+func openTimeIndex(path string, baseOffset int64) (*timeIndex, bool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "open file failed")
+	}
+	ti := &timeIndex{file: file, baseOffset: baseOffset}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close() // nolint: errcheck
+		return nil, false, errors.Wrap(err, "stat file failed")
+	}
+	size := info.Size()
+	format, headerLen, err := readFileHeader(file, size, timeIndexMagic, timeIndexHeaderLen)
+	if err != nil {
+		file.Close() // nolint: errcheck
+		return nil, false, err
+	}
+	if format == FormatV0 || headerLen == 0 {
+		return ti, false, ti.reset()
+	}
+	// Drop a partially written last entry.
+	ti.entries = (size - timeIndexHeaderLen) / timeEntryWidth
+	if err := ti.truncateEntries(ti.entries); err != nil {
+		file.Close() // nolint: errcheck
+		return nil, false, err
+	}
+	return ti, true, nil
+}
+
+// reset removes every entry from the time index.
+func (ti *timeIndex) reset() error {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if err := ti.file.Truncate(0); err != nil {
+		return errors.Wrap(err, "truncate file failed")
+	}
+	if _, err := ti.file.WriteAt(newFileHeader(timeIndexMagic, timeIndexHeaderLen), 0); err != nil {
+		return errors.Wrap(err, "write header failed")
+	}
+	ti.entries = 0
+	return nil
+}
+
+// truncateEntries removes the entries past the first n.
+func (ti *timeIndex) truncateEntries(n int64) error {
+	if err := ti.file.Truncate(timeIndexHeaderLen + n*timeEntryWidth); err != nil {
+		return errors.Wrap(err, "truncate file failed")
+	}
+	ti.entries = n
+	if n == 0 {
+		return nil
+	}
+	return ti.readEntry(&ti.last, n-1)
+}
+
+// truncate removes the entries of messages past the given offset as well as
+// trailing entries which are not ordered, e.g. because they were partially
+// written before a crash.
+func (ti *timeIndex) truncate(lastOffset int64) error {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	var e timeEntry
+	n := int64(sort.Search(int(ti.entries), func(i int) bool {
+		if err := ti.readEntry(&e, int64(i)); err != nil {
+			return true
+		}
+		return e.Offset > lastOffset
+	}))
+	for ; n > 0; n-- {
+		if err := ti.readEntry(&e, n-1); err != nil {
+			return err
+		}
+		if e.Offset < ti.baseOffset || e.Position < 0 {
+			continue
+		}
+		if n == 1 {
+			break
+		}
+		var prev timeEntry
+		if err := ti.readEntry(&prev, n-2); err != nil {
+			return err
+		}
+		if prev.Timestamp <= e.Timestamp && prev.Offset < e.Offset && prev.Position <= e.Position {
+			break
+		}
+	}
+	if n == ti.entries {
+		return nil
+	}
+	return ti.truncateEntries(n)
+}
+
+// append adds the given entries to the end of the time index.
+func (ti *timeIndex) append(entries []timeEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	b := make([]byte, len(entries)*timeEntryWidth)
+	for i, e := range entries {
+		p := b[i*timeEntryWidth:]
+		proto.Encoding.PutUint64(p, uint64(e.Timestamp))
+		proto.Encoding.PutUint32(p[timestampWidth:], uint32(e.Offset-ti.baseOffset))
+		proto.Encoding.PutUint32(p[timestampWidth+offsetWidth:], uint32(e.Position))
+	}
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if ti.closed {
+		return ErrSegmentClosed
+	}
+	if _, err := ti.file.WriteAt(b, timeIndexHeaderLen+ti.entries*timeEntryWidth); err != nil {
+		return errors.Wrap(err, "time index write failed")
+	}
+	ti.entries += int64(len(entries))
+	ti.last = entries[len(entries)-1]
+	return nil
+}
+
+// lastEntry returns the last entry of the time index. It returns false if the
+// time index is empty.
+func (ti *timeIndex) lastEntry() (timeEntry, bool) {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	return ti.last, ti.entries > 0
+}
+
+// lookup returns the last entry whose timestamp is less than the given
+// timestamp, so the first message with a timestamp at least as large follows
+// the entry's offset. It returns false if there is no such entry, in which
+// case the message may be the first of the segment.
+func (ti *timeIndex) lookup(timestamp int64) (timeEntry, bool, error) {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	if ti.closed {
+		return timeEntry{}, false, ErrSegmentClosed
+	}
+	var (
+		e   timeEntry
+		err error
+	)
+	i := sort.Search(int(ti.entries), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		err = ti.readEntry(&e, int64(i))
+		return err != nil || e.Timestamp >= timestamp
+	})
+	if err != nil || i == 0 {
+		return timeEntry{}, false, err
+	}
+	err = ti.readEntry(&e, int64(i-1))
+	return e, err == nil, err
+}
+
+// readEntries returns the entries of the time index.
+func (ti *timeIndex) readEntries() ([]timeEntry, error) {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	if ti.closed {
+		return nil, ErrSegmentClosed
+	}
+	entries := make([]timeEntry, ti.entries)
+	for i := range entries {
+		if err := ti.readEntry(&entries[i], int64(i)); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// readEntry reads the entry at the given index into e.
+func (ti *timeIndex) readEntry(e *timeEntry, i int64) error {
+	var p [timeEntryWidth]byte
+	if _, err := ti.file.ReadAt(p[:], timeIndexHeaderLen+i*timeEntryWidth); err != nil {
+		return errors.Wrap(err, "time index read failed")
+	}
+	e.Timestamp = int64(proto.Encoding.Uint64(p[:]))
+	e.Offset = ti.baseOffset + int64(proto.Encoding.Uint32(p[timestampWidth:]))
+	e.Position = int64(int32(proto.Encoding.Uint32(p[timestampWidth+offsetWidth:])))
+	return nil
+}
+
+// Sync flushes the time index to disk.
+func (ti *timeIndex) Sync() error {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	if ti.closed {
+		return ErrSegmentClosed
+	}
+	if err := ti.file.Sync(); err != nil {
+		return errors.Wrap(err, "file sync failed")
+	}
+	return nil
+}
+
+// Close flushes the time index to disk and closes it.
+func (ti *timeIndex) Close() error {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if ti.closed {
+		return nil
+	}
+	if err := ti.file.Sync(); err != nil {
+		return errors.Wrap(err, "file sync failed")
+	}
+	if err := ti.file.Close(); err != nil {
+		return err
+	}
+	ti.closed = true
+	return nil
+}
+
+// timeEntries returns the entries to add to the time index for the given
+// messages and updates the segment's largest timestamp. An entry is added for
+// a message if it raises the largest timestamp past that of the last entry
+// and it's far enough past the last entry's message. The caller must hold
+// writeMu or have exclusive access to the segment.
+func (s *segment) timeEntries(entries []*entry) []timeEntry {
+	var (
+		added    []timeEntry
+		max      = atomic.LoadInt64(&s.maxTimestamp)
+		last, ok = s.TimeIndex.lastEntry()
+		interval = s.indexInterval
+	)
+	if interval < minTimeIndexInterval {
+		interval = minTimeIndexInterval
+	}
+	for _, e := range entries {
+		if e.Timestamp > max {
+			max = e.Timestamp
+		}
+		if ok && (max <= last.Timestamp || e.Position-last.Position < interval) {
+			continue
+		}
+		last = timeEntry{Timestamp: max, Offset: e.Offset, Position: e.Position}
+		added = append(added, last)
+		ok = true
+	}
+	atomic.StoreInt64(&s.maxTimestamp, max)
+	return added
+}
+
+// setupTimeIndex opens the segment's time index, rebuilding it from the log
+// if it doesn't exist, and initializes the segment's largest timestamp. Entries
+// past the end of the log, e.g. of messages truncated during recovery, are
+// removed. The index and log must be set up.
+func (s *segment) setupTimeIndex() error {
+	ti, valid, err := openTimeIndex(s.timeIndexPath(), s.BaseOffset)
+	if err != nil {
+		return err
+	}
+	s.TimeIndex = ti
+	atomic.StoreInt64(&s.maxTimestamp, -1)
+	lastOffset := atomic.LoadInt64(&s.lastOffset)
+	if valid {
+		if err := ti.truncate(lastOffset); err != nil {
+			return err
+		}
+	}
+	last, ok := ti.lastEntry()
+	if ok {
+		atomic.StoreInt64(&s.maxTimestamp, last.Timestamp)
+		if last.Offset == lastOffset {
+			return nil
+		}
+	}
+	// Index the messages following the last entry, which is all of them if
+	// the time index is being rebuilt.
+	var pos, after int64 = 0, -1
+	if ok {
+		pos, after = last.Position, last.Offset
+	}
+	var appendErr error
+	_, err = s.scanLog(pos, atomic.LoadInt64(&s.position), false, func(e *entry) bool {
+		if e.Offset > after {
+			appendErr = ti.append(s.timeEntries([]*entry{e}))
+		}
+		return appendErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return appendErr
+}
+
+// sealTimeIndex adds an entry for the segment's last message to its time
+// index, unless it has one, so that the largest timestamp of the sealed
+// segment is known without scanning its log when it's opened again. The
+// caller must hold the segment lock.
+func (s *segment) sealTimeIndex() {
+	lastOffset := atomic.LoadInt64(&s.lastOffset)
+	if s.TimeIndex == nil || lastOffset == -1 {
+		return
+	}
+	if last, ok := s.TimeIndex.lastEntry(); ok && last.Offset == lastOffset {
+		return
+	}
+	// There are no messages past the entry, so it points at the end of the
+	// log.
+	s.TimeIndex.append([]timeEntry{{ // nolint: errcheck
+		Timestamp: atomic.LoadInt64(&s.maxTimestamp),
+		Offset:    lastOffset,
+		Position:  atomic.LoadInt64(&s.position),
+	}})
+}
+
+// MaxTimestamp returns the largest timestamp of the segment's messages, or -1
+// if it's empty.
+func (s *segment) MaxTimestamp() int64 {
+	s.load() // nolint: errcheck
+	return atomic.LoadInt64(&s.maxTimestamp)
+}
@@ -0,0 +1,322 @@
+//go:build linux
+// +build linux
+
+package commitlog
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// io_uring constants from linux/io_uring.h.
+const (
+	ioringOpReadv         = 1
+	ioringOpWritev        = 2
+	ioringEnterGetEvents  = 1 << 0
+	ioringOffSQRing       = 0
+	ioringOffCQRing       = 0x8000000
+	ioringOffSQEs         = 0x10000000
+	ioUringEntries        = 4
+	ioUringSQESize        = 64
+	ioUringCQESize        = 16
+	ioUringMaxRingsFactor = 2
+)
+
+// ioUringParams mirrors struct io_uring_params.
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+// ioSQRingOffsets mirrors struct io_sqring_offsets.
+type ioSQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+// ioCQRingOffsets mirrors struct io_cqring_offsets.
+type ioCQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+// ioUringSQE mirrors struct io_uring_sqe.
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	pad         [2]uint64
+}
+
+// ioUringCQE mirrors struct io_uring_cqe.
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ioUring is a submission and completion queue pair. A ring is used by one
+// goroutine at a time, which submits a single request and waits for its
+// completion.
+type ioUring struct {
+	fd     int
+	sqRing []byte
+	cqRing []byte
+	sqes   []byte
+	params ioUringParams
+	iovecs []unix.Iovec
+}
+
+func newIOUring() (*ioUring, error) {
+	r := &ioUring{}
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, ioUringEntries,
+		uintptr(unsafe.Pointer(&r.params)), 0)
+	if errno != 0 {
+		return nil, errors.Wrap(errno, "io_uring_setup failed")
+	}
+	r.fd = int(fd)
+	var err error
+	r.sqRing, err = unix.Mmap(r.fd, ioringOffSQRing,
+		int(r.params.sqOff.array+r.params.sqEntries*4),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, errors.Wrap(err, "mmap io_uring submission queue failed")
+	}
+	r.cqRing, err = unix.Mmap(r.fd, ioringOffCQRing,
+		int(r.params.cqOff.cqes+r.params.cqEntries*ioUringCQESize),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, errors.Wrap(err, "mmap io_uring completion queue failed")
+	}
+	r.sqes, err = unix.Mmap(r.fd, ioringOffSQEs,
+		int(r.params.sqEntries*ioUringSQESize),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		r.close()
+		return nil, errors.Wrap(err, "mmap io_uring submission entries failed")
+	}
+	return r, nil
+}
+
+func (r *ioUring) uint32At(ring []byte, off uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&ring[off]))
+}
+
+// do submits a readv or writev of the buffers at the given file offset and
+// waits for its completion, returning the number of bytes transferred. The
+// buffers must not be empty.
+func (r *ioUring) do(opcode uint8, fd uintptr, bufs [][]byte, off int64) (int, error) {
+	r.iovecs = r.iovecs[:0]
+	for _, p := range bufs {
+		iovec := unix.Iovec{Base: &p[0]}
+		iovec.SetLen(len(p))
+		r.iovecs = append(r.iovecs, iovec)
+	}
+
+	var (
+		sqTail = r.uint32At(r.sqRing, r.params.sqOff.tail)
+		sqMask = *r.uint32At(r.sqRing, r.params.sqOff.ringMask)
+		tail   = atomic.LoadUint32(sqTail)
+		idx    = tail & sqMask
+		sqe    = (*ioUringSQE)(unsafe.Pointer(&r.sqes[idx*ioUringSQESize]))
+	)
+	*sqe = ioUringSQE{
+		opcode: opcode,
+		fd:     int32(fd),
+		off:    uint64(off),
+		addr:   uint64(uintptr(unsafe.Pointer(&r.iovecs[0]))),
+		len:    uint32(len(r.iovecs)),
+	}
+	*r.uint32At(r.sqRing, r.params.sqOff.array+idx*4) = idx
+	atomic.StoreUint32(sqTail, tail+1)
+
+	var (
+		cqHead   = r.uint32At(r.cqRing, r.params.cqOff.head)
+		cqTail   = r.uint32At(r.cqRing, r.params.cqOff.tail)
+		cqMask   = *r.uint32At(r.cqRing, r.params.cqOff.ringMask)
+		toSubmit = uintptr(1)
+	)
+	for {
+		head := atomic.LoadUint32(cqHead)
+		if head != atomic.LoadUint32(cqTail) {
+			cqe := (*ioUringCQE)(unsafe.Pointer(
+				&r.cqRing[r.params.cqOff.cqes+(head&cqMask)*ioUringCQESize]))
+			res := cqe.res
+			atomic.StoreUint32(cqHead, head+1)
+			runtime.KeepAlive(bufs)
+			if res < 0 {
+				return 0, unix.Errno(-res)
+			}
+			return int(res), nil
+		}
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd),
+			toSubmit, 1, ioringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != unix.EINTR {
+			return 0, errors.Wrap(errno, "io_uring_enter failed")
+		}
+		if errno == 0 {
+			toSubmit = 0
+		}
+	}
+}
+
+func (r *ioUring) close() {
+	for _, m := range [][]byte{r.sqRing, r.cqRing, r.sqes} {
+		if m != nil {
+			unix.Munmap(m) // nolint: errcheck
+		}
+	}
+	unix.Close(r.fd) // nolint: errcheck
+}
+
+// ioUrings holds the rings shared by all io_uring segment files. There are
+// enough rings for reads and writes from every processor to proceed without
+// waiting for each other.
+var ioUrings struct {
+	once  sync.Once
+	err   error
+	rings chan *ioUring
+}
+
+// ioUringSupported sets up the shared rings the first time it is called and
+// returns an error if segment files cannot use io_uring, e.g. because the
+// kernel does not support it or a seccomp profile does not permit it.
+func ioUringSupported() error {
+	ioUrings.once.Do(func() {
+		n := runtime.GOMAXPROCS(0) * ioUringMaxRingsFactor
+		ioUrings.rings = make(chan *ioUring, n)
+		for i := 0; i < n; i++ {
+			r, err := newIOUring()
+			if err != nil {
+				ioUrings.err = err
+				close(ioUrings.rings)
+				for r := range ioUrings.rings {
+					r.close()
+				}
+				return
+			}
+			ioUrings.rings <- r
+		}
+	})
+	return ioUrings.err
+}
+
+// ioUringFile reads and writes a segment's log file using io_uring. Appends
+// rely on the file being opened with O_APPEND.
+type ioUringFile struct {
+	file *os.File
+}
+
+// Write writes p to the end of the file.
+func (f *ioUringFile) Write(p []byte) (int, error) {
+	return f.WriteBuffers([][]byte{p})
+}
+
+// WriteBuffers writes the buffers to the end of the file with vectored
+// writes.
+func (f *ioUringFile) WriteBuffers(bufs [][]byte) (int, error) {
+	return writeAllBuffers(bufs, func(bufs [][]byte) (int, error) {
+		return f.do(ioringOpWritev, bufs, 0)
+	})
+}
+
+// ReadAt reads len(p) bytes from the file starting at offset off. It
+// returns io.EOF if the file ends before p is filled.
+func (f *ioUringFile) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for read < len(p) {
+		n, err := f.do(ioringOpReadv, [][]byte{p[read:]}, off+int64(read))
+		read += n
+		if err != nil {
+			return read, err
+		}
+		if n == 0 {
+			return read, io.EOF
+		}
+	}
+	return read, nil
+}
+
+func (f *ioUringFile) do(opcode uint8, bufs [][]byte, off int64) (int, error) {
+	r := <-ioUrings.rings
+	n, err := r.do(opcode, f.file.Fd(), bufs, off)
+	ioUrings.rings <- r
+	if err != nil {
+		return n, &os.PathError{Op: opName(opcode), Path: f.file.Name(), Err: err}
+	}
+	return n, nil
+}
+
+func opName(opcode uint8) string {
+	if opcode == ioringOpWritev {
+		return "write"
+	}
+	return "read"
+}
+
+// vectoredFile is a segment log file which writes several buffers with a
+// single writev system call.
+type vectoredFile struct {
+	*os.File
+}
+
+// WriteBuffers writes the buffers to the file with vectored writes.
+func (f vectoredFile) WriteBuffers(bufs [][]byte) (int, error) {
+	return writeAllBuffers(bufs, func(bufs [][]byte) (int, error) {
+		n, err := unix.Writev(int(f.Fd()), bufs)
+		if err != nil {
+			return n, &os.PathError{Op: "write", Path: f.Name(), Err: err}
+		}
+		return n, nil
+	})
+}
+
+// newSegmentFileIO returns the writer and reader used for a segment's log
+// file, which use io_uring if requested and supported.
+func newSegmentFileIO(file *os.File, ioUring bool) (io.Writer, io.ReaderAt) {
+	if !ioUring || ioUringSupported() != nil {
+		return vectoredFile{file}, file
+	}
+	f := &ioUringFile{file: file}
+	return f, f
+}
@@ -0,0 +1,89 @@
+//go:build linux
+// +build linux
+
+package commitlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure logs using io_uring can be written, read, and recovered.
+func TestIOUringCommitLog(t *testing.T) {
+	if err := ioUringSupported(); err != nil {
+		t.Skipf("io_uring not supported: %v", err)
+	}
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 256,
+		IOUring:         true,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	_, ok := l.activeSegment().writer.(*ioUringFile)
+	require.True(t, ok)
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append(msgs)
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 1)
+
+	readAll := func(l *commitLog) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r, err := l.NewReader(0, true)
+		require.NoError(t, err)
+		headers := make([]byte, 28)
+		for i := 0; i < 10*len(msgs); i++ {
+			msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+			require.NoError(t, err)
+			require.Equal(t, int64(i), offset)
+			compareMessages(t, msgs[i%len(msgs)], msg)
+		}
+	}
+	readAll(l)
+
+	require.NoError(t, l.Close())
+	recovered, err := New(opts)
+	require.NoError(t, err)
+	defer recovered.Close()
+	readAll(recovered.(*commitLog))
+}
+
+func BenchmarkSegmentReadAt(b *testing.B) {
+	for _, test := range []struct {
+		name    string
+		ioUring bool
+	}{
+		{"std", false},
+		{"io_uring", true},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			if test.ioUring {
+				if err := ioUringSupported(); err != nil {
+					b.Skipf("io_uring not supported: %v", err)
+				}
+			}
+			l, cleanup := setupWithOptions(b, Options{Path: tempDir(b), IOUring: test.ioUring})
+			defer cleanup()
+			defer l.Close()
+			for i := 0; i < 100; i++ {
+				_, err := l.Append(msgs)
+				require.NoError(b, err)
+			}
+			var (
+				seg = l.activeSegment()
+				buf = make([]byte, seg.Position())
+			)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := seg.ReadAt(buf, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package commitlog
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ioUringSupported returns an error since io_uring is only available on
+// Linux.
+func ioUringSupported() error {
+	return errors.New("io_uring is only supported on Linux")
+}
+
+// newSegmentFileIO returns the writer and reader used for a segment's log
+// file, which is always the file itself outside of Linux.
+func newSegmentFileIO(file *os.File, ioUring bool) (io.Writer, io.ReaderAt) {
+	return file, file
+}
@@ -43,13 +43,12 @@ func findSegmentIndexByTimestamp(segments []*segment, timestamp int64) (int, err
 		err error
 	)
 	idx := sort.Search(n, func(i int) bool {
-		// Read the first entry in the segment to determine the base timestamp.
-		var entry entry
-		if e := segments[i].Index.ReadEntryAtLogOffset(&entry, 0); e != nil {
+		first, e := segments[i].firstTimestamp()
+		if e != nil {
 			err = e
 			return true
 		}
-		return entry.Timestamp > timestamp
+		return first > timestamp
 	})
 	return idx, err
 }
@@ -7,12 +7,16 @@ import (
 )
 
 func TestFindSegment(t *testing.T) {
+	dir := tempDir(t)
+	defer remove(t, dir)
+
+	// The segments are marked loaded so they are not opened from disk.
 	segments := []*segment{
-		{BaseOffset: 0, lastOffset: 9},
-		{BaseOffset: 10, lastOffset: 19},
-		{BaseOffset: 20, lastOffset: 29},
-		{BaseOffset: 30, lastOffset: 39},
-		{BaseOffset: 40, lastOffset: 49},
+		{BaseOffset: 0, lastOffset: 9, path: dir, loaded: 1},
+		{BaseOffset: 10, lastOffset: 19, path: dir, loaded: 1},
+		{BaseOffset: 20, lastOffset: 29, path: dir, loaded: 1},
+		{BaseOffset: 30, lastOffset: 39, path: dir, loaded: 1},
+		{BaseOffset: 40, lastOffset: 49, path: dir, loaded: 1},
 	}
 	seg, idx := findSegment(segments, 0)
 	require.Equal(t, 0, idx)
@@ -0,0 +1,455 @@
+package commitlog
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// LogIssueKind is the kind of a problem found in a log by VerifyLog.
+type LogIssueKind string
+
+const (
+	// IssueTruncatedMessage is a message which extends past the end of its
+	// segment's log, e.g. because it was partially written before a crash.
+	IssueTruncatedMessage LogIssueKind = "truncated_message"
+
+	// IssueCorruptMessage is a message which can't be read, has an invalid
+	// size or does not match its CRC.
+	IssueCorruptMessage LogIssueKind = "corrupt_message"
+
+	// IssueInvalidOffset is a message whose offset is before its segment's
+	// base offset or not greater than the offset of the message before it.
+	IssueInvalidOffset LogIssueKind = "invalid_offset"
+
+	// IssueOffsetGap is a gap between the offsets of consecutive messages of
+	// a log which is not compacted.
+	IssueOffsetGap LogIssueKind = "offset_gap"
+
+	// IssueIndexMismatch is an entry of a segment's offset index which does
+	// not match the message at its position, or an empty offset index for a
+	// segment with messages.
+	IssueIndexMismatch LogIssueKind = "index_mismatch"
+
+	// IssueTimeIndexMismatch is an entry of a segment's time index which does
+	// not match the message at its position or the largest timestamp of the
+	// messages up to it.
+	IssueTimeIndexMismatch LogIssueKind = "time_index_mismatch"
+
+	// IssueUnreadableSegment is a segment which can't be opened, e.g. because
+	// its log is encrypted with a key which is not available.
+	IssueUnreadableSegment LogIssueKind = "unreadable_segment"
+)
+
+// maxLogIssues is the maximum number of issues a LogReport lists. Further
+// issues are only counted.
+const maxLogIssues = 1000
+
+// verifyCheckInterval is the number of messages verified between checks of
+// the context.
+const verifyCheckInterval = 1024
+
+// LogIssue is a problem found in a log by VerifyLog.
+type LogIssue struct {
+	Kind LogIssueKind
+	// Segment is the base offset of the segment the issue was found in.
+	Segment int64
+	// Offset is the offset of the message or index entry with the issue, or
+	// -1 if it's not known.
+	Offset int64
+	// Position is the position in the segment's log of the message or index
+	// entry with the issue, or -1 if it concerns the whole segment.
+	Position int64
+	Message  string
+}
+
+// LogReport is the result of verifying a log with VerifyLog or RepairLog.
+type LogReport struct {
+	// Segments is the number of segments verified. Segments removed or
+	// replaced while they were verified, e.g. by retention or compaction, are
+	// skipped.
+	Segments int
+	// Messages is the number of valid messages found.
+	Messages int64
+	Issues   []LogIssue
+	// OmittedIssues is the number of issues found past the first
+	// maxLogIssues, which are not listed.
+	OmittedIssues int
+	// LastValidOffset is the offset of the last message before the first
+	// truncated, corrupt or out of order message, or -1 if there is none.
+	// RepairLog truncates the log after it.
+	LastValidOffset int64
+	// Repaired indicates if RepairLog changed the log.
+	Repaired bool
+}
+
+// logVerifier verifies the segments of a log in order.
+type logVerifier struct {
+	ctx     context.Context
+	report  *LogReport
+	compact bool
+	prev    int64 // Offset of the previous message, -1 if none
+	last    int64 // Offset of the last message before the invalid one
+	// invalidSeg is the segment of the first message which is truncated,
+	// corrupt or out of order, if any, and invalidPos its position.
+	invalidSeg *segment
+	invalidPos int64
+	// rebuild is the set of segments whose indexes must be rebuilt.
+	rebuild map[*segment]bool
+}
+
+// VerifyLog scans the log's segments and indexes for truncated or corrupt
+// messages, offsets which are out of order or, unless the log is compacted,
+// have gaps, and index entries which don't match the log. It can be called
+// while the log is in use and doesn't change it.
+func (l *commitLog) VerifyLog(ctx context.Context) (*LogReport, error) {
+	v, err := l.verify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+// RepairLog verifies the log like VerifyLog and repairs the issues found:
+// the indexes of segments which don't match their logs are rebuilt and the
+// log is truncated before the first message which is truncated, corrupt or
+// out of order. The HW is lowered to the last remaining message if it's past
+// it. Offset gaps and unreadable segments are only reported. Appends are
+// blocked while the log is repaired.
+func (l *commitLog) RepairLog(ctx context.Context) (*LogReport, error) {
+	l.appendMu.Lock()
+	defer l.appendMu.Unlock()
+	v, err := l.verify(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v.invalidSeg == nil && len(v.rebuild) == 0 {
+		return v.report, nil
+	}
+	if err := l.repair(v); err != nil {
+		return nil, err
+	}
+	v.report.Repaired = true
+	if v.invalidSeg != nil && l.HighWatermark() > v.last {
+		l.OverrideHighWatermark(v.last)
+	}
+	return v.report, nil
+}
+
+// verify verifies each of the log's segments.
+func (l *commitLog) verify(ctx context.Context) (*logVerifier, error) {
+	v := &logVerifier{
+		ctx:     ctx,
+		report:  &LogReport{LastValidOffset: -1},
+		compact: l.Compact,
+		prev:    -1,
+		last:    -1,
+		rebuild: make(map[*segment]bool),
+	}
+	for _, seg := range l.Segments() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := v.verifySegment(seg); err != nil {
+			return nil, err
+		}
+	}
+	v.report.LastValidOffset = v.last
+	return v, nil
+}
+
+// repair rebuilds the indexes of the segments marked by the verifier and
+// truncates the log at the first invalid message it found. The caller must
+// hold appendMu so the log hasn't been appended to since it was verified.
+func (l *commitLog) repair(v *logVerifier) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	end := len(l.segments)
+	if v.invalidSeg != nil {
+		end = -1
+		for i, seg := range l.segments {
+			if seg == v.invalidSeg {
+				end = i + 1
+				break
+			}
+		}
+		if end == -1 {
+			return errors.New("log was modified while it was verified")
+		}
+		// Offsets from the truncation point on will be reassigned, so they
+		// must be compacted again.
+		l.compactCleaner.Truncated(v.last + 1)
+		for _, seg := range l.segments[end:] {
+			if err := seg.Delete(); err != nil {
+				return err
+			}
+		}
+	}
+	segments := make([]*segment, end)
+	copy(segments, l.segments[:end])
+	for i, seg := range segments {
+		pos := int64(math.MaxInt64)
+		if seg == v.invalidSeg {
+			pos = v.invalidPos
+		} else if !v.rebuild[seg] {
+			continue
+		}
+		// The copy's indexes are built from its messages.
+		newSegment, err := rewriteSegment(seg, math.MaxInt64, pos)
+		if err != nil {
+			return err
+		}
+		if i < len(segments)-1 {
+			newSegment.Seal()
+		}
+		segments[i] = newSegment
+	}
+	activeSegment := segments[len(segments)-1]
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&l.vActiveSegment)),
+		unsafe.Pointer(activeSegment))
+	l.segments = segments
+	if v.invalidSeg == nil {
+		return nil
+	}
+	return l.leaderEpochCache.ClearLatest(v.last + 1)
+}
+
+// addIssue adds the given issue to the report.
+func (v *logVerifier) addIssue(seg *segment, kind LogIssueKind, offset, pos int64, format string,
+	args ...interface{}) {
+
+	if len(v.report.Issues) >= maxLogIssues {
+		v.report.OmittedIssues++
+		return
+	}
+	v.report.Issues = append(v.report.Issues, LogIssue{
+		Kind:     kind,
+		Segment:  seg.BaseOffset,
+		Offset:   offset,
+		Position: pos,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// invalidate adds an issue for the message at the given position, which
+// makes the rest of the segment unreadable. If it's the first such message,
+// the log is truncated before it when it's repaired.
+func (v *logVerifier) invalidate(seg *segment, kind LogIssueKind, offset, pos int64, format string,
+	args ...interface{}) {
+
+	v.addIssue(seg, kind, offset, pos, format, args...)
+	if v.invalidSeg == nil {
+		v.invalidSeg, v.invalidPos = seg, pos
+	}
+}
+
+// mismatch adds an issue for an index entry of the segment which doesn't
+// match its log, whose indexes are rebuilt when the log is repaired.
+func (v *logVerifier) mismatch(seg *segment, kind LogIssueKind, offset, pos int64, format string,
+	args ...interface{}) {
+
+	v.addIssue(seg, kind, offset, pos, format, args...)
+	v.rebuild[seg] = true
+}
+
+// skipped returns nil if the given error is due to the segment being closed
+// or replaced while it was verified, in which case it's skipped. Otherwise
+// it returns the error.
+func (v *logVerifier) skipped(err error) error {
+	if cause := errors.Cause(err); cause != ErrSegmentClosed && cause != ErrSegmentReplaced {
+		return err
+	}
+	v.prev = -1
+	return nil
+}
+
+// verifySegment verifies the messages of the segment in order, along with
+// the entries of its offset and time indexes.
+func (v *logVerifier) verifySegment(seg *segment) error {
+	if err := seg.load(); err != nil {
+		if errors.Cause(err) == ErrSegmentClosed {
+			return nil
+		}
+		v.addIssue(seg, IssueUnreadableSegment, -1, -1, "%v", err)
+		return nil
+	}
+	// The indexes are read before the log's position so their entries only
+	// refer to messages which were written, since they're written after the
+	// log.
+	indexEnd := seg.Index.Position()
+	timeEntries, err := seg.TimeIndex.readEntries()
+	if err != nil {
+		return v.skipped(err)
+	}
+	var (
+		end        = seg.Position()
+		header     = make(messageSet, msgSetHeaderLen)
+		buf        []byte
+		pos        int64
+		indexPos   int64
+		indexEntry entry
+		maxTs      int64 = math.MinInt64
+		lastOffset int64 = -1
+		valid            = true
+	)
+	for n := 1; pos < end; n++ {
+		if n%verifyCheckInterval == 0 {
+			if err := v.ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if pos+msgSetHeaderLen > end {
+			v.invalidate(seg, IssueTruncatedMessage, -1, pos,
+				"message header extends past the end of the log at position %d", end)
+			valid = false
+			break
+		}
+		if _, err := seg.ReadAt(header, pos); err != nil {
+			if err := v.skipped(err); err == nil {
+				return nil
+			}
+			v.invalidate(seg, IssueCorruptMessage, -1, pos, "%v", err)
+			valid = false
+			break
+		}
+		var (
+			offset    = header.Offset()
+			timestamp = header.Timestamp()
+			size      = int64(header.Size())
+		)
+		if size < minMessageSize {
+			v.invalidate(seg, IssueCorruptMessage, offset, pos, "invalid message size %d", size)
+			valid = false
+			break
+		}
+		if pos+msgSetHeaderLen+size > end {
+			v.invalidate(seg, IssueTruncatedMessage, offset, pos,
+				"message of %d bytes extends past the end of the log at position %d", size, end)
+			valid = false
+			break
+		}
+		if int64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err := seg.ReadAt(buf, pos+msgSetHeaderLen); err != nil {
+			if err := v.skipped(err); err == nil {
+				return nil
+			}
+			v.invalidate(seg, IssueCorruptMessage, offset, pos, "%v", err)
+			valid = false
+			break
+		}
+		if err := verifyMessage(buf, offset); err != nil {
+			v.invalidate(seg, IssueCorruptMessage, offset, pos, "%v", err)
+			valid = false
+			break
+		}
+		if offset < seg.BaseOffset {
+			v.invalidate(seg, IssueInvalidOffset, offset, pos,
+				"offset %d is before the segment's base offset %d", offset, seg.BaseOffset)
+			valid = false
+			break
+		}
+		if v.prev != -1 && offset <= v.prev {
+			v.invalidate(seg, IssueInvalidOffset, offset, pos,
+				"offset %d does not follow offset %d", offset, v.prev)
+			valid = false
+			break
+		}
+		if !v.compact {
+			expected := v.prev + 1
+			if v.prev == -1 {
+				expected = seg.BaseOffset
+			}
+			if offset != expected {
+				v.addIssue(seg, IssueOffsetGap, offset, pos, "expected offset %d, got %d",
+					expected, offset)
+			}
+		}
+
+		// Index entries before the message's position don't point to a
+		// message. Those after it are checked against the next messages.
+		for ; indexPos < indexEnd; indexPos += entryWidth {
+			if err := seg.Index.ReadEntryAtFileOffset(&indexEntry, indexPos); err != nil {
+				return v.skipped(err)
+			}
+			e := indexEntry
+			if e.Position > pos {
+				break
+			}
+			if e.Position < pos {
+				v.mismatch(seg, IssueIndexMismatch, e.Offset, e.Position,
+					"index entry for offset %d does not point to a message", e.Offset)
+			} else if e.Offset != offset || e.Timestamp != timestamp ||
+				int64(e.Size) != size+msgSetHeaderLen {
+				v.mismatch(seg, IssueIndexMismatch, e.Offset, e.Position,
+					"index entry for offset %d does not match the message at offset %d",
+					e.Offset, offset)
+			}
+		}
+		if timestamp > maxTs {
+			maxTs = timestamp
+		}
+		for len(timeEntries) > 0 && timeEntries[0].Position <= pos {
+			e := timeEntries[0]
+			timeEntries = timeEntries[1:]
+			if e.Position < pos {
+				v.mismatch(seg, IssueTimeIndexMismatch, e.Offset, e.Position,
+					"time index entry for offset %d does not point to a message", e.Offset)
+			} else if e.Offset != offset || e.Timestamp != maxTs {
+				v.mismatch(seg, IssueTimeIndexMismatch, e.Offset, e.Position,
+					"time index entry for offset %d does not match the message at offset %d",
+					e.Offset, offset)
+			}
+		}
+
+		if v.invalidSeg == nil {
+			v.last = offset
+		}
+		v.prev = offset
+		v.report.Messages++
+		lastOffset = offset
+		pos += msgSetHeaderLen + size
+	}
+	v.report.Segments++
+	if !valid {
+		// The indexes of the rest of the segment are rebuilt when the
+		// segment is truncated.
+		v.prev = -1
+		return nil
+	}
+
+	for ; indexPos < indexEnd; indexPos += entryWidth {
+		if err := seg.Index.ReadEntryAtFileOffset(&indexEntry, indexPos); err != nil {
+			return v.skipped(err)
+		}
+		v.mismatch(seg, IssueIndexMismatch, indexEntry.Offset, indexEntry.Position,
+			"index entry for offset %d points past the end of the log", indexEntry.Offset)
+	}
+	// The entry added when the segment is sealed points at the end of the
+	// log.
+	for _, e := range timeEntries {
+		if e.Position != end || e.Offset != lastOffset || e.Timestamp != maxTs {
+			v.mismatch(seg, IssueTimeIndexMismatch, e.Offset, e.Position,
+				"time index entry for offset %d points past the last message", e.Offset)
+		}
+	}
+	if lastOffset != -1 && indexEnd == 0 && !seg.indexed() {
+		v.mismatch(seg, IssueIndexMismatch, -1, -1, "index is empty")
+	}
+	return nil
+}
+
+// indexed indicates if the segment's index has entries. The first message is
+// always indexed, but its entry is written after the message, so this waits
+// for a write in progress.
+func (s *segment) indexed() bool {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.Index.Position() > 0
+}
@@ -0,0 +1,268 @@
+package commitlog
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// dumpedPosition returns the position of the message at the given offset in
+// the log of the given segment.
+func dumpedPosition(t *testing.T, seg *segment, offset int64) int64 {
+	pos := int64(-1)
+	_, err := DumpLog(seg.logPath(), func(m *DumpedMessage) bool {
+		if m.Offset == offset {
+			pos = m.Position
+			return false
+		}
+		return true
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, int64(-1), pos)
+	return pos
+}
+
+// flipByte flips the byte at the given offset of the file at the given path.
+func flipByte(t *testing.T, path string, off int64) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0666)
+	require.NoError(t, err)
+	defer f.Close()
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+}
+
+// Ensure logs written in each format, with sparse indexes or compacted, are
+// verified without issues.
+func TestVerifyLogValid(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"v1":         {},
+		"v2":         {MessageFormat: MessageFormatV2},
+		"sparse":     {IndexIntervalBytes: 512},
+		"encrypted":  {EncryptionKeys: newTestKeyProvider()},
+		"compressed": {Compression: CompressionLZ4},
+		"compacted":  {Compact: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.Path = tempDir(t)
+			opts.MaxSegmentBytes = 2048
+			l, cleanup := setupWithOptions(t, opts)
+			defer cleanup()
+			appendBatches(t, l, batchTestMessages(100), 5)
+			require.True(t, len(l.Segments()) > 1)
+			l.SetHighWatermark(99)
+			require.NoError(t, l.Clean(context.Background()))
+
+			report, err := l.VerifyLog(context.Background())
+			require.NoError(t, err)
+			require.Empty(t, report.Issues)
+			require.Equal(t, len(l.Segments()), report.Segments)
+			require.Equal(t, l.NewestOffset(), report.LastValidOffset)
+			if opts.Compact {
+				require.True(t, report.Messages < 100)
+			} else {
+				require.Equal(t, int64(100), report.Messages)
+			}
+			if opts.Compression != "" {
+				require.True(t, l.Segments()[0].IsCompressed())
+			}
+
+			report, err = l.RepairLog(context.Background())
+			require.NoError(t, err)
+			require.False(t, report.Repaired)
+		})
+	}
+}
+
+// Ensure gaps between offsets are reported, but not repaired, in logs which
+// are not compacted.
+func TestVerifyLogOffsetGap(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{Path: tempDir(t)})
+	defer cleanup()
+	appendBatches(t, l, batchTestMessages(5), 5)
+	ms, _, err := newMessageSetFromProto(10, 0, batchTestMessages(5), false)
+	require.NoError(t, err)
+	_, err = l.AppendMessageSet(ms)
+	require.NoError(t, err)
+
+	report, err := l.VerifyLog(context.Background())
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, IssueOffsetGap, report.Issues[0].Kind)
+	require.Equal(t, int64(10), report.Issues[0].Offset)
+	require.Equal(t, int64(14), report.LastValidOffset)
+
+	report, err = l.RepairLog(context.Background())
+	require.NoError(t, err)
+	require.False(t, report.Repaired)
+	require.Equal(t, int64(14), l.NewestOffset())
+
+	l.Compact = true
+	report, err = l.VerifyLog(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, report.Issues)
+}
+
+// Ensure messages appended while the log is verified, including to segments
+// rolled meanwhile, are not reported as issues.
+func TestVerifyLogConcurrentAppends(t *testing.T) {
+	opts := Options{
+		Path:               tempDir(t),
+		MaxSegmentBytes:    4096,
+		IndexIntervalBytes: 256,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if _, err := l.Append(batchTestMessages(5)); err != nil {
+				return
+			}
+		}
+	}()
+	for verifying := true; verifying; {
+		select {
+		case <-done:
+			verifying = false
+		default:
+		}
+		report, err := l.VerifyLog(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, report.Issues)
+	}
+	require.Equal(t, int64(999), l.NewestOffset())
+}
+
+// Ensure a corrupt message is reported and the log is truncated before it
+// when it's repaired, after which appends continue at its offset.
+func TestRepairLogCorruptMessage(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 2048,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	msgs := batchTestMessages(100)
+	appendBatches(t, l, msgs, 5)
+	l.SetHighWatermark(99)
+	require.NoError(t, l.Close())
+	seg := l.Segments()[1]
+	pos := dumpedPosition(t, seg, seg.BaseOffset+3)
+	flipByte(t, seg.logPath(), logHeaderLen+pos+msgSetHeaderLen+10)
+
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	report, err := l.VerifyLog(context.Background())
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 1)
+	issue := report.Issues[0]
+	require.Equal(t, IssueCorruptMessage, issue.Kind)
+	require.Equal(t, seg.BaseOffset, issue.Segment)
+	require.Equal(t, seg.BaseOffset+3, issue.Offset)
+	require.Equal(t, pos, issue.Position)
+	require.Equal(t, seg.BaseOffset+2, report.LastValidOffset)
+	require.False(t, report.Repaired)
+
+	report, err = l.RepairLog(context.Background())
+	require.NoError(t, err)
+	require.True(t, report.Repaired)
+	require.Equal(t, seg.BaseOffset+2, l.NewestOffset())
+	require.Equal(t, seg.BaseOffset+2, l.HighWatermark())
+	require.Len(t, l.Segments(), 2)
+
+	report, err = l.VerifyLog(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, report.Issues)
+
+	appendBatches(t, l, msgs[seg.BaseOffset+3:], 5)
+	requireLogMessages(t, l, msgs)
+	require.NoError(t, l.Close())
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	requireLogMessages(t, l, msgs)
+}
+
+// Ensure a corrupt batch of a log written in MessageFormatV2 is reported and
+// removed when the log is repaired.
+func TestRepairLogCorruptBatch(t *testing.T) {
+	opts := Options{
+		Path:          tempDir(t),
+		MessageFormat: MessageFormatV2,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	msgs := batchTestMessages(30)
+	appendBatches(t, l, msgs, 10)
+	require.NoError(t, l.Close())
+	// Flip a byte in the values of the second batch, which starts after the
+	// first batch's 10 messages.
+	pos := dumpedPosition(t, l.Segments()[0], 10)
+	f, err := os.Open(l.Segments()[0].logPath())
+	require.NoError(t, err)
+	prefix := make([]byte, batchLenWidth)
+	_, err = f.ReadAt(prefix, logHeaderLen)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	second := logHeaderLen + batchLenWidth + int64(proto.Encoding.Uint32(prefix))
+	flipByte(t, l.Segments()[0].logPath(), second+batchHeaderLen+20)
+
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	report, err := l.RepairLog(context.Background())
+	require.NoError(t, err)
+	require.True(t, report.Repaired)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, IssueCorruptMessage, report.Issues[0].Kind)
+	require.Equal(t, pos, report.Issues[0].Position)
+	require.Equal(t, int64(9), report.LastValidOffset)
+	require.Equal(t, int64(9), l.NewestOffset())
+
+	appendBatches(t, l, msgs[10:], 10)
+	requireLogMessages(t, l, msgs)
+}
+
+// Ensure index entries which don't match the log are reported and the
+// indexes are rebuilt when the log is repaired.
+func TestRepairLogIndexMismatch(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 2048,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	msgs := batchTestMessages(100)
+	appendBatches(t, l, msgs, 5)
+	require.NoError(t, l.Close())
+	seg := l.Segments()[0]
+	// Change the offset of the index entry of the segment's third message.
+	flipByte(t, seg.indexPath(), indexHeaderLen+2*entryWidth+3)
+
+	l, cleanup = setupWithOptions(t, opts)
+	defer cleanup()
+	report, err := l.VerifyLog(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, report.Issues)
+	for _, issue := range report.Issues {
+		require.Equal(t, IssueIndexMismatch, issue.Kind)
+		require.Equal(t, seg.BaseOffset, issue.Segment)
+	}
+	require.Equal(t, int64(99), report.LastValidOffset)
+
+	report, err = l.RepairLog(context.Background())
+	require.NoError(t, err)
+	require.True(t, report.Repaired)
+	require.Equal(t, int64(99), l.NewestOffset())
+	report, err = l.VerifyLog(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, report.Issues)
+	requireLogMessages(t, l, msgs)
+}
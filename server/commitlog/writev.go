@@ -0,0 +1,71 @@
+package commitlog
+
+import "io"
+
+// maxIovecs is the maximum number of buffers passed to a single vectored
+// write, which is the IOV_MAX limit on Linux.
+const maxIovecs = 1024
+
+// buffersWriter is implemented by segment log writers which can write several
+// buffers with a single vectored write.
+type buffersWriter interface {
+	WriteBuffers(bufs [][]byte) (int, error)
+}
+
+// writeBuffers writes the buffers in order to w, using a vectored write if w
+// supports it so that they don't need to be copied into a single buffer.
+func writeBuffers(w io.Writer, bufs [][]byte) (int, error) {
+	if len(bufs) == 1 {
+		return w.Write(bufs[0])
+	}
+	if bw, ok := w.(buffersWriter); ok {
+		return bw.WriteBuffers(bufs)
+	}
+	written := 0
+	for _, p := range bufs {
+		n, err := w.Write(p)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// writeAllBuffers calls writev with the buffers until all of them are
+// written, since a vectored write may write only part of them. Empty buffers
+// are skipped and at most maxIovecs buffers are passed to each call.
+func writeAllBuffers(bufs [][]byte, writev func([][]byte) (int, error)) (int, error) {
+	var (
+		written = 0
+		pending = make([][]byte, 0, len(bufs))
+	)
+	for _, p := range bufs {
+		if len(p) > 0 {
+			pending = append(pending, p)
+		}
+	}
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > maxIovecs {
+			batch = batch[:maxIovecs]
+		}
+		n, err := writev(batch)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+		for n > 0 {
+			if n < len(pending[0]) {
+				pending[0] = pending[0][n:]
+				break
+			}
+			n -= len(pending[0])
+			pending = pending[1:]
+		}
+	}
+	return written, nil
+}
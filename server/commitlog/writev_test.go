@@ -0,0 +1,96 @@
+package commitlog
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure writeAllBuffers writes every buffer in order when writes are
+// partial or there are more buffers than fit in a single write.
+func TestWriteAllBuffers(t *testing.T) {
+	var (
+		out    bytes.Buffer
+		calls  int
+		bufs   [][]byte
+		expect []byte
+	)
+	for i := 0; i < maxIovecs+10; i++ {
+		p := bytes.Repeat([]byte{byte(i)}, i%3)
+		bufs = append(bufs, p)
+		expect = append(expect, p...)
+	}
+	writev := func(bufs [][]byte) (int, error) {
+		calls++
+		require.True(t, len(bufs) <= maxIovecs)
+		// Write at most 5 bytes per call.
+		n := 0
+		for _, p := range bufs {
+			require.NotEmpty(t, p)
+			if n+len(p) > 5 {
+				p = p[:5-n]
+			}
+			out.Write(p)
+			n += len(p)
+			if n == 5 {
+				break
+			}
+		}
+		return n, nil
+	}
+	n, err := writeAllBuffers(bufs, writev)
+	require.NoError(t, err)
+	require.Equal(t, len(expect), n)
+	require.Equal(t, expect, out.Bytes())
+	require.True(t, calls > 1)
+}
+
+// Ensure message sets with referenced values are identical to message sets
+// encoded into a single buffer and can be appended to and read from a log.
+func TestMessageSetBuffersFromProto(t *testing.T) {
+	msgs := []*Message{
+		{
+			Key:        []byte("small"),
+			Value:      []byte("value"),
+			Headers:    map[string][]byte{"foo": []byte("bar")},
+			Attributes: AttrBatchContinues,
+		},
+		{
+			Key:     []byte("large"),
+			Value:   bytes.Repeat([]byte("v"), 2*minReferencedBytes),
+			Headers: map[string][]byte{"foo": bytes.Repeat([]byte("h"), minReferencedBytes)},
+		},
+	}
+	expected, expectedEntries, err := newMessageSetFromProto(5, 100, msgs, false)
+	require.NoError(t, err)
+	ms, bufs, entries, err := newMessageSetBuffersFromProto(5, 100, msgs, false)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(bufs))
+	require.Equal(t, []byte(expected), bytes.Join(bufs, nil))
+	require.Equal(t, expectedEntries, entries)
+	require.Equal(t, len(expected)-3*minReferencedBytes, len(ms))
+	releaseMessageSet(ms)
+	releaseMessageSet(expected)
+
+	opts := Options{Path: tempDir(t)}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	_, err = l.Append(msgs)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i, exp := range msgs {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, exp.Key, msg.Key())
+		require.Equal(t, exp.Value, msg.Value())
+		require.Equal(t, exp.Headers, msg.Headers())
+	}
+}
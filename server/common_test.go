@@ -2,11 +2,26 @@ package server
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 // Used by both testing.B and testing.T so need to use
@@ -81,3 +96,81 @@ func (c *captureFatalLogger) Writer() io.Writer {
 }
 
 func (c *captureFatalLogger) SetWriter(writer io.Writer) {}
+
+// testCA is a certificate authority which issues certificates for localhost
+// to tests.
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	dir    string
+	name   string
+	caFile string
+}
+
+// newTestCA creates a certificate authority with the given name which writes
+// its certificates to the given directory.
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	ca := &testCA{cert: cert, key: key, dir: dir, name: name}
+	ca.caFile = ca.writePEM(t, name+"-ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+// issue creates a certificate for localhost which can be used by both
+// servers and clients and returns the paths of its certificate and key files.
+func (c *testCA) issue(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, &key.PublicKey, c.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	prefix := c.name + "-" + name
+	return c.writePEM(t, prefix+".crt", "CERTIFICATE", der), c.writePEM(t, prefix+".key", "EC PRIVATE KEY", keyDER)
+}
+
+// tlsConfig returns a TLS configuration using a certificate issued by the
+// authority with the given name and trusting the authority.
+func (c *testCA) tlsConfig(t *testing.T, name string) *tls.Config {
+	certFile, keyFile := c.issue(t, name)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+func (c *testCA) writePEM(t *testing.T, name, typ string, der []byte) string {
+	file := filepath.Join(c.dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	require.NoError(t, ioutil.WriteFile(file, data, 0600))
+	return file
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// AcceptEncodingMetadata is the Subscribe request metadata key containing the
+// codecs the subscriber can decompress, as a comma-separated list in order of
+// preference. The server delivers messages in batches compressed with the
+// first listed codec it supports and sets the ContentEncodingMetadata response
+// header to it. Supported codecs are "zstd", "gzip", and "zlib". "identity"
+// can be listed to prefer uncompressed delivery.
+const AcceptEncodingMetadata = "liftbridge-accept-encoding"
+
+// ContentEncodingMetadata is the Subscribe response header metadata key
+// containing the codec the server compresses message batches with. It is not
+// set if messages are delivered uncompressed.
+const ContentEncodingMetadata = "liftbridge-content-encoding"
+
+// ContentEncodingHeader is the message header containing the codec the
+// message value is compressed with. Publishers can set it on messages they
+// compress themselves. The server sets it on compressed batches it delivers.
+const ContentEncodingHeader = "Liftbridge-Content-Encoding"
+
+// BatchCountHeader is the header set on a compressed batch delivered to a
+// subscription containing the number of messages in the batch. The value of
+// a batch is a sequence of messages, each prefixed with its size as a varint,
+// compressed with the codec in the ContentEncodingHeader. A batch has the
+// offset and timestamp of its last message.
+const BatchCountHeader = "Liftbridge-Batch-Count"
+
+const (
+	encodingIdentity = "identity"
+	encodingGzip     = "gzip"
+	encodingZlib     = "zlib"
+	encodingZstd     = "zstd"
+
+	// Limits on the size of compressed batches delivered to subscriptions.
+	maxEncodedBatchMessages = 256
+	maxEncodedBatchBytes    = 1024 * 1024
+)
+
+// subscriptionEncoder compresses messages delivered to a subscription using
+// the codecs negotiated with the subscriber. Messages the publisher already
+// compressed with a codec the subscriber accepts are passed through as they
+// are stored. Ones compressed with a codec it doesn't accept are decompressed
+// if the server supports the codec.
+type subscriptionEncoder struct {
+	encoding   string
+	accepted   map[string]struct{}
+	dictionary *compressionDictionary // Dictionary batches are compressed with, if any
+}
+
+// encoderFromContext negotiates the encoding for a subscription from the
+// incoming request metadata and sends the chosen encoding in the response
+// header. It returns nil if the subscriber does not advertise any codecs.
+func encoderFromContext(ctx context.Context) *subscriptionEncoder {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.Get(AcceptEncodingMetadata)
+	if len(values) == 0 {
+		return nil
+	}
+	encoder := &subscriptionEncoder{accepted: make(map[string]struct{})}
+	for _, value := range values {
+		for _, codec := range strings.Split(value, ",") {
+			codec = strings.ToLower(strings.TrimSpace(codec))
+			if codec == "" {
+				continue
+			}
+			encoder.accepted[codec] = struct{}{}
+			if encoder.encoding == "" && isSupportedEncoding(codec) {
+				encoder.encoding = codec
+			}
+		}
+	}
+	if encoder.encoding == encodingIdentity {
+		encoder.encoding = ""
+	}
+	if encoder.encoding != "" {
+		// This fails for internal subscriptions, which don't have a gRPC
+		// stream, but those never advertise codecs.
+		grpc.SetHeader(ctx, metadata.Pairs(ContentEncodingMetadata, encoder.encoding))
+	}
+	return encoder
+}
+
+// isSupportedEncoding indicates if the server supports the given codec.
+func isSupportedEncoding(codec string) bool {
+	switch codec {
+	case encodingIdentity, encodingGzip, encodingZlib, encodingZstd:
+		return true
+	}
+	return false
+}
+
+// compresses indicates if the encoder batches and compresses messages.
+func (e *subscriptionEncoder) compresses() bool {
+	return e != nil && e.encoding != ""
+}
+
+// encode returns the messages to deliver for the given messages. If the
+// encoder compresses, consecutive messages are delivered as compressed
+// batches.
+func (e *subscriptionEncoder) encode(msgs []*client.Message) ([]*client.Message, error) {
+	if e == nil {
+		return msgs, nil
+	}
+	var (
+		out        = make([]*client.Message, 0, len(msgs))
+		batch      []*client.Message
+		batchBytes int
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		msg, err := e.encodeBatch(batch)
+		if err != nil {
+			return err
+		}
+		out = append(out, msg)
+		batch, batchBytes = nil, 0
+		return nil
+	}
+	for _, msg := range msgs {
+		if codec, ok := msg.Headers[ContentEncodingHeader]; ok {
+			if _, accepted := e.accepted[string(codec)]; accepted || !isSupportedEncoding(string(codec)) {
+				// Pass through the stored compression.
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				out = append(out, msg)
+				continue
+			}
+			decoded, err := decompress(string(codec), msg.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress message at offset %d: %v", msg.Offset, err)
+			}
+			// The message may be shared with other subscriptions, so the
+			// decoded message is a copy.
+			decodedMsg := *msg
+			decodedMsg.Value = decoded
+			decodedMsg.Headers = make(map[string][]byte, len(msg.Headers))
+			for key, value := range msg.Headers {
+				if key != ContentEncodingHeader {
+					decodedMsg.Headers[key] = value
+				}
+			}
+			msg = &decodedMsg
+		}
+		if e.encoding == "" {
+			out = append(out, msg)
+			continue
+		}
+		if len(batch) >= maxEncodedBatchMessages || batchBytes+msg.Size() > maxEncodedBatchBytes {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		batch = append(batch, msg)
+		batchBytes += msg.Size()
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// encodeBatch returns a message containing the given messages compressed with
+// the encoder's codec.
+func (e *subscriptionEncoder) encodeBatch(batch []*client.Message) (*client.Message, error) {
+	data, err := proto.MarshalBatch(batch)
+	if err != nil {
+		return nil, err
+	}
+	var compressed []byte
+	if e.dictionary != nil {
+		compressed = e.dictionary.compress(data)
+	} else {
+		compressed, err = compress(e.encoding, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	last := batch[len(batch)-1]
+	return &client.Message{
+		Offset:    last.Offset,
+		Value:     compressed,
+		Timestamp: last.Timestamp,
+		Stream:    last.Stream,
+		Partition: last.Partition,
+		Headers: map[string][]byte{
+			ContentEncodingHeader: []byte(e.encoding),
+			BatchCountHeader:      []byte(strconv.Itoa(len(batch))),
+		},
+	}, nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the zstd encoder and decoder shared by subscriptions,
+// creating them the first time it's called.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// compress compresses the data with the given codec.
+func compress(codec string, data []byte) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+	switch codec {
+	case encodingZstd:
+		encoder, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	case encodingGzip:
+		w = gzip.NewWriter(&buf)
+	case encodingZlib:
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", codec)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decompresses data compressed with the given codec.
+func decompress(codec string, data []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+	switch codec {
+	case encodingIdentity:
+		return data, nil
+	case encodingZstd:
+		_, decoder, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(data, nil)
+	case encodingGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case encodingZlib:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
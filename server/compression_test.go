@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure encoderFromContext picks the first codec the server supports.
+func TestEncoderFromContext(t *testing.T) {
+	require.Nil(t, encoderFromContext(context.Background()))
+
+	tests := []struct {
+		accept   string
+		encoding string
+	}{
+		{"gzip", encodingGzip},
+		{"br, ZLIB, gzip", encodingZlib},
+		{"zstd, gzip", encodingZstd},
+		{"identity, gzip", ""},
+		{"br", ""},
+	}
+	for _, test := range tests {
+		ctx := metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs(AcceptEncodingMetadata, test.accept))
+		encoder := encoderFromContext(ctx)
+		require.NotNil(t, encoder, test.accept)
+		require.Equal(t, test.encoding, encoder.encoding, test.accept)
+		require.Equal(t, test.encoding != "", encoder.compresses(), test.accept)
+	}
+}
+
+// Ensure subscriptionEncoder batches and compresses messages, passes through
+// messages compressed with an accepted codec, and decompresses others.
+func TestSubscriptionEncoderEncode(t *testing.T) {
+	gzipped, err := compress(encodingGzip, []byte("stored"))
+	require.NoError(t, err)
+	msgs := func() []*client.Message {
+		return []*client.Message{
+			{Offset: 0, Value: []byte("zero")},
+			{Offset: 1, Value: []byte("one"), Headers: map[string][]byte{"foo": []byte("bar")}},
+			{Offset: 2, Value: gzipped, Headers: map[string][]byte{ContentEncodingHeader: []byte(encodingGzip)}},
+			{Offset: 3, Value: []byte("three")},
+		}
+	}
+
+	// A nil encoder delivers messages as they are.
+	var encoder *subscriptionEncoder
+	out, err := encoder.encode(msgs())
+	require.NoError(t, err)
+	require.Equal(t, msgs(), out)
+
+	encoder = &subscriptionEncoder{
+		encoding: encodingZlib,
+		accepted: map[string]struct{}{encodingZlib: {}, encodingGzip: {}},
+	}
+	out, err = encoder.encode(msgs())
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+
+	require.Equal(t, int64(1), out[0].Offset)
+	require.Equal(t, []byte(encodingZlib), out[0].Headers[ContentEncodingHeader])
+	require.Equal(t, []byte("2"), out[0].Headers[BatchCountHeader])
+	data, err := decompress(encodingZlib, out[0].Value)
+	require.NoError(t, err)
+	batch, err := proto.UnmarshalBatch(data)
+	require.NoError(t, err)
+	require.Equal(t, msgs()[:2], batch)
+
+	require.Equal(t, msgs()[2], out[1])
+
+	require.Equal(t, int64(3), out[2].Offset)
+	require.Equal(t, []byte("1"), out[2].Headers[BatchCountHeader])
+
+	// Stored compression the subscriber doesn't accept is removed.
+	encoder = &subscriptionEncoder{accepted: map[string]struct{}{"br": {}}}
+	out, err = encoder.encode(msgs())
+	require.NoError(t, err)
+	require.Len(t, out, 4)
+	require.Equal(t, []byte("stored"), out[2].Value)
+	_, ok := out[2].Headers[ContentEncodingHeader]
+	require.False(t, ok)
+
+	// Batches are split at the maximum number of messages.
+	many := make([]*client.Message, maxEncodedBatchMessages+1)
+	for i := range many {
+		many[i] = &client.Message{Offset: int64(i), Value: []byte(strconv.Itoa(i))}
+	}
+	encoder = &subscriptionEncoder{encoding: encodingGzip}
+	out, err = encoder.encode(many)
+	require.NoError(t, err)
+	require.Len(t, out, 2)
+	require.Equal(t, []byte(strconv.Itoa(maxEncodedBatchMessages)), out[0].Headers[BatchCountHeader])
+	require.Equal(t, int64(maxEncodedBatchMessages), out[1].Offset)
+
+	encoder = &subscriptionEncoder{encoding: encodingZstd}
+	out, err = encoder.encode(msgs()[:2])
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	require.Equal(t, []byte(encodingZstd), out[0].Headers[ContentEncodingHeader])
+	data, err = decompress(encodingZstd, out[0].Value)
+	require.NoError(t, err)
+	batch, err = proto.UnmarshalBatch(data)
+	require.NoError(t, err)
+	require.Equal(t, msgs()[:2], batch)
+}
+
+// Ensure subscriptions which advertise codecs receive compressed batches.
+func TestSubscribeCompressed(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	num := 10
+	for i := 0; i < num; i++ {
+		_, err = api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	sub, err := api.Subscribe(
+		metadata.AppendToOutgoingContext(ctx, AcceptEncodingMetadata, "br, gzip"),
+		&client.SubscribeRequest{
+			Stream:        "foo",
+			StartPosition: client.StartPosition_EARLIEST,
+		},
+	)
+	require.NoError(t, err)
+	header, err := sub.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{encodingGzip}, header.Get(ContentEncodingMetadata))
+	_, err = sub.Recv()
+	require.NoError(t, err)
+
+	received := 0
+	for received < num {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, []byte(encodingGzip), msg.Headers[ContentEncodingHeader])
+		data, err := decompress(encodingGzip, msg.Value)
+		require.NoError(t, err)
+		batch, err := proto.UnmarshalBatch(data)
+		require.NoError(t, err)
+		require.Equal(t, []byte(strconv.Itoa(len(batch))), msg.Headers[BatchCountHeader])
+		for _, m := range batch {
+			require.Equal(t, int64(received), m.Offset)
+			require.Equal(t, []byte(strconv.Itoa(received)), m.Value)
+			received++
+		}
+		require.Equal(t, int64(received-1), msg.Offset)
+	}
+}
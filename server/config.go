@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +22,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 
+	"github.com/liftbridge-io/liftbridge/server/archive"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	"github.com/liftbridge-io/liftbridge/server/logger"
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
 
@@ -31,6 +37,9 @@ const (
 	DefaultPort = 9292
 )
 
+// envPrefix is the prefix of environment variables overriding settings.
+const envPrefix = "LIFTBRIDGE"
+
 // Config setting defaults.
 const (
 	defaultListenAddress                  = "0.0.0.0"
@@ -39,80 +48,199 @@ const (
 	defaultReplicaMaxLeaderTimeout        = 15 * time.Second
 	defaultReplicaMaxIdleWait             = 10 * time.Second
 	defaultReplicationMaxBytes            = 1024 * 1024 // 1MB
+	defaultGossipInterval                 = time.Second
+	defaultGossipSuspectTimeout           = 5 * time.Second
+	defaultGossipDeadTimeout              = 15 * time.Second
+	defaultTransportListen                = "0.0.0.0:9296"
 	defaultRaftSnapshots                  = 2
 	defaultRaftCacheSize                  = 512
 	defaultMetadataCacheMaxAge            = 2 * time.Minute
+	defaultUnixSocketMode                 = 0600
 	defaultBatchMaxMessages               = 1024
+	defaultSubscriptionPriorityWindowMax  = 10000
 	defaultReplicaFetchTimeout            = 3 * time.Second
+	defaultReplicaMaxInflightRequests     = 1
 	defaultMinInsyncReplicas              = 1
 	defaultRetentionMaxAge                = 7 * 24 * time.Hour
 	defaultCleanerInterval                = 5 * time.Minute
+	defaultBackgroundTick                 = 10 * time.Millisecond
+	defaultCompactionWorkers              = 1
+	defaultHotSampleInterval              = 10 * time.Second
 	defaultMaxSegmentBytes                = 1024 * 1024 * 256 // 256MB
 	defaultMaxSegmentAge                  = defaultRetentionMaxAge
 	defaultActivityStreamPublishTimeout   = 5 * time.Second
 	defaultActivityStreamPublishAckPolicy = client.AckPolicy_ALL
 	defaultCursorsStreamAutoPauseTime     = time.Minute
+	defaultCanaryInterval                 = 10 * time.Second
+	defaultCanaryTimeout                  = 5 * time.Second
+	defaultCanaryFailureThreshold         = 3
 	defaultConcurrencyControl             = false
 	defaultEncryption                     = false
+	defaultStreamsAutoCreatePartitions    = 1
+	defaultStreamsAutoCreateReplication   = 1
+	defaultCompressionDictionarySamples   = 1000
+	defaultAckCoalesceMaxAcks             = 256
+	defaultDedupMaxEntries                = 100000
+	defaultIndexMmap                      = true
+	defaultDrainTimeout                   = 30 * time.Second
 )
 
 // Config setting key names.
 const (
 	configListen              = "listen"
+	configListeners           = "listeners"
 	configHost                = "host"
 	configPort                = "port"
 	configDataDir             = "data.dir"
+	configDataDirs            = "data.dirs"
 	configMetadataCacheMaxAge = "metadata.cache.max.age"
 
 	configLoggingLevel    = "logging.level"
 	configLoggingRecovery = "logging.recovery"
 	configLoggingRaft     = "logging.raft"
 	configLoggingNATS     = "logging.nats"
+	configLoggingFile     = "logging.file"
 
 	configBatchMaxMessages = "batch.max.messages"
 	configBatchMaxTime     = "batch.max.time"
+	configBatchMaxBytes    = "batch.max.bytes"
+
+	configSubscriptionBufferMaxBytes    = "subscription.buffer.max.bytes"
+	configSubscriptionMaxPerConnection  = "subscription.max.per.connection"
+	configSubscriptionMaxPerStream      = "subscription.max.per.stream"
+	configSubscriptionPriorityWindowMax = "subscription.priority.window.max"
+	configConnectionMax                 = "connection.max"
 
 	configTLSKey               = "tls.key"
 	configTLSCert              = "tls.cert"
 	configTLSClientAuthEnabled = "tls.client.auth.enabled"
 	configTLSClientAuthCA      = "tls.client.auth.ca"
 
-	configNATSServers        = "nats.servers"
-	configNATSUser           = "nats.user"
-	configNATSPassword       = "nats.password"
-	configNATSCert           = "nats.tls.cert"
-	configNATSKey            = "nats.tls.key"
-	configNATSCA             = "nats.tls.ca"
-	configNATSEmbedded       = "nats.embedded"
-	configNATSEmbeddedConfig = "nats.embedded.config"
+	configUnixSocketPath = "unix.socket.path"
+	configUnixSocketMode = "unix.socket.mode"
+
+	configGRPCReflectionEnabled = "grpc.reflection.enabled"
+	configGRPCChannelzEnabled   = "grpc.channelz.enabled"
+
+	configAdminListen      = "admin.listen"
+	configAdminTLSCert     = "admin.tls.cert"
+	configAdminTLSKey      = "admin.tls.key"
+	configAdminTLSClientCA = "admin.tls.client.ca"
+	configAdminToken       = "admin.token"
+	configDrainTimeout     = "drain.timeout"
+	configArchiveLocation  = "archive.location"
+	configExportDir        = "export.dir"
+	configExportURLs       = "export.allowed.urls"
+
+	configNATSServers          = "nats.servers"
+	configNATSUser             = "nats.user"
+	configNATSPassword         = "nats.password"
+	configNATSCert             = "nats.tls.cert"
+	configNATSKey              = "nats.tls.key"
+	configNATSCA               = "nats.tls.ca"
+	configNATSEmbedded         = "nats.embedded"
+	configNATSEmbeddedConfig   = "nats.embedded.config"
+	configNATSDisabled         = "nats.disabled"
+	configNATSInternalServers  = "nats.internal.servers"
+	configNATSInternalUser     = "nats.internal.user"
+	configNATSInternalPassword = "nats.internal.password"
+	configNATSInternalCert     = "nats.internal.tls.cert"
+	configNATSInternalKey      = "nats.internal.tls.key"
+	configNATSInternalCA       = "nats.internal.tls.ca"
 
 	configStreamsRetentionMaxBytes             = "streams.retention.max.bytes"
 	configStreamsRetentionMaxMessages          = "streams.retention.max.messages"
 	configStreamsRetentionMaxAge               = "streams.retention.max.age"
+	configStreamsRetentionMaxKeys              = "streams.retention.max.keys"
 	configStreamsCleanerInterval               = "streams.cleaner.interval"
 	configStreamsSegmentMaxBytes               = "streams.segment.max.bytes"
 	configStreamsSegmentMaxAge                 = "streams.segment.max.age"
 	configStreamsCompactEnabled                = "streams.compact.enabled"
 	configStreamsCompactMaxGoroutines          = "streams.compact.max.goroutines"
+	configStreamsCompactKeepVersions           = "streams.compact.keep.versions"
+	configStreamsCompactTombstoneRetention     = "streams.compact.tombstone.retention"
+	configStreamsCompactMinDirtyRatio          = "streams.compact.min.dirty.ratio"
+	configStreamsCompactMaxBytes               = "streams.compact.max.bytes"
 	configStreamsAutoPauseTime                 = "streams.auto.pause.time"
 	configStreamsAutoPauseDisableIfSubscribers = "streams.auto.pause.disable.if.subscribers"
+	configStreamsAutoDeleteTime                = "streams.auto.delete.time"
 	configStreamsConcurrencyControl            = "streams.concurrency.control"
 	configStreamsEncryption                    = "streams.encryption"
-
-	configClusteringServerID                = "clustering.server.id"
-	configClusteringNamespace               = "clustering.namespace"
-	configClusteringRaftSnapshotRetain      = "clustering.raft.snapshot.retain"
-	configClusteringRaftSnapshotThreshold   = "clustering.raft.snapshot.threshold"
-	configClusteringRaftCacheSize           = "clustering.raft.cache.size"
-	configClusteringRaftBootstrapSeed       = "clustering.raft.bootstrap.seed"
-	configClusteringRaftBootstrapPeers      = "clustering.raft.bootstrap.peers"
-	configClusteringRaftMaxQuorumSize       = "clustering.raft.max.quorum.size"
-	configClusteringReplicaMaxLagTime       = "clustering.replica.max.lag.time"
-	configClusteringReplicaMaxLeaderTimeout = "clustering.replica.max.leader.timeout"
-	configClusteringReplicaMaxIdleWait      = "clustering.replica.max.idle.wait"
-	configClusteringReplicaFetchTimeout     = "clustering.replica.fetch.timeout"
-	configClusteringMinInsyncReplicas       = "clustering.min.insync.replicas"
-	configClusteringReplicationMaxBytes     = "clustering.replication.max.bytes"
+	configStreamsDedupWindow                   = "streams.dedup.window"
+	configStreamsDedupMaxEntries               = "streams.dedup.max.entries"
+	configStreamsSyncOnAppend                  = "streams.sync.on.append"
+	configStreamsSyncMaxDelay                  = "streams.sync.max.delay"
+	configStreamsFlushMessages                 = "streams.flush.messages"
+	configStreamsFlushMs                       = "streams.flush.ms"
+	configStreamsIOUringEnabled                = "streams.io.uring.enabled"
+	configStreamsFanoutCacheSize               = "streams.fanout.cache.size"
+	configStreamsIndexIntervalBytes            = "streams.index.interval.bytes"
+	configStreamsIndexAdvice                   = "streams.index.advice"
+	configStreamsIndexLockBytes                = "streams.index.lock.bytes"
+	configStreamsIndexMmapEnabled              = "streams.index.mmap.enabled"
+	configStreamsIndexPreallocateBytes         = "streams.index.preallocate.bytes"
+	configStreamsChecksumVerification          = "streams.checksum.verification"
+	configStreamsTieredStorageEnabled          = "streams.tiered.storage.enabled"
+	configStreamsTieredStorageBucket           = "streams.tiered.storage.bucket"
+	configStreamsTieredStoragePrefix           = "streams.tiered.storage.prefix"
+	configStreamsTieredStorageAllowedBuckets   = "streams.tiered.storage.allowed.buckets"
+	configStreamsSegmentCompression            = "streams.segment.compression"
+	configStreamsSegmentEncryptionEnabled      = "streams.segment.encryption.enabled"
+	configStreamsSegmentEncryptionKeysDir      = "streams.segment.encryption.keys.dir"
+	configStreamsSegmentMessageFormat          = "streams.segment.message.format"
+	configStreamsBlockCacheMaxBytes            = "streams.block.cache.max.bytes"
+	configStreamsBackgroundWorkers             = "streams.background.workers"
+	configStreamsBackgroundTick                = "streams.background.tick"
+	configStreamsCompactionWorkers             = "streams.compaction.workers"
+	configStreamsCompactionIOMaxBytesPerSec    = "streams.compaction.io.max.bytes.per.sec"
+	configStreamsHotSampleInterval             = "streams.hot.sample.interval"
+	configStreamsHotAppendRate                 = "streams.hot.append.rate"
+	configStreamsHotReadRate                   = "streams.hot.read.rate"
+	configStreamsHotLockWait                   = "streams.hot.lock.wait"
+	configStreamsCompressionDictionaryBytes    = "streams.compression.dictionary.bytes"
+	configStreamsCompressionDictionarySamples  = "streams.compression.dictionary.samples"
+	configStreamsAckCoalesceInterval           = "streams.ack.coalesce.interval"
+	configStreamsAckCoalesceMaxAcks            = "streams.ack.coalesce.max.acks"
+	configStreamsWatchInterval                 = "streams.watch.interval"
+	configStreamsWatchExisting                 = "streams.watch.existing"
+
+	configStreamsAutoCreateEnabled              = "streams.auto.create.enabled"
+	configStreamsAutoCreatePartitions           = "streams.auto.create.partitions"
+	configStreamsAutoCreateReplicationFactor    = "streams.auto.create.replication.factor"
+	configStreamsAutoCreateRetentionMaxBytes    = "streams.auto.create.retention.max.bytes"
+	configStreamsAutoCreateRetentionMaxMessages = "streams.auto.create.retention.max.messages"
+	configStreamsAutoCreateRetentionMaxAge      = "streams.auto.create.retention.max.age"
+	configStreamsAutoCreateNamePattern          = "streams.auto.create.name.pattern"
+	configStreamsAutoCreateClients              = "streams.auto.create.clients"
+
+	configClusteringServerID                   = "clustering.server.id"
+	configClusteringNamespace                  = "clustering.namespace"
+	configClusteringRack                       = "clustering.rack"
+	configClusteringRaftSnapshotRetain         = "clustering.raft.snapshot.retain"
+	configClusteringRaftSnapshotThreshold      = "clustering.raft.snapshot.threshold"
+	configClusteringRaftCacheSize              = "clustering.raft.cache.size"
+	configClusteringRaftBootstrapSeed          = "clustering.raft.bootstrap.seed"
+	configClusteringRaftBootstrapPeers         = "clustering.raft.bootstrap.peers"
+	configClusteringRaftBootstrapDNSName       = "clustering.raft.bootstrap.dns.name"
+	configClusteringRaftBootstrapDNSExpect     = "clustering.raft.bootstrap.dns.expect"
+	configClusteringRaftMaxQuorumSize          = "clustering.raft.max.quorum.size"
+	configClusteringReplicaMaxLagTime          = "clustering.replica.max.lag.time"
+	configClusteringReplicaMaxLeaderTimeout    = "clustering.replica.max.leader.timeout"
+	configClusteringReplicaMaxIdleWait         = "clustering.replica.max.idle.wait"
+	configClusteringReplicaFetchTimeout        = "clustering.replica.fetch.timeout"
+	configClusteringReplicaMaxInflightRequests = "clustering.replica.max.inflight.requests"
+	configClusteringMinInsyncReplicas          = "clustering.min.insync.replicas"
+	configClusteringReplicationMaxBytes        = "clustering.replication.max.bytes"
+	configClusteringReplicationTLSCert         = "clustering.replication.tls.cert"
+	configClusteringReplicationTLSKey          = "clustering.replication.tls.key"
+	configClusteringReplicationTLSCA           = "clustering.replication.tls.ca"
+	configClusteringGossipListen               = "clustering.gossip.listen"
+	configClusteringGossipSeeds                = "clustering.gossip.seeds"
+	configClusteringGossipInterval             = "clustering.gossip.interval"
+	configClusteringGossipSuspectTimeout       = "clustering.gossip.suspect.timeout"
+	configClusteringGossipDeadTimeout          = "clustering.gossip.dead.timeout"
+	configClusteringTransportListen            = "clustering.transport.listen"
+	configClusteringTransportSeeds             = "clustering.transport.seeds"
 
 	configActivityStreamEnabled          = "activity.stream.enabled"
 	configActivityStreamPublishTimeout   = "activity.stream.publish.timeout"
@@ -120,63 +248,169 @@ const (
 
 	configCursorsStreamPartitions    = "cursors.stream.partitions"
 	configCursorsStreamAutoPauseTime = "cursors.stream.auto.pause.time"
+
+	configSchedulesStreamPartitions = "schedules.stream.partitions"
+
+	configCanaryEnabled          = "canary.enabled"
+	configCanaryInterval         = "canary.interval"
+	configCanaryTimeout          = "canary.timeout"
+	configCanaryFailureThreshold = "canary.failure.threshold"
 )
 
 var configKeys = map[string]struct{}{
-	configListen:                               {},
-	configHost:                                 {},
-	configPort:                                 {},
-	configDataDir:                              {},
-	configMetadataCacheMaxAge:                  {},
-	configLoggingLevel:                         {},
-	configLoggingRecovery:                      {},
-	configLoggingRaft:                          {},
-	configLoggingNATS:                          {},
-	configBatchMaxMessages:                     {},
-	configBatchMaxTime:                         {},
-	configTLSKey:                               {},
-	configTLSCert:                              {},
-	configTLSClientAuthEnabled:                 {},
-	configTLSClientAuthCA:                      {},
-	configNATSServers:                          {},
-	configNATSUser:                             {},
-	configNATSPassword:                         {},
-	configNATSCert:                             {},
-	configNATSKey:                              {},
-	configNATSCA:                               {},
-	configNATSEmbedded:                         {},
-	configNATSEmbeddedConfig:                   {},
-	configStreamsRetentionMaxBytes:             {},
-	configStreamsRetentionMaxMessages:          {},
-	configStreamsRetentionMaxAge:               {},
-	configStreamsCleanerInterval:               {},
-	configStreamsSegmentMaxBytes:               {},
-	configStreamsSegmentMaxAge:                 {},
-	configStreamsCompactEnabled:                {},
-	configStreamsConcurrencyControl:            {},
-	configStreamsEncryption:                    {},
-	configStreamsCompactMaxGoroutines:          {},
-	configStreamsAutoPauseTime:                 {},
-	configStreamsAutoPauseDisableIfSubscribers: {},
-	configClusteringServerID:                   {},
-	configClusteringNamespace:                  {},
-	configClusteringRaftSnapshotRetain:         {},
-	configClusteringRaftSnapshotThreshold:      {},
-	configClusteringRaftCacheSize:              {},
-	configClusteringRaftBootstrapSeed:          {},
-	configClusteringRaftBootstrapPeers:         {},
-	configClusteringRaftMaxQuorumSize:          {},
-	configClusteringReplicaMaxLagTime:          {},
-	configClusteringReplicaMaxLeaderTimeout:    {},
-	configClusteringReplicaMaxIdleWait:         {},
-	configClusteringReplicaFetchTimeout:        {},
-	configClusteringMinInsyncReplicas:          {},
-	configClusteringReplicationMaxBytes:        {},
-	configActivityStreamEnabled:                {},
-	configActivityStreamPublishTimeout:         {},
-	configActivityStreamPublishAckPolicy:       {},
-	configCursorsStreamPartitions:              {},
-	configCursorsStreamAutoPauseTime:           {},
+	configListen:                                {},
+	configListeners:                             {},
+	configHost:                                  {},
+	configPort:                                  {},
+	configDataDir:                               {},
+	configDataDirs:                              {},
+	configMetadataCacheMaxAge:                   {},
+	configLoggingLevel:                          {},
+	configLoggingRecovery:                       {},
+	configLoggingRaft:                           {},
+	configLoggingNATS:                           {},
+	configLoggingFile:                           {},
+	configBatchMaxMessages:                      {},
+	configBatchMaxTime:                          {},
+	configBatchMaxBytes:                         {},
+	configSubscriptionBufferMaxBytes:            {},
+	configSubscriptionMaxPerConnection:          {},
+	configSubscriptionMaxPerStream:              {},
+	configSubscriptionPriorityWindowMax:         {},
+	configConnectionMax:                         {},
+	configTLSKey:                                {},
+	configTLSCert:                               {},
+	configTLSClientAuthEnabled:                  {},
+	configTLSClientAuthCA:                       {},
+	configUnixSocketPath:                        {},
+	configUnixSocketMode:                        {},
+	configGRPCReflectionEnabled:                 {},
+	configGRPCChannelzEnabled:                   {},
+	configAdminListen:                           {},
+	configAdminTLSCert:                          {},
+	configAdminTLSKey:                           {},
+	configAdminTLSClientCA:                      {},
+	configAdminToken:                            {},
+	configDrainTimeout:                          {},
+	configArchiveLocation:                       {},
+	configExportDir:                             {},
+	configExportURLs:                            {},
+	configNATSServers:                           {},
+	configNATSUser:                              {},
+	configNATSPassword:                          {},
+	configNATSCert:                              {},
+	configNATSKey:                               {},
+	configNATSCA:                                {},
+	configNATSEmbedded:                          {},
+	configNATSEmbeddedConfig:                    {},
+	configNATSDisabled:                          {},
+	configNATSInternalServers:                   {},
+	configNATSInternalUser:                      {},
+	configNATSInternalPassword:                  {},
+	configNATSInternalCert:                      {},
+	configNATSInternalKey:                       {},
+	configNATSInternalCA:                        {},
+	configStreamsRetentionMaxBytes:              {},
+	configStreamsRetentionMaxMessages:           {},
+	configStreamsRetentionMaxKeys:               {},
+	configStreamsRetentionMaxAge:                {},
+	configStreamsCleanerInterval:                {},
+	configStreamsSegmentMaxBytes:                {},
+	configStreamsSegmentMaxAge:                  {},
+	configStreamsCompactEnabled:                 {},
+	configStreamsConcurrencyControl:             {},
+	configStreamsEncryption:                     {},
+	configStreamsDedupWindow:                    {},
+	configStreamsDedupMaxEntries:                {},
+	configStreamsSyncOnAppend:                   {},
+	configStreamsSyncMaxDelay:                   {},
+	configStreamsFlushMessages:                  {},
+	configStreamsFlushMs:                        {},
+	configStreamsIOUringEnabled:                 {},
+	configStreamsFanoutCacheSize:                {},
+	configStreamsIndexIntervalBytes:             {},
+	configStreamsIndexAdvice:                    {},
+	configStreamsIndexLockBytes:                 {},
+	configStreamsIndexMmapEnabled:               {},
+	configStreamsIndexPreallocateBytes:          {},
+	configStreamsChecksumVerification:           {},
+	configStreamsTieredStorageEnabled:           {},
+	configStreamsTieredStorageBucket:            {},
+	configStreamsTieredStoragePrefix:            {},
+	configStreamsTieredStorageAllowedBuckets:    {},
+	configStreamsSegmentCompression:             {},
+	configStreamsSegmentEncryptionEnabled:       {},
+	configStreamsSegmentEncryptionKeysDir:       {},
+	configStreamsSegmentMessageFormat:           {},
+	configStreamsBlockCacheMaxBytes:             {},
+	configStreamsBackgroundWorkers:              {},
+	configStreamsBackgroundTick:                 {},
+	configStreamsCompactionWorkers:              {},
+	configStreamsCompactionIOMaxBytesPerSec:     {},
+	configStreamsHotSampleInterval:              {},
+	configStreamsHotAppendRate:                  {},
+	configStreamsHotReadRate:                    {},
+	configStreamsHotLockWait:                    {},
+	configStreamsCompressionDictionaryBytes:     {},
+	configStreamsCompressionDictionarySamples:   {},
+	configStreamsAckCoalesceInterval:            {},
+	configStreamsAckCoalesceMaxAcks:             {},
+	configStreamsWatchInterval:                  {},
+	configStreamsWatchExisting:                  {},
+	configStreamsAutoCreateEnabled:              {},
+	configStreamsAutoCreatePartitions:           {},
+	configStreamsAutoCreateReplicationFactor:    {},
+	configStreamsAutoCreateRetentionMaxBytes:    {},
+	configStreamsAutoCreateRetentionMaxMessages: {},
+	configStreamsAutoCreateRetentionMaxAge:      {},
+	configStreamsAutoCreateNamePattern:          {},
+	configStreamsAutoCreateClients:              {},
+	configStreamsCompactMaxGoroutines:           {},
+	configStreamsCompactKeepVersions:            {},
+	configStreamsCompactTombstoneRetention:      {},
+	configStreamsCompactMinDirtyRatio:           {},
+	configStreamsCompactMaxBytes:                {},
+	configStreamsAutoPauseTime:                  {},
+	configStreamsAutoPauseDisableIfSubscribers:  {},
+	configStreamsAutoDeleteTime:                 {},
+	configClusteringServerID:                    {},
+	configClusteringNamespace:                   {},
+	configClusteringRack:                        {},
+	configClusteringRaftSnapshotRetain:          {},
+	configClusteringRaftSnapshotThreshold:       {},
+	configClusteringRaftCacheSize:               {},
+	configClusteringRaftBootstrapSeed:           {},
+	configClusteringRaftBootstrapPeers:          {},
+	configClusteringRaftBootstrapDNSName:        {},
+	configClusteringRaftBootstrapDNSExpect:      {},
+	configClusteringRaftMaxQuorumSize:           {},
+	configClusteringReplicaMaxLagTime:           {},
+	configClusteringReplicaMaxLeaderTimeout:     {},
+	configClusteringReplicaMaxIdleWait:          {},
+	configClusteringReplicaFetchTimeout:         {},
+	configClusteringReplicaMaxInflightRequests:  {},
+	configClusteringMinInsyncReplicas:           {},
+	configClusteringReplicationMaxBytes:         {},
+	configClusteringReplicationTLSCert:          {},
+	configClusteringReplicationTLSKey:           {},
+	configClusteringReplicationTLSCA:            {},
+	configClusteringGossipListen:                {},
+	configClusteringGossipSeeds:                 {},
+	configClusteringGossipInterval:              {},
+	configClusteringGossipSuspectTimeout:        {},
+	configClusteringGossipDeadTimeout:           {},
+	configClusteringTransportListen:             {},
+	configClusteringTransportSeeds:              {},
+	configActivityStreamEnabled:                 {},
+	configActivityStreamPublishTimeout:          {},
+	configActivityStreamPublishAckPolicy:        {},
+	configCursorsStreamPartitions:               {},
+	configCursorsStreamAutoPauseTime:            {},
+	configSchedulesStreamPartitions:             {},
+	configCanaryEnabled:                         {},
+	configCanaryInterval:                        {},
+	configCanaryTimeout:                         {},
+	configCanaryFailureThreshold:                {},
 }
 
 // StreamsConfig contains settings for controlling the message log for streams.
@@ -184,16 +418,60 @@ type StreamsConfig struct {
 	RetentionMaxBytes             int64
 	RetentionMaxMessages          int64
 	RetentionMaxAge               time.Duration
+	RetentionMaxKeys              int64
 	CleanerInterval               time.Duration
 	SegmentMaxBytes               int64
 	SegmentMaxAge                 time.Duration
 	Compact                       bool
 	CompactMaxGoroutines          int
+	CompactKeepVersions           int
+	CompactTombstoneRetention     time.Duration
+	CompactMinDirtyRatio          float64
+	CompactMaxBytes               int64
 	AutoPauseTime                 time.Duration
 	AutoPauseDisableIfSubscribers bool
+	AutoDeleteTime                time.Duration
 	MinISR                        int
 	ConcurrencyControl            bool
 	Encryption                    bool
+	DedupWindow                   time.Duration
+	DedupMaxEntries               int
+	SyncOnAppend                  bool
+	SyncMaxDelay                  time.Duration
+	FlushMessages                 int64
+	FlushInterval                 time.Duration
+	IOUring                       bool
+	FanoutCacheSize               int
+	IndexIntervalBytes            int64
+	IndexAdvice                   commitlog.IndexAdvice
+	IndexLockBytes                int64
+	IndexMmap                     bool
+	IndexPreallocateBytes         int64
+	ChecksumVerification          commitlog.ChecksumVerification
+	TieredStorage                 bool
+	TieredStorageBucket           string
+	TieredStoragePrefix           string
+	TieredStorageAllowedBuckets   []string
+	SegmentCompression            commitlog.SegmentCompression
+	SegmentEncryption             bool
+	SegmentEncryptionKeysDir      string
+	SegmentEncryptionKeys         commitlog.KeyProvider // Used instead of SegmentEncryptionKeysDir if set
+	SegmentMessageFormat          commitlog.MessageFormat
+	BlockCacheMaxBytes            int64
+	BackgroundWorkers             int
+	BackgroundTick                time.Duration
+	CompactionWorkers             int
+	CompactionIOMaxBytesPerSec    int64
+	HotSampleInterval             time.Duration
+	HotAppendRate                 float64
+	HotReadRate                   float64
+	HotLockWait                   float64
+	CompressionDictionaryBytes    int
+	CompressionDictionarySamples  int
+	AckCoalesceInterval           time.Duration
+	AckCoalesceMaxAcks            int
+	WatchInterval                 time.Duration
+	WatchExisting                 []string
 }
 
 // RetentionString returns a human-readable string representation of the
@@ -213,6 +491,10 @@ func (l StreamsConfig) RetentionString() string {
 		str += fmt.Sprintf("%sAge: %s", prefix, durafmt.Parse(l.RetentionMaxAge))
 		prefix = ", "
 	}
+	if l.Compact && l.RetentionMaxKeys > 0 {
+		str += fmt.Sprintf("%sKeys: %s", prefix, humanize.Comma(l.RetentionMaxKeys))
+		prefix = ", "
+	}
 	if prefix == "" {
 		str += "no limits"
 	}
@@ -231,6 +513,52 @@ func (l StreamsConfig) AutoPauseString() string {
 	return str
 }
 
+// setStreamDefaults copies the settings which are defaults for each stream
+// partition from the given config. These can be overridden by a stream's
+// configuration and are reloaded when the configuration file is watched.
+func (l *StreamsConfig) setStreamDefaults(from *StreamsConfig) {
+	l.SegmentMaxBytes = from.SegmentMaxBytes
+	l.SegmentMaxAge = from.SegmentMaxAge
+	l.RetentionMaxBytes = from.RetentionMaxBytes
+	l.RetentionMaxMessages = from.RetentionMaxMessages
+	l.RetentionMaxAge = from.RetentionMaxAge
+	l.RetentionMaxKeys = from.RetentionMaxKeys
+	l.CleanerInterval = from.CleanerInterval
+	l.Compact = from.Compact
+	l.CompactMaxGoroutines = from.CompactMaxGoroutines
+	l.CompactKeepVersions = from.CompactKeepVersions
+	l.CompactTombstoneRetention = from.CompactTombstoneRetention
+	l.CompactMinDirtyRatio = from.CompactMinDirtyRatio
+	l.CompactMaxBytes = from.CompactMaxBytes
+	l.AutoPauseTime = from.AutoPauseTime
+	l.AutoPauseDisableIfSubscribers = from.AutoPauseDisableIfSubscribers
+	l.Encryption = from.Encryption
+	l.DedupWindow = from.DedupWindow
+	l.DedupMaxEntries = from.DedupMaxEntries
+	l.SyncOnAppend = from.SyncOnAppend
+	l.SyncMaxDelay = from.SyncMaxDelay
+	l.FlushMessages = from.FlushMessages
+	l.FlushInterval = from.FlushInterval
+	l.IOUring = from.IOUring
+	l.FanoutCacheSize = from.FanoutCacheSize
+	l.IndexIntervalBytes = from.IndexIntervalBytes
+	l.IndexAdvice = from.IndexAdvice
+	l.IndexLockBytes = from.IndexLockBytes
+	l.IndexMmap = from.IndexMmap
+	l.IndexPreallocateBytes = from.IndexPreallocateBytes
+	l.ChecksumVerification = from.ChecksumVerification
+	l.TieredStorage = from.TieredStorage
+	l.TieredStorageBucket = from.TieredStorageBucket
+	l.TieredStoragePrefix = from.TieredStoragePrefix
+	l.SegmentCompression = from.SegmentCompression
+	l.SegmentEncryption = from.SegmentEncryption
+	l.SegmentEncryptionKeysDir = from.SegmentEncryptionKeysDir
+	l.SegmentEncryptionKeys = from.SegmentEncryptionKeys
+	l.SegmentMessageFormat = from.SegmentMessageFormat
+	l.AckCoalesceInterval = from.AckCoalesceInterval
+	l.AckCoalesceMaxAcks = from.AckCoalesceMaxAcks
+}
+
 // ApplyOverrides applies the values from the StreamConfig protobuf to the
 // StreamsConfig struct. If the value is present in the request's config
 // section, it will be set in StreamsConfig.
@@ -260,6 +588,38 @@ func (l *StreamsConfig) ApplyOverrides(c *proto.StreamConfig) {
 		l.RetentionMaxMessages = maxMessages.Value
 	}
 
+	if maxKeys := c.RetentionMaxKeys; maxKeys != nil {
+		l.RetentionMaxKeys = maxKeys.Value
+	}
+
+	if flushMessages := c.FlushMessages; flushMessages != nil {
+		l.FlushMessages = flushMessages.Value
+	}
+
+	if flushMs := c.FlushMs; flushMs != nil {
+		l.FlushInterval = time.Duration(flushMs.Value) * time.Millisecond
+	}
+
+	if tieredStorage := c.TieredStorageEnabled; tieredStorage != nil {
+		l.TieredStorage = tieredStorage.Value
+	}
+
+	if bucket := c.TieredStorageBucket; bucket != "" {
+		l.TieredStorageBucket = bucket
+	}
+
+	if prefix := c.TieredStoragePrefix; prefix != "" {
+		l.TieredStoragePrefix = prefix
+	}
+
+	if compression := c.SegmentCompression; compression != "" {
+		l.SegmentCompression = commitlog.SegmentCompression(compression)
+	}
+
+	if dedupWindow := c.DedupWindow; dedupWindow != nil {
+		l.DedupWindow = time.Duration(dedupWindow.Value) * time.Millisecond
+	}
+
 	if segmentMaxBytes := c.SegmentMaxBytes; segmentMaxBytes != nil {
 		l.SegmentMaxBytes = segmentMaxBytes.Value
 	}
@@ -272,6 +632,10 @@ func (l *StreamsConfig) ApplyOverrides(c *proto.StreamConfig) {
 		l.CompactMaxGoroutines = int(maxGoroutines.Value)
 	}
 
+	if keepVersions := c.CompactKeepVersions; keepVersions != nil {
+		l.CompactKeepVersions = int(keepVersions.Value)
+	}
+
 	if autoPauseTime := c.AutoPauseTime; autoPauseTime != nil {
 		l.AutoPauseTime = time.Duration(autoPauseTime.Value) * time.Millisecond
 	}
@@ -293,22 +657,62 @@ func (l *StreamsConfig) ApplyOverrides(c *proto.StreamConfig) {
 	}
 }
 
+// StreamsAutoCreateConfig contains settings for creating streams when they are
+// first published to.
+type StreamsAutoCreateConfig struct {
+	Enabled              bool
+	Partitions           int32
+	ReplicationFactor    int32
+	RetentionMaxBytes    int64
+	RetentionMaxMessages int64
+	RetentionMaxAge      time.Duration
+	NamePattern          *regexp.Regexp
+	Clients              []string
+}
+
+// streamConfig returns the configuration overrides for auto-created streams.
+func (c StreamsAutoCreateConfig) streamConfig() *proto.StreamConfig {
+	config := new(proto.StreamConfig)
+	if c.RetentionMaxBytes != 0 {
+		config.RetentionMaxBytes = &proto.NullableInt64{Value: c.RetentionMaxBytes}
+	}
+	if c.RetentionMaxMessages != 0 {
+		config.RetentionMaxMessages = &proto.NullableInt64{Value: c.RetentionMaxMessages}
+	}
+	if c.RetentionMaxAge != 0 {
+		config.RetentionMaxAge = &proto.NullableInt64{Value: c.RetentionMaxAge.Milliseconds()}
+	}
+	return config
+}
+
 // ClusteringConfig contains settings for controlling cluster behavior.
 type ClusteringConfig struct {
-	ServerID                string
-	Namespace               string
-	RaftSnapshots           int
-	RaftSnapshotThreshold   uint64
-	RaftCacheSize           int
-	RaftBootstrapSeed       bool
-	RaftBootstrapPeers      []string
-	RaftMaxQuorumSize       uint
-	ReplicaMaxLagTime       time.Duration
-	ReplicaMaxLeaderTimeout time.Duration
-	ReplicaFetchTimeout     time.Duration
-	ReplicaMaxIdleWait      time.Duration
-	MinISR                  int
-	ReplicationMaxBytes     int64
+	ServerID                   string
+	Namespace                  string
+	Rack                       string
+	RaftSnapshots              int
+	RaftSnapshotThreshold      uint64
+	RaftCacheSize              int
+	RaftBootstrapSeed          bool
+	RaftBootstrapPeers         []string
+	RaftBootstrapDNSName       string
+	RaftBootstrapDNSExpect     int
+	RaftMaxQuorumSize          uint
+	ReplicaMaxLagTime          time.Duration
+	ReplicaMaxLeaderTimeout    time.Duration
+	ReplicaFetchTimeout        time.Duration
+	ReplicaMaxIdleWait         time.Duration
+	ReplicaMaxInflightRequests int
+	MinISR                     int
+	ReplicationMaxBytes        int64
+	ReplicationTLS             *tls.Config
+	GossipListen               string
+	GossipSeeds                []string
+	GossipInterval             time.Duration
+	GossipSuspectTimeout       time.Duration
+	GossipDeadTimeout          time.Duration
+	TransportListen            string
+	TransportSeeds             []string
 }
 
 // ActivityStreamConfig contains settings for controlling activity stream
@@ -326,42 +730,91 @@ type CursorsStreamConfig struct {
 	AutoPauseTime time.Duration
 }
 
+// SchedulesStreamConfig contains settings for controlling scheduled publish
+// behavior.
+type SchedulesStreamConfig struct {
+	Partitions int32
+}
+
+// CanaryConfig contains settings for controlling the canary which checks the
+// health of the publish and delivery path.
+type CanaryConfig struct {
+	Enabled          bool
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+}
+
 // Config contains all settings for a Liftbridge Server.
 type Config struct {
-	Listen              HostPort
-	Host                string
-	Port                int
-	LogLevel            uint32
-	LogRecovery         bool
-	LogRaft             bool
-	LogNATS             bool
-	LogSilent           bool
-	DataDir             string
-	BatchMaxMessages    int
-	BatchMaxTime        time.Duration
-	MetadataCacheMaxAge time.Duration
-	TLSKey              string
-	TLSCert             string
-	TLSClientAuth       bool
-	TLSClientAuthCA     string
-	NATS                nats.Options
-	EmbeddedNATS        bool
-	EmbeddedNATSConfig  string
-	Streams             StreamsConfig
-	Clustering          ClusteringConfig
-	ActivityStream      ActivityStreamConfig
-	CursorsStream       CursorsStreamConfig
+	Listen                        HostPort
+	Host                          string
+	Port                          int
+	Listeners                     []ListenerConfig
+	LogLevel                      uint32
+	LogRecovery                   bool
+	LogRaft                       bool
+	LogNATS                       bool
+	LogSilent                     bool
+	LogFile                       string
+	Logger                        logger.Logger // Used instead of the default Logger if set
+	DataDir                       string
+	DataDirs                      []string
+	BatchMaxMessages              int
+	BatchMaxTime                  time.Duration
+	BatchMaxBytes                 int
+	MetadataCacheMaxAge           time.Duration
+	SubscriptionBufferMaxBytes    int64
+	SubscriptionMaxPerConnection  int
+	SubscriptionMaxPerStream      int
+	SubscriptionPriorityWindowMax int
+	ConnectionMax                 int
+	TLSKey                        string
+	TLSCert                       string
+	TLSClientAuth                 bool
+	TLSClientAuthCA               string
+	UnixSocketPath                string
+	UnixSocketMode                os.FileMode
+	GRPCReflection                bool
+	GRPCChannelz                  bool
+	AdminListen                   string
+	AdminTLSCert                  string
+	AdminTLSKey                   string
+	AdminTLSClientCA              string
+	AdminToken                    string
+	DrainTimeout                  time.Duration
+	ArchiveLocation               string
+	ArchiveStore                  archive.Store // Used instead of ArchiveLocation if set
+	ExportDir                     string
+	ExportAllowedURLs             []string
+	NATS                          nats.Options
+	EmbeddedNATS                  bool
+	EmbeddedNATSConfig            string
+	NATSDisabled                  bool
+	InternalNATS                  nats.Options
+	Streams                       StreamsConfig
+	StreamsAutoCreate             StreamsAutoCreateConfig
+	Clustering                    ClusteringConfig
+	ActivityStream                ActivityStreamConfig
+	CursorsStream                 CursorsStreamConfig
+	SchedulesStream               SchedulesStreamConfig
+	Canary                        CanaryConfig
+	file                          string // Path of the configuration file, if any
 }
 
 // NewDefaultConfig creates a new Config with default settings.
 func NewDefaultConfig() *Config {
 	config := &Config{
-		NATS: nats.GetDefaultOptions(),
-		Port: DefaultPort,
+		NATS:         nats.GetDefaultOptions(),
+		InternalNATS: nats.GetDefaultOptions(),
+		Port:         DefaultPort,
 	}
 	config.LogLevel = uint32(log.InfoLevel)
 	config.BatchMaxMessages = defaultBatchMaxMessages
+	config.SubscriptionPriorityWindowMax = defaultSubscriptionPriorityWindowMax
 	config.MetadataCacheMaxAge = defaultMetadataCacheMaxAge
+	config.DrainTimeout = defaultDrainTimeout
+	config.UnixSocketMode = defaultUnixSocketMode
 	config.NATS.Servers = []string{nats.DefaultURL}
 	config.Clustering.ServerID = nuid.Next()
 	config.Clustering.Namespace = DefaultNamespace
@@ -369,19 +822,37 @@ func NewDefaultConfig() *Config {
 	config.Clustering.ReplicaMaxLeaderTimeout = defaultReplicaMaxLeaderTimeout
 	config.Clustering.ReplicaMaxIdleWait = defaultReplicaMaxIdleWait
 	config.Clustering.ReplicaFetchTimeout = defaultReplicaFetchTimeout
+	config.Clustering.ReplicaMaxInflightRequests = defaultReplicaMaxInflightRequests
 	config.Clustering.RaftSnapshots = defaultRaftSnapshots
 	config.Clustering.RaftCacheSize = defaultRaftCacheSize
 	config.Clustering.MinISR = defaultMinInsyncReplicas
 	config.Clustering.ReplicationMaxBytes = defaultReplicationMaxBytes
+	config.Clustering.GossipInterval = defaultGossipInterval
+	config.Clustering.GossipSuspectTimeout = defaultGossipSuspectTimeout
+	config.Clustering.GossipDeadTimeout = defaultGossipDeadTimeout
+	config.Clustering.TransportListen = defaultTransportListen
 	config.Streams.SegmentMaxBytes = defaultMaxSegmentBytes
 	config.Streams.SegmentMaxAge = defaultMaxSegmentAge
 	config.Streams.RetentionMaxAge = defaultRetentionMaxAge
 	config.Streams.CleanerInterval = defaultCleanerInterval
+	config.Streams.BackgroundWorkers = runtime.NumCPU()
+	config.Streams.BackgroundTick = defaultBackgroundTick
+	config.Streams.CompactionWorkers = defaultCompactionWorkers
+	config.Streams.HotSampleInterval = defaultHotSampleInterval
 	config.Streams.ConcurrencyControl = defaultConcurrencyControl
 	config.Streams.Encryption = defaultEncryption
+	config.Streams.CompressionDictionarySamples = defaultCompressionDictionarySamples
+	config.Streams.AckCoalesceMaxAcks = defaultAckCoalesceMaxAcks
+	config.Streams.DedupMaxEntries = defaultDedupMaxEntries
+	config.Streams.IndexMmap = defaultIndexMmap
+	config.StreamsAutoCreate.Partitions = defaultStreamsAutoCreatePartitions
+	config.StreamsAutoCreate.ReplicationFactor = defaultStreamsAutoCreateReplication
 	config.ActivityStream.PublishTimeout = defaultActivityStreamPublishTimeout
 	config.ActivityStream.PublishAckPolicy = defaultActivityStreamPublishAckPolicy
 	config.CursorsStream.AutoPauseTime = defaultCursorsStreamAutoPauseTime
+	config.Canary.Interval = defaultCanaryInterval
+	config.Canary.Timeout = defaultCanaryTimeout
+	config.Canary.FailureThreshold = defaultCanaryFailureThreshold
 	return config
 }
 
@@ -391,6 +862,18 @@ func (c Config) NATSServersString() string {
 	return "[" + strings.Join(c.NATS.Servers, ", ") + "]"
 }
 
+// HasInternalNATS indicates if inter-broker traffic uses a separate NATS
+// cluster from client traffic.
+func (c Config) HasInternalNATS() bool {
+	return len(c.InternalNATS.Servers) > 0
+}
+
+// InternalNATSServersString returns a human-readable string representation
+// of the list of NATS servers used for inter-broker traffic.
+func (c Config) InternalNATSServersString() string {
+	return "[" + strings.Join(c.InternalNATS.Servers, ", ") + "]"
+}
+
 // GetListenAddress returns the address and port to listen to.
 func (c Config) GetListenAddress() HostPort {
 	if len(c.Listen.Host) > 0 {
@@ -449,29 +932,32 @@ func GetLogLevel(level string) (uint32, error) {
 }
 
 // NewConfig creates a new Config with default settings and applies any
-// settings from the given configuration file.
+// settings from the given configuration file and environment variables.
+// Environment variables take precedence over the configuration file. The
+// configuration file is optional.
 func NewConfig(configFile string) (*Config, error) { // nolint: gocyclo
 	var (
 		config = NewDefaultConfig()
 		v      = viper.New()
 	)
 
-	// Return default config if config file is not given.
-	if configFile == "" {
-		return config, nil
-	}
+	// Allow overriding any setting with environment variables, e.g.
+	// LIFTBRIDGE_LOGGING_LEVEL for logging.level.
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
 
-	// Expect a yaml config file.
-	v.SetConfigFile(configFile)
-	v.SetConfigType("yaml")
+	if configFile != "" {
+		config.file = configFile
 
-	// Allow overriding config with environment variables
-	v.SetEnvPrefix("LIFTBRIDGE")
-	v.AutomaticEnv()
+		// Expect a yaml config file.
+		v.SetConfigFile(configFile)
+		v.SetConfigType("yaml")
 
-	// Parse the config file.
-	if err := v.ReadInConfig(); err != nil {
-		return nil, errors.Wrap(err, "Failed to load configuration file")
+		// Parse the config file.
+		if err := v.ReadInConfig(); err != nil {
+			return nil, errors.Wrap(err, "Failed to load configuration file")
+		}
 	}
 
 	// Validate config settings.
@@ -494,8 +980,22 @@ func NewConfig(configFile string) (*Config, error) { // nolint: gocyclo
 		config.Listen = *hp
 	}
 
+	if v.IsSet(configListeners) {
+		listeners, err := parseListeners(v.Get(configListeners))
+		if err != nil {
+			return nil, err
+		}
+		config.Listeners = listeners
+	}
+
 	if v.IsSet(configPort) {
-		config.Port = v.GetInt(configPort)
+		// Parse strictly since Kubernetes sets LIFTBRIDGE_PORT to a URL for
+		// services named liftbridge unless service links are disabled.
+		port, err := strconv.Atoi(v.GetString(configPort))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s setting %q", configPort, v.GetString(configPort))
+		}
+		config.Port = port
 	}
 
 	if v.IsSet(configHost) {
@@ -524,10 +1024,18 @@ func NewConfig(configFile string) (*Config, error) { // nolint: gocyclo
 		config.LogNATS = v.GetBool(configLoggingNATS)
 	}
 
+	if v.IsSet(configLoggingFile) {
+		config.LogFile = v.GetString(configLoggingFile)
+	}
+
 	if v.IsSet(configDataDir) {
 		config.DataDir = v.GetString(configDataDir)
 	}
 
+	if v.IsSet(configDataDirs) {
+		config.DataDirs = getStringSlice(v, configDataDirs)
+	}
+
 	if v.IsSet(configBatchMaxMessages) {
 		config.BatchMaxMessages = v.GetInt(configBatchMaxMessages)
 	}
@@ -536,6 +1044,30 @@ func NewConfig(configFile string) (*Config, error) { // nolint: gocyclo
 		config.BatchMaxTime = v.GetDuration(configBatchMaxTime)
 	}
 
+	if v.IsSet(configBatchMaxBytes) {
+		config.BatchMaxBytes = v.GetInt(configBatchMaxBytes)
+	}
+
+	if v.IsSet(configSubscriptionBufferMaxBytes) {
+		config.SubscriptionBufferMaxBytes = v.GetInt64(configSubscriptionBufferMaxBytes)
+	}
+
+	if v.IsSet(configSubscriptionMaxPerConnection) {
+		config.SubscriptionMaxPerConnection = v.GetInt(configSubscriptionMaxPerConnection)
+	}
+
+	if v.IsSet(configSubscriptionMaxPerStream) {
+		config.SubscriptionMaxPerStream = v.GetInt(configSubscriptionMaxPerStream)
+	}
+
+	if v.IsSet(configSubscriptionPriorityWindowMax) {
+		config.SubscriptionPriorityWindowMax = v.GetInt(configSubscriptionPriorityWindowMax)
+	}
+
+	if v.IsSet(configConnectionMax) {
+		config.ConnectionMax = v.GetInt(configConnectionMax)
+	}
+
 	if v.IsSet(configMetadataCacheMaxAge) {
 		config.MetadataCacheMaxAge = v.GetDuration(configMetadataCacheMaxAge)
 	}
@@ -556,12 +1088,72 @@ func NewConfig(configFile string) (*Config, error) { // nolint: gocyclo
 		config.TLSClientAuthCA = v.GetString(configTLSClientAuthCA)
 	}
 
+	if v.IsSet(configUnixSocketPath) {
+		config.UnixSocketPath = v.GetString(configUnixSocketPath)
+	}
+
+	if v.IsSet(configUnixSocketMode) {
+		mode, err := parseFileMode(v.Get(configUnixSocketMode))
+		if err != nil {
+			return nil, err
+		}
+		config.UnixSocketMode = mode
+	}
+
+	if v.IsSet(configGRPCReflectionEnabled) {
+		config.GRPCReflection = v.GetBool(configGRPCReflectionEnabled)
+	}
+
+	if v.IsSet(configGRPCChannelzEnabled) {
+		config.GRPCChannelz = v.GetBool(configGRPCChannelzEnabled)
+	}
+
+	if v.IsSet(configAdminListen) {
+		config.AdminListen = v.GetString(configAdminListen)
+	}
+
+	if v.IsSet(configAdminTLSCert) {
+		config.AdminTLSCert = v.GetString(configAdminTLSCert)
+	}
+
+	if v.IsSet(configAdminTLSKey) {
+		config.AdminTLSKey = v.GetString(configAdminTLSKey)
+	}
+
+	if v.IsSet(configAdminTLSClientCA) {
+		config.AdminTLSClientCA = v.GetString(configAdminTLSClientCA)
+	}
+
+	if v.IsSet(configAdminToken) {
+		config.AdminToken = v.GetString(configAdminToken)
+	}
+
+	if v.IsSet(configDrainTimeout) {
+		config.DrainTimeout = v.GetDuration(configDrainTimeout)
+		if config.DrainTimeout <= 0 {
+			return nil, fmt.Errorf("Invalid %s setting %s", configDrainTimeout, config.DrainTimeout)
+		}
+	}
+
+	if v.IsSet(configArchiveLocation) {
+		config.ArchiveLocation = v.GetString(configArchiveLocation)
+	}
+	if v.IsSet(configExportDir) {
+		config.ExportDir = v.GetString(configExportDir)
+	}
+	if v.IsSet(configExportURLs) {
+		config.ExportAllowedURLs = getStringSlice(v, configExportURLs)
+	}
+
 	if err := parseNATSConfig(config, v); err != nil {
 		return nil, err
 	}
 	if err := parseStreamsConfig(config, v); err != nil {
 		return nil, err
 	}
+	if err := parseStreamsAutoCreateConfig(config, v); err != nil {
+		return nil, err
+	}
 	if err := parseClusteringConfig(config, v); err != nil {
 		return nil, err
 	}
@@ -571,6 +1163,12 @@ func NewConfig(configFile string) (*Config, error) { // nolint: gocyclo
 	if err := parseCursorsStreamConfig(config, v); err != nil {
 		return nil, err
 	}
+	if err := parseSchedulesStreamConfig(config, v); err != nil {
+		return nil, err
+	}
+	if err := parseCanaryConfig(config, v); err != nil {
+		return nil, err
+	}
 
 	// If SegmentMaxAge is not set, default it to the retention time.
 	if config.Streams.SegmentMaxAge == 0 {
@@ -592,8 +1190,12 @@ func parseNATSConfig(config *Config, v *viper.Viper) error {
 		config.EmbeddedNATS = true
 	}
 
+	if v.IsSet(configNATSDisabled) {
+		config.NATSDisabled = v.GetBool(configNATSDisabled)
+	}
+
 	if v.IsSet(configNATSServers) {
-		servers := v.GetStringSlice(configNATSServers)
+		servers := getStringSlice(v, configNATSServers)
 		config.NATS.Servers = servers
 	}
 
@@ -605,43 +1207,75 @@ func parseNATSConfig(config *Config, v *viper.Viper) error {
 		config.NATS.Password = v.GetString(configNATSPassword)
 	}
 
-	// NATS TLS config
-	// Both Cert and Key must be presented
+	tlsConfig, err := parseNATSTLSConfig(v, configNATSCert, configNATSKey, configNATSCA)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		config.NATS.TLSConfig = tlsConfig
+	}
 
-	if v.IsSet(configNATSCert) && v.IsSet(configNATSKey) {
+	if v.IsSet(configNATSInternalServers) {
+		config.InternalNATS.Servers = getStringSlice(v, configNATSInternalServers)
+	}
 
-		// Load cert and key file
-		certFile := v.GetString(configNATSCert)
-		keyFile := v.GetString(configNATSKey)
+	if v.IsSet(configNATSInternalUser) {
+		config.InternalNATS.User = v.GetString(configNATSInternalUser)
+	}
 
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			return err
-		}
+	if v.IsSet(configNATSInternalPassword) {
+		config.InternalNATS.Password = v.GetString(configNATSInternalPassword)
+	}
 
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			MinVersion:   tls.VersionTLS12,
-		}
+	tlsConfig, err = parseNATSTLSConfig(v, configNATSInternalCert, configNATSInternalKey, configNATSInternalCA)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		config.InternalNATS.TLSConfig = tlsConfig
+	}
 
-		// Load CACert if available
-		if v.IsSet(configNATSCA) {
-			caFile := v.GetString(configNATSCA)
-			// Load CA cert
-			caCert, err := ioutil.ReadFile(caFile)
+	return nil
+}
 
-			if err != nil {
-				return err
-			}
-			caCertPool := x509.NewCertPool()
-			caCertPool.AppendCertsFromPEM(caCert)
+// parseNATSTLSConfig parses the TLS configuration for a NATS connection from
+// the given cert, key and CA settings. It returns nil if the cert and key are
+// not both set.
+func parseNATSTLSConfig(v *viper.Viper, certSetting, keySetting, caSetting string) (*tls.Config, error) {
+	// Both Cert and Key must be presented
+	if !v.IsSet(certSetting) || !v.IsSet(keySetting) {
+		return nil, nil
+	}
 
-			tlsConfig.RootCAs = caCertPool
-		}
-		config.NATS.TLSConfig = tlsConfig
+	// Load cert and key file
+	certFile := v.GetString(certSetting)
+	keyFile := v.GetString(keySetting)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// Load CACert if available
+	if v.IsSet(caSetting) {
+		caFile := v.GetString(caSetting)
+		// Load CA cert
+		caCert, err := ioutil.ReadFile(caFile)
+
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+
+		tlsConfig.RootCAs = caCertPool
+	}
+	return tlsConfig, nil
 }
 
 // parseStreamConfig parses the `streams` section of a config file and
@@ -659,6 +1293,10 @@ func parseStreamsConfig(config *Config, v *viper.Viper) error {
 		config.Streams.RetentionMaxAge = v.GetDuration(configStreamsRetentionMaxAge)
 	}
 
+	if v.IsSet(configStreamsRetentionMaxKeys) {
+		config.Streams.RetentionMaxKeys = v.GetInt64(configStreamsRetentionMaxKeys)
+	}
+
 	if v.IsSet(configStreamsCleanerInterval) {
 		config.Streams.CleanerInterval = v.GetDuration(configStreamsCleanerInterval)
 	}
@@ -679,6 +1317,22 @@ func parseStreamsConfig(config *Config, v *viper.Viper) error {
 		config.Streams.CompactMaxGoroutines = v.GetInt(configStreamsCompactMaxGoroutines)
 	}
 
+	if v.IsSet(configStreamsCompactKeepVersions) {
+		config.Streams.CompactKeepVersions = v.GetInt(configStreamsCompactKeepVersions)
+	}
+
+	if v.IsSet(configStreamsCompactTombstoneRetention) {
+		config.Streams.CompactTombstoneRetention = v.GetDuration(configStreamsCompactTombstoneRetention)
+	}
+
+	if v.IsSet(configStreamsCompactMinDirtyRatio) {
+		config.Streams.CompactMinDirtyRatio = v.GetFloat64(configStreamsCompactMinDirtyRatio)
+	}
+
+	if v.IsSet(configStreamsCompactMaxBytes) {
+		config.Streams.CompactMaxBytes = v.GetInt64(configStreamsCompactMaxBytes)
+	}
+
 	if v.IsSet(configStreamsAutoPauseTime) {
 		config.Streams.AutoPauseTime = v.GetDuration(configStreamsAutoPauseTime)
 	}
@@ -686,12 +1340,245 @@ func parseStreamsConfig(config *Config, v *viper.Viper) error {
 	if v.IsSet(configStreamsAutoPauseDisableIfSubscribers) {
 		config.Streams.AutoPauseDisableIfSubscribers = v.GetBool(configStreamsAutoPauseDisableIfSubscribers)
 	}
+
+	if v.IsSet(configStreamsAutoDeleteTime) {
+		config.Streams.AutoDeleteTime = v.GetDuration(configStreamsAutoDeleteTime)
+	}
 	if v.IsSet(configStreamsConcurrencyControl) {
 		config.Streams.ConcurrencyControl = v.GetBool(configStreamsConcurrencyControl)
 	}
 	if v.IsSet(configStreamsEncryption) {
 		config.Streams.Encryption = v.GetBool(configStreamsEncryption)
 	}
+	if v.IsSet(configStreamsDedupWindow) {
+		config.Streams.DedupWindow = v.GetDuration(configStreamsDedupWindow)
+	}
+	if v.IsSet(configStreamsDedupMaxEntries) {
+		config.Streams.DedupMaxEntries = v.GetInt(configStreamsDedupMaxEntries)
+		if config.Streams.DedupMaxEntries < 0 {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsDedupMaxEntries,
+				config.Streams.DedupMaxEntries)
+		}
+	}
+	if v.IsSet(configStreamsSyncOnAppend) {
+		config.Streams.SyncOnAppend = v.GetBool(configStreamsSyncOnAppend)
+	}
+	if v.IsSet(configStreamsSyncMaxDelay) {
+		config.Streams.SyncMaxDelay = v.GetDuration(configStreamsSyncMaxDelay)
+	}
+	if v.IsSet(configStreamsFlushMessages) {
+		config.Streams.FlushMessages = v.GetInt64(configStreamsFlushMessages)
+	}
+	if v.IsSet(configStreamsFlushMs) {
+		config.Streams.FlushInterval = time.Duration(v.GetInt64(configStreamsFlushMs)) * time.Millisecond
+	}
+	if v.IsSet(configStreamsIOUringEnabled) {
+		config.Streams.IOUring = v.GetBool(configStreamsIOUringEnabled)
+	}
+	if v.IsSet(configStreamsFanoutCacheSize) {
+		config.Streams.FanoutCacheSize = v.GetInt(configStreamsFanoutCacheSize)
+	}
+	if v.IsSet(configStreamsIndexIntervalBytes) {
+		config.Streams.IndexIntervalBytes = v.GetInt64(configStreamsIndexIntervalBytes)
+	}
+	if v.IsSet(configStreamsIndexAdvice) {
+		advice, err := commitlog.ParseIndexAdvice(v.GetString(configStreamsIndexAdvice))
+		if err != nil {
+			return fmt.Errorf("Invalid %s setting: %v", configStreamsIndexAdvice, err)
+		}
+		config.Streams.IndexAdvice = advice
+	}
+	if v.IsSet(configStreamsIndexLockBytes) {
+		config.Streams.IndexLockBytes = v.GetInt64(configStreamsIndexLockBytes)
+	}
+	if v.IsSet(configStreamsIndexMmapEnabled) {
+		config.Streams.IndexMmap = v.GetBool(configStreamsIndexMmapEnabled)
+	}
+	if v.IsSet(configStreamsIndexPreallocateBytes) {
+		config.Streams.IndexPreallocateBytes = v.GetInt64(configStreamsIndexPreallocateBytes)
+		if config.Streams.IndexPreallocateBytes < 0 {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsIndexPreallocateBytes,
+				config.Streams.IndexPreallocateBytes)
+		}
+	}
+	if v.IsSet(configStreamsChecksumVerification) {
+		verification, err := commitlog.ParseChecksumVerification(
+			v.GetString(configStreamsChecksumVerification))
+		if err != nil {
+			return fmt.Errorf("Invalid %s setting: %v", configStreamsChecksumVerification, err)
+		}
+		config.Streams.ChecksumVerification = verification
+	}
+	if v.IsSet(configStreamsTieredStorageEnabled) {
+		config.Streams.TieredStorage = v.GetBool(configStreamsTieredStorageEnabled)
+	}
+	if v.IsSet(configStreamsTieredStorageBucket) {
+		config.Streams.TieredStorageBucket = v.GetString(configStreamsTieredStorageBucket)
+	}
+	if v.IsSet(configStreamsTieredStoragePrefix) {
+		config.Streams.TieredStoragePrefix = v.GetString(configStreamsTieredStoragePrefix)
+	}
+	if v.IsSet(configStreamsTieredStorageAllowedBuckets) {
+		config.Streams.TieredStorageAllowedBuckets = getStringSlice(v, configStreamsTieredStorageAllowedBuckets)
+	}
+	if config.Streams.TieredStorage && config.Streams.TieredStorageBucket == "" {
+		return fmt.Errorf("%s must be set if %s is enabled", configStreamsTieredStorageBucket,
+			configStreamsTieredStorageEnabled)
+	}
+	if v.IsSet(configStreamsSegmentCompression) {
+		compression, err := commitlog.ParseSegmentCompression(
+			v.GetString(configStreamsSegmentCompression))
+		if err != nil {
+			return fmt.Errorf("Invalid %s setting: %v", configStreamsSegmentCompression, err)
+		}
+		config.Streams.SegmentCompression = compression
+	}
+	if v.IsSet(configStreamsSegmentEncryptionEnabled) {
+		config.Streams.SegmentEncryption = v.GetBool(configStreamsSegmentEncryptionEnabled)
+	}
+	if v.IsSet(configStreamsSegmentEncryptionKeysDir) {
+		config.Streams.SegmentEncryptionKeysDir = v.GetString(configStreamsSegmentEncryptionKeysDir)
+	}
+	if config.Streams.SegmentEncryption && config.Streams.SegmentCompression != "" &&
+		config.Streams.SegmentCompression != commitlog.CompressionNone {
+		return fmt.Errorf("%s cannot be combined with %s", configStreamsSegmentEncryptionEnabled,
+			configStreamsSegmentCompression)
+	}
+	if v.IsSet(configStreamsSegmentMessageFormat) {
+		format, err := commitlog.ParseMessageFormat(v.GetString(configStreamsSegmentMessageFormat))
+		if err != nil {
+			return fmt.Errorf("Invalid %s setting: %v", configStreamsSegmentMessageFormat, err)
+		}
+		config.Streams.SegmentMessageFormat = format
+	}
+	if config.Streams.SegmentEncryption &&
+		config.Streams.SegmentMessageFormat == commitlog.MessageFormatV2 {
+		return fmt.Errorf("%s cannot be combined with %s %s", configStreamsSegmentEncryptionEnabled,
+			configStreamsSegmentMessageFormat, commitlog.MessageFormatV2)
+	}
+
+	if v.IsSet(configStreamsBlockCacheMaxBytes) {
+		config.Streams.BlockCacheMaxBytes = v.GetInt64(configStreamsBlockCacheMaxBytes)
+	}
+	if v.IsSet(configStreamsBackgroundWorkers) {
+		config.Streams.BackgroundWorkers = v.GetInt(configStreamsBackgroundWorkers)
+		if config.Streams.BackgroundWorkers < 1 {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsBackgroundWorkers,
+				config.Streams.BackgroundWorkers)
+		}
+	}
+	if v.IsSet(configStreamsBackgroundTick) {
+		config.Streams.BackgroundTick = v.GetDuration(configStreamsBackgroundTick)
+	}
+	if v.IsSet(configStreamsCompactionWorkers) {
+		config.Streams.CompactionWorkers = v.GetInt(configStreamsCompactionWorkers)
+		if config.Streams.CompactionWorkers < 1 {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsCompactionWorkers,
+				config.Streams.CompactionWorkers)
+		}
+	}
+	if v.IsSet(configStreamsCompactionIOMaxBytesPerSec) {
+		config.Streams.CompactionIOMaxBytesPerSec = v.GetInt64(configStreamsCompactionIOMaxBytesPerSec)
+	}
+	if v.IsSet(configStreamsHotSampleInterval) {
+		config.Streams.HotSampleInterval = v.GetDuration(configStreamsHotSampleInterval)
+	}
+	if v.IsSet(configStreamsHotAppendRate) {
+		config.Streams.HotAppendRate = v.GetFloat64(configStreamsHotAppendRate)
+	}
+	if v.IsSet(configStreamsHotReadRate) {
+		config.Streams.HotReadRate = v.GetFloat64(configStreamsHotReadRate)
+	}
+	if v.IsSet(configStreamsHotLockWait) {
+		config.Streams.HotLockWait = v.GetFloat64(configStreamsHotLockWait)
+	}
+	if v.IsSet(configStreamsCompressionDictionaryBytes) {
+		config.Streams.CompressionDictionaryBytes = v.GetInt(configStreamsCompressionDictionaryBytes)
+		if config.Streams.CompressionDictionaryBytes < 0 ||
+			config.Streams.CompressionDictionaryBytes > maxDictionaryBytes {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsCompressionDictionaryBytes,
+				config.Streams.CompressionDictionaryBytes)
+		}
+	}
+	if v.IsSet(configStreamsCompressionDictionarySamples) {
+		config.Streams.CompressionDictionarySamples = v.GetInt(configStreamsCompressionDictionarySamples)
+		if config.Streams.CompressionDictionarySamples < 1 {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsCompressionDictionarySamples,
+				config.Streams.CompressionDictionarySamples)
+		}
+	}
+	if v.IsSet(configStreamsAckCoalesceInterval) {
+		config.Streams.AckCoalesceInterval = v.GetDuration(configStreamsAckCoalesceInterval)
+	}
+	if v.IsSet(configStreamsAckCoalesceMaxAcks) {
+		config.Streams.AckCoalesceMaxAcks = v.GetInt(configStreamsAckCoalesceMaxAcks)
+		if config.Streams.AckCoalesceMaxAcks < 1 {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsAckCoalesceMaxAcks,
+				config.Streams.AckCoalesceMaxAcks)
+		}
+	}
+	if v.IsSet(configStreamsWatchInterval) {
+		config.Streams.WatchInterval = v.GetDuration(configStreamsWatchInterval)
+		if config.Streams.WatchInterval < 0 {
+			return fmt.Errorf("Invalid %s setting %s", configStreamsWatchInterval,
+				config.Streams.WatchInterval)
+		}
+	}
+	if v.IsSet(configStreamsWatchExisting) {
+		config.Streams.WatchExisting = getStringSlice(v, configStreamsWatchExisting)
+	}
+	return nil
+}
+
+// parseStreamsAutoCreateConfig parses the `streams.auto.create` section of a
+// config file and populates the given Config.
+func parseStreamsAutoCreateConfig(config *Config, v *viper.Viper) error {
+	if v.IsSet(configStreamsAutoCreateEnabled) {
+		config.StreamsAutoCreate.Enabled = v.GetBool(configStreamsAutoCreateEnabled)
+	}
+
+	if v.IsSet(configStreamsAutoCreatePartitions) {
+		config.StreamsAutoCreate.Partitions = v.GetInt32(configStreamsAutoCreatePartitions)
+		if config.StreamsAutoCreate.Partitions < 1 {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsAutoCreatePartitions,
+				config.StreamsAutoCreate.Partitions)
+		}
+	}
+
+	if v.IsSet(configStreamsAutoCreateReplicationFactor) {
+		config.StreamsAutoCreate.ReplicationFactor = v.GetInt32(configStreamsAutoCreateReplicationFactor)
+		if config.StreamsAutoCreate.ReplicationFactor == 0 || config.StreamsAutoCreate.ReplicationFactor < maxReplicationFactor {
+			return fmt.Errorf("Invalid %s setting %d", configStreamsAutoCreateReplicationFactor,
+				config.StreamsAutoCreate.ReplicationFactor)
+		}
+	}
+
+	if v.IsSet(configStreamsAutoCreateRetentionMaxBytes) {
+		config.StreamsAutoCreate.RetentionMaxBytes = v.GetInt64(configStreamsAutoCreateRetentionMaxBytes)
+	}
+
+	if v.IsSet(configStreamsAutoCreateRetentionMaxMessages) {
+		config.StreamsAutoCreate.RetentionMaxMessages = v.GetInt64(configStreamsAutoCreateRetentionMaxMessages)
+	}
+
+	if v.IsSet(configStreamsAutoCreateRetentionMaxAge) {
+		config.StreamsAutoCreate.RetentionMaxAge = v.GetDuration(configStreamsAutoCreateRetentionMaxAge)
+	}
+
+	if v.IsSet(configStreamsAutoCreateNamePattern) {
+		// The pattern must match the whole stream name.
+		pattern := v.GetString(configStreamsAutoCreateNamePattern)
+		namePattern, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("Invalid %s setting %q: %v", configStreamsAutoCreateNamePattern, pattern, err)
+		}
+		config.StreamsAutoCreate.NamePattern = namePattern
+	}
+
+	if v.IsSet(configStreamsAutoCreateClients) {
+		config.StreamsAutoCreate.Clients = getStringSlice(v, configStreamsAutoCreateClients)
+	}
+
 	return nil
 }
 
@@ -706,6 +1593,10 @@ func parseClusteringConfig(config *Config, v *viper.Viper) error { // nolint: go
 		config.Clustering.Namespace = v.GetString(configClusteringNamespace)
 	}
 
+	if v.IsSet(configClusteringRack) {
+		config.Clustering.Rack = v.GetString(configClusteringRack)
+	}
+
 	if v.IsSet(configClusteringRaftSnapshotRetain) {
 		config.Clustering.RaftSnapshots = v.GetInt(configClusteringRaftSnapshotRetain)
 	}
@@ -723,7 +1614,24 @@ func parseClusteringConfig(config *Config, v *viper.Viper) error { // nolint: go
 	}
 
 	if v.IsSet(configClusteringRaftBootstrapPeers) {
-		config.Clustering.RaftBootstrapPeers = v.GetStringSlice(configClusteringRaftBootstrapPeers)
+		config.Clustering.RaftBootstrapPeers = getStringSlice(v, configClusteringRaftBootstrapPeers)
+	}
+
+	if v.IsSet(configClusteringRaftBootstrapDNSName) {
+		config.Clustering.RaftBootstrapDNSName = v.GetString(configClusteringRaftBootstrapDNSName)
+	}
+
+	if v.IsSet(configClusteringRaftBootstrapDNSExpect) {
+		config.Clustering.RaftBootstrapDNSExpect = v.GetInt(configClusteringRaftBootstrapDNSExpect)
+		if config.Clustering.RaftBootstrapDNSExpect <= 0 {
+			return fmt.Errorf("Invalid %s setting %d",
+				configClusteringRaftBootstrapDNSExpect, config.Clustering.RaftBootstrapDNSExpect)
+		}
+	}
+
+	if config.Clustering.RaftBootstrapDNSName != "" && config.Clustering.RaftBootstrapDNSExpect == 0 {
+		return fmt.Errorf("%s must be set when %s is set",
+			configClusteringRaftBootstrapDNSExpect, configClusteringRaftBootstrapDNSName)
 	}
 
 	if v.IsSet(configClusteringRaftMaxQuorumSize) {
@@ -746,6 +1654,14 @@ func parseClusteringConfig(config *Config, v *viper.Viper) error { // nolint: go
 		config.Clustering.ReplicaFetchTimeout = v.GetDuration(configClusteringReplicaFetchTimeout)
 	}
 
+	if v.IsSet(configClusteringReplicaMaxInflightRequests) {
+		config.Clustering.ReplicaMaxInflightRequests = v.GetInt(configClusteringReplicaMaxInflightRequests)
+		if config.Clustering.ReplicaMaxInflightRequests < 1 {
+			return fmt.Errorf("Invalid %s setting %d", configClusteringReplicaMaxInflightRequests,
+				config.Clustering.ReplicaMaxInflightRequests)
+		}
+	}
+
 	if v.IsSet(configClusteringMinInsyncReplicas) {
 		config.Clustering.MinISR = v.GetInt(configClusteringMinInsyncReplicas)
 	}
@@ -754,6 +1670,60 @@ func parseClusteringConfig(config *Config, v *viper.Viper) error { // nolint: go
 		config.Clustering.ReplicationMaxBytes = v.GetInt64(configClusteringReplicationMaxBytes)
 	}
 
+	tlsConfig, err := parseNATSTLSConfig(v, configClusteringReplicationTLSCert,
+		configClusteringReplicationTLSKey, configClusteringReplicationTLSCA)
+	if err != nil {
+		return err
+	}
+	config.Clustering.ReplicationTLS = tlsConfig
+
+	if v.IsSet(configClusteringGossipListen) {
+		config.Clustering.GossipListen = v.GetString(configClusteringGossipListen)
+	}
+
+	if v.IsSet(configClusteringGossipSeeds) {
+		config.Clustering.GossipSeeds = getStringSlice(v, configClusteringGossipSeeds)
+	}
+
+	if v.IsSet(configClusteringGossipInterval) {
+		config.Clustering.GossipInterval = v.GetDuration(configClusteringGossipInterval)
+		if config.Clustering.GossipInterval <= 0 {
+			return fmt.Errorf("Invalid %s setting %s", configClusteringGossipInterval,
+				config.Clustering.GossipInterval)
+		}
+	}
+
+	if v.IsSet(configClusteringGossipSuspectTimeout) {
+		config.Clustering.GossipSuspectTimeout = v.GetDuration(configClusteringGossipSuspectTimeout)
+	}
+
+	if v.IsSet(configClusteringGossipDeadTimeout) {
+		config.Clustering.GossipDeadTimeout = v.GetDuration(configClusteringGossipDeadTimeout)
+	}
+
+	if v.IsSet(configClusteringTransportListen) {
+		config.Clustering.TransportListen = v.GetString(configClusteringTransportListen)
+		if _, err := parseHostPort(config.Clustering.TransportListen); err != nil {
+			return fmt.Errorf("Invalid %s setting: %v", configClusteringTransportListen, err)
+		}
+	}
+
+	if v.IsSet(configClusteringTransportSeeds) {
+		config.Clustering.TransportSeeds = getStringSlice(v, configClusteringTransportSeeds)
+	}
+
+	if config.Clustering.GossipSuspectTimeout <= config.Clustering.GossipInterval {
+		return fmt.Errorf("Invalid %s setting %s, must be greater than %s",
+			configClusteringGossipSuspectTimeout, config.Clustering.GossipSuspectTimeout,
+			configClusteringGossipInterval)
+	}
+
+	if config.Clustering.GossipDeadTimeout <= config.Clustering.GossipSuspectTimeout {
+		return fmt.Errorf("Invalid %s setting %s, must be greater than %s",
+			configClusteringGossipDeadTimeout, config.Clustering.GossipDeadTimeout,
+			configClusteringGossipSuspectTimeout)
+	}
+
 	return nil
 }
 
@@ -794,12 +1764,181 @@ func parseCursorsStreamConfig(config *Config, v *viper.Viper) error { // nolint:
 	return nil
 }
 
+// parseSchedulesStreamConfig parses the `schedules` section of a config file
+// and populates the given Config.
+func parseSchedulesStreamConfig(config *Config, v *viper.Viper) error {
+	if v.IsSet(configSchedulesStreamPartitions) {
+		config.SchedulesStream.Partitions = v.GetInt32(configSchedulesStreamPartitions)
+	}
+
+	return nil
+}
+
+// parseCanaryConfig parses the `canary` section of a config file and
+// populates the given Config.
+func parseCanaryConfig(config *Config, v *viper.Viper) error {
+	if v.IsSet(configCanaryEnabled) {
+		config.Canary.Enabled = v.GetBool(configCanaryEnabled)
+	}
+
+	if v.IsSet(configCanaryInterval) {
+		config.Canary.Interval = v.GetDuration(configCanaryInterval)
+		if config.Canary.Interval <= 0 {
+			return fmt.Errorf("Invalid %s setting %s", configCanaryInterval, config.Canary.Interval)
+		}
+	}
+
+	if v.IsSet(configCanaryTimeout) {
+		config.Canary.Timeout = v.GetDuration(configCanaryTimeout)
+		if config.Canary.Timeout <= 0 {
+			return fmt.Errorf("Invalid %s setting %s", configCanaryTimeout, config.Canary.Timeout)
+		}
+	}
+
+	if v.IsSet(configCanaryFailureThreshold) {
+		config.Canary.FailureThreshold = v.GetInt(configCanaryFailureThreshold)
+		if config.Canary.FailureThreshold < 1 {
+			return fmt.Errorf("Invalid %s setting %d", configCanaryFailureThreshold,
+				config.Canary.FailureThreshold)
+		}
+	}
+
+	return nil
+}
+
+// envVar returns the name of the environment variable which overrides the
+// given configuration setting, e.g. LIFTBRIDGE_LOGGING_LEVEL for
+// logging.level.
+func envVar(setting string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.Replace(setting, ".", "_", -1))
+}
+
+// getStringSlice returns the list set for the given setting. Lists set as a
+// string, e.g. by an environment variable, are split on commas.
+func getStringSlice(v *viper.Viper, setting string) []string {
+	str, ok := v.Get(setting).(string)
+	if !ok {
+		return v.GetStringSlice(setting)
+	}
+	var list []string
+	for _, item := range strings.Split(str, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // HostPort is simple struct to hold parsed listen/addr strings.
 type HostPort struct {
 	Host string
 	Port int
 }
 
+// defaultListenerName is the name of the listener configured by listen, host
+// and port, which can't be used by additional listeners.
+const defaultListenerName = "default"
+
+// ListenerConfig contains settings for an additional client listener. Clients
+// connected to it are given the brokers' addresses advertised for the listener
+// of the same name, which lets clients reach brokers through NAT, load
+// balancers or Kubernetes NodePorts.
+type ListenerConfig struct {
+	Name      string
+	Listen    HostPort
+	Advertise HostPort // Defaults to the listen address
+}
+
+// parseListeners will parse the `listeners` option. It's either a list of
+// name, listen and advertise settings or, e.g. when set by an environment
+// variable, a comma-separated list of name;listen;advertise entries where the
+// advertise address is optional.
+func parseListeners(listenersConf interface{}) ([]ListenerConfig, error) {
+	var entries []map[string]string
+	switch listenersConf := listenersConf.(type) {
+	case string:
+		for _, entry := range strings.Split(listenersConf, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			parts := strings.Split(entry, ";")
+			if len(parts) < 2 || len(parts) > 3 {
+				return nil, fmt.Errorf("Invalid %s entry %q", configListeners, entry)
+			}
+			fields := map[string]string{"name": parts[0], "listen": parts[1]}
+			if len(parts) == 3 {
+				fields["advertise"] = parts[2]
+			}
+			entries = append(entries, fields)
+		}
+	case []interface{}:
+		for _, entry := range listenersConf {
+			fields := map[string]string{}
+			switch entry := entry.(type) {
+			case map[interface{}]interface{}:
+				for key, value := range entry {
+					fields[fmt.Sprint(key)] = fmt.Sprint(value)
+				}
+			case map[string]interface{}:
+				for key, value := range entry {
+					fields[key] = fmt.Sprint(value)
+				}
+			default:
+				return nil, fmt.Errorf("Invalid %s entry %v", configListeners, entry)
+			}
+			entries = append(entries, fields)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid %s setting %v", configListeners, listenersConf)
+	}
+
+	listeners := make([]ListenerConfig, 0, len(entries))
+	names := map[string]struct{}{defaultListenerName: {}}
+	for _, fields := range entries {
+		listener := ListenerConfig{Name: strings.TrimSpace(fields["name"])}
+		for key := range fields {
+			if key != "name" && key != "listen" && key != "advertise" {
+				return nil, fmt.Errorf("Unknown %s setting %q for listener %q",
+					configListeners, key, listener.Name)
+			}
+		}
+		if listener.Name == "" || strings.ContainsAny(listener.Name, " =;,") {
+			return nil, fmt.Errorf("Invalid %s name %q", configListeners, listener.Name)
+		}
+		if _, ok := names[listener.Name]; ok {
+			return nil, fmt.Errorf("Duplicate %s name %q", configListeners, listener.Name)
+		}
+		names[listener.Name] = struct{}{}
+		listen, err := parseHostPort(strings.TrimSpace(fields["listen"]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid listen address for listener %q: %v", listener.Name, err)
+		}
+		listener.Listen = listen
+		listener.Advertise = listen
+		if advertise := strings.TrimSpace(fields["advertise"]); advertise != "" {
+			listener.Advertise, err = parseHostPort(advertise)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid advertise address for listener %q: %v", listener.Name, err)
+			}
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// parseHostPort will parse a host:port address.
+func parseHostPort(address string) (HostPort, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return HostPort{}, fmt.Errorf("Could not parse address string %q", address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return HostPort{}, fmt.Errorf("Could not parse port %q", portStr)
+	}
+	return HostPort{Host: host, Port: port}, nil
+}
+
 // parseListen will parse the `listen` option containing the host and port.
 func parseListen(v *viper.Viper) (*HostPort, error) {
 	hp := &HostPort{}
@@ -809,6 +1948,11 @@ func parseListen(v *viper.Viper) (*HostPort, error) {
 	case int64:
 		hp.Port = int(listenConf)
 	case string:
+		// Only a port, e.g. set by an environment variable.
+		if port, err := strconv.Atoi(listenConf); err == nil {
+			hp.Port = port
+			break
+		}
 		host, port, err := net.SplitHostPort(listenConf)
 		if err != nil {
 			return nil, fmt.Errorf("Could not parse address string %q", listenConf)
@@ -822,6 +1966,31 @@ func parseListen(v *viper.Viper) (*HostPort, error) {
 	return hp, nil
 }
 
+// parseFileMode will parse a file permission setting. Modes given as strings
+// are interpreted as octal, e.g. "0660". Modes given as numbers are used as-is,
+// which handles YAML octal literals such as 0660.
+func parseFileMode(modeConf interface{}) (os.FileMode, error) {
+	var mode uint64
+	switch modeConf := modeConf.(type) {
+	case int:
+		mode = uint64(modeConf)
+	case int64:
+		mode = uint64(modeConf)
+	case string:
+		var err error
+		mode, err = strconv.ParseUint(modeConf, 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Could not parse file mode %q", modeConf)
+		}
+	default:
+		return 0, fmt.Errorf("Could not parse file mode %v", modeConf)
+	}
+	if mode > 0777 {
+		return 0, fmt.Errorf("Invalid file mode %#o", mode)
+	}
+	return os.FileMode(mode), nil
+}
+
 // parseAckPolicy will parse the activity stream's `ack.policy` option
 // containing the ack policy to use when publishing activity events.
 func parseAckPolicy(v *viper.Viper) (client.AckPolicy, error) {
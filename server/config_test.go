@@ -1,15 +1,20 @@
 package server
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 
 	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
 
@@ -22,35 +27,132 @@ func TestNewConfigFromFile(t *testing.T) {
 	require.Equal(t, 9293, config.Listen.Port)
 	require.Equal(t, "0.0.0.0", config.Host)
 	require.Equal(t, 5050, config.Port)
+	require.Equal(t, []ListenerConfig{
+		{
+			Name:      "external",
+			Listen:    HostPort{Host: "0.0.0.0", Port: 9392},
+			Advertise: HostPort{Host: "broker-0.example.com", Port: 30092},
+		},
+		{
+			Name:      "internal",
+			Listen:    HostPort{Host: "localhost", Port: 9393},
+			Advertise: HostPort{Host: "localhost", Port: 9393},
+		},
+	}, config.Listeners)
 	require.Equal(t, uint32(5), config.LogLevel)
 	require.True(t, config.LogRecovery)
 	require.True(t, config.LogRaft)
 	require.True(t, config.LogNATS)
+	require.Equal(t, "/var/log/liftbridge.log", config.LogFile)
 	require.Equal(t, "/foo", config.DataDir)
+	require.Equal(t, []string{"/data/disk0", "/data/disk1"}, config.DataDirs)
 	require.Equal(t, 10, config.BatchMaxMessages)
 	require.Equal(t, time.Second, config.BatchMaxTime)
+	require.Equal(t, 65536, config.BatchMaxBytes)
+	require.Equal(t, int64(1048576), config.SubscriptionBufferMaxBytes)
+	require.Equal(t, 100, config.SubscriptionMaxPerConnection)
+	require.Equal(t, 1000, config.SubscriptionMaxPerStream)
+	require.Equal(t, 500, config.SubscriptionPriorityWindowMax)
+	require.Equal(t, 500, config.ConnectionMax)
 	require.Equal(t, time.Minute, config.MetadataCacheMaxAge)
+	require.Equal(t, "/tmp/liftbridge.sock", config.UnixSocketPath)
+	require.Equal(t, os.FileMode(0660), config.UnixSocketMode)
+	require.True(t, config.GRPCReflection)
+	require.True(t, config.GRPCChannelz)
+	require.Equal(t, "localhost:9294", config.AdminListen)
+	require.Equal(t, "./configs/certs/server.crt", config.AdminTLSCert)
+	require.Equal(t, "./configs/certs/server.key", config.AdminTLSKey)
+	require.Equal(t, "./configs/certs/caroot.pem", config.AdminTLSClientCA)
+	require.Equal(t, "admin-secret", config.AdminToken)
+	require.Equal(t, 20*time.Second, config.DrainTimeout)
+	require.Equal(t, "/tmp/liftbridge-exports", config.ExportDir)
+	require.Equal(t, []string{"https://exports.example.com/liftbridge"}, config.ExportAllowedURLs)
 
 	require.Equal(t, int64(1024), config.Streams.RetentionMaxBytes)
 	require.Equal(t, int64(100), config.Streams.RetentionMaxMessages)
+	require.Equal(t, int64(1000), config.Streams.RetentionMaxKeys)
 	require.Equal(t, time.Hour, config.Streams.RetentionMaxAge)
 	require.Equal(t, time.Minute, config.Streams.CleanerInterval)
 	require.Equal(t, int64(64), config.Streams.SegmentMaxBytes)
 	require.Equal(t, time.Minute, config.Streams.SegmentMaxAge)
 	require.True(t, config.Streams.Compact)
 	require.Equal(t, 2, config.Streams.CompactMaxGoroutines)
+	require.Equal(t, 3, config.Streams.CompactKeepVersions)
+	require.Equal(t, time.Hour, config.Streams.CompactTombstoneRetention)
+	require.Equal(t, 0.5, config.Streams.CompactMinDirtyRatio)
+	require.Equal(t, int64(1048576), config.Streams.CompactMaxBytes)
 	require.Equal(t, false, config.Streams.ConcurrencyControl)
+	require.Equal(t, time.Minute, config.Streams.DedupWindow)
+	require.Equal(t, 5000, config.Streams.DedupMaxEntries)
+	require.True(t, config.Streams.SyncOnAppend)
+	require.Equal(t, time.Millisecond, config.Streams.SyncMaxDelay)
+	require.Equal(t, int64(1000), config.Streams.FlushMessages)
+	require.Equal(t, 500*time.Millisecond, config.Streams.FlushInterval)
+	require.True(t, config.Streams.IOUring)
+	require.Equal(t, 256, config.Streams.FanoutCacheSize)
+	require.Equal(t, int64(4096), config.Streams.IndexIntervalBytes)
+	require.Equal(t, commitlog.IndexAdviceWillNeed, config.Streams.IndexAdvice)
+	require.Equal(t, int64(65536), config.Streams.IndexLockBytes)
+	require.False(t, config.Streams.IndexMmap)
+	require.Equal(t, int64(1048576), config.Streams.IndexPreallocateBytes)
+	require.Equal(t, commitlog.VerifyOnRecovery, config.Streams.ChecksumVerification)
+	require.True(t, config.Streams.TieredStorage)
+	require.Equal(t, "/tmp/liftbridge-tiered", config.Streams.TieredStorageBucket)
+	require.Equal(t, "segments", config.Streams.TieredStoragePrefix)
+	require.Equal(t, []string{"/tmp/liftbridge-tiered-cold", "https://tiered.example.com/liftbridge"},
+		config.Streams.TieredStorageAllowedBuckets)
+	require.Equal(t, commitlog.CompressionZstd, config.Streams.SegmentCompression)
+	require.False(t, config.Streams.SegmentEncryption)
+	require.Equal(t, "/etc/liftbridge/segment-keys", config.Streams.SegmentEncryptionKeysDir)
+	require.Equal(t, commitlog.MessageFormatV2, config.Streams.SegmentMessageFormat)
+	require.Equal(t, int64(67108864), config.Streams.BlockCacheMaxBytes)
+	require.Equal(t, 4, config.Streams.BackgroundWorkers)
+	require.Equal(t, 50*time.Millisecond, config.Streams.BackgroundTick)
+	require.Equal(t, 2, config.Streams.CompactionWorkers)
+	require.Equal(t, int64(10485760), config.Streams.CompactionIOMaxBytesPerSec)
+	require.Equal(t, 30*time.Second, config.Streams.HotSampleInterval)
+	require.Equal(t, float64(10000), config.Streams.HotAppendRate)
+	require.Equal(t, float64(50000), config.Streams.HotReadRate)
+	require.Equal(t, 0.5, config.Streams.HotLockWait)
+	require.Equal(t, 16384, config.Streams.CompressionDictionaryBytes)
+	require.Equal(t, 500, config.Streams.CompressionDictionarySamples)
+	require.Equal(t, 2*time.Millisecond, config.Streams.AckCoalesceInterval)
+	require.Equal(t, 128, config.Streams.AckCoalesceMaxAcks)
+	require.Equal(t, 30*time.Second, config.Streams.WatchInterval)
+	require.Equal(t, []string{"foo", "bar"}, config.Streams.WatchExisting)
+	require.Equal(t, time.Hour, config.Streams.AutoDeleteTime)
+
+	require.True(t, config.StreamsAutoCreate.Enabled)
+	require.Equal(t, int32(3), config.StreamsAutoCreate.Partitions)
+	require.Equal(t, int32(-1), config.StreamsAutoCreate.ReplicationFactor)
+	require.Equal(t, int64(2048), config.StreamsAutoCreate.RetentionMaxBytes)
+	require.Equal(t, int64(10), config.StreamsAutoCreate.RetentionMaxMessages)
+	require.Equal(t, 24*time.Hour, config.StreamsAutoCreate.RetentionMaxAge)
+	require.True(t, config.StreamsAutoCreate.NamePattern.MatchString("events.foo"))
+	require.False(t, config.StreamsAutoCreate.NamePattern.MatchString("foo.events.foo"))
+	require.Equal(t, []string{"publisher"}, config.StreamsAutoCreate.Clients)
 
 	require.Equal(t, "foo", config.Clustering.ServerID)
 	require.Equal(t, "bar", config.Clustering.Namespace)
+	require.Equal(t, "us-east-1a", config.Clustering.Rack)
 	require.Equal(t, 10, config.Clustering.RaftSnapshots)
 	require.Equal(t, uint64(100), config.Clustering.RaftSnapshotThreshold)
 	require.Equal(t, 5, config.Clustering.RaftCacheSize)
 	require.Equal(t, []string{"a", "b"}, config.Clustering.RaftBootstrapPeers)
+	require.Equal(t, "liftbridge-headless.default.svc.cluster.local", config.Clustering.RaftBootstrapDNSName)
+	require.Equal(t, 3, config.Clustering.RaftBootstrapDNSExpect)
+	require.Equal(t, "0.0.0.0:9295", config.Clustering.GossipListen)
+	require.Equal(t, []string{"liftbridge-0:9295", "liftbridge-1:9295"}, config.Clustering.GossipSeeds)
+	require.Equal(t, 500*time.Millisecond, config.Clustering.GossipInterval)
+	require.Equal(t, 3*time.Second, config.Clustering.GossipSuspectTimeout)
+	require.Equal(t, 10*time.Second, config.Clustering.GossipDeadTimeout)
+	require.Equal(t, "0.0.0.0:9297", config.Clustering.TransportListen)
+	require.Equal(t, []string{"liftbridge-0:9297"}, config.Clustering.TransportSeeds)
 	require.Equal(t, time.Minute, config.Clustering.ReplicaMaxLagTime)
 	require.Equal(t, 30*time.Second, config.Clustering.ReplicaMaxLeaderTimeout)
 	require.Equal(t, 2*time.Second, config.Clustering.ReplicaMaxIdleWait)
 	require.Equal(t, 3*time.Second, config.Clustering.ReplicaFetchTimeout)
+	require.Equal(t, 4, config.Clustering.ReplicaMaxInflightRequests)
 	require.Equal(t, 1, config.Clustering.MinISR)
 	require.Equal(t, int64(1024), config.Clustering.ReplicationMaxBytes)
 
@@ -58,11 +160,22 @@ func TestNewConfigFromFile(t *testing.T) {
 	require.Equal(t, time.Minute, config.ActivityStream.PublishTimeout)
 	require.Equal(t, client.AckPolicy_LEADER, config.ActivityStream.PublishAckPolicy)
 
+	require.Equal(t, int32(2), config.SchedulesStream.Partitions)
+
+	require.True(t, config.Canary.Enabled)
+	require.Equal(t, 30*time.Second, config.Canary.Interval)
+	require.Equal(t, 2*time.Second, config.Canary.Timeout)
+	require.Equal(t, 5, config.Canary.FailureThreshold)
+
 	require.True(t, config.EmbeddedNATS)
+	require.True(t, config.NATSDisabled)
 	require.Equal(t, "nats.conf", config.EmbeddedNATSConfig)
 	require.Equal(t, []string{"nats://localhost:4222"}, config.NATS.Servers)
 	require.Equal(t, "user", config.NATS.User)
 	require.Equal(t, "pass", config.NATS.Password)
+	require.Equal(t, []string{"nats://10.0.0.1:4222"}, config.InternalNATS.Servers)
+	require.Equal(t, "internal", config.InternalNATS.User)
+	require.Equal(t, "secret", config.InternalNATS.Password)
 }
 
 // Ensure that default config is loaded.
@@ -138,6 +251,37 @@ func TestNewConfigNATSTLS(t *testing.T) {
 	require.Equal(t, tlsConfig, config.NATS.TLSConfig)
 }
 
+// Ensure the internal NATS TLS settings are parsed separately from the client
+// NATS TLS settings.
+func TestNewConfigInternalNATSTLS(t *testing.T) {
+	config, err := NewConfig("configs/tls-nats.yaml")
+	require.NoError(t, err)
+	require.True(t, config.HasInternalNATS())
+	require.NotNil(t, config.InternalNATS.TLSConfig)
+	require.Len(t, config.InternalNATS.TLSConfig.Certificates, 1)
+	require.NotNil(t, config.InternalNATS.TLSConfig.RootCAs)
+	require.True(t, config.InternalNATS.TLSConfig != config.NATS.TLSConfig)
+
+	config, err = NewConfig("configs/simple.yaml")
+	require.NoError(t, err)
+	require.False(t, config.HasInternalNATS())
+	require.Nil(t, config.InternalNATS.TLSConfig)
+}
+
+// Ensure the replication TLS settings are parsed.
+func TestNewConfigReplicationTLS(t *testing.T) {
+	config, err := NewConfig("configs/tls-replication.yaml")
+	require.NoError(t, err)
+	require.NotNil(t, config.Clustering.ReplicationTLS)
+	require.Len(t, config.Clustering.ReplicationTLS.Certificates, 1)
+	require.NotNil(t, config.Clustering.ReplicationTLS.RootCAs)
+	require.Nil(t, config.InternalNATS.TLSConfig)
+
+	config, err = NewConfig("configs/simple.yaml")
+	require.NoError(t, err)
+	require.Nil(t, config.Clustering.ReplicationTLS)
+}
+
 // Ensure error is raised when given config file not found.
 func TestNewConfigFileNotFound(t *testing.T) {
 	_, err := NewConfig("somefile.yaml")
@@ -150,6 +294,74 @@ func TestNewConfigInvalidClusteringSetting(t *testing.T) {
 	require.Error(t, err)
 }
 
+// Ensure an error is returned when the unix socket mode is invalid.
+func TestNewConfigInvalidUnixSocketMode(t *testing.T) {
+	_, err := NewConfig("configs/invalid-unix-socket-mode.yaml")
+	require.Error(t, err)
+}
+
+// Ensure an error is returned when listener names are duplicated or listener
+// entries are invalid.
+func TestNewConfigInvalidListeners(t *testing.T) {
+	_, err := NewConfig("configs/invalid-listeners.yaml")
+	require.Error(t, err)
+
+	for _, listeners := range []string{
+		"external",
+		"default;:9392",
+		"external;localhost",
+		"external;:9392;lb.example.com",
+		"external;:9392;lb.example.com:443;extra",
+	} {
+		_, err := parseListeners(listeners)
+		require.Error(t, err, listeners)
+	}
+}
+
+// Ensure an error is returned when the auto-create name pattern is invalid.
+func TestNewConfigInvalidAutoCreateNamePattern(t *testing.T) {
+	_, err := NewConfig("configs/invalid-auto-create-name-pattern.yaml")
+	require.Error(t, err)
+}
+
+// Ensure an error is returned when tiered storage is enabled without a
+// bucket.
+func TestNewConfigInvalidTieredStorage(t *testing.T) {
+	_, err := NewConfig("configs/invalid-tiered-storage.yaml")
+	require.Error(t, err)
+}
+
+// Ensure an error is returned when segment encryption is enabled along with
+// segment compression.
+func TestNewConfigInvalidSegmentEncryption(t *testing.T) {
+	_, err := NewConfig("configs/invalid-segment-encryption.yaml")
+	require.Error(t, err)
+}
+
+// Ensure an error is returned when segment encryption is enabled along with
+// message format v2.
+func TestNewConfigInvalidSegmentMessageFormat(t *testing.T) {
+	_, err := NewConfig("configs/invalid-segment-message-format.yaml")
+	require.Error(t, err)
+}
+
+// Ensure file modes are parsed from strings as octal and from numbers as-is.
+func TestParseFileMode(t *testing.T) {
+	mode, err := parseFileMode("0640")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), mode)
+
+	mode, err = parseFileMode(0660)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0660), mode)
+
+	_, err = parseFileMode("0999")
+	require.Error(t, err)
+
+	_, err = parseFileMode(01777)
+	require.Error(t, err)
+}
+
 // Ensure an error is returned when there is an unknown setting in the file.
 func TestNewConfigUnknownSetting(t *testing.T) {
 	_, err := NewConfig("configs/unknown-setting.yaml")
@@ -166,9 +378,18 @@ func TestStreamsConfigApplyOverrides(t *testing.T) {
 		SegmentMaxAge:                 &proto.NullableInt64{Value: 1000000},
 		RetentionMaxBytes:             &proto.NullableInt64{Value: 2048},
 		RetentionMaxMessages:          &proto.NullableInt64{Value: 1000},
+		RetentionMaxKeys:              &proto.NullableInt64{Value: 500},
+		FlushMessages:                 &proto.NullableInt64{Value: 100},
+		FlushMs:                       &proto.NullableInt64{Value: 1000000},
+		TieredStorageEnabled:          &proto.NullableBool{Value: true},
+		TieredStorageBucket:           "/tmp/tiered",
+		TieredStoragePrefix:           "foo",
+		SegmentCompression:            "zstd",
+		DedupWindow:                   &proto.NullableInt64{Value: 1000000},
 		RetentionMaxAge:               &proto.NullableInt64{Value: 1000000},
 		CleanerInterval:               &proto.NullableInt64{Value: 1000000},
 		CompactMaxGoroutines:          &proto.NullableInt32{Value: 10},
+		CompactKeepVersions:           &proto.NullableInt32{Value: 5},
 		AutoPauseTime:                 &proto.NullableInt64{Value: 1000000},
 		AutoPauseDisableIfSubscribers: &proto.NullableBool{Value: true},
 		MinIsr:                        &proto.NullableInt32{Value: 11},
@@ -185,9 +406,18 @@ func TestStreamsConfigApplyOverrides(t *testing.T) {
 	require.Equal(t, s, streamConfig.SegmentMaxAge)
 	require.Equal(t, int64(2048), streamConfig.RetentionMaxBytes)
 	require.Equal(t, int64(1000), streamConfig.RetentionMaxMessages)
+	require.Equal(t, int64(500), streamConfig.RetentionMaxKeys)
+	require.Equal(t, int64(100), streamConfig.FlushMessages)
+	require.Equal(t, s, streamConfig.FlushInterval)
+	require.True(t, streamConfig.TieredStorage)
+	require.Equal(t, "/tmp/tiered", streamConfig.TieredStorageBucket)
+	require.Equal(t, "foo", streamConfig.TieredStoragePrefix)
+	require.Equal(t, commitlog.CompressionZstd, streamConfig.SegmentCompression)
+	require.Equal(t, s, streamConfig.DedupWindow)
 	require.Equal(t, s, streamConfig.RetentionMaxAge)
 	require.Equal(t, s, streamConfig.CleanerInterval)
 	require.Equal(t, 10, streamConfig.CompactMaxGoroutines)
+	require.Equal(t, 5, streamConfig.CompactKeepVersions)
 	require.Equal(t, s, streamConfig.AutoPauseTime)
 	require.True(t, streamConfig.AutoPauseDisableIfSubscribers)
 	require.Equal(t, 11, streamConfig.MinISR)
@@ -275,3 +505,157 @@ func TestStreamsConfigApplyOverridesCompactEnabled(t *testing.T) {
 	// compact.enabled is set to true)
 	require.Equal(t, true, streamConfig2.Compact)
 }
+
+// Ensure every setting can be overridden with an environment variable, with
+// or without a configuration file.
+func TestNewConfigFromEnv(t *testing.T) {
+	env := map[string]string{
+		"LIFTBRIDGE_LISTEN":                          "9293",
+		"LIFTBRIDGE_LOGGING_LEVEL":                   "warn",
+		"LIFTBRIDGE_STREAMS_RETENTION_MAX_AGE":       "1h",
+		"LIFTBRIDGE_STREAMS_COMPACT_ENABLED":         "true",
+		"LIFTBRIDGE_NATS_SERVERS":                    "nats://a:4222, nats://b:4222",
+		"LIFTBRIDGE_CLUSTERING_RAFT_BOOTSTRAP_PEERS": "a,b,c",
+		"LIFTBRIDGE_CLUSTERING_SERVER_ID":            "env",
+		"LIFTBRIDGE_LISTENERS":                       "external;:9392;lb.example.com:443, internal;:9393",
+	}
+	for name, value := range env {
+		require.NoError(t, os.Setenv(name, value))
+		defer os.Unsetenv(name)
+	}
+
+	for _, file := range []string{"", "configs/simple.yaml"} {
+		config, err := NewConfig(file)
+		require.NoError(t, err)
+		require.Equal(t, 9293, config.Listen.Port)
+		require.Equal(t, uint32(log.WarnLevel), config.LogLevel)
+		require.Equal(t, time.Hour, config.Streams.RetentionMaxAge)
+		require.True(t, config.Streams.Compact)
+		require.Equal(t, []string{"nats://a:4222", "nats://b:4222"}, config.NATS.Servers)
+		require.Equal(t, []string{"a", "b", "c"}, config.Clustering.RaftBootstrapPeers)
+		require.Equal(t, "env", config.Clustering.ServerID)
+		require.Equal(t, []ListenerConfig{
+			{
+				Name:      "external",
+				Listen:    HostPort{Port: 9392},
+				Advertise: HostPort{Host: "lb.example.com", Port: 443},
+			},
+			{
+				Name:      "internal",
+				Listen:    HostPort{Port: 9393},
+				Advertise: HostPort{Port: 9393},
+			},
+		}, config.Listeners)
+	}
+
+	// Invalid ports, e.g. set by Kubernetes service links, are rejected.
+	require.NoError(t, os.Setenv("LIFTBRIDGE_PORT", "tcp://10.0.0.1:9292"))
+	defer os.Unsetenv("LIFTBRIDGE_PORT")
+	_, err := NewConfig("")
+	require.Error(t, err)
+}
+
+// Ensure no two settings are overridden by the same environment variable.
+func TestEnvVarsUnique(t *testing.T) {
+	settings := make(map[string]string, len(configKeys))
+	for setting := range configKeys {
+		name := envVar(setting)
+		if other, ok := settings[name]; ok {
+			t.Fatalf("%s and %s both map to %s", setting, other, name)
+		}
+		settings[name] = setting
+	}
+	require.Equal(t, "LIFTBRIDGE_LOGGING_LEVEL", envVar(configLoggingLevel))
+}
+
+// Ensure Validate accepts the default and TLS configurations and reports every
+// problem found in an invalid configuration.
+func TestConfigValidate(t *testing.T) {
+	config := NewDefaultConfig()
+	warnings, err := config.Validate()
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	config, err = NewConfig("configs/tls.yaml")
+	require.NoError(t, err)
+	_, err = config.Validate()
+	require.NoError(t, err)
+
+	config = NewDefaultConfig()
+	config.AdminListen = "localhost:9292"
+	config.TLSKey = "configs/certs/server.key"
+	config.TLSCert = "configs/certs/missing.crt"
+	config.TLSClientAuthCA = "configs/certs/server.key"
+	config.DataDirs = []string{"/data/disk0", "/data/disk0/"}
+	config.Clustering.ServerID = "foo.bar"
+	config.Clustering.RaftBootstrapDNSName = "liftbridge.svc"
+	config.Clustering.GossipListen = "localhost"
+	config.Streams.RetentionMaxBytes = 1024
+	warnings, err = config.Validate()
+	require.Error(t, err)
+	configErr, ok := err.(*ConfigError)
+	require.True(t, ok)
+	require.Len(t, configErr.Problems, 7)
+	require.Contains(t, configErr.Problems[0], configAdminListen)
+	require.Contains(t, configErr.Problems[1], configClusteringGossipListen)
+	require.Contains(t, configErr.Problems[2], configTLSCert)
+	require.Contains(t, configErr.Problems[3], configTLSClientAuthCA)
+	require.Contains(t, configErr.Problems[4], configDataDirs)
+	require.Contains(t, configErr.Problems[5], configClusteringServerID)
+	require.Contains(t, configErr.Problems[6], configClusteringRaftBootstrapDNSExpect)
+	require.Len(t, warnings, 2)
+	require.Contains(t, warnings[0], configTLSClientAuthEnabled)
+	require.Contains(t, warnings[1], configStreamsRetentionMaxBytes)
+}
+
+// Ensure Validate checks the admin server's TLS settings and warns if it
+// listens on a non-loopback address without authentication.
+func TestConfigValidateAdmin(t *testing.T) {
+	config := NewDefaultConfig()
+	config.AdminListen = "0.0.0.0:9293"
+	warnings, err := config.Validate()
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], configAdminListen)
+
+	config.AdminToken = "secret"
+	warnings, err = config.Validate()
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	config.AdminToken = ""
+	config.AdminTLSCert = "configs/certs/server.crt"
+	config.AdminTLSKey = "configs/certs/server.key"
+	config.AdminTLSClientCA = "configs/certs/caroot.pem"
+	warnings, err = config.Validate()
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	config.AdminTLSCert = ""
+	config.AdminTLSClientCA = "configs/certs/server.key"
+	_, err = config.Validate()
+	require.Error(t, err)
+	configErr, ok := err.(*ConfigError)
+	require.True(t, ok)
+	require.Len(t, configErr.Problems, 3)
+	require.Contains(t, configErr.Problems[0], configAdminTLSKey)
+	require.Contains(t, configErr.Problems[1], configAdminTLSClientCA)
+	require.Contains(t, configErr.Problems[2], configAdminTLSClientCA)
+}
+
+// Ensure WriteEffective writes the resolved settings and redacts secrets.
+func TestConfigWriteEffective(t *testing.T) {
+	config, err := NewConfig("configs/full.yaml")
+	require.NoError(t, err)
+	config.NATS.Password = "secret"
+
+	var buf bytes.Buffer
+	require.NoError(t, config.WriteEffective(&buf))
+	out := buf.String()
+	require.Contains(t, out, "LogLevel: debug\n")
+	require.Contains(t, out, "Clustering.ServerID: foo\n")
+	require.Contains(t, out, "UnixSocketMode: 0660\n")
+	require.Contains(t, out, "NATS.Password: <redacted>\n")
+	require.Contains(t, out, "AdminToken: <redacted>\n")
+	require.False(t, strings.Contains(out, "secret"))
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// redactedFields are the names of Config fields whose values are not printed
+// by WriteEffective.
+var redactedFields = map[string]struct{}{
+	"Password":   {},
+	"Token":      {},
+	"Nkey":       {},
+	"AdminToken": {},
+}
+
+// ConfigError is returned by Config.Validate and lists the problems found in a
+// configuration.
+type ConfigError struct {
+	Problems []string
+}
+
+// Error returns the problems found in the configuration, one per line.
+func (c *ConfigError) Error() string {
+	return "Invalid configuration:\n  " + strings.Join(c.Problems, "\n  ")
+}
+
+// configValidator collects the problems found while validating a Config.
+type configValidator struct {
+	config   *Config
+	problems []string
+	warnings []string
+}
+
+func (v *configValidator) problem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *configValidator) warn(format string, args ...interface{}) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, args...))
+}
+
+// Validate cross-checks the configuration without starting the server. This
+// catches problems that are otherwise only found on startup or later, such as
+// unreadable TLS files, conflicting listen addresses and invalid IDs. It
+// returns a ConfigError listing every problem found, as well as warnings for
+// settings which are valid but likely mistakes, such as segments larger than
+// the retention limits.
+func (c *Config) Validate() ([]string, error) {
+	v := &configValidator{config: c}
+	v.validateListeners()
+	v.validateTLS()
+	v.validateAdmin()
+	v.validateDataDirs()
+	v.validateStreams()
+	v.validateClustering()
+	if c.NATSDisabled {
+		if c.EmbeddedNATS || c.HasInternalNATS() {
+			v.warn("%s and %s are ignored since %s is set", configNATSEmbedded,
+				configNATSInternalServers, configNATSDisabled)
+		}
+	} else if c.EmbeddedNATSConfig != "" {
+		v.validateReadable(configNATSEmbeddedConfig, c.EmbeddedNATSConfig)
+	}
+	if len(v.problems) > 0 {
+		return v.warnings, &ConfigError{Problems: v.problems}
+	}
+	return v.warnings, nil
+}
+
+// validateListeners checks the server's ports are in range and its TCP
+// listeners don't conflict.
+func (v *configValidator) validateListeners() {
+	c := v.config
+	listen := c.GetListenAddress()
+	if listen.Port < 0 || listen.Port > 65535 {
+		v.problem("%s port %d is out of range", configListen, listen.Port)
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		v.problem("%s %d is out of range", configPort, c.Port)
+	}
+	ports := map[int]string{}
+	if listen.Port != 0 {
+		ports[listen.Port] = configListen
+	}
+	for _, listener := range c.Listeners {
+		setting := fmt.Sprintf("%s %s", configListeners, listener.Name)
+		if listener.Listen.Port == 0 {
+			continue
+		}
+		if other, ok := ports[listener.Listen.Port]; ok {
+			v.problem("%s port %d is also used by %s", setting, listener.Listen.Port, other)
+			continue
+		}
+		ports[listener.Listen.Port] = setting
+	}
+	if c.NATSDisabled {
+		if port, ok := v.validateAddress(configClusteringTransportListen, c.Clustering.TransportListen); ok && port != 0 {
+			if other, ok := ports[port]; ok {
+				v.problem("%s port %d is also used by %s", configClusteringTransportListen, port, other)
+			}
+		}
+		for _, seed := range c.Clustering.TransportSeeds {
+			v.validateAddress(configClusteringTransportSeeds, seed)
+		}
+	}
+	if c.AdminListen != "" {
+		if port, ok := v.validateAddress(configAdminListen, c.AdminListen); ok && port != 0 {
+			if other, ok := ports[port]; ok {
+				v.problem("%s port %d is also used by %s", configAdminListen, port, other)
+			}
+		}
+	}
+	if c.Clustering.GossipListen != "" {
+		v.validateAddress(configClusteringGossipListen, c.Clustering.GossipListen)
+	}
+	for _, seed := range c.Clustering.GossipSeeds {
+		v.validateAddress(configClusteringGossipSeeds, seed)
+	}
+}
+
+// validateAddress checks the given host:port address is valid and returns
+// its port.
+func (v *configValidator) validateAddress(setting, address string) (int, bool) {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		v.problem("%s address %q is invalid: %v", setting, address, err)
+		return 0, false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		v.problem("%s address %q has an invalid port", setting, address)
+		return 0, false
+	}
+	return port, true
+}
+
+// validateTLS checks the TLS files can be read and loaded.
+func (v *configValidator) validateTLS() {
+	c := v.config
+	switch {
+	case c.TLSKey != "" && c.TLSCert == "":
+		v.problem("%s is set but %s is not", configTLSKey, configTLSCert)
+	case c.TLSKey == "" && c.TLSCert != "":
+		v.problem("%s is set but %s is not", configTLSCert, configTLSKey)
+	case c.TLSKey != "" && c.TLSCert != "":
+		if v.validateReadable(configTLSKey, c.TLSKey) && v.validateReadable(configTLSCert, c.TLSCert) {
+			if _, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey); err != nil {
+				v.problem("%s and %s are not a valid key pair: %v", configTLSCert, configTLSKey, err)
+			}
+		}
+	}
+	if c.TLSClientAuth && c.TLSKey == "" {
+		v.problem("%s requires %s and %s to be set", configTLSClientAuthEnabled, configTLSKey, configTLSCert)
+	}
+	if c.TLSClientAuthCA != "" {
+		if !c.TLSClientAuth {
+			v.warn("%s is set but %s is not enabled", configTLSClientAuthCA, configTLSClientAuthEnabled)
+		}
+		if v.validateReadable(configTLSClientAuthCA, c.TLSClientAuthCA) {
+			ca, _ := ioutil.ReadFile(c.TLSClientAuthCA)
+			if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+				v.problem("%s %q contains no PEM certificates", configTLSClientAuthCA, c.TLSClientAuthCA)
+			}
+		}
+	}
+}
+
+// validateAdmin checks the admin server's TLS files can be read and loaded
+// and warns if the admin server is reachable from other hosts without
+// authentication.
+func (v *configValidator) validateAdmin() {
+	c := v.config
+	switch {
+	case c.AdminTLSKey != "" && c.AdminTLSCert == "":
+		v.problem("%s is set but %s is not", configAdminTLSKey, configAdminTLSCert)
+	case c.AdminTLSKey == "" && c.AdminTLSCert != "":
+		v.problem("%s is set but %s is not", configAdminTLSCert, configAdminTLSKey)
+	case c.AdminTLSKey != "" && c.AdminTLSCert != "":
+		if v.validateReadable(configAdminTLSKey, c.AdminTLSKey) &&
+			v.validateReadable(configAdminTLSCert, c.AdminTLSCert) {
+			if _, err := tls.LoadX509KeyPair(c.AdminTLSCert, c.AdminTLSKey); err != nil {
+				v.problem("%s and %s are not a valid key pair: %v", configAdminTLSCert, configAdminTLSKey, err)
+			}
+		}
+	}
+	if c.AdminTLSClientCA != "" {
+		if c.AdminTLSCert == "" {
+			v.problem("%s requires %s and %s to be set", configAdminTLSClientCA, configAdminTLSCert,
+				configAdminTLSKey)
+		}
+		if v.validateReadable(configAdminTLSClientCA, c.AdminTLSClientCA) {
+			ca, _ := ioutil.ReadFile(c.AdminTLSClientCA)
+			if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+				v.problem("%s %q contains no PEM certificates", configAdminTLSClientCA, c.AdminTLSClientCA)
+			}
+		}
+	}
+	if c.AdminListen != "" && c.AdminTLSClientCA == "" && c.AdminToken == "" &&
+		!isLoopbackAddress(c.AdminListen) {
+		v.warn("%s %q is not a loopback address but neither %s nor %s is set, so anyone who can "+
+			"reach it can drain the server and change the cluster", configAdminListen, c.AdminListen,
+			configAdminTLSClientCA, configAdminToken)
+	}
+}
+
+// isLoopbackAddress indicates if the host of the given host:port address is
+// localhost or a loopback IP.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validateReadable checks the file set by the given setting can be read.
+func (v *configValidator) validateReadable(setting, path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		v.problem("%s file is not readable: %v", setting, err)
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// validateDataDirs checks partitions aren't placed in the same directory
+// twice.
+func (v *configValidator) validateDataDirs() {
+	seen := make(map[string]struct{}, len(v.config.DataDirs))
+	for _, dir := range v.config.DataDirs {
+		clean := filepath.Clean(dir)
+		if _, ok := seen[clean]; ok {
+			v.problem("%s lists directory %s more than once", configDataDirs, dir)
+		}
+		seen[clean] = struct{}{}
+	}
+}
+
+// validateStreams checks the stream retention and segment settings are
+// consistent.
+func (v *configValidator) validateStreams() {
+	s := v.config.Streams
+	if s.SegmentMaxBytes <= 0 {
+		v.problem("%s must be positive, got %d", configStreamsSegmentMaxBytes, s.SegmentMaxBytes)
+	}
+	if s.RetentionMaxBytes > 0 && s.SegmentMaxBytes > s.RetentionMaxBytes {
+		v.warn("%s (%d) exceeds %s (%d), so the retention limit is only enforced once segments roll",
+			configStreamsSegmentMaxBytes, s.SegmentMaxBytes, configStreamsRetentionMaxBytes, s.RetentionMaxBytes)
+	}
+	if s.RetentionMaxAge > 0 && s.SegmentMaxAge > s.RetentionMaxAge {
+		v.warn("%s (%s) exceeds %s (%s), so the retention limit is only enforced once segments roll",
+			configStreamsSegmentMaxAge, s.SegmentMaxAge, configStreamsRetentionMaxAge, s.RetentionMaxAge)
+	}
+	if s.CleanerInterval <= 0 {
+		v.problem("%s must be positive, got %s", configStreamsCleanerInterval, s.CleanerInterval)
+	}
+}
+
+// validateClustering checks the clustering settings are consistent.
+func (v *configValidator) validateClustering() {
+	c := v.config.Clustering
+	if c.ServerID == "" || strings.ContainsAny(c.ServerID, " .") {
+		v.problem("%s %q must be non-empty and contain no spaces or periods", configClusteringServerID, c.ServerID)
+	}
+	if c.Namespace == "" || strings.ContainsAny(c.Namespace, " .") {
+		v.problem("%s %q must be non-empty and contain no spaces or periods", configClusteringNamespace, c.Namespace)
+	}
+	for _, peer := range c.RaftBootstrapPeers {
+		if peer == "" || strings.ContainsAny(peer, " .") {
+			v.problem("%s peer %q must be non-empty and contain no spaces or periods",
+				configClusteringRaftBootstrapPeers, peer)
+		}
+	}
+	if c.RaftBootstrapSeed && len(c.RaftBootstrapPeers) > 0 {
+		v.warn("%s and %s are both set, %s takes precedence",
+			configClusteringRaftBootstrapSeed, configClusteringRaftBootstrapPeers, configClusteringRaftBootstrapPeers)
+	}
+	if c.RaftBootstrapDNSName != "" {
+		if c.RaftBootstrapDNSExpect <= 0 {
+			v.problem("%s must be set when %s is set",
+				configClusteringRaftBootstrapDNSExpect, configClusteringRaftBootstrapDNSName)
+		}
+		if c.RaftBootstrapSeed || len(c.RaftBootstrapPeers) > 0 {
+			v.warn("%s is ignored since %s or %s is set", configClusteringRaftBootstrapDNSName,
+				configClusteringRaftBootstrapSeed, configClusteringRaftBootstrapPeers)
+		}
+	}
+	if c.MinISR < 1 {
+		v.problem("%s must be at least 1, got %d", configClusteringMinInsyncReplicas, c.MinISR)
+	}
+	if c.ReplicaMaxIdleWait >= c.ReplicaMaxLeaderTimeout {
+		v.warn("%s (%s) is not less than %s (%s), so idle followers may report their leader as failed",
+			configClusteringReplicaMaxIdleWait, c.ReplicaMaxIdleWait,
+			configClusteringReplicaMaxLeaderTimeout, c.ReplicaMaxLeaderTimeout)
+	}
+	if c.ReplicationMaxBytes <= 0 {
+		v.problem("%s must be positive, got %d", configClusteringReplicationMaxBytes, c.ReplicationMaxBytes)
+	}
+	if c.GossipInterval <= 0 || c.GossipSuspectTimeout <= c.GossipInterval ||
+		c.GossipDeadTimeout <= c.GossipSuspectTimeout {
+		v.problem("%s (%s), %s (%s) and %s (%s) must be positive and increasing",
+			configClusteringGossipInterval, c.GossipInterval,
+			configClusteringGossipSuspectTimeout, c.GossipSuspectTimeout,
+			configClusteringGossipDeadTimeout, c.GossipDeadTimeout)
+	}
+}
+
+// WriteEffective writes the resolved configuration, including defaults, to
+// the given writer with one "Field: value" line per setting. Nested settings
+// are named by their path, e.g. "Clustering.ServerID", and passwords and
+// tokens are redacted.
+func (c *Config) WriteEffective(w io.Writer) error {
+	return writeFields(w, "", reflect.ValueOf(c).Elem())
+}
+
+// writeFields writes the exported fields of the given struct value, prefixing
+// their names with the given prefix. Fields which can't be represented as
+// settings, such as functions and TLS configurations, are skipped.
+func writeFields(w io.Writer, prefix string, value reflect.Value) error {
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := prefix + field.Name
+		fieldValue := value.Field(i)
+		var str string
+		switch {
+		case isRedacted(field.Name):
+			if fieldValue.Kind() == reflect.String && fieldValue.String() == "" {
+				str = ""
+			} else {
+				str = "<redacted>"
+			}
+		case name == "LogLevel":
+			str = log.Level(fieldValue.Uint()).String()
+		case field.Type == reflect.TypeOf(os.FileMode(0)):
+			str = fmt.Sprintf("%#o", fieldValue.Uint())
+		case fieldValue.Kind() == reflect.Ptr:
+			if fieldValue.IsNil() {
+				continue
+			}
+			stringer, ok := fieldValue.Interface().(fmt.Stringer)
+			if !ok {
+				continue
+			}
+			str = stringer.String()
+		case fieldValue.Kind() == reflect.Struct:
+			if err := writeFields(w, name+".", fieldValue); err != nil {
+				return err
+			}
+			continue
+		case fieldValue.Kind() == reflect.Func, fieldValue.Kind() == reflect.Chan,
+			fieldValue.Kind() == reflect.Interface:
+			continue
+		default:
+			str = fmt.Sprintf("%v", fieldValue.Interface())
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", name, str); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRedacted indicates if the value of the field with the given name should
+// not be printed.
+func isRedacted(name string) bool {
+	_, ok := redactedFields[name]
+	return ok
+}
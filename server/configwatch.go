@@ -0,0 +1,154 @@
+package server
+
+import (
+	"os"
+	"reflect"
+	"time"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// watchAllStreams is the streams.watch.existing entry which applies reloaded
+// stream defaults to every existing stream.
+const watchAllStreams = "*"
+
+// retentionDefaults are the stream defaults which are applied to the
+// partitions of existing streams when they are reloaded.
+var retentionDefaults = map[string]struct{}{
+	"RetentionMaxBytes":    {},
+	"RetentionMaxMessages": {},
+	"RetentionMaxAge":      {},
+}
+
+// getStreamsConfig returns the settings for a partition of a stream with the
+// given configuration, which overrides the server's stream defaults.
+func (s *Server) getStreamsConfig(config *proto.StreamConfig) *StreamsConfig {
+	streamsConfig := &StreamsConfig{MinISR: s.config.Clustering.MinISR}
+	s.streamsConfigMu.RLock()
+	streamsConfig.setStreamDefaults(&s.config.Streams)
+	s.streamsConfigMu.RUnlock()
+	streamsConfig.ApplyOverrides(config)
+	return streamsConfig
+}
+
+// startConfigWatcher starts a goroutine which reloads the stream defaults
+// when the configuration file changes if streams.watch.interval is set. The
+// file is polled rather than watched with inotify so that this also works
+// with files replaced through symlinks, e.g. Kubernetes ConfigMaps.
+func (s *Server) startConfigWatcher() {
+	var (
+		file     = s.config.file
+		interval = s.config.Streams.WatchInterval
+	)
+	if file == "" || interval == 0 {
+		return
+	}
+	s.logger.Infof("Watching %s for changes to stream defaults", file)
+	s.startGoroutine(func() {
+		var (
+			ticker      = time.NewTicker(interval)
+			lastInfo, _ = os.Stat(file)
+		)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.shutdownCh:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				s.logger.Warnf("Failed to check configuration file %s for changes: %v", file, err)
+				continue
+			}
+			if lastInfo != nil && info.ModTime().Equal(lastInfo.ModTime()) && info.Size() == lastInfo.Size() {
+				continue
+			}
+			lastInfo = info
+			if err := s.reloadStreamsConfig(); err != nil {
+				s.logger.Errorf("Failed to reload stream defaults from %s: %v", file, err)
+			}
+		}
+	})
+}
+
+// reloadStreamsConfig reads the configuration file and applies its stream
+// defaults to partitions created from now on and to the existing streams
+// listed in streams.watch.existing. Only the retention limits of existing
+// streams can be changed without recreating their partitions, so their other
+// settings take effect when the server restarts, and a warning lists the ones
+// which changed. Settings which aren't stream defaults, such as
+// streams.background.workers, are not reloaded.
+func (s *Server) reloadStreamsConfig() error {
+	config, err := NewConfig(s.config.file)
+	if err != nil {
+		return err
+	}
+	if logRollTime := config.Streams.SegmentMaxAge; logRollTime != 0 && logRollTime < time.Second {
+		config.Streams.SegmentMaxAge = time.Second
+	}
+
+	var oldDefaults, newDefaults StreamsConfig
+	newDefaults.setStreamDefaults(&config.Streams)
+	s.streamsConfigMu.Lock()
+	oldDefaults.setStreamDefaults(&s.config.Streams)
+	s.config.Streams.setStreamDefaults(&config.Streams)
+	s.config.Streams.WatchExisting = config.Streams.WatchExisting
+	s.streamsConfigMu.Unlock()
+
+	if reflect.DeepEqual(oldDefaults, newDefaults) {
+		s.logger.Debugf("Stream defaults in %s are unchanged", s.config.file)
+	} else {
+		s.logger.Infof("Reloaded stream defaults from %s, retention policy: %s",
+			s.config.file, newDefaults.RetentionString())
+	}
+	if ignored := unappliedStreamDefaults(oldDefaults, newDefaults); len(ignored) > 0 &&
+		len(config.Streams.WatchExisting) > 0 {
+		s.logger.Warnf("Changes to stream defaults %v in %s are not applied to existing streams "+
+			"until the server restarts, only retention limits are", ignored, s.config.file)
+	}
+
+	for _, stream := range s.metadata.GetStreams() {
+		if !watchesStream(config.Streams.WatchExisting, stream.GetName()) {
+			continue
+		}
+		streamsConfig := s.getStreamsConfig(stream.GetConfig())
+		for _, partition := range stream.GetPartitions() {
+			partition.log.SetRetention(streamsConfig.RetentionMaxBytes,
+				streamsConfig.RetentionMaxMessages, streamsConfig.RetentionMaxAge)
+		}
+	}
+	return nil
+}
+
+// unappliedStreamDefaults returns the names of the stream defaults which differ
+// between old and new but are not applied to existing streams when reloaded.
+func unappliedStreamDefaults(old, new StreamsConfig) []string {
+	var (
+		names  []string
+		oldVal = reflect.ValueOf(old)
+		newVal = reflect.ValueOf(new)
+	)
+	for i := 0; i < oldVal.NumField(); i++ {
+		field := oldVal.Type().Field(i)
+		if _, ok := retentionDefaults[field.Name]; ok || field.PkgPath != "" {
+			continue
+		}
+		if !reflect.DeepEqual(oldVal.Field(i).Interface(), newVal.Field(i).Interface()) {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// watchesStream indicates if reloaded stream defaults apply to the existing
+// stream with the given name. Internal streams are only included if listed
+// by name.
+func watchesStream(existing []string, name string) bool {
+	for _, watched := range existing {
+		if watched == name || (watched == watchAllStreams && !isInternalStream(name)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// Ensure stream defaults are reloaded when the configuration file changes,
+// that they apply to new streams, and that the retention limits of existing
+// streams are only changed for the streams which opt in.
+func TestConfigWatchReloadsStreamDefaults(t *testing.T) {
+	defer cleanupStorage(t)
+
+	file, err := ioutil.TempFile("", "liftbridge-config-*.yaml")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	require.NoError(t, file.Close())
+	// Replace the file atomically so it's never read partially written.
+	writeConfig := func(contents string) {
+		tmp := file.Name() + ".tmp"
+		require.NoError(t, ioutil.WriteFile(tmp, []byte(contents), 0644))
+		require.NoError(t, os.Rename(tmp, file.Name()))
+	}
+	writeConfig("streams.segment.max.bytes: 1\n")
+
+	config := getTestConfig("a", true, 5050)
+	config.file = file.Name()
+	// Roll a segment for every message so retention applies per message.
+	config.Streams.SegmentMaxBytes = 1
+	config.Streams.WatchInterval = 10 * time.Millisecond
+	s1 := runServerWithConfig(t, config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, name := range []string{"foo", "bar"} {
+		_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: name, Name: name})
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			_, err = api.Publish(ctx, &client.PublishRequest{
+				Stream:    name,
+				Value:     []byte("hello"),
+				AckPolicy: client.AckPolicy_ALL,
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	// Changes to stream defaults are picked up.
+	writeConfig(`streams:
+  segment.max.bytes: 1
+  retention.max.messages: 2
+  watch.existing: [foo]
+`)
+	require.Eventually(t, func() bool {
+		return s1.getStreamsConfig(nil).RetentionMaxMessages == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Only foo opted in to the new retention limit.
+	foo := s1.metadata.GetPartition("foo", 0)
+	require.NoError(t, foo.log.Clean(context.Background()))
+	require.True(t, foo.log.OldestOffset() > 0)
+	bar := s1.metadata.GetPartition("bar", 0)
+	require.NoError(t, bar.log.Clean(context.Background()))
+	require.Equal(t, int64(0), bar.log.OldestOffset())
+
+	// Invalid configuration files are not applied.
+	writeConfig("streams.retention.max.messages: foo\nunknown.setting: true\n")
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, int64(2), s1.getStreamsConfig(nil).RetentionMaxMessages)
+}
+
+// Ensure reloaded stream defaults apply to the existing streams listed by
+// name or to all non-internal streams.
+func TestWatchesStream(t *testing.T) {
+	require.True(t, watchesStream([]string{"foo"}, "foo"))
+	require.False(t, watchesStream([]string{"foo"}, "bar"))
+	require.False(t, watchesStream(nil, "foo"))
+	require.True(t, watchesStream([]string{watchAllStreams}, "bar"))
+	require.False(t, watchesStream([]string{watchAllStreams}, activityStream))
+	require.True(t, watchesStream([]string{activityStream}, activityStream))
+}
+
+// Ensure the changed stream defaults which aren't applied to existing streams
+// are reported, excluding the retention limits.
+func TestUnappliedStreamDefaults(t *testing.T) {
+	old := StreamsConfig{RetentionMaxMessages: 10, SegmentMaxBytes: 1024}
+	require.Empty(t, unappliedStreamDefaults(old, old))
+
+	new := old
+	new.RetentionMaxMessages = 20
+	new.RetentionMaxAge = time.Hour
+	require.Empty(t, unappliedStreamDefaults(old, new))
+
+	new.SegmentMaxBytes = 2048
+	new.Compact = true
+	require.Equal(t, []string{"SegmentMaxBytes", "Compact"}, unappliedStreamDefaults(old, new))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression with the standard five fields:
+// minute, hour, day of month, month, and day of week. Each field is a set of
+// allowed values stored as a bitmask. Times are matched in UTC.
+type cronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// Whether the day of month and day of week fields are unrestricted. If
+	// both are restricted, a day matches if either field matches.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// cronField describes the range of values allowed in a cron field.
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// cronMaxSearch bounds how far ahead cronSchedule.next looks for a matching
+// time, so expressions that never match, e.g. February 30, terminate.
+const cronMaxSearch = 5 * 366 * 24 * time.Hour
+
+// parseCron parses a five-field cron expression. Each field is "*" or a
+// comma-separated list of values and inclusive ranges, e.g. "1,5-10", any of
+// which may have a step, e.g. "*/15". Sunday is 0 or 7 in the day of week
+// field.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields", expr, len(cronFields))
+	}
+	masks := make([]uint64, len(fields))
+	for i, field := range fields {
+		mask, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expr, err)
+		}
+		masks[i] = mask
+	}
+	schedule := &cronSchedule{
+		minute:        masks[0],
+		hour:          masks[1],
+		dayOfMonth:    masks[2],
+		month:         masks[3],
+		dayOfWeek:     masks[4],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}
+	// Sunday is both 0 and 7.
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	return schedule, nil
+}
+
+// parseCronField parses a single cron field into a bitmask of allowed values.
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field %q", spec.name, part)
+			}
+		}
+		start, end := spec.min, spec.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %s field %q", spec.name, part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value in %s field %q", spec.name, part)
+				}
+			} else if step > 1 {
+				// A single value with a step, e.g. "5/15", runs to the max.
+				end = spec.max
+			}
+		}
+		if start < spec.min || end > spec.max || start > end {
+			return 0, fmt.Errorf("%s field %q is out of range %d-%d", spec.name, part, spec.min, spec.max)
+		}
+		for v := start; v <= end; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// next returns the first time after the given time matching the schedule, or
+// the zero time if there is none.
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronMaxSearch)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchesDay indicates if the day of the given time matches the schedule.
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	var (
+		dom = c.dayOfMonth&(1<<uint(t.Day())) != 0
+		dow = c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	)
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dom && dow
+	}
+	return dom || dow
+}
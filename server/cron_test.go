@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure parseCron rejects invalid expressions.
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := parseCron(expr)
+		require.Error(t, err, expr)
+	}
+}
+
+// Ensure cronSchedule.next returns the first matching time after the given
+// time.
+func TestCronScheduleNext(t *testing.T) {
+	// Wednesday.
+	from := time.Date(2020, time.January, 1, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2020, time.January, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, time.January, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2020, time.January, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2020, time.January, 1, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2020, time.January, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2020, time.January, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Restricted day of month and day of week match either.
+		{"0 0 15 * 5", time.Date(2020, time.January, 3, 0, 0, 0, 0, time.UTC)},
+		{"30 12 1,20 3 *", time.Date(2020, time.March, 1, 12, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		cron, err := parseCron(test.expr)
+		require.NoError(t, err, test.expr)
+		require.Equal(t, test.expected, cron.next(from), test.expr)
+	}
+
+	// Expressions which never match return the zero time.
+	cron, err := parseCron("0 0 30 2 *")
+	require.NoError(t, err)
+	require.True(t, cron.next(from).IsZero())
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// SetCursor request actions. The API has no RPCs for acting on a running
+// subscription, so SetCursor requests carry them instead: a request which sets
+// SetCursorActionMetadata performs the action rather than setting a cursor,
+// and its cursor ID and offset fields take the meaning given by the action.
+//
+// NOTE: This is an experimental contract and is subject to change. It is not
+// included as part of Liftbridge's semantic versioning scheme.
+const (
+	// SetCursorActionMetadata is the SetCursor request metadata key naming
+	// the action the request performs instead of setting a cursor. It can
+	// only be set once.
+	SetCursorActionMetadata = "liftbridge-set-cursor-action"
+
+	// SetCursorActionGrantCredit grants the credit set with
+	// CreditMessagesMetadata and CreditBytesMetadata to the flow-controlled
+	// subscription whose ID is the request's cursor ID. The offset must not
+	// be set. It must be sent to the server the subscription is on.
+	SetCursorActionGrantCredit = "grant-credit"
+
+	// SetCursorActionQueueAck acks the message at the request's offset in the
+	// work queue whose name is the request's cursor ID. It must be sent to
+	// the partition leader.
+	SetCursorActionQueueAck = "queue-ack"
+
+	// SetCursorActionAutoCommitAck acks the message at the request's offset
+	// for the at-least-once subscription auto-committing the cursor with the
+	// request's cursor ID. Messages can be acked in any order. The cursor
+	// advances to the last message before the first one which has not been
+	// acked, which is committed before the request returns. It must be sent
+	// to the server the subscription is on.
+	SetCursorActionAutoCommitAck = "auto-commit-ack"
+)
+
+// setCursorActionFromContext returns the action set in the incoming SetCursor
+// request metadata, if any.
+func setCursorActionFromContext(ctx context.Context) (string, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", nil
+	}
+	values := md.Get(SetCursorActionMetadata)
+	switch len(values) {
+	case 0:
+		return "", nil
+	case 1:
+		return values[0], nil
+	default:
+		return "", status.Newf(codes.InvalidArgument, "%s can only be set once", SetCursorActionMetadata)
+	}
+}
+
+// setCursorAction performs the given action for a SetCursor request instead of
+// setting a cursor.
+func (a *apiServer) setCursorAction(ctx context.Context, action string, req *client.SetCursorRequest) *status.Status {
+	switch action {
+	case SetCursorActionGrantCredit:
+		return a.grantCredit(ctx, req)
+	case SetCursorActionQueueAck:
+		return a.ackQueueMessage(ctx, req)
+	case SetCursorActionAutoCommitAck:
+		return a.ackAutoCommit(ctx, req)
+	default:
+		return status.Newf(codes.InvalidArgument, "Invalid %s value %q", SetCursorActionMetadata, action)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// Ensure setCursorActionFromContext returns the SetCursor action from the
+// request metadata and rejects it being set more than once.
+func TestSetCursorActionFromContext(t *testing.T) {
+	action, st := setCursorActionFromContext(context.Background())
+	require.Nil(t, st)
+	require.Equal(t, "", action)
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(SetCursorActionMetadata, SetCursorActionGrantCredit))
+	action, st = setCursorActionFromContext(ctx)
+	require.Nil(t, st)
+	require.Equal(t, SetCursorActionGrantCredit, action)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		SetCursorActionMetadata, SetCursorActionGrantCredit,
+		SetCursorActionMetadata, SetCursorActionGrantCredit))
+	_, st = setCursorActionFromContext(ctx)
+	require.NotNil(t, st)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
+
+// Ensure unknown SetCursor actions are rejected.
+func TestSetCursorActionUnknown(t *testing.T) {
+	a := &apiServer{}
+	st := a.setCursorAction(context.Background(), "foo", nil)
+	require.NotNil(t, st)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// CursorCommitMetadata is the Publish request metadata key which commits a
+// cursor together with the published message or atomic batch, so that a
+// stream processor consumes its input and publishes its output exactly once.
+// The value is a URL query string with the stream, partition, cursorId, and
+// offset of the cursor to commit, e.g.
+// "stream=foo&partition=0&cursorId=bar&offset=42".
+//
+// The message, or the last message of the batch, is published with a
+// CursorCommitHeader recording the commit and a MsgIDHeader derived from it,
+// replacing any the client set. Once the publish is acked, the cursor is set
+// to the offset unless it's already at or past it, so concurrent commits never
+// move the cursor backwards. The output log is the record of the commit: if the cursor
+// isn't set because the server fails, retrying the request within
+// streams.dedup.window acks the original publish and sets the cursor, so
+// streams.dedup.window must be set. The request must use AckPolicy_ALL and a
+// deadline so that it waits for the ack, and, as with SetCursor, be sent to
+// the leader of the cursors partition for the cursor. A FailedPrecondition
+// status code is returned if the cursor is already at or past the offset,
+// i.e. the commit was made by an earlier or concurrent attempt.
+const CursorCommitMetadata = "liftbridge-cursor-commit"
+
+// CursorCommitHeader is the message header recording the cursor committed
+// with the message, in the format of CursorCommitMetadata.
+const CursorCommitHeader = "Liftbridge-Cursor-Commit"
+
+// cursorCommit is a cursor committed with a publish.
+type cursorCommit struct {
+	stream    string
+	partition int32
+	cursorID  string
+	offset    int64
+}
+
+// cursorCommitFromContext parses the cursor committed with a publish from the
+// incoming request metadata. It returns nil if no cursor is committed.
+func cursorCommitFromContext(ctx context.Context) (*cursorCommit, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	values := md.Get(CursorCommitMetadata)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	invalid := func(reason string) *status.Status {
+		return status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q: %s", CursorCommitMetadata, values[0], reason))
+	}
+	query, err := url.ParseQuery(values[0])
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+	commit := &cursorCommit{
+		stream:   query.Get("stream"),
+		cursorID: query.Get("cursorId"),
+	}
+	if commit.stream == "" {
+		return nil, invalid("no stream provided")
+	}
+	if commit.cursorID == "" {
+		return nil, invalid("no cursorId provided")
+	}
+	if p := query.Get("partition"); p != "" {
+		partition, err := strconv.ParseInt(p, 10, 32)
+		if err != nil || partition < 0 {
+			return nil, invalid("invalid partition")
+		}
+		commit.partition = int32(partition)
+	}
+	commit.offset, err = strconv.ParseInt(query.Get("offset"), 10, 64)
+	if err != nil || commit.offset < 0 {
+		return nil, invalid("invalid offset")
+	}
+	return commit, nil
+}
+
+// String returns the commit in the format of CursorCommitMetadata.
+func (c *cursorCommit) String() string {
+	return url.Values{
+		"stream":    {c.stream},
+		"partition": {strconv.FormatInt(int64(c.partition), 10)},
+		"cursorId":  {c.cursorID},
+		"offset":    {strconv.FormatInt(c.offset, 10)},
+	}.Encode()
+}
+
+// msgID returns the message ID of the message committing the cursor, which
+// is the same for every attempt to make the commit.
+func (c *cursorCommit) msgID() string {
+	return "cursor-commit:" + c.String()
+}
+
+// stamp sets the commit's headers on the message published with the request,
+// or on the last message of the batch if batch is true.
+func (c *cursorCommit) stamp(req *client.PublishRequest, batch bool) *client.PublishAsyncError {
+	if !batch {
+		req.Headers = c.headers(req.Headers)
+		return nil
+	}
+	msgs, err := proto.UnmarshalBatch(req.Value)
+	if err != nil {
+		return &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_BAD_REQUEST,
+			Message: fmt.Sprintf("invalid batch: %v", err),
+		}
+	}
+	if len(msgs) == 0 {
+		return &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_BAD_REQUEST,
+			Message: "empty batch",
+		}
+	}
+	last := msgs[len(msgs)-1]
+	last.Headers = c.headers(last.Headers)
+	value, err := proto.MarshalBatch(msgs)
+	if err != nil {
+		return &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_INTERNAL,
+			Message: fmt.Sprintf("failed to marshal batch: %v", err),
+		}
+	}
+	req.Value = value
+	return nil
+}
+
+// headers returns a copy of the given headers with the commit's headers set.
+func (c *cursorCommit) headers(headers map[string][]byte) map[string][]byte {
+	stamped := make(map[string][]byte, len(headers)+2)
+	for k, v := range headers {
+		stamped[k] = v
+	}
+	stamped[MsgIDHeader] = []byte(c.msgID())
+	stamped[CursorCommitHeader] = []byte(c.String())
+	return stamped
+}
+
+// prepareCursorCommit checks that the cursor can be committed with the given
+// publish request and stamps the request with the commit's headers.
+func (a *apiServer) prepareCursorCommit(ctx context.Context, commit *cursorCommit,
+	req *client.PublishRequest, batch bool) error {
+
+	if _, ok := ctx.Deadline(); !ok || req.AckPolicy != client.AckPolicy_ALL {
+		return status.Errorf(codes.InvalidArgument,
+			"%s requires AckPolicy_ALL and a deadline", CursorCommitMetadata)
+	}
+	// The commit is only applied once if the publish is deduplicated.
+	if stream := a.metadata.GetStream(req.Stream); stream != nil &&
+		a.getStreamsConfig(stream.GetConfig()).DedupWindow <= 0 {
+		return status.Errorf(codes.FailedPrecondition,
+			"%s requires deduplication to be enabled on stream %s", CursorCommitMetadata, req.Stream)
+	}
+	commit.stream = a.streamName(commit.stream)
+	if a.metadata.GetPartition(commit.stream, commit.partition) == nil {
+		return status.Errorf(codes.NotFound, "No such partition: %s/%d",
+			commit.stream, commit.partition)
+	}
+	current, st := a.cursors.GetCursor(ctx, commit.stream, commit.cursorID, commit.partition)
+	if st != nil {
+		return st.Err()
+	}
+	if current >= commit.offset {
+		return status.Errorf(codes.FailedPrecondition,
+			"Cursor %s is already committed at offset %d", commit.cursorID, current)
+	}
+	return convertPublishAsyncError(commit.stamp(req, batch))
+}
+
+// finishCursorCommit advances the cursor once the publish committing it is
+// acked. The cursor is checked again as it's updated since another attempt
+// may have committed it since prepareCursorCommit.
+func (a *apiServer) finishCursorCommit(ctx context.Context, commit *cursorCommit) error {
+	// The cursor is set by publishing to the cursors stream, which must not
+	// see the metadata of the publish committing it.
+	ctx = metadata.NewIncomingContext(ctx, nil)
+	current, st := a.cursors.AdvanceCursor(ctx, commit.stream, commit.cursorID, commit.partition, commit.offset)
+	if st != nil {
+		return st.Err()
+	}
+	if current >= commit.offset {
+		return status.Errorf(codes.FailedPrecondition,
+			"Cursor %s is already committed at offset %d", commit.cursorID, current)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure cursorCommitFromContext parses the committed cursor and rejects
+// invalid values.
+func TestCursorCommitFromContext(t *testing.T) {
+	commit, st := cursorCommitFromContext(context.Background())
+	require.Nil(t, st)
+	require.Nil(t, commit)
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(CursorCommitMetadata, "stream=foo&partition=2&cursorId=bar&offset=42"))
+	commit, st = cursorCommitFromContext(ctx)
+	require.Nil(t, st)
+	require.Equal(t, &cursorCommit{stream: "foo", partition: 2, cursorID: "bar", offset: 42}, commit)
+	require.Equal(t, "cursorId=bar&offset=42&partition=2&stream=foo", commit.String())
+
+	for _, value := range []string{
+		"partition=0&cursorId=bar&offset=42",
+		"stream=foo&partition=0&offset=42",
+		"stream=foo&partition=-1&cursorId=bar&offset=42",
+		"stream=foo&partition=0&cursorId=bar",
+		"stream=foo&partition=0&cursorId=bar&offset=-1",
+		"stream=foo&offset=%zz",
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs(CursorCommitMetadata, value))
+		_, st := cursorCommitFromContext(ctx)
+		require.NotNil(t, st, value)
+		require.Equal(t, codes.InvalidArgument, st.Code(), value)
+	}
+}
+
+// Ensure a publish with a cursor commit publishes the batch and sets the
+// cursor, and a retry of the commit doesn't publish the batch again.
+func TestPublishCursorCommit(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.CursorsStream.Partitions = 1
+	s1Config.Streams.DedupWindow = time.Minute
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, name := range []string{"in", "out"} {
+		_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: name, Name: name})
+		require.NoError(t, err)
+	}
+
+	value, err := proto.MarshalBatch([]*client.Message{
+		{Value: []byte("one")},
+		{Value: []byte("two"), Headers: map[string][]byte{"foo": []byte("bar")}},
+	})
+	require.NoError(t, err)
+	commitCtx := metadata.AppendToOutgoingContext(ctx,
+		BatchMetadata, "true",
+		CursorCommitMetadata, "stream=in&partition=0&cursorId=processor&offset=5")
+	req := &client.PublishRequest{
+		Stream:    "out",
+		Value:     value,
+		AckPolicy: client.AckPolicy_ALL,
+	}
+
+	// The commit requires AckPolicy_ALL.
+	_, err = api.Publish(commitCtx, &client.PublishRequest{
+		Stream:    "out",
+		Value:     value,
+		AckPolicy: client.AckPolicy_LEADER,
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := api.Publish(commitCtx, req)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), resp.Ack.Offset)
+
+	cursor, err := api.FetchCursor(ctx, &client.FetchCursorRequest{
+		Stream:   "in",
+		CursorId: "processor",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), cursor.Offset)
+
+	// The commit was made, so it's rejected.
+	_, err = api.Publish(commitCtx, req)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// If the cursor wasn't set after the publish, e.g. because the server
+	// failed, a retry sets it without publishing the batch again.
+	_, err = api.SetCursor(ctx, &client.SetCursorRequest{
+		Stream:   "in",
+		CursorId: "processor",
+		Offset:   4,
+	})
+	require.NoError(t, err)
+	resp, err = api.Publish(commitCtx, req)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), resp.Ack.Offset)
+	cursor, err = api.FetchCursor(ctx, &client.FetchCursorRequest{
+		Stream:   "in",
+		CursorId: "processor",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), cursor.Offset)
+
+	partition := s1.metadata.GetPartition("out", 0)
+	require.NotNil(t, partition)
+	require.Equal(t, int64(1), partition.log.NewestOffset())
+
+	// The last message of the batch records the commit.
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "out",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Nil(t, msg.Headers[CursorCommitHeader])
+	msg, err = sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []byte("two"), msg.Value)
+	require.Equal(t, []byte("bar"), msg.Headers["foo"])
+	require.Equal(t, []byte("cursorId=processor&offset=5&partition=0&stream=in"),
+		msg.Headers[CursorCommitHeader])
+}
+
+// Ensure concurrent commits never move a cursor backwards, and a commit whose
+// cursor is advanced past its offset while it's published is rejected.
+func TestCursorCommitMonotonic(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.CursorsStream.Partitions = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "in", Name: "in"})
+	require.NoError(t, err)
+	waitForPartition(t, 10*time.Second, cursorsStream, 0, s1)
+
+	var wg sync.WaitGroup
+	for i := 19; i >= 0; i-- {
+		wg.Add(1)
+		go func(offset int64) {
+			defer wg.Done()
+			_, st := s1.api.cursors.AdvanceCursor(ctx, "in", "processor", 0, offset)
+			require.Nil(t, st)
+		}(int64(i))
+	}
+	wg.Wait()
+	offset, st := s1.api.cursors.GetCursor(ctx, "in", "processor", 0)
+	require.Nil(t, st)
+	require.Equal(t, int64(19), offset)
+
+	current, st := s1.api.cursors.AdvanceCursor(ctx, "in", "processor", 0, 5)
+	require.Nil(t, st)
+	require.Equal(t, int64(19), current)
+
+	err = s1.api.finishCursorCommit(ctx, &cursorCommit{
+		stream:   "in",
+		cursorID: "processor",
+		offset:   10,
+	})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	cursor, err := api.FetchCursor(ctx, &client.FetchCursorRequest{
+		Stream:   "in",
+		CursorId: "processor",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(19), cursor.Offset)
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/hashicorp/golang-lru"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	client "github.com/liftbridge-io/liftbridge-api/go"
@@ -20,6 +21,27 @@ const (
 	cursorCacheSize      = 512
 )
 
+// StartCursorMetadata is the Subscribe request metadata key containing the ID
+// of a cursor to start the subscription from. The subscription starts at the
+// offset after the cursor's position, which the server resolves when the
+// subscription is created. If the cursor has not been set, the request's
+// StartPosition is used. The server must be the leader of the internal
+// cursors partition for the cursor as with FetchCursor.
+const StartCursorMetadata = "liftbridge-start-cursor"
+
+// startCursorFromContext returns the ID of the cursor the subscription should
+// start from, or an empty string if the request does not start from a cursor.
+func startCursorFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(StartCursorMetadata); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
 // cursorManager provides an API for managing consumer cursor positions for
 // stream partitions. A cursorManager can only accept operations for requests
 // that map to internal cursor partitions of which this server is the leader.
@@ -85,12 +107,59 @@ func (c *cursorManager) Initialize() error {
 func (c *cursorManager) SetCursor(ctx context.Context, streamName, cursorID string, partitionID int32, offset int64) *status.Status {
 	var (
 		cursorKey              = c.getCursorKey(cursorID, streamName, partitionID)
-		cursorsPartitionID, st = c.getCursorsPartitionID(cursorKey)
+		cursorsPartitionID, st = c.getCursorsPartitionID(ctx, cursorKey)
 	)
 	if st != nil {
 		return st
 	}
 
+	ctx, cancel := ensureTimeout(ctx, defaultCursorTimeout)
+	defer cancel()
+
+	// We lock on write to ensure ordering is consistent between the partition
+	// and in-memory cache even though the cache itself is thread-safe.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.setCursor(ctx, cursorKey, cursorsPartitionID, streamName, cursorID, partitionID, offset)
+}
+
+// AdvanceCursor stores a cursor position like SetCursor, but only if it's
+// past the cursor's current position. The check and the update are atomic
+// with respect to other updates of cursors on this server, so concurrent
+// updates can't move the cursor backwards. It returns the position of the
+// cursor before the update, which is at or past the given offset if the
+// cursor was not updated.
+func (c *cursorManager) AdvanceCursor(ctx context.Context, streamName, cursorID string, partitionID int32, offset int64) (int64, *status.Status) {
+	var (
+		cursorKey              = c.getCursorKey(cursorID, streamName, partitionID)
+		cursorsPartitionID, st = c.getCursorsPartitionID(ctx, cursorKey)
+	)
+	if st != nil {
+		return 0, st
+	}
+
+	ctx, cancel := ensureTimeout(ctx, defaultCursorTimeout)
+	defer cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, err := c.cursorOffset(ctx, cursorKey, cursorsPartitionID)
+	if err != nil {
+		return 0, status.New(codes.Internal, err.Error())
+	}
+	if current >= offset {
+		return current, nil
+	}
+	return current, c.setCursor(ctx, cursorKey, cursorsPartitionID, streamName, cursorID, partitionID, offset)
+}
+
+// setCursor publishes the cursor position to the cursors partition and caches
+// it. The lock must be held.
+func (c *cursorManager) setCursor(ctx context.Context, cursorKey []byte, cursorsPartitionID int32,
+	streamName, cursorID string, partitionID int32, offset int64) *status.Status {
+
 	var (
 		cursor = &proto.Cursor{
 			Stream:    streamName,
@@ -104,14 +173,6 @@ func (c *cursorManager) SetCursor(ctx context.Context, streamName, cursorID stri
 		panic(err)
 	}
 
-	ctx, cancel := ensureTimeout(ctx, defaultCursorTimeout)
-	defer cancel()
-
-	// We lock on write to ensure ordering is consistent between the partition
-	// and in-memory cache even though the cache itself is thread-safe.
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	_, err = c.api.Publish(ctx, &client.PublishRequest{
 		Key:       cursorKey,
 		Value:     serializedCursor,
@@ -126,6 +187,13 @@ func (c *cursorManager) SetCursor(ctx context.Context, streamName, cursorID stri
 	// Cache the offset.
 	c.cache.Add(string(cursorKey), cursor.Offset)
 
+	c.cursorCommitted(&CursorCommit{
+		Stream:    streamName,
+		Partition: partitionID,
+		CursorID:  cursorID,
+		Offset:    offset,
+	})
+
 	return nil
 }
 
@@ -134,7 +202,7 @@ func (c *cursorManager) SetCursor(ctx context.Context, streamName, cursorID stri
 func (c *cursorManager) GetCursor(ctx context.Context, streamName, cursorID string, partitionID int32) (int64, *status.Status) {
 	var (
 		cursorKey              = c.getCursorKey(cursorID, streamName, partitionID)
-		cursorsPartitionID, st = c.getCursorsPartitionID(cursorKey)
+		cursorsPartitionID, st = c.getCursorsPartitionID(ctx, cursorKey)
 	)
 	if st != nil {
 		return 0, st
@@ -163,7 +231,45 @@ func (c *cursorManager) GetCursor(ctx context.Context, streamName, cursorID stri
 	return offset, nil
 }
 
-func (c *cursorManager) getCursorsPartitionID(cursorKey []byte) (int32, *status.Status) {
+// cursorOffset returns the latest offset for the cursor with the given key
+// like GetCursor, but with the lock held so that it can't change until the
+// lock is released.
+func (c *cursorManager) cursorOffset(ctx context.Context, cursorKey []byte, cursorsPartitionID int32) (int64, error) {
+	if !c.disableCache {
+		if offset, ok := c.cache.Get(string(cursorKey)); ok {
+			return offset.(int64), nil
+		}
+	}
+
+	// Find the latest offset for the cursor in the log.
+	offset, err := c.getLatestCursorOffset(ctx, cursorKey, cursorsPartitionID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Cache the offset.
+	c.cache.Add(string(cursorKey), offset)
+	return offset, nil
+}
+
+// applyStartCursor sets the start position of a subscription to the partition
+// to the offset after the position of the given cursor, if it has been set.
+func (c *cursorManager) applyStartCursor(ctx context.Context, partition *partition,
+	cursorID string, req *client.SubscribeRequest) *status.Status {
+
+	offset, st := c.GetCursor(ctx, partition.Stream, cursorID, partition.Id)
+	if st != nil {
+		return st
+	}
+	if offset < 0 {
+		return nil
+	}
+	req.StartPosition = client.StartPosition_OFFSET
+	req.StartOffset = offset + 1
+	return nil
+}
+
+func (c *cursorManager) getCursorsPartitionID(ctx context.Context, cursorKey []byte) (int32, *status.Status) {
 	stream := c.metadata.GetStream(cursorsStream)
 	if stream == nil {
 		return 0, status.New(codes.Internal, "Cursors stream does not exist")
@@ -180,7 +286,8 @@ func (c *cursorManager) getCursorsPartitionID(cursorKey []byte) (int32, *status.
 	leader, _ := cursorsPartition.GetLeader()
 	if leader != c.config.Clustering.ServerID {
 		// TODO: Attempt to forward to partition leader.
-		return 0, status.Newf(codes.FailedPrecondition, "Server not leader for cursors partition %d", cursorsPartitionID)
+		return 0, c.metadata.notLeaderStatus(ctx, cursorsPartition,
+			fmt.Sprintf("Server not leader for cursors partition %d", cursorsPartitionID))
 	}
 
 	return cursorsPartitionID, nil
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Paths of the data directory API on the admin HTTP server. Data directories
+// are local to each server, so requests apply to the server they're sent to.
+const (
+	dataDirsPath        = "/v1/datadirs"
+	dataDirsRebuildPath = dataDirsPath + "/rebuild"
+)
+
+// errNoOnlineDataDirs is returned when a partition can't be placed because
+// every data directory has failed.
+var errNoOnlineDataDirs = errors.New("no online data directories")
+
+// storageErrors are the errors which indicate a data directory's disk has
+// failed rather than a problem with a single file.
+var storageErrors = []syscall.Errno{syscall.EIO, syscall.EROFS, syscall.ENXIO, syscall.ENODEV}
+
+// isStorageError indicates if the error indicates the disk it came from has
+// failed.
+func isStorageError(err error) bool {
+	for _, errno := range storageErrors {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// dataDir is a directory partition data is stored in.
+type dataDir struct {
+	path     string
+	offline  bool
+	err      error     // Error which took the directory offline
+	failedAt time.Time // When the directory was taken offline
+
+	// compaction cleans the compacted logs in the directory so compactions
+	// on the same disk share an IO budget.
+	compaction *commitlog.CompactionScheduler
+}
+
+// dataDirs places partitions in the data directories and tracks which of them
+// have failed. Partitions stay in the directory they were first placed in,
+// and new partitions are placed in the online directory with the fewest
+// partitions.
+type dataDirs struct {
+	mu         sync.RWMutex
+	dirs       []*dataDir
+	partitions map[string]*dataDir // Directory of each partition by partitionKey
+}
+
+// newDataDirs creates a dataDirs for the configured data.dirs, or for the data
+// directory if there are none.
+func newDataDirs(config *Config) *dataDirs {
+	paths := config.DataDirs
+	if len(paths) == 0 {
+		paths = []string{config.DataDir}
+	}
+	dirs := make([]*dataDir, len(paths))
+	for i, path := range paths {
+		dirs[i] = &dataDir{path: path}
+	}
+	return &dataDirs{
+		dirs:       dirs,
+		partitions: make(map[string]*dataDir),
+	}
+}
+
+// partitionKey returns the key identifying a stream partition.
+func partitionKey(stream string, id int32) string {
+	return stream + "/" + strconv.FormatInt(int64(id), 10)
+}
+
+// partitionPath returns the path of the partition's commit log in the given
+// data directory.
+func partitionPath(dir *dataDir, stream string, id int32) string {
+	return filepath.Join(dir.path, "streams", stream, strconv.FormatInt(int64(id), 10))
+}
+
+// create creates the data directories if they don't exist.
+func (d *dataDirs) create() error {
+	for _, dir := range d.dirs {
+		if err := os.MkdirAll(dir.path, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// place returns the directory to store the partition in. This is the online
+// directory it's already stored in, if any, or else the online directory with
+// the fewest partitions. It returns errNoOnlineDataDirs if every directory is
+// offline.
+func (d *dataDirs) place(stream string, id int32) (*dataDir, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := partitionKey(stream, id)
+	if dir, ok := d.partitions[key]; ok && !dir.offline {
+		return dir, nil
+	}
+
+	var (
+		counts = make(map[*dataDir]int, len(d.dirs))
+		placed *dataDir
+	)
+	for _, dir := range d.partitions {
+		counts[dir]++
+	}
+	for _, dir := range d.dirs {
+		if dir.offline {
+			continue
+		}
+		// Partitions recovered on startup are already on disk.
+		if _, err := os.Stat(partitionPath(dir, stream, id)); err == nil {
+			placed = dir
+			break
+		}
+		if placed == nil || counts[dir] < counts[placed] {
+			placed = dir
+		}
+	}
+	if placed == nil {
+		return nil, errNoOnlineDataDirs
+	}
+	d.partitions[key] = placed
+	return placed, nil
+}
+
+// startCompaction starts a CompactionScheduler for each directory with the
+// given number of workers and IO budget.
+func (d *dataDirs) startCompaction(workers int, bytesPerSec int64) {
+	for _, dir := range d.dirs {
+		dir.compaction = commitlog.NewCompactionScheduler(workers, bytesPerSec)
+	}
+}
+
+// stopCompaction stops the directories' CompactionSchedulers, if started.
+func (d *dataDirs) stopCompaction() {
+	for _, dir := range d.dirs {
+		dir.compaction.Stop()
+	}
+}
+
+// remove forgets the directory of a deleted partition.
+func (d *dataDirs) remove(stream string, id int32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.partitions, partitionKey(stream, id))
+}
+
+// online returns the directories which haven't failed.
+func (d *dataDirs) online() []*dataDir {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	online := make([]*dataDir, 0, len(d.dirs))
+	for _, dir := range d.dirs {
+		if !dir.offline {
+			online = append(online, dir)
+		}
+	}
+	return online
+}
+
+// setOffline takes the directory offline because of the given error. It
+// returns false if the directory was already offline.
+func (d *dataDirs) setOffline(dir *dataDir, err error) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dir.offline {
+		return false
+	}
+	dir.offline = true
+	dir.err = err
+	dir.failedAt = time.Now()
+	return true
+}
+
+// dataDirInfo describes a data directory as reported by the admin API.
+type dataDirInfo struct {
+	Path       string     `json:"path"`
+	Online     bool       `json:"online"`
+	Error      string     `json:"error,omitempty"`
+	FailedAt   *time.Time `json:"failedAt,omitempty"`
+	Partitions int        `json:"partitions"`
+}
+
+// dataDirsResponse is the response to listing the data directories.
+type dataDirsResponse struct {
+	DataDirs []*dataDirInfo `json:"dataDirs"`
+}
+
+// info describes the data directories.
+func (d *dataDirs) info() []*dataDirInfo {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	counts := make(map[*dataDir]int, len(d.dirs))
+	for _, dir := range d.partitions {
+		counts[dir]++
+	}
+	infos := make([]*dataDirInfo, len(d.dirs))
+	for i, dir := range d.dirs {
+		info := &dataDirInfo{
+			Path:       dir.path,
+			Online:     !dir.offline,
+			Partitions: counts[dir],
+		}
+		if dir.offline {
+			failedAt := dir.failedAt
+			info.Error = dir.err.Error()
+			info.FailedAt = &failedAt
+		}
+		infos[i] = info
+	}
+	return infos
+}
+
+// handleStorageError takes the partition's data directory offline if the
+// given error indicates its disk has failed. The partitions stored in the
+// directory are then failed in the background: they are stopped, and the
+// server steps down as their leader or leaves their ISR so that the other
+// replicas carry on without it. It returns false if the error is not a
+// storage error, in which case the caller handles it.
+func (s *Server) handleStorageError(p *partition, err error) bool {
+	if !isStorageError(err) {
+		return false
+	}
+	if !s.dataDirs.setOffline(p.dataDir, err) {
+		return true
+	}
+	s.logger.Errorf("Data directory %s failed, taking it offline: %v", p.dataDir.path, err)
+	dir := p.dataDir
+	s.startGoroutine(func() {
+		s.failPartitions(dir)
+	})
+	return true
+}
+
+// failPartitions fails the partitions stored in the given data directory.
+func (s *Server) failPartitions(dir *dataDir) {
+	for _, stream := range s.metadata.GetStreams() {
+		for _, partition := range stream.GetPartitions() {
+			if partition.dataDir != dir {
+				continue
+			}
+			if err := partition.Fail(); err != nil {
+				s.logger.Warnf("Error stopping failed partition %s: %v", partition, err)
+			}
+			s.logger.Errorf("Partition %s failed since data directory %s is offline", partition, dir.path)
+			s.leaveFailedPartition(partition)
+		}
+	}
+}
+
+// leaveFailedPartition hands the failed partition over to its other replicas.
+// If this server leads it, a new leader is elected from the other in-sync
+// replicas. If it's a follower in the ISR, it's removed from the ISR.
+func (s *Server) leaveFailedPartition(p *partition) {
+	var (
+		serverID      = s.config.Clustering.ServerID
+		leader, epoch = p.GetLeader()
+		ctx, cancel   = context.WithTimeout(context.Background(), defaultRaftApplyTimeout)
+	)
+	defer cancel()
+	if leader == serverID {
+		req := &proto.ReportLeaderOp{
+			Stream:      p.Stream,
+			Partition:   p.Id,
+			Replica:     serverID,
+			Leader:      serverID,
+			LeaderEpoch: epoch,
+		}
+		if st := s.metadata.ReportLeader(ctx, req); st != nil {
+			s.logger.Errorf("Failed to step down as leader for failed partition %s: %s", p, st.Message())
+		}
+		return
+	}
+	if !p.inISR(serverID) {
+		return
+	}
+	req := &proto.ShrinkISROp{
+		Stream:          p.Stream,
+		Partition:       p.Id,
+		ReplicaToRemove: serverID,
+		Leader:          leader,
+		LeaderEpoch:     epoch,
+	}
+	if st := s.metadata.ShrinkISR(ctx, req); st != nil {
+		s.logger.Errorf("Failed to leave ISR for failed partition %s: %s", p, st.Message())
+	}
+}
+
+// rebuildResult describes the outcome of rebuilding failed partitions.
+type rebuildResult struct {
+	Rebuilt []string `json:"rebuilt"`
+	Pending []string `json:"pending"`
+}
+
+// rebuildFailedPartitions recreates the failed partitions in the online data
+// directories. They start out empty and replicate the leader's log as
+// followers, rejoining the ISR once caught up. Partitions this server still
+// leads, because no other in-sync replica could take over, are left pending
+// since rebuilding them would lose their data.
+func (s *Server) rebuildFailedPartitions() (*rebuildResult, error) {
+	if len(s.dataDirs.online()) == 0 {
+		return nil, errNoOnlineDataDirs
+	}
+	result := &rebuildResult{Rebuilt: []string{}, Pending: []string{}}
+	for _, stream := range s.metadata.GetStreams() {
+		for _, partition := range stream.GetPartitions() {
+			if !partition.IsFailed() {
+				continue
+			}
+			name := partitionKey(partition.Stream, partition.Id)
+			if leader, _ := partition.GetLeader(); leader == s.config.Clustering.ServerID {
+				result.Pending = append(result.Pending, name)
+				continue
+			}
+			rebuilt, err := s.metadata.RebuildPartition(partition.Stream, partition.Id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to rebuild partition %s: %v", partition, err)
+			}
+			s.logger.Infof("Rebuilding partition %s in data directory %s", rebuilt, rebuilt.dataDir.path)
+			result.Rebuilt = append(result.Rebuilt, name)
+		}
+	}
+	return result, nil
+}
+
+// handleDataDirs lists the server's data directories.
+func (s *Server) handleDataDirs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, &dataDirsResponse{DataDirs: s.dataDirs.info()})
+}
+
+// handleRebuildDataDirs rebuilds the partitions which failed along with their
+// data directories in the online ones.
+func (s *Server) handleRebuildDataDirs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	result, err := s.rebuildFailedPartitions()
+	if err == errNoOnlineDataDirs {
+		writeAdminError(w, http.StatusConflict, "No online data directories to rebuild partitions in", "")
+		return
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error(), "")
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, result)
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure partitions are spread across the online data directories and stay in
+// the directory they are stored in.
+func TestDataDirsPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "liftbridge-datadirs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := NewDefaultConfig()
+	config.DataDirs = []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	dirs := newDataDirs(config)
+	require.NoError(t, dirs.create())
+
+	// A partition recovered from disk is placed where it's stored.
+	require.NoError(t, os.MkdirAll(partitionPath(dirs.dirs[1], "foo", 0), os.ModePerm))
+	placed, err := dirs.place("foo", 0)
+	require.NoError(t, err)
+	require.Equal(t, dirs.dirs[1], placed)
+
+	// New partitions go to the directory with the fewest partitions.
+	placed, err = dirs.place("foo", 1)
+	require.NoError(t, err)
+	require.Equal(t, dirs.dirs[0], placed)
+	placed, err = dirs.place("foo", 1)
+	require.NoError(t, err)
+	require.Equal(t, dirs.dirs[0], placed)
+
+	// Partitions of an offline directory are placed in the online ones.
+	require.True(t, dirs.setOffline(dirs.dirs[0], syscall.EIO))
+	require.False(t, dirs.setOffline(dirs.dirs[0], syscall.EIO))
+	placed, err = dirs.place("foo", 1)
+	require.NoError(t, err)
+	require.Equal(t, dirs.dirs[1], placed)
+
+	infos := dirs.info()
+	require.False(t, infos[0].Online)
+	require.Equal(t, syscall.EIO.Error(), infos[0].Error)
+	require.Equal(t, 0, infos[0].Partitions)
+	require.True(t, infos[1].Online)
+	require.Equal(t, 2, infos[1].Partitions)
+
+	require.True(t, dirs.setOffline(dirs.dirs[1], syscall.EIO))
+	_, err = dirs.place("foo", 2)
+	require.Equal(t, errNoOnlineDataDirs, err)
+}
+
+// Ensure only I/O errors indicating a failed disk are storage errors.
+func TestIsStorageError(t *testing.T) {
+	require.True(t, isStorageError(&os.PathError{Op: "write", Path: "/data", Err: syscall.EIO}))
+	require.True(t, isStorageError(errors.Wrap(&os.PathError{Op: "open", Path: "/data", Err: syscall.EROFS}, "failed")))
+	require.False(t, isStorageError(&os.PathError{Op: "open", Path: "/data", Err: syscall.ENOENT}))
+	require.False(t, isStorageError(errors.New("failed")))
+}
+
+// Ensure a failed data directory only fails the partitions stored in it, which
+// fail over to the other replicas, and that the admin API rebuilds them in the
+// online data directories.
+func TestDataDirFailure(t *testing.T) {
+	defer cleanupStorage(t)
+
+	var servers []*Server
+	for i, id := range []string{"a", "b"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.DataDirs = []string{
+			filepath.Join(config.DataDir, "data-0"),
+			filepath.Join(config.DataDir, "data-1"),
+		}
+		config.AdminListen = fmt.Sprintf("localhost:%d", 9390+i)
+		// The failed leader leaves the ISR once it exceeds the max lag time,
+		// so keep it short while keeping followers which caught up fetching.
+		config.Clustering.ReplicaMaxLagTime = 3 * time.Second
+		config.Clustering.ReplicaMaxIdleWait = 2 * time.Second
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	getMetadataLeader(t, 10*time.Second, servers...)
+	requireReplication(t, servers...)
+
+	leader := getPartitionLeader(t, 10*time.Second, "foo", 0, servers...)
+	follower := servers[0]
+	if follower == leader {
+		follower = servers[1]
+	}
+
+	// Fail the leader's data directory.
+	failed := leader.metadata.GetPartition("foo", 0)
+	failedDir := failed.dataDir
+	ioErr := &os.PathError{Op: "write", Path: failedDir.path, Err: syscall.EIO}
+	require.True(t, leader.handleStorageError(failed, ioErr))
+
+	require.Eventually(t, func() bool {
+		return getPartitionLeader(t, 10*time.Second, "foo", 0, servers...) == follower
+	}, 10*time.Second, 10*time.Millisecond)
+	require.True(t, failed.IsFailed())
+	waitForISR(t, 10*time.Second, "foo", 0, 1, servers...)
+
+	var dirsResp dataDirsResponse
+	require.Equal(t, http.StatusOK, adminRequest(t, leader, http.MethodGet, dataDirsPath, &dirsResp))
+	require.Len(t, dirsResp.DataDirs, 2)
+	for _, info := range dirsResp.DataDirs {
+		require.Equal(t, info.Path != failedDir.path, info.Online)
+	}
+
+	// Rebuild the partition in the other data directory.
+	var rebuildResp rebuildResult
+	require.Equal(t, http.StatusOK, adminRequest(t, leader, http.MethodPost, dataDirsRebuildPath, &rebuildResp))
+	require.Equal(t, []string{"foo/0"}, rebuildResp.Rebuilt)
+	require.Empty(t, rebuildResp.Pending)
+
+	waitForISR(t, 10*time.Second, "foo", 0, 2, servers...)
+	rebuilt := leader.metadata.GetPartition("foo", 0)
+	require.False(t, rebuilt.IsFailed())
+	require.NotEqual(t, failedDir, rebuilt.dataDir)
+	require.Eventually(t, func() bool {
+		return rebuilt.log.NewestOffset() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, http.StatusMethodNotAllowed, adminRequest(t, leader, http.MethodGet, dataDirsRebuildPath, nil))
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"time"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// MsgIDHeader is the message header containing a client-supplied message ID.
+// When streams.dedup.window is set, a partition leader drops messages whose ID
+// was already published to the partition within the window and acks them
+// with the offset of the original message.
+const MsgIDHeader = "Liftbridge-Msg-Id"
+
+// dedupCache tracks the offsets of messages published to a partition within
+// a time window, keyed by message ID. If maxEntries is positive, the oldest
+// entries are evicted to keep at most that many, even if they are within the
+// window.
+type dedupCache struct {
+	window     time.Duration
+	maxEntries int
+	offsets    map[string]int64
+	entries    *list.List // dedupEntry values in timestamp order
+}
+
+type dedupEntry struct {
+	id        string
+	timestamp int64
+}
+
+func newDedupCache(window time.Duration, maxEntries int) *dedupCache {
+	return &dedupCache{
+		window:     window,
+		maxEntries: maxEntries,
+		offsets:    make(map[string]int64),
+		entries:    list.New(),
+	}
+}
+
+// get returns the offset of the message with the given ID if one was
+// published within the window ending at the given timestamp.
+func (d *dedupCache) get(id string, now int64) (int64, bool) {
+	d.expire(now)
+	offset, ok := d.offsets[id]
+	return offset, ok
+}
+
+// add records the offset of a message published at the given timestamp. If
+// the ID is already present, the original offset is kept. If the cache is
+// full, the oldest entry is evicted.
+func (d *dedupCache) add(id string, offset, timestamp int64) {
+	if _, ok := d.offsets[id]; ok {
+		return
+	}
+	d.offsets[id] = offset
+	d.entries.PushBack(dedupEntry{id: id, timestamp: timestamp})
+	if d.maxEntries > 0 && d.entries.Len() > d.maxEntries {
+		d.remove(d.entries.Front())
+	}
+}
+
+// expire removes messages published before the window ending at the given
+// timestamp.
+func (d *dedupCache) expire(now int64) {
+	cutoff := now - int64(d.window)
+	for e := d.entries.Front(); e != nil; e = d.entries.Front() {
+		entry := e.Value.(dedupEntry)
+		if entry.timestamp > cutoff {
+			return
+		}
+		d.remove(e)
+	}
+}
+
+func (d *dedupCache) remove(e *list.Element) {
+	delete(d.offsets, e.Value.(dedupEntry).id)
+	d.entries.Remove(e)
+}
+
+// deduplicator filters duplicate messages out of the batches written by a
+// partition leader. Duplicates of messages in the batch being built are held
+// until the batch is appended and its offsets are known.
+type deduplicator struct {
+	cache     *dedupCache
+	batchIDs  map[string]int // Message ID to index in the current batch
+	batchDups []batchDuplicate
+}
+
+type batchDuplicate struct {
+	msg   *commitlog.Message
+	index int
+}
+
+// duplicateAck is a duplicate message and the offset of the original.
+type duplicateAck struct {
+	msg    *commitlog.Message
+	offset int64
+}
+
+func newDeduplicator(cache *dedupCache) *deduplicator {
+	return &deduplicator{cache: cache, batchIDs: make(map[string]int)}
+}
+
+// filter checks if the message, which would be added to the current batch at
+// the given index, is a duplicate. If it duplicates a message already in the
+// log, the original offset is returned. If it duplicates a message in the
+// current batch, it is held and -1 is returned.
+func (d *deduplicator) filter(m *commitlog.Message, index int) (int64, bool) {
+	id := string(m.Headers[MsgIDHeader])
+	if id == "" {
+		return 0, false
+	}
+	if offset, ok := d.cache.get(id, m.Timestamp); ok {
+		return offset, true
+	}
+	if original, ok := d.batchIDs[id]; ok {
+		d.batchDups = append(d.batchDups, batchDuplicate{msg: m, index: original})
+		return -1, true
+	}
+	d.batchIDs[id] = index
+	return 0, false
+}
+
+// appended records the IDs of a batch that was written to the log at the
+// given offsets and returns the held duplicates with their original offsets.
+// Messages which fell out of the window are expired, so they don't linger
+// while messages without IDs are published.
+func (d *deduplicator) appended(batch []*commitlog.Message, offsets []int64) []duplicateAck {
+	for i, m := range batch {
+		if id := string(m.Headers[MsgIDHeader]); id != "" {
+			d.cache.add(id, offsets[i], m.Timestamp)
+		}
+	}
+	if len(batch) > 0 {
+		d.cache.expire(batch[len(batch)-1].Timestamp)
+	}
+	dups := make([]duplicateAck, len(d.batchDups))
+	for i, dup := range d.batchDups {
+		dups[i] = duplicateAck{msg: dup.msg, offset: offsets[dup.index]}
+	}
+	d.reset()
+	return dups
+}
+
+// reset discards the state of the current batch.
+func (d *deduplicator) reset() {
+	for id := range d.batchIDs {
+		delete(d.batchIDs, id)
+	}
+	d.batchDups = d.batchDups[:0]
+}
+
+// loadDedupCache rebuilds the dedup cache from the messages in the log that
+// are within the dedup window, so that a new leader continues to detect
+// duplicates of messages published to the previous leader. If the log can't
+// be read, the cache is missing messages and duplicates of them are written,
+// so a warning is logged.
+func (p *partition) loadDedupCache() *dedupCache {
+	cache := newDedupCache(p.dedupWindow, p.dedupMaxEntries)
+	newest := p.log.NewestOffset()
+	if newest < 0 {
+		return cache
+	}
+	degraded := func(err error, from int64) *dedupCache {
+		p.srv.logger.Warnf("Deduplication is degraded for partition %s: failed to load message IDs "+
+			"from offset %d to %d, duplicates of these messages will not be detected: %v",
+			p, from, newest, err)
+		return cache
+	}
+	start, err := p.log.EarliestOffsetAfterTimestamp(timestamp() - int64(p.dedupWindow))
+	if err != nil {
+		return degraded(err, p.log.OldestOffset())
+	}
+	if start > newest {
+		return cache
+	}
+	reader, err := p.log.NewReader(start, true)
+	if err != nil {
+		return degraded(err, start)
+	}
+	// Read control records, which have no message ID, so that the newest
+	// offset is reached even if it's one.
+	reader.SetReadControl(true)
+	headersBuf := make([]byte, 28)
+	next := start
+	for {
+		msg, offset, timestamp, _, err := reader.ReadPooledMessage(context.Background(), headersBuf)
+		if err != nil {
+			return degraded(err, next)
+		}
+		if id := msg.Headers()[MsgIDHeader]; len(id) > 0 {
+			cache.add(string(id), offset, timestamp)
+		}
+		commitlog.ReleaseMessage(msg)
+		if offset >= newest {
+			return cache
+		}
+		next = offset + 1
+	}
+}
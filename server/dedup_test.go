@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+func dedupMessage(id string, ts int64) *commitlog.Message {
+	return &commitlog.Message{
+		Timestamp: ts,
+		Headers:   map[string][]byte{MsgIDHeader: []byte(id)},
+	}
+}
+
+// Ensure dedupCache remembers message IDs for the duration of the window and
+// keeps the offset of the first message with an ID.
+func TestDedupCache(t *testing.T) {
+	cache := newDedupCache(10, 0)
+	cache.add("a", 0, 100)
+	cache.add("b", 1, 105)
+	cache.add("a", 2, 106)
+
+	offset, ok := cache.get("a", 109)
+	require.True(t, ok)
+	require.Equal(t, int64(0), offset)
+
+	_, ok = cache.get("a", 110)
+	require.False(t, ok)
+	offset, ok = cache.get("b", 110)
+	require.True(t, ok)
+	require.Equal(t, int64(1), offset)
+
+	_, ok = cache.get("b", 115)
+	require.False(t, ok)
+	require.Empty(t, cache.offsets)
+	require.Equal(t, 0, cache.entries.Len())
+}
+
+// Ensure dedupCache evicts the oldest entries once it holds the maximum
+// number of entries.
+func TestDedupCacheMaxEntries(t *testing.T) {
+	cache := newDedupCache(time.Minute, 2)
+	cache.add("a", 0, 100)
+	cache.add("b", 1, 101)
+	cache.add("c", 2, 102)
+
+	_, ok := cache.get("a", 103)
+	require.False(t, ok)
+	offset, ok := cache.get("b", 103)
+	require.True(t, ok)
+	require.Equal(t, int64(1), offset)
+	offset, ok = cache.get("c", 103)
+	require.True(t, ok)
+	require.Equal(t, int64(2), offset)
+	require.Len(t, cache.offsets, 2)
+	require.Equal(t, 2, cache.entries.Len())
+}
+
+// Ensure deduplicator expires messages which fell out of the window when a
+// batch is appended, even if its messages have no ID.
+func TestDeduplicatorExpiresOnAppend(t *testing.T) {
+	cache := newDedupCache(10, 0)
+	cache.add("a", 0, 100)
+	dedup := newDeduplicator(cache)
+
+	dedup.appended([]*commitlog.Message{{Timestamp: 105, Headers: map[string][]byte{}}}, []int64{1})
+	require.Len(t, cache.offsets, 1)
+	dedup.appended([]*commitlog.Message{{Timestamp: 110, Headers: map[string][]byte{}}}, []int64{2})
+	require.Empty(t, cache.offsets)
+	require.Equal(t, 0, cache.entries.Len())
+}
+
+// Ensure deduplicator detects duplicates of logged messages and of messages
+// in the current batch.
+func TestDeduplicator(t *testing.T) {
+	cache := newDedupCache(time.Minute, 0)
+	cache.add("a", 5, 100)
+	dedup := newDeduplicator(cache)
+
+	offset, dup := dedup.filter(dedupMessage("a", 200), 0)
+	require.True(t, dup)
+	require.Equal(t, int64(5), offset)
+
+	// Messages without an ID are never duplicates.
+	_, dup = dedup.filter(&commitlog.Message{Timestamp: 200, Headers: map[string][]byte{}}, 0)
+	require.False(t, dup)
+
+	b := dedupMessage("b", 200)
+	_, dup = dedup.filter(b, 1)
+	require.False(t, dup)
+	bDup := dedupMessage("b", 201)
+	offset, dup = dedup.filter(bDup, 2)
+	require.True(t, dup)
+	require.Equal(t, int64(-1), offset)
+
+	batch := []*commitlog.Message{{Headers: map[string][]byte{}}, b}
+	dups := dedup.appended(batch, []int64{6, 7})
+	require.Len(t, dups, 1)
+	require.Equal(t, bDup, dups[0].msg)
+	require.Equal(t, int64(7), dups[0].offset)
+	require.Empty(t, dedup.batchIDs)
+	require.Empty(t, dedup.batchDups)
+
+	offset, dup = dedup.filter(dedupMessage("b", 300), 0)
+	require.True(t, dup)
+	require.Equal(t, int64(7), offset)
+}
+
+// Ensure loadDedupCache rebuilds the dedup cache from messages in the log
+// within the dedup window.
+func TestPartitionLoadDedupCache(t *testing.T) {
+	defer cleanupStorage(t)
+
+	server := createServer()
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	p, err := server.newPartition(&proto.Partition{
+		Subject:  "foo",
+		Stream:   "foo",
+		Replicas: []string{"a"},
+		Leader:   "a",
+		Isr:      []string{"a"},
+	}, false, nil)
+	require.NoError(t, err)
+	defer p.Close()
+	p.dedupWindow = time.Minute
+
+	require.Empty(t, p.loadDedupCache().offsets)
+
+	now := time.Now().UnixNano()
+	old := now - int64(time.Hour)
+	_, err = p.log.Append([]*commitlog.Message{
+		dedupMessage("a", old),
+		dedupMessage("b", old),
+		dedupMessage("c", now),
+		{Timestamp: now, Headers: map[string][]byte{}},
+		dedupMessage("d", now),
+	})
+	require.NoError(t, err)
+
+	cache := p.loadDedupCache()
+	require.Equal(t, map[string]int64{"c": 2, "d": 4}, cache.offsets)
+}
+
+// Ensure publishes with a message ID that was already published within the
+// dedup window are dropped and acked with the original offset.
+func TestPublishDedup(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.DedupWindow = time.Minute
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	publish := func(id string, ackPolicy client.AckPolicy) int64 {
+		req := &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte("hello"),
+			AckPolicy: ackPolicy,
+		}
+		if id != "" {
+			req.Headers = map[string][]byte{MsgIDHeader: []byte(id)}
+		}
+		resp, err := api.Publish(ctx, req)
+		require.NoError(t, err)
+		return resp.Ack.Offset
+	}
+
+	require.Equal(t, int64(0), publish("a", client.AckPolicy_ALL))
+	require.Equal(t, int64(0), publish("a", client.AckPolicy_ALL))
+	require.Equal(t, int64(0), publish("a", client.AckPolicy_LEADER))
+	require.Equal(t, int64(1), publish("b", client.AckPolicy_LEADER))
+	require.Equal(t, int64(2), publish("", client.AckPolicy_LEADER))
+	require.Equal(t, int64(3), publish("", client.AckPolicy_LEADER))
+	require.Equal(t, int64(1), publish("b", client.AckPolicy_ALL))
+
+	partition := s1.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+	require.Equal(t, int64(3), partition.log.NewestOffset())
+}
+
+// Ensure streams can enable or disable deduplication with their own window.
+func TestPublishDedupPerStream(t *testing.T) {
+	for _, tc := range []struct {
+		serverWindow time.Duration
+		streamWindow string
+		deduped      bool
+	}{
+		{0, "1m", true},
+		{time.Minute, "0s", false},
+		{time.Minute, "", true},
+	} {
+		func() {
+			defer cleanupStorage(t)
+
+			s1Config := getTestConfig("a", true, 5050)
+			s1Config.Streams.DedupWindow = tc.serverWindow
+			s1 := runServerWithConfig(t, s1Config)
+			defer s1.Stop()
+			getMetadataLeader(t, 10*time.Second, s1)
+
+			conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+			require.NoError(t, err)
+			defer conn.Close()
+			api := client.NewAPIClient(conn)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			createCtx := ctx
+			if tc.streamWindow != "" {
+				createCtx = metadata.AppendToOutgoingContext(ctx, DedupWindowMetadata, tc.streamWindow)
+			}
+			_, err = api.CreateStream(createCtx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+			require.NoError(t, err)
+
+			var offsets []int64
+			for i := 0; i < 2; i++ {
+				resp, err := api.Publish(ctx, &client.PublishRequest{
+					Stream:    "foo",
+					Value:     []byte("hello"),
+					Headers:   map[string][]byte{MsgIDHeader: []byte("a")},
+					AckPolicy: client.AckPolicy_ALL,
+				})
+				require.NoError(t, err)
+				offsets = append(offsets, resp.Ack.Offset)
+			}
+			require.Equal(t, tc.deduped, offsets[0] == offsets[1], "%+v", tc)
+		}()
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// DeriveFromMetadata is the CreateStream request metadata key used to create
+// the stream as a derived stream of the existing stream with the given name.
+// The cluster continuously republishes the messages committed to the source
+// stream to the derived stream, partitioning them by key, so that a stream
+// can be re-keyed or repartitioned without running a consumer to do it.
+const DeriveFromMetadata = "liftbridge-derive-from"
+
+// DeriveKeyHeaderMetadata is the CreateStream request metadata key containing
+// the name of a message header whose value is used as the key of messages
+// republished to a derived stream. Messages without the header keep their
+// key. It is only valid with DeriveFromMetadata.
+const DeriveKeyHeaderMetadata = "liftbridge-derive-key-header"
+
+// DerivedSourcePartitionHeader and DerivedSourceOffsetHeader are the headers
+// set on messages in a derived stream containing the partition and offset of
+// the message in the source stream. Messages may be republished more than
+// once if a source partition's leader fails, so consumers can use these to
+// detect duplicates.
+const (
+	DerivedSourcePartitionHeader = "Liftbridge-Derived-Source-Partition"
+	DerivedSourceOffsetHeader    = "Liftbridge-Derived-Source-Offset"
+)
+
+const (
+	// deriverRefreshInterval is how often a deriver checks for derived
+	// streams of its partition's stream being created or deleted.
+	deriverRefreshInterval = time.Second
+	// derivedCheckpointInterval is how often the progress of a derived
+	// stream is checkpointed through Raft.
+	derivedCheckpointInterval = time.Second
+	defaultDeriveTimeout      = 5 * time.Second
+	deriveRetryInterval       = time.Second
+)
+
+// applyDeriveMetadata sets the derived stream settings of a CreateStream
+// request from the request metadata on the given StreamConfig.
+func applyDeriveMetadata(md metadata.MD, config *proto.StreamConfig) *status.Status {
+	var (
+		deriveFrom = md.Get(DeriveFromMetadata)
+		keyHeader  = md.Get(DeriveKeyHeaderMetadata)
+	)
+	if len(deriveFrom) == 0 && len(keyHeader) == 0 {
+		return nil
+	}
+	if len(deriveFrom) == 0 || deriveFrom[0] == "" {
+		return status.Newf(codes.InvalidArgument, "%s requires %s",
+			DeriveKeyHeaderMetadata, DeriveFromMetadata)
+	}
+	if config.SampleOf != "" {
+		return status.Newf(codes.InvalidArgument, "%s cannot be used with %s",
+			DeriveFromMetadata, SampleOfMetadata)
+	}
+	config.DeriveFrom = deriveFrom[0]
+	if len(keyHeader) > 0 {
+		config.DeriveKeyHeader = keyHeader[0]
+	}
+	return nil
+}
+
+// ensureDeriveSource verifies the source stream of a derived stream exists
+// and can be derived from. Aliases are resolved to the stream's name so that
+// the derived stream keeps following the stream if the alias is removed.
+func (a *apiServer) ensureDeriveSource(config *proto.StreamConfig) *status.Status {
+	if config.DeriveFrom == "" {
+		return nil
+	}
+	source := a.metadata.GetStream(config.DeriveFrom)
+	if source == nil {
+		return streamNotFoundStatus(codes.NotFound, fmt.Sprintf("No such stream: %s", config.DeriveFrom),
+			config.DeriveFrom)
+	}
+	if isInternalStream(source.GetName()) {
+		return status.Newf(codes.InvalidArgument, "Cannot derive from internal stream %s", source.GetName())
+	}
+	config.DeriveFrom = source.GetName()
+	return nil
+}
+
+// derivedStreams returns the streams which are derived from the stream with
+// the given name, including its snapshot streams.
+func derivedStreams(streams []*stream, source string) []*stream {
+	var derived []*stream
+	for _, stream := range streams {
+		config := stream.GetConfig()
+		if config.GetDeriveFrom() == source || config.GetSnapshotOf() == source {
+			derived = append(derived, stream)
+		}
+	}
+	return derived
+}
+
+// derivedMessage returns the publish request republishing a message from a
+// source stream partition to the given derived stream. If the derived stream
+// has a key header, its value in the message becomes the key. Keyed messages
+// are assigned to partitions by key hash, and messages without a key to the
+// derived stream partition with the same ID modulo the number of partitions.
+func derivedMessage(derived *stream, msg *client.Message) *client.PublishRequest {
+	key := msg.Key
+	if header := derived.GetConfig().GetDeriveKeyHeader(); header != "" {
+		if value, ok := msg.Headers[header]; ok {
+			key = value
+		}
+	}
+	numPartitions := uint32(len(derived.GetPartitions()))
+	partition := uint32(msg.Partition) % numPartitions
+	if len(key) > 0 {
+		partition = hasher(key) % numPartitions
+	}
+
+	headers := make(map[string][]byte, len(msg.Headers)+2)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[DerivedSourcePartitionHeader] = []byte(strconv.FormatInt(int64(msg.Partition), 10))
+	headers[DerivedSourceOffsetHeader] = []byte(strconv.FormatInt(msg.Offset, 10))
+	return &client.PublishRequest{
+		Key:       key,
+		Value:     msg.Value,
+		Stream:    derived.GetName(),
+		Partition: int32(partition),
+		Headers:   headers,
+		AckPolicy: client.AckPolicy_ALL,
+	}
+}
+
+// runDeriver republishes the messages committed to the given partition to the
+// derived streams of its stream until the stop channel is closed. It should
+// be run by the partition leader. Each derived stream is populated
+// independently, starting after its last checkpointed offset for the
+// partition or at the beginning of the partition if there is none. Snapshot
+// streams are populated once with the latest message for each key.
+func (a *apiServer) runDeriver(p *partition, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		running = make(map[string]context.CancelFunc)
+		ticker  = time.NewTicker(deriverRefreshInterval)
+	)
+	defer ticker.Stop()
+	for {
+		current := make(map[string]struct{})
+		for _, derived := range derivedStreams(a.metadata.GetStreams(), p.Stream) {
+			name := derived.GetName()
+			current[name] = struct{}{}
+			if _, ok := running[name]; ok {
+				continue
+			}
+			deriveCtx, deriveCancel := context.WithCancel(ctx)
+			running[name] = deriveCancel
+			derived := derived
+			if derived.GetConfig().GetSnapshotOf() != "" {
+				a.startGoroutine(func() {
+					a.snapshotPartition(deriveCtx, p, derived)
+				})
+				continue
+			}
+			a.startGoroutine(func() {
+				a.derivePartition(deriveCtx, p, derived)
+			})
+		}
+		for name, deriveCancel := range running {
+			if _, ok := current[name]; !ok {
+				deriveCancel()
+				delete(running, name)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// derivePartition republishes the messages committed to the given source
+// partition to a derived stream until the context is canceled, periodically
+// checkpointing the offset of the last message republished. Messages are
+// republished at least once: those republished after the last checkpoint
+// are republished again by the next leader if leadership changes.
+func (a *apiServer) derivePartition(ctx context.Context, p *partition, derived *stream) {
+	start := p.log.OldestOffset()
+	if offset, ok := derived.GetDerivedOffset(p.Id); ok && offset+1 > start {
+		start = offset + 1
+	}
+	if start < 0 {
+		start = 0
+	}
+	reader, err := p.log.NewReader(start, false)
+	if err != nil {
+		a.logger.Errorf("Failed to start deriving stream %s from partition %s: %v",
+			derived.GetName(), p, err)
+		return
+	}
+
+	// Checkpoint progress in the background since reading blocks until the
+	// next message is committed.
+	published := start - 1
+	checkpointed := published
+	checkpoint := func() {
+		offset := atomic.LoadInt64(&published)
+		if offset == checkpointed {
+			return
+		}
+		if a.checkpointDerivedOffset(derived, p.Id, offset) {
+			checkpointed = offset
+		}
+	}
+	a.startGoroutine(func() {
+		ticker := time.NewTicker(derivedCheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				checkpoint()
+				return
+			case <-ticker.C:
+				checkpoint()
+			}
+		}
+	})
+
+	headersBuf := make([]byte, 28)
+	for {
+		msg, st := readSubscriptionMessage(ctx, p, reader, headersBuf)
+		if st != nil {
+			if ctx.Err() == nil && st.Code() == codes.Internal {
+				a.logger.Errorf("Deriver for partition %s failed to read messages: %v", p, st.Message())
+			}
+			return
+		}
+		req := derivedMessage(derived, msg)
+		for !a.republish(req, "derived") {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(deriveRetryInterval):
+			}
+		}
+		atomic.StoreInt64(&published, msg.Offset)
+	}
+}
+
+// checkpointDerivedOffset records the offset of the last message from the
+// given source partition republished to a derived stream. It returns false if
+// the checkpoint failed and should be retried.
+func (a *apiServer) checkpointDerivedOffset(derived *stream, partition int32, offset int64) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDeriveTimeout)
+	defer cancel()
+	st := a.metadata.SetDerivedOffset(ctx, &proto.SetDerivedOffsetOp{
+		Stream:    derived.GetName(),
+		Partition: partition,
+		Offset:    offset,
+	})
+	if st == nil {
+		return true
+	}
+	if st.Code() == codes.NotFound {
+		// The derived stream was deleted.
+		return true
+	}
+	a.logger.Warnf("Failed to checkpoint derived stream %s: %v", derived.GetName(), st.Message())
+	return false
+}
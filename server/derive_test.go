@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure applyDeriveMetadata validates the derived stream settings.
+func TestApplyDeriveMetadata(t *testing.T) {
+	config := new(proto.StreamConfig)
+	require.Nil(t, applyDeriveMetadata(metadata.MD{}, config))
+	require.Equal(t, "", config.DeriveFrom)
+
+	require.Nil(t, applyDeriveMetadata(metadata.Pairs(DeriveFromMetadata, "foo"), config))
+	require.Equal(t, "foo", config.DeriveFrom)
+	require.Equal(t, "", config.DeriveKeyHeader)
+
+	config = new(proto.StreamConfig)
+	require.Nil(t, applyDeriveMetadata(metadata.Pairs(
+		DeriveFromMetadata, "foo", DeriveKeyHeaderMetadata, "user"), config))
+	require.Equal(t, "foo", config.DeriveFrom)
+	require.Equal(t, "user", config.DeriveKeyHeader)
+
+	st := applyDeriveMetadata(metadata.Pairs(DeriveKeyHeaderMetadata, "user"), new(proto.StreamConfig))
+	require.NotNil(t, st)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	st = applyDeriveMetadata(metadata.Pairs(DeriveFromMetadata, "foo"), &proto.StreamConfig{SampleOf: "bar"})
+	require.NotNil(t, st)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
+
+// Ensure derivedMessage re-keys messages by the key header and partitions
+// them by key.
+func TestDerivedMessage(t *testing.T) {
+	derived := newStream("bar", "bar", &proto.StreamConfig{DeriveFrom: "foo", DeriveKeyHeader: "user"}, time.Now())
+	for i := int32(0); i < 3; i++ {
+		derived.SetPartition(i, &partition{Partition: &proto.Partition{Id: i}})
+	}
+
+	msg := &client.Message{
+		Offset:    5,
+		Partition: 1,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Headers:   map[string][]byte{"user": []byte("alice")},
+	}
+	req := derivedMessage(derived, msg)
+	require.Equal(t, "bar", req.Stream)
+	require.Equal(t, []byte("alice"), req.Key)
+	require.Equal(t, []byte("value"), req.Value)
+	require.Equal(t, int32(hasher([]byte("alice"))%3), req.Partition)
+	require.Equal(t, []byte("alice"), req.Headers["user"])
+	require.Equal(t, []byte("1"), req.Headers[DerivedSourcePartitionHeader])
+	require.Equal(t, []byte("5"), req.Headers[DerivedSourceOffsetHeader])
+	require.Len(t, msg.Headers, 1)
+
+	// Messages without the header keep their key.
+	req = derivedMessage(derived, &client.Message{Partition: 1, Key: []byte("key")})
+	require.Equal(t, []byte("key"), req.Key)
+	require.Equal(t, int32(hasher([]byte("key"))%3), req.Partition)
+
+	// Messages without a key keep their partition modulo the number of
+	// derived partitions.
+	req = derivedMessage(derived, &client.Message{Partition: 4})
+	require.Nil(t, req.Key)
+	require.Equal(t, int32(1), req.Partition)
+}
+
+// Ensure a derived stream receives the messages of its source stream re-keyed
+// and repartitioned, including those published before it was created, and
+// that its progress is checkpointed.
+func TestDerivedStream(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo", Partitions: 2})
+	require.NoError(t, err)
+
+	num := 20
+	publish := func(i int) {
+		user := fmt.Sprintf("user-%d", i%5)
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Partition: int32(i % 2),
+			Key:       []byte(strconv.Itoa(i)),
+			Value:     []byte(strconv.Itoa(i)),
+			Headers:   map[string][]byte{"user": []byte(user)},
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+	for i := 0; i < num/2; i++ {
+		publish(i)
+	}
+
+	_, err = api.CreateStream(
+		metadata.AppendToOutgoingContext(ctx, DeriveFromMetadata, "foo", DeriveKeyHeaderMetadata, "user"),
+		&client.CreateStreamRequest{Subject: "foo-by-user", Name: "foo-by-user", Partitions: 3},
+	)
+	require.NoError(t, err)
+	derived := s1.metadata.GetStream("foo-by-user")
+	require.NotNil(t, derived)
+	require.Equal(t, "foo", derived.GetConfig().DeriveFrom)
+	require.Equal(t, "user", derived.GetConfig().DeriveKeyHeader)
+
+	for i := num / 2; i < num; i++ {
+		publish(i)
+	}
+
+	waitForStreamMessages(t, 5*time.Second, derived, int64(num))
+
+	received := make(map[string]bool)
+	for partition := int32(0); partition < 3; partition++ {
+		sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+			Stream:        "foo-by-user",
+			Partition:     partition,
+			StartPosition: client.StartPosition_EARLIEST,
+		})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+
+		hw := derived.GetPartition(partition).log.HighWatermark()
+		for i := int64(0); i <= hw; i++ {
+			msg, err := sub.Recv()
+			require.NoError(t, err)
+			require.Equal(t, int32(hasher(msg.Key)%3), partition)
+			require.Equal(t, msg.Headers["user"], msg.Key)
+			require.NotNil(t, msg.Headers[DerivedSourceOffsetHeader])
+			received[string(msg.Value)] = true
+		}
+	}
+	for i := 0; i < num; i++ {
+		require.True(t, received[strconv.Itoa(i)], i)
+	}
+
+	// Progress through each source partition is checkpointed.
+	waitForDerivedOffset(t, 5*time.Second, derived, 0, int64(num/2-1))
+	waitForDerivedOffset(t, 5*time.Second, derived, 1, int64(num/2-1))
+}
+
+// Ensure creating a derived stream of a stream which doesn't exist fails.
+func TestDerivedStreamNoSource(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(
+		metadata.AppendToOutgoingContext(ctx, DeriveFromMetadata, "foo"),
+		&client.CreateStreamRequest{Subject: "bar", Name: "bar"},
+	)
+	require.Error(t, err)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Nil(t, s1.metadata.GetStream("bar"))
+}
+
+func waitForStreamMessages(t *testing.T, timeout time.Duration, stream *stream, expected int64) {
+	var count int64
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		count = 0
+		for _, partition := range stream.GetPartitions() {
+			count += partition.log.HighWatermark() + 1
+		}
+		if count == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stackFatalf(t, "Stream has %d messages, expected %d", count, expected)
+}
+
+func waitForDerivedOffset(t *testing.T, timeout time.Duration, derived *stream, partition int32, expected int64) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if offset, ok := derived.GetDerivedOffset(partition); ok && offset == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	offset, _ := derived.GetDerivedOffset(partition)
+	stackFatalf(t, "Derived offset for partition %d is %d, expected %d", partition, offset, expected)
+}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/huff0"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// AcceptDictionaryMetadata is the Subscribe request metadata key which, if
+// "true", asks for compressed batches to be compressed with a dictionary
+// trained from a sample of the partition's messages. This only applies to the
+// zstd codec and once the partition has enough messages to train a
+// dictionary. The dictionary is sent in the DictionaryMetadata response header
+// and is needed to decompress the batches.
+const AcceptDictionaryMetadata = "liftbridge-accept-dictionary"
+
+// DictionaryMetadata is the Subscribe response header metadata key containing
+// the dictionary batches are compressed with, in the zstd dictionary format.
+// It is not set if batches are compressed without a dictionary. zstd frames
+// compressed with it identify it by its dictionary ID.
+const DictionaryMetadata = "liftbridge-compression-dictionary-bin"
+
+const (
+	// dictionaryKmerSize is the length of the substrings counted when
+	// training a dictionary.
+	dictionaryKmerSize = 8
+	// dictionarySegmentSize is the length of the segments a dictionary is
+	// built from.
+	dictionarySegmentSize = 64
+	// maxDictionaryBytes is the largest dictionary content trained, which
+	// keeps the response header the dictionary is sent in small.
+	maxDictionaryBytes = 32 * 1024
+	// dictionarySampleTimeout bounds reading the sample of a partition's
+	// messages.
+	dictionarySampleTimeout = 5 * time.Second
+	// dictionaryEncoderLevel is the zstd level batches are compressed with
+	// when using a dictionary. The default level doesn't find matches in the
+	// dictionary.
+	dictionaryEncoderLevel = zstd.SpeedBetterCompression
+)
+
+// zstdDictionaryMagic starts dictionaries in the zstd dictionary format.
+var zstdDictionaryMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// Normalized counts of zstd's predefined distributions of offset, match
+// length, and literal length codes, and their accuracy logs.
+var (
+	zstdOffsetCodeCounts = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+	zstdMatchLengthCodeCounts = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1}
+	zstdLiteralLengthCodeCounts = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1}
+)
+
+const (
+	zstdOffsetCodeLog        = 5
+	zstdMatchLengthCodeLog   = 6
+	zstdLiteralLengthCodeLog = 6
+)
+
+// compressionDictionary is a dictionary trained from a partition's messages.
+type compressionDictionary struct {
+	data    []byte        // Dictionary in the zstd dictionary format
+	encoder *zstd.Encoder // Compresses batches with the dictionary
+}
+
+// newCompressionDictionary returns a dictionary with the given raw content. It
+// returns nil if the content's literals don't compress, in which case the
+// dictionary wouldn't help either.
+func newCompressionDictionary(content []byte) (*compressionDictionary, error) {
+	data, err := zstdDictionary(content)
+	if err == huff0.ErrIncompressible || err == huff0.ErrUseRLE {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	encoder, err := zstd.NewWriter(nil,
+		zstd.WithEncoderDict(data), zstd.WithEncoderLevel(dictionaryEncoderLevel))
+	if err != nil {
+		return nil, err
+	}
+	return &compressionDictionary{data: data, encoder: encoder}, nil
+}
+
+// compress compresses the data with the dictionary.
+func (d *compressionDictionary) compress(data []byte) []byte {
+	return d.encoder.EncodeAll(data, nil)
+}
+
+// zstdDictionary wraps raw dictionary content in the zstd dictionary format,
+// which zstd libraries, unlike for raw content, load with a dictionary ID and
+// entropy tables. The literals table is built from the content and the
+// sequence tables are zstd's predefined ones. The ID is derived from the
+// content, outside the ranges zstd reserves.
+func zstdDictionary(content []byte) ([]byte, error) {
+	var literals huff0.Scratch
+	if _, _, err := huff0.Compress1X(content, &literals); err != nil {
+		return nil, err
+	}
+	id := 32768 + crc32.ChecksumIEEE(content)%(1<<31-32768)
+	dict := make([]byte, 0, 8+len(literals.OutTable)+64+12+len(content))
+	dict = append(dict, zstdDictionaryMagic...)
+	dict = appendUint32(dict, id)
+	dict = append(dict, literals.OutTable...)
+	dict = appendNormalizedCounts(dict, zstdOffsetCodeCounts, zstdOffsetCodeLog)
+	dict = appendNormalizedCounts(dict, zstdMatchLengthCodeCounts, zstdMatchLengthCodeLog)
+	dict = appendNormalizedCounts(dict, zstdLiteralLengthCodeCounts, zstdLiteralLengthCodeLog)
+	// Initial repeat offsets, which must be within the content.
+	for _, offset := range []uint32{1, 4, 8} {
+		dict = appendUint32(dict, offset)
+	}
+	return append(dict, content...), nil
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+// appendNormalizedCounts appends the FSE table description of the given
+// normalized counts with the given accuracy log. The counts must not be zero,
+// so the zero run length encoding is not needed.
+func appendNormalizedCounts(buf []byte, counts []int16, tableLog uint) []byte {
+	var (
+		bits      uint64
+		nbits     uint
+		remaining = (1 << tableLog) + 1
+		threshold = 1 << tableLog
+		width     = tableLog + 1
+	)
+	flush := func() {
+		for ; nbits >= 8; nbits -= 8 {
+			buf = append(buf, byte(bits))
+			bits >>= 8
+		}
+	}
+	bits |= uint64(tableLog-5) << nbits
+	nbits += 4
+	for _, count := range counts {
+		if remaining <= 1 {
+			break
+		}
+		// Values below max are written with one bit less.
+		var (
+			max   = 2*threshold - 1 - remaining
+			value = int(count) + 1
+		)
+		if count < 0 {
+			remaining += int(count)
+		} else {
+			remaining -= int(count)
+		}
+		if value >= threshold {
+			value += max
+		}
+		bits |= uint64(value) << nbits
+		nbits += width
+		if value < max {
+			nbits--
+		}
+		for remaining < threshold {
+			width--
+			threshold >>= 1
+		}
+		flush()
+	}
+	if nbits > 0 {
+		buf = append(buf, byte(bits))
+	}
+	return buf
+}
+
+// trainDictionary builds a raw content dictionary of up to size bytes from the
+// samples using a simplified version of the COVER algorithm zstd uses. The
+// samples are split into epochs, one per segment of the dictionary, and the
+// segment of each epoch whose substrings occur in the most samples is chosen,
+// ignoring substrings covered by segments already chosen. The best segments
+// are placed last since nearer matches are encoded more cheaply. It returns
+// nil if the samples have no content in common.
+func trainDictionary(samples [][]byte, size int) []byte {
+	if size > maxDictionaryBytes {
+		size = maxDictionaryBytes
+	}
+	// Count the number of samples each substring occurs in.
+	var (
+		freqs = make(map[string]int)
+		seen  = make(map[string]struct{})
+	)
+	for _, sample := range samples {
+		for key := range seen {
+			delete(seen, key)
+		}
+		for i := 0; i+dictionaryKmerSize <= len(sample); i++ {
+			key := string(sample[i : i+dictionaryKmerSize])
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			freqs[key]++
+		}
+	}
+
+	data := bytes.Join(samples, nil)
+	segmentSize := dictionarySegmentSize
+	if size < segmentSize {
+		segmentSize = size
+	}
+	if len(data) < segmentSize || segmentSize < dictionaryKmerSize {
+		return nil
+	}
+	epochs := size / segmentSize
+	if max := len(data) / segmentSize; epochs > max {
+		epochs = max
+	}
+	type segment struct {
+		data  []byte
+		score int
+	}
+	var (
+		epochSize = len(data) / epochs
+		segments  = make([]segment, 0, epochs)
+	)
+	for e := 0; e < epochs; e++ {
+		epoch := data[e*epochSize : (e+1)*epochSize]
+		start, score := bestDictionarySegment(epoch, segmentSize, freqs)
+		if score == 0 {
+			continue
+		}
+		seg := epoch[start : start+segmentSize]
+		segments = append(segments, segment{data: seg, score: score})
+		// Later segments should cover other content.
+		for i := 0; i+dictionaryKmerSize <= len(seg); i++ {
+			delete(freqs, string(seg[i:i+dictionaryKmerSize]))
+		}
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score < segments[j].score
+	})
+	dict := make([]byte, 0, len(segments)*segmentSize)
+	for _, seg := range segments {
+		dict = append(dict, seg.data...)
+	}
+	return dict
+}
+
+// bestDictionarySegment returns the start and score of the segment of the
+// epoch whose distinct substrings occur in the most samples. Substrings which
+// occur in a single sample don't count.
+func bestDictionarySegment(epoch []byte, segmentSize int, freqs map[string]int) (int, int) {
+	var (
+		kmers     = segmentSize - dictionaryKmerSize + 1 // Substrings per segment
+		window    = make(map[string]int)
+		score     = 0
+		bestStart = 0
+		bestScore = 0
+	)
+	weight := func(key string) int {
+		if freq := freqs[key]; freq > 1 {
+			return freq
+		}
+		return 0
+	}
+	for i := 0; i+dictionaryKmerSize <= len(epoch); i++ {
+		key := string(epoch[i : i+dictionaryKmerSize])
+		window[key]++
+		if window[key] == 1 {
+			score += weight(key)
+		}
+		if i >= kmers {
+			old := string(epoch[i-kmers : i-kmers+dictionaryKmerSize])
+			window[old]--
+			if window[old] == 0 {
+				delete(window, old)
+				score -= weight(old)
+			}
+		}
+		if i >= kmers-1 && score > bestScore {
+			bestStart, bestScore = i-kmers+1, score
+		}
+	}
+	return bestStart, bestScore
+}
+
+// compressionDictionary returns the dictionary trained from a sample of the
+// partition's most recent committed messages, training it the first time
+// it's called once the partition has enough messages. It returns nil if
+// dictionaries are disabled, the partition is encrypted, or the partition
+// does not have enough messages yet.
+func (p *partition) compressionDictionary() (*compressionDictionary, error) {
+	var (
+		size    = p.srv.config.Streams.CompressionDictionaryBytes
+		samples = p.srv.config.Streams.CompressionDictionarySamples
+	)
+	// Encrypted values have nothing in common to train on.
+	if size <= 0 || samples <= 0 || p.encryptionHandler != nil {
+		return nil, nil
+	}
+	p.dictionaryMu.Lock()
+	defer p.dictionaryMu.Unlock()
+	if p.dictionary != nil {
+		return p.dictionary, nil
+	}
+	var (
+		hw     = p.log.HighWatermark()
+		oldest = p.log.OldestOffset()
+		start  = hw - int64(samples) + 1
+	)
+	if hw < 0 || start < oldest {
+		return nil, nil
+	}
+	reader, err := p.log.NewReader(start, false)
+	if err != nil {
+		return nil, err
+	}
+	// Read control records so that the HW is reached even if it's one, but
+	// don't sample them.
+	reader.SetReadControl(true)
+	ctx, cancel := context.WithTimeout(context.Background(), dictionarySampleTimeout)
+	defer cancel()
+	var (
+		values     = make([][]byte, 0, samples)
+		headersBuf = make([]byte, 28)
+	)
+	for offset := start - 1; offset < hw; {
+		var msg commitlog.SerializedMessage
+		msg, offset, _, _, err = reader.ReadMessage(ctx, headersBuf)
+		if err != nil {
+			return nil, err
+		}
+		if !msg.IsControl() {
+			values = append(values, msg.Value())
+		}
+	}
+	content := trainDictionary(values, size)
+	if content == nil {
+		return nil, nil
+	}
+	dict, err := newCompressionDictionary(content)
+	if err != nil || dict == nil {
+		return nil, err
+	}
+	p.srv.logger.Debugf("Trained %d byte compression dictionary for partition %s from %d messages",
+		len(dict.data), p, len(values))
+	p.dictionary = dict
+	return dict, nil
+}
+
+// setEncoderDictionary sets the dictionary the encoder compresses batches with
+// if the incoming request metadata asks for one, the encoder compresses with
+// zstd, and the partition has one. The dictionary is sent in the response
+// header.
+func setEncoderDictionary(ctx context.Context, encoder *subscriptionEncoder, p *partition) *status.Status {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.Get(AcceptDictionaryMetadata)
+	if len(values) == 0 {
+		return nil
+	}
+	accept, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", AcceptDictionaryMetadata, values[0]))
+	}
+	if !accept || encoder == nil || encoder.encoding != encodingZstd {
+		return nil
+	}
+	dict, err := p.compressionDictionary()
+	if err != nil {
+		return status.New(codes.Internal,
+			fmt.Sprintf("Failed to train compression dictionary: %v", err))
+	}
+	if dict == nil {
+		return nil
+	}
+	encoder.dictionary = dict
+	grpc.SetHeader(ctx, metadata.Pairs(DictionaryMetadata, string(dict.data))) // nolint: errcheck
+	return nil
+}
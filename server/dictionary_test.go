@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+func dictionaryTestValue(i int) []byte {
+	return []byte(fmt.Sprintf(
+		`{"event":"order.created","id":%d,"customer":{"id":%d,"region":"us-east"},"total":%d.99,"currency":"USD"}`,
+		i, i*7%1000, i%250))
+}
+
+func decompressWithDictionary(t *testing.T, data, dict []byte) []byte {
+	r, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dict))
+	require.NoError(t, err)
+	defer r.Close()
+	decoded, err := r.DecodeAll(data, nil)
+	require.NoError(t, err)
+	return decoded
+}
+
+// Ensure a trained dictionary compresses small similar messages better than
+// compressing them on their own.
+func TestTrainDictionary(t *testing.T) {
+	require.Nil(t, trainDictionary(nil, 1024))
+	require.Nil(t, trainDictionary([][]byte{[]byte("a"), []byte("b")}, 1024))
+
+	samples := make([][]byte, 500)
+	for i := range samples {
+		samples[i] = dictionaryTestValue(i)
+	}
+	dict := trainDictionary(samples, 4096)
+	require.NotEmpty(t, dict)
+	require.True(t, len(dict) <= 4096)
+	require.Contains(t, string(dict), `"event":"order.created"`)
+
+	compressionDict, err := newCompressionDictionary(dict)
+	require.NoError(t, err)
+	require.NotNil(t, compressionDict)
+
+	value := dictionaryTestValue(1000)
+	plain, err := compress(encodingZstd, value)
+	require.NoError(t, err)
+	compressed := compressionDict.compress(value)
+	require.Less(t, len(compressed), len(plain))
+	require.Equal(t, value, decompressWithDictionary(t, compressed, compressionDict.data))
+}
+
+// Ensure zstdDictionary wraps the content in the zstd dictionary format and
+// frames compressed with it carry its ID.
+func TestZstdDictionary(t *testing.T) {
+	content := []byte(`{"event":"order.created","customer":{"region":"us-east"},"currency":"USD"}`)
+	dict, err := zstdDictionary(content)
+	require.NoError(t, err)
+	require.Equal(t, zstdDictionaryMagic, dict[:4])
+	require.Equal(t, content, dict[len(dict)-len(content):])
+	id := binary.LittleEndian.Uint32(dict[4:8])
+	require.True(t, id >= 32768 && id < 1<<31)
+
+	encoder, err := zstd.NewWriter(nil,
+		zstd.WithEncoderDict(dict), zstd.WithEncoderLevel(dictionaryEncoderLevel))
+	require.NoError(t, err)
+	compressed := encoder.EncodeAll(content, nil)
+	var header zstd.Header
+	require.NoError(t, header.Decode(compressed))
+	require.Equal(t, id, header.DictionaryID)
+	require.Equal(t, content, decompressWithDictionary(t, compressed, dict))
+
+	// Content whose literals don't compress has no dictionary.
+	_, err = zstdDictionary([]byte("abcdefgh"))
+	require.Error(t, err)
+	compressionDict, err := newCompressionDictionary([]byte("abcdefgh"))
+	require.NoError(t, err)
+	require.Nil(t, compressionDict)
+}
+
+// Ensure dictionaries and the frames compressed with them work with the
+// reference zstd implementation: it decompresses frames compressed with a
+// dictionary, and frames it compresses with the dictionary decompress. This
+// uses the zstd command and is skipped if it's not installed.
+func TestZstdDictionaryReference(t *testing.T) {
+	zstdCmd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd command not installed")
+	}
+	dir, err := ioutil.TempDir("", "liftbridge_dictionary_test_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	run := func(args ...string) {
+		out, err := exec.Command(zstdCmd, append([]string{"-q", "-f"}, args...)...).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	samples := make([][]byte, 500)
+	for i := range samples {
+		samples[i] = dictionaryTestValue(i)
+	}
+	dict, err := newCompressionDictionary(trainDictionary(samples, 4096))
+	require.NoError(t, err)
+	require.NotNil(t, dict)
+	dictFile := filepath.Join(dir, "dict")
+	require.NoError(t, ioutil.WriteFile(dictFile, dict.data, 0644))
+
+	values := [][]byte{
+		dictionaryTestValue(1000),
+		bytes.Repeat(dictionaryTestValue(1001), 50),
+		[]byte("unrelated content with no matches in the dictionary"),
+	}
+	for i, value := range values {
+		var (
+			compressed   = filepath.Join(dir, fmt.Sprintf("%d.zst", i))
+			decompressed = filepath.Join(dir, fmt.Sprintf("%d.out", i))
+			input        = filepath.Join(dir, fmt.Sprintf("%d.in", i))
+		)
+		require.NoError(t, ioutil.WriteFile(compressed, dict.compress(value), 0644))
+		run("-d", "-D", dictFile, compressed, "-o", decompressed)
+		data, err := ioutil.ReadFile(decompressed)
+		require.NoError(t, err)
+		require.Equal(t, value, data)
+
+		require.NoError(t, ioutil.WriteFile(input, value, 0644))
+		for _, level := range []string{"-1", "-3", "-19"} {
+			run(level, "-D", dictFile, input, "-o", compressed)
+			data, err = ioutil.ReadFile(compressed)
+			require.NoError(t, err)
+			require.Equal(t, value, decompressWithDictionary(t, data, dict.data), level)
+		}
+	}
+}
+
+// Ensure subscribers which accept a dictionary receive batches compressed
+// with the partition's dictionary once it has enough messages.
+func TestSubscribeCompressedDictionary(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.CompressionDictionaryBytes = 4096
+	s1Config.Streams.CompressionDictionarySamples = 100
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	subscribe := func() (client.API_SubscribeClient, metadata.MD) {
+		sub, err := api.Subscribe(
+			metadata.AppendToOutgoingContext(ctx,
+				AcceptEncodingMetadata, encodingZstd,
+				AcceptDictionaryMetadata, "true"),
+			&client.SubscribeRequest{
+				Stream:        "foo",
+				StartPosition: client.StartPosition_EARLIEST,
+			},
+		)
+		require.NoError(t, err)
+		header, err := sub.Header()
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+		return sub, header
+	}
+
+	publish := func(start, num int) {
+		for i := start; i < start+num; i++ {
+			_, err = api.Publish(ctx, &client.PublishRequest{
+				Stream:    "foo",
+				Value:     dictionaryTestValue(i),
+				AckPolicy: client.AckPolicy_ALL,
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	// Not enough messages to train a dictionary yet.
+	publish(0, 50)
+	_, header := subscribe()
+	require.Equal(t, []string{encodingZstd}, header.Get(ContentEncodingMetadata))
+	require.Empty(t, header.Get(DictionaryMetadata))
+
+	publish(50, 50)
+	sub, header := subscribe()
+	dicts := header.Get(DictionaryMetadata)
+	require.Len(t, dicts, 1)
+	dict := []byte(dicts[0])
+	require.NotEmpty(t, dict)
+
+	received := 0
+	for received < 100 {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, []byte(encodingZstd), msg.Headers[ContentEncodingHeader])
+		batch, err := proto.UnmarshalBatch(decompressWithDictionary(t, msg.Value, dict))
+		require.NoError(t, err)
+		for _, m := range batch {
+			require.Equal(t, int64(received), m.Offset)
+			require.Equal(t, dictionaryTestValue(received), m.Value)
+			received++
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Resolvers used for DNS peer discovery, which are replaced in tests.
+var (
+	lookupHost = net.LookupHost
+	lookupAddr = net.LookupAddr
+)
+
+// discoverBootstrapPeers resolves clustering.raft.bootstrap.dns.name, e.g. a
+// Kubernetes headless service, to find the IDs of the servers to bootstrap the
+// metadata Raft group with. The name's addresses are resolved to hostnames,
+// and each peer's ID is the first label of its hostname with the same prefix
+// as this server's ID, so that servers with IDs such as "cluster-liftbridge-0"
+// on host "liftbridge-0" find each other. This retries until the name resolves
+// to the expected number of servers, including this one, and returns the peer
+// IDs. If it resolves to more servers than expected, the cluster has already
+// been formed, so it returns no peers to indicate the server should join it
+// instead.
+func (s *Server) discoverBootstrapPeers() ([]string, error) {
+	var (
+		name   = s.config.Clustering.RaftBootstrapDNSName
+		expect = s.config.Clustering.RaftBootstrapDNSExpect
+		peers  []string
+		err    error
+	)
+	for i := 0; i < raftJoinAttempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		s.logger.Debugf("Attempting to discover metadata Raft peers from %s...", name)
+		peers, err = s.resolvePeerIDs(name)
+		if err != nil {
+			s.logger.Debugf("Failed to discover metadata Raft peers: %v", err)
+			continue
+		}
+		if len(peers) > expect {
+			s.logger.Debugf("Discovered %d servers, more than the %d expected, joining existing cluster",
+				len(peers), expect)
+			return nil, nil
+		}
+		if len(peers) == expect && containsString(peers, s.config.Clustering.ServerID) {
+			s.logger.Debugf("Discovered metadata Raft peers %v", peers)
+			return peers, nil
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to discover metadata Raft peers from %s", name)
+	}
+	return nil, errors.Errorf("failed to discover metadata Raft peers from %s: found %v, expected %d servers including %s",
+		name, peers, expect, s.config.Clustering.ServerID)
+}
+
+// resolvePeerIDs returns the sorted IDs of the servers the given DNS name
+// resolves to as described by discoverBootstrapPeers.
+func (s *Server) resolvePeerIDs(name string) ([]string, error) {
+	addrs, err := lookupHost(name)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for _, addr := range addrs {
+		names, err := lookupAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, errors.Errorf("no hostname for %s", addr)
+		}
+		host := strings.TrimSuffix(names[0], ".")
+		if i := strings.IndexByte(host, '.'); i != -1 {
+			host = host[:i]
+		}
+		hosts = append(hosts, host)
+	}
+
+	// Find the prefix of our ID from the longest hostname it ends with.
+	var (
+		serverID = s.config.Clustering.ServerID
+		self     = ""
+	)
+	for _, host := range hosts {
+		if strings.HasSuffix(serverID, host) && len(host) > len(self) {
+			self = host
+		}
+	}
+	if self == "" {
+		return nil, errors.Errorf("server ID %s does not end with any of the hostnames %v", serverID, hosts)
+	}
+	prefix := strings.TrimSuffix(serverID, self)
+
+	ids := make(map[string]struct{}, len(hosts))
+	for _, host := range hosts {
+		ids[prefix+host] = struct{}{}
+	}
+	peers := make([]string, 0, len(ids))
+	for id := range ids {
+		peers = append(peers, id)
+	}
+	sort.Strings(peers)
+	return peers, nil
+}
+
+// containsString indicates if the given slice contains the given string.
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	natsdTest "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver resolves a DNS name to the addresses of the hosts set on it,
+// for which the reverse lookup returns names in the name's domain.
+type fakeResolver struct {
+	mu    sync.Mutex
+	name  string
+	hosts []string
+}
+
+func (f *fakeResolver) setHosts(hosts ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hosts = hosts
+}
+
+func (f *fakeResolver) lookupHost(name string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if name != f.name {
+		return nil, errors.New("no such host")
+	}
+	addrs := make([]string, len(f.hosts))
+	for i := range f.hosts {
+		addrs[i] = fmt.Sprintf("10.0.0.%d", i+1)
+	}
+	return addrs, nil
+}
+
+func (f *fakeResolver) lookupAddr(addr string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var i int
+	if _, err := fmt.Sscanf(addr, "10.0.0.%d", &i); err != nil || i < 1 || i > len(f.hosts) {
+		return nil, errors.New("no such host")
+	}
+	return []string{f.hosts[i-1] + "." + f.name + "."}, nil
+}
+
+// useFakeResolver replaces the resolvers used for peer discovery with the
+// given fake resolver and returns a function restoring them.
+func useFakeResolver(f *fakeResolver) func() {
+	lookupHost = f.lookupHost
+	lookupAddr = f.lookupAddr
+	return func() {
+		lookupHost = defaultLookupHost
+		lookupAddr = defaultLookupAddr
+	}
+}
+
+var (
+	defaultLookupHost = lookupHost
+	defaultLookupAddr = lookupAddr
+)
+
+// Ensure peer IDs are derived from the discovered hostnames using the prefix
+// of the server's ID.
+func TestResolvePeerIDs(t *testing.T) {
+	resolver := &fakeResolver{name: "liftbridge.svc"}
+	defer useFakeResolver(resolver)()
+
+	resolver.setHosts("liftbridge-1", "liftbridge-0", "liftbridge-2")
+	s := New(getTestConfig("cluster-liftbridge-0", false, 0))
+	peers, err := s.resolvePeerIDs("liftbridge.svc")
+	require.NoError(t, err)
+	require.Equal(t, []string{"cluster-liftbridge-0", "cluster-liftbridge-1", "cluster-liftbridge-2"}, peers)
+
+	// The longest hostname the ID ends with is used.
+	resolver.setHosts("b", "ab")
+	s = New(getTestConfig("ab", false, 0))
+	peers, err = s.resolvePeerIDs("liftbridge.svc")
+	require.NoError(t, err)
+	require.Equal(t, []string{"ab", "b"}, peers)
+
+	// The ID must end with one of the hostnames.
+	s = New(getTestConfig("foo", false, 0))
+	_, err = s.resolvePeerIDs("liftbridge.svc")
+	require.Error(t, err)
+
+	_, err = s.resolvePeerIDs("unknown.svc")
+	require.Error(t, err)
+}
+
+// Ensure servers bootstrap the metadata Raft group with the peers they
+// discover over DNS and that servers discovered beyond the expected number
+// join the existing cluster.
+func TestDNSBootstrapDiscovery(t *testing.T) {
+	defer cleanupStorage(t)
+
+	resolver := &fakeResolver{name: "liftbridge.svc"}
+	resolver.setHosts("a", "b", "c")
+	defer useFakeResolver(resolver)()
+
+	// Use an external NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	// Start the servers concurrently as none of them is a seed.
+	var (
+		servers = make([]*Server, 3)
+		errs    = make([]error, 3)
+		wg      sync.WaitGroup
+	)
+	for i, id := range []string{"a", "b", "c"} {
+		config := getTestConfig(id, false, 5050+i)
+		config.EmbeddedNATS = false
+		config.Clustering.RaftBootstrapDNSName = "liftbridge.svc"
+		config.Clustering.RaftBootstrapDNSExpect = 3
+		servers[i] = New(config)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = servers[i].Start()
+		}(i)
+	}
+	wg.Wait()
+	for i, s := range servers {
+		require.NoError(t, errs[i])
+		defer s.Stop()
+	}
+	leader := getMetadataLeader(t, 10*time.Second, servers...)
+	ids, err := leader.metadata.getClusterServerIDs()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "b", "c"}, ids)
+
+	// A server added later discovers more servers than expected and joins.
+	resolver.setHosts("a", "b", "c", "d")
+	config := getTestConfig("d", false, 5053)
+	config.EmbeddedNATS = false
+	config.Clustering.RaftBootstrapDNSName = "liftbridge.svc"
+	config.Clustering.RaftBootstrapDNSExpect = 3
+	s4 := runServerWithConfig(t, config)
+	defer s4.Stop()
+	ids, err = leader.metadata.getClusterServerIDs()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"a", "b", "c", "d"}, ids)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// subscriptionBufferSize is the number of messages buffered for delivery on
+// each subscription. It lets the subscription dispatcher hand off messages
+// without waiting for subscribers to send them.
+const subscriptionBufferSize = 32
+
+// subscriptionDispatcher delivers new messages on a partition to the
+// subscriptions tailing it. Subscriptions read from their own log reader in
+// their own goroutine until they catch up with the HW. They then attach to the
+// partition's dispatcher, which reads each new message once for all of them
+// from a single reader, so idle subscriptions don't each hold a goroutine
+// waiting for data. A subscription whose buffer is full, i.e. which is not
+// keeping up, is detached and resumes reading on its own until it catches up
+// again. The dispatcher only runs while subscriptions are attached.
+type subscriptionDispatcher struct {
+	partition *partition
+	mu        sync.Mutex
+	subs      map[chan struct{}]*dispatchedSubscription // Keyed by cancel channel
+	next      int64                                     // Offset of the next message to dispatch
+	stop      context.CancelFunc                        // Stops the dispatch loop, nil if it's not running
+}
+
+// dispatchedSubscription is a subscription which can be attached to a
+// subscriptionDispatcher. Only subscriptions which deliver messages one at a
+// time, i.e. not by priority or in compressed batches, are attached.
+type dispatchedSubscription struct {
+	ch         chan<- *client.Message
+	cancel     chan struct{}
+	filter     *keyFilter
+	encoder    *subscriptionEncoder
+	stopOffset int64
+	// sendErr sends the status ending the subscription in a new goroutine
+	// and then calls done.
+	sendErr func(*status.Status)
+	// done is called once the subscription ends without an error.
+	done func()
+	// resume starts reading messages for the subscription in its own
+	// goroutine from the given offset.
+	resume func(offset int64)
+}
+
+func newSubscriptionDispatcher(p *partition) *subscriptionDispatcher {
+	return &subscriptionDispatcher{
+		partition: p,
+		subs:      make(map[chan struct{}]*dispatchedSubscription),
+	}
+}
+
+// attach hands the subscription, which has caught up to the given offset, to
+// the dispatcher. It returns false if the dispatcher is at a different offset,
+// in which case the subscription keeps reading on its own.
+func (d *subscriptionDispatcher) attach(sub *dispatchedSubscription, offset int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop == nil {
+		reader, err := d.partition.log.NewReader(offset, false)
+		if err != nil {
+			return false
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		d.stop = cancel
+		d.next = offset
+		d.partition.srv.startGoroutine(func() {
+			d.dispatch(ctx, reader)
+		})
+	} else if offset != d.next {
+		return false
+	}
+	d.subs[sub.cancel] = sub
+	return true
+}
+
+// cancel detaches the subscription with the given cancel channel, if it's
+// attached, once it's been canceled.
+func (d *subscriptionDispatcher) cancel(cancel chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sub, ok := d.subs[cancel]
+	if !ok {
+		return
+	}
+	delete(d.subs, cancel)
+	sub.done()
+	d.stopIfIdle()
+}
+
+// stopIfIdle stops the dispatch loop if no subscriptions are attached. Must be
+// called with the mutex held.
+func (d *subscriptionDispatcher) stopIfIdle() {
+	if len(d.subs) == 0 && d.stop != nil {
+		d.stop()
+		d.stop = nil
+	}
+}
+
+// dispatch is a long-running loop which reads committed messages from the
+// reader and delivers them to the attached subscriptions until the context is
+// canceled or reading fails, which ends the attached subscriptions.
+func (d *subscriptionDispatcher) dispatch(ctx context.Context, reader *commitlog.Reader) {
+	headersBuf := make([]byte, 28)
+	for {
+		msg, s := readSharedSubscriptionMessage(ctx, d.partition, reader, headersBuf)
+		d.mu.Lock()
+		// The loop was stopped, possibly while reading the message, so the
+		// subscriptions are not its to deliver to.
+		if ctx.Err() != nil {
+			d.mu.Unlock()
+			return
+		}
+		if s != nil {
+			for cancel, sub := range d.subs {
+				delete(d.subs, cancel)
+				sub.sendErr(s)
+			}
+			d.stopIfIdle()
+			d.mu.Unlock()
+			return
+		}
+		d.next = msg.Offset + 1
+		for cancel, sub := range d.subs {
+			if !d.deliver(sub, msg) {
+				delete(d.subs, cancel)
+			}
+		}
+		d.stopIfIdle()
+		d.mu.Unlock()
+	}
+}
+
+// deliver sends the message to the subscription without blocking. It returns
+// false if the subscription is no longer attached, i.e. it ended or it's
+// resuming on its own because its buffer is full. Must be called with the
+// mutex held.
+func (d *subscriptionDispatcher) deliver(sub *dispatchedSubscription, msg *client.Message) bool {
+	select {
+	case <-sub.cancel:
+		sub.done()
+		return false
+	default:
+	}
+	if sub.filter.matches(msg.Key) {
+		msgs, err := sub.encoder.encode([]*client.Message{msg})
+		if err != nil {
+			sub.sendErr(status.New(codes.Internal, err.Error()))
+			return false
+		}
+		for _, m := range msgs {
+			select {
+			case sub.ch <- m:
+			default:
+				sub.resume(msg.Offset)
+				return false
+			}
+		}
+	}
+	if msg.Offset == sub.stopOffset {
+		sub.sendErr(status.New(codes.ResourceExhausted, "Stop offset reached"))
+		return false
+	}
+	return true
+}
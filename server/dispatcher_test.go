@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+func waitForDispatched(t *testing.T, timeout time.Duration, d *subscriptionDispatcher, subs int) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		n := len(d.subs)
+		d.mu.Unlock()
+		if n == subs {
+			return
+		}
+		time.Sleep(15 * time.Millisecond)
+	}
+	stackFatalf(t, "Dispatcher did not reach %d subscriptions", subs)
+}
+
+// Ensure subscriptions tailing a partition are attached to its dispatcher and
+// receive every message in order, a subscription which stops receiving is
+// detached and catches up on its own, and canceled subscriptions are removed.
+func TestSubscriptionDispatcher(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	// A fixed flow control window keeps gRPC from growing it to fit all
+	// messages, so a subscription which doesn't receive fills its buffer.
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure(),
+		grpc.WithInitialWindowSize(64*1024), grpc.WithInitialConnWindowSize(1024*1024))
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	partition := s1.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+
+	publish := func(start, n int, value []byte) {
+		for i := start; i < start+n; i++ {
+			_, err := api.Publish(ctx, &client.PublishRequest{
+				Stream:    "foo",
+				Key:       []byte(strconv.Itoa(i)),
+				Value:     value,
+				AckPolicy: client.AckPolicy_ALL,
+			})
+			require.NoError(t, err)
+		}
+	}
+	publish(0, 5, []byte("x"))
+
+	var (
+		subCtxs = make([]context.CancelFunc, 3)
+		subs    = make([]client.API_SubscribeClient, 3)
+	)
+	for i := range subs {
+		subCtx, subCancel := context.WithCancel(ctx)
+		subCtxs[i] = subCancel
+		sub, err := api.Subscribe(subCtx, &client.SubscribeRequest{
+			Stream:        "foo",
+			StartPosition: client.StartPosition_EARLIEST,
+		})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+		subs[i] = sub
+	}
+	recv := func(sub client.API_SubscribeClient, start, n int) {
+		for i := start; i < start+n; i++ {
+			msg, err := sub.Recv()
+			require.NoError(t, err)
+			require.Equal(t, int64(i), msg.Offset)
+			require.Equal(t, []byte(strconv.Itoa(i)), msg.Key)
+		}
+	}
+
+	// Subscriptions catch up on their own and are then attached.
+	for _, sub := range subs {
+		recv(sub, 0, 5)
+	}
+	waitForDispatched(t, 5*time.Second, partition.dispatcher, 3)
+	publish(5, 5, []byte("x"))
+	for _, sub := range subs {
+		recv(sub, 5, 5)
+	}
+
+	// The last subscription doesn't receive, so its buffer fills and it's
+	// detached.
+	value := bytes.Repeat([]byte("x"), 4096)
+	publish(10, 100, value)
+	for _, sub := range subs[:2] {
+		recv(sub, 10, 100)
+	}
+	waitForDispatched(t, 5*time.Second, partition.dispatcher, 2)
+
+	// It catches up on its own and is attached again.
+	recv(subs[2], 10, 100)
+	waitForDispatched(t, 5*time.Second, partition.dispatcher, 3)
+
+	// Canceled subscriptions are removed, stopping the dispatcher.
+	for _, subCancel := range subCtxs {
+		subCancel()
+	}
+	waitForDispatched(t, 5*time.Second, partition.dispatcher, 0)
+	partition.dispatcher.mu.Lock()
+	require.Nil(t, partition.dispatcher.stop)
+	partition.dispatcher.mu.Unlock()
+	require.Eventually(t, func() bool {
+		partition.mu.RLock()
+		defer partition.mu.RUnlock()
+		return partition.subscriberCount == 0
+	}, 5*time.Second, 15*time.Millisecond)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+
+	"github.com/liftbridge-io/liftbridge/server/health"
+)
+
+// drainPath is the path of the drain endpoint on the admin HTTP server.
+const drainPath = "/drain"
+
+// ServerDrainingMetadata is the trailer metadata key set to "true" on the
+// Unavailable status returned to requests the server ends or rejects because
+// it is draining. It is also set as a header on the notification message sent
+// on subscriptions before they are ended. Clients should fetch updated
+// metadata and retry on another server.
+const ServerDrainingMetadata = "liftbridge-server-draining"
+
+// PartitionLeaderMetadata is set alongside ServerDrainingMetadata to the ID of
+// the server now leading the partition the request was for if that is known
+// and isn't the draining server.
+const PartitionLeaderMetadata = "liftbridge-partition-leader"
+
+// Drain prepares the Server to be stopped without clients noticing more than
+// a failover. It marks the API as not serving so that health checks fail,
+// hands off the server's partition and metadata leaderships, then notifies
+// subscriptions that the server is draining and ends them and PublishAsync
+// sessions with an Unavailable status so clients move to other servers. New
+// publishes are rejected the same way. It then stops the API server from
+// accepting connections and waits for in-flight requests to finish. The server
+// keeps replicating as a follower until it's stopped. Drain returns once
+// draining is complete or returns the context's error if it's done first, in
+// which case remaining requests are cut off when the server is stopped.
+func (s *Server) Drain(ctx context.Context) error {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	if s.drained {
+		return nil
+	}
+
+	s.logger.Info("Draining server...")
+	health.SetNotServing()
+	if err := s.transferPartitionLeadership(ctx); err != nil {
+		return err
+	}
+	s.transferMetadataLeadership()
+
+	// End subscriptions and PublishAsync sessions, which would otherwise keep
+	// the API server from stopping, and reject new publishes.
+	select {
+	case <-s.drainCh:
+	default:
+		close(s.drainCh)
+	}
+
+	s.mu.RLock()
+	grpcServer := s.grpcServer
+	s.mu.RUnlock()
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			s.logger.Warn("Timed out waiting for API requests to finish")
+			return ctx.Err()
+		}
+	}
+
+	s.drained = true
+	s.logger.Info("Finished draining server")
+	return nil
+}
+
+// isDraining indicates if the server has begun ending client requests because
+// it is draining.
+func (s *Server) isDraining() bool {
+	select {
+	case <-s.drainCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainingMetadata returns the trailer metadata for requests to the given
+// stream partition which are ended or rejected because the server is
+// draining. The stream is empty for requests not bound to a partition.
+func (s *Server) drainingMetadata(stream string, partitionID int32) metadata.MD {
+	md := metadata.Pairs(ServerDrainingMetadata, "true")
+	if stream == "" {
+		return md
+	}
+	partition := s.metadata.GetPartition(stream, partitionID)
+	if partition == nil {
+		return md
+	}
+	if leader, _ := partition.GetLeader(); leader != "" && leader != s.config.Clustering.ServerID {
+		md.Set(PartitionLeaderMetadata, leader)
+	}
+	return md
+}
+
+// drainingNotification returns the message sent on a subscription to the
+// given stream partition before it's ended because the server is draining.
+// Like the message signaling a subscription was created, it has no offset or
+// value, so clients which don't check its headers ignore it.
+func (s *Server) drainingNotification(stream string, partitionID int32) *client.Message {
+	headers := make(map[string][]byte)
+	for key, values := range s.drainingMetadata(stream, partitionID) {
+		headers[key] = []byte(values[0])
+	}
+	return &client.Message{
+		Stream:    stream,
+		Partition: partitionID,
+		Headers:   headers,
+	}
+}
+
+// errServerDraining returns the status returned to requests which are ended
+// or rejected because the server is draining.
+func errServerDraining() error {
+	return withErrorDetails(status.New(codes.Unavailable, "Server is draining"), errorDetails{
+		reason:    ErrorReasonServerDraining,
+		retryable: true,
+	}).Err()
+}
+
+// handleDrain drains the server, responding once draining is complete. The
+// timeout query parameter bounds how long draining may take and defaults to
+// the configured drain timeout. Since draining changes the server's state,
+// only POST requests are accepted.
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	timeout := s.config.DrainTimeout
+	if param := r.URL.Query().Get("timeout"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid timeout %q", param), http.StatusBadRequest)
+			return
+		}
+		timeout = parsed
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if err := s.Drain(ctx); err != nil {
+		http.Error(w, fmt.Sprintf("Drain incomplete: %v", err), http.StatusGatewayTimeout)
+		return
+	}
+	fmt.Fprintln(w, "Drained")
+}
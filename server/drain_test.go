@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	natsdTest "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure the drain endpoint hands off the server's partition leadership, ends
+// its subscriptions and stops its API server, and rejects bad requests.
+func TestDrainEndpoint(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Use an external NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	var servers []*Server
+	for i, id := range []string{"a", "b", "c"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		config.Clustering.ReplicaMaxLeaderTimeout = time.Minute
+		config.Clustering.ReplicaMaxIdleWait = 500 * time.Millisecond
+		config.AdminListen = fmt.Sprintf("localhost:%d", 9390+i)
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	getMetadataLeader(t, 10*time.Second, servers...)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.NewAPIClient(conn).CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	waitForISR(t, 10*time.Second, "foo", 0, 3, servers...)
+
+	leader := getPartitionLeader(t, 10*time.Second, "foo", 0, servers...)
+	var remaining []*Server
+	for _, s := range servers {
+		if s != leader {
+			remaining = append(remaining, s)
+		}
+	}
+
+	// Subscribe to the leader.
+	leaderConn, err := grpc.Dial(fmt.Sprintf("localhost:%d", leader.config.Port), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer leaderConn.Close()
+	subCtx := metadata.AppendToOutgoingContext(ctx, ClientAPIVersionMetadata, strconv.Itoa(APIVersion))
+	sub, err := client.NewAPIClient(leaderConn).Subscribe(subCtx, &client.SubscribeRequest{Stream: "foo"})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+
+	drainURL := "http://" + leader.config.AdminListen + drainPath
+	drain := func(method, query string) (int, string) {
+		req, err := http.NewRequest(method, drainURL+query, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, _ := drain(http.MethodPut, "")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+	code, _ = drain(http.MethodGet, "")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+	code, _ = drain(http.MethodPost, "?timeout=soon")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, body := drain(http.MethodPost, "?timeout=10s")
+	require.Equal(t, http.StatusOK, code, body)
+
+	// The subscription was notified the server is draining, pointing at the
+	// new partition leader, then ended.
+	newLeader := getPartitionLeader(t, 5*time.Second, "foo", 0, remaining...)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, "true", string(msg.Headers[ServerDrainingMetadata]))
+	require.Equal(t, newLeader.config.Clustering.ServerID, string(msg.Headers[PartitionLeaderMetadata]))
+	_, err = sub.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, []string{"true"}, sub.Trailer().Get(ServerDrainingMetadata))
+	require.False(t, leader.IsRunning())
+
+	// Draining again returns right away.
+	code, _ = drain(http.MethodPost, "")
+	require.Equal(t, http.StatusOK, code)
+}
+
+// Ensure publishes are rejected with a retryable status pointing at the
+// partition leader once the server begins draining.
+func TestPublishRejectedWhileDraining(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Use an external NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	config := getTestConfig("a", true, 5050)
+	config.EmbeddedNATS = false
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	// Begin draining without stopping the API server.
+	close(s.drainCh)
+
+	var trailer metadata.MD
+	_, err = api.Publish(ctx, &client.PublishRequest{Stream: "foo", Value: []byte("hello")},
+		grpc.Trailer(&trailer))
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, []string{"true"}, trailer.Get(ServerDrainingMetadata))
+	// The server still leads the partition, so there's no leader to point to.
+	require.Empty(t, trailer.Get(PartitionLeaderMetadata))
+
+	_, err = api.PublishToSubject(ctx, &client.PublishToSubjectRequest{Subject: "foo", Value: []byte("hello")})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,124 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// keyDerivationLabel prefixes the stream name when deriving a stream's key
+// from a master key.
+const keyDerivationLabel = "liftbridge segment key\x00"
+
+// DirKeyProvider provides the keys commit log segments are encrypted with
+// from a directory of master keys. Each file in the directory holds a
+// hex-encoded 128, 192, or 256 bit AES key and is named after the key's ID.
+// New segments are encrypted with the key whose ID sorts last, so keys are
+// rotated by adding a key with a greater ID, e.g. one prefixed by the date,
+// without restarting the server. A key must be kept until the segments
+// encrypted with it are deleted. Each stream is encrypted with its own key,
+// which is derived from the master key with HMAC-SHA256.
+type DirKeyProvider struct {
+	dir  string
+	mu   sync.Mutex
+	keys map[string][]byte // Master keys by ID
+}
+
+// NewDirKeyProvider returns a DirKeyProvider for the given directory, which
+// must contain at least one key.
+func NewDirKeyProvider(dir string) (*DirKeyProvider, error) {
+	p := &DirKeyProvider{dir: dir, keys: make(map[string][]byte)}
+	if _, _, err := p.currentMasterKey(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CurrentKey returns the ID of the newest master key and the key of the given
+// stream derived from it.
+func (p *DirKeyProvider) CurrentKey(stream string) (string, []byte, error) {
+	id, master, err := p.currentMasterKey()
+	if err != nil {
+		return "", nil, err
+	}
+	return id, deriveKey(master, stream), nil
+}
+
+// Key returns the key of the given stream derived from the master key with
+// the given ID.
+func (p *DirKeyProvider) Key(stream, id string) ([]byte, error) {
+	master, err := p.masterKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return deriveKey(master, stream), nil
+}
+
+// currentMasterKey returns the master key whose ID sorts last. The directory
+// is listed each time since keys may be added while the server is running.
+func (p *DirKeyProvider) currentMasterKey() (string, []byte, error) {
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to read key directory")
+	}
+	id := ""
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if name > id {
+			id = name
+		}
+	}
+	if id == "" {
+		return "", nil, errors.Errorf("no keys in key directory %s", p.dir)
+	}
+	key, err := p.masterKey(id)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, key, nil
+}
+
+// masterKey returns the master key with the given ID, reading it from the
+// directory unless it was read before.
+func (p *DirKeyProvider) masterKey(id string) ([]byte, error) {
+	if id == "" || strings.HasPrefix(id, ".") || strings.ContainsAny(id, `/\`) {
+		return nil, errors.Errorf("invalid key ID %q", id)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[id]; ok {
+		return key, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(p.dir, id))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read key %q", id)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "key %q is not hex-encoded", id)
+	}
+	switch AESKeyLength(len(key)) {
+	case AES128KeyLength, AES192KeyLength, AES256KeyLength:
+	default:
+		return nil, errors.Errorf("key %q has invalid length %d", id, len(key))
+	}
+	p.keys[id] = key
+	return key, nil
+}
+
+// deriveKey returns the key of the given stream derived from the master key,
+// which has the same length.
+func deriveKey(master []byte, stream string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(keyDerivationLabel + stream)) // nolint: errcheck
+	return mac.Sum(nil)[:len(master)]
+}
@@ -0,0 +1,78 @@
+package encryption
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T, dir, id string, length int) {
+	key := strings.Repeat(id[len(id)-1:], length*2)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, id), []byte(key+"\n"), 0600))
+}
+
+// Ensure the newest key is current, keys added later are picked up, and
+// older keys remain available by ID.
+func TestDirKeyProviderRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "liftbridge-keys-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = NewDirKeyProvider(dir)
+	require.Error(t, err)
+
+	writeKey(t, dir, "2021-01-01", 32)
+	p, err := NewDirKeyProvider(dir)
+	require.NoError(t, err)
+
+	id, key1, err := p.CurrentKey("foo")
+	require.NoError(t, err)
+	require.Equal(t, "2021-01-01", id)
+	require.Len(t, key1, 32)
+
+	writeKey(t, dir, "2021-06-01", 16)
+	id, key2, err := p.CurrentKey("foo")
+	require.NoError(t, err)
+	require.Equal(t, "2021-06-01", id)
+	require.Len(t, key2, 16)
+
+	key, err := p.Key("foo", "2021-01-01")
+	require.NoError(t, err)
+	require.Equal(t, key1, key)
+
+	_, err = p.Key("foo", "2022-01-01")
+	require.Error(t, err)
+	_, err = p.Key("foo", "../2021-01-01")
+	require.Error(t, err)
+}
+
+// Ensure each stream gets its own key.
+func TestDirKeyProviderPerStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "liftbridge-keys-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeKey(t, dir, "key1", 32)
+
+	p, err := NewDirKeyProvider(dir)
+	require.NoError(t, err)
+	foo, err := p.Key("foo", "key1")
+	require.NoError(t, err)
+	bar, err := p.Key("bar", "key1")
+	require.NoError(t, err)
+	require.NotEqual(t, foo, bar)
+}
+
+// Ensure keys which are not valid AES keys are rejected.
+func TestDirKeyProviderInvalidKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "liftbridge-keys-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeKey(t, dir, "key1", 20)
+
+	_, err = NewDirKeyProvider(dir)
+	require.Error(t, err)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrorDomain is the domain of the google.rpc.ErrorInfo detail attached to
+// every API error.
+const ErrorDomain = "liftbridge.io"
+
+// Reasons in the google.rpc.ErrorInfo detail of API errors. Unlike error
+// messages, they are stable, so clients can act on them. Errors without one of
+// these reasons have the name of their gRPC code as the reason, e.g.
+// "INVALID_ARGUMENT".
+const (
+	// ErrorReasonNotLeader indicates the server is not the leader of the
+	// partition the request is for. The request should be retried on the
+	// leader, which the ErrorInfo metadata names if it's known.
+	ErrorReasonNotLeader = "NOT_LEADER"
+
+	// ErrorReasonStreamNotFound indicates the stream does not exist.
+	ErrorReasonStreamNotFound = "STREAM_NOT_FOUND"
+
+	// ErrorReasonPartitionNotFound indicates the partition does not exist.
+	ErrorReasonPartitionNotFound = "PARTITION_NOT_FOUND"
+
+	// ErrorReasonLimitExceeded indicates a connection or subscription limit
+	// was reached. The ErrorInfo metadata names the limit.
+	ErrorReasonLimitExceeded = "LIMIT_EXCEEDED"
+
+	// ErrorReasonServerDraining indicates the server is draining and the
+	// request should be retried on another server.
+	ErrorReasonServerDraining = "SERVER_DRAINING"
+)
+
+// Keys of the google.rpc.ErrorInfo metadata of API errors.
+const (
+	// ErrorMetadataLeaderID is the ID of the partition leader a
+	// NOT_LEADER request should be retried on.
+	ErrorMetadataLeaderID = "leaderId"
+
+	// ErrorMetadataLeaderAddress is the host:port address of the partition
+	// leader for the listener the request arrived on.
+	ErrorMetadataLeaderAddress = "leaderAddress"
+
+	// ErrorMetadataLimit is the limit a LIMIT_EXCEEDED request was rejected
+	// for, one of the LimitExceededMetadata values.
+	ErrorMetadataLimit = "limit"
+)
+
+// Resource types in the google.rpc.ResourceInfo detail of API errors.
+const (
+	// ResourceTypeStream is a stream, named by the stream name.
+	ResourceTypeStream = "stream"
+
+	// ResourceTypePartition is a stream partition, named "<stream>/<id>".
+	ResourceTypePartition = "partition"
+)
+
+// codeReasons are the reasons of errors which have no more specific reason.
+var codeReasons = map[codes.Code]string{
+	codes.Canceled:           "CANCELED",
+	codes.Unknown:            "UNKNOWN",
+	codes.InvalidArgument:    "INVALID_ARGUMENT",
+	codes.DeadlineExceeded:   "DEADLINE_EXCEEDED",
+	codes.NotFound:           "NOT_FOUND",
+	codes.AlreadyExists:      "ALREADY_EXISTS",
+	codes.PermissionDenied:   "PERMISSION_DENIED",
+	codes.ResourceExhausted:  "RESOURCE_EXHAUSTED",
+	codes.FailedPrecondition: "FAILED_PRECONDITION",
+	codes.Aborted:            "ABORTED",
+	codes.OutOfRange:         "OUT_OF_RANGE",
+	codes.Unimplemented:      "UNIMPLEMENTED",
+	codes.Internal:           "INTERNAL",
+	codes.Unavailable:        "UNAVAILABLE",
+	codes.DataLoss:           "DATA_LOSS",
+	codes.Unauthenticated:    "UNAUTHENTICATED",
+}
+
+// retryableCodes are the codes of errors which can be retried as is, mapped to
+// how long to wait before retrying.
+var retryableCodes = map[codes.Code]time.Duration{
+	codes.DeadlineExceeded: 0,
+	codes.Aborted:          0,
+	codes.Unavailable:      time.Second,
+}
+
+// errorDetails describe an API error to clients.
+type errorDetails struct {
+	reason       string
+	metadata     map[string]string
+	retryable    bool
+	retryDelay   time.Duration
+	resourceType string
+	resourceName string
+}
+
+// withErrorDetails returns the status with google.rpc details attached: an
+// ErrorInfo with the reason and metadata, a RetryInfo if the request can be
+// retried, and a ResourceInfo if the error concerns a resource.
+func withErrorDetails(st *status.Status, details errorDetails) *status.Status {
+	msgs := []proto.Message{&errdetails.ErrorInfo{
+		Reason:   details.reason,
+		Domain:   ErrorDomain,
+		Metadata: details.metadata,
+	}}
+	if details.retryable {
+		msgs = append(msgs, &errdetails.RetryInfo{RetryDelay: durationpb.New(details.retryDelay)})
+	}
+	if details.resourceType != "" {
+		msgs = append(msgs, &errdetails.ResourceInfo{
+			ResourceType: details.resourceType,
+			ResourceName: details.resourceName,
+		})
+	}
+	withDetails, err := st.WithDetails(msgs...)
+	if err != nil {
+		// This only happens for OK statuses, which are not errors.
+		return st
+	}
+	return withDetails
+}
+
+// withDefaultErrorDetails returns the status with the details derived from its
+// code attached unless it already has details.
+func withDefaultErrorDetails(st *status.Status) *status.Status {
+	if st.Code() == codes.OK || len(st.Proto().Details) > 0 {
+		return st
+	}
+	reason, ok := codeReasons[st.Code()]
+	if !ok {
+		reason = codeReasons[codes.Unknown]
+	}
+	delay, retryable := retryableCodes[st.Code()]
+	return withErrorDetails(st, errorDetails{
+		reason:     reason,
+		retryable:  retryable,
+		retryDelay: delay,
+	})
+}
+
+// partitionResourceName returns the ResourceInfo name of a partition.
+func partitionResourceName(stream string, partition int32) string {
+	return fmt.Sprintf("%s/%d", stream, partition)
+}
+
+// streamNotFoundStatus returns the status of requests for a stream which
+// does not exist.
+func streamNotFoundStatus(code codes.Code, msg, stream string) *status.Status {
+	return withErrorDetails(status.New(code, msg), errorDetails{
+		reason:       ErrorReasonStreamNotFound,
+		resourceType: ResourceTypeStream,
+		resourceName: stream,
+	})
+}
+
+// partitionNotFoundStatus returns the status of requests for a partition which
+// does not exist.
+func partitionNotFoundStatus(code codes.Code, msg, stream string, partition int32) *status.Status {
+	return withErrorDetails(status.New(code, msg), errorDetails{
+		reason:       ErrorReasonPartitionNotFound,
+		resourceType: ResourceTypePartition,
+		resourceName: partitionResourceName(stream, partition),
+	})
+}
+
+// notFoundStatus returns the NotFound status of a metadata operation on the
+// given stream which failed with ErrStreamNotFound or ErrPartitionNotFound.
+func notFoundStatus(err error, stream string) *status.Status {
+	reason := ErrorReasonStreamNotFound
+	if err == ErrPartitionNotFound {
+		reason = ErrorReasonPartitionNotFound
+	}
+	return withErrorDetails(status.New(codes.NotFound, err.Error()), errorDetails{
+		reason:       reason,
+		resourceType: ResourceTypeStream,
+		resourceName: stream,
+	})
+}
+
+// notLeaderStatus returns the status of requests rejected because the server
+// is not the leader of the given partition. Its details name the partition's
+// leader and, if it can be determined, its address so that clients can retry
+// there without refetching metadata.
+func (m *metadataAPI) notLeaderStatus(ctx context.Context, p *partition, msg string) *status.Status {
+	md := make(map[string]string, 2)
+	if leader, _ := p.GetLeader(); leader != "" {
+		md[ErrorMetadataLeaderID] = leader
+		if address := m.brokerAddress(ctx, leader); address != "" {
+			md[ErrorMetadataLeaderAddress] = address
+		}
+	}
+	return withErrorDetails(status.New(codes.FailedPrecondition, msg), errorDetails{
+		reason:       ErrorReasonNotLeader,
+		metadata:     md,
+		retryable:    true,
+		resourceType: ResourceTypePartition,
+		resourceName: partitionResourceName(p.Stream, p.Id),
+	})
+}
+
+// brokerAddress returns the address the given server advertises for the
+// listener the request arrived on or an empty string if it's unknown.
+func (m *metadataAPI) brokerAddress(ctx context.Context, serverID string) string {
+	infos, st := m.getBrokerInfo(ctx)
+	if st != nil {
+		return ""
+	}
+	for _, broker := range brokersForListener(infos, listenerFromContext(ctx)) {
+		if broker.Id == serverID {
+			return net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+		}
+	}
+	return ""
+}
+
+// errorDetailsUnaryInterceptor attaches the default details to errors
+// returned by unary API requests which have none.
+func errorDetailsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	resp, err := handler(ctx, req)
+	if err != nil && isAPIMethod(info.FullMethod) {
+		err = withDefaultErrorDetails(status.Convert(err)).Err()
+	}
+	return resp, err
+}
+
+// errorDetailsStreamInterceptor attaches the default details to errors
+// returned by streaming API requests which have none.
+func errorDetailsStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	err := handler(srv, ss)
+	if err != nil && isAPIMethod(info.FullMethod) {
+		err = withDefaultErrorDetails(status.Convert(err)).Err()
+	}
+	return err
+}
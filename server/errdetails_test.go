@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDetailsOf returns the google.rpc details of the error.
+func errorDetailsOf(t *testing.T, err error) (*errdetails.ErrorInfo, *errdetails.RetryInfo,
+	*errdetails.ResourceInfo) {
+
+	var (
+		info     *errdetails.ErrorInfo
+		retry    *errdetails.RetryInfo
+		resource *errdetails.ResourceInfo
+	)
+	for _, detail := range status.Convert(err).Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			info = d
+		case *errdetails.RetryInfo:
+			retry = d
+		case *errdetails.ResourceInfo:
+			resource = d
+		default:
+			t.Fatalf("Unexpected error detail %T", detail)
+		}
+	}
+	require.NotNil(t, info)
+	require.Equal(t, ErrorDomain, info.Domain)
+	return info, retry, resource
+}
+
+// Ensure errors without details get the details derived from their code.
+func TestDefaultErrorDetails(t *testing.T) {
+	err := withDefaultErrorDetails(status.New(codes.InvalidArgument, "bad")).Err()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Equal(t, "bad", status.Convert(err).Message())
+	info, retry, resource := errorDetailsOf(t, err)
+	require.Equal(t, "INVALID_ARGUMENT", info.Reason)
+	require.Nil(t, retry)
+	require.Nil(t, resource)
+
+	err = withDefaultErrorDetails(status.New(codes.Unavailable, "unavailable")).Err()
+	info, retry, _ = errorDetailsOf(t, err)
+	require.Equal(t, "UNAVAILABLE", info.Reason)
+	require.NotNil(t, retry)
+	require.Equal(t, time.Second, retry.RetryDelay.AsDuration())
+
+	// Errors with details are left as is.
+	err = withDefaultErrorDetails(partitionNotFoundStatus(codes.NotFound, "missing", "foo", 1)).Err()
+	info, retry, resource = errorDetailsOf(t, err)
+	require.Equal(t, ErrorReasonPartitionNotFound, info.Reason)
+	require.Nil(t, retry)
+	require.Equal(t, ResourceTypePartition, resource.ResourceType)
+	require.Equal(t, "foo/1", resource.ResourceName)
+}
+
+// Ensure API errors carry details and requests to a server which is not the
+// partition leader name the leader and its address.
+func TestAPIErrorDetails(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	s2Config := getTestConfig("b", false, 5051)
+	s2 := runServerWithConfig(t, s2Config)
+	defer s2.Stop()
+	getMetadataLeader(t, 10*time.Second, s1, s2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	// Errors without a specific reason are named by their code.
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	info, _, _ := errorDetailsOf(t, err)
+	require.Equal(t, "INVALID_ARGUMENT", info.Reason)
+
+	_, err = api.FetchPartitionMetadata(ctx, &client.FetchPartitionMetadataRequest{Stream: "foo"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	info, _, resource := errorDetailsOf(t, err)
+	require.Equal(t, ErrorReasonPartitionNotFound, info.Reason)
+	require.Equal(t, "foo/0", resource.ResourceName)
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	waitForPartition(t, 10*time.Second, "foo", 0, s1, s2)
+	leader := getPartitionLeader(t, 10*time.Second, "foo", 0, s1, s2)
+	follower := s1
+	if leader == s1 {
+		follower = s2
+	}
+
+	followerConn, err := grpc.Dial(fmt.Sprintf("localhost:%d", follower.config.Port), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer followerConn.Close()
+	followerAPI := client.NewAPIClient(followerConn)
+
+	sub, err := followerAPI.Subscribe(ctx, &client.SubscribeRequest{Stream: "foo"})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	info, retry, resource := errorDetailsOf(t, err)
+	require.Equal(t, ErrorReasonNotLeader, info.Reason)
+	require.Equal(t, leader.config.Clustering.ServerID, info.Metadata[ErrorMetadataLeaderID])
+	require.True(t, strings.HasSuffix(info.Metadata[ErrorMetadataLeaderAddress],
+		":"+strconv.Itoa(leader.config.Port)))
+	require.NotNil(t, retry)
+	require.Equal(t, ResourceTypePartition, resource.ResourceType)
+	require.Equal(t, "foo/0", resource.ResourceName)
+}
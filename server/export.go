@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	"github.com/liftbridge-io/liftbridge/server/encryption"
+	"github.com/liftbridge-io/liftbridge/server/parquet"
+)
+
+// ExportOptions contains settings for exporting a partition to Parquet.
+type ExportOptions struct {
+	// StartOffset is the first offset to export. If negative, the export
+	// starts at the oldest offset in the log.
+	StartOffset int64
+
+	// EndOffset is the last offset to export (inclusive). If negative, the
+	// export ends at the log's high watermark.
+	EndOffset int64
+
+	// Destination is either a local file path or an http(s) URL. Files
+	// written to a URL are uploaded with a PUT request, which works with
+	// presigned object storage URLs, e.g. S3 or GCS. Local files must not
+	// already exist.
+	Destination string
+
+	// RowGroupSize is the number of messages per Parquet row group. If not
+	// positive, parquet.DefaultRowGroupSize is used.
+	RowGroupSize int
+
+	// Codec is used to decrypt message values if the partition has
+	// encryption at rest enabled.
+	Codec encryption.Codec
+}
+
+// ExportPartition writes the committed messages of the given partition on
+// this server within the configured offset range to a Parquet file. It
+// returns the number of messages exported.
+func (s *Server) ExportPartition(ctx context.Context, stream string, partitionID int32,
+	opts ExportOptions) (int64, error) {
+
+	partition, err := s.replicaPartition(stream, partitionID)
+	if err != nil {
+		return 0, err
+	}
+	partition.mu.RLock()
+	closed := partition.isClosed
+	partition.mu.RUnlock()
+	if closed {
+		return 0, errPartitionClosed
+	}
+	if opts.Codec == nil {
+		opts.Codec = partition.encryptionHandler
+	}
+	return ExportLog(ctx, partition.log, opts)
+}
+
+// exportResponse is the response to exporting a partition.
+type exportResponse struct {
+	Stream      string `json:"stream"`
+	Partition   int32  `json:"partition"`
+	Destination string `json:"destination"`
+	Messages    int64  `json:"messages"`
+}
+
+// handlePartitionExport exports a partition on this server with
+// ExportPartition. The destination and offset range are taken from the query
+// parameters. The destination is restricted by exportDestination.
+func (s *Server) handlePartitionExport(w http.ResponseWriter, r *http.Request, stream,
+	idParam string) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	id, err := strconv.ParseInt(idParam, 10, 32)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "Invalid partition "+strconv.Quote(idParam), "")
+		return
+	}
+	query := r.URL.Query()
+	opts := ExportOptions{
+		StartOffset: -1,
+		EndOffset:   -1,
+		Destination: query.Get("destination"),
+	}
+	if opts.Destination == "" {
+		writeAdminError(w, http.StatusBadRequest, "Missing destination", "")
+		return
+	}
+	opts.Destination, err = s.exportDestination(opts.Destination)
+	if err != nil {
+		writeAdminError(w, http.StatusForbidden, err.Error(), "")
+		return
+	}
+	for name, offset := range map[string]*int64{"startOffset": &opts.StartOffset, "endOffset": &opts.EndOffset} {
+		param := query.Get(name)
+		if param == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s %q", name, param), "")
+			return
+		}
+		*offset = parsed
+	}
+	if param := query.Get("rowGroupSize"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("Invalid rowGroupSize %q", param), "")
+			return
+		}
+		opts.RowGroupSize = parsed
+	}
+	n, err := s.ExportPartition(r.Context(), stream, int32(id), opts)
+	switch err {
+	case nil:
+	case ErrStreamNotFound, ErrPartitionNotFound:
+		writeAdminError(w, http.StatusNotFound, err.Error(), "")
+		return
+	case errNotReplica, errPartitionClosed:
+		writeAdminError(w, http.StatusConflict, err.Error(), "")
+		return
+	default:
+		if os.IsExist(errors.Cause(err)) {
+			writeAdminError(w, http.StatusConflict, err.Error(), "")
+			return
+		}
+		writeAdminError(w, http.StatusInternalServerError, err.Error(), "")
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, exportResponse{
+		Stream:      stream,
+		Partition:   int32(id),
+		Destination: opts.Destination,
+		Messages:    n,
+	})
+}
+
+// exportDestination returns the destination to export to for one requested
+// through the admin API, or an error if it's not allowed. URLs must be under
+// one of the allowed export URLs. File paths are resolved relative to the
+// export directory and must be within it.
+func (s *Server) exportDestination(destination string) (string, error) {
+	if isURL(destination) {
+		for _, allowed := range s.config.ExportAllowedURLs {
+			if isURLUnder(destination, allowed) {
+				return destination, nil
+			}
+		}
+		return "", fmt.Errorf("Destination %q is not under an allowed export URL", destination)
+	}
+	if s.config.ExportDir == "" {
+		return "", errors.New("Exporting to files requires an export directory")
+	}
+	dir, err := filepath.Abs(s.config.ExportDir)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve export directory")
+	}
+	file := destination
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	file = filepath.Clean(file)
+	rel, err := filepath.Rel(dir, file)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Destination %q is not within the export directory", destination)
+	}
+	return file, nil
+}
+
+// isURLUnder indicates if the given URL has the same scheme and host as the
+// allowed URL and a path within its path. URLs whose paths aren't clean are
+// never under another, so that they can't refer to parent paths.
+func isURLUnder(destination, allowed string) bool {
+	d, err := url.Parse(destination)
+	if err != nil {
+		return false
+	}
+	a, err := url.Parse(allowed)
+	if err != nil {
+		return false
+	}
+	if d.User != nil || d.Scheme != a.Scheme || !strings.EqualFold(d.Host, a.Host) {
+		return false
+	}
+	if d.Path == "" || path.Clean(d.Path) != d.Path {
+		return false
+	}
+	prefix := strings.TrimSuffix(a.Path, "/")
+	return d.Path == prefix || strings.HasPrefix(d.Path, prefix+"/")
+}
+
+// ExportLog writes the messages in the given log within the configured offset
+// range to a Parquet file. It returns the number of messages exported.
+func ExportLog(ctx context.Context, log commitlog.CommitLog, opts ExportOptions) (int64, error) {
+	start, end := exportRange(log, opts)
+	return export(ctx, opts, func(w io.Writer) (int64, error) {
+		return writeParquet(ctx, log, w, start, end, opts)
+	})
+}
+
+// ExportDir writes the messages in the partition log directory at the given
+// path within the configured offset range to a Parquet file. It returns the
+// number of messages exported. The log's files are only opened for reading,
+// so this can be used to export a partition offline. The export ends at the
+// high watermark last checkpointed to the directory, which is the latest one
+// if the server was shut down cleanly. keys decrypt encrypted segments of the
+// log, whose keys are scoped by the given name, and may be nil if its segments
+// are not encrypted.
+func ExportDir(ctx context.Context, path string, keys commitlog.KeyProvider, name string,
+	opts ExportOptions) (int64, error) {
+
+	hw, err := commitlog.DumpHighWatermark(path)
+	if err != nil {
+		return 0, err
+	}
+	end := hw
+	if opts.EndOffset >= 0 && opts.EndOffset < end {
+		end = opts.EndOffset
+	}
+	return export(ctx, opts, func(w io.Writer) (int64, error) {
+		return writeDirParquet(ctx, path, keys, name, w, opts.StartOffset, end, opts)
+	})
+}
+
+// export writes a Parquet file with the given function to the configured
+// destination.
+func export(ctx context.Context, opts ExportOptions, write func(io.Writer) (int64, error)) (int64, error) {
+	if opts.Destination == "" {
+		return 0, errors.New("no export destination provided")
+	}
+
+	if isURL(opts.Destination) {
+		// Object storage requires the content length up front, so write to a
+		// temporary file first and then upload it.
+		file, err := ioutil.TempFile("", "liftbridge-export-*.parquet")
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to create temporary export file")
+		}
+		defer os.Remove(file.Name())
+		defer file.Close()
+		n, err := write(file)
+		if err != nil {
+			return 0, err
+		}
+		if err := upload(ctx, opts.Destination, file); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Destination), 0755); err != nil {
+		return 0, errors.Wrap(err, "failed to create export directory")
+	}
+	file, err := os.OpenFile(opts.Destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create export file")
+	}
+	n, err := write(file)
+	if err != nil {
+		file.Close()
+		os.Remove(opts.Destination)
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, errors.Wrap(err, "failed to close export file")
+	}
+	return n, nil
+}
+
+// exportRange returns the start and end offsets (inclusive) to export from the
+// log. If the range is empty, end will be less than start.
+func exportRange(log commitlog.CommitLog, opts ExportOptions) (int64, int64) {
+	start := opts.StartOffset
+	if oldest := log.OldestOffset(); start < oldest {
+		start = oldest
+	}
+	end := log.HighWatermark()
+	if opts.EndOffset >= 0 && opts.EndOffset < end {
+		end = opts.EndOffset
+	}
+	return start, end
+}
+
+func writeParquet(ctx context.Context, log commitlog.CommitLog, w io.Writer,
+	start, end int64, opts ExportOptions) (int64, error) {
+
+	writer := parquet.NewWriter(w, opts.RowGroupSize)
+	if start >= 0 && start <= end {
+		reader, err := log.NewReader(start, true)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to create log reader")
+		}
+		// Read control records so that the export ends at the end offset
+		// even if it's one, but don't export them.
+		reader.SetReadControl(true)
+		headersBuf := make([]byte, 28)
+		for {
+			m, offset, timestamp, _, err := reader.ReadMessage(ctx, headersBuf)
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to read message")
+			}
+			// Offsets may be skipped if the log has been compacted.
+			if offset > end {
+				break
+			}
+			if m.IsControl() {
+				if offset == end {
+					break
+				}
+				continue
+			}
+			rec := &parquet.Record{
+				Offset:    offset,
+				Timestamp: timestamp,
+				Key:       m.Key(),
+				Headers:   m.Headers(),
+				Value:     m.Value(),
+			}
+			if err := writeRecord(writer, rec, opts); err != nil {
+				return 0, err
+			}
+			if offset == end {
+				break
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return 0, errors.Wrap(err, "failed to write parquet footer")
+	}
+	return writer.NumRows(), nil
+}
+
+func writeDirParquet(ctx context.Context, path string, keys commitlog.KeyProvider, name string,
+	w io.Writer, start, end int64, opts ExportOptions) (int64, error) {
+
+	var (
+		writer = parquet.NewWriter(w, opts.RowGroupSize)
+		err    error
+	)
+	if end >= 0 && start <= end {
+		dumpErr := commitlog.DumpDir(path, keys, name, func(msg *commitlog.DumpedMessage) bool {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+			if !msg.CrcValid {
+				err = errors.Errorf("corrupt message at offset %d", msg.Offset)
+				return false
+			}
+			// Offsets may be skipped if the log has been compacted.
+			if msg.Offset > end {
+				return false
+			}
+			if msg.Offset < start || msg.Attributes&commitlog.AttrControl != 0 {
+				return msg.Offset < end
+			}
+			err = writeRecord(writer, &parquet.Record{
+				Offset:    msg.Offset,
+				Timestamp: msg.Timestamp,
+				Key:       msg.Key,
+				Headers:   msg.Headers,
+				Value:     msg.Value,
+			}, opts)
+			return err == nil && msg.Offset < end
+		})
+		if err == nil && dumpErr != nil {
+			err = errors.Wrap(dumpErr, "failed to read log")
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return 0, errors.Wrap(err, "failed to write parquet footer")
+	}
+	return writer.NumRows(), nil
+}
+
+// writeRecord writes the given record, decrypting its value first if
+// configured.
+func writeRecord(writer *parquet.Writer, rec *parquet.Record, opts ExportOptions) error {
+	if opts.Codec != nil && rec.Value != nil {
+		value, err := opts.Codec.Read(rec.Value)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decrypt message at offset %d", rec.Offset)
+		}
+		rec.Value = value
+	}
+	if err := writer.Write(rec); err != nil {
+		return errors.Wrap(err, "failed to write parquet record")
+	}
+	return nil
+}
+
+func isURL(destination string) bool {
+	u, err := url.Parse(destination)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// uploadClient is used to upload exports. It doesn't follow redirects, so an
+// upload can't be sent to a URL other than its destination.
+var uploadClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// upload PUTs the contents of the given file to the destination URL.
+func upload(ctx context.Context, destination string, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat export file")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to seek export file")
+	}
+	req, err := http.NewRequest(http.MethodPut, destination, file)
+	if err != nil {
+		return errors.Wrap(err, "failed to create upload request")
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/vnd.apache.parquet")
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to upload export")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to upload export: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+func newExportTestLog(t *testing.T, numMsgs int) (commitlog.CommitLog, func()) {
+	dir, err := ioutil.TempDir("", "liftbridge_export_test_")
+	require.NoError(t, err)
+	log, err := commitlog.New(commitlog.Options{Path: filepath.Join(dir, "log")})
+	require.NoError(t, err)
+	appendExportTestMessages(t, log, numMsgs)
+	return log, func() {
+		log.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func appendExportTestMessages(t *testing.T, log commitlog.CommitLog, numMsgs int) {
+	msgs := make([]*commitlog.Message, numMsgs)
+	for i := range msgs {
+		msgs[i] = &commitlog.Message{
+			Key:       []byte(strconv.Itoa(i)),
+			Value:     []byte("hello"),
+			Timestamp: int64(i),
+			Headers:   map[string][]byte{"subject": []byte("foo")},
+		}
+	}
+	if numMsgs > 0 {
+		_, err := log.Append(msgs)
+		require.NoError(t, err)
+	}
+	log.SetHighWatermark(int64(numMsgs - 1))
+}
+
+func requireParquetFile(t *testing.T, data []byte) {
+	require.True(t, len(data) > 12)
+	require.Equal(t, "PAR1", string(data[:4]))
+	require.Equal(t, "PAR1", string(data[len(data)-4:]))
+	footerLen := binary.LittleEndian.Uint32(data[len(data)-8:])
+	require.True(t, int(footerLen) <= len(data)-12)
+}
+
+// Ensures ExportLog writes the committed messages in the given offset range to
+// a local file.
+func TestExportLogToFile(t *testing.T) {
+	log, cleanup := newExportTestLog(t, 10)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "liftbridge_export_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "out", "export.parquet")
+
+	n, err := ExportLog(context.Background(), log, ExportOptions{
+		StartOffset: 2,
+		EndOffset:   6,
+		Destination: dest,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+
+	data, err := ioutil.ReadFile(dest)
+	require.NoError(t, err)
+	requireParquetFile(t, data)
+
+	// Existing files are not overwritten.
+	_, err = ExportLog(context.Background(), log, ExportOptions{
+		EndOffset:   -1,
+		Destination: dest,
+	})
+	require.True(t, os.IsExist(errors.Cause(err)))
+	after, err := ioutil.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, data, after)
+
+	// Exporting past the HW stops at the HW.
+	log.OverrideHighWatermark(3)
+	n, err = ExportLog(context.Background(), log, ExportOptions{
+		StartOffset: -1,
+		EndOffset:   -1,
+		Destination: filepath.Join(dir, "out", "export2.parquet"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), n)
+}
+
+// Ensures ExportLog writes an empty file if there are no committed messages.
+func TestExportLogEmpty(t *testing.T) {
+	log, cleanup := newExportTestLog(t, 0)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "liftbridge_export_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "export.parquet")
+
+	n, err := ExportLog(context.Background(), log, ExportOptions{
+		EndOffset:   -1,
+		Destination: dest,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), n)
+
+	data, err := ioutil.ReadFile(dest)
+	require.NoError(t, err)
+	requireParquetFile(t, data)
+}
+
+// Ensures ExportLog uploads the file with a PUT request when the destination
+// is a URL.
+func TestExportLogToURL(t *testing.T) {
+	log, cleanup := newExportTestLog(t, 3)
+	defer cleanup()
+
+	var (
+		method string
+		body   []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		body, _ = ioutil.ReadAll(r.Body)
+		require.Equal(t, int64(len(body)), r.ContentLength)
+	}))
+	defer srv.Close()
+
+	n, err := ExportLog(context.Background(), log, ExportOptions{
+		EndOffset:   -1,
+		Destination: srv.URL + "/bucket/export.parquet",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+	require.Equal(t, http.MethodPut, method)
+	requireParquetFile(t, body)
+	require.True(t, bytes.Contains(body, []byte("hello")))
+}
+
+// Ensures ExportLog returns an error if the upload fails.
+func TestExportLogToURLError(t *testing.T) {
+	log, cleanup := newExportTestLog(t, 1)
+	defer cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	_, err := ExportLog(context.Background(), log, ExportOptions{
+		EndOffset:   -1,
+		Destination: srv.URL,
+	})
+	require.Error(t, err)
+}
+
+// Ensures ExportDir exports the committed messages of a closed log from its
+// directory without modifying its files.
+func TestExportDir(t *testing.T) {
+	path, err := ioutil.TempDir("", "liftbridge_export_test_")
+	require.NoError(t, err)
+	defer os.RemoveAll(path)
+	log, err := commitlog.New(commitlog.Options{Path: path})
+	require.NoError(t, err)
+	appendExportTestMessages(t, log, 10)
+	log.OverrideHighWatermark(7)
+	require.NoError(t, log.Close())
+
+	readFiles := func() map[string][]byte {
+		files := make(map[string][]byte)
+		infos, err := ioutil.ReadDir(path)
+		require.NoError(t, err)
+		for _, info := range infos {
+			data, err := ioutil.ReadFile(filepath.Join(path, info.Name()))
+			require.NoError(t, err)
+			files[info.Name()] = data
+		}
+		return files
+	}
+	before := readFiles()
+
+	dir, err := ioutil.TempDir("", "liftbridge_export_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dest := filepath.Join(dir, "export.parquet")
+
+	n, err := ExportDir(context.Background(), path, nil, "", ExportOptions{
+		StartOffset: 2,
+		EndOffset:   -1,
+		Destination: dest,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(6), n)
+	data, err := ioutil.ReadFile(dest)
+	require.NoError(t, err)
+	requireParquetFile(t, data)
+
+	n, err = ExportDir(context.Background(), path, nil, "", ExportOptions{
+		StartOffset: -1,
+		EndOffset:   4,
+		Destination: filepath.Join(dir, "export2.parquet"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+
+	require.Equal(t, before, readFiles())
+}
+
+// Ensure the admin API exports a partition on the server to the given
+// destination.
+func TestAdminPartitionExportAPI(t *testing.T) {
+	defer cleanupStorage(t)
+
+	dir, err := ioutil.TempDir("", "liftbridge_export_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var uploaded bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploaded = true
+	}))
+	defer srv.Close()
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	config.ExportDir = dir
+	config.ExportAllowedURLs = []string{srv.URL + "/exports/"}
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	exportPath := func(dest string) string {
+		return streamsPath + "/foo/partitions/0/export?destination=" + url.QueryEscape(dest)
+	}
+	dest := filepath.Join(dir, "export.parquet")
+	path := exportPath("export.parquet")
+
+	var resp exportResponse
+	require.Equal(t, http.StatusOK,
+		adminRequest(t, s, http.MethodPost, path+"&startOffset=1&endOffset=3", &resp))
+	require.Equal(t, exportResponse{
+		Stream:      "foo",
+		Partition:   0,
+		Destination: dest,
+		Messages:    3,
+	}, resp)
+	data, err := ioutil.ReadFile(dest)
+	require.NoError(t, err)
+	requireParquetFile(t, data)
+
+	// Existing files are not overwritten.
+	require.Equal(t, http.StatusConflict, adminRequest(t, s, http.MethodPost, path, nil))
+
+	// Absolute paths within the export directory are allowed.
+	require.Equal(t, http.StatusOK,
+		adminRequest(t, s, http.MethodPost, exportPath(filepath.Join(dir, "all.parquet")), &resp))
+	require.Equal(t, int64(5), resp.Messages)
+
+	// Paths outside the export directory are forbidden.
+	for _, dest := range []string{"../export.parquet", "a/../../export.parquet", "/tmp/export.parquet", dir, "."} {
+		require.Equal(t, http.StatusForbidden, adminRequest(t, s, http.MethodPost, exportPath(dest), nil), dest)
+	}
+
+	// Only allowed URLs can be uploaded to.
+	require.Equal(t, http.StatusOK,
+		adminRequest(t, s, http.MethodPost, exportPath(srv.URL+"/exports/export.parquet"), &resp))
+	require.True(t, uploaded)
+	for _, dest := range []string{
+		srv.URL + "/other/export.parquet",
+		srv.URL + "/exports/../other/export.parquet",
+		srv.URL + "/exportsx/export.parquet",
+		"http://169.254.169.254/latest/meta-data",
+	} {
+		require.Equal(t, http.StatusForbidden, adminRequest(t, s, http.MethodPost, exportPath(dest), nil), dest)
+	}
+
+	require.Equal(t, http.StatusMethodNotAllowed, adminRequest(t, s, http.MethodGet, path, nil))
+	require.Equal(t, http.StatusBadRequest,
+		adminRequest(t, s, http.MethodPost, streamsPath+"/foo/partitions/0/export", nil))
+	require.Equal(t, http.StatusBadRequest,
+		adminRequest(t, s, http.MethodPost, path+"&startOffset=x", nil))
+	require.Equal(t, http.StatusNotFound,
+		adminRequest(t, s, http.MethodPost, streamsPath+"/bar/partitions/0/export?destination=x", nil))
+	require.Equal(t, http.StatusNotFound,
+		adminRequest(t, s, http.MethodPost, streamsPath+"/foo/partitions/1/export?destination=x", nil))
+}
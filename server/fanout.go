@@ -0,0 +1,112 @@
+package server
+
+import (
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/encoding"
+	protoCodec "google.golang.org/grpc/encoding/proto"
+)
+
+// deliveryCache holds the messages most recently delivered to subscriptions
+// of a partition so that subscriptions tailing the partition share them
+// instead of each decoding its own copy. Each message is serialized once for
+// all of the subscriptions it is sent to. Messages are slotted by offset, so
+// the cache holds up to its size of the most recent offsets read. A nil
+// deliveryCache disables caching.
+type deliveryCache struct {
+	mu     sync.Mutex
+	frames []*deliveryFrame
+}
+
+// deliveryFrame is a message delivered to subscriptions, which is serialized
+// the first time it is sent. Cached messages are shared by subscriptions and
+// must not be modified.
+type deliveryFrame struct {
+	msg  *client.Message
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// newDeliveryCache returns a deliveryCache holding up to the given number of
+// messages or nil if size is not positive.
+func newDeliveryCache(size int) *deliveryCache {
+	if size <= 0 {
+		return nil
+	}
+	return &deliveryCache{frames: make([]*deliveryFrame, size)}
+}
+
+// share returns the cached message for the offset of the given message read
+// from the log, caching the given message if there is none.
+func (c *deliveryCache) share(msg *client.Message) *client.Message {
+	if c == nil {
+		return msg
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := c.slot(msg.Offset)
+	// The timestamp guards against a message which was truncated from the
+	// log and replaced by another at the same offset.
+	if f := c.frames[slot]; f != nil && f.msg.Offset == msg.Offset && f.msg.Timestamp == msg.Timestamp {
+		return f.msg
+	}
+	c.frames[slot] = &deliveryFrame{msg: msg}
+	return msg
+}
+
+// frame returns what to send on a subscription stream for the given message.
+// This is the cached frame if the message is shared, which the delivery codec
+// serializes only once, or the message itself otherwise.
+func (c *deliveryCache) frame(msg *client.Message) interface{} {
+	if c == nil {
+		return msg
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f := c.frames[c.slot(msg.Offset)]; f != nil && f.msg == msg {
+		return f
+	}
+	return msg
+}
+
+// reset removes all messages from the cache. This is called when the log is
+// truncated.
+func (c *deliveryCache) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.frames {
+		c.frames[i] = nil
+	}
+}
+
+func (c *deliveryCache) slot(offset int64) int {
+	return int(offset % int64(len(c.frames)))
+}
+
+// deliveryCodec is the gRPC server codec. It serializes messages with the
+// proto codec, except for delivery frames, which are serialized once and
+// reused.
+type deliveryCodec struct {
+	encoding.Codec
+}
+
+func newDeliveryCodec() encoding.Codec {
+	return deliveryCodec{encoding.GetCodec(protoCodec.Name)}
+}
+
+// Marshal returns the wire format of v.
+func (c deliveryCodec) Marshal(v interface{}) ([]byte, error) {
+	f, ok := v.(*deliveryFrame)
+	if !ok {
+		return c.Codec.Marshal(v)
+	}
+	f.once.Do(func() {
+		f.data, f.err = c.Codec.Marshal(f.msg)
+	})
+	return f.data, f.err
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	protoCodec "google.golang.org/grpc/encoding/proto"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure messages read at the same offset are shared and serialized once.
+func TestDeliveryCache(t *testing.T) {
+	cache := newDeliveryCache(2)
+	msg := &client.Message{Offset: 1, Timestamp: 1, Value: []byte("foo")}
+	require.Equal(t, msg, cache.share(msg))
+
+	// The same message read by another subscription is shared.
+	shared := cache.share(&client.Message{Offset: 1, Timestamp: 1, Value: []byte("foo")})
+	require.True(t, shared == msg)
+
+	// A message which replaced a truncated one is not.
+	replaced := &client.Message{Offset: 1, Timestamp: 2, Value: []byte("bar")}
+	require.True(t, cache.share(replaced) == replaced)
+
+	// Shared messages are sent as frames, which are serialized once.
+	frame, ok := cache.frame(replaced).(*deliveryFrame)
+	require.True(t, ok)
+	require.Equal(t, msg, cache.frame(msg))
+	codec := newDeliveryCodec()
+	expected, err := encoding.GetCodec(protoCodec.Name).Marshal(replaced)
+	require.NoError(t, err)
+	data, err := codec.Marshal(frame)
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+	data2, err := codec.Marshal(frame)
+	require.NoError(t, err)
+	require.True(t, &data[0] == &data2[0])
+
+	cache.reset()
+	require.Equal(t, replaced, cache.frame(replaced))
+
+	// A nil cache shares nothing.
+	var disabled *deliveryCache
+	require.True(t, disabled.share(msg) == msg)
+	require.Equal(t, msg, disabled.frame(msg))
+}
+
+// Ensure messages read for subscriptions are shared through the delivery
+// cache and the copies read by later subscriptions are still intact after
+// being released.
+func TestReadSharedSubscriptionMessage(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 0)
+	config.Streams.FanoutCacheSize = 4
+	server := New(config)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	p, err := server.newPartition(&proto.Partition{
+		Subject:  "foo",
+		Stream:   "foo",
+		Replicas: []string{"a"},
+		Leader:   "a",
+		Isr:      []string{"a"},
+	}, false, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err = p.log.Append([]*commitlog.Message{{
+			Key:     []byte("key"),
+			Value:   []byte{byte(i)},
+			Headers: map[string][]byte{},
+		}})
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	readers := make([]*commitlog.Reader, 3)
+	for i := range readers {
+		readers[i], err = p.log.NewReader(0, true)
+		require.NoError(t, err)
+	}
+	headersBuf := make([]byte, 28)
+	for i := 0; i < 3; i++ {
+		first, s := readSharedSubscriptionMessage(ctx, p, readers[0], headersBuf)
+		require.Nil(t, s)
+		for _, reader := range readers[1:] {
+			msg, s := readSharedSubscriptionMessage(ctx, p, reader, headersBuf)
+			require.Nil(t, s)
+			require.True(t, msg == first)
+		}
+		require.Equal(t, int64(i), first.Offset)
+		require.Equal(t, []byte("key"), first.Key)
+		require.Equal(t, []byte{byte(i)}, first.Value)
+	}
+}
+
+// Ensure subscriptions sharing messages through the delivery cache receive
+// all messages.
+func TestSubscribeFanoutCache(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.FanoutCacheSize = 4
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	num := 10
+	for i := 0; i < num; i++ {
+		_, err = api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Key:       []byte("key"),
+			Value:     []byte{byte(i)},
+			AckPolicy: client.AckPolicy_LEADER,
+		})
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+			Stream:        "foo",
+			StartPosition: client.StartPosition_EARLIEST,
+			StopPosition:  client.StopPosition_STOP_LATEST,
+		})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+		for j := 0; j < num; j++ {
+			msg, err := sub.Recv()
+			require.NoError(t, err)
+			require.Equal(t, int64(j), msg.Offset)
+			require.Equal(t, []byte("key"), msg.Key)
+			require.Equal(t, []byte{byte(j)}, msg.Value)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Request metadata keys used for credit-based flow control of subscriptions.
+// Neither the Subscribe nor the SetCursor request has fields for this, so
+// credit is requested and granted with gRPC metadata. Credit is granted with
+// the SetCursorActionGrantCredit action.
+const (
+	// FlowControlIDMetadata is the Subscribe request metadata key naming the
+	// subscription so that the subscriber can grant it credit. The server
+	// only sends the subscription messages it has credit for. The ID must be
+	// unique among the partition's flow-controlled subscriptions on the
+	// server, and at least one of CreditMessagesMetadata and
+	// CreditBytesMetadata must set the initial credit.
+	FlowControlIDMetadata = "liftbridge-flow-control-id"
+
+	// CreditMessagesMetadata is the request metadata key setting the number
+	// of messages the server may send to a flow-controlled subscription. On
+	// Subscribe, it sets the initial credit and limits the subscription by
+	// message count. On a SetCursor request granting credit, it adds to the
+	// subscription's credit.
+	CreditMessagesMetadata = "liftbridge-credit-messages"
+
+	// CreditBytesMetadata is the request metadata key setting the number of
+	// message bytes the server may send to a flow-controlled subscription. On
+	// Subscribe, it sets the initial credit and limits the subscription by
+	// size. On a SetCursor request granting credit, it adds to the
+	// subscription's credit.
+	CreditBytesMetadata = "liftbridge-credit-bytes"
+)
+
+// subscriptionCredit is the credit a subscriber has granted a subscription,
+// limiting the messages the server sends it to the number of messages and
+// bytes the subscriber is ready for. A message is sent once there is at least
+// one message and one byte of credit, so a message larger than the remaining
+// byte credit is sent and overdraws it rather than stalling the subscription.
+// Likewise, a compressed batch takes a message of credit for each message in
+// it and overdraws the message credit if it holds more messages than remain.
+// A nil subscriptionCredit is unlimited.
+type subscriptionCredit struct {
+	id            string
+	limitMessages bool
+	limitBytes    bool
+	mu            sync.Mutex
+	messages      int64
+	bytes         int64
+	granted       chan struct{} // closed when credit is granted
+}
+
+// creditFromContext parses the flow control ID and initial credit of a
+// subscription from the incoming request metadata. It returns nil if the
+// subscription is not flow controlled.
+func creditFromContext(ctx context.Context) (*subscriptionCredit, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	ids := md.Get(FlowControlIDMetadata)
+	if len(ids) == 0 || ids[0] == "" {
+		return nil, nil
+	}
+	messages, limitMessages, st := creditMetadata(md, CreditMessagesMetadata)
+	if st != nil {
+		return nil, st
+	}
+	bytes, limitBytes, st := creditMetadata(md, CreditBytesMetadata)
+	if st != nil {
+		return nil, st
+	}
+	if !limitMessages && !limitBytes {
+		return nil, status.Newf(codes.InvalidArgument, "%s requires %s or %s",
+			FlowControlIDMetadata, CreditMessagesMetadata, CreditBytesMetadata)
+	}
+	return &subscriptionCredit{
+		id:            ids[0],
+		limitMessages: limitMessages,
+		limitBytes:    limitBytes,
+		messages:      messages,
+		bytes:         bytes,
+		granted:       make(chan struct{}),
+	}, nil
+}
+
+// creditMetadata parses the credit set with the given metadata key and
+// indicates if it's set.
+func creditMetadata(md metadata.MD, key string) (int64, bool, *status.Status) {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return 0, false, nil
+	}
+	credit, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil || credit < 0 {
+		return 0, false, status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", key, values[0]))
+	}
+	return credit, true, nil
+}
+
+// grantedCreditFromContext returns the number of messages and bytes granted
+// by the incoming SetCursor request metadata. At least one must be set.
+func grantedCreditFromContext(ctx context.Context) (int64, int64, *status.Status) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	messages, grantMessages, st := creditMetadata(md, CreditMessagesMetadata)
+	if st != nil {
+		return 0, 0, st
+	}
+	bytes, grantBytes, st := creditMetadata(md, CreditBytesMetadata)
+	if st != nil {
+		return 0, 0, st
+	}
+	if !grantMessages && !grantBytes {
+		return 0, 0, status.Newf(codes.InvalidArgument, "Granting credit requires %s or %s",
+			CreditMessagesMetadata, CreditBytesMetadata)
+	}
+	return messages, bytes, nil
+}
+
+// acquire takes the credit to send the given message, waiting until it's
+// granted. It returns false if cancel is closed first.
+func (c *subscriptionCredit) acquire(msg *client.Message, cancel <-chan struct{}) bool {
+	if c == nil {
+		return true
+	}
+	var count, size int64
+	if c.limitMessages {
+		count = batchCount(msg)
+	}
+	if c.limitBytes {
+		size = int64(msg.Size())
+	}
+	for {
+		c.mu.Lock()
+		if (!c.limitMessages || c.messages > 0) && (!c.limitBytes || c.bytes > 0) {
+			c.messages -= count
+			c.bytes -= size
+			c.mu.Unlock()
+			return true
+		}
+		granted := c.granted
+		c.mu.Unlock()
+		select {
+		case <-granted:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// batchCount returns the number of messages the given message holds, which is
+// more than one for a compressed batch.
+func batchCount(msg *client.Message) int64 {
+	count, err := strconv.ParseInt(string(msg.Headers[BatchCountHeader]), 10, 64)
+	if err != nil || count < 1 {
+		return 1
+	}
+	return count
+}
+
+// grant adds the given number of messages and bytes to the credit and wakes
+// the subscription if it's waiting for credit.
+func (c *subscriptionCredit) grant(messages, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = saturatingAdd(c.messages, messages)
+	c.bytes = saturatingAdd(c.bytes, bytes)
+	close(c.granted)
+	c.granted = make(chan struct{})
+}
+
+// saturatingAdd returns credit plus n, saturating rather than overflowing.
+func saturatingAdd(credit, n int64) int64 {
+	if credit > math.MaxInt64-n {
+		return math.MaxInt64
+	}
+	return credit + n
+}
+
+// addCredit registers the credit of a flow-controlled subscription to the
+// partition so it can be granted more. It returns an AlreadyExists status if
+// a subscription with the same ID is registered.
+func (p *partition) addCredit(credit *subscriptionCredit) *status.Status {
+	p.creditsMu.Lock()
+	defer p.creditsMu.Unlock()
+	if _, ok := p.credits[credit.id]; ok {
+		return status.Newf(codes.AlreadyExists,
+			"Flow-controlled subscription %s already exists", credit.id)
+	}
+	if p.credits == nil {
+		p.credits = make(map[string]*subscriptionCredit)
+	}
+	p.credits[credit.id] = credit
+	return nil
+}
+
+// removeCredit unregisters the credit of a flow-controlled subscription which
+// has ended.
+func (p *partition) removeCredit(credit *subscriptionCredit) {
+	p.creditsMu.Lock()
+	defer p.creditsMu.Unlock()
+	if p.credits[credit.id] == credit {
+		delete(p.credits, credit.id)
+	}
+}
+
+// getCredit returns the credit of the flow-controlled subscription with the
+// given ID or nil if there is no such subscription.
+func (p *partition) getCredit(id string) *subscriptionCredit {
+	p.creditsMu.Lock()
+	defer p.creditsMu.Unlock()
+	return p.credits[id]
+}
+
+// grantCredit grants the number of messages and bytes set in the request
+// metadata to the flow-controlled subscription named by the SetCursor
+// request's cursor ID.
+func (a *apiServer) grantCredit(ctx context.Context, req *client.SetCursorRequest) *status.Status {
+	if req.Offset != 0 {
+		return status.New(codes.InvalidArgument, "Offset cannot be set when granting credit")
+	}
+	messages, bytes, st := grantedCreditFromContext(ctx)
+	if st != nil {
+		return st
+	}
+	partition := a.metadata.GetPartition(a.streamName(req.Stream), req.Partition)
+	if partition == nil {
+		return partitionNotFoundStatus(codes.NotFound, "No such partition", req.Stream, req.Partition)
+	}
+	credit := partition.getCredit(req.CursorId)
+	if credit == nil {
+		return status.Newf(codes.NotFound, "No such flow-controlled subscription: %s", req.CursorId)
+	}
+	credit.grant(messages, bytes)
+	return nil
+}
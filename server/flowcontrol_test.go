@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure creditFromContext parses the flow control ID and initial credit.
+func TestCreditFromContext(t *testing.T) {
+	credit, st := creditFromContext(context.Background())
+	require.Nil(t, st)
+	require.Nil(t, credit)
+
+	incoming := func(kv ...string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+	}
+
+	credit, st = creditFromContext(incoming(CreditMessagesMetadata, "10"))
+	require.Nil(t, st)
+	require.Nil(t, credit)
+
+	credit, st = creditFromContext(incoming(FlowControlIDMetadata, "sub", CreditMessagesMetadata, "10"))
+	require.Nil(t, st)
+	require.Equal(t, "sub", credit.id)
+	require.True(t, credit.limitMessages)
+	require.False(t, credit.limitBytes)
+	require.Equal(t, int64(10), credit.messages)
+
+	credit, st = creditFromContext(incoming(FlowControlIDMetadata, "sub", CreditBytesMetadata, "0"))
+	require.Nil(t, st)
+	require.False(t, credit.limitMessages)
+	require.True(t, credit.limitBytes)
+	require.Equal(t, int64(0), credit.bytes)
+
+	_, st = creditFromContext(incoming(FlowControlIDMetadata, "sub"))
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	_, st = creditFromContext(incoming(FlowControlIDMetadata, "sub", CreditMessagesMetadata, "-1"))
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	_, st = creditFromContext(incoming(FlowControlIDMetadata, "sub", CreditBytesMetadata, "lots"))
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
+
+// Ensure acquire waits for message and byte credit and a message larger than
+// the remaining byte credit overdraws it.
+func TestSubscriptionCreditAcquire(t *testing.T) {
+	var unlimited *subscriptionCredit
+	require.True(t, unlimited.acquire(&client.Message{}, nil))
+
+	var (
+		credit = &subscriptionCredit{
+			limitMessages: true,
+			limitBytes:    true,
+			messages:      2,
+			bytes:         1,
+			granted:       make(chan struct{}),
+		}
+		msg    = &client.Message{Value: []byte("hello")}
+		cancel = make(chan struct{})
+	)
+	require.True(t, credit.acquire(msg, cancel))
+	require.Equal(t, int64(1), credit.messages)
+	require.Equal(t, int64(1-msg.Size()), credit.bytes)
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- credit.acquire(msg, cancel)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected acquire to wait for byte credit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	credit.grant(0, int64(msg.Size()))
+	require.True(t, <-acquired)
+	require.Equal(t, int64(0), credit.messages)
+
+	go func() {
+		acquired <- credit.acquire(msg, cancel)
+	}()
+	close(cancel)
+	require.False(t, <-acquired)
+
+	credit.grant(math.MaxInt64, 0)
+	credit.grant(math.MaxInt64, 0)
+	require.Equal(t, int64(math.MaxInt64), credit.messages)
+
+	// A compressed batch takes credit for each message in it.
+	credit = &subscriptionCredit{
+		limitMessages: true,
+		messages:      2,
+		granted:       make(chan struct{}),
+	}
+	batch := &client.Message{Headers: map[string][]byte{BatchCountHeader: []byte("5")}}
+	require.True(t, credit.acquire(batch, nil))
+	require.Equal(t, int64(-3), credit.messages)
+	go func() {
+		acquired <- credit.acquire(msg, nil)
+	}()
+	credit.grant(3, 0)
+	select {
+	case <-acquired:
+		t.Fatal("Expected acquire to wait for the overdraft to be paid back")
+	case <-time.After(50 * time.Millisecond):
+	}
+	credit.grant(1, 0)
+	require.True(t, <-acquired)
+	require.Equal(t, int64(0), credit.messages)
+}
+
+// Ensure a flow-controlled subscription only receives the messages it has
+// been granted credit for.
+func TestSubscribeFlowControl(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	num := 5
+	for i := 0; i < num; i++ {
+		_, err = api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	subCtx := metadata.AppendToOutgoingContext(ctx,
+		FlowControlIDMetadata, "sub",
+		CreditMessagesMetadata, "2")
+	sub, err := api.Subscribe(subCtx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+
+	// The ID is already in use.
+	dup, err := api.Subscribe(subCtx, &client.SubscribeRequest{Stream: "foo"})
+	require.NoError(t, err)
+	_, err = dup.Recv()
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	msgs := make(chan *client.Message)
+	go func() {
+		for {
+			msg, err := sub.Recv()
+			if err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	recv := func(offset int64) {
+		select {
+		case msg := <-msgs:
+			require.Equal(t, offset, msg.Offset)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected message %d", offset)
+		}
+	}
+	noRecv := func() {
+		select {
+		case msg := <-msgs:
+			t.Fatalf("Unexpected message %d", msg.Offset)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	grant := func(id string, messages int) error {
+		_, err := api.SetCursor(
+			metadata.AppendToOutgoingContext(ctx,
+				SetCursorActionMetadata, SetCursorActionGrantCredit,
+				CreditMessagesMetadata, strconv.Itoa(messages)),
+			&client.SetCursorRequest{Stream: "foo", CursorId: id})
+		return err
+	}
+
+	recv(0)
+	recv(1)
+	noRecv()
+
+	require.Equal(t, codes.NotFound, status.Code(grant("other", 1)))
+
+	// Granting credit requires a credit key and doesn't take an offset.
+	_, err = api.SetCursor(
+		metadata.AppendToOutgoingContext(ctx, SetCursorActionMetadata, SetCursorActionGrantCredit),
+		&client.SetCursorRequest{Stream: "foo", CursorId: "sub"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = api.SetCursor(
+		metadata.AppendToOutgoingContext(ctx,
+			SetCursorActionMetadata, SetCursorActionGrantCredit,
+			CreditMessagesMetadata, "1"),
+		&client.SetCursorRequest{Stream: "foo", CursorId: "sub", Offset: 1})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	noRecv()
+
+	require.NoError(t, grant("sub", 1))
+	recv(2)
+	noRecv()
+
+	require.NoError(t, grant("sub", 10))
+	recv(3)
+	recv(4)
+}
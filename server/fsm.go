@@ -4,15 +4,39 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"sync/atomic"
 
 	"github.com/dustin/go-humanize/english"
 	"github.com/hashicorp/raft"
 	"github.com/pkg/errors"
 
+	"github.com/liftbridge-io/liftbridge/server/logger"
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
 
+// metadataVersion is the version of the Raft log entry and metadata snapshot
+// encoding written by this server. Entries and snapshots are accepted from
+// servers running any older version and up to one version newer so that a
+// cluster can be upgraded one server at a time. Servers which predate
+// versioning write version 0. Fields added by newer versions are ignored, and
+// operations introduced by a newer version are skipped since they only affect
+// state this server doesn't know about. The metadata leader doesn't propose
+// operations or fields until every server supports them, see opVersions.
+//
+// Version 2 added stream aliases, renames, and sampled, derived, and snapshot
+// streams along with the stream settings only servers running it know.
+const metadataVersion = 2
+
+// checkMetadataVersion returns an error if entries or snapshots written with
+// the given version can't be read by this server.
+func checkMetadataVersion(version uint32) error {
+	if version > metadataVersion+1 {
+		return fmt.Errorf("unsupported metadata version %d, supported versions are 0 to %d",
+			version, metadataVersion+1)
+	}
+	return nil
+}
+
 // recoverLatestCommittedFSMLog returns the last committed Raft FSM log entry.
 // It returns nil if there are no entries in the Raft log.
 func (s *Server) recoverLatestCommittedFSMLog(applyIndex uint64) (*raft.Log, error) {
@@ -53,6 +77,9 @@ func (s *Server) recoverLatestCommittedFSMLog(applyIndex uint64) (*raft.Log, err
 // committed to Raft as part of the recovery process. As such, this should be
 // an idempotent call.
 func (s *Server) Apply(l *raft.Log) interface{} {
+	// Record the log as applied once any recovered streams have been started.
+	defer atomic.StoreUint64(&s.fsmAppliedIndex, l.Index)
+
 	// If recoveryStarted is false, the server was just started. We are going
 	// to recover the last committed Raft FSM log entry, if any, to determine
 	// the recovery high watermark. Once we apply all entries up to that point,
@@ -101,6 +128,9 @@ func (s *Server) Apply(l *raft.Log) interface{} {
 	if err := log.Unmarshal(l.Data); err != nil {
 		panic(err)
 	}
+	if err := checkMetadataVersion(log.Version); err != nil {
+		panic(fmt.Sprintf("failed to apply Raft log entry %d: %v", l.Index, err))
+	}
 	value, err := s.apply(log, l.Index, recovered)
 	if err != nil {
 		if s.isShutdown() {
@@ -111,6 +141,12 @@ func (s *Server) Apply(l *raft.Log) interface{} {
 		panic(err)
 	}
 	s.activity.SignalCommit()
+	if streams := metadataChangeStreams(log); len(streams) > 0 {
+		s.metadataWatch.record(l.Index, streams)
+	}
+	if !recovered {
+		s.streamChanged(log, l.Index)
+	}
 
 	// Send the Raft log entry to listeners.
 	s.mu.RLock()
@@ -191,6 +227,31 @@ func (s *Server) apply(log *proto.RaftLog, index uint64, recovered bool) (interf
 		if err := s.applySetStreamReadonly(stream, partitions, readonly); err != nil {
 			return nil, err
 		}
+	case proto.Op_SET_STREAM_ALIAS:
+		var (
+			stream = log.SetStreamAliasOp.Stream
+			alias  = log.SetStreamAliasOp.Alias
+			remove = log.SetStreamAliasOp.Remove
+		)
+		if err := s.applySetStreamAlias(stream, alias, remove); err != nil {
+			return nil, err
+		}
+	case proto.Op_RENAME_STREAM:
+		var (
+			stream  = log.RenameStreamOp.Stream
+			name    = log.RenameStreamOp.Name
+			replace = log.RenameStreamOp.Replace
+		)
+		if err := s.applyRenameStream(stream, name, replace); err != nil {
+			return nil, err
+		}
+	case proto.Op_SET_DERIVED_OFFSET:
+		var (
+			stream    = log.SetDerivedOffsetOp.Stream
+			partition = log.SetDerivedOffsetOp.Partition
+			offset    = log.SetDerivedOffsetOp.Offset
+		)
+		s.applySetDerivedOffset(stream, partition, offset)
 	case proto.Op_RESUME_STREAM:
 		var (
 			stream     = log.ResumeStreamOp.Stream
@@ -202,6 +263,11 @@ func (s *Server) apply(log *proto.RaftLog, index uint64, recovered bool) (interf
 	case proto.Op_PUBLISH_ACTIVITY:
 		s.activity.SetLastPublishedRaftIndex(log.PublishActivityOp.RaftIndex)
 	default:
+		if log.Version > metadataVersion {
+			s.logger.Warnf("fsm: Skipping unknown Raft operation %s written by newer metadata version %d",
+				log.Op, log.Version)
+			return nil, nil
+		}
 		return nil, fmt.Errorf("Unknown Raft operation: %s", log.Op)
 	}
 	return nil, nil
@@ -214,9 +280,11 @@ func (s *Server) startedRecovery() {
 		return
 	}
 	// If LogRecovery is disabled, we need to suppress logs while replaying the
-	// Raft log. Do this by discarding the log output.
-	s.loggerOut = s.logger.Writer()
-	s.logger.SetWriter(ioutil.Discard)
+	// Raft log. This is only possible if the logger is suppressible, which it
+	// is unless a test replaced it.
+	if l, ok := s.logger.(*logger.SuppressibleLogger); ok {
+		s.endRecoverySuppression = l.Suppress()
+	}
 }
 
 // finishedRecovery should be called when the FSM has finished replaying any
@@ -224,9 +292,10 @@ func (s *Server) startedRecovery() {
 // during the replay. It returns the number of streams which had partitions
 // that were recovered.
 func (s *Server) finishedRecovery() (int, error) {
-	// If LogRecovery is disabled, we need to restore the previous log output.
-	if !s.config.LogRecovery {
-		s.logger.SetWriter(s.loggerOut)
+	// If LogRecovery is disabled, we need to stop suppressing logs.
+	if s.endRecoverySuppression != nil {
+		s.endRecoverySuppression()
+		s.endRecoverySuppression = nil
 	}
 	recoveredStreams := make(map[string]struct{})
 	for _, stream := range s.metadata.GetStreams() {
@@ -298,22 +367,30 @@ func (s *Server) Snapshot() (raft.FSMSnapshot, error) {
 		var (
 			partitions  = stream.GetPartitions()
 			protoStream = &proto.Stream{
-				Name:       stream.GetName(),
-				Subject:    stream.GetSubject(),
-				Config:     stream.GetConfig(),
-				Partitions: make([]*proto.Partition, len(partitions)),
+				Name:           stream.GetName(),
+				Subject:        stream.GetSubject(),
+				Config:         stream.GetConfig(),
+				Partitions:     make([]*proto.Partition, len(partitions)),
+				Aliases:        stream.GetAliases(),
+				DerivedOffsets: stream.GetDerivedOffsets(),
 			}
 		)
 		creationTime := stream.GetCreationTime()
 		if !creationTime.IsZero() {
 			protoStream.CreationTimestamp = creationTime.UnixNano()
 		}
+		if name := stream.GetDisplayName(); name != protoStream.Name {
+			protoStream.DisplayName = name
+		}
 		for j, partition := range partitions {
 			protoStream.Partitions[j] = partition.Partition
 		}
 		protoStreams[i] = protoStream
 	}
-	return &fsmSnapshot{&proto.MetadataSnapshot{Streams: protoStreams}}, nil
+	return &fsmSnapshot{&proto.MetadataSnapshot{
+		Streams: protoStreams,
+		Version: metadataVersion,
+	}}, nil
 }
 
 // Restore is used to restore an FSM from a snapshot. It is not called
@@ -338,11 +415,15 @@ func (s *Server) Restore(snapshot io.ReadCloser) error {
 	if err := snap.Unmarshal(buf); err != nil {
 		return err
 	}
+	if err := checkMetadataVersion(snap.Version); err != nil {
+		return errors.Wrap(err, "failed to restore snapshot")
+	}
 
 	// Drop state and restore.
 	if err := s.metadata.Reset(); err != nil {
 		return err
 	}
+	s.metadataWatch.reset()
 	for _, stream := range snap.Streams {
 		if err := s.applyCreateStream(stream, false); err != nil {
 			return err
@@ -462,3 +543,43 @@ func (s *Server) applySetStreamReadonly(streamName string, partitions []int32, r
 	s.logger.Debugf("fsm: Set stream %s readonly flag as %v", streamName, readonly)
 	return nil
 }
+
+// applySetStreamAlias adds or removes an alias of the given stream in the
+// metadata store.
+func (s *Server) applySetStreamAlias(streamName, alias string, remove bool) error {
+	if remove {
+		if err := s.metadata.RemoveStreamAlias(streamName, alias); err != nil {
+			return errors.Wrap(err, "failed to remove stream alias")
+		}
+		s.logger.Debugf("fsm: Removed alias %s from stream %s", alias, streamName)
+		return nil
+	}
+
+	if err := s.metadata.AddStreamAlias(streamName, alias); err != nil {
+		return errors.Wrap(err, "failed to add stream alias")
+	}
+	s.logger.Debugf("fsm: Added alias %s to stream %s", alias, streamName)
+	return nil
+}
+
+// applyRenameStream renames the given stream in the metadata store, replacing
+// the stream the new name refers to if replace is set.
+func (s *Server) applyRenameStream(streamName, name string, replace bool) error {
+	if err := s.metadata.SetStreamName(streamName, name, replace); err != nil {
+		return errors.Wrap(err, "failed to rename stream")
+	}
+	s.logger.Debugf("fsm: Renamed stream %s to %s", streamName, name)
+	return nil
+}
+
+// applySetDerivedOffset records the progress of the given derived stream in
+// the metadata store. The stream may have been deleted since the offset was
+// checkpointed, in which case the offset is ignored.
+func (s *Server) applySetDerivedOffset(streamName string, partition int32, offset int64) {
+	if err := s.metadata.setDerivedOffset(streamName, partition, offset); err != nil {
+		s.logger.Debugf("fsm: Ignoring derived offset for stream %s: %v", streamName, err)
+		return
+	}
+	s.logger.Debugf("fsm: Set derived offset of stream %s for source partition %d to %d",
+		streamName, partition, offset)
+}
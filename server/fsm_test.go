@@ -1,12 +1,20 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
 	"testing"
 	"time"
 
 	lift "github.com/liftbridge-io/go-liftbridge/v2"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
 
 // Ensure Raft FSM properly snapshots and restores state.
@@ -45,3 +53,79 @@ func TestFSMSnapshotRestore(t *testing.T) {
 	waitForPartition(t, 10*time.Second, "bar", 2, s1)
 	require.Len(t, s1.metadata.GetStreams(), 2)
 }
+
+// Ensure operations unknown to the server are skipped if they were written by
+// a newer metadata version.
+func TestFSMApplyNewerVersion(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	// Fields unknown to the server are ignored too.
+	op := &proto.RaftLog{
+		Op:      proto.Op(100),
+		Version: metadataVersion + 1,
+	}
+	data, err := op.Marshal()
+	require.NoError(t, err)
+	data = append(data, 0xf8, 0x01, 0x01) // Field 31, varint 1
+	require.NoError(t, s1.getRaft().Apply(data, 5*time.Second).Error())
+
+	createFSMTestStream(t, "foo")
+	waitForPartition(t, 10*time.Second, "foo", 0, s1)
+}
+
+// Ensure snapshots written by a metadata version up to one version newer are
+// restored and later versions are rejected.
+func TestFSMRestoreVersion(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	createFSMTestStream(t, "foo")
+	waitForPartition(t, 10*time.Second, "foo", 0, s1)
+
+	snapshot, err := s1.Snapshot()
+	require.NoError(t, err)
+	require.Equal(t, uint32(metadataVersion), snapshot.(*fsmSnapshot).Version)
+
+	// Fields unknown to the server are ignored.
+	encode := func(snap *proto.MetadataSnapshot) io.ReadCloser {
+		data, err := snap.Marshal()
+		require.NoError(t, err)
+		data = append(data, 0xf8, 0x01, 0x01) // Field 31, varint 1
+		buf := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint32(buf, uint32(len(data)))
+		return ioutil.NopCloser(bytes.NewReader(append(buf, data...)))
+	}
+
+	// A snapshot too new to read leaves the state untouched.
+	require.Error(t, s1.Restore(encode(&proto.MetadataSnapshot{Version: metadataVersion + 2})))
+	require.Len(t, s1.metadata.GetStreams(), 1)
+
+	require.NoError(t, s1.Restore(encode(&proto.MetadataSnapshot{Version: metadataVersion + 1})))
+	require.Len(t, s1.metadata.GetStreams(), 0)
+}
+
+// createFSMTestStream creates a stream with the given name through the API of
+// the server listening on port 5050.
+func createFSMTestStream(t *testing.T, name string) {
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.NewAPIClient(conn).CreateStream(ctx, &client.CreateStreamRequest{
+		Subject: name,
+		Name:    name,
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/liftbridge-io/liftbridge/server/logger"
+)
+
+// Liveness of a gossip member. Members are alive while their heartbeat keeps
+// increasing, suspect once it hasn't for the suspect timeout and dead once it
+// hasn't for the dead timeout.
+const (
+	livenessAlive   = "alive"
+	livenessSuspect = "suspect"
+	livenessDead    = "dead"
+)
+
+const (
+	// gossipFanout is the number of random members gossip is sent to each
+	// interval in addition to the seeds.
+	gossipFanout = 3
+
+	// maxGossipMessageSize is the largest gossip message that can be received.
+	maxGossipMessageSize = 65507
+)
+
+// gossipMember is a server's entry in the gossip member list.
+type gossipMember struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"` // Gossip address
+	Host string `json:"host"` // Client API host
+	Port int32  `json:"port"` // Client API port
+
+	// Incarnation distinguishes restarts of a server, whose heartbeat starts
+	// over, and Heartbeat is incremented by the server every interval.
+	Incarnation int64  `json:"incarnation"`
+	Heartbeat   uint64 `json:"heartbeat"`
+
+	updated time.Time // When the heartbeat last increased
+	dead    bool      // Whether the member was detected as dead
+}
+
+// newerThan indicates if the member's heartbeat is more recent than the given
+// member's.
+func (g *gossipMember) newerThan(other *gossipMember) bool {
+	if g.Incarnation != other.Incarnation {
+		return g.Incarnation > other.Incarnation
+	}
+	return g.Heartbeat > other.Heartbeat
+}
+
+// gossipMessage is sent between servers to exchange member lists.
+type gossipMessage struct {
+	Namespace string          `json:"namespace"`
+	Members   []*gossipMember `json:"members"`
+}
+
+// gossipMemberStatus is a snapshot of a gossip member.
+type gossipMemberStatus struct {
+	ID       string
+	Addr     string
+	Host     string
+	Port     int32
+	Liveness string
+}
+
+// gossipConfig contains settings for a gossiper.
+type gossipConfig struct {
+	Namespace      string
+	Seeds          []string
+	Interval       time.Duration
+	SuspectTimeout time.Duration
+	DeadTimeout    time.Duration
+}
+
+// gossiper detects the liveness of servers by exchanging heartbeats over UDP
+// independently of NATS. Every interval, it increments its own heartbeat and
+// sends the members it has recently heard from to a few random members and
+// the seeds, which merge it into their own member lists. Members which have
+// not been heard from for the suspect timeout are no longer gossiped, so
+// failed servers age out of every member list. Dead members are kept until
+// they rejoin so that their liveness can still be reported.
+type gossiper struct {
+	config  gossipConfig
+	logger  logger.Logger
+	conn    *net.UDPConn
+	onDead  func(id string)
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	self    *gossipMember
+	members map[string]*gossipMember
+}
+
+// newGossiper creates a gossiper listening on the given UDP address for the
+// server with the given ID and client API address. The advertised host is
+// used in place of an unspecified listen host. Call start to begin gossiping.
+// The onDead function is called when a member is detected as dead.
+func newGossiper(id, listen, advertiseHost string, port int32, config gossipConfig,
+	logger logger.Logger, onDead func(id string)) (*gossiper, error) {
+
+	addr, err := net.ResolveUDPAddr("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host := addr.IP.String()
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		host = advertiseHost
+	}
+	localPort := conn.LocalAddr().(*net.UDPAddr).Port
+	return &gossiper{
+		config: config,
+		logger: logger,
+		conn:   conn,
+		onDead: onDead,
+		stopCh: make(chan struct{}),
+		self: &gossipMember{
+			ID:          id,
+			Addr:        net.JoinHostPort(host, strconv.Itoa(localPort)),
+			Host:        advertiseHost,
+			Port:        port,
+			Incarnation: time.Now().UnixNano(),
+		},
+		members: make(map[string]*gossipMember),
+	}, nil
+}
+
+// start begins sending and receiving gossip.
+func (g *gossiper) start() {
+	g.wg.Add(2)
+	go g.receiveLoop()
+	go g.gossipLoop()
+}
+
+// stop stops gossiping and closes the UDP connection.
+func (g *gossiper) stop() {
+	select {
+	case <-g.stopCh:
+		return
+	default:
+	}
+	close(g.stopCh)
+	g.conn.Close()
+	g.wg.Wait()
+}
+
+// memberStatuses returns the status of the known members by ID, excluding
+// this server.
+func (g *gossiper) memberStatuses() map[string]*gossipMemberStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	statuses := make(map[string]*gossipMemberStatus, len(g.members))
+	for id, member := range g.members {
+		statuses[id] = &gossipMemberStatus{
+			ID:       member.ID,
+			Addr:     member.Addr,
+			Host:     member.Host,
+			Port:     member.Port,
+			Liveness: g.liveness(member, now),
+		}
+	}
+	return statuses
+}
+
+// deadMembers returns the IDs of the members detected as dead.
+func (g *gossiper) deadMembers() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var dead []string
+	for id, member := range g.members {
+		if member.dead {
+			dead = append(dead, id)
+		}
+	}
+	return dead
+}
+
+// liveness returns the liveness of the given member. This must be called
+// with the mutex held.
+func (g *gossiper) liveness(member *gossipMember, now time.Time) string {
+	age := now.Sub(member.updated)
+	switch {
+	case member.dead || age > g.config.DeadTimeout:
+		return livenessDead
+	case age > g.config.SuspectTimeout:
+		return livenessSuspect
+	default:
+		return livenessAlive
+	}
+}
+
+// gossipLoop sends gossip every interval until the gossiper is stopped.
+func (g *gossiper) gossipLoop() {
+	defer g.wg.Done()
+	ticker := time.NewTicker(g.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.gossip()
+		case <-g.stopCh:
+			return
+		}
+	}
+}
+
+// gossip increments this server's heartbeat, detects dead members and sends
+// the member list to random members and the seeds.
+func (g *gossiper) gossip() {
+	var (
+		now     = time.Now()
+		targets []string
+		dead    []string
+	)
+	g.mu.Lock()
+	g.self.Heartbeat++
+	msg := &gossipMessage{
+		Namespace: g.config.Namespace,
+		Members:   []*gossipMember{g.self},
+	}
+	for id, member := range g.members {
+		switch g.liveness(member, now) {
+		case livenessDead:
+			if !member.dead {
+				member.dead = true
+				dead = append(dead, id)
+			}
+			continue
+		case livenessAlive:
+			msg.Members = append(msg.Members, member)
+		}
+		// Keep sending to suspect members so they can recover.
+		targets = append(targets, member.Addr)
+	}
+	data, err := json.Marshal(msg)
+	g.mu.Unlock()
+	if err != nil {
+		panic(err)
+	}
+
+	rand.Shuffle(len(targets), func(i, j int) { targets[i], targets[j] = targets[j], targets[i] })
+	if len(targets) > gossipFanout {
+		targets = targets[:gossipFanout]
+	}
+	for _, seed := range g.config.Seeds {
+		if seed != g.self.Addr {
+			targets = append(targets, seed)
+		}
+	}
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			g.logger.Warnf("Failed to resolve gossip address %s: %v", target, err)
+			continue
+		}
+		if _, err := g.conn.WriteToUDP(data, addr); err != nil {
+			g.logger.Debugf("Failed to send gossip to %s: %v", target, err)
+		}
+	}
+
+	for _, id := range dead {
+		g.logger.Warnf("Gossip detected server %s as dead", id)
+		g.onDead(id)
+	}
+}
+
+// receiveLoop merges received gossip into the member list until the gossiper
+// is stopped.
+func (g *gossiper) receiveLoop() {
+	defer g.wg.Done()
+	buf := make([]byte, maxGossipMessageSize)
+	for {
+		n, _, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-g.stopCh:
+				return
+			default:
+			}
+			g.logger.Debugf("Failed to receive gossip: %v", err)
+			continue
+		}
+		msg := &gossipMessage{}
+		if err := json.Unmarshal(buf[:n], msg); err != nil {
+			g.logger.Warnf("Received invalid gossip: %v", err)
+			continue
+		}
+		if msg.Namespace != g.config.Namespace {
+			continue
+		}
+		g.merge(msg.Members)
+	}
+}
+
+// merge updates the member list with the given members' heartbeats if they
+// are more recent.
+func (g *gossiper) merge(members []*gossipMember) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for _, member := range members {
+		if member.ID == g.self.ID {
+			continue
+		}
+		existing, ok := g.members[member.ID]
+		if ok && !member.newerThan(existing) {
+			continue
+		}
+		switch {
+		case !ok:
+			g.logger.Infof("Gossip discovered server %s at %s", member.ID, member.Addr)
+		case existing.dead:
+			g.logger.Infof("Gossip detected server %s as alive again", member.ID)
+		}
+		member.updated = now
+		g.members[member.ID] = member
+	}
+}
+
+// startGossip starts gossiping with the other servers to detect their
+// liveness if a gossip address is configured.
+func (s *Server) startGossip() error {
+	if s.config.Clustering.GossipListen == "" {
+		return nil
+	}
+	address := s.getConnectionAddress()
+	gossip, err := newGossiper(
+		s.config.Clustering.ServerID,
+		s.config.Clustering.GossipListen,
+		address.Host,
+		int32(address.Port),
+		gossipConfig{
+			Namespace:      s.config.Clustering.Namespace,
+			Seeds:          s.config.Clustering.GossipSeeds,
+			Interval:       s.config.Clustering.GossipInterval,
+			SuspectTimeout: s.config.Clustering.GossipSuspectTimeout,
+			DeadTimeout:    s.config.Clustering.GossipDeadTimeout,
+		},
+		s.logger,
+		s.handleGossipDead,
+	)
+	if err != nil {
+		return err
+	}
+	s.gossip = gossip
+	s.logger.Infof("Gossiping on %s", gossip.self.Addr)
+	gossip.start()
+	return nil
+}
+
+// handleGossipDead is called when gossip detects a server as dead.
+func (s *Server) handleGossipDead(id string) {
+	s.startGoroutine(func() {
+		s.electLeadersForDeadServer(id)
+	})
+}
+
+// electLeadersForDeadServer elects new leaders for the partitions led by the
+// given server if this server is the metadata leader. This fails over
+// partitions as soon as gossip detects the server as dead rather than
+// waiting for their followers to report the leader over NATS.
+func (s *Server) electLeadersForDeadServer(id string) {
+	if !s.metadata.IsLeader() {
+		return
+	}
+	for _, stream := range s.metadata.GetStreams() {
+		for _, partition := range stream.GetPartitions() {
+			if leader, _ := partition.GetLeader(); leader != id || partition.ISRSize() <= 1 {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), defaultRaftApplyTimeout)
+			st := s.metadata.electNewPartitionLeader(ctx, partition)
+			cancel()
+			if st != nil {
+				s.logger.Warnf("Failed to elect new leader for partition %s led by dead server %s: %s",
+					partition, id, st.Message())
+				continue
+			}
+			s.logger.Infof("Elected new leader for partition %s led by dead server %s", partition, id)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	natsdTest "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/liftbridge-io/liftbridge/server/logger"
+)
+
+var testGossipConfig = gossipConfig{
+	Namespace:      "test",
+	Interval:       10 * time.Millisecond,
+	SuspectTimeout: 100 * time.Millisecond,
+	DeadTimeout:    300 * time.Millisecond,
+}
+
+// newTestGossiper creates and starts a gossiper on a random local port which
+// reports dead members on the given channel.
+func newTestGossiper(t *testing.T, id string, seeds []string, dead chan<- string) *gossiper {
+	log := logger.NewLogger(0)
+	log.SetWriter(ioutil.Discard)
+	config := testGossipConfig
+	config.Seeds = seeds
+	g, err := newGossiper(id, "127.0.0.1:0", "localhost", 5050, config, log, func(id string) {
+		dead <- id
+	})
+	require.NoError(t, err)
+	g.start()
+	return g
+}
+
+// waitForLiveness waits for the gossiper to see the member with the given
+// liveness.
+func waitForLiveness(t *testing.T, g *gossiper, id, liveness string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status, ok := g.memberStatuses()[id]; ok && status.Liveness == liveness {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stackFatalf(t, "Gossiper %s did not see %s as %s", g.self.ID, id, liveness)
+}
+
+// Ensure gossipers discover each other through a seed, detect failed members
+// and detect restarted members as alive again.
+func TestGossipMembership(t *testing.T) {
+	dead := make(chan string, 10)
+	g1 := newTestGossiper(t, "a", nil, dead)
+	defer g1.stop()
+	g2 := newTestGossiper(t, "b", []string{g1.self.Addr}, dead)
+	defer g2.stop()
+	g3 := newTestGossiper(t, "c", []string{g1.self.Addr}, dead)
+
+	// b and c discover each other through a.
+	for _, g := range []*gossiper{g1, g2, g3} {
+		for _, id := range []string{"a", "b", "c"} {
+			if id != g.self.ID {
+				waitForLiveness(t, g, id, livenessAlive)
+			}
+		}
+	}
+	status := g2.memberStatuses()["c"]
+	require.Equal(t, g3.self.Addr, status.Addr)
+	require.Equal(t, "localhost", status.Host)
+	require.Equal(t, int32(5050), status.Port)
+
+	// Stopping c makes it suspect, then dead.
+	g3.stop()
+	waitForLiveness(t, g1, "c", livenessSuspect)
+	waitForLiveness(t, g1, "c", livenessDead)
+	waitForLiveness(t, g2, "c", livenessDead)
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-dead:
+			require.Equal(t, "c", id)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected dead member to be reported")
+		}
+	}
+	require.ElementsMatch(t, []string{"c"}, g1.deadMembers())
+
+	// Restarting c makes it alive again.
+	g3 = newTestGossiper(t, "c", []string{g1.self.Addr}, dead)
+	defer g3.stop()
+	waitForLiveness(t, g1, "c", livenessAlive)
+	waitForLiveness(t, g2, "c", livenessAlive)
+	require.Empty(t, g1.deadMembers())
+
+	// Gossip for other namespaces is ignored.
+	log := logger.NewLogger(0)
+	log.SetWriter(ioutil.Discard)
+	config := testGossipConfig
+	config.Namespace = "other"
+	config.Seeds = []string{g1.self.Addr}
+	other, err := newGossiper("d", "127.0.0.1:0", "localhost", 5050, config, log, func(string) {})
+	require.NoError(t, err)
+	other.start()
+	defer other.stop()
+	time.Sleep(100 * time.Millisecond)
+	_, ok := g1.memberStatuses()["d"]
+	require.False(t, ok)
+}
+
+// Ensure the metadata leader elects a new leader for a partition once gossip
+// detects its leader as dead, without waiting for the followers to report it.
+func TestGossipElectsLeaderForDeadServer(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Use an external NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	var (
+		ids     = []string{"a", "b", "c"}
+		seeds   = make([]string, len(ids))
+		servers []*Server
+	)
+	for i := range ids {
+		seeds[i] = fmt.Sprintf("127.0.0.1:%d", 9490+i)
+	}
+	for i, id := range ids {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		// Make failure detection by followers too slow to be what elects new
+		// leaders.
+		config.Clustering.ReplicaMaxLeaderTimeout = time.Minute
+		config.Clustering.ReplicaMaxIdleWait = 500 * time.Millisecond
+		config.Clustering.GossipListen = seeds[i]
+		config.Clustering.GossipSeeds = seeds
+		config.Clustering.GossipInterval = testGossipConfig.Interval
+		config.Clustering.GossipSuspectTimeout = testGossipConfig.SuspectTimeout
+		config.Clustering.GossipDeadTimeout = testGossipConfig.DeadTimeout
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	metadataLeader := getMetadataLeader(t, 10*time.Second, servers...)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.NewAPIClient(conn).CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	waitForISR(t, 10*time.Second, "foo", 0, 3, servers...)
+
+	// Make sure the partition is led by a server other than the metadata
+	// leader.
+	leader := getPartitionLeader(t, 10*time.Second, "foo", 0, servers...)
+	if leader == metadataLeader {
+		partition := metadataLeader.metadata.GetPartition("foo", 0)
+		require.Nil(t, metadataLeader.metadata.electNewPartitionLeader(ctx, partition))
+		waitForISR(t, 10*time.Second, "foo", 0, 3, servers...)
+		for leader == metadataLeader {
+			time.Sleep(10 * time.Millisecond)
+			leader = getPartitionLeader(t, 10*time.Second, "foo", 0, servers...)
+		}
+	}
+
+	// Stop the leader without handing off its leadership.
+	var remaining []*Server
+	for _, s := range servers {
+		if s != leader {
+			remaining = append(remaining, s)
+		}
+	}
+	leader.Stop()
+	getPartitionLeader(t, 5*time.Second, "foo", 0, remaining...)
+
+	waitForLiveness(t, metadataLeader.gossip, leader.config.Clustering.ServerID, livenessDead)
+}
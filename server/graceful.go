@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// gracefulStopPollInterval is how often GracefulStop checks if the partitions
+// it stepped down from have new leaders.
+const gracefulStopPollInterval = 10 * time.Millisecond
+
+var (
+	// errNotPartitionLeader is returned by HandoffPartition if the server
+	// doesn't lead the partition.
+	errNotPartitionLeader = errors.New("server is not the partition leader")
+
+	// errNoHandoffCandidates is returned by HandoffPartition if the partition
+	// has no other in-sync replica to take over its leadership.
+	errNoHandoffCandidates = errors.New("partition has no other in-sync replicas")
+)
+
+// GracefulStop stops the Server after draining it so that clients fail over
+// without waiting for the rest of the cluster to detect the server is gone.
+// Draining first asks the metadata leader to elect new leaders for the
+// partitions the server leads and have other in-sync replicas, transfers the
+// metadata leadership if the server holds it, then notifies clients and ends
+// their requests. Draining is bounded by the given timeout, after which the
+// server stops regardless. Stopping closes the partition logs, which
+// checkpoints their high watermarks to disk.
+func (s *Server) GracefulStop(timeout time.Duration) error {
+	if s.IsRunning() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := s.Drain(ctx); err != nil {
+			s.logger.Warnf("Failed to drain server before stopping: %v", err)
+		}
+		cancel()
+	}
+	return s.Stop()
+}
+
+// transferPartitionLeadership steps down as leader of the partitions the
+// server leads which have other in-sync replicas and waits for them to have
+// new leaders. It returns the context's error if it's done first.
+func (s *Server) transferPartitionLeadership(ctx context.Context) error {
+	var (
+		serverID = s.config.Clustering.ServerID
+		stepped  []*partition
+	)
+	for _, stream := range s.metadata.GetStreams() {
+		for _, partition := range stream.GetPartitions() {
+			leader, epoch := partition.GetLeader()
+			if leader != serverID || partition.ISRSize() <= 1 {
+				continue
+			}
+			if st := s.stepDownPartitionLeader(ctx, partition, epoch); st != nil {
+				s.logger.Warnf("Failed to step down as leader for partition %s: %s",
+					partition, st.Message())
+				continue
+			}
+			stepped = append(stepped, partition)
+		}
+	}
+
+	// Wait for the leader changes to be applied so that the partitions stop
+	// leading before their logs are closed.
+	for _, partition := range stepped {
+		if _, err := s.waitForNewPartitionLeader(ctx, partition); err != nil {
+			s.logger.Warnf("Timed out waiting for new leader for partition %s", partition)
+			return err
+		}
+	}
+	return nil
+}
+
+// HandoffPartition steps down as leader of the given partition so that the
+// metadata leader elects the in-sync replica leading the fewest partitions
+// instead, and returns the new leader once the change is applied. The server
+// keeps replicating the partition as a follower. This can be used to balance
+// partition leadership across the cluster without stopping servers.
+func (s *Server) HandoffPartition(ctx context.Context, stream string, id int32) (string, error) {
+	partition, err := s.replicaPartition(stream, id)
+	if err != nil {
+		return "", err
+	}
+	leader, epoch := partition.GetLeader()
+	if leader != s.config.Clustering.ServerID {
+		return "", errNotPartitionLeader
+	}
+	if partition.ISRSize() <= 1 {
+		return "", errNoHandoffCandidates
+	}
+	if st := s.stepDownPartitionLeader(ctx, partition, epoch); st != nil {
+		return "", st.Err()
+	}
+	return s.waitForNewPartitionLeader(ctx, partition)
+}
+
+// stepDownPartitionLeader asks the metadata leader to elect a new leader for
+// the given partition, which the server leads in the given epoch.
+func (s *Server) stepDownPartitionLeader(ctx context.Context, partition *partition,
+	epoch uint64) *status.Status {
+
+	serverID := s.config.Clustering.ServerID
+	return s.metadata.ReportLeader(ctx, &proto.ReportLeaderOp{
+		Stream:      partition.Stream,
+		Partition:   partition.Id,
+		Replica:     serverID,
+		Leader:      serverID,
+		LeaderEpoch: epoch,
+	})
+}
+
+// waitForNewPartitionLeader waits until another server leads the given
+// partition and returns it. It returns the context's error if it's done
+// first.
+func (s *Server) waitForNewPartitionLeader(ctx context.Context, partition *partition) (
+	string, error) {
+
+	for {
+		if leader, _ := partition.GetLeader(); leader != s.config.Clustering.ServerID {
+			return leader, nil
+		}
+		select {
+		case <-time.After(gracefulStopPollInterval):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// transferMetadataLeadership transfers the metadata leadership to another
+// server in the cluster if this server is the metadata leader.
+func (s *Server) transferMetadataLeadership() {
+	raft := s.getRaft()
+	if raft == nil || !raft.isLeader() {
+		return
+	}
+	ids, err := s.metadata.getClusterServerIDs()
+	if err != nil {
+		s.logger.Warnf("Failed to transfer metadata leadership: %v", err)
+		return
+	}
+	if len(ids) <= 1 {
+		return
+	}
+	s.logger.Info("Transferring metadata leadership")
+	if err := raft.LeadershipTransfer().Error(); err != nil {
+		s.logger.Warnf("Failed to transfer metadata leadership: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	natsdTest "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure GracefulStop hands off the leadership of the partitions the server
+// leads without waiting for the other replicas to detect the leader is gone.
+func TestGracefulStopTransfersPartitionLeadership(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Use an external NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	var servers []*Server
+	for i, id := range []string{"a", "b", "c"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		// Make failure detection too slow to be what elects new leaders.
+		config.Clustering.ReplicaMaxLeaderTimeout = time.Minute
+		config.Clustering.ReplicaMaxIdleWait = 500 * time.Millisecond
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	getMetadataLeader(t, 10*time.Second, servers...)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 3,
+		Partitions:        2,
+	})
+	require.NoError(t, err)
+	for id := int32(0); id < 2; id++ {
+		waitForISR(t, 10*time.Second, "foo", id, 3, servers...)
+	}
+
+	// Stop the leader of the second partition, which is not the stream's
+	// first partition, so that the leader change must name the partition.
+	leader := getPartitionLeader(t, 10*time.Second, "foo", 1, servers...)
+	var remaining []*Server
+	for _, s := range servers {
+		if s != leader {
+			remaining = append(remaining, s)
+		}
+	}
+	require.NoError(t, leader.GracefulStop(5*time.Second))
+	require.False(t, leader.IsRunning())
+
+	// The remaining servers lead both partitions well before the leader would
+	// be detected as failed.
+	for id := int32(0); id < 2; id++ {
+		getPartitionLeader(t, 5*time.Second, "foo", id, remaining...)
+	}
+}
+
+// Ensure the admin API hands off the leadership of a partition to another
+// in-sync replica while the server keeps running.
+func TestAdminPartitionHandoffAPI(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Use an external NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	var servers []*Server
+	for i, id := range []string{"a", "b"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		config.AdminListen = fmt.Sprintf("localhost:%d", 9390+i)
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	getMetadataLeader(t, 10*time.Second, servers...)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	waitForISR(t, 10*time.Second, "foo", 0, 2, servers...)
+
+	leader := getPartitionLeader(t, 10*time.Second, "foo", 0, servers...)
+	follower := servers[0]
+	if follower == leader {
+		follower = servers[1]
+	}
+	path := streamsPath + "/foo/partitions/0/handoff"
+
+	require.Equal(t, http.StatusConflict, adminRequest(t, follower, http.MethodPost, path, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, adminRequest(t, leader, http.MethodGet, path, nil))
+	require.Equal(t, http.StatusNotFound,
+		adminRequest(t, leader, http.MethodPost, streamsPath+"/bar/partitions/0/handoff", nil))
+
+	var resp handoffResponse
+	require.Equal(t, http.StatusOK, adminRequest(t, leader, http.MethodPost, path, &resp))
+	require.Equal(t, handoffResponse{
+		Stream:    "foo",
+		Partition: 0,
+		Leader:    follower.config.Clustering.ServerID,
+	}, resp)
+	require.True(t, leader.IsRunning())
+	require.Equal(t, follower, getPartitionLeader(t, 5*time.Second, "foo", 0, servers...))
+}
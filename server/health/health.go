@@ -8,6 +8,10 @@ import (
 
 const serviceName = "proto.API" // taken from compiled protobuf file api.go.pb (line 793)
 
+// CanaryServiceName is the name of the health service reporting whether the
+// server's canary probes succeed.
+const CanaryServiceName = "liftbridge.canary"
+
 var server = health.NewServer()
 
 // Register the health service with a gRPC server.
@@ -24,3 +28,13 @@ func SetServing() {
 func SetNotServing() {
 	server.SetServingStatus(serviceName, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
 }
+
+// SetCanaryServing marks the canary as healthy.
+func SetCanaryServing() {
+	server.SetServingStatus(CanaryServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+}
+
+// SetCanaryNotServing marks the canary as unhealthy.
+func SetCanaryNotServing() {
+	server.SetServingStatus(CanaryServiceName, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+}
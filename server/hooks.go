@@ -0,0 +1,104 @@
+package server
+
+import (
+	client "github.com/liftbridge-io/liftbridge-api/go"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// CursorCommit is a cursor position committed to the cursors stream.
+type CursorCommit struct {
+	Stream    string
+	Partition int32
+	CursorID  string
+	Offset    int64
+}
+
+// CursorCommitListener is a listener for cursor commits. It's called on the
+// server leading the cursors partition for the cursor once the commit has been
+// replicated, whether the cursor was set with SetCursor or by an
+// auto-committing subscription. Commits of a cursor are received in order
+// since the listener is called while further commits wait, so it must not block
+// or call the server's API.
+type CursorCommitListener interface {
+	CursorCommitted(*CursorCommit)
+}
+
+// StreamLifecycleListener is a listener for streams being created, deleted,
+// paused, resumed, or set readonly. It's called on every server as the change
+// is applied to its metadata, so changes replayed when the server restarts are
+// not received again. The event is the one published to the activity stream.
+// It's called from the Raft FSM, which must not be held up, so the listener must
+// not block.
+type StreamLifecycleListener interface {
+	StreamChanged(*client.ActivityStreamEvent)
+}
+
+// PublishAckListener is a listener for publish acks. It's called on the
+// partition leader for each ack the message's AckPolicy calls for, including
+// acks reporting errors, whether or not the publisher requested the ack with an
+// ack inbox. Acks for internal streams such as the cursors stream are received
+// too. It's called while the partition commits messages, so it must not block
+// or modify the ack, which is sent after it returns.
+type PublishAckListener interface {
+	PublishAcked(*client.Ack)
+}
+
+// AddCursorCommitListener adds a cursor commit listener.
+func (s *Server) AddCursorCommitListener(listener CursorCommitListener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.cursorCommitListeners = append(s.cursorCommitListeners, listener)
+}
+
+// AddStreamLifecycleListener adds a stream lifecycle listener.
+func (s *Server) AddStreamLifecycleListener(listener StreamLifecycleListener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.streamLifecycleListeners = append(s.streamLifecycleListeners, listener)
+}
+
+// AddPublishAckListener adds a publish ack listener.
+func (s *Server) AddPublishAckListener(listener PublishAckListener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.publishAckListeners = append(s.publishAckListeners, listener)
+}
+
+// cursorCommitted notifies cursor commit listeners of a commit.
+func (s *Server) cursorCommitted(commit *CursorCommit) {
+	s.listenersMu.RLock()
+	listeners := s.cursorCommitListeners
+	s.listenersMu.RUnlock()
+	for _, listener := range listeners {
+		listener.CursorCommitted(commit)
+	}
+}
+
+// streamChanged notifies stream lifecycle listeners of the Raft log operation
+// at the given index if it changes a stream's lifecycle.
+func (s *Server) streamChanged(log *proto.RaftLog, index uint64) {
+	s.listenersMu.RLock()
+	listeners := s.streamLifecycleListeners
+	s.listenersMu.RUnlock()
+	if len(listeners) == 0 {
+		return
+	}
+	event := activityEvent(log, index)
+	if event == nil {
+		return
+	}
+	for _, listener := range listeners {
+		listener.StreamChanged(event)
+	}
+}
+
+// publishAcked notifies publish ack listeners of an ack.
+func (s *Server) publishAcked(ack *client.Ack) {
+	s.listenersMu.RLock()
+	listeners := s.publishAckListeners
+	s.listenersMu.RUnlock()
+	for _, listener := range listeners {
+		listener.PublishAcked(ack)
+	}
+}
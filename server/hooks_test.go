@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+type hookListener struct {
+	commits chan *CursorCommit
+	events  chan *client.ActivityStreamEvent
+	acks    chan *client.Ack
+}
+
+func newHookListener() *hookListener {
+	return &hookListener{
+		commits: make(chan *CursorCommit, 10),
+		events:  make(chan *client.ActivityStreamEvent, 10),
+		acks:    make(chan *client.Ack, 10),
+	}
+}
+
+func (h *hookListener) CursorCommitted(commit *CursorCommit) {
+	h.commits <- commit
+}
+
+func (h *hookListener) StreamChanged(event *client.ActivityStreamEvent) {
+	h.events <- event
+}
+
+func (h *hookListener) PublishAcked(ack *client.Ack) {
+	// Ignore acks for internal streams.
+	if ack.Stream == "foo" {
+		h.acks <- ack
+	}
+}
+
+func (h *hookListener) nextEvent(t *testing.T) *client.ActivityStreamEvent {
+	select {
+	case event := <-h.events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Did not receive expected stream event")
+	}
+	return nil
+}
+
+// Ensure listeners are notified of cursor commits, stream lifecycle changes,
+// and publish acks.
+func TestHookListeners(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.CursorsStream.Partitions = 1
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	listener := newHookListener()
+	s.AddCursorCommitListener(listener)
+	s.AddStreamLifecycleListener(listener)
+	s.AddPublishAckListener(listener)
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	waitForPartition(t, 10*time.Second, "foo", 0, s)
+
+	// Skip events for internal streams.
+	event := listener.nextEvent(t)
+	for event.Op == client.ActivityStreamOp_CREATE_STREAM && event.CreateStreamOp.Stream != "foo" {
+		event = listener.nextEvent(t)
+	}
+	require.Equal(t, client.ActivityStreamOp_CREATE_STREAM, event.Op)
+	require.Equal(t, []int32{0}, event.CreateStreamOp.Partitions)
+
+	// Acks are received even if the publisher did not request them.
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("hello"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+	_, err = api.PublishToSubject(ctx, &client.PublishToSubjectRequest{
+		Subject:   "foo",
+		Value:     []byte("world"),
+		AckPolicy: client.AckPolicy_LEADER,
+	})
+	require.NoError(t, err)
+	for i := int64(0); i < 2; i++ {
+		select {
+		case ack := <-listener.acks:
+			require.Equal(t, i, ack.Offset)
+			require.NotZero(t, ack.CommitTimestamp)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Did not receive expected ack")
+		}
+	}
+
+	_, err = api.SetCursor(ctx, &client.SetCursorRequest{Stream: "foo", CursorId: "abc", Offset: 1})
+	require.NoError(t, err)
+	select {
+	case commit := <-listener.commits:
+		require.Equal(t, &CursorCommit{Stream: "foo", CursorID: "abc", Offset: 1}, commit)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Did not receive expected cursor commit")
+	}
+
+	_, err = api.PauseStream(ctx, &client.PauseStreamRequest{Name: "foo"})
+	require.NoError(t, err)
+	event = listener.nextEvent(t)
+	require.Equal(t, client.ActivityStreamOp_PAUSE_STREAM, event.Op)
+	require.Equal(t, "foo", event.PauseStreamOp.Stream)
+
+	_, err = api.DeleteStream(ctx, &client.DeleteStreamRequest{Name: "foo"})
+	require.NoError(t, err)
+	event = listener.nextEvent(t)
+	require.Equal(t, client.ActivityStreamOp_DELETE_STREAM, event.Op)
+	require.Equal(t, "foo", event.DeleteStreamOp.Stream)
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// HotPartitionsMetadata is the FetchMetadata request metadata key asking for
+// the busiest partitions on the server handling the request. Its value is the
+// maximum number of partitions to return. The response header metadata then
+// contains a HotPartitionMetadata value for each partition with any load,
+// busiest first.
+const HotPartitionsMetadata = "liftbridge-hot-partitions"
+
+// HotPartitionMetadata is the FetchMetadata response header metadata key
+// containing the load of a partition, encoded as described for
+// PartitionLoadHeader.
+const HotPartitionMetadata = "liftbridge-hot-partition"
+
+// HotPartitionHeader is the header set on the activity stream event published
+// when the load of a partition crosses one of the configured thresholds. Its
+// value is "hot" when the partition becomes hot and "cool" when its load falls
+// back below all thresholds. The event is a SET_STREAM_READONLY event for the
+// partition with readonly false and ID 0 since it does not correspond to a
+// Raft operation or change the partition.
+const HotPartitionHeader = "Liftbridge-Hot-Partition"
+
+// PartitionLoadHeader is the header set on hot partition activity stream
+// events containing the load of the partition. It's URL query encoded with
+// the stream, partition, appends (messages appended per second), reads
+// (messages read by subscriptions per second), and lock_wait (seconds spent
+// waiting for the partition lock per second).
+const PartitionLoadHeader = "Liftbridge-Partition-Load"
+
+// partitionLoad is the load of a partition measured over a sample interval.
+type partitionLoad struct {
+	stream     string
+	partition  int32
+	appendRate float64
+	readRate   float64
+	lockWait   float64
+}
+
+// String returns the URL query encoding of the load.
+func (l partitionLoad) String() string {
+	return url.Values{
+		"stream":    {l.stream},
+		"partition": {strconv.FormatInt(int64(l.partition), 10)},
+		"appends":   {strconv.FormatFloat(l.appendRate, 'f', 2, 64)},
+		"reads":     {strconv.FormatFloat(l.readRate, 'f', 2, 64)},
+		"lock_wait": {strconv.FormatFloat(l.lockWait, 'f', 4, 64)},
+	}.Encode()
+}
+
+// idle indicates if the partition had no load.
+func (l partitionLoad) idle() bool {
+	return l.appendRate == 0 && l.readRate == 0 && l.lockWait == 0
+}
+
+// partitionCounters are the cumulative counters of a partition at a sample.
+type partitionCounters struct {
+	appends   int64
+	reads     int64
+	lockWait  int64
+	timestamp time.Time
+}
+
+// contendedRWMutex is a sync.RWMutex which accumulates the time spent waiting
+// to acquire it.
+type contendedRWMutex struct {
+	waitNanos int64 // Atomic, must be first for alignment
+	sync.RWMutex
+}
+
+// Lock locks the mutex for writing.
+func (m *contendedRWMutex) Lock() {
+	start := time.Now()
+	m.RWMutex.Lock()
+	atomic.AddInt64(&m.waitNanos, int64(time.Since(start)))
+}
+
+// RLock locks the mutex for reading.
+func (m *contendedRWMutex) RLock() {
+	start := time.Now()
+	m.RWMutex.RLock()
+	atomic.AddInt64(&m.waitNanos, int64(time.Since(start)))
+}
+
+// hotPartitionTracker periodically measures the load of the partitions on the
+// server, ranks them, and publishes activity stream events when a partition's
+// load crosses the configured thresholds.
+type hotPartitionTracker struct {
+	*Server
+	mu       sync.Mutex
+	counters map[*partition]partitionCounters
+	loads    []partitionLoad     // Busiest first
+	hot      map[string]struct{} // Hot partitions by stream and partition ID
+}
+
+func newHotPartitionTracker(s *Server) *hotPartitionTracker {
+	return &hotPartitionTracker{
+		Server:   s,
+		counters: make(map[*partition]partitionCounters),
+		hot:      make(map[string]struct{}),
+	}
+}
+
+// sample measures the load of each partition since the previous sample and
+// publishes events for partitions which became hot or cooled down. It returns
+// the time until the next sample.
+func (h *hotPartitionTracker) sample() time.Duration {
+	var (
+		now      = time.Now()
+		counters = make(map[*partition]partitionCounters)
+		loads    []partitionLoad
+	)
+	for _, stream := range h.metadata.GetStreams() {
+		for _, p := range stream.GetPartitions() {
+			c := partitionCounters{
+				appends:   atomic.LoadInt64(&p.appendCount),
+				reads:     atomic.LoadInt64(&p.readCount),
+				lockWait:  atomic.LoadInt64(&p.mu.waitNanos),
+				timestamp: now,
+			}
+			counters[p] = c
+			prev, ok := h.counters[p]
+			if !ok {
+				// The partition is new, so there's nothing to compare to.
+				continue
+			}
+			elapsed := c.timestamp.Sub(prev.timestamp).Seconds()
+			if elapsed <= 0 {
+				continue
+			}
+			loads = append(loads, partitionLoad{
+				stream:     p.Stream,
+				partition:  p.Id,
+				appendRate: float64(c.appends-prev.appends) / elapsed,
+				readRate:   float64(c.reads-prev.reads) / elapsed,
+				lockWait:   time.Duration(c.lockWait-prev.lockWait).Seconds() / elapsed,
+			})
+		}
+	}
+	sort.SliceStable(loads, func(i, j int) bool {
+		ri := loads[i].appendRate + loads[i].readRate
+		rj := loads[j].appendRate + loads[j].readRate
+		if ri != rj {
+			return ri > rj
+		}
+		return loads[i].lockWait > loads[j].lockWait
+	})
+
+	h.mu.Lock()
+	h.counters = counters
+	h.loads = loads
+	var changed []partitionLoad
+	hot := make(map[string]struct{})
+	for _, load := range loads {
+		key := fmt.Sprintf("%s:%d", load.stream, load.partition)
+		_, wasHot := h.hot[key]
+		isHot := h.isHot(load)
+		if isHot {
+			hot[key] = struct{}{}
+		}
+		if isHot != wasHot {
+			changed = append(changed, load)
+		}
+	}
+	h.hot = hot
+	h.mu.Unlock()
+
+	for _, load := range changed {
+		isHot := h.isHot(load)
+		if isHot {
+			h.logger.Warnf("Partition %d of stream %s is hot: %s", load.partition, load.stream, load)
+		} else {
+			h.logger.Infof("Partition %d of stream %s cooled down: %s", load.partition, load.stream, load)
+		}
+		load := load
+		h.startGoroutine(func() {
+			h.publishHotPartitionEvent(load, isHot)
+		})
+	}
+	return h.config.Streams.HotSampleInterval
+}
+
+// isHot indicates if the load crosses any of the configured thresholds.
+// Internal streams are ranked but never considered hot since hot partition
+// events are themselves published to the activity stream.
+func (h *hotPartitionTracker) isHot(load partitionLoad) bool {
+	if isInternalStream(load.stream) {
+		return false
+	}
+	config := h.config.Streams
+	return (config.HotAppendRate > 0 && load.appendRate >= config.HotAppendRate) ||
+		(config.HotReadRate > 0 && load.readRate >= config.HotReadRate) ||
+		(config.HotLockWait > 0 && load.lockWait >= config.HotLockWait)
+}
+
+// top returns the loads of up to n of the busiest partitions with any load.
+func (h *hotPartitionTracker) top(n int) []partitionLoad {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var top []partitionLoad
+	for _, load := range h.loads {
+		if len(top) == n || load.idle() {
+			break
+		}
+		top = append(top, load)
+	}
+	return top
+}
+
+// setHotPartitionsHeader sets the response header metadata of a FetchMetadata
+// request with the busiest partitions if the request asks for them.
+func (h *hotPartitionTracker) setHotPartitionsHeader(ctx context.Context) *status.Status {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.Get(HotPartitionsMetadata)
+	if len(values) == 0 {
+		return nil
+	}
+	n, err := strconv.Atoi(values[0])
+	if err != nil || n < 1 {
+		return status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s metadata %q", HotPartitionsMetadata, values[0]))
+	}
+	top := h.top(n)
+	if len(top) == 0 {
+		return nil
+	}
+	header := make(metadata.MD, 1)
+	for _, load := range top {
+		header.Append(HotPartitionMetadata, load.String())
+	}
+	grpc.SetHeader(ctx, header) // nolint: errcheck
+	return nil
+}
+
+// publishHotPartitionEvent publishes an event to the activity stream, if it's
+// enabled, indicating the partition became hot or cooled down.
+func (h *hotPartitionTracker) publishHotPartitionEvent(load partitionLoad, hot bool) {
+	if !h.config.ActivityStream.Enabled {
+		return
+	}
+	event := &client.ActivityStreamEvent{
+		Op: client.ActivityStreamOp_SET_STREAM_READONLY,
+		SetStreamReadonlyOp: &client.SetStreamReadonlyOp{
+			Stream:     load.stream,
+			Partitions: []int32{load.partition},
+		},
+	}
+	data, err := event.Marshal()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.ActivityStream.PublishTimeout)
+	defer cancel()
+
+	value := "cool"
+	if hot {
+		value = "hot"
+	}
+	if _, err := h.api.Publish(ctx, &client.PublishRequest{
+		Value:  data,
+		Stream: activityStream,
+		Headers: map[string][]byte{
+			HotPartitionHeader:  []byte(value),
+			PartitionLoadHeader: []byte(load.String()),
+		},
+		AckPolicy: h.config.ActivityStream.PublishAckPolicy,
+	}); err != nil {
+		h.logger.Errorf("Failed to publish hot partition event for partition %d of stream %s: %v",
+			load.partition, load.stream, err)
+	}
+}
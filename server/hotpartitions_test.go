@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Ensure FetchMetadata returns the busiest partitions when asked for them and
+// activity stream events are published when a partition becomes hot and when
+// it cools down.
+func TestHotPartitions(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.ActivityStream.Enabled = true
+	s1Config.ActivityStream.PublishTimeout = time.Second
+	s1Config.ActivityStream.PublishAckPolicy = client.AckPolicy_LEADER
+	s1Config.Streams.HotSampleInterval = 500 * time.Millisecond
+	s1Config.Streams.HotAppendRate = 20
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, name := range []string{"foo", "bar"} {
+		_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: name, Name: name})
+		require.NoError(t, err)
+	}
+
+	// Publish to foo until it's hot, and to bar at a lower rate.
+	hot := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-hot:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			stream := "foo"
+			if i%10 == 0 {
+				stream = "bar"
+			}
+			if _, err := api.Publish(ctx, &client.PublishRequest{
+				Stream:    stream,
+				Value:     []byte("x"),
+				AckPolicy: client.AckPolicy_LEADER,
+			}); err != nil {
+				return
+			}
+		}
+	}()
+
+	var loads []url.Values
+	require.Eventually(t, func() bool {
+		var header metadata.MD
+		_, err := api.FetchMetadata(
+			metadata.AppendToOutgoingContext(ctx, HotPartitionsMetadata, "2"),
+			&client.FetchMetadataRequest{}, grpc.Header(&header))
+		require.NoError(t, err)
+		loads = nil
+		for _, value := range header.Get(HotPartitionMetadata) {
+			load, err := url.ParseQuery(value)
+			require.NoError(t, err)
+			loads = append(loads, load)
+		}
+		return len(loads) == 2 && loads[0].Get("stream") == "foo" && loads[1].Get("stream") == "bar"
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, "0", loads[0].Get("partition"))
+	require.NotEqual(t, "0.00", loads[0].Get("appends"))
+	require.NotEmpty(t, loads[0].Get("lock_wait"))
+
+	// Invalid partition counts are rejected.
+	_, err = api.FetchMetadata(
+		metadata.AppendToOutgoingContext(ctx, HotPartitionsMetadata, "0"),
+		&client.FetchMetadataRequest{})
+	require.Error(t, err)
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        activityStream,
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	nextHotEvent := func() (string, url.Values) {
+		for {
+			msg, err := sub.Recv()
+			require.NoError(t, err)
+			if value, ok := msg.Headers[HotPartitionHeader]; ok {
+				load, err := url.ParseQuery(string(msg.Headers[PartitionLoadHeader]))
+				require.NoError(t, err)
+				event := &client.ActivityStreamEvent{}
+				require.NoError(t, event.Unmarshal(msg.Value))
+				require.Equal(t, client.ActivityStreamOp_SET_STREAM_READONLY, event.Op)
+				require.Equal(t, load.Get("stream"), event.SetStreamReadonlyOp.Stream)
+				return string(value), load
+			}
+		}
+	}
+
+	value, load := nextHotEvent()
+	require.Equal(t, "hot", value)
+	require.Equal(t, "foo", load.Get("stream"))
+
+	// Once publishing stops, foo cools down.
+	close(hot)
+	value, load = nextHotEvent()
+	require.Equal(t, "cool", value)
+	require.Equal(t, "foo", load.Get("stream"))
+}
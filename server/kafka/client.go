@@ -0,0 +1,512 @@
+// Package kafka implements a minimal Kafka consumer used to migrate Kafka
+// topics into Liftbridge streams. It speaks the Kafka wire protocol directly
+// and supports only what is needed for importing: topic metadata, offset
+// lookups, fetching committed records (message format v2), and reading
+// consumer group offsets, over plaintext or TLS connections optionally
+// authenticated with SASL.
+package kafka
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	clientID            = "liftbridge-import"
+	defaultDialTimeout  = 10 * time.Second
+	defaultFetchMaxWait = 500 * time.Millisecond
+	defaultFetchMaxSize = 1024 * 1024
+)
+
+// ErrNoBrokers is returned when none of the bootstrap brokers are reachable.
+var ErrNoBrokers = errors.New("kafka: no brokers available")
+
+// Options contains settings for configuring a Client.
+type Options struct {
+	Brokers      []string      // Bootstrap broker addresses
+	DialTimeout  time.Duration // Timeout for connecting to a broker
+	FetchMaxWait time.Duration // Max time a broker waits for data on fetch
+	FetchMaxSize int32         // Max bytes returned per partition on fetch
+	TLS          *tls.Config   // Connect to brokers with TLS if set
+	SASL         *SASL         // Authenticate to brokers with SASL if set
+}
+
+type broker struct {
+	mu            sync.Mutex
+	addr          string
+	conn          net.Conn
+	correlationID int32
+	dialTimeout   time.Duration
+	timeout       time.Duration
+	tls           *tls.Config
+	sasl          *SASL
+}
+
+// request sends a request to the broker and returns the response body.
+func (b *broker) request(apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		if err := b.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return b.send(apiKey, apiVersion, body)
+}
+
+// connect dials the broker and authenticates the connection if SASL is
+// configured. The broker's lock must be held.
+func (b *broker) connect() error {
+	var (
+		dialer = &net.Dialer{Timeout: b.dialTimeout}
+		conn   net.Conn
+		err    error
+	)
+	if b.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.addr, b.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", b.addr)
+	}
+	if err != nil {
+		return err
+	}
+	b.conn = conn
+	if b.sasl != nil {
+		if err := b.authenticate(); err != nil {
+			if b.conn != nil {
+				b.conn.Close()
+				b.conn = nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends a request on the broker's connection and returns the response
+// body. The connection is closed if the request fails. The broker's lock must
+// be held.
+func (b *broker) send(apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	b.correlationID++
+
+	header := &encoder{}
+	header.int32(0) // Size placeholder
+	header.int16(apiKey)
+	header.int16(apiVersion)
+	header.int32(b.correlationID)
+	header.string(clientID)
+	msg := append(header.buf, body...)
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)-4))
+
+	resp, err := b.roundTrip(msg)
+	if err != nil {
+		b.conn.Close()
+		b.conn = nil
+		return nil, err
+	}
+	if len(resp) < 4 {
+		return nil, errMalformed
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != b.correlationID {
+		b.conn.Close()
+		b.conn = nil
+		return nil, fmt.Errorf("kafka: correlation ID mismatch: expected %d, got %d",
+			b.correlationID, id)
+	}
+	return resp[4:], nil
+}
+
+func (b *broker) roundTrip(msg []byte) ([]byte, error) {
+	if err := b.conn.SetDeadline(time.Now().Add(b.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := b.conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(b.conn, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(b.conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+// Client consumes records from a Kafka cluster. It is safe for concurrent
+// use.
+type Client struct {
+	opts    Options
+	mu      sync.Mutex
+	brokers map[int32]*broker
+	leaders map[string]map[int32]int32
+	seeds   []*broker
+}
+
+// NewClient creates a Client for the given bootstrap brokers. Connections are
+// established lazily.
+func NewClient(opts Options) (*Client, error) {
+	if len(opts.Brokers) == 0 {
+		return nil, errors.New("kafka: no brokers provided")
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+	if opts.FetchMaxWait == 0 {
+		opts.FetchMaxWait = defaultFetchMaxWait
+	}
+	if opts.FetchMaxSize == 0 {
+		opts.FetchMaxSize = defaultFetchMaxSize
+	}
+	if opts.SASL != nil {
+		if err := opts.SASL.validate(); err != nil {
+			return nil, err
+		}
+	}
+	c := &Client{
+		opts:    opts,
+		brokers: make(map[int32]*broker),
+		leaders: make(map[string]map[int32]int32),
+	}
+	for _, addr := range opts.Brokers {
+		c.seeds = append(c.seeds, c.newBroker(addr))
+	}
+	return c, nil
+}
+
+// Close closes all broker connections.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, b := range c.seeds {
+		b.close()
+	}
+	for _, b := range c.brokers {
+		b.close()
+	}
+}
+
+// anyBroker sends a request to the first reachable bootstrap broker.
+func (c *Client) anyBroker(apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	err := ErrNoBrokers
+	for _, b := range c.seeds {
+		var resp []byte
+		resp, err = b.request(apiKey, apiVersion, body)
+		if err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) newBroker(addr string) *broker {
+	return &broker{
+		addr:        addr,
+		dialTimeout: c.opts.DialTimeout,
+		// Fetches may block on the broker for up to FetchMaxWait.
+		timeout: c.opts.DialTimeout + c.opts.FetchMaxWait,
+		tls:     c.opts.TLS,
+		sasl:    c.opts.SASL,
+	}
+}
+
+func (c *Client) broker(id int32, host string, port int32) *broker {
+	addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	b, ok := c.brokers[id]
+	if !ok || b.addr != addr {
+		if ok {
+			b.close()
+		}
+		b = c.newBroker(addr)
+		c.brokers[id] = b
+	}
+	return b
+}
+
+// Partitions returns the partition IDs of the given topic and refreshes the
+// cached partition leaders.
+func (c *Client) Partitions(topic string) ([]int32, error) {
+	req := &encoder{}
+	req.arrayLength(1)
+	req.string(topic)
+	resp, err := c.anyBroker(apiKeyMetadata, 1, req.buf)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := &decoder{buf: resp}
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // Rack
+		if d.err == nil {
+			c.broker(id, host, port)
+		}
+	}
+	d.int32() // Controller ID
+	var partitions []int32
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		topicErr := d.int16()
+		name := d.string()
+		d.int8() // Is internal
+		leaders := make(map[int32]int32)
+		for j, m := 0, d.arrayLength(); j < m; j++ {
+			d.int16() // Partition error
+			partition := d.int32()
+			leaders[partition] = d.int32()
+			for k, r := 0, d.arrayLength(); k < r; k++ {
+				d.int32() // Replica
+			}
+			for k, r := 0, d.arrayLength(); k < r; k++ {
+				d.int32() // ISR
+			}
+			if name == topic {
+				partitions = append(partitions, partition)
+			}
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+		if name != topic {
+			continue
+		}
+		if err := errorFromCode(topicErr); err != nil {
+			return nil, err
+		}
+		c.leaders[topic] = leaders
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if partitions == nil {
+		return nil, ErrUnknownTopicOrPartition
+	}
+	return partitions, nil
+}
+
+func (c *Client) leader(topic string, partition int32) (*broker, error) {
+	c.mu.Lock()
+	leaders, ok := c.leaders[topic]
+	c.mu.Unlock()
+	if !ok {
+		if _, err := c.Partitions(topic); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		leaders = c.leaders[topic]
+		c.mu.Unlock()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := leaders[partition]
+	if !ok {
+		return nil, ErrUnknownTopicOrPartition
+	}
+	b, ok := c.brokers[id]
+	if !ok {
+		return nil, ErrNotLeaderForPartition
+	}
+	return b, nil
+}
+
+// invalidate drops cached leaders for the topic so they are refreshed on the
+// next request.
+func (c *Client) invalidate(topic string, err error) {
+	if err == ErrNotLeaderForPartition || err == ErrUnknownTopicOrPartition {
+		c.mu.Lock()
+		delete(c.leaders, topic)
+		c.mu.Unlock()
+	}
+}
+
+// EarliestOffset returns the oldest available offset in the partition.
+func (c *Client) EarliestOffset(topic string, partition int32) (int64, error) {
+	return c.listOffset(topic, partition, timestampEarliest)
+}
+
+// LatestOffset returns the offset of the next committed record in the
+// partition, i.e. the partition's last stable offset. This is the high
+// watermark unless there are open transactions.
+func (c *Client) LatestOffset(topic string, partition int32) (int64, error) {
+	return c.listOffset(topic, partition, timestampLatest)
+}
+
+func (c *Client) listOffset(topic string, partition int32, timestamp int64) (int64, error) {
+	b, err := c.leader(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	req := &encoder{}
+	req.int32(-1)                    // Replica ID
+	req.int8(isolationReadCommitted) // Isolation level
+	req.arrayLength(1)
+	req.string(topic)
+	req.arrayLength(1)
+	req.int32(partition)
+	req.int64(timestamp)
+	resp, err := b.request(apiKeyListOffsets, 2, req.buf)
+	if err != nil {
+		return 0, err
+	}
+
+	d := &decoder{buf: resp}
+	d.int32() // Throttle time
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		name := d.string()
+		for j, m := 0, d.arrayLength(); j < m; j++ {
+			p := d.int32()
+			code := d.int16()
+			d.int64() // Timestamp
+			offset := d.int64()
+			if d.err == nil && name == topic && p == partition {
+				err := errorFromCode(code)
+				c.invalidate(topic, err)
+				return offset, err
+			}
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return 0, ErrUnknownTopicOrPartition
+}
+
+// Fetch returns committed records from the partition starting at the given
+// offset along with the offset to fetch from next. Records of aborted
+// transactions are skipped. It blocks up to FetchMaxWait if no records are
+// available.
+func (c *Client) Fetch(topic string, partition int32, offset int64) ([]Record, int64, error) {
+	b, err := c.leader(topic, partition)
+	if err != nil {
+		return nil, 0, err
+	}
+	req := &encoder{}
+	req.int32(-1) // Replica ID
+	req.int32(int32(c.opts.FetchMaxWait / time.Millisecond))
+	req.int32(1)                   // Min bytes
+	req.int32(c.opts.FetchMaxSize) // Max bytes
+	req.int8(isolationReadCommitted)
+	req.arrayLength(1)
+	req.string(topic)
+	req.arrayLength(1)
+	req.int32(partition)
+	req.int64(offset)
+	req.int32(c.opts.FetchMaxSize)
+	resp, err := b.request(apiKeyFetch, 4, req.buf)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d := &decoder{buf: resp}
+	d.int32() // Throttle time
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		name := d.string()
+		for j, m := 0, d.arrayLength(); j < m; j++ {
+			p := d.int32()
+			code := d.int16()
+			d.int64() // High watermark
+			d.int64() // Last stable offset
+			var aborted []abortedTransaction
+			for k, r := 0, d.arrayLength(); k < r; k++ {
+				aborted = append(aborted, abortedTransaction{
+					producerID:  d.int64(),
+					firstOffset: d.int64(),
+				})
+			}
+			recordSet := d.bytes()
+			if d.err != nil || name != topic || p != partition {
+				continue
+			}
+			if err := errorFromCode(code); err != nil {
+				c.invalidate(topic, err)
+				return nil, 0, err
+			}
+			return decodeRecordSet(recordSet, offset, aborted)
+		}
+	}
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	return nil, 0, ErrUnknownTopicOrPartition
+}
+
+// CommittedOffset returns the offset committed by the consumer group for the
+// partition, i.e. the offset of the next record the group will consume, or -1
+// if the group has no committed offset.
+func (c *Client) CommittedOffset(group, topic string, partition int32) (int64, error) {
+	coordinator, err := c.coordinator(group)
+	if err != nil {
+		return 0, err
+	}
+	req := &encoder{}
+	req.string(group)
+	req.arrayLength(1)
+	req.string(topic)
+	req.arrayLength(1)
+	req.int32(partition)
+	resp, err := coordinator.request(apiKeyOffsetFetch, 1, req.buf)
+	if err != nil {
+		return 0, err
+	}
+
+	d := &decoder{buf: resp}
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		name := d.string()
+		for j, m := 0, d.arrayLength(); j < m; j++ {
+			p := d.int32()
+			offset := d.int64()
+			d.string() // Metadata
+			code := d.int16()
+			if d.err == nil && name == topic && p == partition {
+				return offset, errorFromCode(code)
+			}
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return -1, nil
+}
+
+func (c *Client) coordinator(group string) (*broker, error) {
+	req := &encoder{}
+	req.string(group)
+	resp, err := c.anyBroker(apiKeyFindCoordinator, 0, req.buf)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{buf: resp}
+	code := d.int16()
+	id := d.int32()
+	host := d.string()
+	port := d.int32()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := errorFromCode(code); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.broker(id, host, port), nil
+}
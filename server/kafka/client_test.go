@@ -0,0 +1,428 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBroker is a single Kafka broker serving one topic with one partition.
+// If sasl is set, connections must authenticate with its credentials before
+// making other requests.
+type fakeBroker struct {
+	t         *testing.T
+	listener  net.Listener
+	topic     string
+	recordSet []byte
+	aborted   []abortedTransaction
+	earliest  int64
+	latest    int64
+	committed int64
+	sasl      *SASL
+}
+
+func newFakeBroker(t *testing.T, topic string) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return startFakeBroker(t, l, topic)
+}
+
+func newFakeTLSBroker(t *testing.T, topic string) *fakeBroker {
+	cert, err := tls.LoadX509KeyPair("../configs/certs/server.crt", "../configs/certs/server.key")
+	require.NoError(t, err)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	return startFakeBroker(t, l, topic)
+}
+
+func startFakeBroker(t *testing.T, l net.Listener, topic string) *fakeBroker {
+	b := &fakeBroker{t: t, listener: l, topic: topic}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *fakeBroker) close() {
+	b.listener.Close()
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	var (
+		authenticated = b.sasl == nil
+		scram         *fakeScram
+	)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := &decoder{buf: req}
+		apiKey := d.int16()
+		d.int16() // Version
+		correlationID := d.int32()
+		d.string() // Client ID
+
+		resp := &encoder{}
+		resp.int32(0) // Size placeholder
+		resp.int32(correlationID)
+		if !authenticated && apiKey != apiKeySASLHandshake && apiKey != apiKeySASLAuthenticate {
+			return
+		}
+		switch apiKey {
+		case apiKeySASLHandshake:
+			mechanism := d.string()
+			if mechanism != b.sasl.mechanism() {
+				resp.int16(int16(ErrUnsupportedSASLMechanism))
+			} else {
+				resp.int16(0)
+			}
+			resp.arrayLength(1)
+			resp.string(b.sasl.mechanism())
+			if mechanism != SASLPlain {
+				scram = &fakeScram{sasl: b.sasl}
+			}
+		case apiKeySASLAuthenticate:
+			auth := d.bytes()
+			var (
+				out []byte
+				ok  bool
+			)
+			if scram != nil {
+				out, ok, authenticated = scram.step(b.t, string(auth))
+			} else {
+				ok = string(auth) == "\x00"+b.sasl.User+"\x00"+b.sasl.Password
+				authenticated = ok
+			}
+			if ok {
+				resp.int16(0)
+				resp.int16(-1) // Error message
+			} else {
+				resp.int16(int16(ErrSASLAuthenticationFailed))
+				resp.string("invalid credentials")
+			}
+			resp.bytes(out)
+		case apiKeyMetadata:
+			b.metadata(resp)
+		case apiKeyListOffsets:
+			b.listOffsets(d, resp)
+		case apiKeyFetch:
+			b.fetch(d, resp)
+		case apiKeyFindCoordinator:
+			b.findCoordinator(resp)
+		case apiKeyOffsetFetch:
+			b.offsetFetch(resp)
+		default:
+			return
+		}
+		binary.BigEndian.PutUint32(resp.buf, uint32(len(resp.buf)-4))
+		if _, err := conn.Write(resp.buf); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) hostPort() (string, int32) {
+	host, port, err := net.SplitHostPort(b.addr())
+	require.NoError(b.t, err)
+	p, err := strconv.Atoi(port)
+	require.NoError(b.t, err)
+	return host, int32(p)
+}
+
+func (b *fakeBroker) metadata(resp *encoder) {
+	host, port := b.hostPort()
+	resp.arrayLength(1)
+	resp.int32(1) // Node ID
+	resp.string(host)
+	resp.int32(port)
+	resp.int16(-1) // Rack
+	resp.int32(1)  // Controller ID
+	resp.arrayLength(1)
+	resp.int16(0)
+	resp.string(b.topic)
+	resp.int8(0)
+	resp.arrayLength(1)
+	resp.int16(0)
+	resp.int32(0) // Partition
+	resp.int32(1) // Leader
+	resp.arrayLength(1)
+	resp.int32(1)
+	resp.arrayLength(1)
+	resp.int32(1)
+}
+
+func (b *fakeBroker) listOffsets(d *decoder, resp *encoder) {
+	d.int32() // Replica ID
+	require.Equal(b.t, int8(isolationReadCommitted), d.int8())
+	d.arrayLength()
+	d.string()
+	d.arrayLength()
+	d.int32()
+	timestamp := d.int64()
+	offset := b.latest
+	if timestamp == timestampEarliest {
+		offset = b.earliest
+	}
+	resp.int32(0) // Throttle time
+	resp.arrayLength(1)
+	resp.string(b.topic)
+	resp.arrayLength(1)
+	resp.int32(0)
+	resp.int16(0)
+	resp.int64(-1)
+	resp.int64(offset)
+}
+
+func (b *fakeBroker) fetch(d *decoder, resp *encoder) {
+	d.int32() // Replica ID
+	d.int32() // Max wait
+	d.int32() // Min bytes
+	d.int32() // Max bytes
+	require.Equal(b.t, int8(isolationReadCommitted), d.int8())
+	resp.int32(0) // Throttle time
+	resp.arrayLength(1)
+	resp.string(b.topic)
+	resp.arrayLength(1)
+	resp.int32(0)
+	resp.int16(0)
+	resp.int64(b.latest)
+	resp.int64(b.latest)
+	if b.aborted == nil {
+		resp.arrayLength(-1)
+	} else {
+		resp.arrayLength(len(b.aborted))
+	}
+	for _, txn := range b.aborted {
+		resp.int64(txn.producerID)
+		resp.int64(txn.firstOffset)
+	}
+	resp.int32(int32(len(b.recordSet)))
+	resp.buf = append(resp.buf, b.recordSet...)
+}
+
+func (b *fakeBroker) findCoordinator(resp *encoder) {
+	host, port := b.hostPort()
+	resp.int16(0)
+	resp.int32(1)
+	resp.string(host)
+	resp.int32(port)
+}
+
+func (b *fakeBroker) offsetFetch(resp *encoder) {
+	resp.arrayLength(1)
+	resp.string(b.topic)
+	resp.arrayLength(1)
+	resp.int32(0)
+	resp.int64(b.committed)
+	resp.int16(-1) // Metadata
+	resp.int16(0)
+}
+
+// fakeScram is the server side of a SCRAM exchange.
+type fakeScram struct {
+	sasl        *SASL
+	clientFirst string
+	serverFirst string
+	nonce       string
+}
+
+const (
+	fakeScramSalt       = "salt"
+	fakeScramIterations = 4096
+)
+
+// step handles a client message and returns the response, whether the
+// message was valid, and whether authentication is complete.
+func (s *fakeScram) step(t *testing.T, msg string) ([]byte, bool, bool) {
+	h := sha256.New
+	if s.sasl.Mechanism == SASLScramSHA512 {
+		h = func() hash.Hash { return sha512.New() }
+	}
+	if s.clientFirst == "" {
+		require.True(t, strings.HasPrefix(msg, "n,,"))
+		s.clientFirst = strings.TrimPrefix(msg, "n,,")
+		attrs := scramAttributes(s.clientFirst)
+		user := strings.NewReplacer("=2C", ",", "=3D", "=").Replace(attrs["n"])
+		if user != s.sasl.User {
+			return nil, false, false
+		}
+		s.nonce = attrs["r"] + "server"
+		s.serverFirst = "r=" + s.nonce + ",s=" +
+			base64.StdEncoding.EncodeToString([]byte(fakeScramSalt)) +
+			",i=" + strconv.Itoa(fakeScramIterations)
+		return []byte(s.serverFirst), true, false
+	}
+	attrs := scramAttributes(msg)
+	clientFinal := "c=biws,r=" + s.nonce
+	require.True(t, strings.HasPrefix(msg, clientFinal+","))
+	proof, err := base64.StdEncoding.DecodeString(attrs["p"])
+	require.NoError(t, err)
+
+	salted := scramHi(h, []byte(s.sasl.Password), []byte(fakeScramSalt), fakeScramIterations)
+	storedKey := h()
+	storedKey.Write(scramHMAC(h, salted, []byte("Client Key")))
+	authMessage := []byte(s.clientFirst + "," + s.serverFirst + "," + clientFinal)
+	clientKey := scramHMAC(h, storedKey.Sum(nil), authMessage)
+	if len(proof) != len(clientKey) {
+		return nil, false, false
+	}
+	for i := range clientKey {
+		clientKey[i] ^= proof[i]
+	}
+	check := h()
+	check.Write(clientKey)
+	if string(check.Sum(nil)) != string(storedKey.Sum(nil)) {
+		return nil, false, false
+	}
+	serverKey := scramHMAC(h, salted, []byte("Server Key"))
+	signature := scramHMAC(h, serverKey, authMessage)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(signature)), true, true
+}
+
+// Ensures the Client can look up partitions and offsets, fetch records, and
+// fetch consumer group offsets from a broker.
+func TestClient(t *testing.T) {
+	broker := newFakeBroker(t, "foo")
+	defer broker.close()
+	broker.earliest = 5
+	broker.latest = 7
+	broker.committed = 6
+	broker.recordSet = encodeBatch(t, 5, compressionNone, []Record{
+		{Key: []byte("a"), Value: []byte("hello")},
+		{Value: []byte("world")},
+	})
+
+	c, err := NewClient(Options{Brokers: []string{"127.0.0.1:1", broker.addr()}})
+	require.NoError(t, err)
+	defer c.Close()
+
+	partitions, err := c.Partitions("foo")
+	require.NoError(t, err)
+	require.Equal(t, []int32{0}, partitions)
+
+	earliest, err := c.EarliestOffset("foo", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), earliest)
+
+	latest, err := c.LatestOffset("foo", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), latest)
+
+	records, next, err := c.Fetch("foo", 0, 5)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), next)
+	require.Len(t, records, 2)
+	require.Equal(t, []byte("a"), records[0].Key)
+	require.Equal(t, []byte("hello"), records[0].Value)
+	require.Nil(t, records[1].Key)
+	require.Equal(t, int64(6), records[1].Offset)
+
+	committed, err := c.CommittedOffset("group", "foo", 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), committed)
+
+	_, err = c.LatestOffset("foo", 1)
+	require.Equal(t, ErrUnknownTopicOrPartition, err)
+}
+
+// Ensures NewClient requires brokers.
+func TestNewClientNoBrokers(t *testing.T) {
+	_, err := NewClient(Options{})
+	require.Error(t, err)
+}
+
+// Ensures the Client skips records of aborted transactions.
+func TestClientFetchReadCommitted(t *testing.T) {
+	broker := newFakeBroker(t, "foo")
+	defer broker.close()
+	records := []Record{{Value: []byte("a")}, {Value: []byte("b")}}
+	broker.recordSet = append(
+		encodeProducerBatch(t, 0, transactionalBatchFlag, 1, records),
+		encodeProducerBatch(t, 2, transactionalBatchFlag|controlBatchFlag, 1, records[:1])...)
+	broker.recordSet = append(broker.recordSet, encodeBatch(t, 3, compressionNone, records)...)
+	broker.aborted = []abortedTransaction{{producerID: 1, firstOffset: 0}}
+
+	c, err := NewClient(Options{Brokers: []string{broker.addr()}})
+	require.NoError(t, err)
+	defer c.Close()
+
+	fetched, next, err := c.Fetch("foo", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), next)
+	require.Len(t, fetched, 2)
+	require.Equal(t, int64(3), fetched[0].Offset)
+	require.Equal(t, int64(4), fetched[1].Offset)
+}
+
+// Ensures the Client connects to brokers with TLS and authenticates with each
+// supported SASL mechanism.
+func TestClientTLSAndSASL(t *testing.T) {
+	for _, mechanism := range []string{"", SASLPlain, SASLScramSHA256, SASLScramSHA512} {
+		t.Run(mechanism, func(t *testing.T) {
+			broker := newFakeTLSBroker(t, "foo")
+			defer broker.close()
+			broker.latest = 3
+			broker.sasl = &SASL{Mechanism: mechanism, User: "user=,1", Password: "secret"}
+
+			// The test certificate has no SANs, so it can't be verified.
+			tlsConfig := &tls.Config{InsecureSkipVerify: true}
+			c, err := NewClient(Options{
+				Brokers: []string{broker.addr()},
+				TLS:     tlsConfig,
+				SASL:    &SASL{Mechanism: mechanism, User: "user=,1", Password: "secret"},
+			})
+			require.NoError(t, err)
+			defer c.Close()
+			latest, err := c.LatestOffset("foo", 0)
+			require.NoError(t, err)
+			require.Equal(t, int64(3), latest)
+
+			bad, err := NewClient(Options{
+				Brokers: []string{broker.addr()},
+				TLS:     tlsConfig,
+				SASL:    &SASL{Mechanism: mechanism, User: "user=,1", Password: "wrong"},
+			})
+			require.NoError(t, err)
+			defer bad.Close()
+			_, err = bad.Partitions("foo")
+			require.Error(t, err)
+		})
+	}
+}
+
+// Ensures NewClient rejects invalid SASL settings.
+func TestNewClientInvalidSASL(t *testing.T) {
+	_, err := NewClient(Options{Brokers: []string{"localhost:9092"}, SASL: &SASL{User: ""}})
+	require.Error(t, err)
+	_, err = NewClient(Options{Brokers: []string{"localhost:9092"},
+		SASL: &SASL{Mechanism: "GSSAPI", User: "user"}})
+	require.Error(t, err)
+}
@@ -0,0 +1,398 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/liftbridge-io/liftbridge/server/logger"
+)
+
+// Headers added to each imported message to record its origin.
+const (
+	HeaderTopic     = "kafka.topic"
+	HeaderPartition = "kafka.partition"
+	HeaderOffset    = "kafka.offset"
+	HeaderTimestamp = "kafka.timestamp"
+)
+
+// Source is a Kafka topic to import records from. It is implemented by
+// Client.
+type Source interface {
+	Partitions(topic string) ([]int32, error)
+	EarliestOffset(topic string, partition int32) (int64, error)
+	LatestOffset(topic string, partition int32) (int64, error)
+	Fetch(topic string, partition int32, offset int64) ([]Record, int64, error)
+	CommittedOffset(group, topic string, partition int32) (int64, error)
+}
+
+// ImportOptions contains settings for importing a Kafka topic.
+type ImportOptions struct {
+	// Topic is the Kafka topic to import.
+	Topic string
+
+	// Stream is the Liftbridge stream to import into. Kafka partition N is
+	// imported into stream partition N. If the stream does not exist, it is
+	// created with the same number of partitions as the topic.
+	Stream string
+
+	// Group is an optional Kafka consumer group. Its committed offsets are
+	// translated into Liftbridge cursors with the group name as the cursor
+	// ID so consumers can cut over to the stream where they left off.
+	Group string
+
+	// CursorID is the ID of the Liftbridge cursor used to checkpoint import
+	// progress. Each partition's cursor stores the last imported Kafka
+	// offset. Defaults to "kafka-import-<topic>".
+	CursorID string
+
+	// Follow keeps importing new records after catching up. Otherwise the
+	// import stops once it reaches the topic's end offsets as of the start
+	// of the import.
+	Follow bool
+
+	Logger logger.Logger
+}
+
+// Importer replays a Kafka topic into a Liftbridge stream. Progress is
+// checkpointed with Liftbridge cursors, so an interrupted import can be
+// resumed by running it again with the same options. Records are imported at
+// least once: records published after the last checkpoint may be imported
+// again when resuming.
+//
+// To translate consumer group offsets, the stream should not be published to
+// by anything other than the Importer until the group's offsets have been
+// translated.
+type Importer struct {
+	source Source
+	apis   []client.APIClient
+	opts   ImportOptions
+}
+
+// NewImporter creates an Importer which reads from the given source and
+// writes to the Liftbridge cluster using the given API clients. Requests
+// which must be served by a particular server, e.g. cursor operations, are
+// retried against each client until one succeeds, so a client should be
+// provided for every server in the cluster.
+func NewImporter(source Source, apis []client.APIClient, opts ImportOptions) (*Importer, error) {
+	if opts.Topic == "" {
+		return nil, errors.New("no topic provided")
+	}
+	if opts.Stream == "" {
+		return nil, errors.New("no stream provided")
+	}
+	if len(apis) == 0 {
+		return nil, errors.New("no Liftbridge API clients provided")
+	}
+	if opts.CursorID == "" {
+		opts.CursorID = "kafka-import-" + opts.Topic
+	}
+	if opts.Logger == nil {
+		opts.Logger = logger.NewNopLogger()
+	}
+	return &Importer{source: source, apis: apis, opts: opts}, nil
+}
+
+// Run imports all partitions of the topic concurrently. It returns when all
+// partitions have been imported, the context is canceled, or an error
+// occurs.
+func (i *Importer) Run(ctx context.Context) error {
+	partitions, err := i.source.Partitions(i.opts.Topic)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch topic partitions")
+	}
+	if err := i.ensureStream(ctx, int32(len(partitions))); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, partition := range partitions {
+		wg.Add(1)
+		go func(partition int32) {
+			defer wg.Done()
+			if err := i.importPartition(ctx, partition); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = errors.Wrapf(err, "failed to import partition %d", partition)
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(partition)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// ensureStream creates the stream if it does not exist and checks that it has
+// enough partitions for the topic.
+func (i *Importer) ensureStream(ctx context.Context, partitions int32) error {
+	_, err := i.apis[0].CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:    i.opts.Stream,
+		Name:       i.opts.Stream,
+		Partitions: partitions,
+	})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return errors.Wrap(err, "failed to create stream")
+	}
+	resp, err := i.apis[0].FetchMetadata(ctx, &client.FetchMetadataRequest{
+		Streams: []string{i.opts.Stream},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch stream metadata")
+	}
+	for _, stream := range resp.Metadata {
+		if stream.Name != i.opts.Stream {
+			continue
+		}
+		if n := int32(len(stream.Partitions)); n < partitions {
+			return fmt.Errorf("stream %s has %d partitions but topic %s has %d",
+				i.opts.Stream, n, i.opts.Topic, partitions)
+		}
+		return nil
+	}
+	return fmt.Errorf("stream %s does not exist", i.opts.Stream)
+}
+
+func (i *Importer) importPartition(ctx context.Context, partition int32) error {
+	var (
+		topic  = i.opts.Topic
+		stream = i.opts.Stream
+	)
+
+	// Resume from the last checkpoint, if any.
+	progress, err := i.fetchCursor(ctx, i.opts.CursorID, partition)
+	if err != nil {
+		return err
+	}
+	offset := progress + 1
+	if progress < 0 {
+		if offset, err = i.source.EarliestOffset(topic, partition); err != nil {
+			return errors.Wrap(err, "failed to fetch earliest offset")
+		}
+	}
+
+	groupOffset, lastLiftOffset, err := i.groupOffset(ctx, partition, offset)
+	if err != nil {
+		return err
+	}
+
+	end := int64(-1)
+	if !i.opts.Follow {
+		if end, err = i.source.LatestOffset(topic, partition); err != nil {
+			return errors.Wrap(err, "failed to fetch latest offset")
+		}
+	}
+
+	i.opts.Logger.Infof("Importing Kafka topic %s partition %d into stream %s from offset %d",
+		topic, partition, stream, offset)
+
+	imported := 0
+	for end < 0 || offset < end {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		records, next, err := i.source.Fetch(topic, partition, offset)
+		if err == ErrOffsetOutOfRange {
+			// Records were removed by retention since the last fetch.
+			if next, err = i.source.EarliestOffset(topic, partition); err != nil {
+				return errors.Wrap(err, "failed to fetch earliest offset")
+			}
+			i.opts.Logger.Warnf("Kafka offset %d for topic %s partition %d is out of range, "+
+				"resuming from %d", offset, topic, partition, next)
+			offset = next
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to fetch records")
+		}
+
+		for _, rec := range records {
+			liftOffset, err := i.publish(ctx, partition, rec)
+			if err != nil {
+				return err
+			}
+			if groupOffset < 0 {
+				continue
+			}
+			if rec.Offset < groupOffset {
+				lastLiftOffset = liftOffset
+			}
+			if rec.Offset+1 >= groupOffset {
+				if err := i.translateGroupOffset(ctx, partition, groupOffset, lastLiftOffset); err != nil {
+					return err
+				}
+				groupOffset = -1
+			}
+		}
+		if len(records) > 0 {
+			last := records[len(records)-1].Offset
+			if err := i.setCursor(ctx, i.opts.CursorID, partition, last); err != nil {
+				return err
+			}
+			imported += len(records)
+		}
+		offset = next
+	}
+
+	// The committed offset may be the end of the partition, in which case
+	// there is no record at it to trigger the translation.
+	if groupOffset >= 0 && offset >= groupOffset {
+		if err := i.translateGroupOffset(ctx, partition, groupOffset, lastLiftOffset); err != nil {
+			return err
+		}
+	}
+
+	i.opts.Logger.Infof("Imported %d records from Kafka topic %s partition %d into stream %s",
+		imported, topic, partition, stream)
+	return nil
+}
+
+// groupOffset returns the consumer group's committed offset for the partition
+// if it still needs to be translated, or -1 if it does not. It also returns
+// the Liftbridge offset to use for the group's cursor if the import has not
+// reached the committed offset yet.
+func (i *Importer) groupOffset(ctx context.Context, partition int32, offset int64) (int64, int64, error) {
+	if i.opts.Group == "" {
+		return -1, -1, nil
+	}
+	cursor, err := i.fetchCursor(ctx, i.opts.Group, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	if cursor >= 0 {
+		// Already translated.
+		return -1, -1, nil
+	}
+	committed, err := i.source.CommittedOffset(i.opts.Group, i.opts.Topic, partition)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to fetch consumer group offset")
+	}
+	if committed < 0 {
+		return -1, -1, nil
+	}
+	newest, err := i.newestOffset(ctx, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	if committed < offset {
+		// The import already passed the committed offset, so assume the last
+		// message in the stream is the last one consumed by the group.
+		if err := i.setCursor(ctx, i.opts.Group, partition, newest); err != nil {
+			return 0, 0, err
+		}
+		return -1, -1, nil
+	}
+	return committed, newest, nil
+}
+
+// translateGroupOffset sets the consumer group's cursor to the given stream
+// offset, which is the offset of the last message the group consumed.
+func (i *Importer) translateGroupOffset(ctx context.Context, partition int32, groupOffset, liftOffset int64) error {
+	if err := i.setCursor(ctx, i.opts.Group, partition, liftOffset); err != nil {
+		return err
+	}
+	i.opts.Logger.Infof("Translated consumer group %s offset %d for Kafka topic %s "+
+		"partition %d to stream %s offset %d", i.opts.Group, groupOffset, i.opts.Topic,
+		partition, i.opts.Stream, liftOffset)
+	return nil
+}
+
+func (i *Importer) publish(ctx context.Context, partition int32, rec Record) (int64, error) {
+	headers := make(map[string][]byte, len(rec.Headers)+4)
+	for _, header := range rec.Headers {
+		headers[header.Key] = header.Value
+	}
+	headers[HeaderTopic] = []byte(i.opts.Topic)
+	headers[HeaderPartition] = []byte(strconv.FormatInt(int64(partition), 10))
+	headers[HeaderOffset] = []byte(strconv.FormatInt(rec.Offset, 10))
+	headers[HeaderTimestamp] = []byte(strconv.FormatInt(rec.Timestamp, 10))
+
+	resp, err := i.apis[0].Publish(ctx, &client.PublishRequest{
+		Stream:    i.opts.Stream,
+		Partition: partition,
+		Key:       rec.Key,
+		Value:     rec.Value,
+		Headers:   headers,
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to publish Kafka offset %d", rec.Offset)
+	}
+	if resp.Ack == nil {
+		return 0, fmt.Errorf("no ack received for Kafka offset %d", rec.Offset)
+	}
+	if resp.Ack.AckError != client.Ack_OK {
+		return 0, fmt.Errorf("failed to publish Kafka offset %d: %s", rec.Offset, resp.Ack.AckError)
+	}
+	return resp.Ack.Offset, nil
+}
+
+func (i *Importer) fetchCursor(ctx context.Context, cursorID string, partition int32) (int64, error) {
+	var offset int64
+	err := i.call(func(api client.APIClient) error {
+		resp, err := api.FetchCursor(ctx, &client.FetchCursorRequest{
+			Stream:    i.opts.Stream,
+			Partition: partition,
+			CursorId:  cursorID,
+		})
+		if err == nil {
+			offset = resp.Offset
+		}
+		return err
+	})
+	return offset, errors.Wrapf(err, "failed to fetch cursor %s", cursorID)
+}
+
+func (i *Importer) setCursor(ctx context.Context, cursorID string, partition int32, offset int64) error {
+	err := i.call(func(api client.APIClient) error {
+		_, err := api.SetCursor(ctx, &client.SetCursorRequest{
+			Stream:    i.opts.Stream,
+			Partition: partition,
+			CursorId:  cursorID,
+			Offset:    offset,
+		})
+		return err
+	})
+	return errors.Wrapf(err, "failed to set cursor %s", cursorID)
+}
+
+func (i *Importer) newestOffset(ctx context.Context, partition int32) (int64, error) {
+	var offset int64
+	err := i.call(func(api client.APIClient) error {
+		resp, err := api.FetchPartitionMetadata(ctx, &client.FetchPartitionMetadataRequest{
+			Stream:    i.opts.Stream,
+			Partition: partition,
+		})
+		if err == nil {
+			offset = resp.Metadata.NewestOffset
+		}
+		return err
+	})
+	return offset, errors.Wrap(err, "failed to fetch partition metadata")
+}
+
+// call invokes fn with each API client until one is not rejected because the
+// server is not the leader for the request.
+func (i *Importer) call(fn func(client.APIClient) error) error {
+	var err error
+	for _, api := range i.apis {
+		err = fn(api)
+		if status.Code(err) != codes.FailedPrecondition {
+			return err
+		}
+	}
+	return err
+}
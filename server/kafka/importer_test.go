@@ -0,0 +1,230 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeSource struct {
+	records   []Record
+	committed int64
+	fetchSize int
+}
+
+func (f *fakeSource) Partitions(topic string) ([]int32, error) {
+	return []int32{0}, nil
+}
+
+func (f *fakeSource) EarliestOffset(topic string, partition int32) (int64, error) {
+	return f.records[0].Offset, nil
+}
+
+func (f *fakeSource) LatestOffset(topic string, partition int32) (int64, error) {
+	return f.records[len(f.records)-1].Offset + 1, nil
+}
+
+func (f *fakeSource) Fetch(topic string, partition int32, offset int64) ([]Record, int64, error) {
+	var records []Record
+	for _, rec := range f.records {
+		if rec.Offset >= offset && len(records) < f.fetchSize {
+			records = append(records, rec)
+		}
+	}
+	if len(records) == 0 {
+		return nil, offset, nil
+	}
+	return records, records[len(records)-1].Offset + 1, nil
+}
+
+func (f *fakeSource) CommittedOffset(group, topic string, partition int32) (int64, error) {
+	return f.committed, nil
+}
+
+// fakeAPI is a Liftbridge API client storing published messages and cursors
+// in memory. If notLeader is set, cursor and partition metadata requests are
+// rejected as if sent to a follower.
+type fakeAPI struct {
+	client.APIClient
+	mu        sync.Mutex
+	notLeader bool
+	messages  []*client.PublishRequest
+	cursors   map[string]int64
+	failAfter int
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{cursors: make(map[string]int64), failAfter: -1}
+}
+
+func (f *fakeAPI) CreateStream(ctx context.Context, in *client.CreateStreamRequest, opts ...grpc.CallOption) (*client.CreateStreamResponse, error) {
+	return nil, status.Error(codes.AlreadyExists, "stream exists")
+}
+
+func (f *fakeAPI) FetchMetadata(ctx context.Context, in *client.FetchMetadataRequest, opts ...grpc.CallOption) (*client.FetchMetadataResponse, error) {
+	return &client.FetchMetadataResponse{Metadata: []*client.StreamMetadata{{
+		Name:       in.Streams[0],
+		Partitions: map[int32]*client.PartitionMetadata{0: {}},
+	}}}, nil
+}
+
+func (f *fakeAPI) FetchPartitionMetadata(ctx context.Context, in *client.FetchPartitionMetadataRequest, opts ...grpc.CallOption) (*client.FetchPartitionMetadataResponse, error) {
+	if f.notLeader {
+		return nil, status.Error(codes.FailedPrecondition, "Server not partition leader")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &client.FetchPartitionMetadataResponse{Metadata: &client.PartitionMetadata{
+		NewestOffset: int64(len(f.messages) - 1),
+	}}, nil
+}
+
+func (f *fakeAPI) Publish(ctx context.Context, in *client.PublishRequest, opts ...grpc.CallOption) (*client.PublishResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failAfter == 0 {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	f.failAfter--
+	f.messages = append(f.messages, in)
+	return &client.PublishResponse{Ack: &client.Ack{Offset: int64(len(f.messages) - 1)}}, nil
+}
+
+func (f *fakeAPI) cursorKey(id string, partition int32) string {
+	return id + "/" + strconv.Itoa(int(partition))
+}
+
+func (f *fakeAPI) SetCursor(ctx context.Context, in *client.SetCursorRequest, opts ...grpc.CallOption) (*client.SetCursorResponse, error) {
+	if f.notLeader {
+		return nil, status.Error(codes.FailedPrecondition, "Server not leader for cursors partition")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cursors[f.cursorKey(in.CursorId, in.Partition)] = in.Offset
+	return &client.SetCursorResponse{}, nil
+}
+
+func (f *fakeAPI) FetchCursor(ctx context.Context, in *client.FetchCursorRequest, opts ...grpc.CallOption) (*client.FetchCursorResponse, error) {
+	if f.notLeader {
+		return nil, status.Error(codes.FailedPrecondition, "Server not leader for cursors partition")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	offset, ok := f.cursors[f.cursorKey(in.CursorId, in.Partition)]
+	if !ok {
+		offset = -1
+	}
+	return &client.FetchCursorResponse{Offset: offset}, nil
+}
+
+func newFakeSource(start, n int) *fakeSource {
+	source := &fakeSource{committed: -1, fetchSize: 3}
+	for i := 0; i < n; i++ {
+		offset := int64(start + i)
+		source.records = append(source.records, Record{
+			Offset:    offset,
+			Timestamp: 1000 + offset,
+			Value:     []byte(strconv.Itoa(int(offset))),
+			Headers:   []Header{{Key: "foo", Value: []byte("bar")}},
+		})
+	}
+	return source
+}
+
+// Ensures records are imported with origin headers and import progress and
+// the consumer group offset are checkpointed with cursors.
+func TestImporterRun(t *testing.T) {
+	source := newFakeSource(10, 8)
+	source.committed = 14
+	follower, leader := newFakeAPI(), newFakeAPI()
+	follower.notLeader = true
+
+	importer, err := NewImporter(source, []client.APIClient{leader, follower}, ImportOptions{
+		Topic:  "topic",
+		Stream: "stream",
+		Group:  "group",
+	})
+	require.NoError(t, err)
+	require.NoError(t, importer.Run(context.Background()))
+
+	require.Len(t, leader.messages, 8)
+	for i, msg := range leader.messages {
+		offset := strconv.Itoa(10 + i)
+		require.Equal(t, "stream", msg.Stream)
+		require.Equal(t, []byte(offset), msg.Value)
+		require.Equal(t, []byte(offset), msg.Headers[HeaderOffset])
+		require.Equal(t, []byte("topic"), msg.Headers[HeaderTopic])
+		require.Equal(t, []byte("0"), msg.Headers[HeaderPartition])
+		require.Equal(t, []byte("bar"), msg.Headers["foo"])
+		require.Equal(t, client.AckPolicy_ALL, msg.AckPolicy)
+	}
+	require.Equal(t, int64(17), leader.cursors["kafka-import-topic/0"])
+	// The group's next Kafka offset is 14, so it consumed Kafka offset 13,
+	// which is stream offset 3.
+	require.Equal(t, int64(3), leader.cursors["group/0"])
+}
+
+// Ensures an interrupted import resumes after the last checkpoint.
+func TestImporterResume(t *testing.T) {
+	source := newFakeSource(0, 7)
+	source.committed = 6
+	api := newFakeAPI()
+	api.failAfter = 4
+
+	opts := ImportOptions{Topic: "topic", Stream: "stream", Group: "group"}
+	importer, err := NewImporter(source, []client.APIClient{api}, opts)
+	require.NoError(t, err)
+	require.Error(t, importer.Run(context.Background()))
+	// The first batch of 3 was checkpointed, the second failed partway.
+	require.Equal(t, int64(2), api.cursors["kafka-import-topic/0"])
+	require.Len(t, api.messages, 4)
+
+	api.failAfter = -1
+	importer, err = NewImporter(source, []client.APIClient{api}, opts)
+	require.NoError(t, err)
+	require.NoError(t, importer.Run(context.Background()))
+	require.Equal(t, int64(6), api.cursors["kafka-import-topic/0"])
+
+	// Kafka offset 3 was imported twice since it was published after the
+	// last checkpoint.
+	require.Len(t, api.messages, 8)
+	require.Equal(t, []byte("3"), api.messages[3].Value)
+	require.Equal(t, []byte("3"), api.messages[4].Value)
+	// The group consumed Kafka offset 5, which is stream offset 6.
+	require.Equal(t, int64(6), api.cursors["group/0"])
+}
+
+// Ensures a consumer group at the end of the partition is translated to the
+// last imported message.
+func TestImporterGroupAtEnd(t *testing.T) {
+	source := newFakeSource(0, 3)
+	source.committed = 3
+	api := newFakeAPI()
+
+	importer, err := NewImporter(source, []client.APIClient{api}, ImportOptions{
+		Topic:  "topic",
+		Stream: "stream",
+		Group:  "group",
+	})
+	require.NoError(t, err)
+	require.NoError(t, importer.Run(context.Background()))
+	require.Equal(t, int64(2), api.cursors["group/0"])
+}
+
+// Ensures NewImporter validates its options.
+func TestNewImporterValidation(t *testing.T) {
+	apis := []client.APIClient{newFakeAPI()}
+	_, err := NewImporter(&fakeSource{}, apis, ImportOptions{Stream: "stream"})
+	require.Error(t, err)
+	_, err = NewImporter(&fakeSource{}, apis, ImportOptions{Topic: "topic"})
+	require.Error(t, err)
+	_, err = NewImporter(&fakeSource{}, nil, ImportOptions{Topic: "topic", Stream: "stream"})
+	require.Error(t, err)
+}
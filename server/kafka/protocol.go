@@ -0,0 +1,234 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Kafka API keys used by the Client.
+const (
+	apiKeyFetch            = 1
+	apiKeyListOffsets      = 2
+	apiKeyMetadata         = 3
+	apiKeyOffsetFetch      = 9
+	apiKeyFindCoordinator  = 10
+	apiKeySASLHandshake    = 17
+	apiKeySASLAuthenticate = 36
+)
+
+// isolationReadCommitted is the isolation level of requests which only read
+// records of committed transactions.
+const isolationReadCommitted = 1
+
+// Special timestamps for ListOffsets requests.
+const (
+	timestampLatest   = -1
+	timestampEarliest = -2
+)
+
+var errMalformed = errors.New("malformed kafka response")
+
+// Error is an error code returned by a Kafka broker.
+type Error int16
+
+// Error codes handled by the Client.
+const (
+	ErrNone                     Error = 0
+	ErrOffsetOutOfRange         Error = 1
+	ErrUnknownTopicOrPartition  Error = 3
+	ErrNotLeaderForPartition    Error = 6
+	ErrCoordinatorNotAvailable  Error = 15
+	ErrNotCoordinator           Error = 16
+	ErrUnsupportedSASLMechanism Error = 33
+	ErrIllegalSASLState         Error = 34
+	ErrSASLAuthenticationFailed Error = 58
+)
+
+func (e Error) Error() string {
+	switch e {
+	case ErrOffsetOutOfRange:
+		return "kafka: offset out of range"
+	case ErrUnknownTopicOrPartition:
+		return "kafka: unknown topic or partition"
+	case ErrNotLeaderForPartition:
+		return "kafka: not leader for partition"
+	case ErrCoordinatorNotAvailable:
+		return "kafka: coordinator not available"
+	case ErrNotCoordinator:
+		return "kafka: not coordinator"
+	case ErrUnsupportedSASLMechanism:
+		return "kafka: unsupported SASL mechanism"
+	case ErrIllegalSASLState:
+		return "kafka: illegal SASL state"
+	case ErrSASLAuthenticationFailed:
+		return "kafka: SASL authentication failed"
+	}
+	return fmt.Sprintf("kafka: error code %d", int16(e))
+}
+
+func errorFromCode(code int16) error {
+	if code == 0 {
+		return nil
+	}
+	return Error(code)
+}
+
+// encoder builds a Kafka request body. All integers are big endian.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) int8(v int8) {
+	e.buf = append(e.buf, byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	e.buf = append(e.buf, byte(v>>8), byte(v))
+}
+
+func (e *encoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// bytes writes int32 length-prefixed bytes.
+func (e *encoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) arrayLength(n int) {
+	e.int32(int32(n))
+}
+
+// decoder reads a Kafka response body. Reads past the end of the buffer set
+// err and return zero values, so callers only need to check err once after
+// decoding.
+type decoder struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (d *decoder) remaining() int {
+	return len(d.buf) - d.pos
+}
+
+func (d *decoder) need(n int) bool {
+	if d.err != nil {
+		return false
+	}
+	if n < 0 || d.remaining() < n {
+		d.err = errMalformed
+		return false
+	}
+	return true
+}
+
+func (d *decoder) int8() int8 {
+	if !d.need(1) {
+		return 0
+	}
+	v := int8(d.buf[d.pos])
+	d.pos++
+	return v
+}
+
+func (d *decoder) int16() int16 {
+	if !d.need(2) {
+		return 0
+	}
+	v := int16(binary.BigEndian.Uint16(d.buf[d.pos:]))
+	d.pos += 2
+	return v
+}
+
+func (d *decoder) int32() int32 {
+	if !d.need(4) {
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(d.buf[d.pos:]))
+	d.pos += 4
+	return v
+}
+
+func (d *decoder) int64() int64 {
+	if !d.need(8) {
+		return 0
+	}
+	v := int64(binary.BigEndian.Uint64(d.buf[d.pos:]))
+	d.pos += 8
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf[d.pos:])
+	if n <= 0 {
+		d.err = errMalformed
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) raw(n int) []byte {
+	if !d.need(n) {
+		return nil
+	}
+	v := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.raw(int(n)))
+}
+
+// bytes reads int32 length-prefixed bytes. A length of -1 is null.
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.raw(int(n))
+}
+
+// varBytes reads varint length-prefixed bytes. A length of -1 is null.
+func (d *decoder) varBytes() []byte {
+	n := d.varint()
+	if n < 0 {
+		return nil
+	}
+	return d.raw(int(n))
+}
+
+func (d *decoder) arrayLength() int {
+	n := int(d.int32())
+	// Each element is at least one byte, so anything larger than the
+	// remaining buffer is malformed.
+	if n > d.remaining() {
+		d.err = errMalformed
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,227 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+const (
+	// recordBatchOverhead is the size of the record batch header up to and
+	// including the magic byte.
+	recordBatchOverhead = 17
+
+	compressionMask        = 0x07
+	compressionNone        = 0
+	compressionGzip        = 1
+	compressionSnappy      = 2
+	compressionLZ4         = 3
+	compressionZstd        = 4
+	transactionalBatchFlag = 0x10
+	controlBatchFlag       = 0x20
+)
+
+// xerialSnappyMagic starts snappy-compressed data in the framing used by the
+// Java client. Other clients may write a single raw snappy block instead.
+var xerialSnappyMagic = []byte("\x82SNAPPY\x00")
+
+// Header is a Kafka record header.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Record is a message consumed from a Kafka partition.
+type Record struct {
+	Offset    int64
+	Timestamp int64 // Unix time in milliseconds
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+}
+
+// abortedTransaction is a transaction a fetch response reports as aborted.
+type abortedTransaction struct {
+	producerID  int64
+	firstOffset int64
+}
+
+// decodeRecordSet decodes the record batches in a fetch response record set.
+// Only message format v2 (Kafka 0.11+) is supported. Records before
+// fetchOffset, which may be returned because brokers return whole batches,
+// are dropped, as are control batches such as transaction markers and the
+// batches of the given aborted transactions. A truncated batch at the end of
+// the set is ignored as brokers may return partial batches.
+//
+// The returned next offset is the offset to fetch from next, which may be
+// past the last returned record if batches were skipped.
+func decodeRecordSet(data []byte, fetchOffset int64, aborted []abortedTransaction) ([]Record, int64, error) {
+	var (
+		records []Record
+		next    = fetchOffset
+		d       = &decoder{buf: data}
+		// Producers whose batches are currently part of an aborted
+		// transaction.
+		abortedProducers = make(map[int64]struct{})
+	)
+	aborted = append([]abortedTransaction(nil), aborted...)
+	sort.Slice(aborted, func(i, j int) bool { return aborted[i].firstOffset < aborted[j].firstOffset })
+	for d.remaining() >= recordBatchOverhead {
+		start := d.pos
+		baseOffset := d.int64()
+		batchLength := d.int32()
+		if d.remaining() < int(batchLength) {
+			// Partial batch.
+			break
+		}
+		batch := &decoder{buf: d.buf[start : d.pos+int(batchLength)]}
+		d.pos += int(batchLength)
+
+		batch.pos = 16 // Skip base offset, length, and leader epoch.
+		magic := batch.int8()
+		if magic != 2 {
+			return nil, 0, fmt.Errorf("unsupported kafka message format v%d", magic)
+		}
+		batch.int32() // CRC
+		attributes := batch.int16()
+		lastOffsetDelta := batch.int32()
+		firstTimestamp := batch.int64()
+		batch.int64() // Max timestamp
+		producerID := batch.int64()
+		batch.int16() // Producer epoch
+		batch.int32() // Base sequence
+		numRecords := batch.int32()
+		if batch.err != nil {
+			return nil, 0, batch.err
+		}
+
+		lastOffset := baseOffset + int64(lastOffsetDelta)
+		if lastOffset+1 > next {
+			next = lastOffset + 1
+		}
+		for len(aborted) > 0 && aborted[0].firstOffset <= lastOffset {
+			abortedProducers[aborted[0].producerID] = struct{}{}
+			aborted = aborted[1:]
+		}
+		if attributes&controlBatchFlag != 0 {
+			// A control batch is the marker ending the producer's
+			// transaction.
+			delete(abortedProducers, producerID)
+			continue
+		}
+		if attributes&transactionalBatchFlag != 0 {
+			if _, ok := abortedProducers[producerID]; ok {
+				continue
+			}
+		}
+
+		recordsData, err := decompress(attributes&compressionMask, batch.buf[batch.pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		rd := &decoder{buf: recordsData}
+		for i := int32(0); i < numRecords; i++ {
+			rd.varint() // Length
+			rd.int8()   // Attributes
+			timestampDelta := rd.varint()
+			offsetDelta := rd.varint()
+			rec := Record{
+				Offset:    baseOffset + offsetDelta,
+				Timestamp: firstTimestamp + timestampDelta,
+				Key:       rd.varBytes(),
+				Value:     rd.varBytes(),
+			}
+			numHeaders := rd.varint()
+			if numHeaders > int64(rd.remaining()) {
+				return nil, 0, errMalformed
+			}
+			for j := int64(0); j < numHeaders; j++ {
+				key := rd.varBytes()
+				rec.Headers = append(rec.Headers, Header{Key: string(key), Value: rd.varBytes()})
+			}
+			if rd.err != nil {
+				return nil, 0, rd.err
+			}
+			if rec.Offset >= fetchOffset {
+				records = append(records, rec)
+			}
+		}
+	}
+	return records, next, nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// decompress returns the records of a batch compressed with the given codec.
+func decompress(codec int16, data []byte) ([]byte, error) {
+	switch codec {
+	case compressionNone:
+		return data, nil
+	case compressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case compressionSnappy:
+		return decodeSnappy(data)
+	case compressionLZ4:
+		return ioutil.ReadAll(lz4.NewReader(bytes.NewReader(data)))
+	case compressionZstd:
+		zstdOnce.Do(func() {
+			zstdDecoder, zstdErr = zstd.NewReader(nil)
+		})
+		if zstdErr != nil {
+			return nil, zstdErr
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unsupported kafka compression codec %d", codec)
+	}
+}
+
+// decodeSnappy decodes snappy-compressed data which is either a single raw
+// block or in the xerial framing: the magic, a version and a compatible
+// version followed by length-prefixed blocks.
+func decodeSnappy(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, xerialSnappyMagic) {
+		return snappy.Decode(nil, data)
+	}
+	var (
+		out []byte
+		pos = len(xerialSnappyMagic) + 8 // Skip the versions.
+	)
+	if len(data) < pos {
+		return nil, errMalformed
+	}
+	for pos < len(data) {
+		if len(data)-pos < 4 {
+			return nil, errMalformed
+		}
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+		if n < 0 || len(data)-pos < n {
+			return nil, errMalformed
+		}
+		block, err := snappy.Decode(nil, data[pos:pos+n])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, block...)
+		pos += n
+	}
+	return out, nil
+}
@@ -0,0 +1,216 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func appendVarint(buf []byte, v int64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(scratch[:], v)
+	return append(buf, scratch[:n]...)
+}
+
+func appendVarBytes(buf, b []byte) []byte {
+	if b == nil {
+		return appendVarint(buf, -1)
+	}
+	buf = appendVarint(buf, int64(len(b)))
+	return append(buf, b...)
+}
+
+// encodeBatch encodes the given records as a v2 record batch starting at
+// baseOffset. Record offsets are ignored and assigned sequentially.
+func encodeBatch(t *testing.T, baseOffset int64, attributes int16, records []Record) []byte {
+	return encodeProducerBatch(t, baseOffset, attributes, -1, records)
+}
+
+// encodeProducerBatch encodes the given records as a v2 record batch written
+// by the given producer.
+func encodeProducerBatch(t *testing.T, baseOffset int64, attributes int16, producerID int64,
+	records []Record) []byte {
+
+	var recs []byte
+	for i, rec := range records {
+		var body []byte
+		body = append(body, 0) // Attributes
+		body = appendVarint(body, rec.Timestamp-records[0].Timestamp)
+		body = appendVarint(body, int64(i))
+		body = appendVarBytes(body, rec.Key)
+		body = appendVarBytes(body, rec.Value)
+		body = appendVarint(body, int64(len(rec.Headers)))
+		for _, header := range rec.Headers {
+			body = appendVarBytes(body, []byte(header.Key))
+			body = appendVarBytes(body, header.Value)
+		}
+		recs = appendVarint(recs, int64(len(body)))
+		recs = append(recs, body...)
+	}
+	recs = compress(t, attributes&compressionMask, recs)
+
+	var firstTimestamp int64
+	if len(records) > 0 {
+		firstTimestamp = records[0].Timestamp
+	}
+	e := &encoder{}
+	e.int64(baseOffset)
+	e.int32(0) // Length placeholder
+	e.int32(0) // Leader epoch
+	e.int8(2)  // Magic
+	e.int32(0) // CRC
+	e.int16(attributes)
+	e.int32(int32(len(records) - 1))
+	e.int64(firstTimestamp)
+	e.int64(firstTimestamp)
+	e.int64(producerID)
+	e.int16(-1) // Producer epoch
+	e.int32(-1) // Base sequence
+	e.int32(int32(len(records)))
+	e.buf = append(e.buf, recs...)
+	binary.BigEndian.PutUint32(e.buf[8:], uint32(len(e.buf)-12))
+	return e.buf
+}
+
+func compress(t *testing.T, codec int16, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	switch codec {
+	case compressionNone:
+		return data
+	case compressionGzip:
+		w := gzip.NewWriter(buf)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case compressionSnappy:
+		return snappy.Encode(nil, data)
+	case compressionLZ4:
+		w := lz4.NewWriter(buf)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case compressionZstd:
+		w, err := zstd.NewWriter(nil)
+		require.NoError(t, err)
+		return w.EncodeAll(data, nil)
+	default:
+		return data
+	}
+	return buf.Bytes()
+}
+
+// xerialSnappy compresses data with snappy in the xerial framing, splitting it
+// into blocks of the given size.
+func xerialSnappy(data []byte, blockSize int) []byte {
+	out := append([]byte(nil), xerialSnappyMagic...)
+	out = append(out, 0, 0, 0, 1, 0, 0, 0, 1) // Version and compatible version
+	for len(data) > 0 {
+		n := blockSize
+		if n > len(data) {
+			n = len(data)
+		}
+		block := snappy.Encode(nil, data[:n])
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(block)))
+		out = append(append(out, size[:]...), block...)
+		data = data[n:]
+	}
+	return out
+}
+
+// Ensures records are decoded from uncompressed and compressed batches.
+func TestDecodeRecordSet(t *testing.T) {
+	records := []Record{
+		{Timestamp: 100, Key: []byte("a"), Value: []byte("foo"),
+			Headers: []Header{{Key: "h", Value: []byte("v")}}},
+		{Timestamp: 105, Value: []byte("bar")},
+	}
+	codecs := []int16{compressionNone, compressionGzip, compressionSnappy, compressionLZ4, compressionZstd}
+	var data []byte
+	for i, codec := range codecs {
+		data = append(data, encodeBatch(t, int64(10+2*i), codec, records)...)
+	}
+
+	decoded, next, err := decodeRecordSet(data, 10, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(10+2*len(codecs)), next)
+	require.Len(t, decoded, 2*len(codecs))
+	for i, rec := range decoded {
+		require.Equal(t, int64(10+i), rec.Offset)
+		require.Equal(t, records[i%2].Timestamp, rec.Timestamp)
+		require.Equal(t, records[i%2].Key, rec.Key)
+		require.Equal(t, records[i%2].Value, rec.Value)
+		require.Equal(t, records[i%2].Headers, rec.Headers)
+	}
+}
+
+// Ensures records before the fetch offset, control batches, and partial
+// batches are skipped.
+func TestDecodeRecordSetSkips(t *testing.T) {
+	records := []Record{{Value: []byte("a")}, {Value: []byte("b")}, {Value: []byte("c")}}
+	data := encodeBatch(t, 0, compressionNone, records)
+	data = append(data, encodeBatch(t, 3, controlBatchFlag, records[:1])...)
+	partial := encodeBatch(t, 4, compressionNone, records)
+	data = append(data, partial[:len(partial)-5]...)
+
+	decoded, next, err := decodeRecordSet(data, 1, nil)
+	require.NoError(t, err)
+	require.Len(t, decoded, 2)
+	require.Equal(t, int64(1), decoded[0].Offset)
+	require.Equal(t, int64(2), decoded[1].Offset)
+	// The control batch is skipped but the next offset moves past it.
+	require.Equal(t, int64(4), next)
+}
+
+// Ensures snappy data in the xerial framing used by the Java client is
+// decoded.
+func TestDecodeSnappyXerial(t *testing.T) {
+	data := bytes.Repeat([]byte("liftbridge"), 100)
+	decoded, err := decodeSnappy(xerialSnappy(data, 64))
+	require.NoError(t, err)
+	require.Equal(t, data, decoded)
+
+	_, err = decodeSnappy(xerialSnappy(data, 64)[:20])
+	require.Error(t, err)
+}
+
+// Ensures records of aborted transactions are skipped while records of
+// committed transactions and other producers are decoded.
+func TestDecodeRecordSetAbortedTransactions(t *testing.T) {
+	records := []Record{{Value: []byte("a")}, {Value: []byte("b")}}
+	txn := int16(transactionalBatchFlag)
+	marker := int16(transactionalBatchFlag | controlBatchFlag)
+	var data []byte
+	// Producer 1 aborts its transaction at offsets 0-1 and commits the one at
+	// offsets 5-6. Producer 2 commits its transaction at offsets 2-3 which
+	// is interleaved with the aborted one.
+	data = append(data, encodeProducerBatch(t, 0, txn, 1, records)...)
+	data = append(data, encodeProducerBatch(t, 2, txn, 2, records)...)
+	data = append(data, encodeProducerBatch(t, 4, marker, 1, records[:1])...)
+	data = append(data, encodeProducerBatch(t, 5, txn, 1, records)...)
+	data = append(data, encodeProducerBatch(t, 7, marker, 1, records[:1])...)
+	data = append(data, encodeProducerBatch(t, 8, marker, 2, records[:1])...)
+	data = append(data, encodeBatch(t, 9, compressionNone, records[:1])...)
+
+	decoded, next, err := decodeRecordSet(data, 0, []abortedTransaction{{producerID: 1, firstOffset: 0}})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), next)
+	var offsets []int64
+	for _, rec := range decoded {
+		offsets = append(offsets, rec.Offset)
+	}
+	require.Equal(t, []int64{2, 3, 5, 6, 9}, offsets)
+}
+
+// Ensures unsupported compression codecs return an error.
+func TestDecodeRecordSetUnsupportedCompression(t *testing.T) {
+	data := encodeBatch(t, 0, 5, []Record{{Value: []byte("a")}})
+	_, _, err := decodeRecordSet(data, 0, nil)
+	require.Error(t, err)
+}
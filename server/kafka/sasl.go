@@ -0,0 +1,210 @@
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// SASL mechanisms supported by the Client.
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// SASL contains the credentials used to authenticate to brokers with SASL.
+type SASL struct {
+	Mechanism string // One of the supported mechanisms, defaults to SASLPlain
+	User      string
+	Password  string
+}
+
+func (s *SASL) validate() error {
+	switch s.Mechanism {
+	case "", SASLPlain, SASLScramSHA256, SASLScramSHA512:
+	default:
+		return fmt.Errorf("kafka: unsupported SASL mechanism %q", s.Mechanism)
+	}
+	if s.User == "" {
+		return errors.New("kafka: no SASL user provided")
+	}
+	return nil
+}
+
+func (s *SASL) mechanism() string {
+	if s.Mechanism == "" {
+		return SASLPlain
+	}
+	return s.Mechanism
+}
+
+// authenticate performs a SASL handshake and authentication on the broker's
+// connection. The broker's lock must be held.
+func (b *broker) authenticate() error {
+	mechanism := b.sasl.mechanism()
+	req := &encoder{}
+	req.string(mechanism)
+	resp, err := b.send(apiKeySASLHandshake, 1, req.buf)
+	if err != nil {
+		return err
+	}
+	d := &decoder{buf: resp}
+	code := d.int16()
+	for i, n := 0, d.arrayLength(); i < n; i++ {
+		d.string() // Enabled mechanism
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if err := errorFromCode(code); err != nil {
+		return err
+	}
+
+	switch mechanism {
+	case SASLPlain:
+		msg := "\x00" + b.sasl.User + "\x00" + b.sasl.Password
+		_, err := b.saslAuthenticate([]byte(msg))
+		return err
+	case SASLScramSHA256:
+		return b.scramAuthenticate(sha256.New)
+	default:
+		return b.scramAuthenticate(sha512.New)
+	}
+}
+
+// saslAuthenticate sends SASL authentication bytes to the broker and returns
+// its response bytes.
+func (b *broker) saslAuthenticate(auth []byte) ([]byte, error) {
+	req := &encoder{}
+	req.bytes(auth)
+	resp, err := b.send(apiKeySASLAuthenticate, 0, req.buf)
+	if err != nil {
+		return nil, err
+	}
+	d := &decoder{buf: resp}
+	code := d.int16()
+	msg := d.string()
+	auth = d.bytes()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := errorFromCode(code); err != nil {
+		if msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return auth, nil
+}
+
+// scramAuthenticate authenticates with SCRAM (RFC 5802) using the given hash.
+func (b *broker) scramAuthenticate(h func() hash.Hash) error {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	clientNonce := base64.RawStdEncoding.EncodeToString(nonce[:])
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(b.sasl.User)
+	clientFirstBare := "n=" + user + ",r=" + clientNonce
+
+	serverFirst, err := b.saslAuthenticate([]byte("n,," + clientFirstBare))
+	if err != nil {
+		return err
+	}
+	clientFinal, serverSignature, err := scramClientFinal(h, b.sasl.Password,
+		clientFirstBare, clientNonce, string(serverFirst))
+	if err != nil {
+		return err
+	}
+	serverFinal, err := b.saslAuthenticate([]byte(clientFinal))
+	if err != nil {
+		return err
+	}
+	attrs := scramAttributes(string(serverFinal))
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("kafka: SCRAM authentication failed: %s", e)
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil || !hmac.Equal(signature, serverSignature) {
+		return errors.New("kafka: invalid SCRAM server signature")
+	}
+	return nil
+}
+
+// scramClientFinal returns the client's final SCRAM message, which proves it
+// knows the password, in response to the server's first message along with
+// the signature the server must respond with.
+func scramClientFinal(h func() hash.Hash, password, clientFirstBare, clientNonce,
+	serverFirst string) (string, []byte, error) {
+
+	attrs := scramAttributes(serverFirst)
+	serverNonce, salt64, iterStr := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(serverNonce, clientNonce) || len(serverNonce) == len(clientNonce) {
+		return "", nil, errors.New("kafka: invalid SCRAM server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return "", nil, errors.New("kafka: invalid SCRAM salt")
+	}
+	iterations, err := strconv.Atoi(iterStr)
+	if err != nil || iterations < 1 {
+		return "", nil, errors.New("kafka: invalid SCRAM iteration count")
+	}
+
+	saltedPassword := scramHi(h, []byte(password), salt, iterations)
+	clientKey := scramHMAC(h, saltedPassword, []byte("Client Key"))
+	storedKey := h()
+	storedKey.Write(clientKey)
+	clientFinalBare := "c=biws,r=" + serverNonce
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + clientFinalBare)
+	proof := scramHMAC(h, storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	serverKey := scramHMAC(h, saltedPassword, []byte("Server Key"))
+	clientFinal := clientFinalBare + ",p=" + base64.StdEncoding.EncodeToString(proof)
+	return clientFinal, scramHMAC(h, serverKey, authMessage), nil
+}
+
+// scramAttributes parses the comma-separated key=value attributes of a SCRAM
+// message.
+func scramAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(msg, ",") {
+		if i := strings.IndexByte(attr, '='); i > 0 {
+			attrs[attr[:i]] = attr[i+1:]
+		}
+	}
+	return attrs
+}
+
+func scramHMAC(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// scramHi is the SCRAM Hi function, which is PBKDF2 with HMAC producing a
+// single block.
+func scramHi(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	var block [4]byte
+	binary.BigEndian.PutUint32(block[:], 1)
+	u := scramHMAC(h, password, append(append([]byte(nil), salt...), block[:]...))
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		u = scramHMAC(h, password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
@@ -0,0 +1,26 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensures the SCRAM client messages match the SCRAM-SHA-256 example exchange
+// in RFC 7677.
+func TestScramClientFinal(t *testing.T) {
+	clientFinal, signature, err := scramClientFinal(sha256.New, "pencil",
+		"n=user,r=rOprNGfwEbeRWgbNEkqO", "rOprNGfwEbeRWgbNEkqO",
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	require.NoError(t, err)
+	require.Equal(t, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,"+
+		"p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", clientFinal)
+	require.Equal(t, "6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+		base64.StdEncoding.EncodeToString(signature))
+
+	// The server nonce must extend the client nonce.
+	_, _, err = scramClientFinal(sha256.New, "pencil", "n=user,r=abc", "abc", "r=xyz,s=c2FsdA==,i=4096")
+	require.Error(t, err)
+}
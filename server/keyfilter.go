@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Subscribe request metadata keys used to filter messages by key. The
+// SubscribeRequest message has no fields for this, so key-scoped
+// subscriptions are requested with gRPC metadata.
+const (
+	// KeyFilterMetadata is a message key to subscribe to. It may be given
+	// multiple times.
+	KeyFilterMetadata = "liftbridge-key"
+
+	// KeyFilterBinMetadata is a binary message key to subscribe to. It may be
+	// given multiple times.
+	KeyFilterBinMetadata = "liftbridge-key-bin"
+
+	// KeyHashRangeMetadata is an inclusive range of key hashes to subscribe
+	// to, formatted as "<start>-<end>". Keys are hashed with CRC-32 (IEEE),
+	// the same hash used to partition messages by key.
+	KeyHashRangeMetadata = "liftbridge-key-hash-range"
+
+	// LatestPerKeyMetadata, if "true", causes the subscription to first
+	// receive only the latest message for each matching key up to the
+	// partition's high watermark, in offset order, before receiving new
+	// messages. This is useful for reading the current state of a compacted
+	// stream.
+	LatestPerKeyMetadata = "liftbridge-latest-per-key"
+)
+
+// keyFilter selects the messages a subscription receives by key.
+type keyFilter struct {
+	keys         map[string]struct{}
+	hashRange    bool
+	hashStart    uint32
+	hashEnd      uint32
+	latestPerKey bool
+}
+
+// keyFilterFromContext parses a keyFilter from the incoming request metadata.
+// It returns nil if the request does not filter by key.
+func keyFilterFromContext(ctx context.Context) (*keyFilter, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	var (
+		keys        = append(md.Get(KeyFilterMetadata), md.Get(KeyFilterBinMetadata)...)
+		hashRanges  = md.Get(KeyHashRangeMetadata)
+		latestFirst = md.Get(LatestPerKeyMetadata)
+	)
+	if len(keys) == 0 && len(hashRanges) == 0 && len(latestFirst) == 0 {
+		return nil, nil
+	}
+
+	filter := &keyFilter{}
+	if len(keys) > 0 {
+		filter.keys = make(map[string]struct{}, len(keys))
+		for _, key := range keys {
+			filter.keys[key] = struct{}{}
+		}
+	}
+	if len(hashRanges) > 0 {
+		if len(hashRanges) > 1 || len(keys) > 0 {
+			return nil, status.New(codes.InvalidArgument,
+				"Only one of a key set or a key hash range can be given")
+		}
+		start, end, err := parseHashRange(hashRanges[0])
+		if err != nil {
+			return nil, status.New(codes.InvalidArgument, err.Error())
+		}
+		filter.hashRange = true
+		filter.hashStart = start
+		filter.hashEnd = end
+	}
+	if len(latestFirst) > 0 {
+		latest, err := strconv.ParseBool(latestFirst[0])
+		if err != nil {
+			return nil, status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", LatestPerKeyMetadata, latestFirst[0]))
+		}
+		filter.latestPerKey = latest
+	}
+	return filter, nil
+}
+
+// parseHashRange parses an inclusive key hash range of the form
+// "<start>-<end>".
+func parseHashRange(hashRange string) (uint32, uint32, error) {
+	parts := strings.Split(hashRange, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid key hash range %q", hashRange)
+	}
+	start, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid key hash range %q", hashRange)
+	}
+	end, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid key hash range %q", hashRange)
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("Invalid key hash range %q: start is after end", hashRange)
+	}
+	return uint32(start), uint32(end), nil
+}
+
+// matches indicates if a message with the given key passes the filter. A nil
+// filter matches all messages.
+func (f *keyFilter) matches(key []byte) bool {
+	if f == nil {
+		return true
+	}
+	if f.keys != nil {
+		_, ok := f.keys[string(key)]
+		return ok
+	}
+	if f.hashRange {
+		hash := hasher(key)
+		return hash >= f.hashStart && hash <= f.hashEnd
+	}
+	return true
+}
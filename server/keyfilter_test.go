@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure keyFilterFromContext parses key filters from request metadata.
+func TestKeyFilterFromContext(t *testing.T) {
+	filter, st := keyFilterFromContext(context.Background())
+	require.Nil(t, st)
+	require.Nil(t, filter)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("foo", "bar"))
+	filter, st = keyFilterFromContext(ctx)
+	require.Nil(t, st)
+	require.Nil(t, filter)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		KeyFilterMetadata, "a",
+		KeyFilterMetadata, "b",
+		KeyFilterBinMetadata, "\x00c",
+		LatestPerKeyMetadata, "true",
+	))
+	filter, st = keyFilterFromContext(ctx)
+	require.Nil(t, st)
+	require.True(t, filter.latestPerKey)
+	require.True(t, filter.matches([]byte("a")))
+	require.True(t, filter.matches([]byte("b")))
+	require.True(t, filter.matches([]byte("\x00c")))
+	require.False(t, filter.matches([]byte("c")))
+	require.False(t, filter.matches(nil))
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		KeyHashRangeMetadata, "0-2147483647",
+	))
+	filter, st = keyFilterFromContext(ctx)
+	require.Nil(t, st)
+	require.False(t, filter.latestPerKey)
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		require.Equal(t, hasher(key) <= 2147483647, filter.matches(key))
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		LatestPerKeyMetadata, "true",
+	))
+	filter, st = keyFilterFromContext(ctx)
+	require.Nil(t, st)
+	require.True(t, filter.latestPerKey)
+	require.True(t, filter.matches([]byte("a")))
+	require.True(t, filter.matches(nil))
+}
+
+// Ensure keyFilterFromContext rejects invalid key filters.
+func TestKeyFilterFromContextInvalid(t *testing.T) {
+	for _, pairs := range [][]string{
+		{KeyFilterMetadata, "a", KeyHashRangeMetadata, "0-10"},
+		{KeyHashRangeMetadata, "0-10", KeyHashRangeMetadata, "20-30"},
+		{KeyHashRangeMetadata, "10"},
+		{KeyHashRangeMetadata, "10-5"},
+		{KeyHashRangeMetadata, "0-4294967296"},
+		{KeyHashRangeMetadata, "a-b"},
+		{LatestPerKeyMetadata, "maybe"},
+	} {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+		filter, st := keyFilterFromContext(ctx)
+		require.Nil(t, filter)
+		require.NotNil(t, st, pairs)
+		require.Equal(t, codes.InvalidArgument, st.Code())
+	}
+}
+
+// Ensure key-scoped subscriptions only receive messages with matching keys
+// and, when requested, the latest message for each key first.
+func TestSubscribeKeyFilter(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	publish := func(key, value string) {
+		req := &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(value),
+			AckPolicy: client.AckPolicy_ALL,
+		}
+		if key != "" {
+			req.Key = []byte(key)
+		}
+		_, err := api.Publish(ctx, req)
+		require.NoError(t, err)
+	}
+	publish("a", "a1") // 0
+	publish("b", "b1") // 1
+	publish("", "x")   // 2
+	publish("a", "a2") // 3
+	publish("c", "c1") // 4
+
+	subscribe := func(req *client.SubscribeRequest, pairs ...string) client.API_SubscribeClient {
+		subCtx := metadata.AppendToOutgoingContext(ctx, pairs...)
+		stream, err := api.Subscribe(subCtx, req)
+		require.NoError(t, err)
+		// Wait for the subscription to be created.
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		return stream
+	}
+	recv := func(stream client.API_SubscribeClient, offset int64, value string) {
+		msg, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, offset, msg.Offset)
+		require.Equal(t, value, string(msg.Value))
+	}
+
+	// Subscribe to keys a and c from the beginning.
+	stream := subscribe(&client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	}, KeyFilterMetadata, "a", KeyFilterMetadata, "c")
+	recv(stream, 0, "a1")
+	recv(stream, 3, "a2")
+	recv(stream, 4, "c1")
+
+	// Subscribe to the latest value of keys a and b, then new messages.
+	latest := subscribe(&client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	}, KeyFilterMetadata, "a", KeyFilterMetadata, "b", LatestPerKeyMetadata, "true")
+	recv(latest, 1, "b1")
+	recv(latest, 3, "a2")
+
+	publish("b", "b2") // 5
+	publish("c", "c2") // 6
+	publish("a", "a3") // 7
+	recv(stream, 6, "c2")
+	recv(stream, 7, "a3")
+	recv(latest, 5, "b2")
+	recv(latest, 7, "a3")
+
+	// Snapshot up to a stop offset.
+	bounded := subscribe(&client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+		StopPosition:  client.StopPosition_STOP_OFFSET,
+		StopOffset:    5,
+	}, LatestPerKeyMetadata, "true")
+	recv(bounded, 3, "a2")
+	recv(bounded, 4, "c1")
+	recv(bounded, 5, "b2")
+	_, err = bounded.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Invalid filters are rejected.
+	invalid, err := api.Subscribe(metadata.AppendToOutgoingContext(ctx,
+		KeyFilterMetadata, "a", KeyHashRangeMetadata, "0-10"),
+		&client.SubscribeRequest{Stream: "foo"})
+	require.NoError(t, err)
+	_, err = invalid.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
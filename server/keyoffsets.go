@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// ExpectedKeyOffsetHeader is the message header containing the offset of the
+// latest message with the same key the publisher expects the partition to
+// have, or -1 if it expects the key to have no messages. When concurrency
+// control is enabled for a stream, a partition leader rejects messages whose
+// expectation does not hold with an INCORRECT_OFFSET ack error. This allows
+// optimistic concurrency control per key, e.g. per event-sourced aggregate,
+// rather than only for the partition as a whole.
+const ExpectedKeyOffsetHeader = "Liftbridge-Expected-Key-Offset"
+
+// keyOffsets tracks the offset of the latest message for each key in a
+// partition. It is maintained by the partition leader when concurrency
+// control is enabled.
+type keyOffsets map[string]int64
+
+// get returns the offset of the latest message with the given key or -1 if
+// there is none.
+func (k keyOffsets) get(key string) int64 {
+	if offset, ok := k[key]; ok {
+		return offset
+	}
+	return -1
+}
+
+// check verifies the expected key offsets of messages which would be appended
+// to the log starting at the given offset. Messages earlier in the batch count
+// towards the offsets expected by later ones. It returns an error wrapping
+// commitlog.ErrIncorrectOffset if an expectation does not hold.
+func (k keyOffsets) check(msgs []*commitlog.Message, baseOffset int64) error {
+	if k == nil {
+		return nil
+	}
+	pending := make(map[string]int64)
+	for i, m := range msgs {
+		key := string(m.Key)
+		if header, ok := m.Headers[ExpectedKeyOffsetHeader]; ok {
+			expected, err := strconv.ParseInt(string(header), 10, 64)
+			if err != nil {
+				return errors.Wrapf(commitlog.ErrIncorrectOffset,
+					"invalid expected key offset %q", header)
+			}
+			if key == "" {
+				return errors.Wrap(commitlog.ErrIncorrectOffset,
+					"expected key offset given for message without key")
+			}
+			actual, ok := pending[key]
+			if !ok {
+				actual = k.get(key)
+			}
+			if actual != expected {
+				return errors.Wrapf(commitlog.ErrIncorrectOffset,
+					"key %q is at offset %d, expected %d", key, actual, expected)
+			}
+		}
+		if key != "" {
+			pending[key] = baseOffset + int64(i)
+		}
+	}
+	return nil
+}
+
+// appended records the offsets of keyed messages written to the log.
+func (k keyOffsets) appended(msgs []*commitlog.Message, offsets []int64) {
+	if k == nil {
+		return
+	}
+	for i, m := range msgs {
+		if len(m.Key) > 0 {
+			k[string(m.Key)] = offsets[i]
+		}
+	}
+}
+
+// loadKeyOffsets builds the index of latest offsets per key from the messages
+// in the log, so that a new leader checks expected key offsets against
+// messages published to the previous leader.
+func (p *partition) loadKeyOffsets() keyOffsets {
+	offsets := make(keyOffsets)
+	newest := p.log.NewestOffset()
+	if newest < 0 {
+		return offsets
+	}
+	reader, err := p.log.NewReader(p.log.OldestOffset(), true)
+	if err != nil {
+		p.srv.logger.Errorf("Failed to load key offsets for partition %s: %v", p, err)
+		return offsets
+	}
+	// Read control records, which have no key, so that the newest offset is
+	// reached even if it's one.
+	reader.SetReadControl(true)
+	headersBuf := make([]byte, 28)
+	for {
+		msg, offset, _, _, err := reader.ReadPooledMessage(context.Background(), headersBuf)
+		if err != nil {
+			p.srv.logger.Errorf("Failed to load key offsets for partition %s: %v", p, err)
+			return offsets
+		}
+		if key := msg.Key(); len(key) > 0 {
+			offsets[string(key)] = offset
+		}
+		commitlog.ReleaseMessage(msg)
+		if offset >= newest {
+			return offsets
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// keyMessage returns a message with the given key which expects the key's
+// latest message to be at the given offset, or expects nothing if it is
+// nil.
+func keyMessage(key string, expected []byte) *commitlog.Message {
+	msg := &commitlog.Message{Key: []byte(key), Headers: map[string][]byte{}}
+	if expected != nil {
+		msg.Headers[ExpectedKeyOffsetHeader] = expected
+	}
+	return msg
+}
+
+// Ensure keyOffsets checks expected key offsets against the latest offset of
+// each key, including earlier messages in the same batch.
+func TestKeyOffsetsCheck(t *testing.T) {
+	keys := keyOffsets{"a": 3}
+
+	require.NoError(t, keys.check([]*commitlog.Message{keyMessage("a", []byte("3"))}, 5))
+	require.NoError(t, keys.check([]*commitlog.Message{keyMessage("b", []byte("-1"))}, 5))
+	require.NoError(t, keys.check([]*commitlog.Message{keyMessage("a", nil)}, 5))
+	require.NoError(t, keys.check([]*commitlog.Message{
+		keyMessage("b", []byte("-1")),
+		keyMessage("b", []byte("5")),
+		keyMessage("a", []byte("3")),
+	}, 5))
+
+	for _, msgs := range [][]*commitlog.Message{
+		{keyMessage("a", []byte("2"))},
+		{keyMessage("a", []byte("-1"))},
+		{keyMessage("b", []byte("0"))},
+		{keyMessage("a", []byte("foo"))},
+		{keyMessage("", []byte("-1"))},
+		{keyMessage("a", nil), keyMessage("a", []byte("3"))},
+	} {
+		err := keys.check(msgs, 5)
+		require.True(t, errors.Is(err, commitlog.ErrIncorrectOffset))
+	}
+
+	keys.appended([]*commitlog.Message{keyMessage("a", nil), keyMessage("", nil)}, []int64{5, 6})
+	require.Equal(t, keyOffsets{"a": 5}, keys)
+
+	// A nil index checks nothing.
+	var none keyOffsets
+	require.NoError(t, none.check([]*commitlog.Message{keyMessage("a", []byte("2"))}, 5))
+	none.appended([]*commitlog.Message{keyMessage("a", nil)}, []int64{5})
+}
+
+// Ensure loadKeyOffsets rebuilds the latest offset of each key from the log.
+func TestPartitionLoadKeyOffsets(t *testing.T) {
+	defer cleanupStorage(t)
+
+	server := createServer()
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	p, err := server.newPartition(&proto.Partition{
+		Subject:  "foo",
+		Stream:   "foo",
+		Replicas: []string{"a"},
+		Leader:   "a",
+		Isr:      []string{"a"},
+	}, false, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	require.Empty(t, p.loadKeyOffsets())
+
+	_, err = p.log.Append([]*commitlog.Message{
+		keyMessage("a", nil),
+		keyMessage("b", nil),
+		keyMessage("", nil),
+		keyMessage("a", nil),
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, keyOffsets{"a": 3, "b": 1}, p.loadKeyOffsets())
+}
+
+// Ensure publishes to a stream with concurrency control are rejected if the
+// latest offset of their key does not match the expected key offset.
+func TestPublishExpectedKeyOffset(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:                      "foo",
+		Name:                         "foo",
+		OptimisticConcurrencyControl: &client.NullableBool{Value: true},
+	})
+	require.NoError(t, err)
+
+	publish := func(key, expected string) (int64, error) {
+		resp, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:         "foo",
+			Key:            []byte(key),
+			Value:          []byte("hello"),
+			Headers:        map[string][]byte{ExpectedKeyOffsetHeader: []byte(expected)},
+			AckPolicy:      client.AckPolicy_ALL,
+			ExpectedOffset: -1,
+		})
+		if err != nil {
+			return 0, err
+		}
+		return resp.Ack.Offset, nil
+	}
+
+	offset, err := publish("a", "-1")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+	offset, err = publish("b", "-1")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), offset)
+	offset, err = publish("a", "0")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+
+	// Concurrent writers expecting a stale offset are rejected.
+	_, err = publish("a", "0")
+	require.Error(t, err)
+	_, err = publish("b", "-1")
+	require.Error(t, err)
+
+	offset, err = publish("b", "1")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), offset)
+
+	partition := s1.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+	require.Equal(t, int64(3), partition.log.NewestOffset())
+}
@@ -0,0 +1,431 @@
+package liftbridgetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	gnatsd "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nuid"
+	"google.golang.org/grpc"
+
+	"github.com/liftbridge-io/liftbridge/server"
+)
+
+const defaultClusterSize = 3
+
+// ClusterOptions configures a test Cluster.
+type ClusterOptions struct {
+	// Brokers is the number of brokers in the cluster. Defaults to 3.
+	Brokers int
+
+	// NATSServers are the URLs of an existing NATS cluster to use. If empty,
+	// a NATS server is run in-process on a free port.
+	NATSServers []string
+
+	// Configure, if set, is called with the configuration of each broker
+	// before it is first started so that any other setting can be changed.
+	// Brokers are numbered from 0 and broker 0 bootstraps the cluster.
+	Configure func(broker int, config *server.Config)
+
+	// Logging enables server logs.
+	Logging bool
+
+	// StartTimeout is the maximum time to wait for the cluster to elect a
+	// metadata leader. It's also the default timeout of the Cluster's wait
+	// methods. Defaults to 10 seconds.
+	StartTimeout time.Duration
+}
+
+// Cluster is a multi-node Liftbridge cluster running in-process and, unless
+// an external NATS cluster is used, the NATS server its brokers connect to.
+// Brokers and the NATS server can be stopped and started again to inject
+// faults.
+type Cluster struct {
+	mu       sync.Mutex
+	opts     ClusterOptions
+	brokers  []*Broker
+	nats     *gnatsd.Server
+	natsOpts *gnatsd.Options
+	tempDir  string
+	stopped  bool
+}
+
+// Broker is a broker of a test Cluster.
+type Broker struct {
+	mu     sync.Mutex
+	config *server.Config
+	server *server.Server
+}
+
+// StartCluster runs a Cluster with the given options and waits for it to
+// elect a metadata leader. Each broker stores its data in its own temporary
+// directory, which is removed when the Cluster is stopped, and listens on a
+// free port on the loopback interface.
+func StartCluster(opts ClusterOptions) (*Cluster, error) {
+	if opts.Brokers == 0 {
+		opts.Brokers = defaultClusterSize
+	}
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = defaultStartTimeout
+	}
+	dir, err := ioutil.TempDir("", "liftbridgetest_")
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{opts: opts, tempDir: dir}
+
+	natsServers := opts.NATSServers
+	if len(natsServers) == 0 {
+		c.natsOpts = &gnatsd.Options{
+			Host:   "127.0.0.1",
+			Port:   gnatsd.RANDOM_PORT,
+			NoLog:  true,
+			NoSigs: true,
+		}
+		if err := c.StartNATS(); err != nil {
+			c.Stop()
+			return nil, err
+		}
+		natsServers = []string{c.nats.ClientURL()}
+	}
+
+	// Use a unique namespace so clusters sharing a NATS cluster are isolated.
+	namespace := "liftbridgetest-" + nuid.Next()
+	for i := 0; i < opts.Brokers; i++ {
+		id := strconv.Itoa(i)
+		config := server.NewDefaultConfig()
+		config.DataDir = filepath.Join(dir, id)
+		config.Listen = server.HostPort{Host: "127.0.0.1"}
+		config.NATS.Servers = natsServers
+		config.Clustering.ServerID = id
+		config.Clustering.Namespace = namespace
+		config.Clustering.RaftBootstrapSeed = i == 0
+		config.LogSilent = !opts.Logging
+		if opts.Configure != nil {
+			opts.Configure(i, config)
+		}
+		c.brokers = append(c.brokers, &Broker{config: config})
+	}
+	for _, broker := range c.brokers {
+		if err := broker.start(); err != nil {
+			c.Stop()
+			return nil, err
+		}
+	}
+	if _, err := c.WaitForMetadataLeader(opts.StartTimeout); err != nil {
+		c.Stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+// RunCluster starts a Cluster for the duration of a test. The test fails
+// immediately if the Cluster cannot be started, and the Cluster is stopped
+// when the test and its subtests complete.
+func RunCluster(t testing.TB, opts ClusterOptions) *Cluster {
+	t.Helper()
+	c, err := StartCluster(opts)
+	if err != nil {
+		t.Fatalf("Failed to start Liftbridge cluster: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := c.Stop(); err != nil {
+			t.Errorf("Failed to stop Liftbridge cluster: %v", err)
+		}
+	})
+	return c
+}
+
+// Brokers returns the brokers of the cluster.
+func (c *Cluster) Brokers() []*Broker {
+	return c.brokers
+}
+
+// Broker returns the broker with the given server ID, or nil if there is no
+// such broker.
+func (c *Cluster) Broker(id string) *Broker {
+	for _, broker := range c.brokers {
+		if broker.ID() == id {
+			return broker
+		}
+	}
+	return nil
+}
+
+// Addrs returns the host and port of each running broker for clients to
+// connect to.
+func (c *Cluster) Addrs() []string {
+	var addrs []string
+	for _, broker := range c.brokers {
+		if broker.Running() {
+			addrs = append(addrs, broker.Addr())
+		}
+	}
+	return addrs
+}
+
+// NATSURL returns the URL of the in-process NATS server, or an empty string if
+// an external NATS cluster is used.
+func (c *Cluster) NATSURL() string {
+	if c.natsOpts == nil {
+		return ""
+	}
+	return fmt.Sprintf("nats://%s:%d", c.natsOpts.Host, c.natsOpts.Port)
+}
+
+// StartNATS starts the in-process NATS server again after StopNATS, on the
+// same port. Brokers reconnect to it on their own.
+func (c *Cluster) StartNATS() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.natsOpts == nil {
+		return errors.New("cluster uses an external NATS cluster")
+	}
+	if c.nats != nil {
+		return nil
+	}
+	ns, err := gnatsd.NewServer(c.natsOpts)
+	if err != nil {
+		return err
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(c.opts.StartTimeout) {
+		ns.Shutdown()
+		return errors.New("unable to start NATS server")
+	}
+	// Keep the chosen port across restarts so brokers can reconnect.
+	c.natsOpts.Port = ns.Addr().(*net.TCPAddr).Port
+	c.nats = ns
+	return nil
+}
+
+// StopNATS shuts down the in-process NATS server, which cuts the brokers off
+// from each other and from NATS clients until StartNATS is called.
+func (c *Cluster) StopNATS() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.natsOpts == nil {
+		return errors.New("cluster uses an external NATS cluster")
+	}
+	if c.nats != nil {
+		c.nats.Shutdown()
+		c.nats = nil
+	}
+	return nil
+}
+
+// StopBroker stops the broker with the given server ID without handing off
+// its leaderships, as if it had crashed. Its data is kept so that it can be
+// started again with StartBroker.
+func (c *Cluster) StopBroker(id string) error {
+	broker := c.Broker(id)
+	if broker == nil {
+		return fmt.Errorf("no broker %s", id)
+	}
+	return broker.stop()
+}
+
+// StartBroker starts the broker with the given server ID again after
+// StopBroker, on the same port and with the same data.
+func (c *Cluster) StartBroker(id string) error {
+	broker := c.Broker(id)
+	if broker == nil {
+		return fmt.Errorf("no broker %s", id)
+	}
+	c.mu.Lock()
+	stopped := c.stopped
+	c.mu.Unlock()
+	if stopped {
+		return errors.New("cluster stopped")
+	}
+	return broker.start()
+}
+
+// WaitForMetadataLeader waits until a running broker is the metadata leader
+// and returns it. A timeout of 0 uses the cluster's start timeout.
+func (c *Cluster) WaitForMetadataLeader(timeout time.Duration) (*Broker, error) {
+	var leader *Broker
+	err := c.waitFor(timeout, "metadata leader", func() bool {
+		leader = c.metadataLeader()
+		return leader != nil
+	})
+	return leader, err
+}
+
+// WaitForPartitionLeader waits until the given partition has a running
+// leader and returns it. A timeout of 0 uses the cluster's start timeout.
+func (c *Cluster) WaitForPartitionLeader(stream string, partition int32, timeout time.Duration) (*Broker, error) {
+	var leader *Broker
+	err := c.waitFor(timeout, fmt.Sprintf("leader of partition %d of stream %s", partition, stream), func() bool {
+		metadata := c.partitionMetadata(stream, partition)
+		if metadata == nil {
+			return false
+		}
+		leader = c.Broker(metadata.Leader)
+		return leader != nil && leader.Running()
+	})
+	return leader, err
+}
+
+// WaitForISR waits until the given partition has the given number of in-sync
+// replicas. A timeout of 0 uses the cluster's start timeout.
+func (c *Cluster) WaitForISR(stream string, partition int32, size int, timeout time.Duration) error {
+	return c.waitFor(timeout, fmt.Sprintf("%d in-sync replicas of partition %d of stream %s", size, partition, stream), func() bool {
+		metadata := c.partitionMetadata(stream, partition)
+		return metadata != nil && len(metadata.Isr) == size
+	})
+}
+
+// Stop shuts down the brokers and in-process NATS server and removes the
+// brokers' data. It is safe to call Stop more than once.
+func (c *Cluster) Stop() error {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil
+	}
+	c.stopped = true
+	c.mu.Unlock()
+
+	var err error
+	for _, broker := range c.brokers {
+		if stopErr := broker.stop(); err == nil {
+			err = stopErr
+		}
+	}
+	if c.natsOpts != nil {
+		c.StopNATS()
+	}
+	if rmErr := os.RemoveAll(c.tempDir); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// metadataLeader returns the running broker which is the metadata leader, if
+// any.
+func (c *Cluster) metadataLeader() *Broker {
+	for _, broker := range c.brokers {
+		if s := broker.Server(); s != nil && s.IsRunning() && s.IsLeader() {
+			return broker
+		}
+	}
+	return nil
+}
+
+// partitionMetadata fetches the metadata of the given partition from the
+// metadata leader. It returns nil if it can't be fetched.
+func (c *Cluster) partitionMetadata(stream string, partition int32) *client.PartitionMetadata {
+	leader := c.metadataLeader()
+	if leader == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, leader.Addr(), grpc.WithInsecure())
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	resp, err := client.NewAPIClient(conn).FetchMetadata(ctx, &client.FetchMetadataRequest{
+		Streams: []string{stream},
+	})
+	if err != nil {
+		return nil
+	}
+	for _, metadata := range resp.Metadata {
+		if metadata.Name == stream {
+			return metadata.Partitions[partition]
+		}
+	}
+	return nil
+}
+
+// waitFor polls the condition until it's true or the timeout elapses.
+func (c *Cluster) waitFor(timeout time.Duration, what string, condition func() bool) error {
+	if timeout == 0 {
+		timeout = c.opts.StartTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// ID returns the server ID of the broker.
+func (b *Broker) ID() string {
+	return b.config.Clustering.ServerID
+}
+
+// Addr returns the host and port clients should connect to.
+func (b *Broker) Addr() string {
+	return net.JoinHostPort(b.config.Listen.Host, strconv.Itoa(b.Port()))
+}
+
+// Port returns the port the client API listens on.
+func (b *Broker) Port() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.config.Listen.Port
+}
+
+// Config returns the configuration the broker is started with.
+func (b *Broker) Config() *server.Config {
+	return b.config
+}
+
+// Server returns the underlying Liftbridge server, or nil if the broker is
+// stopped.
+func (b *Broker) Server() *server.Server {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.server
+}
+
+// Running indicates if the broker is running.
+func (b *Broker) Running() bool {
+	s := b.Server()
+	return s != nil && s.IsRunning()
+}
+
+func (b *Broker) start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.server != nil {
+		return nil
+	}
+	s := server.New(b.config)
+	if err := s.Start(); err != nil {
+		s.Stop()
+		return fmt.Errorf("failed to start broker %s: %v", b.ID(), err)
+	}
+	// Keep the chosen port across restarts so clients can reconnect.
+	b.config.Listen.Port = s.GetListenPort()
+	b.server = s
+	return nil
+}
+
+func (b *Broker) stop() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.server == nil {
+		return nil
+	}
+	err := b.server.Stop()
+	b.server = nil
+	return err
+}
@@ -0,0 +1,95 @@
+package liftbridgetest
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/liftbridge-io/liftbridge/server"
+)
+
+// Ensures a partition of a Cluster fails over when its leader is stopped and
+// the stopped broker rejoins the ISR once it's started again.
+func TestClusterFailover(t *testing.T) {
+	configured := 0
+	c := RunCluster(t, ClusterOptions{
+		Configure: func(broker int, config *server.Config) {
+			configured++
+			config.Clustering.ReplicaMaxLeaderTimeout = time.Second
+		},
+	})
+	require.Equal(t, 3, configured)
+	require.Len(t, c.Brokers(), 3)
+	require.Len(t, c.Addrs(), 3)
+
+	conn, err := grpc.Dial(c.Addrs()[0], grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.NewAPIClient(conn).CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.WaitForISR("foo", 0, 3, 0))
+
+	old, err := c.WaitForPartitionLeader("foo", 0, 0)
+	require.NoError(t, err)
+	port := old.Port()
+	require.NoError(t, c.StopBroker(old.ID()))
+	require.False(t, old.Running())
+	require.Len(t, c.Addrs(), 2)
+	leader, err := c.WaitForPartitionLeader("foo", 0, 0)
+	require.NoError(t, err)
+	require.NotEqual(t, old.ID(), leader.ID())
+
+	require.NoError(t, c.StartBroker(old.ID()))
+	require.True(t, old.Running())
+	require.Equal(t, port, old.Port())
+	require.NoError(t, c.WaitForISR("foo", 0, 3, 0))
+
+	require.Error(t, c.StopBroker("foo"))
+}
+
+// Ensures brokers reconnect once the NATS server is started again and that
+// stopping a Cluster removes its data.
+func TestClusterRestartNATS(t *testing.T) {
+	c, err := StartCluster(ClusterOptions{Brokers: 1})
+	require.NoError(t, err)
+	url := c.NATSURL()
+	require.NotEmpty(t, url)
+	dataDir := c.Brokers()[0].Config().DataDir
+
+	require.NoError(t, c.StopNATS())
+	require.NoError(t, c.StartNATS())
+	require.Equal(t, url, c.NATSURL())
+
+	conn, err := grpc.Dial(c.Addrs()[0], grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.Eventually(t, func() bool {
+		_, err := client.NewAPIClient(conn).CreateStream(ctx, &client.CreateStreamRequest{
+			Subject: "foo",
+			Name:    "foo",
+		})
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	leader, err := c.WaitForPartitionLeader("foo", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, c.Brokers()[0], leader)
+
+	require.NoError(t, c.Stop())
+	require.NoError(t, c.Stop())
+	_, err = os.Stat(dataDir)
+	require.True(t, os.IsNotExist(err))
+	require.Error(t, c.StartBroker(leader.ID()))
+}
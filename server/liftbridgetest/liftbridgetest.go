@@ -0,0 +1,248 @@
+// Package liftbridgetest runs Liftbridge servers inside a Go process so
+// application tests can exercise real brokers without external
+// infrastructure. Run starts a single-node server and RunCluster a multi-node
+// cluster whose brokers and NATS server can be stopped and started again to
+// test clustering behavior.
+//
+//	func TestPublish(t *testing.T) {
+//		s := liftbridgetest.Run(t, liftbridgetest.Options{})
+//		client, err := lift.Connect([]string{s.Addr()})
+//		...
+//	}
+package liftbridgetest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	gnatsd "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nuid"
+
+	"github.com/liftbridge-io/liftbridge/server"
+)
+
+const defaultStartTimeout = 10 * time.Second
+
+// Options configures a test Server.
+type Options struct {
+	// DataDir is the directory to store data in. If empty, a temporary
+	// directory is created and removed when the Server is stopped.
+	DataDir string
+
+	// Port is the port the client API listens on. If 0, a free port is
+	// chosen. The API always listens on the loopback interface.
+	Port int
+
+	// NATSServers are the URLs of an existing NATS cluster to use. If empty,
+	// a NATS server is run in-process on a free port.
+	NATSServers []string
+
+	// Configure, if set, is called with the server configuration before the
+	// server is started so that any other setting can be changed.
+	Configure func(*server.Config)
+
+	// Logging enables server logs.
+	Logging bool
+
+	// StartTimeout is the maximum time to wait for the server to become
+	// ready. Defaults to 10 seconds.
+	StartTimeout time.Duration
+}
+
+// Server is a single-node Liftbridge server and, unless an external NATS
+// cluster is used, the NATS server it connects to.
+type Server struct {
+	mu      sync.Mutex
+	opts    Options
+	config  *server.Config
+	server  *server.Server
+	nats    *gnatsd.Server
+	tempDir string
+	stopped bool
+}
+
+// Start runs a Server with the given options and waits for it to be ready to
+// serve requests.
+func Start(opts Options) (*Server, error) {
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = defaultStartTimeout
+	}
+	s := &Server{opts: opts}
+
+	dataDir := opts.DataDir
+	if dataDir == "" {
+		dir, err := ioutil.TempDir("", "liftbridgetest_")
+		if err != nil {
+			return nil, err
+		}
+		s.tempDir = dir
+		dataDir = dir
+	}
+
+	natsServers := opts.NATSServers
+	if len(natsServers) == 0 {
+		if err := s.startNATS(); err != nil {
+			s.Stop()
+			return nil, err
+		}
+		natsServers = []string{s.nats.ClientURL()}
+	}
+
+	config := server.NewDefaultConfig()
+	config.DataDir = dataDir
+	config.Listen = server.HostPort{Host: "127.0.0.1", Port: opts.Port}
+	config.NATS.Servers = natsServers
+	// Use a unique namespace so servers sharing a NATS cluster are isolated.
+	config.Clustering.Namespace = "liftbridgetest-" + nuid.Next()
+	config.Clustering.RaftBootstrapSeed = true
+	config.LogSilent = !opts.Logging
+	if opts.Configure != nil {
+		opts.Configure(config)
+	}
+	s.config = config
+
+	if err := s.startServer(); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Run starts a Server for the duration of a test. The test fails immediately
+// if the Server cannot be started, and the Server is stopped when the test
+// and its subtests complete.
+func Run(t testing.TB, opts Options) *Server {
+	t.Helper()
+	s, err := Start(opts)
+	if err != nil {
+		t.Fatalf("Failed to start Liftbridge server: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := s.Stop(); err != nil {
+			t.Errorf("Failed to stop Liftbridge server: %v", err)
+		}
+	})
+	return s
+}
+
+// startNATS runs an in-process NATS server on a free port.
+func (s *Server) startNATS() error {
+	ns, err := gnatsd.NewServer(&gnatsd.Options{
+		Host:   "127.0.0.1",
+		Port:   gnatsd.RANDOM_PORT,
+		NoLog:  true,
+		NoSigs: true,
+	})
+	if err != nil {
+		return err
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(s.opts.StartTimeout) {
+		ns.Shutdown()
+		return errors.New("unable to start NATS server")
+	}
+	s.nats = ns
+	return nil
+}
+
+// startServer starts the Liftbridge server and waits for it to become the
+// metadata leader.
+func (s *Server) startServer() error {
+	s.server = server.New(s.config)
+	if err := s.server.Start(); err != nil {
+		return err
+	}
+	// Keep the chosen port across restarts so clients can reconnect.
+	s.config.Listen.Port = s.server.GetListenPort()
+
+	deadline := time.Now().Add(s.opts.StartTimeout)
+	for !s.server.IsLeader() {
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for server to become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// Addr returns the host and port clients should connect to.
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.config.Listen.Host, strconv.Itoa(s.Port()))
+}
+
+// Port returns the port the client API listens on.
+func (s *Server) Port() int {
+	return s.config.Listen.Port
+}
+
+// NATSURL returns the URL of the in-process NATS server, or an empty string if
+// an external NATS cluster is used.
+func (s *Server) NATSURL() string {
+	if s.nats == nil {
+		return ""
+	}
+	return s.nats.ClientURL()
+}
+
+// Config returns the configuration the server was started with.
+func (s *Server) Config() *server.Config {
+	return s.config
+}
+
+// Server returns the underlying Liftbridge server.
+func (s *Server) Server() *server.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.server
+}
+
+// Restart stops the Liftbridge server and starts it again with the same data
+// directory, address, and NATS server. This can be used to test client
+// reconnects and recovery of persisted data.
+func (s *Server) Restart() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return errors.New("server stopped")
+	}
+	if err := s.server.Stop(); err != nil {
+		return err
+	}
+	if err := s.startServer(); err != nil {
+		return fmt.Errorf("failed to restart server: %v", err)
+	}
+	return nil
+}
+
+// Stop shuts down the Liftbridge server and in-process NATS server and
+// removes the temporary data directory, if one was created. It is safe to call
+// Stop more than once.
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+
+	var err error
+	if s.server != nil {
+		err = s.server.Stop()
+	}
+	if s.nats != nil {
+		s.nats.Shutdown()
+	}
+	if s.tempDir != "" {
+		if rmErr := os.RemoveAll(s.tempDir); err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package liftbridgetest
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/liftbridge-io/liftbridge/server"
+)
+
+// Ensures a Server can be started, serves the API, keeps its data across
+// restarts, and cleans up its temporary data directory when stopped.
+func TestServerLifecycle(t *testing.T) {
+	s, err := Start(Options{})
+	require.NoError(t, err)
+	require.NotZero(t, s.Port())
+	require.NotEmpty(t, s.NATSURL())
+	dataDir := s.Config().DataDir
+
+	conn, err := grpc.Dial(s.Addr(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject: "foo",
+		Name:    "foo",
+	})
+	require.NoError(t, err)
+	resp, err := api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("hello"),
+		AckPolicy: client.AckPolicy_LEADER,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), resp.Ack.Offset)
+
+	port := s.Port()
+	require.NoError(t, s.Restart())
+	require.Equal(t, port, s.Port())
+
+	// The stream is recovered from the data directory.
+	require.Eventually(t, func() bool {
+		meta, err := api.FetchPartitionMetadata(ctx, &client.FetchPartitionMetadataRequest{
+			Stream: "foo",
+		})
+		return err == nil && meta.Metadata.NewestOffset == 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Stop())
+	require.NoError(t, s.Stop())
+	_, err = os.Stat(dataDir)
+	require.True(t, os.IsNotExist(err))
+	require.Error(t, s.Restart())
+}
+
+// Ensures Run applies custom configuration and stops the Server when the test
+// completes.
+func TestRun(t *testing.T) {
+	var s *Server
+	t.Run("run", func(t *testing.T) {
+		s = Run(t, Options{
+			Configure: func(config *server.Config) {
+				config.Streams.SegmentMaxBytes = 1024
+			},
+		})
+		require.Equal(t, int64(1024), s.Config().Streams.SegmentMaxBytes)
+		require.True(t, s.Server().IsRunning())
+	})
+	require.False(t, s.Server().IsRunning())
+}
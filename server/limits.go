@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// LimitExceededMetadata is the trailer set on requests rejected because a
+// limit configured on the server was reached. Its value identifies the limit,
+// i.e. LimitConnections, LimitConnectionSubscriptions or
+// LimitStreamSubscriptions, so clients can tell them apart.
+const LimitExceededMetadata = "liftbridge-limit-exceeded"
+
+const (
+	// LimitConnections is the value of LimitExceededMetadata on requests made
+	// over a connection accepted while the server had connection.max clients
+	// connected.
+	LimitConnections = "connections"
+
+	// LimitConnectionSubscriptions is the value of LimitExceededMetadata on
+	// subscriptions rejected because their connection already has
+	// subscription.max.per.connection subscriptions.
+	LimitConnectionSubscriptions = "connection-subscriptions"
+
+	// LimitStreamSubscriptions is the value of LimitExceededMetadata on
+	// subscriptions rejected because their stream already has
+	// subscription.max.per.stream subscriptions on the server.
+	LimitStreamSubscriptions = "stream-subscriptions"
+)
+
+// connectionRejectGrace is how long a connection accepted over the connection
+// limit is kept open so the client sees its requests rejected with
+// LimitConnections rather than a closed connection.
+const connectionRejectGrace = time.Second
+
+// subscriptionLimits limits the number of subscriptions per client connection
+// and per stream. A limit of 0 or less is unlimited.
+type subscriptionLimits struct {
+	maxPerConnection int
+	maxPerStream     int
+	mu               sync.Mutex
+	connections      map[*connectedClient]int
+	streams          map[string]int
+}
+
+func newSubscriptionLimits(maxPerConnection, maxPerStream int) *subscriptionLimits {
+	return &subscriptionLimits{
+		maxPerConnection: maxPerConnection,
+		maxPerStream:     maxPerStream,
+		connections:      make(map[*connectedClient]int),
+		streams:          make(map[string]int),
+	}
+}
+
+// acquire counts a subscription of the given client to the given stream. It
+// returns a function which releases the subscription or, if a limit was
+// reached, the limit. Subscriptions from a nil client, i.e. one not accepted
+// by a trackingListener, only count towards the stream limit.
+func (l *subscriptionLimits) acquire(c *connectedClient, stream string) (func(), string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c != nil && l.maxPerConnection > 0 && l.connections[c] >= l.maxPerConnection {
+		return nil, LimitConnectionSubscriptions
+	}
+	if l.maxPerStream > 0 && l.streams[stream] >= l.maxPerStream {
+		return nil, LimitStreamSubscriptions
+	}
+	if c != nil {
+		l.connections[c]++
+	}
+	l.streams[stream]++
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(c, stream) })
+	}, ""
+}
+
+func (l *subscriptionLimits) release(c *connectedClient, stream string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c != nil {
+		if l.connections[c]--; l.connections[c] <= 0 {
+			delete(l.connections, c)
+		}
+	}
+	if l.streams[stream]--; l.streams[stream] <= 0 {
+		delete(l.streams, stream)
+	}
+}
+
+// limitExceededStatus returns the status of requests rejected because the
+// given limit was reached.
+func limitExceededStatus(limit string) *status.Status {
+	var msg string
+	switch limit {
+	case LimitConnections:
+		msg = "Too many connections to server"
+	case LimitConnectionSubscriptions:
+		msg = "Too many subscriptions on connection"
+	default:
+		msg = "Too many subscriptions to stream"
+	}
+	return withErrorDetails(status.New(codes.ResourceExhausted, msg), errorDetails{
+		reason:     ErrorReasonLimitExceeded,
+		metadata:   map[string]string{ErrorMetadataLimit: limit},
+		retryable:  true,
+		retryDelay: connectionRejectGrace,
+	})
+}
+
+// limitExceededMetadata returns the trailer identifying the limit a request
+// was rejected for.
+func limitExceededMetadata(limit string) metadata.MD {
+	return metadata.Pairs(LimitExceededMetadata, limit)
+}
+
+// limitUnaryInterceptor rejects unary requests made over connections accepted
+// over the connection limit.
+func limitUnaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	if clientFromContext(ctx).overLimit() {
+		grpc.SetTrailer(ctx, limitExceededMetadata(LimitConnections)) // nolint: errcheck
+		return nil, limitExceededStatus(LimitConnections).Err()
+	}
+	return handler(ctx, req)
+}
+
+// limitStreamInterceptor rejects streaming requests made over connections
+// accepted over the connection limit.
+func limitStreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	if clientFromContext(ss.Context()).overLimit() {
+		ss.SetTrailer(limitExceededMetadata(LimitConnections))
+		return limitExceededStatus(LimitConnections).Err()
+	}
+	return handler(srv, ss)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Ensure subscriptions are counted per connection and per stream and
+// released.
+func TestSubscriptionLimits(t *testing.T) {
+	limits := newSubscriptionLimits(1, 2)
+	c1, c2 := &connectedClient{id: 1}, &connectedClient{id: 2}
+
+	release1, limit := limits.acquire(c1, "foo")
+	require.Empty(t, limit)
+	_, limit = limits.acquire(c1, "bar")
+	require.Equal(t, LimitConnectionSubscriptions, limit)
+
+	release2, limit := limits.acquire(c2, "foo")
+	require.Empty(t, limit)
+	_, limit = limits.acquire(nil, "foo")
+	require.Equal(t, LimitStreamSubscriptions, limit)
+
+	// Releasing more than once has no effect.
+	release1()
+	release1()
+	release3, limit := limits.acquire(nil, "foo")
+	require.Empty(t, limit)
+	_, limit = limits.acquire(nil, "foo")
+	require.Equal(t, LimitStreamSubscriptions, limit)
+
+	release2()
+	release3()
+	require.Empty(t, limits.connections)
+	require.Empty(t, limits.streams)
+
+	// No limits.
+	limits = newSubscriptionLimits(0, 0)
+	for i := 0; i < 10; i++ {
+		_, limit = limits.acquire(c1, "foo")
+		require.Empty(t, limit)
+	}
+}
+
+// Ensure subscriptions over the per-connection and per-stream limits are
+// rejected with distinct trailers.
+func TestSubscribeLimitExceeded(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.SubscriptionMaxPerConnection = 1
+	config.SubscriptionMaxPerStream = 2
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	dial := func() client.APIClient {
+		conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return client.NewAPIClient(conn)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	api1 := dial()
+	_, err := api1.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	_, err = api1.CreateStream(ctx, &client.CreateStreamRequest{Subject: "bar", Name: "bar"})
+	require.NoError(t, err)
+	_, err = api1.CreateStream(metadata.AppendToOutgoingContext(ctx, AliasForMetadata, "foo"),
+		&client.CreateStreamRequest{Name: "baz"})
+	require.NoError(t, err)
+	waitForPartition(t, 10*time.Second, "foo", 0, s)
+	waitForPartition(t, 10*time.Second, "bar", 0, s)
+
+	subscribe := func(ctx context.Context, api client.APIClient, stream string) (
+		client.API_SubscribeClient, error) {
+
+		sub, err := api.Subscribe(ctx, &client.SubscribeRequest{Stream: stream})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		return sub, err
+	}
+	requireLimit := func(sub client.API_SubscribeClient, err error, limit string) {
+		require.Error(t, err)
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+		require.Equal(t, []string{limit}, sub.Trailer().Get(LimitExceededMetadata))
+	}
+
+	sub1Ctx, sub1Cancel := context.WithCancel(ctx)
+	_, err = subscribe(sub1Ctx, api1, "foo")
+	require.NoError(t, err)
+
+	// The connection already has a subscription.
+	sub, err := subscribe(ctx, api1, "bar")
+	requireLimit(sub, err, LimitConnectionSubscriptions)
+
+	// The stream has two subscriptions.
+	_, err = subscribe(ctx, dial(), "foo")
+	require.NoError(t, err)
+	api3 := dial()
+	sub, err = subscribe(ctx, api3, "foo")
+	requireLimit(sub, err, LimitStreamSubscriptions)
+
+	// Subscriptions through an alias count against the stream.
+	sub, err = subscribe(ctx, api3, "baz")
+	requireLimit(sub, err, LimitStreamSubscriptions)
+	_, err = subscribe(ctx, api3, "bar")
+	require.NoError(t, err)
+
+	// Ending a subscription makes room for another.
+	sub1Cancel()
+	require.Eventually(t, func() bool {
+		subCtx, subCancel := context.WithCancel(ctx)
+		defer subCancel()
+		_, err := subscribe(subCtx, dial(), "foo")
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+// Ensure requests from clients connected over the connection limit are
+// rejected and their connections are closed.
+func TestConnectionLimitExceeded(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Use a random port so clients left over from other tests don't take up
+	// the only connection.
+	config := getTestConfig("a", true, 0)
+	config.ConnectionMax = 1
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+	addr := fmt.Sprintf("localhost:%d", s.GetListenPort())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn1, err := grpc.Dial(addr, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn1.Close()
+	_, err = client.NewAPIClient(conn1).FetchMetadata(ctx, &client.FetchMetadataRequest{})
+	require.NoError(t, err)
+
+	conn2, err := grpc.Dial(addr, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn2.Close()
+	api2 := client.NewAPIClient(conn2)
+	var trailer metadata.MD
+	_, err = api2.FetchMetadata(ctx, &client.FetchMetadataRequest{}, grpc.Trailer(&trailer))
+	require.Error(t, err)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, []string{LimitConnections}, trailer.Get(LimitExceededMetadata))
+
+	// Once the first client disconnects, the second one reconnects after its
+	// connection is closed.
+	conn1.Close()
+	require.Eventually(t, func() bool {
+		_, err := api2.FetchMetadata(ctx, &client.FetchMetadataRequest{})
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/stats"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// listenerKey is the context key for the name of the listener a client
+// connected to.
+type listenerKey struct{}
+
+// clientListener is an additional listener clients can connect to the API on.
+type clientListener struct {
+	net.Listener
+	config ListenerConfig
+}
+
+// Accept waits for the next connection and tags its local address with the
+// listener's name so that API requests can tell which listener they arrived
+// on.
+func (l *clientListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &listenerConn{
+		Conn: conn,
+		addr: &listenerAddr{Addr: conn.LocalAddr(), listener: l.config.Name},
+	}, nil
+}
+
+// listenerConn is a connection accepted by a clientListener.
+type listenerConn struct {
+	net.Conn
+	addr *listenerAddr
+}
+
+// LocalAddr returns the local address of the connection tagged with the name
+// of the listener which accepted it.
+func (c *listenerConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// listenerAddr is the local address of a connection accepted by a
+// clientListener.
+type listenerAddr struct {
+	net.Addr
+	listener string
+}
+
+// listenerStatsHandler is a gRPC stats handler which adds the name of the
+// listener a connection was accepted by to the context of its requests.
+type listenerStatsHandler struct{}
+
+// TagConn adds the listener name to the connection's context if it was
+// accepted by a clientListener.
+func (listenerStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	if addr, ok := info.LocalAddr.(*listenerAddr); ok {
+		return context.WithValue(ctx, listenerKey{}, addr.listener)
+	}
+	return ctx
+}
+
+func (listenerStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (listenerStatsHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (listenerStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// listenerFromContext returns the name of the listener the request with the
+// given context arrived on, or an empty string for the default listener.
+func listenerFromContext(ctx context.Context) string {
+	listener, _ := ctx.Value(listenerKey{}).(string)
+	return listener
+}
+
+// startListeners starts the additional client listeners.
+func (s *Server) startListeners() error {
+	for _, config := range s.config.Listeners {
+		hp := net.JoinHostPort(config.Listen.Host, strconv.Itoa(config.Listen.Port))
+		l, err := net.Listen("tcp", hp)
+		if err != nil {
+			return errors.Wrapf(err, "failed starting listener %s", config.Name)
+		}
+		listener := &clientListener{Listener: l, config: config}
+		s.listeners = append(s.listeners, listener)
+		s.logger.Infof("Listening for clients on %s for listener %s, advertising %s",
+			l.Addr(), config.Name, s.advertisedAddress(listener))
+	}
+	return nil
+}
+
+// advertisedAddress returns the address advertised to clients connected to
+// the given listener. An unspecified advertised host is replaced by the
+// server's connection host, and port 0 by the port the listener is bound to.
+func (s *Server) advertisedAddress(listener *clientListener) string {
+	var (
+		host = listener.config.Advertise.Host
+		port = listener.config.Advertise.Port
+	)
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = s.getConnectionAddress().Host
+	}
+	if port == 0 {
+		port = listener.Addr().(*net.TCPAddr).Port
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// advertisedListeners returns the addresses advertised for the additional
+// client listeners as name=host:port entries.
+func (s *Server) advertisedListeners() []string {
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	advertised := make([]string, len(s.listeners))
+	for i, listener := range s.listeners {
+		advertised[i] = listener.config.Name + "=" + s.advertisedAddress(listener)
+	}
+	return advertised
+}
+
+// brokersForListener returns the brokers described by the given server info
+// with the addresses they advertise for the given listener. Brokers which
+// don't have a listener of that name are returned with their default address.
+func brokersForListener(servers []*proto.ServerInfoResponse, listener string) []*client.Broker {
+	brokers := make([]*client.Broker, len(servers))
+	for i, server := range servers {
+		broker := &client.Broker{
+			Id:   server.Id,
+			Host: server.Host,
+			Port: server.Port,
+		}
+		if listener != "" {
+			if host, port, ok := listenerAddress(server.Listeners, listener); ok {
+				broker.Host = host
+				broker.Port = port
+			}
+		}
+		brokers[i] = broker
+	}
+	return brokers
+}
+
+// listenerAddress returns the host and port of the given listener's entry in
+// a list of advertised name=host:port listener addresses.
+func listenerAddress(advertised []string, listener string) (string, int32, bool) {
+	for _, entry := range advertised {
+		name, address := entry, ""
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, address = entry[:i], entry[i+1:]
+		}
+		if name != listener {
+			continue
+		}
+		host, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			return "", 0, false
+		}
+		port, err := strconv.ParseInt(portStr, 10, 32)
+		if err != nil {
+			return "", 0, false
+		}
+		return host, int32(port), true
+	}
+	return "", 0, false
+}
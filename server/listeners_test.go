@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure clients are given the broker addresses advertised for the listener
+// they connected to, including when the broker metadata is cached.
+func TestListenersAdvertisedAddresses(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.Listeners = []ListenerConfig{{
+		Name:      "external",
+		Listen:    HostPort{Host: "localhost", Port: 5051},
+		Advertise: HostPort{Host: "broker-0.example.com", Port: 30051},
+	}}
+	s1 := runServerWithConfig(t, config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	require.Equal(t, []string{"external=broker-0.example.com:30051"}, s1.serverInfo().Listeners)
+
+	fetchBroker := func(address string) *client.Broker {
+		conn, err := grpc.Dial(address, grpc.WithInsecure())
+		require.NoError(t, err)
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp, err := client.NewAPIClient(conn).FetchMetadata(ctx, &client.FetchMetadataRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.Brokers, 1)
+		return resp.Brokers[0]
+	}
+
+	for i := 0; i < 2; i++ {
+		broker := fetchBroker("localhost:5050")
+		require.Equal(t, "localhost", broker.Host)
+		require.Equal(t, int32(5050), broker.Port)
+
+		broker = fetchBroker("localhost:5051")
+		require.Equal(t, "broker-0.example.com", broker.Host)
+		require.Equal(t, int32(30051), broker.Port)
+	}
+}
+
+// Ensure brokers without a listener of the requested name are returned with
+// their default address.
+func TestBrokersForListener(t *testing.T) {
+	servers := []*proto.ServerInfoResponse{
+		{
+			Id:        "a",
+			Host:      "10.0.0.1",
+			Port:      9292,
+			Listeners: []string{"external=a.example.com:30001", "internal=a.local:9393"},
+		},
+		{Id: "b", Host: "10.0.0.2", Port: 9292},
+	}
+
+	brokers := brokersForListener(servers, "")
+	require.Equal(t, "10.0.0.1", brokers[0].Host)
+	require.Equal(t, int32(9292), brokers[0].Port)
+
+	brokers = brokersForListener(servers, "external")
+	require.Equal(t, "a.example.com", brokers[0].Host)
+	require.Equal(t, int32(30001), brokers[0].Port)
+	require.Equal(t, "10.0.0.2", brokers[1].Host)
+	require.Equal(t, int32(9292), brokers[1].Port)
+
+	brokers = brokersForListener(servers, "internal")
+	require.Equal(t, "a.local", brokers[0].Host)
+	require.Equal(t, int32(9393), brokers[0].Port)
+}
@@ -2,12 +2,18 @@ package logger
 
 import (
 	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
 
 	gnatsd "github.com/nats-io/nats-server/v2/server"
 	log "github.com/sirupsen/logrus"
 )
 
-// Logger interface is used to allow tests to inject custom loggers.
+// Logger is the interface the server logs through. NewLogger returns the
+// default Logger, which is backed by Logrus. When embedding the server, set
+// Config.Logger to log through another Logger, e.g. one of the adapters for
+// Logrus, zap, or slog.
 type Logger interface {
 	Fatalf(string, ...interface{})
 	Debugf(string, ...interface{})
@@ -18,6 +24,12 @@ type Logger interface {
 	Warn(...interface{})
 	Info(...interface{})
 	Fatal(...interface{})
+}
+
+// WriterLogger is a Logger whose output can be redirected, such as the
+// default Logger.
+type WriterLogger interface {
+	Logger
 	Writer() io.Writer
 	SetWriter(io.Writer)
 }
@@ -27,7 +39,7 @@ type logger struct {
 }
 
 // NewLogger returns a new Logger instance backed by Logrus.
-func NewLogger(level uint32) Logger {
+func NewLogger(level uint32) WriterLogger {
 	l := log.New()
 	l.SetLevel(log.Level(level))
 	logFormatter := &log.TextFormatter{
@@ -46,6 +58,98 @@ func (l *logger) SetWriter(writer io.Writer) {
 	l.Out = writer
 }
 
+// NewLogrusLogger returns a Logger which logs to the given Logrus logger or
+// entry, e.g. one with fields identifying the server.
+func NewLogrusLogger(l log.FieldLogger) Logger {
+	return l
+}
+
+// NewNopLogger returns a Logger which discards all messages. Fatal messages
+// still exit the process.
+func NewNopLogger() Logger {
+	l := NewLogger(uint32(log.PanicLevel))
+	l.SetWriter(ioutil.Discard)
+	return l
+}
+
+// SuppressibleLogger wraps a Logger so that its messages can be suppressed for
+// a while, e.g. while the server replays its Raft log. Fatal messages are never
+// suppressed.
+type SuppressibleLogger struct {
+	Logger
+	suppressed int32 // Number of active suppressions, accessed atomically
+}
+
+// NewSuppressibleLogger returns a SuppressibleLogger which logs to the given
+// Logger.
+func NewSuppressibleLogger(l Logger) *SuppressibleLogger {
+	return &SuppressibleLogger{Logger: l}
+}
+
+// Suppress suppresses messages until the returned function is called, which
+// has no effect after the first call. Suppressions can overlap, in which case
+// messages are suppressed until all of them end.
+func (s *SuppressibleLogger) Suppress() func() {
+	atomic.AddInt32(&s.suppressed, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt32(&s.suppressed, -1) })
+	}
+}
+
+func (s *SuppressibleLogger) enabled() bool {
+	return atomic.LoadInt32(&s.suppressed) == 0
+}
+
+// Debugf logs a debug statement unless messages are suppressed.
+func (s *SuppressibleLogger) Debugf(format string, v ...interface{}) {
+	if s.enabled() {
+		s.Logger.Debugf(format, v...)
+	}
+}
+
+// Infof logs an info statement unless messages are suppressed.
+func (s *SuppressibleLogger) Infof(format string, v ...interface{}) {
+	if s.enabled() {
+		s.Logger.Infof(format, v...)
+	}
+}
+
+// Warnf logs a warning statement unless messages are suppressed.
+func (s *SuppressibleLogger) Warnf(format string, v ...interface{}) {
+	if s.enabled() {
+		s.Logger.Warnf(format, v...)
+	}
+}
+
+// Errorf logs an error unless messages are suppressed.
+func (s *SuppressibleLogger) Errorf(format string, v ...interface{}) {
+	if s.enabled() {
+		s.Logger.Errorf(format, v...)
+	}
+}
+
+// Debug logs a debug statement unless messages are suppressed.
+func (s *SuppressibleLogger) Debug(v ...interface{}) {
+	if s.enabled() {
+		s.Logger.Debug(v...)
+	}
+}
+
+// Info logs an info statement unless messages are suppressed.
+func (s *SuppressibleLogger) Info(v ...interface{}) {
+	if s.enabled() {
+		s.Logger.Info(v...)
+	}
+}
+
+// Warn logs a warning statement unless messages are suppressed.
+func (s *SuppressibleLogger) Warn(v ...interface{}) {
+	if s.enabled() {
+		s.Logger.Warn(v...)
+	}
+}
+
 // natsLogger implements the NATS server logger interface by writing log
 // messages to a Liftbridge logger.
 type natsLogger struct {
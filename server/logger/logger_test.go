@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// Ensure messages are suppressed until all suppressions end.
+func TestSuppressibleLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := NewLogger(uint32(log.InfoLevel))
+	l.SetWriter(buf)
+	s := NewSuppressibleLogger(l)
+
+	s.Infof("one")
+	require.Contains(t, buf.String(), "one")
+
+	end1 := s.Suppress()
+	end2 := s.Suppress()
+	s.Infof("two")
+	s.Warn("three")
+	end1()
+	end1()
+	s.Errorf("four")
+	require.NotContains(t, buf.String(), "two")
+	require.NotContains(t, buf.String(), "three")
+	require.NotContains(t, buf.String(), "four")
+
+	end2()
+	s.Info("five")
+	require.Contains(t, buf.String(), "five")
+}
+
+// Ensure the zap adapter logs at the corresponding levels.
+func TestZapLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := NewZapLogger(zap.New(core))
+
+	l.Debugf("debug %d", 1)
+	l.Infof("info %d", 2)
+	l.Warn("warn")
+	l.Errorf("error")
+
+	entries := logs.AllUntimed()
+	require.Len(t, entries, 4)
+	require.Equal(t, zapcore.DebugLevel, entries[0].Level)
+	require.Equal(t, "debug 1", entries[0].Message)
+	require.Equal(t, zapcore.InfoLevel, entries[1].Level)
+	require.Equal(t, "info 2", entries[1].Message)
+	require.Equal(t, zapcore.WarnLevel, entries[2].Level)
+	require.Equal(t, zapcore.ErrorLevel, entries[3].Level)
+}
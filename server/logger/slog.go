@@ -0,0 +1,88 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+)
+
+// LevelFatal is the slog level of fatal messages logged through a Logger
+// returned by NewSlogLogger.
+const LevelFatal = slog.LevelError + 4
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger which logs to the given slog logger. Like
+// with the other Loggers, fatal messages exit the process after they are
+// logged.
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l}
+}
+
+func (s *slogLogger) Fatalf(format string, v ...interface{}) {
+	s.logf(LevelFatal, format, v...)
+	os.Exit(1)
+}
+
+func (s *slogLogger) Debugf(format string, v ...interface{}) {
+	s.logf(slog.LevelDebug, format, v...)
+}
+
+func (s *slogLogger) Errorf(format string, v ...interface{}) {
+	s.logf(slog.LevelError, format, v...)
+}
+
+func (s *slogLogger) Infof(format string, v ...interface{}) {
+	s.logf(slog.LevelInfo, format, v...)
+}
+
+func (s *slogLogger) Warnf(format string, v ...interface{}) {
+	s.logf(slog.LevelWarn, format, v...)
+}
+
+func (s *slogLogger) Debug(v ...interface{}) {
+	s.log(slog.LevelDebug, v...)
+}
+
+func (s *slogLogger) Warn(v ...interface{}) {
+	s.log(slog.LevelWarn, v...)
+}
+
+func (s *slogLogger) Info(v ...interface{}) {
+	s.log(slog.LevelInfo, v...)
+}
+
+func (s *slogLogger) Fatal(v ...interface{}) {
+	s.log(LevelFatal, v...)
+	os.Exit(1)
+}
+
+func (s *slogLogger) logf(level slog.Level, format string, v ...interface{}) {
+	if s.logger.Enabled(context.Background(), level) {
+		s.write(level, fmt.Sprintf(format, v...))
+	}
+}
+
+func (s *slogLogger) log(level slog.Level, v ...interface{}) {
+	if s.logger.Enabled(context.Background(), level) {
+		s.write(level, fmt.Sprint(v...))
+	}
+}
+
+// write logs the message with the source location of the caller of the
+// Logger method.
+func (s *slogLogger) write(level slog.Level, msg string) {
+	var pcs [1]uintptr
+	// Skip runtime.Callers, write, log/logf, and the Logger method.
+	runtime.Callers(4, pcs[:])
+	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	s.logger.Handler().Handle(context.Background(), record) // nolint: errcheck
+}
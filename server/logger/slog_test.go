@@ -0,0 +1,28 @@
+//go:build go1.21
+// +build go1.21
+
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure the slog adapter formats messages, respects the handler's level,
+// and reports the caller's source location.
+func TestSlogLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	handler := slog.NewTextHandler(buf, &slog.HandlerOptions{AddSource: true, Level: slog.LevelInfo})
+	l := NewSlogLogger(slog.New(handler))
+
+	l.Debugf("debug %d", 1)
+	require.Empty(t, buf.String())
+
+	l.Warnf("warn %d", 2)
+	require.Contains(t, buf.String(), "level=WARN")
+	require.Contains(t, buf.String(), `msg="warn 2"`)
+	require.Contains(t, buf.String(), "slog_test.go")
+}
@@ -0,0 +1,10 @@
+package logger
+
+import (
+	"go.uber.org/zap"
+)
+
+// NewZapLogger returns a Logger which logs to the given zap logger.
+func NewZapLogger(l *zap.Logger) Logger {
+	return l.Sugar()
+}
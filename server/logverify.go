@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+var (
+	// errNotReplica is returned when verifying or repairing the log of a
+	// partition the server is not a replica of, so it has no data.
+	errNotReplica = errors.New("server is not a replica of the partition")
+
+	// errRepairLeader is returned by RepairPartition if the server leads the
+	// partition and it has other replicas, which may have replicated the
+	// messages the repair would remove.
+	errRepairLeader = errors.New("server is the partition leader and the partition has other replicas")
+
+	// errPartitionClosed is returned when verifying or repairing the log of
+	// a partition which is paused or closed.
+	errPartitionClosed = errors.New("partition is paused or closed")
+)
+
+// logIssue is a problem found in a partition's log as reported by the admin
+// API.
+type logIssue struct {
+	Kind     string `json:"kind"`
+	Segment  int64  `json:"segment"`
+	Offset   int64  `json:"offset"`
+	Position int64  `json:"position"`
+	Message  string `json:"message"`
+}
+
+// logReport is the response to verifying or repairing a partition's log.
+type logReport struct {
+	Stream          string     `json:"stream"`
+	Partition       int32      `json:"partition"`
+	Valid           bool       `json:"valid"`
+	Segments        int        `json:"segments"`
+	Messages        int64      `json:"messages"`
+	Issues          []logIssue `json:"issues"`
+	OmittedIssues   int        `json:"omittedIssues,omitempty"`
+	LastValidOffset int64      `json:"lastValidOffset"`
+	Repaired        bool       `json:"repaired"`
+}
+
+// replicaPartition returns the given partition if the server is one of its
+// replicas.
+func (s *Server) replicaPartition(stream string, id int32) (*partition, error) {
+	if s.metadata.GetStream(stream) == nil {
+		return nil, ErrStreamNotFound
+	}
+	partition := s.metadata.GetPartition(stream, id)
+	if partition == nil {
+		return nil, ErrPartitionNotFound
+	}
+	for _, replica := range partition.GetReplicas() {
+		if replica == s.config.Clustering.ServerID {
+			return partition, nil
+		}
+	}
+	return nil, errNotReplica
+}
+
+// VerifyPartition checks the integrity of the log of the given partition on
+// this server while it remains in use. See commitlog.CommitLog.VerifyLog.
+func (s *Server) VerifyPartition(ctx context.Context, stream string, id int32) (
+	*commitlog.LogReport, error) {
+
+	partition, err := s.replicaPartition(stream, id)
+	if err != nil {
+		return nil, err
+	}
+	return partition.VerifyLog(ctx)
+}
+
+// RepairPartition checks the integrity of the log of the given partition on
+// this server and repairs it, truncating it before the first invalid message.
+// See commitlog.CommitLog.RepairLog. Since the truncated messages may have
+// been replicated, a leader with other replicas can't be repaired, while a
+// follower replicates them again from the leader.
+func (s *Server) RepairPartition(ctx context.Context, stream string, id int32) (
+	*commitlog.LogReport, error) {
+
+	partition, err := s.replicaPartition(stream, id)
+	if err != nil {
+		return nil, err
+	}
+	report, err := partition.RepairLog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if report.Repaired {
+		s.logger.Warnf("Repaired log for partition %s, which is valid up to offset %d "+
+			"(%d issues found)", partition, report.LastValidOffset,
+			len(report.Issues)+report.OmittedIssues)
+	}
+	return report, nil
+}
+
+// handoffResponse is the response to handing off a partition's leadership.
+type handoffResponse struct {
+	Stream    string `json:"stream"`
+	Partition int32  `json:"partition"`
+	Leader    string `json:"leader"`
+}
+
+// handlePartitionHandoff hands off the leadership of a partition the server
+// leads with HandoffPartition.
+func (s *Server) handlePartitionHandoff(w http.ResponseWriter, r *http.Request, stream,
+	idParam string) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	id, err := strconv.ParseInt(idParam, 10, 32)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "Invalid partition "+strconv.Quote(idParam), "")
+		return
+	}
+	leader, err := s.HandoffPartition(r.Context(), stream, int32(id))
+	switch err {
+	case nil:
+	case ErrStreamNotFound, ErrPartitionNotFound:
+		writeAdminError(w, http.StatusNotFound, err.Error(), "")
+		return
+	case errNotReplica, errNotPartitionLeader, errNoHandoffCandidates:
+		writeAdminError(w, http.StatusConflict, err.Error(), "")
+		return
+	default:
+		writeAdminError(w, http.StatusInternalServerError, err.Error(), "")
+		return
+	}
+	writeAdminResponse(w, http.StatusOK, handoffResponse{
+		Stream:    stream,
+		Partition: int32(id),
+		Leader:    leader,
+	})
+}
+
+// VerifyLog verifies the partition's log with commitlog.CommitLog.VerifyLog.
+func (p *partition) VerifyLog(ctx context.Context) (*commitlog.LogReport, error) {
+	p.mu.RLock()
+	closed := p.isClosed
+	p.mu.RUnlock()
+	if closed {
+		return nil, errPartitionClosed
+	}
+	return p.log.VerifyLog(ctx)
+}
+
+// RepairLog repairs the partition's log with commitlog.CommitLog.RepairLog.
+// The partition stops leading or following while its log is repaired and
+// starts again afterwards, so its replication state doesn't refer to
+// truncated messages.
+func (p *partition) RepairLog(ctx context.Context) (*commitlog.LogReport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.isClosed {
+		return nil, errPartitionClosed
+	}
+	if p.isLeading && len(p.replicas) > 1 {
+		return nil, errRepairLeader
+	}
+	running := p.isLeading || p.isFollowing
+	if err := p.stopLeadingOrFollowing(); err != nil {
+		return nil, err
+	}
+	report, err := p.log.RepairLog(ctx)
+	if err == nil && report.Repaired {
+		// Messages in the delivery cache may have been truncated.
+		p.deliveryCache.reset()
+		if rep, ok := p.isr[p.srv.config.Clustering.ServerID]; ok {
+			rep.resetLatestOffset(p.log.NewestOffset())
+		}
+	}
+	if running {
+		if startErr := p.startLeadingOrFollowing(); err == nil {
+			err = startErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// handlePartitionLog serves GET streamsPath/<name>/partitions/<id>/verify,
+// which verifies the log of a partition on this server, and POST
+// streamsPath/<name>/partitions/<id>/repair, which repairs it.
+func (s *Server) handlePartitionLog(w http.ResponseWriter, r *http.Request, stream, idParam,
+	action string) {
+
+	var (
+		operation func(context.Context, string, int32) (*commitlog.LogReport, error)
+		method    string
+	)
+	switch action {
+	case "verify":
+		operation, method = s.VerifyPartition, http.MethodGet
+	case "repair":
+		operation, method = s.RepairPartition, http.MethodPost
+	default:
+		writeAdminError(w, http.StatusNotFound, "Not found", "")
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	id, err := strconv.ParseInt(idParam, 10, 32)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "Invalid partition "+strconv.Quote(idParam), "")
+		return
+	}
+	report, err := operation(r.Context(), stream, int32(id))
+	switch err {
+	case nil:
+	case ErrStreamNotFound, ErrPartitionNotFound:
+		writeAdminError(w, http.StatusNotFound, err.Error(), "")
+		return
+	case errNotReplica, errRepairLeader, errPartitionClosed:
+		writeAdminError(w, http.StatusConflict, err.Error(), "")
+		return
+	default:
+		writeAdminError(w, http.StatusInternalServerError, err.Error(), "")
+		return
+	}
+	resp := logReport{
+		Stream:          stream,
+		Partition:       int32(id),
+		Valid:           len(report.Issues) == 0,
+		Segments:        report.Segments,
+		Messages:        report.Messages,
+		Issues:          make([]logIssue, len(report.Issues)),
+		OmittedIssues:   report.OmittedIssues,
+		LastValidOffset: report.LastValidOffset,
+		Repaired:        report.Repaired,
+	}
+	for i, issue := range report.Issues {
+		resp.Issues[i] = logIssue{
+			Kind:     string(issue.Kind),
+			Segment:  issue.Segment,
+			Offset:   issue.Offset,
+			Position: issue.Position,
+			Message:  issue.Message,
+		}
+	}
+	writeAdminResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// Ensure the admin API verifies the log of a partition and repairs it by
+// truncating it before a corrupt message, after which publishes continue at
+// the message's offset.
+func TestAdminPartitionLogAPI(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	publish := func(i int) int64 {
+		resp, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+		return resp.Ack.Offset
+	}
+	for i := 0; i < 3; i++ {
+		publish(i)
+	}
+
+	var report logReport
+	require.Equal(t, http.StatusOK,
+		adminRequest(t, s, http.MethodGet, streamsPath+"/foo/partitions/0/verify", &report))
+	require.True(t, report.Valid)
+	require.Empty(t, report.Issues)
+	require.Equal(t, int64(3), report.Messages)
+	require.Equal(t, int64(2), report.LastValidOffset)
+
+	require.Equal(t, http.StatusMethodNotAllowed,
+		adminRequest(t, s, http.MethodPost, streamsPath+"/foo/partitions/0/verify", nil))
+	require.Equal(t, http.StatusNotFound,
+		adminRequest(t, s, http.MethodGet, streamsPath+"/bar/partitions/0/verify", nil))
+	require.Equal(t, http.StatusNotFound,
+		adminRequest(t, s, http.MethodGet, streamsPath+"/foo/partitions/1/verify", nil))
+	require.Equal(t, http.StatusBadRequest,
+		adminRequest(t, s, http.MethodGet, streamsPath+"/foo/partitions/x/verify", nil))
+
+	// Corrupt the value of the last message.
+	dir, err := s.dataDirs.place("foo", 0)
+	require.NoError(t, err)
+	logs, err := filepath.Glob(filepath.Join(partitionPath(dir, "foo", 0), "*.log"))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	f, err := os.OpenFile(logs[0], os.O_RDWR, 0666)
+	require.NoError(t, err)
+	info, err := f.Stat()
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{'x'}, info.Size()-1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.Equal(t, http.StatusOK,
+		adminRequest(t, s, http.MethodGet, streamsPath+"/foo/partitions/0/verify", &report))
+	require.False(t, report.Valid)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, "corrupt_message", report.Issues[0].Kind)
+	require.Equal(t, int64(2), report.Issues[0].Offset)
+	require.Equal(t, int64(1), report.LastValidOffset)
+	require.False(t, report.Repaired)
+
+	require.Equal(t, http.StatusOK,
+		adminRequest(t, s, http.MethodPost, streamsPath+"/foo/partitions/0/repair", &report))
+	require.True(t, report.Repaired)
+	require.Equal(t, http.StatusOK,
+		adminRequest(t, s, http.MethodGet, streamsPath+"/foo/partitions/0/verify", &report))
+	require.True(t, report.Valid)
+	require.Equal(t, int64(2), report.Messages)
+
+	require.Equal(t, int64(2), publish(3))
+}
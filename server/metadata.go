@@ -35,6 +35,18 @@ var (
 	// ErrPartitionNotFound is returned by PauseStream when attempting to pause
 	// a stream partition that does not exist.
 	ErrPartitionNotFound = errors.New("partition does not exist")
+
+	// ErrStreamAliasNotFound is returned by SetStreamAlias when attempting to
+	// remove an alias the stream does not have.
+	ErrStreamAliasNotFound = errors.New("stream alias does not exist")
+
+	// ErrStreamAliasInUse is returned by SetStreamAlias when attempting to
+	// remove the alias a stream has been renamed to.
+	ErrStreamAliasInUse = errors.New("stream alias is the stream's current name")
+
+	// ErrInternalStreamRename is returned by RenameStream when attempting to
+	// rename or replace an internal stream.
+	ErrInternalStreamRename = errors.New("internal streams cannot be renamed or replaced")
 )
 
 // leaderReport tracks witnesses for a partition leader. Witnesses are replicas
@@ -100,19 +112,22 @@ func (l *leaderReport) cancel() {
 type metadataAPI struct {
 	*Server
 	streams             map[string]*stream
+	aliases             map[string]string // Maps stream aliases to stream names
 	mu                  sync.RWMutex
 	leaderReports       map[*partition]*leaderReport
-	cachedBrokers       []*client.Broker
+	cachedServers       []*proto.ServerInfoResponse
 	cachedServerIDs     map[string]struct{}
 	lastCached          time.Time
 	brokerPartitionLoad map[string]int
 	brokerLeaderLoad    map[string]int
+	clusterVersion      negotiatedVersion // Cached cluster metadata version
 }
 
 func newMetadataAPI(s *Server) *metadataAPI {
 	return &metadataAPI{
 		Server:              s,
 		streams:             make(map[string]*stream),
+		aliases:             make(map[string]string),
 		leaderReports:       make(map[*partition]*leaderReport),
 		brokerPartitionLoad: make(map[string]int),
 		brokerLeaderLoad:    make(map[string]int),
@@ -151,6 +166,22 @@ func (m *metadataAPI) FetchMetadata(ctx context.Context, req *client.FetchMetada
 
 	resp := m.createMetadataResponse(req.Streams)
 
+	// The broker info holds the addresses advertised for every listener, so
+	// brokers are returned with the ones for the listener the request arrived
+	// on.
+	infos, st := m.getBrokerInfo(ctx)
+	if st != nil {
+		return nil, st
+	}
+	resp.Brokers = brokersForListener(infos, listenerFromContext(ctx))
+
+	return resp, nil
+}
+
+// getBrokerInfo returns the information each server in the cluster reports
+// about itself. It's cached, so the servers are only queried if the cluster
+// changed or the cache is past the metadata cache max age.
+func (m *metadataAPI) getBrokerInfo(ctx context.Context) ([]*proto.ServerInfoResponse, *status.Status) {
 	servers, err := m.getClusterServerIDs()
 	if err != nil {
 		return nil, status.New(codes.Internal, err.Error())
@@ -163,24 +194,23 @@ func (m *metadataAPI) FetchMetadata(ctx context.Context, req *client.FetchMetada
 
 	// Check if we can use cached broker info.
 	if cached, ok := m.brokerCache(serverIDs); ok {
-		resp.Brokers = cached
-	} else {
-		// Query broker info from peers.
-		brokers, err := m.fetchBrokerInfo(ctx, len(servers)-1)
-		if err != nil {
-			return nil, err
-		}
-		resp.Brokers = brokers
+		return cached, nil
+	}
 
-		// Update the cache.
-		m.mu.Lock()
-		m.cachedBrokers = brokers
-		m.cachedServerIDs = serverIDs
-		m.lastCached = time.Now()
-		m.mu.Unlock()
+	// Query broker info from peers.
+	infos, st := m.surveyServers(ctx, len(servers)-1)
+	if st != nil {
+		return nil, st
 	}
 
-	return resp, nil
+	// Update the cache.
+	m.mu.Lock()
+	m.cachedServers = infos
+	m.cachedServerIDs = serverIDs
+	m.lastCached = time.Now()
+	m.mu.Unlock()
+
+	return infos, nil
 }
 
 // FetchPartitionMetadata retrieves the metadata for the partition leader. This
@@ -190,10 +220,10 @@ func (m *metadataAPI) FetchPartitionMetadata(ctx context.Context, req *client.Fe
 
 	partition := m.GetPartition(req.Stream, req.Partition)
 	if partition == nil {
-		return nil, status.New(codes.NotFound, "partition not found")
+		return nil, partitionNotFoundStatus(codes.NotFound, "partition not found", req.Stream, req.Partition)
 	}
 	if !partition.IsLeader() {
-		return nil, status.New(codes.FailedPrecondition, "The request should be sent to partition leader")
+		return nil, m.notLeaderStatus(ctx, partition, "The request should be sent to partition leader")
 	}
 	metadata := getPartitionMetadata(req.Partition, partition)
 	return &client.FetchPartitionMetadataResponse{Metadata: metadata}, nil
@@ -201,8 +231,9 @@ func (m *metadataAPI) FetchPartitionMetadata(ctx context.Context, req *client.Fe
 
 // brokerCache checks if the cache of broker metadata is clean and, if it is
 // and it's not past the metadata cache max age, returns the cached broker
-// list. The bool returned indicates if the cached data is returned or not.
-func (m *metadataAPI) brokerCache(serverIDs map[string]struct{}) ([]*client.Broker, bool) {
+// information. The bool returned indicates if the cached data is returned or
+// not.
+func (m *metadataAPI) brokerCache(serverIDs map[string]struct{}) ([]*proto.ServerInfoResponse, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	serversChanged := false
@@ -216,25 +247,22 @@ func (m *metadataAPI) brokerCache(serverIDs map[string]struct{}) ([]*client.Brok
 			}
 		}
 	}
-	useCache := len(m.cachedBrokers) > 0 &&
+	useCache := len(m.cachedServers) > 0 &&
 		!serversChanged &&
 		time.Since(m.lastCached) <= m.config.MetadataCacheMaxAge
 	if useCache {
-		return m.cachedBrokers, true
+		return m.cachedServers, true
 	}
 	return nil, false
 }
 
-// fetchBrokerInfo retrieves the broker metadata for the cluster. The numPeers
-// argument is the expected number of peers to get a response from.
-func (m *metadataAPI) fetchBrokerInfo(ctx context.Context, numPeers int) ([]*client.Broker, *status.Status) {
+// surveyServers retrieves the information each server in the cluster reports
+// about itself, starting with this server. The numPeers argument is the
+// expected number of peers to get a response from. Servers which don't
+// respond before the context is done are left out.
+func (m *metadataAPI) surveyServers(ctx context.Context, numPeers int) ([]*proto.ServerInfoResponse, *status.Status) {
 	// Add ourselves.
-	connectionAddress := m.getConnectionAddress()
-	brokers := []*client.Broker{{
-		Id:   m.config.Clustering.ServerID,
-		Host: connectionAddress.Host,
-		Port: int32(connectionAddress.Port),
-	}}
+	servers := []*proto.ServerInfoResponse{m.serverInfo()}
 
 	// Make sure there is a deadline on the request.
 	ctx, cancel := ensureTimeout(ctx, defaultPropagateTimeout)
@@ -270,14 +298,10 @@ func (m *metadataAPI) fetchBrokerInfo(ctx context.Context, numPeers int) ([]*cli
 			m.logger.Warnf("Received invalid server info response: %v", err)
 			continue
 		}
-		brokers = append(brokers, &client.Broker{
-			Id:   queryResp.Id,
-			Host: queryResp.Host,
-			Port: queryResp.Port,
-		})
+		servers = append(servers, queryResp)
 	}
 
-	return brokers, nil
+	return servers, nil
 }
 
 // createMetadataResponse creates a FetchMetadataResponse and populates it with
@@ -288,7 +312,7 @@ func (m *metadataAPI) createMetadataResponse(streams []string) *client.FetchMeta
 	// If no stream names were provided, fetch metadata for all streams.
 	if len(streams) == 0 {
 		for _, stream := range m.GetStreams() {
-			streams = append(streams, stream.GetName())
+			streams = append(streams, stream.GetDisplayName())
 		}
 	}
 
@@ -349,8 +373,14 @@ func (m *metadataAPI) CreateStream(ctx context.Context, req *proto.CreateStreamO
 			return st
 		}
 
-		// Select a leader at random.
-		leader := m.selectPartitionLeader(replicas)
+		// Select a leader at random unless the partition has one already,
+		// e.g. because it has the partition's data.
+		leader := partition.Leader
+		if leader == "" {
+			leader = m.selectPartitionLeader(replicas)
+		} else if !containsString(replicas, leader) {
+			replicas[len(replicas)-1] = leader
+		}
 
 		partition.Replicas = replicas
 		partition.Isr = replicas
@@ -418,11 +448,10 @@ func (m *metadataAPI) DeleteStream(ctx context.Context, req *proto.DeleteStreamO
 	// Wait on result of deletion.
 	future, err := m.getRaft().applyOperation(ctx, op, m.checkDeleteStreamPreconditions)
 	if err != nil {
-		code := codes.FailedPrecondition
 		if err == ErrStreamNotFound {
-			code = codes.NotFound
+			return notFoundStatus(err, req.Stream)
 		}
-		return status.Newf(code, err.Error())
+		return status.Newf(codes.FailedPrecondition, err.Error())
 	}
 	if err := future.Error(); err != nil {
 		return status.Newf(codes.Internal, "Failed to delete stream: %v", err.Error())
@@ -457,11 +486,10 @@ func (m *metadataAPI) PauseStream(ctx context.Context, req *proto.PauseStreamOp)
 	// Wait on result of pausing.
 	future, err := m.getRaft().applyOperation(ctx, op, m.checkPauseStreamPreconditions)
 	if err != nil {
-		code := codes.FailedPrecondition
 		if err == ErrStreamNotFound || err == ErrPartitionNotFound {
-			code = codes.NotFound
+			return notFoundStatus(err, req.Stream)
 		}
-		return status.Newf(code, err.Error())
+		return status.Newf(codes.FailedPrecondition, err.Error())
 	}
 	if err := future.Error(); err != nil {
 		return status.Newf(codes.Internal, "Failed to pause stream: %v", err.Error())
@@ -498,11 +526,10 @@ func (m *metadataAPI) ResumeStream(ctx context.Context, req *proto.ResumeStreamO
 	// Wait on result of replication.
 	future, err := m.getRaft().applyOperation(ctx, op, m.checkResumeStreamPreconditions)
 	if err != nil {
-		code := codes.FailedPrecondition
 		if err == ErrStreamNotFound || err == ErrPartitionNotFound {
-			code = codes.NotFound
+			return notFoundStatus(err, req.Stream)
 		}
-		return status.Newf(code, err.Error())
+		return status.Newf(codes.FailedPrecondition, err.Error())
 	}
 	if err := future.Error(); err != nil {
 		return status.Newf(codes.Internal, "Failed to resume stream: %v", err.Error())
@@ -543,8 +570,9 @@ func (m *metadataAPI) ShrinkISR(ctx context.Context, req *proto.ShrinkISROp) *st
 	// Verify the partition exists.
 	partition := m.GetPartition(req.Stream, req.Partition)
 	if partition == nil {
-		return status.New(codes.FailedPrecondition, fmt.Sprintf("No such partition [stream=%s, partition=%d]",
-			req.Stream, req.Partition))
+		return partitionNotFoundStatus(codes.FailedPrecondition,
+			fmt.Sprintf("No such partition [stream=%s, partition=%d]", req.Stream, req.Partition),
+			req.Stream, req.Partition)
 	}
 
 	// Check the leader epoch.
@@ -594,8 +622,9 @@ func (m *metadataAPI) ExpandISR(ctx context.Context, req *proto.ExpandISROp) *st
 	// Verify the partition exists.
 	partition := m.GetPartition(req.Stream, req.Partition)
 	if partition == nil {
-		return status.New(codes.FailedPrecondition, fmt.Sprintf("No such partition [stream=%s, partition=%d]",
-			req.Stream, req.Partition))
+		return partitionNotFoundStatus(codes.FailedPrecondition,
+			fmt.Sprintf("No such partition [stream=%s, partition=%d]", req.Stream, req.Partition),
+			req.Stream, req.Partition)
 	}
 
 	// Check the leader epoch.
@@ -629,7 +658,9 @@ func (m *metadataAPI) ExpandISR(ctx context.Context, req *proto.ExpandISROp) *st
 // specified replica if this server is the metadata leader. If it is not, it
 // will forward the request to the leader and return the response. If a quorum
 // of replicas report the partition leader within a bounded period, the
-// metadata leader will select a new partition leader.
+// metadata leader will select a new partition leader. A partition leader
+// reporting itself is stepping down, e.g. because it is shutting down, so a
+// new leader is selected right away.
 func (m *metadataAPI) ReportLeader(ctx context.Context, req *proto.ReportLeaderOp) *status.Status {
 	// Forward the request if we're not the leader.
 	if !m.IsLeader() {
@@ -646,8 +677,9 @@ func (m *metadataAPI) ReportLeader(ctx context.Context, req *proto.ReportLeaderO
 	// Verify the partition exists.
 	partition := m.GetPartition(req.Stream, req.Partition)
 	if partition == nil {
-		return status.New(codes.FailedPrecondition, fmt.Sprintf("No such partition [stream=%s, partition=%d]",
-			req.Stream, req.Partition))
+		return partitionNotFoundStatus(codes.FailedPrecondition,
+			fmt.Sprintf("No such partition [stream=%s, partition=%d]", req.Stream, req.Partition),
+			req.Stream, req.Partition)
 	}
 
 	// Check the leader epoch.
@@ -659,6 +691,10 @@ func (m *metadataAPI) ReportLeader(ctx context.Context, req *proto.ReportLeaderO
 				leader, epoch, req.Leader, req.LeaderEpoch))
 	}
 
+	if req.Replica == req.Leader {
+		return m.electNewPartitionLeader(ctx, partition)
+	}
+
 	m.mu.Lock()
 	reported := m.leaderReports[partition]
 	if reported == nil {
@@ -700,14 +736,139 @@ func (m *metadataAPI) SetStreamReadonly(ctx context.Context, req *proto.SetStrea
 	// Wait on result of setting the readonly flag.
 	future, err := m.getRaft().applyOperation(ctx, op, m.checkSetStreamReadonlyPreconditions)
 	if err != nil {
-		code := codes.FailedPrecondition
 		if err == ErrStreamNotFound || err == ErrPartitionNotFound {
+			return notFoundStatus(err, req.Stream)
+		}
+		return status.Newf(codes.FailedPrecondition, err.Error())
+	}
+	if err := future.Error(); err != nil {
+		return status.Newf(codes.Internal, "Failed to set stream readonly flag: %v", err.Error())
+	}
+
+	return nil
+}
+
+// SetStreamAlias adds or removes an alias of a stream if this server is the
+// metadata leader. If it is not, it will forward the request to the leader and
+// return the response. This operation is replicated by Raft. If successful,
+// this will return once the alias has been added or removed.
+func (m *metadataAPI) SetStreamAlias(ctx context.Context, req *proto.SetStreamAliasOp) *status.Status {
+	// Forward the request if we're not the leader.
+	if !m.IsLeader() {
+		isLeader, st := m.propagateSetStreamAlias(ctx, req)
+		if st != nil {
+			return st
+		}
+		// If we have since become leader, continue on with the request.
+		if !isLeader {
+			return nil
+		}
+	}
+
+	// Replicate the stream alias through Raft.
+	op := &proto.RaftLog{
+		Op:               proto.Op_SET_STREAM_ALIAS,
+		SetStreamAliasOp: req,
+	}
+
+	// Wait on result of setting the alias.
+	future, err := m.getRaft().applyOperation(ctx, op, m.checkSetStreamAliasPreconditions)
+	if err != nil {
+		code := codes.FailedPrecondition
+		switch err {
+		case ErrStreamNotFound:
+			return notFoundStatus(err, req.Stream)
+		case ErrStreamAliasNotFound:
 			code = codes.NotFound
+		case ErrStreamExists:
+			code = codes.AlreadyExists
 		}
 		return status.Newf(code, err.Error())
 	}
 	if err := future.Error(); err != nil {
-		return status.Newf(codes.Internal, "Failed to set stream readonly flag: %v", err.Error())
+		return status.Newf(codes.Internal, "Failed to set stream alias: %v", err.Error())
+	}
+
+	return nil
+}
+
+// SetDerivedOffset checkpoints the progress of a derived stream if this server
+// is the metadata leader. If it is not, it will forward the request to the
+// leader and return the response. This operation is replicated by Raft. If
+// successful, this will return once the offset has been recorded.
+func (m *metadataAPI) SetDerivedOffset(ctx context.Context, req *proto.SetDerivedOffsetOp) *status.Status {
+	// Forward the request if we're not the leader.
+	if !m.IsLeader() {
+		isLeader, st := m.propagateSetDerivedOffset(ctx, req)
+		if st != nil {
+			return st
+		}
+		// If we have since become leader, continue on with the request.
+		if !isLeader {
+			return nil
+		}
+	}
+
+	// Replicate the derived offset through Raft.
+	op := &proto.RaftLog{
+		Op:                 proto.Op_SET_DERIVED_OFFSET,
+		SetDerivedOffsetOp: req,
+	}
+
+	// Wait on result of setting the offset.
+	future, err := m.getRaft().applyOperation(ctx, op, m.checkSetDerivedOffsetPreconditions)
+	if err != nil {
+		if err == ErrStreamNotFound {
+			return notFoundStatus(err, req.Stream)
+		}
+		return status.Newf(codes.FailedPrecondition, err.Error())
+	}
+	if err := future.Error(); err != nil {
+		return status.Newf(codes.Internal, "Failed to set derived offset: %v", err.Error())
+	}
+
+	return nil
+}
+
+// RenameStream renames a stream if this server is the metadata leader. If it
+// is not, it will forward the request to the leader and return the response.
+// This operation is replicated by Raft. If successful, this will return once
+// the stream has been renamed.
+func (m *metadataAPI) RenameStream(ctx context.Context, req *proto.RenameStreamOp) *status.Status {
+	// Forward the request if we're not the leader.
+	if !m.IsLeader() {
+		isLeader, st := m.propagateRenameStream(ctx, req)
+		if st != nil {
+			return st
+		}
+		// If we have since become leader, continue on with the request.
+		if !isLeader {
+			return nil
+		}
+	}
+
+	// Replicate the stream rename through Raft.
+	op := &proto.RaftLog{
+		Op:             proto.Op_RENAME_STREAM,
+		RenameStreamOp: req,
+	}
+
+	// Wait on result of renaming.
+	future, err := m.getRaft().applyOperation(ctx, op, m.checkRenameStreamPreconditions)
+	if err != nil {
+		code := codes.FailedPrecondition
+		switch err {
+		case ErrStreamNotFound:
+			code = codes.NotFound
+		case ErrStreamExists:
+			code = codes.AlreadyExists
+		case ErrInternalStreamRename:
+			code = codes.InvalidArgument
+		}
+		return status.Newf(code, err.Error())
+	}
+	if err := future.Error(); err != nil {
+		return status.Newf(codes.Internal, "Failed to rename stream: %v", err.Error())
 	}
 
 	return nil
@@ -726,10 +887,14 @@ func (m *metadataAPI) AddStream(protoStream *proto.Stream, recovered bool) (*str
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	_, ok := m.streams[protoStream.Name]
-	if ok {
+	if m.getStream(protoStream.Name) != nil {
 		return nil, ErrStreamExists
 	}
+	for _, alias := range protoStream.Aliases {
+		if alias == protoStream.Name || m.getStream(alias) != nil {
+			return nil, ErrStreamExists
+		}
+	}
 
 	config := protoStream.GetConfig()
 	creationTime := time.Unix(0, protoStream.CreationTimestamp)
@@ -743,6 +908,18 @@ func (m *metadataAPI) AddStream(protoStream *proto.Stream, recovered bool) (*str
 		}
 	}
 
+	for _, alias := range protoStream.Aliases {
+		stream.addAlias(alias)
+		m.aliases[alias] = protoStream.Name
+	}
+	if protoStream.DisplayName != "" {
+		stream.setDisplayName(protoStream.DisplayName)
+	}
+
+	for _, derived := range protoStream.DerivedOffsets {
+		stream.setDerivedOffset(derived.Partition, derived.Offset)
+	}
+
 	// Update broker load counts.
 	for _, partition := range stream.GetPartitions() {
 		for _, broker := range partition.Replicas {
@@ -788,8 +965,8 @@ func (m *metadataAPI) ResumePartition(streamName string, id int32, recovered boo
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	stream, ok := m.streams[streamName]
-	if !ok {
+	stream := m.getStream(streamName)
+	if stream == nil {
 		return nil, ErrStreamNotFound
 	}
 	partition := stream.GetPartition(id)
@@ -825,6 +1002,34 @@ func (m *metadataAPI) ResumePartition(streamName string, id int32, recovered boo
 	return partition, err
 }
 
+// RebuildPartition replaces the given stream partition, which failed along
+// with its data directory, with an empty one in an online data directory and
+// starts it. It returns ErrPartitionNotFound if there is no partition with the
+// ID for the stream.
+func (m *metadataAPI) RebuildPartition(streamName string, id int32) (*partition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stream := m.getStream(streamName)
+	if stream == nil {
+		return nil, ErrStreamNotFound
+	}
+	partition := stream.GetPartition(id)
+	if partition == nil {
+		return nil, ErrPartitionNotFound
+	}
+
+	rebuilt, err := m.replacePartition(partition, false, stream.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	stream.SetPartition(id, rebuilt)
+
+	// Start following the leader to replicate its log.
+	leader, epoch := rebuilt.GetLeader()
+	return rebuilt, rebuilt.SetLeader(leader, epoch)
+}
+
 // RemoveFromISR removes the given replica from the partition's ISR if the
 // given epoch is greater than the current epoch.
 func (m *metadataAPI) RemoveFromISR(streamName, replica string, partitionID int32, epoch uint64) error {
@@ -947,6 +1152,103 @@ func (m *metadataAPI) SetReadonly(streamName string, partitions []int32, readonl
 	return nil
 }
 
+// AddStreamAlias adds an alias the stream can be referenced by. It returns
+// ErrStreamExists if the alias is already the name or alias of a stream.
+func (m *metadataAPI) AddStreamAlias(streamName, alias string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stream := m.getStream(streamName)
+	if stream == nil {
+		return ErrStreamNotFound
+	}
+	if m.getStream(alias) != nil {
+		return ErrStreamExists
+	}
+
+	stream.addAlias(alias)
+	m.aliases[alias] = stream.GetName()
+	return nil
+}
+
+// RemoveStreamAlias removes an alias from the stream. It returns
+// ErrStreamAliasNotFound if the stream does not have the alias.
+func (m *metadataAPI) RemoveStreamAlias(streamName, alias string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stream := m.getStream(streamName)
+	if stream == nil {
+		return ErrStreamNotFound
+	}
+	if !stream.HasAlias(alias) {
+		return ErrStreamAliasNotFound
+	}
+	if stream.GetDisplayName() == alias {
+		return ErrStreamAliasInUse
+	}
+
+	stream.removeAlias(alias)
+	delete(m.aliases, alias)
+	return nil
+}
+
+// SetStreamName renames a stream by swapping the name it's listed under with
+// the given name, which becomes an alias if it isn't one already. The previous
+// name remains an alias, so the stream can still be referenced by it. Since
+// data and cursors are stored under the name the stream was created with,
+// that name always refers to the stream. If the name already refers to
+// another stream, it returns ErrStreamExists unless replace is set. In that
+// case, the other stream is deleted if the name is its name, or the alias is
+// moved if it's one of its aliases, in the same operation.
+func (m *metadataAPI) SetStreamName(streamName, name string, replace bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stream := m.getStream(streamName)
+	if stream == nil {
+		return ErrStreamNotFound
+	}
+	if isInternalStream(stream.GetName()) {
+		return ErrInternalStreamRename
+	}
+	if existing := m.getStream(name); existing != nil && existing != stream {
+		if !replace {
+			return ErrStreamExists
+		}
+		if isInternalStream(existing.GetName()) {
+			return ErrInternalStreamRename
+		}
+		if existing.GetName() == name || existing.GetDisplayName() == name {
+			if err := m.closeAndDeleteStream(existing); err != nil {
+				return err
+			}
+		} else {
+			existing.removeAlias(name)
+			delete(m.aliases, name)
+		}
+	}
+
+	if name != stream.GetName() && !stream.HasAlias(name) {
+		stream.addAlias(name)
+		m.aliases[name] = stream.GetName()
+	}
+	stream.setDisplayName(name)
+	return nil
+}
+
+// setDerivedOffset records the last offset of a source stream partition
+// republished to the given derived stream. It returns ErrStreamNotFound if
+// the stream doesn't exist.
+func (m *metadataAPI) setDerivedOffset(streamName string, partition int32, offset int64) error {
+	stream := m.GetStream(streamName)
+	if stream == nil {
+		return ErrStreamNotFound
+	}
+	stream.setDerivedOffset(partition, offset)
+	return nil
+}
+
 // GetStreams returns all streams from the metadata store.
 func (m *metadataAPI) GetStreams() []*stream {
 	m.mu.RLock()
@@ -954,21 +1256,21 @@ func (m *metadataAPI) GetStreams() []*stream {
 	return m.getStreams()
 }
 
-// GetStream returns the stream with the given name or nil if no such stream
-// exists.
+// GetStream returns the stream with the given name or alias or nil if no such
+// stream exists.
 func (m *metadataAPI) GetStream(name string) *stream {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.streams[name]
+	return m.getStream(name)
 }
 
-// GetPartition returns the stream partition for the given stream and partition
-// ID. It returns nil if no such partition exists.
+// GetPartition returns the stream partition for the given stream name or alias
+// and partition ID. It returns nil if no such partition exists.
 func (m *metadataAPI) GetPartition(streamName string, id int32) *partition {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	stream, ok := m.streams[streamName]
-	if !ok {
+	stream := m.getStream(streamName)
+	if stream == nil {
 		return nil
 	}
 	return stream.GetPartition(id)
@@ -985,6 +1287,7 @@ func (m *metadataAPI) Reset() error {
 		}
 	}
 	m.streams = make(map[string]*stream)
+	m.aliases = make(map[string]string)
 	for _, report := range m.leaderReports {
 		report.cancel()
 	}
@@ -997,20 +1300,29 @@ func (m *metadataAPI) Reset() error {
 func (m *metadataAPI) CloseAndDeleteStream(stream *stream) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.closeAndDeleteStream(stream)
+}
 
+// closeAndDeleteStream closes a stream and clears corresponding state in the
+// metadata store. This must be called while holding the metadataAPI lock.
+func (m *metadataAPI) closeAndDeleteStream(stream *stream) error {
 	err := stream.Delete()
 	if err != nil {
 		return errors.Wrap(err, "failed to delete stream")
 	}
 
-	// Remove the stream data directory
-	streamDataDir := filepath.Join(m.Server.config.DataDir, "streams", stream.GetName())
-	err = os.RemoveAll(streamDataDir)
-	if err != nil {
-		return errors.Wrap(err, "failed to delete stream data directory")
+	// Remove the stream data directories
+	for _, dir := range m.dataDirs.online() {
+		streamDataDir := filepath.Join(dir.path, "streams", stream.GetName())
+		if err := os.RemoveAll(streamDataDir); err != nil {
+			return errors.Wrap(err, "failed to delete stream data directory")
+		}
 	}
 
 	delete(m.streams, stream.GetName())
+	for _, alias := range stream.GetAliases() {
+		delete(m.aliases, alias)
+	}
 
 	for _, partition := range stream.GetPartitions() {
 		report, ok := m.leaderReports[partition]
@@ -1045,6 +1357,18 @@ func (m *metadataAPI) LostLeadership() {
 	m.leaderReports = make(map[*partition]*leaderReport)
 }
 
+// getStream returns the stream with the given name or alias or nil if no such
+// stream exists. This must be called while holding the metadataAPI lock.
+func (m *metadataAPI) getStream(name string) *stream {
+	if stream, ok := m.streams[name]; ok {
+		return stream
+	}
+	if streamName, ok := m.aliases[name]; ok {
+		return m.streams[streamName]
+	}
+	return nil
+}
+
 func (m *metadataAPI) getStreams() []*stream {
 	streams := make([]*stream, 0, len(m.streams))
 	for _, stream := range m.streams {
@@ -1130,8 +1454,9 @@ func (m *metadataAPI) electNewPartitionLeader(ctx context.Context, partition *pa
 	op := &proto.RaftLog{
 		Op: proto.Op_CHANGE_LEADER,
 		ChangeLeaderOp: &proto.ChangeLeaderOp{
-			Stream: partition.Stream,
-			Leader: leader,
+			Stream:    partition.Stream,
+			Partition: partition.Id,
+			Leader:    leader,
 		},
 	}
 
@@ -1241,6 +1566,42 @@ func (m *metadataAPI) propagateSetStreamReadonly(ctx context.Context, req *proto
 	return m.propagateRequest(ctx, propagate)
 }
 
+// propagateSetStreamAlias forwards a SetStreamAlias request to the metadata
+// leader. The bool indicates if this server has since become leader and the
+// request should be performed locally. A Status is returned if the propagated
+// request failed.
+func (m *metadataAPI) propagateSetStreamAlias(ctx context.Context, req *proto.SetStreamAliasOp) (bool, *status.Status) {
+	propagate := &proto.PropagatedRequest{
+		Op:               proto.Op_SET_STREAM_ALIAS,
+		SetStreamAliasOp: req,
+	}
+	return m.propagateRequest(ctx, propagate)
+}
+
+// propagateSetDerivedOffset forwards a SetDerivedOffset request to the
+// metadata leader. The bool indicates if this server has since become leader
+// and the request should be performed locally. A Status is returned if the
+// propagated request failed.
+func (m *metadataAPI) propagateSetDerivedOffset(ctx context.Context, req *proto.SetDerivedOffsetOp) (bool, *status.Status) {
+	propagate := &proto.PropagatedRequest{
+		Op:                 proto.Op_SET_DERIVED_OFFSET,
+		SetDerivedOffsetOp: req,
+	}
+	return m.propagateRequest(ctx, propagate)
+}
+
+// propagateRenameStream forwards a RenameStream request to the metadata
+// leader. The bool indicates if this server has since become leader and the
+// request should be performed locally. A Status is returned if the propagated
+// request failed.
+func (m *metadataAPI) propagateRenameStream(ctx context.Context, req *proto.RenameStreamOp) (bool, *status.Status) {
+	propagate := &proto.PropagatedRequest{
+		Op:             proto.Op_RENAME_STREAM,
+		RenameStreamOp: req,
+	}
+	return m.propagateRequest(ctx, propagate)
+}
+
 // propagateRequest forwards a metadata request to the metadata leader. The
 // bool indicates if this server has since become leader and the request should
 // be performed locally. A Status is returned if the propagated request failed.
@@ -1264,7 +1625,7 @@ func (m *metadataAPI) propagateRequest(ctx context.Context, req *proto.Propagate
 	ctx, cancel := ensureTimeout(ctx, defaultPropagateTimeout)
 	defer cancel()
 
-	resp, err := m.nc.RequestWithContext(ctx, m.getPropagateInbox(), data)
+	resp, err := m.ncRaft.RequestWithContext(ctx, m.getPropagateInbox(), data)
 	if err != nil {
 		return false, status.New(codes.Internal, err.Error())
 	}
@@ -1421,6 +1782,67 @@ func (m *metadataAPI) checkSetStreamReadonlyPreconditions(op *proto.RaftLog) err
 	return nil
 }
 
+// checkSetStreamAliasPreconditions checks if the stream whose alias is being
+// set exists. If it doesn't, it returns ErrStreamNotFound. If an alias is being
+// added and it is already the name or alias of a stream, it returns
+// ErrStreamExists. If an alias is being removed and the stream doesn't have
+// it, it returns ErrStreamAliasNotFound. Otherwise, it returns nil.
+func (m *metadataAPI) checkSetStreamAliasPreconditions(op *proto.RaftLog) error {
+	stream := m.GetStream(op.SetStreamAliasOp.Stream)
+	if stream == nil {
+		return ErrStreamNotFound
+	}
+	if op.SetStreamAliasOp.Remove {
+		if !stream.HasAlias(op.SetStreamAliasOp.Alias) {
+			return ErrStreamAliasNotFound
+		}
+		if stream.GetDisplayName() == op.SetStreamAliasOp.Alias {
+			return ErrStreamAliasInUse
+		}
+		return nil
+	}
+	if m.GetStream(op.SetStreamAliasOp.Alias) != nil {
+		return ErrStreamExists
+	}
+	return nil
+}
+
+// checkSetDerivedOffsetPreconditions checks if the derived stream whose
+// offset is being set exists. If it doesn't, it returns ErrStreamNotFound.
+// Otherwise, it returns nil.
+func (m *metadataAPI) checkSetDerivedOffsetPreconditions(op *proto.RaftLog) error {
+	if m.GetStream(op.SetDerivedOffsetOp.Stream) == nil {
+		return ErrStreamNotFound
+	}
+	return nil
+}
+
+// checkRenameStreamPreconditions checks if the stream being renamed exists. If
+// it doesn't, it returns ErrStreamNotFound. If the stream or the stream the new
+// name refers to is internal, it returns ErrInternalStreamRename. If the new
+// name refers to another stream and the rename doesn't replace it, it returns
+// ErrStreamExists. Otherwise, it returns nil.
+func (m *metadataAPI) checkRenameStreamPreconditions(op *proto.RaftLog) error {
+	stream := m.GetStream(op.RenameStreamOp.Stream)
+	if stream == nil {
+		return ErrStreamNotFound
+	}
+	if isInternalStream(stream.GetName()) {
+		return ErrInternalStreamRename
+	}
+	existing := m.GetStream(op.RenameStreamOp.Name)
+	if existing == nil || existing == stream {
+		return nil
+	}
+	if !op.RenameStreamOp.Replace {
+		return ErrStreamExists
+	}
+	if isInternalStream(existing.GetName()) {
+		return ErrInternalStreamRename
+	}
+	return nil
+}
+
 // checkResumeStreamPreconditions checks if the stream and partitions to be
 // resumed exist. If the stream does not exist, it returns ErrStreamNotFound.
 // If any partitions do not exist, it returns ErrPartitionNotFound. Otherwise,
@@ -78,6 +78,144 @@ func TestMetadataAddStreamAlreadyExists(t *testing.T) {
 	require.Equal(t, ErrStreamExists, err)
 }
 
+// Ensure streams can be looked up by their aliases and aliases cannot collide
+// with stream names or other aliases.
+func TestMetadataStreamAliases(t *testing.T) {
+	defer cleanupStorage(t)
+
+	server := New(getTestConfig("a", true, 0))
+	metadata := newMetadataAPI(server)
+	defer metadata.Reset()
+
+	_, err := metadata.AddStream(&proto.Stream{
+		Name:    "foo",
+		Subject: "foo",
+		Partitions: []*proto.Partition{
+			{
+				Stream:  "foo",
+				Subject: "foo",
+				Id:      0,
+			},
+		},
+		Aliases: []string{"bar"},
+	}, false)
+	require.NoError(t, err)
+
+	require.Equal(t, "foo", metadata.GetStream("bar").GetName())
+	require.NotNil(t, metadata.GetPartition("bar", 0))
+	require.Nil(t, metadata.GetStream("baz"))
+
+	// Aliases cannot be used as stream names.
+	_, err = metadata.AddStream(&proto.Stream{
+		Name:    "bar",
+		Subject: "bar",
+		Partitions: []*proto.Partition{
+			{
+				Stream:  "bar",
+				Subject: "bar",
+				Id:      0,
+			},
+		},
+	}, false)
+	require.Equal(t, ErrStreamExists, err)
+
+	require.NoError(t, metadata.AddStreamAlias("bar", "baz"))
+	require.Equal(t, []string{"bar", "baz"}, metadata.GetStream("foo").GetAliases())
+	require.Equal(t, ErrStreamExists, metadata.AddStreamAlias("foo", "foo"))
+	require.Equal(t, ErrStreamExists, metadata.AddStreamAlias("foo", "baz"))
+	require.Equal(t, ErrStreamNotFound, metadata.AddStreamAlias("qux", "quux"))
+
+	err = metadata.checkSetStreamAliasPreconditions(&proto.RaftLog{
+		Op:               proto.Op_SET_STREAM_ALIAS,
+		SetStreamAliasOp: &proto.SetStreamAliasOp{Stream: "foo", Alias: "bar"},
+	})
+	require.Equal(t, ErrStreamExists, err)
+	err = metadata.checkSetStreamAliasPreconditions(&proto.RaftLog{
+		Op:               proto.Op_SET_STREAM_ALIAS,
+		SetStreamAliasOp: &proto.SetStreamAliasOp{Stream: "foo", Alias: "qux", Remove: true},
+	})
+	require.Equal(t, ErrStreamAliasNotFound, err)
+
+	require.NoError(t, metadata.RemoveStreamAlias("foo", "bar"))
+	require.Equal(t, ErrStreamAliasNotFound, metadata.RemoveStreamAlias("foo", "bar"))
+	require.Nil(t, metadata.GetStream("bar"))
+	require.Equal(t, []string{"baz"}, metadata.GetStream("foo").GetAliases())
+
+	// Deleting the stream removes its aliases.
+	require.NoError(t, metadata.CloseAndDeleteStream(metadata.GetStream("foo")))
+	require.Nil(t, metadata.GetStream("baz"))
+}
+
+// Ensure renaming a stream swaps its name with an alias, and replacing the
+// stream a name refers to deletes it or moves its alias.
+func TestMetadataStreamRename(t *testing.T) {
+	defer cleanupStorage(t)
+
+	server := New(getTestConfig("a", true, 0))
+	metadata := newMetadataAPI(server)
+	defer metadata.Reset()
+
+	for _, name := range []string{"foo", "foo-v2", "__cursors"} {
+		_, err := metadata.AddStream(&proto.Stream{
+			Name:    name,
+			Subject: name,
+			Partitions: []*proto.Partition{
+				{
+					Stream:  name,
+					Subject: name,
+					Id:      0,
+				},
+			},
+		}, false)
+		require.NoError(t, err)
+	}
+	require.NoError(t, metadata.AddStreamAlias("foo", "bar"))
+
+	require.Equal(t, ErrStreamExists, metadata.SetStreamName("foo-v2", "foo", false))
+	require.Equal(t, ErrStreamNotFound, metadata.SetStreamName("baz", "qux", false))
+	require.Equal(t, ErrInternalStreamRename, metadata.SetStreamName("__cursors", "qux", false))
+	require.Equal(t, ErrInternalStreamRename, metadata.SetStreamName("foo-v2", "__cursors", true))
+	err := metadata.checkRenameStreamPreconditions(&proto.RaftLog{
+		Op:             proto.Op_RENAME_STREAM,
+		RenameStreamOp: &proto.RenameStreamOp{Stream: "foo-v2", Name: "bar"},
+	})
+	require.Equal(t, ErrStreamExists, err)
+	err = metadata.checkRenameStreamPreconditions(&proto.RaftLog{
+		Op:             proto.Op_RENAME_STREAM,
+		RenameStreamOp: &proto.RenameStreamOp{Stream: "foo-v2", Name: "bar", Replace: true},
+	})
+	require.NoError(t, err)
+
+	// Renaming to a new name swaps it with the stream's name.
+	require.NoError(t, metadata.SetStreamName("foo-v2", "baz", false))
+	stream := metadata.GetStream("baz")
+	require.Equal(t, "foo-v2", stream.GetName())
+	require.Equal(t, "baz", stream.GetDisplayName())
+	require.Equal(t, []string{"baz"}, stream.GetAliases())
+	require.Equal(t, stream, metadata.GetStream("foo-v2"))
+	require.Equal(t, ErrStreamAliasInUse, metadata.RemoveStreamAlias("foo-v2", "baz"))
+
+	// Replacing an alias of another stream moves it.
+	require.NoError(t, metadata.SetStreamName("baz", "bar", true))
+	require.Equal(t, "bar", stream.GetDisplayName())
+	require.Equal(t, stream, metadata.GetStream("bar"))
+	require.Empty(t, metadata.GetStream("foo").GetAliases())
+	require.NoError(t, metadata.RemoveStreamAlias("bar", "baz"))
+
+	// Replacing another stream's name deletes the stream.
+	require.NoError(t, metadata.SetStreamName("bar", "foo", true))
+	require.Equal(t, stream, metadata.GetStream("foo"))
+	require.Equal(t, "foo", stream.GetDisplayName())
+	require.Equal(t, []string{"bar", "foo"}, stream.GetAliases())
+	require.Len(t, metadata.GetStreams(), 2)
+
+	// Renaming back to the stream's name keeps the other names as aliases.
+	require.NoError(t, metadata.SetStreamName("foo", "foo-v2", false))
+	require.Equal(t, "foo-v2", stream.GetDisplayName())
+	require.NoError(t, metadata.RemoveStreamAlias("foo-v2", "foo"))
+	require.Nil(t, metadata.GetStream("foo"))
+}
+
 // Ensure addPartition returns an error if the partition already exists.
 func TestMetadataAddPartitionAlreadyExists(t *testing.T) {
 	defer cleanupStorage(t)
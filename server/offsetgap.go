@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// LogStartOffsetMetadata is the Subscribe and FetchPartitionMetadata response
+// header metadata key containing the partition's log start offset. Offsets
+// before it were deleted, e.g. by retention. Consumers can compare it with
+// the offset they last processed to detect data loss.
+const LogStartOffsetMetadata = "liftbridge-log-start-offset"
+
+// GapFirstOffsetMetadata and GapReasonMetadata are the Subscribe response
+// header metadata keys set when the start offset requested by a subscription
+// is no longer in the log. They contain the offset the subscription starts at
+// instead, which is the first available offset after the requested one, and
+// the reason the requested offset is missing, which is "deleted" if it is
+// before the log start offset and "compacted" if it was removed by
+// compaction.
+const (
+	GapFirstOffsetMetadata = "liftbridge-gap-first-offset"
+	GapReasonMetadata      = "liftbridge-gap-reason"
+)
+
+// FailOnGapMetadata is the Subscribe request metadata key which, if "true",
+// causes a subscription to fail with an OutOfRange status if its requested
+// start offset is no longer in the log instead of starting at the first
+// available offset after it.
+const FailOnGapMetadata = "liftbridge-fail-on-gap"
+
+const (
+	gapReasonDeleted   = "deleted"
+	gapReasonCompacted = "compacted"
+)
+
+// offsetGap describes a requested offset which is no longer in a log.
+type offsetGap struct {
+	offset      int64
+	firstOffset int64
+	reason      string
+}
+
+// String returns a human-readable description of the gap.
+func (g *offsetGap) String() string {
+	return fmt.Sprintf("offset %d was %s, first available offset is %d",
+		g.offset, g.reason, g.firstOffset)
+}
+
+// logStartOffsetHeader returns response header metadata containing the log
+// start offset of the given log.
+func logStartOffsetHeader(log commitlog.CommitLog) metadata.MD {
+	return metadata.Pairs(LogStartOffsetMetadata, strconv.FormatInt(log.LogStartOffset(), 10))
+}
+
+// setLogStartOffsetHeader sets the response header metadata of a unary
+// request with the log start offset of the given log.
+func setLogStartOffsetHeader(ctx context.Context, log commitlog.CommitLog) {
+	grpc.SetHeader(ctx, logStartOffsetHeader(log)) // nolint: errcheck
+}
+
+// failOnGapFromContext indicates if the incoming request metadata asks for a
+// subscription to fail if its start offset is no longer in the log.
+func failOnGapFromContext(ctx context.Context) (bool, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false, nil
+	}
+	values := md.Get(FailOnGapMetadata)
+	if len(values) == 0 {
+		return false, nil
+	}
+	fail, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", FailOnGapMetadata, values[0]))
+	}
+	return fail, nil
+}
+
+// findOffsetGap returns the gap in the log at the given offset or nil if the
+// offset is in the log or has not been written yet.
+func findOffsetGap(log commitlog.CommitLog, offset int64) (*offsetGap, error) {
+	if offset < 0 || offset > log.HighWatermark() {
+		return nil, nil
+	}
+	first, err := log.EarliestOffsetAfter(offset)
+	if err != nil {
+		return nil, err
+	}
+	if first == offset {
+		return nil, nil
+	}
+	reason := gapReasonCompacted
+	if offset < log.LogStartOffset() {
+		reason = gapReasonDeleted
+	}
+	return &offsetGap{offset: offset, firstOffset: first, reason: reason}, nil
+}
+
+// checkOffsetContinuity sets the response header metadata of a subscription
+// starting at the given offset with the log start offset and any gap at the
+// start offset. It returns an OutOfRange status if there is a gap and the
+// subscription asked to fail on gaps.
+func checkOffsetContinuity(ctx context.Context, req *client.SubscribeRequest, log commitlog.CommitLog,
+	startOffset int64) *status.Status {
+
+	failOnGap, st := failOnGapFromContext(ctx)
+	if st != nil {
+		return st
+	}
+	md := logStartOffsetHeader(log)
+	if req.StartPosition == client.StartPosition_OFFSET {
+		gap, err := findOffsetGap(log, startOffset)
+		if err != nil {
+			return status.New(codes.Internal, fmt.Sprintf("Failed to check start offset: %v", err))
+		}
+		if gap != nil {
+			if failOnGap {
+				return status.New(codes.OutOfRange, fmt.Sprintf("Start %s", gap))
+			}
+			md.Append(GapFirstOffsetMetadata, strconv.FormatInt(gap.firstOffset, 10))
+			md.Append(GapReasonMetadata, gap.reason)
+		}
+	}
+	// This fails for internal subscriptions, which don't have a gRPC stream.
+	grpc.SetHeader(ctx, md) // nolint: errcheck
+	return nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure subscriptions starting at offsets which are no longer in the log
+// report the gap and the log start offset.
+func TestSubscribeOffsetGap(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.Compact = true
+	s1Config.Streams.SegmentMaxBytes = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	for _, key := range []string{"a", "b", "a", "b", "c", "c"} {
+		_, err = api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Key:       []byte(key),
+			Value:     []byte("value"),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	// Each message is in its own segment, so compaction deletes offsets 0
+	// and 1 from the start of the log and offset 4 from the middle.
+	partition := s1.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+	require.NoError(t, partition.log.Clean(context.Background()))
+	require.Equal(t, int64(2), partition.log.LogStartOffset())
+
+	subscribe := func(ctx context.Context, offset int64) (metadata.MD, *client.Message, error) {
+		sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+			Stream:        "foo",
+			StartPosition: client.StartPosition_OFFSET,
+			StartOffset:   offset,
+		})
+		require.NoError(t, err)
+		if _, err := sub.Recv(); err != nil {
+			return nil, nil, err
+		}
+		header, err := sub.Header()
+		require.NoError(t, err)
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		return header, msg, nil
+	}
+
+	header, msg, err := subscribe(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{"2"}, header.Get(LogStartOffsetMetadata))
+	require.Equal(t, []string{"2"}, header.Get(GapFirstOffsetMetadata))
+	require.Equal(t, []string{gapReasonDeleted}, header.Get(GapReasonMetadata))
+	require.Equal(t, int64(2), msg.Offset)
+
+	header, msg, err = subscribe(ctx, 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{"5"}, header.Get(GapFirstOffsetMetadata))
+	require.Equal(t, []string{gapReasonCompacted}, header.Get(GapReasonMetadata))
+	require.Equal(t, int64(5), msg.Offset)
+
+	header, msg, err = subscribe(ctx, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{"2"}, header.Get(LogStartOffsetMetadata))
+	require.Empty(t, header.Get(GapFirstOffsetMetadata))
+	require.Equal(t, int64(3), msg.Offset)
+
+	_, _, err = subscribe(metadata.AppendToOutgoingContext(ctx, FailOnGapMetadata, "true"), 0)
+	require.Error(t, err)
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	header = metadata.MD{}
+	_, err = api.FetchPartitionMetadata(ctx, &client.FetchPartitionMetadataRequest{Stream: "foo"},
+		grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{"2"}, header.Get(LogStartOffsetMetadata))
+}
@@ -0,0 +1,147 @@
+package parquet
+
+import "encoding/binary"
+
+// Thrift compact protocol type identifiers.
+const (
+	compactBoolTrue  = 1
+	compactBoolFalse = 2
+	compactI32       = 5
+	compactI64       = 6
+	compactBinary    = 8
+	compactList      = 9
+	compactStruct    = 12
+)
+
+// compactEncoder serializes Thrift structs using the compact protocol, which
+// is what Parquet uses for page headers and the file footer. Only the subset
+// of the protocol needed to write Parquet metadata is implemented.
+type compactEncoder struct {
+	buf         []byte
+	lastFieldID []int16
+}
+
+func newCompactEncoder() *compactEncoder {
+	return &compactEncoder{lastFieldID: []int16{0}}
+}
+
+// Bytes returns the encoded data.
+func (e *compactEncoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *compactEncoder) fieldHeader(id int16, typ byte) {
+	last := e.lastFieldID[len(e.lastFieldID)-1]
+	if delta := id - last; delta > 0 && delta <= 15 {
+		e.buf = append(e.buf, byte(delta)<<4|typ)
+	} else {
+		e.buf = append(e.buf, typ)
+		e.putVarint(int64(id))
+	}
+	e.lastFieldID[len(e.lastFieldID)-1] = id
+}
+
+func (e *compactEncoder) putVarint(v int64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(scratch[:], v)
+	e.buf = append(e.buf, scratch[:n]...)
+}
+
+func (e *compactEncoder) putUvarint(v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], v)
+	e.buf = append(e.buf, scratch[:n]...)
+}
+
+func (e *compactEncoder) putBinary(b []byte) {
+	e.putUvarint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *compactEncoder) listHeader(size int, elemType byte) {
+	if size < 15 {
+		e.buf = append(e.buf, byte(size)<<4|elemType)
+		return
+	}
+	e.buf = append(e.buf, 0xf0|elemType)
+	e.putUvarint(uint64(size))
+}
+
+// BoolField writes a boolean field.
+func (e *compactEncoder) BoolField(id int16, v bool) {
+	if v {
+		e.fieldHeader(id, compactBoolTrue)
+	} else {
+		e.fieldHeader(id, compactBoolFalse)
+	}
+}
+
+// I32Field writes a 32-bit integer field.
+func (e *compactEncoder) I32Field(id int16, v int32) {
+	e.fieldHeader(id, compactI32)
+	e.putVarint(int64(v))
+}
+
+// I64Field writes a 64-bit integer field.
+func (e *compactEncoder) I64Field(id int16, v int64) {
+	e.fieldHeader(id, compactI64)
+	e.putVarint(v)
+}
+
+// StringField writes a string field.
+func (e *compactEncoder) StringField(id int16, v string) {
+	e.fieldHeader(id, compactBinary)
+	e.putBinary([]byte(v))
+}
+
+// I32ListField writes a list of 32-bit integers.
+func (e *compactEncoder) I32ListField(id int16, vs []int32) {
+	e.fieldHeader(id, compactList)
+	e.listHeader(len(vs), compactI32)
+	for _, v := range vs {
+		e.putVarint(int64(v))
+	}
+}
+
+// StringListField writes a list of strings.
+func (e *compactEncoder) StringListField(id int16, vs []string) {
+	e.fieldHeader(id, compactList)
+	e.listHeader(len(vs), compactBinary)
+	for _, v := range vs {
+		e.putBinary([]byte(v))
+	}
+}
+
+// StructListField writes a list of structs, invoking fn to encode the fields
+// of the element at each index.
+func (e *compactEncoder) StructListField(id int16, size int, fn func(i int)) {
+	e.fieldHeader(id, compactList)
+	e.listHeader(size, compactStruct)
+	for i := 0; i < size; i++ {
+		e.beginStruct()
+		fn(i)
+		e.endStruct()
+	}
+}
+
+// StructField writes a nested struct field, invoking fn to encode its fields.
+func (e *compactEncoder) StructField(id int16, fn func()) {
+	e.fieldHeader(id, compactStruct)
+	e.beginStruct()
+	fn()
+	e.endStruct()
+}
+
+func (e *compactEncoder) beginStruct() {
+	e.lastFieldID = append(e.lastFieldID, 0)
+}
+
+func (e *compactEncoder) endStruct() {
+	e.buf = append(e.buf, 0) // STOP
+	e.lastFieldID = e.lastFieldID[:len(e.lastFieldID)-1]
+}
+
+// End terminates the top-level struct.
+func (e *compactEncoder) End() {
+	e.buf = append(e.buf, 0) // STOP
+}
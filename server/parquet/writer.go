@@ -0,0 +1,361 @@
+// Package parquet implements a minimal Apache Parquet writer for exporting
+// stream messages to a columnar format that can be queried by analytics tools
+// such as DuckDB, Spark, or pandas.
+//
+// Files are written with a fixed schema (offset, timestamp, key, headers,
+// value) using PLAIN encoding and no compression. Each batch of buffered rows
+// is flushed as a row group containing a single data page per column.
+package parquet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+const (
+	magic = "PAR1"
+
+	// DefaultRowGroupSize is the number of rows buffered before a row group
+	// is flushed if no size is specified.
+	DefaultRowGroupSize = 10000
+
+	createdBy = "liftbridge"
+)
+
+// Parquet physical types.
+const (
+	typeInt64     = 2
+	typeByteArray = 6
+)
+
+// Parquet field repetition types.
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+)
+
+// Parquet encodings.
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+)
+
+const (
+	convertedTypeUTF8 = 0
+	pageTypeData      = 0
+	codecUncompressed = 0
+)
+
+// ErrWriterClosed is returned when writing to a Writer that has been closed.
+var ErrWriterClosed = errors.New("parquet writer closed")
+
+// Record is a single row in an exported file.
+type Record struct {
+	Offset    int64
+	Timestamp int64 // Unix time in nanoseconds
+	Key       []byte
+	Headers   map[string][]byte
+	Value     []byte
+}
+
+type column struct {
+	name      string
+	typ       int32
+	optional  bool
+	utf8      bool
+	timestamp bool
+}
+
+// schema is the fixed set of columns written for each record. Headers are
+// encoded as a JSON object mapping header names to base64-encoded values.
+var schema = []column{
+	{name: "offset", typ: typeInt64},
+	{name: "timestamp", typ: typeInt64, timestamp: true},
+	{name: "key", typ: typeByteArray, optional: true},
+	{name: "headers", typ: typeByteArray, utf8: true},
+	{name: "value", typ: typeByteArray, optional: true},
+}
+
+type columnChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	size    int64
+	rows    int64
+}
+
+// Writer writes Records to an underlying io.Writer in Parquet format. Close
+// must be called to flush buffered rows and write the file footer. A Writer
+// is not safe for concurrent use.
+type Writer struct {
+	w            io.Writer
+	pos          int64
+	rowGroupSize int
+	rows         []*Record
+	rowGroups    []rowGroup
+	numRows      int64
+	closed       bool
+}
+
+// NewWriter returns a Writer which buffers up to rowGroupSize rows before
+// flushing a row group to w. If rowGroupSize is not positive,
+// DefaultRowGroupSize is used.
+func NewWriter(w io.Writer, rowGroupSize int) *Writer {
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultRowGroupSize
+	}
+	return &Writer{
+		w:            w,
+		rowGroupSize: rowGroupSize,
+		rows:         make([]*Record, 0, rowGroupSize),
+	}
+}
+
+// Write buffers the given Record, flushing a row group if the buffer is full.
+func (w *Writer) Write(rec *Record) error {
+	if w.closed {
+		return ErrWriterClosed
+	}
+	if w.pos == 0 {
+		if err := w.write([]byte(magic)); err != nil {
+			return err
+		}
+	}
+	w.rows = append(w.rows, rec)
+	if len(w.rows) >= w.rowGroupSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// NumRows returns the number of rows written so far, including buffered rows.
+func (w *Writer) NumRows() int64 {
+	return w.numRows + int64(len(w.rows))
+}
+
+// Close flushes any buffered rows and writes the file footer. It does not
+// close the underlying io.Writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if w.pos == 0 {
+		if err := w.write([]byte(magic)); err != nil {
+			return err
+		}
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	w.closed = true
+	footer := w.encodeFooter()
+	if err := w.write(footer); err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	if err := w.write(length[:]); err != nil {
+		return err
+	}
+	return w.write([]byte(magic))
+}
+
+func (w *Writer) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.pos += int64(n)
+	return err
+}
+
+// flush writes the buffered rows as a row group.
+func (w *Writer) flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+	rg := rowGroup{
+		columns: make([]columnChunk, len(schema)),
+		rows:    int64(len(w.rows)),
+	}
+	for i := range schema {
+		page, err := w.encodePage(i)
+		if err != nil {
+			return err
+		}
+		header := encodePageHeader(len(w.rows), len(page))
+		chunk := columnChunk{
+			offset: w.pos,
+			size:   int64(len(header) + len(page)),
+			values: int64(len(w.rows)),
+		}
+		if err := w.write(header); err != nil {
+			return err
+		}
+		if err := w.write(page); err != nil {
+			return err
+		}
+		rg.columns[i] = chunk
+		rg.size += chunk.size
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.numRows += rg.rows
+	w.rows = w.rows[:0]
+	return nil
+}
+
+// encodePage returns the data page body for the column at the given index.
+func (w *Writer) encodePage(idx int) ([]byte, error) {
+	var (
+		col  = schema[idx]
+		buf  []byte
+		defs []bool
+	)
+	if col.optional {
+		defs = make([]bool, len(w.rows))
+	}
+	var scratch [8]byte
+	for i, rec := range w.rows {
+		switch col.name {
+		case "offset":
+			binary.LittleEndian.PutUint64(scratch[:], uint64(rec.Offset))
+			buf = append(buf, scratch[:]...)
+		case "timestamp":
+			binary.LittleEndian.PutUint64(scratch[:], uint64(rec.Timestamp))
+			buf = append(buf, scratch[:]...)
+		case "key":
+			if rec.Key != nil {
+				defs[i] = true
+				buf = appendByteArray(buf, rec.Key)
+			}
+		case "headers":
+			headers := rec.Headers
+			if headers == nil {
+				headers = map[string][]byte{}
+			}
+			data, err := json.Marshal(headers)
+			if err != nil {
+				return nil, err
+			}
+			buf = appendByteArray(buf, data)
+		case "value":
+			if rec.Value != nil {
+				defs[i] = true
+				buf = appendByteArray(buf, rec.Value)
+			}
+		}
+	}
+	if !col.optional {
+		return buf, nil
+	}
+	levels := encodeDefinitionLevels(defs)
+	page := make([]byte, 4, 4+len(levels)+len(buf))
+	binary.LittleEndian.PutUint32(page, uint32(len(levels)))
+	page = append(page, levels...)
+	return append(page, buf...), nil
+}
+
+func appendByteArray(buf, data []byte) []byte {
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(data)))
+	buf = append(buf, length[:]...)
+	return append(buf, data...)
+}
+
+// encodeDefinitionLevels encodes the given definition levels, which have a max
+// level of 1, using the RLE run encoding of the RLE/bit-packing hybrid.
+func encodeDefinitionLevels(defs []bool) []byte {
+	var buf []byte
+	for i := 0; i < len(defs); {
+		j := i
+		for j < len(defs) && defs[j] == defs[i] {
+			j++
+		}
+		var scratch [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(scratch[:], uint64(j-i)<<1)
+		buf = append(buf, scratch[:n]...)
+		if defs[i] {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		i = j
+	}
+	return buf
+}
+
+func encodePageHeader(numValues, size int) []byte {
+	e := newCompactEncoder()
+	e.I32Field(1, pageTypeData)
+	e.I32Field(2, int32(size))
+	e.I32Field(3, int32(size))
+	e.StructField(5, func() {
+		e.I32Field(1, int32(numValues))
+		e.I32Field(2, encodingPlain)
+		e.I32Field(3, encodingRLE)
+		e.I32Field(4, encodingRLE)
+	})
+	e.End()
+	return e.Bytes()
+}
+
+func (w *Writer) encodeFooter() []byte {
+	e := newCompactEncoder()
+	e.I32Field(1, 1) // version
+	e.StructListField(2, len(schema)+1, func(i int) {
+		if i == 0 {
+			e.StringField(4, "schema")
+			e.I32Field(5, int32(len(schema)))
+			return
+		}
+		col := schema[i-1]
+		e.I32Field(1, col.typ)
+		if col.optional {
+			e.I32Field(3, repetitionOptional)
+		} else {
+			e.I32Field(3, repetitionRequired)
+		}
+		e.StringField(4, col.name)
+		if col.utf8 {
+			e.I32Field(6, convertedTypeUTF8)
+		}
+		if col.timestamp {
+			// LogicalType TIMESTAMP(isAdjustedToUTC=true, unit=NANOS).
+			e.StructField(10, func() {
+				e.StructField(8, func() {
+					e.BoolField(1, true)
+					e.StructField(2, func() {
+						e.StructField(3, func() {})
+					})
+				})
+			})
+		}
+	})
+	e.I64Field(3, w.numRows)
+	e.StructListField(4, len(w.rowGroups), func(i int) {
+		rg := w.rowGroups[i]
+		e.StructListField(1, len(rg.columns), func(j int) {
+			chunk := rg.columns[j]
+			col := schema[j]
+			e.I64Field(2, chunk.offset)
+			e.StructField(3, func() {
+				e.I32Field(1, col.typ)
+				e.I32ListField(2, []int32{encodingPlain, encodingRLE})
+				e.StringListField(3, []string{col.name})
+				e.I32Field(4, codecUncompressed)
+				e.I64Field(5, chunk.values)
+				e.I64Field(6, chunk.size)
+				e.I64Field(7, chunk.size)
+				e.I64Field(9, chunk.offset)
+			})
+		})
+		e.I64Field(2, rg.size)
+		e.I64Field(3, rg.rows)
+	})
+	e.StringField(6, createdBy)
+	e.End()
+	return e.Bytes()
+}
@@ -0,0 +1,184 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// compactDecoder is a minimal Thrift compact protocol decoder used to verify
+// the metadata produced by the Writer. Structs are decoded into maps keyed by
+// field ID.
+type compactDecoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *compactDecoder) byte() byte {
+	b := d.buf[d.pos]
+	d.pos++
+	return b
+}
+
+func (d *compactDecoder) varint() int64 {
+	v, n := binary.Varint(d.buf[d.pos:])
+	d.pos += n
+	return v
+}
+
+func (d *compactDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	d.pos += n
+	return v
+}
+
+func (d *compactDecoder) value(typ byte) interface{} {
+	switch typ {
+	case compactBoolTrue:
+		return true
+	case compactBoolFalse:
+		return false
+	case compactI32, compactI64:
+		return d.varint()
+	case compactBinary:
+		n := int(d.uvarint())
+		b := d.buf[d.pos : d.pos+n]
+		d.pos += n
+		return string(b)
+	case compactList:
+		header := d.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(d.uvarint())
+		}
+		elemType := header & 0x0f
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = d.value(elemType)
+		}
+		return list
+	case compactStruct:
+		return d.structure()
+	}
+	panic("unsupported type")
+}
+
+func (d *compactDecoder) structure() map[int16]interface{} {
+	var (
+		fields = map[int16]interface{}{}
+		last   int16
+	)
+	for {
+		header := d.byte()
+		if header == 0 {
+			return fields
+		}
+		typ := header & 0x0f
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(d.varint())
+		}
+		// Booleans are encoded in the field header.
+		fields[id] = d.value(typ)
+		last = id
+	}
+}
+
+func readFooter(t *testing.T, data []byte) map[int16]interface{} {
+	require.Equal(t, magic, string(data[:4]))
+	require.Equal(t, magic, string(data[len(data)-4:]))
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	d := &compactDecoder{buf: footer}
+	fields := d.structure()
+	require.Equal(t, len(footer), d.pos)
+	return fields
+}
+
+// Ensures an empty file contains only the magic bytes and a footer with no
+// row groups.
+func TestWriterEmpty(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, 0)
+	require.NoError(t, w.Close())
+
+	meta := readFooter(t, buf.Bytes())
+	require.Equal(t, int64(1), meta[1])
+	require.Equal(t, int64(0), meta[3])
+	require.Len(t, meta[4], 0)
+	require.Len(t, meta[2], len(schema)+1)
+	require.Equal(t, createdBy, meta[6])
+}
+
+// Ensures records are split into row groups and each column chunk points to a
+// data page with the expected number of values.
+func TestWriterRowGroups(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, 2)
+	for i := 0; i < 5; i++ {
+		rec := &Record{
+			Offset:    int64(i),
+			Timestamp: int64(i * 1000),
+			Headers:   map[string][]byte{"subject": []byte("foo")},
+			Value:     []byte("hello"),
+		}
+		if i%2 == 0 {
+			rec.Key = []byte("key")
+		}
+		require.NoError(t, w.Write(rec))
+	}
+	require.Equal(t, int64(5), w.NumRows())
+	require.NoError(t, w.Close())
+	require.Equal(t, ErrWriterClosed, w.Write(&Record{}))
+
+	data := buf.Bytes()
+	meta := readFooter(t, data)
+	require.Equal(t, int64(5), meta[3])
+
+	rowGroups := meta[4].([]interface{})
+	require.Len(t, rowGroups, 3)
+	expectedRows := []int64{2, 2, 1}
+	for i, rg := range rowGroups {
+		rowGroup := rg.(map[int16]interface{})
+		require.Equal(t, expectedRows[i], rowGroup[3])
+		columns := rowGroup[1].([]interface{})
+		require.Len(t, columns, len(schema))
+		for j, c := range columns {
+			chunk := c.(map[int16]interface{})
+			colMeta := chunk[3].(map[int16]interface{})
+			require.Equal(t, []interface{}{schema[j].name}, colMeta[3])
+			require.Equal(t, expectedRows[i], colMeta[5])
+
+			// Decode the page header at the data page offset.
+			d := &compactDecoder{buf: data, pos: int(colMeta[9].(int64))}
+			pageHeader := d.structure()
+			dataPageHeader := pageHeader[5].(map[int16]interface{})
+			require.Equal(t, expectedRows[i], dataPageHeader[1])
+			pageSize := int(pageHeader[3].(int64))
+			require.Equal(t, colMeta[7], int64(d.pos+pageSize)-colMeta[9].(int64))
+		}
+	}
+}
+
+// Ensures the offset column of the first row group is PLAIN encoded.
+func TestWriterPlainInt64(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf, 0)
+	require.NoError(t, w.Write(&Record{Offset: 42, Timestamp: 7}))
+	require.NoError(t, w.Write(&Record{Offset: 43, Timestamp: 8}))
+	require.NoError(t, w.Close())
+
+	data := buf.Bytes()
+	d := &compactDecoder{buf: data, pos: len(magic)}
+	d.structure()
+	require.Equal(t, uint64(42), binary.LittleEndian.Uint64(data[d.pos:]))
+	require.Equal(t, uint64(43), binary.LittleEndian.Uint64(data[d.pos+8:]))
+}
+
+// Ensures definition levels are run-length encoded.
+func TestEncodeDefinitionLevels(t *testing.T) {
+	levels := encodeDefinitionLevels([]bool{true, true, false, true})
+	require.Equal(t, []byte{4, 1, 2, 0, 2, 1}, levels)
+}
@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
-	"path/filepath"
-	"strconv"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Workiva/go-datastructures/queue"
@@ -17,6 +18,7 @@ import (
 	"github.com/liftbridge-io/liftbridge/server/commitlog"
 	encryption "github.com/liftbridge-io/liftbridge/server/encryption"
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+	"github.com/liftbridge-io/liftbridge/server/timerwheel"
 )
 
 // recvChannelSize specifies the size of the channel that feeds the leader
@@ -46,6 +48,14 @@ func (r *replica) updateLatestOffset(offset int64) (updated bool) {
 	return
 }
 
+// resetLatestOffset sets the replica's latest log offset, even if the given
+// offset is less than the current offset because the log was truncated.
+func (r *replica) resetLatestOffset(offset int64) {
+	r.mu.Lock()
+	r.offset = offset
+	r.mu.Unlock()
+}
+
 // getLatestOffset returns the replica's latest log offset.
 func (r *replica) getLatestOffset() int64 {
 	r.mu.RLock()
@@ -80,7 +90,9 @@ func (e *EventTimestamps) update() {
 // leader's log by fetching messages from it. All partition access should go
 // through exported methods.
 type partition struct {
-	mu                            sync.RWMutex
+	appendCount                   int64 // Atomic, messages appended, must be first for alignment
+	readCount                     int64 // Atomic, messages read by subscriptions
+	mu                            contendedRWMutex
 	closeMu                       sync.Mutex
 	sub                           *nats.Subscription // Subscription to partition NATS subject
 	leaderReplSub                 *nats.Subscription // Subscription for replication requests from followers
@@ -104,13 +116,30 @@ type partition struct {
 	pause                         bool // Pause replication on the leader (for unit testing)
 	shutdown                      sync.WaitGroup
 	paused                        bool
+	failed                        bool     // Stopped because its data directory failed
+	dataDir                       *dataDir // Data directory the commit log is stored in
 	autoPauseTime                 time.Duration
+	autoPauseTask                 *timerwheel.Task
 	autoPauseDisableIfSubscribers bool
 	subscriberCount               int64
 	messagesReceivedTimestamps    EventTimestamps // First and latest time a message was received on this partition
 	pauseTimestamps               EventTimestamps // First and latest time this partition was paused or resumed
 	readonlyTimestamps            EventTimestamps // First and latest time this partition had its read-only status changed
 	encryptionHandler             encryption.Codec
+	dedupWindow                   time.Duration
+	dedupMaxEntries               int
+	keyExtractor                  *keyExtractor           // Evaluates the key of messages published to the partition
+	deliveryCache                 *deliveryCache          // Messages shared by subscriptions tailing the partition
+	dispatcher                    *subscriptionDispatcher // Delivers new messages to subscriptions which caught up
+	queuesMu                      sync.Mutex
+	queues                        map[string]*workQueue // Work queues consuming the partition
+	dictionaryMu                  sync.Mutex
+	dictionary                    *compressionDictionary // Compression dictionary trained from the partition's messages
+	creditsMu                     sync.Mutex
+	credits                       map[string]*subscriptionCredit // Flow-controlled subscriptions by ID
+	autoCommitsMu                 sync.Mutex
+	autoCommits                   map[string]*autoCommitter // Subscriptions auto-committing cursors by cursor ID
+	acks                          *ackCoalescer             // Batches acks to publishers, nil if disabled
 	*proto.Partition
 }
 
@@ -122,42 +151,76 @@ type partition struct {
 // A partitioned stream maps to separate NATS subjects: subject, subject.1,
 // subject.2, etc.
 func (s *Server) newPartition(protoPartition *proto.Partition, recovered bool, config *proto.StreamConfig) (*partition, error) {
-	streamsConfig := &StreamsConfig{
-		SegmentMaxBytes:               s.config.Streams.SegmentMaxBytes,
-		SegmentMaxAge:                 s.config.Streams.SegmentMaxAge,
-		RetentionMaxBytes:             s.config.Streams.RetentionMaxBytes,
-		RetentionMaxMessages:          s.config.Streams.RetentionMaxMessages,
-		RetentionMaxAge:               s.config.Streams.RetentionMaxAge,
-		CleanerInterval:               s.config.Streams.CleanerInterval,
-		Compact:                       s.config.Streams.Compact,
-		CompactMaxGoroutines:          s.config.Streams.CompactMaxGoroutines,
-		AutoPauseTime:                 s.config.Streams.AutoPauseTime,
-		AutoPauseDisableIfSubscribers: s.config.Streams.AutoPauseDisableIfSubscribers,
-		MinISR:                        s.config.Clustering.MinISR,
-		Encryption:                    s.config.Streams.Encryption,
-	}
-	streamsConfig.ApplyOverrides(config)
+	streamsConfig := s.getStreamsConfig(config)
+	dir, err := s.dataDirs.place(protoPartition.Stream, protoPartition.Id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to place commit log")
+	}
 	var (
-		file = filepath.Join(s.config.DataDir, "streams", protoPartition.Stream,
-			strconv.FormatInt(int64(protoPartition.Id), 10))
+		file = partitionPath(dir, protoPartition.Stream, protoPartition.Id)
 		name = fmt.Sprintf("[subject=%s, stream=%s, partition=%d]",
 			protoPartition.Subject, protoPartition.Stream, protoPartition.Id)
-
-		log, err = commitlog.New(commitlog.Options{
-			Name:                 name,
-			Path:                 file,
-			MaxSegmentBytes:      streamsConfig.SegmentMaxBytes,
-			MaxSegmentAge:        streamsConfig.SegmentMaxAge,
-			MaxLogBytes:          streamsConfig.RetentionMaxBytes,
-			MaxLogMessages:       streamsConfig.RetentionMaxMessages,
-			MaxLogAge:            streamsConfig.RetentionMaxAge,
-			CleanerInterval:      streamsConfig.CleanerInterval,
-			Compact:              streamsConfig.Compact,
-			CompactMaxGoroutines: streamsConfig.CompactMaxGoroutines,
-			Logger:               s.logger,
-			ConcurrencyControl:   streamsConfig.ConcurrencyControl,
-		})
 	)
+	var (
+		tieredStorage *commitlog.TieredStorage
+		tieredPrefix  string
+		keys          commitlog.KeyProvider
+	)
+	if streamsConfig.TieredStorage {
+		tieredStorage, err = s.getTieredStorage(streamsConfig.TieredStorageBucket)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open tiered storage")
+		}
+		// Each replica offloads its own segments since segment boundaries
+		// differ between replicas.
+		tieredPrefix = fmt.Sprintf("%s/%d/%s", url.PathEscape(protoPartition.Stream),
+			protoPartition.Id, s.config.Clustering.ServerID)
+		if prefix := strings.Trim(streamsConfig.TieredStoragePrefix, "/"); prefix != "" {
+			tieredPrefix = prefix + "/" + tieredPrefix
+		}
+	}
+	if streamsConfig.SegmentEncryption {
+		keys = s.segmentKeys
+	}
+	log, err := commitlog.New(commitlog.Options{
+		Name:                      name,
+		Path:                      file,
+		MaxSegmentBytes:           streamsConfig.SegmentMaxBytes,
+		MaxSegmentAge:             streamsConfig.SegmentMaxAge,
+		MaxLogBytes:               streamsConfig.RetentionMaxBytes,
+		MaxLogMessages:            streamsConfig.RetentionMaxMessages,
+		MaxLogKeys:                streamsConfig.RetentionMaxKeys,
+		MaxLogAge:                 streamsConfig.RetentionMaxAge,
+		CleanerInterval:           streamsConfig.CleanerInterval,
+		Compact:                   streamsConfig.Compact,
+		CompactMaxGoroutines:      streamsConfig.CompactMaxGoroutines,
+		CompactKeepVersions:       streamsConfig.CompactKeepVersions,
+		CompactTombstoneRetention: streamsConfig.CompactTombstoneRetention,
+		CompactMinDirtyRatio:      streamsConfig.CompactMinDirtyRatio,
+		CompactMaxBytes:           streamsConfig.CompactMaxBytes,
+		Logger:                    s.logger,
+		ConcurrencyControl:        streamsConfig.ConcurrencyControl,
+		SyncOnAppend:              streamsConfig.SyncOnAppend,
+		SyncMaxDelay:              streamsConfig.SyncMaxDelay,
+		FlushMessages:             streamsConfig.FlushMessages,
+		FlushInterval:             streamsConfig.FlushInterval,
+		IOUring:                   streamsConfig.IOUring,
+		IndexIntervalBytes:        streamsConfig.IndexIntervalBytes,
+		IndexAdvice:               streamsConfig.IndexAdvice,
+		IndexLockBytes:            streamsConfig.IndexLockBytes,
+		IndexPositionalIO:         !streamsConfig.IndexMmap,
+		IndexPreallocateBytes:     streamsConfig.IndexPreallocateBytes,
+		BlockCache:                s.blockCache,
+		ChecksumVerification:      streamsConfig.ChecksumVerification,
+		TimerWheel:                s.timerWheel,
+		CompactionScheduler:       dir.compaction,
+		TieredStorage:             tieredStorage,
+		TieredPrefix:              tieredPrefix,
+		Compression:               streamsConfig.SegmentCompression,
+		MessageFormat:             streamsConfig.SegmentMessageFormat,
+		EncryptionKeys:            keys,
+		EncryptionKeyName:         protoPartition.Stream,
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create commit log")
 	}
@@ -187,9 +250,15 @@ func (s *Server) newPartition(protoPartition *proto.Partition, recovered bool, c
 		commitCheck:                   make(chan struct{}, len(protoPartition.Replicas)),
 		notify:                        make(chan struct{}, 1),
 		recovered:                     recovered,
+		dataDir:                       dir,
 		autoPauseTime:                 streamsConfig.AutoPauseTime,
 		autoPauseDisableIfSubscribers: streamsConfig.AutoPauseDisableIfSubscribers,
+		dedupWindow:                   streamsConfig.DedupWindow,
+		dedupMaxEntries:               streamsConfig.DedupMaxEntries,
+		deliveryCache:                 newDeliveryCache(streamsConfig.FanoutCacheSize),
 	}
+	st.dispatcher = newSubscriptionDispatcher(st)
+	st.acks = newAckCoalescer(st, streamsConfig.AckCoalesceInterval, streamsConfig.AckCoalesceMaxAcks)
 
 	if streamsConfig.Encryption {
 		// Init handler for Encryption-at-Rest
@@ -203,6 +272,14 @@ func (s *Server) newPartition(protoPartition *proto.Partition, recovered bool, c
 		st.encryptionHandler = encryptionHandler
 	}
 
+	if source := config.GetPartitionKey(); source != "" {
+		keyExtractor, err := newKeyExtractor(source)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to initialize partition key")
+		}
+		st.keyExtractor = keyExtractor
+	}
+
 	return st, nil
 }
 
@@ -268,6 +345,38 @@ func (p *partition) Pause() error {
 	return p.close()
 }
 
+// Fail stops the partition and closes the commit log after its data directory
+// failed. The partition isn't started again, even if its leader changes,
+// until it's rebuilt in another data directory.
+func (p *partition) Fail() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failed = true
+
+	p.closeMu.Lock()
+	defer p.closeMu.Unlock()
+
+	if p.isClosed {
+		return nil
+	}
+	p.isClosed = true
+
+	// Stop before closing the log since the log may fail to close.
+	err := p.stopLeadingOrFollowing()
+	if closeErr := p.log.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// IsFailed indicates if the partition failed because of its data directory.
+func (p *partition) IsFailed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.failed
+}
+
 // IsPaused indicates if the partition is currently paused.
 func (p *partition) IsPaused() bool {
 	p.mu.RLock()
@@ -302,6 +411,7 @@ func (p *partition) Delete() error {
 	if err := p.log.Delete(); err != nil {
 		return err
 	}
+	p.srv.dataDirs.remove(p.Stream, p.Id)
 
 	return p.stopLeadingOrFollowing()
 }
@@ -392,10 +502,10 @@ func (p *partition) SetLeader(leader string, epoch uint64) error {
 	p.Leader = leader
 	p.LeaderEpoch = epoch
 
-	if p.recovered || p.paused {
+	if p.recovered || p.paused || p.failed {
 		// If this partition is being recovered, we will start the
 		// leader/follower loop later. If it's paused, we won't start it til
-		// it's resumed.
+		// it's resumed, and if it failed, til it's rebuilt.
 		return nil
 	}
 
@@ -507,6 +617,9 @@ func (p *partition) becomeLeader(epoch uint64) error {
 	// Subscribe to the NATS subject and begin sequencing messages.
 	// TODO: This should be drained on shutdown.
 	sub, err := p.srv.nc.QueueSubscribe(p.getSubject(), p.Group, func(m *nats.Msg) {
+		if p.routeByKey(m) {
+			return
+		}
 		recvChan <- m
 	})
 	if err != nil {
@@ -535,8 +648,33 @@ func (p *partition) becomeLeader(epoch uint64) error {
 
 	// Start auto-pause timer if enabled.
 	if p.autoPauseTime > 0 {
+		stop := p.stopLeader
+		p.autoPauseTask = p.srv.timerWheel.Schedule(p.autoPauseTime, func() time.Duration {
+			return p.checkAutoPause(stop)
+		})
+	}
+
+	// Start firing scheduled publishes if this is a partition of the internal
+	// schedules stream.
+	if p.Stream == schedulesStream {
+		stop := p.stopLeader
+		p.srv.startGoroutine(func() {
+			p.srv.api.runScheduler(p, stop)
+		})
+	}
+
+	// Start publishing sampled messages to the sampled mirrors of the stream
+	// and republishing messages to the streams derived from it.
+	if !isInternalStream(p.Stream) {
+		stop := p.stopLeader
+		// The sampler's context is created before starting it so that it's
+		// canceled even if the server begins shutting down in between.
+		ctx := p.srv.contextWithCancel(context.Background(), stop)
+		p.srv.startGoroutine(func() {
+			p.srv.api.runSampler(ctx, p)
+		})
 		p.srv.startGoroutine(func() {
-			p.autoPauseLoop(p.stopLeader)
+			p.srv.api.runDeriver(p, stop)
 		})
 	}
 
@@ -573,6 +711,10 @@ func (p *partition) stopLeading() error {
 		p.shutdown.Add(replicas - 1) // Replicator loops (minus one to exclude self)
 	}
 	close(p.stopLeader)
+	if p.autoPauseTask != nil {
+		p.autoPauseTask.Stop()
+		p.autoPauseTask = nil
+	}
 
 	// Wait for loops to shutdown. Release mutex while we wait to avoid
 	// deadlocks.
@@ -580,6 +722,9 @@ func (p *partition) stopLeading() error {
 	p.shutdown.Wait()
 	p.mu.Lock()
 
+	// Send the acks still waiting to be coalesced.
+	p.acks.flush()
+
 	p.commitQueue.Dispose()
 	p.isLeading = false
 
@@ -601,8 +746,17 @@ func (p *partition) becomeFollower() error {
 	// Start fetching messages from the leader's log starting at the HW.
 	p.stopFollower = make(chan struct{})
 	p.srv.logger.Debugf("Replicating partition %s from leader %s", p, p.Leader)
+	var (
+		leader = p.Leader
+		epoch  = p.LeaderEpoch
+		stop   = p.stopFollower
+	)
 	p.srv.startGoroutine(func() {
-		p.replicationRequestLoop(p.Leader, p.LeaderEpoch, p.stopFollower)
+		if p.srv.config.Clustering.ReplicaMaxInflightRequests > 1 {
+			p.pipelinedReplicationLoop(leader, epoch, stop)
+		} else {
+			p.replicationRequestLoop(leader, epoch, stop)
+		}
 	})
 
 	p.isFollowing = true
@@ -684,23 +838,27 @@ func (p *partition) handleReplicationRequest(msg *nats.Msg) {
 
 // handleReplicationResponse is a NATS handler that's invoked when a follower
 // receives a replication response from the leader. This response will contain
-// the leader epoch, leader HW, and (optionally) messages to replicate.
-func (p *partition) handleReplicationResponse(msg *nats.Msg) int {
+// the leader epoch, leader HW, and (optionally) messages to replicate. It
+// returns the number of messages replicated. Messages the log already has are
+// skipped, and if the messages don't directly follow the log, which can only
+// happen if a response to a pipelined request was lost, none are replicated
+// and errReplicationGap is returned.
+func (p *partition) handleReplicationResponse(msg *nats.Msg) (int, error) {
 	leaderEpoch, hw, data, err := proto.UnmarshalReplicationResponse(msg.Data)
 	if err != nil {
 		p.srv.logger.Warnf("Invalid replication response for partition %s: %s", p, err)
-		return 0
+		return 0, nil
 	}
 
 	p.mu.RLock()
 	if !p.isFollowing {
 		p.mu.RUnlock()
-		return 0
+		return 0, nil
 	}
 
 	if p.LeaderEpoch != leaderEpoch {
 		p.mu.RUnlock()
-		return 0
+		return 0, nil
 	}
 	p.mu.RUnlock()
 
@@ -708,23 +866,29 @@ func (p *partition) handleReplicationResponse(msg *nats.Msg) int {
 	p.log.SetHighWatermark(hw)
 
 	if len(data) == 0 {
-		return 0
+		return 0, nil
 	}
 
 	// We should have at least 28 bytes for headers.
 	if len(data) <= 28 {
 		p.srv.logger.Warnf("Invalid replication response for partition %s", p)
-		return 0
+		return 0, nil
 	}
 	offset := int64(proto.Encoding.Uint64(data[:8]))
-	if offset < p.log.NewestOffset()+1 {
-		return 0
+	if next := p.log.NewestOffset() + 1; offset != next {
+		if offset > next {
+			return 0, errReplicationGap
+		}
+		return 0, nil
 	}
 	offsets, err := p.log.AppendMessageSet(data)
 	if err != nil {
+		if p.srv.handleStorageError(p, err) {
+			return 0, err
+		}
 		panic(fmt.Errorf("Failed to replicate data to log %s: %v", p, err))
 	}
-	return len(offsets)
+	return len(offsets), nil
 }
 
 // getReplicationRequestInbox returns the NATS subject to send replication
@@ -741,33 +905,30 @@ func (p *partition) getLeaderOffsetRequestInbox() string {
 		p.srv.config.Clustering.Namespace, p.Stream, p.Id)
 }
 
-// autoPauseLoop is a long-running loop the leader runs to check if the
-// partition should be automatically paused due to inactivity.
-func (p *partition) autoPauseLoop(stop <-chan struct{}) {
-	timer := time.NewTimer(p.autoPauseTime)
-	defer timer.Stop()
-	for {
-		select {
-		case <-stop:
-			return
-		case <-timer.C:
-		}
+// checkAutoPause is a background task the leader runs to check if the
+// partition should be automatically paused due to inactivity. It returns the
+// time until the next check, or 0 once the stop channel is closed.
+func (p *partition) checkAutoPause(stop <-chan struct{}) time.Duration {
+	select {
+	case <-stop:
+		return 0
+	default:
+	}
 
-		p.mu.RLock()
-		latestReceivedElapsed := time.Since(p.messagesReceivedTimestamps.latestTime)
-		subsAllowPausing := !p.autoPauseDisableIfSubscribers || p.subscriberCount == 0
-		p.mu.RUnlock()
+	p.mu.RLock()
+	latestReceivedElapsed := time.Since(p.messagesReceivedTimestamps.latestTime)
+	subsAllowPausing := !p.autoPauseDisableIfSubscribers || p.subscriberCount == 0
+	p.mu.RUnlock()
 
-		if latestReceivedElapsed > p.autoPauseTime && subsAllowPausing {
-			p.srv.logger.Infof("Partition %s has not received a message in over %s, "+
-				"auto pausing partition", p, p.autoPauseTime)
-			if err := p.requestPause(); err != nil {
-				p.srv.logger.Errorf("Failed to auto pause partition %s: %v", p, err)
-			}
+	if latestReceivedElapsed > p.autoPauseTime && subsAllowPausing {
+		p.srv.logger.Infof("Partition %s has not received a message in over %s, "+
+			"auto pausing partition", p, p.autoPauseTime)
+		if err := p.requestPause(); err != nil {
+			p.srv.logger.Errorf("Failed to auto pause partition %s: %v", p, err)
 		}
-
-		timer.Reset(computeTick(latestReceivedElapsed, p.autoPauseTime))
 	}
+
+	return computeTick(latestReceivedElapsed, p.autoPauseTime)
 }
 
 // requestPause sends a request to pause the partition.
@@ -784,7 +945,9 @@ func (p *partition) requestPause() error {
 
 // messageProcessingLoop is a long-running loop that processes messages
 // received on the given channel until the stop channel is closed. This will
-// attempt to batch messages up before writing them to the commit log. Once
+// attempt to batch messages up before writing them to the commit log, waiting
+// up to the max batch time after the first message of a batch for more
+// messages until the max batch size or bytes is reached. Once
 // written to the write-ahead log, a marker is written to the commit queue to
 // indicate it's pending commit. Once the ISR has replicated the message, the
 // leader commits it by removing it from the queue and sending an
@@ -793,15 +956,22 @@ func (p *partition) messageProcessingLoop(recvChan <-chan *nats.Msg, stop <-chan
 	leaderEpoch uint64) {
 
 	var (
-		msg       *nats.Msg
-		batchSize = p.srv.config.BatchMaxMessages
-		batchWait = p.srv.config.BatchMaxTime
-		msgBatch  = make([]*commitlog.Message, 0, batchSize)
+		msg        *nats.Msg
+		batchSize  = p.srv.config.BatchMaxMessages
+		batchBytes = p.srv.config.BatchMaxBytes
+		batchWait  = p.srv.config.BatchMaxTime
+		msgBatch   = make([]*commitlog.Message, 0, batchSize)
 	)
 	// If Concurrency Control is enabled, then the message will be appended one by one.
 	// This is to ensure no conflict between each message.
+	var keys keyOffsets
 	if p.log.IsConcurrencyControlEnabled() {
 		batchSize = 1
+		keys = p.loadKeyOffsets()
+	}
+	var dedup *deduplicator
+	if p.dedupWindow > 0 {
+		dedup = newDeduplicator(p.loadDedupCache())
 	}
 
 	for {
@@ -816,105 +986,69 @@ func (p *partition) messageProcessingLoop(recvChan <-chan *nats.Msg, stop <-chan
 		p.messagesReceivedTimestamps.update()
 		p.mu.Unlock()
 
-		m := natsToProtoMessage(msg, leaderEpoch)
-
-		if p.encryptionHandler != nil {
-			// Encrypt value
-			encryptedValue, err := p.encryptionHandler.Seal(m.Value)
-
-			if err != nil {
-				ack := &client.Ack{
-					Stream:             p.Stream,
-					PartitionSubject:   p.Subject,
-					MsgSubject:         string(m.Headers["subject"]),
-					AckInbox:           m.AckInbox,
-					CorrelationId:      m.CorrelationID,
-					AckPolicy:          m.AckPolicy,
-					ReceptionTimestamp: m.Timestamp,
-					AckError:           client.Ack_ENCRYPTION,
-				}
-
-				p.sendAck(ack)
-				p.srv.logger.Errorf("Failed to encrypt message %s: %v", p, err)
-				continue
-			}
-			// Set encrypted value
-			m.Value = encryptedValue
-		}
-
-		// Reject messages that are larger than the max replication size.
-		if int64(len(msg.Data)) > p.srv.config.Clustering.ReplicationMaxBytes {
-			p.sendTooLargeNack(m)
+		msgs := p.prepareMessages(msg, leaderEpoch, dedup, 0)
+		if msgs == nil {
 			continue
 		}
-		msgBatch = append(msgBatch, m)
-		remaining := batchSize - 1
-
-		// Fill the batch up to the max batch size or until the channel is
-		// empty.
-		for remaining > 0 {
-			chanLen := len(recvChan)
-			if chanLen == 0 {
-				if batchWait > 0 {
-					time.Sleep(batchWait)
-					chanLen = len(recvChan)
-					if chanLen == 0 {
-						break
-					}
-				} else {
-					break
-				}
-			}
-
-			if chanLen > remaining {
-				chanLen = remaining
-			}
+		msgBatch = append(msgBatch, msgs...)
+		var (
+			remaining = batchSize - len(msgs)
+			size      = len(msg.Data)
+			deadline  *time.Timer
+		)
+		if batchWait > 0 {
+			deadline = time.NewTimer(batchWait)
+		}
 
-			added := 0
-			for i := 0; i < chanLen; i++ {
-				msg = <-recvChan
-				m := natsToProtoMessage(msg, leaderEpoch)
-
-				if p.encryptionHandler != nil {
-					// Encrypt value
-					encryptedValue, err := p.encryptionHandler.Seal(m.Value)
-
-					if err != nil {
-						ack := &client.Ack{
-							Stream:             p.Stream,
-							PartitionSubject:   p.Subject,
-							MsgSubject:         string(m.Headers["subject"]),
-							AckInbox:           m.AckInbox,
-							CorrelationId:      m.CorrelationID,
-							AckPolicy:          m.AckPolicy,
-							ReceptionTimestamp: m.Timestamp,
-							AckError:           client.Ack_ENCRYPTION,
-						}
-
-						p.sendAck(ack)
-						p.srv.logger.Errorf("Failed to encrypt message %s: %v", p, err)
-						continue
-					}
-					// Set encrypted value
-					m.Value = encryptedValue
+		// Fill the batch up to the max batch size or bytes or until the
+		// channel is empty and the max batch time has passed since the first
+		// message. Atomic batches are always added whole, so this can exceed
+		// the max batch size.
+	fill:
+		for remaining > 0 && (batchBytes == 0 || size < batchBytes) {
+			select {
+			case msg = <-recvChan:
+			default:
+				if deadline == nil {
+					break fill
 				}
-				if int64(len(msg.Data)) > p.srv.config.Clustering.ReplicationMaxBytes {
-					p.sendTooLargeNack(m)
-					continue
+				select {
+				case msg = <-recvChan:
+				case <-deadline.C:
+					break fill
+				case <-stop:
+					break fill
 				}
-				msgBatch = append(msgBatch, m)
-				added++
 			}
-			remaining -= added
+			msgs := p.prepareMessages(msg, leaderEpoch, dedup, len(msgBatch))
+			msgBatch = append(msgBatch, msgs...)
+			remaining -= len(msgs)
+			size += len(msg.Data)
+		}
+		if deadline != nil {
+			deadline.Stop()
 		}
 
-		// Write uncommitted messages to log.
-		offsets, err := p.log.Append(msgBatch)
+		// Write uncommitted messages to log if their expected key offsets
+		// hold.
+		var offsets []int64
+		err := keys.check(msgBatch, p.log.NewestOffset()+1)
+		if err == nil {
+			offsets, err = p.log.Append(msgBatch)
+		}
+		if err == nil {
+			atomic.AddInt64(&p.appendCount, int64(len(msgBatch)))
+		}
 		if err != nil {
+			if dedup != nil {
+				dedup.reset()
+			}
 
 			// AckErr should be dispatched if ErrIncorrectOffset is raised.
+			// Since only one message or atomic batch is appended at a time
+			// with Concurrency Control, the ack is for its last message.
 			if errors.Is(err, commitlog.ErrIncorrectOffset) {
-				msg := msgBatch[0]
+				msg := msgBatch[len(msgBatch)-1]
 				ack := &client.Ack{
 					Stream:             p.Stream,
 					PartitionSubject:   p.Subject,
@@ -929,12 +1063,22 @@ func (p *partition) messageProcessingLoop(recvChan <-chan *nats.Msg, stop <-chan
 				p.sendAck(ack)
 			}
 			p.srv.logger.Errorf("Failed to append to log %s: %v", p, err)
+			p.srv.handleStorageError(p, err)
 			continue
 		}
 
 		for i, msg := range msgBatch {
 			p.processPendingMessage(offsets[i], msg)
 		}
+		keys.appended(msgBatch, offsets)
+
+		// Ack duplicates of messages in the batch now that their offsets are
+		// known.
+		if dedup != nil {
+			for _, dup := range dedup.appended(msgBatch, offsets) {
+				p.ackDuplicate(dup.msg, dup.offset)
+			}
+		}
 
 		// Update this replica's latest offset.
 		p.updateISRLatestOffset(
@@ -944,6 +1088,81 @@ func (p *partition) messageProcessingLoop(recvChan <-chan *nats.Msg, stop <-chan
 	}
 }
 
+// prepareMessages converts a received NATS message to the commit log messages
+// to append, which are an atomic batch if the NATS message is a publish batch,
+// sets their keys if the stream has a partition key source, and encrypts their
+// values if encryption is enabled. The messages would be
+// added to the batch being built at the given index. It returns nil if the
+// messages are rejected or dropped as duplicates, in which case they are acked
+// accordingly. A batch is only acked for its last message, so it is rejected
+// or deduplicated as a whole.
+func (p *partition) prepareMessages(msg *nats.Msg, leaderEpoch uint64, dedup *deduplicator,
+	index int) []*commitlog.Message {
+
+	var (
+		msgs = natsToProtoMessages(msg, leaderEpoch)
+		last = msgs[len(msgs)-1]
+	)
+
+	// Set the key of messages from the stream's partition key source. This
+	// must happen before encryption since it may read the value.
+	if p.keyExtractor != nil {
+		for _, m := range msgs {
+			if key := p.keyExtractor.extract(m.Key, m.Headers, m.Value); key != nil {
+				m.Key = key
+			}
+		}
+	}
+
+	clearTombstoneValues(msgs)
+	applyAckPolicyHeader(msgs)
+
+	if p.encryptionHandler != nil {
+		for _, m := range msgs {
+			// Tombstones have no value to encrypt.
+			if isTombstone(m.Key, m.Value) {
+				continue
+			}
+			// Encrypt value
+			encryptedValue, err := p.encryptionHandler.Seal(m.Value)
+
+			if err != nil {
+				ack := &client.Ack{
+					Stream:             p.Stream,
+					PartitionSubject:   p.Subject,
+					MsgSubject:         string(last.Headers["subject"]),
+					AckInbox:           last.AckInbox,
+					CorrelationId:      last.CorrelationID,
+					AckPolicy:          last.AckPolicy,
+					ReceptionTimestamp: last.Timestamp,
+					AckError:           client.Ack_ENCRYPTION,
+				}
+
+				p.sendAck(ack)
+				p.srv.logger.Errorf("Failed to encrypt message %s: %v", p, err)
+				return nil
+			}
+			// Set encrypted value
+			m.Value = encryptedValue
+		}
+	}
+
+	// Reject messages that are larger than the max replication size.
+	if int64(len(msg.Data)) > p.srv.config.Clustering.ReplicationMaxBytes {
+		p.sendTooLargeNack(last)
+		return nil
+	}
+	if dedup != nil {
+		if offset, dup := dedup.filter(last, index+len(msgs)-1); dup {
+			if offset >= 0 {
+				p.ackDuplicate(last, offset)
+			}
+			return nil
+		}
+	}
+	return msgs
+}
+
 // processPendingMessage sends an ack if the message's AckPolicy is LEADER and
 // adds the pending message to the commit queue. Messages are removed from the
 // queue and committed when the entire ISR has replicated them.
@@ -969,6 +1188,36 @@ func (p *partition) processPendingMessage(offset int64, msg *commitlog.Message)
 	}
 }
 
+// ackDuplicate acks a message that was dropped as a duplicate with the offset
+// of the original message. If the AckPolicy is ALL, the ack is added to the
+// commit queue so it's sent once the original message is committed.
+func (p *partition) ackDuplicate(msg *commitlog.Message, offset int64) {
+	p.srv.logger.Debugf("Dropping duplicate message %s for partition %s, original offset: %d",
+		msg.Headers[MsgIDHeader], p, offset)
+	ack := &client.Ack{
+		Stream:             p.Stream,
+		PartitionSubject:   p.Subject,
+		MsgSubject:         string(msg.Headers["subject"]),
+		Offset:             offset,
+		AckInbox:           msg.AckInbox,
+		CorrelationId:      msg.CorrelationID,
+		AckPolicy:          msg.AckPolicy,
+		ReceptionTimestamp: msg.Timestamp,
+	}
+	if msg.AckPolicy != client.AckPolicy_ALL {
+		p.sendAck(ack)
+		return
+	}
+	if err := p.commitQueue.Put(ack); err != nil {
+		// This is very bad and should not happen.
+		panic(fmt.Sprintf("Failed to add message to commit queue: %v", err))
+	}
+	select {
+	case p.commitCheck <- struct{}{}:
+	default:
+	}
+}
+
 // startReplicating starts a long-running goroutine which handles committing
 // messages in the commit queue and a replication goroutine for each replica.
 func (p *partition) startReplicating(epoch uint64, stop chan struct{}) {
@@ -1062,18 +1311,31 @@ func (p *partition) commitLoop(stop chan struct{}) {
 	}
 }
 
-// sendAck publishes an ack to the specified AckInbox. If no AckInbox is set,
-// this does nothing.
+// sendAck notifies publish ack listeners of an ack and publishes it to the
+// specified AckInbox. If no AckInbox is set, the ack is not published.
 func (p *partition) sendAck(ack *client.Ack) {
+	ack.CommitTimestamp = timestamp()
+	p.srv.publishAcked(ack)
 	if ack.AckInbox == "" {
 		return
 	}
-	ack.CommitTimestamp = timestamp()
-	data, err := proto.MarshalAck(ack)
+	if p.acks != nil {
+		p.acks.send(ack)
+		return
+	}
+	p.publishAck(ack)
+}
+
+// publishAck serializes the ack in a pooled buffer and publishes it to its
+// AckInbox.
+func (p *partition) publishAck(ack *client.Ack) {
+	data, err := proto.AppendAck(getEnvelopeBuffer(), ack)
 	if err != nil {
 		panic(err)
 	}
-	if err := p.srv.ncAcks.Publish(ack.AckInbox, data); err != nil {
+	err = p.srv.ncAcks.Publish(ack.AckInbox, data)
+	putEnvelopeBuffer(data)
+	if err != nil {
 		p.srv.logger.Errorf("Error sending ack for partition %s: %v", p, err)
 	}
 }
@@ -1085,9 +1347,6 @@ func (p *partition) sendTooLargeNack(msg *commitlog.Message) {
 	p.srv.logger.Errorf(
 		"Rejecting message received on partition %s that exceeds clustering.replication.max.bytes (%d)",
 		p, p.srv.config.Clustering.ReplicationMaxBytes)
-	if msg.AckInbox == "" {
-		return
-	}
 	ack := &client.Ack{
 		Stream:             p.Stream,
 		PartitionSubject:   p.Subject,
@@ -1098,13 +1357,11 @@ func (p *partition) sendTooLargeNack(msg *commitlog.Message) {
 		ReceptionTimestamp: msg.Timestamp,
 		AckError:           client.Ack_TOO_LARGE,
 	}
-	data, err := proto.MarshalAck(ack)
-	if err != nil {
-		panic(err)
-	}
-	if err := p.srv.ncAcks.Publish(ack.AckInbox, data); err != nil {
-		p.srv.logger.Errorf("Error sending ack for partition %s: %v", p, err)
+	p.srv.publishAcked(ack)
+	if ack.AckInbox == "" {
+		return
 	}
+	p.publishAck(ack)
 }
 
 // replicationRequestLoop is a long-running loop which sends replication
@@ -1158,6 +1415,95 @@ func (p *partition) replicationRequestLoop(leader string, epoch uint64, stop <-c
 	}
 }
 
+// pipelinedReplicationLoop is a long-running loop like replicationRequestLoop
+// which keeps up to clustering.replica.max.inflight.requests replication
+// requests outstanding to the leader instead of waiting for each response
+// before sending the next request. Responses are received in the order the
+// requests were sent on an inbox for the loop and applied in that order. Each
+// request still carries the log end offset, which is what the leader uses to
+// commit, but a pipelined request asks the leader to continue from the last
+// message it sent. If a response times out or is lost, the pipeline is drained
+// and restarts from the log end offset.
+func (p *partition) pipelinedReplicationLoop(leader string, epoch uint64, stop <-chan struct{}) {
+	var (
+		maxInflight  = p.srv.config.Clustering.ReplicaMaxInflightRequests
+		fetchTimeout = p.srv.config.Clustering.ReplicaFetchTimeout
+		inbox        = nats.NewInbox()
+		responses    = make(chan *nats.Msg, 2*maxInflight)
+	)
+	sub, err := p.srv.ncRepl.ChanSubscribe(inbox, responses)
+	if err != nil {
+		p.srv.logger.Errorf("Failed to subscribe to replication responses for partition %s, "+
+			"not pipelining requests: %v", p, err)
+		p.replicationRequestLoop(leader, epoch, stop)
+		return
+	}
+	defer sub.Unsubscribe() // nolint: errcheck
+
+	var (
+		leaderLastSeen = time.Now()
+		inflight       []time.Time // Times outstanding requests were sent, oldest first
+		lastRequested  int64       // Log end offset sent with the last request
+		caughtUp       bool        // The leader has nothing more to send
+	)
+	for {
+		// Fill the pipeline unless the leader has nothing more to send. The
+		// first request in the pipeline tells the leader to start from the
+		// log end offset.
+		for !caughtUp && len(inflight) < maxInflight {
+			lastRequested = p.log.NewestOffset()
+			if err := p.publishReplicationRequest(epoch, inbox, lastRequested, len(inflight) > 0); err != nil {
+				p.srv.logger.Errorf(
+					"Error sending replication request for partition %s: %v", p, err)
+				break
+			}
+			inflight = append(inflight, time.Now())
+		}
+
+		var wait time.Duration
+		if len(inflight) > 0 {
+			wait = time.Until(inflight[0].Add(fetchTimeout))
+		} else {
+			// If we are caught up with the leader, wait for data.
+			wait = p.computeReplicaFetchSleep()
+		}
+		select {
+		case <-stop:
+			return
+		case msg := <-responses:
+			if len(inflight) > 0 {
+				inflight = inflight[1:]
+			}
+			leaderLastSeen = time.Now()
+			replicated, err := p.handleReplicationResponse(msg)
+			if err != nil {
+				p.srv.logger.Warnf("Restarting replication request pipeline for partition %s: %v", p, err)
+				inflight = nil
+				caughtUp = false
+				break
+			}
+			// Only stop sending requests once the leader has nothing more to
+			// send and has been told the log end offset, so that it commits
+			// and notifies the follower of new data.
+			caughtUp = replicated == 0 && p.log.NewestOffset() <= lastRequested
+		case <-time.After(wait):
+			if len(inflight) > 0 {
+				p.srv.logger.Errorf(
+					"Error sending replication request for partition %s: %v", p, nats.ErrTimeout)
+				inflight = nil
+			}
+			// Check in with leader to maintain health status.
+			caughtUp = false
+		case <-p.notify:
+			// Leader has signalled more data is available.
+			caughtUp = false
+		}
+
+		// Check if leader has exceeded max leader timeout.
+		p.checkLeaderHealth(leader, epoch, leaderLastSeen)
+	}
+}
+
 // checkLeaderHealth checks if the leader has responded within
 // ReplicaMaxLeaderTimeout and, if not, reports the leader to the controller.
 func (p *partition) checkLeaderHealth(leader string, epoch uint64, leaderLastSeen time.Time) {
@@ -1170,6 +1516,7 @@ func (p *partition) checkLeaderHealth(leader string, epoch uint64, leaderLastSee
 			leader, p, lastSeenElapsed)
 		req := &proto.ReportLeaderOp{
 			Stream:      p.Stream,
+			Partition:   p.Id,
 			Replica:     p.srv.config.Clustering.ServerID,
 			Leader:      leader,
 			LeaderEpoch: epoch,
@@ -1210,13 +1557,35 @@ func (p *partition) sendReplicationRequest(leaderEpoch uint64) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return p.handleReplicationResponse(resp), nil
+	return p.handleReplicationResponse(resp)
+}
+
+// publishReplicationRequest sends a replication request to the partition
+// leader with the given log end offset without waiting for the response,
+// which is sent to the given inbox. If pipelined is true, the leader continues
+// from the last message it sent instead of the log end offset.
+func (p *partition) publishReplicationRequest(leaderEpoch uint64, inbox string, offset int64,
+	pipelined bool) error {
+
+	data, err := proto.MarshalReplicationRequest(&proto.ReplicationRequest{
+		ReplicaID:   p.srv.config.Clustering.ServerID,
+		Offset:      offset,
+		LeaderEpoch: leaderEpoch,
+		Pipelined:   pipelined,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return p.srv.ncRepl.PublishRequest(p.getReplicationRequestInbox(), inbox, data)
 }
 
 // truncateUncommitted truncates the log up to the start offset of the first
 // leader epoch larger than the current epoch. This removes any potentially
 // uncommitted messages in the log.
 func (p *partition) truncateUncommitted() error {
+	// Messages in the delivery cache may be truncated.
+	p.deliveryCache.reset()
+
 	// Request the last offset for the epoch from the leader.
 	var (
 		lastOffset  int64
@@ -1493,27 +1862,63 @@ func getMessage(data []byte) *client.Message {
 }
 
 // natsToProtoMessage converts the given NATS message to a commit log Message.
+// The payload is deserialized into a pooled protobuf message.
 func natsToProtoMessage(msg *nats.Msg, leaderEpoch uint64) *commitlog.Message {
-	message := getMessage(msg.Data)
-	m := &commitlog.Message{
-		MagicByte:   1,
-		Timestamp:   timestamp(),
-		LeaderEpoch: leaderEpoch,
-		Headers:     make(map[string][]byte),
+	message := getPooledMessage()
+	defer putPooledMessage(message)
+	if err := proto.UnmarshalPublishTo(msg.Data, message); err != nil {
+		message.Reset()
+		message.Value = msg.Data
 	}
+	return clientToProtoMessage(message, msg, leaderEpoch, timestamp())
+}
 
-	if message != nil {
-		m.Key = message.Key
-		m.Value = message.Value
-		for key, value := range message.Headers {
-			m.Headers[key] = value
+// natsToProtoMessages converts the given NATS message to commit log Messages.
+// If the NATS message is a publish batch, this returns an atomic batch in
+// which every message but the last is marked with
+// commitlog.AttrBatchContinues. Otherwise, it returns a single Message.
+func natsToProtoMessages(msg *nats.Msg, leaderEpoch uint64) []*commitlog.Message {
+	if !proto.IsPublishBatch(msg.Data) {
+		return []*commitlog.Message{natsToProtoMessage(msg, leaderEpoch)}
+	}
+	batch, err := proto.UnmarshalPublishBatch(msg.Data)
+	if err != nil || len(batch) == 0 {
+		return []*commitlog.Message{natsToProtoMessage(msg, leaderEpoch)}
+	}
+	var (
+		msgs = make([]*commitlog.Message, len(batch))
+		ts   = timestamp()
+	)
+	for i, message := range batch {
+		msgs[i] = clientToProtoMessage(message, msg, leaderEpoch, ts)
+		if i < len(batch)-1 {
+			msgs[i].Attributes |= commitlog.AttrBatchContinues
 		}
-		m.AckInbox = message.AckInbox
-		m.CorrelationID = message.CorrelationId
-		m.AckPolicy = message.AckPolicy
-		m.Offset = message.Offset
-	} else {
-		m.Value = msg.Data
+	}
+	return msgs
+}
+
+// clientToProtoMessage converts a message received in the given NATS message
+// to a commit log Message.
+func clientToProtoMessage(message *client.Message, msg *nats.Msg, leaderEpoch uint64,
+	timestamp int64) *commitlog.Message {
+
+	m := &commitlog.Message{
+		MagicByte:     1,
+		Timestamp:     timestamp,
+		LeaderEpoch:   leaderEpoch,
+		Key:           message.Key,
+		Value:         message.Value,
+		Headers:       message.Headers,
+		AckInbox:      message.AckInbox,
+		CorrelationID: message.CorrelationId,
+		AckPolicy:     message.AckPolicy,
+		Offset:        message.Offset,
+	}
+	// The headers were deserialized for this message, so it takes them over
+	// rather than copying them.
+	if m.Headers == nil {
+		m.Headers = make(map[string][]byte, 2)
 	}
 	m.Headers["subject"] = []byte(msg.Subject)
 	m.Headers["reply"] = []byte(msg.Reply)
@@ -1,7 +1,12 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,8 +14,11 @@ import (
 	lift "github.com/liftbridge-io/go-liftbridge/v2"
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
 
@@ -695,6 +703,36 @@ func TestPartitionAutoPauseDisableIfSubscribers(t *testing.T) {
 	waitForPartition(t, time.Second, name, 0)
 }
 
+// Ensure partitions are automatically paused by the server's background task
+// workers when there are fewer workers than partitions.
+func TestPartitionAutoPauseBackgroundWorkers(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Configure server.
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.AutoPauseTime = 100 * time.Millisecond
+	s1Config.Streams.BackgroundWorkers = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	_, err = api.CreateStream(context.Background(), &client.CreateStreamRequest{
+		Subject:    "foo",
+		Name:       "foo",
+		Partitions: 3,
+	})
+	require.NoError(t, err)
+
+	for i := int32(0); i < 3; i++ {
+		waitForPause(t, 5*time.Second, s1.metadata.GetPartition("foo", i))
+	}
+}
+
 // Ensure computeTick correctly computes the sleep time for the tick loop based
 // on the elapsed time.
 func TestComputeTick(t *testing.T) {
@@ -710,3 +748,143 @@ func TestComputeTick(t *testing.T) {
 
 	require.Equal(t, maxSleep, computeTick(0, maxSleep))
 }
+
+// Ensure batches are written once they reach the max batch bytes without
+// waiting for the max batch time, and otherwise after the max batch time
+// since their first message.
+func TestPartitionBatchMaxBytes(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.BatchMaxTime = time.Minute
+	s1Config.BatchMaxBytes = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		resp, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte("hello"),
+			AckPolicy: client.AckPolicy_LEADER,
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(i), resp.Ack.Offset)
+	}
+
+	// Without a byte limit, the batch is written after the max batch time.
+	s1.config.BatchMaxTime = 200 * time.Millisecond
+	s1.config.BatchMaxBytes = 0
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "bar", Name: "bar"})
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "bar",
+		Value:     []byte("hello"),
+		AckPolicy: client.AckPolicy_LEADER,
+	})
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= 200*time.Millisecond)
+}
+
+// Ensure partition segments are encrypted with the stream's key when segment
+// encryption is enabled.
+func TestPartitionSegmentEncryption(t *testing.T) {
+	defer cleanupStorage(t)
+
+	keys, err := ioutil.TempDir("", "liftbridge-keys-")
+	require.NoError(t, err)
+	defer os.RemoveAll(keys)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(keys, "key1"),
+		[]byte(strings.Repeat("ab", 32)), 0600))
+
+	config := getTestConfig("a", true, 0)
+	config.Streams.SegmentEncryption = true
+	config.Streams.SegmentEncryptionKeysDir = keys
+	server := New(config)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	p, err := server.newPartition(&proto.Partition{
+		Subject:  "foo",
+		Stream:   "foo",
+		Replicas: []string{"a"},
+		Leader:   "a",
+		Isr:      []string{"a"},
+	}, false, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	value := []byte(strings.Repeat("secret", 10))
+	_, err = p.log.Append([]*commitlog.Message{{Value: value, Headers: map[string][]byte{}}})
+	require.NoError(t, err)
+
+	r, err := p.log.NewReader(0, true)
+	require.NoError(t, err)
+	msg, _, _, _, err := r.ReadMessage(context.Background(), make([]byte, 28))
+	require.NoError(t, err)
+	require.Equal(t, value, msg.Value())
+
+	logs, err := filepath.Glob(filepath.Join(config.DataDir, "streams", "foo", "0", "*.log"))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	data, err := ioutil.ReadFile(logs[0])
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(data, value))
+}
+
+// Ensure streams can compress sealed segments with their own codec and that
+// segment compression can't be combined with segment encryption.
+func TestPartitionSegmentCompressionOverride(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 0)
+	config.Streams.SegmentMaxBytes = 1
+	server := New(config)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	value := []byte(strings.Repeat("compressible", 100))
+	sealedLogSize := func(stream string, streamConfig *proto.StreamConfig) int64 {
+		p, err := server.newPartition(&proto.Partition{
+			Subject:  stream,
+			Stream:   stream,
+			Replicas: []string{"a"},
+			Leader:   "a",
+			Isr:      []string{"a"},
+		}, false, streamConfig)
+		require.NoError(t, err)
+		defer p.Close()
+		for i := 0; i < 3; i++ {
+			_, err = p.log.Append([]*commitlog.Message{{
+				Value:     value,
+				Timestamp: time.Now().UnixNano(),
+				Headers:   map[string][]byte{},
+			}})
+			require.NoError(t, err)
+		}
+		require.NoError(t, p.log.Clean(context.Background()))
+		info, err := os.Stat(filepath.Join(config.DataDir, "streams", stream, "0",
+			"00000000000000000000.log"))
+		require.NoError(t, err)
+		return info.Size()
+	}
+	require.True(t, sealedLogSize("foo", nil) > int64(len(value)))
+	require.True(t, sealedLogSize("bar", &proto.StreamConfig{SegmentCompression: "zstd"}) < int64(len(value)))
+
+	server.config.Streams.SegmentEncryption = true
+	st := server.api.checkSegmentCompression(&proto.StreamConfig{SegmentCompression: "lz4"})
+	require.NotNil(t, st)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Nil(t, server.api.checkSegmentCompression(&proto.StreamConfig{SegmentCompression: "none"}))
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// PartitionKeyMetadata is the CreateStream request metadata key specifying
+// where the key of messages published to the stream's NATS subjects comes
+// from. It is "key" for the message key, "header:<name>" for the value of the
+// named message header, or "json:<path>" for the field of a JSON message
+// value at the given dot-separated path, e.g. "json:user.id". The key is
+// evaluated by the server and used to assign messages published to the
+// stream's subject to a partition by key hash.
+const PartitionKeyMetadata = "liftbridge-partition-key"
+
+const (
+	partitionKeySourceKey    = "key"
+	partitionKeySourceHeader = "header:"
+	partitionKeySourceJSON   = "json:"
+)
+
+// keyExtractor evaluates the partitioning key of a message according to a
+// stream's PartitionKey setting.
+type keyExtractor struct {
+	header string
+	path   []string
+}
+
+// newKeyExtractor returns a keyExtractor for the given PartitionKey setting
+// or an error if it is invalid.
+func newKeyExtractor(source string) (*keyExtractor, error) {
+	switch {
+	case source == partitionKeySourceKey:
+		return &keyExtractor{}, nil
+	case strings.HasPrefix(source, partitionKeySourceHeader):
+		header := strings.TrimPrefix(source, partitionKeySourceHeader)
+		if header == "" {
+			return nil, fmt.Errorf("header name cannot be empty")
+		}
+		return &keyExtractor{header: header}, nil
+	case strings.HasPrefix(source, partitionKeySourceJSON):
+		path := strings.Split(strings.TrimPrefix(source, partitionKeySourceJSON), ".")
+		for _, field := range path {
+			if field == "" {
+				return nil, fmt.Errorf("invalid JSON path %q",
+					strings.TrimPrefix(source, partitionKeySourceJSON))
+			}
+		}
+		return &keyExtractor{path: path}, nil
+	default:
+		return nil, fmt.Errorf("unknown partition key source %q", source)
+	}
+}
+
+// extract returns the partitioning key of a message with the given key,
+// headers, and value, or nil if the message doesn't have one.
+func (k *keyExtractor) extract(key []byte, headers map[string][]byte, value []byte) []byte {
+	switch {
+	case k.header != "":
+		return headers[k.header]
+	case k.path != nil:
+		return jsonField(value, k.path)
+	default:
+		return key
+	}
+}
+
+// jsonField returns the field at the given path in a JSON document. Strings
+// are returned without quotes and other values as JSON. Numeric path elements
+// index into arrays. It returns nil if the value is not JSON, the field does
+// not exist, or it is null.
+func jsonField(data []byte, path []string) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	for _, field := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[field]
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []byte(v)
+	case json.Number:
+		return []byte(v.String())
+	default:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return buf
+	}
+}
+
+// applyPartitionKeyMetadata sets the partition key source of a CreateStream
+// request from the request metadata on the given StreamConfig.
+func applyPartitionKeyMetadata(md metadata.MD, config *proto.StreamConfig) *status.Status {
+	values := md.Get(PartitionKeyMetadata)
+	if len(values) == 0 {
+		return nil
+	}
+	if _, err := newKeyExtractor(values[0]); err != nil {
+		return status.Newf(codes.InvalidArgument, "Invalid %s value: %v", PartitionKeyMetadata, err)
+	}
+	config.PartitionKey = values[0]
+	return nil
+}
+
+// routeByKey forwards a message received on the stream's subject to the
+// partition its key hashes to if that is not this partition. It returns true
+// if the message was forwarded. Only partition 0, which is attached to the
+// stream's subject, routes messages, and messages published to the subject of
+// a specific partition stay in that partition. Atomic batches, messages
+// without a key, and messages for partitions which are paused are not routed.
+func (p *partition) routeByKey(msg *nats.Msg) bool {
+	if p.keyExtractor == nil || p.Id != 0 {
+		return false
+	}
+	stream := p.srv.metadata.GetStream(p.Stream)
+	if stream == nil {
+		return false
+	}
+	numPartitions := uint32(len(stream.GetPartitions()))
+	if numPartitions < 2 {
+		return false
+	}
+	if proto.IsPublishBatch(msg.Data) {
+		return false
+	}
+
+	var key []byte
+	if message := getMessage(msg.Data); message != nil {
+		key = p.keyExtractor.extract(message.Key, message.Headers, message.Value)
+	} else {
+		key = p.keyExtractor.extract(nil, nil, msg.Data)
+	}
+	if len(key) == 0 {
+		return false
+	}
+	target := stream.GetPartition(int32(hasher(key) % numPartitions))
+	if target == nil || target.Id == p.Id || target.IsPaused() {
+		return false
+	}
+	if err := p.srv.nc.PublishRequest(target.getSubject(), msg.Reply, msg.Data); err != nil {
+		p.srv.logger.Errorf("Failed to route message to partition %s: %v", target, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure newKeyExtractor parses partition key sources and extract evaluates
+// them.
+func TestKeyExtractor(t *testing.T) {
+	var (
+		key     = []byte("key")
+		headers = map[string][]byte{"user": []byte("alice")}
+		value   = []byte(`{"user": {"id": 42, "name": "bob", "tags": ["a", "b"]}}`)
+	)
+	tests := []struct {
+		source   string
+		expected []byte
+	}{
+		{"key", key},
+		{"header:user", []byte("alice")},
+		{"header:missing", nil},
+		{"json:user.id", []byte("42")},
+		{"json:user.name", []byte("bob")},
+		{"json:user.tags.1", []byte("b")},
+		{"json:user.tags", []byte(`["a","b"]`)},
+		{"json:user.missing", nil},
+		{"json:user.tags.2", nil},
+	}
+	for _, test := range tests {
+		extractor, err := newKeyExtractor(test.source)
+		require.NoError(t, err, test.source)
+		require.Equal(t, test.expected, extractor.extract(key, headers, value), test.source)
+	}
+
+	extractor, err := newKeyExtractor("json:user")
+	require.NoError(t, err)
+	require.Nil(t, extractor.extract(nil, nil, []byte("not json")))
+
+	for _, source := range []string{"", "value", "header:", "json:", "json:user..id"} {
+		_, err := newKeyExtractor(source)
+		require.Error(t, err, source)
+	}
+}
+
+// Ensure applyPartitionKeyMetadata validates the partition key source.
+func TestApplyPartitionKeyMetadata(t *testing.T) {
+	config := new(proto.StreamConfig)
+	require.Nil(t, applyPartitionKeyMetadata(metadata.MD{}, config))
+	require.Equal(t, "", config.PartitionKey)
+
+	require.Nil(t, applyPartitionKeyMetadata(metadata.Pairs(PartitionKeyMetadata, "json:user.id"), config))
+	require.Equal(t, "json:user.id", config.PartitionKey)
+
+	st := applyPartitionKeyMetadata(metadata.Pairs(PartitionKeyMetadata, "value"), new(proto.StreamConfig))
+	require.NotNil(t, st)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
+
+// Ensure messages published to a stream's NATS subject are keyed and
+// partitioned by the stream's partition key source.
+func TestPartitionKeyRouting(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	nc, err := nats.GetDefaultOptions().Connect()
+	require.NoError(t, err)
+	defer nc.Close()
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(
+		metadata.AppendToOutgoingContext(ctx, PartitionKeyMetadata, "json:user"),
+		&client.CreateStreamRequest{Subject: "foo", Name: "foo", Partitions: 3},
+	)
+	require.NoError(t, err)
+
+	num := 30
+	for i := 0; i < num; i++ {
+		value := fmt.Sprintf(`{"user": "user-%d", "seq": %d}`, i%7, i)
+		require.NoError(t, nc.Publish("foo", []byte(value)))
+	}
+	// Messages published to a partition's subject stay in the partition.
+	require.NoError(t, nc.Publish("foo.1", []byte(`{"user": "direct"}`)))
+	require.NoError(t, nc.Flush())
+
+	stream := s1.metadata.GetStream("foo")
+	require.NotNil(t, stream)
+	waitForStreamMessages(t, 5*time.Second, stream, int64(num+1))
+
+	received := 0
+	for id := int32(0); id < 3; id++ {
+		sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+			Stream:        "foo",
+			Partition:     id,
+			StartPosition: client.StartPosition_EARLIEST,
+		})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+
+		hw := stream.GetPartition(id).log.HighWatermark()
+		for i := int64(0); i <= hw; i++ {
+			msg, err := sub.Recv()
+			require.NoError(t, err)
+			if string(msg.Key) == "direct" {
+				require.Equal(t, int32(1), id)
+				continue
+			}
+			require.Equal(t, int32(hasher(msg.Key)%3), id, string(msg.Key))
+			received++
+		}
+	}
+	require.Equal(t, num, received)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// maxPooledEnvelopeSize is the capacity above which envelope buffers are not
+// returned to the pool so that an occasional large publish does not pin
+// memory.
+const maxPooledEnvelopeSize = 1024 * 1024 // 1MB
+
+// envelopePool holds the buffers publishes and acks are serialized in before
+// they're sent over NATS, which copies them. Reusing them avoids allocating a
+// buffer for every message.
+var envelopePool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+// messagePool holds the protobuf messages publishes are serialized from and
+// deserialized into on their way to the log.
+var messagePool = sync.Pool{
+	New: func() interface{} {
+		return new(client.Message)
+	},
+}
+
+// getEnvelopeBuffer returns an empty buffer from the pool to append an
+// envelope to.
+func getEnvelopeBuffer() []byte {
+	return (*envelopePool.Get().(*[]byte))[:0]
+}
+
+// putEnvelopeBuffer returns a buffer obtained with getEnvelopeBuffer to the
+// pool. The buffer must not be used afterwards.
+func putEnvelopeBuffer(buf []byte) {
+	if cap(buf) > maxPooledEnvelopeSize {
+		return
+	}
+	buf = buf[:0]
+	envelopePool.Put(&buf)
+}
+
+// getPooledMessage returns an empty protobuf message from the pool.
+func getPooledMessage() *client.Message {
+	return messagePool.Get().(*client.Message)
+}
+
+// putPooledMessage resets the protobuf message, dropping its references, and
+// returns it to the pool. The message must not be used afterwards.
+func putPooledMessage(msg *client.Message) {
+	msg.Reset()
+	messagePool.Put(msg)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure messages converted from pooled protobuf messages and envelope
+// buffers are not affected by the pooled values being reused.
+func TestNatsToProtoMessagesPooled(t *testing.T) {
+	convert := func(req *client.PublishRequest, batch bool) []*commitlog.Message {
+		buf, e := marshalPublishRequest(req, "foo", batch)
+		require.Nil(t, e)
+		msgs := natsToProtoMessages(&nats.Msg{Subject: "foo", Reply: "bar", Data: buf}, 1)
+		putEnvelopeBuffer(buf)
+		return msgs
+	}
+
+	first := convert(&client.PublishRequest{
+		Key:       []byte("a"),
+		Value:     []byte("one"),
+		Headers:   map[string][]byte{"foo": []byte("bar")},
+		AckInbox:  "ack",
+		AckPolicy: client.AckPolicy_ALL,
+	}, false)
+	value, err := proto.MarshalBatch([]*client.Message{{Value: []byte("two")}, {Value: []byte("three")}})
+	require.NoError(t, err)
+	batch := convert(&client.PublishRequest{Value: value}, true)
+	second := convert(&client.PublishRequest{Key: []byte("b"), Value: []byte("four")}, false)
+	raw := natsToProtoMessages(&nats.Msg{Subject: "foo", Data: []byte("five")}, 1)
+
+	require.Len(t, first, 1)
+	require.Equal(t, []byte("a"), first[0].Key)
+	require.Equal(t, []byte("one"), first[0].Value)
+	require.Equal(t, "ack", first[0].AckInbox)
+	require.Equal(t, client.AckPolicy_ALL, first[0].AckPolicy)
+	require.Equal(t, map[string][]byte{
+		"foo":     []byte("bar"),
+		"subject": []byte("foo"),
+		"reply":   []byte("bar"),
+	}, first[0].Headers)
+
+	require.Len(t, batch, 2)
+	require.Equal(t, []byte("two"), batch[0].Value)
+	require.Equal(t, commitlog.AttrBatchContinues, batch[0].Attributes)
+	require.Equal(t, []byte("three"), batch[1].Value)
+
+	require.Len(t, second, 1)
+	require.Equal(t, []byte("b"), second[0].Key)
+	require.Equal(t, []byte("four"), second[0].Value)
+	require.Empty(t, second[0].AckInbox)
+	require.Len(t, second[0].Headers, 2)
+
+	require.Len(t, raw, 1)
+	require.Nil(t, raw[0].Key)
+	require.Equal(t, []byte("five"), raw[0].Value)
+}
+
+// BenchmarkPublishPath measures serializing a publish request into an envelope
+// and converting the envelope into a commit log message as it's received by
+// the partition.
+func BenchmarkPublishPath(b *testing.B) {
+	req := &client.PublishRequest{
+		Stream:        "foo",
+		Key:           []byte("key"),
+		Value:         bytes.Repeat([]byte("x"), 256),
+		Headers:       map[string][]byte{"foo": []byte("bar")},
+		AckInbox:      "ack",
+		CorrelationId: "123",
+		AckPolicy:     client.AckPolicy_LEADER,
+	}
+	msg := &nats.Msg{Subject: "foo"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, e := marshalPublishRequest(req, "foo", false)
+		if e != nil {
+			b.Fatal(e.Message)
+		}
+		msg.Data = buf
+		natsToProtoMessages(msg, 1)
+		putEnvelopeBuffer(buf)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// PriorityHeader is the message header containing the priority of a message
+// as an integer, where higher values are more urgent. Messages without a
+// valid priority have priority 0.
+const PriorityHeader = "Liftbridge-Priority"
+
+// PriorityWindowMetadata is the Subscribe request metadata key which enables
+// priority delivery. Its value is the maximum number of committed messages the
+// subscription reads ahead while it is behind the high watermark. Each window
+// of messages read ahead is delivered highest priority first and in offset
+// order within a priority, so messages are only ever reordered within a
+// window. Once the subscription has caught up, messages are delivered in
+// offset order as they are committed.
+const PriorityWindowMetadata = "liftbridge-priority-window"
+
+// priorityWindowFromContext parses the priority window from the incoming
+// request metadata. It returns 0 if the request does not enable priority
+// delivery and an InvalidArgument status if the window exceeds the given
+// maximum, since the window is buffered in memory.
+func priorityWindowFromContext(ctx context.Context, max int) (int, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	values := md.Get(PriorityWindowMetadata)
+	if len(values) == 0 {
+		return 0, nil
+	}
+	window, err := strconv.Atoi(values[0])
+	if err != nil || window < 0 {
+		return 0, status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", PriorityWindowMetadata, values[0]))
+	}
+	if window > max {
+		return 0, status.New(codes.InvalidArgument,
+			fmt.Sprintf("%s value %d exceeds the maximum of %d", PriorityWindowMetadata, window, max))
+	}
+	return window, nil
+}
+
+// messagePriority returns the priority of the given message.
+func messagePriority(msg *client.Message) int64 {
+	priority, err := strconv.ParseInt(string(msg.Headers[PriorityHeader]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return priority
+}
+
+// sortByPriority orders the messages highest priority first, keeping messages
+// of equal priority in offset order.
+func sortByPriority(msgs []*client.Message) {
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return messagePriority(msgs[i]) > messagePriority(msgs[j])
+	})
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure sortByPriority orders messages highest priority first and keeps
+// messages of equal priority in offset order.
+func TestSortByPriority(t *testing.T) {
+	msg := func(offset int64, priority string) *client.Message {
+		m := &client.Message{Offset: offset, Headers: map[string][]byte{}}
+		if priority != "" {
+			m.Headers[PriorityHeader] = []byte(priority)
+		}
+		return m
+	}
+	msgs := []*client.Message{
+		msg(0, ""),
+		msg(1, "2"),
+		msg(2, "foo"),
+		msg(3, "-1"),
+		msg(4, "2"),
+		msg(5, "1"),
+	}
+	sortByPriority(msgs)
+	offsets := make([]int64, len(msgs))
+	for i, m := range msgs {
+		offsets[i] = m.Offset
+	}
+	require.Equal(t, []int64{1, 4, 5, 0, 2, 3}, offsets)
+}
+
+// Ensure priorityWindowFromContext parses the priority window metadata.
+func TestPriorityWindowFromContext(t *testing.T) {
+	window, st := priorityWindowFromContext(context.Background(), 100)
+	require.Nil(t, st)
+	require.Equal(t, 0, window)
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(PriorityWindowMetadata, "10"))
+	window, st = priorityWindowFromContext(ctx, 100)
+	require.Nil(t, st)
+	require.Equal(t, 10, window)
+
+	// Windows over the maximum are rejected rather than buffered.
+	for _, value := range []string{"foo", "-1", "101", "9223372036854775807", "99999999999999999999"} {
+		ctx = metadata.NewIncomingContext(context.Background(),
+			metadata.Pairs(PriorityWindowMetadata, value))
+		_, st = priorityWindowFromContext(ctx, 100)
+		require.Equal(t, codes.InvalidArgument, st.Code())
+	}
+}
+
+// Ensure a subscription with a priority window that is behind receives higher
+// priority messages first within each window.
+func TestSubscribePriorityWindow(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.SubscriptionPriorityWindowMax = 10
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	for i, priority := range []int{0, 0, 5, 0, 1, 5, 0} {
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			Headers:   map[string][]byte{PriorityHeader: []byte(strconv.Itoa(priority))},
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	subscribe := func(window string) []int64 {
+		subCtx := metadata.AppendToOutgoingContext(ctx, PriorityWindowMetadata, window)
+		sub, err := api.Subscribe(subCtx, &client.SubscribeRequest{
+			Stream:        "foo",
+			StartPosition: client.StartPosition_EARLIEST,
+			StopPosition:  client.StopPosition_STOP_LATEST,
+		})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+		var offsets []int64
+		for {
+			msg, err := sub.Recv()
+			if err != nil {
+				require.Equal(t, codes.ResourceExhausted, status.Code(err))
+				return offsets
+			}
+			offsets = append(offsets, msg.Offset)
+		}
+	}
+
+	require.Equal(t, []int64{2, 0, 1, 3, 5, 4, 6}, subscribe("4"))
+	require.Equal(t, []int64{2, 5, 4, 0, 1, 3, 6}, subscribe("10"))
+	require.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6}, subscribe("0"))
+
+	for _, window := range []string{"foo", "11", "9223372036854775807"} {
+		sub, err := api.Subscribe(metadata.AppendToOutgoingContext(ctx, PriorityWindowMetadata, window),
+			&client.SubscribeRequest{Stream: "foo"})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
@@ -6,8 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"hash/crc32"
+	"io"
 
-	pb "github.com/golang/protobuf/proto"
 	client "github.com/liftbridge-io/liftbridge-api/go"
 )
 
@@ -37,6 +37,10 @@ const (
 	msgTypePartitionStatusResponse
 
 	msgTypePartitionNotification
+
+	msgTypePublishBatch
+
+	msgTypeAckBatch
 )
 
 const (
@@ -60,18 +64,133 @@ var (
 	envelopeMagicNumberLen = len(envelopeMagicNumber)
 
 	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errMissingHeader       = errors.New("data missing envelope header")
+	errUnexpectedMagic     = errors.New("unexpected envelope magic number")
+	errIncorrectHeaderSize = errors.New("incorrect envelope header size")
 )
 
+// marshaler is a protobuf message which can marshal itself into a provided
+// buffer, as the generated protobuf types do.
+type marshaler interface {
+	Size() int
+	MarshalTo([]byte) (int, error)
+}
+
+// unmarshaler is a protobuf message which can unmarshal itself, as the
+// generated protobuf types do.
+type unmarshaler interface {
+	Reset()
+	Unmarshal([]byte) error
+}
+
 // MarshalPublish serializes a protobuf publish message into the Liftbridge
 // envelope wire format.
 func MarshalPublish(msg *client.Message) ([]byte, error) {
-	return marshalEnvelope(msg, msgTypePublish)
+	return AppendPublish(nil, msg)
+}
+
+// AppendPublish serializes a protobuf publish message into the Liftbridge
+// envelope wire format, appending it to buf, and returns the extended buffer.
+// This lets callers reuse buffers across publishes.
+func AppendPublish(buf []byte, msg *client.Message) ([]byte, error) {
+	return appendEnvelope(buf, msg, msgTypePublish)
+}
+
+// MarshalPublishBatch serializes an atomic batch of protobuf publish messages
+// into the Liftbridge envelope wire format.
+func MarshalPublishBatch(msgs []*client.Message) ([]byte, error) {
+	return AppendPublishBatch(nil, msgs)
+}
+
+// AppendPublishBatch serializes an atomic batch of protobuf publish messages
+// into the Liftbridge envelope wire format, appending it to buf, and returns
+// the extended buffer.
+func AppendPublishBatch(buf []byte, msgs []*client.Message) ([]byte, error) {
+	start := len(buf)
+	buf = grow(buf, envelopeMinHeaderLen+batchSize(msgs))
+	putEnvelopeHeader(buf[start:], msgTypePublishBatch)
+	return appendBatch(buf[:start+envelopeMinHeaderLen], msgs)
+}
+
+// MarshalBatch serializes a batch of protobuf messages as a sequence of
+// messages each prefixed with its size as a varint, i.e. the protobuf
+// length-delimited format.
+func MarshalBatch(msgs []*client.Message) ([]byte, error) {
+	return appendBatch(make([]byte, 0, batchSize(msgs)), msgs)
+}
+
+// batchSize returns the size of the messages serialized with MarshalBatch.
+func batchSize(msgs []*client.Message) int {
+	size := 0
+	for _, msg := range msgs {
+		n := msg.Size()
+		size += uvarintSize(uint64(n)) + n
+	}
+	return size
+}
+
+// appendBatch serializes a batch of protobuf messages in the format of
+// MarshalBatch, appending it to buf, and returns the extended buffer.
+func appendBatch(buf []byte, msgs []*client.Message) ([]byte, error) {
+	var err error
+	for _, msg := range msgs {
+		if buf, err = appendDelimited(buf, msg); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendDelimited serializes a protobuf message prefixed with its size as a
+// varint, appending it to buf, and returns the extended buffer.
+func appendDelimited(buf []byte, msg marshaler) ([]byte, error) {
+	var (
+		varint [binary.MaxVarintLen64]byte
+		size   = msg.Size()
+	)
+	buf = append(buf, varint[:binary.PutUvarint(varint[:], uint64(size))]...)
+	start := len(buf)
+	buf = grow(buf, size)
+	n, err := msg.MarshalTo(buf[start : start+size])
+	if err != nil {
+		return nil, err
+	}
+	return buf[:start+n], nil
 }
 
 // MarshalAck serializes a protobuf ack message into the Liftbridge envelope
 // wire format.
 func MarshalAck(ack *client.Ack) ([]byte, error) {
-	return marshalEnvelope(ack, msgTypeAck)
+	return AppendAck(nil, ack)
+}
+
+// AppendAck serializes a protobuf ack message into the Liftbridge envelope
+// wire format, appending it to buf, and returns the extended buffer.
+func AppendAck(buf []byte, ack *client.Ack) ([]byte, error) {
+	return appendEnvelope(buf, ack, msgTypeAck)
+}
+
+// AppendAckBatch serializes protobuf ack messages into a single Liftbridge
+// ack batch envelope, appending it to buf, and returns the extended buffer.
+// The acks are serialized in the format of MarshalBatch.
+func AppendAckBatch(buf []byte, acks []*client.Ack) ([]byte, error) {
+	size := 0
+	for _, ack := range acks {
+		n := ack.Size()
+		size += uvarintSize(uint64(n)) + n
+	}
+	start := len(buf)
+	buf = grow(buf, envelopeMinHeaderLen+size)
+	putEnvelopeHeader(buf[start:], msgTypeAckBatch)
+	buf = buf[:start+envelopeMinHeaderLen]
+	var err error
+	for _, ack := range acks {
+		if buf, err = appendDelimited(buf, ack); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
 }
 
 // MarshalServerInfoRequest serializes a ServerInfoRequest protobuf into the
@@ -159,14 +278,52 @@ func WriteReplicationResponseHeader(buf *bytes.Buffer) int {
 
 // marshalEnvelope serializes a protobuf message into the Liftbridge envelope
 // wire format.
-func marshalEnvelope(msg pb.Message, msgType msgType) ([]byte, error) {
-	data, err := pb.Marshal(msg)
+func marshalEnvelope(msg marshaler, msgType msgType) ([]byte, error) {
+	return appendEnvelope(nil, msg, msgType)
+}
+
+// appendEnvelope serializes a protobuf message into the Liftbridge envelope
+// wire format, appending it to buf, and returns the extended buffer. The
+// message is marshaled directly after the header, so this allocates at most
+// once.
+func appendEnvelope(buf []byte, msg marshaler, msgType msgType) ([]byte, error) {
+	var (
+		start = len(buf)
+		size  = msg.Size()
+	)
+	buf = grow(buf, envelopeMinHeaderLen+size)
+	putEnvelopeHeader(buf[start:], msgType)
+	n, err := msg.MarshalTo(buf[start+envelopeMinHeaderLen : start+envelopeMinHeaderLen+size])
 	if err != nil {
 		return nil, err
 	}
+	return buf[:start+envelopeMinHeaderLen+n], nil
+}
 
+// grow extends the buffer by n bytes, reallocating it if it lacks capacity.
+func grow(buf []byte, n int) []byte {
+	if len(buf)+n <= cap(buf) {
+		return buf[:len(buf)+n]
+	}
+	grown := make([]byte, len(buf)+n, 2*len(buf)+n)
+	copy(grown, buf)
+	return grown
+}
+
+// uvarintSize returns the number of bytes needed to encode x as a varint.
+func uvarintSize(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
+
+// putEnvelopeHeader writes the Liftbridge envelope header without a CRC to
+// buf, which must have room for it.
+func putEnvelopeHeader(buf []byte, msgType msgType) {
 	var (
-		buf       = make([]byte, envelopeMagicNumberLen+4+len(data))
 		pos       = 0
 		headerLen = envelopeMinHeaderLen
 	)
@@ -184,8 +341,6 @@ func marshalEnvelope(msg pb.Message, msgType msgType) ([]byte, error) {
 		panic(fmt.Sprintf("Payload position (%d) does not match expected HeaderLen (%d)",
 			pos, headerLen))
 	}
-	copy(buf[pos:], data)
-	return buf, nil
 }
 
 // UnmarshalPublish deserializes a Liftbridge publish envelope into a protobuf
@@ -198,6 +353,50 @@ func UnmarshalPublish(data []byte) (*client.Message, error) {
 	return msg, err
 }
 
+// UnmarshalPublishTo deserializes a Liftbridge publish envelope into the given
+// protobuf message, resetting it first. This lets callers reuse messages.
+func UnmarshalPublishTo(data []byte, msg *client.Message) error {
+	return unmarshalEnvelope(data, msg, msgTypePublish)
+}
+
+// IsPublishBatch indicates if the data is a Liftbridge publish batch
+// envelope. It only checks the envelope header.
+func IsPublishBatch(data []byte) bool {
+	return len(data) >= envelopeMinHeaderLen &&
+		bytes.Equal(data[:envelopeMagicNumberLen], envelopeMagicNumber) &&
+		msgType(data[7]) == msgTypePublishBatch
+}
+
+// UnmarshalPublishBatch deserializes a Liftbridge publish batch envelope into
+// protobuf messages.
+func UnmarshalPublishBatch(data []byte) ([]*client.Message, error) {
+	payload, err := checkEnvelope(data, msgTypePublishBatch)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalBatch(payload)
+}
+
+// UnmarshalBatch deserializes a batch of protobuf messages serialized with
+// MarshalBatch.
+func UnmarshalBatch(data []byte) ([]*client.Message, error) {
+	var msgs []*client.Message
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data = data[n:]
+		msg := new(client.Message)
+		if err := msg.Unmarshal(data[:size]); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+		data = data[size:]
+	}
+	return msgs, nil
+}
+
 // UnmarshalAck deserializes a Liftbridge ack envelope into a protobuf message.
 func UnmarshalAck(data []byte) (*client.Ack, error) {
 	var (
@@ -207,6 +406,38 @@ func UnmarshalAck(data []byte) (*client.Ack, error) {
 	return ack, err
 }
 
+// IsAckBatch indicates if the data is a Liftbridge ack batch envelope. It
+// only checks the envelope header.
+func IsAckBatch(data []byte) bool {
+	return len(data) >= envelopeMinHeaderLen &&
+		bytes.Equal(data[:envelopeMagicNumberLen], envelopeMagicNumber) &&
+		msgType(data[7]) == msgTypeAckBatch
+}
+
+// UnmarshalAckBatch deserializes a Liftbridge ack batch envelope into
+// protobuf ack messages.
+func UnmarshalAckBatch(data []byte) ([]*client.Ack, error) {
+	payload, err := checkEnvelope(data, msgTypeAckBatch)
+	if err != nil {
+		return nil, err
+	}
+	var acks []*client.Ack
+	for len(payload) > 0 {
+		size, n := binary.Uvarint(payload)
+		if n <= 0 || size > uint64(len(payload)-n) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		payload = payload[n:]
+		ack := new(client.Ack)
+		if err := ack.Unmarshal(payload[:size]); err != nil {
+			return nil, err
+		}
+		acks = append(acks, ack)
+		payload = payload[size:]
+	}
+	return acks, nil
+}
+
 // UnmarshalPropagatedRequest deserializes a Liftbridge PropagatedRequest
 // envelope into a protobuf message.
 func UnmarshalPropagatedRequest(data []byte) (*PropagatedRequest, error) {
@@ -350,20 +581,21 @@ func UnmarshalReplicationResponse(data []byte) (uint64, int64, []byte, error) {
 
 // unmarshalEnvelope deserializes a Liftbridge envelope into a protobuf
 // message.
-func unmarshalEnvelope(data []byte, msg pb.Message, msgType msgType) error {
+func unmarshalEnvelope(data []byte, msg unmarshaler, msgType msgType) error {
 	payload, err := checkEnvelope(data, msgType)
 	if err != nil {
 		return err
 	}
-	return pb.Unmarshal(payload, msg)
+	msg.Reset()
+	return msg.Unmarshal(payload)
 }
 
 func checkEnvelope(data []byte, expectedType msgType) ([]byte, error) {
 	if len(data) < envelopeMinHeaderLen {
-		return nil, errors.New("data missing envelope header")
+		return nil, errMissingHeader
 	}
 	if !bytes.Equal(data[:envelopeMagicNumberLen], envelopeMagicNumber) {
-		return nil, errors.New("unexpected envelope magic number")
+		return nil, errUnexpectedMagic
 	}
 	if data[4] != envelopeProtoV0 {
 		return nil, fmt.Errorf("unknown envelope protocol: %v", data[4])
@@ -384,7 +616,7 @@ func checkEnvelope(data []byte, expectedType msgType) ([]byte, error) {
 	if hasBit(flags, 0) {
 		// Make sure there is a CRC present.
 		if headerLen != envelopeMinHeaderLen+4 {
-			return nil, errors.New("incorrect envelope header size")
+			return nil, errIncorrectHeaderSize
 		}
 		crc := Encoding.Uint32(data[envelopeMinHeaderLen:headerLen])
 		if c := crc32.Checksum(payload, crc32cTable); c != crc {
@@ -37,6 +37,44 @@ func TestMarshalUnmarshalPublish(t *testing.T) {
 	require.Equal(t, msg, unmarshaled)
 }
 
+// Ensure we can marshal a batch of messages and then unmarshal it.
+func TestMarshalUnmarshalPublishBatch(t *testing.T) {
+	msgs := []*client.Message{
+		{
+			Key:     []byte("foo"),
+			Value:   []byte("hello"),
+			Stream:  "foo",
+			Subject: "foo",
+			Headers: map[string][]byte{"foo": []byte("bar")},
+		},
+		{},
+		{
+			Value:         []byte("world"),
+			Stream:        "foo",
+			Subject:       "foo",
+			AckInbox:      "ack",
+			CorrelationId: "123",
+		},
+	}
+
+	envelope, err := MarshalPublishBatch(msgs)
+	require.NoError(t, err)
+
+	unmarshaled, err := UnmarshalPublishBatch(envelope)
+	require.NoError(t, err)
+	require.Len(t, unmarshaled, len(msgs))
+	require.Equal(t, msgs[0], unmarshaled[0])
+	require.Equal(t, msgs[2], unmarshaled[2])
+
+	// A batch is not a single publish message and vice versa.
+	_, err = UnmarshalPublish(envelope)
+	require.Error(t, err)
+	single, err := MarshalPublish(msgs[0])
+	require.NoError(t, err)
+	_, err = UnmarshalPublishBatch(single)
+	require.Error(t, err)
+}
+
 // Ensure we can marshal an ack and then unmarshal it.
 func TestMarshalUnmarshalAck(t *testing.T) {
 	ack := &client.Ack{
@@ -59,6 +97,32 @@ func TestMarshalUnmarshalAck(t *testing.T) {
 	require.Equal(t, ack, unmarshaled)
 }
 
+// Ensure we can marshal a batch of Acks and then unmarshal it.
+func TestMarshalUnmarshalAckBatch(t *testing.T) {
+	acks := []*client.Ack{
+		{Offset: 1, Stream: "foo", AckInbox: "ack", CorrelationId: "1"},
+		{Offset: 2, Stream: "foo", AckInbox: "ack", CorrelationId: "2"},
+		{Offset: 3, Stream: "foo", AckInbox: "ack", CorrelationId: "3"},
+	}
+
+	envelope, err := AppendAckBatch([]byte("prefix"), acks)
+	require.NoError(t, err)
+	require.Equal(t, []byte("prefix"), envelope[:6])
+	envelope = envelope[6:]
+	require.True(t, IsAckBatch(envelope))
+
+	unmarshaled, err := UnmarshalAckBatch(envelope)
+	require.NoError(t, err)
+	require.Equal(t, acks, unmarshaled)
+
+	// A single ack is not a batch.
+	single, err := MarshalAck(acks[0])
+	require.NoError(t, err)
+	require.False(t, IsAckBatch(single))
+	_, err = UnmarshalAckBatch(single)
+	require.Error(t, err)
+}
+
 // Ensure we can marshal a ServerInfoRequest and then unmarshal it.
 func TestMarshalUnmarshalServerInfoRequest(t *testing.T) {
 	req := &ServerInfoRequest{
@@ -160,6 +224,7 @@ func TestMarshalUnmarshalReplicationRequest(t *testing.T) {
 	req := &ReplicationRequest{
 		ReplicaID: "b",
 		Offset:    10,
+		Pipelined: true,
 	}
 	envelope, err := MarshalReplicationRequest(req)
 	require.NoError(t, err)
@@ -327,3 +392,49 @@ func setBit(n byte, pos uint8) byte {
 	n |= (1 << pos)
 	return n
 }
+
+func benchmarkMessage() *client.Message {
+	return &client.Message{
+		Key:           []byte("key"),
+		Value:         bytes.Repeat([]byte("x"), 256),
+		Stream:        "foo",
+		Subject:       "foo",
+		Headers:       map[string][]byte{"foo": []byte("bar")},
+		AckInbox:      "ack",
+		CorrelationId: "123",
+	}
+}
+
+func BenchmarkMarshalPublish(b *testing.B) {
+	msg := benchmarkMessage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalPublish(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalPublishBatch(b *testing.B) {
+	msgs := []*client.Message{benchmarkMessage(), benchmarkMessage(), benchmarkMessage()}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalPublishBatch(msgs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalPublish(b *testing.B) {
+	envelope, err := MarshalPublish(benchmarkMessage())
+	require.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := UnmarshalPublish(envelope); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -4,6 +4,7 @@
 package protocol
 
 import (
+	encoding_binary "encoding/binary"
 	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
 	io "io"
@@ -35,19 +36,25 @@ const (
 	Op_RESUME_STREAM       Op = 7
 	Op_PUBLISH_ACTIVITY    Op = 8
 	Op_SET_STREAM_READONLY Op = 9
+	Op_SET_STREAM_ALIAS    Op = 10
+	Op_SET_DERIVED_OFFSET  Op = 11
+	Op_RENAME_STREAM       Op = 12
 )
 
 var Op_name = map[int32]string{
-	0: "CREATE_STREAM",
-	1: "SHRINK_ISR",
-	2: "REPORT_LEADER",
-	3: "CHANGE_LEADER",
-	4: "EXPAND_ISR",
-	5: "DELETE_STREAM",
-	6: "PAUSE_STREAM",
-	7: "RESUME_STREAM",
-	8: "PUBLISH_ACTIVITY",
-	9: "SET_STREAM_READONLY",
+	0:  "CREATE_STREAM",
+	1:  "SHRINK_ISR",
+	2:  "REPORT_LEADER",
+	3:  "CHANGE_LEADER",
+	4:  "EXPAND_ISR",
+	5:  "DELETE_STREAM",
+	6:  "PAUSE_STREAM",
+	7:  "RESUME_STREAM",
+	8:  "PUBLISH_ACTIVITY",
+	9:  "SET_STREAM_READONLY",
+	10: "SET_STREAM_ALIAS",
+	11: "SET_DERIVED_OFFSET",
+	12: "RENAME_STREAM",
 }
 
 var Op_value = map[string]int32{
@@ -61,6 +68,9 @@ var Op_value = map[string]int32{
 	"RESUME_STREAM":       7,
 	"PUBLISH_ACTIVITY":    8,
 	"SET_STREAM_READONLY": 9,
+	"SET_STREAM_ALIAS":    10,
+	"SET_DERIVED_OFFSET":  11,
+	"RENAME_STREAM":       12,
 }
 
 func (x Op) String() string {
@@ -129,6 +139,10 @@ type RaftLog struct {
 	ResumeStreamOp       *ResumeStreamOp      `protobuf:"bytes,8,opt,name=resumeStreamOp,proto3" json:"resumeStreamOp,omitempty"`
 	PublishActivityOp    *PublishActivityOp   `protobuf:"bytes,9,opt,name=publishActivityOp,proto3" json:"publishActivityOp,omitempty"`
 	SetStreamReadonlyOp  *SetStreamReadonlyOp `protobuf:"bytes,10,opt,name=setStreamReadonlyOp,proto3" json:"setStreamReadonlyOp,omitempty"`
+	SetStreamAliasOp     *SetStreamAliasOp    `protobuf:"bytes,11,opt,name=setStreamAliasOp,proto3" json:"setStreamAliasOp,omitempty"`
+	SetDerivedOffsetOp   *SetDerivedOffsetOp  `protobuf:"bytes,12,opt,name=setDerivedOffsetOp,proto3" json:"setDerivedOffsetOp,omitempty"`
+	Version              uint32               `protobuf:"varint,13,opt,name=version,proto3" json:"version,omitempty"`
+	RenameStreamOp       *RenameStreamOp      `protobuf:"bytes,14,opt,name=renameStreamOp,proto3" json:"renameStreamOp,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
 	XXX_unrecognized     []byte               `json:"-"`
 	XXX_sizecache        int32                `json:"-"`
@@ -237,6 +251,34 @@ func (m *RaftLog) GetSetStreamReadonlyOp() *SetStreamReadonlyOp {
 	return nil
 }
 
+func (m *RaftLog) GetSetStreamAliasOp() *SetStreamAliasOp {
+	if m != nil {
+		return m.SetStreamAliasOp
+	}
+	return nil
+}
+
+func (m *RaftLog) GetSetDerivedOffsetOp() *SetDerivedOffsetOp {
+	if m != nil {
+		return m.SetDerivedOffsetOp
+	}
+	return nil
+}
+
+func (m *RaftLog) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *RaftLog) GetRenameStreamOp() *RenameStreamOp {
+	if m != nil {
+		return m.RenameStreamOp
+	}
+	return nil
+}
+
 type CreateStreamOp struct {
 	Stream               *Stream  `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -1014,6 +1056,21 @@ type StreamConfig struct {
 	MinIsr                        *NullableInt32 `protobuf:"bytes,11,opt,name=minIsr,proto3" json:"minIsr,omitempty"`
 	OptimisticConcurrencyControl  *NullableBool  `protobuf:"bytes,12,opt,name=optimisticConcurrencyControl,proto3" json:"optimisticConcurrencyControl,omitempty"`
 	Encryption                    *NullableBool  `protobuf:"bytes,13,opt,name=encryption,proto3" json:"encryption,omitempty"`
+	CompactKeepVersions           *NullableInt32 `protobuf:"bytes,14,opt,name=compactKeepVersions,proto3" json:"compactKeepVersions,omitempty"`
+	SampleOf                      string         `protobuf:"bytes,15,opt,name=sampleOf,proto3" json:"sampleOf,omitempty"`
+	SampleRate                    float64        `protobuf:"fixed64,16,opt,name=sampleRate,proto3" json:"sampleRate,omitempty"`
+	DeriveFrom                    string         `protobuf:"bytes,17,opt,name=deriveFrom,proto3" json:"deriveFrom,omitempty"`
+	DeriveKeyHeader               string         `protobuf:"bytes,18,opt,name=deriveKeyHeader,proto3" json:"deriveKeyHeader,omitempty"`
+	SnapshotOf                    string         `protobuf:"bytes,19,opt,name=snapshotOf,proto3" json:"snapshotOf,omitempty"`
+	PartitionKey                  string         `protobuf:"bytes,20,opt,name=partitionKey,proto3" json:"partitionKey,omitempty"`
+	RetentionMaxKeys              *NullableInt64 `protobuf:"bytes,21,opt,name=retentionMaxKeys,proto3" json:"retentionMaxKeys,omitempty"`
+	FlushMessages                 *NullableInt64 `protobuf:"bytes,22,opt,name=flushMessages,proto3" json:"flushMessages,omitempty"`
+	FlushMs                       *NullableInt64 `protobuf:"bytes,23,opt,name=flushMs,proto3" json:"flushMs,omitempty"`
+	TieredStorageEnabled          *NullableBool  `protobuf:"bytes,24,opt,name=tieredStorageEnabled,proto3" json:"tieredStorageEnabled,omitempty"`
+	TieredStorageBucket           string         `protobuf:"bytes,25,opt,name=tieredStorageBucket,proto3" json:"tieredStorageBucket,omitempty"`
+	TieredStoragePrefix           string         `protobuf:"bytes,26,opt,name=tieredStoragePrefix,proto3" json:"tieredStoragePrefix,omitempty"`
+	SegmentCompression            string         `protobuf:"bytes,27,opt,name=segmentCompression,proto3" json:"segmentCompression,omitempty"`
+	DedupWindow                   *NullableInt64 `protobuf:"bytes,28,opt,name=dedupWindow,proto3" json:"dedupWindow,omitempty"`
 	XXX_NoUnkeyedLiteral          struct{}       `json:"-"`
 	XXX_unrecognized              []byte         `json:"-"`
 	XXX_sizecache                 int32          `json:"-"`
@@ -1143,15 +1200,123 @@ func (m *StreamConfig) GetEncryption() *NullableBool {
 	return nil
 }
 
+func (m *StreamConfig) GetCompactKeepVersions() *NullableInt32 {
+	if m != nil {
+		return m.CompactKeepVersions
+	}
+	return nil
+}
+
+func (m *StreamConfig) GetSampleOf() string {
+	if m != nil {
+		return m.SampleOf
+	}
+	return ""
+}
+
+func (m *StreamConfig) GetSampleRate() float64 {
+	if m != nil {
+		return m.SampleRate
+	}
+	return 0
+}
+
+func (m *StreamConfig) GetDeriveFrom() string {
+	if m != nil {
+		return m.DeriveFrom
+	}
+	return ""
+}
+
+func (m *StreamConfig) GetDeriveKeyHeader() string {
+	if m != nil {
+		return m.DeriveKeyHeader
+	}
+	return ""
+}
+
+func (m *StreamConfig) GetSnapshotOf() string {
+	if m != nil {
+		return m.SnapshotOf
+	}
+	return ""
+}
+
+func (m *StreamConfig) GetPartitionKey() string {
+	if m != nil {
+		return m.PartitionKey
+	}
+	return ""
+}
+
+func (m *StreamConfig) GetRetentionMaxKeys() *NullableInt64 {
+	if m != nil {
+		return m.RetentionMaxKeys
+	}
+	return nil
+}
+
+func (m *StreamConfig) GetFlushMessages() *NullableInt64 {
+	if m != nil {
+		return m.FlushMessages
+	}
+	return nil
+}
+
+func (m *StreamConfig) GetFlushMs() *NullableInt64 {
+	if m != nil {
+		return m.FlushMs
+	}
+	return nil
+}
+
+func (m *StreamConfig) GetTieredStorageEnabled() *NullableBool {
+	if m != nil {
+		return m.TieredStorageEnabled
+	}
+	return nil
+}
+
+func (m *StreamConfig) GetTieredStorageBucket() string {
+	if m != nil {
+		return m.TieredStorageBucket
+	}
+	return ""
+}
+
+func (m *StreamConfig) GetTieredStoragePrefix() string {
+	if m != nil {
+		return m.TieredStoragePrefix
+	}
+	return ""
+}
+
+func (m *StreamConfig) GetSegmentCompression() string {
+	if m != nil {
+		return m.SegmentCompression
+	}
+	return ""
+}
+
+func (m *StreamConfig) GetDedupWindow() *NullableInt64 {
+	if m != nil {
+		return m.DedupWindow
+	}
+	return nil
+}
+
 type Stream struct {
-	Name                 string        `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
-	Subject              string        `protobuf:"bytes,2,opt,name=subject,proto3" json:"subject,omitempty"`
-	Partitions           []*Partition  `protobuf:"bytes,3,rep,name=partitions,proto3" json:"partitions,omitempty"`
-	Config               *StreamConfig `protobuf:"bytes,4,opt,name=config,proto3" json:"config,omitempty"`
-	CreationTimestamp    int64         `protobuf:"varint,5,opt,name=creationTimestamp,proto3" json:"creationTimestamp,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
-	XXX_unrecognized     []byte        `json:"-"`
-	XXX_sizecache        int32         `json:"-"`
+	Name                 string           `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Subject              string           `protobuf:"bytes,2,opt,name=subject,proto3" json:"subject,omitempty"`
+	Partitions           []*Partition     `protobuf:"bytes,3,rep,name=partitions,proto3" json:"partitions,omitempty"`
+	Config               *StreamConfig    `protobuf:"bytes,4,opt,name=config,proto3" json:"config,omitempty"`
+	CreationTimestamp    int64            `protobuf:"varint,5,opt,name=creationTimestamp,proto3" json:"creationTimestamp,omitempty"`
+	Aliases              []string         `protobuf:"bytes,6,rep,name=aliases,proto3" json:"aliases,omitempty"`
+	DerivedOffsets       []*DerivedOffset `protobuf:"bytes,7,rep,name=derivedOffsets,proto3" json:"derivedOffsets,omitempty"`
+	DisplayName          string           `protobuf:"bytes,8,opt,name=displayName,proto3" json:"displayName,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
 }
 
 func (m *Stream) Reset()         { *m = Stream{} }
@@ -1222,6 +1387,27 @@ func (m *Stream) GetCreationTimestamp() int64 {
 	return 0
 }
 
+func (m *Stream) GetAliases() []string {
+	if m != nil {
+		return m.Aliases
+	}
+	return nil
+}
+
+func (m *Stream) GetDerivedOffsets() []*DerivedOffset {
+	if m != nil {
+		return m.DerivedOffsets
+	}
+	return nil
+}
+
+func (m *Stream) GetDisplayName() string {
+	if m != nil {
+		return m.DisplayName
+	}
+	return ""
+}
+
 type Partition struct {
 	Subject              string   `protobuf:"bytes,1,opt,name=subject,proto3" json:"subject,omitempty"`
 	Stream               string   `protobuf:"bytes,2,opt,name=stream,proto3" json:"stream,omitempty"`
@@ -1463,6 +1649,7 @@ func (m *RaftJoinResponse) GetError() string {
 
 type MetadataSnapshot struct {
 	Streams              []*Stream `protobuf:"bytes,1,rep,name=streams,proto3" json:"streams,omitempty"`
+	Version              uint32    `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
 	XXX_unrecognized     []byte    `json:"-"`
 	XXX_sizecache        int32     `json:"-"`
@@ -1508,10 +1695,18 @@ func (m *MetadataSnapshot) GetStreams() []*Stream {
 	return nil
 }
 
+func (m *MetadataSnapshot) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
 type ReplicationRequest struct {
 	ReplicaID            string   `protobuf:"bytes,1,opt,name=replicaID,proto3" json:"replicaID,omitempty"`
 	Offset               int64    `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
 	LeaderEpoch          uint64   `protobuf:"varint,3,opt,name=leaderEpoch,proto3" json:"leaderEpoch,omitempty"`
+	Pipelined            bool     `protobuf:"varint,4,opt,name=pipelined,proto3" json:"pipelined,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -1571,6 +1766,13 @@ func (m *ReplicationRequest) GetLeaderEpoch() uint64 {
 	return 0
 }
 
+func (m *ReplicationRequest) GetPipelined() bool {
+	if m != nil {
+		return m.Pipelined
+	}
+	return false
+}
+
 type LeaderEpochOffsetRequest struct {
 	LeaderEpoch          uint64   `protobuf:"varint,1,opt,name=leaderEpoch,proto3" json:"leaderEpoch,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -1675,6 +1877,9 @@ type PropagatedRequest struct {
 	PauseStreamOp        *PauseStreamOp       `protobuf:"bytes,7,opt,name=pauseStreamOp,proto3" json:"pauseStreamOp,omitempty"`
 	ResumeStreamOp       *ResumeStreamOp      `protobuf:"bytes,8,opt,name=resumeStreamOp,proto3" json:"resumeStreamOp,omitempty"`
 	SetStreamReadonlyOp  *SetStreamReadonlyOp `protobuf:"bytes,9,opt,name=setStreamReadonlyOp,proto3" json:"setStreamReadonlyOp,omitempty"`
+	SetStreamAliasOp     *SetStreamAliasOp    `protobuf:"bytes,10,opt,name=setStreamAliasOp,proto3" json:"setStreamAliasOp,omitempty"`
+	SetDerivedOffsetOp   *SetDerivedOffsetOp  `protobuf:"bytes,11,opt,name=setDerivedOffsetOp,proto3" json:"setDerivedOffsetOp,omitempty"`
+	RenameStreamOp       *RenameStreamOp      `protobuf:"bytes,12,opt,name=renameStreamOp,proto3" json:"renameStreamOp,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
 	XXX_unrecognized     []byte               `json:"-"`
 	XXX_sizecache        int32                `json:"-"`
@@ -1776,6 +1981,27 @@ func (m *PropagatedRequest) GetSetStreamReadonlyOp() *SetStreamReadonlyOp {
 	return nil
 }
 
+func (m *PropagatedRequest) GetSetStreamAliasOp() *SetStreamAliasOp {
+	if m != nil {
+		return m.SetStreamAliasOp
+	}
+	return nil
+}
+
+func (m *PropagatedRequest) GetSetDerivedOffsetOp() *SetDerivedOffsetOp {
+	if m != nil {
+		return m.SetDerivedOffsetOp
+	}
+	return nil
+}
+
+func (m *PropagatedRequest) GetRenameStreamOp() *RenameStreamOp {
+	if m != nil {
+		return m.RenameStreamOp
+	}
+	return nil
+}
+
 type Error struct {
 	Code                 uint32   `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
 	Msg                  string   `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
@@ -1937,6 +2163,12 @@ type ServerInfoResponse struct {
 	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Host                 string   `protobuf:"bytes,2,opt,name=host,proto3" json:"host,omitempty"`
 	Port                 int32    `protobuf:"varint,3,opt,name=port,proto3" json:"port,omitempty"`
+	Version              string   `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
+	Rack                 string   `protobuf:"bytes,5,opt,name=rack,proto3" json:"rack,omitempty"`
+	Serving              bool     `protobuf:"varint,6,opt,name=serving,proto3" json:"serving,omitempty"`
+	AdminAddress         string   `protobuf:"bytes,7,opt,name=adminAddress,proto3" json:"adminAddress,omitempty"`
+	Listeners            []string `protobuf:"bytes,8,rep,name=listeners,proto3" json:"listeners,omitempty"`
+	MetadataVersion      uint32   `protobuf:"varint,9,opt,name=metadataVersion,proto3" json:"metadataVersion,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -1996,6 +2228,48 @@ func (m *ServerInfoResponse) GetPort() int32 {
 	return 0
 }
 
+func (m *ServerInfoResponse) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *ServerInfoResponse) GetRack() string {
+	if m != nil {
+		return m.Rack
+	}
+	return ""
+}
+
+func (m *ServerInfoResponse) GetServing() bool {
+	if m != nil {
+		return m.Serving
+	}
+	return false
+}
+
+func (m *ServerInfoResponse) GetAdminAddress() string {
+	if m != nil {
+		return m.AdminAddress
+	}
+	return ""
+}
+
+func (m *ServerInfoResponse) GetListeners() []string {
+	if m != nil {
+		return m.Listeners
+	}
+	return nil
+}
+
+func (m *ServerInfoResponse) GetMetadataVersion() uint32 {
+	if m != nil {
+		return m.MetadataVersion
+	}
+	return 0
+}
+
 type PartitionStatusRequest struct {
 	Stream               string   `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
 	Partition            int32    `protobuf:"varint,2,opt,name=partition,proto3" json:"partition,omitempty"`
@@ -2232,185 +2506,468 @@ func (m *Cursor) GetOffset() int64 {
 	return 0
 }
 
-func init() {
-	proto.RegisterEnum("protocol.Op", Op_name, Op_value)
-	proto.RegisterType((*ServerState)(nil), "protocol.ServerState")
-	proto.RegisterType((*RaftLog)(nil), "protocol.RaftLog")
-	proto.RegisterType((*CreateStreamOp)(nil), "protocol.CreateStreamOp")
-	proto.RegisterType((*ShrinkISROp)(nil), "protocol.ShrinkISROp")
-	proto.RegisterType((*ExpandISROp)(nil), "protocol.ExpandISROp")
-	proto.RegisterType((*DeleteStreamOp)(nil), "protocol.DeleteStreamOp")
-	proto.RegisterType((*PauseStreamOp)(nil), "protocol.PauseStreamOp")
-	proto.RegisterType((*ResumeStreamOp)(nil), "protocol.ResumeStreamOp")
-	proto.RegisterType((*ReportLeaderOp)(nil), "protocol.ReportLeaderOp")
-	proto.RegisterType((*ChangeLeaderOp)(nil), "protocol.ChangeLeaderOp")
-	proto.RegisterType((*PublishActivityOp)(nil), "protocol.PublishActivityOp")
-	proto.RegisterType((*SetStreamReadonlyOp)(nil), "protocol.SetStreamReadonlyOp")
-	proto.RegisterType((*NullableInt64)(nil), "protocol.NullableInt64")
-	proto.RegisterType((*NullableInt32)(nil), "protocol.NullableInt32")
-	proto.RegisterType((*NullableBool)(nil), "protocol.NullableBool")
-	proto.RegisterType((*StreamConfig)(nil), "protocol.StreamConfig")
-	proto.RegisterType((*Stream)(nil), "protocol.Stream")
-	proto.RegisterType((*Partition)(nil), "protocol.Partition")
-	proto.RegisterType((*RaftJoinRequest)(nil), "protocol.RaftJoinRequest")
-	proto.RegisterType((*RaftJoinResponse)(nil), "protocol.RaftJoinResponse")
-	proto.RegisterType((*MetadataSnapshot)(nil), "protocol.MetadataSnapshot")
-	proto.RegisterType((*ReplicationRequest)(nil), "protocol.ReplicationRequest")
-	proto.RegisterType((*LeaderEpochOffsetRequest)(nil), "protocol.LeaderEpochOffsetRequest")
-	proto.RegisterType((*LeaderEpochOffsetResponse)(nil), "protocol.LeaderEpochOffsetResponse")
-	proto.RegisterType((*PropagatedRequest)(nil), "protocol.PropagatedRequest")
-	proto.RegisterType((*Error)(nil), "protocol.Error")
-	proto.RegisterType((*PropagatedResponse)(nil), "protocol.PropagatedResponse")
-	proto.RegisterType((*ServerInfoRequest)(nil), "protocol.ServerInfoRequest")
-	proto.RegisterType((*ServerInfoResponse)(nil), "protocol.ServerInfoResponse")
-	proto.RegisterType((*PartitionStatusRequest)(nil), "protocol.PartitionStatusRequest")
-	proto.RegisterType((*PartitionStatusResponse)(nil), "protocol.PartitionStatusResponse")
-	proto.RegisterType((*PartitionNotification)(nil), "protocol.PartitionNotification")
-	proto.RegisterType((*Cursor)(nil), "protocol.Cursor")
+type SetStreamAliasOp struct {
+	Stream               string   `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
+	Alias                string   `protobuf:"bytes,2,opt,name=alias,proto3" json:"alias,omitempty"`
+	Remove               bool     `protobuf:"varint,3,opt,name=remove,proto3" json:"remove,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-func init() { proto.RegisterFile("internal.proto", fileDescriptor_41f4a519b878ee3b) }
-
-var fileDescriptor_41f4a519b878ee3b = []byte{
-	// 1606 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe4, 0x58, 0x5f, 0x6f, 0x1b, 0xc7,
-	0x11, 0x37, 0xff, 0x8a, 0x1c, 0x4a, 0x34, 0xb5, 0xb2, 0x65, 0xd6, 0x95, 0x05, 0xe1, 0x5a, 0x03,
-	0xac, 0xd1, 0xaa, 0xa8, 0x5c, 0xb8, 0x68, 0xd1, 0x1a, 0xa5, 0xa4, 0xab, 0xc5, 0x9a, 0x12, 0x85,
-	0x25, 0x1d, 0xc4, 0x41, 0x10, 0xe1, 0x74, 0xb7, 0xa4, 0x2e, 0x39, 0xde, 0x5e, 0x76, 0x97, 0x82,
-	0xf4, 0x4d, 0x92, 0xbc, 0xe5, 0x29, 0x1f, 0x22, 0x8f, 0xc9, 0x43, 0xf2, 0x96, 0x8f, 0x10, 0x38,
-	0x5f, 0x23, 0x0f, 0xc1, 0xee, 0xed, 0xfd, 0xa5, 0xa8, 0x20, 0xf4, 0x4b, 0x80, 0x3c, 0x71, 0x67,
-	0xf6, 0x37, 0xbf, 0x99, 0xdd, 0x9b, 0x9d, 0xd9, 0x25, 0x34, 0x5d, 0x5f, 0x10, 0xe6, 0x5b, 0xde,
-	0x6e, 0xc0, 0xa8, 0xa0, 0xa8, 0xa6, 0x7e, 0x6c, 0xea, 0x19, 0x7f, 0x82, 0xc6, 0x90, 0xb0, 0x4b,
-	0xc2, 0x86, 0xc2, 0x12, 0x04, 0x3d, 0x84, 0x1a, 0x57, 0x62, 0xef, 0xb0, 0x5d, 0xd8, 0x29, 0x74,
-	0xea, 0x38, 0x96, 0x8d, 0x1f, 0xcb, 0xb0, 0x82, 0xad, 0xb1, 0xe8, 0xd3, 0x09, 0xda, 0x82, 0x22,
-	0x0d, 0x14, 0xa2, 0xb9, 0xb7, 0xba, 0x1b, 0xb1, 0xed, 0x0e, 0x02, 0x5c, 0xa4, 0x01, 0xfa, 0x2f,
-	0x34, 0x6d, 0x46, 0x2c, 0x41, 0x86, 0x82, 0x11, 0x6b, 0x3a, 0x08, 0xda, 0xc5, 0x9d, 0x42, 0xa7,
-	0xb1, 0xd7, 0x4e, 0x90, 0x07, 0x99, 0x79, 0x9c, 0xc3, 0xa3, 0x7f, 0x40, 0x83, 0x5f, 0x30, 0xd7,
-	0xff, 0xa8, 0x37, 0xc4, 0x83, 0xa0, 0x5d, 0x52, 0xe6, 0xf7, 0x13, 0xf3, 0x61, 0x32, 0x89, 0xd3,
-	0x48, 0xe5, 0xfa, 0xc2, 0xf2, 0x27, 0xa4, 0x4f, 0x2c, 0x87, 0xb0, 0x41, 0xd0, 0x2e, 0xcf, 0xb9,
-	0xce, 0xcc, 0xe3, 0x1c, 0x5e, 0xba, 0x26, 0x57, 0x81, 0xe5, 0x3b, 0xa1, 0xeb, 0x4a, 0xde, 0xb5,
-	0x99, 0x4c, 0xe2, 0x34, 0x52, 0xba, 0x76, 0x88, 0x47, 0x52, 0xab, 0xae, 0xe6, 0x5d, 0x1f, 0x66,
-	0xe6, 0x71, 0x0e, 0x8f, 0xfe, 0x03, 0x6b, 0x81, 0x35, 0xe3, 0x09, 0xc1, 0x8a, 0x22, 0x78, 0x90,
-	0x10, 0x9c, 0xa6, 0xa7, 0x71, 0x16, 0x2d, 0x03, 0x60, 0x84, 0xcf, 0xa6, 0x89, 0x7d, 0x2d, 0x1f,
-	0x00, 0xce, 0xcc, 0xe3, 0x1c, 0x1e, 0xf5, 0x60, 0x3d, 0x98, 0x9d, 0x7b, 0x2e, 0xbf, 0xe8, 0xda,
-	0xc2, 0xbd, 0x74, 0xc5, 0xf5, 0x20, 0x68, 0xd7, 0x15, 0xc9, 0xef, 0x53, 0x41, 0xe4, 0x21, 0x78,
-	0xde, 0x0a, 0x0d, 0x60, 0x83, 0x13, 0x11, 0x32, 0x63, 0x62, 0x39, 0xd4, 0xf7, 0x24, 0x19, 0x28,
-	0xb2, 0x47, 0xa9, 0x2f, 0x39, 0x0f, 0xc2, 0x37, 0x59, 0x1a, 0xff, 0x82, 0x66, 0x36, 0x69, 0x50,
-	0x07, 0xaa, 0x5c, 0x8d, 0x55, 0x22, 0x36, 0xf6, 0x5a, 0x29, 0xd6, 0xd0, 0x5a, 0xcf, 0x1b, 0x5f,
-	0x14, 0xa0, 0x91, 0x4a, 0x19, 0xb4, 0x99, 0xb1, 0xac, 0x47, 0x38, 0xb4, 0x05, 0xf5, 0xc0, 0x62,
-	0xc2, 0x15, 0x2e, 0xf5, 0x55, 0xce, 0x56, 0x70, 0xa2, 0x40, 0x1d, 0xb8, 0xcb, 0x48, 0xe0, 0xb9,
-	0xb6, 0x35, 0xa2, 0x98, 0x4c, 0xe9, 0x25, 0x51, 0x89, 0x59, 0xc7, 0x79, 0xb5, 0xe4, 0xf7, 0x54,
-	0x3e, 0xa9, 0xec, 0xab, 0x63, 0x2d, 0xa1, 0x1d, 0x68, 0x84, 0x23, 0x33, 0xa0, 0xf6, 0x85, 0xca,
-	0xad, 0x32, 0x4e, 0xab, 0x8c, 0xcf, 0x0b, 0xd0, 0x48, 0x65, 0xd8, 0x92, 0x91, 0x1a, 0xb0, 0x1a,
-	0x87, 0xd4, 0x75, 0x1c, 0x1d, 0x66, 0x46, 0xf7, 0x16, 0x31, 0x76, 0xa0, 0x99, 0x4d, 0xe4, 0x45,
-	0x51, 0x1a, 0x04, 0xd6, 0x32, 0x19, 0xbb, 0x70, 0x39, 0xdb, 0x00, 0x71, 0xf4, 0xbc, 0x5d, 0xdc,
-	0x29, 0x75, 0x2a, 0x38, 0xa5, 0x91, 0xcb, 0x0d, 0x53, 0xb5, 0xeb, 0x79, 0x6a, 0x35, 0x35, 0x9c,
-	0x28, 0x8c, 0x23, 0x68, 0x66, 0x13, 0x7b, 0x59, 0x3f, 0xc6, 0x67, 0x05, 0x49, 0x15, 0x50, 0x26,
-	0xe2, 0x7a, 0xb0, 0xdc, 0x17, 0x68, 0xc3, 0x8a, 0xde, 0x6d, 0xbd, 0xf9, 0x91, 0xf8, 0x16, 0xfb,
-	0xfe, 0x01, 0x34, 0xb3, 0xb5, 0x6b, 0xc9, 0xd8, 0x92, 0x08, 0x4a, 0xe9, 0x08, 0x8c, 0xbf, 0xc1,
-	0xfa, 0xdc, 0xd1, 0x56, 0x3b, 0x6f, 0x8d, 0x45, 0xcf, 0x77, 0xc8, 0x95, 0xf2, 0x52, 0xc6, 0x89,
-	0xc2, 0x70, 0x61, 0xe3, 0x86, 0x03, 0xbc, 0xf4, 0x67, 0x7e, 0x08, 0x35, 0xa6, 0x59, 0xf4, 0x57,
-	0x8e, 0x65, 0xe3, 0x31, 0xac, 0x9d, 0xcc, 0x3c, 0xcf, 0x3a, 0xf7, 0x48, 0xcf, 0x17, 0xcf, 0xfe,
-	0x8e, 0xee, 0x41, 0xe5, 0xd2, 0xf2, 0x66, 0x44, 0xf9, 0x28, 0xe1, 0x50, 0xc8, 0xc1, 0x9e, 0xee,
-	0x65, 0x61, 0x95, 0x08, 0xf6, 0x47, 0x58, 0x8d, 0x60, 0xfb, 0x94, 0x7a, 0x59, 0x54, 0x2d, 0x42,
-	0x7d, 0xba, 0x02, 0xab, 0xe1, 0xe2, 0x0e, 0xa8, 0x3f, 0x76, 0x27, 0xc8, 0x84, 0x75, 0x46, 0x04,
-	0xf1, 0x65, 0xb8, 0xc7, 0xd6, 0xd5, 0xfe, 0xb5, 0x20, 0x5c, 0x57, 0x9f, 0x54, 0x95, 0xce, 0xc4,
-	0x89, 0xe7, 0x2d, 0xd0, 0x4b, 0xb8, 0x97, 0x56, 0x1e, 0x13, 0xce, 0xad, 0x09, 0xe1, 0xba, 0x4d,
-	0x2e, 0x64, 0xba, 0xd1, 0x08, 0x75, 0x65, 0x59, 0x4a, 0xf4, 0xdd, 0x09, 0xd1, 0xfd, 0x72, 0x21,
-	0x4f, 0x1e, 0x2f, 0x29, 0x6c, 0x8f, 0x58, 0x3e, 0x61, 0x3d, 0x79, 0x51, 0xb8, 0xb4, 0x3c, 0xdd,
-	0x36, 0x17, 0x53, 0xe4, 0xf0, 0x92, 0x82, 0x93, 0xc9, 0x94, 0xf8, 0x22, 0xde, 0x97, 0xca, 0xcf,
-	0x50, 0xe4, 0xf0, 0xb2, 0xfd, 0x25, 0x2a, 0xb9, 0x8c, 0xea, 0xed, 0x04, 0x59, 0xb4, 0xdc, 0x54,
-	0x9b, 0x4e, 0x03, 0xcb, 0x96, 0x8a, 0x17, 0x94, 0xd1, 0x99, 0x70, 0x7d, 0xc2, 0xe7, 0x9b, 0x68,
-	0x26, 0x3f, 0xf0, 0x8d, 0x46, 0xe8, 0x39, 0x34, 0xb5, 0xde, 0xf4, 0x25, 0xd6, 0xd1, 0xbd, 0x74,
-	0x73, 0x9e, 0x46, 0xe6, 0x0f, 0xce, 0xa1, 0xe5, 0x5a, 0xac, 0x99, 0xa0, 0xaa, 0xfa, 0x8d, 0xdc,
-	0x29, 0xd1, 0x5d, 0x74, 0xf1, 0x5a, 0x32, 0x68, 0xf4, 0x3e, 0x3c, 0x8a, 0x15, 0x87, 0x2e, 0x57,
-	0xb8, 0xf1, 0x70, 0x76, 0xce, 0x6d, 0xe6, 0x9e, 0x13, 0xc6, 0x75, 0x1f, 0x5d, 0x14, 0xcd, 0xed,
-	0xc6, 0xe8, 0xaf, 0x50, 0x9d, 0xba, 0x7e, 0x8f, 0xb3, 0x76, 0xe3, 0xf6, 0xbd, 0xd1, 0x30, 0xf4,
-	0x1e, 0x6c, 0xd1, 0x40, 0xb8, 0x53, 0x97, 0x0b, 0xd7, 0x3e, 0xa0, 0xbe, 0x3d, 0x63, 0x8c, 0xf8,
-	0xf6, 0xf5, 0x01, 0xf5, 0x05, 0xa3, 0x5e, 0x7b, 0xf5, 0xd6, 0x68, 0x6e, 0xb5, 0x45, 0xcf, 0x00,
-	0x88, 0x6f, 0xb3, 0xeb, 0x40, 0x15, 0xab, 0xb5, 0x5b, 0x99, 0x52, 0x48, 0xe3, 0xdb, 0x02, 0x54,
-	0xc3, 0xb3, 0x89, 0x10, 0x94, 0x7d, 0x6b, 0x4a, 0x74, 0xb1, 0x51, 0x63, 0x59, 0x80, 0xf9, 0xec,
-	0xfc, 0x43, 0x62, 0x0b, 0x75, 0xaa, 0xea, 0x38, 0x12, 0xd1, 0xd3, 0x4c, 0x11, 0x2a, 0xed, 0x94,
-	0x3a, 0x8d, 0xbd, 0x8d, 0xf4, 0x15, 0x4b, 0xcf, 0x65, 0x2a, 0xd3, 0x2e, 0x54, 0x6d, 0x55, 0x02,
-	0xf4, 0xc1, 0xd8, 0xcc, 0xdf, 0x35, 0xc2, 0x02, 0x81, 0x35, 0x0a, 0xfd, 0x19, 0xd6, 0xd5, 0x95,
-	0xd6, 0xa5, 0xbe, 0xfc, 0xa0, 0x5c, 0x58, 0xd3, 0xf0, 0x2e, 0x59, 0xc2, 0xf3, 0x13, 0xc6, 0x57,
-	0x45, 0xa8, 0x9f, 0xa6, 0x7b, 0x47, 0x14, 0x7a, 0x21, 0x1b, 0x7a, 0x52, 0x57, 0x8b, 0x99, 0xba,
-	0xda, 0x84, 0xa2, 0x1b, 0x76, 0xf9, 0x0a, 0x2e, 0xba, 0x8e, 0xac, 0x66, 0x13, 0x46, 0x67, 0x81,
-	0x6e, 0x31, 0xa1, 0x20, 0x63, 0xd2, 0x4d, 0x48, 0xba, 0xf9, 0x9f, 0x65, 0x0b, 0xca, 0x54, 0x4c,
-	0x15, 0x3c, 0x3f, 0x11, 0xd6, 0x62, 0xa5, 0xe4, 0xed, 0xea, 0x4e, 0x49, 0x3e, 0x05, 0x22, 0x39,
-	0xd5, 0x41, 0x56, 0x32, 0x3d, 0xac, 0x05, 0x25, 0x97, 0xb3, 0x76, 0x4d, 0xc1, 0xe5, 0x30, 0xdf,
-	0xd5, 0xea, 0x73, 0x5d, 0x4d, 0xc6, 0x4a, 0xd4, 0x1c, 0xa8, 0xb9, 0x50, 0x90, 0x1e, 0xd4, 0xe5,
-	0xd6, 0x51, 0x29, 0x5a, 0xc3, 0x5a, 0xca, 0x74, 0x88, 0xd5, 0x5c, 0x87, 0x30, 0xe1, 0xae, 0x7c,
-	0x9f, 0xfc, 0x9f, 0xba, 0x3e, 0x26, 0x1f, 0xcf, 0x08, 0x57, 0x1b, 0xe6, 0x53, 0x87, 0xc4, 0xaf,
-	0x19, 0x2d, 0x49, 0x1a, 0x39, 0xea, 0x3a, 0x0e, 0xd3, 0x5b, 0x19, 0xcb, 0x46, 0x07, 0x5a, 0x09,
-	0x0d, 0x0f, 0xa8, 0xcf, 0x89, 0x0a, 0x92, 0x31, 0xca, 0x34, 0x4d, 0x28, 0x18, 0xcf, 0xa1, 0x75,
-	0x4c, 0x84, 0xe5, 0x58, 0xc2, 0x1a, 0xfa, 0x56, 0xc0, 0x2f, 0xa8, 0x40, 0x4f, 0x60, 0x25, 0xfc,
-	0x28, 0xb2, 0x2f, 0x94, 0x6e, 0xbc, 0x95, 0x46, 0x00, 0xc3, 0x03, 0x84, 0x93, 0x7d, 0x8f, 0x62,
-	0x56, 0x77, 0x1d, 0xa5, 0x8d, 0xc3, 0x4e, 0x14, 0x72, 0x45, 0x74, 0x3c, 0xe6, 0x24, 0x4c, 0xeb,
-	0x12, 0xd6, 0x52, 0x7e, 0xa3, 0x4b, 0xf3, 0xd7, 0x87, 0x7f, 0x43, 0xbb, 0x9f, 0x88, 0x03, 0x65,
-	0x16, 0xf9, 0xcc, 0x59, 0x17, 0xe6, 0xad, 0xff, 0x09, 0xbf, 0xbb, 0xc1, 0x5a, 0x6f, 0xcf, 0x16,
-	0xd4, 0x89, 0xef, 0x84, 0x4a, 0xdd, 0x8e, 0x13, 0x85, 0xf1, 0x65, 0x19, 0xd6, 0x4f, 0x19, 0x0d,
-	0xac, 0x89, 0x25, 0x88, 0x93, 0x2c, 0xf3, 0xd7, 0xfb, 0x84, 0x64, 0x99, 0x2b, 0xe0, 0xfc, 0x13,
-	0x32, 0x7b, 0x45, 0xc4, 0x39, 0xfc, 0x6f, 0xfa, 0x09, 0xb9, 0xe0, 0xdd, 0x57, 0x5f, 0xfa, 0xdd,
-	0xf7, 0x17, 0xa8, 0x98, 0xf2, 0xb4, 0xc9, 0x2a, 0x6f, 0x53, 0x27, 0xac, 0xf2, 0x6b, 0x58, 0x8d,
-	0x65, 0xc1, 0x99, 0xf2, 0x89, 0x3e, 0xc2, 0x72, 0x68, 0xbc, 0x06, 0x94, 0xce, 0xb5, 0x38, 0x41,
-	0x6f, 0x4b, 0xb6, 0xc7, 0xd1, 0xe9, 0x0e, 0x73, 0xec, 0x6e, 0xea, 0x4b, 0x49, 0x75, 0x74, 0xdc,
-	0xff, 0x00, 0xeb, 0xe1, 0x7f, 0x25, 0x3d, 0x7f, 0x4c, 0xa3, 0x34, 0x0e, 0x4b, 0x6f, 0x78, 0x4c,
-	0x8b, 0xae, 0x63, 0xf4, 0x01, 0xa5, 0x41, 0xda, 0x7f, 0x0e, 0x25, 0xd7, 0x72, 0x41, 0x79, 0xd4,
-	0x9a, 0xd4, 0x58, 0xea, 0x64, 0x16, 0xe9, 0x32, 0xae, 0xc6, 0xc6, 0x09, 0x6c, 0xc6, 0x7d, 0x61,
-	0x28, 0x2c, 0x31, 0xe3, 0xa9, 0xca, 0xf6, 0xcb, 0xaf, 0xfe, 0xc6, 0x31, 0x3c, 0x98, 0xe3, 0xd3,
-	0x21, 0x6e, 0x42, 0x95, 0x5c, 0xb9, 0x5c, 0x70, 0x7d, 0x05, 0xd6, 0x92, 0x2c, 0x95, 0x2e, 0x0f,
-	0x53, 0x5b, 0xf1, 0xd5, 0x70, 0x2c, 0x1b, 0xc7, 0x70, 0x3f, 0xa6, 0x3b, 0xa1, 0xc2, 0x1d, 0xeb,
-	0x52, 0xb6, 0x64, 0x74, 0x0c, 0xaa, 0x07, 0x33, 0xc6, 0x29, 0x5b, 0xf2, 0x61, 0xf3, 0x10, 0x6a,
-	0xb6, 0xb2, 0xef, 0x45, 0x4f, 0xde, 0x58, 0x4e, 0xd5, 0xcd, 0x72, 0xba, 0x6e, 0x3e, 0xf9, 0xba,
-	0x00, 0xc5, 0x41, 0x80, 0xd6, 0x61, 0xed, 0x00, 0x9b, 0xdd, 0x91, 0x79, 0x36, 0x1c, 0x61, 0xb3,
-	0x7b, 0xdc, 0xba, 0x83, 0x9a, 0x00, 0xc3, 0x23, 0xdc, 0x3b, 0x79, 0x79, 0xd6, 0x1b, 0xe2, 0x56,
-	0x41, 0x42, 0xb0, 0x79, 0x3a, 0xc0, 0xa3, 0xb3, 0xbe, 0xd9, 0x3d, 0x34, 0x71, 0xab, 0xa8, 0xac,
-	0x8e, 0xba, 0x27, 0x2f, 0xcc, 0x48, 0x55, 0x92, 0x56, 0xe6, 0xbb, 0xa7, 0xdd, 0x93, 0x43, 0x65,
-	0x55, 0x96, 0x90, 0x43, 0xb3, 0x6f, 0x26, 0xc4, 0x15, 0xd4, 0x82, 0xd5, 0xd3, 0xee, 0xab, 0x61,
-	0xac, 0xa9, 0x86, 0xd4, 0xc3, 0x57, 0xc7, 0xb1, 0x6a, 0x05, 0xdd, 0x83, 0xd6, 0xe9, 0xab, 0xfd,
-	0x7e, 0x6f, 0x78, 0x74, 0xd6, 0x3d, 0x18, 0xf5, 0xde, 0xe9, 0x8d, 0x5e, 0xb7, 0x6a, 0xe8, 0x01,
-	0x6c, 0x0c, 0xcd, 0x91, 0x46, 0x9d, 0x61, 0xb3, 0x7b, 0x38, 0x38, 0xe9, 0xbf, 0x6e, 0xd5, 0xf7,
-	0x5b, 0xdf, 0xbc, 0xd9, 0x2e, 0x7c, 0xf7, 0x66, 0xbb, 0xf0, 0xfd, 0x9b, 0xed, 0xc2, 0x27, 0x3f,
-	0x6c, 0xdf, 0x39, 0xaf, 0xaa, 0x24, 0x7e, 0xfa, 0x53, 0x00, 0x00, 0x00, 0xff, 0xff, 0x1c, 0x1b,
-	0xc0, 0x93, 0xfc, 0x13, 0x00, 0x00,
+func (m *SetStreamAliasOp) Reset()         { *m = SetStreamAliasOp{} }
+func (m *SetStreamAliasOp) String() string { return proto.CompactTextString(m) }
+func (*SetStreamAliasOp) ProtoMessage()    {}
+func (*SetStreamAliasOp) Descriptor() ([]byte, []int) {
+	return fileDescriptor_41f4a519b878ee3b, []int{33}
 }
-
-func (m *ServerState) Marshal() (dAtA []byte, err error) {
-	size := m.Size()
-	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
-	if err != nil {
-		return nil, err
+func (m *SetStreamAliasOp) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SetStreamAliasOp) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SetStreamAliasOp.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
 	}
-	return dAtA[:n], nil
+}
+func (m *SetStreamAliasOp) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetStreamAliasOp.Merge(m, src)
+}
+func (m *SetStreamAliasOp) XXX_Size() int {
+	return m.Size()
+}
+func (m *SetStreamAliasOp) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetStreamAliasOp.DiscardUnknown(m)
 }
 
-func (m *ServerState) MarshalTo(dAtA []byte) (int, error) {
-	var i int
-	_ = i
-	var l int
-	_ = l
-	if len(m.ServerID) > 0 {
-		dAtA[i] = 0xa
-		i++
-		i = encodeVarintInternal(dAtA, i, uint64(len(m.ServerID)))
-		i += copy(dAtA[i:], m.ServerID)
-	}
-	if m.XXX_unrecognized != nil {
-		i += copy(dAtA[i:], m.XXX_unrecognized)
+var xxx_messageInfo_SetStreamAliasOp proto.InternalMessageInfo
+
+func (m *SetStreamAliasOp) GetStream() string {
+	if m != nil {
+		return m.Stream
 	}
-	return i, nil
+	return ""
 }
 
-func (m *RaftLog) Marshal() (dAtA []byte, err error) {
-	size := m.Size()
-	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
-	if err != nil {
-		return nil, err
+func (m *SetStreamAliasOp) GetAlias() string {
+	if m != nil {
+		return m.Alias
 	}
-	return dAtA[:n], nil
+	return ""
+}
+
+func (m *SetStreamAliasOp) GetRemove() bool {
+	if m != nil {
+		return m.Remove
+	}
+	return false
+}
+
+type SetDerivedOffsetOp struct {
+	Stream               string   `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
+	Partition            int32    `protobuf:"varint,2,opt,name=partition,proto3" json:"partition,omitempty"`
+	Offset               int64    `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetDerivedOffsetOp) Reset()         { *m = SetDerivedOffsetOp{} }
+func (m *SetDerivedOffsetOp) String() string { return proto.CompactTextString(m) }
+func (*SetDerivedOffsetOp) ProtoMessage()    {}
+func (*SetDerivedOffsetOp) Descriptor() ([]byte, []int) {
+	return fileDescriptor_41f4a519b878ee3b, []int{34}
+}
+func (m *SetDerivedOffsetOp) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SetDerivedOffsetOp) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SetDerivedOffsetOp.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *SetDerivedOffsetOp) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetDerivedOffsetOp.Merge(m, src)
+}
+func (m *SetDerivedOffsetOp) XXX_Size() int {
+	return m.Size()
+}
+func (m *SetDerivedOffsetOp) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetDerivedOffsetOp.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetDerivedOffsetOp proto.InternalMessageInfo
+
+func (m *SetDerivedOffsetOp) GetStream() string {
+	if m != nil {
+		return m.Stream
+	}
+	return ""
+}
+
+func (m *SetDerivedOffsetOp) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *SetDerivedOffsetOp) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+type DerivedOffset struct {
+	Partition            int32    `protobuf:"varint,1,opt,name=partition,proto3" json:"partition,omitempty"`
+	Offset               int64    `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DerivedOffset) Reset()         { *m = DerivedOffset{} }
+func (m *DerivedOffset) String() string { return proto.CompactTextString(m) }
+func (*DerivedOffset) ProtoMessage()    {}
+func (*DerivedOffset) Descriptor() ([]byte, []int) {
+	return fileDescriptor_41f4a519b878ee3b, []int{35}
+}
+func (m *DerivedOffset) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *DerivedOffset) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_DerivedOffset.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *DerivedOffset) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DerivedOffset.Merge(m, src)
+}
+func (m *DerivedOffset) XXX_Size() int {
+	return m.Size()
+}
+func (m *DerivedOffset) XXX_DiscardUnknown() {
+	xxx_messageInfo_DerivedOffset.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DerivedOffset proto.InternalMessageInfo
+
+func (m *DerivedOffset) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *DerivedOffset) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+type RenameStreamOp struct {
+	Stream               string   `protobuf:"bytes,1,opt,name=stream,proto3" json:"stream,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Replace              bool     `protobuf:"varint,3,opt,name=replace,proto3" json:"replace,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenameStreamOp) Reset()         { *m = RenameStreamOp{} }
+func (m *RenameStreamOp) String() string { return proto.CompactTextString(m) }
+func (*RenameStreamOp) ProtoMessage()    {}
+func (*RenameStreamOp) Descriptor() ([]byte, []int) {
+	return fileDescriptor_41f4a519b878ee3b, []int{36}
+}
+func (m *RenameStreamOp) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *RenameStreamOp) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_RenameStreamOp.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *RenameStreamOp) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenameStreamOp.Merge(m, src)
+}
+func (m *RenameStreamOp) XXX_Size() int {
+	return m.Size()
+}
+func (m *RenameStreamOp) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenameStreamOp.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenameStreamOp proto.InternalMessageInfo
+
+func (m *RenameStreamOp) GetStream() string {
+	if m != nil {
+		return m.Stream
+	}
+	return ""
+}
+
+func (m *RenameStreamOp) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *RenameStreamOp) GetReplace() bool {
+	if m != nil {
+		return m.Replace
+	}
+	return false
+}
+
+func init() {
+	proto.RegisterEnum("protocol.Op", Op_name, Op_value)
+	proto.RegisterType((*ServerState)(nil), "protocol.ServerState")
+	proto.RegisterType((*RaftLog)(nil), "protocol.RaftLog")
+	proto.RegisterType((*CreateStreamOp)(nil), "protocol.CreateStreamOp")
+	proto.RegisterType((*ShrinkISROp)(nil), "protocol.ShrinkISROp")
+	proto.RegisterType((*ExpandISROp)(nil), "protocol.ExpandISROp")
+	proto.RegisterType((*DeleteStreamOp)(nil), "protocol.DeleteStreamOp")
+	proto.RegisterType((*PauseStreamOp)(nil), "protocol.PauseStreamOp")
+	proto.RegisterType((*ResumeStreamOp)(nil), "protocol.ResumeStreamOp")
+	proto.RegisterType((*ReportLeaderOp)(nil), "protocol.ReportLeaderOp")
+	proto.RegisterType((*ChangeLeaderOp)(nil), "protocol.ChangeLeaderOp")
+	proto.RegisterType((*PublishActivityOp)(nil), "protocol.PublishActivityOp")
+	proto.RegisterType((*SetStreamReadonlyOp)(nil), "protocol.SetStreamReadonlyOp")
+	proto.RegisterType((*NullableInt64)(nil), "protocol.NullableInt64")
+	proto.RegisterType((*NullableInt32)(nil), "protocol.NullableInt32")
+	proto.RegisterType((*NullableBool)(nil), "protocol.NullableBool")
+	proto.RegisterType((*StreamConfig)(nil), "protocol.StreamConfig")
+	proto.RegisterType((*Stream)(nil), "protocol.Stream")
+	proto.RegisterType((*Partition)(nil), "protocol.Partition")
+	proto.RegisterType((*RaftJoinRequest)(nil), "protocol.RaftJoinRequest")
+	proto.RegisterType((*RaftJoinResponse)(nil), "protocol.RaftJoinResponse")
+	proto.RegisterType((*MetadataSnapshot)(nil), "protocol.MetadataSnapshot")
+	proto.RegisterType((*ReplicationRequest)(nil), "protocol.ReplicationRequest")
+	proto.RegisterType((*LeaderEpochOffsetRequest)(nil), "protocol.LeaderEpochOffsetRequest")
+	proto.RegisterType((*LeaderEpochOffsetResponse)(nil), "protocol.LeaderEpochOffsetResponse")
+	proto.RegisterType((*PropagatedRequest)(nil), "protocol.PropagatedRequest")
+	proto.RegisterType((*Error)(nil), "protocol.Error")
+	proto.RegisterType((*PropagatedResponse)(nil), "protocol.PropagatedResponse")
+	proto.RegisterType((*ServerInfoRequest)(nil), "protocol.ServerInfoRequest")
+	proto.RegisterType((*ServerInfoResponse)(nil), "protocol.ServerInfoResponse")
+	proto.RegisterType((*PartitionStatusRequest)(nil), "protocol.PartitionStatusRequest")
+	proto.RegisterType((*PartitionStatusResponse)(nil), "protocol.PartitionStatusResponse")
+	proto.RegisterType((*PartitionNotification)(nil), "protocol.PartitionNotification")
+	proto.RegisterType((*Cursor)(nil), "protocol.Cursor")
+	proto.RegisterType((*SetStreamAliasOp)(nil), "protocol.SetStreamAliasOp")
+	proto.RegisterType((*SetDerivedOffsetOp)(nil), "protocol.SetDerivedOffsetOp")
+	proto.RegisterType((*DerivedOffset)(nil), "protocol.DerivedOffset")
+	proto.RegisterType((*RenameStreamOp)(nil), "protocol.RenameStreamOp")
+}
+
+func init() { proto.RegisterFile("internal.proto", fileDescriptor_41f4a519b878ee3b) }
+
+var fileDescriptor_41f4a519b878ee3b = []byte{
+	// 2175 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe4, 0x58, 0xcd, 0x6f, 0x23, 0xb7,
+	0x15, 0x8f, 0x24, 0x4b, 0x96, 0x9e, 0x6c, 0xad, 0x4c, 0x7b, 0xbd, 0x93, 0x8d, 0xb3, 0x30, 0xa6,
+	0x0d, 0xe0, 0x06, 0xed, 0xb6, 0xf1, 0x16, 0x29, 0x52, 0xf4, 0x4b, 0x6b, 0xcd, 0x66, 0x15, 0x7f,
+	0xc8, 0xa0, 0xbc, 0xdb, 0x6c, 0x51, 0xd4, 0xa0, 0x67, 0x28, 0x79, 0xba, 0xa3, 0xe1, 0x94, 0xa4,
+	0x5c, 0xfb, 0x96, 0x4b, 0x2f, 0xbd, 0xf6, 0x52, 0xf4, 0xd6, 0x53, 0xff, 0x90, 0x02, 0x45, 0x8f,
+	0xfd, 0x13, 0x8a, 0xed, 0xbd, 0x7f, 0x43, 0x41, 0x0e, 0xe7, 0x53, 0xb2, 0x16, 0x71, 0x72, 0x28,
+	0x90, 0xd3, 0xcc, 0x7b, 0xfc, 0xbd, 0xc7, 0xc7, 0x47, 0xbe, 0x0f, 0x12, 0x3a, 0x7e, 0x28, 0x29,
+	0x0f, 0x49, 0xf0, 0x38, 0xe2, 0x4c, 0x32, 0xd4, 0xd4, 0x1f, 0x97, 0x05, 0xf6, 0x77, 0xa0, 0x3d,
+	0xa2, 0xfc, 0x8a, 0xf2, 0x91, 0x24, 0x92, 0xa2, 0x87, 0xd0, 0x14, 0x9a, 0x1c, 0xf4, 0xad, 0xca,
+	0x6e, 0x65, 0xaf, 0x85, 0x53, 0xda, 0xfe, 0x6f, 0x03, 0x56, 0x31, 0x19, 0xcb, 0x23, 0x36, 0x41,
+	0x3b, 0x50, 0x65, 0x91, 0x46, 0x74, 0xf6, 0xd7, 0x1e, 0x27, 0xda, 0x1e, 0x0f, 0x23, 0x5c, 0x65,
+	0x11, 0xfa, 0x05, 0x74, 0x5c, 0x4e, 0x89, 0xa4, 0x23, 0xc9, 0x29, 0x99, 0x0e, 0x23, 0xab, 0xba,
+	0x5b, 0xd9, 0x6b, 0xef, 0x5b, 0x19, 0xf2, 0xa0, 0x30, 0x8e, 0x4b, 0x78, 0xf4, 0x23, 0x68, 0x8b,
+	0x4b, 0xee, 0x87, 0xaf, 0x07, 0x23, 0x3c, 0x8c, 0xac, 0x9a, 0x16, 0xbf, 0x9f, 0x89, 0x8f, 0xb2,
+	0x41, 0x9c, 0x47, 0xea, 0xa9, 0x2f, 0x49, 0x38, 0xa1, 0x47, 0x94, 0x78, 0x94, 0x0f, 0x23, 0x6b,
+	0x65, 0x6e, 0xea, 0xc2, 0x38, 0x2e, 0xe1, 0xd5, 0xd4, 0xf4, 0x3a, 0x22, 0xa1, 0x17, 0x4f, 0x5d,
+	0x2f, 0x4f, 0xed, 0x64, 0x83, 0x38, 0x8f, 0x54, 0x53, 0x7b, 0x34, 0xa0, 0xb9, 0x55, 0x37, 0xca,
+	0x53, 0xf7, 0x0b, 0xe3, 0xb8, 0x84, 0x47, 0x3f, 0x85, 0xf5, 0x88, 0xcc, 0x44, 0xa6, 0x60, 0x55,
+	0x2b, 0x78, 0x90, 0x29, 0x38, 0xcd, 0x0f, 0xe3, 0x22, 0x5a, 0x19, 0xc0, 0xa9, 0x98, 0x4d, 0x33,
+	0xf9, 0x66, 0xd9, 0x00, 0x5c, 0x18, 0xc7, 0x25, 0x3c, 0x1a, 0xc0, 0x46, 0x34, 0xbb, 0x08, 0x7c,
+	0x71, 0xd9, 0x73, 0xa5, 0x7f, 0xe5, 0xcb, 0x9b, 0x61, 0x64, 0xb5, 0xb4, 0x92, 0xf7, 0x72, 0x46,
+	0x94, 0x21, 0x78, 0x5e, 0x0a, 0x0d, 0x61, 0x53, 0x50, 0x19, 0x6b, 0xc6, 0x94, 0x78, 0x2c, 0x0c,
+	0x94, 0x32, 0xd0, 0xca, 0xde, 0xcf, 0xed, 0xe4, 0x3c, 0x08, 0x2f, 0x92, 0x44, 0xcf, 0xa0, 0x9b,
+	0xb2, 0x7b, 0x81, 0x4f, 0xc4, 0x30, 0xb2, 0xda, 0x5a, 0xdb, 0xc3, 0x05, 0xda, 0x0c, 0x02, 0xcf,
+	0xc9, 0xa0, 0x23, 0x40, 0x82, 0xca, 0x3e, 0xe5, 0xfe, 0x15, 0xf5, 0x86, 0xe3, 0xb1, 0xa0, 0x72,
+	0x18, 0x59, 0x6b, 0x5a, 0xd3, 0x4e, 0x41, 0x53, 0x09, 0x83, 0x17, 0xc8, 0x21, 0x0b, 0x56, 0xaf,
+	0x28, 0x17, 0x3e, 0x0b, 0xad, 0xf5, 0xdd, 0xca, 0xde, 0x3a, 0x4e, 0xc8, 0x78, 0x37, 0x42, 0x92,
+	0xdb, 0x8d, 0xce, 0xfc, 0x6e, 0x84, 0xa4, 0xb8, 0x1b, 0x79, 0xda, 0xfe, 0x31, 0x74, 0x8a, 0x61,
+	0x82, 0xf6, 0xa0, 0x21, 0xf4, 0xbf, 0x0e, 0xbd, 0xf6, 0x7e, 0x37, 0x67, 0x6f, 0xec, 0x2f, 0x33,
+	0x6e, 0xff, 0xad, 0x02, 0xed, 0x5c, 0x90, 0xa0, 0xed, 0x82, 0x64, 0x2b, 0xc1, 0xa1, 0x1d, 0x68,
+	0x45, 0x84, 0x4b, 0x5f, 0xaa, 0x15, 0xa8, 0x28, 0xad, 0xe3, 0x8c, 0x81, 0xf6, 0xe0, 0x1e, 0xa7,
+	0x51, 0xe0, 0xbb, 0xe4, 0x8c, 0x61, 0x3a, 0x65, 0x57, 0x54, 0x87, 0x62, 0x0b, 0x97, 0xd9, 0x4a,
+	0x7f, 0xa0, 0x23, 0x48, 0xc7, 0x5b, 0x0b, 0x1b, 0x0a, 0xed, 0x42, 0x3b, 0xfe, 0x73, 0x22, 0xe6,
+	0x5e, 0xea, 0x68, 0x5a, 0xc1, 0x79, 0x96, 0xfd, 0xd7, 0x0a, 0xb4, 0x73, 0x31, 0x75, 0x47, 0x4b,
+	0x6d, 0x58, 0x4b, 0x4d, 0xea, 0x79, 0x9e, 0x31, 0xb3, 0xc0, 0xfb, 0x0a, 0x36, 0xee, 0x41, 0xa7,
+	0x18, 0xba, 0xb7, 0x59, 0x69, 0x53, 0x58, 0x2f, 0xc4, 0xe8, 0xad, 0xcb, 0x79, 0x04, 0x90, 0x5a,
+	0x2f, 0xac, 0xea, 0x6e, 0x6d, 0xaf, 0x8e, 0x73, 0x1c, 0xb5, 0xdc, 0x38, 0x38, 0x7b, 0x41, 0xa0,
+	0x57, 0xd3, 0xc4, 0x19, 0xc3, 0x7e, 0x0e, 0x9d, 0x62, 0x28, 0xdf, 0x75, 0x1e, 0xfb, 0x2f, 0x15,
+	0xa5, 0x2a, 0x62, 0x5c, 0xa6, 0x19, 0xf0, 0x6e, 0x3b, 0x60, 0xc1, 0xaa, 0xf1, 0xb6, 0x71, 0x7e,
+	0x42, 0x7e, 0x05, 0xbf, 0xff, 0x06, 0x3a, 0xc5, 0x6c, 0x7d, 0x47, 0xdb, 0x32, 0x0b, 0x6a, 0x79,
+	0x0b, 0xec, 0x8f, 0x60, 0x63, 0x2e, 0x99, 0x69, 0xcf, 0x93, 0xb1, 0x1c, 0x84, 0x1e, 0xbd, 0xd6,
+	0xb3, 0xac, 0xe0, 0x8c, 0x61, 0xfb, 0xb0, 0xb9, 0x20, 0x65, 0xdd, 0x79, 0x9b, 0x1f, 0x42, 0x93,
+	0x1b, 0x2d, 0x66, 0x97, 0x53, 0xda, 0xfe, 0x00, 0xd6, 0x4f, 0x66, 0x41, 0x40, 0x2e, 0x02, 0x3a,
+	0x08, 0xe5, 0xc7, 0x3f, 0x44, 0x5b, 0x50, 0xbf, 0x22, 0xc1, 0x8c, 0xea, 0x39, 0x6a, 0x38, 0x26,
+	0x4a, 0xb0, 0x27, 0xfb, 0x45, 0x58, 0x3d, 0x81, 0x7d, 0x1b, 0xd6, 0x12, 0xd8, 0x53, 0xc6, 0x82,
+	0x22, 0xaa, 0x99, 0xa0, 0xbe, 0x58, 0x87, 0xb5, 0x78, 0x71, 0x07, 0x2c, 0x1c, 0xfb, 0x13, 0xe4,
+	0xc0, 0x06, 0xa7, 0x92, 0x86, 0xca, 0xdc, 0x63, 0x72, 0xfd, 0xf4, 0x46, 0x52, 0x61, 0x55, 0xca,
+	0x75, 0xa9, 0x60, 0x27, 0x9e, 0x97, 0x40, 0x87, 0xb0, 0x95, 0x67, 0x1e, 0x53, 0x21, 0xc8, 0x84,
+	0x0a, 0xab, 0xba, 0x5c, 0xd3, 0x42, 0x21, 0xd4, 0x83, 0x7b, 0x79, 0x7e, 0x6f, 0x42, 0xad, 0xda,
+	0x72, 0x3d, 0x65, 0xbc, 0x52, 0xe1, 0x06, 0x94, 0x84, 0x94, 0x0f, 0x42, 0x49, 0xf9, 0x15, 0x09,
+	0xac, 0x95, 0xb7, 0xa8, 0x28, 0xe1, 0x95, 0x0a, 0x41, 0x27, 0x53, 0x1a, 0xca, 0xd4, 0x2f, 0xf5,
+	0xb7, 0xa8, 0x28, 0xe1, 0x55, 0xc1, 0xcf, 0x58, 0x6a, 0x19, 0x8d, 0xe5, 0x0a, 0x8a, 0x68, 0xe5,
+	0x54, 0x97, 0x4d, 0x23, 0xe2, 0x2a, 0xc6, 0xa7, 0x8c, 0xb3, 0x99, 0xf4, 0x43, 0x2a, 0xac, 0xd5,
+	0x25, 0x5a, 0x9e, 0xec, 0xe3, 0x85, 0x42, 0xe8, 0x67, 0xd0, 0x31, 0x7c, 0x27, 0x54, 0x58, 0xcf,
+	0x74, 0x0f, 0xdb, 0xf3, 0x6a, 0xd4, 0xf9, 0xc1, 0x25, 0xb4, 0x5a, 0x0b, 0x99, 0x49, 0xa6, 0xb3,
+	0xdf, 0x99, 0x3f, 0xa5, 0x56, 0x6b, 0x89, 0x15, 0x6a, 0x2d, 0x05, 0x34, 0xfa, 0x35, 0xbc, 0x9f,
+	0x32, 0xfa, 0xbe, 0xd0, 0xb8, 0xf1, 0x68, 0x76, 0x21, 0x5c, 0xee, 0x5f, 0x50, 0x2e, 0x2c, 0x58,
+	0x6a, 0xcd, 0x72, 0x61, 0xf4, 0x7d, 0x68, 0x4c, 0xfd, 0x70, 0x20, 0xb8, 0xd5, 0x5e, 0x62, 0xd5,
+	0x93, 0x7d, 0x6c, 0x60, 0xe8, 0x57, 0xb0, 0xc3, 0x22, 0xe9, 0x4f, 0x7d, 0x21, 0x7d, 0xf7, 0x80,
+	0x85, 0xee, 0x8c, 0x73, 0x1a, 0xba, 0x37, 0x07, 0x2c, 0x94, 0x9c, 0x05, 0xd6, 0xda, 0x52, 0x6b,
+	0x96, 0xca, 0xa2, 0x8f, 0x01, 0x68, 0xe8, 0xf2, 0x9b, 0x48, 0x26, 0x6d, 0xc3, 0xed, 0x9a, 0x72,
+	0x48, 0x34, 0x80, 0x4d, 0xe3, 0xf3, 0x43, 0x4a, 0xa3, 0x97, 0x71, 0x9f, 0x21, 0xac, 0xce, 0xf2,
+	0x15, 0x2d, 0x92, 0xd1, 0x7d, 0x3e, 0x99, 0x46, 0x01, 0x1d, 0x8e, 0xad, 0x7b, 0xa6, 0xcf, 0x37,
+	0xb4, 0x4a, 0x59, 0xf1, 0x3f, 0x26, 0x92, 0x5a, 0xdd, 0xdd, 0xca, 0x5e, 0x05, 0xe7, 0x38, 0x6a,
+	0xdc, 0xd3, 0x5d, 0xd0, 0x33, 0xce, 0xa6, 0xd6, 0x86, 0x96, 0xce, 0x71, 0x54, 0xd3, 0x10, 0x53,
+	0x87, 0xf4, 0xe6, 0x79, 0x9c, 0x75, 0x51, 0xdc, 0x34, 0x94, 0xd8, 0x7a, 0xa6, 0x90, 0x44, 0xe2,
+	0x92, 0xc9, 0xe1, 0xd8, 0xda, 0x8c, 0x35, 0x65, 0x1c, 0x55, 0xd4, 0xd3, 0x54, 0x79, 0x48, 0x6f,
+	0xac, 0xad, 0xb8, 0xa8, 0xe7, 0x79, 0xe8, 0x00, 0xba, 0xf9, 0xd8, 0x3e, 0xa4, 0x37, 0xc2, 0xba,
+	0xbf, 0xfc, 0xe4, 0xcd, 0x09, 0xa8, 0xb3, 0x3b, 0x0e, 0x66, 0xe2, 0x32, 0x4d, 0x4b, 0xdb, 0x6f,
+	0x39, 0xbb, 0x05, 0x34, 0xfa, 0x08, 0x56, 0x63, 0x86, 0xb0, 0x1e, 0x2c, 0x17, 0x4c, 0x70, 0xe8,
+	0x33, 0xd8, 0x92, 0x3e, 0xe5, 0xd4, 0x1b, 0x49, 0xc6, 0xc9, 0x84, 0x26, 0x31, 0x67, 0x2d, 0x3d,
+	0x0d, 0x0b, 0x65, 0xd0, 0x0f, 0x60, 0xb3, 0xc0, 0x7f, 0x3a, 0x73, 0x5f, 0x53, 0x69, 0xbd, 0xab,
+	0xbd, 0xb5, 0x68, 0x68, 0x4e, 0xe2, 0x94, 0xd3, 0xb1, 0x7f, 0x6d, 0x3d, 0x5c, 0x20, 0x11, 0x0f,
+	0xa1, 0xc7, 0x80, 0x4c, 0xee, 0x39, 0x60, 0xd3, 0x88, 0x53, 0xa1, 0x5b, 0xde, 0xf7, 0xb4, 0xc0,
+	0x82, 0x11, 0xf4, 0x09, 0xb4, 0x3d, 0xea, 0xcd, 0xa2, 0x5f, 0xfa, 0xa1, 0xc7, 0x7e, 0x6f, 0xed,
+	0x2c, 0x77, 0x4b, 0x1e, 0x6b, 0xff, 0xa3, 0x0a, 0x8d, 0xb8, 0x04, 0x21, 0x04, 0x2b, 0xaa, 0x23,
+	0x36, 0x35, 0x55, 0xff, 0xab, 0x3e, 0x43, 0xcc, 0x2e, 0x7e, 0x4b, 0x5d, 0xa9, 0x8b, 0x47, 0x0b,
+	0x27, 0x24, 0x7a, 0x52, 0xa8, 0xb5, 0xb5, 0xdd, 0xda, 0x5e, 0x7b, 0x7f, 0x33, 0x7f, 0x77, 0x32,
+	0x63, 0x85, 0x02, 0xfc, 0x18, 0x1a, 0xae, 0xae, 0x74, 0xd6, 0x4a, 0xd9, 0xf5, 0xf9, 0x3a, 0x88,
+	0x0d, 0x0a, 0x7d, 0x17, 0x36, 0xf4, 0x5d, 0xd5, 0x67, 0xa1, 0xca, 0x5b, 0x42, 0x92, 0x69, 0x7c,
+	0x49, 0xac, 0xe1, 0xf9, 0x01, 0x65, 0x2c, 0x51, 0xf7, 0x0e, 0x2a, 0xac, 0xc6, 0x6e, 0x4d, 0x19,
+	0x6b, 0x48, 0xf4, 0x73, 0xe8, 0xc4, 0xe1, 0x60, 0xee, 0x12, 0x2a, 0x6b, 0xd7, 0x8a, 0x3e, 0x2a,
+	0xdc, 0x35, 0x70, 0x09, 0xae, 0xba, 0x27, 0xcf, 0x17, 0x51, 0x40, 0x6e, 0x4e, 0x94, 0x8b, 0x9a,
+	0xda, 0x17, 0x79, 0x96, 0xfd, 0xf7, 0x2a, 0xb4, 0x4e, 0xf3, 0xfd, 0x59, 0xe2, 0xb7, 0x4a, 0xd1,
+	0x6f, 0x59, 0xef, 0x52, 0x2d, 0xf4, 0x2e, 0x1d, 0xa8, 0xfa, 0x71, 0x27, 0x5d, 0xc7, 0x55, 0xdf,
+	0x53, 0x1d, 0xc3, 0x84, 0xb3, 0x59, 0x64, 0xda, 0xb8, 0x98, 0x50, 0x0e, 0x31, 0x8d, 0x9e, 0x9a,
+	0xe6, 0x19, 0x71, 0x25, 0xe3, 0xda, 0x21, 0x75, 0x3c, 0x3f, 0x10, 0xf7, 0x3b, 0x9a, 0x99, 0x78,
+	0x24, 0xa5, 0x73, 0x5d, 0xda, 0x6a, 0xa1, 0x4f, 0xec, 0x42, 0xcd, 0x17, 0xdc, 0x6a, 0x6a, 0xb8,
+	0xfa, 0x2d, 0x77, 0x8e, 0xad, 0xb9, 0xce, 0x51, 0xd9, 0x4a, 0xf5, 0x18, 0xe8, 0xb1, 0x98, 0x50,
+	0x33, 0xe8, 0x2b, 0xb3, 0xa7, 0xcb, 0x40, 0x13, 0x1b, 0xaa, 0xd0, 0x85, 0xad, 0x95, 0xba, 0x30,
+	0x07, 0xee, 0xa9, 0x57, 0x8f, 0xcf, 0x98, 0x1f, 0x62, 0xfa, 0xbb, 0x19, 0x15, 0xda, 0x61, 0x21,
+	0xf3, 0x68, 0xfa, 0x46, 0x62, 0x28, 0xa5, 0x46, 0xfd, 0xf5, 0x3c, 0x8f, 0x1b, 0x57, 0xa6, 0xb4,
+	0xbd, 0x07, 0xdd, 0x4c, 0x8d, 0x88, 0x58, 0x28, 0xa8, 0x36, 0x92, 0x73, 0xc6, 0x8d, 0x9a, 0x98,
+	0xb0, 0x3f, 0x87, 0xee, 0x31, 0x95, 0xc4, 0x23, 0x92, 0x8c, 0x4c, 0x2e, 0x44, 0x1f, 0xc2, 0x6a,
+	0xbc, 0x29, 0xaa, 0xf7, 0xaa, 0x2d, 0xbc, 0xf9, 0x25, 0x80, 0xfc, 0x95, 0xb4, 0x5a, 0xb8, 0x92,
+	0xda, 0x7f, 0xac, 0x00, 0xc2, 0xd9, 0x96, 0x24, 0xcb, 0xd1, 0x57, 0x0d, 0xcd, 0x4d, 0x57, 0x94,
+	0x31, 0xd4, 0x62, 0x99, 0x3e, 0x72, 0x5a, 0x5b, 0x0d, 0x1b, 0xaa, 0xbc, 0x07, 0xb5, 0xf9, 0x3d,
+	0x50, 0x3d, 0xb9, 0x1f, 0xd1, 0xc0, 0x0f, 0xa9, 0xa7, 0xcf, 0x4c, 0x13, 0x67, 0x0c, 0xfb, 0x27,
+	0x60, 0x1d, 0x65, 0x60, 0x73, 0xc8, 0x8d, 0x45, 0x25, 0xdd, 0x95, 0xf9, 0x9b, 0xc1, 0x27, 0xf0,
+	0xee, 0x02, 0x69, 0xe3, 0xd7, 0x1d, 0x68, 0xd1, 0xd0, 0x04, 0x8a, 0xe9, 0x95, 0x33, 0x86, 0xfd,
+	0xa7, 0x06, 0x6c, 0x9c, 0x72, 0x16, 0x91, 0x09, 0x91, 0xd4, 0xcb, 0x9c, 0xf0, 0xff, 0xfb, 0xa2,
+	0xc5, 0x0b, 0xf7, 0xb3, 0xf9, 0x17, 0xad, 0xe2, 0xfd, 0x0d, 0x97, 0xf0, 0xdf, 0xe8, 0x17, 0xad,
+	0x5b, 0x9e, 0xa1, 0x5a, 0x5f, 0xeb, 0x33, 0x14, 0x7c, 0x6d, 0xcf, 0x50, 0xed, 0x3b, 0x3e, 0x43,
+	0xcd, 0x3f, 0x36, 0xad, 0x7d, 0xc9, 0xc7, 0xa6, 0xef, 0x41, 0xdd, 0xe1, 0x9c, 0x71, 0x55, 0x73,
+	0x5d, 0xe6, 0xc5, 0x35, 0x77, 0x1d, 0xeb, 0x7f, 0x95, 0x81, 0xa7, 0x62, 0x62, 0x72, 0x9a, 0xfa,
+	0xb5, 0x5f, 0x01, 0xca, 0xc7, 0x50, 0x1a, 0x78, 0xcb, 0x82, 0xe8, 0x83, 0x24, 0xdd, 0xc5, 0xb1,
+	0x73, 0x2f, 0x77, 0x02, 0x15, 0x3b, 0xc9, 0x7f, 0xdf, 0x82, 0x8d, 0xf8, 0x49, 0x7a, 0x10, 0x8e,
+	0x59, 0x12, 0x9e, 0x71, 0x2d, 0x8a, 0x93, 0x53, 0xd5, 0xf7, 0xec, 0x2f, 0xaa, 0x80, 0xf2, 0x28,
+	0x63, 0x40, 0x09, 0xa6, 0x16, 0x73, 0xc9, 0x44, 0xd2, 0x29, 0xe8, 0x7f, 0xc5, 0x53, 0xe1, 0x61,
+	0x0a, 0x9b, 0xfe, 0xcf, 0xe7, 0xcc, 0xb8, 0xb8, 0x25, 0xa4, 0x42, 0x73, 0xe2, 0xbe, 0xd6, 0x51,
+	0xd3, 0xc2, 0xfa, 0x5f, 0xa1, 0xd5, 0xab, 0xb8, 0x1f, 0x4e, 0x74, 0x40, 0x34, 0x71, 0x42, 0xaa,
+	0x8e, 0x95, 0x78, 0x53, 0x3f, 0x54, 0x29, 0x9f, 0x0a, 0x61, 0x0a, 0x59, 0x81, 0xa7, 0xb2, 0x53,
+	0xe0, 0x0b, 0x49, 0x43, 0x75, 0xab, 0x89, 0x8b, 0x5a, 0xc6, 0x50, 0xdd, 0xf3, 0xd4, 0x64, 0x7f,
+	0xd3, 0xad, 0xeb, 0xc3, 0xba, 0x8e, 0xcb, 0x6c, 0xfb, 0x04, 0xb6, 0xd3, 0xea, 0x3e, 0x92, 0x44,
+	0xce, 0x44, 0xae, 0x3e, 0x7d, 0xf9, 0x47, 0x12, 0xfb, 0x18, 0x1e, 0xcc, 0xe9, 0x33, 0x6e, 0xdd,
+	0x86, 0x06, 0xbd, 0xf6, 0x85, 0x14, 0xe6, 0xb1, 0xc0, 0x50, 0xaa, 0xe0, 0xf9, 0x22, 0xce, 0x33,
+	0x5a, 0x5f, 0x13, 0xa7, 0xb4, 0x7d, 0x0c, 0xf7, 0x53, 0x75, 0x27, 0x4c, 0xfa, 0x63, 0x53, 0x75,
+	0xee, 0x68, 0x1d, 0x87, 0xc6, 0xc1, 0x8c, 0x0b, 0xc6, 0xef, 0x26, 0xaf, 0x4c, 0x75, 0xb5, 0xfc,
+	0x20, 0x79, 0x1c, 0x4c, 0xe9, 0x5c, 0x89, 0x5b, 0xc9, 0x97, 0x38, 0x55, 0x89, 0xcb, 0x91, 0x7c,
+	0xeb, 0xec, 0x5b, 0x50, 0xd7, 0xad, 0x9d, 0x39, 0x6a, 0x31, 0xa1, 0xd0, 0x3c, 0x7b, 0x37, 0x6d,
+	0x62, 0x43, 0xd9, 0x17, 0xea, 0xf4, 0xce, 0x45, 0xf1, 0x9d, 0x1f, 0xb7, 0x8c, 0xf5, 0xb5, 0x82,
+	0xf5, 0x0e, 0xac, 0x17, 0x26, 0x28, 0xaa, 0xa9, 0xdc, 0xae, 0xa6, 0x50, 0xe7, 0xed, 0x97, 0xea,
+	0x7d, 0x30, 0x9f, 0x2a, 0x6e, 0x35, 0x33, 0xe9, 0xd6, 0xab, 0xc5, 0x6e, 0x5d, 0xb5, 0x12, 0xc4,
+	0x4d, 0x3c, 0x90, 0x90, 0x1f, 0xfe, 0xa1, 0x0a, 0xd5, 0x61, 0x84, 0x36, 0x60, 0xfd, 0x00, 0x3b,
+	0xbd, 0x33, 0xe7, 0x7c, 0x74, 0x86, 0x9d, 0xde, 0x71, 0xf7, 0x1d, 0xd4, 0x01, 0x18, 0x3d, 0xc7,
+	0x83, 0x93, 0xc3, 0xf3, 0xc1, 0x08, 0x77, 0x2b, 0x0a, 0x82, 0x9d, 0xd3, 0x21, 0x3e, 0x3b, 0x3f,
+	0x72, 0x7a, 0x7d, 0x07, 0x77, 0xab, 0x5a, 0xea, 0x79, 0xef, 0xe4, 0x53, 0x27, 0x61, 0xd5, 0x94,
+	0x94, 0xf3, 0xf9, 0x69, 0xef, 0xa4, 0xaf, 0xa5, 0x56, 0x14, 0xa4, 0xef, 0x1c, 0x39, 0x99, 0xe2,
+	0x3a, 0xea, 0xc2, 0xda, 0x69, 0xef, 0xc5, 0x28, 0xe5, 0x34, 0x62, 0xd5, 0xa3, 0x17, 0xc7, 0x29,
+	0x6b, 0x15, 0x6d, 0x41, 0xf7, 0xf4, 0xc5, 0xd3, 0xa3, 0xc1, 0xe8, 0xf9, 0x79, 0xef, 0xe0, 0x6c,
+	0xf0, 0x72, 0x70, 0xf6, 0xaa, 0xdb, 0x44, 0x0f, 0x60, 0x73, 0xe4, 0x9c, 0x19, 0xd4, 0x39, 0x76,
+	0x7a, 0xfd, 0xe1, 0xc9, 0xd1, 0xab, 0x6e, 0x4b, 0xc1, 0x73, 0x03, 0xbd, 0xa3, 0x41, 0x6f, 0xd4,
+	0x05, 0xb4, 0x0d, 0x48, 0x71, 0xfb, 0x0e, 0x1e, 0xbc, 0x74, 0xfa, 0xe7, 0xc3, 0x67, 0xcf, 0x46,
+	0xce, 0x59, 0xb7, 0x1d, 0xcf, 0x77, 0xd2, 0xcb, 0xe6, 0x5b, 0x7b, 0xda, 0xfd, 0xe7, 0x9b, 0x47,
+	0x95, 0x7f, 0xbd, 0x79, 0x54, 0xf9, 0xf7, 0x9b, 0x47, 0x95, 0x3f, 0xff, 0xe7, 0xd1, 0x3b, 0x17,
+	0x0d, 0x9d, 0x17, 0x9f, 0xfc, 0x6f, 0x00, 0x8a, 0xe1, 0x59, 0x3b, 0xb6, 0x1b, 0x00, 0x00,
+}
+
+func (m *ServerState) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ServerState) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.ServerID) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.ServerID)))
+		i += copy(dAtA[i:], m.ServerID)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *RaftLog) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
 }
 
 func (m *RaftLog) MarshalTo(dAtA []byte) (int, error) {
@@ -2513,6 +3070,41 @@ func (m *RaftLog) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i += n9
 	}
+	if m.SetStreamAliasOp != nil {
+		dAtA[i] = 0x5a
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.SetStreamAliasOp.Size()))
+		n10, err10 := m.SetStreamAliasOp.MarshalTo(dAtA[i:])
+		if err10 != nil {
+			return 0, err10
+		}
+		i += n10
+	}
+	if m.SetDerivedOffsetOp != nil {
+		dAtA[i] = 0x62
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.SetDerivedOffsetOp.Size()))
+		n11, err11 := m.SetDerivedOffsetOp.MarshalTo(dAtA[i:])
+		if err11 != nil {
+			return 0, err11
+		}
+		i += n11
+	}
+	if m.Version != 0 {
+		dAtA[i] = 0x68
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Version))
+	}
+	if m.RenameStreamOp != nil {
+		dAtA[i] = 0x72
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.RenameStreamOp.Size()))
+		n45, err45 := m.RenameStreamOp.MarshalTo(dAtA[i:])
+		if err45 != nil {
+			return 0, err45
+		}
+		i += n45
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -2538,11 +3130,11 @@ func (m *CreateStreamOp) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0xa
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.Stream.Size()))
-		n10, err10 := m.Stream.MarshalTo(dAtA[i:])
-		if err10 != nil {
-			return 0, err10
+		n12, err12 := m.Stream.MarshalTo(dAtA[i:])
+		if err12 != nil {
+			return 0, err12
 		}
-		i += n10
+		i += n12
 	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
@@ -2697,22 +3289,22 @@ func (m *PauseStreamOp) MarshalTo(dAtA []byte) (int, error) {
 		i += copy(dAtA[i:], m.Stream)
 	}
 	if len(m.Partitions) > 0 {
-		dAtA12 := make([]byte, len(m.Partitions)*10)
-		var j11 int
+		dAtA14 := make([]byte, len(m.Partitions)*10)
+		var j13 int
 		for _, num1 := range m.Partitions {
 			num := uint64(num1)
 			for num >= 1<<7 {
-				dAtA12[j11] = uint8(uint64(num)&0x7f | 0x80)
+				dAtA14[j13] = uint8(uint64(num)&0x7f | 0x80)
 				num >>= 7
-				j11++
+				j13++
 			}
-			dAtA12[j11] = uint8(num)
-			j11++
+			dAtA14[j13] = uint8(num)
+			j13++
 		}
 		dAtA[i] = 0x12
 		i++
-		i = encodeVarintInternal(dAtA, i, uint64(j11))
-		i += copy(dAtA[i:], dAtA12[:j11])
+		i = encodeVarintInternal(dAtA, i, uint64(j13))
+		i += copy(dAtA[i:], dAtA14[:j13])
 	}
 	if m.ResumeAll {
 		dAtA[i] = 0x18
@@ -2752,22 +3344,22 @@ func (m *ResumeStreamOp) MarshalTo(dAtA []byte) (int, error) {
 		i += copy(dAtA[i:], m.Stream)
 	}
 	if len(m.Partitions) > 0 {
-		dAtA14 := make([]byte, len(m.Partitions)*10)
-		var j13 int
+		dAtA16 := make([]byte, len(m.Partitions)*10)
+		var j15 int
 		for _, num1 := range m.Partitions {
 			num := uint64(num1)
 			for num >= 1<<7 {
-				dAtA14[j13] = uint8(uint64(num)&0x7f | 0x80)
+				dAtA16[j15] = uint8(uint64(num)&0x7f | 0x80)
 				num >>= 7
-				j13++
+				j15++
 			}
-			dAtA14[j13] = uint8(num)
-			j13++
+			dAtA16[j15] = uint8(num)
+			j15++
 		}
 		dAtA[i] = 0x12
 		i++
-		i = encodeVarintInternal(dAtA, i, uint64(j13))
-		i += copy(dAtA[i:], dAtA14[:j13])
+		i = encodeVarintInternal(dAtA, i, uint64(j15))
+		i += copy(dAtA[i:], dAtA16[:j15])
 	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
@@ -2910,22 +3502,22 @@ func (m *SetStreamReadonlyOp) MarshalTo(dAtA []byte) (int, error) {
 		i += copy(dAtA[i:], m.Stream)
 	}
 	if len(m.Partitions) > 0 {
-		dAtA16 := make([]byte, len(m.Partitions)*10)
-		var j15 int
+		dAtA18 := make([]byte, len(m.Partitions)*10)
+		var j17 int
 		for _, num1 := range m.Partitions {
 			num := uint64(num1)
 			for num >= 1<<7 {
-				dAtA16[j15] = uint8(uint64(num)&0x7f | 0x80)
+				dAtA18[j17] = uint8(uint64(num)&0x7f | 0x80)
 				num >>= 7
-				j15++
+				j17++
 			}
-			dAtA16[j15] = uint8(num)
-			j15++
+			dAtA18[j17] = uint8(num)
+			j17++
 		}
 		dAtA[i] = 0x12
 		i++
-		i = encodeVarintInternal(dAtA, i, uint64(j15))
-		i += copy(dAtA[i:], dAtA16[:j15])
+		i = encodeVarintInternal(dAtA, i, uint64(j17))
+		i += copy(dAtA[i:], dAtA18[:j17])
 	}
 	if m.Readonly {
 		dAtA[i] = 0x18
@@ -3045,131 +3637,271 @@ func (m *StreamConfig) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0xa
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.RetentionMaxBytes.Size()))
-		n17, err17 := m.RetentionMaxBytes.MarshalTo(dAtA[i:])
-		if err17 != nil {
-			return 0, err17
+		n19, err19 := m.RetentionMaxBytes.MarshalTo(dAtA[i:])
+		if err19 != nil {
+			return 0, err19
 		}
-		i += n17
+		i += n19
 	}
 	if m.RetentionMaxMessages != nil {
 		dAtA[i] = 0x12
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.RetentionMaxMessages.Size()))
-		n18, err18 := m.RetentionMaxMessages.MarshalTo(dAtA[i:])
-		if err18 != nil {
-			return 0, err18
+		n20, err20 := m.RetentionMaxMessages.MarshalTo(dAtA[i:])
+		if err20 != nil {
+			return 0, err20
 		}
-		i += n18
+		i += n20
 	}
 	if m.RetentionMaxAge != nil {
 		dAtA[i] = 0x1a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.RetentionMaxAge.Size()))
-		n19, err19 := m.RetentionMaxAge.MarshalTo(dAtA[i:])
-		if err19 != nil {
-			return 0, err19
+		n21, err21 := m.RetentionMaxAge.MarshalTo(dAtA[i:])
+		if err21 != nil {
+			return 0, err21
 		}
-		i += n19
+		i += n21
 	}
 	if m.CleanerInterval != nil {
 		dAtA[i] = 0x22
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.CleanerInterval.Size()))
-		n20, err20 := m.CleanerInterval.MarshalTo(dAtA[i:])
-		if err20 != nil {
-			return 0, err20
+		n22, err22 := m.CleanerInterval.MarshalTo(dAtA[i:])
+		if err22 != nil {
+			return 0, err22
 		}
-		i += n20
+		i += n22
 	}
 	if m.SegmentMaxBytes != nil {
 		dAtA[i] = 0x2a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.SegmentMaxBytes.Size()))
-		n21, err21 := m.SegmentMaxBytes.MarshalTo(dAtA[i:])
-		if err21 != nil {
-			return 0, err21
+		n23, err23 := m.SegmentMaxBytes.MarshalTo(dAtA[i:])
+		if err23 != nil {
+			return 0, err23
 		}
-		i += n21
+		i += n23
 	}
 	if m.SegmentMaxAge != nil {
 		dAtA[i] = 0x32
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.SegmentMaxAge.Size()))
-		n22, err22 := m.SegmentMaxAge.MarshalTo(dAtA[i:])
-		if err22 != nil {
-			return 0, err22
+		n24, err24 := m.SegmentMaxAge.MarshalTo(dAtA[i:])
+		if err24 != nil {
+			return 0, err24
 		}
-		i += n22
+		i += n24
 	}
 	if m.CompactMaxGoroutines != nil {
 		dAtA[i] = 0x3a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.CompactMaxGoroutines.Size()))
-		n23, err23 := m.CompactMaxGoroutines.MarshalTo(dAtA[i:])
-		if err23 != nil {
-			return 0, err23
+		n25, err25 := m.CompactMaxGoroutines.MarshalTo(dAtA[i:])
+		if err25 != nil {
+			return 0, err25
 		}
-		i += n23
+		i += n25
 	}
 	if m.CompactEnabled != nil {
 		dAtA[i] = 0x42
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.CompactEnabled.Size()))
-		n24, err24 := m.CompactEnabled.MarshalTo(dAtA[i:])
-		if err24 != nil {
-			return 0, err24
+		n26, err26 := m.CompactEnabled.MarshalTo(dAtA[i:])
+		if err26 != nil {
+			return 0, err26
 		}
-		i += n24
+		i += n26
 	}
 	if m.AutoPauseTime != nil {
 		dAtA[i] = 0x4a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.AutoPauseTime.Size()))
-		n25, err25 := m.AutoPauseTime.MarshalTo(dAtA[i:])
-		if err25 != nil {
-			return 0, err25
+		n27, err27 := m.AutoPauseTime.MarshalTo(dAtA[i:])
+		if err27 != nil {
+			return 0, err27
 		}
-		i += n25
+		i += n27
 	}
 	if m.AutoPauseDisableIfSubscribers != nil {
 		dAtA[i] = 0x52
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.AutoPauseDisableIfSubscribers.Size()))
-		n26, err26 := m.AutoPauseDisableIfSubscribers.MarshalTo(dAtA[i:])
-		if err26 != nil {
-			return 0, err26
+		n28, err28 := m.AutoPauseDisableIfSubscribers.MarshalTo(dAtA[i:])
+		if err28 != nil {
+			return 0, err28
 		}
-		i += n26
+		i += n28
 	}
 	if m.MinIsr != nil {
 		dAtA[i] = 0x5a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.MinIsr.Size()))
-		n27, err27 := m.MinIsr.MarshalTo(dAtA[i:])
-		if err27 != nil {
-			return 0, err27
+		n29, err29 := m.MinIsr.MarshalTo(dAtA[i:])
+		if err29 != nil {
+			return 0, err29
 		}
-		i += n27
+		i += n29
 	}
 	if m.OptimisticConcurrencyControl != nil {
 		dAtA[i] = 0x62
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.OptimisticConcurrencyControl.Size()))
-		n28, err28 := m.OptimisticConcurrencyControl.MarshalTo(dAtA[i:])
-		if err28 != nil {
-			return 0, err28
+		n30, err30 := m.OptimisticConcurrencyControl.MarshalTo(dAtA[i:])
+		if err30 != nil {
+			return 0, err30
 		}
-		i += n28
+		i += n30
 	}
 	if m.Encryption != nil {
 		dAtA[i] = 0x6a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.Encryption.Size()))
-		n29, err29 := m.Encryption.MarshalTo(dAtA[i:])
-		if err29 != nil {
-			return 0, err29
+		n31, err31 := m.Encryption.MarshalTo(dAtA[i:])
+		if err31 != nil {
+			return 0, err31
 		}
-		i += n29
+		i += n31
+	}
+	if m.CompactKeepVersions != nil {
+		dAtA[i] = 0x72
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.CompactKeepVersions.Size()))
+		n32, err32 := m.CompactKeepVersions.MarshalTo(dAtA[i:])
+		if err32 != nil {
+			return 0, err32
+		}
+		i += n32
+	}
+	if len(m.SampleOf) > 0 {
+		dAtA[i] = 0x7a
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.SampleOf)))
+		i += copy(dAtA[i:], m.SampleOf)
+	}
+	if m.SampleRate != 0 {
+		dAtA[i] = 0x81
+		i++
+		dAtA[i] = 0x1
+		i++
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.SampleRate))))
+		i += 8
+	}
+	if len(m.DeriveFrom) > 0 {
+		dAtA[i] = 0x8a
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.DeriveFrom)))
+		i += copy(dAtA[i:], m.DeriveFrom)
+	}
+	if len(m.DeriveKeyHeader) > 0 {
+		dAtA[i] = 0x92
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.DeriveKeyHeader)))
+		i += copy(dAtA[i:], m.DeriveKeyHeader)
+	}
+	if len(m.SnapshotOf) > 0 {
+		dAtA[i] = 0x9a
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.SnapshotOf)))
+		i += copy(dAtA[i:], m.SnapshotOf)
+	}
+	if len(m.PartitionKey) > 0 {
+		dAtA[i] = 0xa2
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.PartitionKey)))
+		i += copy(dAtA[i:], m.PartitionKey)
+	}
+	if m.RetentionMaxKeys != nil {
+		dAtA[i] = 0xaa
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.RetentionMaxKeys.Size()))
+		n47, err47 := m.RetentionMaxKeys.MarshalTo(dAtA[i:])
+		if err47 != nil {
+			return 0, err47
+		}
+		i += n47
+	}
+	if m.FlushMessages != nil {
+		dAtA[i] = 0xb2
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.FlushMessages.Size()))
+		n48, err48 := m.FlushMessages.MarshalTo(dAtA[i:])
+		if err48 != nil {
+			return 0, err48
+		}
+		i += n48
+	}
+	if m.FlushMs != nil {
+		dAtA[i] = 0xba
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.FlushMs.Size()))
+		n49, err49 := m.FlushMs.MarshalTo(dAtA[i:])
+		if err49 != nil {
+			return 0, err49
+		}
+		i += n49
+	}
+	if m.TieredStorageEnabled != nil {
+		dAtA[i] = 0xc2
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.TieredStorageEnabled.Size()))
+		n50, err50 := m.TieredStorageEnabled.MarshalTo(dAtA[i:])
+		if err50 != nil {
+			return 0, err50
+		}
+		i += n50
+	}
+	if len(m.TieredStorageBucket) > 0 {
+		dAtA[i] = 0xca
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.TieredStorageBucket)))
+		i += copy(dAtA[i:], m.TieredStorageBucket)
+	}
+	if len(m.TieredStoragePrefix) > 0 {
+		dAtA[i] = 0xd2
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.TieredStoragePrefix)))
+		i += copy(dAtA[i:], m.TieredStoragePrefix)
+	}
+	if len(m.SegmentCompression) > 0 {
+		dAtA[i] = 0xda
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.SegmentCompression)))
+		i += copy(dAtA[i:], m.SegmentCompression)
+	}
+	if m.DedupWindow != nil {
+		dAtA[i] = 0xe2
+		i++
+		dAtA[i] = 0x1
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.DedupWindow.Size()))
+		n51, err51 := m.DedupWindow.MarshalTo(dAtA[i:])
+		if err51 != nil {
+			return 0, err51
+		}
+		i += n51
 	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
@@ -3220,17 +3952,50 @@ func (m *Stream) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0x22
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.Config.Size()))
-		n30, err30 := m.Config.MarshalTo(dAtA[i:])
-		if err30 != nil {
-			return 0, err30
+		n33, err33 := m.Config.MarshalTo(dAtA[i:])
+		if err33 != nil {
+			return 0, err33
 		}
-		i += n30
+		i += n33
 	}
 	if m.CreationTimestamp != 0 {
 		dAtA[i] = 0x28
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.CreationTimestamp))
 	}
+	if len(m.Aliases) > 0 {
+		for _, s := range m.Aliases {
+			dAtA[i] = 0x32
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if len(m.DerivedOffsets) > 0 {
+		for _, msg := range m.DerivedOffsets {
+			dAtA[i] = 0x3a
+			i++
+			i = encodeVarintInternal(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.DisplayName) > 0 {
+		dAtA[i] = 0x42
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.DisplayName)))
+		i += copy(dAtA[i:], m.DisplayName)
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -3439,6 +4204,11 @@ func (m *MetadataSnapshot) MarshalTo(dAtA []byte) (int, error) {
 			i += n
 		}
 	}
+	if m.Version != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Version))
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -3476,6 +4246,16 @@ func (m *ReplicationRequest) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.LeaderEpoch))
 	}
+	if m.Pipelined {
+		dAtA[i] = 0x20
+		i++
+		if m.Pipelined {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -3558,81 +4338,111 @@ func (m *PropagatedRequest) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0x12
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.CreateStreamOp.Size()))
-		n31, err31 := m.CreateStreamOp.MarshalTo(dAtA[i:])
-		if err31 != nil {
-			return 0, err31
+		n34, err34 := m.CreateStreamOp.MarshalTo(dAtA[i:])
+		if err34 != nil {
+			return 0, err34
 		}
-		i += n31
+		i += n34
 	}
 	if m.ShrinkISROp != nil {
 		dAtA[i] = 0x1a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.ShrinkISROp.Size()))
-		n32, err32 := m.ShrinkISROp.MarshalTo(dAtA[i:])
-		if err32 != nil {
-			return 0, err32
+		n35, err35 := m.ShrinkISROp.MarshalTo(dAtA[i:])
+		if err35 != nil {
+			return 0, err35
 		}
-		i += n32
+		i += n35
 	}
 	if m.ReportLeaderOp != nil {
 		dAtA[i] = 0x22
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.ReportLeaderOp.Size()))
-		n33, err33 := m.ReportLeaderOp.MarshalTo(dAtA[i:])
-		if err33 != nil {
-			return 0, err33
+		n36, err36 := m.ReportLeaderOp.MarshalTo(dAtA[i:])
+		if err36 != nil {
+			return 0, err36
 		}
-		i += n33
+		i += n36
 	}
 	if m.ExpandISROp != nil {
 		dAtA[i] = 0x2a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.ExpandISROp.Size()))
-		n34, err34 := m.ExpandISROp.MarshalTo(dAtA[i:])
-		if err34 != nil {
-			return 0, err34
+		n37, err37 := m.ExpandISROp.MarshalTo(dAtA[i:])
+		if err37 != nil {
+			return 0, err37
 		}
-		i += n34
+		i += n37
 	}
 	if m.DeleteStreamOp != nil {
 		dAtA[i] = 0x32
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.DeleteStreamOp.Size()))
-		n35, err35 := m.DeleteStreamOp.MarshalTo(dAtA[i:])
-		if err35 != nil {
-			return 0, err35
+		n38, err38 := m.DeleteStreamOp.MarshalTo(dAtA[i:])
+		if err38 != nil {
+			return 0, err38
 		}
-		i += n35
+		i += n38
 	}
 	if m.PauseStreamOp != nil {
 		dAtA[i] = 0x3a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.PauseStreamOp.Size()))
-		n36, err36 := m.PauseStreamOp.MarshalTo(dAtA[i:])
-		if err36 != nil {
-			return 0, err36
+		n39, err39 := m.PauseStreamOp.MarshalTo(dAtA[i:])
+		if err39 != nil {
+			return 0, err39
 		}
-		i += n36
+		i += n39
 	}
 	if m.ResumeStreamOp != nil {
 		dAtA[i] = 0x42
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.ResumeStreamOp.Size()))
-		n37, err37 := m.ResumeStreamOp.MarshalTo(dAtA[i:])
-		if err37 != nil {
-			return 0, err37
+		n40, err40 := m.ResumeStreamOp.MarshalTo(dAtA[i:])
+		if err40 != nil {
+			return 0, err40
 		}
-		i += n37
+		i += n40
 	}
 	if m.SetStreamReadonlyOp != nil {
 		dAtA[i] = 0x4a
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.SetStreamReadonlyOp.Size()))
-		n38, err38 := m.SetStreamReadonlyOp.MarshalTo(dAtA[i:])
-		if err38 != nil {
-			return 0, err38
+		n41, err41 := m.SetStreamReadonlyOp.MarshalTo(dAtA[i:])
+		if err41 != nil {
+			return 0, err41
 		}
-		i += n38
+		i += n41
+	}
+	if m.SetStreamAliasOp != nil {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.SetStreamAliasOp.Size()))
+		n42, err42 := m.SetStreamAliasOp.MarshalTo(dAtA[i:])
+		if err42 != nil {
+			return 0, err42
+		}
+		i += n42
+	}
+	if m.SetDerivedOffsetOp != nil {
+		dAtA[i] = 0x5a
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.SetDerivedOffsetOp.Size()))
+		n43, err43 := m.SetDerivedOffsetOp.MarshalTo(dAtA[i:])
+		if err43 != nil {
+			return 0, err43
+		}
+		i += n43
+	}
+	if m.RenameStreamOp != nil {
+		dAtA[i] = 0x62
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.RenameStreamOp.Size()))
+		n46, err46 := m.RenameStreamOp.MarshalTo(dAtA[i:])
+		if err46 != nil {
+			return 0, err46
+		}
+		i += n46
 	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
@@ -3696,11 +4506,11 @@ func (m *PropagatedResponse) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0x12
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.Error.Size()))
-		n39, err39 := m.Error.MarshalTo(dAtA[i:])
-		if err39 != nil {
-			return 0, err39
+		n44, err44 := m.Error.MarshalTo(dAtA[i:])
+		if err44 != nil {
+			return 0, err44
 		}
-		i += n39
+		i += n44
 	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
@@ -3767,39 +4577,87 @@ func (m *ServerInfoResponse) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintInternal(dAtA, i, uint64(m.Port))
 	}
-	if m.XXX_unrecognized != nil {
-		i += copy(dAtA[i:], m.XXX_unrecognized)
-	}
-	return i, nil
-}
-
-func (m *PartitionStatusRequest) Marshal() (dAtA []byte, err error) {
-	size := m.Size()
-	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
-	if err != nil {
-		return nil, err
-	}
-	return dAtA[:n], nil
-}
-
-func (m *PartitionStatusRequest) MarshalTo(dAtA []byte) (int, error) {
-	var i int
-	_ = i
-	var l int
-	_ = l
-	if len(m.Stream) > 0 {
-		dAtA[i] = 0xa
+	if len(m.Version) > 0 {
+		dAtA[i] = 0x22
 		i++
-		i = encodeVarintInternal(dAtA, i, uint64(len(m.Stream)))
-		i += copy(dAtA[i:], m.Stream)
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.Version)))
+		i += copy(dAtA[i:], m.Version)
 	}
-	if m.Partition != 0 {
-		dAtA[i] = 0x10
+	if len(m.Rack) > 0 {
+		dAtA[i] = 0x2a
 		i++
-		i = encodeVarintInternal(dAtA, i, uint64(m.Partition))
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.Rack)))
+		i += copy(dAtA[i:], m.Rack)
 	}
-	if m.XXX_unrecognized != nil {
+	if m.Serving {
+		dAtA[i] = 0x30
+		i++
+		if m.Serving {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if len(m.AdminAddress) > 0 {
+		dAtA[i] = 0x3a
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.AdminAddress)))
+		i += copy(dAtA[i:], m.AdminAddress)
+	}
+	if len(m.Listeners) > 0 {
+		for _, s := range m.Listeners {
+			dAtA[i] = 0x42
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if m.MetadataVersion != 0 {
+		dAtA[i] = 0x48
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.MetadataVersion))
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *PartitionStatusRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *PartitionStatusRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Stream) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.Stream)))
+		i += copy(dAtA[i:], m.Stream)
+	}
+	if m.Partition != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Partition))
+	}
+	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
 	return i, nil
@@ -3921,6 +4779,160 @@ func (m *Cursor) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *SetStreamAliasOp) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SetStreamAliasOp) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Stream) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.Stream)))
+		i += copy(dAtA[i:], m.Stream)
+	}
+	if len(m.Alias) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.Alias)))
+		i += copy(dAtA[i:], m.Alias)
+	}
+	if m.Remove {
+		dAtA[i] = 0x18
+		i++
+		if m.Remove {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *SetDerivedOffsetOp) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SetDerivedOffsetOp) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Stream) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.Stream)))
+		i += copy(dAtA[i:], m.Stream)
+	}
+	if m.Partition != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Partition))
+	}
+	if m.Offset != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Offset))
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *DerivedOffset) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *DerivedOffset) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Partition != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Partition))
+	}
+	if m.Offset != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Offset))
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
+func (m *RenameStreamOp) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *RenameStreamOp) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Stream) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.Stream)))
+		i += copy(dAtA[i:], m.Stream)
+	}
+	if len(m.Name) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(len(m.Name)))
+		i += copy(dAtA[i:], m.Name)
+	}
+	if m.Replace {
+		dAtA[i] = 0x18
+		i++
+		if m.Replace {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
 func encodeVarintInternal(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -3991,6 +5003,21 @@ func (m *RaftLog) Size() (n int) {
 		l = m.SetStreamReadonlyOp.Size()
 		n += 1 + l + sovInternal(uint64(l))
 	}
+	if m.SetStreamAliasOp != nil {
+		l = m.SetStreamAliasOp.Size()
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.SetDerivedOffsetOp != nil {
+		l = m.SetDerivedOffsetOp.Size()
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.Version != 0 {
+		n += 1 + sovInternal(uint64(m.Version))
+	}
+	if m.RenameStreamOp != nil {
+		l = m.RenameStreamOp.Size()
+		n += 1 + l + sovInternal(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -4335,6 +5362,65 @@ func (m *StreamConfig) Size() (n int) {
 		l = m.Encryption.Size()
 		n += 1 + l + sovInternal(uint64(l))
 	}
+	if m.CompactKeepVersions != nil {
+		l = m.CompactKeepVersions.Size()
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	l = len(m.SampleOf)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.SampleRate != 0 {
+		n += 10
+	}
+	l = len(m.DeriveFrom)
+	if l > 0 {
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	l = len(m.DeriveKeyHeader)
+	if l > 0 {
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	l = len(m.SnapshotOf)
+	if l > 0 {
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	l = len(m.PartitionKey)
+	if l > 0 {
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	if m.RetentionMaxKeys != nil {
+		l = m.RetentionMaxKeys.Size()
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	if m.FlushMessages != nil {
+		l = m.FlushMessages.Size()
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	if m.FlushMs != nil {
+		l = m.FlushMs.Size()
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	if m.TieredStorageEnabled != nil {
+		l = m.TieredStorageEnabled.Size()
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	l = len(m.TieredStorageBucket)
+	if l > 0 {
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	l = len(m.TieredStoragePrefix)
+	if l > 0 {
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	l = len(m.SegmentCompression)
+	if l > 0 {
+		n += 2 + l + sovInternal(uint64(l))
+	}
+	if m.DedupWindow != nil {
+		l = m.DedupWindow.Size()
+		n += 2 + l + sovInternal(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -4368,6 +5454,22 @@ func (m *Stream) Size() (n int) {
 	if m.CreationTimestamp != 0 {
 		n += 1 + sovInternal(uint64(m.CreationTimestamp))
 	}
+	if len(m.Aliases) > 0 {
+		for _, s := range m.Aliases {
+			l = len(s)
+			n += 1 + l + sovInternal(uint64(l))
+		}
+	}
+	if len(m.DerivedOffsets) > 0 {
+		for _, e := range m.DerivedOffsets {
+			l = e.Size()
+			n += 1 + l + sovInternal(uint64(l))
+		}
+	}
+	l = len(m.DisplayName)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -4480,6 +5582,9 @@ func (m *MetadataSnapshot) Size() (n int) {
 			n += 1 + l + sovInternal(uint64(l))
 		}
 	}
+	if m.Version != 0 {
+		n += 1 + sovInternal(uint64(m.Version))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -4502,6 +5607,9 @@ func (m *ReplicationRequest) Size() (n int) {
 	if m.LeaderEpoch != 0 {
 		n += 1 + sovInternal(uint64(m.LeaderEpoch))
 	}
+	if m.Pipelined {
+		n += 2
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -4579,6 +5687,18 @@ func (m *PropagatedRequest) Size() (n int) {
 		l = m.SetStreamReadonlyOp.Size()
 		n += 1 + l + sovInternal(uint64(l))
 	}
+	if m.SetStreamAliasOp != nil {
+		l = m.SetStreamAliasOp.Size()
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.SetDerivedOffsetOp != nil {
+		l = m.SetDerivedOffsetOp.Size()
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.RenameStreamOp != nil {
+		l = m.RenameStreamOp.Size()
+		n += 1 + l + sovInternal(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -4656,11 +5776,35 @@ func (m *ServerInfoResponse) Size() (n int) {
 	if m.Port != 0 {
 		n += 1 + sovInternal(uint64(m.Port))
 	}
-	if m.XXX_unrecognized != nil {
-		n += len(m.XXX_unrecognized)
+	l = len(m.Version)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
 	}
-	return n
-}
+	l = len(m.Rack)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.Serving {
+		n += 2
+	}
+	l = len(m.AdminAddress)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if len(m.Listeners) > 0 {
+		for _, s := range m.Listeners {
+			l = len(s)
+			n += 1 + l + sovInternal(uint64(l))
+		}
+	}
+	if m.MetadataVersion != 0 {
+		n += 1 + sovInternal(uint64(m.MetadataVersion))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
 
 func (m *PartitionStatusRequest) Size() (n int) {
 	if m == nil {
@@ -4744,6 +5888,92 @@ func (m *Cursor) Size() (n int) {
 	return n
 }
 
+func (m *SetStreamAliasOp) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Stream)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	l = len(m.Alias)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.Remove {
+		n += 2
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *SetDerivedOffsetOp) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Stream)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.Partition != 0 {
+		n += 1 + sovInternal(uint64(m.Partition))
+	}
+	if m.Offset != 0 {
+		n += 1 + sovInternal(uint64(m.Offset))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *DerivedOffset) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Partition != 0 {
+		n += 1 + sovInternal(uint64(m.Partition))
+	}
+	if m.Offset != 0 {
+		n += 1 + sovInternal(uint64(m.Offset))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *RenameStreamOp) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Stream)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovInternal(uint64(l))
+	}
+	if m.Replace {
+		n += 2
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
 func sovInternal(x uint64) (n int) {
 	return (math_bits.Len64(x|1) + 6) / 7
 }
@@ -5208,6 +6438,133 @@ func (m *RaftLog) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SetStreamAliasOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.SetStreamAliasOp == nil {
+				m.SetStreamAliasOp = &SetStreamAliasOp{}
+			}
+			if err := m.SetStreamAliasOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 12:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SetDerivedOffsetOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.SetDerivedOffsetOp == nil {
+				m.SetDerivedOffsetOp = &SetDerivedOffsetOp{}
+			}
+			if err := m.SetDerivedOffsetOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			m.Version = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Version |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 14:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RenameStreamOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.RenameStreamOp == nil {
+				m.RenameStreamOp = &RenameStreamOp{}
+			}
+			if err := m.RenameStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -7426,63 +8783,45 @@ func (m *StreamConfig) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
-		default:
-			iNdEx = preIndex
-			skippy, err := skipInternal(dAtA[iNdEx:])
-			if err != nil {
-				return err
+		case 14:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CompactKeepVersions", wireType)
 			}
-			if skippy < 0 {
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			if (iNdEx + skippy) < 0 {
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
-			if (iNdEx + skippy) > l {
+			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
-			iNdEx += skippy
-		}
-	}
-
-	if iNdEx > l {
-		return io.ErrUnexpectedEOF
-	}
-	return nil
-}
-func (m *Stream) Unmarshal(dAtA []byte) error {
-	l := len(dAtA)
-	iNdEx := 0
-	for iNdEx < l {
-		preIndex := iNdEx
-		var wire uint64
-		for shift := uint(0); ; shift += 7 {
-			if shift >= 64 {
-				return ErrIntOverflowInternal
+			if m.CompactKeepVersions == nil {
+				m.CompactKeepVersions = &NullableInt32{}
 			}
-			if iNdEx >= l {
-				return io.ErrUnexpectedEOF
-			}
-			b := dAtA[iNdEx]
-			iNdEx++
-			wire |= uint64(b&0x7F) << shift
-			if b < 0x80 {
-				break
+			if err := m.CompactKeepVersions.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
 			}
-		}
-		fieldNum := int32(wire >> 3)
-		wireType := int(wire & 0x7)
-		if wireType == 4 {
-			return fmt.Errorf("proto: Stream: wiretype end group for non-group")
-		}
-		if fieldNum <= 0 {
-			return fmt.Errorf("proto: Stream: illegal tag %d (wire type %d)", fieldNum, wire)
-		}
-		switch fieldNum {
-		case 1:
+			iNdEx = postIndex
+		case 15:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field SampleOf", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -7510,11 +8849,22 @@ func (m *Stream) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Name = string(dAtA[iNdEx:postIndex])
+			m.SampleOf = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 2:
+		case 16:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SampleRate", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.SampleRate = float64(math.Float64frombits(v))
+		case 17:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Subject", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field DeriveFrom", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -7542,13 +8892,13 @@ func (m *Stream) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Subject = string(dAtA[iNdEx:postIndex])
+			m.DeriveFrom = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 3:
+		case 18:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Partitions", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field DeriveKeyHeader", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -7558,31 +8908,29 @@ func (m *Stream) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Partitions = append(m.Partitions, &Partition{})
-			if err := m.Partitions[len(m.Partitions)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
+			m.DeriveKeyHeader = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 4:
+		case 19:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Config", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field SnapshotOf", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -7592,33 +8940,29 @@ func (m *Stream) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.Config == nil {
-				m.Config = &StreamConfig{}
-			}
-			if err := m.Config.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
+			m.SnapshotOf = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 5:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field CreationTimestamp", wireType)
+		case 20:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PartitionKey", wireType)
 			}
-			m.CreationTimestamp = 0
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -7628,70 +8972,29 @@ func (m *Stream) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.CreationTimestamp |= int64(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-		default:
-			iNdEx = preIndex
-			skippy, err := skipInternal(dAtA[iNdEx:])
-			if err != nil {
-				return err
-			}
-			if skippy < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			if (iNdEx + skippy) < 0 {
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
-			if (iNdEx + skippy) > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
-			iNdEx += skippy
-		}
-	}
-
-	if iNdEx > l {
-		return io.ErrUnexpectedEOF
-	}
-	return nil
-}
-func (m *Partition) Unmarshal(dAtA []byte) error {
-	l := len(dAtA)
-	iNdEx := 0
-	for iNdEx < l {
-		preIndex := iNdEx
-		var wire uint64
-		for shift := uint(0); ; shift += 7 {
-			if shift >= 64 {
-				return ErrIntOverflowInternal
-			}
-			if iNdEx >= l {
+			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			b := dAtA[iNdEx]
-			iNdEx++
-			wire |= uint64(b&0x7F) << shift
-			if b < 0x80 {
-				break
-			}
-		}
-		fieldNum := int32(wire >> 3)
-		wireType := int(wire & 0x7)
-		if wireType == 4 {
-			return fmt.Errorf("proto: Partition: wiretype end group for non-group")
-		}
-		if fieldNum <= 0 {
-			return fmt.Errorf("proto: Partition: illegal tag %d (wire type %d)", fieldNum, wire)
-		}
-		switch fieldNum {
-		case 1:
+			m.PartitionKey = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 21:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Subject", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field RetentionMaxKeys", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -7701,29 +9004,33 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Subject = string(dAtA[iNdEx:postIndex])
+			if m.RetentionMaxKeys == nil {
+				m.RetentionMaxKeys = &NullableInt64{}
+			}
+			if err := m.RetentionMaxKeys.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
 			iNdEx = postIndex
-		case 2:
+		case 22:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Stream", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field FlushMessages", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -7733,48 +9040,33 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Stream = string(dAtA[iNdEx:postIndex])
-			iNdEx = postIndex
-		case 3:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			if m.FlushMessages == nil {
+				m.FlushMessages = &NullableInt64{}
 			}
-			m.Id = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowInternal
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.Id |= int32(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
+			if err := m.FlushMessages.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
 			}
-		case 4:
+			iNdEx = postIndex
+		case 23:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Group", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field FlushMs", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -7784,29 +9076,33 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Group = string(dAtA[iNdEx:postIndex])
+			if m.FlushMs == nil {
+				m.FlushMs = &NullableInt64{}
+			}
+			if err := m.FlushMs.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
 			iNdEx = postIndex
-		case 5:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ReplicationFactor", wireType)
+		case 24:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TieredStorageEnabled", wireType)
 			}
-			m.ReplicationFactor = 0
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -7816,14 +9112,31 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.ReplicationFactor |= int32(b&0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-		case 6:
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.TieredStorageEnabled == nil {
+				m.TieredStorageEnabled = &NullableBool{}
+			}
+			if err := m.TieredStorageEnabled.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 25:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Replicas", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field TieredStorageBucket", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -7851,11 +9164,11 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Replicas = append(m.Replicas, string(dAtA[iNdEx:postIndex]))
+			m.TieredStorageBucket = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 7:
+		case 26:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Leader", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field TieredStoragePrefix", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -7883,11 +9196,11 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Leader = string(dAtA[iNdEx:postIndex])
+			m.TieredStoragePrefix = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 8:
+		case 27:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Isr", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field SegmentCompression", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -7915,13 +9228,13 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Isr = append(m.Isr, string(dAtA[iNdEx:postIndex]))
+			m.SegmentCompression = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 9:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field LeaderEpoch", wireType)
+		case 28:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DedupWindow", wireType)
 			}
-			m.LeaderEpoch = 0
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -7931,70 +9244,28 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.LeaderEpoch |= uint64(b&0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-		case 10:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Epoch", wireType)
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
 			}
-			m.Epoch = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowInternal
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.Epoch |= uint64(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
 			}
-		case 11:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Paused", wireType)
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
 			}
-			var v int
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowInternal
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				v |= int(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-			m.Paused = bool(v != 0)
-		case 12:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Readonly", wireType)
+			if m.DedupWindow == nil {
+				m.DedupWindow = &NullableInt64{}
 			}
-			var v int
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowInternal
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				v |= int(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
+			if err := m.DedupWindow.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
 			}
-			m.Readonly = bool(v != 0)
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -8020,7 +9291,7 @@ func (m *Partition) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *RaftJoinRequest) Unmarshal(dAtA []byte) error {
+func (m *Stream) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -8043,15 +9314,15 @@ func (m *RaftJoinRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: RaftJoinRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: Stream: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: RaftJoinRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Stream: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field NodeID", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -8079,13 +9350,1556 @@ func (m *RaftJoinRequest) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.NodeID = string(dAtA[iNdEx:postIndex])
+			m.Name = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field NodeAddr", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Subject", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Subject = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Partitions", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Partitions = append(m.Partitions, &Partition{})
+			if err := m.Partitions[len(m.Partitions)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Config", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Config == nil {
+				m.Config = &StreamConfig{}
+			}
+			if err := m.Config.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CreationTimestamp", wireType)
+			}
+			m.CreationTimestamp = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.CreationTimestamp |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Aliases", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Aliases = append(m.Aliases, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DerivedOffsets", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.DerivedOffsets = append(m.DerivedOffsets, &DerivedOffset{})
+			if err := m.DerivedOffsets[len(m.DerivedOffsets)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DisplayName", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.DisplayName = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Partition) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Partition: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Partition: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Subject", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Subject = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Stream", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Stream = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			m.Id = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Id |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Group", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Group = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReplicationFactor", wireType)
+			}
+			m.ReplicationFactor = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ReplicationFactor |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Replicas", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Replicas = append(m.Replicas, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Leader", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Leader = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Isr", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Isr = append(m.Isr, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LeaderEpoch", wireType)
+			}
+			m.LeaderEpoch = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LeaderEpoch |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Epoch", wireType)
+			}
+			m.Epoch = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Epoch |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Paused", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Paused = bool(v != 0)
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Readonly", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Readonly = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *RaftJoinRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: RaftJoinRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: RaftJoinRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NodeID", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.NodeID = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NodeAddr", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.NodeAddr = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *RaftJoinResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: RaftJoinResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: RaftJoinResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Error = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MetadataSnapshot) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MetadataSnapshot: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MetadataSnapshot: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Streams", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Streams = append(m.Streams, &Stream{})
+			if err := m.Streams[len(m.Streams)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			m.Version = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Version |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *ReplicationRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: ReplicationRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: ReplicationRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReplicaID", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ReplicaID = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Offset", wireType)
+			}
+			m.Offset = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Offset |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LeaderEpoch", wireType)
+			}
+			m.LeaderEpoch = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LeaderEpoch |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Pipelined", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Pipelined = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LeaderEpochOffsetRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LeaderEpochOffsetRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LeaderEpochOffsetRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LeaderEpoch", wireType)
+			}
+			m.LeaderEpoch = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LeaderEpoch |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LeaderEpochOffsetResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LeaderEpochOffsetResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LeaderEpochOffsetResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field EndOffset", wireType)
+			}
+			m.EndOffset = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.EndOffset |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: PropagatedRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: PropagatedRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Op", wireType)
+			}
+			m.Op = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Op |= Op(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CreateStreamOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.CreateStreamOp == nil {
+				m.CreateStreamOp = &CreateStreamOp{}
+			}
+			if err := m.CreateStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ShrinkISROp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ShrinkISROp == nil {
+				m.ShrinkISROp = &ShrinkISROp{}
+			}
+			if err := m.ShrinkISROp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReportLeaderOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ReportLeaderOp == nil {
+				m.ReportLeaderOp = &ReportLeaderOp{}
+			}
+			if err := m.ReportLeaderOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ExpandISROp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ExpandISROp == nil {
+				m.ExpandISROp = &ExpandISROp{}
+			}
+			if err := m.ExpandISROp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DeleteStreamOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.DeleteStreamOp == nil {
+				m.DeleteStreamOp = &DeleteStreamOp{}
+			}
+			if err := m.DeleteStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PauseStreamOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.PauseStreamOp == nil {
+				m.PauseStreamOp = &PauseStreamOp{}
+			}
+			if err := m.PauseStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ResumeStreamOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.ResumeStreamOp == nil {
+				m.ResumeStreamOp = &ResumeStreamOp{}
+			}
+			if err := m.ResumeStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SetStreamReadonlyOp", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.SetStreamReadonlyOp == nil {
+				m.SetStreamReadonlyOp = &SetStreamReadonlyOp{}
+			}
+			if err := m.SetStreamReadonlyOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SetStreamAliasOp", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8095,83 +10909,33 @@ func (m *RaftJoinRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.NodeAddr = string(dAtA[iNdEx:postIndex])
-			iNdEx = postIndex
-		default:
-			iNdEx = preIndex
-			skippy, err := skipInternal(dAtA[iNdEx:])
-			if err != nil {
-				return err
-			}
-			if skippy < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if (iNdEx + skippy) < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if (iNdEx + skippy) > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
-			iNdEx += skippy
-		}
-	}
-
-	if iNdEx > l {
-		return io.ErrUnexpectedEOF
-	}
-	return nil
-}
-func (m *RaftJoinResponse) Unmarshal(dAtA []byte) error {
-	l := len(dAtA)
-	iNdEx := 0
-	for iNdEx < l {
-		preIndex := iNdEx
-		var wire uint64
-		for shift := uint(0); ; shift += 7 {
-			if shift >= 64 {
-				return ErrIntOverflowInternal
-			}
-			if iNdEx >= l {
-				return io.ErrUnexpectedEOF
+			if m.SetStreamAliasOp == nil {
+				m.SetStreamAliasOp = &SetStreamAliasOp{}
 			}
-			b := dAtA[iNdEx]
-			iNdEx++
-			wire |= uint64(b&0x7F) << shift
-			if b < 0x80 {
-				break
+			if err := m.SetStreamAliasOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
 			}
-		}
-		fieldNum := int32(wire >> 3)
-		wireType := int(wire & 0x7)
-		if wireType == 4 {
-			return fmt.Errorf("proto: RaftJoinResponse: wiretype end group for non-group")
-		}
-		if fieldNum <= 0 {
-			return fmt.Errorf("proto: RaftJoinResponse: illegal tag %d (wire type %d)", fieldNum, wire)
-		}
-		switch fieldNum {
-		case 1:
+			iNdEx = postIndex
+		case 11:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field SetDerivedOffsetOp", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8181,81 +10945,31 @@ func (m *RaftJoinResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Error = string(dAtA[iNdEx:postIndex])
-			iNdEx = postIndex
-		default:
-			iNdEx = preIndex
-			skippy, err := skipInternal(dAtA[iNdEx:])
-			if err != nil {
-				return err
-			}
-			if skippy < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if (iNdEx + skippy) < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if (iNdEx + skippy) > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
-			iNdEx += skippy
-		}
-	}
-
-	if iNdEx > l {
-		return io.ErrUnexpectedEOF
-	}
-	return nil
-}
-func (m *MetadataSnapshot) Unmarshal(dAtA []byte) error {
-	l := len(dAtA)
-	iNdEx := 0
-	for iNdEx < l {
-		preIndex := iNdEx
-		var wire uint64
-		for shift := uint(0); ; shift += 7 {
-			if shift >= 64 {
-				return ErrIntOverflowInternal
-			}
-			if iNdEx >= l {
-				return io.ErrUnexpectedEOF
+			if m.SetDerivedOffsetOp == nil {
+				m.SetDerivedOffsetOp = &SetDerivedOffsetOp{}
 			}
-			b := dAtA[iNdEx]
-			iNdEx++
-			wire |= uint64(b&0x7F) << shift
-			if b < 0x80 {
-				break
+			if err := m.SetDerivedOffsetOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
 			}
-		}
-		fieldNum := int32(wire >> 3)
-		wireType := int(wire & 0x7)
-		if wireType == 4 {
-			return fmt.Errorf("proto: MetadataSnapshot: wiretype end group for non-group")
-		}
-		if fieldNum <= 0 {
-			return fmt.Errorf("proto: MetadataSnapshot: illegal tag %d (wire type %d)", fieldNum, wire)
-		}
-		switch fieldNum {
-		case 1:
+			iNdEx = postIndex
+		case 12:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Streams", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field RenameStreamOp", wireType)
 			}
 			var msglen int
 			for shift := uint(0); ; shift += 7 {
@@ -8282,8 +10996,10 @@ func (m *MetadataSnapshot) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Streams = append(m.Streams, &Stream{})
-			if err := m.Streams[len(m.Streams)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+			if m.RenameStreamOp == nil {
+				m.RenameStreamOp = &RenameStreamOp{}
+			}
+			if err := m.RenameStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
 				return err
 			}
 			iNdEx = postIndex
@@ -8312,7 +11028,7 @@ func (m *MetadataSnapshot) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *ReplicationRequest) Unmarshal(dAtA []byte) error {
+func (m *Error) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -8335,17 +11051,17 @@ func (m *ReplicationRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: ReplicationRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: Error: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: ReplicationRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Error: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ReplicaID", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Code", wireType)
 			}
-			var stringLen uint64
+			m.Code = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8355,29 +11071,16 @@ func (m *ReplicationRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				m.Code |= uint32(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthInternal
-			}
-			postIndex := iNdEx + intStringLen
-			if postIndex < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.ReplicaID = string(dAtA[iNdEx:postIndex])
-			iNdEx = postIndex
 		case 2:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Offset", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Msg", wireType)
 			}
-			m.Offset = 0
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8387,30 +11090,24 @@ func (m *ReplicationRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Offset |= int64(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-		case 3:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field LeaderEpoch", wireType)
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
 			}
-			m.LeaderEpoch = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowInternal
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.LeaderEpoch |= uint64(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
 			}
+			m.Msg = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -8436,7 +11133,7 @@ func (m *ReplicationRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LeaderEpochOffsetRequest) Unmarshal(dAtA []byte) error {
+func (m *PropagatedResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -8459,17 +11156,17 @@ func (m *LeaderEpochOffsetRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LeaderEpochOffsetRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: PropagatedResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LeaderEpochOffsetRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: PropagatedResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field LeaderEpoch", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Op", wireType)
 			}
-			m.LeaderEpoch = 0
+			m.Op = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8479,11 +11176,47 @@ func (m *LeaderEpochOffsetRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.LeaderEpoch |= uint64(b&0x7F) << shift
+				m.Op |= Op(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Error == nil {
+				m.Error = &Error{}
+			}
+			if err := m.Error.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -8509,7 +11242,7 @@ func (m *LeaderEpochOffsetRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LeaderEpochOffsetResponse) Unmarshal(dAtA []byte) error {
+func (m *ServerInfoRequest) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -8532,17 +11265,17 @@ func (m *LeaderEpochOffsetResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LeaderEpochOffsetResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: ServerInfoRequest: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LeaderEpochOffsetResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: ServerInfoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field EndOffset", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
 			}
-			m.EndOffset = 0
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8552,11 +11285,24 @@ func (m *LeaderEpochOffsetResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.EndOffset |= int64(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Id = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -8582,7 +11328,7 @@ func (m *LeaderEpochOffsetResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
+func (m *ServerInfoResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -8605,17 +11351,17 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PropagatedRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: ServerInfoResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PropagatedRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: ServerInfoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Op", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
 			}
-			m.Op = 0
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8625,16 +11371,29 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Op |= Op(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Id = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field CreateStreamOp", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Host", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8644,33 +11403,29 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.CreateStreamOp == nil {
-				m.CreateStreamOp = &CreateStreamOp{}
-			}
-			if err := m.CreateStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
+			m.Host = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 3:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ShrinkISROp", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Port", wireType)
 			}
-			var msglen int
+			m.Port = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8680,33 +11435,16 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				m.Port |= int32(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
-				return ErrInvalidLengthInternal
-			}
-			postIndex := iNdEx + msglen
-			if postIndex < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			if m.ShrinkISROp == nil {
-				m.ShrinkISROp = &ShrinkISROp{}
-			}
-			if err := m.ShrinkISROp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			iNdEx = postIndex
 		case 4:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ReportLeaderOp", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8716,33 +11454,29 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.ReportLeaderOp == nil {
-				m.ReportLeaderOp = &ReportLeaderOp{}
-			}
-			if err := m.ReportLeaderOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
+			m.Version = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 5:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ExpandISROp", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Rack", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8752,33 +11486,29 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.ExpandISROp == nil {
-				m.ExpandISROp = &ExpandISROp{}
-			}
-			if err := m.ExpandISROp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
+			m.Rack = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 6:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field DeleteStreamOp", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Serving", wireType)
 			}
-			var msglen int
+			var v int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8788,33 +11518,17 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				v |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
-				return ErrInvalidLengthInternal
-			}
-			postIndex := iNdEx + msglen
-			if postIndex < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			if m.DeleteStreamOp == nil {
-				m.DeleteStreamOp = &DeleteStreamOp{}
-			}
-			if err := m.DeleteStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			iNdEx = postIndex
+			m.Serving = bool(v != 0)
 		case 7:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field PauseStreamOp", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field AdminAddress", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8824,33 +11538,29 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.PauseStreamOp == nil {
-				m.PauseStreamOp = &PauseStreamOp{}
-			}
-			if err := m.PauseStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
+			m.AdminAddress = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 8:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field ResumeStreamOp", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Listeners", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8860,33 +11570,29 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthInternal
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex < 0 {
 				return ErrInvalidLengthInternal
 			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			if m.ResumeStreamOp == nil {
-				m.ResumeStreamOp = &ResumeStreamOp{}
-			}
-			if err := m.ResumeStreamOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
+			m.Listeners = append(m.Listeners, string(dAtA[iNdEx:postIndex]))
 			iNdEx = postIndex
 		case 9:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field SetStreamReadonlyOp", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MetadataVersion", wireType)
 			}
-			var msglen int
+			m.MetadataVersion = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -8896,28 +11602,11 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				m.MetadataVersion |= uint32(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
-				return ErrInvalidLengthInternal
-			}
-			postIndex := iNdEx + msglen
-			if postIndex < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			if m.SetStreamReadonlyOp == nil {
-				m.SetStreamReadonlyOp = &SetStreamReadonlyOp{}
-			}
-			if err := m.SetStreamReadonlyOp.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -8943,7 +11632,7 @@ func (m *PropagatedRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *Error) Unmarshal(dAtA []byte) error {
+func (m *PartitionStatusRequest) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -8966,34 +11655,15 @@ func (m *Error) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: Error: wiretype end group for non-group")
+			return fmt.Errorf("proto: PartitionStatusRequest: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: Error: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: PartitionStatusRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Code", wireType)
-			}
-			m.Code = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowInternal
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.Code |= uint32(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Msg", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Stream", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -9021,8 +11691,27 @@ func (m *Error) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Msg = string(dAtA[iNdEx:postIndex])
+			m.Stream = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Partition", wireType)
+			}
+			m.Partition = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Partition |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -9048,7 +11737,7 @@ func (m *Error) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *PropagatedResponse) Unmarshal(dAtA []byte) error {
+func (m *PartitionStatusResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -9071,17 +11760,17 @@ func (m *PropagatedResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PropagatedResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: PartitionStatusResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PropagatedResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: PartitionStatusResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Op", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Exists", wireType)
 			}
-			m.Op = 0
+			var v int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9091,16 +11780,17 @@ func (m *PropagatedResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Op |= Op(b&0x7F) << shift
+				v |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			m.Exists = bool(v != 0)
 		case 2:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field IsLeader", wireType)
 			}
-			var msglen int
+			var v int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9110,28 +11800,12 @@ func (m *PropagatedResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				v |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
-				return ErrInvalidLengthInternal
-			}
-			postIndex := iNdEx + msglen
-			if postIndex < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			if m.Error == nil {
-				m.Error = &Error{}
-			}
-			if err := m.Error.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			iNdEx = postIndex
+			m.IsLeader = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -9157,7 +11831,7 @@ func (m *PropagatedResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *ServerInfoRequest) Unmarshal(dAtA []byte) error {
+func (m *PartitionNotification) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -9180,15 +11854,15 @@ func (m *ServerInfoRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: ServerInfoRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: PartitionNotification: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: ServerInfoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: PartitionNotification: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Stream", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -9216,8 +11890,27 @@ func (m *ServerInfoRequest) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Id = string(dAtA[iNdEx:postIndex])
+			m.Stream = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Partition", wireType)
+			}
+			m.Partition = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Partition |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -9243,7 +11936,7 @@ func (m *ServerInfoRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *ServerInfoResponse) Unmarshal(dAtA []byte) error {
+func (m *Cursor) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -9266,15 +11959,15 @@ func (m *ServerInfoResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: ServerInfoResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: Cursor: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: ServerInfoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Cursor: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Stream", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -9302,11 +11995,30 @@ func (m *ServerInfoResponse) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Id = string(dAtA[iNdEx:postIndex])
+			m.Stream = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Partition", wireType)
+			}
+			m.Partition = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Partition |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Host", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field CursorId", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -9334,13 +12046,13 @@ func (m *ServerInfoResponse) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Host = string(dAtA[iNdEx:postIndex])
+			m.CursorId = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 3:
+		case 4:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Port", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Offset", wireType)
 			}
-			m.Port = 0
+			m.Offset = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9350,7 +12062,7 @@ func (m *ServerInfoResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Port |= int32(b&0x7F) << shift
+				m.Offset |= int64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -9380,7 +12092,7 @@ func (m *ServerInfoResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *PartitionStatusRequest) Unmarshal(dAtA []byte) error {
+func (m *SetStreamAliasOp) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -9403,10 +12115,10 @@ func (m *PartitionStatusRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PartitionStatusRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: SetStreamAliasOp: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PartitionStatusRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: SetStreamAliasOp: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
@@ -9442,10 +12154,42 @@ func (m *PartitionStatusRequest) Unmarshal(dAtA []byte) error {
 			m.Stream = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Alias", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Alias = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Partition", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Remove", wireType)
 			}
-			m.Partition = 0
+			var v int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9455,11 +12199,12 @@ func (m *PartitionStatusRequest) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Partition |= int32(b&0x7F) << shift
+				v |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			m.Remove = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -9485,7 +12230,7 @@ func (m *PartitionStatusRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *PartitionStatusResponse) Unmarshal(dAtA []byte) error {
+func (m *SetDerivedOffsetOp) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -9508,17 +12253,17 @@ func (m *PartitionStatusResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PartitionStatusResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: SetDerivedOffsetOp: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PartitionStatusResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: SetDerivedOffsetOp: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Exists", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Stream", wireType)
 			}
-			var v int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9528,17 +12273,29 @@ func (m *PartitionStatusResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				v |= int(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			m.Exists = bool(v != 0)
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthInternal
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Stream = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		case 2:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field IsLeader", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Partition", wireType)
 			}
-			var v int
+			m.Partition = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9548,12 +12305,30 @@ func (m *PartitionStatusResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				v |= int(b&0x7F) << shift
+				m.Partition |= int32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Offset", wireType)
+			}
+			m.Offset = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Offset |= int64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			m.IsLeader = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
@@ -9579,7 +12354,7 @@ func (m *PartitionStatusResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *PartitionNotification) Unmarshal(dAtA []byte) error {
+func (m *DerivedOffset) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -9602,17 +12377,17 @@ func (m *PartitionNotification) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: PartitionNotification: wiretype end group for non-group")
+			return fmt.Errorf("proto: DerivedOffset: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: PartitionNotification: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: DerivedOffset: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Stream", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Partition", wireType)
 			}
-			var stringLen uint64
+			m.Partition = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9622,29 +12397,16 @@ func (m *PartitionNotification) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= uint64(b&0x7F) << shift
+				m.Partition |= int32(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthInternal
-			}
-			postIndex := iNdEx + intStringLen
-			if postIndex < 0 {
-				return ErrInvalidLengthInternal
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.Stream = string(dAtA[iNdEx:postIndex])
-			iNdEx = postIndex
 		case 2:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Partition", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Offset", wireType)
 			}
-			m.Partition = 0
+			m.Offset = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9654,7 +12416,7 @@ func (m *PartitionNotification) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Partition |= int32(b&0x7F) << shift
+				m.Offset |= int64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -9684,7 +12446,7 @@ func (m *PartitionNotification) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *Cursor) Unmarshal(dAtA []byte) error {
+func (m *RenameStreamOp) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -9707,10 +12469,10 @@ func (m *Cursor) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: Cursor: wiretype end group for non-group")
+			return fmt.Errorf("proto: RenameStreamOp: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: Cursor: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: RenameStreamOp: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
@@ -9746,27 +12508,8 @@ func (m *Cursor) Unmarshal(dAtA []byte) error {
 			m.Stream = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 2:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Partition", wireType)
-			}
-			m.Partition = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowInternal
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.Partition |= int32(b&0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-		case 3:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field CursorId", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -9794,13 +12537,13 @@ func (m *Cursor) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.CursorId = string(dAtA[iNdEx:postIndex])
+			m.Name = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
-		case 4:
+		case 3:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Offset", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Replace", wireType)
 			}
-			m.Offset = 0
+			var v int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowInternal
@@ -9810,11 +12553,12 @@ func (m *Cursor) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Offset |= int64(b&0x7F) << shift
+				v |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			m.Replace = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternal(dAtA[iNdEx:])
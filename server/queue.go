@@ -0,0 +1,612 @@
+package server
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// Request metadata keys used to consume a partition as a work queue. The
+// Subscribe and SetCursor requests have no fields for this, so queue
+// consumption is requested with gRPC metadata. Messages are acked with the
+// SetCursorActionQueueAck action.
+const (
+	// QueueMetadata is the Subscribe request metadata key naming the work
+	// queue the subscription consumes from. Subscriptions to the same queue
+	// on a partition receive disjoint messages, each of which must be acked
+	// within the visibility timeout or it is redelivered.
+	QueueMetadata = "liftbridge-queue"
+
+	// QueueVisibilityTimeoutMetadata is the Subscribe request metadata key
+	// setting how long a queue waits for a delivered message to be acked
+	// before redelivering it, as a duration string such as "30s". It is set
+	// by the subscription which creates the queue.
+	QueueVisibilityTimeoutMetadata = "liftbridge-queue-visibility-timeout"
+
+	// QueueRedeliveryBackoffMetadata is the Subscribe request metadata key
+	// setting how long a queue waits after a message's visibility timeout
+	// passes before redelivering it, as a duration string. The wait doubles
+	// with each further delivery of the message up to the maximum set with
+	// QueueMaxRedeliveryBackoffMetadata. It is set by the subscription which
+	// creates the queue.
+	QueueRedeliveryBackoffMetadata = "liftbridge-queue-redelivery-backoff"
+
+	// QueueMaxRedeliveryBackoffMetadata is the Subscribe request metadata key
+	// setting the maximum wait before redelivering a message, as a duration
+	// string. It is set by the subscription which creates the queue.
+	QueueMaxRedeliveryBackoffMetadata = "liftbridge-queue-max-redelivery-backoff"
+
+	// QueueMaxDeliveriesMetadata is the Subscribe request metadata key
+	// setting how many times a queue delivers a message before dropping it
+	// if it is not acked. It is set by the subscription which creates the
+	// queue.
+	QueueMaxDeliveriesMetadata = "liftbridge-queue-max-deliveries"
+
+	// QueueMaxInFlightMetadata is the Subscribe request metadata key setting
+	// how many messages a queue holds which have been read from the log but
+	// not acked. The queue stops reading new messages while it holds this
+	// many. It is set by the subscription which creates the queue.
+	QueueMaxInFlightMetadata = "liftbridge-queue-max-in-flight"
+)
+
+// DeliveryCountHeader is the message header set on messages delivered by a
+// work queue to the number of times the message has been delivered, starting
+// at 1, so consumers can detect redeliveries.
+const DeliveryCountHeader = "Liftbridge-Delivery-Count"
+
+const (
+	defaultQueueVisibilityTimeout    = 30 * time.Second
+	defaultQueueMaxRedeliveryBackoff = 5 * time.Minute
+	defaultQueueMaxInFlight          = 1000
+	queueRedeliveryInterval          = 100 * time.Millisecond
+	queueCursorPrefix                = "queue:"
+)
+
+// queueOptions configures the delivery of messages by a work queue.
+type queueOptions struct {
+	visibilityTimeout    time.Duration
+	redeliveryBackoff    time.Duration
+	maxRedeliveryBackoff time.Duration
+	maxDeliveries        int // Unlimited if 0
+	maxInFlight          int // Unlimited if 0
+}
+
+// backoff returns how long to wait after the visibility timeout of a message
+// which has been delivered the given number of times passes before
+// redelivering it.
+func (o queueOptions) backoff(deliveries int) time.Duration {
+	backoff := o.redeliveryBackoff
+	for i := 1; i < deliveries && backoff < o.maxRedeliveryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > o.maxRedeliveryBackoff {
+		backoff = o.maxRedeliveryBackoff
+	}
+	return backoff
+}
+
+// queueFromContext parses the work queue name and delivery options from the
+// incoming request metadata. It returns an empty name if the request does not
+// consume from a queue.
+func queueFromContext(ctx context.Context) (string, queueOptions, *status.Status) {
+	options := queueOptions{
+		visibilityTimeout:    defaultQueueVisibilityTimeout,
+		maxRedeliveryBackoff: defaultQueueMaxRedeliveryBackoff,
+		maxInFlight:          defaultQueueMaxInFlight,
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", options, nil
+	}
+	names := md.Get(QueueMetadata)
+	if len(names) == 0 || names[0] == "" {
+		return "", options, nil
+	}
+	durations := []struct {
+		key      string
+		value    *time.Duration
+		positive bool
+	}{
+		{QueueVisibilityTimeoutMetadata, &options.visibilityTimeout, true},
+		{QueueRedeliveryBackoffMetadata, &options.redeliveryBackoff, false},
+		{QueueMaxRedeliveryBackoffMetadata, &options.maxRedeliveryBackoff, false},
+	}
+	for _, d := range durations {
+		values := md.Get(d.key)
+		if len(values) == 0 {
+			continue
+		}
+		duration, err := time.ParseDuration(values[0])
+		if err != nil || duration < 0 || (d.positive && duration == 0) {
+			return "", options, status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", d.key, values[0]))
+		}
+		*d.value = duration
+	}
+	limits := []struct {
+		key   string
+		value *int
+	}{
+		{QueueMaxDeliveriesMetadata, &options.maxDeliveries},
+		{QueueMaxInFlightMetadata, &options.maxInFlight},
+	}
+	for _, l := range limits {
+		values := md.Get(l.key)
+		if len(values) == 0 {
+			continue
+		}
+		limit, err := strconv.Atoi(values[0])
+		if err != nil || limit < 0 {
+			return "", options, status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", l.key, values[0]))
+		}
+		*l.value = limit
+	}
+	return names[0], options, nil
+}
+
+// queueCursorID returns the ID of the cursor storing the progress of the
+// named work queue.
+func queueCursorID(name string) string {
+	return queueCursorPrefix + name
+}
+
+// offsetHeap is a min-heap of offsets which supports removing any offset in
+// logarithmic time.
+type offsetHeap struct {
+	offsets []int64
+	index   map[int64]int // Offset to its index in offsets
+}
+
+func newOffsetHeap() *offsetHeap {
+	return &offsetHeap{index: make(map[int64]int)}
+}
+
+func (h *offsetHeap) Len() int           { return len(h.offsets) }
+func (h *offsetHeap) Less(i, j int) bool { return h.offsets[i] < h.offsets[j] }
+
+func (h *offsetHeap) Swap(i, j int) {
+	h.offsets[i], h.offsets[j] = h.offsets[j], h.offsets[i]
+	h.index[h.offsets[i]] = i
+	h.index[h.offsets[j]] = j
+}
+
+func (h *offsetHeap) Push(x interface{}) {
+	offset := x.(int64)
+	h.index[offset] = len(h.offsets)
+	h.offsets = append(h.offsets, offset)
+}
+
+func (h *offsetHeap) Pop() interface{} {
+	last := len(h.offsets) - 1
+	offset := h.offsets[last]
+	h.offsets = h.offsets[:last]
+	delete(h.index, offset)
+	return offset
+}
+
+// remove removes the given offset from the heap if it's present.
+func (h *offsetHeap) remove(offset int64) {
+	if i, ok := h.index[offset]; ok {
+		heap.Remove(h, i)
+	}
+}
+
+// workQueue dispatches the committed messages of a partition to the
+// subscriptions consuming it as a queue. Each message is delivered to one
+// subscription at a time and is redelivered if it is not acked within the
+// visibility timeout, after a backoff which grows with each delivery. The
+// queue tracks the offset up to which all messages have been acked, which is
+// stored as a cursor so a new queue resumes from it. It stops reading from
+// the log while it holds the maximum number of unacked messages.
+type workQueue struct {
+	name        string
+	partition   *partition
+	options     queueOptions
+	deliver     chan *client.Message
+	stop        chan struct{}
+	failed      chan struct{}
+	failOnce    sync.Once
+	err         *status.Status
+	consumers   int // Protected by the partition's queuesMu
+	mu          sync.Mutex
+	pending     map[int64]*client.Message // Read from the log but not acked
+	order       *offsetHeap               // Offsets of pending messages
+	acked       chan struct{}             // Signaled when a pending message is removed
+	deliveries  map[int64]int             // Delivery counts of pending messages
+	redeliverAt map[int64]time.Time       // Pending messages awaiting an ack
+	read        int64                     // Offset of the last message read
+	committed   int64                     // All messages up to this offset are acked
+	persistMu   sync.Mutex
+	persisted   int64
+}
+
+func newWorkQueue(name string, p *partition, startOffset int64, options queueOptions) *workQueue {
+	return &workQueue{
+		name:        name,
+		partition:   p,
+		options:     options,
+		deliver:     make(chan *client.Message),
+		stop:        make(chan struct{}),
+		failed:      make(chan struct{}),
+		pending:     make(map[int64]*client.Message),
+		order:       newOffsetHeap(),
+		acked:       make(chan struct{}, 1),
+		deliveries:  make(map[int64]int),
+		redeliverAt: make(map[int64]time.Time),
+		read:        startOffset - 1,
+		committed:   startOffset - 1,
+		persisted:   startOffset - 1,
+	}
+}
+
+// start begins reading committed messages from the reader and dispatching
+// them to consumers until the queue is stopped.
+func (q *workQueue) start(reader *commitlog.Reader) {
+	ctx, cancel := context.WithCancel(context.Background())
+	logC := make(chan *client.Message)
+	q.partition.srv.startGoroutine(func() {
+		headersBuf := make([]byte, 28)
+		for {
+			msg, st := readSubscriptionMessage(ctx, q.partition, reader, headersBuf)
+			if st != nil {
+				select {
+				case <-q.stop:
+				default:
+					q.fail(st)
+				}
+				return
+			}
+			select {
+			case logC <- msg:
+			case <-q.stop:
+				return
+			}
+		}
+	})
+	q.partition.srv.startGoroutine(func() {
+		defer cancel()
+		q.dispatch(logC)
+	})
+}
+
+// dispatch delivers messages read from the log and expired messages to
+// consumers. Expired messages are redelivered before new ones.
+func (q *workQueue) dispatch(logC <-chan *client.Message) {
+	var (
+		ticker    = time.NewTicker(queueRedeliveryInterval)
+		current   *client.Message
+		delivery  *client.Message
+		redeliver []*client.Message
+	)
+	defer ticker.Stop()
+	for {
+		for current == nil && len(redeliver) > 0 {
+			if q.isPending(redeliver[0].Offset) {
+				current = redeliver[0]
+				delivery = q.newDelivery(current)
+			}
+			redeliver = redeliver[1:]
+		}
+		var (
+			in  = logC
+			out chan<- *client.Message
+		)
+		if current != nil {
+			in = nil
+			out = q.deliver
+		} else if q.isFull() {
+			in = nil
+		}
+		select {
+		case msg := <-in:
+			q.add(msg)
+			current = msg
+			delivery = q.newDelivery(current)
+		case out <- delivery:
+			q.mu.Lock()
+			if _, ok := q.pending[current.Offset]; ok {
+				q.deliveries[current.Offset]++
+				q.redeliverAt[current.Offset] = time.Now().Add(q.options.visibilityTimeout +
+					q.options.backoff(q.deliveries[current.Offset]))
+			}
+			q.mu.Unlock()
+			current = nil
+			delivery = nil
+		case <-ticker.C:
+			redeliver = append(redeliver, q.expired()...)
+		case <-q.acked:
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// add tracks a message read from the log as pending.
+func (q *workQueue) add(msg *client.Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending[msg.Offset] = msg
+	heap.Push(q.order, msg.Offset)
+	q.read = msg.Offset
+}
+
+// isFull indicates if the queue holds the maximum number of pending messages,
+// so it must not read more from the log.
+func (q *workQueue) isFull() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.options.maxInFlight > 0 && len(q.pending) >= q.options.maxInFlight
+}
+
+// isPending indicates if the message at the given offset has not been acked.
+func (q *workQueue) isPending(offset int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.pending[offset]
+	return ok
+}
+
+// newDelivery returns a copy of the message to deliver with its delivery
+// count set.
+func (q *workQueue) newDelivery(msg *client.Message) *client.Message {
+	q.mu.Lock()
+	count := q.deliveries[msg.Offset] + 1
+	q.mu.Unlock()
+	delivery := *msg
+	delivery.Headers = make(map[string][]byte, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		delivery.Headers[key] = value
+	}
+	delivery.Headers[DeliveryCountHeader] = []byte(strconv.Itoa(count))
+	return &delivery
+}
+
+// expired returns the delivered messages which are due for redelivery in
+// offset order. Messages which have reached the maximum number of deliveries
+// are dropped from the queue instead.
+func (q *workQueue) expired() []*client.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var (
+		now  = time.Now()
+		msgs []*client.Message
+	)
+	for offset, redeliverAt := range q.redeliverAt {
+		if now.Before(redeliverAt) {
+			continue
+		}
+		if maxDeliveries := q.options.maxDeliveries; maxDeliveries > 0 &&
+			q.deliveries[offset] >= maxDeliveries {
+			q.partition.srv.logger.Warnf("Dropping message %d from queue %s for partition %s "+
+				"after %d deliveries", offset, q.name, q.partition, q.deliveries[offset])
+			q.remove(offset)
+			continue
+		}
+		delete(q.redeliverAt, offset)
+		msgs = append(msgs, q.pending[offset])
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].Offset < msgs[j].Offset
+	})
+	return msgs
+}
+
+// release makes a delivered message that could not be sent to its consumer
+// available for redelivery immediately. This does not count as a delivery.
+func (q *workQueue) release(offset int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.redeliverAt[offset]; ok {
+		q.deliveries[offset]--
+		q.redeliverAt[offset] = time.Now()
+	}
+}
+
+// ack removes the message at the given offset from the queue so it is not
+// redelivered. It returns the offset up to which all messages are acked.
+func (q *workQueue) ack(offset int64) (int64, *status.Status) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[offset]; !ok {
+		return 0, status.Newf(codes.NotFound,
+			"Message %d is not pending in queue %s", offset, q.name)
+	}
+	q.remove(offset)
+	return q.committed, nil
+}
+
+// remove deletes the pending message at the given offset and updates the
+// offset up to which all messages are acked, which only advances when the
+// oldest pending message is removed. This must be called with the lock held.
+func (q *workQueue) remove(offset int64) {
+	delete(q.pending, offset)
+	delete(q.deliveries, offset)
+	delete(q.redeliverAt, offset)
+	q.order.remove(offset)
+	if q.order.Len() > 0 {
+		q.committed = q.order.offsets[0] - 1
+	} else {
+		q.committed = q.read
+	}
+	// Wake dispatch in case it stopped reading because the queue was full.
+	select {
+	case q.acked <- struct{}{}:
+	default:
+	}
+}
+
+// fail stops delivery to consumers with the given status.
+func (q *workQueue) fail(st *status.Status) {
+	q.failOnce.Do(func() {
+		q.err = st
+		close(q.failed)
+	})
+}
+
+// joinQueue adds a consumer to the named work queue of the partition, creating
+// the queue if needed. A new queue starts at the offset returned by
+// startOffset.
+func (p *partition) joinQueue(name string, options queueOptions,
+	startOffset func() (int64, *status.Status)) (*workQueue, *status.Status) {
+
+	p.queuesMu.Lock()
+	defer p.queuesMu.Unlock()
+	if q, ok := p.queues[name]; ok {
+		q.consumers++
+		return q, nil
+	}
+	offset, st := startOffset()
+	if st != nil {
+		return nil, st
+	}
+	reader, err := p.log.NewReader(offset, false)
+	if err != nil {
+		return nil, status.New(
+			codes.Internal, fmt.Sprintf("Failed to create stream reader: %v", err))
+	}
+	q := newWorkQueue(name, p, offset, options)
+	q.consumers++
+	if p.queues == nil {
+		p.queues = make(map[string]*workQueue)
+	}
+	p.queues[name] = q
+	q.start(reader)
+	return q, nil
+}
+
+// leaveQueue removes a consumer from the work queue, stopping the queue when
+// it has no consumers left. Messages which have not been acked are then
+// redelivered from the queue's stored progress when it is next created.
+func (p *partition) leaveQueue(q *workQueue) {
+	p.queuesMu.Lock()
+	defer p.queuesMu.Unlock()
+	q.consumers--
+	if q.consumers > 0 {
+		return
+	}
+	delete(p.queues, q.name)
+	close(q.stop)
+}
+
+// getQueue returns the named work queue of the partition or nil if it has no
+// consumers.
+func (p *partition) getQueue(name string) *workQueue {
+	p.queuesMu.Lock()
+	defer p.queuesMu.Unlock()
+	return p.queues[name]
+}
+
+// subscribeQueue creates a subscription consuming the partition as the named
+// work queue.
+func (a *apiServer) subscribeQueue(ctx context.Context, partition *partition,
+	req *client.SubscribeRequest, name string, options queueOptions, cancel chan struct{}) (
+	<-chan *client.Message, <-chan *status.Status, *status.Status) {
+
+	q, st := partition.joinQueue(name, options, func() (int64, *status.Status) {
+		return a.queueStartOffset(ctx, partition, req, name)
+	})
+	if st != nil {
+		return nil, nil, st
+	}
+
+	var (
+		ch    = make(chan *client.Message)
+		errCh = make(chan *status.Status)
+	)
+	a.startGoroutine(func() {
+		// Update the active subscriber count.
+		partition.IncreaseSubscriberCount()
+		defer partition.DecreaseSubscriberCount()
+		defer partition.leaveQueue(q)
+
+		for {
+			select {
+			case msg := <-q.deliver:
+				select {
+				case ch <- msg:
+				case <-cancel:
+					q.release(msg.Offset)
+					return
+				}
+			case <-q.failed:
+				select {
+				case errCh <- q.err:
+				case <-cancel:
+				}
+				return
+			case <-cancel:
+				return
+			}
+		}
+	})
+	return ch, errCh, nil
+}
+
+// queueStartOffset returns the offset a new work queue starts at. This is the
+// offset after the queue's stored progress if there is any, otherwise the
+// subscription's start position.
+func (a *apiServer) queueStartOffset(ctx context.Context, partition *partition,
+	req *client.SubscribeRequest, name string) (int64, *status.Status) {
+
+	if a.config.CursorsStream.Partitions > 0 {
+		offset, st := a.cursors.GetCursor(ctx, partition.Stream, queueCursorID(name), partition.Id)
+		if st != nil {
+			a.logger.Warnf("api: Failed to fetch progress of queue %s for partition %s: %v",
+				name, partition, st.Err())
+		} else if offset >= 0 {
+			return offset + 1, nil
+		}
+	}
+	return getStartOffset(req, partition.log)
+}
+
+// ackQueueMessage acks the message at the request's offset in the work queue
+// named by its cursor ID and stores the queue's progress if it advanced.
+func (a *apiServer) ackQueueMessage(ctx context.Context, req *client.SetCursorRequest) *status.Status {
+	if req.Offset < 0 {
+		return status.Newf(codes.InvalidArgument, "Invalid offset %d", req.Offset)
+	}
+	partition := a.metadata.GetPartition(a.streamName(req.Stream), req.Partition)
+	if partition == nil {
+		return partitionNotFoundStatus(codes.NotFound, "No such partition", req.Stream, req.Partition)
+	}
+	q := partition.getQueue(req.CursorId)
+	if q == nil {
+		return status.Newf(codes.NotFound, "No such queue: %s", req.CursorId)
+	}
+	if _, st := q.ack(req.Offset); st != nil {
+		return st
+	}
+	if a.config.CursorsStream.Partitions == 0 {
+		return nil
+	}
+
+	// Serialize storing progress so it never moves backwards.
+	q.persistMu.Lock()
+	defer q.persistMu.Unlock()
+	q.mu.Lock()
+	committed := q.committed
+	q.mu.Unlock()
+	if committed <= q.persisted {
+		return nil
+	}
+	if st := a.cursors.SetCursor(ctx, partition.Stream, queueCursorID(q.name), partition.Id, committed); st != nil {
+		a.logger.Warnf("api: Failed to store progress of queue %s for partition %s: %v",
+			q.name, partition, st.Err())
+		return nil
+	}
+	q.persisted = committed
+	return nil
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure queueFromContext parses the queue name and delivery options.
+func TestQueueFromContext(t *testing.T) {
+	name, _, st := queueFromContext(context.Background())
+	require.Nil(t, st)
+	require.Empty(t, name)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(QueueMetadata, "jobs"))
+	name, options, st := queueFromContext(ctx)
+	require.Nil(t, st)
+	require.Equal(t, "jobs", name)
+	require.Equal(t, queueOptions{
+		visibilityTimeout:    defaultQueueVisibilityTimeout,
+		maxRedeliveryBackoff: defaultQueueMaxRedeliveryBackoff,
+		maxInFlight:          defaultQueueMaxInFlight,
+	}, options)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		QueueMetadata, "jobs",
+		QueueVisibilityTimeoutMetadata, "5s",
+		QueueRedeliveryBackoffMetadata, "1s",
+		QueueMaxRedeliveryBackoffMetadata, "1m",
+		QueueMaxDeliveriesMetadata, "3",
+		QueueMaxInFlightMetadata, "0",
+	))
+	_, options, st = queueFromContext(ctx)
+	require.Nil(t, st)
+	require.Equal(t, queueOptions{
+		visibilityTimeout:    5 * time.Second,
+		redeliveryBackoff:    time.Second,
+		maxRedeliveryBackoff: time.Minute,
+		maxDeliveries:        3,
+	}, options)
+
+	for _, pair := range [][2]string{
+		{QueueVisibilityTimeoutMetadata, "foo"},
+		{QueueVisibilityTimeoutMetadata, "0s"},
+		{QueueVisibilityTimeoutMetadata, "-1s"},
+		{QueueRedeliveryBackoffMetadata, "-1s"},
+		{QueueMaxRedeliveryBackoffMetadata, "foo"},
+		{QueueMaxDeliveriesMetadata, "-1"},
+		{QueueMaxInFlightMetadata, "foo"},
+	} {
+		ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			QueueMetadata, "jobs", pair[0], pair[1]))
+		_, _, st = queueFromContext(ctx)
+		require.Equal(t, codes.InvalidArgument, st.Code())
+	}
+}
+
+// Ensure the redelivery backoff doubles with each delivery up to the
+// maximum.
+func TestQueueOptionsBackoff(t *testing.T) {
+	options := queueOptions{
+		redeliveryBackoff:    time.Second,
+		maxRedeliveryBackoff: 5 * time.Second,
+	}
+	require.Equal(t, time.Second, options.backoff(1))
+	require.Equal(t, 2*time.Second, options.backoff(2))
+	require.Equal(t, 4*time.Second, options.backoff(3))
+	require.Equal(t, 5*time.Second, options.backoff(4))
+	require.Equal(t, 5*time.Second, options.backoff(100))
+
+	options.redeliveryBackoff = 0
+	require.Equal(t, time.Duration(0), options.backoff(3))
+}
+
+// Ensure workQueue tracks the offset up to which all messages are acked.
+func TestWorkQueueAck(t *testing.T) {
+	q := newWorkQueue("jobs", nil, 5, queueOptions{visibilityTimeout: time.Minute})
+	for offset := int64(5); offset < 9; offset++ {
+		q.add(&client.Message{Offset: offset})
+	}
+
+	committed, st := q.ack(6)
+	require.Nil(t, st)
+	require.Equal(t, int64(4), committed)
+	committed, st = q.ack(5)
+	require.Nil(t, st)
+	require.Equal(t, int64(6), committed)
+	_, st = q.ack(5)
+	require.Equal(t, codes.NotFound, st.Code())
+	committed, st = q.ack(8)
+	require.Nil(t, st)
+	require.Equal(t, int64(6), committed)
+	committed, st = q.ack(7)
+	require.Nil(t, st)
+	require.Equal(t, int64(8), committed)
+	require.Equal(t, 0, q.order.Len())
+	require.Empty(t, q.order.index)
+}
+
+// Ensure a workQueue stops reading messages from the log while it holds the
+// maximum number of unacked messages.
+func TestWorkQueueMaxInFlight(t *testing.T) {
+	q := newWorkQueue("jobs", nil, 0, queueOptions{visibilityTimeout: time.Minute, maxInFlight: 2})
+	logC := make(chan *client.Message)
+	go q.dispatch(logC)
+	defer close(q.stop)
+
+	for offset := int64(0); offset < 2; offset++ {
+		logC <- &client.Message{Offset: offset}
+		require.Equal(t, offset, (<-q.deliver).Offset)
+	}
+	select {
+	case logC <- &client.Message{Offset: 2}:
+		t.Fatal("Expected queue to stop reading")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	_, st := q.ack(1)
+	require.Nil(t, st)
+	select {
+	case logC <- &client.Message{Offset: 2}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected queue to resume reading")
+	}
+	require.Equal(t, int64(2), (<-q.deliver).Offset)
+}
+
+// Ensure subscriptions to the same queue receive disjoint messages, that
+// unacked messages are redelivered after the visibility timeout, and that a
+// new queue resumes after the acked messages.
+func TestSubscribeQueue(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.CursorsStream.Partitions = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	subscribe := func(ctx context.Context) client.API_SubscribeClient {
+		queueCtx := metadata.AppendToOutgoingContext(ctx,
+			QueueMetadata, "jobs", QueueVisibilityTimeoutMetadata, "500ms")
+		sub, err := api.Subscribe(queueCtx, &client.SubscribeRequest{
+			Stream:        "foo",
+			StartPosition: client.StartPosition_EARLIEST,
+		})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+		return sub
+	}
+	ack := func(offset int64) error {
+		_, err := api.SetCursor(metadata.AppendToOutgoingContext(ctx, SetCursorActionMetadata, SetCursorActionQueueAck),
+			&client.SetCursorRequest{Stream: "foo", CursorId: "jobs", Offset: offset})
+		return err
+	}
+
+	// Receive from two subscriptions to the same queue.
+	subCtx, subCancel := context.WithCancel(ctx)
+	received := make(chan int64)
+	for i := 0; i < 2; i++ {
+		sub := subscribe(subCtx)
+		go func() {
+			for {
+				msg, err := sub.Recv()
+				if err != nil {
+					return
+				}
+				received <- msg.Offset
+			}
+		}()
+	}
+	recv := func() int64 {
+		select {
+		case offset := <-received:
+			return offset
+		case <-time.After(5 * time.Second):
+			t.Fatal("Did not receive expected message")
+		}
+		return -1
+	}
+
+	// Each message is received by one of the subscriptions. Leave one
+	// unacked.
+	seen := make(map[int64]bool)
+	for i := 0; i < 4; i++ {
+		offset := recv()
+		require.False(t, seen[offset])
+		seen[offset] = true
+		if offset != 1 {
+			require.NoError(t, ack(offset))
+		}
+	}
+	require.Len(t, seen, 4)
+	require.Equal(t, codes.NotFound, status.Code(ack(2)))
+	require.Equal(t, codes.InvalidArgument, status.Code(ack(-1)))
+
+	// The unacked message is redelivered after the visibility timeout.
+	require.Equal(t, int64(1), recv())
+	require.NoError(t, ack(1))
+
+	offset, st := s1.api.cursors.GetCursor(ctx, "foo", queueCursorID("jobs"), 0)
+	require.Nil(t, st)
+	require.Equal(t, int64(3), offset)
+	subCancel()
+
+	// A new queue resumes after the acked messages.
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("4"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+	sub := subscribe(ctx)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), msg.Offset)
+}
+
+// Ensure queues attach delivery counts, back off before redelivering, and
+// drop messages after the maximum number of deliveries.
+func TestSubscribeQueueRedeliveryPolicy(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.CursorsStream.Partitions = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	publish := func(value string) {
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(value),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+	publish("0")
+
+	queueCtx := metadata.AppendToOutgoingContext(ctx,
+		QueueMetadata, "jobs",
+		QueueVisibilityTimeoutMetadata, "200ms",
+		QueueRedeliveryBackoffMetadata, "300ms",
+		QueueMaxDeliveriesMetadata, "2",
+	)
+	sub, err := api.Subscribe(queueCtx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	delivered := time.Now()
+	require.Equal(t, int64(0), msg.Offset)
+	require.Equal(t, []byte("1"), msg.Headers[DeliveryCountHeader])
+
+	// The message is redelivered after the visibility timeout and backoff.
+	msg, err = sub.Recv()
+	require.NoError(t, err)
+	require.True(t, time.Since(delivered) >= 500*time.Millisecond)
+	require.Equal(t, int64(0), msg.Offset)
+	require.Equal(t, []byte("2"), msg.Headers[DeliveryCountHeader])
+
+	// After the maximum deliveries, the message is dropped.
+	time.Sleep(time.Second)
+	publish("1")
+	msg, err = sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), msg.Offset)
+	require.Equal(t, []byte("1"), msg.Headers[DeliveryCountHeader])
+
+	_, err = api.SetCursor(metadata.AppendToOutgoingContext(ctx, SetCursorActionMetadata, SetCursorActionQueueAck),
+		&client.SetCursorRequest{Stream: "foo", CursorId: "jobs", Offset: 1})
+	require.NoError(t, err)
+	offset, st := s1.api.cursors.GetCursor(ctx, "foo", queueCursorID("jobs"), 0)
+	require.Nil(t, st)
+	require.Equal(t, int64(1), offset)
+}
@@ -64,6 +64,10 @@ type raftNode struct {
 	logInput  io.WriteCloser
 	joinSub   *nats.Subscription
 	notifyCh  <-chan bool
+
+	// checkOpSupported returns an error if an operation can't be proposed
+	// because not every server in the cluster supports it.
+	checkOpSupported func(context.Context, *proto.RaftLog) error
 }
 
 // isLeader indicates if the Raft node is currently the leader.
@@ -85,11 +89,18 @@ func (r *raftNode) setLeader(leader bool) {
 // has lost leadership, the returned future will yield an error. This will use
 // the deadline provided on the context and check for preconditions using the
 // supplied function, if provided. This will only return an error if
-// preconditions have failed, indicating the operation was not proposed to the
-// Raft cluster.
+// preconditions have failed or not every server in the cluster supports the
+// operation, indicating the operation was not proposed to the Raft cluster.
 func (r *raftNode) applyOperation(ctx context.Context, op *proto.RaftLog,
 	checkPreconditions func(*proto.RaftLog) error) (raft.ApplyFuture, error) {
 
+	if r.checkOpSupported != nil {
+		if err := r.checkOpSupported(ctx, op); err != nil {
+			return nil, err
+		}
+	}
+
+	op.Version = metadataVersion
 	data, err := op.Marshal()
 	if err != nil {
 		panic(err)
@@ -206,11 +217,20 @@ func (s *Server) setupMetadataRaft() (*raftNode, error) {
 	}
 
 	// Bootstrap if there is no previous state and we are starting this node as
-	// a seed or a cluster configuration is provided.
+	// a seed or a cluster configuration is provided or discovered.
+	peers := s.config.Clustering.RaftBootstrapPeers
 	bootstrap := !existingState &&
-		(s.config.Clustering.RaftBootstrapSeed || len(s.config.Clustering.RaftBootstrapPeers) > 0)
+		(s.config.Clustering.RaftBootstrapSeed || len(peers) > 0)
+	if !existingState && !bootstrap && s.config.Clustering.RaftBootstrapDNSName != "" {
+		peers, err = s.discoverBootstrapPeers()
+		if err != nil {
+			node.shutdown()
+			return nil, err
+		}
+		bootstrap = len(peers) > 0
+	}
 	if bootstrap {
-		if err := s.bootstrapCluster(node.Raft); err != nil {
+		if err := s.bootstrapCluster(node.Raft, peers); err != nil {
 			node.shutdown()
 			return nil, err
 		}
@@ -264,18 +284,17 @@ func (s *Server) setupMetadataRaft() (*raftNode, error) {
 }
 
 // bootstrapCluster bootstraps the node for the provided Raft group either as a
-// seed node or with the given peer configuration, depending on configuration
-// and with the latter taking precedence.
-func (s *Server) bootstrapCluster(node *raft.Raft) error {
+// seed node or with the given peers, with the latter taking precedence.
+func (s *Server) bootstrapCluster(node *raft.Raft, peers []string) error {
 	// Include ourself in the cluster.
 	servers := []raft.Server{{
 		ID:      raft.ServerID(s.config.Clustering.ServerID),
 		Address: raft.ServerAddress(s.config.Clustering.ServerID),
 	}}
-	if len(s.config.Clustering.RaftBootstrapPeers) > 0 {
+	if len(peers) > 0 {
 		// Bootstrap using provided cluster configuration.
 		s.logger.Debug("Bootstrapping metadata Raft group using provided configuration")
-		for _, peer := range s.config.Clustering.RaftBootstrapPeers {
+		for _, peer := range peers {
 			if peer == s.config.Clustering.ServerID {
 				// Don't add ourselves twice.
 				continue
@@ -429,6 +448,8 @@ func (s *Server) createRaftNode() (*raftNode, bool, error) {
 		logInput:  logWriter,
 		notifyCh:  raftNotifyCh,
 		joinSub:   sub,
+
+		checkOpSupported: s.metadata.checkOpSupported,
 	}
 	s.setRaft(raftNode)
 
@@ -466,25 +487,8 @@ func (s *Server) newClusterJoinRequestHandler(node *raft.Raft) func(*nats.Msg) {
 
 		// Add the node to the cluster with appropriate suffrage. This is
 		// idempotent.
-		isVoter, err := s.addAsVoter(node)
-		if err != nil {
+		if err := s.addRaftServer(node, req.NodeID, req.NodeAddr); err != nil {
 			resp.Error = err.Error()
-		} else {
-			var future raft.IndexFuture
-			if isVoter {
-				s.logger.Debugf("Adding server %s to metadata Raft group as voter", req.NodeID)
-				future = node.AddVoter(
-					raft.ServerID(req.NodeID),
-					raft.ServerAddress(req.NodeAddr), 0, 0)
-			} else {
-				s.logger.Debugf("Adding server %s to metadata Raft group as non-voter", req.NodeID)
-				future = node.AddNonvoter(
-					raft.ServerID(req.NodeID),
-					raft.ServerAddress(req.NodeAddr), 0, 0)
-			}
-			if err := future.Error(); err != nil {
-				resp.Error = err.Error()
-			}
 		}
 
 		if resp.Error != "" {
@@ -500,6 +504,25 @@ func (s *Server) newClusterJoinRequestHandler(node *raft.Raft) func(*nats.Msg) {
 	}
 }
 
+// addRaftServer adds the server with the given ID and address to the
+// metadata Raft group as a voter or non-voter depending on addAsVoter. This
+// fails if the node is not the leader.
+func (s *Server) addRaftServer(node *raft.Raft, id, addr string) error {
+	isVoter, err := s.addAsVoter(node)
+	if err != nil {
+		return err
+	}
+	var future raft.IndexFuture
+	if isVoter {
+		s.logger.Debugf("Adding server %s to metadata Raft group as voter", id)
+		future = node.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	} else {
+		s.logger.Debugf("Adding server %s to metadata Raft group as non-voter", id)
+		future = node.AddNonvoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	}
+	return future.Error()
+}
+
 // addAsVoter returns a bool indicating if a new node to be added to the
 // cluster should be added as a voter or not based on current configuration. If
 // we are below the max quorum size or there is no quorum limit, the new node
@@ -8,11 +8,16 @@ import (
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
 
 	"github.com/liftbridge-io/liftbridge/server/commitlog"
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
 
+// errReplicationGap is returned when a replication response does not directly
+// follow the follower's log.
+var errReplicationGap = errors.New("replication response does not follow log")
+
 // replicationOverhead is the non-data size overhead of replication messages: 8
 // bytes for the leader epoch and 8 bytes for the HW.
 const replicationOverhead = 16
@@ -29,7 +34,9 @@ type replicationRequest struct {
 // its health. Requests are received on the requests channel and a long-running
 // loop processes them and sends responses. If the replica does not catch up to
 // the leader's log in maxLagTime, it's removed from the ISR until it catches
-// back up.
+// back up. A replica may pipeline requests, in which case each pipelined
+// request continues from the last message sent in response to the previous
+// request rather than the replica's log end offset.
 type replicator struct {
 	partition    *partition
 	replica      string
@@ -43,16 +50,26 @@ type replicator struct {
 	headersBuf   [28]byte // scratch buffer for reading message headers
 	writer       replicationProtocolWriter
 	waiter       <-chan struct{}
+	// sentOffset is the offset of the last message sent to the replica. It's
+	// only accessed by the replication loop.
+	sentOffset int64
 }
 
 func newReplicator(epoch uint64, replica string, p *partition) *replicator {
+	// Buffer enough requests for a replica pipelining the maximum number of
+	// requests.
+	maxInflight := p.srv.config.Clustering.ReplicaMaxInflightRequests
+	if maxInflight < 1 {
+		maxInflight = 1
+	}
 	return &replicator{
 		epoch:      epoch,
 		replica:    replica,
 		partition:  p,
-		requests:   make(chan replicationRequest, 1),
+		requests:   make(chan replicationRequest, maxInflight),
 		maxLagTime: p.srv.config.Clustering.ReplicaMaxLagTime,
 		leader:     p.srv.config.Clustering.ServerID,
+		sentOffset: -1,
 	}
 }
 
@@ -71,8 +88,11 @@ func (r *replicator) start(stop <-chan struct{}) {
 	r.writer = newReplicationProtocolWriter(r, stop)
 	r.mu.Unlock()
 
-	// Start a goroutine to track the replica's health.
-	r.partition.srv.startGoroutine(func() { r.tick(stop) })
+	// Schedule checks of the replica's health.
+	healthCheck := r.partition.srv.timerWheel.Schedule(r.maxLagTime, func() time.Duration {
+		return r.tick(stop)
+	})
+	defer healthCheck.Stop()
 
 	var req replicationRequest
 	for {
@@ -93,25 +113,43 @@ func (r *replicator) start(stop <-chan struct{}) {
 		var (
 			latest   = r.partition.log.NewestOffset()
 			earliest = r.partition.log.OldestOffset()
+			offset   = req.Offset
 		)
 
+		// Responses to the replica's earlier pipelined requests may still be
+		// in flight, so continue from the last message sent.
+		if req.Pipelined && r.sentOffset > offset {
+			offset = r.sentOffset
+		}
+		r.sentOffset = offset
+
 		// Check if we're caught up.
 		if req.Offset >= latest {
 			r.caughtUp(stop, latest, req)
 			continue
 		}
 
+		// If everything has been sent but the replica has not applied it
+		// yet, there's nothing to send.
+		if offset >= latest {
+			if err := r.sendHW(req.request); err != nil {
+				r.partition.srv.logger.Errorf("Failed to send HW for partition %s to replica %s: %v",
+					r.partition, req.ReplicaID, err)
+			}
+			continue
+		}
+
 		// Create a log reader starting at the requested offset.
 		func() {
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			reader, err := r.partition.log.NewReader(req.Offset+1, true)
+			reader, err := r.partition.log.NewReader(offset+1, true)
 			if err != nil {
 				r.partition.srv.logger.Errorf(
 					"Failed to create replication reader for partition %s "+
 						"and replica %s (requested offset %d, earliest %d, latest %d): %v",
-					r.partition, r.replica, req.Offset+1, earliest, latest, err)
+					r.partition, r.replica, offset+1, earliest, latest, err)
 				// Send a response to short-circuit request timeout.
 				if err := r.sendHW(req.request); err != nil {
 					r.partition.srv.logger.Errorf("Failed to send HW for partition %s to replica %s: %v",
@@ -121,7 +159,7 @@ func (r *replicator) start(stop <-chan struct{}) {
 			}
 
 			// Send a batch of messages to the replica.
-			if err := r.replicate(ctx, reader, req.request, req.Offset); err != nil {
+			if err := r.replicate(ctx, reader, req.request, offset); err != nil {
 				// Send a response to short-circuit request timeout.
 				if err := r.sendHW(req.request); err != nil {
 					r.partition.srv.logger.Errorf("Failed to send HW for partition %s to replica %s: %v",
@@ -142,44 +180,41 @@ func (r *replicator) request(req replicationRequest) {
 	}
 }
 
-// tick is a long-running call that checks to see if the follower hasn't sent
+// tick is a background task that checks to see if the follower hasn't sent
 // any replication requests or hasn't consumed up to the leader's log end
 // offset for the lag-time duration. If this is the case, the follower is
-// removed from the ISR until it catches back up.
-func (r *replicator) tick(stop <-chan struct{}) {
-	timer := time.NewTimer(r.maxLagTime)
-	defer timer.Stop()
-	for {
-		select {
-		case <-stop:
-			return
-		case <-timer.C:
-		}
-		r.mu.RLock()
-		var (
-			now                 = time.Now()
-			lastSeenElapsed     = now.Sub(r.lastSeen)
-			lastCaughtUpElapsed = now.Sub(r.lastCaughtUp)
-		)
-		r.mu.RUnlock()
-		outOfSync := lastSeenElapsed > r.maxLagTime || lastCaughtUpElapsed > r.maxLagTime
-		if outOfSync && r.partition.inISR(r.replica) {
-			// Follower has not sent a request or has not caught up in
-			// maxLagTime, so remove it from the ISR.
-			r.partition.srv.logger.Errorf("Replica %s for partition %s exceeded max lag time "+
-				"(last seen: %s, last caught up: %s), removing from ISR",
-				r.replica, r.partition, lastSeenElapsed, lastCaughtUpElapsed)
-
-			r.shrinkISR()
-		} else if !outOfSync && !r.partition.inISR(r.replica) {
-			// Add replica back into ISR.
-			r.partition.srv.logger.Infof("Replica %s for partition %s caught back up with leader, "+
-				"rejoining ISR", r.replica, r.partition)
-			r.expandISR()
-		}
-
-		timer.Reset(computeTick(lastCaughtUpElapsed, r.maxLagTime))
+// removed from the ISR until it catches back up. It returns the time until the
+// next check, or 0 once the stop channel is closed.
+func (r *replicator) tick(stop <-chan struct{}) time.Duration {
+	select {
+	case <-stop:
+		return 0
+	default:
 	}
+	r.mu.RLock()
+	var (
+		now                 = time.Now()
+		lastSeenElapsed     = now.Sub(r.lastSeen)
+		lastCaughtUpElapsed = now.Sub(r.lastCaughtUp)
+	)
+	r.mu.RUnlock()
+	outOfSync := lastSeenElapsed > r.maxLagTime || lastCaughtUpElapsed > r.maxLagTime
+	if outOfSync && r.partition.inISR(r.replica) {
+		// Follower has not sent a request or has not caught up in
+		// maxLagTime, so remove it from the ISR.
+		r.partition.srv.logger.Errorf("Replica %s for partition %s exceeded max lag time "+
+			"(last seen: %s, last caught up: %s), removing from ISR",
+			r.replica, r.partition, lastSeenElapsed, lastCaughtUpElapsed)
+
+		r.shrinkISR()
+	} else if !outOfSync && !r.partition.inISR(r.replica) {
+		// Add replica back into ISR.
+		r.partition.srv.logger.Infof("Replica %s for partition %s caught back up with leader, "+
+			"rejoining ISR", r.replica, r.partition)
+		r.expandISR()
+	}
+
+	return computeTick(lastCaughtUpElapsed, r.maxLagTime)
 }
 
 // shrinkISR sends a ShrinkISR request to the controller to remove the replica
@@ -217,27 +252,50 @@ func (r *replicator) expandISR() {
 }
 
 // replicate sends a batch of messages to the given NATS inbox along with the
-// leader epoch and HW.
+// leader epoch and HW and records the offset of the last message sent.
+// Control records are replicated like any other message so that followers'
+// logs match the leader's.
 func (r *replicator) replicate(
 	ctx context.Context, reader *commitlog.Reader, request *nats.Msg, offset int64) error {
 
+	reader.SetReadControl(true)
 	var (
 		newestOffset = r.partition.log.NewestOffset()
+		maxBytes     = r.partition.srv.config.Clustering.ReplicationMaxBytes
+		emptyLen     = r.writer.Len()
+		batchStart   = -1 // Buffer length before the current atomic batch
+		sent         = offset
 		message      commitlog.SerializedMessage
 		err          error
 	)
-	for offset < newestOffset && int64(r.writer.Len()) < r.partition.srv.config.Clustering.ReplicationMaxBytes {
-		message, offset, _, _, err = reader.ReadMessage(ctx, r.headersBuf[:])
+	for offset < newestOffset {
+		// Read the message in place into the response buffer if it fits,
+		// leaving room for its headers, to avoid copying it.
+		message, offset, _, _, err = reader.ReadMessageInto(ctx, r.headersBuf[:],
+			r.writer.Tail(len(r.headersBuf)))
 		if err != nil {
 			r.partition.srv.logger.Errorf("Failed to read message while replicating: %v", err)
 			return err
 		}
+		if batchStart == -1 && message.BatchContinues() {
+			batchStart = r.writer.Len()
+		}
 
 		// Check if this message will put us over the batch size limit. If it
-		// does, flush the batch now.
+		// does, flush the batch now. Atomic batches are never split across
+		// responses so that followers only ever have whole batches, so a
+		// partially buffered batch is left for the next response. If the
+		// batch is the only data in the response, it's sent whole even though
+		// it's over the limit.
 		batchSize := int64(len(message)) + int64(len(r.headersBuf)) + int64(r.writer.Len())
-		if batchSize > r.partition.srv.config.Clustering.ReplicationMaxBytes {
-			break
+		if batchSize > maxBytes {
+			if batchStart == -1 {
+				break
+			}
+			if batchStart > emptyLen {
+				r.writer.Truncate(batchStart)
+				break
+			}
 		}
 
 		// Write the message to the buffer.
@@ -245,6 +303,14 @@ func (r *replicator) replicate(
 			r.partition.srv.logger.Errorf("Failed to write message to buffer while replicating: %v", err)
 			return err
 		}
+
+		if !message.BatchContinues() {
+			batchStart = -1
+			sent = offset
+			if int64(r.writer.Len()) >= maxBytes {
+				break
+			}
+		}
 	}
 
 	// Flush the batch.
@@ -252,6 +318,7 @@ func (r *replicator) replicate(
 		r.partition.srv.logger.Errorf("Failed to flush buffer while replicating: %v", err)
 		return err
 	}
+	r.sentOffset = sent
 	return nil
 }
 
@@ -265,7 +332,7 @@ func (r *replicator) caughtUp(stop <-chan struct{}, leo int64, req replicationRe
 	if waiter == nil {
 		// Register a waiter to be notified when new messages are written after
 		// the current log end offset to preempt an idle follower.
-		waiter = r.partition.log.NotifyLEO(r, leo)
+		waiter = r.partition.log.NotifyLEO(leo)
 		r.partition.srv.startGoroutine(func() {
 			select {
 			case <-waiter:
@@ -294,14 +361,16 @@ func (r *replicator) sendHW(request *nats.Msg) error {
 
 type replicationProtocolWriter interface {
 	Write(offset int64, headers, message []byte) error
+	Tail(headersLen int) []byte
 	Flush(func(data []byte) error) error
 	Len() int
+	Truncate(n int)
 	Reset()
 }
 
 type protocolWriter struct {
 	*replicator
-	buf        *bytes.Buffer
+	buf        []byte
 	log        commitlog.CommitLog
 	lastOffset int64
 	dataPos    int
@@ -311,7 +380,6 @@ type protocolWriter struct {
 func newReplicationProtocolWriter(r *replicator, stop <-chan struct{}) replicationProtocolWriter {
 	w := &protocolWriter{
 		replicator: r,
-		buf:        new(bytes.Buffer),
 		log:        r.partition.log,
 		stop:       stop,
 	}
@@ -319,19 +387,37 @@ func newReplicationProtocolWriter(r *replicator, stop <-chan struct{}) replicati
 	return w
 }
 
+// Write appends the message and its headers to the buffer. If the message was
+// read into the slice returned by Tail, only the headers are copied.
 func (w *protocolWriter) Write(offset int64, headers, message []byte) error {
-	if _, err := w.buf.Write(headers); err != nil {
-		return err
-	}
-	if _, err := w.buf.Write(message); err != nil {
-		return err
+	var (
+		start = len(w.buf)
+		end   = start + len(headers) + len(message)
+	)
+	if len(message) > 0 && end <= cap(w.buf) && &w.buf[:end][start+len(headers)] == &message[0] {
+		w.buf = w.buf[:end]
+		copy(w.buf[start:], headers)
+	} else {
+		w.buf = append(append(w.buf, headers...), message...)
 	}
 	w.lastOffset = offset
 	return nil
 }
 
+// Tail returns an empty slice of the unused capacity of the buffer, starting
+// headersLen bytes past its end, so that the next message can be read into
+// the buffer in place before it's written. It returns nil if there's no
+// unused capacity.
+func (w *protocolWriter) Tail(headersLen int) []byte {
+	start := len(w.buf) + headersLen
+	if start >= cap(w.buf) {
+		return nil
+	}
+	return w.buf[start:start]
+}
+
 func (w *protocolWriter) Flush(write func([]byte) error) error {
-	data := w.buf.Bytes()
+	data := w.buf
 	// Replace the HW.
 	proto.Encoding.PutUint64(data[w.dataPos+8:], uint64(w.log.HighWatermark()))
 
@@ -345,19 +431,26 @@ func (w *protocolWriter) Flush(write func([]byte) error) error {
 }
 
 func (w *protocolWriter) Len() int {
-	return w.buf.Len()
+	return len(w.buf)
+}
+
+// Truncate discards all but the first n bytes written to the buffer.
+func (w *protocolWriter) Truncate(n int) {
+	w.buf = w.buf[:n]
 }
 
 func (w *protocolWriter) Reset() {
-	w.buf.Reset()
+	// Reuse the buffer's capacity.
+	buf := bytes.NewBuffer(w.buf[:0])
 	w.lastOffset = -1
 
 	// Write envelope header.
-	w.dataPos = proto.WriteReplicationResponseHeader(w.buf)
+	w.dataPos = proto.WriteReplicationResponseHeader(buf)
 
 	// Write the leader epoch.
-	binary.Write(w.buf, proto.Encoding, w.replicator.epoch)
+	binary.Write(buf, proto.Encoding, w.replicator.epoch)
 	// Reserve space for the HW. This will be replaced with the HW at the time
 	// of flush.
-	binary.Write(w.buf, proto.Encoding, int64(0))
+	binary.Write(buf, proto.Encoding, int64(0))
+	w.buf = buf.Bytes()
 }
@@ -2,7 +2,10 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -10,8 +13,11 @@ import (
 	natsdTest "github.com/nats-io/nats-server/v2/test"
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 
 	lift "github.com/liftbridge-io/go-liftbridge/v2"
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
 
@@ -1036,3 +1042,304 @@ func TestShrinkExpandISR(t *testing.T) {
 	// Wait for ISR to expand to 3.
 	waitForISR(t, 10*time.Second, name, 0, 3, servers...)
 }
+
+// recordingWriter is a replicationProtocolWriter which records the offsets of
+// the messages in each flushed response.
+type recordingWriter struct {
+	buf       []byte
+	offsets   []int64
+	positions []int
+	flushed   [][]int64
+}
+
+func (w *recordingWriter) Write(offset int64, headers, message []byte) error {
+	w.positions = append(w.positions, len(w.buf))
+	w.offsets = append(w.offsets, offset)
+	w.buf = append(append(w.buf, headers...), message...)
+	return nil
+}
+
+func (w *recordingWriter) Tail(headersLen int) []byte {
+	return nil
+}
+
+func (w *recordingWriter) Flush(write func([]byte) error) error {
+	w.flushed = append(w.flushed, w.offsets)
+	w.Reset()
+	return nil
+}
+
+func (w *recordingWriter) Len() int {
+	return len(w.buf)
+}
+
+func (w *recordingWriter) Truncate(n int) {
+	i := len(w.positions)
+	for i > 0 && w.positions[i-1] >= n {
+		i--
+	}
+	w.positions = w.positions[:i]
+	w.offsets = w.offsets[:i]
+	w.buf = w.buf[:n]
+}
+
+func (w *recordingWriter) Reset() {
+	w.buf = nil
+	w.offsets = nil
+	w.positions = nil
+}
+
+// Ensure atomic batches are never split across replication responses, and a
+// batch larger than the replication max bytes is sent in a response by itself.
+func TestReplicatorAtomicBatch(t *testing.T) {
+	defer cleanupStorage(t)
+
+	server := createServer()
+	// Each message below is 54 bytes including its headers.
+	server.config.Clustering.ReplicationMaxBytes = 100
+	p, err := server.newPartition(&proto.Partition{
+		Subject:  "foo",
+		Stream:   "foo",
+		Replicas: []string{"a", "b"},
+		Leader:   "a",
+		Isr:      []string{"a", "b"},
+	}, false, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	value := []byte("0123456789")
+	_, err = p.log.Append([]*commitlog.Message{{Value: value}})
+	require.NoError(t, err)
+	_, err = p.log.Append([]*commitlog.Message{
+		{Value: value, Attributes: commitlog.AttrBatchContinues},
+		{Value: value, Attributes: commitlog.AttrBatchContinues},
+		{Value: value},
+	})
+	require.NoError(t, err)
+	_, err = p.log.Append([]*commitlog.Message{{Value: value}})
+	require.NoError(t, err)
+
+	var (
+		writer = new(recordingWriter)
+		r      = newReplicator(0, "b", p)
+	)
+	r.writer = writer
+	replicate := func(offset int64) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		reader, err := p.log.NewReader(offset+1, true)
+		require.NoError(t, err)
+		require.NoError(t, r.replicate(ctx, reader, &nats.Msg{}, offset))
+	}
+
+	replicate(-1)
+	replicate(0)
+	replicate(3)
+	require.Equal(t, [][]int64{{0}, {1, 2, 3}, {4}}, writer.flushed)
+}
+
+// Ensure control records are replicated like any other message.
+func TestReplicatorControlRecords(t *testing.T) {
+	defer cleanupStorage(t)
+
+	server := createServer()
+	p, err := server.newPartition(&proto.Partition{
+		Subject:  "foo",
+		Stream:   "foo",
+		Replicas: []string{"a", "b"},
+		Leader:   "a",
+		Isr:      []string{"a", "b"},
+	}, false, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	_, err = p.log.Append([]*commitlog.Message{
+		{Value: []byte("foo")},
+		commitlog.NewControlMessage(commitlog.ControlCommit, nil),
+	})
+	require.NoError(t, err)
+
+	var (
+		writer = new(recordingWriter)
+		r      = newReplicator(0, "b", p)
+	)
+	r.writer = writer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader, err := p.log.NewReader(0, true)
+	require.NoError(t, err)
+	require.NoError(t, r.replicate(ctx, reader, &nats.Msg{}, -1))
+	require.Equal(t, [][]int64{{0, 1}}, writer.flushed)
+}
+
+// Ensure protocolWriter copies only the headers of messages read in place
+// into the slice returned by Tail and copies other messages whole.
+func TestProtocolWriterTail(t *testing.T) {
+	w := &protocolWriter{replicator: &replicator{epoch: 1}}
+	w.Reset()
+	emptyLen := w.Len()
+
+	headers := make([]byte, 28)
+	headers[0] = 1
+	require.NoError(t, w.Write(0, headers, []byte("copied")))
+
+	// Grow the buffer so the next message fits after it.
+	w.buf = append(make([]byte, 0, 1024), w.buf...)
+	tail := w.Tail(len(headers))
+	require.NotNil(t, tail)
+	message := append(tail, "in place"...)
+	require.Equal(t, &w.buf[:cap(w.buf)][w.Len()+len(headers)], &message[0])
+	headers[0] = 2
+	require.NoError(t, w.Write(1, headers, message))
+	require.Equal(t, int64(1), w.lastOffset)
+
+	data := w.buf[emptyLen:]
+	require.Equal(t, 2*len(headers)+len("copied")+len("in place"), len(data))
+	require.Equal(t, byte(1), data[0])
+	require.Equal(t, []byte("copied"), data[28:34])
+	require.Equal(t, byte(2), data[34])
+	require.Equal(t, []byte("in place"), data[62:])
+
+	// Reset keeps the buffer's capacity.
+	w.Reset()
+	require.Equal(t, emptyLen, w.Len())
+	require.Equal(t, 1024, cap(w.buf))
+}
+
+// Ensure followers pipelining replication requests replicate the leader's log
+// in order.
+func TestReplicationPipelined(t *testing.T) {
+	defer cleanupStorage(t)
+
+	var servers []*Server
+	for i, id := range []string{"a", "b", "c"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.Clustering.ReplicaMaxInflightRequests = 4
+		config.Clustering.ReplicaMaxIdleWait = 500 * time.Millisecond
+		// Limit responses to a few messages so catching up takes several.
+		config.Clustering.ReplicationMaxBytes = 256
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	metadataLeader := getMetadataLeader(t, 10*time.Second, servers...)
+
+	// Create NATS connection.
+	nc, err := nats.GetDefaultOptions().Connect()
+	require.NoError(t, err)
+	defer nc.Close()
+
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", metadataLeader.config.Port), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	// Create stream.
+	name := "foo"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              name,
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+	waitForPartition(t, 5*time.Second, name, 0, servers...)
+	waitForISR(t, 10*time.Second, name, 0, 3, servers...)
+	leader := getPartitionLeader(t, 10*time.Second, name, 0, servers...)
+
+	// Observe replication requests sent to the leader.
+	pipelined := make(chan struct{}, 1)
+	_, err = nc.Subscribe(leader.metadata.GetPartition(name, 0).getReplicationRequestInbox(),
+		func(msg *nats.Msg) {
+			req, err := proto.UnmarshalReplicationRequest(msg.Data)
+			if err == nil && req.Pipelined {
+				select {
+				case pipelined <- struct{}{}:
+				default:
+				}
+			}
+		})
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	// Publish more messages than a single response can replicate.
+	num := 100
+	for i := 0; i < num; i++ {
+		ackPolicy := client.AckPolicy_LEADER
+		if i == num-1 {
+			ackPolicy = client.AckPolicy_ALL
+		}
+		_, err = api.Publish(ctx, &client.PublishRequest{
+			Stream:    name,
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: ackPolicy,
+		})
+		require.NoError(t, err)
+	}
+
+	select {
+	case <-pipelined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected pipelined replication request")
+	}
+
+	// Each follower's log must match the leader's.
+	waitForHW(t, 10*time.Second, name, 0, int64(num-1), servers...)
+	for _, s := range servers {
+		partition := s.metadata.GetPartition(name, 0)
+		require.Equal(t, int64(num-1), partition.log.NewestOffset())
+		reader, err := partition.log.NewReader(0, false)
+		require.NoError(t, err)
+		headersBuf := make([]byte, 28)
+		for i := 0; i < num; i++ {
+			msg, offset, _, _, err := reader.ReadMessage(context.Background(), headersBuf)
+			require.NoError(t, err)
+			require.Equal(t, int64(i), offset)
+			require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+		}
+	}
+}
+
+// Ensure stream replication uses its own TLS configuration, independently of
+// the one used for Raft, when connecting to NATS.
+func TestReplicationTLS(t *testing.T) {
+	defer cleanupStorage(t)
+
+	dir, err := ioutil.TempDir("", "liftbridge-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "liftbridge")
+
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+	internalOpts := natsdTest.DefaultTestOptions
+	internalOpts.Port = 4322
+	internalOpts.TLSConfig = ca.tlsConfig(t, "nats")
+	internalOpts.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	internalOpts.TLSConfig.ClientCAs = internalOpts.TLSConfig.RootCAs
+	internalOpts.TLSVerify = true
+	internalOpts.TLSTimeout = 2
+	internalNS := natsdTest.RunServer(&internalOpts)
+	defer internalNS.Shutdown()
+
+	var servers []*Server
+	for i, id := range []string{"a", "b"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		config.InternalNATS.Servers = []string{"nats://127.0.0.1:4322"}
+		config.InternalNATS.TLSConfig = ca.tlsConfig(t, "raft-"+id)
+		config.Clustering.ReplicationTLS = ca.tlsConfig(t, "replication-"+id)
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	getMetadataLeader(t, 10*time.Second, servers...)
+	require.Equal(t, 4, internalNS.NumClients())
+	for _, s := range servers {
+		require.True(t, s.ncRepl.Opts.Secure)
+		require.True(t, s.ncRepl.Opts.TLSConfig == s.config.Clustering.ReplicationTLS)
+		require.True(t, s.ncRaft.Opts.TLSConfig == s.config.InternalNATS.TLSConfig)
+	}
+	requireReplication(t, servers...)
+}
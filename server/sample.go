@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// SampleOfMetadata is the CreateStream request metadata key used to create the
+// stream as a sampled mirror of the existing stream with the given name. The
+// mirror receives a deterministic sample of the messages committed to the
+// source stream, selected by key hash at the rate given by SampleRateMetadata.
+const SampleOfMetadata = "liftbridge-sample-of"
+
+// SampleRateMetadata is the CreateStream request metadata key containing the
+// fraction of a source stream's messages its sampled mirror receives, greater
+// than 0 and at most 1, e.g. 0.01 for 1%. It is required with
+// SampleOfMetadata.
+const SampleRateMetadata = "liftbridge-sample-rate"
+
+// SampleSourcePartitionHeader and SampleSourceOffsetHeader are the headers set
+// on messages in a sampled mirror containing the partition and offset of the
+// message in the source stream.
+const (
+	SampleSourcePartitionHeader = "Liftbridge-Sample-Source-Partition"
+	SampleSourceOffsetHeader    = "Liftbridge-Sample-Source-Offset"
+)
+
+const (
+	// samplerRefreshInterval is how often a sampler checks for sampled
+	// mirrors of its partition's stream being created or deleted.
+	samplerRefreshInterval  = time.Second
+	defaultRepublishTimeout = 5 * time.Second
+	sampleRetryInterval     = time.Second
+)
+
+// applySampleMetadata sets the sampled mirror settings of a CreateStream
+// request from the request metadata on the given StreamConfig.
+func applySampleMetadata(md metadata.MD, config *proto.StreamConfig) *status.Status {
+	var (
+		sampleOf = md.Get(SampleOfMetadata)
+		rate     = md.Get(SampleRateMetadata)
+	)
+	if len(sampleOf) == 0 && len(rate) == 0 {
+		return nil
+	}
+	if len(sampleOf) == 0 || sampleOf[0] == "" {
+		return status.New(codes.InvalidArgument,
+			fmt.Sprintf("%s requires %s", SampleRateMetadata, SampleOfMetadata))
+	}
+	if len(rate) == 0 {
+		return status.New(codes.InvalidArgument,
+			fmt.Sprintf("%s requires %s", SampleOfMetadata, SampleRateMetadata))
+	}
+	sampleRate, err := strconv.ParseFloat(rate[0], 64)
+	if err != nil || sampleRate <= 0 || sampleRate > 1 {
+		return status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", SampleRateMetadata, rate[0]))
+	}
+	config.SampleOf = sampleOf[0]
+	config.SampleRate = sampleRate
+	return nil
+}
+
+// ensureSampleSource verifies the source stream of a sampled mirror exists
+// and can be sampled. Aliases are resolved to the stream's name so that the
+// mirror keeps following the stream if the alias is removed.
+func (a *apiServer) ensureSampleSource(config *proto.StreamConfig) *status.Status {
+	if config.SampleOf == "" {
+		return nil
+	}
+	source := a.metadata.GetStream(config.SampleOf)
+	if source == nil {
+		return streamNotFoundStatus(codes.NotFound, fmt.Sprintf("No such stream: %s", config.SampleOf),
+			config.SampleOf)
+	}
+	if isInternalStream(source.GetName()) {
+		return status.Newf(codes.InvalidArgument, "Cannot sample internal stream %s", source.GetName())
+	}
+	config.SampleOf = source.GetName()
+	return nil
+}
+
+// sampledMirrors returns the streams which are sampled mirrors of the stream
+// with the given name.
+func sampledMirrors(streams []*stream, source string) []*stream {
+	var mirrors []*stream
+	for _, stream := range streams {
+		if stream.GetConfig().GetSampleOf() == source {
+			mirrors = append(mirrors, stream)
+		}
+	}
+	return mirrors
+}
+
+// isSampled indicates if a message is included in a sample taken at the given
+// rate. Messages are selected by the hash of their key, so all messages with
+// the same key are either included or not. Messages without a key are
+// selected by the hash of their value.
+func isSampled(msg *client.Message, rate float64) bool {
+	data := msg.Key
+	if len(data) == 0 {
+		data = msg.Value
+	}
+	return float64(hasher(data)) < rate*(1<<32)
+}
+
+// runSampler publishes the sampled messages committed to the given partition
+// to the sampled mirrors of its stream until the context is done. It should be
+// run by the partition leader. Sampling starts with the messages
+// committed after the first mirror was created, but not before the server
+// became leader, so messages committed but not yet sampled when leadership
+// changes are not sampled by the new leader. Mirrors created while others are
+// being sampled receive messages committed after the sampler notices them.
+func (a *apiServer) runSampler(ctx context.Context, p *partition) {
+	var (
+		leaderOffset = p.log.HighWatermark() + 1
+		reader       *commitlog.Reader
+		mirrors      []*stream
+		refreshed    time.Time
+		headersBuf   = make([]byte, 28)
+		ticker       = time.NewTicker(samplerRefreshInterval)
+		err          error
+	)
+	defer ticker.Stop()
+	for {
+		if time.Since(refreshed) >= samplerRefreshInterval {
+			mirrors = sampledMirrors(a.metadata.GetStreams(), p.Stream)
+			refreshed = time.Now()
+		}
+		if len(mirrors) == 0 {
+			reader = nil
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			continue
+		}
+		if reader == nil {
+			reader, err = newSampleReader(p, mirrors, leaderOffset)
+			if err != nil {
+				a.logger.Errorf("Failed to start sampler for partition %s: %v", p, err)
+				return
+			}
+		}
+
+		msg, st := readSubscriptionMessage(ctx, p, reader, headersBuf)
+		if st != nil {
+			if ctx.Err() == nil && st.Code() == codes.Internal {
+				a.logger.Errorf("Sampler for partition %s failed to read messages: %v", p, st.Message())
+			}
+			return
+		}
+		for _, mirror := range mirrors {
+			if !isSampled(msg, mirror.GetConfig().GetSampleRate()) {
+				continue
+			}
+			for !a.publishSample(mirror, msg) {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(sampleRetryInterval):
+				}
+			}
+		}
+	}
+}
+
+// newSampleReader returns a reader starting at the earliest message committed
+// to the partition after the first of the given mirrors was created, but not
+// before the given offset.
+func newSampleReader(p *partition, mirrors []*stream, minOffset int64) (*commitlog.Reader, error) {
+	created := mirrors[0].GetCreationTime()
+	for _, mirror := range mirrors[1:] {
+		if mirror.GetCreationTime().Before(created) {
+			created = mirror.GetCreationTime()
+		}
+	}
+	offset, err := p.log.EarliestOffsetAfterTimestamp(created.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	if offset < minOffset {
+		offset = minOffset
+	}
+	return p.log.NewReader(offset, false)
+}
+
+// publishSample publishes a sampled message to a sampled mirror and waits for
+// the mirror's partition leader to ack it. Messages from a source partition
+// are published to the mirror partition with the same ID modulo the number of
+// mirror partitions. It returns false if the publish failed and should be
+// retried.
+func (a *apiServer) publishSample(mirror *stream, msg *client.Message) bool {
+	headers := make(map[string][]byte, len(msg.Headers)+2)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[SampleSourcePartitionHeader] = []byte(strconv.FormatInt(int64(msg.Partition), 10))
+	headers[SampleSourceOffsetHeader] = []byte(strconv.FormatInt(msg.Offset, 10))
+	return a.republish(&client.PublishRequest{
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Stream:    mirror.GetName(),
+		Partition: msg.Partition % int32(len(mirror.GetPartitions())),
+		Headers:   headers,
+		AckPolicy: client.AckPolicy_LEADER,
+	}, "sampled")
+}
+
+// republish publishes a message read from one stream to another on behalf of
+// the server and waits for it to be acked according to the request's
+// AckPolicy. The kind describes the message in logs. It returns false if the
+// publish failed and should be retried. Publishes which are rejected, e.g.
+// because the target stream was deleted, are not retried.
+func (a *apiServer) republish(req *client.PublishRequest, kind string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRepublishTimeout)
+	defer cancel()
+
+	req.AckInbox = a.getAckInbox()
+	subject, e := a.getPublishSubject(req)
+	if e == nil {
+		e = a.ensurePublishPreconditions(req)
+	}
+	if e != nil {
+		a.logger.Errorf("Dropping %s message for stream %s: %s", kind, req.Stream, e.Message)
+		return true
+	}
+	if err := a.resumeStream(ctx, req.Stream, req.Partition); err != nil {
+		a.logger.Warnf("Failed to resume %s stream %s: %v", kind, req.Stream, err)
+		return false
+	}
+	buf, e := marshalPublishRequest(req, subject, false)
+	if e != nil {
+		a.logger.Errorf("Dropping %s message for stream %s: %s", kind, req.Stream, e.Message)
+		return true
+	}
+	ack, err := a.publishEnvelope(ctx, subject, req.AckInbox, req.AckPolicy, buf)
+	if err != nil {
+		a.logger.Warnf("Failed to publish %s message to stream %s: %v", kind, req.Stream, err)
+		return false
+	}
+	if e := convertAckError(ack.AckError); e != nil {
+		a.logger.Errorf("%s message for stream %s was rejected: %s",
+			strings.Title(kind), req.Stream, e.Message)
+	}
+	return true
+}
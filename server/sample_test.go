@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure applySampleMetadata validates the sampled mirror settings.
+func TestApplySampleMetadata(t *testing.T) {
+	config := new(proto.StreamConfig)
+	require.Nil(t, applySampleMetadata(metadata.MD{}, config))
+	require.Equal(t, "", config.SampleOf)
+
+	require.Nil(t, applySampleMetadata(metadata.Pairs(
+		SampleOfMetadata, "foo", SampleRateMetadata, "0.01"), config))
+	require.Equal(t, "foo", config.SampleOf)
+	require.Equal(t, 0.01, config.SampleRate)
+
+	for _, md := range []metadata.MD{
+		metadata.Pairs(SampleOfMetadata, "foo"),
+		metadata.Pairs(SampleRateMetadata, "0.5"),
+		metadata.Pairs(SampleOfMetadata, "foo", SampleRateMetadata, "0"),
+		metadata.Pairs(SampleOfMetadata, "foo", SampleRateMetadata, "1.5"),
+		metadata.Pairs(SampleOfMetadata, "foo", SampleRateMetadata, "half"),
+	} {
+		st := applySampleMetadata(md, new(proto.StreamConfig))
+		require.NotNil(t, st, md)
+		require.Equal(t, codes.InvalidArgument, st.Code(), md)
+	}
+}
+
+// Ensure isSampled selects messages deterministically by key at roughly the
+// given rate.
+func TestIsSampled(t *testing.T) {
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		msg := &client.Message{Key: []byte(strconv.Itoa(i)), Value: []byte("foo")}
+		if isSampled(msg, 0.1) {
+			sampled++
+			require.True(t, isSampled(msg, 0.1))
+			require.True(t, isSampled(msg, 0.2))
+		}
+		require.True(t, isSampled(msg, 1))
+	}
+	require.InDelta(t, 1000, sampled, 100)
+}
+
+// Ensure a sampled mirror receives the messages of each source partition
+// selected by key hash.
+func TestSampledMirror(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo", Partitions: 2})
+	require.NoError(t, err)
+
+	// Messages published before the mirror is created are not sampled.
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Key:       []byte("before"),
+		Value:     []byte("before"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+
+	_, err = api.CreateStream(
+		metadata.AppendToOutgoingContext(ctx, SampleOfMetadata, "foo", SampleRateMetadata, "0.5"),
+		&client.CreateStreamRequest{Subject: "foo-sample", Name: "foo-sample"},
+	)
+	require.NoError(t, err)
+	mirror := s1.metadata.GetStream("foo-sample")
+	require.NotNil(t, mirror)
+	require.Equal(t, "foo", mirror.GetConfig().SampleOf)
+	require.Equal(t, 0.5, mirror.GetConfig().SampleRate)
+
+	expected := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		partition := int32(i % 2)
+		_, err = api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Partition: partition,
+			Key:       []byte(key),
+			Value:     []byte(key),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+		if isSampled(&client.Message{Key: []byte(key)}, 0.5) {
+			expected[key] = strconv.Itoa(int(partition))
+		}
+	}
+	require.NotEmpty(t, expected)
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo-sample",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+
+	received := make(map[string]string)
+	for len(received) < len(expected) {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		_, ok := expected[string(msg.Key)]
+		require.True(t, ok, string(msg.Key))
+		require.Equal(t, msg.Key, msg.Value)
+		require.NotNil(t, msg.Headers[SampleSourceOffsetHeader])
+		received[string(msg.Key)] = string(msg.Headers[SampleSourcePartitionHeader])
+	}
+	require.Equal(t, expected, received)
+}
+
+// Ensure creating a sampled mirror of a stream which doesn't exist fails.
+func TestSampledMirrorNoSource(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(
+		metadata.AppendToOutgoingContext(ctx, SampleOfMetadata, "foo", SampleRateMetadata, "0.5"),
+		&client.CreateStreamRequest{Subject: "foo-sample", Name: "foo-sample"},
+	)
+	require.Error(t, err)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Nil(t, s1.metadata.GetStream("foo-sample"))
+}
@@ -0,0 +1,465 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/nats-io/nuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Publish request metadata keys used to schedule a message to be published at
+// a later time rather than immediately. Scheduled publishes are stored in the
+// internal schedules stream, so they survive restarts and leader failover.
+const (
+	// ScheduleAtMetadata is the time, in RFC 3339 format, at which the
+	// message is published once.
+	ScheduleAtMetadata = "liftbridge-schedule-at"
+
+	// ScheduleCronMetadata is a five-field cron expression, evaluated in UTC,
+	// on which the message is published repeatedly.
+	ScheduleCronMetadata = "liftbridge-schedule-cron"
+
+	// ScheduleIDMetadata identifies the schedule. Scheduling with the ID of
+	// an existing schedule replaces it. If it is not set, an ID is generated.
+	// The ID is returned in the response header metadata under the same key.
+	ScheduleIDMetadata = "liftbridge-schedule-id"
+
+	// ScheduleCancelMetadata, when "true", cancels the schedule with the
+	// given ID.
+	ScheduleCancelMetadata = "liftbridge-schedule-cancel"
+)
+
+// ScheduleIDHeader is the header added to messages published by a schedule
+// containing the schedule ID.
+const ScheduleIDHeader = "Liftbridge-Schedule-Id"
+
+const (
+	// Headers of records in the schedules stream.
+	scheduleNextHeader = "next"
+	scheduleCronHeader = "cron"
+
+	defaultScheduleTimeout = 5 * time.Second
+	scheduleRetryInterval  = time.Second
+)
+
+// scheduleRequest is a request to schedule or cancel a publish.
+type scheduleRequest struct {
+	id       string
+	at       time.Time
+	cronExpr string
+	cron     *cronSchedule
+	cancel   bool
+}
+
+// scheduleFromContext parses a schedule request from the incoming request
+// metadata. It returns nil if the request is not scheduled.
+func scheduleFromContext(ctx context.Context) (*scheduleRequest, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	var (
+		at       = md.Get(ScheduleAtMetadata)
+		cronExpr = md.Get(ScheduleCronMetadata)
+		id       = md.Get(ScheduleIDMetadata)
+		cancel   = md.Get(ScheduleCancelMetadata)
+	)
+	if len(at) == 0 && len(cronExpr) == 0 && len(id) == 0 && len(cancel) == 0 {
+		return nil, nil
+	}
+
+	req := new(scheduleRequest)
+	if len(id) > 0 {
+		req.id = id[0]
+	}
+	if len(cancel) > 0 {
+		var err error
+		req.cancel, err = strconv.ParseBool(cancel[0])
+		if err != nil {
+			return nil, status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", ScheduleCancelMetadata, cancel[0]))
+		}
+	}
+	if req.cancel {
+		if req.id == "" {
+			return nil, status.New(codes.InvalidArgument,
+				fmt.Sprintf("%s requires %s", ScheduleCancelMetadata, ScheduleIDMetadata))
+		}
+		if len(at) > 0 || len(cronExpr) > 0 {
+			return nil, status.New(codes.InvalidArgument,
+				fmt.Sprintf("%s cannot be combined with a schedule", ScheduleCancelMetadata))
+		}
+		return req, nil
+	}
+
+	switch {
+	case len(at) > 0 && len(cronExpr) > 0:
+		return nil, status.New(codes.InvalidArgument,
+			fmt.Sprintf("Only one of %s and %s can be set", ScheduleAtMetadata, ScheduleCronMetadata))
+	case len(at) > 0:
+		t, err := time.Parse(time.RFC3339, at[0])
+		if err != nil {
+			return nil, status.New(codes.InvalidArgument,
+				fmt.Sprintf("Invalid %s value %q", ScheduleAtMetadata, at[0]))
+		}
+		req.at = t
+	case len(cronExpr) > 0:
+		cron, err := parseCron(cronExpr[0])
+		if err != nil {
+			return nil, status.New(codes.InvalidArgument, err.Error())
+		}
+		if cron.next(time.Now()).IsZero() {
+			return nil, status.New(codes.InvalidArgument,
+				fmt.Sprintf("Cron expression %q never matches", cronExpr[0]))
+		}
+		req.cronExpr = cronExpr[0]
+		req.cron = cron
+	default:
+		return nil, status.New(codes.InvalidArgument,
+			fmt.Sprintf("One of %s or %s is required", ScheduleAtMetadata, ScheduleCronMetadata))
+	}
+	return req, nil
+}
+
+// createSchedulesStream creates the internal schedules stream if it doesn't
+// yet exist and the configured number of partitions is greater than 0. This
+// should be called when this node has been elected metadata leader. The
+// stream is compacted and has no retention limits since schedules may fire
+// far in the future.
+func (s *Server) createSchedulesStream() error {
+	if s.config.SchedulesStream.Partitions == 0 {
+		return nil
+	}
+	if stream := s.metadata.GetStream(schedulesStream); stream != nil {
+		return nil
+	}
+
+	partitions := make([]*proto.Partition, s.config.SchedulesStream.Partitions)
+	for i := int32(0); i < s.config.SchedulesStream.Partitions; i++ {
+		partitions[i] = &proto.Partition{
+			Subject:           s.getSchedulesStreamSubject(),
+			Stream:            schedulesStream,
+			ReplicationFactor: maxReplicationFactor,
+			Id:                i,
+		}
+	}
+	stream := &proto.Stream{
+		Name:       schedulesStream,
+		Subject:    s.getSchedulesStreamSubject(),
+		Partitions: partitions,
+		Config: &proto.StreamConfig{
+			CompactEnabled:       &proto.NullableBool{Value: true},
+			RetentionMaxAge:      &proto.NullableInt64{Value: 0},
+			RetentionMaxBytes:    &proto.NullableInt64{Value: 0},
+			RetentionMaxMessages: &proto.NullableInt64{Value: 0},
+			AutoPauseTime:        &proto.NullableInt64{Value: 0},
+		},
+	}
+	status := s.metadata.CreateStream(context.Background(), &proto.CreateStreamOp{Stream: stream})
+	if status == nil || status.Code() == codes.AlreadyExists {
+		return nil
+	}
+
+	return status.Err()
+}
+
+// schedulePublish stores a scheduled publish of the given request, or cancels
+// a schedule, by writing a record keyed by the schedule ID to the schedules
+// stream. The leader of the record's partition fires the schedule.
+func (a *apiServer) schedulePublish(ctx context.Context, req *client.PublishRequest,
+	sched *scheduleRequest) (*client.PublishResponse, error) {
+
+	if a.config.SchedulesStream.Partitions == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "Scheduled publishing is not enabled")
+	}
+	if batch, _ := batchFromContext(ctx); batch {
+		return nil, status.Error(codes.InvalidArgument, "Batches cannot be scheduled")
+	}
+	stream := a.metadata.GetStream(schedulesStream)
+	if stream == nil {
+		return nil, status.Error(codes.Internal, "Schedules stream does not exist")
+	}
+
+	id := sched.id
+	if id == "" {
+		id = nuid.Next()
+	}
+	record := &client.PublishRequest{
+		Key:       []byte(id),
+		Stream:    schedulesStream,
+		Partition: int32(hasher([]byte(id)) % uint32(len(stream.GetPartitions()))),
+		AckPolicy: client.AckPolicy_ALL,
+	}
+	if !sched.cancel {
+		next := sched.at
+		if sched.cron != nil {
+			next = sched.cron.next(time.Now())
+		}
+		target := &client.PublishRequest{
+			Key:            req.Key,
+			Value:          req.Value,
+			Stream:         req.Stream,
+			Partition:      req.Partition,
+			Headers:        req.Headers,
+			ExpectedOffset: req.ExpectedOffset,
+		}
+		value, err := target.Marshal()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		record.Value = value
+		record.Headers = map[string][]byte{
+			scheduleNextHeader: []byte(strconv.FormatInt(next.UnixNano(), 10)),
+		}
+		if sched.cron != nil {
+			record.Headers[scheduleCronHeader] = []byte(sched.cronExpr)
+		}
+	}
+
+	// Use a new context so the schedule metadata is not applied to the
+	// record itself.
+	recordCtx, cancel := context.WithTimeout(context.Background(), defaultScheduleTimeout)
+	defer cancel()
+	if _, err := a.Publish(recordCtx, record); err != nil {
+		a.logger.Errorf("api: Failed to store schedule %s: %v", id, err)
+		return nil, err
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(ScheduleIDMetadata, id)); err != nil {
+		a.logger.Warnf("api: Failed to send schedule ID header: %v", err)
+	}
+	return new(client.PublishResponse), nil
+}
+
+// scheduledPublish is a schedule stored in the schedules stream.
+type scheduledPublish struct {
+	req      *client.PublishRequest
+	value    []byte
+	next     time.Time
+	cronExpr string
+	cron     *cronSchedule
+}
+
+// parseScheduleRecord parses a record read from the schedules stream. It
+// returns nil if the record cancels the schedule.
+func parseScheduleRecord(msg *client.Message) (*scheduledPublish, error) {
+	if len(msg.Value) == 0 {
+		return nil, nil
+	}
+	sched := &scheduledPublish{
+		req:   new(client.PublishRequest),
+		value: msg.Value,
+	}
+	if err := sched.req.Unmarshal(msg.Value); err != nil {
+		return nil, err
+	}
+	next, err := strconv.ParseInt(string(msg.Headers[scheduleNextHeader]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid next fire time %q", msg.Headers[scheduleNextHeader])
+	}
+	sched.next = time.Unix(0, next)
+	if cronExpr, ok := msg.Headers[scheduleCronHeader]; ok {
+		sched.cronExpr = string(cronExpr)
+		if sched.cron, err = parseCron(sched.cronExpr); err != nil {
+			return nil, err
+		}
+	}
+	return sched, nil
+}
+
+// runScheduler fires the schedules stored in the given partition of the
+// schedules stream until the stop channel is closed. It should be run by the
+// partition leader. Schedules are loaded by reading the partition and kept up
+// to date by following it. After a schedule fires, a record with its next
+// fire time is written, or one removing it if it won't fire again. If the
+// leader fails before this record is committed, the new leader fires the
+// schedule again, so scheduled messages are delivered at least once.
+func (a *apiServer) runScheduler(p *partition, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reader, err := p.log.NewReader(p.log.OldestOffset(), false)
+	if err != nil {
+		a.logger.Errorf("Failed to start scheduler for partition %s: %v", p, err)
+		return
+	}
+	records := make(chan *client.Message)
+	a.startGoroutine(func() {
+		defer close(records)
+		headersBuf := make([]byte, 28)
+		for {
+			msg, st := readSubscriptionMessage(ctx, p, reader, headersBuf)
+			if st != nil {
+				if ctx.Err() == nil {
+					a.logger.Errorf("Scheduler for partition %s failed to read schedules: %v",
+						p, st.Message())
+				}
+				return
+			}
+			select {
+			case records <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	var (
+		schedules = make(map[string]*scheduledPublish)
+		// Schedules don't fire until the schedules committed before this
+		// server became leader have been loaded, so that ones which have
+		// since been replaced or canceled don't fire.
+		loadedOffset = p.log.HighWatermark()
+		loaded       = loadedOffset < 0
+		timer        = time.NewTimer(time.Hour)
+	)
+	defer timer.Stop()
+	for {
+		timer.Stop()
+		if loaded {
+			if next, ok := nextSchedule(schedules); ok {
+				timer = time.NewTimer(time.Until(next))
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case msg, ok := <-records:
+			if !ok {
+				return
+			}
+			sched, err := parseScheduleRecord(msg)
+			if err != nil {
+				a.logger.Errorf("Invalid record for schedule %s in partition %s: %v", msg.Key, p, err)
+			} else if sched == nil {
+				delete(schedules, string(msg.Key))
+			} else {
+				schedules[string(msg.Key)] = sched
+			}
+			if msg.Offset >= loadedOffset {
+				loaded = true
+			}
+		case <-timer.C:
+			now := time.Now()
+			for id, sched := range schedules {
+				if sched.next.After(now) {
+					continue
+				}
+				if !a.fireSchedule(id, sched) {
+					sched.next = now.Add(scheduleRetryInterval)
+					continue
+				}
+				a.advanceSchedule(p, id, sched, now)
+				if sched.next.IsZero() {
+					delete(schedules, id)
+				}
+			}
+		}
+	}
+}
+
+// nextSchedule returns the earliest fire time of the given schedules.
+func nextSchedule(schedules map[string]*scheduledPublish) (time.Time, bool) {
+	var (
+		next  time.Time
+		found bool
+	)
+	for _, sched := range schedules {
+		if !found || sched.next.Before(next) {
+			next = sched.next
+			found = true
+		}
+	}
+	return next, found
+}
+
+// fireSchedule publishes a scheduled message and waits for it to be
+// committed. It returns false if the publish failed and should be retried.
+// Publishes which are rejected, e.g. because the target stream no longer
+// exists, are not retried.
+func (a *apiServer) fireSchedule(id string, sched *scheduledPublish) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultScheduleTimeout)
+	defer cancel()
+
+	headers := make(map[string][]byte, len(sched.req.Headers)+1)
+	for key, value := range sched.req.Headers {
+		headers[key] = value
+	}
+	headers[ScheduleIDHeader] = []byte(id)
+	req := &client.PublishRequest{
+		Key:            sched.req.Key,
+		Value:          sched.req.Value,
+		Stream:         sched.req.Stream,
+		Partition:      sched.req.Partition,
+		Headers:        headers,
+		AckInbox:       a.getAckInbox(),
+		AckPolicy:      client.AckPolicy_ALL,
+		ExpectedOffset: sched.req.ExpectedOffset,
+	}
+
+	subject, e := a.getPublishSubject(req)
+	if e == nil {
+		e = a.ensurePublishPreconditions(req)
+	}
+	if e != nil {
+		a.logger.Errorf("Dropping publish for schedule %s: %s", id, e.Message)
+		return true
+	}
+	if err := a.resumeStream(ctx, req.Stream, req.Partition); err != nil {
+		a.logger.Warnf("Failed to resume stream for schedule %s: %v", id, err)
+		return false
+	}
+	buf, e := marshalPublishRequest(req, subject, false)
+	if e != nil {
+		a.logger.Errorf("Dropping publish for schedule %s: %s", id, e.Message)
+		return true
+	}
+	ack, err := a.publishEnvelope(ctx, subject, req.AckInbox, req.AckPolicy, buf)
+	if err != nil {
+		a.logger.Warnf("Failed to publish for schedule %s: %v", id, err)
+		return false
+	}
+	if e := convertAckError(ack.AckError); e != nil {
+		a.logger.Errorf("Publish for schedule %s was rejected: %s", id, e.Message)
+	}
+	return true
+}
+
+// advanceSchedule sets the next fire time of a schedule which has fired and
+// records it in the schedules stream. The next fire time is zero if the
+// schedule won't fire again, in which case the schedule is removed.
+func (a *apiServer) advanceSchedule(p *partition, id string, sched *scheduledPublish, now time.Time) {
+	record := &client.PublishRequest{
+		Key:       []byte(id),
+		Stream:    schedulesStream,
+		Partition: p.Id,
+		AckPolicy: client.AckPolicy_ALL,
+	}
+	sched.next = time.Time{}
+	if sched.cron != nil {
+		sched.next = sched.cron.next(now)
+	}
+	if !sched.next.IsZero() {
+		record.Value = sched.value
+		record.Headers = map[string][]byte{
+			scheduleNextHeader: []byte(strconv.FormatInt(sched.next.UnixNano(), 10)),
+			scheduleCronHeader: []byte(sched.cronExpr),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultScheduleTimeout)
+	defer cancel()
+	if _, err := a.Publish(ctx, record); err != nil {
+		a.logger.Errorf("Failed to record next fire time for schedule %s: %v", id, err)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	natsdTest "github.com/nats-io/nats-server/v2/test"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure scheduleFromContext parses and validates schedule metadata.
+func TestScheduleFromContext(t *testing.T) {
+	sched, st := scheduleFromContext(context.Background())
+	require.Nil(t, st)
+	require.Nil(t, sched)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		ScheduleAtMetadata, "2020-01-01T10:00:00Z",
+		ScheduleIDMetadata, "foo",
+	))
+	sched, st = scheduleFromContext(ctx)
+	require.Nil(t, st)
+	require.Equal(t, "foo", sched.id)
+	require.True(t, sched.at.Equal(time.Date(2020, time.January, 1, 10, 0, 0, 0, time.UTC)))
+	require.Nil(t, sched.cron)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		ScheduleCronMetadata, "0 9 * * 1-5",
+	))
+	sched, st = scheduleFromContext(ctx)
+	require.Nil(t, st)
+	require.Empty(t, sched.id)
+	require.Equal(t, "0 9 * * 1-5", sched.cronExpr)
+	require.NotNil(t, sched.cron)
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		ScheduleIDMetadata, "foo",
+		ScheduleCancelMetadata, "true",
+	))
+	sched, st = scheduleFromContext(ctx)
+	require.Nil(t, st)
+	require.True(t, sched.cancel)
+
+	for _, pairs := range [][]string{
+		{ScheduleIDMetadata, "foo"},
+		{ScheduleAtMetadata, "tomorrow"},
+		{ScheduleCronMetadata, "* * *"},
+		{ScheduleCronMetadata, "0 0 31 2 *"},
+		{ScheduleAtMetadata, "2020-01-01T10:00:00Z", ScheduleCronMetadata, "* * * * *"},
+		{ScheduleCancelMetadata, "true"},
+		{ScheduleCancelMetadata, "yes", ScheduleIDMetadata, "foo"},
+		{ScheduleCancelMetadata, "true", ScheduleIDMetadata, "foo", ScheduleCronMetadata, "* * * * *"},
+	} {
+		ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+		_, st = scheduleFromContext(ctx)
+		require.NotNil(t, st, pairs)
+		require.Equal(t, codes.InvalidArgument, st.Code(), pairs)
+	}
+}
+
+// Ensure scheduled messages are published at their scheduled time and
+// canceled schedules don't fire.
+func TestSchedulePublish(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.SchedulesStream.Partitions = 2
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	at := time.Now().Add(2 * time.Second).UTC().Format(time.RFC3339)
+	var header metadata.MD
+	_, err = api.Publish(
+		metadata.AppendToOutgoingContext(ctx, ScheduleAtMetadata, at),
+		&client.PublishRequest{
+			Stream:  "foo",
+			Key:     []byte("key"),
+			Value:   []byte("hello"),
+			Headers: map[string][]byte{"foo": []byte("bar")},
+		},
+		grpc.Header(&header),
+	)
+	require.NoError(t, err)
+	ids := header.Get(ScheduleIDMetadata)
+	require.Len(t, ids, 1)
+
+	_, err = api.Publish(
+		metadata.AppendToOutgoingContext(ctx, ScheduleAtMetadata, at, ScheduleIDMetadata, "canceled"),
+		&client.PublishRequest{Stream: "foo", Value: []byte("canceled")},
+	)
+	require.NoError(t, err)
+	_, err = api.Publish(
+		metadata.AppendToOutgoingContext(ctx, ScheduleIDMetadata, "canceled", ScheduleCancelMetadata, "true"),
+		&client.PublishRequest{Stream: "foo"},
+	)
+	require.NoError(t, err)
+
+	// Nothing is published until the scheduled time.
+	partition := s1.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+	require.Equal(t, int64(-1), partition.log.NewestOffset())
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), msg.Offset)
+	require.Equal(t, []byte("key"), msg.Key)
+	require.Equal(t, []byte("hello"), msg.Value)
+	require.Equal(t, []byte("bar"), msg.Headers["foo"])
+	require.Equal(t, []byte(ids[0]), msg.Headers[ScheduleIDHeader])
+
+	// Ensure the canceled schedule doesn't fire and the fired one doesn't
+	// fire again.
+	time.Sleep(2 * time.Second)
+	require.Equal(t, int64(0), partition.log.NewestOffset())
+}
+
+// Ensure schedules fire after the leader of their schedules partition fails.
+func TestSchedulePublishFailover(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Use an external NATS server.
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+
+	servers := make([]*Server, 3)
+	for i, id := range []string{"a", "b", "c"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		config.Clustering.ReplicaMaxLeaderTimeout = time.Second
+		config.Clustering.ReplicaMaxIdleWait = 500 * time.Millisecond
+		config.Clustering.ReplicaFetchTimeout = 500 * time.Millisecond
+		servers[i] = runServerWithConfig(t, config)
+		defer servers[i].Stop()
+	}
+	metadataLeader := getMetadataLeader(t, 10*time.Second, servers...)
+
+	// Create the schedules stream once all servers have joined so that it's
+	// replicated to all of them.
+	for _, s := range servers {
+		s.config.SchedulesStream.Partitions = 1
+	}
+	require.NoError(t, metadataLeader.createSchedulesStream())
+	waitForISR(t, 10*time.Second, schedulesStream, 0, 3, servers...)
+
+	leader := getPartitionLeader(t, 10*time.Second, schedulesStream, 0, servers...)
+	var followers []*Server
+	for _, s := range servers {
+		if s != leader {
+			followers = append(followers, s)
+		}
+	}
+
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", followers[0].config.Port), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 3,
+	})
+	require.NoError(t, err)
+
+	at := time.Now().Add(3 * time.Second).UTC().Format(time.RFC3339)
+	_, err = api.Publish(
+		metadata.AppendToOutgoingContext(ctx, ScheduleAtMetadata, at, ScheduleIDMetadata, "reminder"),
+		&client.PublishRequest{Stream: "foo", Value: []byte("hello")},
+	)
+	require.NoError(t, err)
+	waitForHW(t, 5*time.Second, schedulesStream, 0, 0, servers...)
+
+	// Kill the schedules partition leader before the schedule fires.
+	leader.Stop()
+	getPartitionLeader(t, 10*time.Second, schedulesStream, 0, followers...)
+	getPartitionLeader(t, 10*time.Second, "foo", 0, followers...)
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), msg.Value)
+	require.Equal(t, []byte("reminder"), msg.Headers[ScheduleIDHeader])
+}
+
+// Ensure scheduling fails if the schedules stream is not enabled.
+func TestSchedulePublishDisabled(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	_, err = api.Publish(
+		metadata.AppendToOutgoingContext(ctx, ScheduleCronMetadata, "* * * * *"),
+		&client.PublishRequest{Stream: "foo", Value: []byte("hello")},
+	)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -5,10 +5,10 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -23,11 +23,17 @@ import (
 	"github.com/nats-io/nuid"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
 
+	"github.com/liftbridge-io/liftbridge/server/archive"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	"github.com/liftbridge-io/liftbridge/server/encryption"
 	"github.com/liftbridge-io/liftbridge/server/health"
 	"github.com/liftbridge-io/liftbridge/server/logger"
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+	"github.com/liftbridge-io/liftbridge/server/timerwheel"
 )
 
 const stateFile = "liftbridge"
@@ -40,6 +46,8 @@ const (
 	publishesConnName   = "publishes"
 	activityStream      = "__activity"
 	cursorsStream       = "__cursors"
+	schedulesStream     = "__schedules"
+	canaryStream        = "__canary"
 )
 
 // RaftLog represents an entry into the Raft log.
@@ -55,33 +63,65 @@ type RaftLogListener interface {
 // Server is the main Liftbridge object. Create it by calling New or
 // RunServerWithConfig.
 type Server struct {
-	config             *Config
-	listener           net.Listener
-	port               int
-	embeddedNATS       *gnatsd.Server
-	nc                 *nats.Conn
-	ncRaft             *nats.Conn
-	ncRepl             *nats.Conn
-	ncAcks             *nats.Conn
-	ncPublishes        *nats.Conn
-	logger             logger.Logger
-	loggerOut          io.Writer
-	grpcServer         *grpc.Server
-	api                *apiServer
-	metadata           *metadataAPI
-	shutdownCh         chan struct{}
-	raftInitialized    chan struct{}
-	raft               atomic.Value
-	leaderSub          *nats.Subscription
-	recoveryStarted    bool
-	latestRecoveredLog *raft.Log
-	mu                 sync.RWMutex
-	shutdown           bool
-	running            bool
-	goroutineWait      sync.WaitGroup
-	activity           *activityManager
-	cursors            *cursorManager
-	raftLogListeners   []RaftLogListener
+	fsmAppliedIndex          uint64 // Index of the last Raft log applied to the FSM, accessed atomically
+	config                   *Config
+	metadataVersion          uint32 // Metadata version reported to the cluster, lowered by tests to simulate older servers
+	listener                 net.Listener
+	unixListener             net.Listener
+	listeners                []*clientListener
+	clients                  *clientRegistry // Clients connected to the API
+	port                     int
+	embeddedNATS             *gnatsd.Server
+	transport                *gnatsd.Server // Broker transport used when NATS is disabled
+	dataDirs                 *dataDirs      // Directories partition data is stored in
+	nc                       *nats.Conn
+	ncRaft                   *nats.Conn
+	ncRepl                   *nats.Conn
+	ncAcks                   *nats.Conn
+	ncPublishes              *nats.Conn
+	logger                   logger.Logger
+	defaultLogger            logger.WriterLogger // Nil if Config.Logger is set
+	endRecoverySuppression   func()
+	logFile                  *os.File
+	grpcServer               *grpc.Server
+	api                      *apiServer
+	metadata                 *metadataAPI
+	shutdownCh               chan struct{}
+	raftInitialized          chan struct{}
+	raft                     atomic.Value
+	leaderSub                *nats.Subscription
+	autoDeleteStop           chan struct{}
+	recoveryStarted          bool
+	latestRecoveredLog       *raft.Log
+	mu                       sync.RWMutex
+	shutdown                 bool
+	running                  bool
+	goroutineWait            sync.WaitGroup
+	activity                 *activityManager
+	cursors                  *cursorManager
+	subscriptionBudget       *subscriptionBudget
+	subscriptionLimits       *subscriptionLimits
+	blockCache               *commitlog.BlockCache
+	timerWheel               *timerwheel.Wheel
+	hotPartitions            *hotPartitionTracker
+	metadataWatch            *metadataWatch
+	tieredMu                 sync.Mutex
+	archiveStore             archive.Store                       // Nil if no archive store is configured
+	tieredStorage            *commitlog.TieredStorage            // Counts the statistics of every bucket, nil until tiered storage is used
+	tieredBuckets            map[string]*commitlog.TieredStorage // Tiered storage of each bucket in use
+	segmentKeys              commitlog.KeyProvider               // Nil if segment encryption is disabled
+	canary                   *canary
+	raftLogListeners         []RaftLogListener
+	cursorCommitListeners    []CursorCommitListener
+	streamLifecycleListeners []StreamLifecycleListener
+	publishAckListeners      []PublishAckListener
+	adminServer              *http.Server
+	gossip                   *gossiper
+	drainMu                  sync.Mutex
+	drainCh                  chan struct{} // Closed when the server begins draining
+	drained                  bool
+	streamsConfigMu          sync.RWMutex // Protects stream defaults in config.Streams
+	listenersMu              sync.RWMutex // Protects the hook listeners
 }
 
 // RunServerWithConfig creates and starts a new Server with the given
@@ -99,19 +139,45 @@ func New(config *Config) *Server {
 	if config.DataDir == "" {
 		config.DataDir = filepath.Join("/tmp", "liftbridge", config.Clustering.Namespace)
 	}
-	logger := logger.NewLogger(config.LogLevel)
+	var defaultLogger logger.WriterLogger
+	log := config.Logger
+	if log == nil {
+		defaultLogger = logger.NewLogger(config.LogLevel)
+		log = defaultLogger
+	}
+	suppressibleLogger := logger.NewSuppressibleLogger(log)
 	if config.LogSilent {
-		logger.SetWriter(ioutil.Discard)
+		suppressibleLogger.Suppress()
 	}
 	s := &Server{
 		config:          config,
-		logger:          logger,
+		logger:          suppressibleLogger,
+		defaultLogger:   defaultLogger,
+		metadataVersion: metadataVersion,
 		shutdownCh:      make(chan struct{}),
 		raftInitialized: make(chan struct{}),
+		drainCh:         make(chan struct{}),
 	}
+	s.dataDirs = newDataDirs(config)
 	s.metadata = newMetadataAPI(s)
 	s.activity = newActivityManager(s)
 	s.cursors = newCursorManager(s)
+	s.subscriptionBudget = newSubscriptionBudget(config.SubscriptionBufferMaxBytes)
+	s.blockCache = commitlog.NewBlockCache(config.Streams.BlockCacheMaxBytes)
+	s.hotPartitions = newHotPartitionTracker(s)
+	s.metadataWatch = newMetadataWatch()
+	s.archiveStore = config.ArchiveStore
+	if s.archiveStore == nil && config.ArchiveLocation != "" {
+		s.archiveStore, _ = archive.New(config.ArchiveLocation)
+	}
+	if config.Streams.TieredStorage {
+		s.getTieredStorage(config.Streams.TieredStorageBucket)
+	}
+	s.canary = newCanary(s)
+	s.clients = newClientRegistry(config.ConnectionMax)
+	s.subscriptionLimits = newSubscriptionLimits(config.SubscriptionMaxPerConnection,
+		config.SubscriptionMaxPerStream)
+	s.api = &apiServer{s}
 	return s
 }
 
@@ -126,10 +192,37 @@ func (s *Server) Start() (err error) {
 
 	rand.Seed(time.Now().UnixNano())
 
+	// Start the workers running partition background tasks before any
+	// partitions are recovered.
+	s.timerWheel = timerwheel.New(s.config.Streams.BackgroundWorkers, s.config.Streams.BackgroundTick)
+	if interval := s.config.Streams.HotSampleInterval; interval > 0 {
+		s.timerWheel.Schedule(interval, s.hotPartitions.sample)
+	}
+	s.dataDirs.startCompaction(s.config.Streams.CompactionWorkers,
+		s.config.Streams.CompactionIOMaxBytesPerSec)
+
 	// Create the data directory if it doesn't exist.
 	if err := os.MkdirAll(s.config.DataDir, os.ModePerm); err != nil {
 		return errors.Wrap(err, "failed to create data path directories")
 	}
+	if err := s.dataDirs.create(); err != nil {
+		return errors.Wrap(err, "failed to create partition data directories")
+	}
+
+	if err := s.openLogFile(); err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+
+	if s.config.Streams.SegmentEncryption {
+		s.segmentKeys = s.config.Streams.SegmentEncryptionKeys
+		if s.segmentKeys == nil {
+			keys, err := encryption.NewDirKeyProvider(s.config.Streams.SegmentEncryptionKeysDir)
+			if err != nil {
+				return errors.Wrap(err, "failed to load segment encryption keys")
+			}
+			s.segmentKeys = keys
+		}
+	}
 
 	// Recover and persist metadata state.
 	if err := s.recoverAndPersistState(); err != nil {
@@ -139,12 +232,25 @@ func (s *Server) Start() (err error) {
 	s.logger.Infof("Liftbridge Version:        %s", Version)
 	s.logger.Infof("Server ID:                 %s", s.config.Clustering.ServerID)
 	s.logger.Infof("Namespace:                 %s", s.config.Clustering.Namespace)
-	s.logger.Infof("NATS Servers:              %s", s.config.NATSServersString())
+	if s.config.NATSDisabled {
+		s.logger.Infof("NATS Servers:              disabled, using broker transport on %s",
+			s.config.Clustering.TransportListen)
+	} else {
+		s.logger.Infof("NATS Servers:              %s", s.config.NATSServersString())
+		if s.config.HasInternalNATS() {
+			s.logger.Infof("Internal NATS Servers:     %s", s.config.InternalNATSServersString())
+		}
+	}
 	s.logger.Infof("Default Retention Policy:  %s", s.config.Streams.RetentionString())
 	s.logger.Infof("Default Partition Pausing: %s", s.config.Streams.AutoPauseString())
 
-	// Start embedded NATS server if configured.
-	if s.config.EmbeddedNATS {
+	// Start embedded NATS server if configured, or the broker transport if
+	// NATS is disabled.
+	if s.config.NATSDisabled {
+		if err := s.startBrokerTransport(); err != nil {
+			return errors.Wrap(err, "failed to start broker transport")
+		}
+	} else if s.config.EmbeddedNATS {
 		if err := s.startEmbeddedNATS(); err != nil {
 			return errors.Wrap(err, "failed to start embedded NATS server")
 		}
@@ -166,6 +272,19 @@ func (s *Server) Start() (err error) {
 	s.logger.Infof("Starting Liftbridge server on %s...",
 		net.JoinHostPort(listenAddress.Host, strconv.Itoa(s.port)))
 
+	if s.config.UnixSocketPath != "" {
+		l, err := listenUnix(s.config.UnixSocketPath, s.config.UnixSocketMode)
+		if err != nil {
+			return errors.Wrap(err, "failed starting unix socket listener")
+		}
+		s.unixListener = l
+		s.logger.Infof("Listening for clients on unix socket %s", s.config.UnixSocketPath)
+	}
+
+	if err := s.startListeners(); err != nil {
+		return err
+	}
+
 	// Set a lower bound of one second for SegmentMaxAge to avoid frequent log
 	// rolls which will cause performance problems. This is mainly here because
 	// SegmentMaxAge defaults to RetentionMaxAge if it's not set explicitly,
@@ -175,6 +294,11 @@ func (s *Server) Start() (err error) {
 		s.config.Streams.SegmentMaxAge = time.Second
 	}
 
+	// Start gossip before Raft so that liveness is known once we're leader.
+	if err := s.startGossip(); err != nil {
+		return errors.Wrap(err, "failed to start gossip")
+	}
+
 	raftNode, err := s.setupMetadataRaft()
 	if err != nil {
 		return errors.Wrap(err, "failed to start Raft node")
@@ -200,7 +324,15 @@ func (s *Server) Start() (err error) {
 		return errors.Wrap(err, "failed to start API server")
 	}
 
+	if err := s.startAdminServer(); err != nil {
+		return errors.Wrap(err, "failed to start admin server")
+	}
 	s.startRaftLeadershipLoop(raftNode)
+	s.startSystemdNotifier(raftNode)
+	s.startConfigWatcher()
+	if s.config.Canary.Enabled {
+		s.startGoroutine(s.canary.run)
+	}
 	return nil
 }
 
@@ -215,6 +347,9 @@ func (s *Server) Stop() error {
 	}
 
 	s.logger.Info("Shutting down...")
+	if _, err := sdNotify(sdStopping); err != nil {
+		s.logger.Warnf("Failed to notify systemd of shutdown: %v", err)
+	}
 
 	// Close the raftInitialized channel in case the Raft node was never
 	// initialized to prevent a deadlock.
@@ -233,6 +368,22 @@ func (s *Server) Stop() error {
 		s.listener.Close()
 	}
 
+	if s.unixListener != nil {
+		s.unixListener.Close()
+	}
+
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+
+	if s.adminServer != nil {
+		s.adminServer.Close()
+	}
+
+	if s.gossip != nil {
+		s.gossip.stop()
+	}
+
 	if s.metadata != nil {
 		if err := s.metadata.Reset(); err != nil {
 			s.mu.Unlock()
@@ -251,6 +402,9 @@ func (s *Server) Stop() error {
 	if s.embeddedNATS != nil {
 		s.embeddedNATS.Shutdown()
 	}
+	if s.transport != nil {
+		s.transport.Shutdown()
+	}
 
 	s.running = false
 	s.shutdown = true
@@ -258,10 +412,34 @@ func (s *Server) Stop() error {
 
 	// Wait for goroutines to stop.
 	s.goroutineWait.Wait()
+	s.timerWheel.Stop()
+	s.dataDirs.stopCompaction()
+
+	if s.logFile != nil {
+		return s.logFile.Close()
+	}
 
 	return nil
 }
 
+// openLogFile directs log messages to the configured log file, if any, by
+// appending to it. The log file only applies to the default Logger.
+func (s *Server) openLogFile() error {
+	if s.config.LogFile == "" || s.config.LogSilent || s.defaultLogger == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.config.LogFile), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.logFile = file
+	s.defaultLogger.SetWriter(file)
+	return nil
+}
+
 // IsLeader indicates if the server is currently the metadata leader or not. If
 // consistency is required for an operation, it should be threaded through the
 // Raft cluster since that is the single source of truth. If a server thinks
@@ -362,36 +540,59 @@ func (s *Server) startEmbeddedNATS() error {
 // including connections for stream data, Raft, replication, acks, and
 // publishes.
 func (s *Server) createNATSConns() error {
+	// Inter-broker traffic, i.e. Raft, request propagation and replication,
+	// can use a separate NATS cluster, e.g. on a private network, from the
+	// one clients publish to.
+	clientOpts := s.config.NATS
+	internalOpts := s.config.NATS
+	if s.config.HasInternalNATS() {
+		internalOpts = s.config.InternalNATS
+	}
+
+	// All connections use the broker transport if NATS is disabled.
+	if s.transport != nil {
+		clientOpts = s.transportNATSOptions()
+		internalOpts = clientOpts
+	}
+
 	// NATS connection used for stream data.
-	nc, err := s.createNATSConn(streamsConnName)
+	nc, err := s.createNATSConn(streamsConnName, clientOpts)
 	if err != nil {
 		return err
 	}
 	s.nc = nc
 
 	// NATS connection used for Raft metadata replication.
-	ncr, err := s.createNATSConn(raftConnName)
+	ncr, err := s.createNATSConn(raftConnName, internalOpts)
 	if err != nil {
 		return err
 	}
 	s.ncRaft = ncr
 
-	// NATS connection used for stream replication.
-	ncRepl, err := s.createNATSConn(replicationConnName)
+	// NATS connection used for stream replication. It can use its own TLS
+	// settings so that stream data is encrypted independently of Raft. With
+	// the broker transport, these secure the connections between brokers
+	// instead.
+	replOpts := internalOpts
+	if tlsConfig := s.config.Clustering.ReplicationTLS; tlsConfig != nil && s.transport == nil {
+		replOpts.TLSConfig = tlsConfig
+		replOpts.Secure = true
+	}
+	ncRepl, err := s.createNATSConn(replicationConnName, replOpts)
 	if err != nil {
 		return err
 	}
 	s.ncRepl = ncRepl
 
 	// NATS connection used for sending acks.
-	ncAcks, err := s.createNATSConn(acksConnName)
+	ncAcks, err := s.createNATSConn(acksConnName, clientOpts)
 	if err != nil {
 		return err
 	}
 	s.ncAcks = ncAcks
 
 	// NATS connection used for publishing messages.
-	ncPublishes, err := s.createNATSConn(publishesConnName)
+	ncPublishes, err := s.createNATSConn(publishesConnName, clientOpts)
 	if err != nil {
 		return err
 	}
@@ -423,7 +624,7 @@ func (s *Server) closeNATSConns() {
 
 // startAPIServer configures and starts the gRPC API server.
 func (s *Server) startAPIServer() error {
-	opts := []grpc.ServerOption{}
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(newDeliveryCodec())}
 
 	// Setup TLS if key/cert is set.
 	if s.config.TLSKey != "" && s.config.TLSCert != "" {
@@ -460,19 +661,39 @@ func (s *Server) startAPIServer() error {
 		opts = append(opts, grpc.Creds(creds))
 	}
 
+	// Tag requests with the client they arrived from and the listener they
+	// arrived on so that metadata requests return the addresses advertised
+	// for it.
+	opts = append(opts, grpc.StatsHandler(apiStatsHandler{}))
+
+	// Reject requests from clients connected over the connection limit and
+	// negotiate the API version with the rest. Errors get details clients can
+	// act on.
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(errorDetailsUnaryInterceptor, limitUnaryInterceptor,
+			apiVersionUnaryInterceptor),
+		grpc.ChainStreamInterceptor(errorDetailsStreamInterceptor, limitStreamInterceptor,
+			apiVersionStreamInterceptor))
+
 	grpcServer := grpc.NewServer(opts...)
 	s.grpcServer = grpcServer
-	s.api = &apiServer{s}
 	client.RegisterAPIServer(grpcServer, s.api)
 
 	health.Register(grpcServer)
 
+	if s.config.GRPCReflection {
+		reflection.Register(grpcServer)
+	}
+	if s.config.GRPCChannelz {
+		channelz.RegisterChannelzServiceToServer(grpcServer)
+	}
+
 	s.mu.Lock()
 	s.running = true
 	s.mu.Unlock()
 	s.startGoroutine(func() {
 		health.SetServing()
-		err := grpcServer.Serve(s.listener)
+		err := grpcServer.Serve(s.clients.listen(s.listener))
 		s.mu.Lock()
 		s.running = false
 		s.mu.Unlock()
@@ -486,13 +707,39 @@ func (s *Server) startAPIServer() error {
 		}
 	})
 
+	if s.unixListener != nil {
+		s.startGoroutine(func() {
+			if err := grpcServer.Serve(s.clients.listen(s.unixListener)); err != nil {
+				select {
+				case <-s.shutdownCh:
+					return
+				default:
+					s.logger.Fatal(err)
+				}
+			}
+		})
+	}
+
+	for _, listener := range s.listeners {
+		listener := listener
+		s.startGoroutine(func() {
+			if err := grpcServer.Serve(s.clients.listen(listener)); err != nil {
+				select {
+				case <-s.shutdownCh:
+					return
+				default:
+					s.logger.Fatal(err)
+				}
+			}
+		})
+	}
+
 	return nil
 }
 
-// createNATSConn creates a new NATS connection with the given name.
-func (s *Server) createNATSConn(name string) (*nats.Conn, error) {
+// createNATSConn creates a new NATS connection with the given name and options.
+func (s *Server) createNATSConn(name string, opts nats.Options) (*nats.Conn, error) {
 	var err error
-	opts := s.config.NATS
 	opts.Name = fmt.Sprintf("LIFT.%s.%s.%s", s.config.Clustering.Namespace, s.config.Clustering.ServerID, name)
 
 	// Shorten the time we wait to reconnect. Don't make it too short because
@@ -598,7 +845,7 @@ func (s *Server) leadershipAcquired(raft *raftNode) error {
 	}
 
 	// Subscribe to leader NATS subject for propagated requests.
-	sub, err := s.nc.Subscribe(s.getPropagateInbox(), s.handlePropagatedRequest)
+	sub, err := s.ncRaft.Subscribe(s.getPropagateInbox(), s.handlePropagatedRequest)
 	if err != nil {
 		return err
 	}
@@ -612,7 +859,33 @@ func (s *Server) leadershipAcquired(raft *raftNode) error {
 		return err
 	}
 
+	if err := s.createSchedulesStream(); err != nil {
+		return err
+	}
+
+	if err := s.createCanaryStream(); err != nil {
+		return err
+	}
+
+	if s.config.Streams.AutoDeleteTime > 0 {
+		stop := make(chan struct{})
+		s.autoDeleteStop = stop
+		s.startGoroutine(func() {
+			s.autoDeleteLoop(stop)
+		})
+	}
+
 	raft.setLeader(true)
+
+	// Fail over partitions led by servers which died before we became leader.
+	if s.gossip != nil {
+		for _, id := range s.gossip.deadMembers() {
+			id := id
+			s.startGoroutine(func() {
+				s.electLeadersForDeadServer(id)
+			})
+		}
+	}
 	return nil
 }
 
@@ -630,6 +903,11 @@ func (s *Server) leadershipLost(raft *raftNode) error {
 
 	s.metadata.LostLeadership()
 
+	if s.autoDeleteStop != nil {
+		close(s.autoDeleteStop)
+		s.autoDeleteStop = nil
+	}
+
 	if err := s.activity.BecomeFollower(); err != nil {
 		return err
 	}
@@ -676,6 +954,12 @@ func (s *Server) handlePropagatedRequest(m *nats.Msg) {
 		resp = s.handleResumeStream(req)
 	case proto.Op_SET_STREAM_READONLY:
 		resp = s.handleSetStreamReadonly(req)
+	case proto.Op_SET_STREAM_ALIAS:
+		resp = s.handleSetStreamAlias(req)
+	case proto.Op_RENAME_STREAM:
+		resp = s.handleRenameStream(req)
+	case proto.Op_SET_DERIVED_OFFSET:
+		resp = s.handleSetDerivedOffset(req)
 	default:
 		s.logger.Warnf("Unknown propagated request operation: %s", req.Op)
 		return
@@ -769,6 +1053,36 @@ func (s *Server) handleSetStreamReadonly(req *proto.PropagatedRequest) *proto.Pr
 	return resp
 }
 
+func (s *Server) handleSetStreamAlias(req *proto.PropagatedRequest) *proto.PropagatedResponse {
+	resp := &proto.PropagatedResponse{
+		Op: req.Op,
+	}
+	if err := s.metadata.SetStreamAlias(context.Background(), req.SetStreamAliasOp); err != nil {
+		resp.Error = &proto.Error{Code: uint32(err.Code()), Msg: err.Message()}
+	}
+	return resp
+}
+
+func (s *Server) handleRenameStream(req *proto.PropagatedRequest) *proto.PropagatedResponse {
+	resp := &proto.PropagatedResponse{
+		Op: req.Op,
+	}
+	if err := s.metadata.RenameStream(context.Background(), req.RenameStreamOp); err != nil {
+		resp.Error = &proto.Error{Code: uint32(err.Code()), Msg: err.Message()}
+	}
+	return resp
+}
+
+func (s *Server) handleSetDerivedOffset(req *proto.PropagatedRequest) *proto.PropagatedResponse {
+	resp := &proto.PropagatedResponse{
+		Op: req.Op,
+	}
+	if err := s.metadata.SetDerivedOffset(context.Background(), req.SetDerivedOffsetOp); err != nil {
+		resp.Error = &proto.Error{Code: uint32(err.Code()), Msg: err.Message()}
+	}
+	return resp
+}
+
 func (s *Server) isShutdown() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -842,12 +1156,7 @@ func (s *Server) handleServerInfoRequest(m *nats.Msg) {
 		return
 	}
 
-	connectionAddress := s.getConnectionAddress()
-	data, err := proto.MarshalServerInfoResponse(&proto.ServerInfoResponse{
-		Id:   s.config.Clustering.ServerID,
-		Host: connectionAddress.Host,
-		Port: int32(connectionAddress.Port),
-	})
+	data, err := proto.MarshalServerInfoResponse(s.serverInfo())
 	if err != nil {
 		panic(err)
 	}
@@ -857,6 +1166,23 @@ func (s *Server) handleServerInfoRequest(m *nats.Msg) {
 	}
 }
 
+// serverInfo returns the information the server reports about itself when
+// the cluster is surveyed.
+func (s *Server) serverInfo() *proto.ServerInfoResponse {
+	connectionAddress := s.getConnectionAddress()
+	return &proto.ServerInfoResponse{
+		Id:              s.config.Clustering.ServerID,
+		Host:            connectionAddress.Host,
+		Port:            int32(connectionAddress.Port),
+		Version:         Version,
+		Rack:            s.config.Clustering.Rack,
+		Serving:         s.isServing(),
+		AdminAddress:    s.adminAddress(),
+		Listeners:       s.advertisedListeners(),
+		MetadataVersion: s.metadataVersion,
+	}
+}
+
 // handlePartitionStatusRequest is a NATS handler used to process requests
 // querying the status of a partition. This is used as a readiness check to
 // determine if a created partition has actually started.
@@ -955,6 +1281,12 @@ func (s *Server) getCursorStreamSubject() string {
 	return fmt.Sprintf("%s.cursors", s.config.Clustering.Namespace)
 }
 
+// getSchedulesStreamSubject returns the NATS subject used for storing
+// scheduled publishes.
+func (s *Server) getSchedulesStreamSubject() string {
+	return fmt.Sprintf("%s.schedules", s.config.Clustering.Namespace)
+}
+
 // startGoroutine starts a goroutine which is managed by the server. This adds
 // the goroutine to a WaitGroup so that the server can wait for all running
 // goroutines to stop on shutdown. This should be used instead of a "naked"
@@ -988,3 +1320,67 @@ func (s *Server) startGoroutineWithArgs(f func(...interface{}), args ...interfac
 		s.goroutineWait.Done()
 	}()
 }
+
+// listenUnix binds a Unix domain socket at the given path and sets its file
+// permissions. A stale socket left behind by a previous process is removed,
+// but an error is returned if the path is in use or is not a socket. The
+// socket is bound in a private directory and linked into place once its
+// permissions are set, so it's never accessible with the permissions derived
+// from the process umask. The returned listener removes the socket when it's
+// closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	// TempDir creates the directory with 0700 permissions.
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	// Link rather than rename so a socket bound at the path meanwhile isn't
+	// replaced.
+	if err := os.Link(tmpPath, path); err != nil {
+		l.Close()
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%s is already in use", path)
+		}
+		return nil, err
+	}
+	return &unixListener{Listener: l, path: path}, nil
+}
+
+// unixListener is a net.Listener for a Unix domain socket which removes the
+// socket's path when it's closed.
+type unixListener struct {
+	net.Listener
+	path      string
+	closeOnce sync.Once
+}
+
+// Close closes the listener and removes the socket.
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() {
+		os.Remove(l.path)
+	})
+	return err
+}
@@ -4,21 +4,28 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/raft"
 	lift "github.com/liftbridge-io/go-liftbridge/v2"
+	client "github.com/liftbridge-io/liftbridge-api/go"
 	natsdTest "github.com/nats-io/nats-server/v2/test"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
 
 	proto "github.com/liftbridge-io/liftbridge/server/protocol"
 )
@@ -196,7 +203,7 @@ func forceLogClean(t *testing.T, subject, name string, s *Server) {
 	if partition == nil {
 		stackFatalf(t, "Stream not found")
 	}
-	if err := partition.log.Clean(); err != nil {
+	if err := partition.log.Clean(context.Background()); err != nil {
 		stackFatalf(t, "Log clean failed: %s", err)
 	}
 }
@@ -309,6 +316,116 @@ func TestHealthServerStartedCorrectly(t *testing.T) {
 	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, healthCheckReply.Status)
 }
 
+// Ensure the gRPC reflection and channelz services are only registered when
+// enabled.
+func TestGRPCDebugServices(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 20000)
+	s1Config.GRPCReflection = true
+	s1Config.GRPCChannelz = true
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	s2Config := getTestConfig("b", false, 20001)
+	s2 := runServerWithConfig(t, s2Config)
+	defer s2.Stop()
+
+	conn, err := grpc.Dial("127.0.0.1:20000", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := grpc_reflection_v1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&grpc_reflection_v1alpha.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1alpha.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	services := []string{}
+	for _, service := range resp.GetListServicesResponse().Service {
+		services = append(services, service.Name)
+	}
+	require.Contains(t, services, "proto.API")
+	require.Contains(t, services, "grpc.channelz.v1.Channelz")
+
+	servers, err := channelzpb.NewChannelzClient(conn).GetServers(
+		context.Background(), &channelzpb.GetServersRequest{})
+	require.NoError(t, err)
+	require.NotEmpty(t, servers.Server)
+
+	// The services are disabled by default.
+	conn2, err := grpc.Dial("127.0.0.1:20001", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn2.Close()
+
+	stream, err = grpc_reflection_v1alpha.NewServerReflectionClient(conn2).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// Ensure the API is served on a Unix domain socket with the configured
+// permissions when one is set.
+func TestUnixSocketListener(t *testing.T) {
+	defer cleanupStorage(t)
+
+	dir, err := ioutil.TempDir("", "liftbridge_socket_")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "liftbridge.sock")
+
+	// Leave a stale socket behind to ensure it is replaced.
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	s1Config := getTestConfig("a", true, 20000)
+	s1Config.UnixSocketPath = path
+	s1Config.UnixSocketMode = 0660
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0660), info.Mode().Perm())
+
+	conn, err := grpc.Dial("unix://"+path, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	healthClient := grpc_health_v1.NewHealthClient(conn)
+	resp, err := healthClient.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+
+	// A second server can't bind a socket that is in use.
+	s2Config := getTestConfig("b", false, 20001)
+	s2Config.UnixSocketPath = path
+	s2, err := RunServerWithConfig(s2Config)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "already in use")
+	s2.Stop()
+
+	// The socket is removed on shutdown.
+	s1.Stop()
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
+
+// Ensure a Unix socket path that is not a socket is not removed.
+func TestListenUnixNotSocket(t *testing.T) {
+	file, err := ioutil.TempFile("", "liftbridge_socket_")
+	require.NoError(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	_, err = listenUnix(file.Name(), 0600)
+	require.Error(t, err)
+	_, err = os.Stat(file.Name())
+	require.NoError(t, err)
+}
+
 // Ensure starting a cluster with auto configuration works when we start one
 // node in bootstrap mode.
 func TestBootstrapAutoConfig(t *testing.T) {
@@ -1959,3 +2076,136 @@ func TestRaftLogListener(t *testing.T) {
 		t.Fatal("Did not receive expected Raft logs")
 	}
 }
+
+// Ensure inter-broker traffic uses the internal NATS cluster when one is
+// configured while client traffic uses the client NATS cluster.
+func TestInternalNATS(t *testing.T) {
+	defer cleanupStorage(t)
+
+	ns := natsdTest.RunDefaultServer()
+	defer ns.Shutdown()
+	internalOpts := natsdTest.DefaultTestOptions
+	internalOpts.Port = 4322
+	internalNS := natsdTest.RunServer(&internalOpts)
+	defer internalNS.Shutdown()
+
+	var servers []*Server
+	for i, id := range []string{"a", "b"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		config.InternalNATS.Servers = []string{"nats://127.0.0.1:4322"}
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	getMetadataLeader(t, 10*time.Second, servers...)
+
+	// Each server connects to the client NATS for stream data, acks and
+	// publishes and to the internal NATS for Raft and replication.
+	require.Equal(t, 6, ns.NumClients())
+	require.Equal(t, 4, internalNS.NumClients())
+
+	// Requests are propagated to the metadata leader over the internal NATS.
+	conn, err := grpc.Dial("localhost:5051", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	waitForISR(t, 10*time.Second, "foo", 0, 2, servers...)
+
+	// Messages are published over the client NATS and replicated over the
+	// internal NATS.
+	leader := getPartitionLeader(t, 10*time.Second, "foo", 0, servers...)
+	conn, err = grpc.Dial(fmt.Sprintf("localhost:%d", leader.config.Port), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = client.NewAPIClient(conn).Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("hello"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+	for _, s := range servers {
+		partition := s.metadata.GetPartition("foo", 0)
+		require.Eventually(t, func() bool {
+			return partition.log.NewestOffset() == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+type recordingLogger struct {
+	dummyLogger
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *recordingLogger) record(msg string) {
+	r.mu.Lock()
+	r.msgs = append(r.msgs, msg)
+	r.mu.Unlock()
+}
+
+func (r *recordingLogger) Infof(format string, args ...interface{}) {
+	r.record(fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) {
+	r.record(fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) logged(prefix string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range r.msgs {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ensure the server logs through the configured Logger and suppresses
+// messages while replaying the Raft log unless LogRecovery is enabled.
+func TestServerConfigLogger(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.LogSilent = false
+	log1 := &recordingLogger{}
+	config.Logger = log1
+	s := runServerWithConfig(t, config)
+	getMetadataLeader(t, 10*time.Second, s)
+	require.True(t, log1.logged("Liftbridge Version"))
+
+	client, err := lift.Connect([]string{"localhost:5050"})
+	require.NoError(t, err)
+	require.NoError(t, client.CreateStream(context.Background(), "foo", "foo"))
+	client.Close()
+	require.True(t, log1.logged("fsm: Created stream"))
+	s.Stop()
+
+	// Replaying the Raft log on restart is not logged.
+	config.Clustering.RaftBootstrapSeed = false
+	log2 := &recordingLogger{}
+	config.Logger = log2
+	s = runServerWithConfig(t, config)
+	getMetadataLeader(t, 10*time.Second, s)
+	require.True(t, log2.logged("Liftbridge Version"))
+	require.False(t, log2.logged("fsm: Created stream"))
+	s.Stop()
+
+	config.LogRecovery = true
+	log3 := &recordingLogger{}
+	config.Logger = log3
+	s = runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+	require.True(t, log3.logged("fsm: Created stream"))
+}
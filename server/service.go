@@ -0,0 +1,16 @@
+// +build !windows
+
+package server
+
+import "github.com/pkg/errors"
+
+// IsWindowsService indicates if the process is running as a Windows service,
+// which is never the case on this platform.
+func IsWindowsService() (bool, error) {
+	return false, nil
+}
+
+// RunWindowsService is only supported on Windows.
+func RunWindowsService(name string, config *Config) error {
+	return errors.New("Windows services are not supported on this platform")
+}
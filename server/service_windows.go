@@ -0,0 +1,77 @@
+// +build windows
+
+package server
+
+import (
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// serviceStopTimeout bounds how long a Windows service spends handing off
+// its leaderships when stopped. The service control manager gives services
+// about 20 seconds to stop on system shutdown.
+const serviceStopTimeout = 15 * time.Second
+
+// serviceLogFile is the file in the data directory log messages are written
+// to when running as a Windows service and no log file is configured.
+const serviceLogFile = "liftbridge.log"
+
+// IsWindowsService indicates if the process is running as a Windows service.
+func IsWindowsService() (bool, error) {
+	return svc.IsWindowsService()
+}
+
+// RunWindowsService runs a Server with the given configuration as the named
+// Windows service. It returns once the service control manager stops the
+// service, which gracefully stops the Server. Since services have no console,
+// log messages are written to liftbridge.log in the data directory unless a
+// log file is configured.
+func RunWindowsService(name string, config *Config) error {
+	return svc.Run(name, &windowsService{config: config})
+}
+
+// windowsService handles the requests of the Windows service control manager
+// for a Server.
+type windowsService struct {
+	config *Config
+}
+
+// Execute starts the Server and runs until the service is stopped or the
+// system shuts down.
+func (w *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest,
+	changes chan<- svc.Status) (bool, uint32) {
+
+	changes <- svc.Status{State: svc.StartPending}
+	server := New(w.config)
+	if server.config.LogFile == "" && !server.config.LogSilent {
+		server.config.LogFile = filepath.Join(server.config.DataDir, serviceLogFile)
+	}
+	if err := server.Start(); err != nil {
+		server.logger.Errorf("Failed to start Liftbridge service: %v", err)
+		return true, 1
+	}
+
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			changes <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			changes <- svc.Status{
+				State:    svc.StopPending,
+				WaitHint: uint32((serviceStopTimeout + 5*time.Second) / time.Millisecond),
+			}
+			server.logger.Info("Liftbridge service stopping")
+			if err := server.GracefulStop(serviceStopTimeout); err != nil {
+				return true, 2
+			}
+			return false, 0
+		default:
+			server.logger.Warnf("Unexpected Windows service control request %d", req.Cmd)
+		}
+	}
+	return false, 0
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// SnapshotOfMetadata is the CreateStream request metadata key used to create
+// the stream as a snapshot of the existing stream with the given name. The
+// cluster populates the snapshot stream with the latest message for each key
+// committed to the source stream when the snapshot stream was created and
+// then makes it readonly, so that consumers can bootstrap the state of a
+// compacted stream without replaying all of it.
+const SnapshotOfMetadata = "liftbridge-snapshot-of"
+
+// applySnapshotMetadata sets the snapshot stream settings of a CreateStream
+// request from the request metadata on the given StreamConfig.
+func applySnapshotMetadata(md metadata.MD, config *proto.StreamConfig) *status.Status {
+	snapshotOf := md.Get(SnapshotOfMetadata)
+	if len(snapshotOf) == 0 {
+		return nil
+	}
+	if snapshotOf[0] == "" {
+		return status.Newf(codes.InvalidArgument, "Invalid %s value %q", SnapshotOfMetadata, snapshotOf[0])
+	}
+	if config.SampleOf != "" || config.DeriveFrom != "" {
+		return status.Newf(codes.InvalidArgument, "%s cannot be used with %s or %s",
+			SnapshotOfMetadata, SampleOfMetadata, DeriveFromMetadata)
+	}
+	config.SnapshotOf = snapshotOf[0]
+	return nil
+}
+
+// ensureSnapshotSource verifies the source stream of a snapshot stream exists
+// and can be snapshotted. Aliases are resolved to the stream's name.
+func (a *apiServer) ensureSnapshotSource(config *proto.StreamConfig) *status.Status {
+	if config.SnapshotOf == "" {
+		return nil
+	}
+	source := a.metadata.GetStream(config.SnapshotOf)
+	if source == nil {
+		return streamNotFoundStatus(codes.NotFound, fmt.Sprintf("No such stream: %s", config.SnapshotOf),
+			config.SnapshotOf)
+	}
+	if isInternalStream(source.GetName()) {
+		return status.Newf(codes.InvalidArgument, "Cannot snapshot internal stream %s", source.GetName())
+	}
+	config.SnapshotOf = source.GetName()
+	return nil
+}
+
+// snapshotPartition publishes the latest message for each key committed to
+// the given source partition to a snapshot stream, then checkpoints the high
+// watermark the snapshot was taken at. Once every source partition has been
+// checkpointed, the leader of source partition 0 makes the snapshot stream
+// readonly. If leadership changes before the checkpoint, the next leader takes
+// the snapshot again, so messages may be published more than once.
+func (a *apiServer) snapshotPartition(ctx context.Context, p *partition, snapshot *stream) {
+	if _, ok := snapshot.GetDerivedOffset(p.Id); !ok {
+		hw := p.log.HighWatermark()
+		latest, st := latestMessagesByKey(ctx, p, hw)
+		if st != nil {
+			if ctx.Err() == nil && st.Code() == codes.Internal {
+				a.logger.Errorf("Failed to snapshot partition %s: %v", p, st.Message())
+			}
+			return
+		}
+		for _, msg := range latest {
+			req := derivedMessage(snapshot, msg)
+			for !a.republish(req, "snapshot") {
+				if !sleepContext(ctx, deriveRetryInterval) {
+					return
+				}
+			}
+		}
+		for !a.checkpointDerivedOffset(snapshot, p.Id, hw) {
+			if !sleepContext(ctx, deriveRetryInterval) {
+				return
+			}
+		}
+	}
+	if p.Id != 0 {
+		return
+	}
+
+	// Wait for the other source partitions to be snapshotted before ending
+	// the snapshot stream.
+	for !a.isSnapshotComplete(p.Stream, snapshot) {
+		if !sleepContext(ctx, deriveRetryInterval) {
+			return
+		}
+	}
+	for !isStreamReadonly(snapshot) {
+		req := &proto.SetStreamReadonlyOp{Stream: snapshot.GetName(), Readonly: true}
+		if st := a.metadata.SetStreamReadonly(ctx, req); st != nil {
+			if st.Code() == codes.NotFound {
+				return
+			}
+			a.logger.Warnf("Failed to set snapshot stream %s readonly: %v", snapshot.GetName(), st.Message())
+		}
+		if !sleepContext(ctx, deriveRetryInterval) {
+			return
+		}
+	}
+}
+
+// latestMessagesByKey reads the given partition up to the given offset and
+// returns the latest message for each key ordered by offset. Messages without
+// a key and keys whose latest message is a tombstone are skipped.
+func latestMessagesByKey(ctx context.Context, p *partition, hw int64) ([]*client.Message, *status.Status) {
+	if hw < 0 {
+		return nil, nil
+	}
+	start := p.log.OldestOffset()
+	if start < 0 {
+		start = 0
+	}
+	reader, err := p.log.NewReader(start, false)
+	if err != nil {
+		return nil, status.New(codes.Internal, err.Error())
+	}
+	var (
+		latest     = make(map[string]*client.Message)
+		headersBuf = make([]byte, 28)
+	)
+	for {
+		msg, st := readSubscriptionMessage(ctx, p, reader, headersBuf)
+		if st != nil {
+			return nil, st
+		}
+		if isTombstone(msg.Key, msg.Value) {
+			delete(latest, string(msg.Key))
+		} else if len(msg.Key) > 0 {
+			latest[string(msg.Key)] = msg
+		}
+		if msg.Offset >= hw {
+			break
+		}
+	}
+	msgs := make([]*client.Message, 0, len(latest))
+	for _, msg := range latest {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].Offset < msgs[j].Offset
+	})
+	return msgs, nil
+}
+
+// isSnapshotComplete indicates if every partition of the given source stream
+// has been checkpointed in the snapshot stream.
+func (a *apiServer) isSnapshotComplete(source string, snapshot *stream) bool {
+	sourceStream := a.metadata.GetStream(source)
+	if sourceStream == nil {
+		return false
+	}
+	for id := range sourceStream.GetPartitions() {
+		if _, ok := snapshot.GetDerivedOffset(id); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isStreamReadonly indicates if all partitions of the stream are readonly.
+func isStreamReadonly(stream *stream) bool {
+	for _, partition := range stream.GetPartitions() {
+		if !partition.IsReadonly() {
+			return false
+		}
+	}
+	return true
+}
+
+// sleepContext waits for the given duration. It returns false if the context
+// was canceled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
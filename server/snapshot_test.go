@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure applySnapshotMetadata validates the snapshot stream settings.
+func TestApplySnapshotMetadata(t *testing.T) {
+	config := new(proto.StreamConfig)
+	require.Nil(t, applySnapshotMetadata(metadata.MD{}, config))
+	require.Equal(t, "", config.SnapshotOf)
+
+	require.Nil(t, applySnapshotMetadata(metadata.Pairs(SnapshotOfMetadata, "foo"), config))
+	require.Equal(t, "foo", config.SnapshotOf)
+
+	for _, config := range []*proto.StreamConfig{
+		{SampleOf: "foo"},
+		{DeriveFrom: "foo"},
+	} {
+		st := applySnapshotMetadata(metadata.Pairs(SnapshotOfMetadata, "foo"), config)
+		require.NotNil(t, st)
+		require.Equal(t, codes.InvalidArgument, st.Code())
+	}
+
+	st := applySnapshotMetadata(metadata.Pairs(SnapshotOfMetadata, ""), new(proto.StreamConfig))
+	require.NotNil(t, st)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+}
+
+// Ensure a snapshot stream receives the latest message for each key in its
+// source stream and is made readonly once complete.
+func TestSnapshotStream(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo", Partitions: 2})
+	require.NoError(t, err)
+
+	expected := make(map[string]string)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i%10)
+		value := strconv.Itoa(i)
+		_, err = api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Partition: int32(i % 2),
+			Key:       []byte(key),
+			Value:     []byte(value),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+		expected[key] = value
+	}
+	// Messages without a key are not part of the snapshot.
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Value:     []byte("keyless"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+	// Deleted keys are not part of the snapshot.
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Partition: 1,
+		Key:       []byte("key-3"),
+		Headers:   map[string][]byte{TombstoneHeader: nil},
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+	delete(expected, "key-3")
+
+	_, err = api.CreateStream(
+		metadata.AppendToOutgoingContext(ctx, SnapshotOfMetadata, "foo"),
+		&client.CreateStreamRequest{Subject: "foo-snapshot", Name: "foo-snapshot"},
+	)
+	require.NoError(t, err)
+	snapshot := s1.metadata.GetStream("foo-snapshot")
+	require.NotNil(t, snapshot)
+	require.Equal(t, "foo", snapshot.GetConfig().SnapshotOf)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !isStreamReadonly(snapshot) {
+		if time.Now().After(deadline) {
+			t.Fatal("Snapshot stream was not made readonly")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Messages published after the snapshot was taken are not included.
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:    "foo",
+		Key:       []byte("key-0"),
+		Value:     []byte("after"),
+		AckPolicy: client.AckPolicy_ALL,
+	})
+	require.NoError(t, err)
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo-snapshot",
+		StartPosition: client.StartPosition_EARLIEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+
+	received := make(map[string]string)
+	for {
+		msg, err := sub.Recv()
+		if err != nil {
+			require.Equal(t, codes.ResourceExhausted, status.Code(err))
+			break
+		}
+		_, ok := received[string(msg.Key)]
+		require.False(t, ok, string(msg.Key))
+		received[string(msg.Key)] = string(msg.Value)
+	}
+	require.Equal(t, expected, received)
+}
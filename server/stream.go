@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -11,24 +12,29 @@ import (
 // stream is a message stream consisting of one or more partitions. Each
 // partition maps to a NATS subject and is the unit of replication.
 type stream struct {
-	name         string
-	subject      string
-	config       *proto.StreamConfig
-	partitions   map[int32]*partition
-	resumeAll    bool // When partition(s) are paused, this indicates if all should be resumed
-	creationTime time.Time
-	mu           sync.RWMutex
+	name           string
+	subject        string
+	config         *proto.StreamConfig
+	partitions     map[int32]*partition
+	resumeAll      bool // When partition(s) are paused, this indicates if all should be resumed
+	creationTime   time.Time
+	aliases        map[string]struct{}
+	displayName    string          // Set when the stream is renamed to one of its aliases
+	derivedOffsets map[int32]int64 // Last source partition offsets republished to a derived stream
+	mu             sync.RWMutex
 }
 
 // newStream creates a stream for the given NATS subject. All stream
 // interactions should only go through the exported functions.
 func newStream(name, subject string, config *proto.StreamConfig, creationTime time.Time) *stream {
 	return &stream{
-		name:         name,
-		subject:      subject,
-		config:       config,
-		partitions:   make(map[int32]*partition),
-		creationTime: creationTime,
+		name:           name,
+		subject:        subject,
+		config:         config,
+		partitions:     make(map[int32]*partition),
+		creationTime:   creationTime,
+		aliases:        make(map[string]struct{}),
+		derivedOffsets: make(map[int32]int64),
 	}
 }
 
@@ -47,6 +53,19 @@ func (s *stream) GetName() string {
 	return s.name
 }
 
+// GetDisplayName returns the name the stream is listed under. This is the
+// stream's name unless it has been renamed, in which case it's the alias the
+// stream was renamed to. Data and cursors are still stored under the stream's
+// name.
+func (s *stream) GetDisplayName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.displayName != "" {
+		return s.displayName
+	}
+	return s.name
+}
+
 // GetSubject returns the stream's NATS subject.
 func (s *stream) GetSubject() string {
 	s.mu.RLock()
@@ -61,6 +80,91 @@ func (s *stream) GetConfig() *proto.StreamConfig {
 	return s.config
 }
 
+// GetAliases returns the alternate names the stream can be referenced by in
+// sorted order.
+func (s *stream) GetAliases() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	aliases := make([]string, 0, len(s.aliases))
+	for alias := range s.aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// HasAlias indicates if the given name is an alias of the stream.
+func (s *stream) HasAlias(alias string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.aliases[alias]
+	return ok
+}
+
+// addAlias adds an alternate name for the stream. This should only be called
+// by the metadataAPI, which maintains the index of aliases.
+func (s *stream) addAlias(alias string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aliases[alias] = struct{}{}
+}
+
+// removeAlias removes an alternate name for the stream. This should only be
+// called by the metadataAPI, which maintains the index of aliases.
+func (s *stream) removeAlias(alias string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.aliases, alias)
+}
+
+// setDisplayName sets the name the stream is listed under, which must be the
+// stream's name or one of its aliases. This should only be called by the
+// metadataAPI, which maintains the index of aliases.
+func (s *stream) setDisplayName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == s.name {
+		name = ""
+	}
+	s.displayName = name
+}
+
+// GetDerivedOffset returns the last offset of the given source stream
+// partition republished to this derived stream. The bool indicates if any
+// messages from the partition have been republished.
+func (s *stream) GetDerivedOffset(partition int32) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	offset, ok := s.derivedOffsets[partition]
+	return offset, ok
+}
+
+// GetDerivedOffsets returns the derived stream progress for each source
+// stream partition ordered by partition ID.
+func (s *stream) GetDerivedOffsets() []*proto.DerivedOffset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	offsets := make([]*proto.DerivedOffset, 0, len(s.derivedOffsets))
+	for partition, offset := range s.derivedOffsets {
+		offsets = append(offsets, &proto.DerivedOffset{Partition: partition, Offset: offset})
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i].Partition < offsets[j].Partition
+	})
+	return offsets
+}
+
+// setDerivedOffset records the last offset of the given source stream
+// partition republished to this derived stream. Offsets only move forward.
+func (s *stream) setDerivedOffset(partition int32, offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, ok := s.derivedOffsets[partition]; ok && current >= offset {
+		return
+	}
+	s.derivedOffsets[partition] = offset
+}
+
 // GetResumeAll returns a bool indicating if the stream was paused with
 // ResumeAll enabled. This means a message published to any of the stream's
 // partitions will resume any paused partitions.
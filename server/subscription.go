@@ -0,0 +1,467 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// subscriptionOptions are the delivery features a subscription requests in
+// its metadata. They are parsed and checked together before the subscription
+// is created, so that invalid combinations are rejected before anything is
+// done for the subscription. Features combine freely except that:
+//
+//   - queue subscriptions can't filter by key, deliver by priority, start from
+//     or auto-commit a cursor, or stop at a position, since the queue decides
+//     which messages each consumer receives and tracks its own progress,
+//   - auto-committing subscriptions can't deliver by priority, since the
+//     cursor only advances in offset order.
+//
+// Queue subscriptions deliver messages uncompressed, so no encoding is
+// negotiated for them, and a compression dictionary is only used with zstd.
+// Credit only applies to subscriptions made through the gRPC API.
+type subscriptionOptions struct {
+	filter           *keyFilter           // Only deliver messages with matching keys
+	priorityWindow   int                  // Deliver windows of this many messages by priority
+	startCursor      string               // Start after this cursor's position
+	autoCommitCursor string               // Commit this cursor as messages are delivered
+	atLeastOnce      bool                 // Only commit the cursor as messages are acked
+	queueName        string               // Consume the partition as this work queue
+	queueOptions     queueOptions         // Settings of the work queue
+	encoder          *subscriptionEncoder // Compresses delivered messages
+	credit           *subscriptionCredit  // Only deliver messages there is credit for
+}
+
+// subscriptionOptionsFromContext parses the delivery features requested in
+// the incoming Subscribe request metadata and checks they can be combined.
+func (a *apiServer) subscriptionOptionsFromContext(ctx context.Context, req *client.SubscribeRequest) (
+	*subscriptionOptions, *status.Status) {
+
+	var (
+		opts = new(subscriptionOptions)
+		st   *status.Status
+	)
+	if opts.filter, st = keyFilterFromContext(ctx); st != nil {
+		return nil, st
+	}
+	if opts.priorityWindow, st = priorityWindowFromContext(ctx, a.config.SubscriptionPriorityWindowMax); st != nil {
+		return nil, st
+	}
+	opts.startCursor = startCursorFromContext(ctx)
+	if opts.autoCommitCursor, opts.atLeastOnce, st = autoCommitFromContext(ctx); st != nil {
+		return nil, st
+	}
+	if opts.queueName, opts.queueOptions, st = queueFromContext(ctx); st != nil {
+		return nil, st
+	}
+	if opts.credit, st = creditFromContext(ctx); st != nil {
+		return nil, st
+	}
+	if st := opts.check(req); st != nil {
+		return nil, st
+	}
+
+	// Subscriptions auto-committing a cursor resume from it by default.
+	if opts.startCursor == "" {
+		opts.startCursor = opts.autoCommitCursor
+	}
+	if opts.queueName == "" {
+		opts.encoder = encoderFromContext(ctx)
+	}
+	return opts, nil
+}
+
+// check returns an InvalidArgument status if the options can't be combined.
+func (o *subscriptionOptions) check(req *client.SubscribeRequest) *status.Status {
+	if o.queueName != "" {
+		if o.filter != nil || o.priorityWindow > 0 || o.startCursor != "" || o.autoCommitCursor != "" {
+			return status.New(codes.InvalidArgument,
+				"Queue subscriptions cannot filter by key, deliver by priority, or start from "+
+					"or auto-commit a cursor")
+		}
+		if req.StopPosition != client.StopPosition_STOP_ON_CANCEL {
+			return status.New(codes.InvalidArgument,
+				"Queue subscriptions do not support stop positions")
+		}
+	}
+	if o.autoCommitCursor != "" && o.priorityWindow > 0 {
+		return status.New(codes.InvalidArgument,
+			"Auto-committing subscriptions cannot deliver by priority")
+	}
+	return nil
+}
+
+// readAhead returns the number of messages a subscription reads ahead while
+// behind the high watermark, which it does to deliver by priority or to
+// compress batches of messages. It returns 0 if it doesn't read ahead.
+func (o *subscriptionOptions) readAhead() int {
+	if o.priorityWindow > 0 {
+		return o.priorityWindow
+	}
+	if o.encoder.compresses() {
+		return maxEncodedBatchMessages
+	}
+	return 0
+}
+
+// subscription delivers a partition's messages to a subscriber through a
+// pipeline of stages, each implementing one delivery feature:
+//
+//   - snapshot: if the key filter asks for the latest message per key, the
+//     latest matching messages up to the high watermark are delivered first,
+//     in offset order,
+//   - read-ahead: if the subscription reads ahead, windows of messages are
+//     read while behind the high watermark and delivered together, highest
+//     priority first if requested, which lets the encoder compress them in
+//     batches,
+//   - tail: otherwise messages are delivered one at a time, and once caught
+//     up, the subscription is attached to the partition's dispatcher, which
+//     resumes the pipeline if the subscription falls behind.
+//
+// Every stage filters messages by key, holds subscription budget for
+// messages from when they're read until they're sent, and sends them through
+// the encoder.
+type subscription struct {
+	api         *apiServer
+	partition   *partition
+	opts        *subscriptionOptions
+	ch          chan *client.Message
+	errCh       chan *status.Status
+	cancel      chan struct{}
+	readCtx     context.Context // Done when the subscription is canceled
+	readAhead   int             // Messages read ahead per window
+	stopOffset  int64
+	snapshotEnd int64 // Last offset of the snapshot, -1 if there is none
+	dispatched  *dispatchedSubscription
+	doneOnce    sync.Once
+}
+
+// newSubscription returns a subscription delivering the partition's messages
+// up to the stop offset. It counts as one of the partition's subscribers
+// until it ends.
+func (a *apiServer) newSubscription(ctx context.Context, partition *partition, opts *subscriptionOptions,
+	stopOffset int64, cancel chan struct{}) *subscription {
+
+	s := &subscription{
+		api:         a,
+		partition:   partition,
+		opts:        opts,
+		ch:          make(chan *client.Message, subscriptionBufferSize),
+		errCh:       make(chan *status.Status),
+		cancel:      cancel,
+		readAhead:   opts.readAhead(),
+		stopOffset:  stopOffset,
+		snapshotEnd: -1,
+		// Reads stop as soon as the subscription is canceled, not only
+		// when its context is done, so that they release their log
+		// waiters right away rather than on the next write to the
+		// partition.
+		readCtx: a.contextWithCancel(ctx, cancel),
+	}
+	if opts.filter != nil && opts.filter.latestPerKey {
+		s.snapshotEnd = partition.log.HighWatermark()
+		if stopOffset != waitForNewMessages && stopOffset < s.snapshotEnd {
+			s.snapshotEnd = stopOffset
+		}
+	}
+	s.dispatched = &dispatchedSubscription{
+		ch:         s.ch,
+		cancel:     cancel,
+		filter:     opts.filter,
+		encoder:    opts.encoder,
+		stopOffset: stopOffset,
+		sendErr: func(st *status.Status) {
+			a.startGoroutine(func() {
+				s.sendErr(st)
+				s.done()
+			})
+		},
+		done: s.done,
+		resume: func(offset int64) {
+			reader, err := partition.log.NewReader(offset, false)
+			if err != nil {
+				a.startGoroutine(func() {
+					s.sendErr(status.New(codes.Internal,
+						fmt.Sprintf("Failed to create stream reader: %v", err)))
+					s.done()
+				})
+				return
+			}
+			s.start(reader, offset)
+		},
+	}
+	partition.IncreaseSubscriberCount()
+	return s
+}
+
+// done is called once the subscription ends.
+func (s *subscription) done() {
+	s.doneOnce.Do(s.partition.DecreaseSubscriberCount)
+}
+
+// sendErr sends the status ending the subscription unless it's canceled
+// first.
+func (s *subscription) sendErr(st *status.Status) {
+	select {
+	case s.errCh <- st:
+	case <-s.cancel:
+	}
+}
+
+// start runs the pipeline in its own goroutine, reading messages from the
+// reader starting at the given offset.
+func (s *subscription) start(reader *commitlog.Reader, offset int64) {
+	s.api.startGoroutine(func() {
+		run := &subscriptionRun{
+			subscription: s,
+			reader:       reader,
+			nextOffset:   offset,
+			budget:       s.api.subscriptionBudget,
+			headersBuf:   make([]byte, 28),
+			window:       make([]*client.Message, 0, s.readAhead),
+		}
+		defer run.release()
+		if !run.run() {
+			s.done()
+		}
+	})
+}
+
+// stopOffsetReached returns the status ending a subscription which delivered
+// the message at its stop offset.
+func stopOffsetReached() *status.Status {
+	return status.New(codes.ResourceExhausted, "Stop offset reached")
+}
+
+// subscriptionRun is one run of a subscription's pipeline, which reads from
+// the log on its own until the subscription ends or is attached to the
+// partition's dispatcher. A subscription's runs never overlap.
+type subscriptionRun struct {
+	*subscription
+	reader     *commitlog.Reader
+	nextOffset int64
+	budget     *subscriptionBudget
+	held       int64 // Budget held by messages read but not yet sent
+	headersBuf []byte
+	window     []*client.Message
+}
+
+// run delivers messages until the subscription ends, in which case it
+// returns false, or it's attached to the dispatcher, in which case it returns
+// true.
+func (r *subscriptionRun) run() bool {
+	if r.snapshotEnd >= r.nextOffset {
+		if !r.deliverSnapshot() {
+			return false
+		}
+		if r.snapshotEnd == r.stopOffset {
+			r.sendErr(stopOffsetReached())
+			return false
+		}
+	}
+
+	// pending is a message read ahead which did not fit in the subscription
+	// budget and is carried over to the next window.
+	var pending *client.Message
+	for {
+		msg := pending
+		pending = nil
+		if msg == nil {
+			// Once caught up, wait for new messages on the partition's
+			// dispatcher rather than in this goroutine.
+			if r.readAhead == 0 && r.nextOffset > r.partition.log.HighWatermark() &&
+				r.partition.dispatcher.attach(r.dispatched, r.nextOffset) {
+				return true
+			}
+			var st *status.Status
+			if msg, st = r.next(); st != nil {
+				r.sendErr(st)
+				return false
+			}
+		}
+		if r.readAhead > 0 {
+			var ok bool
+			if msg, pending, ok = r.deliverWindow(msg); !ok {
+				return false
+			}
+		} else if r.opts.filter.matches(msg.Key) {
+			if !r.waitFor(msg) || !r.send(msg) {
+				return false
+			}
+		}
+		if pending == nil && msg.Offset == r.stopOffset {
+			r.sendErr(stopOffsetReached())
+			return false
+		}
+	}
+}
+
+// deliverSnapshot delivers the latest message for each matching key up to
+// the end of the snapshot. It finds their offsets and then reads the messages
+// again to send them in offset order, so the snapshot is not buffered. It
+// returns false if the subscription ended.
+func (r *subscriptionRun) deliverSnapshot() bool {
+	latest := make(map[string]int64)
+	for {
+		msg, st := r.next()
+		if st != nil {
+			r.sendErr(st)
+			return false
+		}
+		if msg.Key != nil && r.opts.filter.matches(msg.Key) {
+			if isTombstone(msg.Key, msg.Value) {
+				delete(latest, string(msg.Key))
+			} else {
+				latest[string(msg.Key)] = msg.Offset
+			}
+		}
+		if msg.Offset >= r.snapshotEnd {
+			break
+		}
+	}
+	if len(latest) == 0 {
+		return true
+	}
+	offsets := make([]int64, 0, len(latest))
+	for _, offset := range latest {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+	var batch []*client.Message
+	ok, st := readSnapshotMessages(r.readCtx, r.partition, offsets, r.snapshotEnd, r.headersBuf,
+		func(msg *client.Message) bool {
+			if len(batch) > 0 && (len(batch) >= r.readAhead || !r.tryHold(msg)) {
+				if !r.send(batch...) {
+					return false
+				}
+				batch = batch[:0]
+			}
+			if len(batch) == 0 && !r.waitFor(msg) {
+				return false
+			}
+			batch = append(batch, msg)
+			return true
+		})
+	if st != nil {
+		r.sendErr(st)
+		return false
+	}
+	return ok && r.send(batch...)
+}
+
+// deliverWindow reads ahead from the given message up to the read-ahead
+// window, stopping early at the high watermark, the stop offset, or when the
+// subscription budget is exhausted, and delivers the matching messages
+// together, highest priority first if requested. It returns the last message
+// read and, if it didn't fit in the budget, that message to deliver in the
+// next window. It returns false if the subscription ended.
+func (r *subscriptionRun) deliverWindow(msg *client.Message) (*client.Message, *client.Message, bool) {
+	var (
+		pending *client.Message
+		st      *status.Status
+	)
+	r.window = r.window[:0]
+	if r.opts.filter.matches(msg.Key) {
+		if !r.waitFor(msg) {
+			return nil, nil, false
+		}
+		r.window = append(r.window, msg)
+	}
+	for len(r.window) < r.readAhead && msg.Offset != r.stopOffset &&
+		msg.Offset < r.partition.log.HighWatermark() {
+		msg, st = r.next()
+		if st != nil {
+			break
+		}
+		if !r.opts.filter.matches(msg.Key) {
+			continue
+		}
+		if len(r.window) == 0 {
+			if !r.waitFor(msg) {
+				return nil, nil, false
+			}
+		} else if !r.tryHold(msg) {
+			pending = msg
+			break
+		}
+		r.window = append(r.window, msg)
+	}
+	if r.opts.priorityWindow > 0 {
+		sortByPriority(r.window)
+	}
+	if !r.send(r.window...) {
+		return nil, nil, false
+	}
+	if st != nil {
+		r.sendErr(st)
+		return nil, nil, false
+	}
+	return msg, pending, true
+}
+
+// next reads the next message from the log.
+func (r *subscriptionRun) next() (*client.Message, *status.Status) {
+	msg, st := readSharedSubscriptionMessage(r.readCtx, r.partition, r.reader, r.headersBuf)
+	if st != nil {
+		return nil, st
+	}
+	r.nextOffset = msg.Offset + 1
+	return msg, nil
+}
+
+// waitFor reserves budget for the first message of a batch, waiting for room.
+// It returns false if the subscription is canceled first.
+func (r *subscriptionRun) waitFor(msg *client.Message) bool {
+	n := messageBytes(msg)
+	if !r.budget.acquire(n, r.cancel) {
+		return false
+	}
+	r.held += n
+	return true
+}
+
+// tryHold reserves budget for a message read ahead of the first in a batch.
+// It returns false if there is no room, in which case the message is left for
+// the next batch.
+func (r *subscriptionRun) tryHold(msg *client.Message) bool {
+	n := messageBytes(msg)
+	if !r.budget.tryAcquire(n) {
+		return false
+	}
+	r.held += n
+	return true
+}
+
+// release releases the budget held by messages which were not sent.
+func (r *subscriptionRun) release() {
+	r.budget.release(r.held)
+	r.held = 0
+}
+
+// send encodes the messages and sends them to the subscriber, releasing the
+// budget they hold. It returns false if the subscription ended.
+func (r *subscriptionRun) send(msgs ...*client.Message) bool {
+	defer r.release()
+	msgs, err := r.opts.encoder.encode(msgs)
+	if err != nil {
+		r.sendErr(status.New(codes.Internal, err.Error()))
+		return false
+	}
+	for _, msg := range msgs {
+		select {
+		case r.ch <- msg:
+		case <-r.cancel:
+			return false
+		}
+	}
+	return true
+}
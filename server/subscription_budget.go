@@ -0,0 +1,92 @@
+package server
+
+import (
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// subscriptionBudget limits the bytes of messages subscriptions have read from
+// partition logs but not yet sent, e.g. messages read ahead for priority
+// delivery or compression, across all subscriptions on the server. A
+// subscription waits for budget before reading more, which applies
+// backpressure so that many slow subscribers cannot exhaust memory. Messages
+// which don't fit in the budget are left in the log and read again later. A
+// nil subscriptionBudget is unlimited.
+type subscriptionBudget struct {
+	max   int64
+	mu    sync.Mutex
+	used  int64
+	freed chan struct{} // closed when bytes are released
+}
+
+// newSubscriptionBudget returns a subscriptionBudget of the given number of
+// bytes or nil if max is not positive.
+func newSubscriptionBudget(max int64) *subscriptionBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &subscriptionBudget{max: max, freed: make(chan struct{})}
+}
+
+// tryAcquire reserves n bytes of the budget if they are available and
+// indicates if they were.
+func (b *subscriptionBudget) tryAcquire(n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reserve(n)
+}
+
+// acquire reserves n bytes of the budget, waiting until they are available.
+// It returns false if cancel is closed first.
+func (b *subscriptionBudget) acquire(n int64, cancel <-chan struct{}) bool {
+	if b == nil {
+		return true
+	}
+	for {
+		b.mu.Lock()
+		if b.reserve(n) {
+			b.mu.Unlock()
+			return true
+		}
+		freed := b.freed
+		b.mu.Unlock()
+		select {
+		case <-freed:
+		case <-cancel:
+			return false
+		}
+	}
+}
+
+// reserve reserves n bytes if they are available. A message larger than the
+// whole budget is admitted when nothing else is reserved so that it does not
+// wait forever. The caller must hold the lock.
+func (b *subscriptionBudget) reserve(n int64) bool {
+	if b.used > 0 && b.used+n > b.max {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// release returns n bytes reserved with acquire or tryAcquire to the budget.
+func (b *subscriptionBudget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// messageBytes returns the number of bytes of the budget used by the given
+// message.
+func messageBytes(msg *client.Message) int64 {
+	return int64(msg.Size())
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure subscriptionBudget limits reserved bytes, admits an oversized
+// reservation when nothing is reserved, and wakes waiters on release.
+func TestSubscriptionBudget(t *testing.T) {
+	var unlimited *subscriptionBudget
+	require.True(t, unlimited.tryAcquire(1<<40))
+	unlimited.release(1 << 40)
+	require.Nil(t, newSubscriptionBudget(0))
+
+	budget := newSubscriptionBudget(10)
+	require.True(t, budget.tryAcquire(6))
+	require.True(t, budget.tryAcquire(4))
+	require.False(t, budget.tryAcquire(1))
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- budget.acquire(5, nil)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected acquire to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	budget.release(6)
+	select {
+	case ok := <-acquired:
+		require.True(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected acquire to return")
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	require.False(t, budget.acquire(5, cancel))
+
+	budget.release(9)
+	require.True(t, budget.tryAcquire(100))
+	require.False(t, budget.tryAcquire(1))
+	budget.release(100)
+	require.Equal(t, int64(0), budget.used)
+}
+
+// Ensure subscriptions which read ahead or send a latest-per-key snapshot
+// receive every message when the subscription budget only fits one message
+// at a time, and that the budget is released once they are sent.
+func TestSubscribeSubscriptionBudget(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.SubscriptionBufferMaxBytes = 1
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	for i, priority := range []int{0, 0, 5, 0, 1, 5, 0} {
+		_, err := api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Key:       []byte(strconv.Itoa(i % 3)),
+			Value:     []byte(strconv.Itoa(i)),
+			Headers:   map[string][]byte{PriorityHeader: []byte(strconv.Itoa(priority))},
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	subscribe := func(pairs ...string) []int64 {
+		subCtx := metadata.AppendToOutgoingContext(ctx, pairs...)
+		sub, err := api.Subscribe(subCtx, &client.SubscribeRequest{
+			Stream:        "foo",
+			StartPosition: client.StartPosition_EARLIEST,
+			StopPosition:  client.StopPosition_STOP_LATEST,
+		})
+		require.NoError(t, err)
+		_, err = sub.Recv()
+		require.NoError(t, err)
+		var offsets []int64
+		for {
+			msg, err := sub.Recv()
+			if err != nil {
+				require.Equal(t, codes.ResourceExhausted, status.Code(err))
+				return offsets
+			}
+			offsets = append(offsets, msg.Offset)
+		}
+	}
+
+	// Each window only fits one message, so messages are sent in offset
+	// order.
+	require.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6}, subscribe(PriorityWindowMetadata, "10"))
+	require.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6}, subscribe(AcceptEncodingMetadata, "gzip"))
+	require.Equal(t, []int64{4, 5, 6}, subscribe(LatestPerKeyMetadata, "true"))
+
+	budget := s1.subscriptionBudget
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	require.Equal(t, int64(0), budget.used)
+}
+
+// Ensure readSnapshotMessages skips offsets removed by compaction after they
+// were collected and stops at the end of the snapshot rather than waiting for
+// them.
+func TestReadSnapshotMessagesCompacted(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 0)
+	config.Streams.Compact = true
+	config.Streams.SegmentMaxBytes = 1
+	server := New(config)
+	require.NoError(t, server.Start())
+	defer server.Stop()
+
+	p, err := server.newPartition(&proto.Partition{
+		Subject:  "foo",
+		Stream:   "foo",
+		Replicas: []string{"a"},
+		Leader:   "a",
+		Isr:      []string{"a"},
+	}, false, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Each message is appended to its own segment so it can be compacted.
+	appendKeys := func(keys ...string) {
+		for _, key := range keys {
+			_, err := p.log.Append([]*commitlog.Message{{
+				Key:       []byte(key),
+				Value:     []byte("v"),
+				Timestamp: time.Now().UnixNano(),
+			}})
+			require.NoError(t, err)
+		}
+		p.log.SetHighWatermark(p.log.NewestOffset())
+	}
+	appendKeys("a", "b", "c")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	read := func(offsets []int64) []int64 {
+		var read []int64
+		ok, s := readSnapshotMessages(ctx, p, offsets, 2, make([]byte, 28),
+			func(msg *client.Message) bool {
+				read = append(read, msg.Offset)
+				return true
+			})
+		require.Nil(t, s)
+		require.True(t, ok)
+		return read
+	}
+
+	// The latest offsets of keys a and c as collected by the first pass.
+	require.Equal(t, []int64{0, 2}, read([]int64{0, 2}))
+
+	// Compaction between the two passes removes both collected offsets.
+	appendKeys("a", "c", "d")
+	require.NoError(t, p.log.Clean(context.Background()))
+
+	require.Empty(t, read([]int64{0, 2}))
+	require.Equal(t, []int64{1}, read([]int64{0, 1, 2}))
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure subscriptionOptionsFromContext parses delivery features from request
+// metadata and rejects combinations which are not supported.
+func TestSubscriptionOptionsFromContext(t *testing.T) {
+	api := &apiServer{&Server{config: &Config{SubscriptionPriorityWindowMax: 100}}}
+	req := &client.SubscribeRequest{}
+	parse := func(req *client.SubscribeRequest, kv ...string) (*subscriptionOptions, codes.Code) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+		opts, st := api.subscriptionOptionsFromContext(ctx, req)
+		if st != nil {
+			return nil, st.Code()
+		}
+		return opts, codes.OK
+	}
+
+	opts, code := parse(req)
+	require.Equal(t, codes.OK, code)
+	require.Nil(t, opts.filter)
+	require.Equal(t, 0, opts.readAhead())
+
+	// Auto-committing subscriptions start from their cursor by default.
+	opts, code = parse(req,
+		AutoCommitCursorMetadata, "abc",
+		AutoCommitModeMetadata, autoCommitAtLeastOnce,
+		KeyFilterMetadata, "a",
+		AcceptEncodingMetadata, "gzip",
+	)
+	require.Equal(t, codes.OK, code)
+	require.Equal(t, "abc", opts.startCursor)
+	require.True(t, opts.atLeastOnce)
+	require.True(t, opts.filter.matches([]byte("a")))
+	require.Equal(t, maxEncodedBatchMessages, opts.readAhead())
+
+	opts, code = parse(req, AutoCommitCursorMetadata, "abc", StartCursorMetadata, "def")
+	require.Equal(t, codes.OK, code)
+	require.Equal(t, "def", opts.startCursor)
+
+	opts, code = parse(req, PriorityWindowMetadata, "10", AcceptEncodingMetadata, "gzip")
+	require.Equal(t, codes.OK, code)
+	require.Equal(t, 10, opts.readAhead())
+
+	// Queue subscriptions don't negotiate an encoding.
+	opts, code = parse(req, QueueMetadata, "q", AcceptEncodingMetadata, "gzip")
+	require.Equal(t, codes.OK, code)
+	require.Equal(t, "q", opts.queueName)
+	require.Nil(t, opts.encoder)
+
+	invalid := [][]string{
+		{QueueMetadata, "q", KeyFilterMetadata, "a"},
+		{QueueMetadata, "q", PriorityWindowMetadata, "10"},
+		{QueueMetadata, "q", StartCursorMetadata, "abc"},
+		{QueueMetadata, "q", AutoCommitCursorMetadata, "abc"},
+		{AutoCommitCursorMetadata, "abc", PriorityWindowMetadata, "10"},
+		{PriorityWindowMetadata, "1000"},
+		{AutoCommitCursorMetadata, "abc", AutoCommitModeMetadata, "foo"},
+	}
+	for _, kv := range invalid {
+		_, code = parse(req, kv...)
+		require.Equal(t, codes.InvalidArgument, code, kv)
+	}
+
+	_, code = parse(&client.SubscribeRequest{StopPosition: client.StopPosition_STOP_LATEST},
+		QueueMetadata, "q")
+	require.Equal(t, codes.InvalidArgument, code)
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Variables systemd sets in the environment of services it manages with the
+// notify protocol.
+const (
+	notifySocketEnv = "NOTIFY_SOCKET"
+	watchdogUsecEnv = "WATCHDOG_USEC"
+	watchdogPIDEnv  = "WATCHDOG_PID"
+)
+
+// Notifications sent to systemd.
+const (
+	sdReady    = "READY=1"
+	sdStopping = "STOPPING=1"
+	sdWatchdog = "WATCHDOG=1"
+)
+
+// readinessPollInterval is how often the server checks if it has finished
+// recovering before notifying systemd it's ready.
+const readinessPollInterval = 100 * time.Millisecond
+
+// sdNotify sends the given state to systemd if the server is run by a unit
+// with Type=notify. It indicates if the notification was sent.
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv(notifySocketEnv)
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ names a socket in the abstract namespace, which the net
+	// package handles.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// watchdogInterval returns how often systemd expects the server to feed the
+// watchdog, or 0 if the watchdog is not enabled for the server's process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv(watchdogUsecEnv), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv(watchdogPIDEnv); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startSystemdNotifier notifies systemd the server is ready once it has
+// finished recovering its metadata from the Raft log and started the
+// recovered partitions, then feeds the systemd watchdog, if enabled, while
+// the server is running. It does nothing if the server is not run by systemd
+// with Type=notify.
+func (s *Server) startSystemdNotifier(node *raftNode) {
+	if os.Getenv(notifySocketEnv) == "" {
+		return
+	}
+	s.startGoroutine(func() {
+		ticker := time.NewTicker(readinessPollInterval)
+		defer ticker.Stop()
+		for !s.isRecovered(node) {
+			select {
+			case <-ticker.C:
+			case <-s.shutdownCh:
+				return
+			}
+		}
+		if _, err := sdNotify(sdReady); err != nil {
+			s.logger.Warnf("Failed to notify systemd of readiness: %v", err)
+			return
+		}
+		s.logger.Debug("Notified systemd of readiness")
+
+		interval := watchdogInterval()
+		if interval == 0 {
+			return
+		}
+		// Feed the watchdog twice per interval as systemd recommends.
+		watchdog := time.NewTicker(interval / 2)
+		defer watchdog.Stop()
+		for {
+			select {
+			case <-watchdog.C:
+				if !s.IsRunning() {
+					continue
+				}
+				if _, err := sdNotify(sdWatchdog); err != nil {
+					s.logger.Warnf("Failed to feed systemd watchdog: %v", err)
+				}
+			case <-s.shutdownCh:
+				return
+			}
+		}
+	})
+}
+
+// isRecovered indicates if the server knows of a metadata leader and has
+// applied the latest committed Raft command, meaning any replay of the Raft
+// log on startup is finished and the recovered partitions have been started.
+func (s *Server) isRecovered(node *raftNode) bool {
+	if !s.IsRunning() || node.Leader() == "" {
+		return false
+	}
+	stats := node.Stats()
+	commitIndex, err := strconv.ParseUint(stats["commit_index"], 10, 64)
+	if err != nil || commitIndex == 0 {
+		return false
+	}
+	snapshotIndex, err := strconv.ParseUint(stats["last_snapshot_index"], 10, 64)
+	if err != nil {
+		return false
+	}
+	applied := atomic.LoadUint64(&s.fsmAppliedIndex)
+	if snapshotIndex > applied {
+		applied = snapshotIndex
+	}
+	firstIndex, err := node.store.FirstIndex()
+	if err != nil {
+		return false
+	}
+	// Only commands are applied to the FSM, so find the latest committed one.
+	log := &raft.Log{}
+	for i := commitIndex; i > applied && i >= firstIndex; i-- {
+		if err := node.store.GetLog(i, log); err != nil {
+			return false
+		}
+		if log.Type == raft.LogCommand {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// listenNotifySocket creates a socket for systemd notifications and points
+// NOTIFY_SOCKET at it.
+func listenNotifySocket(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "liftbridge-notify")
+	require.NoError(t, err)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	require.NoError(t, os.Setenv(notifySocketEnv, socket))
+	return conn, func() {
+		os.Unsetenv(notifySocketEnv)
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// readNotification returns the next notification sent to the socket.
+func readNotification(t *testing.T, conn *net.UnixConn, timeout time.Duration) string {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout)))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+// Ensure sdNotify only sends notifications when NOTIFY_SOCKET is set.
+func TestSDNotify(t *testing.T) {
+	os.Unsetenv(notifySocketEnv)
+	sent, err := sdNotify(sdReady)
+	require.NoError(t, err)
+	require.False(t, sent)
+
+	conn, cleanup := listenNotifySocket(t)
+	defer cleanup()
+	sent, err = sdNotify(sdReady)
+	require.NoError(t, err)
+	require.True(t, sent)
+	require.Equal(t, sdReady, readNotification(t, conn, time.Second))
+}
+
+// Ensure watchdogInterval parses WATCHDOG_USEC and ignores it if it's meant
+// for another process.
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv(watchdogUsecEnv)
+	defer os.Unsetenv(watchdogPIDEnv)
+
+	os.Unsetenv(watchdogUsecEnv)
+	require.Equal(t, time.Duration(0), watchdogInterval())
+
+	os.Setenv(watchdogUsecEnv, "2000000")
+	require.Equal(t, 2*time.Second, watchdogInterval())
+
+	os.Setenv(watchdogPIDEnv, "1")
+	require.Equal(t, time.Duration(0), watchdogInterval())
+
+	os.Unsetenv(watchdogPIDEnv)
+	os.Setenv(watchdogUsecEnv, "never")
+	require.Equal(t, time.Duration(0), watchdogInterval())
+}
+
+// Ensure the server notifies systemd it's ready once it has recovered its
+// streams, feeds the watchdog and notifies systemd when it stops.
+func TestSystemdNotifier(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.NewAPIClient(conn).CreateStream(ctx,
+		&client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	s1.Stop()
+
+	notify, cleanup := listenNotifySocket(t)
+	defer cleanup()
+	os.Setenv(watchdogUsecEnv, "100000")
+	defer os.Unsetenv(watchdogUsecEnv)
+
+	// Restart the server so that it recovers the stream from the Raft log.
+	s1Config.Clustering.RaftBootstrapSeed = false
+	s1 = runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	require.Equal(t, sdReady, readNotification(t, notify, 10*time.Second))
+	partition := s1.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+	require.True(t, partition.IsLeader())
+
+	require.Equal(t, sdWatchdog, readNotification(t, notify, time.Second))
+
+	s1.Stop()
+	for {
+		if readNotification(t, notify, time.Second) == sdStopping {
+			break
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/liftbridge-io/liftbridge/server/archive"
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// tieredPath is the path of the tiered storage statistics endpoint on the
+// admin HTTP server.
+const tieredPath = "/v1/tiered"
+
+// tieredStatus is the tiered storage statistics reported by the admin API.
+type tieredStatus struct {
+	Uploads              int64   `json:"uploads"`
+	UploadedBytes        int64   `json:"uploadedBytes"`
+	Hydrations           int64   `json:"hydrations"`
+	HydrationErrors      int64   `json:"hydrationErrors"`
+	HydratedBytes        int64   `json:"hydratedBytes"`
+	HydrationLatency     float64 `json:"hydrationLatencyMs"`
+	MaxHydrationLatency  float64 `json:"maxHydrationLatencyMs"`
+	LastHydrationLatency float64 `json:"lastHydrationLatencyMs"`
+}
+
+// getTieredStorage returns the tiered storage which offloads segments to the
+// given bucket, creating it the first time the bucket is used. The tiered
+// storages of all buckets share their statistics.
+func (s *Server) getTieredStorage(bucket string) (*commitlog.TieredStorage, error) {
+	s.tieredMu.Lock()
+	defer s.tieredMu.Unlock()
+	if tiered, ok := s.tieredBuckets[bucket]; ok {
+		return tiered, nil
+	}
+	store, err := archive.New(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var tiered *commitlog.TieredStorage
+	if s.tieredStorage == nil {
+		tiered = commitlog.NewTieredStorage(store, "")
+		s.tieredStorage = tiered
+	} else {
+		tiered = s.tieredStorage.WithStore(store, "")
+	}
+	if s.tieredBuckets == nil {
+		s.tieredBuckets = make(map[string]*commitlog.TieredStorage)
+	}
+	s.tieredBuckets[bucket] = tiered
+	return tiered, nil
+}
+
+// tieredBucketAllowed indicates if streams can offload segments to the given
+// bucket, which must be the default bucket or one of the allowed buckets.
+func (s *Server) tieredBucketAllowed(bucket string) bool {
+	s.streamsConfigMu.RLock()
+	defer s.streamsConfigMu.RUnlock()
+	if bucket == s.config.Streams.TieredStorageBucket {
+		return true
+	}
+	for _, allowed := range s.config.Streams.TieredStorageAllowedBuckets {
+		if bucket == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTieredStorage returns an error if a stream with the given
+// configuration would offload segments to tiered storage without a bucket or
+// to a bucket which is not allowed.
+func (a *apiServer) checkTieredStorage(config *proto.StreamConfig) *status.Status {
+	if bucket := config.GetTieredStorageBucket(); bucket != "" && !a.tieredBucketAllowed(bucket) {
+		return status.Newf(codes.InvalidArgument, "Tiered storage bucket %q is not allowed", bucket)
+	}
+	streamsConfig := a.getStreamsConfig(config)
+	if streamsConfig.TieredStorage && streamsConfig.TieredStorageBucket == "" {
+		return status.New(codes.InvalidArgument, "Tiered storage requires a bucket")
+	}
+	return nil
+}
+
+// isValidTieredPrefix indicates if the given tiered storage key prefix is
+// relative and doesn't refer to parent directories, so that keys stay within
+// the bucket when it's a local directory.
+func isValidTieredPrefix(prefix string) bool {
+	if path.IsAbs(prefix) {
+		return false
+	}
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// handleTiered serves GET /v1/tiered, which reports the segments offloaded to
+// and hydrated from tiered storage by the server's partitions.
+func (s *Server) handleTiered(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeAdminError(w, http.StatusMethodNotAllowed, "Method not allowed", "")
+		return
+	}
+	s.tieredMu.Lock()
+	tiered := s.tieredStorage
+	s.tieredMu.Unlock()
+	if tiered == nil {
+		writeAdminError(w, http.StatusNotFound, "Tiered storage is not enabled", "")
+		return
+	}
+	stats := tiered.Stats()
+	writeAdminResponse(w, http.StatusOK, tieredStatus{
+		Uploads:              stats.Uploads,
+		UploadedBytes:        stats.UploadedBytes,
+		Hydrations:           stats.Hydrations,
+		HydrationErrors:      stats.HydrationErrors,
+		HydratedBytes:        stats.HydratedBytes,
+		HydrationLatency:     milliseconds(stats.HydrationLatency),
+		MaxHydrationLatency:  milliseconds(stats.MaxHydrationLatency),
+		LastHydrationLatency: milliseconds(stats.LastHydrationLatency),
+	})
+}
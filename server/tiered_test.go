@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure subscriptions can read offsets offloaded to tiered storage after
+// retention deleted them locally and the admin API reports the hydrations.
+func TestTieredStorage(t *testing.T) {
+	defer cleanupStorage(t)
+
+	bucket, err := ioutil.TempDir("", "liftbridge-tiered")
+	require.NoError(t, err)
+	defer os.RemoveAll(bucket)
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	config.Streams.SegmentMaxBytes = 1
+	config.Streams.RetentionMaxMessages = 2
+	config.Streams.TieredStorage = true
+	config.Streams.TieredStorageBucket = bucket
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err = api.Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte(strconv.Itoa(i)),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+
+	partition := s.metadata.GetPartition("foo", 0)
+	require.NotNil(t, partition)
+	require.NoError(t, partition.log.Clean(context.Background()))
+	require.True(t, partition.log.OldestOffset() > 0)
+	require.Equal(t, int64(0), partition.log.LogStartOffset())
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_OFFSET,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	header, err := sub.Header()
+	require.NoError(t, err)
+	require.Empty(t, header.Get(GapFirstOffsetMetadata))
+	require.Equal(t, []string{"0"}, header.Get(LogStartOffsetMetadata))
+	for i := 0; i < 5; i++ {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, int64(i), msg.Offset)
+		require.Equal(t, strconv.Itoa(i), string(msg.Value))
+	}
+
+	status := tieredStatus{}
+	require.Equal(t, http.StatusOK, adminRequest(t, s, http.MethodGet, tieredPath, &status))
+	require.True(t, status.Uploads > 0)
+	require.True(t, status.Hydrations > 0)
+	require.Equal(t, int64(0), status.HydrationErrors)
+}
+
+// Ensure the tiered storage endpoint returns 404 when tiered storage is
+// disabled.
+func TestTieredStorageDisabled(t *testing.T) {
+	defer cleanupStorage(t)
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+
+	getMetadataLeader(t, 10*time.Second, s)
+	require.Equal(t, http.StatusNotFound, adminRequest(t, s, http.MethodGet, tieredPath, nil))
+}
+
+// Ensure streams can enable tiered storage with their own bucket and prefix
+// and that buckets which are not allowed are rejected.
+func TestTieredStoragePerStream(t *testing.T) {
+	defer cleanupStorage(t)
+
+	bucket, err := ioutil.TempDir("", "liftbridge-tiered")
+	require.NoError(t, err)
+	defer os.RemoveAll(bucket)
+
+	config := getTestConfig("a", true, 5050)
+	config.AdminListen = "localhost:9390"
+	config.Streams.SegmentMaxBytes = 1
+	config.Streams.RetentionMaxMessages = 2
+	config.Streams.TieredStorageAllowedBuckets = []string{bucket}
+	s := runServerWithConfig(t, config)
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	createStream := func(name string, kv ...string) error {
+		_, err := api.CreateStream(metadata.AppendToOutgoingContext(ctx, kv...),
+			&client.CreateStreamRequest{Subject: name, Name: name})
+		return err
+	}
+
+	// Tiered storage requires an allowed bucket and a relative prefix.
+	err = createStream("foo", TieredStorageEnabledMetadata, "true")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	err = createStream("foo", TieredStorageEnabledMetadata, "true", TieredStorageBucketMetadata, "/tmp")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	err = createStream("foo", TieredStorageEnabledMetadata, "true", TieredStorageBucketMetadata, bucket,
+		TieredStoragePrefixMetadata, "../foo")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Equal(t, http.StatusNotFound, adminRequest(t, s, http.MethodGet, tieredPath, nil))
+
+	require.NoError(t, createStream("foo", TieredStorageEnabledMetadata, "true",
+		TieredStorageBucketMetadata, bucket, TieredStoragePrefixMetadata, "segments"))
+	require.NoError(t, createStream("bar"))
+	for _, stream := range []string{"foo", "bar"} {
+		for i := 0; i < 5; i++ {
+			_, err = api.Publish(ctx, &client.PublishRequest{
+				Stream:    stream,
+				Value:     []byte(strconv.Itoa(i)),
+				AckPolicy: client.AckPolicy_ALL,
+			})
+			require.NoError(t, err)
+		}
+		partition := s.metadata.GetPartition(stream, 0)
+		require.NotNil(t, partition)
+		require.NoError(t, partition.log.Clean(context.Background()))
+	}
+
+	// Only the stream which enabled tiered storage keeps its offsets.
+	require.Equal(t, int64(0), s.metadata.GetPartition("foo", 0).log.LogStartOffset())
+	require.True(t, s.metadata.GetPartition("bar", 0).log.LogStartOffset() > 0)
+	files, err := ioutil.ReadDir(filepath.Join(bucket, "segments", "foo", "0", "a"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	stats := tieredStatus{}
+	require.Equal(t, http.StatusOK, adminRequest(t, s, http.MethodGet, tieredPath, &stats))
+	require.True(t, stats.Uploads > 0)
+}
@@ -0,0 +1,212 @@
+// Package timerwheel runs delayed and periodic background tasks on a fixed
+// pool of worker goroutines. Tasks are tracked by a hashed timer wheel, so a
+// process with many mostly idle periodic tasks, such as per-partition
+// housekeeping, needs neither a goroutine nor a runtime timer per task.
+package timerwheel
+
+import (
+	"sync"
+	"time"
+)
+
+// numSlots is the number of slots in the wheel. Tasks due more than numSlots
+// ticks in the future wait for the wheel to go around until they are due.
+const numSlots = 512
+
+// Wheel schedules tasks in slots of tick duration and runs them on a fixed
+// number of workers once they are due. Tasks run no earlier than their delay
+// and up to one tick later, or later still if all workers are busy. A nil
+// Wheel runs each task on its own runtime timer instead.
+type Wheel struct {
+	tick    time.Duration
+	mu      sync.Mutex
+	slots   [numSlots]map[*Task]struct{}
+	pos     int
+	ready   []*Task
+	cond    *sync.Cond
+	stopped bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// Task is a task scheduled on a Wheel.
+type Task struct {
+	wheel   *Wheel
+	fn      func() time.Duration
+	mu      sync.Mutex // Protects stopped and timer, held while scheduling
+	stopped bool
+	timer   *time.Timer // Used instead of the wheel if it's nil
+
+	// Protected by the wheel mutex.
+	slot   int // Index of the slot holding the task or -1 if it's not in one
+	rounds int // Number of turns of the wheel before the task is due
+}
+
+// New returns a Wheel which advances every tick and runs due tasks on the
+// given number of worker goroutines. It must be stopped with Stop.
+func New(workers int, tick time.Duration) *Wheel {
+	if workers < 1 {
+		workers = 1
+	}
+	if tick <= 0 {
+		tick = time.Millisecond
+	}
+	w := &Wheel{tick: tick, done: make(chan struct{})}
+	w.cond = sync.NewCond(&w.mu)
+	for i := range w.slots {
+		w.slots[i] = make(map[*Task]struct{})
+	}
+	w.wg.Add(workers + 1)
+	go w.advanceLoop()
+	for i := 0; i < workers; i++ {
+		go w.worker()
+	}
+	return w
+}
+
+// Schedule runs fn after delay. fn returns the delay until it should run
+// again, or zero or less if it shouldn't, so periodic tasks return their
+// interval. A task never runs concurrently with itself.
+func (w *Wheel) Schedule(delay time.Duration, fn func() time.Duration) *Task {
+	t := &Task{wheel: w, fn: fn, slot: -1}
+	t.mu.Lock()
+	t.schedule(delay)
+	t.mu.Unlock()
+	return t
+}
+
+// Stop stops the wheel and its workers, waiting for running tasks to return.
+// Scheduled tasks no longer run.
+func (w *Wheel) Stop() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return
+	}
+	w.stopped = true
+	for i := range w.slots {
+		w.slots[i] = make(map[*Task]struct{})
+	}
+	w.ready = nil
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	close(w.done)
+	w.wg.Wait()
+}
+
+// Stop prevents the task from running again. It does not wait for the task to
+// return if it's running.
+func (t *Task) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.mu.Unlock()
+
+	if w := t.wheel; w != nil {
+		w.mu.Lock()
+		if t.slot >= 0 {
+			delete(w.slots[t.slot], t)
+			t.slot = -1
+		}
+		w.mu.Unlock()
+	}
+}
+
+// schedule adds the task to the wheel, or starts its timer if the wheel is
+// nil, unless it was stopped. It must be called with the task mutex held.
+func (t *Task) schedule(delay time.Duration) {
+	if t.stopped {
+		return
+	}
+	w := t.wheel
+	if w == nil {
+		t.timer = time.AfterFunc(delay, t.run)
+		return
+	}
+
+	ticks := int((delay + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+	t.slot = (w.pos + ticks) % numSlots
+	t.rounds = (ticks - 1) / numSlots
+	w.slots[t.slot][t] = struct{}{}
+}
+
+// run runs the task and schedules it again if it's periodic and hasn't been
+// stopped.
+func (t *Task) run() {
+	t.mu.Lock()
+	stopped := t.stopped
+	t.mu.Unlock()
+	if stopped {
+		return
+	}
+	if delay := t.fn(); delay > 0 {
+		t.mu.Lock()
+		t.schedule(delay)
+		t.mu.Unlock()
+	}
+}
+
+// advanceLoop moves the wheel forward every tick and hands the tasks which
+// are due to the workers.
+func (w *Wheel) advanceLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		w.mu.Lock()
+		w.pos = (w.pos + 1) % numSlots
+		due := false
+		for t := range w.slots[w.pos] {
+			if t.rounds > 0 {
+				t.rounds--
+				continue
+			}
+			delete(w.slots[w.pos], t)
+			t.slot = -1
+			w.ready = append(w.ready, t)
+			due = true
+		}
+		if due {
+			w.cond.Broadcast()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// worker runs tasks which are due until the wheel is stopped.
+func (w *Wheel) worker() {
+	defer w.wg.Done()
+	for {
+		w.mu.Lock()
+		for len(w.ready) == 0 && !w.stopped {
+			w.cond.Wait()
+		}
+		if w.stopped {
+			w.mu.Unlock()
+			return
+		}
+		t := w.ready[0]
+		w.ready[0] = nil
+		w.ready = w.ready[1:]
+		w.mu.Unlock()
+		t.run()
+	}
+}
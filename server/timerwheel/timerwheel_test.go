@@ -0,0 +1,124 @@
+package timerwheel
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure tasks run after their delay, periodic tasks run until stopped, and
+// stopped tasks don't run, both with a wheel and without one.
+func TestSchedule(t *testing.T) {
+	for _, w := range []*Wheel{New(2, time.Millisecond), nil} {
+		var (
+			start = time.Now()
+			once  = make(chan time.Duration, 1)
+			runs  int32
+		)
+		w.Schedule(20*time.Millisecond, func() time.Duration {
+			once <- time.Since(start)
+			return 0
+		})
+		periodic := w.Schedule(time.Millisecond, func() time.Duration {
+			atomic.AddInt32(&runs, 1)
+			return time.Millisecond
+		})
+		stopped := w.Schedule(10*time.Millisecond, func() time.Duration {
+			t.Fatal("Stopped task ran")
+			return 0
+		})
+		stopped.Stop()
+
+		select {
+		case elapsed := <-once:
+			require.True(t, elapsed >= 20*time.Millisecond)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected task to run")
+		}
+
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&runs) >= 3 },
+			5*time.Second, time.Millisecond)
+		periodic.Stop()
+		stoppedRuns := atomic.LoadInt32(&runs)
+		time.Sleep(20 * time.Millisecond)
+		// The task may have been running when it was stopped.
+		require.True(t, atomic.LoadInt32(&runs) <= stoppedRuns+1)
+
+		w.Stop()
+	}
+}
+
+// Ensure tasks due after more than a full turn of the wheel wait for it to go
+// around.
+func TestScheduleRounds(t *testing.T) {
+	w := New(1, time.Microsecond)
+	defer w.Stop()
+
+	var (
+		start = time.Now()
+		ran   = make(chan time.Duration, 1)
+		delay = (numSlots + 10) * 10 * time.Microsecond
+	)
+	w.Schedule(delay, func() time.Duration {
+		ran <- time.Since(start)
+		return 0
+	})
+	select {
+	case elapsed := <-ran:
+		require.True(t, elapsed >= delay)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected task to run")
+	}
+}
+
+// Ensure a wheel runs many tasks on its fixed set of workers without running
+// a task concurrently with itself, and stopping it waits for running tasks.
+func TestWheelWorkers(t *testing.T) {
+	w := New(4, time.Millisecond)
+
+	var (
+		mu      sync.Mutex
+		running = make(map[int]bool)
+		counts  = make(map[int]int)
+	)
+	for i := 0; i < 1000; i++ {
+		i := i
+		w.Schedule(time.Duration(i%10)*time.Millisecond, func() time.Duration {
+			mu.Lock()
+			require.False(t, running[i])
+			running[i] = true
+			mu.Unlock()
+			time.Sleep(10 * time.Microsecond)
+			mu.Lock()
+			running[i] = false
+			counts[i]++
+			n := counts[i]
+			mu.Unlock()
+			if n < 3 {
+				return time.Millisecond
+			}
+			return 0
+		})
+	}
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := 0; i < 1000; i++ {
+			if counts[i] < 3 {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 10*time.Millisecond)
+
+	w.Stop()
+	w.Stop()
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < 1000; i++ {
+		require.Equal(t, 3, counts[i])
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	client "github.com/liftbridge-io/liftbridge-api/go"
+
+	"github.com/liftbridge-io/liftbridge/server/commitlog"
+)
+
+// TombstoneHeader is the message header marking a message as a tombstone,
+// i.e. a delete of its key. A tombstone is any message with a key and no
+// value. Publishers can set the header to delete a key explicitly, in which
+// case the message must have a key and no value. The server sets it on every
+// tombstone it delivers to subscribers, sampled mirrors, and derived streams
+// so that consumers can tell deletes apart from other messages. Compaction
+// removes all earlier messages for a deleted key and eventually the tombstone
+// itself.
+const TombstoneHeader = "Liftbridge-Tombstone"
+
+// isTombstone indicates if a message with the given key and value is a
+// tombstone.
+func isTombstone(key, value []byte) bool {
+	return len(key) > 0 && len(value) == 0
+}
+
+// checkTombstone verifies that a publish request marked as a tombstone has a
+// key and no value.
+func checkTombstone(req *client.PublishRequest) *client.PublishAsyncError {
+	if _, ok := req.Headers[TombstoneHeader]; !ok {
+		return nil
+	}
+	if len(req.Key) == 0 {
+		return &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_BAD_REQUEST,
+			Message: "tombstone must have a key",
+		}
+	}
+	if len(req.Value) != 0 {
+		return &client.PublishAsyncError{
+			Code:    client.PublishAsyncError_BAD_REQUEST,
+			Message: "tombstone cannot have a value",
+		}
+	}
+	return nil
+}
+
+// clearTombstoneValues drops the value of keyed messages published with the
+// TombstoneHeader directly to NATS, which bypasses the publish API checks, so
+// that they are stored as tombstones.
+func clearTombstoneValues(msgs []*commitlog.Message) {
+	for _, m := range msgs {
+		if _, ok := m.Headers[TombstoneHeader]; ok && len(m.Key) > 0 {
+			m.Value = nil
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+)
+
+// Ensure checkTombstone requires messages marked as tombstones to have a key
+// and no value.
+func TestCheckTombstone(t *testing.T) {
+	tombstone := map[string][]byte{TombstoneHeader: nil}
+	require.Nil(t, checkTombstone(&client.PublishRequest{Value: []byte("foo")}))
+	require.Nil(t, checkTombstone(&client.PublishRequest{Key: []byte("foo"), Headers: tombstone}))
+
+	e := checkTombstone(&client.PublishRequest{Headers: tombstone})
+	require.NotNil(t, e)
+	require.Equal(t, client.PublishAsyncError_BAD_REQUEST, e.Code)
+
+	e = checkTombstone(&client.PublishRequest{Key: []byte("foo"), Value: []byte("bar"), Headers: tombstone})
+	require.NotNil(t, e)
+	require.Equal(t, client.PublishAsyncError_BAD_REQUEST, e.Code)
+}
+
+// Ensure tombstones are delivered with the tombstone header and are left out
+// of the latest message per key.
+func TestSubscribeTombstones(t *testing.T) {
+	defer cleanupStorage(t)
+
+	// Tombstones are not encrypted.
+	os.Setenv("LIFTBRIDGE_ENCRYPTION_KEY", "t7w!z%C*F-JaNcRf")
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1Config.Streams.Encryption = true
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	for _, req := range []*client.PublishRequest{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Key: []byte("b"), Value: []byte("1")},
+		{Key: []byte("a"), Headers: map[string][]byte{TombstoneHeader: nil}},
+		// A message with a key and no value is a tombstone too.
+		{Key: []byte("b")},
+		{Key: []byte("b"), Value: []byte("2")},
+	} {
+		req.Stream = "foo"
+		req.AckPolicy = client.AckPolicy_ALL
+		_, err = api.Publish(ctx, req)
+		require.NoError(t, err)
+	}
+
+	_, err = api.Publish(ctx, &client.PublishRequest{
+		Stream:  "foo",
+		Headers: map[string][]byte{TombstoneHeader: nil},
+	})
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{
+		Stream:        "foo",
+		StartPosition: client.StartPosition_EARLIEST,
+		StopPosition:  client.StopPosition_STOP_LATEST,
+	})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	for i, tombstone := range []bool{false, false, true, true, false} {
+		msg, err := sub.Recv()
+		require.NoError(t, err)
+		require.Equal(t, int64(i), msg.Offset)
+		_, ok := msg.Headers[TombstoneHeader]
+		require.Equal(t, tombstone, ok)
+		require.Equal(t, tombstone, len(msg.Value) == 0)
+	}
+
+	// Deleted keys are not part of the latest messages per key.
+	sub, err = api.Subscribe(
+		metadata.AppendToOutgoingContext(ctx, LatestPerKeyMetadata, "true"),
+		&client.SubscribeRequest{
+			Stream:        "foo",
+			StartPosition: client.StartPosition_EARLIEST,
+			StopPosition:  client.StopPosition_STOP_LATEST,
+		},
+	)
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	require.NoError(t, err)
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), msg.Key)
+	require.Equal(t, []byte("2"), msg.Value)
+	_, err = sub.Recv()
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
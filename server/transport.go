@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	gnatsd "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+
+	"github.com/liftbridge-io/liftbridge/server/logger"
+)
+
+// transportTLSTimeout is how long brokers wait for the TLS handshake of
+// transport connections.
+const transportTLSTimeout = 2 * time.Second
+
+// startBrokerTransport starts the transport brokers use to reach each other
+// directly when NATS is disabled. It's an embedded NATS server which only
+// accepts client connections from this server on the loopback interface and
+// connects directly to the other brokers' transports, found from the transport
+// seeds, DNS peer discovery and gossip seeds, on the transport listen address.
+// Brokers only need to find one other broker since transports share the
+// brokers they know about. Streams can only be published to through the API
+// in this mode since nothing else can connect to the transport.
+func (s *Server) startBrokerTransport() error {
+	listen, err := parseHostPort(s.config.Clustering.TransportListen)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", configClusteringTransportListen)
+	}
+	if listen.Port == 0 {
+		listen.Port = gnatsd.RANDOM_PORT
+	}
+	opts := &gnatsd.Options{
+		Host:   "127.0.0.1",
+		Port:   gnatsd.RANDOM_PORT,
+		NoSigs: true,
+		Cluster: gnatsd.ClusterOpts{
+			Host: listen.Host,
+			Port: listen.Port,
+		},
+		Routes: s.discoverTransportRoutes(listen.Port),
+	}
+	// Brokers authenticate each other with the replication certificate if
+	// one is set since the transport carries the replication traffic.
+	if tlsConfig := s.config.Clustering.ReplicationTLS; tlsConfig != nil {
+		routeTLS := tlsConfig.Clone()
+		routeTLS.ClientAuth = tls.RequireAndVerifyClientCert
+		routeTLS.ClientCAs = tlsConfig.RootCAs
+		opts.Cluster.TLSConfig = routeTLS
+		opts.Cluster.TLSTimeout = transportTLSTimeout.Seconds()
+	}
+	transport, err := gnatsd.NewServer(opts)
+	if err != nil {
+		return err
+	}
+	transport.SetLogger(logger.NewNATSLogger(s.logger, s.config.LogNATS), false, false)
+	s.transport = transport
+	s.startGoroutine(transport.Start)
+	if !transport.ReadyForConnections(10 * time.Second) {
+		return errors.New("unable to start broker transport")
+	}
+	s.logger.Infof("Starting broker transport on %s with seeds %v", transport.ClusterAddr(), opts.Routes)
+	return nil
+}
+
+// transportNATSOptions returns the options for NATS connections to the
+// broker transport.
+func (s *Server) transportNATSOptions() nats.Options {
+	opts := nats.GetDefaultOptions()
+	opts.Servers = []string{s.transport.ClientURL()}
+	return opts
+}
+
+// discoverTransportRoutes returns the URLs of the other brokers' transports
+// to connect to. These are the transport seeds and, assuming the other
+// brokers use the same transport port, the addresses
+// clustering.raft.bootstrap.dns.name resolves to and the hosts of the gossip
+// seeds. Routes to this broker are detected and ignored by the transport.
+func (s *Server) discoverTransportRoutes(port int) []*url.URL {
+	var (
+		seen   = make(map[string]struct{})
+		routes []*url.URL
+	)
+	add := func(address string) {
+		if _, ok := seen[address]; ok {
+			return
+		}
+		seen[address] = struct{}{}
+		routes = append(routes, &url.URL{Scheme: "nats-route", Host: address})
+	}
+	for _, seed := range s.config.Clustering.TransportSeeds {
+		add(seed)
+	}
+	if port <= 0 {
+		return routes
+	}
+	portStr := strconv.Itoa(port)
+	if name := s.config.Clustering.RaftBootstrapDNSName; name != "" {
+		addrs, err := lookupHost(name)
+		if err != nil {
+			s.logger.Warnf("Failed to discover broker transports from %s: %v", name, err)
+		}
+		for _, addr := range addrs {
+			add(net.JoinHostPort(addr, portStr))
+		}
+	}
+	for _, seed := range s.config.Clustering.GossipSeeds {
+		host, _, err := net.SplitHostPort(seed)
+		if err != nil {
+			continue
+		}
+		add(net.JoinHostPort(host, portStr))
+	}
+	return routes
+}
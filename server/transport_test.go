@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// Ensure servers with NATS disabled form a cluster over the broker transport
+// and replicate messages published through the API.
+func TestNATSDisabled(t *testing.T) {
+	defer cleanupStorage(t)
+
+	var servers []*Server
+	for i, id := range []string{"a", "b"} {
+		config := getTestConfig(id, i == 0, 5050+i)
+		config.EmbeddedNATS = false
+		config.NATSDisabled = true
+		config.Clustering.TransportListen = fmt.Sprintf("127.0.0.1:%d", 7522+i)
+		config.Clustering.TransportSeeds = []string{"127.0.0.1:7522"}
+		s := runServerWithConfig(t, config)
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	getMetadataLeader(t, 10*time.Second, servers...)
+	requireReplication(t, servers...)
+}
+
+// Ensure brokers with NATS disabled authenticate each other with the
+// replication certificate and reject brokers with untrusted certificates.
+func TestNATSDisabledReplicationTLS(t *testing.T) {
+	defer cleanupStorage(t)
+
+	dir, err := ioutil.TempDir("", "liftbridge-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, dir, "liftbridge")
+
+	getConfig := func(id string, bootstrap bool, i int) *Config {
+		config := getTestConfig(id, bootstrap, 5050+i)
+		config.EmbeddedNATS = false
+		config.NATSDisabled = true
+		config.Clustering.TransportListen = fmt.Sprintf("127.0.0.1:%d", 7522+i)
+		config.Clustering.TransportSeeds = []string{"127.0.0.1:7522"}
+		config.Clustering.ReplicationTLS = ca.tlsConfig(t, id)
+		return config
+	}
+
+	var servers []*Server
+	for i, id := range []string{"a", "b"} {
+		s := runServerWithConfig(t, getConfig(id, i == 0, i))
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	getMetadataLeader(t, 10*time.Second, servers...)
+	requireReplication(t, servers...)
+
+	// A broker with a certificate from another authority can't connect.
+	config := getConfig("c", false, 2)
+	config.Clustering.ReplicationTLS = newTestCA(t, dir, "other").tlsConfig(t, "c")
+	s3 := New(config)
+	require.NoError(t, s3.startBrokerTransport())
+	defer s3.transport.Shutdown()
+	time.Sleep(500 * time.Millisecond)
+	require.Equal(t, 0, s3.transport.NumRoutes())
+	require.Equal(t, 1, servers[0].transport.NumRoutes())
+}
+
+// requireReplication creates a stream replicated to the given two servers,
+// publishes a message to it through the API of each server and waits for the
+// messages to be replicated to both servers.
+func requireReplication(t *testing.T, servers ...*Server) {
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", servers[len(servers)-1].config.Port), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{
+		Subject:           "foo",
+		Name:              "foo",
+		ReplicationFactor: 2,
+	})
+	require.NoError(t, err)
+	waitForISR(t, 10*time.Second, "foo", 0, 2, servers...)
+
+	for _, s := range servers {
+		conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", s.config.Port), grpc.WithInsecure())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = client.NewAPIClient(conn).Publish(ctx, &client.PublishRequest{
+			Stream:    "foo",
+			Value:     []byte("hello"),
+			AckPolicy: client.AckPolicy_ALL,
+		})
+		require.NoError(t, err)
+	}
+	for _, s := range servers {
+		partition := s.metadata.GetPartition("foo", 0)
+		require.Eventually(t, func() bool {
+			return partition.log.NewestOffset() == int64(len(servers)-1)
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+// Ensure the broker transport connects to the transport seeds and to the
+// transport port of the hosts found by DNS peer discovery and gossip seeds.
+func TestDiscoverTransportRoutes(t *testing.T) {
+	resolver := &fakeResolver{name: "liftbridge.svc"}
+	defer useFakeResolver(resolver)()
+	resolver.setHosts("liftbridge-0", "liftbridge-1")
+
+	config := getTestConfig("a", false, 0)
+	config.Clustering.TransportSeeds = []string{"seed:9000"}
+	config.Clustering.RaftBootstrapDNSName = "liftbridge.svc"
+	config.Clustering.GossipSeeds = []string{"10.0.0.1:7946", "gossip:7946"}
+	s := New(config)
+
+	var routes []string
+	for _, route := range s.discoverTransportRoutes(9296) {
+		routes = append(routes, route.String())
+	}
+	require.Equal(t, []string{
+		"nats-route://seed:9000",
+		"nats-route://10.0.0.1:9296",
+		"nats-route://10.0.0.2:9296",
+		"nats-route://gossip:9296",
+	}, routes)
+
+	// Only the seeds are used if the transport port is assigned by the OS.
+	require.Len(t, s.discoverTransportRoutes(0), 1)
+}
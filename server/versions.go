@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// ErrOpNotSupported is returned when proposing a Raft operation introduced by
+// a metadata version which not every server in the cluster supports yet.
+var ErrOpNotSupported = errors.New("operation not supported by every server in the cluster")
+
+// metadataVersionCacheMaxAge is how long the metadata version negotiated with
+// the cluster is reused before surveying the cluster again. It's short since a
+// server may be restarted with an older version without the members changing.
+const metadataVersionCacheMaxAge = 5 * time.Second
+
+// opVersions maps Raft operations added since metadata versioning to the
+// metadata version which introduced them. Operations which aren't listed are
+// understood by every server. Servers which predate versioning fail to apply
+// operations they don't know, and newer servers skip them, so the metadata
+// leader only proposes an operation once every server in the cluster supports
+// it, which keeps servers from crashing or diverging during rolling upgrades.
+var opVersions = map[proto.Op]uint32{
+	proto.Op_SET_STREAM_ALIAS:   2,
+	proto.Op_SET_DERIVED_OFFSET: 2,
+	proto.Op_RENAME_STREAM:      2,
+}
+
+// requiredMetadataVersion returns the metadata version every server must
+// support for the given operation to be proposed. Besides the operation
+// itself, this accounts for stream fields which servers running an older
+// version would ignore, e.g. creating a derived stream, which they would
+// otherwise create as a regular stream.
+func requiredMetadataVersion(op *proto.RaftLog) uint32 {
+	version := opVersions[op.Op]
+	if op.Op == proto.Op_CREATE_STREAM {
+		if v := streamMetadataVersion(op.CreateStreamOp.GetStream()); v > version {
+			version = v
+		}
+	}
+	return version
+}
+
+// streamMetadataVersion returns the metadata version which introduced the
+// newest field set on the given stream.
+func streamMetadataVersion(stream *proto.Stream) uint32 {
+	config := stream.GetConfig()
+	if len(stream.GetAliases()) > 0 || len(stream.GetDerivedOffsets()) > 0 || stream.GetDisplayName() != "" ||
+		config.GetCompactKeepVersions() != nil || config.GetRetentionMaxKeys() != nil ||
+		config.GetSampleOf() != "" || config.GetDeriveFrom() != "" || config.GetSnapshotOf() != "" ||
+		config.GetPartitionKey() != "" || config.GetFlushMessages() != nil || config.GetFlushMs() != nil ||
+		config.GetTieredStorageEnabled() != nil || config.GetTieredStorageBucket() != "" ||
+		config.GetTieredStoragePrefix() != "" || config.GetSegmentCompression() != "" ||
+		config.GetDedupWindow() != nil {
+		return 2
+	}
+	return 0
+}
+
+// negotiateMetadataVersion returns the highest metadata version supported by
+// every member of the cluster given the information the members report about
+// themselves. Servers which predate versioning report version 0. An error is
+// returned if a member didn't report its version.
+func negotiateMetadataVersion(members []string, servers []*proto.ServerInfoResponse) (uint32, error) {
+	versions := make(map[string]uint32, len(servers))
+	for _, server := range servers {
+		versions[server.Id] = server.MetadataVersion
+	}
+	negotiated := uint32(metadataVersion)
+	for _, member := range members {
+		version, ok := versions[member]
+		if !ok {
+			return 0, errors.Errorf("server %s did not report its metadata version", member)
+		}
+		if version < negotiated {
+			negotiated = version
+		}
+	}
+	return negotiated, nil
+}
+
+// negotiatedVersion caches the metadata version negotiated with the members
+// of the cluster so that operations proposed frequently, such as derived
+// stream checkpoints, don't survey the cluster each time.
+type negotiatedVersion struct {
+	mu         sync.Mutex
+	members    string
+	version    uint32
+	negotiated time.Time
+}
+
+// get returns the cached version if it was negotiated with the given members
+// within the last metadataVersionCacheMaxAge.
+func (n *negotiatedVersion) get(members []string) (uint32, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.members != strings.Join(members, ",") || time.Since(n.negotiated) > metadataVersionCacheMaxAge {
+		return 0, false
+	}
+	return n.version, true
+}
+
+// set caches the version negotiated with the given members.
+func (n *negotiatedVersion) set(members []string, version uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.members = strings.Join(members, ",")
+	n.version = version
+	n.negotiated = time.Now()
+}
+
+// clusterMetadataVersion surveys the cluster and returns the highest metadata
+// version supported by every member of the Raft group. The result is cached
+// briefly unless the members change.
+func (m *metadataAPI) clusterMetadataVersion(ctx context.Context) (uint32, error) {
+	members, err := m.getClusterServerIDs()
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(members)
+	if version, ok := m.clusterVersion.get(members); ok {
+		return version, nil
+	}
+	servers, st := m.surveyServers(ctx, len(members)-1)
+	if st != nil {
+		return 0, st.Err()
+	}
+	version, err := negotiateMetadataVersion(members, servers)
+	if err != nil {
+		return 0, err
+	}
+	m.clusterVersion.set(members, version)
+	return version, nil
+}
+
+// checkOpSupported returns an error wrapping ErrOpNotSupported if the given
+// operation or the fields it sets were introduced by a metadata version which
+// not every server in the cluster supports.
+func (m *metadataAPI) checkOpSupported(ctx context.Context, op *proto.RaftLog) error {
+	required := requiredMetadataVersion(op)
+	if required == 0 {
+		return nil
+	}
+	version, err := m.clusterMetadataVersion(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to negotiate metadata version")
+	}
+	if version < required {
+		return errors.Wrapf(ErrOpNotSupported, "%s requires metadata version %d but the cluster supports %d",
+			op.Op, required, version)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// Ensure the negotiated metadata version is the lowest version reported by
+// the members of the cluster.
+func TestNegotiateMetadataVersion(t *testing.T) {
+	servers := []*proto.ServerInfoResponse{
+		{Id: "a", MetadataVersion: metadataVersion},
+		{Id: "b", MetadataVersion: metadataVersion},
+		{Id: "c"},
+	}
+	version, err := negotiateMetadataVersion([]string{"a", "b"}, servers)
+	require.NoError(t, err)
+	require.Equal(t, uint32(metadataVersion), version)
+
+	// Servers which predate versioning report version 0.
+	version, err = negotiateMetadataVersion([]string{"a", "b", "c"}, servers)
+	require.NoError(t, err)
+	require.Equal(t, uint32(0), version)
+
+	// Every member must report its version.
+	_, err = negotiateMetadataVersion([]string{"a", "d"}, servers)
+	require.Error(t, err)
+}
+
+// Ensure the metadata leader only proposes operations supported by every
+// server in the cluster.
+func TestOpRequiresClusterMetadataVersion(t *testing.T) {
+	defer cleanupStorage(t)
+
+	opVersions[proto.Op_CREATE_STREAM] = metadataVersion + 1
+	defer delete(opVersions, proto.Op_CREATE_STREAM)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	getMetadataLeader(t, 10*time.Second, s1)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.Error(t, err)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Nil(t, s1.metadata.GetStream("foo"))
+
+	// Once every server supports the operation, it's proposed.
+	opVersions[proto.Op_CREATE_STREAM] = metadataVersion
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	require.NotNil(t, s1.metadata.GetStream("foo"))
+}
+
+// Ensure operations and stream fields require the metadata version which
+// introduced them.
+func TestRequiredMetadataVersion(t *testing.T) {
+	createStream := func(stream *proto.Stream) *proto.RaftLog {
+		return &proto.RaftLog{
+			Op:             proto.Op_CREATE_STREAM,
+			CreateStreamOp: &proto.CreateStreamOp{Stream: stream},
+		}
+	}
+	require.Equal(t, uint32(0), requiredMetadataVersion(createStream(&proto.Stream{
+		Name:   "foo",
+		Config: &proto.StreamConfig{RetentionMaxMessages: &proto.NullableInt64{Value: 10}},
+	})))
+	require.Equal(t, uint32(2), requiredMetadataVersion(createStream(&proto.Stream{
+		Name:   "foo",
+		Config: &proto.StreamConfig{DeriveFrom: "bar"},
+	})))
+	require.Equal(t, uint32(2), requiredMetadataVersion(createStream(&proto.Stream{
+		Name:   "foo",
+		Config: &proto.StreamConfig{RetentionMaxKeys: &proto.NullableInt64{Value: 10}},
+	})))
+	require.Equal(t, uint32(2), requiredMetadataVersion(createStream(&proto.Stream{
+		Name:   "foo",
+		Config: &proto.StreamConfig{FlushMs: &proto.NullableInt64{Value: 10}},
+	})))
+	require.Equal(t, uint32(2), requiredMetadataVersion(&proto.RaftLog{Op: proto.Op_SET_DERIVED_OFFSET}))
+	require.Equal(t, uint32(2), requiredMetadataVersion(&proto.RaftLog{Op: proto.Op_SET_STREAM_ALIAS}))
+	require.Equal(t, uint32(2), requiredMetadataVersion(&proto.RaftLog{Op: proto.Op_RENAME_STREAM}))
+	require.Equal(t, uint32(0), requiredMetadataVersion(&proto.RaftLog{Op: proto.Op_SHRINK_ISR}))
+}
+
+// Ensure that while a server in the cluster runs an older metadata version,
+// operations and stream fields it doesn't support are rejected rather than
+// proposed, while everything else keeps working.
+func TestMixedVersionCluster(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s1Config := getTestConfig("a", true, 5050)
+	s1 := runServerWithConfig(t, s1Config)
+	defer s1.Stop()
+
+	s2Config := getTestConfig("b", false, 0)
+	s2 := New(s2Config)
+	s2.metadataVersion = 1
+	require.NoError(t, s2.Start())
+	defer s2.Stop()
+
+	leader := getMetadataLeader(t, 10*time.Second, s1, s2)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+
+	// A derived stream would be created as a regular stream by the older
+	// server.
+	_, err = api.CreateStream(metadata.AppendToOutgoingContext(ctx, DeriveFromMetadata, "foo"),
+		&client.CreateStreamRequest{Subject: "bar", Name: "bar"})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.Nil(t, leader.metadata.GetStream("bar"))
+
+	// The older server can't apply derived stream checkpoints.
+	st := leader.metadata.SetDerivedOffset(ctx, &proto.SetDerivedOffsetOp{Stream: "foo", Offset: 1})
+	require.NotNil(t, st)
+	require.Equal(t, codes.FailedPrecondition, st.Code())
+
+	// Nor can it apply aliases or renames, which it would fail on.
+	_, err = api.CreateStream(metadata.AppendToOutgoingContext(ctx, AliasForMetadata, "foo"),
+		&client.CreateStreamRequest{Name: "baz"})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = api.CreateStream(metadata.AppendToOutgoingContext(ctx, RenameFromMetadata, "foo"),
+		&client.CreateStreamRequest{Name: "qux"})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.NotNil(t, leader.metadata.GetStream("foo"))
+	require.Nil(t, leader.metadata.GetStream("baz"))
+	require.Nil(t, leader.metadata.GetStream("qux"))
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/liftbridge-io/liftbridge/server/protocol"
+)
+
+// WatchMetadataMetadata is the Subscribe request metadata key which, if
+// "true", turns the subscription into a metadata watch. Instead of the
+// messages of a partition, the subscription receives a message whenever
+// streams are created or deleted or their partitions change leader, ISR,
+// paused, or readonly state. The message value is a FetchMetadataResponse
+// with the brokers and the current metadata of the changed streams, and its
+// offset is the metadata version of the change. If the request names a
+// stream, only changes to that stream are received, otherwise changes to all
+// streams are.
+const WatchMetadataMetadata = "liftbridge-watch-metadata"
+
+// WatchMetadataVersionMetadata is the metadata watch request metadata key
+// containing the last metadata version the client has seen. The watch then
+// resumes with the changes after that version. If the server no longer has
+// them or the key is not set, the watch starts with a snapshot of the
+// metadata instead.
+const WatchMetadataVersionMetadata = "liftbridge-watch-metadata-version"
+
+// MetadataSnapshotHeader is the header set to "true" on metadata watch
+// messages which contain the metadata of every watched stream rather than
+// only the changed ones. Streams not in a snapshot do not exist.
+const MetadataSnapshotHeader = "Liftbridge-Metadata-Snapshot"
+
+// maxMetadataChanges is the number of metadata changes retained for resuming
+// metadata watches.
+const maxMetadataChanges = 1024
+
+// metadataChange is a change to the metadata of streams at a version, which is
+// the index of the Raft log entry which made it.
+type metadataChange struct {
+	version uint64
+	streams []string
+}
+
+// metadataWatch retains recent metadata changes and wakes metadata watches
+// when there is a new one. Changes are recorded by the FSM on every server,
+// so versions are the same across the cluster and a watch can be resumed on
+// any server.
+type metadataWatch struct {
+	mu         sync.Mutex
+	changes    []metadataChange
+	version    uint64        // Version of the latest change
+	floor      uint64        // All changes after it are retained
+	evicted    uint64        // Version of the latest change no longer retained
+	generation uint64        // Incremented when the metadata is replaced, starts at 1
+	changed    chan struct{} // Closed and replaced on each change
+}
+
+func newMetadataWatch() *metadataWatch {
+	return &metadataWatch{
+		// The changes before the first one recorded are unknown.
+		floor:      math.MaxUint64,
+		generation: 1,
+		changed:    make(chan struct{}),
+	}
+}
+
+// record adds a change to the given streams and wakes metadata watches.
+func (w *metadataWatch) record(version uint64, streams []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.floor == math.MaxUint64 {
+		w.floor = version - 1
+	}
+	if len(w.changes) == maxMetadataChanges {
+		w.evicted = w.changes[0].version
+		w.floor = w.evicted
+		w.changes = w.changes[1:]
+	}
+	w.changes = append(w.changes, metadataChange{version: version, streams: streams})
+	w.version = version
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// reset discards the retained changes when the metadata is replaced by a
+// Raft snapshot, so watches start over with a snapshot.
+func (w *metadataWatch) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.changes = nil
+	w.floor = math.MaxUint64
+	w.evicted = 0
+	w.generation++
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// since returns the streams changed after the given version and the version
+// of the latest change along with the generation of the metadata. Watches
+// pass the generation returned by the previous call, or 0 when resuming. If
+// the changes after the version are not all retained or the metadata was
+// replaced since the given generation, snapshot is true and the streams are
+// nil. The returned channel is closed on the next change.
+func (w *metadataWatch) since(version, generation uint64) (
+	streams []string, latest, gen uint64, snapshot bool, changed <-chan struct{}) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if generation == 0 {
+		// A resuming watch may have missed changes from before the
+		// retained ones.
+		snapshot = w.floor == math.MaxUint64 || version < w.floor
+	} else {
+		// A watch has seen all changes of its generation up to its version.
+		snapshot = generation != w.generation || version < w.evicted
+	}
+	if snapshot {
+		return nil, w.version, w.generation, true, w.changed
+	}
+	seen := make(map[string]struct{})
+	for _, change := range w.changes {
+		if change.version <= version {
+			continue
+		}
+		for _, stream := range change.streams {
+			if _, ok := seen[stream]; !ok {
+				seen[stream] = struct{}{}
+				streams = append(streams, stream)
+			}
+		}
+	}
+	latest = w.version
+	if latest < version {
+		// The client saw changes from a server ahead of this one.
+		latest = version
+	}
+	return streams, latest, w.generation, false, w.changed
+}
+
+// metadataChangeStreams returns the streams whose metadata the Raft log
+// operation changes, if any.
+func metadataChangeStreams(log *proto.RaftLog) []string {
+	switch log.Op {
+	case proto.Op_CREATE_STREAM:
+		return []string{log.CreateStreamOp.Stream.Name}
+	case proto.Op_DELETE_STREAM:
+		return []string{log.DeleteStreamOp.Stream}
+	case proto.Op_SHRINK_ISR:
+		return []string{log.ShrinkISROp.Stream}
+	case proto.Op_EXPAND_ISR:
+		return []string{log.ExpandISROp.Stream}
+	case proto.Op_CHANGE_LEADER:
+		return []string{log.ChangeLeaderOp.Stream}
+	case proto.Op_PAUSE_STREAM:
+		return []string{log.PauseStreamOp.Stream}
+	case proto.Op_RESUME_STREAM:
+		return []string{log.ResumeStreamOp.Stream}
+	case proto.Op_SET_STREAM_READONLY:
+		return []string{log.SetStreamReadonlyOp.Stream}
+	case proto.Op_SET_STREAM_ALIAS:
+		return []string{log.SetStreamAliasOp.Stream, log.SetStreamAliasOp.Alias}
+	case proto.Op_RENAME_STREAM:
+		return []string{log.RenameStreamOp.Stream, log.RenameStreamOp.Name}
+	}
+	return nil
+}
+
+// watchMetadataFromContext indicates if the Subscribe request is a metadata
+// watch and returns the metadata version it resumes after, which is 0 if it
+// starts with a snapshot.
+func watchMetadataFromContext(ctx context.Context) (bool, uint64, *status.Status) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false, 0, nil
+	}
+	values := md.Get(WatchMetadataMetadata)
+	if len(values) == 0 {
+		return false, 0, nil
+	}
+	watch, err := strconv.ParseBool(values[0])
+	if err != nil {
+		return false, 0, status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", WatchMetadataMetadata, values[0]))
+	}
+	if !watch {
+		return false, 0, nil
+	}
+	values = md.Get(WatchMetadataVersionMetadata)
+	if len(values) == 0 {
+		return true, 0, nil
+	}
+	version, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return false, 0, status.New(codes.InvalidArgument,
+			fmt.Sprintf("Invalid %s value %q", WatchMetadataVersionMetadata, values[0]))
+	}
+	return true, version, nil
+}
+
+// watchMetadata sends metadata changes on a metadata watch subscription until
+// the client cancels it or the server drains. Changes the client has not
+// received yet are coalesced, so a slow client receives the current metadata
+// of all streams changed since its last message in one message.
+func (a *apiServer) watchMetadata(req *client.SubscribeRequest, out client.API_SubscribeServer,
+	version uint64) error {
+
+	a.logger.Debugf("api: Subscribe to metadata changes [stream=%s, version=%d]", req.Stream, version)
+
+	// Send an empty message which signals the subscription was successfully
+	// created.
+	if err := out.Send(&client.Message{}); err != nil {
+		return err
+	}
+
+	var (
+		generation uint64
+		resumed    = version > 0
+	)
+	for {
+		streams, latest, gen, snapshot, changed := a.metadataWatch.since(version, generation)
+		if !resumed {
+			snapshot = true
+		}
+		resumed = true
+		if snapshot {
+			streams = nil
+			if req.Stream != "" {
+				streams = []string{req.Stream}
+			}
+		} else {
+			streams = filterWatchedStreams(streams, req.Stream)
+		}
+		if snapshot || len(streams) > 0 {
+			msg, st := a.metadataWatchMessage(out.Context(), streams, latest, snapshot)
+			if st != nil {
+				return st.Err()
+			}
+			if err := out.Send(msg); err != nil {
+				return err
+			}
+		}
+		version, generation = latest, gen
+
+		select {
+		case <-out.Context().Done():
+			return nil
+		case <-a.drainCh:
+			out.SetTrailer(a.drainingMetadata("", 0))
+			return errServerDraining()
+		case <-changed:
+		}
+	}
+}
+
+// filterWatchedStreams returns the changed streams a watch for the given
+// stream receives, which are all of them if no stream is watched.
+func filterWatchedStreams(streams []string, watched string) []string {
+	if watched == "" {
+		return streams
+	}
+	for _, stream := range streams {
+		if stream == watched {
+			return []string{watched}
+		}
+	}
+	return nil
+}
+
+// metadataWatchMessage returns the metadata watch message with the metadata of
+// the given streams, or all streams if none are given, at the given version.
+func (a *apiServer) metadataWatchMessage(ctx context.Context, streams []string, version uint64,
+	snapshot bool) (*client.Message, *status.Status) {
+
+	resp, st := a.metadata.FetchMetadata(ctx, &client.FetchMetadataRequest{Streams: streams})
+	if st != nil {
+		return nil, st
+	}
+	if snapshot {
+		// Streams which don't exist are only listed to report deletions.
+		existing := resp.Metadata[:0]
+		for _, stream := range resp.Metadata {
+			if stream.Error != client.StreamMetadata_UNKNOWN_STREAM {
+				existing = append(existing, stream)
+			}
+		}
+		resp.Metadata = existing
+	}
+	value, err := resp.Marshal()
+	if err != nil {
+		return nil, status.New(codes.Internal, err.Error())
+	}
+	msg := &client.Message{
+		Offset: int64(version),
+		Value:  value,
+	}
+	if snapshot {
+		msg.Headers = map[string][]byte{MetadataSnapshotHeader: []byte("true")}
+	}
+	return msg, nil
+}
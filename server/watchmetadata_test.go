@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	client "github.com/liftbridge-io/liftbridge-api/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func watchMetadata(t *testing.T, ctx context.Context, api client.APIClient, stream string,
+	version string) (client.API_SubscribeClient, error) {
+
+	ctx = metadata.AppendToOutgoingContext(ctx, WatchMetadataMetadata, "true")
+	if version != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, WatchMetadataVersionMetadata, version)
+	}
+	sub, err := api.Subscribe(ctx, &client.SubscribeRequest{Stream: stream})
+	require.NoError(t, err)
+	_, err = sub.Recv()
+	return sub, err
+}
+
+func nextMetadataChange(t *testing.T, sub client.API_SubscribeClient) (
+	*client.FetchMetadataResponse, int64, bool) {
+
+	msg, err := sub.Recv()
+	require.NoError(t, err)
+	resp := &client.FetchMetadataResponse{}
+	require.NoError(t, resp.Unmarshal(msg.Value))
+	return resp, msg.Offset, string(msg.Headers[MetadataSnapshotHeader]) == "true"
+}
+
+// Ensure metadata watches receive a snapshot followed by changes and can be
+// resumed from a version.
+func TestWatchMetadata(t *testing.T) {
+	defer cleanupStorage(t)
+
+	s := runServerWithConfig(t, getTestConfig("a", true, 5050))
+	defer s.Stop()
+	getMetadataLeader(t, 10*time.Second, s)
+
+	conn, err := grpc.Dial("localhost:5050", grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	api := client.NewAPIClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = watchMetadata(t, ctx, api, "", "foo")
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	watchCtx, watchCancel := context.WithCancel(ctx)
+	sub, err := watchMetadata(t, watchCtx, api, "", "")
+	require.NoError(t, err)
+	resp, _, snapshot := nextMetadataChange(t, sub)
+	require.True(t, snapshot)
+	require.Len(t, resp.Brokers, 1)
+
+	_, err = api.CreateStream(ctx, &client.CreateStreamRequest{Subject: "foo", Name: "foo"})
+	require.NoError(t, err)
+	resp, created, snapshot := nextMetadataChange(t, sub)
+	require.False(t, snapshot)
+	require.NotZero(t, created)
+	require.Len(t, resp.Metadata, 1)
+	require.Equal(t, "foo", resp.Metadata[0].Name)
+	require.Equal(t, "a", resp.Metadata[0].Partitions[0].Leader)
+
+	_, err = api.PauseStream(ctx, &client.PauseStreamRequest{Name: "foo"})
+	require.NoError(t, err)
+	resp, paused, _ := nextMetadataChange(t, sub)
+	require.Greater(t, paused, created)
+	require.True(t, resp.Metadata[0].Partitions[0].Paused)
+	watchCancel()
+
+	// Resuming receives the changes after the version.
+	sub, err = watchMetadata(t, ctx, api, "foo", strconv.FormatInt(created, 10))
+	require.NoError(t, err)
+	resp, version, snapshot := nextMetadataChange(t, sub)
+	require.False(t, snapshot)
+	require.Equal(t, paused, version)
+	require.True(t, resp.Metadata[0].Partitions[0].Paused)
+
+	_, err = api.DeleteStream(ctx, &client.DeleteStreamRequest{Name: "foo"})
+	require.NoError(t, err)
+	resp, _, _ = nextMetadataChange(t, sub)
+	require.Equal(t, client.StreamMetadata_UNKNOWN_STREAM, resp.Metadata[0].Error)
+}
+
+// Ensure metadata watches resuming from a version whose changes are no longer
+// retained start with a snapshot.
+func TestMetadataWatchSince(t *testing.T) {
+	w := newMetadataWatch()
+	_, _, _, snapshot, _ := w.since(1, 0)
+	require.True(t, snapshot)
+
+	for i := uint64(10); i < 10+maxMetadataChanges; i++ {
+		w.record(i, []string{"foo"})
+	}
+	w.record(10+maxMetadataChanges, []string{"bar"})
+
+	_, _, _, snapshot, _ = w.since(9, 0)
+	require.True(t, snapshot)
+	streams, latest, gen, snapshot, _ := w.since(10+maxMetadataChanges-2, 0)
+	require.False(t, snapshot)
+	require.Equal(t, []string{"foo", "bar"}, streams)
+	require.Equal(t, uint64(10+maxMetadataChanges), latest)
+
+	w.reset()
+	_, _, _, snapshot, _ = w.since(latest, gen)
+	require.True(t, snapshot)
+}